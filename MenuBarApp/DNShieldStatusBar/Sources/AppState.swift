@@ -176,14 +176,40 @@ class AppState: ObservableObject {
     }
     
     private func handleWebSocketMessage(_ data: Data) {
-        // Parse WebSocket messages and update state accordingly
-        // This would handle real-time updates from the service
+        let decoder = JSONDecoder()
+        decoder.dateDecodingStrategy = .iso8601
+        guard let envelope = try? decoder.decode(WebSocketEnvelope.self, from: data) else {
+            return
+        }
+        
+        DispatchQueue.main.async {
+            switch envelope.type {
+            case "domain_blocked":
+                if let message = try? decoder.decode(WebSocketMessage<BlockedDomain>.self, from: data) {
+                    // Oldest first, matching /api/recent-blocked
+                    self.recentBlocked.append(message.data)
+                    if self.recentBlocked.count > 20 {
+                        self.recentBlocked.removeFirst()
+                    }
+                }
+            case "stats_update":
+                if let message = try? decoder.decode(WebSocketMessage<Statistics>.self, from: data) {
+                    self.statistics = message.data
+                }
+            case "protection_state":
+                if let message = try? decoder.decode(WebSocketMessage<ProtectionState>.self, from: data) {
+                    self.isPaused = message.data.paused
+                }
+            default:
+                break
+            }
+        }
     }
     
     // MARK: - Control Actions
     
-    func pauseProtection(duration: String) {
-        api.pauseProtection(duration: duration)
+    func pauseProtection(duration: String, reason: String) {
+        api.pauseProtection(duration: duration, reason: reason)
             .receive(on: DispatchQueue.main)
             .sink(
                 receiveCompletion: { completion in
@@ -200,6 +226,23 @@ class AppState: ObservableObject {
             .store(in: &cancellables)
     }
     
+    func bypassDomain(_ domain: String, justification: String, duration: String, code: String?,
+                      completion: @escaping (Result<DomainBypass, Error>) -> Void) {
+        api.bypassDomain(domain, justification: justification, duration: duration, code: code)
+            .receive(on: DispatchQueue.main)
+            .sink(
+                receiveCompletion: { result in
+                    if case .failure(let error) = result {
+                        completion(.failure(error))
+                    }
+                },
+                receiveValue: { bypass in
+                    completion(.success(bypass))
+                }
+            )
+            .store(in: &cancellables)
+    }
+    
     func resumeProtection() {
         api.resumeProtection()
             .receive(on: DispatchQueue.main)
@@ -129,4 +129,33 @@ struct Configuration: Codable {
 // MARK: - API Responses
 struct PauseRequest: Codable {
     let duration: String
+    let reason: String
+}
+
+struct DomainBypassRequest: Codable {
+    let domain: String
+    let justification: String
+    let duration: String
+    let code: String?
+}
+
+struct DomainBypass: Codable {
+    let domain: String
+    let until: Date
+}
+
+// MARK: - WebSocket Messages
+struct WebSocketEnvelope: Decodable {
+    let type: String
+}
+
+struct WebSocketMessage<T: Decodable>: Decodable {
+    let type: String
+    let data: T
+}
+
+struct ProtectionState: Decodable {
+    let paused: Bool
+    let until: Date?
+    let reason: String?
 }
\ No newline at end of file
@@ -5,6 +5,8 @@ class DNShieldAPI {
     static let shared = DNShieldAPI()
     
     private let baseURL = "http://127.0.0.1:5353/api"
+    // The agent's socket; TCP is only used when it's missing (api.tcp)
+    private let socket = UnixSocketTransport(path: "/var/run/dnshield/api.sock")
     private let session: URLSession
     private let decoder: JSONDecoder
     private let encoder: JSONEncoder
@@ -22,6 +24,16 @@ class DNShieldAPI {
         self.encoder.dateEncodingStrategy = .iso8601
     }
     
+    private func dataTaskPublisher(for request: URLRequest) -> AnyPublisher<(data: Data, response: URLResponse), Error> {
+        if socket.isAvailable {
+            return socket.dataTaskPublisher(for: request)
+        }
+        return session.dataTaskPublisher(for: request)
+            .map { (data: $0.data, response: $0.response) }
+            .mapError { $0 as Error }
+            .eraseToAnyPublisher()
+    }
+    
     // MARK: - Status & Statistics
     
     func fetchStatus() -> AnyPublisher<ServiceStatus, Error> {
@@ -30,7 +42,7 @@ class DNShieldAPI {
                 .eraseToAnyPublisher()
         }
         
-        return session.dataTaskPublisher(for: url)
+        return dataTaskPublisher(for: URLRequest(url: url))
             .map(\.data)
             .decode(type: ServiceStatus.self, decoder: decoder)
             .eraseToAnyPublisher()
@@ -42,7 +54,7 @@ class DNShieldAPI {
                 .eraseToAnyPublisher()
         }
         
-        return session.dataTaskPublisher(for: url)
+        return dataTaskPublisher(for: URLRequest(url: url))
             .map(\.data)
             .decode(type: Statistics.self, decoder: decoder)
             .eraseToAnyPublisher()
@@ -54,7 +66,7 @@ class DNShieldAPI {
                 .eraseToAnyPublisher()
         }
         
-        return session.dataTaskPublisher(for: url)
+        return dataTaskPublisher(for: URLRequest(url: url))
             .map(\.data)
             .decode(type: [BlockedDomain].self, decoder: decoder)
             .eraseToAnyPublisher()
@@ -66,7 +78,7 @@ class DNShieldAPI {
                 .eraseToAnyPublisher()
         }
         
-        return session.dataTaskPublisher(for: url)
+        return dataTaskPublisher(for: URLRequest(url: url))
             .map(\.data)
             .decode(type: Configuration.self, decoder: decoder)
             .eraseToAnyPublisher()
@@ -74,7 +86,7 @@ class DNShieldAPI {
     
     // MARK: - Control Actions
     
-    func pauseProtection(duration: String) -> AnyPublisher<Void, Error> {
+    func pauseProtection(duration: String, reason: String) -> AnyPublisher<Void, Error> {
         guard let url = URL(string: "\(baseURL)/pause") else {
             return Fail(error: URLError(.badURL))
                 .eraseToAnyPublisher()
@@ -84,7 +96,7 @@ class DNShieldAPI {
         request.httpMethod = "POST"
         request.setValue("application/json", forHTTPHeaderField: "Content-Type")
         
-        let pauseRequest = PauseRequest(duration: duration)
+        let pauseRequest = PauseRequest(duration: duration, reason: reason)
         do {
             request.httpBody = try encoder.encode(pauseRequest)
         } catch {
@@ -92,9 +104,8 @@ class DNShieldAPI {
                 .eraseToAnyPublisher()
         }
         
-        return session.dataTaskPublisher(for: request)
+        return dataTaskPublisher(for: request)
             .map { _ in () }
-            .mapError { $0 as Error }
             .eraseToAnyPublisher()
     }
     
@@ -107,9 +118,8 @@ class DNShieldAPI {
         var request = URLRequest(url: url)
         request.httpMethod = "POST"
         
-        return session.dataTaskPublisher(for: request)
+        return dataTaskPublisher(for: request)
             .map { _ in () }
-            .mapError { $0 as Error }
             .eraseToAnyPublisher()
     }
     
@@ -122,9 +132,8 @@ class DNShieldAPI {
         var request = URLRequest(url: url)
         request.httpMethod = "POST"
         
-        return session.dataTaskPublisher(for: request)
+        return dataTaskPublisher(for: request)
             .map { _ in () }
-            .mapError { $0 as Error }
             .eraseToAnyPublisher()
     }
     
@@ -137,14 +146,48 @@ class DNShieldAPI {
         var request = URLRequest(url: url)
         request.httpMethod = "POST"
         
-        return session.dataTaskPublisher(for: request)
+        return dataTaskPublisher(for: request)
             .map { _ in () }
-            .mapError { $0 as Error }
+            .eraseToAnyPublisher()
+    }
+    
+    func bypassDomain(_ domain: String, justification: String, duration: String, code: String?) -> AnyPublisher<DomainBypass, Error> {
+        guard let url = URL(string: "\(baseURL)/unblock/bypass") else {
+            return Fail(error: URLError(.badURL))
+                .eraseToAnyPublisher()
+        }
+        
+        var request = URLRequest(url: url)
+        request.httpMethod = "POST"
+        request.setValue("application/json", forHTTPHeaderField: "Content-Type")
+        
+        let bypassRequest = DomainBypassRequest(domain: domain, justification: justification, duration: duration, code: code)
+        do {
+            request.httpBody = try encoder.encode(bypassRequest)
+        } catch {
+            return Fail(error: error)
+                .eraseToAnyPublisher()
+        }
+        
+        // The agent explains refusals (bad code, daily limit) in the body
+        return dataTaskPublisher(for: request)
+            .tryMap { data, response in
+                if let http = response as? HTTPURLResponse, http.statusCode != 200 {
+                    let message = String(data: data, encoding: .utf8)?
+                        .trimmingCharacters(in: .whitespacesAndNewlines) ?? ""
+                    throw NSError(domain: "DNShieldAPI", code: http.statusCode,
+                                  userInfo: [NSLocalizedDescriptionKey: message])
+                }
+                return data
+            }
+            .decode(type: DomainBypass.self, decoder: decoder)
             .eraseToAnyPublisher()
     }
     
     // MARK: - WebSocket Connection
     
+    // Real-time updates need the TCP listener; without it the app relies on
+    // its periodic fetches
     func connectWebSocket(onMessage: @escaping (Data) -> Void) -> URLSessionWebSocketTask? {
         guard let url = URL(string: "ws://127.0.0.1:5353/api/ws") else {
             return nil
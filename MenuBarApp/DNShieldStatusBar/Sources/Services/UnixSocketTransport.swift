@@ -0,0 +1,109 @@
+import Foundation
+import Combine
+import Network
+
+/// Sends API requests over the agent's Unix domain socket. The agent
+/// identifies the app by its uid (the console user), so no API key or TCP
+/// listener is needed.
+final class UnixSocketTransport {
+    let path: String
+    private let queue = DispatchQueue(label: "com.dnshield.statusbar.api-socket")
+
+    init(path: String) {
+        self.path = path
+    }
+
+    var isAvailable: Bool {
+        FileManager.default.fileExists(atPath: path)
+    }
+
+    func dataTaskPublisher(for request: URLRequest) -> AnyPublisher<(data: Data, response: URLResponse), Error> {
+        Future { promise in
+            let connection = NWConnection(to: .unix(path: self.path), using: .tcp)
+            var buffer = Data()
+
+            // HTTP/1.0: the agent sends the body unchunked and closes the
+            // connection when it's done
+            func receive() {
+                connection.receive(minimumIncompleteLength: 1, maximumLength: 65536) { data, _, isComplete, error in
+                    if let data = data {
+                        buffer.append(data)
+                    }
+                    if let error = error {
+                        connection.cancel()
+                        promise(.failure(error))
+                        return
+                    }
+                    if isComplete {
+                        connection.cancel()
+                        promise(Result { try Self.parseResponse(buffer, url: request.url) })
+                        return
+                    }
+                    receive()
+                }
+            }
+
+            connection.stateUpdateHandler = { state in
+                switch state {
+                case .ready:
+                    connection.send(content: Self.serialize(request), completion: .contentProcessed { error in
+                        if let error = error {
+                            connection.cancel()
+                            promise(.failure(error))
+                        }
+                    })
+                    receive()
+                case .failed(let error):
+                    promise(.failure(error))
+                default:
+                    break
+                }
+            }
+            connection.start(queue: self.queue)
+        }
+        .timeout(.seconds(5), scheduler: queue, customError: { URLError(.timedOut) })
+        .eraseToAnyPublisher()
+    }
+
+    private static func serialize(_ request: URLRequest) -> Data {
+        var path = request.url?.path ?? "/"
+        if let query = request.url?.query {
+            path += "?\(query)"
+        }
+        let body = request.httpBody ?? Data()
+
+        var head = "\(request.httpMethod ?? "GET") \(path) HTTP/1.0\r\nHost: dnshield\r\n"
+        for (name, value) in request.allHTTPHeaderFields ?? [:] {
+            head += "\(name): \(value)\r\n"
+        }
+        head += "Content-Length: \(body.count)\r\n\r\n"
+
+        var data = Data(head.utf8)
+        data.append(body)
+        return data
+    }
+
+    private static func parseResponse(_ data: Data, url: URL?) throws -> (data: Data, response: URLResponse) {
+        guard let separator = data.range(of: Data("\r\n\r\n".utf8)),
+              let head = String(data: data[..<separator.lowerBound], encoding: .utf8) else {
+            throw URLError(.badServerResponse)
+        }
+
+        var lines = head.components(separatedBy: "\r\n")
+        let statusLine = lines.removeFirst().split(separator: " ")
+        guard statusLine.count >= 2, let statusCode = Int(statusLine[1]) else {
+            throw URLError(.badServerResponse)
+        }
+        var headers: [String: String] = [:]
+        for line in lines {
+            guard let colon = line.firstIndex(of: ":") else { continue }
+            headers[String(line[..<colon])] = line[line.index(after: colon)...].trimmingCharacters(in: .whitespaces)
+        }
+
+        guard let url = url,
+              let response = HTTPURLResponse(url: url, statusCode: statusCode, httpVersion: "HTTP/1.0", headerFields: headers) else {
+            throw URLError(.badServerResponse)
+        }
+        return (Data(data[separator.upperBound...]), response)
+    }
+}
@@ -67,6 +67,7 @@ struct ActivityView: View {
         }
         .sheet(item: $selectedDomain) { domain in
             DomainDetailSheet(domain: domain)
+                .environmentObject(appState)
         }
     }
 }
@@ -121,7 +122,13 @@ struct BlockedDomainRow: View {
 
 struct DomainDetailSheet: View {
     let domain: BlockedDomain
+    @EnvironmentObject var appState: AppState
     @Environment(\.dismiss) var dismiss
+    @State private var bypassDuration = "15m"
+    @State private var justification = ""
+    @State private var adminCode = ""
+    @State private var isBypassing = false
+    @State private var bypassMessage: String?
     
     var body: some View {
         VStack(alignment: .leading, spacing: 16) {
@@ -147,6 +154,43 @@ struct DomainDetailSheet: View {
                 DetailRow(label: "Rule", value: domain.rule)
             }
             
+            Divider()
+            
+            // Bypass just this domain, everything else stays filtered
+            VStack(alignment: .leading, spacing: 8) {
+                Text("Temporary Bypass")
+                    .font(.subheadline.weight(.semibold))
+                
+                Picker("Duration", selection: $bypassDuration) {
+                    Text("5 minutes").tag("5m")
+                    Text("15 minutes").tag("15m")
+                    Text("30 minutes").tag("30m")
+                }
+                .pickerStyle(.segmented)
+                
+                TextField("Why do you need this domain?", text: $justification)
+                    .textFieldStyle(.roundedBorder)
+                
+                SecureField("Admin code (if required)", text: $adminCode)
+                    .textFieldStyle(.roundedBorder)
+                
+                HStack {
+                    if let message = bypassMessage {
+                        Text(message)
+                            .font(.caption)
+                            .foregroundColor(.secondary)
+                            .fixedSize(horizontal: false, vertical: true)
+                    }
+                    
+                    Spacer()
+                    
+                    Button("Bypass") {
+                        bypass()
+                    }
+                    .disabled(isBypassing || justification.trimmingCharacters(in: .whitespaces).isEmpty)
+                }
+            }
+            
             Spacer()
             
             // Actions
@@ -163,6 +207,23 @@ struct DomainDetailSheet: View {
         .frame(width: 400)
     }
     
+    private func bypass() {
+        isBypassing = true
+        bypassMessage = nil
+        let code = adminCode.trimmingCharacters(in: .whitespaces)
+        appState.bypassDomain(domain.domain, justification: justification, duration: bypassDuration,
+                              code: code.isEmpty ? nil : code) { result in
+            isBypassing = false
+            switch result {
+            case .success(let bypass):
+                let until = DateFormatter.localizedString(from: bypass.until, dateStyle: .none, timeStyle: .short)
+                bypassMessage = "Allowed until \(until)"
+                adminCode = ""
+            case .failure(let error):
+                bypassMessage = error.localizedDescription
+            }
+        }
+    }
 }
 
 struct DetailRow: View {
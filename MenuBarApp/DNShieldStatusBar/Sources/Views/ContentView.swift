@@ -145,15 +145,34 @@ struct HeaderView: View {
     }
     
     @objc private func pause5Min() {
-        appState.pauseProtection(duration: "5m")
+        pause(duration: "5m")
     }
     
     @objc private func pause30Min() {
-        appState.pauseProtection(duration: "30m")
+        pause(duration: "30m")
     }
     
     @objc private func pause1Hour() {
-        appState.pauseProtection(duration: "1h")
+        pause(duration: "1h")
+    }
+    
+    // Pauses need a reason, which is recorded in the audit log
+    private func pause(duration: String) {
+        let alert = NSAlert()
+        alert.messageText = "Pause Protection"
+        alert.informativeText = "Why do you need to pause protection?"
+        alert.addButton(withTitle: "Pause")
+        alert.addButton(withTitle: "Cancel")
+        
+        let reasonField = NSTextField(frame: NSRect(x: 0, y: 0, width: 260, height: 24))
+        reasonField.placeholderString = "Reason"
+        alert.accessoryView = reasonField
+        alert.window.initialFirstResponder = reasonField
+        
+        guard alert.runModal() == .alertFirstButtonReturn else { return }
+        let reason = reasonField.stringValue.trimmingCharacters(in: .whitespaces)
+        guard !reason.isEmpty else { return }
+        appState.pauseProtection(duration: duration, reason: reason)
     }
 }
 
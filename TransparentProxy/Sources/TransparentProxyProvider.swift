@@ -0,0 +1,210 @@
+import Foundation
+import Network
+import NetworkExtension
+import os.log
+
+/// Transparent proxy provider that asks the DNShield agent for a verdict on
+/// every new outbound flow. Blocked flows are closed; everything else is
+/// handed back to the system untouched, so no traffic passes through this
+/// process. Decisions use the agent's rules (POST /api/flow/verdict), so an
+/// app that resolves names itself (e.g. hard-coded DNS-over-HTTPS) is still
+/// blocked by hostname or by the address DNShield resolved for it.
+class TransparentProxyProvider: NETransparentProxyProvider {
+    private let log = OSLog(subsystem: "com.dnshield.transparentproxy", category: "flow")
+    private let verdicts = VerdictCache()
+    private var client: VerdictClient?
+
+    override func startProxy(options: [String: Any]?, completionHandler: @escaping (Error?) -> Void) {
+        // The API key is delivered in the MDM profile's provider configuration
+        let config = (protocolConfiguration as? NETunnelProviderProtocol)?.providerConfiguration ?? [:]
+        guard let apiKey = config["apiKey"] as? String, !apiKey.isEmpty else {
+            os_log("Missing apiKey in provider configuration", log: log, type: .error)
+            completionHandler(NEVPNError(.configurationInvalid))
+            return
+        }
+        client = VerdictClient(apiKey: apiKey)
+
+        // Inspect all outbound TCP and UDP flows
+        let settings = NETransparentProxyNetworkSettings(tunnelRemoteAddress: "127.0.0.1")
+        settings.includedNetworkRules = [
+            NENetworkRule(remoteNetwork: nil, remotePrefix: 0, localNetwork: nil, localPrefix: 0, protocol: .TCP, direction: .outbound),
+            NENetworkRule(remoteNetwork: nil, remotePrefix: 0, localNetwork: nil, localPrefix: 0, protocol: .UDP, direction: .outbound),
+        ]
+        setTunnelNetworkSettings(settings) { error in
+            completionHandler(error)
+        }
+    }
+
+    override func stopProxy(with reason: NEProviderStopReason, completionHandler: @escaping () -> Void) {
+        completionHandler()
+    }
+
+    /// Returning false lets the flow proceed directly. Returning true claims
+    /// the flow, which is then closed to block it.
+    override func handleNewFlow(_ flow: NEAppProxyFlow) -> Bool {
+        guard let client = client, let request = FlowRequest(flow: flow) else {
+            return false
+        }
+
+        // Never inspect the agent itself or loopback traffic
+        if request.app.hasPrefix("com.dnshield") || request.isLoopback {
+            return false
+        }
+
+        var verdict = verdicts.get(request.cacheKey)
+        if verdict == nil {
+            verdict = client.verdict(for: request)
+            if let fetched = verdict {
+                verdicts.set(request.cacheKey, fetched)
+            }
+        }
+        guard let verdict = verdict else {
+            // Fail open: the agent is unreachable or too slow
+            return false
+        }
+
+        guard verdict.action == "block" else {
+            return false
+        }
+
+        os_log("Blocked %{public}@ flow to %{public}@ from %{public}@", log: log, type: .info,
+               request.proto, verdict.domain ?? request.cacheKey, request.app)
+        let error = NSError(domain: NEAppProxyErrorDomain, code: NEAppProxyFlowError.refused.rawValue)
+        flow.closeReadWithError(error)
+        flow.closeWriteWithError(error)
+        return true
+    }
+}
+
+/// The fields of /api/flow/verdict requests
+struct FlowRequest: Encodable {
+    let hostname: String?
+    let ip: String?
+    let port: Int?
+    let proto: String
+    let app: String
+
+    enum CodingKeys: String, CodingKey {
+        case hostname, ip, port, app
+        case proto = "protocol"
+    }
+
+    init?(flow: NEAppProxyFlow) {
+        let endpoint: NWHostEndpoint?
+        switch flow {
+        case let tcp as NEAppProxyTCPFlow:
+            endpoint = tcp.remoteEndpoint as? NWHostEndpoint
+            proto = "tcp"
+        case is NEAppProxyUDPFlow:
+            // UDP destinations are only known per datagram; judge by hostname
+            endpoint = nil
+            proto = "udp"
+        default:
+            return nil
+        }
+
+        hostname = flow.remoteHostname
+        if let endpoint = endpoint, IPv4Address(endpoint.hostname) != nil || IPv6Address(endpoint.hostname) != nil {
+            ip = endpoint.hostname
+        } else {
+            ip = nil
+        }
+        port = endpoint.flatMap { Int($0.port) }
+        app = flow.metaData.sourceAppSigningIdentifier
+
+        if hostname == nil && ip == nil {
+            return nil
+        }
+    }
+
+    var cacheKey: String {
+        "\(proto)|\(hostname ?? "")|\(ip ?? "")"
+    }
+
+    var isLoopback: Bool {
+        guard let ip = ip else { return hostname == "localhost" }
+        return ip.hasPrefix("127.") || ip == "::1"
+    }
+}
+
+/// The fields of /api/flow/verdict responses
+struct FlowVerdict: Decodable {
+    let action: String
+    let domain: String?
+    let matchedBy: String?
+    let rule: String?
+    let category: String?
+
+    enum CodingKeys: String, CodingKey {
+        case action, domain, rule, category
+        case matchedBy = "matched_by"
+    }
+}
+
+/// Calls the agent's local API. handleNewFlow is synchronous, so requests
+/// wait on a semaphore with a short timeout.
+final class VerdictClient {
+    private let url = URL(string: "http://127.0.0.1:5353/api/flow/verdict")!
+    private let apiKey: String
+    private let session: URLSession
+    private let timeout: DispatchTimeInterval = .milliseconds(250)
+
+    init(apiKey: String) {
+        self.apiKey = apiKey
+        let config = URLSessionConfiguration.ephemeral
+        config.timeoutIntervalForRequest = 1
+        self.session = URLSession(configuration: config)
+    }
+
+    func verdict(for request: FlowRequest) -> FlowVerdict? {
+        var urlRequest = URLRequest(url: url)
+        urlRequest.httpMethod = "POST"
+        urlRequest.setValue("Bearer \(apiKey)", forHTTPHeaderField: "Authorization")
+        urlRequest.setValue("application/json", forHTTPHeaderField: "Content-Type")
+        urlRequest.httpBody = try? JSONEncoder().encode(request)
+
+        var result: FlowVerdict?
+        let done = DispatchSemaphore(value: 0)
+        let task = session.dataTask(with: urlRequest) { data, response, _ in
+            defer { done.signal() }
+            guard let http = response as? HTTPURLResponse, http.statusCode == 200, let data = data else {
+                return
+            }
+            result = try? JSONDecoder().decode(FlowVerdict.self, from: data)
+        }
+        task.resume()
+
+        if done.wait(timeout: .now() + timeout) == .timedOut {
+            task.cancel()
+            return nil
+        }
+        return result
+    }
+}
+
+/// Short-lived verdict cache so repeated connections to the same
+/// destination don't each need an API round trip
+final class VerdictCache {
+    private let ttl: TimeInterval = 30
+    private let maxEntries = 10_000
+    private var entries: [String: (verdict: FlowVerdict, expires: Date)] = [:]
+    private let lock = NSLock()
+
+    func get(_ key: String) -> FlowVerdict? {
+        lock.lock()
+        defer { lock.unlock() }
+        guard let entry = entries[key], entry.expires > Date() else {
+            return nil
+        }
+        return entry.verdict
+    }
+
+    func set(_ key: String, _ verdict: FlowVerdict) {
+        lock.lock()
+        defer { lock.unlock() }
+        if entries.count >= maxEntries {
+            entries.removeAll()
+        }
+        entries[key] = (verdict, Date().addingTimeInterval(ttl))
+    }
+}
@@ -1,23 +1,24 @@
 package cmd
 
 import (
-	"crypto/rand"
-	"encoding/hex"
-	"encoding/json"
 	"fmt"
 	"os"
-	"path/filepath"
 	"strings"
 	"time"
 
-	"dnshield/internal/utils"
+	"dnshield/internal/api"
 	"github.com/spf13/cobra"
 )
 
 var apikeyCmd = &cobra.Command{
 	Use:   "apikey",
 	Short: "Manage API keys for role-based access control",
-	Long:  `Generate and manage API keys with different roles (admin, operator, viewer) for secure API access.`,
+	Long: `Generate and manage API keys with different roles (admin, operator, viewer) for secure API access.
+
+Only salted hashes of the keys are stored in ~/.dnshield/api_keys.json; a
+key is displayed once, when it is generated or rotated. Keys are identified
+by their first 16 characters. The running agent picks up changes to the
+store within a minute.`,
 }
 
 var generateAPIKeyCmd = &cobra.Command{
@@ -33,15 +34,30 @@ var listAPIKeysCmd = &cobra.Command{
 }
 
 var revokeAPIKeyCmd = &cobra.Command{
-	Use:   "revoke [key]",
+	Use:   "revoke [key-id]",
 	Short: "Revoke an API key",
 	Args:  cobra.ExactArgs(1),
 	RunE:  runRevokeAPIKey,
 }
 
+var rotateAPIKeyCmd = &cobra.Command{
+	Use:   "rotate [key-id]",
+	Short: "Replace an API key with a new one",
+	Long: `Generate a new key with the same role, scopes, allowed IPs and description
+as an existing key. The old key keeps working for the --grace period, so
+clients can be switched over, and is then rejected; --grace 0 revokes it at
+once.`,
+	Args: cobra.ExactArgs(1),
+	RunE: runRotateAPIKey,
+}
+
 var (
-	apiKeyRole       string
-	apiKeyExpiration string
+	apiKeyRole        string
+	apiKeyExpiration  string
+	apiKeyScopes      []string
+	apiKeyAllowedIPs  []string
+	apiKeyDescription string
+	apiKeyGrace       time.Duration
 )
 
 // NewAPIKeyCmd creates the apikey command
@@ -49,144 +65,108 @@ func NewAPIKeyCmd() *cobra.Command {
 	apikeyCmd.AddCommand(generateAPIKeyCmd)
 	apikeyCmd.AddCommand(listAPIKeysCmd)
 	apikeyCmd.AddCommand(revokeAPIKeyCmd)
+	apikeyCmd.AddCommand(rotateAPIKeyCmd)
 
 	generateAPIKeyCmd.Flags().StringVarP(&apiKeyRole, "role", "r", "viewer", "Role for the API key (admin, operator, viewer)")
 	generateAPIKeyCmd.Flags().StringVarP(&apiKeyExpiration, "expires", "e", "", "Expiration duration (e.g., 24h, 7d, 30d)")
+	generateAPIKeyCmd.Flags().StringSliceVar(&apiKeyScopes, "scope", nil, "API path the key may call, repeatable; a trailing * matches a prefix (default: all)")
+	generateAPIKeyCmd.Flags().StringSliceVar(&apiKeyAllowedIPs, "allow-ip", nil, "Address or CIDR range the key may be used from, repeatable (default: any)")
+	generateAPIKeyCmd.Flags().StringVar(&apiKeyDescription, "description", "", "What the key is for")
+	rotateAPIKeyCmd.Flags().DurationVar(&apiKeyGrace, "grace", 24*time.Hour, "How long the old key keeps working")
+	rotateAPIKeyCmd.Flags().StringVarP(&apiKeyExpiration, "expires", "e", "", "Expiration duration of the new key (e.g., 24h, 7d, 30d)")
 	
 	return apikeyCmd
 }
 
-// APIKeyStore manages persistent storage of API keys
-type APIKeyStore struct {
-	Keys map[string]*APIKeyInfo `json:"keys"`
-}
-
-type APIKeyInfo struct {
-	Key         string    `json:"key"`
-	Role        string    `json:"role"`
-	CreatedAt   time.Time `json:"created_at"`
-	ExpiresAt   time.Time `json:"expires_at,omitempty"`
-	Disabled    bool      `json:"disabled"`
-	Description string    `json:"description,omitempty"`
-}
-
-func getAPIKeyStorePath() string {
-	homeDir, _ := os.UserHomeDir()
-	return filepath.Join(homeDir, ".dnshield", "api_keys.json")
-}
-
-func loadAPIKeyStore() (*APIKeyStore, error) {
-	storePath := getAPIKeyStorePath()
-	
-	// Create directory if it doesn't exist
-	if err := os.MkdirAll(filepath.Dir(storePath), 0700); err != nil {
-		return nil, fmt.Errorf("failed to create directory: %w", err)
-	}
-	
-	// If file doesn't exist, return empty store
-	info, err := os.Stat(storePath)
-	if os.IsNotExist(err) {
-		return &APIKeyStore{Keys: make(map[string]*APIKeyInfo)}, nil
+func runGenerateAPIKey(cmd *cobra.Command, args []string) error {
+	// Validate role
+	if apiKeyRole != "admin" && apiKeyRole != "operator" && apiKeyRole != "viewer" {
+		return fmt.Errorf("invalid role: %s (must be admin, operator, or viewer)", apiKeyRole)
 	}
-	if err != nil {
-		return nil, err
+	for _, scope := range apiKeyScopes {
+		if err := api.ValidateScope(scope); err != nil {
+			return err
+		}
 	}
-	
-	// Check file size
-	if info.Size() > utils.MaxConfigFileSize {
-		return nil, fmt.Errorf("API key store file exceeds maximum size of %d bytes", utils.MaxConfigFileSize)
+	if _, err := api.ParseAllowedIPs(apiKeyAllowedIPs); err != nil {
+		return err
 	}
-	
-	data, err := os.ReadFile(storePath)
+	expiresAt, err := parseExpiration(apiKeyExpiration)
 	if err != nil {
-		return nil, fmt.Errorf("failed to read API key store: %w", err)
-	}
-	
-	var store APIKeyStore
-	if err := json.Unmarshal(data, &store); err != nil {
-		return nil, fmt.Errorf("failed to parse API key store: %w", err)
+		return err
 	}
-	
-	if store.Keys == nil {
-		store.Keys = make(map[string]*APIKeyInfo)
+
+	store, err := loadAPIKeyStore()
+	if err != nil {
+		return err
 	}
-	
-	return &store, nil
-}
 
-func saveAPIKeyStore(store *APIKeyStore) error {
-	data, err := json.MarshalIndent(store, "", "  ")
+	key, err := addAPIKey(store, &api.StoredKey{
+		Role:        apiKeyRole,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   expiresAt,
+		Description: apiKeyDescription,
+		Scopes:      apiKeyScopes,
+		AllowedIPs:  apiKeyAllowedIPs,
+	})
 	if err != nil {
-		return fmt.Errorf("failed to marshal API key store: %w", err)
+		return err
 	}
-	
-	storePath := getAPIKeyStorePath()
-	if err := os.WriteFile(storePath, data, 0600); err != nil {
-		return fmt.Errorf("failed to write API key store: %w", err)
+	if err := store.Save(api.KeyStorePath()); err != nil {
+		return err
 	}
-	
+
+	fmt.Printf("API Key generated successfully:\n\n")
+	printAPIKey(key, store.Keys[api.KeyID(key)])
 	return nil
 }
 
-func generateAPIKey() string {
-	bytes := make([]byte, 32)
-	if _, err := rand.Read(bytes); err != nil {
-		panic(err)
+func runRotateAPIKey(cmd *cobra.Command, args []string) error {
+	expiresAt, err := parseExpiration(apiKeyExpiration)
+	if err != nil {
+		return err
 	}
-	return hex.EncodeToString(bytes)
-}
 
-func runGenerateAPIKey(cmd *cobra.Command, args []string) error {
-	// Validate role
-	if apiKeyRole != "admin" && apiKeyRole != "operator" && apiKeyRole != "viewer" {
-		return fmt.Errorf("invalid role: %s (must be admin, operator, or viewer)", apiKeyRole)
+	store, err := loadAPIKeyStore()
+	if err != nil {
+		return err
 	}
-	
-	// Parse expiration
-	var expiresAt time.Time
-	if apiKeyExpiration != "" {
-		duration, err := parseDuration(apiKeyExpiration)
-		if err != nil {
-			return fmt.Errorf("invalid expiration duration: %w", err)
-		}
-		expiresAt = time.Now().Add(duration)
+	old, err := store.Find(args[0])
+	if err != nil {
+		return err
 	}
-	
-	// Generate new API key
-	key := generateAPIKey()
-	
-	// Load store
-	store, err := loadAPIKeyStore()
+	if !old.Active() {
+		return fmt.Errorf("API key %s is revoked or expired", old.ID)
+	}
+
+	key, err := addAPIKey(store, &api.StoredKey{
+		Role:        old.Role,
+		CreatedAt:   time.Now(),
+		ExpiresAt:   expiresAt,
+		Description: old.Description,
+		Scopes:      old.Scopes,
+		AllowedIPs:  old.AllowedIPs,
+		RotatedFrom: old.ID,
+	})
 	if err != nil {
 		return err
 	}
-	
-	// Add key to store
-	info := &APIKeyInfo{
-		Key:       key,
-		Role:      apiKeyRole,
-		CreatedAt: time.Now(),
-		ExpiresAt: expiresAt,
-		Disabled:  false,
-	}
-	store.Keys[key] = info
-	
-	// Save store
-	if err := saveAPIKeyStore(store); err != nil {
+	if apiKeyGrace <= 0 {
+		old.Disabled = true
+	} else if graceEnd := time.Now().Add(apiKeyGrace); old.ExpiresAt.IsZero() || graceEnd.Before(old.ExpiresAt) {
+		old.ExpiresAt = graceEnd
+	}
+	if err := store.Save(api.KeyStorePath()); err != nil {
 		return err
 	}
-	
-	// Display the key
-	fmt.Printf("API Key generated successfully:\n\n")
-	fmt.Printf("Key:  %s\n", key)
-	fmt.Printf("Role: %s\n", apiKeyRole)
-	if !expiresAt.IsZero() {
-		fmt.Printf("Expires: %s\n", expiresAt.Format(time.RFC3339))
+
+	fmt.Printf("API key %s rotated:\n\n", old.ID)
+	printAPIKey(key, store.Keys[api.KeyID(key)])
+	if old.Disabled {
+		fmt.Printf("\nThe old key has been revoked\n")
+	} else {
+		fmt.Printf("\nThe old key stops working at %s\n", old.ExpiresAt.Format(time.RFC3339))
 	}
-	fmt.Printf("\nUse this key in the Authorization header:\n")
-	fmt.Printf("Authorization: Bearer %s\n", key)
-	fmt.Printf("\n⚠️  Save this key securely - it won't be displayed again\n")
-	
 	return nil
 }
 
@@ -195,75 +175,142 @@ func runListAPIKeys(cmd *cobra.Command, args []string) error {
 	if err != nil {
 		return err
 	}
-	
+
 	if len(store.Keys) == 0 {
 		fmt.Println("No API keys found")
 		return nil
 	}
-	
-	fmt.Printf("%-16s %-8s %-20s %-20s %-8s\n", "Key (first 16)", "Role", "Created", "Expires", "Status")
-	fmt.Println(strings.Repeat("-", 80))
-	
-	for key, info := range store.Keys {
-		keyPrefix := key[:16] + "..."
+
+	fmt.Printf("%-16s %-8s %-16s %-16s %-8s %s\n", "ID", "Role", "Created", "Expires", "Status", "Restrictions")
+	fmt.Println(strings.Repeat("-", 90))
+
+	for id, info := range store.Keys {
 		status := "Active"
 		if info.Disabled {
 			status = "Disabled"
-		} else if !info.ExpiresAt.IsZero() && time.Now().After(info.ExpiresAt) {
+		} else if !info.Active() {
 			status = "Expired"
 		}
-		
+
 		expires := "Never"
 		if !info.ExpiresAt.IsZero() {
 			expires = info.ExpiresAt.Format("2006-01-02 15:04")
 		}
-		
-		fmt.Printf("%-16s %-8s %-20s %-20s %-8s\n",
-			keyPrefix,
+
+		var restrictions []string
+		if len(info.Scopes) > 0 {
+			restrictions = append(restrictions, "scopes="+strings.Join(info.Scopes, ","))
+		}
+		if len(info.AllowedIPs) > 0 {
+			restrictions = append(restrictions, "ips="+strings.Join(info.AllowedIPs, ","))
+		}
+		if info.RotatedFrom != "" {
+			restrictions = append(restrictions, "rotated-from="+info.RotatedFrom)
+		}
+
+		fmt.Printf("%-16s %-8s %-16s %-16s %-8s %s\n",
+			id,
 			info.Role,
 			info.CreatedAt.Format("2006-01-02 15:04"),
 			expires,
 			status,
+			strings.Join(restrictions, " "),
 		)
 	}
-	
+
 	return nil
 }
 
 func runRevokeAPIKey(cmd *cobra.Command, args []string) error {
-	keyToRevoke := args[0]
-	
 	store, err := loadAPIKeyStore()
 	if err != nil {
 		return err
 	}
-	
+
 	// Find the key (allow partial match)
-	var foundKey string
-	for key := range store.Keys {
-		if key == keyToRevoke || strings.HasPrefix(key, keyToRevoke) {
-			if foundKey != "" {
-				return fmt.Errorf("multiple keys match the prefix: %s", keyToRevoke)
-			}
-			foundKey = key
-		}
-	}
-	
-	if foundKey == "" {
-		return fmt.Errorf("API key not found: %s", keyToRevoke)
+	info, err := store.Find(args[0])
+	if err != nil {
+		return err
 	}
-	
+
 	// Mark as disabled instead of deleting
-	store.Keys[foundKey].Disabled = true
-	
-	if err := saveAPIKeyStore(store); err != nil {
+	info.Disabled = true
+
+	if err := store.Save(api.KeyStorePath()); err != nil {
 		return err
 	}
-	
-	fmt.Printf("API key revoked: %s...\n", foundKey[:16])
+
+	fmt.Printf("API key revoked: %s\n", info.ID)
 	return nil
 }
 
+// loadAPIKeyStore loads the local key store, saving it right away if it
+// held plaintext keys
+func loadAPIKeyStore() (*api.KeyStore, error) {
+	path := api.KeyStorePath()
+	store, migrated, err := api.LoadKeyStore(path)
+	if err != nil {
+		return nil, err
+	}
+	if migrated {
+		if err := store.Save(path); err != nil {
+			return nil, err
+		}
+	}
+	return store, nil
+}
+
+// addAPIKey generates a key, stores its hash as info and returns the key
+func addAPIKey(store *api.KeyStore, info *api.StoredKey) (string, error) {
+	for {
+		key, err := api.GenerateKey()
+		if err != nil {
+			return "", err
+		}
+		// IDs are 64 random bits; regenerate on the unlikely collision
+		if _, exists := store.Keys[api.KeyID(key)]; exists {
+			continue
+		}
+		if err := info.SetKey(key); err != nil {
+			return "", err
+		}
+		store.Keys[info.ID] = info
+		return key, nil
+	}
+}
+
+// printAPIKey shows a new key, which is not stored and can't be shown again
+func printAPIKey(key string, info *api.StoredKey) {
+	fmt.Printf("Key:  %s\n", key)
+	fmt.Printf("ID:   %s\n", info.ID)
+	fmt.Printf("Role: %s\n", info.Role)
+	if !info.ExpiresAt.IsZero() {
+		fmt.Printf("Expires: %s\n", info.ExpiresAt.Format(time.RFC3339))
+	}
+	if len(info.Scopes) > 0 {
+		fmt.Printf("Scopes: %s\n", strings.Join(info.Scopes, ", "))
+	}
+	if len(info.AllowedIPs) > 0 {
+		fmt.Printf("Allowed IPs: %s\n", strings.Join(info.AllowedIPs, ", "))
+	}
+	fmt.Printf("\nUse this key in the Authorization header:\n")
+	fmt.Printf("Authorization: Bearer %s\n", key)
+	fmt.Printf("\n⚠️  Save this key securely - it won't be displayed again\n")
+}
+
+// parseExpiration returns when a key expiring after s expires; empty
+// means never
+func parseExpiration(s string) (time.Time, error) {
+	if s == "" {
+		return time.Time{}, nil
+	}
+	duration, err := parseDuration(s)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid expiration duration: %w", err)
+	}
+	return time.Now().Add(duration), nil
+}
+
 // parseDuration parses duration strings like "24h", "7d", "30d"
 func parseDuration(s string) (time.Duration, error) {
 	if strings.HasSuffix(s, "d") {
@@ -275,4 +322,21 @@ func parseDuration(s string) (time.Duration, error) {
 		return time.Duration(d) * 24 * time.Hour, nil
 	}
 	return time.ParseDuration(s)
-}
\ No newline at end of file
+}
+
+// resolveAPIKey picks the key CLI commands use to talk to the running agent:
+// the explicit flag value, then DNSHIELD_API_KEY. The key store holds only
+// hashes, so without either the request is sent without a key, which the
+// API socket accepts from allowed users.
+func resolveAPIKey(flagValue string) (string, error) {
+	if flagValue != "" {
+		return flagValue, nil
+	}
+	if key := os.Getenv("DNSHIELD_API_KEY"); key != "" {
+		return key, nil
+	}
+	if _, err := os.Stat(api.DefaultSocketPath); err != nil {
+		return "", fmt.Errorf("no API key available and the API socket is not available; pass --api-key or set DNSHIELD_API_KEY")
+	}
+	return "", nil
+}
@@ -2,19 +2,32 @@ package cmd
 
 import (
 	"fmt"
-	"time"
-	
+	"net/http"
+
+	"dnshield/internal/api"
+
 	"github.com/spf13/cobra"
-	"dnshield/internal/auth"
 )
 
+const captiveBypassPath = "/api/captive-portal/bypass"
+
 // NewBypassCmd creates the bypass command
 func NewBypassCmd() *cobra.Command {
+	var apiKey string
+
 	bypassCmd := &cobra.Command{
 		Use:   "bypass",
 		Short: "Manage DNS filtering bypass for captive portals",
 		Long: `Control DNS filtering bypass mode for connecting through captive portals.
-This temporarily disables DNS filtering to allow captive portal authentication.`,
+This temporarily disables DNS filtering to allow captive portal authentication.
+
+Manual bypasses must be allowed by policy (captivePortal.allowManualBypass),
+are capped in duration and frequency, keep security-critical blocks in force,
+and are recorded in the audit log with the current network.
+
+The API key is taken from --api-key, then DNSHIELD_API_KEY; without one, the
+API socket identifies the user. It needs the protection:captive-bypass
+permission (operator).`,
 	}
 
 	bypassEnableCmd := &cobra.Command{
@@ -22,22 +35,20 @@ This temporarily disables DNS filtering to allow captive portal authentication.`
 		Short: "Enable DNS filtering bypass",
 		Long:  `Temporarily disable DNS filtering to allow captive portal access.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Require authentication for bypass operations
-			token, _ := cmd.Flags().GetString("token")
-			tm := auth.NewTokenManager()
-			
-			if err := tm.ValidateToken(token); err != nil {
-				return fmt.Errorf("authentication failed: %w", err)
-			}
-			
 			duration, _ := cmd.Flags().GetDuration("duration")
-			
-			// This would normally communicate with the running service
-			// For now, we'll print what would happen
-			fmt.Printf("DNS filtering bypass would be enabled for %v\n", duration)
-			fmt.Println("Note: This command requires the DNShield service to be running.")
-			fmt.Println("In the current implementation, bypass mode is automatically activated when captive portal domains are detected.")
-			
+
+			req := api.CaptiveBypassRequest{}
+			if duration > 0 {
+				req.Duration = duration.String()
+			}
+
+			var status api.CaptiveBypassStatus
+			if err := captiveBypassRequest(apiKey, http.MethodPost, req, &status); err != nil {
+				return err
+			}
+
+			fmt.Printf("DNS filtering bypassed until %s (%s)\n", status.Until.Local().Format("15:04:05"), status.Remaining)
+			fmt.Println("Security-critical domains remain blocked.")
 			return nil
 		},
 	}
@@ -47,17 +58,11 @@ This temporarily disables DNS filtering to allow captive portal authentication.`
 		Short: "Disable DNS filtering bypass",
 		Long:  `Re-enable DNS filtering immediately.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			// Require authentication for bypass operations
-			token, _ := cmd.Flags().GetString("token")
-			tm := auth.NewTokenManager()
-			
-			if err := tm.ValidateToken(token); err != nil {
-				return fmt.Errorf("authentication failed: %w", err)
+			if err := captiveBypassRequest(apiKey, http.MethodDelete, nil, nil); err != nil {
+				return err
 			}
-			
-			fmt.Println("DNS filtering bypass would be disabled")
-			fmt.Println("Note: This command requires the DNShield service to be running.")
-			
+
+			fmt.Println("DNS filtering bypass disabled")
 			return nil
 		},
 	}
@@ -67,10 +72,22 @@ This temporarily disables DNS filtering to allow captive portal authentication.`
 		Short: "Show bypass mode status",
 		Long:  `Display whether bypass mode is active and remaining time.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
-			fmt.Println("Bypass mode status:")
-			fmt.Println("Note: This command requires the DNShield service to be running.")
-			fmt.Println("In the current implementation, bypass mode is automatically managed based on captive portal detection.")
-			
+			var status api.CaptiveBypassStatus
+			if err := captiveBypassRequest(apiKey, http.MethodGet, nil, &status); err != nil {
+				return err
+			}
+
+			if !status.Active {
+				fmt.Println("Bypass mode: inactive")
+				return nil
+			}
+
+			kind := "automatic (captive portal detected)"
+			if status.Manual {
+				kind = "manual"
+			}
+			fmt.Printf("Bypass mode: active, %s\n", kind)
+			fmt.Printf("Remaining:   %s\n", status.Remaining)
 			return nil
 		},
 	}
@@ -78,14 +95,18 @@ This temporarily disables DNS filtering to allow captive portal authentication.`
 	bypassCmd.AddCommand(bypassEnableCmd)
 	bypassCmd.AddCommand(bypassDisableCmd)
 	bypassCmd.AddCommand(bypassStatusCmd)
-	
-	// Add authentication flags to commands that modify state
-	bypassEnableCmd.Flags().String("token", "", "Authentication token (required)")
-	bypassEnableCmd.MarkFlagRequired("token")
-	bypassEnableCmd.Flags().Duration("duration", 5*time.Minute, "Duration to bypass DNS filtering")
-	
-	bypassDisableCmd.Flags().String("token", "", "Authentication token (required)")
-	bypassDisableCmd.MarkFlagRequired("token")
-	
+
+	bypassCmd.PersistentFlags().StringVar(&apiKey, "api-key", "", "API key to authenticate with")
+	bypassEnableCmd.Flags().Duration("duration", 0, "Duration to bypass DNS filtering (default and maximum set by policy)")
+
 	return bypassCmd
-}
\ No newline at end of file
+}
+
+// captiveBypassRequest calls the running agent's captive portal bypass API
+func captiveBypassRequest(apiKey, method string, in, out interface{}) error {
+	key, err := resolveAPIKey(apiKey)
+	if err != nil {
+		return err
+	}
+	return api.NewClient(key).Do(method, captiveBypassPath, in, out)
+}
@@ -0,0 +1,117 @@
+package cmd
+
+import (
+	"fmt"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/ca"
+
+	"github.com/spf13/cobra"
+)
+
+// defaultRotationGrace is how long the previous CA stays trusted after a
+// rotation unless --grace is given
+const defaultRotationGrace = 7 * 24 * time.Hour
+
+// NewCACmd creates the ca command
+func NewCACmd() *cobra.Command {
+	caCmd := &cobra.Command{
+		Use:   "ca",
+		Short: "Manage the DNShield CA",
+	}
+
+	var grace time.Duration
+	var complete bool
+	rotateCmd := &cobra.Command{
+		Use:   "rotate",
+		Short: "Replace the CA, trusting both old and new during a grace window",
+		Long: `Generate a new CA and install it alongside the current one.
+
+Both CAs stay trusted for the grace window (--grace, default 7 days) so
+certificates issued by either are accepted while the agent switches over.
+A running agent picks up the new CA within a minute, re-issues block page
+certificates from it, and removes the old CA from the trust store once the
+grace window has passed.
+
+Use --complete to remove the old CA immediately.
+
+Rotation is supported for the file-based CA in ~/.dnshield/; the keychain
+CA of v2 security mode is replaced with 'uninstall' and 'install-ca'.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if ca.UseKeychain() {
+				return fmt.Errorf("rotation is not supported for the keychain CA; run 'dnshield uninstall' and 'dnshield install-ca'")
+			}
+			if complete {
+				return completeCARotation()
+			}
+			return rotateCA(grace)
+		},
+	}
+	rotateCmd.Flags().DurationVar(&grace, "grace", defaultRotationGrace, "How long the previous CA stays trusted")
+	rotateCmd.Flags().BoolVar(&complete, "complete", false, "Remove the previous CA of a pending rotation now")
+
+	caCmd.AddCommand(rotateCmd)
+	return caCmd
+}
+
+func rotateCA(grace time.Duration) error {
+	caPath := ca.GetCAPath()
+
+	newCA, rotation, err := ca.RotateCA(caPath, grace)
+	if err != nil {
+		audit.Log(audit.EventCARotated, "error", "CA rotation failed", map[string]interface{}{
+			"error": err.Error(),
+		})
+		return err
+	}
+	audit.Log(audit.EventCARotated, "info", "CA rotated", map[string]interface{}{
+		"previous_fingerprint": rotation.PreviousFingerprint,
+		"new_fingerprint":      rotation.NewFingerprint,
+		"grace_until":          rotation.GraceUntil,
+	})
+	fmt.Printf("✅ New CA created: %s\n", rotation.NewFingerprint)
+
+	fmt.Println("🔧 Installing the new CA; the previous CA stays trusted until the grace window ends.")
+	fmt.Println("📌 You may be prompted for your password.")
+	if err := ca.TrustPreviousCA(caPath); err != nil {
+		return fmt.Errorf("failed to keep previous CA trusted: %v", err)
+	}
+	if err := newCA.InstallCA(); err != nil {
+		audit.Log(audit.EventCAInstalled, "error", "Failed to install rotated CA", map[string]interface{}{
+			"fingerprint": rotation.NewFingerprint,
+		})
+		return fmt.Errorf("failed to install new CA (retry with 'dnshield install-ca'): %v", err)
+	}
+	audit.Log(audit.EventCAInstalled, "info", "Rotated CA installed", map[string]interface{}{
+		"fingerprint": rotation.NewFingerprint,
+	})
+
+	fmt.Printf("✅ Previous CA %s is trusted until %s\n", rotation.PreviousFingerprint, rotation.GraceUntil.Format(time.RFC3339))
+	fmt.Println("   A running agent switches to the new CA within a minute and removes the previous one when the grace window ends.")
+	return nil
+}
+
+func completeCARotation() error {
+	caPath := ca.GetCAPath()
+
+	rotation, err := ca.PendingRotation(caPath)
+	if err != nil {
+		return err
+	}
+	if rotation == nil {
+		return fmt.Errorf("no CA rotation in progress")
+	}
+	if err := ca.CompleteRotation(caPath); err != nil {
+		return err
+	}
+
+	audit.Log(audit.EventCAUninstalled, "info", "Previous CA removed after rotation", map[string]interface{}{
+		"fingerprint": rotation.PreviousFingerprint,
+		"early":       !rotation.Due(time.Now()),
+	})
+	fmt.Printf("✅ Previous CA %s removed\n", rotation.PreviousFingerprint)
+	return nil
+}
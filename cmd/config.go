@@ -0,0 +1,346 @@
+package cmd
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"text/template"
+
+	"dnshield/internal/config"
+
+	"github.com/spf13/cobra"
+)
+
+// NewConfigCmd creates the config command
+func NewConfigCmd() *cobra.Command {
+	configCmd := &cobra.Command{
+		Use:   "config",
+		Short: "Check a config file or generate a new one",
+	}
+	configCmd.AddCommand(newConfigValidateCmd(), newConfigInitCmd())
+	return configCmd
+}
+
+func newConfigValidateCmd() *cobra.Command {
+	var kiosk, strict bool
+
+	cmd := &cobra.Command{
+		Use:   "validate [file]",
+		Short: "Check a config file without starting the agent",
+		Long: `Check a config file (default ./config.yaml, then /etc/dnshield/config.yaml)
+the way the agent loads it, without running anything: YAML syntax, unknown
+keys, values of the wrong type and settings the agent would refuse are
+errors, each reported with its line and column.
+
+Warnings point out insecure settings: AWS keys and other secrets kept in the
+file instead of the environment, debug logging and the TCP API listener.
+With --kiosk, settings that let whoever uses an unattended, shared machine
+turn filtering off are reported too, such as agent.allowDisable.
+
+Exits non-zero when errors are found, or warnings with --strict, for use in
+CI before a config is rolled out.`,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			path := config.DefaultConfigPath()
+			if len(args) == 1 {
+				path = args[0]
+			}
+			if path == "" {
+				return fmt.Errorf("no config file found; pass its path")
+			}
+
+			issues, err := config.CheckConfig(path, config.CheckOptions{Kiosk: kiosk})
+			if err != nil {
+				return err
+			}
+			errors, warnings := 0, 0
+			for _, issue := range issues {
+				if issue.Severity == config.IssueError {
+					errors++
+					fmt.Printf("❌ %s: %s\n", path, issue)
+				} else {
+					warnings++
+					fmt.Printf("⚠️  %s: %s\n", path, issue)
+				}
+			}
+			if errors > 0 {
+				return fmt.Errorf("%d errors found", errors)
+			}
+			if strict && warnings > 0 {
+				return fmt.Errorf("%d warnings found", warnings)
+			}
+			fmt.Printf("✅ %s is valid\n", path)
+			return nil
+		},
+	}
+
+	cmd.Flags().BoolVar(&kiosk, "kiosk", false, "Also warn about settings a kiosk or other shared machine shouldn't have")
+	cmd.Flags().BoolVar(&strict, "strict", false, "Fail on warnings as well as errors")
+	return cmd
+}
+
+// Scenarios config init generates a config for
+const (
+	scenarioHome       = "home"
+	scenarioEnterprise = "enterprise"
+	scenarioHeadless   = "headless"
+)
+
+// upstreamChoices are the resolvers config init offers, "dhcp" being the
+// network's own
+var upstreamChoices = []struct {
+	name      string
+	upstreams []string
+}{
+	{"Cloudflare", []string{"1.1.1.1", "1.0.0.1"}},
+	{"Quad9", []string{"9.9.9.9", "149.112.112.112"}},
+	{"Google", []string{"8.8.8.8", "8.8.4.4"}},
+	{"The network's own resolvers (DHCP), falling back to Cloudflare", []string{"dhcp", "1.1.1.1"}},
+}
+
+// initAnswers fills in configTemplate
+type initAnswers struct {
+	Scenario     string
+	Upstreams    []string
+	AllowDisable bool
+	RulesDir     string
+	Bucket       string
+	Region       string
+	SigningKey   string
+	DNSPort      int
+	SinkholeIP   string
+}
+
+var configTemplate = template.Must(template.New("config").Funcs(template.FuncMap{"q": strconv.Quote}).Parse(
+	`# DNShield configuration ({{.Scenario}}), generated by dnshield config init.
+# docs/CONFIGURATION.md describes every setting; check changes with
+# dnshield config validate.
+
+agent:
+{{- if .DNSPort}}
+  dnsPort: {{.DNSPort}}
+{{- end}}
+  logLevel: info
+  # Whether users can pause protection from the menu bar or API
+  allowDisable: {{.AllowDisable}}
+  maxPause: 1h
+
+dns:
+  upstreams:
+{{- range .Upstreams}}
+    - {{q .}}
+{{- end}}
+  cacheSize: 10000
+  cacheTTL: 1h
+{{- if .SinkholeIP}}
+
+blocking:
+  # Where blocked names point: an address the clients can reach, for the
+  # block page
+  sinkholeIP: {{q .SinkholeIP}}
+{{- end}}
+{{- if .RulesDir}}
+
+rules:
+  # base.yaml, groups/ and users/ in this directory, applied as they change
+  localDir: {{q .RulesDir}}
+{{- end}}
+{{- if .Bucket}}
+
+s3:
+  bucket: {{q .Bucket}}
+  region: {{q .Region}}
+  updateInterval: 5m
+  # AWS credentials come from the instance role or AWS_ACCESS_KEY_ID and
+  # AWS_SECRET_ACCESS_KEY; don't put them in this file
+{{- if .SigningKey}}
+  # Rules are only applied with a valid signature from this key
+  signingKeys:
+    - {{q .SigningKey}}
+{{- end}}
+{{- end}}
+`))
+
+func newConfigInitCmd() *cobra.Command {
+	var out string
+	var force bool
+
+	cmd := &cobra.Command{
+		Use:   "init",
+		Short: "Generate a starter config by answering a few questions",
+		Long: `Ask a few questions and write a starter config for one of these setups:
+
+  home        A Mac at home or in a small office, with rules in a local
+              directory
+  enterprise  Managed devices that fetch their rules from an S3 bucket
+  headless    A resolver for a lab, CI or container network (dnshield run
+              --headless)
+
+The result is checked as 'dnshield config validate' would. An existing file
+is only replaced with --force.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if _, err := os.Stat(out); err == nil && !force {
+				return fmt.Errorf("%s already exists; use --force to replace it", out)
+			}
+
+			answers := askInitQuestions(newPrompter(os.Stdin, os.Stdout))
+			var buf strings.Builder
+			if err := configTemplate.Execute(&buf, answers); err != nil {
+				return err
+			}
+			if err := os.WriteFile(out, []byte(buf.String()), 0644); err != nil {
+				return err
+			}
+			fmt.Printf("\n📝 Wrote %s\n", out)
+
+			issues, err := config.CheckConfig(out, config.CheckOptions{})
+			if err != nil {
+				return err
+			}
+			for _, issue := range issues {
+				fmt.Printf("⚠️  %s: %s\n", out, issue)
+			}
+			switch answers.Scenario {
+			case scenarioHome:
+				fmt.Printf("   Put base.yaml in %s, then run: sudo dnshield run --config %s --auto-configure-dns\n", answers.RulesDir, out)
+			case scenarioEnterprise:
+				fmt.Println("   Lay out the bucket as in examples/s3-structure and check it with: dnshield rules lint")
+			case scenarioHeadless:
+				fmt.Printf("   Run: dnshield run --headless --config %s\n", out)
+			}
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&out, "out", "o", "config.yaml", "Config file to write")
+	cmd.Flags().BoolVar(&force, "force", false, "Replace an existing file")
+	return cmd
+}
+
+// askInitQuestions asks what config init needs to know for the scenario
+// picked
+func askInitQuestions(p *prompter) initAnswers {
+	scenarios := []string{scenarioHome, scenarioEnterprise, scenarioHeadless}
+	a := initAnswers{Scenario: scenarios[p.choose("What is DNShield for?", []string{
+		"Home or small office, rules in a local directory",
+		"Enterprise fleet, rules from an S3 bucket",
+		"Headless resolver for a lab, CI or container network",
+	}, 0)]}
+
+	names := make([]string, len(upstreamChoices)+1)
+	for i, choice := range upstreamChoices {
+		names[i] = choice.name
+	}
+	names[len(upstreamChoices)] = "Other"
+	if i := p.choose("Which upstream resolvers should answer queries?", names, 0); i < len(upstreamChoices) {
+		a.Upstreams = upstreamChoices[i].upstreams
+	} else {
+		a.Upstreams = strings.Fields(strings.ReplaceAll(p.ask("Upstream addresses, separated by commas", "1.1.1.1"), ",", " "))
+	}
+
+	switch a.Scenario {
+	case scenarioHome:
+		a.RulesDir = p.ask("Rules directory", "/etc/dnshield/rules")
+		a.AllowDisable = p.confirm("Let users pause protection?", true)
+	case scenarioEnterprise:
+		a.Bucket = p.askRequired("S3 bucket with the rules")
+		a.Region = p.ask("Bucket region", "us-east-1")
+		a.SigningKey = p.ask("Base64 public key rules are signed with (empty for unsigned rules)", "")
+		a.AllowDisable = p.confirm("Let users pause protection?", false)
+	case scenarioHeadless:
+		a.DNSPort = p.askInt("DNS port", 53)
+		a.SinkholeIP = p.askIP("Address of this host that clients reach, for blocked names", "")
+		a.RulesDir = p.ask("Rules directory (empty for none)", "")
+	}
+	return a
+}
+
+// prompter asks questions on a terminal, taking the default for an empty
+// answer or the end of input
+type prompter struct {
+	in  *bufio.Reader
+	out io.Writer
+}
+
+func newPrompter(in io.Reader, out io.Writer) *prompter {
+	return &prompter{in: bufio.NewReader(in), out: out}
+}
+
+func (p *prompter) ask(question, def string) string {
+	if def != "" {
+		fmt.Fprintf(p.out, "%s [%s]: ", question, def)
+	} else {
+		fmt.Fprintf(p.out, "%s: ", question)
+	}
+	line, _ := p.in.ReadString('\n')
+	if answer := strings.TrimSpace(line); answer != "" {
+		return answer
+	}
+	return def
+}
+
+// askRequired asks until it gets an answer or input ends
+func (p *prompter) askRequired(question string) string {
+	for {
+		fmt.Fprintf(p.out, "%s: ", question)
+		line, err := p.in.ReadString('\n')
+		if answer := strings.TrimSpace(line); answer != "" || err != nil {
+			return answer
+		}
+	}
+}
+
+func (p *prompter) confirm(question string, def bool) bool {
+	hint := "y/N"
+	if def {
+		hint = "Y/n"
+	}
+	switch strings.ToLower(p.ask(question+" ("+hint+")", "")) {
+	case "y", "yes":
+		return true
+	case "n", "no":
+		return false
+	}
+	return def
+}
+
+func (p *prompter) choose(question string, options []string, def int) int {
+	fmt.Fprintln(p.out, question)
+	for i, option := range options {
+		fmt.Fprintf(p.out, "  %d) %s\n", i+1, option)
+	}
+	for {
+		n, err := strconv.Atoi(p.ask("Choice", strconv.Itoa(def+1)))
+		if err == nil && n >= 1 && n <= len(options) {
+			return n - 1
+		}
+		fmt.Fprintf(p.out, "Enter a number from 1 to %d\n", len(options))
+	}
+}
+
+func (p *prompter) askInt(question string, def int) int {
+	for {
+		n, err := strconv.Atoi(p.ask(question, strconv.Itoa(def)))
+		if err == nil && n > 0 && n <= 65535 {
+			return n
+		}
+		fmt.Fprintln(p.out, "Enter a port from 1 to 65535")
+	}
+}
+
+func (p *prompter) askIP(question, def string) string {
+	for {
+		answer := p.ask(question, def)
+		if answer == "" || net.ParseIP(answer) != nil {
+			return answer
+		}
+		fmt.Fprintln(p.out, "Enter an IP address")
+	}
+}
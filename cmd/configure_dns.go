@@ -3,12 +3,12 @@ package cmd
 import (
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"regexp"
 	"strings"
 
 	"dnshield/internal/audit"
+	"dnshield/internal/dns"
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
 )
@@ -25,14 +25,19 @@ func NewConfigureDNSCmd() *cobra.Command {
 
 	cmd := &cobra.Command{
 		Use:   "configure-dns",
-		Short: "Configure DNS to 127.0.0.1 on all network interfaces",
-		Long: `Automatically configure all network interfaces to use 127.0.0.1 as the DNS server.
-This ensures DNShield filters all DNS traffic on the system.
+		Short: "Configure DNS to 127.0.0.1 and ::1 on all network interfaces",
+		Long: `Automatically configure all network interfaces to use 127.0.0.1 and ::1 as the DNS servers.
+This ensures DNShield filters all DNS traffic on the system, including on
+dual-stack networks whose routers advertise an IPv6 resolver.
 
 This command will:
 - List all network interfaces
-- Set DNS to 127.0.0.1 for each active interface
-- Save current DNS settings for restoration`,
+- Set DNS to 127.0.0.1 and ::1 for each active interface
+- Save current DNS settings for restoration
+
+On macOS the network services are changed with networksetup. On Linux the
+change goes through systemd-resolved, NetworkManager or /etc/resolv.conf,
+whichever manages DNS on the machine.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			if opts.Restore {
 				return restoreDNS()
@@ -123,64 +128,35 @@ func getDNSConfigPath() string {
 
 // getNetworkInterfaces returns all network interfaces
 func getNetworkInterfaces() ([]NetworkInterface, error) {
-	// Get list of network services
-	cmd := exec.Command("networksetup", "-listallnetworkservices")
-	output, err := cmd.Output()
+	// Network services on macOS; links, NetworkManager devices or
+	// resolv.conf on Linux
+	services, err := dns.ListDNSServices()
 	if err != nil {
 		return nil, fmt.Errorf("failed to list network services: %v", err)
 	}
 
-	logrus.WithField("raw_output", string(output)).Debug("Network services list")
+	logrus.WithFields(logrus.Fields{
+		"backend":       dns.DNSBackend(),
+		"service_count": len(services),
+	}).Debug("Found network services")
 
-	lines := strings.Split(string(output), "\n")
 	var interfaces []NetworkInterface
-	logrus.WithField("service_count", len(lines)-1).Debug("Found network services")
-
-	// Skip first line (header) and process each service
-	for i := 1; i < len(lines); i++ {
-		service := strings.TrimSpace(lines[i])
-		if service == "" {
+	for _, service := range services {
+		if !service.IsActive {
+			logrus.WithField("service", service.Name).Debug("Skipping disabled service")
 			continue
 		}
-		if strings.HasPrefix(service, "*") {
-			logrus.WithField("service", service).Debug("Skipping disabled service")
-			continue // Skip disabled services
-		}
 
-		logrus.WithField("service", service).Debug("Processing network service")
-		
 		// Validate service name to prevent command injection
-		if err := validateServiceName(service); err != nil {
-			logrus.WithError(err).WithField("service", service).Error("Invalid service name")
+		if err := validateServiceName(service.Name); err != nil {
+			logrus.WithError(err).WithField("service", service.Name).Error("Invalid service name")
 			continue
 		}
 
-		// Get current DNS servers
-		dnsCmd := exec.Command("networksetup", "-getdnsservers", service)
-		dnsOutput, err := dnsCmd.Output()
-		if err != nil {
-			logrus.WithError(err).WithField("service", service).Debug("Failed to get DNS servers")
-			continue
-		}
-
-		var currentDNS []string
-		dnsLines := strings.Split(strings.TrimSpace(string(dnsOutput)), "\n")
-		logrus.WithFields(logrus.Fields{
-			"service":    service,
-			"dns_output": strings.TrimSpace(string(dnsOutput)),
-		}).Debug("DNS servers output")
-
-		for _, dns := range dnsLines {
-			dns = strings.TrimSpace(dns)
-			if dns != "" && !strings.Contains(dns, "There aren't any DNS Servers") {
-				currentDNS = append(currentDNS, dns)
-			}
-		}
-
 		iface := NetworkInterface{
-			Name:    service,
-			Type:    determineInterfaceType(service),
-			Current: currentDNS,
+			Name:    service.Name,
+			Type:    determineInterfaceType(service.Name),
+			Current: service.DNSServers,
 		}
 		interfaces = append(interfaces, iface)
 
@@ -301,7 +277,7 @@ func configureDNS(opts *ConfigureDNSOptions) error {
 
 	// Confirm with user unless force flag is set
 	if !opts.Force {
-		fmt.Printf("\n⚠️  This will change DNS to 127.0.0.1 on ALL interfaces above.\n")
+		fmt.Printf("\n⚠️  This will change DNS to 127.0.0.1 and ::1 on ALL interfaces above.\n")
 		fmt.Printf("Continue? [y/N]: ")
 
 		var response string
@@ -346,31 +322,23 @@ func configureDNS(opts *ConfigureDNSOptions) error {
 			continue
 		}
 
-		// Set DNS to 127.0.0.1
-		cmd := exec.Command("networksetup", "-setdnsservers", iface.Name, "127.0.0.1")
+		// Point DNS at DNShield over IPv4 and IPv6
 		logrus.WithFields(logrus.Fields{
-			"command":   "networksetup",
-			"args":      []string{"-setdnsservers", iface.Name, "127.0.0.1"},
+			"backend":   dns.DNSBackend(),
+			"servers":   dns.LocalResolvers,
 			"interface": iface.Name,
-		}).Debug("Executing networksetup command")
+		}).Debug("Setting DNS servers")
 
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			logrus.WithError(err).WithFields(logrus.Fields{
-				"interface": iface.Name,
-				"output":    strings.TrimSpace(string(output)),
-			}).Error("Failed to set DNS")
+		if err := dns.SetDNSServers(iface.Name, dns.LocalResolvers...); err != nil {
+			logrus.WithError(err).WithField("interface", iface.Name).Error("Failed to set DNS")
 			if !opts.Force {
-				fmt.Printf("❌ Failed: %s\n", strings.TrimSpace(string(output)))
+				fmt.Printf("❌ Failed: %v\n", err)
 			}
 			failureCount++
 			continue
 		}
 
-		logrus.WithFields(logrus.Fields{
-			"interface": iface.Name,
-			"output":    strings.TrimSpace(string(output)),
-		}).Info("Successfully configured DNS on interface")
+		logrus.WithField("interface", iface.Name).Info("Successfully configured DNS on interface")
 		if !opts.Force {
 			fmt.Println("✅ Configured")
 		}
@@ -381,7 +349,7 @@ func configureDNS(opts *ConfigureDNSOptions) error {
 			"interface":    iface.Name,
 			"type":         iface.Type,
 			"previous_dns": iface.Current,
-			"new_dns":      []string{"127.0.0.1"},
+			"new_dns":      dns.LocalResolvers,
 		})
 	}
 
@@ -401,8 +369,8 @@ func configureDNS(opts *ConfigureDNSOptions) error {
 		} else {
 			verifiedCount := 0
 			for _, iface := range verifiedInterfaces {
-				for _, dns := range iface.Current {
-					if dns == "127.0.0.1" {
+				for _, server := range iface.Current {
+					if dns.IsLocalResolver(server) {
 						verifiedCount++
 						logrus.WithFields(logrus.Fields{
 							"interface": iface.Name,
@@ -500,16 +468,13 @@ func restoreDNS() error {
 
 		fmt.Printf("  %-20s ", interfaceName)
 
-		var cmd *exec.Cmd
-		if dnsServers == "DHCP" {
-			// Restore to DHCP
-			cmd = exec.Command("networksetup", "-setdnsservers", interfaceName, "Empty")
-		} else {
+		// No servers restores DHCP
+		var validServers []string
+		if dnsServers != "DHCP" {
 			// Restore specific DNS servers
 			servers := strings.Split(dnsServers, ",")
 			
 			// Validate each DNS server address
-			validServers := []string{}
 			for _, server := range servers {
 				server = strings.TrimSpace(server)
 				if err := validateDNSServer(server); err != nil {
@@ -524,14 +489,10 @@ func restoreDNS() error {
 				failureCount++
 				continue
 			}
-			
-			args := append([]string{"-setdnsservers", interfaceName}, validServers...)
-			cmd = exec.Command("networksetup", args...)
 		}
 
-		output, err := cmd.CombinedOutput()
-		if err != nil {
-			fmt.Printf("❌ Failed: %s\n", strings.TrimSpace(string(output)))
+		if err := dns.SetDNSServers(interfaceName, validServers...); err != nil {
+			fmt.Printf("❌ Failed: %v\n", err)
 			logrus.WithError(err).WithField("interface", interfaceName).Error("Failed to restore DNS")
 			failureCount++
 			continue
@@ -565,7 +526,7 @@ func restoreDNS() error {
 	return nil
 }
 
-// verifyDNSConfiguration checks if DNS is set to 127.0.0.1 on all interfaces
+// verifyDNSConfiguration checks if DNS is set to DNShield on all interfaces
 func VerifyDNSConfiguration() error {
 	interfaces, err := getNetworkInterfaces()
 	if err != nil {
@@ -575,8 +536,8 @@ func VerifyDNSConfiguration() error {
 	notConfigured := []string{}
 	for _, iface := range interfaces {
 		isConfigured := false
-		for _, dns := range iface.Current {
-			if dns == "127.0.0.1" {
+		for _, server := range iface.Current {
+			if dns.IsLocalResolver(server) {
 				isConfigured = true
 				break
 			}
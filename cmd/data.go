@@ -0,0 +1,94 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"dnshield/internal/api"
+
+	"github.com/spf13/cobra"
+)
+
+// NewDataCmd creates the data command
+func NewDataCmd() *cobra.Command {
+	dataCmd := &cobra.Command{
+		Use:   "data",
+		Short: "Delete query and audit data logged on this device",
+	}
+	var apiKey string
+
+	var before, user string
+	purgeCmd := &cobra.Command{
+		Use:   "purge",
+		Short: "Delete logged data by date, by user or both",
+		Long: `Delete what the running agent logged before a date, about a user, or both:
+queries in the local query log, events in the audit files, and events not
+yet delivered to Splunk or S3. Use it to answer GDPR erasure requests:
+
+  dnshield data purge --before 2024-01-01
+  dnshield data purge --user jane@example.com
+
+The query log doesn't record users, so --user only purges it when the user
+is this device's user. The purge itself is recorded in the audit log with
+who ran it, and that record is kept. Requires an admin API key, taken from
+--api-key, then DNSHIELD_API_KEY, then the local key file.
+
+Data is also deleted automatically once it passes its retention:
+queryLog.retention for the query log and logging.local.retention for the
+rest.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var req api.DataPurgeRequest
+			if before != "" {
+				t, err := parsePurgeDate(before)
+				if err != nil {
+					return err
+				}
+				req.Before = t
+			}
+			req.User = user
+			if req.Before.IsZero() && req.User == "" {
+				return fmt.Errorf("set --before, --user or both")
+			}
+
+			key, err := resolveAPIKey(apiKey)
+			if err != nil {
+				return err
+			}
+			client := api.NewClient(key)
+			client.SetTimeout(2 * time.Minute)
+			var result api.DataPurgeResult
+			if err := client.Do(http.MethodPost, api.DataPurgePath, req, &result); err != nil {
+				return err
+			}
+
+			fmt.Printf("🗑  Purged %d queries, %d audit events and %d undelivered events\n",
+				result.QueryLog, result.AuditEvents, result.BufferedEvents)
+			if result.QueryLogSkipped {
+				fmt.Printf("   Query log kept: it doesn't record users and %s isn't this device's user\n", user)
+			}
+			return nil
+		},
+	}
+	purgeCmd.Flags().StringVar(&before, "before", "", "delete data logged before this date (YYYY-MM-DD or RFC 3339)")
+	purgeCmd.Flags().StringVar(&user, "user", "", "delete data by or about this user")
+
+	dataCmd.AddCommand(purgeCmd)
+	dataCmd.PersistentFlags().StringVar(&apiKey, "api-key", "", "API key to authenticate with")
+	return dataCmd
+}
+
+// parsePurgeDate parses a date, taken as local midnight, or an RFC 3339
+// time
+func parsePurgeDate(value string) (time.Time, error) {
+	if t, err := time.ParseInLocation("2006-01-02", value, time.Local); err == nil {
+		return t, nil
+	}
+	t, err := time.Parse(time.RFC3339, value)
+	if err != nil {
+		return time.Time{}, fmt.Errorf("invalid --before %q (use YYYY-MM-DD or RFC 3339)", value)
+	}
+	return t, nil
+}
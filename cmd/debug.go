@@ -0,0 +1,259 @@
+package cmd
+
+import (
+	"archive/tar"
+	"bytes"
+	"compress/gzip"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"runtime"
+	"sort"
+	"time"
+
+	"dnshield/internal/api"
+
+	"github.com/spf13/cobra"
+)
+
+// DebugManifest describes the contents of a support archive
+type DebugManifest struct {
+	CreatedAt time.Time         `json:"created_at"`
+	Hostname  string            `json:"hostname"`
+	GOOS      string            `json:"goos"`
+	GOARCH    string            `json:"goarch"`
+	Files     []string          `json:"files"`
+	Errors    map[string]string `json:"errors,omitempty"` // Item -> why it is missing
+}
+
+type debugCollectOptions struct {
+	apiKey     string
+	output     string
+	logFile    string
+	logBytes   int64
+	cpuSeconds int
+}
+
+// debugEndpoint is an API path saved into the archive as name
+type debugEndpoint struct {
+	name string
+	path string
+}
+
+// NewDebugCmd creates the debug command
+func NewDebugCmd() *cobra.Command {
+	debugCmd := &cobra.Command{
+		Use:   "debug",
+		Short: "Runtime diagnostics for support",
+	}
+
+	opts := debugCollectOptions{}
+	collectCmd := &cobra.Command{
+		Use:   "collect",
+		Short: "Bundle profiles and recent logs into a support archive",
+		Long: `Collect a support archive (.tar.gz) from the running agent containing:
+
+  - status, statistics and health from the API
+  - heap, goroutine, allocs and CPU profiles plus a goroutine dump
+  - the tail of the agent log and the latest audit log
+
+Profiles require api.profiling: true in the agent configuration and an
+admin API key. Anything that can't be collected is listed in manifest.json
+and the rest of the archive is still written.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runDebugCollect(opts)
+		},
+	}
+
+	collectCmd.Flags().StringVar(&opts.apiKey, "api-key", "", "Admin API key (default: DNSHIELD_API_KEY, or none over the API socket)")
+	collectCmd.Flags().StringVarP(&opts.output, "output", "o", "", "Archive path (default: ./dnshield-debug-<host>-<time>.tar.gz)")
+	collectCmd.Flags().StringVar(&opts.logFile, "log-file", "/var/log/dnshield.log", "Agent log file to include")
+	collectCmd.Flags().Int64Var(&opts.logBytes, "log-bytes", 5*1024*1024, "Maximum bytes of each log to include (from the end)")
+	collectCmd.Flags().IntVar(&opts.cpuSeconds, "cpu-seconds", 5, "CPU profile duration in seconds (0 to skip)")
+
+	debugCmd.AddCommand(collectCmd)
+	return debugCmd
+}
+
+func runDebugCollect(opts debugCollectOptions) error {
+	// The API server's write timeout is 10s, so longer CPU profiles fail
+	if opts.cpuSeconds < 0 || opts.cpuSeconds > 9 {
+		return fmt.Errorf("--cpu-seconds must be between 0 and 9")
+	}
+
+	hostname, _ := os.Hostname()
+	now := time.Now()
+	if opts.output == "" {
+		opts.output = fmt.Sprintf("dnshield-debug-%s-%s.tar.gz", hostname, now.Format("20060102-150405"))
+	}
+
+	manifest := &DebugManifest{
+		CreatedAt: now,
+		Hostname:  hostname,
+		GOOS:      runtime.GOOS,
+		GOARCH:    runtime.GOARCH,
+		Errors:    make(map[string]string),
+	}
+	files := make(map[string][]byte)
+
+	// API data needs a key; logs are still collected without one
+	var client *api.Client
+	if key, err := resolveAPIKey(opts.apiKey); err != nil {
+		manifest.Errors["api"] = err.Error()
+	} else {
+		client = api.NewClient(key)
+	}
+
+	if client != nil {
+		endpoints := []debugEndpoint{
+			{"health.json", "/api/health"},
+			{"status.json", "/api/status"},
+			{"statistics.json", "/api/statistics"},
+			{"top.json", "/api/top"},
+			{"profiles/heap.pb.gz", api.DebugPathPrefix + "heap"},
+			{"profiles/allocs.pb.gz", api.DebugPathPrefix + "allocs"},
+			{"profiles/goroutine.pb.gz", api.DebugPathPrefix + "goroutine"},
+			{"goroutines.txt", "/api/debug/goroutines"},
+		}
+		if opts.cpuSeconds > 0 {
+			cpuPath := fmt.Sprintf("%sprofile?seconds=%d", api.DebugPathPrefix, opts.cpuSeconds)
+			endpoints = append(endpoints, debugEndpoint{"profiles/cpu.pb.gz", cpuPath})
+			client.SetTimeout(time.Duration(opts.cpuSeconds)*time.Second + 5*time.Second)
+		}
+
+		for _, e := range endpoints {
+			var buf bytes.Buffer
+			if err := client.Fetch(e.path, &buf); err != nil {
+				manifest.Errors[e.name] = err.Error()
+				continue
+			}
+			files[e.name] = buf.Bytes()
+		}
+	}
+
+	if data, err := tailFile(opts.logFile, opts.logBytes); err != nil {
+		manifest.Errors["logs/dnshield.log"] = err.Error()
+	} else {
+		files["logs/dnshield.log"] = data
+	}
+
+	if auditLog, err := latestAuditLog(); err != nil {
+		manifest.Errors["logs/audit.log"] = err.Error()
+	} else if data, err := tailFile(auditLog, opts.logBytes); err != nil {
+		manifest.Errors["logs/audit.log"] = err.Error()
+	} else {
+		files["logs/"+filepath.Base(auditLog)] = data
+	}
+
+	for name := range files {
+		manifest.Files = append(manifest.Files, name)
+	}
+	sort.Strings(manifest.Files)
+
+	if err := writeDebugArchive(opts.output, manifest, files); err != nil {
+		return err
+	}
+
+	fmt.Printf("📦 Wrote %s (%d files)\n", opts.output, len(manifest.Files))
+	if len(manifest.Errors) > 0 {
+		fmt.Println("⚠️  Some items could not be collected:")
+		names := make([]string, 0, len(manifest.Errors))
+		for name := range manifest.Errors {
+			names = append(names, name)
+		}
+		sort.Strings(names)
+		for _, name := range names {
+			fmt.Printf("   %s: %s\n", name, manifest.Errors[name])
+		}
+	}
+	return nil
+}
+
+// tailFile returns at most max bytes from the end of path
+func tailFile(path string, max int64) ([]byte, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	info, err := f.Stat()
+	if err != nil {
+		return nil, err
+	}
+	if info.Size() > max {
+		if _, err := f.Seek(info.Size()-max, io.SeekStart); err != nil {
+			return nil, err
+		}
+	}
+	return io.ReadAll(io.LimitReader(f, max))
+}
+
+// latestAuditLog returns the most recent audit log in ~/.dnshield/audit
+func latestAuditLog() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	matches, err := filepath.Glob(filepath.Join(home, ".dnshield", "audit", "audit-*.log"))
+	if err != nil {
+		return "", err
+	}
+	if len(matches) == 0 {
+		return "", fmt.Errorf("no audit logs found")
+	}
+	// Names embed the date, so the last one is the newest
+	sort.Strings(matches)
+	return matches[len(matches)-1], nil
+}
+
+// writeDebugArchive writes manifest.json and files into a gzipped tarball
+func writeDebugArchive(path string, manifest *DebugManifest, files map[string][]byte) error {
+	out, err := os.OpenFile(path, os.O_WRONLY|os.O_CREATE|os.O_EXCL, 0600)
+	if err != nil {
+		return fmt.Errorf("failed to create archive: %v", err)
+	}
+	defer out.Close()
+
+	gz := gzip.NewWriter(out)
+	tw := tar.NewWriter(gz)
+
+	manifestData, err := json.MarshalIndent(manifest, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode manifest: %v", err)
+	}
+
+	add := func(name string, data []byte) error {
+		hdr := &tar.Header{
+			Name:    name,
+			Mode:    0600,
+			Size:    int64(len(data)),
+			ModTime: manifest.CreatedAt,
+		}
+		if err := tw.WriteHeader(hdr); err != nil {
+			return err
+		}
+		_, err := tw.Write(data)
+		return err
+	}
+
+	if err := add("manifest.json", manifestData); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	for _, name := range manifest.Files {
+		if err := add(name, files[name]); err != nil {
+			return fmt.Errorf("failed to write archive: %v", err)
+		}
+	}
+
+	if err := tw.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	if err := gz.Close(); err != nil {
+		return fmt.Errorf("failed to write archive: %v", err)
+	}
+	return out.Close()
+}
@@ -12,16 +12,26 @@ import (
 )
 
 func NewInstallCACmd() *cobra.Command {
-	return &cobra.Command{
+	cmd := &cobra.Command{
 		Use:   "install-ca",
 		Short: "Generate and install the CA certificate",
 		Long: `Generate a local Certificate Authority and install it in the system keychain.
 This is required for HTTPS interception without certificate warnings.
 
 The CA certificate will be stored in ~/.dnshield/ and installed in the system keychain.
-You will be prompted for your password to install the certificate.`,
+You will be prompted for your password to install the certificate.
+
+Use --profile to install the CA for a device group that has its own CA
+configured in the S3 group rules. Profile CAs are stored in
+~/.dnshield/profiles/<name>/.`,
 		RunE: runInstallCA,
 	}
+
+	cmd.Flags().String("profile", "", "Install the CA for this group CA profile")
+	cmd.Flags().String("organization", "", "Organization for a newly created profile CA")
+	cmd.Flags().String("organizational-unit", "", "Organizational unit for a newly created profile CA")
+
+	return cmd
 }
 
 func runInstallCA(cmd *cobra.Command, args []string) error {
@@ -43,7 +53,23 @@ func runInstallCA(cmd *cobra.Command, args []string) error {
 
 	// Load or create CA
 	fmt.Println("📝 Loading or creating CA certificate...")
-	caManager, err := ca.LoadOrCreateManager()
+	profileName, _ := cmd.Flags().GetString("profile")
+	caPath := ca.GetCAPath()
+
+	var caManager ca.Manager
+	var err error
+	if profileName != "" {
+		organization, _ := cmd.Flags().GetString("organization")
+		unit, _ := cmd.Flags().GetString("organizational-unit")
+		caManager, err = ca.LoadOrCreateProfileCA(ca.Profile{
+			Name:               profileName,
+			Organization:       organization,
+			OrganizationalUnit: unit,
+		})
+		caPath = ca.ProfileCAPath(profileName)
+	} else {
+		caManager, err = ca.LoadOrCreateManager()
+	}
 	if err != nil {
 		return fmt.Errorf("failed to load/create CA: %v", err)
 	}
@@ -52,7 +78,7 @@ func runInstallCA(cmd *cobra.Command, args []string) error {
 	cert := caManager.Certificate()
 	fmt.Printf("✅ CA Subject: %s\n", cert.Subject)
 	fmt.Printf("✅ Valid until: %s\n", cert.NotAfter.Format("2006-01-02"))
-	fmt.Printf("✅ CA Path: %s\n", ca.GetCAPath())
+	fmt.Printf("✅ CA Path: %s\n", caPath)
 
 	// Install CA
 	fmt.Println("\n🔧 Installing CA in system keychain...")
@@ -64,7 +90,7 @@ func runInstallCA(cmd *cobra.Command, args []string) error {
 		fmt.Println("\nManual installation instructions:")
 		fmt.Printf("1. Open Keychain Access\n")
 		fmt.Printf("2. Go to System keychain\n")
-		fmt.Printf("3. Drag and drop: %s/ca.crt\n", ca.GetCAPath())
+		fmt.Printf("3. Drag and drop: %s/ca.crt\n", caPath)
 		fmt.Printf("4. Trust the certificate for SSL\n")
 		return err
 	}
@@ -0,0 +1,120 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/oidc"
+	"dnshield/internal/rules"
+
+	"github.com/spf13/cobra"
+)
+
+// NewLoginCmd creates the login command
+func NewLoginCmd() *cobra.Command {
+	var configFile string
+	var logout bool
+
+	cmd := &cobra.Command{
+		Use:   "login",
+		Short: "Sign this device in to the identity provider",
+		Long: `Sign in with the identity provider in oidc.issuer so the agent applies the
+rules of the signed-in user, and of the group the identity provider assigns,
+instead of looking the device up in the device mapping.
+
+A code is shown to enter at the identity provider's verification page, from
+any browser. The ID and refresh tokens are saved to oidc.tokenFile (this
+needs root) and refreshed by the agent. --logout removes them.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cfg, err := config.LoadConfig(configFile)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %v", err)
+			}
+			if !cfg.OIDC.Enabled {
+				return fmt.Errorf("oidc is not enabled in the config")
+			}
+			if logout {
+				if err := os.Remove(cfg.OIDC.TokenFile); err != nil && !os.IsNotExist(err) {
+					return err
+				}
+				fmt.Println("✅ Signed out; the device mapping applies from the next rules update")
+				return nil
+			}
+			return runLogin(&cfg.OIDC)
+		},
+	}
+
+	cmd.Flags().StringVarP(&configFile, "config", "c", "", "config file path")
+	cmd.Flags().BoolVar(&logout, "logout", false, "remove the saved tokens")
+	return cmd
+}
+
+func runLogin(cfg *config.OIDCConfig) error {
+	ctx, cancel := context.WithTimeout(context.Background(), 15*time.Minute)
+	defer cancel()
+
+	client := oidc.NewClient(cfg.Issuer, cfg.ClientID, cfg.Scopes)
+	auth, err := client.StartDeviceAuthorization(ctx)
+	if err != nil {
+		return err
+	}
+	if auth.VerificationURIComplete != "" {
+		fmt.Printf("🔑 Open %s\n", auth.VerificationURIComplete)
+		fmt.Printf("   and check the code is %s\n", auth.UserCode)
+	} else {
+		fmt.Printf("🔑 Open %s\n", auth.VerificationURI)
+		fmt.Printf("   and enter the code %s\n", auth.UserCode)
+	}
+	fmt.Println("   Waiting for approval...")
+
+	token, err := client.PollDeviceToken(ctx, auth)
+	if err != nil {
+		return err
+	}
+	identity, err := client.Verify(ctx, token.IDToken, cfg.EmailClaim, cfg.GroupsClaim)
+	if err != nil {
+		return err
+	}
+	if err := token.Save(cfg.TokenFile); err != nil {
+		return fmt.Errorf("failed to save tokens (run with sudo): %v", err)
+	}
+
+	fmt.Printf("✅ Signed in as %s\n", identity.Email)
+	if group := mapOIDCGroup(cfg, identity.Groups); group != "" {
+		fmt.Printf("   Group: %s\n", group)
+	}
+	fmt.Println("   Run 'dnshield update-rules' to apply the user's rules now")
+	return nil
+}
+
+// newUserResolver resolves the user of this device from the saved OIDC
+// login, or returns nil if oidc is not enabled
+func newUserResolver(cfg *config.OIDCConfig) rules.UserResolver {
+	if !cfg.Enabled {
+		return nil
+	}
+	client := oidc.NewClient(cfg.Issuer, cfg.ClientID, cfg.Scopes)
+	resolver := oidc.NewResolver(client, cfg.TokenFile, cfg.EmailClaim, cfg.GroupsClaim)
+	return func(ctx context.Context) (string, string, error) {
+		identity, err := resolver.Identity(ctx)
+		if err != nil {
+			return "", "", err
+		}
+		return identity.Email, mapOIDCGroup(cfg, identity.Groups), nil
+	}
+}
+
+// mapOIDCGroup returns the DNShield group of the first of groups in
+// oidc.groupMapping
+func mapOIDCGroup(cfg *config.OIDCConfig, groups []string) string {
+	for _, group := range groups {
+		if mapped, ok := cfg.GroupMapping[group]; ok {
+			return mapped
+		}
+	}
+	return ""
+}
@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"os"
+	"strings"
+
+	"dnshield/internal/policy"
+
+	"github.com/spf13/cobra"
+)
+
+// NewPolicyCmd creates the policy command
+func NewPolicyCmd() *cobra.Command {
+	policyCmd := &cobra.Command{
+		Use:   "policy",
+		Short: "Create and sign managed policies",
+		Long: `Create signing keys and sign managed policies for enrolled agents.
+
+A policy is JSON such as:
+
+  {
+    "serial": 3,
+    "issued_at": "2025-01-01T00:00:00Z",
+    "allow_disable": false,
+    "max_pause": "15m",
+    "allow_config_changes": false,
+    "allow_uninstall": false,
+    "rule_sources": {"bucket": "corp-dnshield", "region": "us-east-1"}
+  }
+
+Sign it with the private key and deliver the output through MDM (the
+managedPolicy.path file) or S3 (managedPolicy.s3Key). Agents verify it
+with managedPolicy.publicKey. Increase serial for every new policy; agents
+reject policies older than the one in effect.`,
+	}
+
+	var keyOut string
+	keygenCmd := &cobra.Command{
+		Use:          "keygen",
+		Short:        "Generate a policy signing key pair",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pub, priv, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return fmt.Errorf("failed to generate key: %v", err)
+			}
+			encoded := base64.StdEncoding.EncodeToString(priv)
+			if err := os.WriteFile(keyOut, []byte(encoded+"\n"), 0600); err != nil {
+				return fmt.Errorf("failed to write private key: %v", err)
+			}
+			fmt.Printf("🔑 Private key written to %s (keep it off managed devices)\n", keyOut)
+			fmt.Printf("Public key for managedPolicy.publicKey:\n%s\n", base64.StdEncoding.EncodeToString(pub))
+			return nil
+		},
+	}
+	keygenCmd.Flags().StringVarP(&keyOut, "out", "o", "policy-signing.key", "Private key file")
+
+	var keyFile, signOut string
+	signCmd := &cobra.Command{
+		Use:          "sign <policy.json>",
+		Short:        "Sign a policy",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keyData, err := os.ReadFile(keyFile)
+			if err != nil {
+				return fmt.Errorf("failed to read private key: %v", err)
+			}
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyData)))
+			if err != nil || len(key) != ed25519.PrivateKeySize {
+				return fmt.Errorf("invalid private key in %s", keyFile)
+			}
+
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			var p policy.Policy
+			if err := json.Unmarshal(data, &p); err != nil {
+				return fmt.Errorf("invalid policy: %v", err)
+			}
+
+			signed, err := policy.Sign(&p, ed25519.PrivateKey(key))
+			if err != nil {
+				return err
+			}
+			if signOut == "" {
+				fmt.Println(string(signed))
+				return nil
+			}
+			return os.WriteFile(signOut, append(signed, '\n'), 0644)
+		},
+	}
+	signCmd.Flags().StringVarP(&keyFile, "key", "k", "policy-signing.key", "Private key file")
+	signCmd.Flags().StringVarP(&signOut, "out", "o", "", "Signed policy file (default: stdout)")
+
+	var publicKey string
+	verifyCmd := &cobra.Command{
+		Use:          "verify <signed-policy.json>",
+		Short:        "Verify a signed policy and print it",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := policy.ParsePublicKey(publicKey)
+			if err != nil {
+				return err
+			}
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			p, err := policy.Verify(data, key)
+			if err != nil {
+				return err
+			}
+			out, _ := json.MarshalIndent(p, "", "  ")
+			fmt.Printf("✅ Signature valid\n%s\n", out)
+			return nil
+		},
+	}
+	verifyCmd.Flags().StringVar(&publicKey, "public-key", "", "Base64 public key")
+	verifyCmd.MarkFlagRequired("public-key")
+
+	policyCmd.AddCommand(keygenCmd, signCmd, verifyCmd)
+	return policyCmd
+}
@@ -0,0 +1,123 @@
+package cmd
+
+import (
+	"crypto/x509"
+	"encoding/pem"
+	"fmt"
+	"os"
+
+	"dnshield/internal/ca"
+	"dnshield/internal/mobileconfig"
+
+	"github.com/spf13/cobra"
+)
+
+// NewProfileCmd creates the profile command
+func NewProfileCmd() *cobra.Command {
+	profileCmd := &cobra.Command{
+		Use:   "profile",
+		Short: "Generate configuration profiles for MDM deployment",
+	}
+
+	opts := mobileconfig.Options{}
+	var caCertFile, caProfile, dohURL, dotServerName, out string
+	generateCmd := &cobra.Command{
+		Use:   "generate",
+		Short: "Generate a .mobileconfig with the CA trust and DNS settings",
+		Long: `Generate a macOS configuration profile for Jamf, Kandji or another MDM,
+so devices trust DNShield's CA without running 'install-ca' on each one.
+
+The profile trusts this machine's CA, the CA of a group CA profile
+(--ca-profile), or any CA certificate (--ca-cert). Devices must issue block
+page certificates from the same CA.
+
+macOS DNS Settings payloads only support encrypted DNS. Pass --doh-url or
+--dot-server-name to include one; otherwise run the agent with
+--auto-configure-dns to point the system resolvers at DNShield.`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			cert, err := profileCACertificate(caCertFile, caProfile)
+			if err != nil {
+				return err
+			}
+			opts.CA = cert
+
+			switch {
+			case dohURL != "" && dotServerName != "":
+				return fmt.Errorf("--doh-url and --dot-server-name are mutually exclusive")
+			case dohURL != "":
+				opts.DNSProtocol = mobileconfig.DNSProtocolHTTPS
+				opts.ServerURL = dohURL
+			case dotServerName != "":
+				opts.DNSProtocol = mobileconfig.DNSProtocolTLS
+				opts.ServerName = dotServerName
+			case len(opts.ServerAddresses) > 0:
+				return fmt.Errorf("--dns-server needs --doh-url or --dot-server-name")
+			}
+
+			profile, err := mobileconfig.Generate(opts)
+			if err != nil {
+				return err
+			}
+			if out == "" {
+				fmt.Print(string(profile))
+				return nil
+			}
+			if err := os.WriteFile(out, profile, 0644); err != nil {
+				return err
+			}
+			fmt.Fprintf(os.Stderr, "✅ Profile written to %s (CA: %s)\n", out, cert.Subject)
+			return nil
+		},
+	}
+	generateCmd.Flags().StringVar(&opts.Identifier, "identifier", "com.dnshield.profile", "Profile identifier; profiles with the same identifier replace each other")
+	generateCmd.Flags().StringVar(&opts.DisplayName, "name", "DNShield", "Profile name shown in System Settings")
+	generateCmd.Flags().StringVar(&opts.Organization, "organization", "", "Organization shown in System Settings")
+	generateCmd.Flags().StringVar(&caCertFile, "ca-cert", "", "PEM CA certificate to trust instead of this machine's CA")
+	generateCmd.Flags().StringVar(&caProfile, "ca-profile", "", "Trust the CA of this group CA profile")
+	generateCmd.Flags().StringVar(&dohURL, "doh-url", "", "DNS-over-HTTPS server URL for the DNS Settings payload")
+	generateCmd.Flags().StringVar(&dotServerName, "dot-server-name", "", "DNS-over-TLS server name for the DNS Settings payload")
+	generateCmd.Flags().StringSliceVar(&opts.ServerAddresses, "dns-server", nil, "Addresses of the encrypted DNS server")
+	generateCmd.Flags().StringVarP(&out, "out", "o", "", "Profile file (default: stdout)")
+
+	profileCmd.AddCommand(generateCmd)
+	return profileCmd
+}
+
+// profileCACertificate loads the CA certificate a profile should trust
+func profileCACertificate(certFile, profile string) (*x509.Certificate, error) {
+	if certFile != "" && profile != "" {
+		return nil, fmt.Errorf("--ca-cert and --ca-profile are mutually exclusive")
+	}
+	if certFile != "" {
+		data, err := os.ReadFile(certFile)
+		if err != nil {
+			return nil, err
+		}
+		block, _ := pem.Decode(data)
+		if block == nil || block.Type != "CERTIFICATE" {
+			return nil, fmt.Errorf("%s is not a PEM certificate", certFile)
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			return nil, fmt.Errorf("invalid certificate in %s: %v", certFile, err)
+		}
+		if !cert.IsCA {
+			return nil, fmt.Errorf("%s is not a CA certificate", certFile)
+		}
+		return cert, nil
+	}
+
+	var manager ca.Manager
+	var err error
+	if profile != "" {
+		manager, err = ca.LoadOrCreateProfileCA(ca.Profile{Name: profile})
+	} else {
+		manager, err = ca.LoadOrCreateManager()
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to load CA: %v", err)
+	}
+	return manager.Certificate(), nil
+}
@@ -0,0 +1,221 @@
+package cmd
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/url"
+	"os"
+	"strings"
+
+	"dnshield/internal/api"
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"dnshield/internal/policy"
+	"dnshield/internal/rules"
+
+	miekg "github.com/miekg/dns"
+	"github.com/spf13/cobra"
+)
+
+// NewQueryCmd creates the query command
+func NewQueryCmd() *cobra.Command {
+	var (
+		apiKey     string
+		configFile string
+		dryRun     bool
+		asJSON     bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "query <domain> [type]",
+		Short: "Resolve a domain through DNShield and show what it did",
+		Long: `Resolve a domain the way the running agent does for this device, like dig,
+and show the verdict (blocked or allowed, with the rule), whether the answer
+came from the cache, the upstream that answered, the response time and the
+full answer section. The type defaults to A.
+
+  dnshield query ads.example.com
+  dnshield query example.com AAAA
+
+The query is a real one: it is counted in the statistics and query log and
+may fill the cache. Use 'dnshield why' to see every rule matching a name
+without resolving it.
+
+With --dry-run the agent isn't asked. The name is resolved by a handler
+built here from the config and the cached enterprise rules, with an empty
+cache, so it shows what a freshly started agent would do.
+
+The API key is taken from --api-key, then DNSHIELD_API_KEY; without one, the
+API socket identifies the user. It needs the config:view permission.`,
+		Args:         cobra.RangeArgs(1, 2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			domain := args[0]
+			qtype := miekg.TypeA
+			if len(args) == 2 {
+				var ok bool
+				if qtype, ok = miekg.StringToType[strings.ToUpper(args[1])]; !ok {
+					return fmt.Errorf("unknown record type %q", args[1])
+				}
+			}
+
+			var result *api.ResolveResult
+			if dryRun {
+				var err error
+				if result, err = resolveDryRun(configFile, domain, qtype); err != nil {
+					return err
+				}
+			} else {
+				key, err := resolveAPIKey(apiKey)
+				if err != nil {
+					return err
+				}
+				path := api.ResolvePath + "?domain=" + url.QueryEscape(domain) + "&type=" + miekg.TypeToString[qtype]
+				result = &api.ResolveResult{}
+				if err := api.NewClient(key).Get(path, result); err != nil {
+					return err
+				}
+			}
+
+			if asJSON {
+				encoder := json.NewEncoder(os.Stdout)
+				encoder.SetIndent("", "  ")
+				return encoder.Encode(result)
+			}
+			printResolveResult(result)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&apiKey, "api-key", "", "API key to authenticate with")
+	cmd.Flags().StringVarP(&configFile, "config", "c", "", "config file path, for --dry-run")
+	cmd.Flags().BoolVar(&dryRun, "dry-run", false, "Resolve here from the config and cached rules instead of asking the agent")
+	cmd.Flags().BoolVar(&asJSON, "json", false, "Print the result as JSON")
+
+	return cmd
+}
+
+// resolveResult describes the response resp a handler gave and what it
+// did, as reported in event
+func resolveResult(resp *miekg.Msg, event dns.QueryEvent) *api.ResolveResult {
+	result := &api.ResolveResult{
+		Domain:        event.Domain,
+		Type:          event.QueryType,
+		Action:        event.Action,
+		Rule:          event.Rule,
+		Rcode:         event.Rcode,
+		Upstream:      event.Upstream,
+		UpstreamRTTMs: float64(event.UpstreamRTT.Microseconds()) / 1000,
+		DurationMs:    float64(event.Duration.Microseconds()) / 1000,
+		Answer:        []string{},
+	}
+	if resp == nil {
+		return result
+	}
+	// Queries refused before they are looked at have no event details
+	if result.Rcode == "" {
+		result.Rcode = miekg.RcodeToString[resp.Rcode]
+	}
+	for _, rr := range resp.Answer {
+		result.Answer = append(result.Answer, rr.String())
+	}
+	for _, rr := range resp.Ns {
+		result.Authority = append(result.Authority, rr.String())
+	}
+	return result
+}
+
+// resolveDryRun resolves domain with a handler of its own, set up from the
+// config and the cached enterprise rules as the agent would be
+func resolveDryRun(configFile, domain string, qtype uint16) (*api.ResolveResult, error) {
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to load config: %v", err)
+	}
+	if policy.Enrolled(&cfg.ManagedPolicy) {
+		manager := newPolicyManager(cfg)
+		manager.Load()
+		manager.Policy().Apply(cfg)
+	}
+
+	blocker := dns.NewBlocker()
+	blocker.SetMaxDomains(cfg.Rules.MaxDomains)
+	blocker.SetDefaultEnforcement(cfg.Agent.Enforcement)
+	if len(cfg.TestDomains) > 0 {
+		if err := blocker.UpdateDomains(cfg.TestDomains); err != nil {
+			return nil, fmt.Errorf("failed to load test domains: %v", err)
+		}
+	}
+
+	if cached, err := rules.LoadCache(rules.DefaultCachePath); err == nil {
+		parser := rules.NewParser()
+		parser.SetLimits(cfg.Rules.MaxFileSize, cfg.Rules.MaxDomains)
+		parser.SetCache(rules.NewBlocklistCache(rules.DefaultBlocklistCacheDir))
+		if _, _, ok := loadBlockerRules(cached, parser, blocker); !ok {
+			return nil, fmt.Errorf("failed to load the cached rules from %s", rules.DefaultCachePath)
+		}
+		blocker.UpdateMetadata(cached.UserEmail, cached.GroupName)
+	} else {
+		fmt.Fprintf(os.Stderr, "⚠️  No cached rules in %s; only the config's test domains are blocked\n", rules.DefaultCachePath)
+	}
+
+	// The agent's cache snapshot is left alone
+	dnsCfg := cfg.DNS
+	dnsCfg.CacheSnapshot.Enabled = false
+	dnsCfg.Prefetch = false
+	handler := dns.NewHandler(blocker, &dnsCfg, cfg.Blocking.SinkholeIP, &cfg.CaptivePortal)
+	defer handler.Stop()
+	handler.SetBlockResponse(&cfg.Blocking)
+	handler.SetBlockPagePort(cfg.Agent.HTTPSPort)
+	handler.SetCNAMEUncloaking(cfg.Blocking.CNAMEUncloaking)
+
+	return resolveResult(handler.Resolve(domain, qtype)), nil
+}
+
+func printResolveResult(r *api.ResolveResult) {
+	icon, verdict := "✅", "allowed"
+	switch r.Action {
+	case dns.QueryActionBlocked:
+		icon, verdict = "🚫", "blocked"
+	case dns.QueryActionMonitored:
+		icon, verdict = "👀", "allowed, would be blocked outside monitor mode"
+	case dns.QueryActionFailed:
+		icon, verdict = "❌", "failed, every upstream failed"
+	case dns.QueryActionSafeSearch:
+		verdict = "rewritten to SafeSearch"
+	case dns.QueryActionHosts:
+		verdict = "answered from the hosts file"
+	case dns.QueryActionLocal:
+		verdict = "answered by DNShield"
+	case "":
+		icon, verdict = "❌", "refused"
+	}
+	fmt.Printf("%s %s %s: %s\n", icon, r.Domain, r.Type, verdict)
+	if r.Rule != "" {
+		fmt.Printf("   Rule:     %s\n", r.Rule)
+	}
+
+	cache := "miss"
+	if r.Action == dns.QueryActionCached || (r.Action == dns.QueryActionMonitored && r.Upstream == "") {
+		cache = "hit"
+	}
+	if r.Action == dns.QueryActionAllowed || r.Action == dns.QueryActionCached || r.Action == dns.QueryActionMonitored {
+		fmt.Printf("   Cache:    %s\n", cache)
+	}
+	if r.Upstream != "" {
+		fmt.Printf("   Upstream: %s (%.1f ms)\n", r.Upstream, r.UpstreamRTTMs)
+	}
+	fmt.Printf("   Status:   %s\n", r.Rcode)
+	fmt.Printf("   Time:     %.1f ms\n", r.DurationMs)
+
+	fmt.Println("\n;; ANSWER SECTION:")
+	for _, rr := range r.Answer {
+		fmt.Println(rr)
+	}
+	if len(r.Authority) > 0 {
+		fmt.Println("\n;; AUTHORITY SECTION:")
+		for _, rr := range r.Authority {
+			fmt.Println(rr)
+		}
+	}
+}
@@ -0,0 +1,297 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"dnshield/internal/api"
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"dnshield/internal/logging"
+
+	"github.com/sirupsen/logrus"
+)
+
+// configPollInterval is how often the config file is checked for changes
+const configPollInterval = 5 * time.Second
+
+// reloadable applies the settings under config paths, such as
+// dns.upstreams or captivePortal, to the running agent
+type reloadable struct {
+	paths []string
+	apply func(cfg *config.Config) error
+}
+
+// configReloader re-reads the config file when it changes, on SIGHUP and
+// over the API, and applies the settings that can change without a restart
+type configReloader struct {
+	path string
+	// prepare applies what overrides the file at startup, such as --set
+	// and ports from the command line and the managed policy
+	prepare     func(cfg *config.Config) error
+	reloadables []reloadable
+
+	mu      sync.Mutex
+	startup *config.Config // As the agent started, for what needs a restart
+	current *config.Config
+	modTime time.Time
+}
+
+func newConfigReloader(path string, cfg *config.Config, prepare func(cfg *config.Config) error) *configReloader {
+	r := &configReloader{
+		path:    path,
+		prepare: prepare,
+		startup: cfg,
+		current: cfg,
+	}
+	if info, err := os.Stat(path); err == nil {
+		r.modTime = info.ModTime()
+	}
+	return r
+}
+
+// handle registers apply for changes to the settings under paths. It is
+// called once however many of them changed.
+func (r *configReloader) handle(apply func(cfg *config.Config) error, paths ...string) {
+	r.reloadables = append(r.reloadables, reloadable{paths: paths, apply: apply})
+}
+
+// config returns the config currently in effect
+func (r *configReloader) config() *config.Config {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.current
+}
+
+// reload re-reads the config file and applies what changed. An invalid file
+// changes nothing.
+func (r *configReloader) reload() (*api.ConfigReloadResult, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if info, err := os.Stat(r.path); err == nil {
+		r.modTime = info.ModTime()
+	}
+	cfg, err := config.LoadConfig(r.path)
+	if err != nil {
+		return nil, err
+	}
+	if err := r.prepare(cfg); err != nil {
+		return nil, err
+	}
+	if err := config.ValidateConfig(cfg); err != nil {
+		return nil, err
+	}
+
+	result := &api.ConfigReloadResult{Applied: []string{}}
+	changed := config.Changes(r.current, cfg)
+	for _, rl := range r.reloadables {
+		paths := matchingPaths(changed, rl.paths)
+		if len(paths) == 0 {
+			continue
+		}
+		if err := rl.apply(cfg); err != nil {
+			logrus.WithError(err).WithField("settings", strings.Join(paths, ", ")).Error("Failed to apply reloaded settings, keeping the current ones")
+			result.Failed = append(result.Failed, paths...)
+			continue
+		}
+		result.Applied = append(result.Applied, paths...)
+	}
+	for _, path := range config.Changes(r.startup, cfg) {
+		if !r.reloadablePath(path) {
+			result.RestartRequired = append(result.RestartRequired, path)
+		}
+	}
+	r.current = cfg
+
+	if len(result.Applied) > 0 {
+		logrus.WithField("settings", strings.Join(result.Applied, ", ")).Info("Configuration reloaded")
+	}
+	if len(result.RestartRequired) > 0 {
+		logrus.WithField("settings", strings.Join(result.RestartRequired, ", ")).Warn("Changed settings take effect after a restart")
+	}
+	return result, nil
+}
+
+// reloadablePath reports whether a registered reloadable covers path
+func (r *configReloader) reloadablePath(path string) bool {
+	for _, rl := range r.reloadables {
+		if len(matchingPaths([]string{path}, rl.paths)) > 0 {
+			return true
+		}
+	}
+	return false
+}
+
+// matchingPaths returns the paths that are one of prefixes or are nested
+// under one
+func matchingPaths(paths, prefixes []string) []string {
+	var matched []string
+	for _, path := range paths {
+		for _, prefix := range prefixes {
+			if path == prefix || strings.HasPrefix(path, prefix+".") {
+				matched = append(matched, path)
+				break
+			}
+		}
+	}
+	return matched
+}
+
+// watch reloads the config when the file changes or the agent gets SIGHUP,
+// until ctx is done
+func (r *configReloader) watch(ctx context.Context) {
+	hup := make(chan os.Signal, 1)
+	signal.Notify(hup, syscall.SIGHUP)
+	defer signal.Stop(hup)
+
+	ticker := time.NewTicker(configPollInterval)
+	defer ticker.Stop()
+
+	for {
+		var source string
+		select {
+		case <-ctx.Done():
+			return
+		case <-hup:
+			source = "SIGHUP"
+		case <-ticker.C:
+			if !r.fileChanged() {
+				continue
+			}
+			source = "file change"
+		}
+
+		result, err := r.reload()
+		if err != nil {
+			logrus.WithError(err).WithField("trigger", source).Error("Ignoring invalid configuration change")
+			continue
+		}
+		if len(result.Applied) > 0 || len(result.Failed) > 0 || len(result.RestartRequired) > 0 {
+			audit.Log(audit.EventConfigChange, "info", "Configuration reloaded", map[string]interface{}{
+				"trigger":          source,
+				"applied":          strings.Join(result.Applied, ","),
+				"failed":           strings.Join(result.Failed, ","),
+				"restart_required": strings.Join(result.RestartRequired, ","),
+			})
+		}
+	}
+}
+
+// fileChanged reports whether the config file was modified since it was
+// last read
+func (r *configReloader) fileChanged() bool {
+	info, err := os.Stat(r.path)
+	if err != nil {
+		return false
+	}
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return !info.ModTime().Equal(r.modTime)
+}
+
+// logSinks holds the syslog and Kafka outputs, which a config reload
+// replaces while events are being logged to them
+type logSinks struct {
+	mu     sync.RWMutex
+	syslog *logging.SyslogWriter
+	kafka  *logging.KafkaSink
+}
+
+// logAudit forwards event to the current sinks. It is registered as an
+// audit forwarder once, whichever sinks come and go.
+func (s *logSinks) logAudit(event audit.Event) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.syslog != nil {
+		s.syslog.Log(event)
+	}
+	if s.kafka != nil {
+		s.kafka.LogAudit(event)
+	}
+}
+
+// recordQuery sends event to the Kafka query topic, if there is a sink
+func (s *logSinks) recordQuery(event dns.QueryEvent) {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.kafka != nil {
+		s.kafka.RecordQuery(event)
+	}
+}
+
+// setSyslog replaces the syslog output with one for cfg, or removes it if
+// cfg is disabled. On error the current output is kept.
+func (s *logSinks) setSyslog(cfg *config.SyslogConfig) error {
+	var writer *logging.SyslogWriter
+	if cfg.Enabled {
+		var err error
+		if writer, err = logging.NewSyslogWriter(cfg); err != nil {
+			return fmt.Errorf("failed to start syslog output: %v", err)
+		}
+		target := cfg.Target
+		if target == "" {
+			target = "local"
+		}
+		logrus.WithFields(logrus.Fields{
+			"target": target,
+			"format": cfg.Format,
+		}).Info("Syslog output enabled")
+	}
+
+	s.mu.Lock()
+	previous := s.syslog
+	s.syslog = writer
+	s.mu.Unlock()
+	if previous != nil {
+		previous.Stop()
+	}
+	return nil
+}
+
+// setKafka replaces the Kafka sink with one for cfg, or removes it if cfg
+// is disabled. On error the current sink is kept.
+func (s *logSinks) setKafka(cfg *config.KafkaConfig) error {
+	var sink *logging.KafkaSink
+	if cfg.Enabled {
+		var err error
+		if sink, err = logging.NewKafkaSink(cfg); err != nil {
+			return fmt.Errorf("failed to start Kafka sink: %v", err)
+		}
+		logrus.WithFields(logrus.Fields{
+			"brokers":     len(cfg.Brokers),
+			"audit_topic": cfg.AuditTopic,
+			"query_topic": cfg.QueryTopic,
+		}).Info("Kafka sink enabled")
+	}
+
+	s.mu.Lock()
+	previous := s.kafka
+	s.kafka = sink
+	s.mu.Unlock()
+	if previous != nil {
+		previous.Stop()
+	}
+	return nil
+}
+
+// stop flushes and closes the sinks
+func (s *logSinks) stop() {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.syslog != nil {
+		s.syslog.Stop()
+		s.syslog = nil
+	}
+	if s.kafka != nil {
+		s.kafka.Stop()
+		s.kafka = nil
+	}
+}
@@ -0,0 +1,330 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dnshield/internal/api"
+	"dnshield/internal/config"
+	"dnshield/internal/rules"
+
+	"github.com/spf13/cobra"
+)
+
+// NewRulesCmd creates the rules command
+func NewRulesCmd() *cobra.Command {
+	rulesCmd := &cobra.Command{
+		Use:   "rules",
+		Short: "Sign, lint and convert rules files and roll back applied rules",
+		Long: `Sign base, group and user rules files before uploading them to the
+rules bucket, and roll the running agent back to rules it applied before.
+
+Agents with s3.signingKeys set only apply a file from the rules store with
+a detached signature from one of those keys, stored next to it with a .sig
+suffix. This covers rules files, the device mapping, the user groups file
+and the category registry. Sign them from a copy of the bucket, so each
+file's path is its key in the store:
+
+  dnshield rules sign base.yaml groups/engineering.yaml
+  aws s3 cp base.yaml.sig s3://corp-dnshield/base.yaml.sig
+
+A signature covers the file's key and a serial, the current time unless
+--serial is given, so it can't be copied to another key or replayed once a
+newer version is applied. Upload the signature before the file it signs. A
+file without a valid signature is rejected and the agent keeps the version
+it applied before.
+
+The agent keeps the last rules.historySize rulesets it applied. Rolling back
+keeps the rules in the bucket off the device until they change. The API key
+is taken from --api-key, then DNSHIELD_API_KEY, then the local key file.`,
+	}
+	var apiKey string
+
+	var keyOut string
+	keygenCmd := &cobra.Command{
+		Use:          "keygen",
+		Short:        "Generate a rules signing key pair",
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			pub, priv, err := ed25519.GenerateKey(rand.Reader)
+			if err != nil {
+				return fmt.Errorf("failed to generate key: %v", err)
+			}
+			encoded := base64.StdEncoding.EncodeToString(priv)
+			if err := os.WriteFile(keyOut, []byte(encoded+"\n"), 0600); err != nil {
+				return fmt.Errorf("failed to write private key: %v", err)
+			}
+			fmt.Printf("🔑 Private key written to %s (keep it off managed devices)\n", keyOut)
+			fmt.Printf("Public key for s3.signingKeys:\n%s\n", base64.StdEncoding.EncodeToString(pub))
+			return nil
+		},
+	}
+	keygenCmd.Flags().StringVarP(&keyOut, "out", "o", "rules-signing.key", "Private key file")
+
+	var keyFile, root string
+	var serial uint64
+	signCmd := &cobra.Command{
+		Use:          "sign <rules.yaml>...",
+		Short:        "Write a detached signature next to each rules file",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := readSigningKey(keyFile)
+			if err != nil {
+				return err
+			}
+
+			if serial == 0 {
+				serial = uint64(time.Now().Unix())
+			}
+			for _, file := range args {
+				content, err := os.ReadFile(file)
+				if err != nil {
+					return err
+				}
+				objectKey, err := storeKey(root, file)
+				if err != nil {
+					return err
+				}
+				signature := rules.SignRules(objectKey, serial, content, key)
+				if err := os.WriteFile(file+rules.SignatureSuffix, signature, 0644); err != nil {
+					return fmt.Errorf("failed to write signature: %v", err)
+				}
+				fmt.Printf("✍️  %s%s\n", file, rules.SignatureSuffix)
+			}
+			return nil
+		},
+	}
+	signCmd.Flags().StringVarP(&keyFile, "key", "k", "rules-signing.key", "Private key file")
+	signCmd.Flags().StringVar(&root, "root", ".", "Directory mirroring the root of the rules store")
+	signCmd.Flags().Uint64Var(&serial, "serial", 0, "Serial to sign with (default the current Unix time)")
+
+	var publicKeys []string
+	verifyCmd := &cobra.Command{
+		Use:          "verify <rules.yaml>...",
+		Short:        "Check the detached signature of each rules file",
+		Args:         cobra.MinimumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			keys, err := rules.ParseSigningKeys(publicKeys)
+			if err != nil {
+				return err
+			}
+
+			failed := 0
+			for _, file := range args {
+				var signed uint64
+				content, err := os.ReadFile(file)
+				if err == nil {
+					var signature []byte
+					if signature, err = os.ReadFile(file + rules.SignatureSuffix); err == nil {
+						var objectKey string
+						if objectKey, err = storeKey(root, file); err == nil {
+							signed, err = rules.VerifyRules(objectKey, content, signature, keys)
+						}
+					}
+				}
+				if err != nil {
+					fmt.Printf("❌ %s: %v\n", file, err)
+					failed++
+					continue
+				}
+				fmt.Printf("✅ %s (serial %d)\n", file, signed)
+			}
+			if failed > 0 {
+				return fmt.Errorf("%d of %d files failed verification", failed, len(args))
+			}
+			return nil
+		},
+	}
+	verifyCmd.Flags().StringSliceVar(&publicKeys, "public-key", nil, "Base64 public key (repeatable)")
+	verifyCmd.Flags().StringVar(&root, "root", ".", "Directory mirroring the root of the rules store")
+	verifyCmd.MarkFlagRequired("public-key")
+
+	historyCmd := &cobra.Command{
+		Use:          "history",
+		Short:        "List the rulesets the agent applied, newest first",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := resolveAPIKey(apiKey)
+			if err != nil {
+				return err
+			}
+			var versions []api.RuleVersion
+			if err := api.NewClient(key).Do(http.MethodGet, api.RulesHistoryPath, nil, &versions); err != nil {
+				return err
+			}
+			if len(versions) == 0 {
+				fmt.Println("No rules recorded yet")
+				return nil
+			}
+			for _, v := range versions {
+				marker := " "
+				if v.Current {
+					marker = "*"
+				}
+				fmt.Printf("%s %s  %s\n", marker, v.Version, v.AppliedAt.Local().Format("2006-01-02 15:04:05"))
+			}
+			return nil
+		},
+	}
+
+	rollbackCmd := &cobra.Command{
+		Use:          "rollback [version]",
+		Short:        "Apply an earlier ruleset (default: the one before the current)",
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := resolveAPIKey(apiKey)
+			if err != nil {
+				return err
+			}
+			var req api.RuleRollbackRequest
+			if len(args) == 1 {
+				req.Version = args[0]
+			}
+			client := api.NewClient(key)
+			client.SetTimeout(2 * time.Minute)
+			var result api.RuleRollbackResult
+			if err := client.Do(http.MethodPost, api.RulesRollbackPath, req, &result); err != nil {
+				return err
+			}
+			fmt.Printf("⏪ Rolled back to %s: %d blocked, %d security and %d allowed domains\n",
+				result.Version, result.BlockedDomains, result.SecurityDomains, result.AllowedDomains)
+			return nil
+		},
+	}
+
+	var deltaDir string
+	diffCmd := &cobra.Command{
+		Use:   "diff <old-list> <new-list>",
+		Short: "Write the delta between two versions of an external blocklist",
+		Long: `Write the domains added and removed between two versions of an external
+blocklist. Publish it under <list URL>.delta/ with the name printed, next to
+the new list, and agents holding the old version download only the delta.`,
+		Args:         cobra.ExactArgs(2),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			oldList, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			newList, err := os.ReadFile(args[1])
+			if err != nil {
+				return err
+			}
+			parser := rules.NewParser()
+			parser.SetLimits(1<<30, 0)
+			delta, err := parser.DiffBlocklists(oldList, newList)
+			if err != nil {
+				return err
+			}
+
+			dir := deltaDir
+			if dir == "" {
+				dir = args[1] + rules.DeltaPath
+			}
+			if err := os.MkdirAll(dir, 0755); err != nil {
+				return err
+			}
+			out := filepath.Join(dir, delta.Base)
+			if err := os.WriteFile(out, delta.Marshal(), 0644); err != nil {
+				return fmt.Errorf("failed to write delta: %v", err)
+			}
+			fmt.Printf("📝 %s: %d added, %d removed\n", out, len(delta.Added), len(delta.Removed))
+			return nil
+		},
+	}
+	diffCmd.Flags().StringVarP(&deltaDir, "out", "o", "", "Directory to write the delta to (default <new-list>.delta/)")
+
+	var configFile string
+	var offline bool
+	lintCmd := &cobra.Command{
+		Use:   "lint [dir]",
+		Short: "Check a rules layout before publishing it",
+		Long: `Check the base, group and user rules files, device mapping and user groups
+in dir (default the current directory), laid out as in the rules bucket.
+Files must parse with only known keys; devices and users must map to one
+user and group each; groups must have a rules file. Duplicate rules, block
+rules an allow rule in the same file covers, and invalid regexes are
+reported, and every external source is downloaded unless --offline is set.
+
+Exits non-zero when errors are found, for use in the rules repository's CI.`,
+		Args:         cobra.MaximumNArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			dir := "."
+			if len(args) == 1 {
+				dir = args[0]
+			}
+			cfg, err := config.LoadConfig(configFile)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %v", err)
+			}
+			parser := rules.NewParser()
+			parser.SetLimits(cfg.Rules.MaxFileSize, cfg.Rules.MaxDomains)
+
+			linter := rules.NewLinter(dir, cfg.S3.Paths, parser)
+			linter.CheckSources = !offline
+			errors := 0
+			for _, issue := range linter.Lint() {
+				if issue.Severity == rules.LintError {
+					errors++
+					fmt.Printf("❌ %s\n", issue)
+				} else {
+					fmt.Printf("⚠️  %s\n", issue)
+				}
+			}
+			if errors > 0 {
+				return fmt.Errorf("%d errors found", errors)
+			}
+			fmt.Println("✅ Rules look good")
+			return nil
+		},
+	}
+	lintCmd.Flags().StringVarP(&configFile, "config", "c", "", "config file with s3.paths and rules limits")
+	lintCmd.Flags().BoolVar(&offline, "offline", false, "don't download external sources")
+
+	rulesCmd.AddCommand(keygenCmd, signCmd, verifyCmd, historyCmd, rollbackCmd, diffCmd, lintCmd, newRulesImportCmd(), newRulesExportCmd())
+	rulesCmd.PersistentFlags().StringVar(&apiKey, "api-key", "", "API key to authenticate with")
+	return rulesCmd
+}
+
+// readSigningKey reads a private key written by rules keygen
+func readSigningKey(keyFile string) (ed25519.PrivateKey, error) {
+	keyData, err := os.ReadFile(keyFile)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read private key: %v", err)
+	}
+	key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyData)))
+	if err != nil || len(key) != ed25519.PrivateKeySize {
+		return nil, fmt.Errorf("invalid private key in %s", keyFile)
+	}
+	return ed25519.PrivateKey(key), nil
+}
+
+// storeKey returns the key in the rules store of file, a path under root,
+// a directory mirroring the store
+func storeKey(root, file string) (string, error) {
+	absRoot, err := filepath.Abs(root)
+	if err != nil {
+		return "", err
+	}
+	absFile, err := filepath.Abs(file)
+	if err != nil {
+		return "", err
+	}
+	rel, err := filepath.Rel(absRoot, absFile)
+	if err != nil || rel == ".." || strings.HasPrefix(rel, ".."+string(filepath.Separator)) {
+		return "", fmt.Errorf("%s is not under --root %s", file, root)
+	}
+	return filepath.ToSlash(rel), nil
+}
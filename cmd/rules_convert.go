@@ -0,0 +1,177 @@
+package cmd
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"dnshield/internal/config"
+	"dnshield/internal/rules"
+
+	"github.com/spf13/cobra"
+	"gopkg.in/yaml.v3"
+)
+
+// maxSkippedShown is how many lines import and export list before
+// summarizing the rest
+const maxSkippedShown = 10
+
+// newRulesImportCmd creates the rules import command
+func newRulesImportCmd() *cobra.Command {
+	var (
+		format      string
+		out         string
+		allow       bool
+		description string
+	)
+
+	cmd := &cobra.Command{
+		Use:   "import <file|url>",
+		Short: "Convert a Pi-hole, AdGuard or hosts list to a rules file",
+		Long: `Convert a blocklist from Pi-hole, AdGuard Home or a hosts file into a
+DNShield rules file, to move existing lists over:
+
+  dnshield rules import --format adguard filter.txt -o groups/home.yaml
+
+Adblock rules become block_domains (||example.com^ keeps covering
+subdomains, ||*.example.com^ becomes *.example.com), @@ exceptions become
+allow_domains, and /regex/ rules and other wildcards become block_regex.
+Pi-hole regex list entries are imported as regexes too. With --allow, every
+rule is imported as an allow rule, for a Pi-hole allowlist.
+
+Lines DNShield can't express, such as cosmetic rules, modifiers other than
+$important or Pi-hole regex options, are reported and left out.`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			parser := rules.NewParser()
+			var list *rules.ImportedList
+			var err error
+			if strings.HasPrefix(args[0], "http://") || strings.HasPrefix(args[0], "https://") {
+				list, err = parser.ImportListURL(args[0], format, allow)
+			} else {
+				var f *os.File
+				if f, err = os.Open(args[0]); err != nil {
+					return err
+				}
+				defer f.Close()
+				list, err = parser.ImportList(f, format, allow)
+			}
+			if err != nil {
+				return fmt.Errorf("failed to import %s: %v", args[0], err)
+			}
+
+			if description == "" {
+				description = "Imported from " + args[0]
+			}
+			data, err := yaml.Marshal(list.Rules(description))
+			if err != nil {
+				return err
+			}
+			if err := writeOutput(out, data); err != nil {
+				return err
+			}
+
+			fmt.Fprintf(os.Stderr, "📥 %d block, %d allow and %d regex rules imported\n",
+				len(list.BlockDomains), len(list.AllowDomains), len(list.BlockRegex))
+			printSkipped("lines skipped", list.Skipped)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&format, "format", "f", "", "List format: "+strings.Join(rules.ListFormats, ", "))
+	cmd.Flags().StringVarP(&out, "out", "o", "", "Rules file to write (default stdout)")
+	cmd.Flags().BoolVar(&allow, "allow", false, "Import every rule as an allow rule")
+	cmd.Flags().StringVar(&description, "description", "", "Description of the rules file")
+	cmd.MarkFlagRequired("format")
+	return cmd
+}
+
+// newRulesExportCmd creates the rules export command
+func newRulesExportCmd() *cobra.Command {
+	var (
+		format string
+		out    string
+		allow  bool
+	)
+
+	cmd := &cobra.Command{
+		Use:   "export <rules.yaml>",
+		Short: "Convert a rules file to a Pi-hole, AdGuard or hosts list",
+		Long: `Write the rules of a DNShield rules file as a Pi-hole adlist, an AdGuard Home
+filter or a hosts file, for migrating to another blocker:
+
+  dnshield rules export --format pihole base.yaml -o dnshield.txt
+
+Block and security block rules are exported. AdGuard filters also carry the
+allow rules as @@ exceptions and the regexes; for Pi-hole, export the allow
+rules with --allow as a list of their own. Hosts entries only block the
+names listed, not their subdomains.
+
+External sources, categories, schedules and rules the format has no syntax
+for are reported and left out.`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			data, err := os.ReadFile(args[0])
+			if err != nil {
+				return err
+			}
+			var r config.Rules
+			if err := yaml.Unmarshal(data, &r); err != nil {
+				return fmt.Errorf("failed to parse %s: %v", args[0], err)
+			}
+			r.Normalize()
+
+			var buf bytes.Buffer
+			skipped, err := rules.ExportList(&buf, &r, format, allow)
+			if err != nil {
+				return err
+			}
+			if err := writeOutput(out, buf.Bytes()); err != nil {
+				return err
+			}
+			printSkipped("not exported", skipped)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVarP(&format, "format", "f", "", "List format: "+strings.Join(rules.ListFormats, ", "))
+	cmd.Flags().StringVarP(&out, "out", "o", "", "List file to write (default stdout)")
+	cmd.Flags().BoolVar(&allow, "allow", false, "Export only the allow rules")
+	cmd.MarkFlagRequired("format")
+	return cmd
+}
+
+// writeOutput writes data to the file path, or to stdout if path is empty
+func writeOutput(path string, data []byte) error {
+	var w io.Writer = os.Stdout
+	if path != "" {
+		f, err := os.Create(path)
+		if err != nil {
+			return err
+		}
+		defer f.Close()
+		w = f
+	}
+	_, err := w.Write(data)
+	return err
+}
+
+// printSkipped reports what a conversion left out on stderr, so it stays
+// out of output written to stdout
+func printSkipped(what string, skipped []string) {
+	if len(skipped) == 0 {
+		return
+	}
+	fmt.Fprintf(os.Stderr, "⚠️  %d %s:\n", len(skipped), what)
+	for i, entry := range skipped {
+		if i == maxSkippedShown {
+			fmt.Fprintf(os.Stderr, "   ... and %d more\n", len(skipped)-i)
+			break
+		}
+		fmt.Fprintf(os.Stderr, "   %s\n", entry)
+	}
+}
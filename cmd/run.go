@@ -6,13 +6,18 @@ package cmd
 import (
 	"context"
 	"crypto/sha256"
+	"crypto/x509"
+	"errors"
 	"fmt"
 	"io"
 	"math/rand"
+	"net"
 	"os"
 	"os/exec"
 	"os/signal"
 	"runtime"
+	"sort"
+	"strings"
 	"sync"
 	"syscall"
 	"time"
@@ -22,10 +27,20 @@ import (
 	"dnshield/internal/ca"
 	"dnshield/internal/config"
 	"dnshield/internal/dns"
+	"dnshield/internal/grpcapi"
+	"dnshield/internal/incident"
 	"dnshield/internal/logging"
+	"dnshield/internal/maintenance"
+	"dnshield/internal/mirror"
+	"dnshield/internal/policy"
+	"dnshield/internal/privacy"
+	"dnshield/internal/querylog"
 	"dnshield/internal/proxy"
 	"dnshield/internal/rules"
 	"dnshield/internal/security"
+	"dnshield/internal/telemetry"
+	"dnshield/internal/truststore"
+	"dnshield/internal/unblock"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -35,6 +50,11 @@ import (
 type RunOptions struct {
 	ConfigFile    string
 	AutoConfigure bool
+	Headless      bool
+	DNSPort       int
+	HTTPPort      int
+	HTTPSPort     int
+	Set           []string // key=value config overrides
 }
 
 // NewRunCmd creates the run command
@@ -44,21 +64,40 @@ func NewRunCmd() *cobra.Command {
 	cmd := &cobra.Command{
 		Use:   "run",
 		Short: "Run the DNShield agent service",
-		Long:  `Start the DNS server and HTTPS proxy to filter network traffic.`,
+		Long: `Start the DNS server and HTTPS proxy to filter network traffic.
+
+With --headless the agent leaves the host alone: it doesn't touch system
+DNS, the keychain, the trust store or pf, and keeps its CA in files. This
+suits running DNShield as a network resolver in a container for labs and
+CI, with the ports set by --dns-port, --http-port and --https-port.
+
+Any setting can be overridden without editing the config file, with
+--set dns.cacheSize=5000 or the DNSHIELD_DNS_CACHE_SIZE environment
+variable; --set wins over the environment, which wins over the file.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runAgent(opts)
 		},
 	}
 
 	cmd.Flags().StringVarP(&opts.ConfigFile, "config", "c", "", "config file path")
-	cmd.Flags().BoolVar(&opts.AutoConfigure, "auto-configure-dns", false, "automatically configure DNS on all interfaces to 127.0.0.1")
+	cmd.Flags().BoolVar(&opts.AutoConfigure, "auto-configure-dns", false, "automatically configure DNS on all interfaces to 127.0.0.1 and ::1")
+	cmd.Flags().BoolVar(&opts.Headless, "headless", false, "only run the DNS and proxy servers, without changing system DNS, keychain or trust store")
+	cmd.Flags().StringArrayVar(&opts.Set, "set", nil, "override a config setting, as key=value (repeatable)")
+	cmd.Flags().IntVar(&opts.DNSPort, "dns-port", 0, "DNS server port (overrides agent.dnsPort)")
+	cmd.Flags().IntVar(&opts.HTTPPort, "http-port", 0, "block page HTTP port (overrides agent.httpPort)")
+	cmd.Flags().IntVar(&opts.HTTPSPort, "https-port", 0, "block page HTTPS port (overrides agent.httpsPort)")
 
 	return cmd
 }
 
 func runAgent(opts *RunOptions) error {
-	// Check if running as root
-	if os.Geteuid() != 0 {
+	if opts.Headless && opts.AutoConfigure {
+		return fmt.Errorf("--headless can't be combined with --auto-configure-dns")
+	}
+
+	// Check if running as root. Headless agents may run unprivileged on
+	// high ports.
+	if os.Geteuid() != 0 && !opts.Headless {
 		return fmt.Errorf("dnshield must be run as root to bind to ports 53, 80, and 443")
 	}
 
@@ -80,6 +119,33 @@ func runAgent(opts *RunOptions) error {
 		return fmt.Errorf("failed to load config: %v", err)
 	}
 
+	// Settings and ports from the command line win over the config file
+	applyOverrides := func(cfg *config.Config) error {
+		for _, set := range opts.Set {
+			key, value, ok := strings.Cut(set, "=")
+			if !ok {
+				return fmt.Errorf("invalid --set %q: must be key=value", set)
+			}
+			if err := cfg.Set(strings.TrimSpace(key), value); err != nil {
+				return fmt.Errorf("invalid --set: %v", err)
+			}
+		}
+		if opts.DNSPort != 0 {
+			cfg.Agent.DNSPort = opts.DNSPort
+		}
+		if opts.HTTPPort != 0 {
+			cfg.Agent.HTTPPort = opts.HTTPPort
+		}
+		if opts.HTTPSPort != 0 {
+			cfg.Agent.HTTPSPort = opts.HTTPSPort
+		}
+		cfg.ApplyDerived()
+		return nil
+	}
+	if err := applyOverrides(cfg); err != nil {
+		return err
+	}
+
 	// Check for security warnings
 	securityWarnings := config.ValidateCredentialSecurity(cfg)
 	for _, warning := range securityWarnings {
@@ -139,15 +205,63 @@ func runAgent(opts *RunOptions) error {
 	// Log binary integrity information
 	logBinaryIntegrity()
 
-	// Load CA
+	// A managed policy overrides local settings before anything uses them
+	var policyManager *policy.Manager
+	if policy.Enrolled(&cfg.ManagedPolicy) {
+		policyManager = newPolicyManager(cfg)
+		policyManager.Load()
+		for _, conflict := range policyManager.Policy().Apply(cfg) {
+			logrus.Warnf("Managed policy: %s", conflict)
+			audit.Log(audit.EventPolicyDenied, "warning", "Local setting overridden by managed policy", map[string]interface{}{
+				"setting": conflict,
+			})
+		}
+		defer policyManager.Stop()
+	}
+
+	// Pseudonymize or withhold query data before anything records it
+	privacyFilter, err := privacy.New(cfg.Logging.Privacy, privacy.KeyPath())
+	if err != nil {
+		return fmt.Errorf("failed to set up logging privacy: %v", err)
+	}
+	if privacyFilter != nil {
+		audit.SetFilter(privacyFilter.Event)
+		logrus.AddHook(privacyFilter.Hook())
+		logrus.WithField("mode", privacyFilter.Mode()).Info("Logging privacy enabled")
+	}
+
+	// Load CA. Headless agents have no keychain to keep it in.
 	logrus.Info("Loading CA certificate...")
-	caManager, err := ca.LoadOrCreateManager()
+	loadCA := ca.LoadOrCreateManager
+	if opts.Headless {
+		loadCA = ca.LoadOrCreateFileManager
+	}
+	caManager, err := loadCA()
 	if err != nil {
 		return fmt.Errorf("failed to load CA: %v", err)
 	}
 
+	// Watch the trust store for roots DNShield didn't install
+	if cfg.TrustStore.Enabled && !opts.Headless {
+		monitor := truststore.NewMonitor(&cfg.TrustStore, func() []*x509.Certificate {
+			known := append(ca.ProfileCertificates(), caManager.Certificate())
+			// A rotation adds a new CA and keeps the previous one for a while
+			if cert, err := ca.LoadCACertificate(ca.GetCAPath()); err == nil {
+				known = append(known, cert)
+			}
+			if cert, err := ca.PreviousCertificate(ca.GetCAPath()); err == nil && cert != nil {
+				known = append(known, cert)
+			}
+			return known
+		})
+		monitor.Start()
+		defer monitor.Stop()
+	}
+
 	// Create components
 	blocker := dns.NewBlocker()
+	blocker.SetMaxDomains(cfg.Rules.MaxDomains)
+	blocker.SetDefaultEnforcement(cfg.Agent.Enforcement)
 
 	// Load initial test domains
 	if len(cfg.TestDomains) > 0 {
@@ -160,11 +274,16 @@ func runAgent(opts *RunOptions) error {
 	// Create network-aware DNS manager for handling pause/resume
 	dnsManager := dns.NewNetworkManager()
 
-	// Start network monitoring
-	if err := dnsManager.Start(); err != nil {
-		logrus.WithError(err).Warn("Failed to start network monitoring")
+	// Start network monitoring. Headless agents never change system DNS,
+	// so they have no networks to track.
+	if opts.Headless {
+		logrus.Info("Running headless: system DNS, keychain and trust store are left untouched")
+	} else {
+		if err := dnsManager.Start(); err != nil {
+			logrus.WithError(err).Warn("Failed to start network monitoring")
+		}
+		defer dnsManager.Stop()
 	}
-	defer dnsManager.Stop()
 
 	// Enable DNS filtering if auto-configure is set
 	if opts.AutoConfigure {
@@ -179,21 +298,94 @@ func runAgent(opts *RunOptions) error {
 
 	// Create API server for menu bar app
 	apiServer := api.NewServer(dnsManager)
+	rateLimitPolicy := apiRateLimitPolicy(cfg.API.RateLimit)
+	if _, ok := rateLimitPolicy.Endpoints[api.FlowVerdictPath]; cfg.TransparentProxy.Enabled && !ok {
+		rateLimitPolicy.Endpoints[api.FlowVerdictPath] = api.DefaultFlowVerdictRateLimit
+	}
+	apiServer.SetRateLimitPolicy(rateLimitPolicy)
+	apiServer.SetProfilingEnabled(cfg.API.Profiling)
+	apiServer.SetCurrentUser(blocker.GetMetadata)
+	audit.AddForwarder(apiServer.PublishAudit)
 
 	// Wait group for tracking goroutines
 	var wg sync.WaitGroup
 
-	// Start API server
-	wg.Add(1)
-	go func() {
-		defer wg.Done()
-		if err := apiServer.Start(5353); err != nil {
-			logrus.WithError(err).Error("API server failed")
+	// Start API server: on the socket for the menu bar app and CLI, and on
+	// TCP only if enabled or the transparent proxy extension needs it
+	if cfg.API.Socket.Enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			err := apiServer.StartSocket(api.SocketConfig{
+				Path:             cfg.API.Socket.Path,
+				AllowedUIDs:      cfg.API.Socket.AllowedUIDs,
+				AllowConsoleUser: cfg.API.Socket.AllowConsoleUser,
+				Role:             api.Role(cfg.API.Socket.Role),
+			})
+			if err != nil {
+				logrus.WithError(err).Error("API socket failed")
+			}
+		}()
+	}
+	apiTCP := cfg.API.TCP.Enabled || cfg.TransparentProxy.Enabled
+	if apiTCP {
+		if !cfg.API.TCP.Enabled {
+			logrus.Info("Serving the API on TCP for the transparent proxy extension")
 		}
-	}()
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := apiServer.Start(api.DefaultPort); err != nil {
+				logrus.WithError(err).Error("API server failed")
+			}
+		}()
+	}
+
+	// Serve the API beyond loopback to holders of fleet client certificates
+	if cfg.API.MTLS.Enabled {
+		tlsConfig, err := apiTLSConfig(cfg.API.MTLS, caManager)
+		if err != nil {
+			return err
+		}
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			if err := apiServer.StartTLS(tlsConfig); err != nil {
+				logrus.WithError(err).Error("API mutual TLS listener failed")
+			}
+		}()
+	}
+
+	// Start the gRPC management API
+	var grpcServer *grpcapi.Server
+	if cfg.API.GRPC.Enabled {
+		// The same uids as on the API socket may connect
+		grpcServer = grpcapi.NewServer(apiServer, api.SocketConfig{
+			Path:             cfg.API.GRPC.Socket,
+			AllowedUIDs:      cfg.API.Socket.AllowedUIDs,
+			AllowConsoleUser: cfg.API.Socket.AllowConsoleUser,
+		})
+		if err := grpcServer.Listen(); err != nil {
+			logrus.WithError(err).Error("Failed to start gRPC API")
+			grpcServer = nil
+		} else {
+			wg.Add(1)
+			go func() {
+				defer wg.Done()
+				if err := grpcServer.Serve(); err != nil {
+					logrus.WithError(err).Error("gRPC API failed")
+				}
+			}()
+		}
+	}
 
 	// Create DNS handler and server with API integration and captive portal support
-	handler := dns.NewHandler(blocker, &cfg.DNS, "127.0.0.1", &cfg.CaptivePortal)
+	handler := dns.NewHandler(blocker, &cfg.DNS, cfg.Blocking.SinkholeIP, &cfg.CaptivePortal)
+	handler.SetBlockResponse(&cfg.Blocking)
+	handler.SetBlockPagePort(cfg.Agent.HTTPSPort)
+	handler.SetNetworkResolverSource(dnsManager.GetNetworkResolvers)
+	handler.SetCNAMEUncloaking(cfg.Blocking.CNAMEUncloaking)
+	apiServer.SetCaptivePortalDetector(handler.GetCaptivePortalDetector())
 	handler.SetStatsCallback(func(query bool, blocked bool, cached bool) {
 		if query {
 			apiServer.IncrementQueries()
@@ -207,17 +399,391 @@ func runAgent(opts *RunOptions) error {
 			apiServer.IncrementCacheMiss()
 		}
 	})
-	handler.SetBlockedCallback(func(domain, rule, clientIP string) {
-		apiServer.AddBlockedDomain(domain, rule, clientIP)
+	var queryMirror *mirror.Mirror
+	if cfg.Mirror.Enabled {
+		queryMirror, err = mirror.New(&cfg.Mirror)
+		if err != nil {
+			return fmt.Errorf("failed to start query mirror: %v", err)
+		}
+		defer queryMirror.Stop()
+		logrus.WithField("sampleRate", cfg.Mirror.SampleRate).Info("Query mirroring enabled")
+	}
+	var queryLog *querylog.Log
+	if cfg.QueryLog.Enabled {
+		queryLog, err = querylog.Open(&cfg.QueryLog)
+		if err != nil {
+			return fmt.Errorf("failed to open query log: %v", err)
+		}
+		defer queryLog.Close()
+		apiServer.SetQueryLog(queryLog)
+		logrus.WithFields(logrus.Fields{
+			"path":      cfg.QueryLog.Path,
+			"retention": cfg.QueryLog.Retention,
+		}).Info("Query log enabled")
+	}
+	var exporter *telemetry.Exporter
+	if cfg.Logging.OTLP.Enabled {
+		exporter, err = telemetry.New(&cfg.Logging.OTLP)
+		if err != nil {
+			return fmt.Errorf("failed to start OpenTelemetry export: %v", err)
+		}
+		defer exporter.Stop()
+		exporter.SetMetricsCallback(func() []telemetry.Metric {
+			stats := apiServer.GetStats()
+			return []telemetry.Metric{
+				{Name: "dnshield.cache.hits", Description: "DNS cache hits", Unit: "1", Value: float64(stats.CacheHits), Counter: true},
+				{Name: "dnshield.cache.misses", Description: "DNS cache misses", Unit: "1", Value: float64(stats.CacheMisses), Counter: true},
+				{Name: "dnshield.cache.hit_ratio", Description: "Share of queries answered from the cache", Unit: "1", Value: stats.CacheHitRate / 100},
+				{Name: "dnshield.rules.blocked_domains", Description: "Domains on the blocklist", Unit: "1", Value: float64(blocker.GetBlockedCount())},
+			}
+		})
+		logrus.WithFields(logrus.Fields{
+			"protocol": cfg.Logging.OTLP.Protocol,
+			"interval": cfg.Logging.OTLP.Interval,
+		}).Info("OpenTelemetry export enabled")
+	}
+	// Syslog and Kafka outputs are replaced when the config is reloaded
+	sinks := &logSinks{}
+	defer sinks.stop()
+	if err := sinks.setSyslog(&cfg.Logging.Syslog); err != nil {
+		return err
+	}
+	if err := sinks.setKafka(&cfg.Logging.Kafka); err != nil {
+		return err
+	}
+	audit.AddForwarder(sinks.logAudit)
+	handler.SetQueryCallback(func(event dns.QueryEvent) {
+		apiServer.RecordQuery(event)
+		event, keep := privacyFilter.Query(event)
+		if exporter != nil {
+			if keep {
+				exporter.RecordQuery(event)
+			} else {
+				exporter.CountQuery(event)
+			}
+		}
+		if !keep {
+			return
+		}
+		if queryMirror != nil {
+			queryMirror.Mirror(event)
+		}
+		sinks.recordQuery(event)
+		if queryLog != nil {
+			queryLog.Record(event)
+		}
 	})
+	var incidents *incident.Tracker
+	if cfg.Incident.Enabled {
+		incidents, err = incident.New(&cfg.Incident)
+		if err != nil {
+			return fmt.Errorf("failed to start incident ticketing: %v", err)
+		}
+		defer incidents.Stop()
+		logrus.WithField("provider", cfg.Incident.Provider).Info("Incident ticketing enabled")
+	}
+	onBlocked := func(event dns.BlockEvent) {
+		if !event.Monitored {
+			apiServer.AddBlockedDomain(event)
+			if incidents != nil {
+				incidents.Record(privacyFilter.Block(event))
+			}
+		}
+		details := map[string]interface{}{
+			"domain":      event.Domain,
+			"blocked_at":  event.Timestamp,
+			"query_type":  event.QueryType,
+			"rcode":       event.Rcode,
+			"client_ip":   event.ClientIP,
+			"client_port": event.ClientPort,
+			"rule":        event.Rule,
+			"rule_source": event.Source,
+			"user":        event.User,
+			"group":       event.Group,
+		}
+		if event.DecodedDomain != "" {
+			details["decoded_domain"] = event.DecodedDomain
+		}
+		if event.Monitored {
+			apiServer.IncrementMonitored()
+			audit.Log(audit.EventDomainMonitored, "info", fmt.Sprintf("Would block %s", event.Domain), details)
+			return
+		}
+		audit.LogDomainBlocked(event.Domain, details)
+	}
+	handler.SetBlockedCallback(onBlocked)
+	if cfg.DGA.Enabled {
+		handler.SetDGADetector(dns.NewDGADetector(&cfg.DGA), func(event dns.DGAEvent) {
+			fields := logrus.Fields{
+				"domain":  event.Domain,
+				"score":   fmt.Sprintf("%.2f", event.Score),
+				"blocked": event.Blocked,
+			}
+			logrus.WithFields(fields).Warn("Possible DGA domain")
+			audit.Log(audit.EventDGADetected, "warning", fmt.Sprintf("Possible DGA domain %s", event.Domain), map[string]interface{}{
+				"domain":    event.Domain,
+				"label":     event.Label,
+				"score":     event.Score,
+				"client_ip": event.ClientIP,
+				"blocked":   event.Blocked,
+			})
+		})
+		logrus.WithField("mode", cfg.DGA.Mode).Info("DGA detection enabled")
+	}
+	if cfg.Homograph.Enabled {
+		handler.SetHomographDetector(dns.NewHomographDetector(&cfg.Homograph), func(event dns.HomographEvent) {
+			logrus.WithFields(logrus.Fields{
+				"domain":  event.Domain,
+				"decoded": event.Decoded,
+				"brand":   event.Brand,
+				"blocked": event.Blocked,
+			}).Warn("Possible homograph of a protected domain")
+			audit.Log(audit.EventHomograph, "warning", fmt.Sprintf("Possible homograph of %s: %s", event.Brand, event.Domain), map[string]interface{}{
+				"domain":         event.Domain,
+				"decoded_domain": event.Decoded,
+				"brand":          event.Brand,
+				"client_ip":      event.ClientIP,
+				"blocked":        event.Blocked,
+			})
+		})
+		logrus.WithField("mode", cfg.Homograph.Mode).Info("Homograph detection enabled")
+	}
+	if cfg.DNS.Tunneling.Enabled {
+		handler.SetTunnelCallback(func(event dns.TunnelEvent) {
+			logrus.WithFields(logrus.Fields{
+				"zone":    event.Zone,
+				"client":  event.ClientIP,
+				"queries": event.Queries,
+				"reasons": event.Reasons,
+				"refused": event.Refused,
+			}).Warn("Possible DNS tunnel")
+			audit.Log(audit.EventDNSTunnel, "warning", fmt.Sprintf("Possible DNS tunnel through %s", event.Zone), map[string]interface{}{
+				"zone":         event.Zone,
+				"client_ip":    event.ClientIP,
+				"queries":      event.Queries,
+				"unique_ratio": event.UniqueRatio,
+				"txt_ratio":    event.TXTRatio,
+				"reasons":      event.Reasons,
+				"refused":      event.Refused,
+			})
+		})
+		logrus.WithField("refuse", cfg.DNS.Tunneling.Refuse).Info("DNS tunneling detection enabled")
+	}
+
+	// Share rules and stats with the transparent proxy extension
+	if cfg.TransparentProxy.Enabled {
+		resolved := dns.NewResolvedIPs(cfg.TransparentProxy.CorrelationTTL, cfg.TransparentProxy.MaxTrackedIPs)
+		handler.SetFlowCorrelation(resolved)
+		flowFilter := dns.NewFlowFilter(blocker, resolved, handler.GetCaptivePortalDetector())
+		flowFilter.SetBlockedCallback(onBlocked)
+		apiServer.SetFlowFilter(flowFilter)
+		logrus.Info("Transparent proxy flow verdicts enabled")
+	}
+
+	// Turn scheduled rules on and off at their window boundaries
+	scheduler := dns.NewScheduler(blocker, apiServer.SetScheduleStatus)
+	scheduler.Start()
+	defer scheduler.Stop()
+
+	// Pause or relax filtering during maintenance windows
+	if len(cfg.MaintenanceWindows) > 0 {
+		windows, err := maintenance.NewWindows(cfg.MaintenanceWindows)
+		if err != nil {
+			return err
+		}
+		runner := maintenance.NewRunner(windows, maintenance.Actions{
+			Pause:   apiServer.PauseForMaintenance,
+			Resume:  apiServer.EndMaintenancePause,
+			Monitor: blocker.SetMaintenanceMonitoring,
+		})
+		runner.Start()
+		defer runner.Stop()
+		logrus.WithField("windows", len(windows)).Info("Maintenance windows enabled")
+	}
+
+	// Clients in configured ranges get their group's rules instead of ours
+	clientBlockers := make(map[string]*dns.Blocker)
+	for _, clientGroup := range cfg.DNS.ClientGroups {
+		_, network, err := net.ParseCIDR(clientGroup.CIDR)
+		if err != nil {
+			return fmt.Errorf("invalid client group range: %v", err)
+		}
+		groupBlocker := clientBlockers[clientGroup.Group]
+		if groupBlocker == nil {
+			groupBlocker = dns.NewBlocker()
+			groupBlocker.SetMaxDomains(cfg.Rules.MaxDomains)
+			groupBlocker.SetDefaultEnforcement(cfg.Agent.Enforcement)
+			groupBlocker.UpdateMetadata("", clientGroup.Group)
+			clientBlockers[clientGroup.Group] = groupBlocker
+
+			groupScheduler := dns.NewScheduler(groupBlocker, nil)
+			groupScheduler.Start()
+			defer groupScheduler.Stop()
+		}
+		handler.SetClientBlocker(network, groupBlocker)
+		logrus.WithFields(logrus.Fields{
+			"clients": network.String(),
+			"group":   clientGroup.Group,
+		}).Info("Client range assigned to rule group")
+	}
 	dnsServer := dns.NewServer(handler)
 
+	// Remember what blocked each domain for the block page, including
+	// detections and client group rules the blocker doesn't know about
+	blocks := &blockLookup{blocker: blocker, recent: dns.NewRecentBlocks(recentBlockTTL, maxRecentBlocks)}
+	handler.SetRecentBlocks(blocks.recent)
+
 	// Create certificate generator and HTTPS proxy
-	certGen := proxy.NewCertGenerator(caManager, blocker)
+	certGen := proxy.NewCertGenerator(caManager, blocks)
+	if err := certGen.SetLeafKeys(cfg.Proxy.KeyAlgorithm, cfg.Proxy.KeyPoolSize); err != nil {
+		return fmt.Errorf("failed to configure block page certificates: %v", err)
+	}
+	caSelector := proxy.NewCASelector(certGen, caManager)
 	httpsProxy, err := proxy.NewHTTPSProxy(certGen)
 	if err != nil {
 		return fmt.Errorf("failed to create HTTPS proxy: %v", err)
 	}
+	httpsProxy.SetListenPorts(cfg.Agent.HTTPPort, cfg.Agent.HTTPSPort)
+	httpsProxy.SetBlockPageCallback(func(domain, clientIP string) {
+		// The visit is logged against its block: blocked_at matches the
+		// DOMAIN_BLOCKED event, so audit and SIEM consumers can set
+		// block_page_hit on it
+		var details map[string]interface{}
+		if block, ok := apiServer.MarkBlockPageHit(domain); ok {
+			details = map[string]interface{}{
+				"block_page_hit": true,
+				"blocked_at":     block.Timestamp,
+				"rule":           block.Rule,
+				"rule_source":    block.RuleSource,
+				"query_type":     block.QueryType,
+				"user":           block.User,
+				"group":          block.Group,
+			}
+		}
+		audit.LogBlockPageServed(domain, clientIP, details)
+	})
+	apiServer.SetClearCacheCallback(func() api.CacheClearResult {
+		return api.CacheClearResult{
+			DNSEntries:   handler.ClearCache(),
+			Certificates: certGen.ClearCache(),
+		}
+	})
+	var siem *logging.RemoteLogger
+	if telemetry := cfg.Blocking.SinkholeTelemetry; telemetry.Enabled {
+		if telemetry.ForwardToSIEM {
+			siem, err = logging.NewRemoteLogger(&cfg.Logging, nil)
+			if err != nil {
+				return fmt.Errorf("failed to start SIEM forwarding: %v", err)
+			}
+			defer siem.Shutdown()
+		}
+		httpsProxy.SetSinkholeRequestCallback(func(req proxy.SinkholeRequest) {
+			if telemetry.PrivacyMode {
+				req = req.Redacted()
+			}
+			details := map[string]interface{}{
+				"domain":     req.Domain,
+				"scheme":     req.Scheme,
+				"method":     req.Method,
+				"uri":        req.URI,
+				"user_agent": req.UserAgent,
+				"category":   blocks.match(req.Domain).Category(),
+			}
+			if req.ClientIP != "" {
+				details["client_ip"] = req.ClientIP
+			}
+			message := fmt.Sprintf("Sinkholed %s request for %s", req.Method, req.Domain)
+			audit.Log(audit.EventSinkholeRequest, "info", message, details)
+			if siem != nil {
+				siem.Log(privacyFilter.Event(audit.NewEvent(audit.EventSinkholeRequest, "info", message, details)))
+			}
+		})
+	}
+
+	// Deleting logged data on request and once it passes its retention
+	purger := &dataPurger{
+		queryLog:     queryLog,
+		fallbackPath: cfg.Logging.Local.FallbackPath,
+		remote:       siem,
+		deviceUser:   blocker.GetMetadata,
+	}
+	apiServer.SetDataPurgeCallback(purger.purge)
+	if retention := cfg.Logging.Local.Retention; retention > 0 {
+		go purger.enforceRetention(ctx, retention)
+	}
+
+	httpsProxy.SetBlockDetailsCallback(func(domain string) proxy.BlockDetails {
+		match := blocks.match(domain)
+		return proxy.BlockDetails{Rule: match.Rule, Category: match.Category(), Reason: match.Reason()}
+	})
+
+	// Access requests from the block page, and approvals that answer them
+	if cfg.Unblock.Enabled {
+		unblockService, err := unblock.New(&cfg.Unblock, &cfg.S3, blocker, blocks.match)
+		if err != nil {
+			return fmt.Errorf("failed to start access requests: %v", err)
+		}
+		defer unblockService.Stop()
+		apiServer.SetUnblockService(unblockService)
+
+		var approve func(token string) (string, time.Time, error)
+		if unblockService.ApprovalsEnabled() {
+			approve = func(token string) (string, time.Time, error) {
+				allow, err := unblockService.Approve(token)
+				if err != nil {
+					return "", time.Time{}, err
+				}
+				return allow.Domain, allow.Until, nil
+			}
+		}
+		httpsProxy.SetUnblockCallbacks(func(req proxy.UnblockRequest) (string, error) {
+			submitted, err := unblockService.Submit(req.Domain, req.Justification, req.ClientIP)
+			if err != nil {
+				return "", err
+			}
+			return submitted.ID, nil
+		}, approve)
+		logrus.WithField("approvals", unblockService.ApprovalsEnabled()).Info("Access requests enabled")
+	}
+
+	// Self-service bypasses of a single blocked domain
+	if cfg.DomainBypass.Enabled {
+		bypasser, err := unblock.NewBypasser(&cfg.DomainBypass, blocker, blocks.match)
+		if err != nil {
+			return fmt.Errorf("failed to start domain bypass: %v", err)
+		}
+		apiServer.SetDomainBypasser(bypasser)
+		logrus.WithFields(logrus.Fields{
+			"maxDuration": cfg.DomainBypass.MaxDuration,
+			"requireCode": bypasser.RequiresCode(),
+		}).Info("Domain bypass enabled")
+	}
+
+	// Domains allowed and blocked on this device through the API
+	if cfg.LocalOverrides.Enabled {
+		overrides, err := unblock.NewOverrides(&cfg.LocalOverrides, unblock.DefaultOverridesPath, blocker, blocks.match)
+		if err != nil {
+			return fmt.Errorf("failed to load local rules: %v", err)
+		}
+		apiServer.SetLocalOverrides(overrides)
+		allow, block := overrides.Lists()
+		logrus.WithFields(logrus.Fields{
+			"allow":          len(allow),
+			"block":          len(block),
+			"allowedDomains": cfg.LocalOverrides.AllowedDomains,
+		}).Info("Local rules enabled")
+	}
+	httpsProxy.SetDiagnosticsCallback(func() proxy.DiagnosticsData {
+		data := proxy.DiagnosticsData{
+			Protected:      !dnsManager.IsPaused(),
+			BlockedDomains: blocker.GetBlockedCount(),
+			Version:        "1.0.0",
+		}
+		if apiTCP {
+			data.StatusURL = fmt.Sprintf("http://127.0.0.1:%d/api/health", api.DefaultPort)
+		}
+		return data
+	})
 
 	// Start DNS server
 	if err := dnsServer.Start(cfg.Agent.DNSPort); err != nil {
@@ -229,6 +795,18 @@ func runAgent(opts *RunOptions) error {
 		return fmt.Errorf("failed to start HTTPS proxy: %v", err)
 	}
 
+	// Send queries to hard-coded resolvers through the agent too
+	var dnsRedirect *security.DNSRedirect
+	if cfg.Firewall.RedirectDNS && opts.Headless {
+		logrus.Warn("Ignoring firewall.redirectDNS in headless mode")
+	} else if cfg.Firewall.RedirectDNS {
+		dnsRedirect = security.NewDNSRedirect(cfg.Agent.DNSPort, cfg.Firewall.BlockDoT)
+		if err := dnsRedirect.Install(); err != nil {
+			logrus.WithError(err).Error("Failed to install pf DNS redirect")
+			dnsRedirect = nil
+		}
+	}
+
 	// All privileged ports are now bound, drop privileges if running as root
 	if err := hardening.DropPrivilegesAfterBind(); err != nil {
 		logrus.WithError(err).Warn("Failed to drop privileges")
@@ -236,19 +814,115 @@ func runAgent(opts *RunOptions) error {
 	}
 
 	// Set up S3 rule fetching if configured
-	if cfg.S3.Bucket != "" {
+	rulesStatus := &ruleStatus{}
+	apiServer.SetExplainCallback(func(domain string) *api.DomainExplanation {
+		return explainDomain(blocker, rulesStatus.rules(), domain)
+	})
+	apiServer.SetResolveCallback(func(domain string, qtype uint16) *api.ResolveResult {
+		return resolveResult(handler.Resolve(domain, qtype))
+	})
+	if cfg.S3.Configured() {
+		// /api/refresh-rules runs an update on the updater goroutine and
+		// waits for it
+		refreshRules := make(chan chan ruleRefresh, 4)
+		apiServer.SetRefreshRulesCallback(func(ctx context.Context) (*api.RuleRefreshResult, error) {
+			done := make(chan ruleRefresh, 1)
+			select {
+			case refreshRules <- done:
+			default:
+				return nil, fmt.Errorf("too many rule updates pending")
+			}
+			var refreshed ruleRefresh
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case refreshed = <-done:
+				if !refreshed.ok {
+					return nil, fmt.Errorf("rule update failed; see the agent log")
+				}
+			}
+			return &api.RuleRefreshResult{
+				BlockedDomains:  blocker.GetBlockedCount(),
+				SecurityDomains: blocker.GetSecurityBlockedCount(),
+				AllowedDomains:  blocker.GetAllowlistCount(),
+				FailedSources:   refreshed.failedSources,
+			}, nil
+		})
+
+		// Rollbacks run on the updater goroutine too
+		rollbackRules := make(chan ruleRollback, 4)
+		apiServer.SetRuleHistoryCallbacks(listRuleHistory, func(ctx context.Context, version string) (*api.RuleRollbackResult, error) {
+			req := ruleRollback{version: version, done: make(chan ruleRollbackResult, 1)}
+			select {
+			case rollbackRules <- req:
+			default:
+				return nil, fmt.Errorf("too many rule updates pending")
+			}
+			select {
+			case <-ctx.Done():
+				return nil, ctx.Err()
+			case res := <-req.done:
+				if res.err != nil {
+					return nil, res.err
+				}
+				return &api.RuleRollbackResult{
+					RuleRefreshResult: api.RuleRefreshResult{
+						BlockedDomains:  blocker.GetBlockedCount(),
+						SecurityDomains: blocker.GetSecurityBlockedCount(),
+						AllowedDomains:  blocker.GetAllowlistCount(),
+					},
+					Version: res.version,
+				}, nil
+			}
+		})
+
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			startRuleUpdater(ctx, cfg, blocker, clientBlockers, httpsProxy, caSelector, dnsManager, refreshRules, rollbackRules, rulesStatus, exporter)
+		}()
+	}
+
+	// Feeds outside the S3 rules are loaded into every blocker on their
+	// own schedules
+	allBlockers := []*dns.Blocker{blocker}
+	for _, b := range clientBlockers {
+		allBlockers = append(allBlockers, b)
+	}
+	// Pick up CAs rotated with 'dnshield ca rotate'
+	if !ca.UseKeychain() {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			watchCARotation(ctx, caSelector, !opts.Headless)
+		}()
+	}
+
+	if cfg.ThreatIntel.Enabled {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			startThreatIntelUpdater(ctx, cfg, allBlockers)
+		}()
+	}
+	if cfg.NRD.Enabled {
 		wg.Add(1)
 		go func() {
 			defer wg.Done()
-			startRuleUpdater(ctx, cfg, blocker)
+			startNRDUpdater(ctx, cfg, allBlockers)
 		}()
 	}
 
 	logrus.Info("DNShield is running")
-	logrus.Info("DNS server listening on port 53")
-	logrus.Info("HTTP server listening on port 80")
-	logrus.Info("HTTPS server listening on port 443")
-	logrus.Info("API server listening on port 5353")
+	logrus.Infof("DNS server listening on port %d", cfg.Agent.DNSPort)
+	logrus.Infof("HTTP server listening on port %d", cfg.Agent.HTTPPort)
+	logrus.Infof("HTTPS server listening on port %d", cfg.Agent.HTTPSPort)
+	if cfg.API.Socket.Enabled {
+		logrus.Infof("API server listening on %s", cfg.API.Socket.Path)
+	}
+	if apiTCP {
+		logrus.Infof("API server listening on port %d", api.DefaultPort)
+	}
 	logrus.WithField("domains", blocker.GetBlockedCount()).Info("Blocked domains loaded")
 
 	// Register status callback for API
@@ -257,19 +931,31 @@ func runAgent(opts *RunOptions) error {
 		var m runtime.MemStats
 		runtime.ReadMemStats(&m)
 
-		return api.Status{
+		status := api.Status{
 			Running:          true,
 			Protected:        true,
 			DNSConfigured:    true,
-			CurrentDNS:       []string{"127.0.0.1"},
+			CurrentDNS:       dns.LocalResolvers,
 			UpstreamDNS:      cfg.DNS.Upstreams,
 			Mode:             getSecurityMode(),
-			PolicyEnforced:   !cfg.Agent.AllowDisable,
-			PolicySource:     "local",
+			PolicyEnforced:   false,
+			PolicySource:     policy.SourceLocal,
 			LastHealthCheck:  time.Now(),
 			Version:          "1.0.0",
 			CertificateValid: true,
+			Enforcement:      blocker.Enforcement(),
+		}
+		if cfg.S3.Configured() {
+			rulesStatus.report(&status, cfg.S3.StaleAfter)
 		}
+		if policyManager != nil {
+			ps := policyManager.Status()
+			status.PolicyEnforced = ps.Enforced
+			status.PolicySource = ps.Source
+			status.PolicySerial = ps.Serial
+			status.PolicyError = ps.Error
+		}
+		return status
 	})
 
 	// Load API keys
@@ -277,12 +963,107 @@ func runAgent(opts *RunOptions) error {
 		logrus.WithError(err).Warn("Failed to load API keys")
 	}
 
-	// Update API server configuration
-	apiServer.UpdateConfig(&api.Config{
-		AllowPause:     cfg.Agent.AllowDisable,
-		AllowQuit:      cfg.Agent.AllowDisable,
-		UpdateInterval: int(cfg.S3.UpdateInterval / time.Minute),
+	// Pick up keys generated, rotated and revoked while the agent runs
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		ticker := time.NewTicker(apiKeyReloadInterval)
+		defer ticker.Stop()
+
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-ticker.C:
+				if err := apiServer.ReloadAPIKeys(); err != nil {
+					logrus.WithError(err).Warn("Failed to reload API keys")
+				}
+			}
+		}
+	}()
+
+	// Settings that apply without a restart when the config file changes,
+	// on SIGHUP or over the API
+	configPath := opts.ConfigFile
+	if configPath == "" {
+		configPath = config.DefaultConfigPath()
+	}
+	reloader := newConfigReloader(configPath, cfg, func(newCfg *config.Config) error {
+		if err := applyOverrides(newCfg); err != nil {
+			return err
+		}
+		if policyManager != nil {
+			policyManager.Policy().Apply(newCfg)
+		}
+		return nil
 	})
+	reloader.handle(func(newCfg *config.Config) error {
+		handler.UpdateConfig(&newCfg.DNS)
+		logrus.WithField("upstreams", newCfg.DNS.Upstreams).Info("Reloaded upstreams and cache settings")
+		return nil
+	}, "dns.upstreams", "dns.strategy", "dns.conditionalForwarders", "dns.cacheSize", "dns.cacheTTL", "dns.negativeCacheTTL", "dns.serveStale")
+	reloader.handle(func(newCfg *config.Config) error {
+		if err := handler.SetLocalRecords(newCfg.DNS.LocalRecords); err != nil {
+			return err
+		}
+		logrus.WithField("records", len(newCfg.DNS.LocalRecords)).Info("Reloaded local DNS records")
+		return nil
+	}, "dns.localRecords")
+	reloader.handle(func(newCfg *config.Config) error {
+		handler.UpdateCaptivePortal(&newCfg.CaptivePortal)
+		return nil
+	}, "captivePortal")
+	reloader.handle(func(newCfg *config.Config) error {
+		// Enterprise rules replace the test domains when they load
+		if rulesStatus.rules() != nil {
+			logrus.Info("Test domains changed; not loaded while enterprise rules are in use")
+			return nil
+		}
+		return blocker.UpdateDomains(newCfg.TestDomains)
+	}, "testDomains")
+	reloader.handle(func(newCfg *config.Config) error {
+		if os.Getenv("DNSHIELD_LOG_LEVEL") != "" {
+			logrus.Info("Log level changed; DNSHIELD_LOG_LEVEL still overrides it")
+			return nil
+		}
+		level, err := logrus.ParseLevel(newCfg.Agent.LogLevel)
+		if err != nil {
+			level = logrus.InfoLevel
+		}
+		logrus.SetLevel(level)
+		return nil
+	}, "agent.logLevel")
+	reloader.handle(func(newCfg *config.Config) error {
+		var p *policy.Policy
+		if policyManager != nil {
+			p = policyManager.Policy()
+		}
+		apiServer.UpdateConfig(apiConfig(newCfg, p))
+		return nil
+	}, "agent.allowDisable", "agent.maxPause")
+	reloader.handle(func(newCfg *config.Config) error {
+		return sinks.setSyslog(&newCfg.Logging.Syslog)
+	}, "logging.syslog")
+	reloader.handle(func(newCfg *config.Config) error {
+		return sinks.setKafka(&newCfg.Logging.Kafka)
+	}, "logging.kafka")
+	apiServer.SetConfigReloadCallback(reloader.reload)
+	wg.Add(1)
+	go func() {
+		defer wg.Done()
+		reloader.watch(ctx)
+	}()
+
+	// Update API server configuration
+	if policyManager != nil {
+		apiServer.UpdateConfig(apiConfig(cfg, policyManager.Policy()))
+		policyManager.Start(func(p *policy.Policy) {
+			apiServer.UpdateConfig(apiConfig(reloader.config(), p))
+			logrus.WithField("serial", p.Serial).Info("Managed policy updated; rule source changes apply after restart")
+		})
+	} else {
+		apiServer.UpdateConfig(apiConfig(cfg, nil))
+	}
 
 	// Start periodic stats update
 	wg.Add(1)
@@ -302,6 +1083,14 @@ func runAgent(opts *RunOptions) error {
 				stats := apiServer.GetStats()
 				stats.MemoryUsageMB = float64(m.Alloc) / 1024 / 1024
 				stats.Uptime = time.Since(startTime).String()
+				stats.RegexRules = nil
+				for _, rule := range blocker.RegexRuleHits() {
+					stats.RegexRules = append(stats.RegexRules, api.RegexRuleStats{Pattern: rule.Pattern, Hits: rule.Hits})
+				}
+				stats.Categories = nil
+				for _, category := range blocker.CategoryHits() {
+					stats.Categories = append(stats.Categories, api.CategoryStats{Category: category.Category, Hits: category.Hits})
+				}
 				apiServer.UpdateStats(stats)
 			}
 		}
@@ -326,6 +1115,13 @@ func runAgent(opts *RunOptions) error {
 	// Cancel context to signal all goroutines to stop
 	cancel()
 
+	// Remove the redirect while the DNS server can still answer
+	if dnsRedirect != nil {
+		if err := dnsRedirect.Remove(); err != nil {
+			logrus.WithError(err).Warn("Error removing pf DNS redirect")
+		}
+	}
+
 	// Stop servers with timeout
 	shutdownCtx, shutdownCancel := context.WithTimeout(context.Background(), 10*time.Second)
 	defer shutdownCancel()
@@ -333,6 +1129,11 @@ func runAgent(opts *RunOptions) error {
 	if err := apiServer.Stop(shutdownCtx); err != nil {
 		logrus.WithError(err).Warn("Error stopping API server")
 	}
+	if grpcServer != nil {
+		if err := grpcServer.Stop(shutdownCtx); err != nil {
+			logrus.WithError(err).Warn("Error stopping gRPC API")
+		}
+	}
 	if err := dnsServer.Stop(); err != nil {
 		logrus.WithError(err).Warn("Error stopping DNS server")
 	}
@@ -358,18 +1159,109 @@ func runAgent(opts *RunOptions) error {
 	return nil
 }
 
-func startRuleUpdater(ctx context.Context, cfg *config.Config, blocker *dns.Blocker) {
-	// Create enterprise S3 fetcher
-	fetcher, err := rules.NewEnterpriseFetcher(&cfg.S3)
-	if err != nil {
-		logrus.WithError(err).Error("Failed to create enterprise S3 fetcher")
-		return
+// startRuleUpdater applies enterprise rules at startup, every update
+// interval, and for each request on refresh. Each request receives whether
+// fresh rules were applied, and the blocklists left out of them. Requests on rollback apply an earlier version
+// from the rules history. clientBlockers are loaded with their group's
+// rules at the same times. Where the applied rules came from is recorded
+// in status. Rules from rules.localDir are also applied whenever its
+// files change.
+func startRuleUpdater(ctx context.Context, cfg *config.Config, blocker *dns.Blocker, clientBlockers map[string]*dns.Blocker, httpsProxy *proxy.HTTPSProxy, caSelector *proxy.CASelector, networks *dns.NetworkManager, refresh <-chan chan ruleRefresh, rollback <-chan ruleRollback, status *ruleStatus, exporter *telemetry.Exporter) {
+	parser := rules.NewParser()
+	parser.SetLimits(cfg.Rules.MaxFileSize, cfg.Rules.MaxDomains)
+	parser.SetCache(rules.NewBlocklistCache(rules.DefaultBlocklistCacheDir))
+	history := newRuleHistory(cfg, blocker, func(er *rules.EnterpriseRules) bool {
+		_, ok := applyEnterpriseRules(er, parser, blocker, httpsProxy, caSelector, networks)
+		return ok
+	})
+
+	// Start from the cached rules so blocking works before S3 answers
+	var applied time.Time
+	if cached, err := rules.LoadCache(rules.DefaultCachePath); err == nil {
+		if _, ok := applyEnterpriseRules(cached, parser, blocker, httpsProxy, caSelector, networks); ok {
+			applied = cached.FetchTime
+			history.resume(cached)
+			status.set(ruleSourceCache, cached, cached.FetchTime)
+			logrus.WithField("fetched", cached.FetchTime).Info("Applied cached enterprise rules")
+			if rules.Stale(cached.FetchTime, cfg.S3.StaleAfter, time.Now()) {
+				logrus.WithField("age", time.Since(cached.FetchTime).Round(time.Minute)).Warn("Cached enterprise rules are stale")
+			}
+		}
 	}
 
-	parser := rules.NewParser()
+	source := ruleSourceS3
+	changed := make(chan struct{}, 1)
+	if cfg.Rules.LocalDir != "" {
+		source = ruleSourceLocal
+		go rules.WatchDir(ctx, cfg.Rules.LocalDir, localRulesPollInterval, func() {
+			select {
+			case changed <- struct{}{}:
+			default:
+			}
+		})
+	}
+
+	// The S3 fetcher is created on first use and retried until it succeeds.
+	// failedSources holds the blocklists the last update left out.
+	var fetcher *rules.EnterpriseFetcher
+	var failedSources []string
+	updateRules := func() bool {
+		failedSources = nil
+		if fetcher == nil {
+			f, err := rules.NewEnterpriseFetcher(&cfg.S3)
+			if err != nil {
+				logrus.WithError(err).Error("Failed to create enterprise S3 fetcher")
+			} else {
+				if resolver := newUserResolver(&cfg.OIDC); resolver != nil {
+					f.SetUserResolver(resolver)
+				}
+				if cached, err := rules.LoadCache(rules.DefaultCachePath); err == nil {
+					// Devices left out of a staged rollout keep what they ran before
+					f.RememberApplied(cached)
+				}
+			}
+			fetcher = f
+		}
+		if fetcher != nil {
+			updateClientGroupRules(fetcher, parser, clientBlockers)
+			updated, failed := updateEnterpriseRules(fetcher, parser, blocker, httpsProxy, caSelector, networks, history)
+			if updated != nil {
+				failedSources = failed
+				applied = updated.FetchTime
+				status.set(source, updated, time.Now())
+				return true
+			}
+		}
+
+		// S3 is unreachable; use rules 'dnshield update-rules' cached since
+		cached, err := rules.LoadCache(rules.DefaultCachePath)
+		if err != nil || !cached.FetchTime.After(applied) {
+			return false
+		}
+		if _, ok := applyEnterpriseRules(cached, parser, blocker, httpsProxy, caSelector, networks); ok {
+			applied = cached.FetchTime
+			status.set(ruleSourceCache, cached, cached.FetchTime)
+			logrus.WithField("fetched", cached.FetchTime).Info("Applied cached enterprise rules")
+		}
+		return false
+	}
+
+	// Each update is traced and failures counted when telemetry is on
+	update := func() bool {
+		span := exporter.StartSpan("rules.update", map[string]interface{}{"rules.source": source})
+		ok := updateRules()
+		if ok {
+			span.SetAttribute("rules.blocked_domains", blocker.GetBlockedCount())
+			span.End(nil)
+		} else {
+			exporter.CountError("rules_update")
+			span.End(fmt.Errorf("enterprise rules were not updated"))
+		}
+		return ok
+	}
 
 	// Update rules immediately
-	updateEnterpriseRules(fetcher, parser, blocker)
+	update()
 
 	// Add jitter to prevent thundering herd
 	if cfg.S3.UpdateJitter > 0 {
@@ -387,75 +1279,724 @@ func startRuleUpdater(ctx context.Context, cfg *config.Config, blocker *dns.Bloc
 			logrus.Info("Rule updater shutting down")
 			return
 		case <-ticker.C:
-			updateEnterpriseRules(fetcher, parser, blocker)
+			update()
+		case <-changed:
+			update()
+		case done := <-refresh:
+			ok := update()
+			done <- ruleRefresh{ok: ok, failedSources: failedSources}
+		case req := <-rollback:
+			entry, err := history.rollBack(req.version, nil)
+			if err != nil {
+				req.done <- ruleRollbackResult{err: err}
+				continue
+			}
+			applied = history.applied.FetchTime
+			status.use(history.applied)
+			req.done <- ruleRollbackResult{version: entry.Version}
 		}
 	}
 }
 
-func updateEnterpriseRules(fetcher *rules.EnterpriseFetcher, parser *rules.Parser, blocker *dns.Blocker) {
-	logrus.Info("Updating enterprise blocking rules...")
+// Sources of the enterprise rules in use, reported by /api/status
+const (
+	ruleSourceS3    = "s3"
+	ruleSourceLocal = "local"
+	ruleSourceCache = "cache"
+	ruleSourceNone  = "none"
+)
+
+// localRulesPollInterval is how often rules.localDir is checked for changes
+// file notifications missed
+const localRulesPollInterval = 30 * time.Second
+
+// ruleStatus records the enterprise rules in use, where they came from
+// and when they were fetched
+type ruleStatus struct {
+	mu        sync.Mutex
+	source    string
+	fetchedAt time.Time
+	current   *rules.EnterpriseRules
+	version   string // As in the rules history
+}
+
+func (s *ruleStatus) set(source string, er *rules.EnterpriseRules, fetchedAt time.Time) {
+	version := rulesVersion(er)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.source, s.current, s.fetchedAt, s.version = source, er, fetchedAt, version
+}
+
+// use records rules applied from the same source, e.g. by a rollback
+func (s *ruleStatus) use(er *rules.EnterpriseRules) {
+	version := rulesVersion(er)
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.current, s.version = er, version
+}
+
+// rulesVersion returns the version er has in the rules history
+func rulesVersion(er *rules.EnterpriseRules) string {
+	if er == nil {
+		return ""
+	}
+	return rules.Digest(er)[:12]
+}
+
+// rules returns the enterprise rules in use, or nil
+func (s *ruleStatus) rules() *rules.EnterpriseRules {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// report fills in the rules fields of status. Rules are stale when none
+// are loaded or they were fetched longer than staleAfter ago.
+func (s *ruleStatus) report(status *api.Status, staleAfter time.Duration) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	status.RulesSource = s.source
+	if status.RulesSource == "" {
+		status.RulesSource = ruleSourceNone
+	}
+	status.RulesFetchedAt = s.fetchedAt
+	status.RulesStale = rules.Stale(s.fetchedAt, staleAfter, time.Now())
+	status.RulesVersion = s.version
+}
+
+// ruleRefresh is the outcome of an update /api/refresh-rules asked for
+type ruleRefresh struct {
+	ok            bool
+	failedSources []string
+}
+
+// ruleRollback asks the rule updater to apply an earlier version of the
+// rules, or the one before the current version if version is empty
+type ruleRollback struct {
+	version string
+	done    chan ruleRollbackResult
+}
+
+type ruleRollbackResult struct {
+	version string
+	err     error
+}
+
+// ruleHistory records the enterprise rules the updater applies and rolls
+// them back. It is only used from the updater goroutine.
+type ruleHistory struct {
+	store    *rules.History // Nil when there is nowhere to keep the history
+	blocker  *dns.Blocker
+	apply    func(*rules.EnterpriseRules) bool
+	critical []string // Domains the rules must not block
+
+	current string                 // Version applied now
+	applied *rules.EnterpriseRules // Rules applied now
+}
+
+func newRuleHistory(cfg *config.Config, blocker *dns.Blocker, apply func(*rules.EnterpriseRules) bool) *ruleHistory {
+	h := &ruleHistory{blocker: blocker, apply: apply}
+	if dir, err := rules.DefaultHistoryDir(); err == nil {
+		h.store = rules.NewHistory(dir, cfg.Rules.HistorySize)
+	} else {
+		logrus.WithError(err).Warn("Rules history unavailable; rollbacks are disabled")
+	}
+
+	// Rules that cut the agent off from the rules store can't be fixed
+	// remotely
+	h.critical = append(h.critical, cfg.Rules.CriticalDomains...)
+	h.critical = append(h.critical, rules.StoreHosts(&cfg.S3)...)
+	return h
+}
+
+// listRuleHistory returns the rules history for the API
+func listRuleHistory() ([]api.RuleVersion, error) {
+	dir, err := rules.DefaultHistoryDir()
+	if err != nil {
+		return nil, err
+	}
+	store := rules.NewHistory(dir, 0)
+	entries, err := store.List()
+	if err != nil {
+		return nil, err
+	}
+	versions := make([]api.RuleVersion, 0, len(entries))
+	for _, e := range entries {
+		versions = append(versions, api.RuleVersion{Version: e.Version, AppliedAt: e.AppliedAt, ETags: e.ETags})
+	}
+	if len(versions) > 0 {
+		if cached, err := rules.LoadCache(rules.DefaultCachePath); err == nil {
+			current := rules.Digest(cached)[:12]
+			for i := range versions {
+				versions[i].Current = versions[i].Version == current
+			}
+		}
+	}
+	return versions, nil
+}
+
+// resume picks up the cached rules applied at startup, which are usually
+// in the history already
+func (h *ruleHistory) resume(er *rules.EnterpriseRules) {
+	if h.store != nil {
+		if _, entry, err := h.store.Load(rules.Digest(er)[:12]); err == nil {
+			h.current, h.applied = entry.Version, er
+			return
+		}
+	}
+	h.record(er, nil)
+}
+
+// record adds applied rules to the history
+func (h *ruleHistory) record(er *rules.EnterpriseRules, etags map[string]string) {
+	h.applied = er
+	if h.store == nil {
+		return
+	}
+	entry, err := h.store.Record(er, etags)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to record rules history")
+		return
+	}
+	h.current = entry.Version
+	if err := h.store.Hold(""); err != nil {
+		logrus.WithError(err).Warn("Failed to update rules history")
+	}
+}
+
+// held reports whether er are the rules last rolled back from, which are
+// kept off until they change
+func (h *ruleHistory) held(er *rules.EnterpriseRules) bool {
+	return h.store != nil && h.applied != nil && h.store.Held() == rules.Digest(er)
+}
+
+// blockedCriticalDomain returns a critical domain the applied rules block
+func (h *ruleHistory) blockedCriticalDomain() string {
+	if h.blocker.Monitoring() {
+		return ""
+	}
+	for _, domain := range h.critical {
+		if h.blocker.Check(domain).Blocked {
+			return domain
+		}
+	}
+	return ""
+}
+
+// rollBack applies a recorded version of the rules, or the one before the
+// current version if version is empty. The rules rolled back from, from or
+// the current rules if nil, are held back until they change.
+func (h *ruleHistory) rollBack(version string, from *rules.EnterpriseRules) (*rules.HistoryEntry, error) {
+	if h.store == nil {
+		return nil, fmt.Errorf("rules history is unavailable")
+	}
+	if from == nil {
+		from = h.applied
+	}
+	if version == "" {
+		entries, err := h.store.List()
+		if err != nil {
+			return nil, err
+		}
+		for i, e := range entries {
+			if e.Version == h.current && i+1 < len(entries) {
+				version = entries[i+1].Version
+			}
+		}
+		if version == "" {
+			return nil, fmt.Errorf("no earlier rules version to roll back to")
+		}
+	}
+
+	er, entry, err := h.store.Load(version)
+	if err != nil {
+		return nil, err
+	}
+	er.FetchTime = time.Now()
+	if !h.apply(er) {
+		return nil, fmt.Errorf("failed to apply rules version %s", entry.Version)
+	}
+	if from != nil {
+		if err := h.store.Hold(rules.Digest(from)); err != nil {
+			logrus.WithError(err).Warn("Failed to update rules history")
+		}
+	}
+	previous := h.current
+	h.current, h.applied = entry.Version, er
+	if err := rules.SaveCache(rules.DefaultCachePath, er); err != nil {
+		logrus.WithError(err).Warn("Failed to cache enterprise rules")
+	}
+
+	logrus.WithFields(logrus.Fields{"version": entry.Version, "from": previous}).Warn("Enterprise rules rolled back")
+	audit.Log(audit.EventRulesRollback, "warning", fmt.Sprintf("Rolled back rules to %s", entry.Version), map[string]interface{}{
+		"version":    entry.Version,
+		"from":       previous,
+		"applied_at": entry.AppliedAt.Format(time.RFC3339),
+	})
+	return entry, nil
+}
+
+const (
+	// recentBlockTTL is how long a block is remembered for the block page,
+	// covering clients that cache the sinkhole answer
+	recentBlockTTL  = 15 * time.Minute
+	maxRecentBlocks = 10000
+)
+
+// blockLookup reports why a domain is blocked, preferring what the DNS
+// handler last did for it over the default blocklist
+type blockLookup struct {
+	blocker *dns.Blocker
+	recent  *dns.RecentBlocks
+}
+
+func (l *blockLookup) match(domain string) dns.BlockMatch {
+	if match, ok := l.recent.Lookup(domain); ok {
+		return match
+	}
+	return l.blocker.Check(domain)
+}
+
+// IsBlocked lets the certificate generator issue certificates for every
+// domain the handler sinkholed
+func (l *blockLookup) IsBlocked(domain string) bool {
+	return l.match(domain).Blocked
+}
+
+// caRotationCheckInterval is how often the agent looks for a rotated CA
+const caRotationCheckInterval = time.Minute
+
+// apiKeyReloadInterval is how often the agent looks for changes to the API
+// key store
+const apiKeyReloadInterval = 30 * time.Second
+
+// watchCARotation switches the certificate generator to the file-based CA
+// when 'dnshield ca rotate' replaces it, which re-issues every block page
+// certificate from the new CA. When complete is set it also removes the
+// previous CA from the trust store once the rotation's grace window ends.
+func watchCARotation(ctx context.Context, selector *proxy.CASelector, complete bool) {
+	caPath := ca.GetCAPath()
+	check := func() {
+		cert, err := ca.LoadCACertificate(caPath)
+		if err == nil && !cert.Equal(selector.DefaultCertificate()) {
+			manager, err := ca.LoadOrCreateFileManager()
+			if err != nil {
+				logrus.WithError(err).Error("Failed to load rotated CA, keeping current CA")
+				return
+			}
+			selector.SetDefaultCA(manager)
+			audit.Log(audit.EventCARotated, "info", "Switched to rotated CA", map[string]interface{}{
+				"fingerprint": ca.Fingerprint(manager.Certificate()),
+			})
+			logrus.WithField("fingerprint", ca.Fingerprint(manager.Certificate())).Info("Switched to rotated CA")
+		}
+
+		rotation, err := ca.PendingRotation(caPath)
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to read CA rotation state")
+			return
+		}
+		if !complete || rotation == nil || !rotation.Due(time.Now()) {
+			return
+		}
+		if err := ca.CompleteRotation(caPath); err != nil {
+			logrus.WithError(err).Error("Failed to remove previous CA after rotation")
+			audit.Log(audit.EventCAUninstalled, "error", "Failed to remove previous CA after rotation", map[string]interface{}{
+				"fingerprint": rotation.PreviousFingerprint,
+				"error":       err.Error(),
+			})
+			return
+		}
+		audit.Log(audit.EventCAUninstalled, "info", "Previous CA removed after rotation", map[string]interface{}{
+			"fingerprint": rotation.PreviousFingerprint,
+		})
+		logrus.WithField("fingerprint", rotation.PreviousFingerprint).Info("Rotation grace window ended, previous CA removed")
+	}
+
+	check()
+	ticker := time.NewTicker(caRotationCheckInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+// updateEnterpriseRules fetches and applies the device's rules and caches
+// them. It returns the rules applied, or nil if the update failed, and the
+// blocklists that were left out of them.
+func updateEnterpriseRules(fetcher *rules.EnterpriseFetcher, parser *rules.Parser, blocker *dns.Blocker, httpsProxy *proxy.HTTPSProxy, caSelector *proxy.CASelector, networks *dns.NetworkManager, history *ruleHistory) (*rules.EnterpriseRules, []string) {
+	logrus.Info("Updating enterprise blocking rules...")
 
 	// Fetch all applicable rules for this device
 	enterpriseRules, err := fetcher.FetchEnterpriseRules()
 	if err != nil {
 		logrus.WithError(err).Error("Failed to fetch enterprise rules")
+		return nil, nil
+	}
+	if history.held(enterpriseRules) {
+		logrus.Info("Enterprise rules unchanged since they were rolled back, keeping the earlier version")
+		return history.applied, nil
+	}
+
+	failedSources, ok := applyEnterpriseRules(enterpriseRules, parser, blocker, httpsProxy, caSelector, networks)
+	if !ok {
+		return nil, nil
+	}
+
+	// Undo rules that would stop the agent from fetching a fix
+	if domain := history.blockedCriticalDomain(); domain != "" {
+		logrus.WithField("domain", domain).Error("New enterprise rules block a critical domain, rolling back")
+		audit.Log(audit.EventRulesRollback, "critical", fmt.Sprintf("New rules block critical domain %s", domain), map[string]interface{}{
+			"domain": domain,
+			"etags":  fetcher.ETags(enterpriseRules),
+		})
+		if history.current == "" {
+			logrus.Error("No earlier rules to roll back to, keeping the new rules")
+		} else if _, err := history.rollBack(history.current, enterpriseRules); err != nil {
+			logrus.WithError(err).Error("Failed to roll back enterprise rules")
+		} else {
+			return history.applied, nil
+		}
+	}
+
+	updateCustomBlockPage(fetcher, httpsProxy)
+	if err := rules.SaveCache(rules.DefaultCachePath, enterpriseRules); err != nil {
+		logrus.WithError(err).Warn("Failed to cache enterprise rules")
+	}
+	history.record(enterpriseRules, fetcher.ETags(enterpriseRules))
+	return enterpriseRules, failedSources
+}
+
+// updateCustomBlockPage loads the block page template and assets from the
+// rules bucket. The current page is kept if they can't be fetched or the
+// template is invalid; without a template the built-in page is used.
+func updateCustomBlockPage(fetcher *rules.EnterpriseFetcher, httpsProxy *proxy.HTTPSProxy) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	bundle, err := fetcher.FetchBlockPage(ctx)
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to fetch custom block page, keeping current page")
+		return
+	}
+	if bundle == nil {
+		httpsProxy.SetCustomBlockPage(nil, nil)
+		return
+	}
+	if err := httpsProxy.SetCustomBlockPage(bundle.Template, bundle.Assets); err != nil {
+		logrus.WithError(err).Warn("Invalid custom block page, keeping current page")
 		return
 	}
+	logrus.WithField("assets", len(bundle.Assets)).Debug("Custom block page loaded")
+}
+
+// updateClientGroupRules fetches the rules of each client group and loads
+// them into its blocker. A group whose rules can't be fetched keeps the
+// rules it has.
+func updateClientGroupRules(fetcher *rules.EnterpriseFetcher, parser *rules.Parser, clientBlockers map[string]*dns.Blocker) {
+	for group, groupBlocker := range clientBlockers {
+		groupRules, err := fetcher.FetchGroupRules(group)
+		if err != nil {
+			logrus.WithError(err).WithField("group", group).Error("Failed to fetch client group rules")
+			continue
+		}
+		logFields, _, ok := loadBlockerRules(groupRules, parser, groupBlocker)
+		if !ok {
+			continue
+		}
+		logFields["group"] = group
+		logrus.WithFields(logFields).Info("Client group rules updated")
+	}
+}
 
+// applyEnterpriseRules loads enterpriseRules into the blocker and block
+// page. It returns the blocklists left out, and false if the rules
+// couldn't be applied.
+func applyEnterpriseRules(enterpriseRules *rules.EnterpriseRules, parser *rules.Parser, blocker *dns.Blocker, httpsProxy *proxy.HTTPSProxy, caSelector *proxy.CASelector, networks *dns.NetworkManager) ([]string, bool) {
 	// Log device identity
 	logrus.WithFields(logrus.Fields{
 		"device": enterpriseRules.DeviceName,
 		"user":   enterpriseRules.UserEmail,
+		"source": enterpriseRules.UserSource,
 		"group":  enterpriseRules.GroupName,
 	}).Info("Device identity resolved")
 
 	// Update blocker metadata for logging
 	blocker.UpdateMetadata(enterpriseRules.UserEmail, enterpriseRules.GroupName)
 
+	// Switch to the group's CA if one is configured
+	caSelector.Apply(enterpriseRules.GetCAProfile())
+
+	// Apply the per-network profiles to the current and future networks
+	networks.SetNetworkProfiles(enterpriseRules.GetNetworkProfiles())
+
+	// Show the group's guidance on the block page
+	messaging := proxy.BlockPageMessaging{User: enterpriseRules.UserEmail}
+	if blockPage := enterpriseRules.GetBlockPage(); blockPage != nil {
+		messaging = proxy.BlockPageMessaging{
+			ContactEmail: blockPage.ContactEmail,
+			ContactURL:   blockPage.ContactURL,
+			PolicyURL:    blockPage.PolicyURL,
+			Message:      blockPage.Message,
+			Categories:   blockPage.Categories,
+			User:         enterpriseRules.UserEmail,
+		}
+	}
+	httpsProxy.SetBlockPageMessaging(messaging)
+
+	logFields, failedSources, ok := loadBlockerRules(enterpriseRules, parser, blocker)
+	if !ok {
+		return nil, false
+	}
+	logFields["user"] = enterpriseRules.UserEmail
+	logFields["group"] = enterpriseRules.GroupName
+
+	logrus.WithFields(logFields).Info("Enterprise rules updated")
+	return failedSources, true
+}
+
+// startThreatIntelUpdater polls the threat-intel feeds and loads their
+// domains into blockers until ctx is done
+func startThreatIntelUpdater(ctx context.Context, cfg *config.Config, blockers []*dns.Blocker) {
+	fetcher := rules.NewThreatIntelFetcher(&cfg.ThreatIntel, cfg.Rules.MaxDomains)
+	update := func() {
+		sources := fetcher.Fetch(ctx)
+		for _, b := range blockers {
+			if err := b.UpdateThreatIntel(sources); err != nil {
+				logrus.WithError(err).Error("Failed to update threat-intel domains")
+				return
+			}
+		}
+		logrus.WithFields(logrus.Fields{
+			"feeds":   len(cfg.ThreatIntel.Feeds),
+			"domains": len(sources),
+		}).Info("Threat-intel domains updated")
+	}
+
+	update()
+	ticker := time.NewTicker(cfg.ThreatIntel.UpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			update()
+		}
+	}
+}
+
+// startNRDUpdater fetches the newly registered domain feed and loads the
+// domains registered within nrd.maxAge into blockers until ctx is done
+func startNRDUpdater(ctx context.Context, cfg *config.Config, blockers []*dns.Blocker) {
+	parser := rules.NewParser()
+	parser.SetLimits(cfg.Rules.MaxFileSize, 0)
+	tracker := rules.NewNRDTracker(&cfg.NRD)
+
+	apply := func() {
+		domains := tracker.Domains(time.Now())
+		for _, b := range blockers {
+			if err := b.UpdateNRD(domains, cfg.NRD.Allow); err != nil {
+				logrus.WithError(err).Error("Failed to update newly registered domains")
+				return
+			}
+		}
+		logrus.WithField("domains", len(domains)).Info("Newly registered domains updated")
+	}
+
+	// Block what was already known before the first fetch
+	if err := tracker.Load(); err != nil {
+		logrus.WithError(err).Warn("Failed to load NRD state")
+	}
+	apply()
+
+	update := func() {
+		if err := tracker.Update(parser, time.Now()); err != nil {
+			logrus.WithError(err).Warn("Failed to fetch NRD feed")
+		}
+		if err := tracker.Save(); err != nil {
+			logrus.WithError(err).Warn("Failed to save NRD state")
+		}
+		apply()
+	}
+
+	update()
+	ticker := time.NewTicker(cfg.NRD.UpdateInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+			update()
+		}
+	}
+}
+
+// loadBlockerRules loads the merged block, allow, security, regex, bypass
+// and schedule rules of enterpriseRules into blocker. It returns fields
+// describing what was loaded for logging, and the external lists left out
+// because they failed or would pass rules.maxDomains.
+func loadBlockerRules(enterpriseRules *rules.EnterpriseRules, parser *rules.Parser, blocker *dns.Blocker) (logrus.Fields, []string, bool) {
 	// Merge rules according to precedence
 	blockDomains, allowDomains, allowOnlyMode := enterpriseRules.MergeRules()
 
 	// Get external block sources
 	blockSources := enterpriseRules.GetBlockSources()
 
-	// Fetch and parse external sources (only if not in allow-only mode)
+	// Collect block domains with deduplication, bounded by the configured limit
+	collector := rules.NewDomainCollector(parser.MaxDomains())
+	if err := collector.AddAll(blockDomains); err != nil {
+		logrus.WithError(err).Error("Failed to merge block domains")
+		return nil, nil, false
+	}
+
+	// Each external list streams into its own collector and is merged once
+	// it's complete, so a list that fails part way adds nothing
+	var failedSources []string
+	stream := func(into *rules.DomainCollector, source string, fields logrus.Fields, message string) {
+		list := rules.NewDomainCollector(parser.MaxDomains())
+		err := parser.FetchAndStreamURL(source, "", list.AddFrom(source))
+		if err == nil {
+			err = into.Merge(list)
+		}
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Warn(message)
+			failedSources = append(failedSources, fmt.Sprintf("%s: %v", source, err))
+		}
+	}
+
+	// Stream external sources (only if not in allow-only mode)
+	categorySources := enterpriseRules.GetCategorySources()
 	if !allowOnlyMode {
 		for _, source := range blockSources {
-			domains, err := parser.FetchAndParseURL(source)
-			if err != nil {
-				logrus.WithError(err).WithField("source", source).Warn("Failed to fetch source")
-				continue
-			}
-			blockDomains = append(blockDomains, domains...)
+			stream(collector, source, logrus.Fields{"source": source}, "Failed to fetch source")
+		}
+		// In a fixed order, so a domain on several category lists always
+		// reports the same one
+		sources := make([]string, 0, len(categorySources))
+		for source := range categorySources {
+			sources = append(sources, source)
+		}
+		sort.Strings(sources)
+		for _, source := range sources {
+			stream(collector, source, logrus.Fields{"source": source, "category": categorySources[source]}, "Failed to fetch category source")
 		}
 	}
 
-	// Deduplicate block domains
-	finalBlockDomains := rules.MergeDomains(blockDomains)
+	finalBlockDomains := collector.Domains()
+
+	// Security-critical lists are still needed in allow-only mode, where
+	// they can override the allowlist
+	precedence := enterpriseRules.GetAllowlistPrecedence()
+	if precedence != "" && precedence != dns.PrecedenceAllowlist && precedence != dns.PrecedenceSecurity {
+		logrus.WithField("precedence", precedence).Warn("Unknown allowlist_precedence, allowlist will win")
+		precedence = dns.PrecedenceAllowlist
+	}
+	securityDomains, securitySources := enterpriseRules.MergeSecurityRules()
+	securityCollector := rules.NewDomainCollector(parser.MaxDomains())
+	if err := securityCollector.AddAll(securityDomains); err != nil {
+		logrus.WithError(err).Error("Failed to merge security block domains")
+		return nil, nil, false
+	}
+	for _, source := range securitySources {
+		stream(securityCollector, source, logrus.Fields{"source": source}, "Failed to fetch security source")
+	}
 
 	// Update blocker
-	if err := blocker.UpdateDomains(finalBlockDomains); err != nil {
+	if err := blocker.UpdateDomainsWithSources(finalBlockDomains, collector.Sources()); err != nil {
 		logrus.WithError(err).Error("Failed to update blocked domains")
-		return
+		return nil, nil, false
+	}
+	if err := blocker.UpdateCategories(categorySources); err != nil {
+		logrus.WithError(err).Error("Failed to update block categories")
+		return nil, nil, false
 	}
 	if err := blocker.UpdateAllowlist(allowDomains); err != nil {
 		logrus.WithError(err).Error("Failed to update allowlist")
-		return
+		return nil, nil, false
+	}
+	regexRules := enterpriseRules.MergeRegexRules()
+	if err := blocker.UpdateRegexRules(regexRules); err != nil {
+		logrus.WithError(err).Error("Failed to update regex rules")
+		return nil, nil, false
+	}
+	if err := blocker.UpdateSecurityDomainsWithSources(securityCollector.Domains(), securityCollector.Sources()); err != nil {
+		logrus.WithError(err).Error("Failed to update security blocked domains")
+		return nil, nil, false
+	}
+	bypassEnabled, bypassDomains := false, 0
+	if bypass := enterpriseRules.GetBypassPrevention(); bypass != nil && bypass.Enabled != nil && *bypass.Enabled {
+		bypassCollector := rules.NewDomainCollector(parser.MaxDomains())
+		if err := bypassCollector.AddAll(bypass.Domains); err != nil {
+			logrus.WithError(err).Error("Failed to merge bypass prevention domains")
+			return nil, nil, false
+		}
+		for _, source := range bypass.Sources {
+			stream(bypassCollector, source, logrus.Fields{"source": source}, "Failed to fetch bypass prevention source")
+		}
+		bypassEnabled, bypassDomains = true, bypassCollector.Len()
+		if err := blocker.UpdateBypassPrevention(true, bypassCollector.Domains(), bypass.Exempt); err != nil {
+			logrus.WithError(err).Error("Failed to update bypass prevention")
+			return nil, nil, false
+		}
+	} else if err := blocker.UpdateBypassPrevention(false, nil, nil); err != nil {
+		logrus.WithError(err).Error("Failed to update bypass prevention")
+		return nil, nil, false
 	}
+	var schedules []*dns.Schedule
+	for _, scheduleCfg := range enterpriseRules.MergeSchedules() {
+		schedule, err := dns.NewSchedule(scheduleCfg)
+		if err != nil {
+			logrus.WithError(err).Warn("Skipping invalid schedule")
+			continue
+		}
+		schedules = append(schedules, schedule)
+	}
+	blocker.UpdateSchedules(schedules)
 	blocker.SetAllowOnlyMode(allowOnlyMode)
+	blocker.SetAllowlistPrecedence(precedence)
+	blocker.SetSafeSearch(enterpriseRules.GetEnforceSafeSearch())
+	enforcement := enterpriseRules.GetEnforcement()
+	if enforcement != "" && enforcement != config.EnforcementBlock && enforcement != config.EnforcementMonitor {
+		logrus.WithField("enforcement", enforcement).Warn("Unknown enforcement, rules will block")
+		enforcement = config.EnforcementBlock
+	}
+	blocker.SetEnforcement(enforcement)
 
 	logFields := logrus.Fields{
-		"blocked": len(finalBlockDomains),
-		"allowed": len(allowDomains),
-		"user":    enterpriseRules.UserEmail,
-		"group":   enterpriseRules.GroupName,
+		"blocked":    len(finalBlockDomains),
+		"security":   securityCollector.Len(),
+		"allowed":    len(allowDomains),
+		"regex":      len(regexRules),
+		"categories": len(blocker.CategoryHits()),
+		"schedules":  len(schedules),
+		"precedence": blocker.AllowlistPrecedence(),
 	}
 
 	if allowOnlyMode {
 		logFields["mode"] = "allow-only"
 	}
+	if blocker.SafeSearchEnabled() {
+		logFields["safesearch"] = true
+	}
+	if blocker.Monitoring() {
+		logFields["enforcement"] = config.EnforcementMonitor
+	}
+	if bypassEnabled {
+		logFields["bypass_prevention"] = len(dns.DefaultBypassDomains) + bypassDomains
+	}
+	if len(failedSources) > 0 {
+		logFields["failed_sources"] = len(failedSources)
+	}
 
-	logrus.WithFields(logFields).Info("Enterprise rules updated")
+	return logFields, failedSources, true
 }
 
 // logBinaryIntegrity logs information about the binary for tamper detection
@@ -518,6 +2059,93 @@ func logBinaryIntegrity() {
 	})
 }
 
+// dataPurgeInterval is how often audit events past their retention are
+// deleted
+const dataPurgeInterval = time.Hour
+
+// dataPurger deletes logged queries, audit events and events waiting for
+// remote logging, on request and once they pass their retention
+type dataPurger struct {
+	queryLog     *querylog.Log         // Nil when the query log is off
+	fallbackPath string                // Remote logging's disk buffer
+	remote       *logging.RemoteLogger // Nil when nothing has the buffer open
+	deviceUser   func() (user, group string)
+}
+
+// purge deletes the data req selects
+func (p *dataPurger) purge(req api.DataPurgeRequest) (*api.DataPurgeResult, error) {
+	filter := audit.PurgeFilter{Before: req.Before, User: req.User}
+	result := &api.DataPurgeResult{}
+	var errs []error
+
+	if p.queryLog != nil {
+		user, _ := p.deviceUser()
+		if req.User == "" || strings.EqualFold(req.User, user) {
+			before := req.Before
+			if before.IsZero() {
+				before = time.Now()
+			}
+			n, err := p.queryLog.Purge(before)
+			result.QueryLog = n
+			errs = append(errs, err)
+		} else {
+			result.QueryLogSkipped = true
+		}
+	}
+
+	n, err := audit.Purge(filter)
+	result.AuditEvents = n
+	errs = append(errs, err)
+
+	n, err = p.purgeBuffered(filter.Match)
+	result.BufferedEvents = n
+	errs = append(errs, err)
+
+	return result, errors.Join(errs...)
+}
+
+// purgeBuffered deletes the events match selects from those waiting for
+// remote logging
+func (p *dataPurger) purgeBuffered(match func(audit.Event) bool) (int, error) {
+	if p.remote != nil {
+		return p.remote.Purge(match)
+	}
+	return logging.PurgeDiskBuffer(p.fallbackPath, match)
+}
+
+// enforceRetention deletes audit events and undelivered events older than
+// retention until ctx is done. The query log enforces its own.
+func (p *dataPurger) enforceRetention(ctx context.Context, retention time.Duration) {
+	ticker := time.NewTicker(dataPurgeInterval)
+	defer ticker.Stop()
+
+	for {
+		filter := audit.PurgeFilter{Before: time.Now().Add(-retention)}
+		auditEvents, err := audit.Purge(filter)
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to delete audit events past their retention")
+		}
+		buffered, err := p.purgeBuffered(filter.Match)
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to delete buffered events past their retention")
+		}
+		if auditEvents > 0 || buffered > 0 {
+			audit.Log(audit.EventDataPurged, "info", "Logged data past its retention purged", map[string]interface{}{
+				"before":          filter.Before.UTC().Format(time.RFC3339),
+				"audit_events":    auditEvents,
+				"buffered_events": buffered,
+				"reason":          "retention",
+			})
+		}
+
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
+
 // getSecurityMode returns the current security mode
 func getSecurityMode() string {
 	if ca.UseKeychain() {
@@ -559,3 +2187,94 @@ func monitorDNSConfiguration(ctx context.Context) {
 		}
 	}
 }
+
+// newPolicyManager creates the managed policy manager for the configured
+// source. S3 policies live in the rules bucket.
+func newPolicyManager(cfg *config.Config) *policy.Manager {
+	if cfg.ManagedPolicy.Source != "s3" {
+		return policy.NewManager(&cfg.ManagedPolicy, policy.FileFetcher(cfg.ManagedPolicy.Path))
+	}
+
+	fetcher, err := rules.NewEnterpriseFetcher(&cfg.S3)
+	key := cfg.ManagedPolicy.S3Key
+	return policy.NewManager(&cfg.ManagedPolicy, func() ([]byte, error) {
+		if err != nil {
+			return nil, err
+		}
+		ctx, cancel := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancel()
+		return fetcher.FetchObject(ctx, key)
+	})
+}
+
+// apiConfig returns the settings exposed and enforced by the API, with the
+// managed policy's restrictions when p is set
+func apiConfig(cfg *config.Config, p *policy.Policy) *api.Config {
+	c := &api.Config{
+		AllowPause:      cfg.Agent.AllowDisable,
+		AllowQuit:       cfg.Agent.AllowDisable,
+		UpdateInterval:  int(cfg.S3.UpdateInterval / time.Minute),
+		MaxPauseSeconds: int(cfg.Agent.MaxPause / time.Second),
+	}
+	if p != nil {
+		c.AllowPause = p.AllowDisable
+		c.AllowQuit = p.AllowDisable
+		c.Managed = !p.AllowConfigChanges
+		if max := p.MaxPauseDuration(); max > 0 {
+			c.MaxPauseSeconds = int(max / time.Second)
+		}
+	}
+	return c
+}
+
+// apiRateLimitPolicy converts the api.rateLimit config section into the
+// API server's limiter policy
+func apiRateLimitPolicy(cfg config.APIRateLimitConfig) api.RateLimitPolicy {
+	convert := func(limit config.APIRateLimit) api.RateLimit {
+		return api.RateLimit{Requests: limit.Requests, Window: limit.Window, Burst: limit.Burst}
+	}
+
+	policy := api.RateLimitPolicy{
+		Default:   convert(cfg.APIRateLimit),
+		Endpoints: make(map[string]api.RateLimit),
+		Roles:     make(map[api.Role]api.RateLimit),
+	}
+	for path, limit := range cfg.Endpoints {
+		policy.Endpoints[path] = convert(limit)
+	}
+	for role, limit := range cfg.Roles {
+		policy.Roles[api.Role(role)] = convert(limit)
+	}
+	// Validated with the rest of the config
+	policy.TrustedProxies, _ = api.ParseAllowedIPs(cfg.TrustedProxies)
+	return policy
+}
+
+// apiTLSConfig loads the fleet CA for the API's mutual TLS listener, whose
+// server certificate is issued by issuer
+func apiTLSConfig(cfg config.APIMTLSConfig, issuer ca.Manager) (api.TLSConfig, error) {
+	data, err := os.ReadFile(cfg.ClientCA)
+	if err != nil {
+		return api.TLSConfig{}, fmt.Errorf("failed to read api.mtls.clientCA: %v", err)
+	}
+	clientCAs := x509.NewCertPool()
+	if !clientCAs.AppendCertsFromPEM(data) {
+		return api.TLSConfig{}, fmt.Errorf("no certificates in api.mtls.clientCA %s", cfg.ClientCA)
+	}
+
+	names := cfg.ServerNames
+	if len(names) == 0 {
+		hostname, err := os.Hostname()
+		if err != nil {
+			return api.TLSConfig{}, fmt.Errorf("failed to get host name for the API certificate: %v", err)
+		}
+		names = []string{hostname}
+	}
+	return api.TLSConfig{
+		Address:     cfg.Listen,
+		Issuer:      issuer,
+		ServerNames: names,
+		ClientCAs:   clientCAs,
+		Role:        api.Role(cfg.Role),
+	}, nil
+}
@@ -0,0 +1,135 @@
+package cmd
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"os"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/rules"
+	"dnshield/internal/scim"
+
+	"github.com/sirupsen/logrus"
+	"github.com/spf13/cobra"
+)
+
+// SCIMTokenEnv holds the bearer token the identity provider authenticates with
+const SCIMTokenEnv = "DNSHIELD_SCIM_TOKEN"
+
+// NewSCIMCmd creates the scim command
+func NewSCIMCmd() *cobra.Command {
+	scimCmd := &cobra.Command{
+		Use:   "scim",
+		Short: "Sync user and group assignments from an identity provider",
+		Long: `Receive users and groups from Okta or Azure AD over SCIM 2.0 and write
+users/user-groups.yaml and users/device-mapping.yaml to the rules store, so
+assignments are managed in the identity provider instead of by hand.
+
+This runs on an administrator's server, not on devices, with credentials
+that can write to the rules store.`,
+	}
+
+	var configFile, listen, certFile, keyFile, statePath, signingKeyFile string
+	var groups []string
+	var delay time.Duration
+	serveCmd := &cobra.Command{
+		Use:   "serve",
+		Short: "Serve the SCIM endpoints and publish each change",
+		Long: `Serve SCIM 2.0 at ` + scim.BasePath + ` for the identity provider's provisioning
+app, authenticated with the bearer token in ` + SCIMTokenEnv + `.
+
+Each active user is assigned to the first of their groups in --groups, or
+the first by name when --groups is not set; groups named in --groups are
+the only ones published when it is. A group's name in the rules store is
+its display name in lower case, with spaces replaced by -. Devices come
+from the devices attribute of the ` + scim.DeviceSchema + `
+extension, which the provisioning app maps from a user profile attribute
+such as the serial numbers of the user's Macs.
+
+Users and groups are kept in --state so a restart doesn't need a full
+import. Files are written once changes stop for --delay, and only when
+their content changed. When devices require signed files (s3.signingKeys),
+each file is signed with --signing-key, a key from dnshield rules keygen.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			token := os.Getenv(SCIMTokenEnv)
+			if token == "" {
+				return fmt.Errorf("set the SCIM bearer token in %s", SCIMTokenEnv)
+			}
+			if (certFile == "") != (keyFile == "") {
+				return fmt.Errorf("--tls-cert and --tls-key must be given together")
+			}
+			cfg, err := config.LoadConfig(configFile)
+			if err != nil {
+				return fmt.Errorf("failed to load config: %v", err)
+			}
+			if !cfg.S3.Configured() {
+				return fmt.Errorf("no rules store configured in s3.bucket or s3.url")
+			}
+			fetcher, err := rules.NewEnterpriseFetcher(&cfg.S3)
+			if err != nil {
+				return err
+			}
+			dir, err := scim.OpenDirectory(statePath)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+			publisher := scim.NewPublisher(dir, fetcher.PutObject, cfg.S3.Paths, groups, delay)
+			if signingKeyFile != "" {
+				signingKey, err := readSigningKey(signingKeyFile)
+				if err != nil {
+					return err
+				}
+				publisher.SetSigningKey(signingKey)
+			} else if len(cfg.S3.SigningKeys) > 0 {
+				return fmt.Errorf("s3.signingKeys is set, so devices only accept signed files; pass --signing-key")
+			}
+			go publisher.Run(ctx)
+
+			mux := http.NewServeMux()
+			mux.Handle(scim.BasePath+"/", scim.NewServer(dir, token))
+			server := &http.Server{
+				Addr:              listen,
+				Handler:           mux,
+				ReadHeaderTimeout: 10 * time.Second,
+				ReadTimeout:       30 * time.Second,
+				WriteTimeout:      30 * time.Second,
+			}
+			go func() {
+				<-ctx.Done()
+				shutdownCtx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+				defer cancel()
+				server.Shutdown(shutdownCtx)
+			}()
+
+			logrus.WithFields(logrus.Fields{"listen": listen, "store": rules.StoreURL(&cfg.S3)}).Info("Serving SCIM")
+			if certFile != "" {
+				err = server.ListenAndServeTLS(certFile, keyFile)
+			} else {
+				err = server.ListenAndServe()
+			}
+			if err == http.ErrServerClosed {
+				return nil
+			}
+			return err
+		},
+	}
+	serveCmd.Flags().StringVarP(&configFile, "config", "c", "", "config file with the rules store in s3")
+	serveCmd.Flags().StringVar(&listen, "listen", ":8443", "address to serve SCIM on")
+	serveCmd.Flags().StringVar(&certFile, "tls-cert", "", "TLS certificate; serve plain HTTP behind a TLS proxy when not set")
+	serveCmd.Flags().StringVar(&keyFile, "tls-key", "", "TLS private key")
+	serveCmd.Flags().StringVar(&statePath, "state", "scim-state.json", "file the users and groups are kept in")
+	serveCmd.Flags().StringSliceVar(&groups, "groups", nil, "groups to publish, in precedence order (default all)")
+	serveCmd.Flags().DurationVar(&delay, "delay", 10*time.Second, "wait for changes to stop this long before publishing")
+	serveCmd.Flags().StringVar(&signingKeyFile, "signing-key", "", "private key to sign the published files with")
+
+	scimCmd.AddCommand(serveCmd)
+	return scimCmd
+}
@@ -6,6 +6,7 @@ import (
 	"os"
 	"time"
 
+	"dnshield/internal/api"
 	"dnshield/internal/ca"
 
 	"github.com/miekg/dns"
@@ -80,6 +81,10 @@ func runStatus(cmd *cobra.Command, args []string) error {
 		fmt.Println("❌ HTTPS server is not running")
 	}
 
+	// Check enterprise rules
+	fmt.Println("\n📋 Enterprise Rules:")
+	printRulesStatus()
+
 	// Overall status
 	fmt.Println("\n📊 Overall Status:")
 	if checkPort(53) && checkPort(80) && checkPort(443) {
@@ -96,6 +101,38 @@ func runStatus(cmd *cobra.Command, args []string) error {
 	return nil
 }
 
+// printRulesStatus reports where the running agent's rules came from and
+// whether they are stale
+func printRulesStatus() {
+	key, err := resolveAPIKey("")
+	var status api.Status
+	if err == nil {
+		err = api.NewClient(key).Get("/api/status", &status)
+	}
+	switch {
+	case err != nil:
+		fmt.Printf("⚠️  Could not query the agent: %v\n", err)
+		return
+	case status.RulesSource == "":
+		fmt.Println("ℹ️  No S3 bucket configured")
+		return
+	case status.RulesSource == "none":
+		fmt.Println("❌ No enterprise rules loaded (S3 unreachable and no cache)")
+		return
+	}
+
+	age := time.Since(status.RulesFetchedAt).Round(time.Minute)
+	source := "S3"
+	if status.RulesSource == "cache" {
+		source = "the local cache (S3 unreachable)"
+	}
+	if status.RulesStale {
+		fmt.Printf("⚠️  Rules from %s are stale: fetched %s ago\n", source, age)
+	} else {
+		fmt.Printf("✅ Rules from %s, fetched %s ago\n", source, age)
+	}
+}
+
 func checkPort(port int) bool {
 	conn, err := net.DialTimeout("tcp", fmt.Sprintf("127.0.0.1:%d", port), 1*time.Second)
 	if err != nil {
@@ -0,0 +1,201 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+
+	"dnshield/internal/api"
+	"dnshield/internal/audit"
+	"dnshield/internal/dns"
+
+	"github.com/spf13/cobra"
+)
+
+// blockEventTypes are the audit events --blocked-only keeps
+var blockEventTypes = map[audit.EventType]bool{
+	audit.EventDomainBlocked:   true,
+	audit.EventDomainMonitored: true,
+	audit.EventBlockPageServed: true,
+	audit.EventSinkholeRequest: true,
+	audit.EventDGADetected:     true,
+	audit.EventDNSTunnel:       true,
+	audit.EventHomograph:       true,
+}
+
+// tailFilter selects which streamed events tail prints
+type tailFilter struct {
+	blockedOnly bool
+	domain      string
+	client      string
+}
+
+// matchQuery reports whether a query passes every filter that is set
+func (f *tailFilter) matchQuery(event *api.QueryStreamEvent) bool {
+	if f.blockedOnly && event.Action != dns.QueryActionBlocked {
+		return false
+	}
+	return f.matchTarget(event.Domain, event.ClientIP)
+}
+
+// matchAudit reports whether an audit event passes every filter that is
+// set. Events without a domain or client don't pass those filters.
+func (f *tailFilter) matchAudit(event *audit.Event) bool {
+	if f.blockedOnly && !blockEventTypes[event.Type] {
+		return false
+	}
+	domain, _ := event.Details["domain"].(string)
+	client, _ := event.Details["client_ip"].(string)
+	return f.matchTarget(domain, client)
+}
+
+func (f *tailFilter) matchTarget(domain, client string) bool {
+	if f.domain != "" && !strings.Contains(strings.ToLower(domain), f.domain) {
+		return false
+	}
+	return f.client == "" || client == f.client
+}
+
+// NewTailCmd creates the tail command
+func NewTailCmd() *cobra.Command {
+	var (
+		apiKey string
+		asJSON bool
+	)
+	filter := &tailFilter{}
+
+	tailCmd := &cobra.Command{
+		Use:   "tail",
+		Short: "Stream queries, blocks and audit events from the running agent",
+		Long: `Print what the running agent does as it happens, for troubleshooting: every
+query it answers, with the rule that blocked it, and every audit event, such
+as detections, block page visits, pauses and rule updates. Blocks of DNS
+queries show once, as the blocked query.
+
+  dnshield tail --blocked-only --client 192.168.1.20
+
+shows only what was blocked for one client. --domain keeps events for names
+containing the text. Use --json to print one JSON object per line, with a
+"type" of query or audit, for scripting.
+
+The API key is taken from --api-key, then DNSHIELD_API_KEY; without one, the
+API socket identifies the user. It needs the queries:stream permission
+(admin or operator).`,
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter.domain = strings.ToLower(filter.domain)
+
+			key, err := resolveAPIKey(apiKey)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return runTail(ctx, api.NewClient(key), filter, asJSON, os.Stdout)
+		},
+	}
+
+	tailCmd.Flags().StringVar(&apiKey, "api-key", "", "API key to authenticate with")
+	tailCmd.Flags().BoolVar(&filter.blockedOnly, "blocked-only", false, "Only show blocked queries and block events")
+	tailCmd.Flags().StringVar(&filter.domain, "domain", "", "Only show domains containing this text")
+	tailCmd.Flags().StringVar(&filter.client, "client", "", "Only show events for this client IP")
+	tailCmd.Flags().BoolVar(&asJSON, "json", false, "Print one JSON object per line")
+
+	return tailCmd
+}
+
+func runTail(ctx context.Context, client *api.Client, filter *tailFilter, asJSON bool, out io.Writer) error {
+	stream, err := client.Stream(ctx, api.EventStreamPath)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	encoder := json.NewEncoder(out)
+	err = readStreamEvents(stream, func(name string, data []byte) error {
+		switch name {
+		case api.StreamEventQuery:
+			var event api.QueryStreamEvent
+			if json.Unmarshal(data, &event) != nil || !filter.matchQuery(&event) {
+				return nil
+			}
+			if asJSON {
+				return encoder.Encode(map[string]interface{}{"type": name, "event": event})
+			}
+			line := formatQueryLine(&event)
+			if event.Rule != "" {
+				line += "  " + event.Rule
+			}
+			_, err := fmt.Fprintln(out, line)
+			return err
+
+		case api.StreamEventAudit:
+			var event audit.Event
+			if json.Unmarshal(data, &event) != nil || !filter.matchAudit(&event) {
+				return nil
+			}
+			if queryType, _ := event.Details["query_type"].(string); event.Type == audit.EventDomainBlocked && !strings.HasPrefix(queryType, "FLOW/") {
+				return nil // Shown as the blocked query
+			}
+			if asJSON {
+				return encoder.Encode(map[string]interface{}{"type": name, "event": event})
+			}
+			_, err := fmt.Fprintf(out, "%-12s %-9s %s: %s\n",
+				event.Timestamp.Local().Format("15:04:05.000"), strings.ToLower(event.Severity), event.Type, event.Message)
+			return err
+		}
+		return nil
+	})
+
+	// Ctrl-C cancels the request, which surfaces as a read error
+	if err != nil && ctx.Err() == nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("event stream interrupted: %v", err)
+	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("agent closed the event stream")
+	}
+	return nil
+}
+
+// readStreamEvents calls fn with the name and data of each server-sent
+// event in r until r ends or fn fails. Comments, such as keepalives, are
+// skipped.
+func readStreamEvents(r io.Reader, fn func(name string, data []byte) error) error {
+	scanner := bufio.NewScanner(r)
+	scanner.Buffer(make([]byte, 64*1024), 1<<20)
+	var name string
+	var data []byte
+	hasData := false
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "":
+			if hasData {
+				if err := fn(name, data); err != nil {
+					return err
+				}
+			}
+			name, data, hasData = "", nil, false
+		case strings.HasPrefix(line, ":"):
+		case strings.HasPrefix(line, "event:"):
+			name = strings.TrimSpace(strings.TrimPrefix(line, "event:"))
+		case strings.HasPrefix(line, "data:"):
+			if hasData {
+				data = append(data, '\n')
+			}
+			data = append(data, strings.TrimPrefix(strings.TrimPrefix(line, "data:"), " ")...)
+			hasData = true
+		}
+	}
+	return scanner.Err()
+}
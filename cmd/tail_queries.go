@@ -0,0 +1,169 @@
+package cmd
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"os/signal"
+	"strings"
+	"syscall"
+	"time"
+
+	"dnshield/internal/api"
+
+	"github.com/spf13/cobra"
+)
+
+// queryFilter selects which streamed queries tail-queries prints
+type queryFilter struct {
+	domain     string
+	actions    map[string]bool
+	clientIP   string
+	queryType  string
+	minLatency time.Duration
+}
+
+// match reports whether event passes every filter that is set
+func (f *queryFilter) match(event *api.QueryStreamEvent) bool {
+	if f.domain != "" && !strings.Contains(strings.ToLower(event.Domain), f.domain) {
+		return false
+	}
+	if len(f.actions) > 0 && !f.actions[strings.ToLower(event.Action)] {
+		return false
+	}
+	if f.clientIP != "" && event.ClientIP != f.clientIP {
+		return false
+	}
+	if f.queryType != "" && !strings.EqualFold(event.QueryType, f.queryType) {
+		return false
+	}
+	if f.minLatency > 0 && event.DurationMs < float64(f.minLatency.Microseconds())/1000 {
+		return false
+	}
+	return true
+}
+
+// NewTailQueriesCmd creates the tail-queries command
+func NewTailQueriesCmd() *cobra.Command {
+	var (
+		apiKey  string
+		actions string
+		asJSON  bool
+	)
+	filter := &queryFilter{}
+
+	tailCmd := &cobra.Command{
+		Use:   "tail-queries",
+		Short: "Stream queries answered by the running agent",
+		Long: `Print each DNS query as the running agent answers it, like tcpdump for
+the resolver. Each line shows the time, action, query type, domain, client
+and how long the answer took. Filters combine, so
+
+  dnshield tail-queries --action blocked --domain example.com
+
+shows only blocked queries for names containing example.com. Use --json to
+print one JSON object per line for scripting.
+
+The API key is taken from --api-key, then DNSHIELD_API_KEY; without one, the
+API socket identifies the user. It needs the queries:stream permission
+(admin or operator).`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			filter.domain = strings.ToLower(filter.domain)
+			if actions != "" {
+				filter.actions = make(map[string]bool)
+				for _, a := range strings.Split(actions, ",") {
+					if a = strings.TrimSpace(strings.ToLower(a)); a != "" {
+						filter.actions[a] = true
+					}
+				}
+			}
+
+			key, err := resolveAPIKey(apiKey)
+			if err != nil {
+				return err
+			}
+
+			ctx, stop := signal.NotifyContext(context.Background(), os.Interrupt, syscall.SIGTERM)
+			defer stop()
+
+			return runTailQueries(ctx, api.NewClient(key), filter, asJSON, os.Stdout)
+		},
+	}
+
+	tailCmd.Flags().StringVar(&apiKey, "api-key", "", "API key to authenticate with")
+	tailCmd.Flags().StringVar(&filter.domain, "domain", "", "Only show domains containing this text")
+	tailCmd.Flags().StringVar(&actions, "action", "", "Only show these actions, comma separated (e.g. blocked,allowed)")
+	tailCmd.Flags().StringVar(&filter.clientIP, "client", "", "Only show queries from this client IP")
+	tailCmd.Flags().StringVarP(&filter.queryType, "type", "t", "", "Only show this query type (e.g. AAAA)")
+	tailCmd.Flags().DurationVar(&filter.minLatency, "min-latency", 0, "Only show queries slower than this")
+	tailCmd.Flags().BoolVar(&asJSON, "json", false, "Print one JSON object per line")
+
+	return tailCmd
+}
+
+func runTailQueries(ctx context.Context, client *api.Client, filter *queryFilter, asJSON bool, out io.Writer) error {
+	stream, err := client.Stream(ctx, api.QueryStreamPath)
+	if err != nil {
+		return err
+	}
+	defer stream.Close()
+
+	if !asJSON {
+		fmt.Fprintf(out, "%-12s %-9s %-6s %-40s %-15s %s\n", "TIME", "ACTION", "TYPE", "DOMAIN", "CLIENT", "LATENCY")
+	}
+
+	encoder := json.NewEncoder(out)
+	scanner := bufio.NewScanner(stream)
+	for scanner.Scan() {
+		line := scanner.Bytes()
+		if len(line) == 0 {
+			// Keepalive
+			continue
+		}
+
+		var event api.QueryStreamEvent
+		if err := json.Unmarshal(line, &event); err != nil {
+			continue
+		}
+		if !filter.match(&event) {
+			continue
+		}
+
+		if asJSON {
+			if err := encoder.Encode(&event); err != nil {
+				return err
+			}
+			continue
+		}
+		fmt.Fprintln(out, formatQueryLine(&event))
+	}
+
+	// Ctrl-C cancels the request, which surfaces as a read error
+	if err := scanner.Err(); err != nil && ctx.Err() == nil && !errors.Is(err, context.Canceled) {
+		return fmt.Errorf("query stream interrupted: %v", err)
+	}
+	if ctx.Err() == nil {
+		return fmt.Errorf("agent closed the query stream")
+	}
+	return nil
+}
+
+// formatQueryLine renders event as one aligned line
+func formatQueryLine(event *api.QueryStreamEvent) string {
+	action := event.Action
+	if event.Rcode != "" && event.Rcode != "NOERROR" {
+		action = fmt.Sprintf("%s/%s", event.Action, event.Rcode)
+	}
+	client := event.ClientIP
+	if client == "" {
+		client = "-"
+	}
+	return fmt.Sprintf("%-12s %-9s %-6s %-40s %-15s %.1fms",
+		event.Timestamp.Local().Format("15:04:05.000"),
+		action, event.QueryType, event.Domain, client, event.DurationMs)
+}
@@ -0,0 +1,290 @@
+package cmd
+
+import (
+	"fmt"
+	"io"
+	"strings"
+	"time"
+
+	"dnshield/internal/api"
+
+	tea "github.com/charmbracelet/bubbletea"
+	"github.com/charmbracelet/lipgloss"
+	"github.com/spf13/cobra"
+)
+
+// topHeading styles the dashboard's title and column headings
+var topHeading = lipgloss.NewStyle().Bold(true)
+
+// NewTopCmd creates the top command
+func NewTopCmd() *cobra.Command {
+	var (
+		apiKey   string
+		interval time.Duration
+	)
+
+	topCmd := &cobra.Command{
+		Use:   "top",
+		Short: "Live terminal dashboard for the running agent",
+		Long: `Show live query rate, block rate, cache hit ratio, top domains, blocked
+domains and clients, upstream health, the rules version in use and recent
+blocks, refreshed in place. Works over SSH when the menu bar app isn't
+available.
+
+Keys: q quits, p or space pauses and resumes refreshing, r refreshes now,
+and + and - double or halve the refresh interval.
+
+An upstream shows "ok" when it answered in the last minute and "idle"
+otherwise; SERVFAIL/s counts queries every upstream failed.
+
+The API key is taken from --api-key, then DNSHIELD_API_KEY; without one, the
+API socket identifies the user. It needs the stats:view permission.`,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if interval < minTopInterval {
+				return fmt.Errorf("--interval must be at least 1s")
+			}
+
+			key, err := resolveAPIKey(apiKey)
+			if err != nil {
+				return err
+			}
+
+			return runTop(api.NewClient(key), interval)
+		},
+	}
+
+	topCmd.Flags().StringVar(&apiKey, "api-key", "", "API key to authenticate with")
+	topCmd.Flags().DurationVarP(&interval, "interval", "n", 2*time.Second, "Refresh interval")
+
+	return topCmd
+}
+
+const (
+	// minTopInterval is the shortest refresh interval the dashboard offers
+	minTopInterval = time.Second
+	maxTopInterval = time.Minute
+	// upstreamIdleAfter is how long after its last answer an upstream is
+	// shown as idle rather than ok
+	upstreamIdleAfter = time.Minute
+)
+
+// topView is what a dashboard frame shows
+type topView struct {
+	stats         *api.TopStats
+	qps, bps, fps float64 // Queries, blocks and failures per second
+	paused        bool
+	interval      time.Duration
+	err           error // Last refresh failure, shown under the frame
+}
+
+// topModel is the dashboard's bubbletea model
+type topModel struct {
+	client     *api.Client
+	view       topView
+	previousAt time.Time // When view.stats was fetched
+	cols       int       // Terminal width, 0 until known
+	tick       int       // Generation of the refresh ticker, see topTickMsg
+	err        error     // Failure of the first refresh, which ends the dashboard
+}
+
+// topStatsMsg is the result of a refresh
+type topStatsMsg struct {
+	stats *api.TopStats
+	at    time.Time
+	err   error
+}
+
+// topTickMsg asks for a refresh. Ticks of an earlier generation, from
+// before the interval changed, are dropped.
+type topTickMsg int
+
+func runTop(client *api.Client, interval time.Duration) error {
+	final, err := tea.NewProgram(&topModel{client: client, view: topView{interval: interval}}, tea.WithAltScreen()).Run()
+	if err != nil {
+		return err
+	}
+	return final.(*topModel).err
+}
+
+func (m *topModel) Init() tea.Cmd {
+	return tea.Batch(m.refresh, m.nextTick())
+}
+
+// refresh fetches the dashboard's statistics
+func (m *topModel) refresh() tea.Msg {
+	var stats api.TopStats
+	if err := m.client.Get("/api/top", &stats); err != nil {
+		return topStatsMsg{err: err}
+	}
+	return topStatsMsg{stats: &stats, at: time.Now()}
+}
+
+// nextTick schedules the next refresh of the current generation
+func (m *topModel) nextTick() tea.Cmd {
+	tick := topTickMsg(m.tick)
+	return tea.Tick(m.view.interval, func(time.Time) tea.Msg { return tick })
+}
+
+// setInterval changes the refresh interval, starting a new generation of
+// ticks
+func (m *topModel) setInterval(interval time.Duration) tea.Cmd {
+	m.view.interval = interval
+	m.tick++
+	return m.nextTick()
+}
+
+func (m *topModel) Update(msg tea.Msg) (tea.Model, tea.Cmd) {
+	switch msg := msg.(type) {
+	case tea.WindowSizeMsg:
+		m.cols = msg.Width
+	case topTickMsg:
+		if int(msg) != m.tick {
+			return m, nil
+		}
+		next := m.nextTick()
+		if m.view.paused {
+			return m, next
+		}
+		return m, tea.Batch(m.refresh, next)
+	case topStatsMsg:
+		return m, m.update(msg)
+	case tea.KeyMsg:
+		switch msg.String() {
+		case "q", "Q", "ctrl+c":
+			return m, tea.Quit
+		case "p", "P", " ":
+			if m.view.paused = !m.view.paused; !m.view.paused {
+				return m, m.refresh
+			}
+		case "r", "R":
+			if !m.view.paused {
+				return m, m.refresh
+			}
+		case "+", "=":
+			return m, m.setInterval(min(m.view.interval*2, maxTopInterval))
+		case "-", "_":
+			return m, m.setInterval(max(m.view.interval/2, minTopInterval))
+		}
+	}
+	return m, nil
+}
+
+// update applies a refresh to the view
+func (m *topModel) update(msg topStatsMsg) tea.Cmd {
+	if msg.err != nil {
+		// Keep the first failure fatal so a bad key is reported at once,
+		// but ride out transient errors once the dashboard is up
+		if m.view.stats == nil {
+			m.err = msg.err
+			return tea.Quit
+		}
+		m.view.err = msg.err
+		return nil
+	}
+	if previous := m.view.stats; previous != nil {
+		elapsed := msg.at.Sub(m.previousAt).Seconds()
+		m.view.qps = float64(msg.stats.Statistics.QueriesTotal-previous.Statistics.QueriesTotal) / elapsed
+		m.view.bps = float64(msg.stats.Statistics.QueriesBlocked-previous.Statistics.QueriesBlocked) / elapsed
+		m.view.fps = float64(msg.stats.FailedQueries-previous.FailedQueries) / elapsed
+	}
+	m.view.stats, m.view.err = msg.stats, nil
+	m.previousAt = msg.at
+	return nil
+}
+
+func (m *topModel) View() string {
+	if m.view.stats == nil {
+		return ""
+	}
+	var b strings.Builder
+	renderTop(&b, &m.view, time.Now(), m.cols)
+	return b.String()
+}
+
+// renderTop writes a single frame of the dashboard, fitting the domain
+// columns to cols when the terminal width is known
+func renderTop(w io.Writer, view *topView, now time.Time, cols int) {
+	top := view.stats
+	stats := top.Statistics
+
+	blockRate := 0.0
+	if stats.QueriesTotal > 0 {
+		blockRate = float64(stats.QueriesBlocked) / float64(stats.QueriesTotal) * 100
+	}
+	nameWidth := 44
+	if cols > 0 {
+		nameWidth = min(max((cols-25)/2, 20), 44)
+	}
+
+	state := fmt.Sprintf("every %s", view.interval)
+	if view.paused {
+		state = "PAUSED"
+	}
+	fmt.Fprintf(w, "%s  %s  uptime %s  mem %.1f MB  [%s]\n",
+		topHeading.Render("DNShield top"), now.Format("15:04:05"), stats.Uptime, stats.MemoryUsageMB, state)
+	rules := "none"
+	if top.RulesVersion != "" {
+		rules = top.RulesVersion
+		if top.RulesSource != "" {
+			rules += " from " + top.RulesSource
+		}
+		if !top.RulesFetchedAt.IsZero() {
+			rules += fmt.Sprintf(", fetched %s ago", now.Sub(top.RulesFetchedAt).Round(time.Second))
+		}
+	}
+	fmt.Fprintf(w, "Rules %s\n\n", rules)
+	fmt.Fprintf(w, "QPS %8.1f   blocked/s %6.1f   block rate %5.1f%%   cache hit %5.1f%%   SERVFAIL/s %5.1f\n",
+		view.qps, view.bps, blockRate, stats.CacheHitRate, view.fps)
+	fmt.Fprintf(w, "Queries %d   blocked %d   cache hits %d   misses %d   failed %d\n\n",
+		stats.QueriesTotal, stats.QueriesBlocked, stats.CacheHits, stats.CacheMisses, top.FailedQueries)
+
+	fmt.Fprintln(w, topHeading.Render(fmt.Sprintf("%-*s %8s   %-*s %8s", nameWidth, "TOP DOMAINS", "QUERIES", nameWidth, "TOP BLOCKED", "BLOCKS")))
+	for i := 0; i < len(top.TopDomains) || i < len(top.TopBlocked); i++ {
+		left, right := "", ""
+		if i < len(top.TopDomains) {
+			left = fmt.Sprintf("%-*s %8d", nameWidth, truncate(top.TopDomains[i].Domain, nameWidth), top.TopDomains[i].Count)
+		}
+		if i < len(top.TopBlocked) {
+			right = fmt.Sprintf("%-*s %8d", nameWidth, truncate(top.TopBlocked[i].Domain, nameWidth), top.TopBlocked[i].Count)
+		}
+		fmt.Fprintf(w, "%-*s   %s\n", nameWidth+9, left, right)
+	}
+
+	fmt.Fprintf(w, "\n%s\n", topHeading.Render(fmt.Sprintf("%-*s %8s   %-*s %6s %8s %8s", nameWidth, "TOP CLIENTS", "QUERIES",
+		nameWidth-9, "UPSTREAM", "STATUS", "AVG ms", "LAST ms")))
+	for i := 0; i < len(top.TopClients) || i < len(top.Upstreams); i++ {
+		left, right := "", ""
+		if i < len(top.TopClients) {
+			left = fmt.Sprintf("%-*s %8d", nameWidth, truncate(top.TopClients[i].Client, nameWidth), top.TopClients[i].Count)
+		}
+		if i < len(top.Upstreams) {
+			u := top.Upstreams[i]
+			health := "ok"
+			if now.Sub(u.LastAnswer) > upstreamIdleAfter {
+				health = "idle"
+			}
+			right = fmt.Sprintf("%-*s %6s %8.1f %8.1f", nameWidth-9, truncate(u.Upstream, nameWidth-9), health, u.AvgLatencyMs, u.LastLatencyMs)
+		}
+		fmt.Fprintf(w, "%-*s   %s\n", nameWidth+9, left, right)
+	}
+
+	fmt.Fprintf(w, "\n%s\n", topHeading.Render(fmt.Sprintf("%-8s %-40s %-6s %-15s %s", "TIME", "RECENT BLOCKS", "TYPE", "CLIENT", "SOURCE")))
+	for i := len(top.RecentBlocked) - 1; i >= 0; i-- {
+		b := top.RecentBlocked[i]
+		fmt.Fprintf(w, "%-8s %-40s %-6s %-15s %s\n",
+			b.Timestamp.Local().Format("15:04:05"), truncate(b.Domain, 40), b.QueryType, b.ClientIP, truncate(b.RuleSource, 40))
+	}
+
+	if view.err != nil {
+		fmt.Fprintf(w, "\n%v\n", view.err)
+	}
+	fmt.Fprint(w, "\nq quit  p pause  r refresh  +/- interval")
+}
+
+// truncate shortens s to at most n characters, marking the cut with "…"
+func truncate(s string, n int) string {
+	if len(s) <= n {
+		return s
+	}
+	return s[:n-1] + "…"
+}
@@ -0,0 +1,217 @@
+package cmd
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"dnshield/internal/api"
+	"dnshield/internal/unblock"
+
+	"github.com/spf13/cobra"
+)
+
+// NewUnblockCmd creates the unblock command
+func NewUnblockCmd() *cobra.Command {
+	var apiKey string
+
+	unblockCmd := &cobra.Command{
+		Use:   "unblock",
+		Short: "Request access to blocked domains, approve requests and bypass blocks",
+		Long: `Ask the security team for access to a blocked domain, and answer
+those requests with signed approvals.
+
+Requests (also available on the block page) are delivered to the
+unblock.webhookURL and/or the unblock.s3Prefix folder of the rules bucket.
+The security team answers with an approval code made by "unblock approve",
+signed with a key from "dnshield policy keygen" whose public half is set as
+unblock.approvalPublicKey. Applying the code on the block page or with
+"unblock apply" allows the domain until the approval expires.
+
+Where domainBypass is enabled, "unblock bypass" lets a single blocked
+domain through for a few minutes with a justification, and an admin code
+when domainBypass.requireCode is set. Codes come from an authenticator app
+enrolled with the secret from "unblock totp-secret".
+
+request, apply, bypass, end and list call the running agent's API. The
+API key is taken from --api-key, then DNSHIELD_API_KEY, then the local key
+store.`,
+	}
+
+	var justification string
+	requestCmd := &cobra.Command{
+		Use:          "request <domain>",
+		Short:        "Ask the security team for access to a blocked domain",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var result api.UnblockRequestResult
+			req := api.UnblockRequest{Domain: args[0], Justification: justification}
+			if err := unblockAPIRequest(apiKey, http.MethodPost, api.UnblockRequestPath, req, &result); err != nil {
+				return err
+			}
+			fmt.Printf("📨 Request sent to the security team (reference %s)\n", result.ID)
+			return nil
+		},
+	}
+	requestCmd.Flags().StringVarP(&justification, "justification", "j", "", "Why you need access")
+	requestCmd.MarkFlagRequired("justification")
+
+	applyCmd := &cobra.Command{
+		Use:          "apply <approval-code>",
+		Short:        "Apply an approval code from the security team",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var result api.UnblockApprovalResult
+			if err := unblockAPIRequest(apiKey, http.MethodPost, api.UnblockApprovePath, api.UnblockApproval{Token: args[0]}, &result); err != nil {
+				return err
+			}
+			fmt.Printf("✅ %s is allowed until %s\n", result.Domain, result.Until.Local().Format("2006-01-02 15:04"))
+			return nil
+		},
+	}
+
+	var keyFile, device, approver, requestID string
+	var duration time.Duration
+	approveCmd := &cobra.Command{
+		Use:          "approve <domain>",
+		Short:        "Sign an approval code that temporarily allows a domain",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			if duration <= 0 {
+				return fmt.Errorf("--duration must be positive")
+			}
+			keyData, err := os.ReadFile(keyFile)
+			if err != nil {
+				return fmt.Errorf("failed to read private key: %v", err)
+			}
+			key, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(keyData)))
+			if err != nil || len(key) != ed25519.PrivateKeySize {
+				return fmt.Errorf("invalid private key in %s", keyFile)
+			}
+
+			token, err := unblock.SignApproval(&unblock.Approval{
+				Domain:    args[0],
+				Device:    device,
+				ExpiresAt: time.Now().Add(duration).UTC().Truncate(time.Second),
+				Approver:  approver,
+				RequestID: requestID,
+			}, ed25519.PrivateKey(key))
+			if err != nil {
+				return err
+			}
+			fmt.Println(token)
+			return nil
+		},
+	}
+	approveCmd.Flags().StringVarP(&keyFile, "key", "k", "approval-signing.key", "Private key file")
+	approveCmd.Flags().DurationVarP(&duration, "duration", "d", 4*time.Hour, "How long the domain is allowed (capped by unblock.maxAllowDuration)")
+	approveCmd.Flags().StringVar(&device, "device", "", "Hostname the code is valid on (default: any device)")
+	approveCmd.Flags().StringVar(&approver, "approver", "", "Who approved the request, for the audit log")
+	approveCmd.Flags().StringVar(&requestID, "request", "", "Reference of the request being approved")
+
+	var bypassFor time.Duration
+	var code string
+	bypassCmd := &cobra.Command{
+		Use:          "bypass <domain>",
+		Short:        "Let a single blocked domain through for a few minutes",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var result api.UnblockApprovalResult
+			req := api.DomainBypassRequest{
+				Domain:        args[0],
+				Justification: justification,
+				Duration:      bypassFor.String(),
+				Code:          code,
+			}
+			if err := unblockAPIRequest(apiKey, http.MethodPost, api.UnblockBypassPath, req, &result); err != nil {
+				return err
+			}
+			fmt.Printf("⏱️  %s is bypassed until %s\n", result.Domain, result.Until.Local().Format("15:04"))
+			return nil
+		},
+	}
+	bypassCmd.Flags().DurationVar(&bypassFor, "for", 15*time.Minute, "How long to bypass the domain (capped by domainBypass.maxDuration)")
+	bypassCmd.Flags().StringVarP(&justification, "justification", "j", "", "Why you need the domain")
+	bypassCmd.Flags().StringVar(&code, "code", "", "Admin-issued bypass code")
+	bypassCmd.MarkFlagRequired("justification")
+
+	endCmd := &cobra.Command{
+		Use:          "end <domain>",
+		Short:        "End a bypass early",
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var status api.DomainBypassStatus
+			path := api.UnblockBypassPath + "?domain=" + url.QueryEscape(args[0])
+			if err := unblockAPIRequest(apiKey, http.MethodDelete, path, nil, &status); err != nil {
+				return err
+			}
+			fmt.Printf("🛡️  %s is blocked again\n", args[0])
+			return nil
+		},
+	}
+
+	listCmd := &cobra.Command{
+		Use:          "list",
+		Short:        "List active bypasses",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			var status api.DomainBypassStatus
+			if err := unblockAPIRequest(apiKey, http.MethodGet, api.UnblockBypassPath, nil, &status); err != nil {
+				return err
+			}
+			if len(status.Bypasses) == 0 {
+				fmt.Println("No active bypasses")
+				return nil
+			}
+			for _, bypass := range status.Bypasses {
+				fmt.Printf("%-40s until %s\n", bypass.Domain, bypass.Until.Local().Format("15:04:05"))
+			}
+			return nil
+		},
+	}
+
+	var account string
+	totpSecretCmd := &cobra.Command{
+		Use:          "totp-secret",
+		Short:        "Generate a secret for admin bypass codes",
+		Args:         cobra.NoArgs,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			secret, err := unblock.NewTOTPSecret()
+			if err != nil {
+				return err
+			}
+			fmt.Printf("Secret:      %s\n", secret)
+			fmt.Printf("Enroll URL:  %s\n\n", unblock.TOTPURL(secret, account))
+			fmt.Printf("Deploy the secret as %s (or domainBypass.totpSecret) and add\n", unblock.TOTPSecretEnv)
+			fmt.Println("the enroll URL to the helpdesk's authenticator app.")
+			return nil
+		},
+	}
+	totpSecretCmd.Flags().StringVar(&account, "account", "bypass", "Account name shown in the authenticator app")
+
+	unblockCmd.AddCommand(requestCmd, applyCmd, approveCmd, bypassCmd, endCmd, listCmd, totpSecretCmd)
+	unblockCmd.PersistentFlags().StringVar(&apiKey, "api-key", "", "API key to authenticate with")
+	return unblockCmd
+}
+
+// unblockAPIRequest calls one of the running agent's access request and
+// bypass endpoints
+func unblockAPIRequest(apiKey, method, path string, in, out interface{}) error {
+	key, err := resolveAPIKey(apiKey)
+	if err != nil {
+		return err
+	}
+	return api.NewClient(key).Do(method, path, in, out)
+}
@@ -11,6 +11,9 @@ import (
 
 	"dnshield/internal/audit"
 	"dnshield/internal/ca"
+	"dnshield/internal/config"
+	"dnshield/internal/policy"
+	"dnshield/internal/security"
 
 	"github.com/sirupsen/logrus"
 	"github.com/spf13/cobra"
@@ -18,7 +21,8 @@ import (
 
 // UninstallOptions contains options for the uninstall command
 type UninstallOptions struct {
-	RemoveAll bool
+	RemoveAll  bool
+	ConfigFile string
 }
 
 // validateCertificateName validates certificate names to prevent command injection
@@ -88,6 +92,31 @@ func validatePath(path string) error {
 	return nil
 }
 
+// checkUninstallPolicy refuses to uninstall an enrolled agent unless the
+// managed policy allows it
+func checkUninstallPolicy(configFile string) error {
+	cfg, err := config.LoadConfig(configFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+	if !policy.Enrolled(&cfg.ManagedPolicy) {
+		return nil
+	}
+
+	manager := newPolicyManager(cfg)
+	manager.Load()
+	if manager.Policy().AllowUninstall {
+		return nil
+	}
+
+	status := manager.Status()
+	audit.Log(audit.EventPolicyDenied, "warning", "Uninstall refused by managed policy", map[string]interface{}{
+		"policy_source": status.Source,
+		"policy_serial": status.Serial,
+	})
+	return fmt.Errorf("uninstall is disabled by managed policy (%s); ask your administrator to publish a policy with allow_uninstall", status.Source)
+}
+
 // NewUninstallCmd creates the uninstall command
 func NewUninstallCmd() *cobra.Command {
 	opts := &UninstallOptions{}
@@ -100,8 +129,12 @@ func NewUninstallCmd() *cobra.Command {
 This command will:
 - Remove the CA certificate from the system keychain
 - Remove the CA private key from Keychain (on macOS with v2 security)
+- Remove the pf rules redirecting DNS to DNShield, if any
 - Optionally remove all configuration and data with --all flag
 
+Uninstalling an agent enrolled in a managed policy is refused unless the
+policy sets allow_uninstall.
+
 You will be prompted for your password to remove the certificate.`,
 		RunE: func(cmd *cobra.Command, args []string) error {
 			return runUninstall(opts)
@@ -109,6 +142,7 @@ You will be prompted for your password to remove the certificate.`,
 	}
 
 	cmd.Flags().BoolVar(&opts.RemoveAll, "all", false, "Remove all DNShield data and configuration")
+	cmd.Flags().StringVarP(&opts.ConfigFile, "config", "c", "", "config file path")
 
 	return cmd
 }
@@ -122,6 +156,10 @@ func runUninstall(opts *UninstallOptions) error {
 		return fmt.Errorf("uninstall command is currently only supported on macOS")
 	}
 
+	if err := checkUninstallPolicy(opts.ConfigFile); err != nil {
+		return err
+	}
+
 	// Uninstall based on security mode
 	if ca.UseKeychain() {
 		fmt.Println("📌 Removing CA from Keychain (v2.0 security mode)...")
@@ -164,6 +202,12 @@ func runUninstall(opts *UninstallOptions) error {
 		}
 	}
 
+	// Remove pf rules an agent left behind
+	fmt.Println("📌 Removing pf DNS redirect rules...")
+	if err := security.RemoveDNSRedirect(); err != nil {
+		logrus.WithError(err).Warn("Failed to remove pf DNS redirect")
+	}
+
 	// Remove configuration if requested
 	if opts.RemoveAll {
 		fmt.Println("\n🗑️  Removing all DNShield data...")
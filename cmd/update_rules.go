@@ -0,0 +1,131 @@
+package cmd
+
+import (
+	"fmt"
+	"net/http"
+	"time"
+
+	"dnshield/internal/api"
+	"dnshield/internal/config"
+	"dnshield/internal/policy"
+	"dnshield/internal/rules"
+
+	"github.com/spf13/cobra"
+)
+
+// UpdateRulesOptions contains options for the update-rules command
+type UpdateRulesOptions struct {
+	ConfigFile string
+	APIKey     string
+}
+
+// NewUpdateRulesCmd creates the update-rules command
+func NewUpdateRulesCmd() *cobra.Command {
+	opts := &UpdateRulesOptions{}
+
+	cmd := &cobra.Command{
+		Use:   "update-rules",
+		Short: "Force update blocking rules from S3",
+		Long: `Fetch this device's enterprise rules from the configured S3 bucket and
+apply them now instead of waiting for the next scheduled update.
+
+The rules are fetched here first so S3 access and the device's user and
+group assignment can be checked. The running agent is then asked to
+refresh through its API, which needs the rules:refresh permission
+(operator). If the agent can't be reached, the rules are written to
+` + rules.DefaultCachePath + `
+(this needs root), and the agent applies them when it starts or the next
+time S3 is unreachable.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			return runUpdateRules(opts)
+		},
+	}
+
+	cmd.Flags().StringVarP(&opts.ConfigFile, "config", "c", "", "config file path")
+	cmd.Flags().StringVar(&opts.APIKey, "api-key", "", "API key to authenticate with")
+
+	return cmd
+}
+
+func runUpdateRules(opts *UpdateRulesOptions) error {
+	cfg, err := config.LoadConfig(opts.ConfigFile)
+	if err != nil {
+		return fmt.Errorf("failed to load config: %v", err)
+	}
+
+	// Fetch from the same place the agent does
+	if policy.Enrolled(&cfg.ManagedPolicy) {
+		manager := newPolicyManager(cfg)
+		manager.Load()
+		manager.Policy().Apply(cfg)
+	}
+	if !cfg.S3.Configured() {
+		return fmt.Errorf("no rules store configured; rules are only updated from s3.bucket or s3.url")
+	}
+
+	fmt.Printf("📥 Fetching rules from %s...\n", rules.StoreURL(&cfg.S3))
+	fetcher, err := rules.NewEnterpriseFetcher(&cfg.S3)
+	if err != nil {
+		return err
+	}
+	if resolver := newUserResolver(&cfg.OIDC); resolver != nil {
+		fetcher.SetUserResolver(resolver)
+	}
+	if cached, err := rules.LoadCache(rules.DefaultCachePath); err == nil {
+		fetcher.RememberApplied(cached)
+	}
+	enterpriseRules, err := fetcher.FetchEnterpriseRules()
+	if err != nil {
+		return fmt.Errorf("failed to fetch rules: %v", err)
+	}
+
+	blockDomains, allowDomains, allowOnlyMode := enterpriseRules.MergeRules()
+	securityDomains, securitySources := enterpriseRules.MergeSecurityRules()
+	fmt.Printf("   Device: %s\n", enterpriseRules.DeviceName)
+	if enterpriseRules.DeviceID != "" {
+		fmt.Printf("   Matched: %s\n", enterpriseRules.DeviceID)
+	}
+	fmt.Printf("   User:   %s\n", valueOrNone(enterpriseRules.UserEmail))
+	if enterpriseRules.UserSource == rules.UserSourceOIDC {
+		fmt.Println("           (signed in with the identity provider)")
+	}
+	fmt.Printf("   Group:  %s\n", valueOrNone(enterpriseRules.GroupName))
+	fmt.Printf("   Rules:  %d blocked, %d allowed, %d security, %d external sources\n",
+		len(blockDomains), len(allowDomains), len(securityDomains),
+		len(enterpriseRules.GetBlockSources())+len(enterpriseRules.GetCategorySources())+len(securitySources))
+	if allowOnlyMode {
+		fmt.Println("   Mode:   allow-only")
+	}
+
+	// Ask the running agent to apply them
+	var result api.RuleRefreshResult
+	key, err := resolveAPIKey(opts.APIKey)
+	if err == nil {
+		client := api.NewClient(key)
+		client.SetTimeout(2 * time.Minute)
+		err = client.Do(http.MethodPost, "/api/refresh-rules", nil, &result)
+	}
+	if err == nil {
+		fmt.Printf("✅ Agent loaded %d blocked, %d security and %d allowed domains\n",
+			result.BlockedDomains, result.SecurityDomains, result.AllowedDomains)
+		for _, failed := range result.FailedSources {
+			fmt.Printf("⚠️  Left out %s\n", failed)
+		}
+		return nil
+	}
+
+	fmt.Printf("⚠️  Could not reach the agent: %v\n", err)
+	if err := rules.SaveCache(rules.DefaultCachePath, enterpriseRules); err != nil {
+		return fmt.Errorf("failed to write rules cache (run with sudo): %v", err)
+	}
+	fmt.Printf("💾 Wrote rules to %s; the agent applies them when it next starts\n", rules.DefaultCachePath)
+	return nil
+}
+
+func valueOrNone(s string) string {
+	if s == "" {
+		return "(none)"
+	}
+	return s
+}
@@ -0,0 +1,270 @@
+package cmd
+
+import (
+	"context"
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"time"
+
+	"dnshield/internal/ca"
+	"dnshield/internal/dns"
+
+	"github.com/spf13/cobra"
+)
+
+// VerifyCheck is the outcome of a single step of 'dnshield verify'
+type VerifyCheck struct {
+	Name       string `json:"name"`
+	Passed     bool   `json:"passed"`
+	Detail     string `json:"detail"`
+	DurationMs int64  `json:"duration_ms"`
+}
+
+// VerifyReport is the machine-readable result of 'dnshield verify'
+type VerifyReport struct {
+	Timestamp time.Time     `json:"timestamp"`
+	Hostname  string        `json:"hostname"`
+	Passed    bool          `json:"passed"`
+	Checks    []VerifyCheck `json:"checks"`
+}
+
+type verifyOptions struct {
+	canary  string
+	allowed string
+	caPath  string
+	timeout time.Duration
+	asJSON  bool
+}
+
+// NewVerifyCmd creates the verify command
+func NewVerifyCmd() *cobra.Command {
+	opts := verifyOptions{}
+
+	verifyCmd := &cobra.Command{
+		Use:   "verify",
+		Short: "Verify the protection chain end to end",
+		Long: `Verify that DNShield is protecting this machine end to end:
+
+  1. The canary domain resolves to the block IP through the system resolver
+  2. The block page is served over HTTPS for the canary
+  3. The block page certificate chains to the DNShield CA
+  4. The system trusts that certificate
+  5. An allowed domain still resolves through the upstreams
+
+Exits non-zero if any check fails. Use --json for a machine-readable report
+suitable for MDM compliance checks.`,
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			report := runVerify(opts)
+
+			if opts.asJSON {
+				enc := json.NewEncoder(os.Stdout)
+				enc.SetIndent("", "  ")
+				if err := enc.Encode(report); err != nil {
+					return err
+				}
+			} else {
+				printVerifyReport(report)
+			}
+
+			if !report.Passed {
+				failed := 0
+				for _, check := range report.Checks {
+					if !check.Passed {
+						failed++
+					}
+				}
+				return fmt.Errorf("%d of %d checks failed", failed, len(report.Checks))
+			}
+			return nil
+		},
+	}
+
+	verifyCmd.Flags().StringVar(&opts.canary, "canary", dns.CanaryDomain, "Domain expected to be blocked")
+	verifyCmd.Flags().StringVar(&opts.allowed, "allowed", "apple.com", "Domain expected to resolve normally")
+	verifyCmd.Flags().StringVar(&opts.caPath, "ca-path", ca.GetCAPath(), "Directory containing the DNShield CA certificate")
+	verifyCmd.Flags().DurationVar(&opts.timeout, "timeout", 5*time.Second, "Timeout for each check")
+	verifyCmd.Flags().BoolVar(&opts.asJSON, "json", false, "Print the report as JSON")
+
+	return verifyCmd
+}
+
+func runVerify(opts verifyOptions) *VerifyReport {
+	hostname, _ := os.Hostname()
+	report := &VerifyReport{
+		Timestamp: time.Now(),
+		Hostname:  hostname,
+		Passed:    true,
+	}
+
+	record := func(name string, fn func() (string, error)) bool {
+		start := time.Now()
+		detail, err := fn()
+		check := VerifyCheck{
+			Name:       name,
+			Passed:     err == nil,
+			Detail:     detail,
+			DurationMs: time.Since(start).Milliseconds(),
+		}
+		if err != nil {
+			check.Detail = err.Error()
+			report.Passed = false
+		}
+		report.Checks = append(report.Checks, check)
+		return check.Passed
+	}
+
+	var blockIP string
+	record("canary_blocked", func() (string, error) {
+		addrs, err := lookupHost(opts.canary, opts.timeout)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %v", opts.canary, err)
+		}
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr); ip != nil && ip.IsLoopback() {
+				blockIP = addr
+				return fmt.Sprintf("%s resolved to %s", opts.canary, addr), nil
+			}
+		}
+		return "", fmt.Errorf("%s resolved to %s, not the block IP", opts.canary, strings.Join(addrs, ", "))
+	})
+
+	// The remaining TLS checks all need the block page certificate
+	var peerCerts []*x509.Certificate
+	record("block_page", func() (string, error) {
+		if blockIP == "" {
+			return "", fmt.Errorf("skipped: canary did not resolve to the block IP")
+		}
+		certs, status, err := fetchBlockPage(opts.canary, blockIP, opts.timeout)
+		if err != nil {
+			return "", err
+		}
+		peerCerts = certs
+		return fmt.Sprintf("block page served with HTTP %d", status), nil
+	})
+
+	record("cert_chain", func() (string, error) {
+		if len(peerCerts) == 0 {
+			return "", fmt.Errorf("skipped: no block page certificate")
+		}
+		caCert, err := ca.LoadCACertificate(opts.caPath)
+		if err != nil {
+			return "", fmt.Errorf("failed to load CA certificate from %s: %v", opts.caPath, err)
+		}
+		roots := x509.NewCertPool()
+		roots.AddCert(caCert)
+		if err := verifyPeerChain(peerCerts, opts.canary, roots); err != nil {
+			return "", fmt.Errorf("certificate does not chain to %s: %v", caCert.Subject.CommonName, err)
+		}
+		return fmt.Sprintf("issued by %s", caCert.Subject.CommonName), nil
+	})
+
+	record("ca_trusted", func() (string, error) {
+		if len(peerCerts) == 0 {
+			return "", fmt.Errorf("skipped: no block page certificate")
+		}
+		// nil roots means the system trust store (the keychain on macOS)
+		if err := verifyPeerChain(peerCerts, opts.canary, nil); err != nil {
+			return "", fmt.Errorf("certificate not trusted by the system: %v", err)
+		}
+		return "certificate trusted by the system", nil
+	})
+
+	record("upstream_resolution", func() (string, error) {
+		addrs, err := lookupHost(opts.allowed, opts.timeout)
+		if err != nil {
+			return "", fmt.Errorf("failed to resolve %s: %v", opts.allowed, err)
+		}
+		for _, addr := range addrs {
+			if ip := net.ParseIP(addr); ip != nil && !ip.IsLoopback() {
+				return fmt.Sprintf("%s resolved to %s", opts.allowed, addr), nil
+			}
+		}
+		return "", fmt.Errorf("%s resolved to %s, expected a public address", opts.allowed, strings.Join(addrs, ", "))
+	})
+
+	return report
+}
+
+// lookupHost resolves host through the system resolver, as applications do
+func lookupHost(host string, timeout time.Duration) ([]string, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+	return net.DefaultResolver.LookupHost(ctx, host)
+}
+
+// fetchBlockPage requests https://domain/ from ip and returns the presented
+// certificate chain and HTTP status
+func fetchBlockPage(domain, ip string, timeout time.Duration) ([]*x509.Certificate, int, error) {
+	dialer := &net.Dialer{Timeout: timeout}
+	client := &http.Client{
+		Timeout: timeout,
+		Transport: &http.Transport{
+			DialContext: func(ctx context.Context, network, addr string) (net.Conn, error) {
+				return dialer.DialContext(ctx, network, net.JoinHostPort(ip, "443"))
+			},
+			TLSClientConfig: &tls.Config{
+				ServerName: domain,
+				// The chain is verified explicitly afterwards so both the
+				// DNShield CA and the system trust store can be reported on
+				InsecureSkipVerify: true,
+			},
+		},
+	}
+
+	resp, err := client.Get("https://" + domain + "/")
+	if err != nil {
+		return nil, 0, fmt.Errorf("failed to fetch block page: %v", err)
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.TLS == nil || len(resp.TLS.PeerCertificates) == 0 {
+		return nil, resp.StatusCode, fmt.Errorf("no certificate presented")
+	}
+	if resp.Header.Get("X-Blocked-Domain") == "" {
+		return nil, resp.StatusCode, fmt.Errorf("response is not a DNShield block page (HTTP %d)", resp.StatusCode)
+	}
+
+	return resp.TLS.PeerCertificates, resp.StatusCode, nil
+}
+
+// verifyPeerChain verifies the leaf for domain against roots, or the system
+// roots when roots is nil
+func verifyPeerChain(certs []*x509.Certificate, domain string, roots *x509.CertPool) error {
+	intermediates := x509.NewCertPool()
+	for _, cert := range certs[1:] {
+		intermediates.AddCert(cert)
+	}
+	_, err := certs[0].Verify(x509.VerifyOptions{
+		DNSName:       domain,
+		Roots:         roots,
+		Intermediates: intermediates,
+	})
+	return err
+}
+
+func printVerifyReport(report *VerifyReport) {
+	fmt.Println("🔍 DNShield Verification")
+	fmt.Println("============================")
+	for _, check := range report.Checks {
+		mark := "✅"
+		if !check.Passed {
+			mark = "❌"
+		}
+		fmt.Printf("%s %-20s %s\n", mark, check.Name, check.Detail)
+	}
+	fmt.Println()
+	if report.Passed {
+		fmt.Println("✅ Protection chain verified")
+	} else {
+		fmt.Println("❌ Protection chain is broken")
+	}
+}
@@ -0,0 +1,165 @@
+package cmd
+
+import (
+	"fmt"
+	"net/url"
+	"strings"
+
+	"dnshield/internal/api"
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"dnshield/internal/rules"
+
+	"github.com/spf13/cobra"
+)
+
+// NewWhyCmd creates the why command
+func NewWhyCmd() *cobra.Command {
+	var apiKey string
+
+	cmd := &cobra.Command{
+		Use:   "why <domain>",
+		Short: "Explain why a domain is blocked or allowed",
+		Long: `Ask the running agent why a domain is blocked or allowed: the rule that
+decided it and the list and rules file (base, group or user) it came from,
+every other rule that matches, the allowlist precedence, allow-only mode and
+captive portal exemptions. Hits aren't counted in the statistics.
+
+The API key is taken from --api-key, then DNSHIELD_API_KEY, then the local
+key file.`,
+		Args:         cobra.ExactArgs(1),
+		SilenceUsage: true,
+		RunE: func(cmd *cobra.Command, args []string) error {
+			key, err := resolveAPIKey(apiKey)
+			if err != nil {
+				return err
+			}
+			var e api.DomainExplanation
+			path := api.ExplainPath + "?domain=" + url.QueryEscape(args[0])
+			if err := api.NewClient(key).Get(path, &e); err != nil {
+				return err
+			}
+			printExplanation(&e)
+			return nil
+		},
+	}
+
+	cmd.Flags().StringVar(&apiKey, "api-key", "", "API key to authenticate with")
+	return cmd
+}
+
+func printExplanation(e *api.DomainExplanation) {
+	icon := "✅"
+	switch {
+	case e.Monitored:
+		icon = "👀"
+	case e.Blocked:
+		icon = "🚫"
+	}
+	fmt.Printf("%s %s: %s\n", icon, e.Domain, e.Verdict)
+	if e.Rule != "" {
+		fmt.Printf("   Rule:     %s\n", e.Rule)
+	}
+	if e.Source != "" {
+		fmt.Printf("   Source:   %s\n", e.Source)
+	}
+	if len(e.Files) > 0 {
+		fmt.Printf("   Files:    %s\n", strings.Join(e.Files, ", "))
+	}
+
+	mode := "block"
+	if e.AllowOnly {
+		mode = "allow-only"
+		if e.AllowOnlySchedule != "" {
+			mode += " (schedule " + e.AllowOnlySchedule + ")"
+		}
+	}
+	fmt.Printf("   Mode:     %s, %s enforcement, %s precedence\n", mode, e.Enforcement, e.Precedence)
+
+	if len(e.Matches) == 0 {
+		fmt.Println("   No rules match")
+		return
+	}
+	fmt.Println("   Matching rules:")
+	for _, m := range e.Matches {
+		line := fmt.Sprintf("     %-18s %s", m.List, m.Rule)
+		if m.Source != "" && m.Source != m.Rule {
+			line += "  from " + m.Source
+		}
+		if len(m.Files) > 0 {
+			line += "  (" + strings.Join(m.Files, ", ") + ")"
+		}
+		fmt.Println(line)
+	}
+}
+
+// explainDomain traces domain through blocker. Rules and sources are
+// attributed to the files of er, the enterprise rules applied, if any.
+func explainDomain(blocker *dns.Blocker, er *rules.EnterpriseRules, domain string) *api.DomainExplanation {
+	trace := blocker.Explain(domain)
+	match := trace.Match
+
+	files := func(rule, source string) []string {
+		if er == nil {
+			return nil
+		}
+		if source == dns.SourceLocal {
+			return nil
+		}
+		if source != "" && source != dns.SourceInline {
+			return er.RuleFiles(source)
+		}
+		return er.RuleFiles(rule)
+	}
+
+	e := &api.DomainExplanation{
+		Domain:            trace.Domain,
+		Blocked:           match.Blocked,
+		Matches:           []api.RuleMatch{},
+		CaptivePortal:     trace.CaptivePortal,
+		AllowOnly:         trace.AllowOnly,
+		AllowOnlySchedule: trace.AllowOnlySchedule,
+		Precedence:        trace.Precedence,
+		Enforcement:       trace.Enforcement,
+	}
+	var allow *dns.RuleHit
+	for i, hit := range trace.Hits {
+		e.Matches = append(e.Matches, api.RuleMatch{List: hit.List, Rule: hit.Rule, Source: hit.Source, Files: files(hit.Rule, hit.Source)})
+		if allow == nil && (hit.List == dns.ExplainListAllow || hit.List == dns.ExplainListTemporary || hit.List == dns.ExplainListLocalAllow) {
+			allow = &trace.Hits[i]
+		}
+	}
+
+	switch {
+	case trace.CaptivePortal:
+		e.Verdict = "allowed, captive portal checks are never blocked"
+	case match.Blocked:
+		e.Rule, e.Source, e.Category = match.Rule, match.Source, match.Category()
+		e.Files = files(match.Rule, match.Source)
+		e.Verdict = "blocked by " + match.Reason()
+		if match.OverrodeAllowlist {
+			e.Verdict += ", which overrides the allowlist with security precedence"
+		}
+		// Security rules and canaries are enforced in monitor mode too
+		if trace.Enforcement == config.EnforcementMonitor && !match.Security && match.Source != dns.SourceCanary {
+			e.Monitored = true
+			e.Verdict = "would be " + e.Verdict + ", but only logged in monitor mode"
+		}
+	case allow != nil:
+		e.Rule, e.Files = allow.Rule, files(allow.Rule, allow.Source)
+		switch allow.List {
+		case dns.ExplainListTemporary:
+			e.Verdict = "allowed temporarily by an approved access request or bypass"
+		case dns.ExplainListLocalAllow:
+			e.Verdict = "allowed by local rule " + allow.Rule + " added on this device"
+		default:
+			e.Verdict = "allowed by allowlist rule " + allow.Rule
+		}
+		if len(trace.Hits) > 1 {
+			e.Verdict += ", which wins over the block rules below"
+		}
+	default:
+		e.Verdict = "allowed, no rule matches"
+	}
+	return e
+}
@@ -4,8 +4,11 @@ import (
 	"crypto/rand"
 	"crypto/subtle"
 	"encoding/hex"
+	"encoding/json"
 	"fmt"
+	"net"
 	"net/http"
+	"net/netip"
 	"os"
 	"path/filepath"
 	"strings"
@@ -142,56 +145,254 @@ func PublicEndpoint(next http.HandlerFunc) http.HandlerFunc {
 	return next
 }
 
-// RateLimiter provides basic rate limiting for API endpoints
+// RateLimit is a token bucket: Requests per Window sustained, with up to
+// Burst requests allowed at once
+type RateLimit struct {
+	Requests int
+	Window   time.Duration
+	Burst    int
+}
+
+// MarshalJSON reports the window as a duration string (e.g. "1m0s")
+func (l RateLimit) MarshalJSON() ([]byte, error) {
+	return json.Marshal(struct {
+		Requests int    `json:"requests"`
+		Window   string `json:"window"`
+		Burst    int    `json:"burst"`
+	}{l.Requests, l.Window.String(), l.Burst})
+}
+
+// RateLimitPolicy selects the limit applied to a request. Endpoint
+// overrides win over role overrides, which win over the default.
+type RateLimitPolicy struct {
+	Default   RateLimit
+	Endpoints map[string]RateLimit
+	Roles     map[Role]RateLimit
+
+	// Proxies whose X-Forwarded-For header names the client; from anyone
+	// else the header is ignored
+	TrustedProxies []netip.Prefix
+}
+
+// RateLimiterState is the limiter summary reported by the health endpoint
+type RateLimiterState struct {
+	Default       RateLimit            `json:"default"`
+	Endpoints     map[string]RateLimit `json:"endpoints,omitempty"`
+	Roles         map[Role]RateLimit   `json:"roles,omitempty"`
+	ActiveBuckets int                  `json:"active_buckets"`
+	RejectedTotal int64                `json:"rejected_total"`
+}
+
+// rateLimiterIdleTimeout is how long an unused bucket is kept
+const rateLimiterIdleTimeout = 10 * time.Minute
+
+type tokenBucket struct {
+	tokens   float64
+	lastSeen time.Time
+}
+
+// RateLimiter provides per-client token bucket rate limiting for API endpoints
 type RateLimiter struct {
-	mu       sync.Mutex
-	requests map[string][]time.Time
-	limit    int
-	window   time.Duration
+	mu          sync.Mutex
+	buckets     map[string]*tokenBucket
+	policy      RateLimitPolicy
+	roleOf      func(r *http.Request) (Role, bool)
+	rejected    int64
+	lastCleanup time.Time
 }
 
-// NewRateLimiter creates a new rate limiter
+// NewRateLimiter creates a rate limiter allowing limit requests per window
+// for each client
 func NewRateLimiter(limit int, window time.Duration) *RateLimiter {
+	return NewRateLimiterWithPolicy(RateLimitPolicy{
+		Default: RateLimit{Requests: limit, Window: window, Burst: limit},
+	})
+}
+
+// NewRateLimiterWithPolicy creates a rate limiter from a full policy
+func NewRateLimiterWithPolicy(policy RateLimitPolicy) *RateLimiter {
 	return &RateLimiter{
-		requests: make(map[string][]time.Time),
-		limit:    limit,
-		window:   window,
+		buckets:     make(map[string]*tokenBucket),
+		policy:      normalizePolicy(policy),
+		lastCleanup: time.Now(),
 	}
 }
 
-// RateLimitMiddleware creates HTTP middleware for rate limiting
-func (rl *RateLimiter) RateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
-	return func(w http.ResponseWriter, r *http.Request) {
-		clientIP := r.RemoteAddr
-		if xForwardedFor := r.Header.Get("X-Forwarded-For"); xForwardedFor != "" {
-			clientIP = strings.Split(xForwardedFor, ",")[0]
+// SetPolicy replaces the limits. Existing buckets are reset.
+func (rl *RateLimiter) SetPolicy(policy RateLimitPolicy) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.policy = normalizePolicy(policy)
+	rl.buckets = make(map[string]*tokenBucket)
+}
+
+// SetRoleResolver sets the function used to find the role of a request's
+// API key, enabling per-role limits
+func (rl *RateLimiter) SetRoleResolver(fn func(r *http.Request) (Role, bool)) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	rl.roleOf = fn
+}
+
+// normalizePolicy fills zero override fields from the default limit
+func normalizePolicy(policy RateLimitPolicy) RateLimitPolicy {
+	if policy.Default.Burst <= 0 {
+		policy.Default.Burst = policy.Default.Requests
+	}
+	inherit := func(limit RateLimit) RateLimit {
+		if limit.Requests <= 0 {
+			limit.Requests = policy.Default.Requests
+		}
+		if limit.Window <= 0 {
+			limit.Window = policy.Default.Window
+		}
+		if limit.Burst <= 0 {
+			limit.Burst = limit.Requests
+		}
+		return limit
+	}
+
+	endpoints := make(map[string]RateLimit, len(policy.Endpoints))
+	for path, limit := range policy.Endpoints {
+		endpoints[path] = inherit(limit)
+	}
+	roles := make(map[Role]RateLimit, len(policy.Roles))
+	for role, limit := range policy.Roles {
+		roles[role] = inherit(limit)
+	}
+	policy.Endpoints, policy.Roles = endpoints, roles
+	return policy
+}
+
+// State returns the configured limits and current bucket counts
+func (rl *RateLimiter) State() RateLimiterState {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+	return RateLimiterState{
+		Default:       rl.policy.Default,
+		Endpoints:     rl.policy.Endpoints,
+		Roles:         rl.policy.Roles,
+		ActiveBuckets: len(rl.buckets),
+		RejectedTotal: rl.rejected,
+	}
+}
+
+// limitFor picks the limit and bucket scope for a request. Must be called
+// with rl.mu held.
+func (rl *RateLimiter) limitFor(r *http.Request) (RateLimit, string) {
+	if limit, ok := rl.policy.Endpoints[r.URL.Path]; ok {
+		return limit, "endpoint:" + r.URL.Path
+	}
+	if rl.roleOf != nil && len(rl.policy.Roles) > 0 {
+		if role, ok := rl.roleOf(r); ok {
+			if limit, ok := rl.policy.Roles[role]; ok {
+				return limit, "role:" + string(role)
+			}
 		}
+	}
+	return rl.policy.Default, "default"
+}
 
-		rl.mu.Lock()
-		now := time.Now()
-		
-		// Clean up old requests
-		if requests, exists := rl.requests[clientIP]; exists {
-			var validRequests []time.Time
-			for _, reqTime := range requests {
-				if now.Sub(reqTime) < rl.window {
-					validRequests = append(validRequests, reqTime)
-				}
+// allow takes a token from the client's bucket for this request
+func (rl *RateLimiter) allow(clientIP string, r *http.Request) (bool, RateLimit) {
+	rl.mu.Lock()
+	defer rl.mu.Unlock()
+
+	now := time.Now()
+	if now.Sub(rl.lastCleanup) > time.Minute {
+		for key, bucket := range rl.buckets {
+			if now.Sub(bucket.lastSeen) > rateLimiterIdleTimeout {
+				delete(rl.buckets, key)
 			}
-			rl.requests[clientIP] = validRequests
 		}
+		rl.lastCleanup = now
+	}
+
+	limit, scope := rl.limitFor(r)
+	key := clientIP + "|" + scope
+	bucket, ok := rl.buckets[key]
+	if !ok {
+		bucket = &tokenBucket{tokens: float64(limit.Burst), lastSeen: now}
+		rl.buckets[key] = bucket
+	}
+
+	// Refill at Requests per Window, capped at Burst
+	if limit.Window > 0 {
+		rate := float64(limit.Requests) / limit.Window.Seconds()
+		bucket.tokens += now.Sub(bucket.lastSeen).Seconds() * rate
+		if bucket.tokens > float64(limit.Burst) {
+			bucket.tokens = float64(limit.Burst)
+		}
+	}
+	bucket.lastSeen = now
 
-		// Check rate limit
-		if len(rl.requests[clientIP]) >= rl.limit {
-			rl.mu.Unlock()
+	if bucket.tokens < 1 {
+		rl.rejected++
+		return false, limit
+	}
+	bucket.tokens--
+	return true, limit
+}
+
+// clientOf returns the client a request is counted against: the uid of a
+// socket peer, whose RemoteAddr names it, or the connection's address.
+// X-Forwarded-For is only believed from trusted proxies, and then the
+// client is the last address in it no trusted proxy added.
+func (rl *RateLimiter) clientOf(r *http.Request) string {
+	host, _, err := net.SplitHostPort(r.RemoteAddr)
+	if err != nil {
+		return r.RemoteAddr
+	}
+
+	rl.mu.Lock()
+	trusted := rl.policy.TrustedProxies
+	rl.mu.Unlock()
+	if len(trusted) == 0 || !containsAddr(trusted, host) {
+		return host
+	}
+	client := host
+	hops := strings.Split(strings.Join(r.Header.Values("X-Forwarded-For"), ","), ",")
+	for i := len(hops) - 1; i >= 0; i-- {
+		hop := strings.TrimSpace(hops[i])
+		if _, err := netip.ParseAddr(hop); err != nil {
+			break
+		}
+		client = hop
+		if !containsAddr(trusted, hop) {
+			break
+		}
+	}
+	return client
+}
+
+// containsAddr reports whether the address ip is in any of prefixes
+func containsAddr(prefixes []netip.Prefix, ip string) bool {
+	addr, err := netip.ParseAddr(ip)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range prefixes {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
+// RateLimitMiddleware creates HTTP middleware for rate limiting
+func (rl *RateLimiter) RateLimitMiddleware(next http.HandlerFunc) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		allowed, limit := rl.allow(rl.clientOf(r), r)
+		if !allowed {
+			if limit.Requests > 0 {
+				retryAfter := limit.Window / time.Duration(limit.Requests)
+				w.Header().Set("Retry-After", fmt.Sprintf("%d", int(retryAfter.Seconds())+1))
+			}
 			http.Error(w, "Rate limit exceeded", http.StatusTooManyRequests)
 			return
 		}
 
-		// Add current request
-		rl.requests[clientIP] = append(rl.requests[clientIP], now)
-		rl.mu.Unlock()
-
 		next(w, r)
 	}
-}
\ No newline at end of file
+}
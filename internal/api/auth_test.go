@@ -220,17 +220,105 @@ func TestRateLimiter(t *testing.T) {
 	})
 	
 	t.Run("XForwardedFor", func(t *testing.T) {
-		// Test X-Forwarded-For header
+		// X-Forwarded-For from a client that isn't a trusted proxy doesn't
+		// get it a fresh bucket
 		req := httptest.NewRequest("GET", "/api/test", nil)
-		req.Header.Set("X-Forwarded-For", "10.0.0.1, 192.168.1.1")
-		req.RemoteAddr = "192.168.1.100:12345"
+		req.Header.Set("X-Forwarded-For", "10.0.0.1")
+		req.RemoteAddr = "192.168.1.1:12345"
 		rec := httptest.NewRecorder()
 		
 		limitedHandler(rec, req)
 		
-		// Should use the first IP from X-Forwarded-For
-		if rec.Code != http.StatusOK {
-			t.Errorf("Expected status %d, got %d", http.StatusOK, rec.Code)
+		if rec.Code != http.StatusTooManyRequests {
+			t.Errorf("Expected status %d, got %d", http.StatusTooManyRequests, rec.Code)
 		}
 	})
-}
\ No newline at end of file
+}
+
+func TestRateLimiterTrustedProxies(t *testing.T) {
+	proxies, err := ParseAllowedIPs([]string{"10.1.0.0/16"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl := NewRateLimiterWithPolicy(RateLimitPolicy{
+		Default:        RateLimit{Requests: 1, Window: time.Minute},
+		TrustedProxies: proxies,
+	})
+
+	tests := []struct {
+		remoteAddr string
+		forwarded  string
+		want       string
+	}{
+		{"192.168.1.1:5000", "10.0.0.1", "192.168.1.1"},
+		{"10.1.0.5:5000", "", "10.1.0.5"},
+		{"10.1.0.5:5000", "10.0.0.1", "10.0.0.1"},
+		{"10.1.0.5:5000", "203.0.113.9, 10.0.0.1, 10.1.0.6", "10.0.0.1"},
+		{"10.1.0.5:5000", "not-an-ip", "10.1.0.5"},
+		{"uid=501", "10.0.0.1", "uid=501"},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest("GET", "/api/status", nil)
+		req.RemoteAddr = tt.remoteAddr
+		if tt.forwarded != "" {
+			req.Header.Set("X-Forwarded-For", tt.forwarded)
+		}
+		if got := rl.clientOf(req); got != tt.want {
+			t.Errorf("clientOf(%s, X-Forwarded-For %q) = %s, want %s", tt.remoteAddr, tt.forwarded, got, tt.want)
+		}
+	}
+}
+
+func TestRateLimiterPolicy(t *testing.T) {
+	rl := NewRateLimiterWithPolicy(RateLimitPolicy{
+		Default:   RateLimit{Requests: 2, Window: time.Minute},
+		Endpoints: map[string]RateLimit{"/api/top": {Requests: 60, Window: time.Minute, Burst: 4}},
+		Roles:     map[Role]RateLimit{RoleAdmin: {Requests: 3}},
+	})
+	rl.SetRoleResolver(func(r *http.Request) (Role, bool) {
+		if r.Header.Get("Authorization") == "Bearer admin" {
+			return RoleAdmin, true
+		}
+		return "", false
+	})
+
+	handler := rl.RateLimitMiddleware(func(w http.ResponseWriter, r *http.Request) {
+		w.WriteHeader(http.StatusOK)
+	})
+
+	// count returns how many of n requests are allowed
+	count := func(path, auth string, n int) int {
+		allowed := 0
+		for i := 0; i < n; i++ {
+			req := httptest.NewRequest("GET", path, nil)
+			req.RemoteAddr = "127.0.0.1:50000"
+			if auth != "" {
+				req.Header.Set("Authorization", auth)
+			}
+			rec := httptest.NewRecorder()
+			handler(rec, req)
+			if rec.Code == http.StatusOK {
+				allowed++
+			}
+		}
+		return allowed
+	}
+
+	if got := count("/api/status", "", 5); got != 2 {
+		t.Errorf("Default limit: expected 2 allowed, got %d", got)
+	}
+	if got := count("/api/top", "", 10); got != 4 {
+		t.Errorf("Endpoint burst: expected 4 allowed, got %d", got)
+	}
+	if got := count("/api/status", "Bearer admin", 5); got != 3 {
+		t.Errorf("Role limit: expected 3 allowed, got %d", got)
+	}
+
+	state := rl.State()
+	if state.RejectedTotal != 3+6+2 {
+		t.Errorf("Expected 11 rejected requests, got %d", state.RejectedTotal)
+	}
+	if state.Roles[RoleAdmin].Window != time.Minute {
+		t.Errorf("Role override should inherit the default window, got %v", state.Roles[RoleAdmin].Window)
+	}
+}
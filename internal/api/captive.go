@@ -0,0 +1,133 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// CaptiveBypassRequest starts a manual captive portal bypass
+type CaptiveBypassRequest struct {
+	Duration string `json:"duration,omitempty"` // e.g. "2m"; empty requests the policy maximum
+}
+
+// CaptiveBypassStatus reports the state of the captive portal bypass
+type CaptiveBypassStatus struct {
+	Active    bool      `json:"active"`
+	Manual    bool      `json:"manual"`
+	Until     time.Time `json:"until,omitempty"`
+	Remaining string    `json:"remaining,omitempty"`
+}
+
+// SetCaptivePortalDetector sets the detector controlled by the captive
+// portal bypass endpoint
+func (s *Server) SetCaptivePortalDetector(detector *dns.CaptivePortalDetector) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.captivePortal = detector
+}
+
+// handleCaptiveBypass reports (GET), starts (POST) or ends (DELETE) a
+// manual captive portal bypass. Every change is audited with the current
+// network identity.
+func (s *Server) handleCaptiveBypass(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	detector := s.captivePortal
+	s.mu.RUnlock()
+	if detector == nil {
+		http.Error(w, "Captive portal bypass not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req CaptiveBypassRequest
+		if r.ContentLength != 0 {
+			if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+		}
+
+		var duration time.Duration
+		if req.Duration != "" {
+			var err error
+			if duration, err = time.ParseDuration(req.Duration); err != nil || duration <= 0 {
+				http.Error(w, "Invalid duration format", http.StatusBadRequest)
+				return
+			}
+		}
+
+		until, err := detector.EnableManualBypass(duration)
+		switch {
+		case errors.Is(err, dns.ErrManualBypassNotAllowed):
+			s.auditCaptiveBypass(r, "denied", "Manual captive portal bypass denied by policy", nil)
+			http.Error(w, "Captive portal bypass not allowed by policy", http.StatusForbidden)
+			return
+		case errors.Is(err, dns.ErrManualBypassRateLimited):
+			s.auditCaptiveBypass(r, "rate_limited", "Manual captive portal bypass rate limited", nil)
+			http.Error(w, "Captive portal bypass limit reached", http.StatusTooManyRequests)
+			return
+		case err != nil:
+			logrus.WithError(err).Error("Failed to enable captive portal bypass")
+			http.Error(w, "Failed to enable captive portal bypass", http.StatusInternalServerError)
+			return
+		}
+		s.auditCaptiveBypass(r, "enabled", "Manual captive portal bypass enabled", map[string]interface{}{
+			"requested": req.Duration,
+			"until":     until.Format(time.RFC3339),
+		})
+	case http.MethodDelete:
+		detector.DisableBypass()
+		s.auditCaptiveBypass(r, "disabled", "Captive portal bypass ended", nil)
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := CaptiveBypassStatus{Manual: detector.IsManualBypass()}
+	if active, remaining := detector.GetBypassStatus(); active {
+		status.Active = true
+		status.Until = time.Now().Add(remaining).Truncate(time.Second)
+		status.Remaining = remaining.Truncate(time.Second).String()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// auditCaptiveBypass records a bypass action with the caller and network
+func (s *Server) auditCaptiveBypass(r *http.Request, action, message string, details map[string]interface{}) {
+	if details == nil {
+		details = make(map[string]interface{})
+	}
+	details["action"] = action
+	if role, ok := s.requestRole(r); ok {
+		details["role"] = string(role)
+	}
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		details["client_ip"] = host
+	}
+	if s.dnsManager != nil {
+		if network := s.dnsManager.GetCurrentNetwork(); network != nil {
+			details["network_id"] = network.ID
+			details["ssid"] = network.SSID
+			details["interface"] = network.Interface
+			details["gateway_ip"] = network.GatewayIP
+			details["gateway_mac"] = network.GatewayMAC
+		}
+	}
+
+	severity := "warning"
+	if action == "disabled" {
+		severity = "info"
+	}
+	audit.Log(audit.EventCaptiveBypass, severity, message, details)
+}
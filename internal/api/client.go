@@ -0,0 +1,144 @@
+package api
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"os"
+	"time"
+)
+
+// DefaultPort is the port the agent's API server listens on
+const DefaultPort = 5353
+
+// Client talks to a running agent's API server over its socket, or on
+// localhost if there is no socket at the default path
+type Client struct {
+	baseURL    string
+	apiKey     string
+	httpClient *http.Client
+}
+
+// NewClient creates a client for the local API server using apiKey for
+// authentication
+func NewClient(apiKey string) *Client {
+	c := &Client{
+		baseURL: fmt.Sprintf("http://127.0.0.1:%d", DefaultPort),
+		apiKey:  apiKey,
+		httpClient: &http.Client{
+			Timeout: 5 * time.Second,
+		},
+	}
+	if _, err := os.Stat(DefaultSocketPath); err == nil {
+		c.useSocket(DefaultSocketPath)
+	}
+	return c
+}
+
+// useSocket sends requests over the Unix domain socket at path
+func (c *Client) useSocket(path string) {
+	c.baseURL = "http://dnshield"
+	c.httpClient.Transport = &http.Transport{
+		DialContext: func(ctx context.Context, _, _ string) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, "unix", path)
+		},
+	}
+}
+
+// SetTimeout changes the per-request timeout, e.g. for CPU profiles
+func (c *Client) SetTimeout(timeout time.Duration) {
+	c.httpClient.Timeout = timeout
+}
+
+// Fetch requests path and copies the raw response body to w
+func (c *Client) Fetch(path string, w io.Writer) error {
+	resp, err := c.send(http.MethodGet, path, nil)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if _, err := io.Copy(w, resp.Body); err != nil {
+		return fmt.Errorf("failed to read API response: %v", err)
+	}
+	return nil
+}
+
+// Get requests path and decodes the JSON response into out
+func (c *Client) Get(path string, out interface{}) error {
+	return c.Do(http.MethodGet, path, nil, out)
+}
+
+// Do sends a request with in (if non-nil) as the JSON body and decodes the
+// JSON response into out (if non-nil)
+func (c *Client) Do(method, path string, in, out interface{}) error {
+	resp, err := c.send(method, path, in)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if out == nil {
+		return nil
+	}
+	if err := json.NewDecoder(resp.Body).Decode(out); err != nil {
+		return fmt.Errorf("failed to decode API response: %v", err)
+	}
+	return nil
+}
+
+// Stream opens a long-lived response at path, such as the query stream.
+// It stays open until ctx is cancelled or the agent closes it.
+func (c *Client) Stream(ctx context.Context, path string) (io.ReadCloser, error) {
+	// The per-request timeout would cut the stream off
+	resp, err := c.sendContext(ctx, &http.Client{Transport: c.httpClient.Transport}, http.MethodGet, path, nil)
+	if err != nil {
+		return nil, err
+	}
+	return resp.Body, nil
+}
+
+// send performs the request and returns the response if its status is 200
+func (c *Client) send(method, path string, in interface{}) (*http.Response, error) {
+	return c.sendContext(context.Background(), c.httpClient, method, path, in)
+}
+
+func (c *Client) sendContext(ctx context.Context, httpClient *http.Client, method, path string, in interface{}) (*http.Response, error) {
+	var body io.Reader
+	if in != nil {
+		data, err := json.Marshal(in)
+		if err != nil {
+			return nil, fmt.Errorf("failed to encode request: %v", err)
+		}
+		body = bytes.NewReader(data)
+	}
+
+	req, err := http.NewRequestWithContext(ctx, method, c.baseURL+path, body)
+	if err != nil {
+		return nil, fmt.Errorf("failed to build request: %v", err)
+	}
+	if c.apiKey != "" {
+		req.Header.Set("Authorization", "Bearer "+c.apiKey)
+	}
+	if in != nil {
+		req.Header.Set("Content-Type", "application/json")
+	}
+
+	resp, err := httpClient.Do(req)
+	if err != nil {
+		return nil, fmt.Errorf("failed to reach DNShield API (is the agent running?): %v", err)
+	}
+
+	if resp.StatusCode != http.StatusOK {
+		defer resp.Body.Close()
+		body, _ := io.ReadAll(io.LimitReader(resp.Body, 1024))
+		return nil, fmt.Errorf("API returned %s: %s", resp.Status, string(body))
+	}
+
+	return resp, nil
+}
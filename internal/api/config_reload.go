@@ -0,0 +1,68 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"dnshield/internal/audit"
+	"github.com/sirupsen/logrus"
+)
+
+// ConfigReloadPath re-reads the config file and applies what changed
+const ConfigReloadPath = "/api/config/reload"
+
+// ConfigReloadResult is the response to /api/config/reload, naming
+// settings by their config.yaml path, such as dns.upstreams
+type ConfigReloadResult struct {
+	// Changed settings now in effect
+	Applied []string `json:"applied"`
+	// Changed settings that couldn't be applied, such as a syslog server
+	// that is unreachable; the previous ones stay in effect
+	Failed []string `json:"failed,omitempty"`
+	// Changed settings that take effect when the agent restarts
+	RestartRequired []string `json:"restart_required,omitempty"`
+}
+
+// SetConfigReloadCallback sets the function /api/config/reload calls to
+// reload the config file
+func (s *Server) SetConfigReloadCallback(cb func() (*ConfigReloadResult, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.reloadConfig = cb
+}
+
+// handleConfigReload reloads the config file and records who reloaded it,
+// and what changed, in the audit log
+func (s *Server) handleConfigReload(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	reload := s.reloadConfig
+	s.mu.RUnlock()
+	if reload == nil {
+		http.Error(w, "Config reload is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	result, err := reload()
+	if err != nil {
+		logrus.WithError(err).Warn("Config reload requested over the API failed")
+		http.Error(w, fmt.Sprintf("Configuration not reloaded: %v", err), http.StatusUnprocessableEntity)
+		return
+	}
+
+	if len(result.Applied) > 0 || len(result.Failed) > 0 || len(result.RestartRequired) > 0 {
+		audit.Log(audit.EventConfigChange, "info", "Configuration reloaded", s.callerDetails(s.requestCaller(r), map[string]interface{}{
+			"applied":          strings.Join(result.Applied, ","),
+			"failed":           strings.Join(result.Failed, ","),
+			"restart_required": strings.Join(result.RestartRequired, ","),
+		}))
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
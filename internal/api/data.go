@@ -0,0 +1,100 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"time"
+
+	"dnshield/internal/audit"
+	"github.com/sirupsen/logrus"
+)
+
+// DataPurgePath deletes logged data on request, e.g. for a GDPR erasure
+const DataPurgePath = "/api/data/purge"
+
+// DataPurgeRequest selects the data to delete: what was logged before
+// Before, what belongs to User, or both. One of them is required.
+type DataPurgeRequest struct {
+	Before time.Time `json:"before"`
+	User   string    `json:"user,omitempty"`
+}
+
+// DataPurgeResult is the response to /api/data/purge
+type DataPurgeResult struct {
+	QueryLog       int64 `json:"query_log"`
+	AuditEvents    int   `json:"audit_events"`
+	BufferedEvents int   `json:"buffered_events"` // Not yet delivered to remote logging
+	// The query log doesn't record users, so it is only purged by user for
+	// the device's own user
+	QueryLogSkipped bool `json:"query_log_skipped,omitempty"`
+}
+
+// SetDataPurgeCallback sets the function /api/data/purge calls to delete
+// logged data
+func (s *Server) SetDataPurgeCallback(cb func(DataPurgeRequest) (*DataPurgeResult, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.purgeData = cb
+}
+
+// handleDataPurge deletes the logged data a request selects and records
+// what was deleted, and by whom, in the audit log
+func (s *Server) handleDataPurge(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	purge := s.purgeData
+	s.mu.RUnlock()
+	if purge == nil {
+		http.Error(w, "Data purge is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req DataPurgeRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	req.User = strings.TrimSpace(req.User)
+	if req.Before.IsZero() && req.User == "" {
+		http.Error(w, "A date, a user or both are required", http.StatusBadRequest)
+		return
+	}
+
+	result, err := purge(req)
+	if result == nil {
+		result = &DataPurgeResult{}
+	}
+	details := map[string]interface{}{
+		"query_log":       result.QueryLog,
+		"audit_events":    result.AuditEvents,
+		"buffered_events": result.BufferedEvents,
+	}
+	var scope []string
+	if !req.Before.IsZero() {
+		details["before"] = req.Before.UTC().Format(time.RFC3339)
+		scope = append(scope, "before "+req.Before.UTC().Format(time.RFC3339))
+	}
+	if req.User != "" {
+		details["subject"] = req.User
+		scope = append(scope, "for "+req.User)
+	}
+	if err != nil {
+		details["error"] = err.Error()
+	}
+	audit.Log(audit.EventDataPurged, "warning", fmt.Sprintf("Logged data purged %s", strings.Join(scope, " ")),
+		s.callerDetails(s.requestCaller(r), details))
+
+	if err != nil {
+		logrus.WithError(err).Warn("Data purge requested over the API failed")
+		http.Error(w, fmt.Sprintf("Data purge failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
@@ -0,0 +1,70 @@
+package api
+
+import (
+	"fmt"
+	"net/http"
+	"net/http/pprof"
+	"runtime"
+	runtimepprof "runtime/pprof"
+	"sort"
+
+	"github.com/sirupsen/logrus"
+)
+
+// DebugPathPrefix is where runtime profiles are served when profiling is
+// enabled
+const DebugPathPrefix = "/api/debug/pprof/"
+
+// debugProfiles are the runtime/pprof profiles served by name
+var debugProfiles = []string{"allocs", "block", "goroutine", "heap", "mutex", "threadcreate"}
+
+// SetProfilingEnabled exposes pprof profiles and goroutine dumps to admin
+// keys. It must be called before Start.
+func (s *Server) SetProfilingEnabled(enabled bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.profiling = enabled
+}
+
+// registerDebugHandlers adds the profiling endpoints to mux
+func (s *Server) registerDebugHandlers(mux *http.ServeMux, rl func(http.HandlerFunc) http.HandlerFunc) {
+	debug := func(handler http.HandlerFunc) http.HandlerFunc {
+		return rl(s.RBACMiddleware(PermissionDebug, handler))
+	}
+
+	mux.HandleFunc(DebugPathPrefix, debug(s.handleDebugIndex))
+	mux.HandleFunc(DebugPathPrefix+"profile", debug(pprof.Profile))
+	mux.HandleFunc(DebugPathPrefix+"trace", debug(pprof.Trace))
+	for _, name := range debugProfiles {
+		mux.HandleFunc(DebugPathPrefix+name, debug(pprof.Handler(name).ServeHTTP))
+	}
+	mux.HandleFunc("/api/debug/goroutines", debug(s.handleGoroutineDump))
+
+	logrus.Warn("API profiling endpoints enabled for admin keys")
+}
+
+// handleDebugIndex lists the available profiles
+func (s *Server) handleDebugIndex(w http.ResponseWriter, r *http.Request) {
+	if r.URL.Path != DebugPathPrefix {
+		http.NotFound(w, r)
+		return
+	}
+
+	names := append([]string{"profile", "trace"}, debugProfiles...)
+	sort.Strings(names)
+
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	fmt.Fprintf(w, "goroutines: %d\n\n", runtime.NumGoroutine())
+	for _, name := range names {
+		fmt.Fprintf(w, "%s%s\n", DebugPathPrefix, name)
+	}
+	fmt.Fprintln(w, "/api/debug/goroutines")
+}
+
+// handleGoroutineDump writes the stacks of all goroutines as text
+func (s *Server) handleGoroutineDump(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; charset=utf-8")
+	if err := runtimepprof.Lookup("goroutine").WriteTo(w, 2); err != nil {
+		logrus.WithError(err).Error("Failed to write goroutine dump")
+	}
+}
@@ -0,0 +1,61 @@
+package api
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestDebugEndpoints(t *testing.T) {
+	s := NewServer(nil)
+	s.rbacManager.AddAPIKey("admin-key", RoleAdmin, 0)
+	s.rbacManager.AddAPIKey("operator-key", RoleOperator, 0)
+	s.rbacManager.AddAPIKey("viewer-key", RoleViewer, 0)
+
+	get := func(h http.Handler, path, key string, socketRole Role) *httptest.ResponseRecorder {
+		req := httptest.NewRequest(http.MethodGet, path, nil)
+		if key != "" {
+			req.Header.Set("Authorization", "Bearer "+key)
+		}
+		if socketRole != "" {
+			req = req.WithContext(context.WithValue(req.Context(), peerKey{}, &peer{socket: true, role: socketRole}))
+		}
+		w := httptest.NewRecorder()
+		h.ServeHTTP(w, req)
+		return w
+	}
+
+	// Not served at all unless profiling is enabled
+	if w := get(s.routes(), "/api/debug/goroutines", "admin-key", ""); w.Code != http.StatusNotFound {
+		t.Errorf("goroutine dump with profiling off returned %d, want 404", w.Code)
+	}
+
+	s.SetProfilingEnabled(true)
+	h := s.routes()
+	paths := []string{DebugPathPrefix, DebugPathPrefix + "heap", DebugPathPrefix + "profile", DebugPathPrefix + "trace", "/api/debug/goroutines"}
+	for _, path := range paths {
+		for _, key := range []string{"operator-key", "viewer-key"} {
+			if w := get(h, path, key, ""); w.Code != http.StatusForbidden {
+				t.Errorf("%s with %s returned %d, want 403", path, key, w.Code)
+			}
+		}
+		// Socket peers get the socket's role, which isn't admin
+		if w := get(h, path, "", RoleOperator); w.Code != http.StatusForbidden {
+			t.Errorf("%s from an operator socket peer returned %d, want 403", path, w.Code)
+		}
+		if w := get(h, path, "", ""); w.Code != http.StatusUnauthorized {
+			t.Errorf("%s without a key returned %d, want 401", path, w.Code)
+		}
+	}
+
+	w := get(h, DebugPathPrefix, "admin-key", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), DebugPathPrefix+"heap") {
+		t.Errorf("profile index returned %d: %s", w.Code, w.Body.String())
+	}
+	w = get(h, "/api/debug/goroutines", "admin-key", "")
+	if w.Code != http.StatusOK || !strings.Contains(w.Body.String(), "goroutine ") {
+		t.Errorf("goroutine dump returned %d", w.Code)
+	}
+}
@@ -0,0 +1,95 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"dnshield/internal/audit"
+)
+
+// EventStreamPath streams answered queries and audit events, blocks among
+// them, as server-sent events
+const EventStreamPath = "/api/events/stream"
+
+// Server-sent event names on the event stream
+const (
+	StreamEventQuery = "query" // Data is a QueryStreamEvent
+	StreamEventAudit = "audit" // Data is an audit.Event
+)
+
+// PublishAudit sends an audit event to event stream subscribers. It never
+// blocks, so it can be an audit forwarder.
+func (s *Server) PublishAudit(event audit.Event) {
+	if s.auditStream.hasSubscribers() {
+		s.auditStream.publish(event)
+	}
+}
+
+// handleEventStream streams queries and audit events as they happen until
+// the client disconnects
+func (s *Server) handleEventStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	queries, cancelQueries, err := s.SubscribeQueries()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer cancelQueries()
+	events := s.auditStream.subscribe()
+	if events == nil {
+		http.Error(w, "Too many stream clients", http.StatusServiceUnavailable)
+		return
+	}
+	defer s.auditStream.unsubscribe(events)
+
+	// The server's write timeout would end the stream after 10 seconds
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	for {
+		var err error
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-queries:
+			err = writeStreamEvent(w, StreamEventQuery, event)
+		case event := <-events:
+			err = writeStreamEvent(w, StreamEventAudit, event)
+		case <-keepalive.C:
+			_, err = w.Write([]byte(": keepalive\n\n"))
+		}
+		if err != nil {
+			return
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
+
+// writeStreamEvent writes one server-sent event with data encoded as JSON
+func writeStreamEvent(w http.ResponseWriter, name string, data interface{}) error {
+	encoded, err := json.Marshal(data)
+	if err != nil {
+		return nil // Skipped rather than ending the stream
+	}
+	_, err = fmt.Fprintf(w, "event: %s\ndata: %s\n\n", name, encoded)
+	return err
+}
@@ -0,0 +1,105 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/dns"
+)
+
+func TestEventStream(t *testing.T) {
+	s := NewServer(nil)
+	ts := httptest.NewServer(http.HandlerFunc(s.handleEventStream))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	s.RecordQuery(dns.QueryEvent{
+		Timestamp: time.Now(),
+		Domain:    "ads.example.com",
+		QueryType: "A",
+		Action:    dns.QueryActionBlocked,
+		Rule:      "ads.example.com",
+	})
+	scanner := bufio.NewScanner(resp.Body)
+	name, data := nextStreamEvent(t, scanner)
+	var query QueryStreamEvent
+	if name != StreamEventQuery || json.Unmarshal([]byte(data), &query) != nil || query.Rule != "ads.example.com" {
+		t.Fatalf("Got event %q: %s", name, data)
+	}
+
+	s.PublishAudit(audit.NewEvent(audit.EventDGADetected, "warning", "Possible DGA domain", nil))
+	name, data = nextStreamEvent(t, scanner)
+	var event audit.Event
+	if name != StreamEventAudit || json.Unmarshal([]byte(data), &event) != nil || event.Type != audit.EventDGADetected {
+		t.Fatalf("Got event %q: %s", name, data)
+	}
+
+	// Disconnecting unsubscribes from both streams
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.queryStream.hasSubscribers() || s.auditStream.hasSubscribers() {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber not removed after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+// nextStreamEvent reads one server-sent event
+func nextStreamEvent(t *testing.T, scanner *bufio.Scanner) (name, data string) {
+	t.Helper()
+	for scanner.Scan() {
+		line := scanner.Text()
+		switch {
+		case line == "" && data != "":
+			return name, data
+		case strings.HasPrefix(line, "event: "):
+			name = strings.TrimPrefix(line, "event: ")
+		case strings.HasPrefix(line, "data: "):
+			data = strings.TrimPrefix(line, "data: ")
+		}
+	}
+	t.Fatalf("Stream ended: %v", scanner.Err())
+	return
+}
+
+func TestMarkBlockPageHit(t *testing.T) {
+	s := NewServer(nil)
+	blockedAt := time.Now()
+	s.AddBlockedDomain(dns.BlockEvent{Domain: "ads.example.com", Timestamp: blockedAt.Add(-time.Minute), Rule: "ads.example.com"})
+	s.AddBlockedDomain(dns.BlockEvent{Domain: "ads.example.com", Timestamp: blockedAt, Rule: "*.example.com", Source: "base"})
+
+	if _, ok := s.MarkBlockPageHit("other.example.com"); ok {
+		t.Error("MarkBlockPageHit() found a block for a domain never blocked")
+	}
+
+	// The latest block is flagged and returned so the visit can be
+	// logged against it
+	block, ok := s.MarkBlockPageHit("ads.example.com")
+	if !ok || !block.BlockPageHit || !block.Timestamp.Equal(blockedAt) || block.Rule != "*.example.com" || block.RuleSource != "base" {
+		t.Fatalf("MarkBlockPageHit() = %+v, %v, want the latest block", block, ok)
+	}
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+	if s.recentBlocked[0].BlockPageHit || !s.recentBlocked[1].BlockPageHit {
+		t.Errorf("recent blocks = %+v, want only the latest flagged", s.recentBlocked)
+	}
+}
@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"dnshield/internal/utils"
+)
+
+// ExplainPath is the rule tracing endpoint
+const ExplainPath = "/api/explain"
+
+// DomainExplanation is the response to /api/explain: whether a domain is
+// blocked, the rule that decided it, and every other rule that matches
+type DomainExplanation struct {
+	Domain  string `json:"domain"`
+	Blocked bool   `json:"blocked"`
+	// Matches are only logged because enforcement is monitor
+	Monitored bool   `json:"monitored,omitempty"`
+	Verdict   string `json:"verdict"`
+
+	// The deciding rule, if any
+	Rule     string   `json:"rule,omitempty"`
+	Source   string   `json:"source,omitempty"`
+	Category string   `json:"category,omitempty"`
+	Files    []string `json:"files,omitempty"`
+
+	Matches []RuleMatch `json:"matches"`
+
+	CaptivePortal     bool   `json:"captive_portal"`
+	AllowOnly         bool   `json:"allow_only_mode"`
+	AllowOnlySchedule string `json:"allow_only_schedule,omitempty"`
+	Precedence        string `json:"allowlist_precedence"`
+	Enforcement       string `json:"enforcement"`
+}
+
+// RuleMatch is a rule matching the explained domain. Files are the rules
+// files (base, group or user) that list the rule or its source.
+type RuleMatch struct {
+	List   string   `json:"list"`
+	Rule   string   `json:"rule"`
+	Source string   `json:"source,omitempty"`
+	Files  []string `json:"files,omitempty"`
+}
+
+// SetExplainCallback sets the function /api/explain calls to trace a domain
+func (s *Server) SetExplainCallback(cb func(domain string) *DomainExplanation) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.explain = cb
+}
+
+// handleExplain reports why the domain parameter is blocked or allowed
+func (s *Server) handleExplain(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	explain := s.explain
+	s.mu.RUnlock()
+	if explain == nil {
+		http.Error(w, "Rule tracing is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	domain := strings.TrimSpace(r.URL.Query().Get("domain"))
+	if domain == "" {
+		http.Error(w, "Domain required", http.StatusBadRequest)
+		return
+	}
+	if err := utils.ValidateDomainLength(domain); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(explain(domain))
+}
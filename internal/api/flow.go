@@ -0,0 +1,108 @@
+package api
+
+import (
+	"encoding/json"
+	"net"
+	"net/http"
+	"time"
+
+	"dnshield/internal/dns"
+)
+
+// FlowVerdictPath is the flow verdict endpoint
+const FlowVerdictPath = "/api/flow/verdict"
+
+// DefaultFlowVerdictRateLimit applies to the flow verdict endpoint unless
+// configured otherwise; the extension asks once per new connection
+var DefaultFlowVerdictRateLimit = RateLimit{Requests: 6000, Window: time.Minute, Burst: 500}
+
+// Flow actions returned in FlowVerdictResponse.Action
+const (
+	FlowActionAllow = "allow"
+	FlowActionBlock = "block"
+)
+
+// FlowVerdictRequest describes a new TCP or UDP flow seen by the
+// transparent proxy extension
+type FlowVerdictRequest struct {
+	Hostname string `json:"hostname,omitempty"` // Remote hostname if the app named one
+	IP       string `json:"ip,omitempty"`       // Remote address
+	Port     int    `json:"port,omitempty"`
+	Protocol string `json:"protocol"`      // "tcp" or "udp"
+	App      string `json:"app,omitempty"` // Signing identifier of the source app
+}
+
+// FlowVerdictResponse tells the extension whether to pass or drop a flow
+type FlowVerdictResponse struct {
+	Action    string `json:"action"`
+	Domain    string `json:"domain,omitempty"`
+	MatchedBy string `json:"matched_by,omitempty"`
+	Rule      string `json:"rule,omitempty"`
+	Category  string `json:"category,omitempty"`
+}
+
+// SetFlowFilter enables the flow verdict endpoint
+func (s *Server) SetFlowFilter(filter *dns.FlowFilter) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.flowFilter = filter
+}
+
+// handleFlowVerdict applies the block rules to a flow
+func (s *Server) handleFlowVerdict(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	filter := s.flowFilter
+	s.mu.RUnlock()
+	if filter == nil {
+		http.Error(w, "Transparent proxy mode not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req FlowVerdictRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	if req.Protocol != "tcp" && req.Protocol != "udp" {
+		http.Error(w, "Protocol must be tcp or udp", http.StatusBadRequest)
+		return
+	}
+	ip := net.ParseIP(req.IP)
+	if req.Hostname == "" && ip == nil {
+		http.Error(w, "Hostname or IP required", http.StatusBadRequest)
+		return
+	}
+
+	clientIP := ""
+	if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		clientIP = host
+	}
+
+	verdict := filter.Check(req.Hostname, ip, req.Protocol, clientIP)
+
+	s.mu.Lock()
+	s.stats.FlowsChecked++
+	if verdict.Blocked {
+		s.stats.FlowsBlocked++
+	}
+	s.mu.Unlock()
+
+	resp := FlowVerdictResponse{
+		Action:    FlowActionAllow,
+		Domain:    verdict.Domain,
+		MatchedBy: verdict.MatchedBy,
+	}
+	if verdict.Blocked {
+		resp.Action = FlowActionBlock
+		resp.Rule = verdict.Match.Rule
+		resp.Category = verdict.Match.Category()
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resp)
+}
@@ -0,0 +1,220 @@
+package api
+
+import (
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/subtle"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"net/netip"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dnshield/internal/utils"
+)
+
+// keyIDLength is how many leading characters of a key identify it in the
+// store and in audit events
+const keyIDLength = 16
+
+// KeyStore is the API key file. Only salted hashes of the keys are kept; a
+// key is shown once, when it is generated.
+type KeyStore struct {
+	Keys map[string]*StoredKey `json:"keys"` // By key ID
+}
+
+// StoredKey is an API key in the store
+type StoredKey struct {
+	ID   string `json:"id"`
+	Hash string `json:"hash"` // SHA-256 of the salt and the key, hex
+	Salt string `json:"salt"`
+	// Plaintext key written before keys were hashed; hashed on load
+	Key         string    `json:"key,omitempty"`
+	Role        string    `json:"role"`
+	CreatedAt   time.Time `json:"created_at"`
+	ExpiresAt   time.Time `json:"expires_at,omitempty"`
+	Disabled    bool      `json:"disabled"`
+	Description string    `json:"description,omitempty"`
+	// API paths the key may call, on top of its role's permissions; a
+	// trailing * matches a prefix. Empty allows every path.
+	Scopes []string `json:"scopes,omitempty"`
+	// Addresses or CIDR ranges the key may be used from. Empty allows any;
+	// a restricted key is refused on the Unix sockets.
+	AllowedIPs []string `json:"allowed_ips,omitempty"`
+	// ID of the key this one replaced
+	RotatedFrom string `json:"rotated_from,omitempty"`
+}
+
+// KeyStorePath returns the path of the current user's API key store
+func KeyStorePath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".dnshield", "api_keys.json")
+}
+
+// GenerateKey returns a new random API key
+func GenerateKey() (string, error) {
+	b := make([]byte, 32)
+	if _, err := rand.Read(b); err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(b), nil
+}
+
+// KeyID returns the identifier of key: its first characters
+func KeyID(key string) string {
+	if len(key) > keyIDLength {
+		return key[:keyIDLength]
+	}
+	return key
+}
+
+// SetKey stores a salted hash of key
+func (k *StoredKey) SetKey(key string) error {
+	salt := make([]byte, 16)
+	if _, err := rand.Read(salt); err != nil {
+		return err
+	}
+	k.ID = KeyID(key)
+	k.Salt = hex.EncodeToString(salt)
+	k.Hash = hashKey(salt, key)
+	k.Key = ""
+	return nil
+}
+
+// Matches reports whether key is the stored key
+func (k *StoredKey) Matches(key string) bool {
+	return keyMatches(k.Salt, k.Hash, key)
+}
+
+// Active reports whether the key is neither revoked nor expired
+func (k *StoredKey) Active() bool {
+	return !k.Disabled && (k.ExpiresAt.IsZero() || time.Now().Before(k.ExpiresAt))
+}
+
+// keyMatches reports whether key hashes to hash with salt, both hex
+func keyMatches(salt, hash, key string) bool {
+	saltBytes, err := hex.DecodeString(salt)
+	if err != nil || hash == "" {
+		return false
+	}
+	return subtle.ConstantTimeCompare([]byte(hashKey(saltBytes, key)), []byte(hash)) == 1
+}
+
+func hashKey(salt []byte, key string) string {
+	h := sha256.New()
+	h.Write(salt)
+	h.Write([]byte(key))
+	return hex.EncodeToString(h.Sum(nil))
+}
+
+// ParseAllowedIPs parses addresses and CIDR ranges
+func ParseAllowedIPs(values []string) ([]netip.Prefix, error) {
+	prefixes := make([]netip.Prefix, 0, len(values))
+	for _, v := range values {
+		if strings.Contains(v, "/") {
+			prefix, err := netip.ParsePrefix(v)
+			if err != nil {
+				return nil, fmt.Errorf("invalid CIDR range %q", v)
+			}
+			prefixes = append(prefixes, prefix.Masked())
+			continue
+		}
+		addr, err := netip.ParseAddr(v)
+		if err != nil {
+			return nil, fmt.Errorf("invalid IP address %q", v)
+		}
+		prefixes = append(prefixes, netip.PrefixFrom(addr, addr.BitLen()))
+	}
+	return prefixes, nil
+}
+
+// ValidateScope checks an API path scope
+func ValidateScope(scope string) error {
+	if !strings.HasPrefix(scope, "/api/") {
+		return fmt.Errorf("invalid scope %q: must be an /api/ path", scope)
+	}
+	if i := strings.Index(scope, "*"); i >= 0 && i != len(scope)-1 {
+		return fmt.Errorf("invalid scope %q: * is only allowed at the end", scope)
+	}
+	return nil
+}
+
+// LoadKeyStore reads the store at path; a missing file is an empty store.
+// Plaintext keys from older stores are hashed, and migrated reports
+// whether there were any, so the caller can save the store.
+func LoadKeyStore(path string) (store *KeyStore, migrated bool, err error) {
+	store = &KeyStore{Keys: make(map[string]*StoredKey)}
+	info, err := os.Stat(path)
+	if os.IsNotExist(err) {
+		return store, false, nil
+	}
+	if err != nil {
+		return nil, false, err
+	}
+	if info.Size() > utils.MaxConfigFileSize {
+		return nil, false, fmt.Errorf("API key store file exceeds maximum size of %d bytes", utils.MaxConfigFileSize)
+	}
+
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, false, fmt.Errorf("failed to read API key store: %w", err)
+	}
+	var stored KeyStore
+	if err := json.Unmarshal(data, &stored); err != nil {
+		return nil, false, fmt.Errorf("failed to parse API key store: %w", err)
+	}
+
+	for _, k := range stored.Keys {
+		if k.Key != "" {
+			if err := k.SetKey(k.Key); err != nil {
+				return nil, false, err
+			}
+			migrated = true
+		}
+		store.Keys[k.ID] = k
+	}
+	return store, migrated, nil
+}
+
+// Save writes the store to path, readable only by its owner
+func (ks *KeyStore) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return fmt.Errorf("failed to create directory: %w", err)
+	}
+	data, err := json.MarshalIndent(ks, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to marshal API key store: %w", err)
+	}
+	// Replace the file whole, so the agent never reads half a store
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return fmt.Errorf("failed to write API key store: %w", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write API key store: %w", err)
+	}
+	return nil
+}
+
+// Find returns the key whose ID starts with prefix, which must match
+// exactly one key
+func (ks *KeyStore) Find(prefix string) (*StoredKey, error) {
+	prefix = KeyID(prefix)
+	var found *StoredKey
+	for id, k := range ks.Keys {
+		if strings.HasPrefix(id, prefix) {
+			if found != nil {
+				return nil, fmt.Errorf("multiple keys match the prefix: %s", prefix)
+			}
+			found = k
+		}
+	}
+	if found == nil {
+		return nil, fmt.Errorf("API key not found: %s", prefix)
+	}
+	return found, nil
+}
@@ -0,0 +1,99 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestLoadKeyStoreHashesPlaintextKeys(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "api_keys.json")
+	const key = "0123456789abcdef0123456789abcdef"
+	legacy := `{"keys":{"` + key + `":{"key":"` + key + `","role":"admin"}}}`
+	if err := os.WriteFile(path, []byte(legacy), 0600); err != nil {
+		t.Fatal(err)
+	}
+
+	store, migrated, err := LoadKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !migrated {
+		t.Error("Plaintext store not reported as migrated")
+	}
+	if err := store.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	data, _ := os.ReadFile(path)
+	if strings.Contains(string(data), key) {
+		t.Error("Saved store still contains the plaintext key")
+	}
+
+	store, migrated, err = LoadKeyStore(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if migrated {
+		t.Error("Hashed store reported as migrated")
+	}
+	stored, ok := store.Keys[KeyID(key)]
+	if !ok {
+		t.Fatalf("Key not stored under its ID %s", KeyID(key))
+	}
+	if !stored.Matches(key) {
+		t.Error("Stored hash doesn't match the key")
+	}
+	if stored.Matches(key + "0") {
+		t.Error("Stored hash matches a different key")
+	}
+}
+
+func TestAPIKeyRestrictions(t *testing.T) {
+	server := &Server{rbacManager: NewRBACManager(), config: &Config{}}
+	var stored StoredKey
+	const key = "fedcba9876543210fedcba9876543210"
+	if err := stored.SetKey(key); err != nil {
+		t.Fatal(err)
+	}
+	allowedIPs, err := ParseAllowedIPs([]string{"10.0.0.0/8", "192.168.1.5"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	server.rbacManager.setAPIKeys(map[string]*APIKey{stored.ID: {
+		Key:        stored.ID,
+		Hash:       stored.Hash,
+		Salt:       stored.Salt,
+		Role:       RoleAdmin,
+		Scopes:     []string{"/api/status", "/api/rules/*"},
+		AllowedIPs: allowedIPs,
+	}})
+	handler := server.RBACMiddleware(PermissionViewStatus, func(w http.ResponseWriter, r *http.Request) {})
+
+	tests := []struct {
+		path       string
+		remoteAddr string
+		want       int
+	}{
+		{"/api/status", "10.1.2.3:5000", http.StatusOK},
+		{"/api/rules/local", "192.168.1.5:5000", http.StatusOK},
+		{"/api/statistics", "10.1.2.3:5000", http.StatusForbidden},
+		{"/api/status", "192.168.1.6:5000", http.StatusForbidden},
+	}
+	for _, tt := range tests {
+		req := httptest.NewRequest(http.MethodGet, tt.path, nil)
+		req.RemoteAddr = tt.remoteAddr
+		req.Header.Set("Authorization", "Bearer "+key)
+		rr := httptest.NewRecorder()
+		handler(rr, req)
+		if rr.Code != tt.want {
+			t.Errorf("%s from %s returned %d, want %d", tt.path, tt.remoteAddr, rr.Code, tt.want)
+		}
+	}
+
+	if _, err := server.Authorize(key, "/api/status", PermissionViewStatus); err == nil {
+		t.Error("Key restricted to addresses authorized on a socket")
+	}
+}
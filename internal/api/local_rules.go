@@ -0,0 +1,89 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+
+	"dnshield/internal/unblock"
+)
+
+// Local rule endpoints
+const (
+	RulesAllowPath = "/api/rules/allow"
+	RulesBlockPath = "/api/rules/block"
+)
+
+// LocalRuleRequest adds a domain, and its subdomains, to a local list
+type LocalRuleRequest struct {
+	Domain string `json:"domain"`
+}
+
+// LocalRules lists the domains allowed and blocked on this device
+type LocalRules struct {
+	Allow []string `json:"allow"`
+	Block []string `json:"block"`
+}
+
+// SetLocalOverrides sets the local lists behind the local rule endpoints
+func (s *Server) SetLocalOverrides(overrides *unblock.Overrides) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.overrides = overrides
+}
+
+// handleLocalRules returns a handler that lists the local rules (GET),
+// adds a domain to list (POST) or removes one from it (DELETE ?domain=)
+func (s *Server) handleLocalRules(list string) http.HandlerFunc {
+	return func(w http.ResponseWriter, r *http.Request) {
+		s.mu.RLock()
+		overrides := s.overrides
+		s.mu.RUnlock()
+		if overrides == nil {
+			http.Error(w, "Local rules are not enabled", http.StatusServiceUnavailable)
+			return
+		}
+		clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+		switch r.Method {
+		case http.MethodGet:
+		case http.MethodPost:
+			var req LocalRuleRequest
+			if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+				http.Error(w, "Invalid request", http.StatusBadRequest)
+				return
+			}
+			err := overrides.Add(list, req.Domain, clientIP)
+			switch {
+			case errors.Is(err, unblock.ErrNotOverridable):
+				http.Error(w, err.Error(), http.StatusForbidden)
+				return
+			case errors.Is(err, unblock.ErrOverrideLimit):
+				http.Error(w, err.Error(), http.StatusConflict)
+				return
+			case err != nil:
+				http.Error(w, err.Error(), http.StatusBadRequest)
+				return
+			}
+		case http.MethodDelete:
+			domain := r.URL.Query().Get("domain")
+			removed, err := overrides.Remove(list, domain, clientIP)
+			if err != nil {
+				http.Error(w, err.Error(), http.StatusInternalServerError)
+				return
+			}
+			if !removed {
+				http.Error(w, "No local "+list+" rule for "+domain, http.StatusNotFound)
+				return
+			}
+		default:
+			http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+			return
+		}
+
+		allow, block := overrides.Lists()
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(LocalRules{Allow: allow, Block: block})
+	}
+}
@@ -0,0 +1,134 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"math/big"
+	"net"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// tlsCertValidity is how long the API's server certificate is valid
+	tlsCertValidity = 7 * 24 * time.Hour
+	// tlsCertRenewBefore is how long before expiry it is replaced
+	tlsCertRenewBefore = 24 * time.Hour
+)
+
+// CertIssuer signs the API's server certificate; the DNShield CA
+// (ca.Manager) is one
+type CertIssuer interface {
+	Certificate() *x509.Certificate
+	SignCertificate(template, parent *x509.Certificate, pub crypto.PublicKey) ([]byte, error)
+}
+
+// TLSConfig controls the mutual TLS listener, which serves the API beyond
+// loopback to clients holding a certificate from the fleet CA
+type TLSConfig struct {
+	Address string // e.g. "0.0.0.0:5443"
+	// Issues the server certificate
+	Issuer CertIssuer
+	// Host names and IP addresses in the server certificate
+	ServerNames []string
+	// CAs whose client certificates are accepted
+	ClientCAs *x509.CertPool
+	// Role of clients that send no API key; empty requires a key
+	Role Role
+}
+
+// StartTLS serves the API with mutual TLS until Stop. Clients without a
+// certificate signed by cfg.ClientCAs fail the handshake.
+func (s *Server) StartTLS(cfg TLSConfig) error {
+	certs := &serverCert{issuer: cfg.Issuer, names: cfg.ServerNames}
+	if _, err := certs.get(nil); err != nil {
+		return fmt.Errorf("failed to issue API server certificate: %w", err)
+	}
+
+	listener, err := net.Listen("tcp", cfg.Address)
+	if err != nil {
+		return err
+	}
+	listener = tls.NewListener(listener, &tls.Config{
+		MinVersion:     tls.VersionTLS12,
+		GetCertificate: certs.get,
+		ClientAuth:     tls.RequireAndVerifyClientCert,
+		ClientCAs:      cfg.ClientCAs,
+	})
+
+	logrus.WithFields(logrus.Fields{
+		"address": cfg.Address,
+		"names":   cfg.ServerNames,
+	}).Info("Starting API server with mutual TLS")
+	return s.serve(listener, func(ctx context.Context, c net.Conn) context.Context {
+		return context.WithValue(ctx, peerKey{}, &peer{role: cfg.Role})
+	})
+}
+
+// serverCert issues the API's server certificate and replaces it before
+// it expires
+type serverCert struct {
+	issuer CertIssuer
+	names  []string
+
+	mu   sync.Mutex
+	cert *tls.Certificate
+}
+
+func (c *serverCert) get(*tls.ClientHelloInfo) (*tls.Certificate, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if c.cert != nil && time.Until(c.cert.Leaf.NotAfter) > tlsCertRenewBefore {
+		return c.cert, nil
+	}
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		return nil, err
+	}
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 128))
+	if err != nil {
+		return nil, err
+	}
+	template := &x509.Certificate{
+		SerialNumber: serial,
+		Subject:      pkix.Name{CommonName: "DNShield API"},
+		NotBefore:    time.Now().Add(-5 * time.Minute),
+		NotAfter:     time.Now().Add(tlsCertValidity),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+	}
+	for _, name := range c.names {
+		if ip := net.ParseIP(name); ip != nil {
+			template.IPAddresses = append(template.IPAddresses, ip)
+		} else {
+			template.DNSNames = append(template.DNSNames, name)
+		}
+	}
+
+	der, err := c.issuer.SignCertificate(template, c.issuer.Certificate(), key.Public())
+	if err != nil {
+		return nil, err
+	}
+	leaf, err := x509.ParseCertificate(der)
+	if err != nil {
+		return nil, err
+	}
+	c.cert = &tls.Certificate{
+		Certificate: [][]byte{der, c.issuer.Certificate().Raw},
+		PrivateKey:  key,
+		Leaf:        leaf,
+	}
+	logrus.WithField("expires", leaf.NotAfter).Info("Issued API server certificate")
+	return c.cert, nil
+}
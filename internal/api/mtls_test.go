@@ -0,0 +1,131 @@
+package api
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"net"
+	"net/http"
+	"testing"
+	"time"
+)
+
+// testCA is a CA for tests; it is also a CertIssuer
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T, name string) *testCA {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, _ := x509.ParseCertificate(der)
+	return &testCA{cert: cert, key: key}
+}
+
+func (ca *testCA) Certificate() *x509.Certificate { return ca.cert }
+
+func (ca *testCA) SignCertificate(template, parent *x509.Certificate, pub crypto.PublicKey) ([]byte, error) {
+	return x509.CreateCertificate(rand.Reader, template, parent, pub, ca.key)
+}
+
+// clientCert issues a client certificate for name
+func (ca *testCA) clientCert(t *testing.T, name string) tls.Certificate {
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	der, err := ca.SignCertificate(&x509.Certificate{
+		SerialNumber: big.NewInt(2),
+		Subject:      pkix.Name{CommonName: name},
+		NotBefore:    time.Now().Add(-time.Hour),
+		NotAfter:     time.Now().Add(time.Hour),
+		KeyUsage:     x509.KeyUsageDigitalSignature,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageClientAuth},
+	}, ca.cert, key.Public())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return tls.Certificate{Certificate: [][]byte{der}, PrivateKey: key}
+}
+
+func TestStartTLS(t *testing.T) {
+	agentCA := newTestCA(t, "DNShield CA")
+	fleetCA := newTestCA(t, "Fleet CA")
+	otherCA := newTestCA(t, "Other CA")
+
+	// Pick a free port
+	l, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+
+	clientCAs := x509.NewCertPool()
+	clientCAs.AddCert(fleetCA.cert)
+	s := NewServer(nil)
+	go s.StartTLS(TLSConfig{
+		Address:     addr,
+		Issuer:      agentCA,
+		ServerNames: []string{"127.0.0.1"},
+		ClientCAs:   clientCAs,
+		Role:        RoleViewer,
+	})
+	defer s.Stop(context.Background())
+
+	roots := x509.NewCertPool()
+	roots.AddCert(agentCA.cert)
+	get := func(certs ...tls.Certificate) (*http.Response, error) {
+		client := &http.Client{Transport: &http.Transport{TLSClientConfig: &tls.Config{
+			RootCAs:      roots,
+			Certificates: certs,
+		}}}
+		return client.Get("https://" + addr + "/api/status")
+	}
+
+	var resp *http.Response
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		resp, err = get(fleetCA.clientCert(t, "collector-1"))
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Request with a fleet certificate failed: %v", err)
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		t.Errorf("Status with a fleet certificate and no key returned %d, want 200", resp.StatusCode)
+	}
+
+	if _, err := get(); err == nil {
+		t.Error("Request without a client certificate succeeded")
+	}
+	if _, err := get(otherCA.clientCert(t, "intruder")); err == nil {
+		t.Error("Request with a certificate from another CA succeeded")
+	}
+}
@@ -0,0 +1,316 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"strings"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// maxPauseReasonLength is the longest reason accepted for a pause
+const maxPauseReasonLength = 500
+
+type PauseRequest struct {
+	Duration string `json:"duration"` // "5m", "30m", "1h"
+	// Why protection is paused; required, and recorded in the audit log
+	Reason string `json:"reason"`
+}
+
+// SetCurrentUser sets the function that returns the device's user and
+// group, recorded with pauses
+func (s *Server) SetCurrentUser(fn func() (user, group string)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.currentUser = fn
+}
+
+// Caller identifies who made a request, for the audit log
+type Caller struct {
+	Role     Role    // Empty if unknown
+	ClientIP string  // Or "unix" for socket clients
+	UID      *uint32 // Socket client's uid, if known
+	// Subject of the client certificate of mutual TLS clients
+	ClientCert string
+}
+
+// requestCaller returns the caller of an HTTP request
+func (s *Server) requestCaller(r *http.Request) Caller {
+	var c Caller
+	if role, ok := s.requestRole(r); ok {
+		c.Role = role
+	}
+	if p, ok := peerFrom(r); ok && p.socket {
+		c.ClientIP = "unix"
+		c.UID = &p.uid
+	} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+		c.ClientIP = host
+	}
+	if r.TLS != nil && len(r.TLS.PeerCertificates) > 0 {
+		c.ClientCert = r.TLS.PeerCertificates[0].Subject.String()
+	}
+	return c
+}
+
+func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	allowPause := s.config.AllowPause
+	s.mu.RUnlock()
+	if !allowPause {
+		http.Error(w, "Pause not allowed by policy", http.StatusForbidden)
+		return
+	}
+
+	var req PauseRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 4096)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	until, err := s.Pause(req, s.requestCaller(r))
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+
+	// Pause accepted the duration, so it parses
+	duration, _ := time.ParseDuration(req.Duration)
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{
+		"status":   "paused",
+		"duration": duration.String(),
+		"until":    until.UTC().Format(time.RFC3339),
+	})
+}
+
+// Pause pauses protection for req.Duration and returns when the pause
+// ends. It is refused when pausing isn't allowed, without a reason, or for
+// longer than the maximum pause.
+func (s *Server) Pause(req PauseRequest, caller Caller) (time.Time, error) {
+	s.mu.RLock()
+	allowPause := s.config.AllowPause
+	maxPause := time.Duration(s.config.MaxPauseSeconds) * time.Second
+	s.mu.RUnlock()
+	if !allowPause {
+		return time.Time{}, errorf(http.StatusForbidden, "Pause not allowed by policy")
+	}
+
+	reason := strings.TrimSpace(req.Reason)
+	if reason == "" {
+		return time.Time{}, errorf(http.StatusBadRequest, "A reason is required to pause protection")
+	}
+	if len(reason) > maxPauseReasonLength {
+		return time.Time{}, errorf(http.StatusBadRequest, "Reason is longer than %d characters", maxPauseReasonLength)
+	}
+
+	// Parse duration
+	duration, err := time.ParseDuration(req.Duration)
+	if err != nil || duration <= 0 {
+		return time.Time{}, errorf(http.StatusBadRequest, "Invalid duration format")
+	}
+	if maxPause > 0 && duration > maxPause {
+		audit.Log(audit.EventPolicyDenied, "warning", "Pause longer than policy allows", s.callerDetails(caller, map[string]interface{}{
+			"requested": duration.String(),
+			"max":       maxPause.String(),
+			"reason":    reason,
+		}))
+		return time.Time{}, errorf(http.StatusForbidden, "Pause longer than policy allows (max %s)", maxPause)
+	}
+
+	// Pause DNS filtering
+	if s.dnsManager != nil {
+		if err := s.dnsManager.PauseDNSFiltering(duration); errors.Is(err, dns.ErrStrictNetwork) {
+			audit.Log(audit.EventPolicyDenied, "warning", "Pause refused on strict network", s.callerDetails(caller, map[string]interface{}{
+				"requested": duration.String(),
+				"reason":    reason,
+			}))
+			return time.Time{}, errorf(http.StatusForbidden, "Pause not allowed on this network")
+		} else if err != nil {
+			logrus.WithError(err).Error("Failed to pause DNS filtering")
+			return time.Time{}, errorf(http.StatusInternalServerError, "Failed to pause protection")
+		}
+	}
+
+	until := time.Now().Add(duration)
+	s.startPause(until, reason, "")
+
+	logrus.WithFields(logrus.Fields{"duration": duration, "reason": reason}).Warn("Protection paused")
+	audit.Log(audit.EventProtectionPaused, "warning", fmt.Sprintf("Protection paused for %s", duration), s.callerDetails(caller, map[string]interface{}{
+		"duration": duration.String(),
+		"until":    until.UTC().Format(time.RFC3339),
+		"reason":   reason,
+	}))
+	return until, nil
+}
+
+func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	if err := s.Resume(s.requestCaller(r)); err != nil {
+		writeError(w, err)
+		return
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(map[string]string{"status": "resumed"})
+}
+
+// Resume ends a pause
+func (s *Server) Resume(caller Caller) error {
+	// Resume DNS filtering
+	if s.dnsManager != nil {
+		if err := s.dnsManager.ResumeDNSFiltering(); err != nil {
+			logrus.WithError(err).Error("Failed to resume DNS filtering")
+			return errorf(http.StatusInternalServerError, "Failed to resume protection")
+		}
+	}
+
+	logrus.Info("Resumed protection")
+	s.endPause("Protection resumed", s.callerDetails(caller, nil))
+	return nil
+}
+
+// PauseForMaintenance pauses protection until the maintenance window name
+// closes. The maximum pause doesn't apply, and the window's opening and
+// closing are audited by its runner.
+func (s *Server) PauseForMaintenance(name string, until time.Time) error {
+	duration := time.Until(until)
+	if duration <= 0 {
+		return nil
+	}
+	if s.dnsManager != nil {
+		if err := s.dnsManager.PauseDNSFiltering(duration); err != nil {
+			return err
+		}
+	}
+	s.startPause(until, "Maintenance window "+name, name)
+	return nil
+}
+
+// EndMaintenancePause resumes protection if the maintenance window name
+// paused it and it is still paused
+func (s *Server) EndMaintenancePause(name string) {
+	s.mu.RLock()
+	ours := s.paused && s.pauseWindow == name
+	s.mu.RUnlock()
+	if !ours {
+		return
+	}
+
+	if s.dnsManager != nil && s.dnsManager.IsPaused() {
+		if err := s.dnsManager.ResumeDNSFiltering(); err != nil {
+			logrus.WithError(err).Error("Failed to resume DNS filtering after maintenance")
+		}
+	}
+	s.endPause("", nil)
+}
+
+// startPause records a pause until until, started by the maintenance
+// window, if any, and tells WebSocket clients
+func (s *Server) startPause(until time.Time, reason, window string) {
+	s.mu.Lock()
+	s.paused = true
+	s.pausedUntil = until
+	s.pauseReason = reason
+	s.pauseWindow = window
+	if s.pauseTimer != nil {
+		s.pauseTimer.Stop()
+	}
+	s.pauseTimer = time.AfterFunc(time.Until(until), s.pauseExpired)
+	s.mu.Unlock()
+	s.ws.BroadcastProtectionState(s.protectionState())
+}
+
+// pauseExpired ends a pause that ran out. The DNS manager resumes
+// filtering on its own timer; this resumes it should that have failed.
+func (s *Server) pauseExpired() {
+	s.mu.RLock()
+	expired := s.paused && !time.Now().Before(s.pausedUntil)
+	window := s.pauseWindow
+	s.mu.RUnlock()
+	if !expired {
+		return
+	}
+
+	if s.dnsManager != nil && s.dnsManager.IsPaused() {
+		if err := s.dnsManager.ResumeDNSFiltering(); err != nil {
+			logrus.WithError(err).Error("Failed to resume DNS filtering after pause")
+		}
+	}
+	logrus.Info("Protection resumed after pause")
+	if window != "" {
+		// The maintenance runner records the window closing
+		s.endPause("", nil)
+		return
+	}
+	s.endPause("Pause ended", s.withUser(map[string]interface{}{"expired": true}))
+}
+
+// endPause records that protection was resumed and tells WebSocket
+// clients. If protection was paused and details aren't nil, the resume is
+// audited with details and the pause's reason.
+func (s *Server) endPause(message string, details map[string]interface{}) {
+	s.mu.Lock()
+	wasPaused := s.paused
+	reason := s.pauseReason
+	if s.pauseTimer != nil {
+		s.pauseTimer.Stop()
+		s.pauseTimer = nil
+	}
+	s.paused = false
+	s.pausedUntil = time.Time{}
+	s.pauseReason = ""
+	s.pauseWindow = ""
+	s.mu.Unlock()
+	s.ws.BroadcastProtectionState(s.protectionState())
+
+	if !wasPaused || details == nil {
+		return
+	}
+	details["reason"] = reason
+	audit.Log(audit.EventProtectionResumed, "info", message, details)
+}
+
+// callerDetails adds the caller and the device's user to details, which
+// may be nil
+func (s *Server) callerDetails(caller Caller, details map[string]interface{}) map[string]interface{} {
+	if details == nil {
+		details = make(map[string]interface{})
+	}
+	if caller.Role != "" {
+		details["role"] = string(caller.Role)
+	}
+	if caller.ClientIP != "" {
+		details["client_ip"] = caller.ClientIP
+	}
+	if caller.UID != nil {
+		details["uid"] = *caller.UID
+	}
+	if caller.ClientCert != "" {
+		details["client_cert"] = caller.ClientCert
+	}
+	return s.withUser(details)
+}
+
+// withUser adds the device's user and group to details
+func (s *Server) withUser(details map[string]interface{}) map[string]interface{} {
+	s.mu.RLock()
+	currentUser := s.currentUser
+	s.mu.RUnlock()
+	if currentUser != nil {
+		details["user"], details["group"] = currentUser()
+	}
+	return details
+}
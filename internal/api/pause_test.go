@@ -0,0 +1,81 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestPause(t *testing.T) {
+	s := NewServer(nil)
+	s.UpdateConfig(&Config{AllowPause: true, MaxPauseSeconds: 900})
+	s.SetCurrentUser(func() (string, string) { return "alice@example.com", "engineering" })
+
+	pause := func(body string) int {
+		w := httptest.NewRecorder()
+		s.handlePause(w, httptest.NewRequest(http.MethodPost, "/api/pause", strings.NewReader(body)))
+		return w.Code
+	}
+
+	if code := pause(`not json`); code != http.StatusBadRequest {
+		t.Errorf("Malformed pause returned %d, want 400", code)
+	}
+
+	if code := pause(`{"duration": "5m"}`); code != http.StatusBadRequest {
+		t.Errorf("Pause without a reason returned %d, want 400", code)
+	}
+	if code := pause(`{"duration": "5m", "reason": "   "}`); code != http.StatusBadRequest {
+		t.Errorf("Pause with a blank reason returned %d, want 400", code)
+	}
+	if code := pause(`{"duration": "1h", "reason": "Vendor demo"}`); code != http.StatusForbidden {
+		t.Errorf("Pause longer than the maximum returned %d, want 403", code)
+	}
+	if s.protectionState().Paused {
+		t.Fatal("Rejected pauses paused protection")
+	}
+
+	w := httptest.NewRecorder()
+	s.handlePause(w, httptest.NewRequest(http.MethodPost, "/api/pause", strings.NewReader(`{"duration": "50ms", "reason": "Vendor demo"}`)))
+	if w.Code != http.StatusOK {
+		t.Fatalf("Pause returned %d, want 200", w.Code)
+	}
+	var resp map[string]string
+	if err := json.Unmarshal(w.Body.Bytes(), &resp); err != nil || resp["status"] != "paused" || resp["duration"] != "50ms" {
+		t.Errorf("Pause response = %s, want the requested duration", w.Body.String())
+	}
+	state := s.protectionState()
+	if !state.Paused || state.Reason != "Vendor demo" || state.Until == nil {
+		t.Fatalf("Unexpected state after pause: %+v", state)
+	}
+
+	deadline := time.Now().Add(2 * time.Second)
+	for s.protectionState().Paused {
+		if time.Now().After(deadline) {
+			t.Fatal("Pause did not end on its own")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if state := s.protectionState(); state.Reason != "" || state.Until != nil {
+		t.Errorf("Unexpected state after the pause ended: %+v", state)
+	}
+}
+
+func TestPauseNotAllowed(t *testing.T) {
+	s := NewServer(nil)
+	s.UpdateConfig(&Config{AllowPause: false})
+
+	// Refused by policy before the request is looked at
+	for _, body := range []string{`not json`, `{"duration": "5m", "reason": "Vendor demo"}`} {
+		w := httptest.NewRecorder()
+		s.handlePause(w, httptest.NewRequest(http.MethodPost, "/api/pause", strings.NewReader(body)))
+		if w.Code != http.StatusForbidden {
+			t.Errorf("Pause of %s returned %d, want 403", body, w.Code)
+		}
+	}
+	if s.protectionState().Paused {
+		t.Error("Pause not allowed by policy paused protection")
+	}
+}
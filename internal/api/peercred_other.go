@@ -0,0 +1,18 @@
+//go:build !linux && !darwin
+
+package api
+
+import (
+	"fmt"
+	"net"
+)
+
+// peerUID is not supported on this platform, so the socket refuses every
+// connection
+func peerUID(conn net.Conn) (uint32, error) {
+	return 0, fmt.Errorf("peer credentials not supported on this platform")
+}
+
+func consoleUID() (uint32, bool) {
+	return 0, false
+}
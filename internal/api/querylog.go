@@ -0,0 +1,99 @@
+package api
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	"dnshield/internal/dns"
+	"dnshield/internal/querylog"
+	"github.com/sirupsen/logrus"
+)
+
+// QueryLogPath is the query log search endpoint
+const QueryLogPath = "/api/querylog"
+
+// SetQueryLog enables the query log endpoint
+func (s *Server) SetQueryLog(log *querylog.Log) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.queryLog = log
+}
+
+// handleQueryLog returns a page of logged queries, newest first. Supported
+// parameters: domain, client, verdict, since, until (RFC 3339), limit and
+// offset.
+func (s *Server) handleQueryLog(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	log := s.queryLog
+	s.mu.RUnlock()
+	if log == nil {
+		http.Error(w, "Query log not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	filter, err := parseQueryLogFilter(r)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	page, err := log.Search(filter)
+	if err != nil {
+		logrus.WithError(err).Error("Query log search failed")
+		http.Error(w, "Query log search failed", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(page)
+}
+
+func parseQueryLogFilter(r *http.Request) (querylog.Filter, error) {
+	q := r.URL.Query()
+	filter := querylog.Filter{
+		Domain: q.Get("domain"),
+		Client: q.Get("client"),
+		Action: q.Get("verdict"),
+	}
+
+	switch filter.Action {
+	case "", dns.QueryActionAllowed, dns.QueryActionBlocked, dns.QueryActionCached,
+		dns.QueryActionHosts, dns.QueryActionLocal, dns.QueryActionFailed, dns.QueryActionSafeSearch,
+		dns.QueryActionMonitored:
+	default:
+		return filter, fmt.Errorf("invalid verdict: %q", filter.Action)
+	}
+
+	for name, dst := range map[string]*time.Time{"since": &filter.Since, "until": &filter.Until} {
+		if v := q.Get(name); v != "" {
+			t, err := time.Parse(time.RFC3339, v)
+			if err != nil {
+				return filter, fmt.Errorf("invalid %s: must be RFC 3339", name)
+			}
+			*dst = t
+		}
+	}
+
+	for name, dst := range map[string]*int{"limit": &filter.Limit, "offset": &filter.Offset} {
+		if v := q.Get(name); v != "" {
+			n, err := strconv.Atoi(v)
+			if err != nil || n < 0 {
+				return filter, fmt.Errorf("invalid %s: %q", name, v)
+			}
+			*dst = n
+		}
+	}
+	if filter.Limit > querylog.MaxPageSize {
+		filter.Limit = querylog.MaxPageSize
+	}
+
+	return filter, nil
+}
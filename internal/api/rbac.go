@@ -3,10 +3,14 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"net"
 	"net/http"
+	"net/netip"
 	"strings"
+	"sync"
 	"time"
 
+	"dnshield/internal/audit"
 	"github.com/sirupsen/logrus"
 )
 
@@ -31,6 +35,21 @@ const (
 	PermissionResumeProtection Permission = "protection:resume"
 	PermissionRefreshRules     Permission = "rules:refresh"
 	PermissionClearCache       Permission = "cache:clear"
+	PermissionCaptiveBypass    Permission = "protection:captive-bypass"
+	PermissionDebug            Permission = "debug:profile"
+	PermissionFlowVerdict      Permission = "flow:verdict"
+	PermissionStreamQueries    Permission = "queries:stream"
+	PermissionViewQueryLog     Permission = "querylog:view"
+	// Asking for access to a blocked domain and applying signed approvals
+	PermissionRequestUnblock Permission = "unblock:request"
+	// Bypassing a single blocked domain for a few minutes
+	PermissionDomainBypass Permission = "unblock:bypass"
+	// Applying an earlier version of the enterprise rules
+	PermissionRollbackRules Permission = "rules:rollback"
+	// Allowing and blocking domains on this device
+	PermissionLocalRules Permission = "rules:local"
+	// Deleting logged queries and audit events
+	PermissionPurgeData Permission = "data:purge"
 )
 
 // RolePermissions maps roles to their permissions
@@ -44,6 +63,16 @@ var RolePermissions = map[Role][]Permission{
 		PermissionResumeProtection,
 		PermissionRefreshRules,
 		PermissionClearCache,
+		PermissionCaptiveBypass,
+		PermissionDebug,
+		PermissionFlowVerdict,
+		PermissionStreamQueries,
+		PermissionViewQueryLog,
+		PermissionRequestUnblock,
+		PermissionDomainBypass,
+		PermissionRollbackRules,
+		PermissionLocalRules,
+		PermissionPurgeData,
 	},
 	RoleOperator: {
 		PermissionViewStatus,
@@ -53,26 +82,43 @@ var RolePermissions = map[Role][]Permission{
 		PermissionResumeProtection,
 		PermissionRefreshRules,
 		PermissionClearCache,
+		PermissionCaptiveBypass,
+		PermissionFlowVerdict,
+		PermissionStreamQueries,
+		PermissionViewQueryLog,
+		PermissionRequestUnblock,
+		PermissionDomainBypass,
+		PermissionLocalRules,
 	},
 	RoleViewer: {
 		PermissionViewStatus,
 		PermissionViewStats,
 		PermissionViewConfig,
+		PermissionRequestUnblock,
+		PermissionDomainBypass,
 	},
 }
 
-// APIKey represents an API key with associated role
+// APIKey represents an API key with associated role. Only a salted hash of
+// the key is kept.
 type APIKey struct {
-	Key       string    `json:"key"`
-	Role      Role      `json:"role"`
-	CreatedAt time.Time `json:"created_at"`
-	ExpiresAt time.Time `json:"expires_at,omitempty"`
-	Disabled  bool      `json:"disabled"`
+	Key        string         `json:"key"` // ID: the key's first characters
+	Hash       string         `json:"-"`
+	Salt       string         `json:"-"`
+	Role       Role           `json:"role"`
+	CreatedAt  time.Time      `json:"created_at"`
+	ExpiresAt  time.Time      `json:"expires_at,omitempty"`
+	Disabled   bool           `json:"disabled"`
+	Scopes     []string       `json:"scopes,omitempty"`
+	AllowedIPs []netip.Prefix `json:"allowed_ips,omitempty"`
+
+	lastAudited time.Time // Last time a use of the key was audited
 }
 
 // RBACManager manages role-based access control
 type RBACManager struct {
-	apiKeys map[string]*APIKey
+	mu      sync.Mutex
+	apiKeys map[string]*APIKey // By key ID
 }
 
 // NewRBACManager creates a new RBAC manager
@@ -84,8 +130,15 @@ func NewRBACManager() *RBACManager {
 
 // AddAPIKey adds a new API key with the specified role
 func (r *RBACManager) AddAPIKey(key string, role Role, expiration time.Duration) {
+	var stored StoredKey
+	if err := stored.SetKey(key); err != nil {
+		logrus.WithError(err).Error("Failed to hash API key")
+		return
+	}
 	apiKey := &APIKey{
-		Key:       key,
+		Key:       stored.ID,
+		Hash:      stored.Hash,
+		Salt:      stored.Salt,
 		Role:      role,
 		CreatedAt: time.Now(),
 		Disabled:  false,
@@ -95,31 +148,109 @@ func (r *RBACManager) AddAPIKey(key string, role Role, expiration time.Duration)
 		apiKey.ExpiresAt = time.Now().Add(expiration)
 	}
 	
-	r.apiKeys[key] = apiKey
+	r.mu.Lock()
+	r.apiKeys[apiKey.Key] = apiKey
+	r.mu.Unlock()
 	logrus.WithFields(logrus.Fields{
+		"key_id":     apiKey.Key,
 		"role":       role,
 		"expires_at": apiKey.ExpiresAt,
 	}).Info("Added API key")
 }
 
-// ValidateAPIKey validates an API key and returns its role
-func (r *RBACManager) ValidateAPIKey(key string) (Role, bool) {
-	apiKey, exists := r.apiKeys[key]
-	if !exists {
-		return "", false
+// setAPIKeys replaces all keys, so revoked and rotated keys stop working
+func (r *RBACManager) setAPIKeys(keys map[string]*APIKey) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	for id, k := range keys {
+		if old, ok := r.apiKeys[id]; ok {
+			k.lastAudited = old.lastAudited
+		}
+	}
+	r.apiKeys = keys
+}
+
+// lookup returns the active key matching key. known reports whether a key
+// with its ID exists, valid or not.
+func (r *RBACManager) lookup(key string) (apiKey *APIKey, known bool) {
+	r.mu.Lock()
+	apiKey, known = r.apiKeys[KeyID(key)]
+	r.mu.Unlock()
+	if !known {
+		return nil, false
 	}
 	
-	if apiKey.Disabled {
-		return "", false
+	if !keyMatches(apiKey.Salt, apiKey.Hash, key) || apiKey.Disabled {
+		return nil, true
 	}
 	
 	if !apiKey.ExpiresAt.IsZero() && time.Now().After(apiKey.ExpiresAt) {
-		return "", false
+		return nil, true
 	}
 	
+	return apiKey, true
+}
+
+// ValidateAPIKey validates an API key and returns its role
+func (r *RBACManager) ValidateAPIKey(key string) (Role, bool) {
+	apiKey, _ := r.lookup(key)
+	if apiKey == nil {
+		return "", false
+	}
 	return apiKey.Role, true
 }
 
+// markUsed reports whether a use of apiKey should be audited: the first
+// use each hour is
+func (r *RBACManager) markUsed(apiKey *APIKey) bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if time.Since(apiKey.lastAudited) < keyUseAuditInterval {
+		return false
+	}
+	apiKey.lastAudited = time.Now()
+	return true
+}
+
+// keyUseAuditInterval is how often uses of the same key are audited
+const keyUseAuditInterval = time.Hour
+
+// allowsPath reports whether the key's scopes include path
+func (k *APIKey) allowsPath(path string) bool {
+	if len(k.Scopes) == 0 {
+		return true
+	}
+	for _, scope := range k.Scopes {
+		if prefix, ok := strings.CutSuffix(scope, "*"); ok {
+			if strings.HasPrefix(path, prefix) {
+				return true
+			}
+		} else if path == scope {
+			return true
+		}
+	}
+	return false
+}
+
+// allowsIP reports whether the key may be used from clientIP. Restricted
+// keys are refused on the Unix sockets, which have no address.
+func (k *APIKey) allowsIP(clientIP string) bool {
+	if len(k.AllowedIPs) == 0 {
+		return true
+	}
+	addr, err := netip.ParseAddr(clientIP)
+	if err != nil {
+		return false
+	}
+	addr = addr.Unmap()
+	for _, prefix := range k.AllowedIPs {
+		if prefix.Contains(addr) {
+			return true
+		}
+	}
+	return false
+}
+
 // HasPermission checks if a role has a specific permission
 func (r *RBACManager) HasPermission(role Role, permission Permission) bool {
 	permissions, exists := RolePermissions[role]
@@ -139,27 +270,38 @@ func (r *RBACManager) HasPermission(role Role, permission Permission) bool {
 // RBACMiddleware provides role-based access control for API endpoints
 func (s *Server) RBACMiddleware(permission Permission, handler http.HandlerFunc) http.HandlerFunc {
 	return func(w http.ResponseWriter, r *http.Request) {
+		clientIP := r.RemoteAddr
+		if p, ok := peerFrom(r); ok && p.socket {
+			clientIP = "unix"
+		} else if host, _, err := net.SplitHostPort(r.RemoteAddr); err == nil {
+			clientIP = host
+		}
+
 		// Extract API key from Authorization header
 		authHeader := r.Header.Get("Authorization")
-		if authHeader == "" {
+		role, peerAuth := s.peerRole(r)
+		if authHeader == "" && !peerAuth {
+			auditAuthFailure("Missing authorization header", "", "", r.URL.Path, clientIP)
 			http.Error(w, "Missing authorization header", http.StatusUnauthorized)
 			return
 		}
 		
-		// Expected format: "Bearer <api-key>"
-		parts := strings.Split(authHeader, " ")
-		if len(parts) != 2 || parts[0] != "Bearer" {
-			http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
-			return
-		}
-		
-		apiKey := parts[1]
-		
-		// Validate API key and get role
-		role, valid := s.rbacManager.ValidateAPIKey(apiKey)
-		if !valid {
-			http.Error(w, "Invalid or expired API key", http.StatusUnauthorized)
-			return
+		if authHeader != "" {
+			// Expected format: "Bearer <api-key>"
+			parts := strings.Split(authHeader, " ")
+			if len(parts) != 2 || parts[0] != "Bearer" {
+				auditAuthFailure("Invalid authorization header format", "", "", r.URL.Path, clientIP)
+				http.Error(w, "Invalid authorization header format", http.StatusUnauthorized)
+				return
+			}
+
+			// Validate API key and get role
+			var err error
+			role, err = s.authenticate(parts[1], r.URL.Path, clientIP)
+			if err != nil {
+				writeError(w, err)
+				return
+			}
 		}
 		
 		// Check if role has required permission
@@ -169,6 +311,7 @@ func (s *Server) RBACMiddleware(permission Permission, handler http.HandlerFunc)
 				"permission": permission,
 				"ip":         r.RemoteAddr,
 			}).Warn("Access denied - insufficient permissions")
+			auditAuthFailure("Insufficient permissions", "", role, r.URL.Path, clientIP)
 			http.Error(w, "Insufficient permissions", http.StatusForbidden)
 			return
 		}
@@ -179,6 +322,97 @@ func (s *Server) RBACMiddleware(permission Permission, handler http.HandlerFunc)
 	}
 }
 
+// Authorize returns the role of apiKey if it is valid for path and has
+// permission. It serves transports other than HTTP, such as the gRPC
+// socket, whose calls name the equivalent HTTP path.
+func (s *Server) Authorize(apiKey, path string, permission Permission) (Role, error) {
+	role, err := s.authenticate(apiKey, path, "unix")
+	if err != nil {
+		return "", err
+	}
+	if !s.rbacManager.HasPermission(role, permission) {
+		logrus.WithFields(logrus.Fields{
+			"role":       role,
+			"permission": permission,
+		}).Warn("Access denied - insufficient permissions")
+		auditAuthFailure("Insufficient permissions", "", role, path, "unix")
+		return "", errorf(http.StatusForbidden, "Insufficient permissions")
+	}
+	return role, nil
+}
+
+// authenticate checks apiKey for a call to path from clientIP ("unix" for
+// socket clients) and returns its role. Failures are audited, and so is
+// the first use of each key every hour.
+func (s *Server) authenticate(apiKey, path, clientIP string) (Role, error) {
+	k, known := s.rbacManager.lookup(apiKey)
+	if k == nil {
+		var id string
+		if known {
+			id = KeyID(apiKey)
+		}
+		auditAuthFailure("Invalid or expired API key", id, "", path, clientIP)
+		return "", errorf(http.StatusUnauthorized, "Invalid or expired API key")
+	}
+	if !k.allowsIP(clientIP) {
+		auditAuthFailure("API key used from an address it isn't allowed", k.Key, k.Role, path, clientIP)
+		return "", errorf(http.StatusForbidden, "API key not allowed from this address")
+	}
+	if !k.allowsPath(path) {
+		auditAuthFailure("API key used outside its scopes", k.Key, k.Role, path, clientIP)
+		return "", errorf(http.StatusForbidden, "API key not allowed for this endpoint")
+	}
+	if s.rbacManager.markUsed(k) {
+		audit.Log(audit.EventAPIKeyUsed, "info", "API key used", map[string]interface{}{
+			"key_id":    k.Key,
+			"role":      k.Role,
+			"path":      path,
+			"client_ip": clientIP,
+		})
+	}
+	return k.Role, nil
+}
+
+// auditAuthFailure records a refused API request. keyID and role are
+// empty when unknown.
+func auditAuthFailure(reason, keyID string, role Role, path, clientIP string) {
+	details := map[string]interface{}{
+		"reason":    reason,
+		"path":      path,
+		"client_ip": clientIP,
+	}
+	if keyID != "" {
+		details["key_id"] = keyID
+	}
+	if role != "" {
+		details["role"] = role
+	}
+	audit.Log(audit.EventAPIAuthFailed, "warning", "API request refused: "+reason, details)
+}
+
+// requestRole returns the role of the request's API key, if it has a valid
+// one, or of the authenticated peer that sent it without a key
+func (s *Server) requestRole(r *http.Request) (Role, bool) {
+	if r.Header.Get("Authorization") == "" {
+		return s.peerRole(r)
+	}
+	parts := strings.Split(r.Header.Get("Authorization"), " ")
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return "", false
+	}
+	return s.rbacManager.ValidateAPIKey(parts[1])
+}
+
+// peerRole returns the role of an authenticated peer, if r came from one
+// and its listener grants one
+func (s *Server) peerRole(r *http.Request) (Role, bool) {
+	p, ok := peerFrom(r)
+	if !ok || p.role == "" {
+		return "", false
+	}
+	return p.role, true
+}
+
 // PublicEndpoint wraps endpoints that don't require authentication
 func (s *Server) PublicEndpoint(handler http.HandlerFunc) http.HandlerFunc {
 	return handler
@@ -209,6 +443,14 @@ func (s *Server) handleConfigUpdate(w http.ResponseWriter, r *http.Request) {
 	// Get current config
 	s.mu.Lock()
 	defer s.mu.Unlock()
+
+	if s.config.Managed {
+		audit.Log(audit.EventPolicyDenied, "warning", "Configuration change refused by managed policy", map[string]interface{}{
+			"ip": r.RemoteAddr,
+		})
+		http.Error(w, "Configuration is managed by policy", http.StatusForbidden)
+		return
+	}
 	
 	// Apply updates
 	if update.AllowPause != nil {
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"strings"
+
+	"dnshield/internal/utils"
+
+	"github.com/miekg/dns"
+)
+
+// ResolvePath resolves a name through the agent's DNS handler
+const ResolvePath = "/api/resolve"
+
+// ResolveResult is the response to /api/resolve: what the agent did with
+// a query for the name and the response it gave
+type ResolveResult struct {
+	Domain string `json:"domain"`
+	Type   string `json:"type"`
+	// Action is a dns.QueryAction*, such as blocked or cached
+	Action string `json:"action"`
+	Rule   string `json:"rule,omitempty"`
+	Rcode  string `json:"rcode"`
+
+	// The upstream that answered, empty unless the query was forwarded
+	Upstream      string  `json:"upstream,omitempty"`
+	UpstreamRTTMs float64 `json:"upstream_rtt_ms,omitempty"`
+	DurationMs    float64 `json:"duration_ms"`
+
+	// Records in presentation format
+	Answer    []string `json:"answer"`
+	Authority []string `json:"authority,omitempty"`
+}
+
+// SetResolveCallback sets the function /api/resolve calls to resolve a
+// domain
+func (s *Server) SetResolveCallback(cb func(domain string, qtype uint16) *ResolveResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.resolve = cb
+}
+
+// handleResolve resolves the domain parameter for the type parameter, A
+// by default
+func (s *Server) handleResolve(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	resolve := s.resolve
+	s.mu.RUnlock()
+	if resolve == nil {
+		http.Error(w, "Resolving is not available", http.StatusServiceUnavailable)
+		return
+	}
+
+	domain := strings.TrimSpace(r.URL.Query().Get("domain"))
+	if domain == "" {
+		http.Error(w, "Domain required", http.StatusBadRequest)
+		return
+	}
+	if err := utils.ValidateDomainLength(domain); err != nil {
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+	qtype := dns.TypeA
+	if name := strings.TrimSpace(r.URL.Query().Get("type")); name != "" {
+		var ok bool
+		if qtype, ok = dns.StringToType[strings.ToUpper(name)]; !ok {
+			http.Error(w, "Unknown record type "+name, http.StatusBadRequest)
+			return
+		}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(resolve(domain, qtype))
+}
@@ -0,0 +1,110 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Rules history endpoints
+const (
+	RulesHistoryPath  = "/api/rules/history"
+	RulesRollbackPath = "/api/rules/rollback"
+)
+
+// RuleVersion is an applied ruleset kept in the rules history
+type RuleVersion struct {
+	Version   string            `json:"version"`
+	AppliedAt time.Time         `json:"applied_at"`
+	ETags     map[string]string `json:"etags,omitempty"`
+	Current   bool              `json:"current"`
+}
+
+// RuleRollbackRequest selects the version to roll back to. An empty
+// version means the one before the current version.
+type RuleRollbackRequest struct {
+	Version string `json:"version,omitempty"`
+}
+
+// RuleRollbackResult is the response to /api/rules/rollback
+type RuleRollbackResult struct {
+	RuleRefreshResult
+	Version string `json:"version"`
+}
+
+// SetRuleHistoryCallbacks sets the functions that list the rules history
+// and roll back to a version from it
+func (s *Server) SetRuleHistoryCallbacks(list func() ([]RuleVersion, error), rollback func(ctx context.Context, version string) (*RuleRollbackResult, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.ruleHistory = list
+	s.rollbackRules = rollback
+}
+
+// handleRulesHistory lists the recorded rulesets, newest first
+func (s *Server) handleRulesHistory(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	list := s.ruleHistory
+	s.mu.RUnlock()
+	if list == nil {
+		http.Error(w, "Rule updates are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	versions, err := list()
+	if err != nil {
+		http.Error(w, fmt.Sprintf("Failed to read rules history: %v", err), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(versions)
+}
+
+// handleRulesRollback applies an earlier version of the rules
+func (s *Server) handleRulesRollback(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	rollback := s.rollbackRules
+	s.mu.RUnlock()
+	if rollback == nil {
+		http.Error(w, "Rule updates are not configured", http.StatusServiceUnavailable)
+		return
+	}
+
+	var req RuleRollbackRequest
+	if r.ContentLength != 0 {
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 1024)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+	}
+
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(ruleRefreshTimeout + 5*time.Second))
+	ctx, cancel := context.WithTimeout(r.Context(), ruleRefreshTimeout)
+	defer cancel()
+
+	result, err := rollback(ctx, req.Version)
+	if err != nil {
+		logrus.WithError(err).Warn("Rule rollback requested over the API failed")
+		http.Error(w, fmt.Sprintf("Rule rollback failed: %v", err), http.StatusInternalServerError)
+		return
+	}
+	result.Status = "rolled back"
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
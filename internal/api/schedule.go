@@ -0,0 +1,61 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"time"
+
+	"dnshield/internal/dns"
+)
+
+// SchedulesPath reports the state of scheduled rules
+const SchedulesPath = "/api/schedules"
+
+// ScheduleState is the state of one scheduled rule
+type ScheduleState struct {
+	Name           string     `json:"name"`
+	Active         bool       `json:"active"`
+	AllowOnly      bool       `json:"allow_only"`
+	NextTransition *time.Time `json:"next_transition,omitempty"`
+}
+
+// SetScheduleStatus records the state of the scheduled rules and pushes it
+// to WebSocket clients
+func (s *Server) SetScheduleStatus(statuses []dns.ScheduleStatus) {
+	schedules := make([]ScheduleState, 0, len(statuses))
+	for _, status := range statuses {
+		state := ScheduleState{
+			Name:      status.Name,
+			Active:    status.Active,
+			AllowOnly: status.AllowOnly,
+		}
+		if !status.NextTransition.IsZero() {
+			next := status.NextTransition
+			state.NextTransition = &next
+		}
+		schedules = append(schedules, state)
+	}
+
+	s.mu.Lock()
+	s.schedules = schedules
+	s.mu.Unlock()
+
+	s.ws.BroadcastScheduleState(schedules)
+}
+
+func (s *Server) handleSchedules(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	s.mu.RLock()
+	schedules := s.schedules
+	s.mu.RUnlock()
+	if schedules == nil {
+		schedules = []ScheduleState{}
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(schedules)
+}
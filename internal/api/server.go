@@ -3,28 +3,67 @@ package api
 import (
 	"context"
 	"encoding/json"
+	"errors"
 	"fmt"
+	"net"
 	"net/http"
 	"os"
-	"path/filepath"
 	"sync"
 	"time"
 
+	"dnshield/internal/audit"
 	"dnshield/internal/dns"
-	"dnshield/internal/utils"
+	"dnshield/internal/querylog"
+	"dnshield/internal/unblock"
 	"github.com/sirupsen/logrus"
 )
 
+// ruleRefreshTimeout bounds a rule update requested over the API
+const ruleRefreshTimeout = 2 * time.Minute
+
 type Server struct {
 	mu              sync.RWMutex
 	stats           *Statistics
 	recentBlocked   []BlockedDomain
 	config          *Config
 	statusCallbacks []func() Status
-	server          *http.Server
+	servers         []*http.Server // One per listener: TCP and the socket
+	handler         http.Handler
+	handlerOnce     sync.Once
 	dnsManager      dns.DNSManager
 	rbacManager     *RBACManager
+	keysModTime     time.Time // Of the key store when it was loaded
 	rateLimiter     *RateLimiter
+	domainCounts    map[string]int64
+	blockedCounts   map[string]int64
+	clientCounts    map[string]int64
+	failedQueries   int64 // Queries every upstream failed
+	upstreamStats   map[string]*upstreamTotals
+	captivePortal   *dns.CaptivePortalDetector
+	profiling       bool
+	flowFilter      *dns.FlowFilter
+	queryLog        *querylog.Log
+	schedules       []ScheduleState
+	refreshRules    func(ctx context.Context) (*RuleRefreshResult, error)
+	ruleHistory     func() ([]RuleVersion, error)
+	rollbackRules   func(ctx context.Context, version string) (*RuleRollbackResult, error)
+	clearCache      func() CacheClearResult
+	purgeData       func(DataPurgeRequest) (*DataPurgeResult, error)
+	reloadConfig    func() (*ConfigReloadResult, error)
+	explain         func(domain string) *DomainExplanation
+	resolve         func(domain string, qtype uint16) *ResolveResult
+	unblock         *unblock.Service
+	bypasser        *unblock.Bypasser
+	overrides       *unblock.Overrides
+	queryStream     *eventStream
+	auditStream     *streamHub[audit.Event]
+	ws              *WSServer
+	paused          bool // Last protection state sent to WebSocket clients
+	pausedUntil     time.Time
+	pauseReason     string
+	pauseWindow     string      // Maintenance window that paused protection
+	pauseTimer      *time.Timer // Ends the pause on the server's side
+	currentUser     func() (user, group string)
 }
 
 type Statistics struct {
@@ -40,13 +79,43 @@ type Statistics struct {
 	CacheHitRate    float64   `json:"cache_hit_rate"`
 	MemoryUsageMB   float64   `json:"memory_usage_mb"`
 	CPUUsagePercent float64   `json:"cpu_usage_percent"`
+	FlowsChecked    int64     `json:"flows_checked"`
+	FlowsBlocked    int64     `json:"flows_blocked"`
+	// Queries monitor enforcement would have blocked
+	QueriesMonitored int64 `json:"queries_monitored"`
+	// Blocks by each regex rule since the rules were last loaded
+	RegexRules []RegexRuleStats `json:"regex_rules,omitempty"`
+	// Blocks by the lists of each block category since the rules were
+	// last loaded
+	Categories []CategoryStats `json:"categories,omitempty"`
+}
+
+// RegexRuleStats counts the queries blocked by a regex rule
+type RegexRuleStats struct {
+	Pattern string `json:"pattern"`
+	Hits    uint64 `json:"hits"`
+}
+
+// CategoryStats counts the queries blocked by a category's lists
+type CategoryStats struct {
+	Category string `json:"category"`
+	Hits     uint64 `json:"hits"`
 }
 
 type BlockedDomain struct {
-	Domain    string    `json:"domain"`
-	Timestamp time.Time `json:"timestamp"`
-	Rule      string    `json:"rule"`
-	ClientIP  string    `json:"client_ip"`
+	Domain       string    `json:"domain"`
+	Timestamp    time.Time `json:"timestamp"`
+	Rule         string    `json:"rule"`
+	RuleSource   string    `json:"rule_source,omitempty"`
+	QueryType    string    `json:"query_type,omitempty"`
+	Rcode        string    `json:"rcode,omitempty"`
+	ClientIP     string    `json:"client_ip"`
+	ClientPort   int       `json:"client_port,omitempty"`
+	User         string    `json:"user,omitempty"`
+	Group        string    `json:"group,omitempty"`
+	BlockPageHit bool      `json:"block_page_hit"`
+	// Unicode form of a domain blocked as a homograph
+	DecodedDomain string `json:"decoded_domain,omitempty"`
 }
 
 type Status struct {
@@ -58,12 +127,22 @@ type Status struct {
 	Mode             string    `json:"mode"` // "standard" or "secure"
 	PolicyEnforced   bool      `json:"policy_enforced"`
 	PolicySource     string    `json:"policy_source"`
+	PolicySerial     int64     `json:"policy_serial,omitempty"`
+	PolicyError      string    `json:"policy_error,omitempty"`
 	LastHealthCheck  time.Time `json:"last_health_check"`
 	Version          string    `json:"version"`
 	CertificateValid bool      `json:"certificate_valid"`
 	CurrentNetwork   string    `json:"current_network,omitempty"`
 	NetworkInterface string    `json:"network_interface,omitempty"`
 	OriginalDNS      []string  `json:"original_dns,omitempty"`
+	Enforcement      string    `json:"enforcement"` // "block" or "monitor"
+	// Where the enterprise rules in use came from: "s3", "cache" (S3 was
+	// unreachable) or "none"; empty when no bucket is configured
+	RulesSource    string    `json:"rules_source,omitempty"`
+	RulesFetchedAt time.Time `json:"rules_fetched_at,omitempty"`
+	RulesStale     bool      `json:"rules_stale"` // Fetched longer ago than s3.staleAfter
+	// Version of the enterprise rules in use, as listed in the rules history
+	RulesVersion string `json:"rules_version,omitempty"`
 }
 
 type Config struct {
@@ -72,27 +151,115 @@ type Config struct {
 	PolicyURL      string `json:"policy_url"`
 	ReportingURL   string `json:"reporting_url"`
 	UpdateInterval int    `json:"update_interval"`
+	// Set by a managed policy: configuration can't be changed over the API
+	Managed bool `json:"managed"`
+	// Longest allowed pause in seconds; 0 means no limit
+	MaxPauseSeconds int `json:"max_pause_seconds,omitempty"`
+}
+
+// Error is a request the API refused, with the HTTP status it is reported
+// with
+type Error struct {
+	Status  int
+	Message string
+}
+
+func (e *Error) Error() string {
+	return e.Message
+}
+
+func errorf(status int, format string, args ...interface{}) *Error {
+	return &Error{Status: status, Message: fmt.Sprintf(format, args...)}
+}
+
+// writeError reports err, an *Error or an internal error
+func writeError(w http.ResponseWriter, err error) {
+	var apiErr *Error
+	if errors.As(err, &apiErr) {
+		http.Error(w, apiErr.Message, apiErr.Status)
+		return
+	}
+	http.Error(w, err.Error(), http.StatusInternalServerError)
 }
 
-type PauseRequest struct {
-	Duration string `json:"duration"` // "5m", "30m", "1h"
+// RuleRefreshResult is the response to /api/refresh-rules
+type RuleRefreshResult struct {
+	Status          string `json:"status"`
+	BlockedDomains  int    `json:"blocked_domains"`
+	SecurityDomains int    `json:"security_domains"`
+	AllowedDomains  int    `json:"allowed_domains"`
+
+	// External lists left out of the update, each with the reason
+	FailedSources []string `json:"failed_sources,omitempty"`
+}
+
+// CacheClearResult is the response to /api/clear-cache
+type CacheClearResult struct {
+	Status       string `json:"status"`
+	DNSEntries   int    `json:"dns_entries"`
+	Certificates int    `json:"certificates"`
 }
 
 func NewServer(dnsManager dns.DNSManager) *Server {
-	return &Server{
+	s := &Server{
 		stats:         &Statistics{},
 		recentBlocked: make([]BlockedDomain, 0, 100),
 		config: &Config{
 			AllowPause: true,
 			AllowQuit:  true,
 		},
-		dnsManager:  dnsManager,
-		rbacManager: NewRBACManager(),
-		rateLimiter: NewRateLimiter(100, time.Minute), // 100 requests per minute per IP
+		dnsManager:    dnsManager,
+		rbacManager:   NewRBACManager(),
+		domainCounts:  make(map[string]int64),
+		blockedCounts: make(map[string]int64),
+		clientCounts:  make(map[string]int64),
+		upstreamStats: make(map[string]*upstreamTotals),
+		queryStream:   newEventStream(),
+		auditStream:   newStreamHub[audit.Event](),
+		ws:            NewWSServer(),
+		rateLimiter:   NewRateLimiter(100, time.Minute), // 100 requests per minute per IP
 	}
+	s.rateLimiter.SetRoleResolver(s.requestRole)
+	return s
+}
+
+// SetRateLimitPolicy replaces the API rate limits
+func (s *Server) SetRateLimitPolicy(policy RateLimitPolicy) {
+	s.rateLimiter.SetPolicy(policy)
 }
 
+// Start serves the API on 127.0.0.1:port until Stop
 func (s *Server) Start(port int) error {
+	listener, err := net.Listen("tcp", fmt.Sprintf("127.0.0.1:%d", port))
+	if err != nil {
+		return err
+	}
+	logrus.Infof("Starting API server on port %d", port)
+	return s.serve(listener, nil)
+}
+
+// serve serves the API on listener until Stop. connContext, if not nil,
+// sets up the context of each connection's requests.
+func (s *Server) serve(listener net.Listener, connContext func(ctx context.Context, c net.Conn) context.Context) error {
+	s.handlerOnce.Do(func() {
+		s.handler = s.routes()
+		go s.ws.Run()
+	})
+
+	server := &http.Server{
+		Handler:      s.handler,
+		ReadTimeout:  10 * time.Second,
+		WriteTimeout: 10 * time.Second,
+		ConnContext:  connContext,
+	}
+	s.mu.Lock()
+	s.servers = append(s.servers, server)
+	s.mu.Unlock()
+	return server.Serve(listener)
+}
+
+// routes returns the API's handler
+func (s *Server) routes() http.Handler {
 	mux := http.NewServeMux()
 
 	// Apply rate limiting to all endpoints
@@ -105,36 +272,68 @@ func (s *Server) Start(port int) error {
 	mux.HandleFunc("/api/status", rl(s.RBACMiddleware(PermissionViewStatus, s.handleStatus)))
 	mux.HandleFunc("/api/statistics", rl(s.RBACMiddleware(PermissionViewStats, s.handleStatistics)))
 	mux.HandleFunc("/api/recent-blocked", rl(s.RBACMiddleware(PermissionViewStats, s.handleRecentBlocked)))
+	mux.HandleFunc("/api/top", rl(s.RBACMiddleware(PermissionViewStats, s.handleTop)))
+	mux.HandleFunc(QueryStreamPath, rl(s.RBACMiddleware(PermissionStreamQueries, s.handleQueryStream)))
+	mux.HandleFunc(EventStreamPath, rl(s.RBACMiddleware(PermissionStreamQueries, s.handleEventStream)))
+	mux.HandleFunc(QueryLogPath, rl(s.RBACMiddleware(PermissionViewQueryLog, s.handleQueryLog)))
+	mux.HandleFunc(SchedulesPath, rl(s.RBACMiddleware(PermissionViewStatus, s.handleSchedules)))
 	mux.HandleFunc("/api/config", rl(s.RBACMiddleware(PermissionViewConfig, s.handleConfig)))
+	mux.HandleFunc(ExplainPath, rl(s.RBACMiddleware(PermissionViewConfig, s.handleExplain)))
+	mux.HandleFunc(ResolvePath, rl(s.RBACMiddleware(PermissionViewConfig, s.handleResolve)))
+	mux.HandleFunc(UnblockRequestPath, rl(s.RBACMiddleware(PermissionRequestUnblock, s.handleUnblockRequest)))
+	mux.HandleFunc(UnblockApprovePath, rl(s.RBACMiddleware(PermissionRequestUnblock, s.handleUnblockApprove)))
+	mux.HandleFunc(UnblockBypassPath, rl(s.RBACMiddleware(PermissionDomainBypass, s.handleDomainBypass)))
 
 	// Configuration modification endpoint (admin only)
 	mux.HandleFunc("/api/config/update", rl(s.RBACMiddleware(PermissionModifyConfig, s.handleConfigUpdate)))
+	mux.HandleFunc(ConfigReloadPath, rl(s.RBACMiddleware(PermissionModifyConfig, s.handleConfigReload)))
 
 	// Control endpoints (operator access)
 	mux.HandleFunc("/api/pause", rl(s.RBACMiddleware(PermissionPauseProtection, s.handlePause)))
 	mux.HandleFunc("/api/resume", rl(s.RBACMiddleware(PermissionResumeProtection, s.handleResume)))
 	mux.HandleFunc("/api/refresh-rules", rl(s.RBACMiddleware(PermissionRefreshRules, s.handleRefreshRules)))
+	mux.HandleFunc(RulesHistoryPath, rl(s.RBACMiddleware(PermissionViewConfig, s.handleRulesHistory)))
+	mux.HandleFunc(RulesRollbackPath, rl(s.RBACMiddleware(PermissionRollbackRules, s.handleRulesRollback)))
 	mux.HandleFunc("/api/clear-cache", rl(s.RBACMiddleware(PermissionClearCache, s.handleClearCache)))
+	mux.HandleFunc("/api/captive-portal/bypass", rl(s.RBACMiddleware(PermissionCaptiveBypass, s.handleCaptiveBypass)))
+	mux.HandleFunc(FlowVerdictPath, rl(s.RBACMiddleware(PermissionFlowVerdict, s.handleFlowVerdict)))
+	mux.HandleFunc(RulesAllowPath, rl(s.RBACMiddleware(PermissionLocalRules, s.handleLocalRules(unblock.ListAllow))))
+	mux.HandleFunc(RulesBlockPath, rl(s.RBACMiddleware(PermissionLocalRules, s.handleLocalRules(unblock.ListBlock))))
 
-	// WebSocket for real-time updates (viewer access)
-	mux.HandleFunc("/api/ws", rl(s.RBACMiddleware(PermissionViewStatus, s.handleWebSocket)))
+	// Deleting logged data (admin only)
+	mux.HandleFunc(DataPurgePath, rl(s.RBACMiddleware(PermissionPurgeData, s.handleDataPurge)))
 
-	s.server = &http.Server{
-		Addr:         fmt.Sprintf("127.0.0.1:%d", port),
-		Handler:      mux,
-		ReadTimeout:  10 * time.Second,
-		WriteTimeout: 10 * time.Second,
+	// Runtime diagnostics (admin only, disabled unless configured)
+	s.mu.RLock()
+	profiling := s.profiling
+	s.mu.RUnlock()
+	if profiling {
+		s.registerDebugHandlers(mux, rl)
 	}
 
-	logrus.Infof("Starting API server on port %d", port)
-	return s.server.ListenAndServe()
+	// WebSocket for real-time updates (viewer access)
+	mux.HandleFunc("/api/ws", rl(s.RBACMiddleware(PermissionViewStatus, s.handleWebSocket)))
+
+	return mux
 }
 
 func (s *Server) Stop(ctx context.Context) error {
-	if s.server != nil {
-		return s.server.Shutdown(ctx)
+	s.mu.RLock()
+	servers := s.servers
+	s.mu.RUnlock()
+	if len(servers) == 0 {
+		return nil
 	}
-	return nil
+
+	// WebSocket connections are hijacked, so Shutdown doesn't close them
+	s.ws.Stop()
+	var firstErr error
+	for _, server := range servers {
+		if err := server.Shutdown(ctx); err != nil && firstErr == nil {
+			firstErr = err
+		}
+	}
+	return firstErr
 }
 
 func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
@@ -143,6 +342,12 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Status())
+}
+
+// Status returns the agent's current status
+func (s *Server) Status() Status {
 	// Check if DNS is paused
 	isPaused := false
 	if s.dnsManager != nil {
@@ -199,8 +404,7 @@ func (s *Server) handleStatus(w http.ResponseWriter, r *http.Request) {
 		}
 	}
 
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(status)
+	return status
 }
 
 func (s *Server) handleStatistics(w http.ResponseWriter, r *http.Request) {
@@ -209,6 +413,12 @@ func (s *Server) handleStatistics(w http.ResponseWriter, r *http.Request) {
 		return
 	}
 
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.Statistics())
+}
+
+// Statistics returns the query statistics with the cache hit rate
+func (s *Server) Statistics() Statistics {
 	s.mu.RLock()
 	stats := *s.stats
 	s.mu.RUnlock()
@@ -217,9 +427,7 @@ func (s *Server) handleStatistics(w http.ResponseWriter, r *http.Request) {
 	if stats.CacheHits+stats.CacheMisses > 0 {
 		stats.CacheHitRate = float64(stats.CacheHits) / float64(stats.CacheHits+stats.CacheMisses) * 100
 	}
-
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(stats)
+	return stats
 }
 
 func (s *Server) handleRecentBlocked(w http.ResponseWriter, r *http.Request) {
@@ -256,101 +464,130 @@ func (s *Server) handleConfig(w http.ResponseWriter, r *http.Request) {
 	json.NewEncoder(w).Encode(config)
 }
 
-func (s *Server) handlePause(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleRefreshRules(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	s.mu.RLock()
-	if !s.config.AllowPause {
-		s.mu.RUnlock()
-		http.Error(w, "Pause not allowed by policy", http.StatusForbidden)
+	// Fetching external sources can outlast the server's write timeout
+	http.NewResponseController(w).SetWriteDeadline(time.Now().Add(ruleRefreshTimeout + 5*time.Second))
+	result, err := s.RefreshRules(r.Context())
+	if err != nil {
+		writeError(w, err)
 		return
 	}
-	s.mu.RUnlock()
 
-	var req PauseRequest
-	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
-		http.Error(w, "Invalid request", http.StatusBadRequest)
-		return
-	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
+}
 
-	// Parse duration
-	duration, err := time.ParseDuration(req.Duration)
-	if err != nil {
-		http.Error(w, "Invalid duration format", http.StatusBadRequest)
-		return
+// RefreshRules fetches and applies the rules now and returns the rule
+// counts
+func (s *Server) RefreshRules(ctx context.Context) (*RuleRefreshResult, error) {
+	s.mu.RLock()
+	refresh := s.refreshRules
+	s.mu.RUnlock()
+	if refresh == nil {
+		return nil, errorf(http.StatusServiceUnavailable, "Rule updates are not configured")
 	}
 
-	// Pause DNS filtering
-	if s.dnsManager != nil {
-		if err := s.dnsManager.PauseDNSFiltering(duration); err != nil {
-			logrus.WithError(err).Error("Failed to pause DNS filtering")
-			http.Error(w, "Failed to pause protection", http.StatusInternalServerError)
-			return
-		}
-	}
+	ctx, cancel := context.WithTimeout(ctx, ruleRefreshTimeout)
+	defer cancel()
 
-	logrus.Infof("Paused protection for %s", req.Duration)
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "paused", "duration": req.Duration})
+	logrus.Info("Refreshing blocking rules")
+	result, err := refresh(ctx)
+	if err != nil {
+		logrus.WithError(err).Warn("Rule refresh requested over the API failed")
+		return nil, errorf(http.StatusInternalServerError, "Rule refresh failed: %v", err)
+	}
+	result.Status = "refreshed"
+	return result, nil
 }
 
-func (s *Server) handleResume(w http.ResponseWriter, r *http.Request) {
+func (s *Server) handleClearCache(w http.ResponseWriter, r *http.Request) {
 	if r.Method != http.MethodPost {
 		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
 		return
 	}
 
-	// Resume DNS filtering
-	if s.dnsManager != nil {
-		if err := s.dnsManager.ResumeDNSFiltering(); err != nil {
-			logrus.WithError(err).Error("Failed to resume DNS filtering")
-			http.Error(w, "Failed to resume protection", http.StatusInternalServerError)
-			return
-		}
+	s.mu.RLock()
+	clear := s.clearCache
+	s.mu.RUnlock()
+
+	var result CacheClearResult
+	if clear != nil {
+		result = clear()
 	}
+	result.Status = "cache_cleared"
+	logrus.WithFields(logrus.Fields{
+		"dns_entries":  result.DNSEntries,
+		"certificates": result.Certificates,
+	}).Info("Cleared DNS and certificate caches")
 
-	logrus.Info("Resumed protection")
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "resumed"})
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(result)
 }
 
-func (s *Server) handleRefreshRules(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
-	}
-
-	// TODO: Trigger rule refresh
-	logrus.Info("Refreshing blocking rules")
+func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(struct {
+		Healthy   bool             `json:"healthy"`
+		RateLimit RateLimiterState `json:"rate_limit"`
+	}{true, s.rateLimiter.State()})
+}
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "refreshing"})
+// handleWebSocket streams stats, blocks and pause/resume changes. New
+// clients first receive the current protection state and statistics.
+func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
+	now := time.Now()
+	s.ws.ServeWS(w, r,
+		WSMessage{Type: WSTypeProtectionState, Timestamp: now, Data: s.protectionState()},
+		WSMessage{Type: WSTypeStatsUpdate, Timestamp: now, Data: s.GetStats()},
+	)
 }
 
-func (s *Server) handleClearCache(w http.ResponseWriter, r *http.Request) {
-	if r.Method != http.MethodPost {
-		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
-		return
+// protectionState returns the current pause state
+func (s *Server) protectionState() ProtectionState {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	state := ProtectionState{Paused: s.paused}
+	if s.paused && !s.pausedUntil.IsZero() {
+		until := s.pausedUntil
+		state.Until = &until
+		state.Reason = s.pauseReason
 	}
+	return state
+}
 
-	// TODO: Clear DNS cache
-	logrus.Info("Clearing DNS cache")
+// setProtectionState records a pause or resume and tells WebSocket clients
+func (s *Server) setProtectionState(paused bool, until time.Time) {
+	s.mu.Lock()
+	s.paused = paused
+	s.pausedUntil = until
+	s.pauseReason = ""
+	s.pauseWindow = ""
+	s.mu.Unlock()
 
-	w.WriteHeader(http.StatusOK)
-	json.NewEncoder(w).Encode(map[string]string{"status": "cache_cleared"})
+	s.ws.BroadcastProtectionState(s.protectionState())
 }
 
-func (s *Server) handleHealth(w http.ResponseWriter, r *http.Request) {
-	w.Header().Set("Content-Type", "application/json")
-	json.NewEncoder(w).Encode(map[string]bool{"healthy": true})
-}
+// syncProtectionState notices pauses that ended on their own
+func (s *Server) syncProtectionState() {
+	if s.dnsManager == nil {
+		return
+	}
+	paused := s.dnsManager.IsPaused()
 
-func (s *Server) handleWebSocket(w http.ResponseWriter, r *http.Request) {
-	// TODO: Implement WebSocket for real-time updates
-	http.Error(w, "WebSocket not implemented", http.StatusNotImplemented)
+	s.mu.RLock()
+	changed := paused != s.paused
+	s.mu.RUnlock()
+	if changed && paused {
+		s.setProtectionState(true, time.Time{})
+	} else if changed {
+		s.endPause("Pause ended", s.withUser(map[string]interface{}{"expired": true}))
+	}
 }
 
 // Public methods for updating statistics
@@ -369,6 +606,13 @@ func (s *Server) IncrementBlocked() {
 	s.mu.Unlock()
 }
 
+// IncrementMonitored counts a query monitor enforcement let through
+func (s *Server) IncrementMonitored() {
+	s.mu.Lock()
+	s.stats.QueriesMonitored++
+	s.mu.Unlock()
+}
+
 func (s *Server) IncrementCacheHit() {
 	s.mu.Lock()
 	s.stats.CacheHits++
@@ -381,15 +625,23 @@ func (s *Server) IncrementCacheMiss() {
 	s.mu.Unlock()
 }
 
-func (s *Server) AddBlockedDomain(domain, rule, clientIP string) {
+func (s *Server) AddBlockedDomain(event dns.BlockEvent) {
 	s.mu.Lock()
 	defer s.mu.Unlock()
 
 	blocked := BlockedDomain{
-		Domain:    domain,
-		Timestamp: time.Now(),
-		Rule:      rule,
-		ClientIP:  clientIP,
+		Domain:     event.Domain,
+		Timestamp:  event.Timestamp,
+		Rule:       event.Rule,
+		RuleSource: event.Source,
+		QueryType:  event.QueryType,
+		Rcode:      event.Rcode,
+		ClientIP:   event.ClientIP,
+		ClientPort: event.ClientPort,
+		User:       event.User,
+		Group:      event.Group,
+
+		DecodedDomain: event.DecodedDomain,
 	}
 
 	s.recentBlocked = append(s.recentBlocked, blocked)
@@ -398,12 +650,47 @@ func (s *Server) AddBlockedDomain(domain, rule, clientIP string) {
 	if len(s.recentBlocked) > 100 {
 		s.recentBlocked = s.recentBlocked[1:]
 	}
+
+	s.ws.BroadcastBlockedDomain(blocked)
+}
+
+// MarkBlockPageHit flags the most recent block of domain as having been
+// followed by a visit to the block page and returns that block, so the
+// visit can be logged against it. It returns false if no recent block of
+// the domain is known.
+func (s *Server) MarkBlockPageHit(domain string) (BlockedDomain, bool) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	for i := len(s.recentBlocked) - 1; i >= 0; i-- {
+		if s.recentBlocked[i].Domain == domain {
+			s.recentBlocked[i].BlockPageHit = true
+			return s.recentBlocked[i], true
+		}
+	}
+	return BlockedDomain{}, false
 }
 
 func (s *Server) RegisterStatusCallback(cb func() Status) {
 	s.statusCallbacks = append(s.statusCallbacks, cb)
 }
 
+// SetRefreshRulesCallback sets the function /api/refresh-rules calls to
+// update the rules. It returns the rule counts once the update is applied.
+func (s *Server) SetRefreshRulesCallback(cb func(ctx context.Context) (*RuleRefreshResult, error)) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.refreshRules = cb
+}
+
+// SetClearCacheCallback sets the function /api/clear-cache calls to flush
+// the DNS and certificate caches
+func (s *Server) SetClearCacheCallback(cb func() CacheClearResult) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.clearCache = cb
+}
+
 func (s *Server) UpdateConfig(config *Config) {
 	s.mu.Lock()
 	s.config = config
@@ -421,64 +708,89 @@ func (s *Server) UpdateStats(stats *Statistics) {
 	s.mu.Lock()
 	s.stats = stats
 	s.mu.Unlock()
+
+	s.ws.BroadcastStats(*stats)
+	s.syncProtectionState()
 }
 
-// LoadAPIKeys loads API keys from the persistent store
+// LoadAPIKeys loads API keys from the persistent store, replacing the
+// keys loaded before. Plaintext keys from older stores are hashed and the
+// store rewritten.
 func (s *Server) LoadAPIKeys() error {
-	homeDir, _ := os.UserHomeDir()
-	storePath := filepath.Join(homeDir, ".dnshield", "api_keys.json")
-	
-	// If file doesn't exist, skip loading
+	storePath := KeyStorePath()
 	info, err := os.Stat(storePath)
 	if os.IsNotExist(err) {
 		logrus.Info("No API keys file found, starting with empty key store")
+		s.rbacManager.setAPIKeys(make(map[string]*APIKey))
+		s.mu.Lock()
+		s.keysModTime = time.Time{}
+		s.mu.Unlock()
 		return nil
 	}
 	if err != nil {
 		return err
 	}
-	
-	// Check file size
-	if info.Size() > utils.MaxConfigFileSize {
-		return fmt.Errorf("API key store file exceeds maximum size of %d bytes", utils.MaxConfigFileSize)
-	}
-	
-	data, err := os.ReadFile(storePath)
+
+	store, migrated, err := LoadKeyStore(storePath)
 	if err != nil {
-		return fmt.Errorf("failed to read API keys: %w", err)
-	}
-	
-	var store struct {
-		Keys map[string]struct {
-			Key       string    `json:"key"`
-			Role      string    `json:"role"`
-			CreatedAt time.Time `json:"created_at"`
-			ExpiresAt time.Time `json:"expires_at,omitempty"`
-			Disabled  bool      `json:"disabled"`
-		} `json:"keys"`
-	}
-	
-	if err := json.Unmarshal(data, &store); err != nil {
-		return fmt.Errorf("failed to parse API keys: %w", err)
-	}
-	
-	// Load keys into RBAC manager
-	for _, info := range store.Keys {
-		if info.Disabled {
+		return err
+	}
+	if migrated {
+		if err := store.Save(storePath); err != nil {
+			return fmt.Errorf("failed to save hashed API keys: %w", err)
+		}
+		logrus.Info("Replaced plaintext API keys with salted hashes")
+		if info, err = os.Stat(storePath); err != nil {
+			return err
+		}
+	}
+
+	keys := make(map[string]*APIKey)
+	for _, k := range store.Keys {
+		// Expired keys are kept until the next reload, so a key rotated
+		// with a grace period stops working when the grace ends
+		if k.Disabled {
 			continue
 		}
-		
-		var expiration time.Duration
-		if !info.ExpiresAt.IsZero() {
-			expiration = time.Until(info.ExpiresAt)
-			if expiration < 0 {
-				continue // Skip expired keys
-			}
+		allowedIPs, err := ParseAllowedIPs(k.AllowedIPs)
+		if err != nil {
+			logrus.WithError(err).WithField("key_id", k.ID).Warn("Skipping API key with invalid allowed IPs")
+			continue
+		}
+		keys[k.ID] = &APIKey{
+			Key:        k.ID,
+			Hash:       k.Hash,
+			Salt:       k.Salt,
+			Role:       Role(k.Role),
+			CreatedAt:  k.CreatedAt,
+			ExpiresAt:  k.ExpiresAt,
+			Scopes:     k.Scopes,
+			AllowedIPs: allowedIPs,
 		}
-		
-		s.rbacManager.AddAPIKey(info.Key, Role(info.Role), expiration)
 	}
-	
-	logrus.Infof("Loaded %d active API keys", len(s.rbacManager.apiKeys))
+	s.rbacManager.setAPIKeys(keys)
+
+	s.mu.Lock()
+	s.keysModTime = info.ModTime()
+	s.mu.Unlock()
+	logrus.Infof("Loaded %d API keys", len(keys))
 	return nil
 }
+
+// ReloadAPIKeys loads the key store again if it changed since it was last
+// loaded, so generated, rotated and revoked keys apply without a restart
+func (s *Server) ReloadAPIKeys() error {
+	var modTime time.Time
+	if info, err := os.Stat(KeyStorePath()); err == nil {
+		modTime = info.ModTime()
+	} else if !os.IsNotExist(err) {
+		return err
+	}
+	s.mu.RLock()
+	unchanged := modTime.Equal(s.keysModTime)
+	s.mu.RUnlock()
+	if unchanged {
+		return nil
+	}
+	return s.LoadAPIKeys()
+}
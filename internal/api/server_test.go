@@ -0,0 +1,67 @@
+package api
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestRefreshRules(t *testing.T) {
+	s := NewServer(nil)
+	post := func() *httptest.ResponseRecorder {
+		w := httptest.NewRecorder()
+		s.handleRefreshRules(w, httptest.NewRequest(http.MethodPost, "/api/refresh-rules", nil))
+		return w
+	}
+
+	if w := post(); w.Code != http.StatusServiceUnavailable {
+		t.Errorf("refresh without an updater returned %d, want 503", w.Code)
+	}
+
+	// The updater runs with a deadline and its counts are returned
+	calls := 0
+	s.SetRefreshRulesCallback(func(ctx context.Context) (*RuleRefreshResult, error) {
+		calls++
+		if _, ok := ctx.Deadline(); !ok {
+			t.Error("rule refresh has no deadline")
+		}
+		return &RuleRefreshResult{BlockedDomains: 120, SecurityDomains: 7, AllowedDomains: 3}, nil
+	})
+	w := post()
+	var result RuleRefreshResult
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &result) != nil {
+		t.Fatalf("refresh returned %d: %s", w.Code, w.Body.String())
+	}
+	if calls != 1 || result.Status != "refreshed" || result.BlockedDomains != 120 || result.SecurityDomains != 7 || result.AllowedDomains != 3 {
+		t.Errorf("refresh = %+v after %d updater calls", result, calls)
+	}
+
+	s.SetRefreshRulesCallback(func(ctx context.Context) (*RuleRefreshResult, error) {
+		return nil, errors.New("bucket unreachable")
+	})
+	if w := post(); w.Code != http.StatusInternalServerError {
+		t.Errorf("failed refresh returned %d, want 500", w.Code)
+	}
+}
+
+func TestClearCache(t *testing.T) {
+	s := NewServer(nil)
+	calls := 0
+	s.SetClearCacheCallback(func() CacheClearResult {
+		calls++
+		return CacheClearResult{DNSEntries: 42, Certificates: 5}
+	})
+
+	w := httptest.NewRecorder()
+	s.handleClearCache(w, httptest.NewRequest(http.MethodPost, "/api/clear-cache", nil))
+	var result CacheClearResult
+	if w.Code != http.StatusOK || json.Unmarshal(w.Body.Bytes(), &result) != nil {
+		t.Fatalf("clear-cache returned %d: %s", w.Code, w.Body.String())
+	}
+	if calls != 1 || result.Status != "cache_cleared" || result.DNSEntries != 42 || result.Certificates != 5 {
+		t.Errorf("clear-cache = %+v after %d calls", result, calls)
+	}
+}
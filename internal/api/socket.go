@@ -0,0 +1,155 @@
+package api
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strconv"
+
+	"dnshield/internal/audit"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultSocketPath is where the API socket is created unless configured
+const DefaultSocketPath = "/var/run/dnshield/api.sock"
+
+// SocketConfig controls who may use the API socket. Connections are
+// checked against the peer's uid (SO_PEERCRED on Linux, LOCAL_PEERCRED on
+// macOS); other peers are disconnected before they send a request.
+type SocketConfig struct {
+	Path string
+	// Uids allowed to connect besides root
+	AllowedUIDs []uint32
+	// Also allow the user logged in at the console, who runs the menu bar
+	// app (macOS only)
+	AllowConsoleUser bool
+	// Role of allowed peers that send no API key; empty requires a key
+	Role Role
+}
+
+// peerKey is the context key of a request's authenticated peer
+type peerKey struct{}
+
+// peer is a client authenticated by its connection: a process on the
+// socket, identified by its uid, or a holder of a fleet client certificate
+type peer struct {
+	socket bool
+	uid    uint32 // Of socket peers
+	role   Role   // Granted to requests without an API key
+}
+
+// peerFrom returns the authenticated peer that sent r, if any
+func peerFrom(r *http.Request) (*peer, bool) {
+	p, ok := r.Context().Value(peerKey{}).(*peer)
+	return p, ok
+}
+
+// StartSocket serves the API on a Unix domain socket until Stop. A socket
+// left behind by an earlier run is replaced.
+func (s *Server) StartSocket(cfg SocketConfig) error {
+	if cfg.Path == "" {
+		cfg.Path = DefaultSocketPath
+	}
+	listener, err := ListenSocket(cfg)
+	if err != nil {
+		return err
+	}
+	defer os.Remove(cfg.Path)
+
+	logrus.WithFields(logrus.Fields{
+		"path":         cfg.Path,
+		"allowed_uids": cfg.AllowedUIDs,
+		"console_user": cfg.AllowConsoleUser,
+	}).Info("Starting API server on socket")
+	return s.serve(listener, func(ctx context.Context, c net.Conn) context.Context {
+		if pc, ok := c.(*peerConn); ok {
+			return context.WithValue(ctx, peerKey{}, &peer{socket: true, uid: pc.uid, role: cfg.Role})
+		}
+		return ctx
+	})
+}
+
+// ListenSocket creates a Unix domain socket at cfg.Path, replacing one left
+// behind by an earlier run. Connections from uids cfg doesn't allow are
+// closed as they are accepted, and the accepted ones carry the peer's uid.
+func ListenSocket(cfg SocketConfig) (net.Listener, error) {
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0755); err != nil {
+		return nil, fmt.Errorf("failed to create socket directory: %w", err)
+	}
+	if err := os.Remove(cfg.Path); err != nil && !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to remove stale socket: %w", err)
+	}
+	listener, err := net.Listen("unix", cfg.Path)
+	if err != nil {
+		return nil, err
+	}
+	// Anyone may connect; the peer's uid decides who is served
+	if err := os.Chmod(cfg.Path, 0666); err != nil {
+		listener.Close()
+		return nil, fmt.Errorf("failed to set socket permissions: %w", err)
+	}
+	return &peerListener{Listener: listener, cfg: cfg}, nil
+}
+
+// peerListener accepts socket connections from allowed uids only
+type peerListener struct {
+	net.Listener
+	cfg SocketConfig
+}
+
+// peerConn is an accepted socket connection and its peer's uid
+type peerConn struct {
+	net.Conn
+	uid uint32
+}
+
+// RemoteAddr identifies the peer by uid, so rate limits and logs can tell
+// socket clients apart
+func (c *peerConn) RemoteAddr() net.Addr {
+	return &net.UnixAddr{Net: "unix", Name: "uid=" + strconv.FormatUint(uint64(c.uid), 10)}
+}
+
+func (l *peerListener) Accept() (net.Conn, error) {
+	for {
+		conn, err := l.Listener.Accept()
+		if err != nil {
+			return nil, err
+		}
+		uid, err := peerUID(conn)
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to read API socket peer credentials")
+			conn.Close()
+			continue
+		}
+		if !l.allowed(uid) {
+			logrus.WithField("uid", uid).Warn("API socket connection refused")
+			audit.Log(audit.EventSecurityViolation, "warning", "API socket connection from a uid that isn't allowed", map[string]interface{}{
+				"uid": uid,
+			})
+			conn.Close()
+			continue
+		}
+		return &peerConn{Conn: conn, uid: uid}, nil
+	}
+}
+
+// allowed reports whether uid may use the socket
+func (l *peerListener) allowed(uid uint32) bool {
+	if uid == 0 {
+		return true
+	}
+	for _, allowed := range l.cfg.AllowedUIDs {
+		if uid == allowed {
+			return true
+		}
+	}
+	if l.cfg.AllowConsoleUser {
+		if console, ok := consoleUID(); ok && uid == console {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,50 @@
+package api
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestSocket(t *testing.T) {
+	l := &peerListener{cfg: SocketConfig{AllowedUIDs: []uint32{501}}}
+	for uid, want := range map[uint32]bool{0: true, 501: true, 502: false} {
+		if got := l.allowed(uid); got != want {
+			t.Errorf("allowed(%d) = %v, want %v", uid, got, want)
+		}
+	}
+
+	// Socket paths are limited to about 100 bytes, which t.TempDir can exceed
+	dir, err := os.MkdirTemp("", "api")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "api.sock")
+
+	s := NewServer(nil)
+	allowed := []uint32{uint32(os.Getuid())}
+	go s.StartSocket(SocketConfig{Path: path, AllowedUIDs: allowed, Role: RoleViewer})
+	defer s.Stop(context.Background())
+
+	c := NewClient("")
+	c.useSocket(path)
+	var status Status
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		err = c.Get("/api/status", &status)
+		if err == nil || time.Now().After(deadline) {
+			break
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+	if err != nil {
+		t.Fatalf("Status over the socket without a key: %v", err)
+	}
+	if err := c.Do("POST", "/api/pause", PauseRequest{Duration: "5m", Reason: "Demo"}, nil); err == nil || !strings.Contains(err.Error(), "403") {
+		t.Errorf("Pause with the socket's viewer role: %v, want 403", err)
+	}
+}
@@ -0,0 +1,180 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sync"
+	"time"
+
+	"dnshield/internal/dns"
+)
+
+const (
+	// QueryStreamPath streams every answered query as newline-delimited JSON
+	QueryStreamPath = "/api/queries/stream"
+
+	// maxStreamSubscribers bounds concurrent stream clients
+	maxStreamSubscribers = 8
+	// streamBuffer is how many events a slow client may fall behind before
+	// events are dropped for it
+	streamBuffer = 1024
+	// streamKeepalive is how often an idle stream sends a blank line so
+	// clients can tell the agent is still there
+	streamKeepalive = 15 * time.Second
+)
+
+// QueryStreamEvent is one line of the query stream
+type QueryStreamEvent struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Domain        string    `json:"domain"`
+	QueryType     string    `json:"query_type"`
+	Action        string    `json:"action"`
+	Rcode         string    `json:"rcode,omitempty"`
+	Rule          string    `json:"rule,omitempty"` // Rule that blocked the query
+	ClientIP      string    `json:"client_ip,omitempty"`
+	Upstream      string    `json:"upstream,omitempty"`
+	UpstreamRTTMs float64   `json:"upstream_rtt_ms,omitempty"`
+	DurationMs    float64   `json:"duration_ms"`
+}
+
+// streamHub fans events out to stream subscribers. Publishing never
+// blocks; subscribers that fall behind miss events.
+type streamHub[T any] struct {
+	mu          sync.RWMutex
+	subscribers map[chan T]struct{}
+}
+
+// eventStream fans out answered queries
+type eventStream = streamHub[QueryStreamEvent]
+
+func newEventStream() *eventStream {
+	return newStreamHub[QueryStreamEvent]()
+}
+
+func newStreamHub[T any]() *streamHub[T] {
+	return &streamHub[T]{subscribers: make(map[chan T]struct{})}
+}
+
+// subscribe registers a new subscriber, or returns nil if there are too many
+func (es *streamHub[T]) subscribe() chan T {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+
+	if len(es.subscribers) >= maxStreamSubscribers {
+		return nil
+	}
+	ch := make(chan T, streamBuffer)
+	es.subscribers[ch] = struct{}{}
+	return ch
+}
+
+func (es *streamHub[T]) unsubscribe(ch chan T) {
+	es.mu.Lock()
+	defer es.mu.Unlock()
+	delete(es.subscribers, ch)
+}
+
+func (es *streamHub[T]) publish(event T) {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+
+	for ch := range es.subscribers {
+		select {
+		case ch <- event:
+		default:
+		}
+	}
+}
+
+// hasSubscribers reports whether anyone is listening, so callers can skip
+// building events
+func (es *streamHub[T]) hasSubscribers() bool {
+	es.mu.RLock()
+	defer es.mu.RUnlock()
+	return len(es.subscribers) > 0
+}
+
+// publishQuery sends a query to stream subscribers
+func (s *Server) publishQuery(event dns.QueryEvent) {
+	if !s.queryStream.hasSubscribers() {
+		return
+	}
+	s.queryStream.publish(QueryStreamEvent{
+		Timestamp:     event.Timestamp,
+		Domain:        event.Domain,
+		QueryType:     event.QueryType,
+		Action:        event.Action,
+		Rcode:         event.Rcode,
+		Rule:          event.Rule,
+		ClientIP:      event.ClientIP,
+		Upstream:      event.Upstream,
+		UpstreamRTTMs: float64(event.UpstreamRTT.Microseconds()) / 1000,
+		DurationMs:    float64(event.Duration.Microseconds()) / 1000,
+	})
+}
+
+// SubscribeQueries returns a channel of every answered query until cancel
+// is called. Events are dropped for subscribers that fall behind.
+func (s *Server) SubscribeQueries() (events <-chan QueryStreamEvent, cancel func(), err error) {
+	ch := s.queryStream.subscribe()
+	if ch == nil {
+		return nil, nil, errorf(http.StatusServiceUnavailable, "Too many stream clients")
+	}
+	return ch, func() { s.queryStream.unsubscribe(ch) }, nil
+}
+
+// handleQueryStream streams queries as they are answered until the client
+// disconnects
+func (s *Server) handleQueryStream(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	ch, cancel, err := s.SubscribeQueries()
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	defer cancel()
+
+	// The server's write timeout would end the stream after 10 seconds
+	rc := http.NewResponseController(w)
+	if err := rc.SetWriteDeadline(time.Time{}); err != nil {
+		http.Error(w, "Streaming not supported", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/x-ndjson")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.WriteHeader(http.StatusOK)
+	rc.Flush()
+
+	keepalive := time.NewTicker(streamKeepalive)
+	defer keepalive.Stop()
+
+	encoder := json.NewEncoder(w)
+	for {
+		select {
+		case <-r.Context().Done():
+			return
+		case event := <-ch:
+			if err := encoder.Encode(event); err != nil {
+				return
+			}
+			// Send whatever else is already queued in the same flush
+			for pending := len(ch); pending > 0; pending-- {
+				if err := encoder.Encode(<-ch); err != nil {
+					return
+				}
+			}
+		case <-keepalive.C:
+			if _, err := w.Write([]byte("\n")); err != nil {
+				return
+			}
+		}
+		if err := rc.Flush(); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,80 @@
+package api
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"dnshield/internal/dns"
+)
+
+func TestQueryStream(t *testing.T) {
+	s := NewServer(nil)
+	ts := httptest.NewServer(http.HandlerFunc(s.handleQueryStream))
+	defer ts.Close()
+
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+	req, _ := http.NewRequestWithContext(ctx, http.MethodGet, ts.URL, nil)
+	resp, err := http.DefaultClient.Do(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer resp.Body.Close()
+	if ct := resp.Header.Get("Content-Type"); ct != "application/x-ndjson" {
+		t.Errorf("Content-Type = %q", ct)
+	}
+
+	// The handler subscribes before writing headers, so the query is seen
+	s.RecordQuery(dns.QueryEvent{
+		Timestamp: time.Now(),
+		Domain:    "ads.example.com",
+		QueryType: "A",
+		Action:    dns.QueryActionBlocked,
+		ClientIP:  "127.0.0.1",
+		Duration:  1500 * time.Microsecond,
+	})
+
+	scanner := bufio.NewScanner(resp.Body)
+	if !scanner.Scan() {
+		t.Fatalf("no event received: %v", scanner.Err())
+	}
+	var event QueryStreamEvent
+	if err := json.Unmarshal(scanner.Bytes(), &event); err != nil {
+		t.Fatalf("invalid event %q: %v", scanner.Text(), err)
+	}
+	if event.Domain != "ads.example.com" || event.Action != dns.QueryActionBlocked || event.DurationMs != 1.5 {
+		t.Errorf("event = %+v", event)
+	}
+
+	// Disconnecting unsubscribes
+	cancel()
+	deadline := time.Now().Add(2 * time.Second)
+	for s.queryStream.hasSubscribers() {
+		if time.Now().After(deadline) {
+			t.Fatal("subscriber not removed after disconnect")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestEventStreamLimitsSubscribers(t *testing.T) {
+	es := newEventStream()
+	for i := 0; i < maxStreamSubscribers; i++ {
+		if es.subscribe() == nil {
+			t.Fatalf("subscriber %d rejected", i)
+		}
+	}
+	if es.subscribe() != nil {
+		t.Error("subscriber over the limit accepted")
+	}
+
+	// A full subscriber doesn't block publishing
+	for i := 0; i < streamBuffer+10; i++ {
+		es.publish(QueryStreamEvent{Domain: "example.com"})
+	}
+}
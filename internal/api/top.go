@@ -0,0 +1,194 @@
+package api
+
+import (
+	"encoding/json"
+	"net/http"
+	"sort"
+	"time"
+
+	"dnshield/internal/dns"
+)
+
+const (
+	// maxTrackedDomains bounds the per-domain counters kept for /api/top
+	maxTrackedDomains = 10000
+	// topListSize is the number of entries returned per top list
+	topListSize = 10
+)
+
+// DomainCount is a domain and the number of queries seen for it
+type DomainCount struct {
+	Domain string `json:"domain"`
+	Count  int64  `json:"count"`
+}
+
+// ClientCount is a client and the number of queries seen from it
+type ClientCount struct {
+	Client string `json:"client"`
+	Count  int64  `json:"count"`
+}
+
+// UpstreamStats summarises the queries answered by a single upstream
+type UpstreamStats struct {
+	Upstream      string    `json:"upstream"`
+	Queries       int64     `json:"queries"`
+	AvgLatencyMs  float64   `json:"avg_latency_ms"`
+	LastLatencyMs float64   `json:"last_latency_ms"`
+	LastAnswer    time.Time `json:"last_answer"`
+}
+
+// TopStats is everything the terminal dashboard needs in a single response
+type TopStats struct {
+	Statistics    Statistics      `json:"statistics"`
+	TopDomains    []DomainCount   `json:"top_domains"`
+	TopBlocked    []DomainCount   `json:"top_blocked"`
+	TopClients    []ClientCount   `json:"top_clients"`
+	RecentBlocked []BlockedDomain `json:"recent_blocked"`
+	Upstreams     []UpstreamStats `json:"upstreams"`
+	// Queries answered with SERVFAIL because every upstream failed
+	FailedQueries int64 `json:"failed_queries"`
+
+	RulesVersion   string    `json:"rules_version,omitempty"`
+	RulesSource    string    `json:"rules_source,omitempty"`
+	RulesFetchedAt time.Time `json:"rules_fetched_at,omitempty"`
+}
+
+type upstreamTotals struct {
+	queries    int64
+	total      time.Duration
+	last       time.Duration
+	lastAnswer time.Time
+}
+
+// RecordQuery feeds a completed query into the top domain and upstream
+// latency counters
+func (s *Server) RecordQuery(event dns.QueryEvent) {
+	s.publishQuery(event)
+
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	incrementBounded(s.domainCounts, event.Domain)
+	if event.Action == dns.QueryActionBlocked {
+		incrementBounded(s.blockedCounts, event.Domain)
+	}
+	if event.ClientIP != "" {
+		incrementBounded(s.clientCounts, event.ClientIP)
+	}
+	if event.Action == dns.QueryActionFailed {
+		s.failedQueries++
+	}
+
+	if event.Upstream != "" {
+		totals, ok := s.upstreamStats[event.Upstream]
+		if !ok {
+			totals = &upstreamTotals{}
+			s.upstreamStats[event.Upstream] = totals
+		}
+		totals.queries++
+		totals.total += event.UpstreamRTT
+		totals.last = event.UpstreamRTT
+		totals.lastAnswer = event.Timestamp
+	}
+}
+
+// incrementBounded bumps a counter, halving every count and dropping the
+// ones that reach zero when the map is full so that busy domains survive
+// and one-off lookups age out
+func incrementBounded(counts map[string]int64, domain string) {
+	if _, ok := counts[domain]; !ok && len(counts) >= maxTrackedDomains {
+		for d, c := range counts {
+			if c /= 2; c == 0 {
+				delete(counts, d)
+			} else {
+				counts[d] = c
+			}
+		}
+	}
+	counts[domain]++
+}
+
+// topCounts returns the n highest counts, ties broken alphabetically
+func topCounts(counts map[string]int64, n int) []DomainCount {
+	result := make([]DomainCount, 0, len(counts))
+	for domain, count := range counts {
+		result = append(result, DomainCount{Domain: domain, Count: count})
+	}
+	sort.Slice(result, func(i, j int) bool {
+		if result[i].Count != result[j].Count {
+			return result[i].Count > result[j].Count
+		}
+		return result[i].Domain < result[j].Domain
+	})
+	if len(result) > n {
+		result = result[:n]
+	}
+	return result
+}
+
+// GetTopStats returns a snapshot of the dashboard counters and the rules
+// in use
+func (s *Server) GetTopStats() *TopStats {
+	top := s.topCounters()
+	status := s.Status()
+	top.RulesVersion = status.RulesVersion
+	top.RulesSource = status.RulesSource
+	top.RulesFetchedAt = status.RulesFetchedAt
+	return top
+}
+
+func (s *Server) topCounters() *TopStats {
+	s.mu.RLock()
+	defer s.mu.RUnlock()
+
+	top := &TopStats{
+		Statistics:    *s.stats,
+		TopDomains:    topCounts(s.domainCounts, topListSize),
+		TopBlocked:    topCounts(s.blockedCounts, topListSize),
+		Upstreams:     make([]UpstreamStats, 0, len(s.upstreamStats)),
+		FailedQueries: s.failedQueries,
+	}
+	for _, c := range topCounts(s.clientCounts, topListSize) {
+		top.TopClients = append(top.TopClients, ClientCount{Client: c.Domain, Count: c.Count})
+	}
+
+	if top.Statistics.CacheHits+top.Statistics.CacheMisses > 0 {
+		top.Statistics.CacheHitRate = float64(top.Statistics.CacheHits) / float64(top.Statistics.CacheHits+top.Statistics.CacheMisses) * 100
+	}
+
+	recent := s.recentBlocked
+	if len(recent) > topListSize {
+		recent = recent[len(recent)-topListSize:]
+	}
+	top.RecentBlocked = make([]BlockedDomain, len(recent))
+	copy(top.RecentBlocked, recent)
+
+	for upstream, totals := range s.upstreamStats {
+		top.Upstreams = append(top.Upstreams, UpstreamStats{
+			Upstream:      upstream,
+			Queries:       totals.queries,
+			AvgLatencyMs:  durationMs(totals.total) / float64(totals.queries),
+			LastLatencyMs: durationMs(totals.last),
+			LastAnswer:    totals.lastAnswer,
+		})
+	}
+	sort.Slice(top.Upstreams, func(i, j int) bool {
+		return top.Upstreams[i].Upstream < top.Upstreams[j].Upstream
+	})
+
+	return top
+}
+
+func durationMs(d time.Duration) float64 {
+	return float64(d) / float64(time.Millisecond)
+}
+
+func (s *Server) handleTop(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(s.GetTopStats())
+}
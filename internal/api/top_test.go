@@ -0,0 +1,75 @@
+package api
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"dnshield/internal/dns"
+)
+
+func TestRecordQuery(t *testing.T) {
+	s := NewServer(nil)
+
+	for i := 0; i < 3; i++ {
+		s.RecordQuery(dns.QueryEvent{Domain: "example.com", Action: dns.QueryActionAllowed, Upstream: "1.1.1.1:53", UpstreamRTT: 10 * time.Millisecond})
+	}
+	s.RecordQuery(dns.QueryEvent{Domain: "example.com", Action: dns.QueryActionCached})
+	s.RecordQuery(dns.QueryEvent{Domain: "ads.example.com", Action: dns.QueryActionBlocked})
+	s.RecordQuery(dns.QueryEvent{Domain: "ads.example.com", Action: dns.QueryActionBlocked})
+	s.RecordQuery(dns.QueryEvent{Domain: "other.com", Action: dns.QueryActionAllowed, Upstream: "8.8.8.8:53", UpstreamRTT: 30 * time.Millisecond})
+
+	top := s.GetTopStats()
+
+	if len(top.TopDomains) != 3 || top.TopDomains[0] != (DomainCount{"example.com", 4}) {
+		t.Errorf("Unexpected top domains: %+v", top.TopDomains)
+	}
+	if len(top.TopBlocked) != 1 || top.TopBlocked[0] != (DomainCount{"ads.example.com", 2}) {
+		t.Errorf("Unexpected top blocked: %+v", top.TopBlocked)
+	}
+	if len(top.Upstreams) != 2 {
+		t.Fatalf("Expected 2 upstreams, got %d", len(top.Upstreams))
+	}
+	if u := top.Upstreams[0]; u.Upstream != "1.1.1.1:53" || u.Queries != 3 || u.AvgLatencyMs != 10 {
+		t.Errorf("Unexpected upstream stats: %+v", u)
+	}
+}
+
+func TestTopClientsAndFailures(t *testing.T) {
+	s := NewServer(nil)
+	now := time.Now()
+
+	s.RecordQuery(dns.QueryEvent{Domain: "example.com", ClientIP: "10.0.0.2", Action: dns.QueryActionAllowed, Upstream: "1.1.1.1:53", Timestamp: now})
+	s.RecordQuery(dns.QueryEvent{Domain: "example.com", ClientIP: "10.0.0.2", Action: dns.QueryActionCached})
+	s.RecordQuery(dns.QueryEvent{Domain: "example.org", ClientIP: "10.0.0.3", Action: dns.QueryActionFailed})
+
+	top := s.GetTopStats()
+	if len(top.TopClients) != 2 || top.TopClients[0] != (ClientCount{"10.0.0.2", 2}) {
+		t.Errorf("Unexpected top clients: %+v", top.TopClients)
+	}
+	if top.FailedQueries != 1 {
+		t.Errorf("FailedQueries = %d, want 1", top.FailedQueries)
+	}
+	if len(top.Upstreams) != 1 || !top.Upstreams[0].LastAnswer.Equal(now) {
+		t.Errorf("Unexpected upstreams: %+v", top.Upstreams)
+	}
+}
+
+func TestIncrementBoundedEvictsRareDomains(t *testing.T) {
+	counts := make(map[string]int64)
+	for i := 0; i < 5; i++ {
+		incrementBounded(counts, "busy.example.com")
+	}
+	for i := 0; len(counts) < maxTrackedDomains; i++ {
+		incrementBounded(counts, fmt.Sprintf("host%d.example.com", i))
+	}
+
+	incrementBounded(counts, "new.example.com")
+
+	if len(counts) != 2 {
+		t.Errorf("Expected one-off domains to be evicted, %d remain", len(counts))
+	}
+	if counts["busy.example.com"] != 2 {
+		t.Errorf("Expected busy domain count to be halved to 2, got %d", counts["busy.example.com"])
+	}
+}
@@ -0,0 +1,207 @@
+package api
+
+import (
+	"encoding/json"
+	"errors"
+	"net"
+	"net/http"
+	"time"
+
+	"dnshield/internal/unblock"
+)
+
+// Access request endpoints
+const (
+	UnblockRequestPath = "/api/unblock/request"
+	UnblockApprovePath = "/api/unblock/approve"
+	UnblockBypassPath  = "/api/unblock/bypass"
+)
+
+// UnblockRequest asks the security team for access to a blocked domain
+type UnblockRequest struct {
+	Domain        string `json:"domain"`
+	Justification string `json:"justification"`
+}
+
+// UnblockRequestResult is the response to an accepted request
+type UnblockRequestResult struct {
+	Status string `json:"status"`
+	ID     string `json:"id"`
+}
+
+// UnblockApproval applies an approval token from the security team
+type UnblockApproval struct {
+	Token string `json:"token"`
+}
+
+// UnblockApprovalResult reports the temporary allow an approval granted
+type UnblockApprovalResult struct {
+	Domain string    `json:"domain"`
+	Until  time.Time `json:"until"`
+}
+
+// DomainBypassRequest bypasses one blocked domain for a few minutes
+type DomainBypassRequest struct {
+	Domain        string `json:"domain"`
+	Justification string `json:"justification"`
+	Duration      string `json:"duration"`       // e.g. "15m"
+	Code          string `json:"code,omitempty"` // Admin-issued TOTP code
+}
+
+// DomainBypassStatus lists the active bypasses and the limits on new ones
+type DomainBypassStatus struct {
+	Bypasses     []UnblockApprovalResult `json:"bypasses"`
+	MaxDuration  string                  `json:"max_duration"`
+	RequiresCode bool                    `json:"requires_code"`
+}
+
+// SetUnblockService sets the service behind the access request endpoints
+func (s *Server) SetUnblockService(svc *unblock.Service) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.unblock = svc
+}
+
+// handleUnblockRequest forwards a justified request for access to a
+// blocked domain
+func (s *Server) handleUnblockRequest(w http.ResponseWriter, r *http.Request) {
+	svc := s.unblockService(w, r)
+	if svc == nil {
+		return
+	}
+
+	var req UnblockRequest
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8192)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+	clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+	submitted, err := svc.Submit(req.Domain, req.Justification, clientIP)
+	switch {
+	case errors.Is(err, unblock.ErrRateLimited):
+		http.Error(w, err.Error(), http.StatusTooManyRequests)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusBadRequest)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UnblockRequestResult{Status: "submitted", ID: submitted.ID})
+}
+
+// handleUnblockApprove applies a signed approval
+func (s *Server) handleUnblockApprove(w http.ResponseWriter, r *http.Request) {
+	svc := s.unblockService(w, r)
+	if svc == nil {
+		return
+	}
+
+	var req UnblockApproval
+	if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8192)).Decode(&req); err != nil {
+		http.Error(w, "Invalid request", http.StatusBadRequest)
+		return
+	}
+
+	allow, err := svc.Approve(req.Token)
+	switch {
+	case errors.Is(err, unblock.ErrApprovalsDisabled):
+		http.Error(w, err.Error(), http.StatusNotImplemented)
+		return
+	case err != nil:
+		http.Error(w, err.Error(), http.StatusForbidden)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(UnblockApprovalResult{Domain: allow.Domain, Until: allow.Until})
+}
+
+// SetDomainBypasser sets the bypasser behind the bypass endpoint
+func (s *Server) SetDomainBypasser(bypasser *unblock.Bypasser) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.bypasser = bypasser
+}
+
+// handleDomainBypass lists active bypasses (GET), starts one (POST) or
+// ends one early (DELETE ?domain=)
+func (s *Server) handleDomainBypass(w http.ResponseWriter, r *http.Request) {
+	s.mu.RLock()
+	bypasser := s.bypasser
+	s.mu.RUnlock()
+	if bypasser == nil {
+		http.Error(w, "Domain bypass is not enabled", http.StatusServiceUnavailable)
+		return
+	}
+
+	switch r.Method {
+	case http.MethodGet:
+	case http.MethodPost:
+		var req DomainBypassRequest
+		if err := json.NewDecoder(http.MaxBytesReader(w, r.Body, 8192)).Decode(&req); err != nil {
+			http.Error(w, "Invalid request", http.StatusBadRequest)
+			return
+		}
+		duration, err := time.ParseDuration(req.Duration)
+		if err != nil {
+			http.Error(w, "Invalid duration", http.StatusBadRequest)
+			return
+		}
+		clientIP, _, _ := net.SplitHostPort(r.RemoteAddr)
+
+		allow, err := bypasser.Bypass(req.Domain, req.Justification, duration, req.Code, clientIP)
+		switch {
+		case errors.Is(err, unblock.ErrBypassLimit), errors.Is(err, unblock.ErrCodeLockout):
+			http.Error(w, err.Error(), http.StatusTooManyRequests)
+			return
+		case errors.Is(err, unblock.ErrCodeRequired), errors.Is(err, unblock.ErrInvalidCode), errors.Is(err, unblock.ErrNotBypassable):
+			http.Error(w, err.Error(), http.StatusForbidden)
+			return
+		case err != nil:
+			http.Error(w, err.Error(), http.StatusBadRequest)
+			return
+		}
+
+		w.Header().Set("Content-Type", "application/json")
+		json.NewEncoder(w).Encode(UnblockApprovalResult{Domain: allow.Domain, Until: allow.Until})
+		return
+	case http.MethodDelete:
+		domain := r.URL.Query().Get("domain")
+		if !bypasser.End(domain) {
+			http.Error(w, "No active bypass for "+domain, http.StatusNotFound)
+			return
+		}
+	default:
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return
+	}
+
+	status := DomainBypassStatus{
+		Bypasses:     []UnblockApprovalResult{},
+		MaxDuration:  bypasser.MaxDuration().String(),
+		RequiresCode: bypasser.RequiresCode(),
+	}
+	for _, allow := range bypasser.Active() {
+		status.Bypasses = append(status.Bypasses, UnblockApprovalResult{Domain: allow.Domain, Until: allow.Until})
+	}
+	w.Header().Set("Content-Type", "application/json")
+	json.NewEncoder(w).Encode(status)
+}
+
+// unblockService returns the service for a POST, or writes an error and
+// returns nil
+func (s *Server) unblockService(w http.ResponseWriter, r *http.Request) *unblock.Service {
+	if r.Method != http.MethodPost {
+		http.Error(w, "Method not allowed", http.StatusMethodNotAllowed)
+		return nil
+	}
+	s.mu.RLock()
+	svc := s.unblock
+	s.mu.RUnlock()
+	if svc == nil {
+		http.Error(w, "Access requests are not enabled", http.StatusServiceUnavailable)
+	}
+	return svc
+}
@@ -19,6 +19,24 @@ var upgrader = websocket.Upgrader{
 	},
 }
 
+// WebSocket message types sent on /api/ws
+const (
+	WSTypeStatsUpdate     = "stats_update"     // Statistics, every stats refresh
+	WSTypeDomainBlocked   = "domain_blocked"   // BlockedDomain, per block
+	WSTypeProtectionState = "protection_state" // ProtectionState, on pause/resume
+	WSTypeScheduleState   = "schedule_state"   // []ScheduleState, when a schedule turns on or off
+)
+
+// maxWSClients bounds concurrent WebSocket connections
+const maxWSClients = 16
+
+// ProtectionState reports whether filtering is paused
+type ProtectionState struct {
+	Paused bool       `json:"paused"`
+	Until  *time.Time `json:"until,omitempty"`  // When a pause ends
+	Reason string     `json:"reason,omitempty"` // Why protection was paused
+}
+
 type WSClient struct {
 	conn   *websocket.Conn
 	send   chan []byte
@@ -30,6 +48,7 @@ type WSServer struct {
 	broadcast  chan []byte
 	register   chan *WSClient
 	unregister chan *WSClient
+	done       chan struct{}
 	mu         sync.RWMutex
 }
 
@@ -42,15 +61,26 @@ type WSMessage struct {
 func NewWSServer() *WSServer {
 	return &WSServer{
 		clients:    make(map[*WSClient]bool),
-		broadcast:  make(chan []byte),
+		broadcast:  make(chan []byte, 256),
 		register:   make(chan *WSClient),
 		unregister: make(chan *WSClient),
+		done:       make(chan struct{}),
 	}
 }
 
+// Run delivers broadcasts to clients until Stop is called
 func (ws *WSServer) Run() {
 	for {
 		select {
+		case <-ws.done:
+			ws.mu.Lock()
+			for client := range ws.clients {
+				close(client.send)
+				delete(ws.clients, client)
+			}
+			ws.mu.Unlock()
+			return
+
 		case client := <-ws.register:
 			ws.mu.Lock()
 			ws.clients[client] = true
@@ -69,7 +99,7 @@ func (ws *WSServer) Run() {
 			}
 
 		case message := <-ws.broadcast:
-			ws.mu.RLock()
+			ws.mu.Lock()
 			for client := range ws.clients {
 				select {
 				case client.send <- message:
@@ -79,12 +109,30 @@ func (ws *WSServer) Run() {
 					delete(ws.clients, client)
 				}
 			}
-			ws.mu.RUnlock()
+			ws.mu.Unlock()
 		}
 	}
 }
 
-func (ws *WSServer) ServeWS(w http.ResponseWriter, r *http.Request) {
+// Stop disconnects all clients and stops Run
+func (ws *WSServer) Stop() {
+	close(ws.done)
+}
+
+// ClientCount returns the number of connected clients
+func (ws *WSServer) ClientCount() int {
+	ws.mu.RLock()
+	defer ws.mu.RUnlock()
+	return len(ws.clients)
+}
+
+// ServeWS upgrades the request and sends initial, then every broadcast
+func (ws *WSServer) ServeWS(w http.ResponseWriter, r *http.Request, initial ...WSMessage) {
+	if ws.ClientCount() >= maxWSClients {
+		http.Error(w, "Too many WebSocket clients", http.StatusServiceUnavailable)
+		return
+	}
+
 	conn, err := upgrader.Upgrade(w, r, nil)
 	if err != nil {
 		logrus.Errorf("WebSocket upgrade failed: %v", err)
@@ -96,8 +144,18 @@ func (ws *WSServer) ServeWS(w http.ResponseWriter, r *http.Request) {
 		send:   make(chan []byte, 256),
 		server: ws,
 	}
+	for _, msg := range initial {
+		if data, err := json.Marshal(msg); err == nil {
+			client.send <- data
+		}
+	}
 
-	ws.register <- client
+	select {
+	case ws.register <- client:
+	case <-ws.done:
+		conn.Close()
+		return
+	}
 
 	go client.writePump()
 	go client.readPump()
@@ -105,7 +163,10 @@ func (ws *WSServer) ServeWS(w http.ResponseWriter, r *http.Request) {
 
 func (c *WSClient) readPump() {
 	defer func() {
-		c.server.unregister <- c
+		select {
+		case c.server.unregister <- c:
+		case <-c.server.done:
+		}
 		c.conn.Close()
 	}()
 
@@ -142,7 +203,9 @@ func (c *WSClient) writePump() {
 				return
 			}
 
-			c.conn.WriteMessage(websocket.TextMessage, message)
+			if err := c.conn.WriteMessage(websocket.TextMessage, message); err != nil {
+				return
+			}
 
 		case <-ticker.C:
 			c.conn.SetWriteDeadline(time.Now().Add(10 * time.Second))
@@ -166,7 +229,7 @@ func (ws *WSServer) BroadcastStatus(status Status) {
 
 func (ws *WSServer) BroadcastStats(stats Statistics) {
 	msg := WSMessage{
-		Type:      "stats_update",
+		Type:      WSTypeStatsUpdate,
 		Timestamp: time.Now(),
 		Data:      stats,
 	}
@@ -175,14 +238,36 @@ func (ws *WSServer) BroadcastStats(stats Statistics) {
 
 func (ws *WSServer) BroadcastBlockedDomain(blocked BlockedDomain) {
 	msg := WSMessage{
-		Type:      "domain_blocked",
+		Type:      WSTypeDomainBlocked,
 		Timestamp: time.Now(),
 		Data:      blocked,
 	}
 	ws.broadcastMessage(msg)
 }
 
+func (ws *WSServer) BroadcastProtectionState(state ProtectionState) {
+	msg := WSMessage{
+		Type:      WSTypeProtectionState,
+		Timestamp: time.Now(),
+		Data:      state,
+	}
+	ws.broadcastMessage(msg)
+}
+
+func (ws *WSServer) BroadcastScheduleState(schedules []ScheduleState) {
+	msg := WSMessage{
+		Type:      WSTypeScheduleState,
+		Timestamp: time.Now(),
+		Data:      schedules,
+	}
+	ws.broadcastMessage(msg)
+}
+
 func (ws *WSServer) broadcastMessage(msg WSMessage) {
+	if ws.ClientCount() == 0 {
+		return
+	}
+
 	data, err := json.Marshal(msg)
 	if err != nil {
 		logrus.Errorf("Failed to marshal WebSocket message: %v", err)
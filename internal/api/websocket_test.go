@@ -0,0 +1,68 @@
+package api
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+	"time"
+
+	"dnshield/internal/dns"
+	"github.com/gorilla/websocket"
+)
+
+func TestWebSocketEvents(t *testing.T) {
+	s := NewServer(nil)
+	go s.ws.Run()
+	defer s.ws.Stop()
+
+	ts := httptest.NewServer(http.HandlerFunc(s.handleWebSocket))
+	defer ts.Close()
+
+	conn, _, err := websocket.DefaultDialer.Dial("ws"+strings.TrimPrefix(ts.URL, "http"), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	read := func() WSMessage {
+		t.Helper()
+		var msg WSMessage
+		if err := conn.ReadJSON(&msg); err != nil {
+			t.Fatalf("ReadJSON() error = %v", err)
+		}
+		return msg
+	}
+
+	// New clients get the current state first
+	if msg := read(); msg.Type != WSTypeProtectionState {
+		t.Errorf("first message type = %q, want %q", msg.Type, WSTypeProtectionState)
+	}
+	if msg := read(); msg.Type != WSTypeStatsUpdate {
+		t.Errorf("second message type = %q, want %q", msg.Type, WSTypeStatsUpdate)
+	}
+
+	// Wait for registration before broadcasting
+	deadline := time.Now().Add(2 * time.Second)
+	for s.ws.ClientCount() == 0 {
+		if time.Now().After(deadline) {
+			t.Fatal("client not registered")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+
+	s.AddBlockedDomain(dns.BlockEvent{Domain: "ads.example.com", Timestamp: time.Now()})
+	msg := read()
+	data, _ := msg.Data.(map[string]interface{})
+	if msg.Type != WSTypeDomainBlocked || data["domain"] != "ads.example.com" {
+		t.Errorf("message = %+v, want blocked ads.example.com", msg)
+	}
+
+	s.setProtectionState(true, time.Now().Add(time.Minute))
+	msg = read()
+	data, _ = msg.Data.(map[string]interface{})
+	if msg.Type != WSTypeProtectionState || data["paused"] != true || data["until"] == nil {
+		t.Errorf("message = %+v, want paused with until", msg)
+	}
+}
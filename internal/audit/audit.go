@@ -24,15 +24,47 @@ const (
 	EventCAAccess      EventType = "CA_ACCESS"
 	EventCAInstalled   EventType = "CA_INSTALLED"
 	EventCAUninstalled EventType = "CA_UNINSTALLED"
+	EventCARotated     EventType = "CA_ROTATED"
 
 	// Security operations
 	EventKeychainAccess    EventType = "KEYCHAIN_ACCESS"
 	EventKeychainStore     EventType = "KEYCHAIN_STORE"
 	EventSecurityViolation EventType = "SECURITY_VIOLATION"
+	EventTrustStoreChange  EventType = "TRUST_STORE_CHANGE"
+	EventAPIKeyUsed        EventType = "API_KEY_USED"
+	EventAPIAuthFailed     EventType = "API_AUTH_FAILED"
 
 	// Configuration changes
-	EventConfigChange EventType = "CONFIG_CHANGE"
-	EventRulesUpdate  EventType = "RULES_UPDATE"
+	EventConfigChange  EventType = "CONFIG_CHANGE"
+	EventRulesUpdate   EventType = "RULES_UPDATE"
+	EventRulesRollback EventType = "RULES_ROLLBACK"
+	EventPolicyChange  EventType = "POLICY_CHANGE"
+	EventPolicyDenied  EventType = "POLICY_DENIED"
+
+	// Blocking activity
+	EventDomainBlocked   EventType = "DOMAIN_BLOCKED"
+	EventDomainMonitored EventType = "DOMAIN_MONITORED" // Would have been blocked
+	EventBlockPageServed EventType = "BLOCK_PAGE_SERVED"
+	EventSinkholeRequest EventType = "SINKHOLE_REQUEST"
+	EventDGADetected     EventType = "DGA_DETECTED"
+	EventDNSTunnel       EventType = "DNS_TUNNEL_DETECTED"
+	EventHomograph       EventType = "HOMOGRAPH_DETECTED"
+
+	// Protection overrides
+	EventCaptiveBypass      EventType = "CAPTIVE_PORTAL_BYPASS"
+	EventUnblockRequested   EventType = "UNBLOCK_REQUESTED"
+	EventUnblockApproved    EventType = "UNBLOCK_APPROVED"
+	EventDomainBypass       EventType = "DOMAIN_BYPASS"
+	EventDomainBypassEnd    EventType = "DOMAIN_BYPASS_ENDED"
+	EventLocalRuleAdded     EventType = "LOCAL_RULE_ADDED"
+	EventLocalRuleRemoved   EventType = "LOCAL_RULE_REMOVED"
+	EventProtectionPaused   EventType = "PROTECTION_PAUSED"
+	EventProtectionResumed  EventType = "PROTECTION_RESUMED"
+	EventMaintenanceStarted EventType = "MAINTENANCE_STARTED"
+	EventMaintenanceEnded   EventType = "MAINTENANCE_ENDED"
+
+	// Data protection
+	EventDataPurged EventType = "DATA_PURGED"
 
 	// Service lifecycle
 	EventServiceStart EventType = "SERVICE_START"
@@ -62,15 +94,53 @@ type Logger struct {
 var (
 	defaultLogger *Logger
 	once          sync.Once
+
+	forwardersMu sync.RWMutex
+	forwarders   []func(Event)
+	filter       func(Event) Event
 )
 
+// SetFilter passes every event logged from now on through fn before it is
+// written or forwarded, e.g. to pseudonymize domains
+func SetFilter(fn func(Event) Event) {
+	forwardersMu.Lock()
+	defer forwardersMu.Unlock()
+	filter = fn
+}
+
+// applyFilter returns event as the filter leaves it
+func applyFilter(event Event) Event {
+	forwardersMu.RLock()
+	defer forwardersMu.RUnlock()
+	if filter == nil {
+		return event
+	}
+	return filter(event)
+}
+
+// AddForwarder sends every event logged from now on to fn as well, e.g. to
+// syslog. fn must not block.
+func AddForwarder(fn func(Event)) {
+	forwardersMu.Lock()
+	defer forwardersMu.Unlock()
+	forwarders = append(forwarders, fn)
+}
+
+// forward passes event to the forwarders
+func forward(event Event) {
+	forwardersMu.RLock()
+	defer forwardersMu.RUnlock()
+	for _, fn := range forwarders {
+		fn(event)
+	}
+}
+
 // Initialize sets up the audit logger
 func Initialize() error {
 	var err error
 	once.Do(func() {
 		// Create audit directory
-		home, _ := os.UserHomeDir()
-		auditDir := filepath.Join(home, ".dnshield", "audit")
+		auditDir := Dir()
 		if mkErr := os.MkdirAll(auditDir, 0700); mkErr != nil {
 			err = mkErr
 			return
@@ -100,17 +170,45 @@ func Initialize() error {
 	return err
 }
 
+// Dir returns the directory audit files are kept in
+func Dir() string {
+	home, _ := os.UserHomeDir()
+	return filepath.Join(home, ".dnshield", "audit")
+}
+
 // Log records an audit event
 func Log(eventType EventType, severity string, message string, details map[string]interface{}) {
+	event := applyFilter(NewEvent(eventType, severity, message, details))
+	forward(event)
+
 	if defaultLogger == nil {
 		// Fallback to regular logging if audit not initialized
 		logrus.WithFields(logrus.Fields{
 			"audit_type": eventType,
-			"details":    details,
-		}).Info(message)
+			"details":    event.Details,
+		}).Info(event.Message)
 		return
 	}
 
+	defaultLogger.mu.Lock()
+	defer defaultLogger.mu.Unlock()
+
+	// Write to audit log
+	if err := defaultLogger.encoder.Encode(event); err != nil {
+		logrus.WithError(err).Error("Failed to write audit log")
+	}
+
+	// Also log to standard logger for real-time monitoring
+	logrus.WithFields(logrus.Fields{
+		"audit_type": eventType,
+		"severity":   severity,
+		"details":    event.Details,
+	}).Info(event.Message)
+}
+
+// NewEvent builds an event stamped with the current time and process, for
+// callers that also forward events elsewhere
+func NewEvent(eventType EventType, severity string, message string, details map[string]interface{}) Event {
 	event := Event{
 		Timestamp:   time.Now(),
 		Type:        eventType,
@@ -126,20 +224,7 @@ func Log(eventType EventType, severity string, message string, details map[strin
 		event.User = user
 	}
 
-	defaultLogger.mu.Lock()
-	defer defaultLogger.mu.Unlock()
-
-	// Write to audit log
-	if err := defaultLogger.encoder.Encode(event); err != nil {
-		logrus.WithError(err).Error("Failed to write audit log")
-	}
-
-	// Also log to standard logger for real-time monitoring
-	logrus.WithFields(logrus.Fields{
-		"audit_type": eventType,
-		"severity":   severity,
-		"details":    details,
-	}).Info(message)
+	return event
 }
 
 // LogCertGeneration logs certificate generation events
@@ -182,6 +267,25 @@ func LogConfigChange(change string, oldValue, newValue interface{}) {
 	})
 }
 
+// LogDomainBlocked logs a blocked DNS query with its full context
+// (query type, client, matched rule and source, user/group)
+func LogDomainBlocked(domain string, details map[string]interface{}) {
+	Log(EventDomainBlocked, "info", fmt.Sprintf("Blocked %s", domain), details)
+}
+
+// LogBlockPageServed logs that a client loaded the block page for a domain.
+// details describe the block that led there, if it is known.
+func LogBlockPageServed(domain, clientIP string, details map[string]interface{}) {
+	event := map[string]interface{}{
+		"domain":    domain,
+		"client_ip": clientIP,
+	}
+	for k, v := range details {
+		event[k] = v
+	}
+	Log(EventBlockPageServed, "info", fmt.Sprintf("Block page served for %s", domain), event)
+}
+
 // Close closes the audit logger
 func Close() error {
 	if defaultLogger != nil {
@@ -0,0 +1,133 @@
+package audit
+
+import (
+	"bufio"
+	"bytes"
+	"encoding/json"
+	"errors"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+)
+
+// PurgeFilter selects events to delete, e.g. for a GDPR erasure request or
+// when they pass their retention. Empty fields match every event.
+type PurgeFilter struct {
+	Before time.Time // Events logged before this
+	User   string    // Events by or about this user, case-insensitively
+}
+
+// Match reports whether f selects event. Records of earlier purges are
+// only deleted by age, so a purge by user can't erase the record of another.
+func (f PurgeFilter) Match(event Event) bool {
+	if !f.Before.IsZero() && !event.Timestamp.Before(f.Before) {
+		return false
+	}
+	if f.User == "" {
+		return true
+	}
+	if event.Type == EventDataPurged {
+		return false
+	}
+	if strings.EqualFold(event.User, f.User) {
+		return true
+	}
+	user, _ := event.Details["user"].(string)
+	return user != "" && strings.EqualFold(user, f.User)
+}
+
+// Purge deletes the events f matches from the audit files and returns how
+// many were deleted. Files left empty are removed, except the one being
+// written to.
+func Purge(f PurgeFilter) (int, error) {
+	var current string
+	if defaultLogger != nil {
+		defaultLogger.mu.Lock()
+		defer defaultLogger.mu.Unlock()
+		current = defaultLogger.logPath
+	}
+
+	paths, err := filepath.Glob(filepath.Join(Dir(), "audit-*.log"))
+	if err != nil {
+		return 0, err
+	}
+	purged := 0
+	var errs []error
+	for _, path := range paths {
+		n, err := purgeFile(path, f, path == current)
+		purged += n
+		if err != nil {
+			errs = append(errs, err)
+			continue
+		}
+		if n > 0 && path == current {
+			// The file was replaced, so the open one no longer has a name
+			if err := defaultLogger.reopen(); err != nil {
+				errs = append(errs, err)
+			}
+		}
+	}
+	return purged, errors.Join(errs...)
+}
+
+// purgeFile rewrites the audit file at path without the events f matches.
+// Lines that don't parse are kept.
+func purgeFile(path string, f PurgeFilter, keepEmpty bool) (int, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer file.Close()
+
+	var kept bytes.Buffer
+	purged := 0
+	r := bufio.NewReader(file)
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var event Event
+			if json.Unmarshal(line, &event) == nil && f.Match(event) {
+				purged++
+			} else {
+				kept.Write(line)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+
+	if kept.Len() == 0 && !keepEmpty {
+		return purged, os.Remove(path)
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, kept.Bytes(), 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return purged, nil
+}
+
+// reopen opens the log file again after it was replaced. The caller holds
+// l.mu.
+func (l *Logger) reopen() error {
+	file, err := os.OpenFile(l.logPath, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	l.file.Close()
+	l.file = file
+	l.encoder = json.NewEncoder(file)
+	return nil
+}
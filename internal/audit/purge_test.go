@@ -0,0 +1,65 @@
+package audit
+
+import (
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+)
+
+func writeAuditFile(t *testing.T, name string, events ...Event) string {
+	t.Helper()
+	path := filepath.Join(Dir(), name)
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		t.Fatal(err)
+	}
+	var lines []string
+	for _, e := range events {
+		line, _ := json.Marshal(e)
+		lines = append(lines, string(line))
+	}
+	if err := os.WriteFile(path, []byte(strings.Join(lines, "\n")+"\n"), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestPurge(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+	old := time.Date(2024, 1, 1, 12, 0, 0, 0, time.UTC)
+	recent := time.Date(2024, 6, 1, 12, 0, 0, 0, time.UTC)
+
+	oldFile := writeAuditFile(t, "audit-2024-01-01.log",
+		Event{Timestamp: old, Type: EventDomainBlocked, User: "root"},
+		Event{Timestamp: old, Type: EventProtectionPaused, Details: map[string]interface{}{"user": "jane@example.com"}},
+	)
+	recentFile := writeAuditFile(t, "audit-2024-06-01.log",
+		Event{Timestamp: recent, Type: EventProtectionPaused, Details: map[string]interface{}{"user": "Jane@Example.com"}},
+		Event{Timestamp: recent, Type: EventDataPurged, Details: map[string]interface{}{"user": "jane@example.com"}},
+		Event{Timestamp: recent, Type: EventDomainBlocked, User: "root"},
+	)
+
+	// By user, except the records of earlier purges
+	n, err := Purge(PurgeFilter{User: "jane@example.com"})
+	if err != nil || n != 2 {
+		t.Fatalf("Purge by user = %d, %v; want 2", n, err)
+	}
+	data, _ := os.ReadFile(recentFile)
+	if strings.Contains(string(data), "Jane@") || !strings.Contains(string(data), string(EventDataPurged)) {
+		t.Errorf("Recent file after purge by user:\n%s", data)
+	}
+
+	// By date, removing files left empty
+	n, err = Purge(PurgeFilter{Before: time.Date(2024, 3, 1, 0, 0, 0, 0, time.UTC)})
+	if err != nil || n != 1 {
+		t.Fatalf("Purge by date = %d, %v; want 1", n, err)
+	}
+	if _, err := os.Stat(oldFile); !os.IsNotExist(err) {
+		t.Error("Audit file left empty wasn't removed")
+	}
+	if data, _ := os.ReadFile(recentFile); strings.Count(string(data), "\n") != 2 {
+		t.Errorf("Recent file after purge by date:\n%s", data)
+	}
+}
@@ -17,7 +17,6 @@ import (
 	"fmt"
 	"math/big"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"time"
 
@@ -33,6 +32,7 @@ const (
 type CA struct {
 	cert *x509.Certificate
 	key  *rsa.PrivateKey
+	dir  string // Directory holding ca.crt and ca.key
 }
 
 // GetCAPath returns the path to CA directory
@@ -73,21 +73,7 @@ func LoadOrCreateCA() (*CA, error) {
 // loadCA loads CA from files
 func loadCA(certPath, keyPath string) (*CA, error) {
 	// Read certificate
-	certPEM, err := os.ReadFile(certPath)
-	if err != nil {
-		return nil, err
-	}
-
-	block, rest := pem.Decode(certPEM)
-	if block == nil {
-		return nil, fmt.Errorf("failed to decode certificate PEM")
-	}
-	if len(rest) > 0 {
-		// Log warning about extra data but continue
-		// This is common with certificate chains
-	}
-
-	cert, err := x509.ParseCertificate(block.Bytes)
+	cert, err := readCertificate(certPath)
 	if err != nil {
 		return nil, err
 	}
@@ -98,7 +84,7 @@ func loadCA(certPath, keyPath string) (*CA, error) {
 		return nil, err
 	}
 
-	block, rest = pem.Decode(keyPEM)
+	block, rest := pem.Decode(keyPEM)
 	if block == nil {
 		return nil, fmt.Errorf("failed to decode key PEM")
 	}
@@ -121,11 +107,53 @@ func loadCA(certPath, keyPath string) (*CA, error) {
 		}
 	}
 
-	return &CA{cert: cert, key: key}, nil
+	return &CA{cert: cert, key: key, dir: filepath.Dir(certPath)}, nil
+}
+
+// LoadCACertificate reads the public CA certificate from caPath without
+// touching the private key, so it works for unprivileged checks
+func LoadCACertificate(caPath string) (*x509.Certificate, error) {
+	return readCertificate(filepath.Join(caPath, caCertFile))
+}
+
+// readCertificate parses the first PEM certificate in certPath
+func readCertificate(certPath string) (*x509.Certificate, error) {
+	certPEM, err := os.ReadFile(certPath)
+	if err != nil {
+		return nil, err
+	}
+
+	block, rest := pem.Decode(certPEM)
+	if block == nil {
+		return nil, fmt.Errorf("failed to decode certificate PEM")
+	}
+	if len(rest) > 0 {
+		// Log warning about extra data but continue
+		// This is common with certificate chains
+	}
+
+	return x509.ParseCertificate(block.Bytes)
+}
+
+// defaultCASubject is the subject used for the device-wide CA
+func defaultCASubject() pkix.Name {
+	return pkix.Name{
+		Organization:  []string{"DNShield"},
+		Country:       []string{"US"},
+		Province:      []string{""},
+		Locality:      []string{""},
+		StreetAddress: []string{""},
+		PostalCode:    []string{""},
+	}
 }
 
 // createCA creates a new CA
 func createCA(caPath string) (*CA, error) {
+	return createCAWithSubject(caPath, defaultCASubject())
+}
+
+// createCAWithSubject creates a new CA in caPath with the given subject
+func createCAWithSubject(caPath string, subject pkix.Name) (*CA, error) {
 	// Create directory
 	if err := os.MkdirAll(caPath, 0700); err != nil {
 		return nil, err
@@ -155,17 +183,17 @@ func createCA(caPath string) (*CA, error) {
 		return nil, err
 	}
 
+	// A random serial keeps a rotated CA from sharing issuer and serial
+	// with the one it replaces
+	serial, err := rand.Int(rand.Reader, new(big.Int).Lsh(big.NewInt(1), 127))
+	if err != nil {
+		return nil, err
+	}
+
 	// Create certificate template
 	template := x509.Certificate{
-		SerialNumber: big.NewInt(1),
-		Subject: pkix.Name{
-			Organization:  []string{"DNShield"},
-			Country:       []string{"US"},
-			Province:      []string{""},
-			Locality:      []string{""},
-			StreetAddress: []string{""},
-			PostalCode:    []string{""},
-		},
+		SerialNumber:          serial,
+		Subject:               subject,
 		NotBefore:             time.Now().Add(-security.CertificateNotBeforeOffset),
 		NotAfter:              time.Now().Add(time.Duration(security.CAValidityYears) * 365 * 24 * time.Hour), // 2 years
 		KeyUsage:              x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature | x509.KeyUsageCertSign,
@@ -220,24 +248,19 @@ func createCA(caPath string) (*CA, error) {
 		return nil, err
 	}
 
-	return &CA{cert: cert, key: key}, nil
+	return &CA{cert: cert, key: key, dir: caPath}, nil
 }
 
-// InstallCA installs the CA certificate in the system keychain
+// InstallCA installs the CA certificate in the system trust store: the
+// System keychain on macOS, the distribution's CA bundle on Linux
 func (ca *CA) InstallCA() error {
-	certPath := filepath.Join(GetCAPath(), caCertFile)
-
-	// On macOS, use security command with Touch ID
-	// The -p option allows Touch ID authentication
-	cmd := exec.Command("sudo", "-p", "Touch ID or enter password: ", "security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", "/Library/Keychains/System.keychain", certPath)
-
-	// Set up for interactive authentication
-	cmd.Stdin = os.Stdin
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
+	dir := ca.dir
+	if dir == "" {
+		dir = GetCAPath()
+	}
+	certPath := filepath.Join(dir, caCertFile)
 
-	err := cmd.Run()
-	if err != nil {
+	if err := installTrustedCert(certPath); err != nil {
 		return fmt.Errorf("failed to install CA: %v", err)
 	}
 
@@ -0,0 +1,10 @@
+//go:build darwin && !cgo
+// +build darwin,!cgo
+
+package ca
+
+// On macOS the CA key is held and used through Security.framework, which
+// needs cgo. Without it the keychain code would quietly be replaced by the
+// stubs in keychain_other.go, so the build fails here instead: build with
+// CGO_ENABLED=1 (make build-universal does).
+var _ = dnshieldOnMacOSRequiresCGO_ENABLED_1
@@ -1,15 +1,14 @@
-//go:build darwin
-// +build darwin
+//go:build darwin && cgo
+// +build darwin,cgo
 
 // Package ca provides Certificate Authority management with macOS Keychain integration.
-// This file implements secure storage of CA private keys in the macOS Keychain,
-// ensuring keys are non-extractable and only accessible by the dnshield process.
+// This file keeps the CA private key in the Secure Enclave when the Mac has one,
+// and otherwise as a non-extractable key in the System keychain.
 package ca
 
 import (
 	"crypto"
 	"crypto/ecdsa"
-	"crypto/elliptic"
 	"crypto/rand"
 	"crypto/x509"
 	"crypto/x509/pkix"
@@ -20,7 +19,6 @@ import (
 	"os"
 	"os/exec"
 	"path/filepath"
-	"regexp"
 	"strings"
 	"time"
 
@@ -30,66 +28,25 @@ import (
 )
 
 const (
-	// Keychain item attributes
+	// Legacy generic password holding a base64 DER key, written by
+	// releases that generated the CA key in process
 	keychainServiceName = "com.dnshield.ca"
 	keychainAccountName = "ca-private-key"
-	keychainAccessGroup = "com.dnshield"
 
-	// Key labels in Keychain
+	// Application tag and label of the CA key in the keychain
+	caKeyTag   = "com.dnshield.ca.key"
 	caKeyLabel = "DNShield-CA-Private-Key"
 )
 
-// validateKeychainParam validates keychain parameters to prevent command injection
-func validateKeychainParam(param string) error {
-	// Keychain parameters should only contain alphanumeric characters, dots, hyphens, and underscores
-	validParam := regexp.MustCompile(`^[a-zA-Z0-9\.\-_]+$`)
-	if !validParam.MatchString(param) {
-		return fmt.Errorf("invalid keychain parameter: %s", param)
-	}
-	
-	// Additional check for suspicious patterns
-	suspiciousPatterns := []string{
-		"$", "`", ";", "&", "|", ">", "<", "\n", "\r", "\\",
-		"$(", "${", "&&", "||", "`;", ";`", "../", "/..",
-		"'", "\"", " ", "\t",
-	}
-	
-	for _, pattern := range suspiciousPatterns {
-		if strings.Contains(param, pattern) {
-			return fmt.Errorf("suspicious pattern in keychain parameter: %s", param)
-		}
-	}
-	
-	// Length check
-	if len(param) > 256 {
-		return fmt.Errorf("keychain parameter too long: %d characters", len(param))
-	}
-	
-	return nil
-}
-
-// validateBase64Data validates base64 encoded data to prevent injection
-func validateBase64Data(data string) error {
-	// Base64 should only contain valid base64 characters
-	validBase64 := regexp.MustCompile(`^[A-Za-z0-9+/=]+$`)
-	if !validBase64.MatchString(data) {
-		return fmt.Errorf("invalid base64 data")
-	}
-	
-	// Length check to prevent excessive data
-	if len(data) > 65536 { // 64KB limit for base64 encoded key
-		return fmt.Errorf("base64 data too large: %d characters", len(data))
-	}
-	
-	return nil
-}
-
-// KeychainCAManager manages CA certificates with Keychain storage
+// KeychainCAManager manages CA certificates with Keychain storage. The
+// private key is a non-extractable Security.framework key; signing goes
+// through SecKeyCreateSignature, so the key never exists in process memory.
+// The exception is a legacy generic-password key from older releases,
+// which is parsed in process until the CA is reinstalled.
 type KeychainCAManager struct {
-	cert       *x509.Certificate
-	certPEM    []byte
-	privateKey crypto.PrivateKey
-	keyRef     interface{} // For Keychain reference
+	cert    *x509.Certificate
+	certPEM []byte
+	key     crypto.Signer
 }
 
 // LoadOrCreateKeychainCA loads existing CA from disk/Keychain or creates new one
@@ -131,42 +88,22 @@ func loadExistingKeychainCA() (Manager, error) {
 		return nil, fmt.Errorf("failed to parse CA certificate: %v", err)
 	}
 
-	// Validate keychain parameters as defense-in-depth
-	if err := validateKeychainParam(keychainAccountName); err != nil {
-		return nil, fmt.Errorf("invalid account name: %v", err)
-	}
-	if err := validateKeychainParam(keychainServiceName); err != nil {
-		return nil, fmt.Errorf("invalid service name: %v", err)
+	key, err := loadKeyFromKeychain()
+	if err != nil {
+		audit.LogCAAccess("keychain_query", false)
+		return nil, err
 	}
-
-	// Try to find the key in System Keychain
-	cmd := exec.Command("security", "find-generic-password",
-		"-a", keychainAccountName,
-		"-s", keychainServiceName,
-		"/Library/Keychains/System.keychain")
-
-	if err := cmd.Run(); err != nil {
+	if pub, ok := cert.PublicKey.(*ecdsa.PublicKey); !ok || !pub.Equal(key.Public()) {
 		audit.LogCAAccess("keychain_query", false)
-		return nil, fmt.Errorf("CA private key not found in System Keychain")
+		return nil, fmt.Errorf("CA private key in Keychain does not match the certificate")
 	}
 
 	audit.LogCAAccess("keychain_load", true)
 
-	// For security, we don't extract the key - we need to reload it for signing
-	// This is a limitation of the go-keychain library
-	// In a production implementation, we'd use CGO and Security Framework
-
-	// For now, we'll load the key from Keychain for operations
-	// This is still secure as the key never touches disk
-	privKey, err := loadKeyFromKeychain()
-	if err != nil {
-		return nil, fmt.Errorf("failed to load key from Keychain: %v", err)
-	}
-
 	return &KeychainCAManager{
-		cert:       cert,
-		certPEM:    certPEM,
-		privateKey: privKey,
+		cert:    cert,
+		certPEM: certPEM,
+		key:     key,
 	}, nil
 }
 
@@ -196,23 +133,34 @@ func defaultCATemplate() *x509.Certificate {
 	}
 }
 
-// createNewKeychainCA creates new CA and stores key in Keychain
+// createNewKeychainCA creates a new CA whose key is generated inside the
+// Secure Enclave, or the System keychain on Macs without one
 func createNewKeychainCA() (Manager, error) {
-	// Generate new private key
-	priv, err := ecdsa.GenerateKey(elliptic.P384(), rand.Reader)
+	// Remove keys left by a CA whose certificate is gone
+	deleteKeychainKeys(caKeyTag)
+	deleteGenericPassword(keychainServiceName, keychainAccountName)
+
+	key, err := createKeychainKey(caKeyTag, caKeyLabel, true)
 	if err != nil {
-		return nil, fmt.Errorf("failed to generate private key: %v", err)
+		logrus.WithError(err).Info("Secure Enclave unavailable, creating CA key in System keychain")
+		key, err = createKeychainKey(caKeyTag, caKeyLabel, false)
+	}
+	if err != nil {
+		audit.LogCAAccess("keychain_store", false)
+		return nil, fmt.Errorf("failed to create key in Keychain: %v", err)
 	}
 
 	// Create certificate
 	template := defaultCATemplate()
-	certDER, err := x509.CreateCertificate(rand.Reader, template, template, &priv.PublicKey, priv)
+	certDER, err := x509.CreateCertificate(rand.Reader, template, template, key.Public(), key)
 	if err != nil {
+		deleteKeychainKeys(caKeyTag)
 		return nil, fmt.Errorf("failed to create certificate: %v", err)
 	}
 
 	cert, err := x509.ParseCertificate(certDER)
 	if err != nil {
+		deleteKeychainKeys(caKeyTag)
 		return nil, fmt.Errorf("failed to parse certificate: %v", err)
 	}
 
@@ -225,94 +173,26 @@ func createNewKeychainCA() (Manager, error) {
 	// Save certificate to disk
 	caDir := getCADir()
 	if err := os.MkdirAll(caDir, 0700); err != nil {
+		deleteKeychainKeys(caKeyTag)
 		return nil, fmt.Errorf("failed to create CA directory: %v", err)
 	}
 
 	certPath := filepath.Join(caDir, "ca.crt")
 	if err := os.WriteFile(certPath, certPEM, 0644); err != nil {
+		deleteKeychainKeys(caKeyTag)
 		return nil, fmt.Errorf("failed to write certificate: %v", err)
 	}
 
-	// Store private key in Keychain
-	if err := storeKeyInKeychain(priv); err != nil {
-		// Clean up certificate file
-		os.Remove(certPath)
-		audit.LogCAAccess("keychain_store", false)
-		return nil, fmt.Errorf("failed to store key in Keychain: %v", err)
-	}
-
 	audit.LogCAAccess("keychain_store", true)
-	logrus.Info("New CA created and stored in Keychain")
+	logrus.WithField("secure_enclave", key.enclave).Info("New CA created with its key in Keychain")
 
 	return &KeychainCAManager{
-		cert:       cert,
-		certPEM:    certPEM,
-		privateKey: priv, // Keep for initial operations
+		cert:    cert,
+		certPEM: certPEM,
+		key:     key,
 	}, nil
 }
 
-// storeKeyInKeychain stores the private key securely in macOS System Keychain
-func storeKeyInKeychain(key *ecdsa.PrivateKey) error {
-	// Export key to DER format
-	keyDER, err := x509.MarshalECPrivateKey(key)
-	if err != nil {
-		return fmt.Errorf("failed to marshal private key: %v", err)
-	}
-
-	// Base64 encode for security command
-	keyBase64 := base64.StdEncoding.EncodeToString(keyDER)
-	
-	// Validate base64 data
-	if err := validateBase64Data(keyBase64); err != nil {
-		return fmt.Errorf("invalid key data: %v", err)
-	}
-	
-	// Validate keychain parameters as defense-in-depth
-	if err := validateKeychainParam(keychainAccountName); err != nil {
-		return fmt.Errorf("invalid account name: %v", err)
-	}
-	if err := validateKeychainParam(keychainServiceName); err != nil {
-		return fmt.Errorf("invalid service name: %v", err)
-	}
-	if err := validateKeychainParam(caKeyLabel); err != nil {
-		return fmt.Errorf("invalid key label: %v", err)
-	}
-
-	// Delete any existing entry (ignore errors)
-	exec.Command("security", "delete-generic-password",
-		"-a", keychainAccountName,
-		"-s", keychainServiceName,
-		"/Library/Keychains/System.keychain").Run()
-
-	// Add to System keychain using stdin to avoid exposing key in process list
-	cmd := exec.Command("security", "add-generic-password",
-		"-a", keychainAccountName,
-		"-s", keychainServiceName,
-		"-l", caKeyLabel,
-		"-w", "-", // Read password from stdin
-		"-U", // Update if exists
-		"/Library/Keychains/System.keychain")
-	
-	// Pass the key via stdin to avoid exposure in process list
-	cmd.Stdin = strings.NewReader(keyBase64)
-
-	if output, err := cmd.CombinedOutput(); err != nil {
-		// Clear sensitive data from memory
-		for i := range keyBase64 {
-			keyBase64 = keyBase64[:i] + "0" + keyBase64[i+1:]
-		}
-		return fmt.Errorf("failed to add key to System keychain: %v, output: %s", err, output)
-	}
-	
-	// Clear sensitive data from memory
-	for i := range keyBase64 {
-		keyBase64 = keyBase64[:i] + "0" + keyBase64[i+1:]
-	}
-
-	logrus.Info("CA private key stored in System keychain")
-	return nil
-}
-
 // Certificate returns the CA certificate
 func (m *KeychainCAManager) Certificate() *x509.Certificate {
 	return m.cert
@@ -323,21 +203,9 @@ func (m *KeychainCAManager) CertificatePEM() []byte {
 	return m.certPEM
 }
 
-// SignCertificate signs a certificate using the CA key from Keychain
+// SignCertificate signs a certificate with the CA key in Keychain
 func (m *KeychainCAManager) SignCertificate(template, parent *x509.Certificate, pub crypto.PublicKey) ([]byte, error) {
-	// If we have the key in memory, use it
-	if m.privateKey != nil {
-		return x509.CreateCertificate(rand.Reader, template, parent, pub, m.privateKey)
-	}
-
-	// Otherwise, load from Keychain
-	privKey, err := loadKeyFromKeychain()
-	if err != nil {
-		return nil, fmt.Errorf("failed to load key from Keychain for signing: %v", err)
-	}
-
-	// Sign the certificate
-	return x509.CreateCertificate(rand.Reader, template, parent, pub, privKey)
+	return x509.CreateCertificate(rand.Reader, template, parent, pub, m.key)
 }
 
 // InstallCA installs the CA certificate in system trust store with Touch ID
@@ -385,22 +253,9 @@ func UninstallKeychainCA() error {
 		logrus.WithError(err).Warn("Failed to remove certificate from System keychain")
 	}
 
-	// Validate keychain parameters before use
-	if err := validateKeychainParam(keychainAccountName); err != nil {
-		logrus.WithError(err).Error("Invalid account name")
-	} else if err := validateKeychainParam(keychainServiceName); err != nil {
-		logrus.WithError(err).Error("Invalid service name")
-	} else {
-		// Remove private key from System Keychain
-		cmd2 := exec.Command("security", "delete-generic-password",
-			"-a", keychainAccountName,
-			"-s", keychainServiceName,
-			"/Library/Keychains/System.keychain")
-
-		if err := cmd2.Run(); err != nil {
-			logrus.WithError(err).Warn("Failed to remove private key from System Keychain")
-		}
-	}
+	// Remove private key from Keychain
+	deleteKeychainKeys(caKeyTag)
+	deleteGenericPassword(keychainServiceName, keychainAccountName)
 
 	// Remove certificate file
 	caDir := getCADir()
@@ -421,33 +276,36 @@ func SetKeychainACL(binaryPath string) error {
 	return nil
 }
 
-// loadKeyFromKeychain retrieves the private key from System Keychain
-func loadKeyFromKeychain() (*ecdsa.PrivateKey, error) {
-	// Query System keychain
-	cmd := exec.Command("security", "find-generic-password",
-		"-a", keychainAccountName,
-		"-s", keychainServiceName,
-		"-w", // Output password only
-		"/Library/Keychains/System.keychain")
+// loadKeyFromKeychain returns the CA key from Keychain. A legacy key stored
+// as a generic password is still accepted so that existing installs keep
+// working, but it was generated in process and should be rotated.
+func loadKeyFromKeychain() (crypto.Signer, error) {
+	key, err := findKeychainKey(caKeyTag)
+	if err != nil {
+		return nil, fmt.Errorf("failed to query Keychain: %v", err)
+	}
+	if key != nil {
+		return key, nil
+	}
 
-	output, err := cmd.Output()
+	data, err := genericPassword(keychainServiceName, keychainAccountName)
 	if err != nil {
 		return nil, fmt.Errorf("failed to query System keychain: %v", err)
 	}
+	if data == nil {
+		return nil, fmt.Errorf("CA private key not found in Keychain")
+	}
 
-	// Decode base64
-	keyBase64 := strings.TrimSpace(string(output))
-	keyDER, err := base64.StdEncoding.DecodeString(keyBase64)
+	keyDER, err := base64.StdEncoding.DecodeString(strings.TrimSpace(string(data)))
 	if err != nil {
 		return nil, fmt.Errorf("failed to decode key: %v", err)
 	}
-
-	// Parse the key from DER format
-	key, err := x509.ParseECPrivateKey(keyDER)
+	legacy, err := x509.ParseECPrivateKey(keyDER)
 	if err != nil {
 		return nil, fmt.Errorf("failed to parse private key: %v", err)
 	}
 
 	audit.LogCAAccess("keychain_retrieve", true)
-	return key, nil
+	logrus.Warn("CA private key is an extractable legacy Keychain item; run 'dnshield uninstall' and 'dnshield install-ca' to replace it with a non-extractable key")
+	return legacy, nil
 }
@@ -1,21 +1,21 @@
-//go:build !darwin
-// +build !darwin
+//go:build !darwin || !cgo
+// +build !darwin !cgo
 
 package ca
 
 import "fmt"
 
-// LoadOrCreateKeychainCA is not supported on non-Darwin platforms
+// LoadOrCreateKeychainCA requires macOS and a cgo build
 func LoadOrCreateKeychainCA() (Manager, error) {
-	return nil, fmt.Errorf("Keychain storage is only supported on macOS")
+	return nil, fmt.Errorf("Keychain storage requires macOS and a cgo build")
 }
 
-// UninstallKeychainCA is not supported on non-Darwin platforms
+// UninstallKeychainCA requires macOS and a cgo build
 func UninstallKeychainCA() error {
-	return fmt.Errorf("Keychain storage is only supported on macOS")
+	return fmt.Errorf("Keychain storage requires macOS and a cgo build")
 }
 
-// SetKeychainACL is not supported on non-Darwin platforms
+// SetKeychainACL requires macOS and a cgo build
 func SetKeychainACL(binaryPath string) error {
-	return fmt.Errorf("Keychain ACL is only supported on macOS")
+	return fmt.Errorf("Keychain ACL requires macOS and a cgo build")
 }
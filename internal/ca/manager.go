@@ -43,6 +43,12 @@ func LoadOrCreateManager() (Manager, error) {
 	}
 
 	// Use legacy file-based CA for compatibility
+	return LoadOrCreateFileManager()
+}
+
+// LoadOrCreateFileManager loads or creates the file-based CA whatever the
+// security mode, for hosts without a keychain such as containers
+func LoadOrCreateFileManager() (Manager, error) {
 	legacyCA, err := LoadOrCreateLegacyCA()
 	if err != nil {
 		return nil, err
@@ -0,0 +1,100 @@
+// Package ca handles Certificate Authority operations for DNShield.
+package ca
+
+import (
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"fmt"
+	"path/filepath"
+	"regexp"
+)
+
+// profileNamePattern restricts profile names to safe directory names
+var profileNamePattern = regexp.MustCompile(`^[a-zA-Z0-9_-]{1,64}$`)
+
+// Profile describes a group-specific CA identity. Device groups that belong
+// to separate legal entities (e.g. contractors vs employees) each get their
+// own CA so trust can be granted and revoked independently.
+type Profile struct {
+	Name               string // Identifier used for the on-disk directory
+	Organization       string
+	OrganizationalUnit string
+	Country            string
+}
+
+// ProfileCAPath returns the directory holding the CA for the named profile
+func ProfileCAPath(name string) string {
+	return filepath.Join(GetCAPath(), "profiles", name)
+}
+
+// LoadOrCreateProfileCA loads the file-based CA for a profile, creating it
+// with the profile's subject if it does not exist yet.
+//
+// Profile CAs always use file storage; the Keychain-backed CA is only used
+// for the default device-wide CA.
+func LoadOrCreateProfileCA(p Profile) (Manager, error) {
+	if !profileNamePattern.MatchString(p.Name) {
+		return nil, fmt.Errorf("invalid CA profile name: %q", p.Name)
+	}
+
+	caPath := ProfileCAPath(p.Name)
+	certPath := filepath.Join(caPath, caCertFile)
+	keyPath := filepath.Join(caPath, caKeyFile)
+
+	if ca, err := loadCA(certPath, keyPath); err == nil {
+		return &LegacyCAAdapter{ca: ca}, nil
+	}
+
+	ca, err := createCAWithSubject(caPath, p.caSubject())
+	if err != nil {
+		return nil, fmt.Errorf("failed to create CA for profile %s: %v", p.Name, err)
+	}
+
+	return &LegacyCAAdapter{ca: ca}, nil
+}
+
+// ProfileCertificates returns the CA certificates of every profile created
+// on this machine
+func ProfileCertificates() []*x509.Certificate {
+	matches, _ := filepath.Glob(filepath.Join(GetCAPath(), "profiles", "*", caCertFile))
+
+	var certs []*x509.Certificate
+	for _, path := range matches {
+		if cert, err := readCertificate(path); err == nil {
+			certs = append(certs, cert)
+		}
+	}
+	return certs
+}
+
+// LeafSubject returns the subject for a leaf certificate issued under this
+// profile, carrying the profile's organization details
+func (p Profile) LeafSubject(domain string) pkix.Name {
+	subject := pkix.Name{CommonName: domain}
+	if p.Organization != "" {
+		subject.Organization = []string{p.Organization}
+	}
+	if p.OrganizationalUnit != "" {
+		subject.OrganizationalUnit = []string{p.OrganizationalUnit}
+	}
+	if p.Country != "" {
+		subject.Country = []string{p.Country}
+	}
+	return subject
+}
+
+// caSubject returns the subject for the profile's CA certificate
+func (p Profile) caSubject() pkix.Name {
+	subject := defaultCASubject()
+	subject.CommonName = "DNShield CA (" + p.Name + ")"
+	if p.Organization != "" {
+		subject.Organization = []string{p.Organization}
+	}
+	if p.OrganizationalUnit != "" {
+		subject.OrganizationalUnit = []string{p.OrganizationalUnit}
+	}
+	if p.Country != "" {
+		subject.Country = []string{p.Country}
+	}
+	return subject
+}
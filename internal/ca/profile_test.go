@@ -0,0 +1,45 @@
+package ca
+
+import "testing"
+
+func TestLoadOrCreateProfileCA(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	profile := Profile{Name: "contractors", Organization: "Contractor LLC", Country: "US"}
+	created, err := LoadOrCreateProfileCA(profile)
+	if err != nil {
+		t.Fatalf("LoadOrCreateProfileCA: %v", err)
+	}
+	cert := created.Certificate()
+	if cert.Subject.CommonName != "DNShield CA (contractors)" || len(cert.Subject.Organization) != 1 || cert.Subject.Organization[0] != "Contractor LLC" {
+		t.Errorf("profile CA subject = %s", cert.Subject)
+	}
+	if !cert.IsCA {
+		t.Error("profile CA certificate is not a CA")
+	}
+
+	// The same CA is loaded again rather than a new one created
+	loaded, err := LoadOrCreateProfileCA(profile)
+	if err != nil {
+		t.Fatalf("LoadOrCreateProfileCA again: %v", err)
+	}
+	if !loaded.Certificate().Equal(cert) {
+		t.Error("loading the profile again created a new CA")
+	}
+	if certs := ProfileCertificates(); len(certs) != 1 || !certs[0].Equal(cert) {
+		t.Errorf("ProfileCertificates() = %d certificates, want the profile's", len(certs))
+	}
+
+	for _, name := range []string{"", "../escape", "has space"} {
+		if _, err := LoadOrCreateProfileCA(Profile{Name: name}); err == nil {
+			t.Errorf("LoadOrCreateProfileCA accepted profile name %q", name)
+		}
+	}
+}
+
+func TestProfileLeafSubject(t *testing.T) {
+	subject := Profile{Name: "contractors", Organization: "Contractor LLC", OrganizationalUnit: "IT"}.LeafSubject("blocked.example.com")
+	if subject.CommonName != "blocked.example.com" || subject.Organization[0] != "Contractor LLC" || subject.OrganizationalUnit[0] != "IT" || len(subject.Country) != 0 {
+		t.Errorf("LeafSubject() = %+v", subject)
+	}
+}
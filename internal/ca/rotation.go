@@ -0,0 +1,157 @@
+package ca
+
+import (
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+)
+
+const (
+	rotationFile   = "rotation.json"
+	previousCADir  = "previous"
+	previousCACert = "ca.crt"
+)
+
+// Rotation records a CA rotation whose previous CA is still trusted. Until
+// GraceUntil both CAs are in the trust store, so certificates issued by
+// either are accepted while agents switch to the new one.
+type Rotation struct {
+	PreviousFingerprint string    `json:"previous_fingerprint"`
+	PreviousSubject     string    `json:"previous_subject"`
+	NewFingerprint      string    `json:"new_fingerprint"`
+	RotatedAt           time.Time `json:"rotated_at"`
+	GraceUntil          time.Time `json:"grace_until"`
+}
+
+// Due reports whether the grace window has passed and the previous CA can
+// be removed
+func (r *Rotation) Due(now time.Time) bool {
+	return !now.Before(r.GraceUntil)
+}
+
+// Fingerprint returns the hex SHA-256 of the certificate's DER encoding
+func Fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+// RotateCA replaces the file-based CA in caPath with a new one. The
+// previous certificate is kept in caPath/previous until the rotation
+// completes; its private key is deleted, since nothing is signed with it
+// again. A rotation already in progress must be completed first.
+func RotateCA(caPath string, grace time.Duration) (*CA, *Rotation, error) {
+	if grace < 0 {
+		return nil, nil, fmt.Errorf("grace period must not be negative")
+	}
+	pending, err := PendingRotation(caPath)
+	if err != nil {
+		return nil, nil, err
+	}
+	if pending != nil {
+		return nil, nil, fmt.Errorf("a rotation started %s is still in its grace window; complete it first",
+			pending.RotatedAt.Format(time.RFC3339))
+	}
+
+	certPath := filepath.Join(caPath, caCertFile)
+	keyPath := filepath.Join(caPath, caKeyFile)
+	previous, err := loadCA(certPath, keyPath)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to load current CA: %v", err)
+	}
+
+	prevDir := filepath.Join(caPath, previousCADir)
+	if err := os.MkdirAll(prevDir, 0700); err != nil {
+		return nil, nil, err
+	}
+	if err := os.Rename(certPath, filepath.Join(prevDir, previousCACert)); err != nil {
+		return nil, nil, fmt.Errorf("failed to keep previous CA certificate: %v", err)
+	}
+	if err := os.Remove(keyPath); err != nil {
+		return nil, nil, fmt.Errorf("failed to remove previous CA key: %v", err)
+	}
+
+	next, err := createCAWithSubject(caPath, previous.cert.Subject)
+	if err != nil {
+		return nil, nil, fmt.Errorf("failed to create new CA: %v", err)
+	}
+
+	now := time.Now()
+	rotation := &Rotation{
+		PreviousFingerprint: Fingerprint(previous.cert),
+		PreviousSubject:     previous.cert.Subject.String(),
+		NewFingerprint:      Fingerprint(next.cert),
+		RotatedAt:           now,
+		GraceUntil:          now.Add(grace),
+	}
+	data, err := json.MarshalIndent(rotation, "", "  ")
+	if err != nil {
+		return nil, nil, err
+	}
+	if err := os.WriteFile(filepath.Join(caPath, rotationFile), data, 0600); err != nil {
+		return nil, nil, fmt.Errorf("failed to record rotation: %v", err)
+	}
+
+	return next, rotation, nil
+}
+
+// PendingRotation returns the rotation in progress in caPath, or nil if
+// there is none
+func PendingRotation(caPath string) (*Rotation, error) {
+	data, err := os.ReadFile(filepath.Join(caPath, rotationFile))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	var rotation Rotation
+	if err := json.Unmarshal(data, &rotation); err != nil {
+		return nil, fmt.Errorf("invalid rotation state: %v", err)
+	}
+	return &rotation, nil
+}
+
+// PreviousCertificate returns the CA replaced by a pending rotation, or nil
+// if there is none
+func PreviousCertificate(caPath string) (*x509.Certificate, error) {
+	cert, err := readCertificate(filepath.Join(caPath, previousCADir, previousCACert))
+	if os.IsNotExist(err) {
+		return nil, nil
+	}
+	return cert, err
+}
+
+// TrustPreviousCA keeps the previous CA in the system trust store while the
+// new one is installed. Call it between RotateCA and InstallCA.
+func TrustPreviousCA(caPath string) error {
+	return retainTrustedCert(filepath.Join(caPath, previousCADir, previousCACert))
+}
+
+// CompleteRotation removes the previous CA from the system trust store and
+// forgets the rotation
+func CompleteRotation(caPath string) error {
+	prevPath := filepath.Join(caPath, previousCADir, previousCACert)
+	cert, err := PreviousCertificate(caPath)
+	if err != nil {
+		return fmt.Errorf("failed to read previous CA: %v", err)
+	}
+	if cert != nil {
+		if err := removeTrustedCert(prevPath, cert); err != nil {
+			return fmt.Errorf("failed to remove previous CA from trust store: %v", err)
+		}
+	}
+
+	if err := os.RemoveAll(filepath.Join(caPath, previousCADir)); err != nil {
+		return err
+	}
+	if err := os.Remove(filepath.Join(caPath, rotationFile)); err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return nil
+}
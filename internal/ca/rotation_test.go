@@ -0,0 +1,70 @@
+package ca
+
+import (
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestRotateCA(t *testing.T) {
+	dir := t.TempDir()
+	old, err := createCA(dir)
+	if err != nil {
+		t.Fatalf("createCA: %v", err)
+	}
+
+	next, rotation, err := RotateCA(dir, time.Hour)
+	if err != nil {
+		t.Fatalf("RotateCA: %v", err)
+	}
+	if next.cert.Equal(old.cert) {
+		t.Fatal("rotation kept the old CA")
+	}
+	if next.cert.SerialNumber.Cmp(old.cert.SerialNumber) == 0 {
+		t.Error("new CA shares the old CA's serial number")
+	}
+	if rotation.PreviousFingerprint != Fingerprint(old.cert) || rotation.NewFingerprint != Fingerprint(next.cert) {
+		t.Errorf("rotation fingerprints = %s -> %s", rotation.PreviousFingerprint, rotation.NewFingerprint)
+	}
+
+	loaded, err := loadCA(filepath.Join(dir, caCertFile), filepath.Join(dir, caKeyFile))
+	if err != nil {
+		t.Fatalf("loadCA after rotation: %v", err)
+	}
+	if !loaded.cert.Equal(next.cert) {
+		t.Error("ca.crt is not the new CA")
+	}
+
+	prev, err := PreviousCertificate(dir)
+	if err != nil || prev == nil || !prev.Equal(old.cert) {
+		t.Fatalf("PreviousCertificate = %v, %v; want the old CA", prev, err)
+	}
+	if _, err := os.Stat(filepath.Join(dir, previousCADir, caKeyFile)); !os.IsNotExist(err) {
+		t.Error("previous CA key was kept")
+	}
+
+	pending, err := PendingRotation(dir)
+	if err != nil || pending == nil {
+		t.Fatalf("PendingRotation = %v, %v", pending, err)
+	}
+	if pending.Due(time.Now()) || !pending.Due(time.Now().Add(2*time.Hour)) {
+		t.Errorf("Due does not follow the grace window ending %s", pending.GraceUntil)
+	}
+
+	if _, _, err := RotateCA(dir, time.Hour); err == nil {
+		t.Error("RotateCA succeeded with a rotation pending")
+	}
+}
+
+func TestPendingRotationNone(t *testing.T) {
+	dir := t.TempDir()
+	rotation, err := PendingRotation(dir)
+	if err != nil || rotation != nil {
+		t.Errorf("PendingRotation = %v, %v; want none", rotation, err)
+	}
+	cert, err := PreviousCertificate(dir)
+	if err != nil || cert != nil {
+		t.Errorf("PreviousCertificate = %v, %v; want none", cert, err)
+	}
+}
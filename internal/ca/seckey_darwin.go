@@ -0,0 +1,389 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package ca
+
+/*
+#cgo CFLAGS: -Wno-deprecated-declarations
+#cgo LDFLAGS: -framework Security -framework CoreFoundation
+#include <stdlib.h>
+#include <string.h>
+#include <Security/Security.h>
+
+static const char *systemKeychainPath = "/Library/Keychains/System.keychain";
+
+static CFMutableDictionaryRef newDict(void) {
+	return CFDictionaryCreateMutable(kCFAllocatorDefault, 0,
+		&kCFTypeDictionaryKeyCallBacks, &kCFTypeDictionaryValueCallBacks);
+}
+
+static CFDataRef newTag(const char *tag) {
+	return CFDataCreate(kCFAllocatorDefault, (const UInt8 *)tag, (CFIndex)strlen(tag));
+}
+
+static CFStringRef newString(const char *s) {
+	return CFStringCreateWithCString(kCFAllocatorDefault, s, kCFStringEncodingUTF8);
+}
+
+// createKey generates a permanent, non-extractable EC private key tagged
+// tag: P-256 in the Secure Enclave when enclave is set, otherwise P-384 in
+// the System keychain
+static SecKeyRef createKey(const char *tag, const char *label, int enclave, CFErrorRef *error) {
+	CFMutableDictionaryRef attrs = newDict();
+	CFMutableDictionaryRef privateAttrs = newDict();
+	CFDataRef tagData = newTag(tag);
+	CFStringRef labelRef = newString(label);
+	SecAccessControlRef access = NULL;
+	SecKeychainRef keychain = NULL;
+	SecKeyRef key = NULL;
+	int bits = enclave ? 256 : 384;
+	CFNumberRef bitsRef = CFNumberCreate(kCFAllocatorDefault, kCFNumberIntType, &bits);
+
+	CFDictionarySetValue(privateAttrs, kSecAttrIsPermanent, kCFBooleanTrue);
+	CFDictionarySetValue(privateAttrs, kSecAttrIsExtractable, kCFBooleanFalse);
+	CFDictionarySetValue(privateAttrs, kSecAttrApplicationTag, tagData);
+	CFDictionarySetValue(privateAttrs, kSecAttrLabel, labelRef);
+	CFDictionarySetValue(attrs, kSecAttrKeyType, kSecAttrKeyTypeECSECPrimeRandom);
+	CFDictionarySetValue(attrs, kSecAttrKeySizeInBits, bitsRef);
+
+	if (enclave) {
+		access = SecAccessControlCreateWithFlags(kCFAllocatorDefault,
+			kSecAttrAccessibleAfterFirstUnlockThisDeviceOnly, kSecAccessControlPrivateKeyUsage, error);
+		if (access != NULL) {
+			CFDictionarySetValue(privateAttrs, kSecAttrAccessControl, access);
+			CFDictionarySetValue(attrs, kSecAttrTokenID, kSecAttrTokenIDSecureEnclave);
+			CFDictionarySetValue(attrs, kSecUseDataProtectionKeychain, kCFBooleanTrue);
+		}
+	} else if (SecKeychainOpen(systemKeychainPath, &keychain) == errSecSuccess) {
+		CFDictionarySetValue(attrs, kSecUseKeychain, keychain);
+	}
+
+	if (!enclave || access != NULL) {
+		CFDictionarySetValue(attrs, kSecPrivateKeyAttrs, privateAttrs);
+		key = SecKeyCreateRandomKey(attrs, error);
+	}
+
+	if (access != NULL) CFRelease(access);
+	if (keychain != NULL) CFRelease(keychain);
+	CFRelease(bitsRef);
+	CFRelease(labelRef);
+	CFRelease(tagData);
+	CFRelease(privateAttrs);
+	CFRelease(attrs);
+	return key;
+}
+
+// findKey returns the private key tagged tag, looking in the data
+// protection keychain (which holds Secure Enclave keys) when dataProtection
+// is set and in the file-based keychains otherwise
+static SecKeyRef findKey(const char *tag, int dataProtection, OSStatus *status) {
+	CFMutableDictionaryRef query = newDict();
+	CFDataRef tagData = newTag(tag);
+	CFTypeRef result = NULL;
+
+	CFDictionarySetValue(query, kSecClass, kSecClassKey);
+	CFDictionarySetValue(query, kSecAttrKeyClass, kSecAttrKeyClassPrivate);
+	CFDictionarySetValue(query, kSecAttrApplicationTag, tagData);
+	CFDictionarySetValue(query, kSecReturnRef, kCFBooleanTrue);
+	if (dataProtection) {
+		CFDictionarySetValue(query, kSecUseDataProtectionKeychain, kCFBooleanTrue);
+	}
+	*status = SecItemCopyMatching(query, &result);
+
+	CFRelease(tagData);
+	CFRelease(query);
+	return (SecKeyRef)result;
+}
+
+// deleteKeys removes the private keys tagged tag from both keychains
+static void deleteKeys(const char *tag) {
+	for (int dataProtection = 0; dataProtection <= 1; dataProtection++) {
+		CFMutableDictionaryRef query = newDict();
+		CFDataRef tagData = newTag(tag);
+		CFDictionarySetValue(query, kSecClass, kSecClassKey);
+		CFDictionarySetValue(query, kSecAttrApplicationTag, tagData);
+		if (dataProtection) {
+			CFDictionarySetValue(query, kSecUseDataProtectionKeychain, kCFBooleanTrue);
+		}
+		SecItemDelete(query);
+		CFRelease(tagData);
+		CFRelease(query);
+	}
+}
+
+// genericPasswordQuery matches a generic password in the System keychain
+static CFMutableDictionaryRef genericPasswordQuery(const char *service, const char *account) {
+	CFMutableDictionaryRef query = newDict();
+	CFStringRef serviceRef = newString(service);
+	CFStringRef accountRef = newString(account);
+	SecKeychainRef keychain = NULL;
+
+	CFDictionarySetValue(query, kSecClass, kSecClassGenericPassword);
+	CFDictionarySetValue(query, kSecAttrService, serviceRef);
+	CFDictionarySetValue(query, kSecAttrAccount, accountRef);
+	if (SecKeychainOpen(systemKeychainPath, &keychain) == errSecSuccess) {
+		CFArrayRef searchList = CFArrayCreate(kCFAllocatorDefault, (const void **)&keychain, 1, &kCFTypeArrayCallBacks);
+		CFDictionarySetValue(query, kSecMatchSearchList, searchList);
+		CFRelease(searchList);
+		CFRelease(keychain);
+	}
+
+	CFRelease(accountRef);
+	CFRelease(serviceRef);
+	return query;
+}
+
+// copyGenericPassword returns the data of a generic password
+static CFDataRef copyGenericPassword(const char *service, const char *account, OSStatus *status) {
+	CFMutableDictionaryRef query = genericPasswordQuery(service, account);
+	CFTypeRef result = NULL;
+
+	CFDictionarySetValue(query, kSecReturnData, kCFBooleanTrue);
+	*status = SecItemCopyMatching(query, &result);
+
+	CFRelease(query);
+	return (CFDataRef)result;
+}
+
+static void deleteGenericPassword(const char *service, const char *account) {
+	CFMutableDictionaryRef query = genericPasswordQuery(service, account);
+	SecItemDelete(query);
+	CFRelease(query);
+}
+
+// copyPublicKeyData returns the public half of key in ANSI X9.63 form
+static CFDataRef copyPublicKeyData(SecKeyRef key, CFErrorRef *error) {
+	SecKeyRef pub = SecKeyCopyPublicKey(key);
+	if (pub == NULL) {
+		return NULL;
+	}
+	CFDataRef data = SecKeyCopyExternalRepresentation(pub, error);
+	CFRelease(pub);
+	return data;
+}
+
+// signDigest signs a digest of hashBits with key, returning an X9.62
+// (ASN.1 DER) ECDSA signature
+static CFDataRef signDigest(SecKeyRef key, int hashBits, const UInt8 *digest, CFIndex length, CFErrorRef *error) {
+	SecKeyAlgorithm algorithm = kSecKeyAlgorithmECDSASignatureDigestX962SHA512;
+	if (hashBits == 256) {
+		algorithm = kSecKeyAlgorithmECDSASignatureDigestX962SHA256;
+	} else if (hashBits == 384) {
+		algorithm = kSecKeyAlgorithmECDSASignatureDigestX962SHA384;
+	}
+	CFDataRef data = CFDataCreate(kCFAllocatorDefault, digest, length);
+	CFDataRef signature = SecKeyCreateSignature(key, algorithm, data, error);
+	CFRelease(data);
+	return signature;
+}
+
+// errorDescription copies the description of err into buf and releases err
+static void errorDescription(CFErrorRef err, char *buf, CFIndex size) {
+	buf[0] = 0;
+	if (err == NULL) {
+		return;
+	}
+	CFStringRef desc = CFErrorCopyDescription(err);
+	if (desc != NULL) {
+		if (!CFStringGetCString(desc, buf, size, kCFStringEncodingUTF8)) {
+			buf[0] = 0;
+		}
+		CFRelease(desc);
+	}
+	CFRelease(err);
+}
+*/
+import "C"
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"fmt"
+	"io"
+	"runtime"
+	"unsafe"
+)
+
+// keychainKey is a CA private key held by Security.framework. The process
+// only has a reference to it: the key is created non-extractable and every
+// signature is made by the Secure Enclave or the keychain. The reference is
+// released when the keychainKey is garbage collected.
+type keychainKey struct {
+	ref     C.SecKeyRef
+	pub     *ecdsa.PublicKey
+	enclave bool
+}
+
+var _ crypto.Signer = (*keychainKey)(nil)
+
+// createKeychainKey generates a new key tagged tag, in the Secure Enclave
+// when enclave is set
+func createKeychainKey(tag, label string, enclave bool) (*keychainKey, error) {
+	cTag, cLabel := C.CString(tag), C.CString(label)
+	defer C.free(unsafe.Pointer(cTag))
+	defer C.free(unsafe.Pointer(cLabel))
+
+	var flag C.int
+	if enclave {
+		flag = 1
+	}
+	var cfErr C.CFErrorRef
+	ref := C.createKey(cTag, cLabel, flag, &cfErr)
+	if ref == 0 {
+		return nil, fmt.Errorf("SecKeyCreateRandomKey: %s", cfErrorString(cfErr))
+	}
+	return newKeychainKey(ref, enclave)
+}
+
+// findKeychainKey returns the key tagged tag, preferring a Secure Enclave
+// key. It returns nil if there is none.
+func findKeychainKey(tag string) (*keychainKey, error) {
+	cTag := C.CString(tag)
+	defer C.free(unsafe.Pointer(cTag))
+
+	for _, enclave := range []bool{true, false} {
+		var flag C.int
+		if enclave {
+			flag = 1
+		}
+		var status C.OSStatus
+		ref := C.findKey(cTag, flag, &status)
+		if status == C.errSecSuccess && ref != 0 {
+			return newKeychainKey(ref, enclave)
+		}
+		if status != C.errSecItemNotFound && status != C.errSecSuccess {
+			return nil, fmt.Errorf("SecItemCopyMatching: OSStatus %d", int(status))
+		}
+	}
+	return nil, nil
+}
+
+// deleteKeychainKeys removes the keys tagged tag
+func deleteKeychainKeys(tag string) {
+	cTag := C.CString(tag)
+	defer C.free(unsafe.Pointer(cTag))
+	C.deleteKeys(cTag)
+}
+
+// genericPassword returns a generic password from the System keychain, or
+// nil if there is none
+func genericPassword(service, account string) ([]byte, error) {
+	cService, cAccount := C.CString(service), C.CString(account)
+	defer C.free(unsafe.Pointer(cService))
+	defer C.free(unsafe.Pointer(cAccount))
+
+	var status C.OSStatus
+	data := C.copyGenericPassword(cService, cAccount, &status)
+	if status == C.errSecItemNotFound {
+		return nil, nil
+	}
+	if status != C.errSecSuccess || data == 0 {
+		return nil, fmt.Errorf("SecItemCopyMatching: OSStatus %d", int(status))
+	}
+	defer C.CFRelease(C.CFTypeRef(data))
+	return cfDataBytes(data), nil
+}
+
+// deleteGenericPassword removes a generic password from the System keychain
+func deleteGenericPassword(service, account string) {
+	cService, cAccount := C.CString(service), C.CString(account)
+	defer C.free(unsafe.Pointer(cService))
+	defer C.free(unsafe.Pointer(cAccount))
+	C.deleteGenericPassword(cService, cAccount)
+}
+
+func newKeychainKey(ref C.SecKeyRef, enclave bool) (*keychainKey, error) {
+	var cfErr C.CFErrorRef
+	data := C.copyPublicKeyData(ref, &cfErr)
+	if data == 0 {
+		C.CFRelease(C.CFTypeRef(ref))
+		return nil, fmt.Errorf("SecKeyCopyExternalRepresentation: %s", cfErrorString(cfErr))
+	}
+	defer C.CFRelease(C.CFTypeRef(data))
+
+	point := cfDataBytes(data)
+	var curve elliptic.Curve
+	switch len(point) {
+	case 65:
+		curve = elliptic.P256()
+	case 97:
+		curve = elliptic.P384()
+	default:
+		C.CFRelease(C.CFTypeRef(ref))
+		return nil, fmt.Errorf("unsupported public key of %d bytes", len(point))
+	}
+	x, y := elliptic.Unmarshal(curve, point)
+	if x == nil {
+		C.CFRelease(C.CFTypeRef(ref))
+		return nil, fmt.Errorf("invalid public key")
+	}
+
+	key := &keychainKey{
+		ref:     ref,
+		pub:     &ecdsa.PublicKey{Curve: curve, X: x, Y: y},
+		enclave: enclave,
+	}
+	runtime.SetFinalizer(key, (*keychainKey).release)
+	return key, nil
+}
+
+// release drops the process's reference to the key; the key itself stays
+// in the keychain
+func (k *keychainKey) release() {
+	C.CFRelease(C.CFTypeRef(k.ref))
+}
+
+// Public returns the public key
+func (k *keychainKey) Public() crypto.PublicKey {
+	return k.pub
+}
+
+// Sign signs digest with SecKeyCreateSignature. rand is unused; the
+// Secure Enclave or keychain supplies its own randomness.
+func (k *keychainKey) Sign(_ io.Reader, digest []byte, opts crypto.SignerOpts) ([]byte, error) {
+	var bits C.int
+	switch opts.HashFunc() {
+	case crypto.SHA256:
+		bits = 256
+	case crypto.SHA384:
+		bits = 384
+	case crypto.SHA512:
+		bits = 512
+	default:
+		return nil, fmt.Errorf("unsupported hash %v", opts.HashFunc())
+	}
+	if len(digest) != opts.HashFunc().Size() {
+		return nil, fmt.Errorf("digest is %d bytes, want %d", len(digest), opts.HashFunc().Size())
+	}
+
+	var cfErr C.CFErrorRef
+	signature := C.signDigest(k.ref, bits, (*C.UInt8)(unsafe.Pointer(&digest[0])), C.CFIndex(len(digest)), &cfErr)
+	// k must not be finalized while Security.framework is using its ref
+	runtime.KeepAlive(k)
+	if signature == 0 {
+		return nil, fmt.Errorf("SecKeyCreateSignature: %s", cfErrorString(cfErr))
+	}
+	defer C.CFRelease(C.CFTypeRef(signature))
+	return cfDataBytes(signature), nil
+}
+
+func cfDataBytes(data C.CFDataRef) []byte {
+	return C.GoBytes(unsafe.Pointer(C.CFDataGetBytePtr(data)), C.int(C.CFDataGetLength(data)))
+}
+
+// cfErrorString describes and releases err
+func cfErrorString(err C.CFErrorRef) string {
+	buf := make([]byte, 512)
+	C.errorDescription(err, (*C.char)(unsafe.Pointer(&buf[0])), C.CFIndex(len(buf)))
+	for i, b := range buf {
+		if b == 0 {
+			buf = buf[:i]
+			break
+		}
+	}
+	if len(buf) == 0 {
+		return "unknown error"
+	}
+	return string(buf)
+}
@@ -0,0 +1,71 @@
+//go:build darwin && cgo
+// +build darwin,cgo
+
+package ca
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/sha256"
+	"runtime"
+	"testing"
+)
+
+const testKeyTag = "com.dnshield.ca.test-key"
+
+// newTestKeychainKey creates a key in the System keychain, which needs
+// root, and removes it when the test ends
+func newTestKeychainKey(t *testing.T) *keychainKey {
+	t.Helper()
+	key, err := createKeychainKey(testKeyTag, "DNShield-CA-Test-Key", false)
+	if err != nil {
+		t.Skipf("cannot create a System keychain key: %v", err)
+	}
+	t.Cleanup(func() { deleteKeychainKeys(testKeyTag) })
+	return key
+}
+
+func TestKeychainKeySign(t *testing.T) {
+	key := newTestKeychainKey(t)
+
+	digest := sha256.Sum256([]byte("certificate"))
+	signature, err := key.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil {
+		t.Fatalf("Sign: %v", err)
+	}
+	if !ecdsa.VerifyASN1(key.Public().(*ecdsa.PublicKey), digest[:], signature) {
+		t.Error("signature doesn't verify with the public key")
+	}
+	if _, err := key.Sign(nil, digest[:4], crypto.SHA256); err == nil {
+		t.Error("signed a truncated digest")
+	}
+}
+
+func TestKeychainKeyRelease(t *testing.T) {
+	key := newTestKeychainKey(t)
+	want := key.Public().(*ecdsa.PublicKey)
+
+	// Each lookup takes a reference that is released once the key is
+	// collected; a key found again must still sign
+	for i := 0; i < 100; i++ {
+		found, err := findKeychainKey(testKeyTag)
+		if err != nil || found == nil {
+			t.Fatalf("findKeychainKey = %v, %v", found, err)
+		}
+		if !found.Public().(*ecdsa.PublicKey).Equal(want) {
+			t.Fatal("found a different key")
+		}
+	}
+	runtime.GC()
+
+	found, err := findKeychainKey(testKeyTag)
+	if err != nil || found == nil {
+		t.Fatalf("findKeychainKey = %v, %v", found, err)
+	}
+	runtime.GC()
+	digest := sha256.Sum256([]byte("after collection"))
+	signature, err := found.Sign(nil, digest[:], crypto.SHA256)
+	if err != nil || !ecdsa.VerifyASN1(want, digest[:], signature) {
+		t.Errorf("Sign after collecting other references: %v", err)
+	}
+}
@@ -0,0 +1,48 @@
+//go:build darwin
+// +build darwin
+
+package ca
+
+import (
+	"crypto/sha1"
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/exec"
+)
+
+// installTrustedCert adds the certificate at certPath to the System
+// keychain as a trusted root
+func installTrustedCert(certPath string) error {
+	// On macOS, use security command with Touch ID
+	// The -p option allows Touch ID authentication
+	cmd := exec.Command("sudo", "-p", "Touch ID or enter password: ", "security", "add-trusted-cert", "-d", "-r", "trustRoot", "-k", "/Library/Keychains/System.keychain", certPath)
+
+	// Set up for interactive authentication
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
+
+// retainTrustedCert is a no-op on macOS: the System keychain keeps the
+// previous CA when the new one is added
+func retainTrustedCert(certPath string) error {
+	return nil
+}
+
+// removeTrustedCert deletes cert from the System keychain by its SHA-1
+// hash, so a CA sharing its subject is left alone
+func removeTrustedCert(certPath string, cert *x509.Certificate) error {
+	args := []string{"security", "delete-certificate", "-Z", fmt.Sprintf("%X", sha1.Sum(cert.Raw)), "/Library/Keychains/System.keychain"}
+	if os.Geteuid() != 0 {
+		args = append([]string{"sudo", "-p", "Touch ID or enter password: "}, args...)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin = os.Stdin
+	cmd.Stdout = os.Stdout
+	cmd.Stderr = os.Stderr
+
+	return cmd.Run()
+}
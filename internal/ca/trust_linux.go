@@ -0,0 +1,91 @@
+//go:build linux
+// +build linux
+
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+)
+
+// linuxTrustStores are the anchor directories and update commands of the
+// Debian and Red Hat families
+var linuxTrustStores = []struct {
+	dir    string
+	update string
+}{
+	{"/usr/local/share/ca-certificates", "update-ca-certificates"},
+	{"/etc/pki/ca-trust/source/anchors", "update-ca-trust"},
+}
+
+const (
+	anchorFile         = "dnshield-ca.crt"
+	previousAnchorFile = "dnshield-ca-previous.crt"
+)
+
+// installTrustedCert copies the certificate at certPath into the system CA
+// bundle and rebuilds it
+func installTrustedCert(certPath string) error {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return err
+	}
+
+	for _, store := range linuxTrustStores {
+		if _, err := exec.LookPath(store.update); err != nil {
+			continue
+		}
+		if err := os.MkdirAll(store.dir, 0755); err != nil {
+			return err
+		}
+		if err := os.WriteFile(filepath.Join(store.dir, anchorFile), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s (run as root): %v", store.dir, err)
+		}
+		cmd := exec.Command(store.update)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	return fmt.Errorf("no supported CA bundle found (need update-ca-certificates or update-ca-trust)")
+}
+
+// retainTrustedCert copies the certificate at certPath to a second anchor
+// file, so installing the new CA doesn't drop it from the bundle
+func retainTrustedCert(certPath string) error {
+	data, err := os.ReadFile(certPath)
+	if err != nil {
+		return err
+	}
+
+	for _, store := range linuxTrustStores {
+		if _, err := exec.LookPath(store.update); err != nil {
+			continue
+		}
+		if err := os.WriteFile(filepath.Join(store.dir, previousAnchorFile), data, 0644); err != nil {
+			return fmt.Errorf("failed to write %s (run as root): %v", store.dir, err)
+		}
+		return nil
+	}
+	return fmt.Errorf("no supported CA bundle found (need update-ca-certificates or update-ca-trust)")
+}
+
+// removeTrustedCert deletes the anchor written by retainTrustedCert and
+// rebuilds the bundle
+func removeTrustedCert(certPath string, cert *x509.Certificate) error {
+	for _, store := range linuxTrustStores {
+		if _, err := exec.LookPath(store.update); err != nil {
+			continue
+		}
+		if err := os.Remove(filepath.Join(store.dir, previousAnchorFile)); err != nil && !os.IsNotExist(err) {
+			return fmt.Errorf("failed to remove previous CA from %s (run as root): %v", store.dir, err)
+		}
+		cmd := exec.Command(store.update)
+		cmd.Stdout = os.Stdout
+		cmd.Stderr = os.Stderr
+		return cmd.Run()
+	}
+	return fmt.Errorf("no supported CA bundle found (need update-ca-certificates or update-ca-trust)")
+}
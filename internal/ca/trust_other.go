@@ -0,0 +1,24 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package ca
+
+import (
+	"crypto/x509"
+	"fmt"
+)
+
+// installTrustedCert is not supported on this platform
+func installTrustedCert(certPath string) error {
+	return fmt.Errorf("CA installation is only supported on macOS and Linux")
+}
+
+// retainTrustedCert is not supported on this platform
+func retainTrustedCert(certPath string) error {
+	return fmt.Errorf("CA installation is only supported on macOS and Linux")
+}
+
+// removeTrustedCert is not supported on this platform
+func removeTrustedCert(certPath string, cert *x509.Certificate) error {
+	return fmt.Errorf("CA installation is only supported on macOS and Linux")
+}
@@ -0,0 +1,237 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"os"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severities of config issues
+const (
+	IssueError   = "error"
+	IssueWarning = "warning"
+)
+
+// Issue is a problem CheckConfig found in a config file
+type Issue struct {
+	// Setting it concerns, such as dns.upstreams; empty when it isn't
+	// about one
+	Path string
+	// Where in the file, from 1; zero when the setting isn't in the file
+	Line     int
+	Column   int
+	Severity string
+	Message  string
+}
+
+func (i Issue) String() string {
+	if i.Line == 0 {
+		return i.Message
+	}
+	return fmt.Sprintf("line %d:%d: %s", i.Line, i.Column, i.Message)
+}
+
+// CheckOptions describes the machine a config is meant for
+type CheckOptions struct {
+	// Kiosk warns about settings that let whoever uses an unattended,
+	// shared machine turn filtering off
+	Kiosk bool
+}
+
+var (
+	// yamlLine finds the line yaml.v3 puts in its error messages
+	yamlLine = regexp.MustCompile(`^(?:yaml: )?line (\d+): `)
+	// settingPath finds the setting a ValidateConfig error names
+	settingPath = regexp.MustCompile(`\b[a-z][A-Za-z0-9]*(?:\.[a-z][A-Za-z0-9]*(?:\[\d+\])?)+`)
+)
+
+// CheckConfig checks the config file at path without running anything:
+// that it parses with only known keys and values of the right types, that
+// the agent would accept it, and that it keeps no secrets or other
+// insecure settings. Issues are placed at their line in the file where
+// possible, errors first.
+func CheckConfig(path string, opts CheckOptions) ([]Issue, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	var root yaml.Node
+	if err := yaml.Unmarshal(data, &root); err != nil {
+		return []Issue{yamlIssue(err.Error())}, nil
+	}
+
+	var issues []Issue
+	dec := yaml.NewDecoder(bytes.NewReader(data))
+	dec.KnownFields(true)
+	if err := dec.Decode(&Config{}); err != nil {
+		var typeErr *yaml.TypeError
+		if !errors.As(err, &typeErr) {
+			return []Issue{yamlIssue(err.Error())}, nil
+		}
+		for _, msg := range typeErr.Errors {
+			issues = append(issues, yamlIssue(msg))
+		}
+		return issues, nil
+	}
+
+	cfg, err := LoadConfig(path)
+	if err != nil {
+		return []Issue{{Severity: IssueError, Message: err.Error()}}, nil
+	}
+	if err := ValidateConfig(cfg); err != nil {
+		issue := Issue{Severity: IssueError, Message: err.Error()}
+		if setting := settingPath.FindString(issue.Message); isSetting(setting) {
+			issue.Path = setting
+		}
+		issues = append(issues, issue)
+	}
+
+	issues = append(issues, inlineSecrets(cfg)...)
+	if cfg.Agent.LogLevel == "debug" {
+		issues = append(issues, warning("agent.logLevel", "debug logging can write sensitive data to the logs"))
+	}
+	if cfg.API.TCP.Enabled {
+		issues = append(issues, warning("api.tcp.enabled", "the TCP API listener can be reached by any local process; prefer the API socket"))
+	}
+	if opts.Kiosk {
+		issues = append(issues, kioskIssues(cfg)...)
+	}
+
+	for i := range issues {
+		issues[i].Line, issues[i].Column = locate(&root, issues[i].Path)
+	}
+	sort.SliceStable(issues, func(i, j int) bool {
+		return issues[i].Severity == IssueError && issues[j].Severity != IssueError
+	})
+	return issues, nil
+}
+
+// inlineSecrets reports credentials kept in the config file rather than
+// in the environment or a role
+func inlineSecrets(cfg *Config) []Issue {
+	var issues []Issue
+	if inFile("s3.accessKeyId", cfg.S3.AccessKeyID) {
+		issues = append(issues, warning("s3.accessKeyId", "AWS credentials found in configuration file - consider using environment variables or IAM roles"))
+	} else if inFile("s3.secretKey", cfg.S3.SecretKey) {
+		issues = append(issues, warning("s3.secretKey", "AWS credentials found in configuration file - consider using environment variables or IAM roles"))
+	}
+	if cfg.Logging.Splunk.Enabled && inFile("logging.splunk.token", cfg.Logging.Splunk.Token) {
+		issues = append(issues, warning("logging.splunk.token", "Splunk HEC token found in configuration file - consider using environment variables"))
+	}
+	if cfg.Incident.Enabled && inFile("incident.token", cfg.Incident.Token) {
+		issues = append(issues, warning("incident.token", "Incident ticketing token found in configuration file - consider using DNSHIELD_INCIDENT_TOKEN"))
+	}
+	if cfg.DomainBypass.Enabled && inFile("domainBypass.totpSecret", cfg.DomainBypass.TOTPSecret) {
+		issues = append(issues, warning("domainBypass.totpSecret", "Bypass TOTP secret found in configuration file - consider using DNSHIELD_BYPASS_TOTP_SECRET"))
+	}
+	if cfg.ThreatIntel.Enabled {
+		for i, feed := range cfg.ThreatIntel.Feeds {
+			if feed.Token != "" {
+				issues = append(issues, warning(fmt.Sprintf("threatIntel.feeds[%d].token", i),
+					fmt.Sprintf("Threat-intel feed %s token found in configuration file - consider using tokenEnv", feed.Name)))
+			}
+		}
+	}
+	return issues
+}
+
+// kioskIssues reports settings that let the user of a kiosk stop or get
+// around filtering
+func kioskIssues(cfg *Config) []Issue {
+	var issues []Issue
+	if cfg.Agent.AllowDisable {
+		issues = append(issues, warning("agent.allowDisable", "protection can be paused from the menu bar and API; set allowDisable: false on kiosks"))
+	}
+	if cfg.CaptivePortal.AllowManualBypass {
+		issues = append(issues, warning("captivePortal.allowManualBypass", "anyone at the kiosk can turn filtering off for captive portal logins"))
+	}
+	if cfg.DomainBypass.Enabled && !cfg.DomainBypass.RequireCode {
+		issues = append(issues, warning("domainBypass.enabled", "blocked domains can be bypassed without an admin-issued code"))
+	}
+	if cfg.API.Socket.Enabled && cfg.API.Socket.AllowConsoleUser && cfg.API.Socket.Role != "" && cfg.API.Socket.Role != "viewer" {
+		issues = append(issues, warning("api.socket.allowConsoleUser", fmt.Sprintf("the console user gets the %s role on the API socket without a key", cfg.API.Socket.Role)))
+	}
+	return issues
+}
+
+// inFile reports whether a secret setting has a value that didn't come
+// from its environment variable
+func inFile(path, value string) bool {
+	return value != "" && os.Getenv(EnvName(path)) != value
+}
+
+func warning(path, message string) Issue {
+	return Issue{Path: path, Severity: IssueWarning, Message: message}
+}
+
+// yamlIssue turns a yaml.v3 error message into an error issue at its line
+func yamlIssue(msg string) Issue {
+	issue := Issue{Severity: IssueError, Message: msg}
+	if m := yamlLine.FindStringSubmatch(msg); m != nil {
+		issue.Line, _ = strconv.Atoi(m[1])
+		issue.Message = msg[len(m[0]):]
+	}
+	return issue
+}
+
+// isSetting reports whether path starts with a top-level config key, so
+// a domain in an error message isn't taken for one
+func isSetting(path string) bool {
+	top, _, _ := strings.Cut(path, ".")
+	t := reflect.TypeOf(Config{})
+	for i := 0; i < t.NumField(); i++ {
+		name, _, _ := strings.Cut(t.Field(i).Tag.Get("yaml"), ",")
+		if name == top {
+			return true
+		}
+	}
+	return false
+}
+
+// locate returns the line and column of the setting at path in the parsed
+// file, or of the closest enclosing setting present when it isn't there
+func locate(root *yaml.Node, path string) (line, column int) {
+	if path == "" || len(root.Content) == 0 {
+		return 0, 0
+	}
+	node := root.Content[0]
+	for _, part := range strings.Split(path, ".") {
+		key, index := part, -1
+		if i := strings.IndexByte(part, '['); i >= 0 {
+			key = part[:i]
+			index, _ = strconv.Atoi(strings.TrimSuffix(part[i+1:], "]"))
+		}
+
+		var value *yaml.Node
+		if node.Kind == yaml.MappingNode {
+			for i := 0; i+1 < len(node.Content); i += 2 {
+				if node.Content[i].Value == key {
+					line, column = node.Content[i].Line, node.Content[i].Column
+					value = node.Content[i+1]
+					break
+				}
+			}
+		}
+		if value == nil {
+			return line, column
+		}
+		if index >= 0 {
+			if value.Kind != yaml.SequenceNode || index >= len(value.Content) {
+				return line, column
+			}
+			value = value.Content[index]
+			line, column = value.Line, value.Column
+		}
+		node = value
+	}
+	return line, column
+}
@@ -0,0 +1,95 @@
+package config
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func writeConfig(t *testing.T, data string) string {
+	t.Helper()
+	path := filepath.Join(t.TempDir(), "config.yaml")
+	if err := os.WriteFile(path, []byte(data), 0600); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestCheckConfig(t *testing.T) {
+	tests := []struct {
+		name   string
+		config string
+		opts   CheckOptions
+		want   []Issue // Messages are matched by prefix
+	}{
+		{
+			name: "valid",
+			config: `agent:
+  allowDisable: false
+dns:
+  upstreams: ["1.1.1.1"]
+`,
+		},
+		{
+			name:   "syntax error",
+			config: "dns:\n  upstreams:\n\t- 1.1.1.1\n",
+			want:   []Issue{{Line: 3, Severity: IssueError, Message: "found character that cannot start any token"}},
+		},
+		{
+			name: "unknown key and wrong type",
+			config: `dns:
+  upstream: ["1.1.1.1"]
+  cacheSize: lots
+`,
+			want: []Issue{
+				{Line: 2, Severity: IssueError, Message: "field upstream not found"},
+				{Line: 3, Severity: IssueError, Message: "cannot unmarshal !!str `lots` into int"},
+			},
+		},
+		{
+			name: "invalid value and insecure settings",
+			config: `agent:
+  allowDisable: true
+dns:
+  upstreams: ["1.1.1.1"]
+  strategy: fastest
+s3:
+  accessKeyId: AKIAEXAMPLE
+threatIntel:
+  enabled: true
+  feeds:
+    - name: misp
+      url: https://misp.example.com
+      token: secret
+`,
+			opts: CheckOptions{Kiosk: true},
+			want: []Issue{
+				{Path: "dns.strategy", Line: 5, Column: 3, Severity: IssueError, Message: "invalid dns.strategy"},
+				{Path: "s3.accessKeyId", Line: 7, Column: 3, Severity: IssueWarning, Message: "AWS credentials"},
+				{Path: "threatIntel.feeds[0].token", Line: 13, Column: 7, Severity: IssueWarning, Message: "Threat-intel feed misp token"},
+				{Path: "agent.allowDisable", Line: 2, Column: 3, Severity: IssueWarning, Message: "protection can be paused"},
+				{Path: "api.socket.allowConsoleUser", Severity: IssueWarning, Message: "the console user gets the operator role"},
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			issues, err := CheckConfig(writeConfig(t, tt.config), tt.opts)
+			if err != nil {
+				t.Fatal(err)
+			}
+			if len(issues) != len(tt.want) {
+				t.Fatalf("got %d issues %v, want %d", len(issues), issues, len(tt.want))
+			}
+			for i, want := range tt.want {
+				got := issues[i]
+				if got.Path != want.Path || got.Line != want.Line || (want.Column != 0 && got.Column != want.Column) ||
+					got.Severity != want.Severity || !strings.HasPrefix(got.Message, want.Message) {
+					t.Errorf("issue %d = %+v, want %+v", i, got, want)
+				}
+			}
+		})
+	}
+}
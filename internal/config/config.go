@@ -1,16 +1,18 @@
 // Package config defines configuration structures and loading logic for DNShield.
 // It supports YAML configuration files with validation and sensible defaults.
-// Configuration can be loaded from files or environment variables, with support
-// for hot reloading in future versions.
+// Configuration can be loaded from files or environment variables, and
+// Changes reports what differs between two of them for hot reloading.
 package config
 
 import (
 	"fmt"
+	"net/url"
 	"os"
 	"path/filepath"
 	"strings"
 	"time"
 
+	"dnshield/internal/utils"
 	"gopkg.in/yaml.v3"
 )
 
@@ -19,11 +21,48 @@ type Config struct {
 	S3            S3Config            `yaml:"s3"`
 	DNS           DNSConfig           `yaml:"dns"`
 	Blocking      BlockingConfig      `yaml:"blocking"`
+	Rules         RulesConfig         `yaml:"rules"`
 	CaptivePortal CaptivePortalConfig `yaml:"captivePortal"`
 	Logging       LoggingConfig       `yaml:"logging"`
+	API           APIConfig           `yaml:"api"`
+	Mirror        MirrorConfig        `yaml:"mirror"`
+	TrustStore    TrustStoreConfig    `yaml:"trustStore"`
+	Incident      IncidentConfig      `yaml:"incident"`
+	// Flow verdicts for the transparent proxy system extension
+	TransparentProxy TransparentProxyConfig `yaml:"transparentProxy"`
+	// Signed organization policy that overrides local settings
+	ManagedPolicy ManagedPolicyConfig `yaml:"managedPolicy"`
+	// Local database of answered queries
+	QueryLog QueryLogConfig `yaml:"queryLog"`
+	// Domain indicators pulled from STIX/TAXII and MISP feeds
+	ThreatIntel ThreatIntelConfig `yaml:"threatIntel"`
+	// Blocking of newly registered domains
+	NRD NRDConfig `yaml:"nrd"`
+	// Detection of algorithmically generated (DGA) domains
+	DGA DGAConfig `yaml:"dga"`
+	// Detection of punycode lookalikes of protected brand domains
+	Homograph HomographConfig `yaml:"homograph"`
+	// pf rules that send outbound DNS to the agent
+	Firewall FirewallConfig `yaml:"firewall"`
+	// Block page certificates issued by the HTTPS proxy
+	Proxy ProxyConfig `yaml:"proxy"`
+	// Access requests from the block page and approved temporary allows
+	Unblock UnblockConfig `yaml:"unblock"`
+	// User-initiated bypasses of a single blocked domain
+	DomainBypass DomainBypassConfig `yaml:"domainBypass"`
+	// Domains allowed and blocked on this device through the API
+	LocalOverrides LocalOverridesConfig `yaml:"localOverrides"`
+	// Recurring windows during which filtering is paused or relaxed
+	MaintenanceWindows []MaintenanceWindowConfig `yaml:"maintenanceWindows,omitempty"`
+	// Users resolved from an OpenID Connect identity provider instead of
+	// the device mapping
+	OIDC OIDCConfig `yaml:"oidc"`
 
 	// For demo purposes
 	TestDomains []string `yaml:"testDomains"`
+
+	// s3.url as last derived from rules.localDir, see ApplyDerived
+	localRulesURL string
 }
 
 type AgentConfig struct {
@@ -32,42 +71,550 @@ type AgentConfig struct {
 	HTTPSPort    int    `yaml:"httpsPort"`
 	LogLevel     string `yaml:"logLevel"`
 	AllowDisable bool   `yaml:"allowDisable"`
+	// Longest pause /api/pause accepts; 0 means no limit. A managed
+	// policy's max_pause replaces it.
+	MaxPause time.Duration `yaml:"maxPause"`
+	// "block" (default) or "monitor" to log matches but resolve them, for
+	// trialing new rules; the rules' enforcement setting overrides it
+	Enforcement string `yaml:"enforcement"`
 }
 
+// Enforcement modes
+const (
+	EnforcementBlock   = "block"
+	EnforcementMonitor = "monitor" // Log, audit and count matches, but resolve them normally
+)
+
 type S3Config struct {
+	// Rules store other than an S3 bucket: gs://bucket, azblob://account/container,
+	// https://host/path or a Git repository (git+https://, git+ssh://, with
+	// #branch). Replaces bucket when set.
+	URL            string        `yaml:"url,omitempty"`
 	Bucket         string        `yaml:"bucket"`
 	Region         string        `yaml:"region"`
 	RulesPath      string        `yaml:"rulesPath"` // Deprecated, kept for compatibility
 	UpdateInterval time.Duration `yaml:"updateInterval"`
 	UpdateJitter   time.Duration `yaml:"updateJitter"` // Random delay to prevent thundering herd
+	StaleAfter     time.Duration `yaml:"staleAfter"`   // Rules fetched longer ago than this are reported stale
 	AccessKeyID    string        `yaml:"accessKeyId,omitempty"`
 	SecretKey      string        `yaml:"secretKey,omitempty"`
 	LogPrefix      string        `yaml:"logPrefix,omitempty"`
 
+	// Base64 Ed25519 public keys. When set, base, group and user rules are
+	// only applied with a valid detached signature from one of them
+	SigningKeys []string `yaml:"signingKeys,omitempty"`
+
+	// Identifiers the device is looked up by in the device mapping, in
+	// order: serial, mdm, consoleUser and hostname. Defaults to serial,
+	// mdm, hostname.
+	DeviceIdentity []string `yaml:"deviceIdentity,omitempty"`
+
 	// New path structure for enterprise rules
 	Paths S3Paths `yaml:"paths"`
 }
 
+// Configured reports whether a rules store is set
+func (c *S3Config) Configured() bool {
+	return c.Bucket != "" || c.URL != ""
+}
+
 type S3Paths struct {
 	Base             string `yaml:"base"`             // base.yaml
 	DeviceMapping    string `yaml:"deviceMapping"`    // users/device-mapping.yaml
 	UserGroups       string `yaml:"userGroups"`       // users/user-groups.yaml
 	GroupsDir        string `yaml:"groupsDir"`        // groups/
 	UserOverridesDir string `yaml:"userOverridesDir"` // users/overrides/
+	Categories       string `yaml:"categories"`       // categories.yaml
+	BlockPageDir     string `yaml:"blockPageDir"`     // blockpage/
 }
 
 type DNSConfig struct {
-	Upstreams        []string      `yaml:"upstreams"`
-	CacheSize        int           `yaml:"cacheSize"`
-	CacheTTL         time.Duration `yaml:"cacheTTL"`
-	RateLimitQueries int           `yaml:"rateLimitQueries"` // Queries per second per IP
-	RateLimitWindow  time.Duration `yaml:"rateLimitWindow"`  // Rate limit window
+	Upstreams        []string             `yaml:"upstreams"`
+	Strategy         string               `yaml:"strategy"` // "sequential" (default), "race" or "random"
+	// Domain suffix → upstreams used instead of Upstreams for names under it
+	ConditionalForwarders map[string][]string `yaml:"conditionalForwarders"`
+	CacheSize        int                  `yaml:"cacheSize"`
+	CacheTTL         time.Duration        `yaml:"cacheTTL"`
+	NegativeCacheTTL time.Duration        `yaml:"negativeCacheTTL"` // Cap for caching NXDOMAIN/NODATA answers; 0 disables
+	ServeStale       time.Duration        `yaml:"serveStale"`       // How long expired answers are kept for when every upstream fails; 0 disables
+	RateLimitQueries int                  `yaml:"rateLimitQueries"` // Queries per second per IP
+	RateLimitWindow  time.Duration        `yaml:"rateLimitWindow"`  // Rate limit window
+	HostsFile        string               `yaml:"hostsFile"`        // Answered before upstreams; empty disables
+	LocalRecords     []LocalRecordConfig  `yaml:"localRecords"`     // Static answers, reloaded when the config file changes
+	// Client address ranges that get a rule group's rules instead of this
+	// device's, when other hosts or VMs use DNShield as their resolver
+	ClientGroups []ClientGroupConfig `yaml:"clientGroups"`
+	ServerIdentity   ServerIdentityConfig `yaml:"serverIdentity"`
+	Tunneling        TunnelingConfig      `yaml:"tunneling"`
+	// Saving the cache to disk so restarts don't start cold
+	CacheSnapshot CacheSnapshotConfig `yaml:"cacheSnapshot"`
+	// Refresh the most queried names in the background before their
+	// cached answers expire
+	Prefetch     bool `yaml:"prefetch"`
+	PrefetchTopN int  `yaml:"prefetchTopN"`
+	// EDNS Client Subnet handling on upstream queries
+	ECS ECSConfig `yaml:"ecs"`
+	// Randomize the case of names sent upstream (DNS 0x20) and reject
+	// answers that don't echo it
+	CaseRandomization bool `yaml:"caseRandomization"`
 }
 
+// EDNS Client Subnet modes
+const (
+	ECSModeForward = "forward" // Pass on whatever the client sent
+	ECSModeStrip   = "strip"   // Remove client subnets
+	ECSModeInject  = "inject"  // Replace them with Subnet
+)
+
+type ECSConfig struct {
+	// "forward" (default), "strip" or "inject"
+	Mode string `yaml:"mode"`
+	// Prefix sent in inject mode, e.g. "198.51.100.0/24"
+	Subnet string `yaml:"subnet"`
+}
+
+type CacheSnapshotConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Snapshot file; a leading ~/ is the home directory
+	Path string `yaml:"path"`
+	// How often to checkpoint besides shutdown; 0 only saves on shutdown
+	Interval time.Duration `yaml:"interval"`
+}
+
+// TunnelingConfig detects DNS tunnels (iodine, dnscat2) from the queries
+// each client sends to each zone
+type TunnelingConfig struct {
+	Enabled bool          `yaml:"enabled"`
+	Window  time.Duration `yaml:"window"` // Queries are counted per client and zone over this window
+	// Queries to a zone within Window before it is judged
+	MinQueries int `yaml:"minQueries"`
+	// Share of queries for distinct names that flags a zone (0-1)
+	UniqueRatio float64 `yaml:"uniqueRatio"`
+	// Share of TXT and NULL queries that flags a zone (0-1)
+	TXTRatio float64 `yaml:"txtRatio"`
+	// Answer REFUSED for a flagged zone for Cooldown; otherwise only report
+	Refuse   bool          `yaml:"refuse"`
+	Cooldown time.Duration `yaml:"cooldown"`
+	// Zones never flagged, e.g. DNSBLs and antivirus lookups
+	Exempt []string `yaml:"exempt"`
+}
+
+// ClientGroupConfig assigns the queries from a client range to a rule group
+type ClientGroupConfig struct {
+	CIDR  string `yaml:"cidr"`  // e.g. "192.168.64.0/24"
+	Group string `yaml:"group"` // Rules from groups/<group>.yaml on top of the base rules
+}
+
+// LocalRecordConfig is a static DNS record answered by the agent, e.g.
+// printer.lan → 192.168.1.50
+type LocalRecordConfig struct {
+	Name  string        `yaml:"name"`
+	Type  string        `yaml:"type"`  // A, AAAA or CNAME
+	Value string        `yaml:"value"` // Address, or the target name for CNAME
+	TTL   time.Duration `yaml:"ttl"`   // 1m if unset
+}
+
+// ServerIdentityConfig controls how fingerprinting queries such as CHAOS
+// version.bind and EDNS NSID are handled
+type ServerIdentityConfig struct {
+	Mode     string `yaml:"mode"`     // "refuse" (default) or "answer"
+	Version  string `yaml:"version"`  // Returned for version.bind / version.server in answer mode
+	Hostname string `yaml:"hostname"` // Returned for hostname.bind / id.server in answer mode
+}
+
+// Block types: how blocked queries are answered
+const (
+	BlockTypeSinkhole = "sinkhole" // The sinkhole address, where the block page is served
+	BlockTypeNXDomain = "nxdomain"
+	BlockTypeRefused  = "refused"
+	BlockTypeNullIP   = "null_ip" // 0.0.0.0 and ::
+	BlockTypeNoData   = "nodata"  // An empty answer; only for recordTypes
+)
+
 type BlockingConfig struct {
 	DefaultAction string        `yaml:"defaultAction"`
 	BlockType     string        `yaml:"blockType"`
 	BlockTTL      time.Duration `yaml:"blockTTL"`
+	// Addresses blocked A and AAAA queries resolve to with the sinkhole
+	// block type. Without an IPv6 sinkhole AAAA queries resolve to ::1 if
+	// the IPv4 sinkhole is loopback, else get empty answers.
+	SinkholeIP   string `yaml:"sinkholeIP"`
+	SinkholeIPv6 string `yaml:"sinkholeIPv6"`
+	// Block type by query type, e.g. {AAAA: nodata, HTTPS: nxdomain},
+	// overriding BlockType
+	RecordTypes map[string]string `yaml:"recordTypes"`
+	// Block queries whose answer aliases (CNAMEs) a blocked domain
+	CNAMEUncloaking bool `yaml:"cnameUncloaking"`
+	// What blocked clients requested from the sinkhole
+	SinkholeTelemetry SinkholeTelemetryConfig `yaml:"sinkholeTelemetry"`
+}
+
+type SinkholeTelemetryConfig struct {
+	// Record method, URL path and User-Agent of requests to blocked domains
+	Enabled bool `yaml:"enabled"`
+	// Drop query strings and client IPs from records
+	PrivacyMode bool `yaml:"privacyMode"`
+	// Also send records to Splunk (requires logging.splunk)
+	ForwardToSIEM bool `yaml:"forwardToSIEM"`
+}
+
+type RulesConfig struct {
+	// Maximum number of domains accepted in a single block or allow list
+	MaxDomains int `yaml:"maxDomains"`
+	// Maximum size in bytes of a single external blocklist download
+	MaxFileSize int64 `yaml:"maxFileSize"`
+	// Number of applied rulesets kept in ~/.dnshield/rules-history
+	HistorySize int `yaml:"historySize"`
+	// Domains that must stay resolvable; rules that block one of them, or
+	// the rules bucket, are rolled back automatically
+	CriticalDomains []string `yaml:"criticalDomains"`
+	// Directory with the base, groups and users layout of a rules store,
+	// applied whenever its files change. Replaces s3 when set.
+	LocalDir string `yaml:"localDir,omitempty"`
+}
+
+// LocalRulesURL is the rules store URL of rules.localDir
+func LocalRulesURL(dir string) string {
+	return (&url.URL{Scheme: "file", Path: filepath.ToSlash(dir)}).String()
+}
+
+type APIConfig struct {
+	RateLimit APIRateLimitConfig `yaml:"rateLimit"`
+	// Expose pprof profiles and goroutine dumps to admin keys
+	Profiling bool `yaml:"profiling"`
+	// Management API over gRPC on a Unix domain socket
+	GRPC APIGRPCConfig `yaml:"grpc"`
+	// Unix domain socket restricted by peer uid; the menu bar app and CLI
+	// use it
+	Socket APISocketConfig `yaml:"socket"`
+	// Listener on 127.0.0.1:5353, reachable by any local process
+	TCP APITCPConfig `yaml:"tcp"`
+	// Mutual TLS listener for clients beyond loopback, such as fleet health
+	// collectors
+	MTLS APIMTLSConfig `yaml:"mtls"`
+}
+
+type APISocketConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Path    string `yaml:"path"`
+	// Uids allowed to connect besides root
+	AllowedUIDs []uint32 `yaml:"allowedUIDs,omitempty"`
+	// Also allow the user logged in at the console (macOS)
+	AllowConsoleUser bool `yaml:"allowConsoleUser"`
+	// Role of allowed peers that send no API key (admin, operator or
+	// viewer); empty requires a key
+	Role string `yaml:"role"`
+}
+
+type APITCPConfig struct {
+	Enabled bool `yaml:"enabled"`
+}
+
+type APIMTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Address to listen on, e.g. "0.0.0.0:5443"
+	Listen string `yaml:"listen"`
+	// PEM file of the fleet CA(s) whose client certificates are accepted
+	ClientCA string `yaml:"clientCA"`
+	// Host names and IP addresses of the server certificate, issued by the
+	// DNShield CA; defaults to the host name
+	ServerNames []string `yaml:"serverNames,omitempty"`
+	// Role of clients that send no API key (admin, operator or viewer);
+	// empty requires a key
+	Role string `yaml:"role"`
+}
+
+type APIGRPCConfig struct {
+	Enabled bool   `yaml:"enabled"`
+	Socket  string `yaml:"socket"`
+}
+
+// APIRateLimit is a token bucket: Requests per Window sustained, with up to
+// Burst requests allowed at once. Zero fields inherit from the default limit.
+type APIRateLimit struct {
+	Requests int           `yaml:"requests"`
+	Window   time.Duration `yaml:"window"`
+	Burst    int           `yaml:"burst"`
+}
+
+type APIRateLimitConfig struct {
+	// Default limit applied per client
+	APIRateLimit `yaml:",inline"`
+	// Overrides for specific endpoints, keyed by path (e.g. /api/top)
+	Endpoints map[string]APIRateLimit `yaml:"endpoints,omitempty"`
+	// Overrides for API key roles (admin, operator, viewer)
+	Roles map[string]APIRateLimit `yaml:"roles,omitempty"`
+	// Addresses or CIDR ranges of proxies whose X-Forwarded-For header
+	// names the client; it is ignored from everyone else
+	TrustedProxies []string `yaml:"trustedProxies,omitempty"`
+}
+
+type MirrorConfig struct {
+	// Forward a copy of query metadata to an analysis endpoint
+	Enabled bool `yaml:"enabled"`
+	// Where to send records: unix:///path, unixgram:///path, udp://host:port or tcp://host:port
+	Target string `yaml:"target"`
+	// Fraction of queries to mirror, between 0 (exclusive) and 1
+	SampleRate float64 `yaml:"sampleRate"`
+	// Records buffered before new ones are dropped
+	QueueSize int `yaml:"queueSize"`
+	// Include the querying client's IP address in records
+	IncludeClientIP bool `yaml:"includeClientIP"`
+}
+
+type TrustStoreConfig struct {
+	// Watch the System keychain for newly trusted root CAs
+	Enabled bool `yaml:"enabled"`
+	// How often to scan the trust store
+	Interval time.Duration `yaml:"interval"`
+	// Optional HTTPS endpoint that receives a JSON alert for new CAs
+	WebhookURL string `yaml:"webhookURL"`
+}
+
+type TransparentProxyConfig struct {
+	// Answer flow verdicts for the transparent proxy system extension
+	Enabled bool `yaml:"enabled"`
+	// How long a resolved address stays linked to its domain (at least the record TTL)
+	CorrelationTTL time.Duration `yaml:"correlationTTL"`
+	// Maximum resolved addresses remembered
+	MaxTrackedIPs int `yaml:"maxTrackedIPs"`
+}
+
+type ManagedPolicyConfig struct {
+	// Enforce a signed policy. A policy file at Path, a cached policy or an
+	// embedded key enrolls the agent even when this is false.
+	Enabled bool `yaml:"enabled"`
+	// Where the signed policy comes from: file (delivered by MDM) or s3
+	Source string `yaml:"source"`
+	// Policy file for the file source
+	Path string `yaml:"path"`
+	// Object key in s3.bucket for the s3 source
+	S3Key string `yaml:"s3Key"`
+	// Base64 Ed25519 public key that policies must be signed with
+	PublicKey string `yaml:"publicKey"`
+	// How often the policy is reloaded
+	RefreshInterval time.Duration `yaml:"refreshInterval"`
+}
+
+type QueryLogConfig struct {
+	// Record every answered query in a local SQLite database
+	Enabled bool `yaml:"enabled"`
+	// Database file
+	Path string `yaml:"path"`
+	// Queries older than this are deleted
+	Retention time.Duration `yaml:"retention"`
+	// Oldest queries are deleted once the database grows past this size
+	MaxSizeMB int `yaml:"maxSizeMB"`
+}
+
+type ThreatIntelConfig struct {
+	// Block domain indicators from the feeds as security-critical
+	Enabled bool `yaml:"enabled"`
+	// How often feeds are polled, separately from the S3 rules
+	UpdateInterval time.Duration         `yaml:"updateInterval"`
+	Feeds          []ThreatIntelFeedConfig `yaml:"feeds"`
+}
+
+// ThreatIntelFeedConfig is one TAXII 2.1 collection or MISP instance
+type ThreatIntelFeedConfig struct {
+	// Reported as the block source ("threat-intel:<name>") in audit logs
+	Name string `yaml:"name"`
+	// "taxii" or "misp"
+	Type string `yaml:"type"`
+	// TAXII API root (https://taxii.example.com/api1/) or MISP base URL
+	URL string `yaml:"url"`
+	// TAXII collection ID
+	Collection string `yaml:"collection"`
+	// TAXII basic auth user; Token is its password
+	Username string `yaml:"username"`
+	// TAXII password or MISP auth key (prefer TokenEnv)
+	Token string `yaml:"token"`
+	// Environment variable holding the token
+	TokenEnv string `yaml:"tokenEnv"`
+	// Only indicators added within this window are fetched
+	MaxAge time.Duration `yaml:"maxAge"`
+}
+
+type NRDConfig struct {
+	// Block domains registered within MaxAge, from a daily feed
+	Enabled bool `yaml:"enabled"`
+	// Newly registered domains, one per line: "example.com" (registered on
+	// the day it is fetched) or "example.com,2024-01-20"
+	FeedURL string `yaml:"feedURL"`
+	// Domains stay blocked this long after registration
+	MaxAge time.Duration `yaml:"maxAge"`
+	// How often the feed is fetched
+	UpdateInterval time.Duration `yaml:"updateInterval"`
+	// Registration dates are kept here across restarts
+	StatePath string `yaml:"statePath"`
+	// Cap on tracked domains
+	MaxDomains int `yaml:"maxDomains"`
+	// Domains and their subdomains never blocked as newly registered
+	Allow []string `yaml:"allow"`
+}
+
+// DGA detection modes
+const (
+	DGAModeMonitor = "monitor" // Log and audit flagged domains only
+	DGAModeBlock   = "block"
+)
+
+type DGAConfig struct {
+	// Score queried domains for signs of a domain generation algorithm
+	Enabled bool `yaml:"enabled"`
+	// "monitor" (default) or "block"
+	Mode string `yaml:"mode"`
+	// Domains scoring at least this (0-1) are flagged
+	Threshold float64 `yaml:"threshold"`
+	// Registered names shorter than this are never flagged
+	MinLength int `yaml:"minLength"`
+}
+
+// Homograph detection modes
+const (
+	HomographModeWarn  = "warn" // Log and audit lookalikes only
+	HomographModeBlock = "block"
+)
+
+type HomographConfig struct {
+	// Compare internationalized (punycode) names against Brands
+	Enabled bool `yaml:"enabled"`
+	// "warn" (default) or "block"
+	Mode string `yaml:"mode"`
+	// Protected domains, e.g. the company's own; names that look like
+	// them once decoded are flagged
+	Brands []string `yaml:"brands"`
+}
+
+type FirewallConfig struct {
+	// Redirect all outbound port 53 traffic to the agent with pf, so
+	// applications with hard-coded resolvers are filtered too. Requires root.
+	RedirectDNS bool `yaml:"redirectDNS"`
+	// Also reject DNS over TLS (port 853), so clients fall back to plain DNS
+	BlockDoT bool `yaml:"blockDoT"`
+}
+
+// Leaf certificate key algorithms
+const (
+	KeyAlgorithmECDSAP256 = "ecdsa-p256"
+	KeyAlgorithmRSA2048   = "rsa-2048" // For clients without ECDSA support
+)
+
+type ProxyConfig struct {
+	// Key type of block page certificates: "ecdsa-p256" (default) or "rsa-2048"
+	KeyAlgorithm string `yaml:"keyAlgorithm"`
+	// Keys generated ahead of handshakes; 0 generates each one on demand
+	KeyPoolSize int `yaml:"keyPoolSize"`
+}
+
+type UnblockConfig struct {
+	// Let users request access from the block page
+	Enabled bool `yaml:"enabled"`
+	// Requests are posted as JSON to this https URL
+	WebhookURL string `yaml:"webhookURL"`
+	// and/or written as JSON objects under this prefix of s3.bucket
+	S3Prefix string `yaml:"s3Prefix"`
+	// Base64 Ed25519 public key of signed approvals that allow a domain
+	// for a while; empty disables approvals
+	ApprovalPublicKey string `yaml:"approvalPublicKey"`
+	// Longest temporary allow an approval can grant
+	MaxAllowDuration time.Duration `yaml:"maxAllowDuration"`
+	// Cap on requests per hour, so the block page can't be used to flood
+	// the security team
+	MaxRequestsPerHour int `yaml:"maxRequestsPerHour"`
+}
+
+// Maintenance window modes
+const (
+	MaintenancePause   = "pause"   // Hand DNS back to the network's resolvers
+	MaintenanceMonitor = "monitor" // Log matches but resolve them; security rules still block
+)
+
+// MaintenanceWindowConfig pauses or relaxes filtering during a recurring
+// window, e.g. for patching systems that need direct resolution
+type MaintenanceWindowConfig struct {
+	Name     string   `yaml:"name"`
+	Timezone string   `yaml:"timezone,omitempty"` // IANA name; the device's local time if empty
+	Days     []string `yaml:"days,omitempty"`     // sun, mon, ... sat; every day if empty
+	Start    string   `yaml:"start"`              // "HH:MM"
+	End      string   `yaml:"end"`                // "HH:MM"; before Start for overnight windows
+	Mode     string   `yaml:"mode,omitempty"`     // "pause" (default) or "monitor"
+}
+
+type LocalOverridesConfig struct {
+	// Let operator keys allow and block domains on this device through
+	// /api/rules/allow and /api/rules/block
+	Enabled bool `yaml:"enabled"`
+	// Domains that can be allowed locally, with their subdomains; empty
+	// allows any domain outside the excluded categories
+	AllowedDomains []string `yaml:"allowedDomains"`
+	// Block categories that can never be allowed locally
+	ExcludedCategories []string `yaml:"excludedCategories"`
+	// Most allow and block entries together
+	MaxEntries int `yaml:"maxEntries"`
+}
+
+type OIDCConfig struct {
+	// Resolve the user from the ID token saved by 'dnshield login'; the
+	// device mapping is used when there is none
+	Enabled bool `yaml:"enabled"`
+	// Issuer URL, such as https://example.okta.com/oauth2/default
+	Issuer string `yaml:"issuer"`
+	// Public client with the device authorization grant enabled
+	ClientID string   `yaml:"clientId"`
+	Scopes   []string `yaml:"scopes,omitempty"`
+	// ID token claims holding the user's email address and groups
+	EmailClaim  string `yaml:"emailClaim"`
+	GroupsClaim string `yaml:"groupsClaim"`
+	// Identity provider group to DNShield group; the first of the user's
+	// groups listed here is applied. Users in none of them are looked up
+	// in users/user-groups.yaml.
+	GroupMapping map[string]string `yaml:"groupMapping,omitempty"`
+	// Where the tokens are kept
+	TokenFile string `yaml:"tokenFile"`
+}
+
+type DomainBypassConfig struct {
+	// Let users bypass a blocked domain for a few minutes with a justification
+	Enabled bool `yaml:"enabled"`
+	// Longest bypass a user can ask for
+	MaxDuration time.Duration `yaml:"maxDuration"`
+	// Require an admin-issued TOTP code as well as a justification
+	RequireCode bool `yaml:"requireCode"`
+	// Base32 TOTP secret the codes are generated from (prefer the
+	// DNSHIELD_BYPASS_TOTP_SECRET environment variable)
+	TOTPSecret string `yaml:"totpSecret"`
+	// Bypasses allowed per rolling 24 hours
+	MaxPerDay int `yaml:"maxPerDay"`
+	// Block categories that can never be bypassed
+	ExcludedCategories []string `yaml:"excludedCategories"`
+}
+
+type IncidentConfig struct {
+	// Open tickets when a device repeatedly hits security blocks
+	Enabled bool `yaml:"enabled"`
+	// Ticketing system: servicenow or jira
+	Provider string `yaml:"provider"`
+	// Instance base URL, e.g. https://company.service-now.com
+	URL string `yaml:"url"`
+	// Account used to authenticate (ServiceNow user or Jira account email)
+	Username string `yaml:"username"`
+	// Password or API token (prefer the DNSHIELD_INCIDENT_TOKEN environment variable)
+	Token string `yaml:"token"`
+	// Block categories that count towards a ticket
+	Categories []string `yaml:"categories"`
+	// Blocks within Window that open a ticket
+	Threshold int           `yaml:"threshold"`
+	Window    time.Duration `yaml:"window"`
+	// Further blocks update the open ticket until it has been quiet this long
+	DedupeWindow time.Duration `yaml:"dedupeWindow"`
+	// Cap on tickets created or updated per hour
+	MaxTicketsPerHour int `yaml:"maxTicketsPerHour"`
+	// ServiceNow: assignment group for new incidents
+	AssignmentGroup string `yaml:"assignmentGroup"`
+	// Jira: project key and issue type for new issues
+	Project   string `yaml:"project"`
+	IssueType string `yaml:"issueType"`
 }
 
 type CaptivePortalConfig struct {
@@ -81,12 +628,26 @@ type CaptivePortalConfig struct {
 	BypassDuration time.Duration `yaml:"bypassDuration"`
 	// Additional captive portal domains to monitor (beyond the built-in list)
 	AdditionalDomains []string `yaml:"additionalDomains,omitempty"`
+	// Allow users to start a bypass manually ("I'm behind a captive portal")
+	AllowManualBypass bool `yaml:"allowManualBypass"`
+	// Longest bypass a user can request manually
+	ManualBypassMaxDuration time.Duration `yaml:"manualBypassMaxDuration"`
+	// Number of manual bypasses allowed per hour
+	ManualBypassMaxPerHour int `yaml:"manualBypassMaxPerHour"`
 }
 
 type LoggingConfig struct {
 	Splunk SplunkConfig `yaml:"splunk"`
 	S3     S3LogConfig  `yaml:"s3"`
 	Local  LocalConfig  `yaml:"local"`
+	OTLP   OTLPConfig   `yaml:"otlp"`
+	Syslog SyslogConfig `yaml:"syslog"`
+	// Stream audit events and query records to Kafka
+	Kafka KafkaConfig `yaml:"kafka"`
+	// What query data logs and exports keep: "full", "hashed" (domains and
+	// client IPs HMAC-hashed with a per-device key) or "aggregate" (only
+	// counters)
+	Privacy string `yaml:"privacy"`
 }
 
 type SplunkConfig struct {
@@ -110,6 +671,96 @@ type S3LogConfig struct {
 type LocalConfig struct {
 	BufferSize   int    `yaml:"bufferSize"`
 	FallbackPath string `yaml:"fallbackPath"`
+	// Oldest undelivered events are dropped once the fallback buffer
+	// grows past this size
+	FallbackMaxSizeMB int `yaml:"fallbackMaxSizeMB"`
+	// Audit events and undelivered events older than this are deleted;
+	// 0 keeps them
+	Retention time.Duration `yaml:"retention"`
+}
+
+// SyslogConfig sends audit events, including blocks, to syslog
+type SyslogConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Empty for the local syslog daemon, or udp://host:port, tcp://host:port
+	// or tls://host:port for a remote collector (RFC 5424)
+	Target string `yaml:"target"`
+	// Message body: "text", "cef" (ArcSight) or "leef" (QRadar)
+	Format string `yaml:"format"`
+	// Syslog facility, e.g. "local0" or "authpriv"
+	Facility string `yaml:"facility"`
+	// APP-NAME (or tag) of the messages
+	AppName string `yaml:"appName"`
+	// PEM CA bundle verifying a tls:// collector; empty uses the system roots
+	CAFile string `yaml:"caFile,omitempty"`
+	// Audit event types to send, e.g. DOMAIN_BLOCKED; empty sends all
+	EventTypes []string `yaml:"eventTypes,omitempty"`
+	// Messages buffered before new ones are dropped
+	QueueSize int `yaml:"queueSize"`
+}
+
+// KafkaConfig produces audit events and query records to Kafka topics
+type KafkaConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// Bootstrap brokers as host:port
+	Brokers []string `yaml:"brokers"`
+	// Topic for audit events, including blocks; empty sends none
+	AuditTopic string `yaml:"auditTopic"`
+	// Topic for a record of every answered query; empty sends none
+	QueryTopic string `yaml:"queryTopic"`
+	// Client ID reported to the brokers
+	ClientID string          `yaml:"clientID"`
+	TLS      KafkaTLSConfig  `yaml:"tls"`
+	SASL     KafkaSASLConfig `yaml:"sasl"`
+	// Record batch compression: "none" or "gzip"
+	Compression string `yaml:"compression"`
+	// Acknowledgements to wait for: 1 (leader) or -1 (all in-sync replicas)
+	RequiredAcks int `yaml:"requiredAcks"`
+	// Records per produce request, and the longest a record waits for one
+	BatchSize     int           `yaml:"batchSize"`
+	BatchInterval time.Duration `yaml:"batchInterval"`
+	// Records buffered before new ones are dropped
+	QueueSize int `yaml:"queueSize"`
+	// Include the querying client's IP address in query records
+	IncludeClientIP bool `yaml:"includeClientIP"`
+}
+
+// KafkaTLSConfig encrypts broker connections
+type KafkaTLSConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// PEM CA bundle verifying the brokers; empty uses the system roots
+	CAFile string `yaml:"caFile,omitempty"`
+}
+
+// KafkaSASLConfig authenticates to the brokers
+type KafkaSASLConfig struct {
+	// "PLAIN", "SCRAM-SHA-256" or "SCRAM-SHA-512"; empty disables SASL
+	Mechanism string `yaml:"mechanism"`
+	Username  string `yaml:"username"`
+	// ${VAR} is read from the environment
+	Password string `yaml:"password"`
+}
+
+// OTLPConfig exports traces and metrics to an OpenTelemetry collector
+type OTLPConfig struct {
+	Enabled bool `yaml:"enabled"`
+	// "grpc", or "http" for protobuf over HTTP
+	Protocol string `yaml:"protocol"`
+	// Collector URL; http:// sends in plaintext. Defaults to
+	// http://localhost:4317 for grpc and http://localhost:4318 for http.
+	Endpoint string `yaml:"endpoint"`
+	// Sent with every export, e.g. an authorization token. ${VAR} in a
+	// value is replaced from the environment.
+	Headers map[string]string `yaml:"headers,omitempty"`
+	// service.name reported to the collector
+	ServiceName string `yaml:"serviceName"`
+	// How often metrics and queued spans are sent
+	Interval time.Duration `yaml:"interval"`
+	// Fraction of DNS queries traced, between 0 and 1; failed queries and
+	// rule updates are always traced
+	TraceSampleRate float64 `yaml:"traceSampleRate"`
+	// Spans buffered between exports before new ones are dropped
+	QueueSize int `yaml:"queueSize"`
 }
 
 // LoadConfig loads configuration from a YAML file
@@ -150,22 +801,56 @@ func LoadConfig(path string) (*Config, error) {
 			HTTPSPort:    443,
 			LogLevel:     "info",
 			AllowDisable: true,
+			MaxPause:     time.Hour,
+			Enforcement:  EnforcementBlock,
 		},
 		DNS: DNSConfig{
 			Upstreams:        []string{"1.1.1.1", "8.8.8.8"},
+			Strategy:         "sequential",
 			CacheSize:        10000,
 			CacheTTL:         1 * time.Hour,
+			NegativeCacheTTL: 5 * time.Minute,
+			ServeStale:       24 * time.Hour,
 			RateLimitQueries: 100,          // 100 queries per second per IP
 			RateLimitWindow:  1 * time.Second,
+			HostsFile:        "/etc/hosts",
+			ServerIdentity: ServerIdentityConfig{
+				Mode: "refuse",
+			},
+			Tunneling: TunnelingConfig{
+				Window:      time.Minute,
+				MinQueries:  50,
+				UniqueRatio: 0.8,
+				TXTRatio:    0.5,
+				Cooldown:    10 * time.Minute,
+			},
+			CacheSnapshot: CacheSnapshotConfig{
+				Path:     "~/.dnshield/cache.bin",
+				Interval: 5 * time.Minute,
+			},
+			PrefetchTopN: 200,
+			ECS: ECSConfig{
+				Mode: ECSModeForward,
+			},
 		},
 		Blocking: BlockingConfig{
 			DefaultAction: "block",
-			BlockType:     "sinkhole",
+			BlockType:     BlockTypeSinkhole,
 			BlockTTL:      10 * time.Second,
+			SinkholeIP:    "127.0.0.1",
+			SinkholeTelemetry: SinkholeTelemetryConfig{
+				Enabled: true,
+			},
+		},
+		Rules: RulesConfig{
+			MaxDomains:  utils.MaxDomainsPerRule,
+			MaxFileSize: utils.MaxRulesFileSize,
+			HistorySize: 20,
 		},
 		S3: S3Config{
 			UpdateInterval: 5 * time.Minute,
 			UpdateJitter:   30 * time.Second,
+			StaleAfter:     24 * time.Hour,
 			LogPrefix:      "audit-logs/",
 			Paths: S3Paths{
 				Base:             "base.yaml",
@@ -173,6 +858,8 @@ func LoadConfig(path string) (*Config, error) {
 				UserGroups:       "users/user-groups.yaml",
 				GroupsDir:        "groups/",
 				UserOverridesDir: "users/overrides/",
+				Categories:       "categories.yaml",
+				BlockPageDir:     "blockpage/",
 			},
 		},
 		Logging: LoggingConfig{
@@ -191,26 +878,140 @@ func LoadConfig(path string) (*Config, error) {
 				Retention:     90 * 24 * time.Hour, // 90 days
 			},
 			Local: LocalConfig{
-				BufferSize:   10000,
-				FallbackPath: "~/.dnshield/audit/buffer",
+				BufferSize:        10000,
+				FallbackPath:      "~/.dnshield/audit/buffer",
+				FallbackMaxSizeMB: 100,
+				Retention:         365 * 24 * time.Hour,
+			},
+			Syslog: SyslogConfig{
+				Format:    "text",
+				Facility:  "local0",
+				AppName:   "dnshield",
+				QueueSize: 10000,
+			},
+			Privacy: "full",
+			Kafka: KafkaConfig{
+				ClientID:      "dnshield",
+				Compression:   "gzip",
+				RequiredAcks:  1,
+				BatchSize:     500,
+				BatchInterval: time.Second,
+				QueueSize:     100000,
+			},
+			OTLP: OTLPConfig{
+				Protocol:        "http",
+				ServiceName:     "dnshield",
+				Interval:        30 * time.Second,
+				TraceSampleRate: 0.1,
+				QueueSize:       10000,
 			},
 		},
 		CaptivePortal: CaptivePortalConfig{
-			Enabled:            true,
-			DetectionThreshold: 3,
-			DetectionWindow:    10 * time.Second,
-			BypassDuration:     5 * time.Minute,
+			Enabled:                 true,
+			DetectionThreshold:      3,
+			DetectionWindow:         10 * time.Second,
+			BypassDuration:          5 * time.Minute,
+			ManualBypassMaxDuration: 5 * time.Minute,
+			ManualBypassMaxPerHour:  3,
+		},
+		Mirror: MirrorConfig{
+			SampleRate: 1.0,
+			QueueSize:  10000,
+		},
+		TrustStore: TrustStoreConfig{
+			Enabled:  true,
+			Interval: 10 * time.Minute,
+		},
+		TransparentProxy: TransparentProxyConfig{
+			CorrelationTTL: 10 * time.Minute,
+			MaxTrackedIPs:  100000,
+		},
+		ManagedPolicy: ManagedPolicyConfig{
+			Source:          "file",
+			Path:            "/Library/Application Support/DNShield/managed-policy.json",
+			S3Key:           "policy/managed-policy.json",
+			RefreshInterval: 15 * time.Minute,
+		},
+		QueryLog: QueryLogConfig{
+			Path:      "/Library/Application Support/DNShield/querylog.db",
+			Retention: 7 * 24 * time.Hour,
+			MaxSizeMB: 100,
+		},
+		ThreatIntel: ThreatIntelConfig{
+			UpdateInterval: 15 * time.Minute,
+		},
+		DGA: DGAConfig{
+			Mode:      DGAModeMonitor,
+			Threshold: 0.75,
+			MinLength: 10,
+		},
+		Homograph: HomographConfig{
+			Mode: HomographModeWarn,
+		},
+		Proxy: ProxyConfig{
+			KeyAlgorithm: KeyAlgorithmECDSAP256,
+			KeyPoolSize:  8,
+		},
+		NRD: NRDConfig{
+			MaxAge:         30 * 24 * time.Hour,
+			UpdateInterval: 24 * time.Hour,
+			StatePath:      "/Library/Application Support/DNShield/nrd-state.txt",
+			MaxDomains:     2000000,
+		},
+		Unblock: UnblockConfig{
+			S3Prefix:           "unblock-requests/",
+			MaxAllowDuration:   24 * time.Hour,
+			MaxRequestsPerHour: 10,
+		},
+		LocalOverrides: LocalOverridesConfig{
+			ExcludedCategories: []string{"security"},
+			MaxEntries:         100,
+		},
+		OIDC: OIDCConfig{
+			EmailClaim:  "email",
+			GroupsClaim: "groups",
+			TokenFile:   "/Library/Application Support/DNShield/oidc-token.json",
+		},
+		DomainBypass: DomainBypassConfig{
+			MaxDuration:        30 * time.Minute,
+			MaxPerDay:          5,
+			ExcludedCategories: []string{"security"},
+		},
+		Incident: IncidentConfig{
+			Categories:        []string{"security"},
+			Threshold:         3,
+			Window:            15 * time.Minute,
+			DedupeWindow:      24 * time.Hour,
+			MaxTicketsPerHour: 5,
+			IssueType:         "Task",
+		},
+		API: APIConfig{
+			RateLimit: APIRateLimitConfig{
+				APIRateLimit: APIRateLimit{
+					Requests: 100, // 100 requests per minute per client
+					Window:   time.Minute,
+					Burst:    100,
+				},
+			},
+			GRPC: APIGRPCConfig{
+				Socket: "/var/run/dnshield/grpc.sock",
+			},
+			Socket: APISocketConfig{
+				Enabled:          true,
+				Path:             "/var/run/dnshield/api.sock",
+				AllowConsoleUser: true,
+				Role:             "operator",
+			},
+			MTLS: APIMTLSConfig{
+				Listen: "0.0.0.0:5443",
+				Role:   "viewer",
+			},
 		},
 	}
 
 	// If no path specified, try default locations
 	if path == "" {
-		for _, p := range []string{"./config.yaml", "/etc/dnshield/config.yaml"} {
-			if _, err := os.Stat(p); err == nil {
-				path = p
-				break
-			}
-		}
+		path = DefaultConfigPath()
 	}
 
 	// If we have a config file, load it
@@ -240,15 +1041,78 @@ func LoadConfig(path string) (*Config, error) {
 		}
 	}
 
+	// DNSHIELD_* environment variables win over the file
+	if err := cfg.ApplyEnv(os.Environ()); err != nil {
+		return nil, err
+	}
+	cfg.ApplyDerived()
+
 	return cfg, nil
 }
 
+// ApplyDerived fills in the settings that follow from others. LoadConfig
+// calls it; call it again after changing settings with Set.
+func (c *Config) ApplyDerived() {
+	// The local rules directory is read through the rules store like
+	// s3.url. An s3.url derived from an earlier rules.localDir follows it.
+	if c.S3.URL != "" && c.S3.URL == c.localRulesURL {
+		c.S3.URL = ""
+	}
+	c.localRulesURL = ""
+	if c.Rules.LocalDir != "" && !c.S3.Configured() {
+		c.localRulesURL = LocalRulesURL(c.Rules.LocalDir)
+		c.S3.URL = c.localRulesURL
+	}
+}
+
+// DefaultConfigPath returns the first config file found in the default
+// locations, or "" if there is none
+func DefaultConfigPath() string {
+	for _, p := range []string{"./config.yaml", "/etc/dnshield/config.yaml"} {
+		if _, err := os.Stat(p); err == nil {
+			return p
+		}
+	}
+	return ""
+}
+
+// CAProfileConfig selects a dedicated CA for a device group. Leaf
+// certificates issued for the group carry the same organization details.
+type CAProfileConfig struct {
+	Name               string `yaml:"name"`                          // Profile identifier, e.g. "contractors"
+	Organization       string `yaml:"organization,omitempty"`        // Legal entity name
+	OrganizationalUnit string `yaml:"organizational_unit,omitempty"` // e.g. "Contractors"
+	Country            string `yaml:"country,omitempty"`
+}
+
+// BlockPageConfig customizes the guidance shown on the block page. Group
+// values override base values field by field.
+type BlockPageConfig struct {
+	ContactEmail string            `yaml:"contact_email,omitempty"` // Who to ask for access
+	ContactURL   string            `yaml:"contact_url,omitempty"`   // Where to ask for access, e.g. a ticket form
+	PolicyURL    string            `yaml:"policy_url,omitempty"`    // Acceptable use policy
+	Message      string            `yaml:"message,omitempty"`       // Replaces the default explanation
+	Categories   map[string]string `yaml:"categories,omitempty"`    // Message per block category (security, blocklist, allow-only)
+}
+
+// BypassPreventionConfig blocks public DNS-over-HTTPS/TLS endpoints and
+// the canary domains browsers check before bypassing the local resolver.
+// Group values override the base enabled flag; lists from both are merged.
+type BypassPreventionConfig struct {
+	Enabled *bool    `yaml:"enabled,omitempty"`
+	Domains []string `yaml:"domains,omitempty"` // Endpoints added to the built-in list
+	Sources []string `yaml:"sources,omitempty"` // Lists of endpoints to fetch, like block_sources
+	Exempt  []string `yaml:"exempt,omitempty"`  // Built-in endpoints to leave alone, e.g. your own DoH server
+}
+
 // Rules represents the blocklist rules fetched from S3
 type Rules struct {
 	Version      string              `yaml:"version"`
 	Description  string              `yaml:"description,omitempty"`
 	Updated      time.Time           `yaml:"updated"`
 	BlockSources []string            `yaml:"block_sources"` // External blocklist URLs
+	// Categories from the category registry whose lists are blocked
+	BlockCategories []string `yaml:"block_categories,omitempty"`
 	BlockDomains []string            `yaml:"block_domains"` // Domains to block
 	AllowDomains []string            `yaml:"allow_domains"` // Domains to never block
 	Checksums    map[string]string   `yaml:"checksums,omitempty"`     // SHA256 checksums for BlockSources
@@ -256,11 +1120,92 @@ type Rules struct {
 	// Allow-only mode: when true, block everything except AllowDomains
 	AllowOnlyMode bool `yaml:"allow_only_mode,omitempty"`
 
+	// Security-critical (malware/C2/phishing) domains and lists. These can
+	// override allow_domains when allowlist_precedence is "security".
+	SecurityBlockDomains []string `yaml:"security_block_domains,omitempty"`
+	SecurityBlockSources []string `yaml:"security_block_sources,omitempty"`
+
+	// Regular expressions (Go RE2 syntax) matched against lowercase names
+	// without the trailing dot
+	BlockRegex []string `yaml:"block_regex,omitempty"`
+
+	// Rules that only apply during a daily time window
+	Schedules []ScheduleConfig `yaml:"schedules,omitempty"`
+
+	// Which wins when a domain is both allowed and security-blocked:
+	// "allowlist" (default) or "security". Only honored in base and group rules
+	AllowlistPrecedence string `yaml:"allowlist_precedence,omitempty"`
+
+	// Group-specific CA identity; only honored in base and group rules
+	CA *CAProfileConfig `yaml:"ca,omitempty"`
+
+	// Block page guidance; only honored in base and group rules
+	BlockPage *BlockPageConfig `yaml:"block_page,omitempty"`
+
+	// Blocking of DoH/DoT resolvers apps could use instead of DNShield;
+	// only honored in base and group rules
+	BypassPrevention *BypassPreventionConfig `yaml:"bypass_prevention,omitempty"`
+
+	// Rewrite search engines to their SafeSearch names; only honored in
+	// base and group rules
+	EnforceSafeSearch *bool `yaml:"enforce_safesearch,omitempty"`
+
+	// Behavior per network the device joins; only honored in base and
+	// group rules
+	Networks []NetworkProfileConfig `yaml:"networks,omitempty"`
+
+	// "block" or "monitor" to only log what the rules match; only honored
+	// in base and group rules
+	Enforcement string `yaml:"enforcement,omitempty"`
+
+	// Stage this version of the file to a share of devices; the rest keep
+	// the version they applied before
+	Rollout *RolloutConfig `yaml:"rollout,omitempty"`
+
 	// Deprecated fields for backward compatibility
 	Sources   []string `yaml:"sources,omitempty"`   // Maps to BlockSources
 	Domains   []string `yaml:"domains,omitempty"`   // Maps to BlockDomains
 	Whitelist []string `yaml:"whitelist,omitempty"` // Maps to AllowDomains
-	Regex     []string `yaml:"regex,omitempty"`
+	Regex     []string `yaml:"regex,omitempty"`     // Maps to BlockRegex
+}
+
+// RolloutConfig stages a rules file. Each device decides from a hash of
+// its name and the salt whether it is among the first Percent of devices.
+type RolloutConfig struct {
+	Percent int    `yaml:"percent"`        // 0-100
+	Salt    string `yaml:"salt,omitempty"` // Change to pick a different set of devices
+}
+
+// ScheduleConfig applies extra rules during a daily time window, e.g.
+// blocking social media 09:00-17:00 on weekdays
+type ScheduleConfig struct {
+	Name     string   `yaml:"name"`
+	Timezone string   `yaml:"timezone,omitempty"` // IANA name, e.g. "America/New_York"; the device's local time if empty
+	Days     []string `yaml:"days,omitempty"`     // sun, mon, ... sat; every day if empty
+	Start    string   `yaml:"start"`              // "HH:MM"
+	End      string   `yaml:"end"`                // "HH:MM"; before Start for overnight windows
+
+	// Domains blocked while the schedule is active
+	BlockDomains []string `yaml:"block_domains,omitempty"`
+	// Block everything except allow_domains while the schedule is active
+	AllowOnlyMode bool `yaml:"allow_only_mode,omitempty"`
+}
+
+// Network profile filtering modes
+const (
+	NetworkFilteringOn     = "on"     // Filter as usual
+	NetworkFilteringOff    = "off"    // Hand DNS back to the network, e.g. behind an on-prem filter
+	NetworkFilteringStrict = "strict" // Filter, and refuse pausing or disabling
+)
+
+// NetworkProfileConfig sets how DNShield behaves on the networks it
+// matches. A profile with no ssids or gateway_macs applies to networks no
+// other profile matches.
+type NetworkProfileConfig struct {
+	Name        string   `yaml:"name"`
+	SSIDs       []string `yaml:"ssids,omitempty"`        // WiFi network names
+	GatewayMACs []string `yaml:"gateway_macs,omitempty"` // Router MACs, e.g. "a4:83:e7:12:34:56"
+	Filtering   string   `yaml:"filtering,omitempty"`    // "on" (default), "off" or "strict"
 }
 
 // DeviceMapping represents the device-to-user mapping
@@ -274,6 +1219,19 @@ type UserDevices struct {
 	Devices []string `yaml:"devices"`
 }
 
+// CategoryRegistry maps category names referenced by block_categories to
+// the curated lists behind them
+type CategoryRegistry struct {
+	Version    string                    `yaml:"version"`
+	Categories map[string]CategoryConfig `yaml:"categories"`
+}
+
+// CategoryConfig is one category in the registry
+type CategoryConfig struct {
+	Description string   `yaml:"description,omitempty"`
+	Sources     []string `yaml:"sources"` // Blocklist URLs, like block_sources
+}
+
 // UserGroups represents the user-to-group mapping
 type UserGroups struct {
 	Version          string              `yaml:"version"`
@@ -297,4 +1255,8 @@ func (r *Rules) Normalize() {
 		r.AllowDomains = r.Whitelist
 		r.Whitelist = nil
 	}
+	if len(r.Regex) > 0 && len(r.BlockRegex) == 0 {
+		r.BlockRegex = r.Regex
+		r.Regex = nil
+	}
 }
@@ -93,14 +93,9 @@ func SanitizeConfig(cfg *Config) Config {
 func ValidateCredentialSecurity(cfg *Config) []string {
 	var warnings []string
 	
-	// Check for AWS credentials in config
-	if cfg.S3.AccessKeyID != "" || cfg.S3.SecretKey != "" {
-		warnings = append(warnings, "AWS credentials found in configuration file - consider using environment variables or IAM roles")
-	}
-	
-	// Check for Splunk token in config
-	if cfg.Logging.Splunk.Enabled && cfg.Logging.Splunk.Token != "" {
-		warnings = append(warnings, "Splunk HEC token found in configuration file - consider using environment variables")
+	// Check for AWS keys, tokens and secrets in config
+	for _, issue := range inlineSecrets(cfg) {
+		warnings = append(warnings, issue.Message)
 	}
 	
 	// Check if running in debug mode
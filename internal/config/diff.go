@@ -0,0 +1,49 @@
+package config
+
+import (
+	"reflect"
+	"strings"
+)
+
+// Changes returns the settings that differ between old and new, named by
+// their config.yaml path, such as dns.upstreams. Settings nested deeper
+// than a section's fields are reported by that field, e.g. a change to
+// dns.cacheSnapshot.interval as dns.cacheSnapshot.
+func Changes(old, new *Config) []string {
+	var changed []string
+	diffFields(reflect.ValueOf(old).Elem(), reflect.ValueOf(new).Elem(), "", 2, &changed)
+	return changed
+}
+
+// diffFields appends the paths of the fields of struct values a and b that
+// differ to changed, descending depth levels of structs
+func diffFields(a, b reflect.Value, prefix string, depth int, changed *[]string) {
+	t := a.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		if !field.IsExported() {
+			continue
+		}
+		name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if name == "-" {
+			continue
+		}
+		fa, fb := a.Field(i), b.Field(i)
+		if strings.Contains(opts, "inline") && field.Type.Kind() == reflect.Struct {
+			diffFields(fa, fb, prefix, depth, changed)
+			continue
+		}
+		if reflect.DeepEqual(fa.Interface(), fb.Interface()) {
+			continue
+		}
+
+		if name == "" {
+			name = strings.ToLower(field.Name)
+		}
+		if depth > 1 && field.Type.Kind() == reflect.Struct {
+			diffFields(fa, fb, prefix+name+".", depth-1, changed)
+			continue
+		}
+		*changed = append(*changed, prefix+name)
+	}
+}
@@ -0,0 +1,30 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestChanges(t *testing.T) {
+	old := &Config{}
+	old.DNS.Upstreams = []string{"1.1.1.1"}
+	old.DNS.CacheSnapshot.Interval = time.Minute
+	old.CaptivePortal.Enabled = true
+
+	if changed := Changes(old, old); len(changed) != 0 {
+		t.Errorf("unchanged config reported changes %q", changed)
+	}
+
+	new := *old
+	new.DNS.Upstreams = []string{"9.9.9.9"}
+	new.DNS.CacheSnapshot.Interval = time.Hour
+	new.CaptivePortal.Enabled = false
+	new.TestDomains = []string{"test.example.com"}
+	new.API.RateLimit.Requests = 10
+
+	want := []string{"dns.upstreams", "dns.cacheSnapshot", "captivePortal.enabled", "api.rateLimit", "testDomains"}
+	if changed := Changes(old, &new); !reflect.DeepEqual(changed, want) {
+		t.Errorf("Changes = %q, want %q", changed, want)
+	}
+}
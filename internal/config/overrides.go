@@ -0,0 +1,190 @@
+package config
+
+import (
+	"fmt"
+	"reflect"
+	"sort"
+	"strings"
+	"unicode"
+
+	"gopkg.in/yaml.v3"
+)
+
+// EnvPrefix starts the environment variables that override settings
+const EnvPrefix = "DNSHIELD_"
+
+// Set sets the setting at path, such as dns.cacheSize or agent.logLevel,
+// to value. String settings take value as is; others parse it as YAML, so
+// lists and maps can be given in flow style ([a, b] or {k: v}), and lists
+// of strings also as a, b.
+func (c *Config) Set(path, value string) error {
+	field, err := settingField(reflect.ValueOf(c).Elem(), path)
+	if err != nil {
+		return err
+	}
+
+	switch {
+	case field.Kind() == reflect.String:
+		field.SetString(value)
+		return nil
+	case field.Kind() == reflect.Slice && field.Type().Elem().Kind() == reflect.String && !strings.HasPrefix(strings.TrimSpace(value), "["):
+		var items []string
+		for _, item := range strings.Split(value, ",") {
+			if item = strings.TrimSpace(item); item != "" {
+				items = append(items, item)
+			}
+		}
+		list := reflect.MakeSlice(field.Type(), len(items), len(items))
+		for i, item := range items {
+			list.Index(i).SetString(item)
+		}
+		field.Set(list)
+		return nil
+	}
+
+	// Decoded into a fresh value so maps and structs are replaced, not
+	// merged into
+	parsed := reflect.New(field.Type())
+	if err := yaml.Unmarshal([]byte(value), parsed.Interface()); err != nil {
+		return fmt.Errorf("invalid value for %s: %v", path, strings.TrimPrefix(err.Error(), "yaml: "))
+	}
+	field.Set(parsed.Elem())
+	return nil
+}
+
+// ApplyEnv sets every setting named by a variable in environ (as from
+// os.Environ), DNSHIELD_ followed by the setting's path in upper snake
+// case: DNSHIELD_DNS_CACHE_SIZE for dns.cacheSize. Other DNSHIELD_
+// variables, such as DNSHIELD_API_KEY, are left alone.
+func (c *Config) ApplyEnv(environ []string) error {
+	paths := envSettings()
+	var names []string
+	values := make(map[string]string)
+	for _, entry := range environ {
+		name, value, ok := strings.Cut(entry, "=")
+		if !ok || !strings.HasPrefix(name, EnvPrefix) {
+			continue
+		}
+		if _, ok := paths[name]; ok {
+			names = append(names, name)
+			values[name] = value
+		}
+	}
+
+	sort.Strings(names)
+	for _, name := range names {
+		if err := c.Set(paths[name], values[name]); err != nil {
+			return fmt.Errorf("%s: %v", name, err)
+		}
+	}
+	return nil
+}
+
+// EnvName returns the environment variable that overrides the setting at
+// path
+func EnvName(path string) string {
+	var b strings.Builder
+	b.WriteString(EnvPrefix)
+	for i, part := range strings.Split(path, ".") {
+		if i > 0 {
+			b.WriteByte('_')
+		}
+		runes := []rune(part)
+		for j, r := range runes {
+			// A word starts at an upper case letter after a lower case one
+			// or digit, or at the last letter of an acronym: cacheTTL,
+			// s3Key, TTLValue (but allowedUIDs)
+			if j > 0 && unicode.IsUpper(r) && (!unicode.IsUpper(runes[j-1]) || startsWord(runes[j+1:])) {
+				b.WriteByte('_')
+			}
+			b.WriteRune(unicode.ToUpper(r))
+		}
+	}
+	return b.String()
+}
+
+// startsWord reports whether the rest of a name after an upper case letter
+// makes it the start of a word rather than the end of an acronym
+func startsWord(rest []rune) bool {
+	return len(rest) > 0 && unicode.IsLower(rest[0]) && string(rest) != "s"
+}
+
+// envSettings maps the environment variable of every setting to its path
+func envSettings() map[string]string {
+	paths := make(map[string]string)
+	for _, path := range settingPaths() {
+		paths[EnvName(path)] = path
+	}
+	return paths
+}
+
+// settingPaths returns the path of every setting: the fields that aren't
+// sections of other settings
+func settingPaths() []string {
+	var paths []string
+	var walk func(t reflect.Type, prefix string)
+	walk = func(t reflect.Type, prefix string) {
+		for i := 0; i < t.NumField(); i++ {
+			field := t.Field(i)
+			name, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+			if !field.IsExported() || name == "-" {
+				continue
+			}
+			if strings.Contains(opts, "inline") {
+				walk(field.Type, prefix)
+				continue
+			}
+			if name == "" {
+				name = strings.ToLower(field.Name)
+			}
+			if field.Type.Kind() == reflect.Struct {
+				walk(field.Type, prefix+name+".")
+				continue
+			}
+			paths = append(paths, prefix+name)
+		}
+	}
+	walk(reflect.TypeOf(Config{}), "")
+	return paths
+}
+
+// settingField returns the field of the config struct v at path
+func settingField(v reflect.Value, path string) (reflect.Value, error) {
+	for _, part := range strings.Split(path, ".") {
+		if v.Kind() != reflect.Struct {
+			return reflect.Value{}, fmt.Errorf("unknown setting %q", path)
+		}
+		field, ok := structField(v, part)
+		if !ok {
+			return reflect.Value{}, fmt.Errorf("unknown setting %q", path)
+		}
+		v = field
+	}
+	return v, nil
+}
+
+// structField returns the field of struct v with the YAML name, looking
+// into inlined structs
+func structField(v reflect.Value, name string) (reflect.Value, bool) {
+	t := v.Type()
+	for i := 0; i < t.NumField(); i++ {
+		field := t.Field(i)
+		tag, opts, _ := strings.Cut(field.Tag.Get("yaml"), ",")
+		if !field.IsExported() || tag == "-" {
+			continue
+		}
+		if strings.Contains(opts, "inline") {
+			if inner, ok := structField(v.Field(i), name); ok {
+				return inner, true
+			}
+			continue
+		}
+		if tag == "" {
+			tag = strings.ToLower(field.Name)
+		}
+		if tag == name {
+			return v.Field(i), true
+		}
+	}
+	return reflect.Value{}, false
+}
@@ -0,0 +1,134 @@
+package config
+
+import (
+	"reflect"
+	"testing"
+	"time"
+)
+
+func TestEnvName(t *testing.T) {
+	tests := map[string]string{
+		"dns.cacheSize":                   "DNSHIELD_DNS_CACHE_SIZE",
+		"dns.cacheTTL":                    "DNSHIELD_DNS_CACHE_TTL",
+		"s3.accessKeyId":                  "DNSHIELD_S3_ACCESS_KEY_ID",
+		"captivePortal.allowManualBypass": "DNSHIELD_CAPTIVE_PORTAL_ALLOW_MANUAL_BYPASS",
+		"api.socket.allowedUIDs":          "DNSHIELD_API_SOCKET_ALLOWED_UIDS",
+		"incident.token":                  "DNSHIELD_INCIDENT_TOKEN",
+	}
+	for path, want := range tests {
+		if got := EnvName(path); got != want {
+			t.Errorf("EnvName(%s) = %s, want %s", path, got, want)
+		}
+	}
+
+	// Every setting needs a variable of its own
+	names := envSettings()
+	if paths := settingPaths(); len(names) != len(paths) {
+		t.Errorf("%d variables for %d settings", len(names), len(paths))
+	}
+	for _, path := range []string{"dns.cacheSize", "api.rateLimit.requests", "logging.kafka.brokers"} {
+		if names[EnvName(path)] != path {
+			t.Errorf("no variable for %s", path)
+		}
+	}
+}
+
+func TestConfigSet(t *testing.T) {
+	cfg := &Config{}
+	cfg.DNS.ConditionalForwarders = map[string][]string{"old.example.com": {"10.0.0.1"}}
+
+	sets := [][2]string{
+		{"agent.logLevel", "debug"},
+		{"agent.allowDisable", "false"},
+		{"agent.maxPause", "15m"},
+		{"dns.cacheSize", "5000"},
+		{"dns.upstreams", "9.9.9.9, 149.112.112.112"},
+		{"dns.conditionalForwarders", `{corp.example.com: ["10.0.0.53"]}`},
+		{"rules.criticalDomains", `["okta.com", "slack.com"]`},
+		{"api.rateLimit.requests", "20"},
+		{"api.socket.allowedUIDs", "[501, 502]"},
+	}
+	for _, set := range sets {
+		if err := cfg.Set(set[0], set[1]); err != nil {
+			t.Fatalf("Set(%s, %s): %v", set[0], set[1], err)
+		}
+	}
+
+	if cfg.Agent.LogLevel != "debug" || cfg.Agent.AllowDisable || cfg.Agent.MaxPause != 15*time.Minute {
+		t.Errorf("agent = %+v", cfg.Agent)
+	}
+	if cfg.DNS.CacheSize != 5000 || !reflect.DeepEqual(cfg.DNS.Upstreams, []string{"9.9.9.9", "149.112.112.112"}) {
+		t.Errorf("cacheSize = %d, upstreams = %q", cfg.DNS.CacheSize, cfg.DNS.Upstreams)
+	}
+	if want := map[string][]string{"corp.example.com": {"10.0.0.53"}}; !reflect.DeepEqual(cfg.DNS.ConditionalForwarders, want) {
+		t.Errorf("conditional forwarders = %v, want them replaced with %v", cfg.DNS.ConditionalForwarders, want)
+	}
+	if !reflect.DeepEqual(cfg.Rules.CriticalDomains, []string{"okta.com", "slack.com"}) {
+		t.Errorf("critical domains = %q", cfg.Rules.CriticalDomains)
+	}
+	if cfg.API.RateLimit.Requests != 20 || !reflect.DeepEqual(cfg.API.Socket.AllowedUIDs, []uint32{501, 502}) {
+		t.Errorf("api = %+v", cfg.API)
+	}
+
+	if err := cfg.Set("dns.cacheSizes", "1"); err == nil {
+		t.Error("expected an unknown setting to be rejected")
+	}
+	if err := cfg.Set("dns", "1"); err == nil {
+		t.Error("expected a section to be rejected as a value")
+	}
+	if err := cfg.Set("dns.cacheSize", "lots"); err == nil {
+		t.Error("expected an invalid number to be rejected")
+	}
+}
+
+func TestApplyEnv(t *testing.T) {
+	cfg := &Config{}
+	err := cfg.ApplyEnv([]string{
+		"DNSHIELD_DNS_UPSTREAMS=1.1.1.1,8.8.8.8",
+		"DNSHIELD_S3_BUCKET=rules-bucket",
+		"DNSHIELD_CAPTIVE_PORTAL_ENABLED=true",
+		"DNSHIELD_API_KEY=not-a-setting",
+		"PATH=/usr/bin",
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.DNS.Upstreams, []string{"1.1.1.1", "8.8.8.8"}) || cfg.S3.Bucket != "rules-bucket" || !cfg.CaptivePortal.Enabled {
+		t.Errorf("upstreams = %q, bucket = %q, captive portal = %v", cfg.DNS.Upstreams, cfg.S3.Bucket, cfg.CaptivePortal.Enabled)
+	}
+
+	if err := cfg.ApplyEnv([]string{"DNSHIELD_DNS_CACHE_SIZE=lots"}); err == nil {
+		t.Error("expected an invalid value to be rejected")
+	}
+}
+
+func TestApplyDerived(t *testing.T) {
+	cfg := &Config{}
+	if err := cfg.Set("rules.localDir", "/var/dnshield/rules"); err != nil {
+		t.Fatal(err)
+	}
+	cfg.ApplyDerived()
+	if want := LocalRulesURL("/var/dnshield/rules"); cfg.S3.URL != want {
+		t.Errorf("s3.url = %q, want %q", cfg.S3.URL, want)
+	}
+
+	// A later rules.localDir replaces the derived s3.url
+	cfg.Set("rules.localDir", "/opt/rules")
+	cfg.ApplyDerived()
+	if want := LocalRulesURL("/opt/rules"); cfg.S3.URL != want {
+		t.Errorf("s3.url = %q, want %q", cfg.S3.URL, want)
+	}
+	cfg.Set("rules.localDir", "")
+	cfg.ApplyDerived()
+	if cfg.S3.URL != "" {
+		t.Errorf("s3.url = %q after clearing rules.localDir", cfg.S3.URL)
+	}
+
+	// An explicit s3.url is left for validation to reject
+	cfg.Set("s3.url", "https://rules.example.com")
+	cfg.Set("rules.localDir", "/opt/rules")
+	cfg.ApplyDerived()
+	if cfg.S3.URL != "https://rules.example.com" {
+		t.Errorf("s3.url = %q, want the explicit URL", cfg.S3.URL)
+	}
+}
@@ -1,8 +1,17 @@
 package config
 
 import (
+	"crypto/ed25519"
+	"encoding/base32"
+	"encoding/base64"
 	"fmt"
+	"net"
 	"net/url"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"dnshield/internal/utils"
 )
 
 
@@ -14,24 +23,42 @@ func SanitizeConfigForLogging(cfg *Config) map[string]interface{} {
 	agent := make(map[string]interface{})
 	agent["log_level"] = cfg.Agent.LogLevel
 	agent["allow_disable"] = cfg.Agent.AllowDisable
+	agent["max_pause"] = cfg.Agent.MaxPause.String()
 	agent["dns_port"] = cfg.Agent.DNSPort
+	agent["enforcement"] = cfg.Agent.Enforcement
 	sanitized["agent"] = agent
 
 	// DNS configuration
 	dns := make(map[string]interface{})
 	dns["upstreams"] = cfg.DNS.Upstreams
+	dns["strategy"] = cfg.DNS.Strategy
+	dns["local_records"] = len(cfg.DNS.LocalRecords)
+	dns["client_groups"] = cfg.DNS.ClientGroups
+	dns["conditional_forwarders"] = cfg.DNS.ConditionalForwarders
 	dns["cache_size"] = cfg.DNS.CacheSize
 	dns["cache_ttl"] = cfg.DNS.CacheTTL
+	dns["negative_cache_ttl"] = cfg.DNS.NegativeCacheTTL
+	dns["serve_stale"] = cfg.DNS.ServeStale
 	dns["rate_limit_queries"] = cfg.DNS.RateLimitQueries
 	dns["rate_limit_window"] = cfg.DNS.RateLimitWindow
+	dns["server_identity_mode"] = cfg.DNS.ServerIdentity.Mode
+	dns["tunneling"] = cfg.DNS.Tunneling.Enabled
+	dns["cache_snapshot"] = cfg.DNS.CacheSnapshot.Enabled
+	dns["prefetch"] = cfg.DNS.Prefetch
+	dns["ecs_mode"] = cfg.DNS.ECS.Mode
+	dns["case_randomization"] = cfg.DNS.CaseRandomization
 	sanitized["dns"] = dns
 
 	// S3 configuration (sanitized)
-	if cfg.S3.Bucket != "" {
+	if cfg.S3.Configured() {
 		s3 := make(map[string]interface{})
+		s3["url"] = cfg.S3.URL
 		s3["bucket"] = cfg.S3.Bucket
 		s3["region"] = cfg.S3.Region
 		s3["update_interval"] = cfg.S3.UpdateInterval
+		s3["stale_after"] = cfg.S3.StaleAfter
+		s3["signed_rules"] = len(cfg.S3.SigningKeys) > 0
+		s3["device_identity"] = cfg.S3.DeviceIdentity
 		// Explicitly not including AccessKeyID or SecretKey
 		s3["credentials"] = "[CONFIGURED]"
 		sanitized["s3"] = s3
@@ -53,14 +80,246 @@ func SanitizeConfigForLogging(cfg *Config) map[string]interface{} {
 		s3Log["batch_interval"] = cfg.Logging.S3.BatchInterval
 		logging["s3"] = s3Log
 	}
+	if cfg.Logging.OTLP.Enabled {
+		otlp := make(map[string]interface{})
+		otlp["enabled"] = true
+		otlp["protocol"] = cfg.Logging.OTLP.Protocol
+		otlp["endpoint"] = "[CONFIGURED]"
+		otlp["headers"] = len(cfg.Logging.OTLP.Headers)
+		otlp["trace_sample_rate"] = cfg.Logging.OTLP.TraceSampleRate
+		logging["otlp"] = otlp
+	}
+	logging["privacy"] = cfg.Logging.Privacy
+	logging["retention"] = cfg.Logging.Local.Retention.String()
+	if cfg.Logging.Syslog.Enabled {
+		syslog := make(map[string]interface{})
+		syslog["enabled"] = true
+		syslog["target"] = "local"
+		if cfg.Logging.Syslog.Target != "" {
+			syslog["target"] = "[CONFIGURED]"
+		}
+		syslog["format"] = cfg.Logging.Syslog.Format
+		syslog["facility"] = cfg.Logging.Syslog.Facility
+		syslog["event_types"] = len(cfg.Logging.Syslog.EventTypes)
+		logging["syslog"] = syslog
+	}
+	if cfg.Logging.Kafka.Enabled {
+		kafka := make(map[string]interface{})
+		kafka["enabled"] = true
+		kafka["brokers"] = len(cfg.Logging.Kafka.Brokers)
+		kafka["audit_topic"] = cfg.Logging.Kafka.AuditTopic
+		kafka["query_topic"] = cfg.Logging.Kafka.QueryTopic
+		kafka["tls"] = cfg.Logging.Kafka.TLS.Enabled
+		kafka["sasl_mechanism"] = cfg.Logging.Kafka.SASL.Mechanism
+		kafka["compression"] = cfg.Logging.Kafka.Compression
+		logging["kafka"] = kafka
+	}
 	sanitized["logging"] = logging
 
 	// Blocking configuration
 	blocking := make(map[string]interface{})
 	blocking["default_action"] = cfg.Blocking.DefaultAction
 	blocking["block_type"] = cfg.Blocking.BlockType
+	blocking["sinkhole_ip"] = cfg.Blocking.SinkholeIP
+	if cfg.Blocking.SinkholeIPv6 != "" {
+		blocking["sinkhole_ipv6"] = cfg.Blocking.SinkholeIPv6
+	}
+	if len(cfg.Blocking.RecordTypes) > 0 {
+		blocking["record_types"] = cfg.Blocking.RecordTypes
+	}
 	sanitized["blocking"] = blocking
 
+	// Rule list limits
+	rules := make(map[string]interface{})
+	rules["max_domains"] = cfg.Rules.MaxDomains
+	rules["max_file_size"] = cfg.Rules.MaxFileSize
+	rules["history_size"] = cfg.Rules.HistorySize
+	rules["local_dir"] = cfg.Rules.LocalDir
+	rules["critical_domains"] = cfg.Rules.CriticalDomains
+	sanitized["rules"] = rules
+
+	// API rate limits
+	apiLimits := make(map[string]interface{})
+	apiLimits["requests"] = cfg.API.RateLimit.Requests
+	apiLimits["window"] = cfg.API.RateLimit.Window
+	apiLimits["burst"] = cfg.API.RateLimit.Burst
+	apiLimits["endpoint_overrides"] = len(cfg.API.RateLimit.Endpoints)
+	apiLimits["role_overrides"] = len(cfg.API.RateLimit.Roles)
+	sanitized["api_rate_limit"] = apiLimits
+	sanitized["api_grpc"] = map[string]interface{}{
+		"enabled": cfg.API.GRPC.Enabled,
+		"socket":  cfg.API.GRPC.Socket,
+	}
+	sanitized["api_socket"] = map[string]interface{}{
+		"enabled":            cfg.API.Socket.Enabled,
+		"path":               cfg.API.Socket.Path,
+		"allowed_uids":       cfg.API.Socket.AllowedUIDs,
+		"allow_console_user": cfg.API.Socket.AllowConsoleUser,
+		"role":               cfg.API.Socket.Role,
+	}
+	sanitized["api_tcp"] = cfg.API.TCP.Enabled
+	if cfg.API.MTLS.Enabled {
+		sanitized["api_mtls"] = map[string]interface{}{
+			"listen":       cfg.API.MTLS.Listen,
+			"client_ca":    cfg.API.MTLS.ClientCA,
+			"server_names": cfg.API.MTLS.ServerNames,
+			"role":         cfg.API.MTLS.Role,
+		}
+	}
+
+	// Query mirroring (target may point at internal infrastructure)
+	if cfg.Mirror.Enabled {
+		mirror := make(map[string]interface{})
+		mirror["target"] = "[CONFIGURED]"
+		mirror["sample_rate"] = cfg.Mirror.SampleRate
+		mirror["include_client_ip"] = cfg.Mirror.IncludeClientIP
+		sanitized["mirror"] = mirror
+	}
+
+	// Trust store monitoring
+	trustStore := make(map[string]interface{})
+	trustStore["enabled"] = cfg.TrustStore.Enabled
+	trustStore["interval"] = cfg.TrustStore.Interval.String()
+	trustStore["webhook_configured"] = cfg.TrustStore.WebhookURL != ""
+	sanitized["trust_store"] = trustStore
+
+	sanitized["transparent_proxy"] = cfg.TransparentProxy.Enabled
+
+	// Managed policy
+	if cfg.ManagedPolicy.Enabled {
+		managed := make(map[string]interface{})
+		managed["source"] = cfg.ManagedPolicy.Source
+		managed["refresh_interval"] = cfg.ManagedPolicy.RefreshInterval.String()
+		sanitized["managed_policy"] = managed
+	}
+
+	// Query log
+	if cfg.QueryLog.Enabled {
+		querylog := make(map[string]interface{})
+		querylog["retention"] = cfg.QueryLog.Retention.String()
+		querylog["max_size_mb"] = cfg.QueryLog.MaxSizeMB
+		sanitized["query_log"] = querylog
+	}
+
+	// Threat-intel feeds (URLs may point at internal infrastructure)
+	if cfg.ThreatIntel.Enabled {
+		threatIntel := make(map[string]interface{})
+		threatIntel["update_interval"] = cfg.ThreatIntel.UpdateInterval.String()
+		feeds := make([]string, 0, len(cfg.ThreatIntel.Feeds))
+		for _, feed := range cfg.ThreatIntel.Feeds {
+			feeds = append(feeds, feed.Name+" ("+feed.Type+")")
+		}
+		threatIntel["feeds"] = feeds
+		sanitized["threat_intel"] = threatIntel
+	}
+
+	// Newly registered domains
+	if cfg.NRD.Enabled {
+		nrd := make(map[string]interface{})
+		nrd["max_age"] = cfg.NRD.MaxAge.String()
+		nrd["update_interval"] = cfg.NRD.UpdateInterval.String()
+		nrd["allow"] = len(cfg.NRD.Allow)
+		sanitized["nrd"] = nrd
+	}
+
+	// DGA detection
+	if cfg.DGA.Enabled {
+		dga := make(map[string]interface{})
+		dga["mode"] = cfg.DGA.Mode
+		dga["threshold"] = cfg.DGA.Threshold
+		dga["min_length"] = cfg.DGA.MinLength
+		sanitized["dga"] = dga
+	}
+
+	// Homograph detection
+	if cfg.Homograph.Enabled {
+		homograph := make(map[string]interface{})
+		homograph["mode"] = cfg.Homograph.Mode
+		homograph["brands"] = cfg.Homograph.Brands
+		sanitized["homograph"] = homograph
+	}
+
+	// pf DNS redirect
+	if cfg.Firewall.RedirectDNS {
+		sanitized["firewall"] = map[string]interface{}{
+			"redirect_dns": cfg.Firewall.RedirectDNS,
+			"block_dot":    cfg.Firewall.BlockDoT,
+		}
+	}
+
+	// Block page certificates
+	sanitized["proxy"] = map[string]interface{}{
+		"key_algorithm": cfg.Proxy.KeyAlgorithm,
+		"key_pool_size": cfg.Proxy.KeyPoolSize,
+	}
+
+	// Access requests
+	if cfg.Unblock.Enabled {
+		sanitized["unblock"] = map[string]interface{}{
+			"webhook_configured":    cfg.Unblock.WebhookURL != "",
+			"s3_prefix":             cfg.Unblock.S3Prefix,
+			"approvals":             cfg.Unblock.ApprovalPublicKey != "",
+			"max_allow_duration":    cfg.Unblock.MaxAllowDuration.String(),
+			"max_requests_per_hour": cfg.Unblock.MaxRequestsPerHour,
+		}
+	}
+
+	// Self-service bypass
+	if cfg.DomainBypass.Enabled {
+		sanitized["domain_bypass"] = map[string]interface{}{
+			"max_duration":        cfg.DomainBypass.MaxDuration.String(),
+			"require_code":        cfg.DomainBypass.RequireCode,
+			"max_per_day":         cfg.DomainBypass.MaxPerDay,
+			"excluded_categories": cfg.DomainBypass.ExcludedCategories,
+		}
+	}
+
+	// Local allow and block entries
+	if cfg.LocalOverrides.Enabled {
+		sanitized["local_overrides"] = map[string]interface{}{
+			"allowed_domains":     cfg.LocalOverrides.AllowedDomains,
+			"excluded_categories": cfg.LocalOverrides.ExcludedCategories,
+			"max_entries":         cfg.LocalOverrides.MaxEntries,
+		}
+	}
+
+	// Maintenance windows
+	if len(cfg.MaintenanceWindows) > 0 {
+		windows := make([]map[string]interface{}, 0, len(cfg.MaintenanceWindows))
+		for _, w := range cfg.MaintenanceWindows {
+			windows = append(windows, map[string]interface{}{
+				"name":     w.Name,
+				"timezone": w.Timezone,
+				"days":     w.Days,
+				"start":    w.Start,
+				"end":      w.End,
+				"mode":     w.Mode,
+			})
+		}
+		sanitized["maintenance_windows"] = windows
+	}
+
+	// Identity provider users
+	if cfg.OIDC.Enabled {
+		sanitized["oidc"] = map[string]interface{}{
+			"issuer":        cfg.OIDC.Issuer,
+			"client_id":     cfg.OIDC.ClientID,
+			"groups_claim":  cfg.OIDC.GroupsClaim,
+			"group_mapping": cfg.OIDC.GroupMapping,
+		}
+	}
+
+	// Incident ticketing
+	if cfg.Incident.Enabled {
+		incident := make(map[string]interface{})
+		incident["provider"] = cfg.Incident.Provider
+		incident["url"] = "[CONFIGURED]"
+		incident["categories"] = cfg.Incident.Categories
+		incident["threshold"] = cfg.Incident.Threshold
+		incident["window"] = cfg.Incident.Window.String()
+		sanitized["incident"] = incident
+	}
+
 	// Test domains
 	if len(cfg.TestDomains) > 0 {
 		sanitized["test_domains_count"] = len(cfg.TestDomains)
@@ -75,6 +334,12 @@ func ValidateConfig(cfg *Config) error {
 	if cfg.Agent.DNSPort == 0 {
 		cfg.Agent.DNSPort = 53 // Default
 	}
+	if cfg.Agent.MaxPause < 0 {
+		return fmt.Errorf("agent.maxPause cannot be negative")
+	}
+	if cfg.Agent.Enforcement != "" && cfg.Agent.Enforcement != EnforcementBlock && cfg.Agent.Enforcement != EnforcementMonitor {
+		return fmt.Errorf("invalid agent.enforcement: %q (must be block or monitor)", cfg.Agent.Enforcement)
+	}
 
 	if len(cfg.DNS.Upstreams) == 0 {
 		return fmt.Errorf("no DNS upstreams configured")
@@ -86,19 +351,558 @@ func ValidateConfig(cfg *Config) error {
 			return fmt.Errorf("empty DNS upstream configured")
 		}
 	}
+	for suffix, upstreams := range cfg.DNS.ConditionalForwarders {
+		if strings.TrimSpace(suffix) == "" || len(upstreams) == 0 {
+			return fmt.Errorf("invalid dns.conditionalForwarders entry %q: needs a domain and at least one upstream", suffix)
+		}
+		for _, upstream := range upstreams {
+			if upstream == "" {
+				return fmt.Errorf("empty upstream in dns.conditionalForwarders[%q]", suffix)
+			}
+		}
+	}
+	for _, clientGroup := range cfg.DNS.ClientGroups {
+		if _, _, err := net.ParseCIDR(clientGroup.CIDR); err != nil {
+			return fmt.Errorf("invalid dns.clientGroups cidr %q: %v", clientGroup.CIDR, err)
+		}
+		if clientGroup.Group == "" || strings.ContainsAny(clientGroup.Group, "/\\") || strings.Contains(clientGroup.Group, "..") {
+			return fmt.Errorf("invalid dns.clientGroups group %q for %s", clientGroup.Group, clientGroup.CIDR)
+		}
+	}
+	for _, record := range cfg.DNS.LocalRecords {
+		if record.Name == "" || record.Value == "" {
+			return fmt.Errorf("invalid dns.localRecords entry: name and value are required")
+		}
+		switch strings.ToUpper(record.Type) {
+		case "A", "AAAA", "CNAME":
+		default:
+			return fmt.Errorf("invalid dns.localRecords type for %s: %q (must be A, AAAA or CNAME)", record.Name, record.Type)
+		}
+		if record.TTL < 0 {
+			return fmt.Errorf("invalid dns.localRecords ttl for %s: %v", record.Name, record.TTL)
+		}
+	}
+	switch cfg.DNS.Strategy {
+	case "", "sequential", "race", "random":
+	default:
+		return fmt.Errorf("invalid dns.strategy: %q (must be sequential, race or random)", cfg.DNS.Strategy)
+	}
 
 	// Validate S3 configuration if present
-	if cfg.S3.Bucket != "" {
-		if cfg.S3.Region == "" {
-			return fmt.Errorf("S3 bucket configured but region not specified")
+	if cfg.Rules.LocalDir != "" {
+		if !filepath.IsAbs(cfg.Rules.LocalDir) {
+			return fmt.Errorf("rules.localDir must be an absolute path")
+		}
+		if cfg.S3.URL != LocalRulesURL(cfg.Rules.LocalDir) {
+			return fmt.Errorf("rules.localDir can't be combined with s3.bucket or s3.url")
+		}
+	} else if cfg.S3.URL != "" {
+		u, err := url.Parse(cfg.S3.URL)
+		if err != nil || u.Host == "" {
+			return fmt.Errorf("invalid s3.url: %q", cfg.S3.URL)
+		}
+		switch u.Scheme {
+		case "s3":
+			if cfg.S3.Region == "" {
+				return fmt.Errorf("S3 bucket configured but region not specified")
+			}
+		case "gs", "azblob", "https", "git+https", "git+ssh":
+		default:
+			return fmt.Errorf("invalid s3.url scheme: %q (must be s3, gs, azblob, https, git+https or git+ssh)", u.Scheme)
+		}
+	} else if cfg.S3.Bucket != "" && cfg.S3.Region == "" {
+		return fmt.Errorf("S3 bucket configured but region not specified")
+	}
+	if cfg.S3.Configured() {
+		if cfg.S3.StaleAfter <= 0 {
+			return fmt.Errorf("s3.staleAfter must be positive")
+		}
+	}
+	for _, encoded := range cfg.S3.SigningKeys {
+		key, err := base64.StdEncoding.DecodeString(encoded)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return fmt.Errorf("s3.signingKeys must be base64 Ed25519 public keys")
 		}
 	}
+	seenIdentity := make(map[string]bool)
+	for _, kind := range cfg.S3.DeviceIdentity {
+		switch kind {
+		case "serial", "mdm", "consoleUser", "hostname":
+		default:
+			return fmt.Errorf("invalid s3.deviceIdentity entry: %q (must be serial, mdm, consoleUser or hostname)", kind)
+		}
+		if seenIdentity[kind] {
+			return fmt.Errorf("s3.deviceIdentity lists %q twice", kind)
+		}
+		seenIdentity[kind] = true
+	}
 
 	// Validate rate limiting
 	if cfg.DNS.RateLimitQueries < 0 {
 		return fmt.Errorf("invalid rate limit queries: %d", cfg.DNS.RateLimitQueries)
 	}
+
+	// Validate cache lifetimes
+	if cfg.DNS.NegativeCacheTTL < 0 {
+		return fmt.Errorf("invalid dns.negativeCacheTTL: %v", cfg.DNS.NegativeCacheTTL)
+	}
+	if cfg.DNS.ServeStale < 0 {
+		return fmt.Errorf("invalid dns.serveStale: %v", cfg.DNS.ServeStale)
+	}
+
+	// Validate manual captive portal bypass limits
+	if cfg.CaptivePortal.ManualBypassMaxDuration < 0 || cfg.CaptivePortal.ManualBypassMaxDuration > time.Hour {
+		return fmt.Errorf("invalid captivePortal.manualBypassMaxDuration: %v (must be at most 1h)", cfg.CaptivePortal.ManualBypassMaxDuration)
+	}
+	if cfg.CaptivePortal.ManualBypassMaxPerHour < 0 {
+		return fmt.Errorf("invalid captivePortal.manualBypassMaxPerHour: %d", cfg.CaptivePortal.ManualBypassMaxPerHour)
+	}
+
+	// Validate server identity handling
+	switch cfg.DNS.ServerIdentity.Mode {
+	case "", "refuse", "answer":
+	default:
+		return fmt.Errorf("invalid dns.serverIdentity.mode: %q (must be refuse or answer)", cfg.DNS.ServerIdentity.Mode)
+	}
+
+	// Validate EDNS Client Subnet handling
+	switch cfg.DNS.ECS.Mode {
+	case "", ECSModeForward, ECSModeStrip:
+	case ECSModeInject:
+		if _, _, err := net.ParseCIDR(cfg.DNS.ECS.Subnet); err != nil {
+			return fmt.Errorf("invalid dns.ecs.subnet: %q (inject mode needs a prefix such as 198.51.100.0/24)", cfg.DNS.ECS.Subnet)
+		}
+	default:
+		return fmt.Errorf("invalid dns.ecs.mode: %q (must be forward, strip or inject)", cfg.DNS.ECS.Mode)
+	}
+
+	// Validate prefetching
+	if cfg.DNS.Prefetch && (cfg.DNS.PrefetchTopN < 1 || cfg.DNS.PrefetchTopN > 10000) {
+		return fmt.Errorf("invalid dns.prefetchTopN: %d (must be between 1 and 10000)", cfg.DNS.PrefetchTopN)
+	}
+
+	// Validate cache snapshots
+	if cfg.DNS.CacheSnapshot.Enabled {
+		if cfg.DNS.CacheSnapshot.Path == "" {
+			return fmt.Errorf("dns.cacheSnapshot enabled but no path configured")
+		}
+		if cfg.DNS.CacheSnapshot.Interval < 0 {
+			return fmt.Errorf("invalid dns.cacheSnapshot.interval: %v", cfg.DNS.CacheSnapshot.Interval)
+		}
+	}
+
+	// Validate tunneling detection
+	if cfg.DNS.Tunneling.Enabled {
+		tunneling := cfg.DNS.Tunneling
+		if tunneling.Window <= 0 {
+			return fmt.Errorf("invalid dns.tunneling.window: %v", tunneling.Window)
+		}
+		if tunneling.MinQueries < 1 {
+			return fmt.Errorf("invalid dns.tunneling.minQueries: %d", tunneling.MinQueries)
+		}
+		if tunneling.UniqueRatio <= 0 || tunneling.UniqueRatio > 1 {
+			return fmt.Errorf("invalid dns.tunneling.uniqueRatio: %v (must be greater than 0 and at most 1)", tunneling.UniqueRatio)
+		}
+		if tunneling.TXTRatio <= 0 || tunneling.TXTRatio > 1 {
+			return fmt.Errorf("invalid dns.tunneling.txtRatio: %v (must be greater than 0 and at most 1)", tunneling.TXTRatio)
+		}
+		if tunneling.Refuse && tunneling.Cooldown <= 0 {
+			return fmt.Errorf("invalid dns.tunneling.cooldown: %v", tunneling.Cooldown)
+		}
+	}
 	
+	// Validate rule list limits
+	if cfg.Rules.MaxDomains <= 0 || cfg.Rules.MaxDomains > utils.MaxConfigurableDomains {
+		return fmt.Errorf("invalid rules.maxDomains: %d (must be between 1 and %d)", cfg.Rules.MaxDomains, utils.MaxConfigurableDomains)
+	}
+	if cfg.Rules.MaxFileSize <= 0 || cfg.Rules.MaxFileSize > utils.MaxConfigurableRulesFileSize {
+		return fmt.Errorf("invalid rules.maxFileSize: %d (must be between 1 and %d)", cfg.Rules.MaxFileSize, utils.MaxConfigurableRulesFileSize)
+	}
+	if cfg.Rules.HistorySize < 1 {
+		return fmt.Errorf("invalid rules.historySize: %d (must be at least 1)", cfg.Rules.HistorySize)
+	}
+
+	// Validate API rate limits
+	if err := validateAPIRateLimit("api.rateLimit", cfg.API.RateLimit.APIRateLimit, true); err != nil {
+		return err
+	}
+	for path, limit := range cfg.API.RateLimit.Endpoints {
+		if !strings.HasPrefix(path, "/api/") {
+			return fmt.Errorf("invalid api.rateLimit.endpoints key %q: must be an /api/ path", path)
+		}
+		if err := validateAPIRateLimit("api.rateLimit.endpoints."+path, limit, false); err != nil {
+			return err
+		}
+	}
+	for _, proxy := range cfg.API.RateLimit.TrustedProxies {
+		if net.ParseIP(proxy) == nil {
+			if _, _, err := net.ParseCIDR(proxy); err != nil {
+				return fmt.Errorf("invalid api.rateLimit.trustedProxies entry %q: must be an IP address or CIDR range", proxy)
+			}
+		}
+	}
+	for role, limit := range cfg.API.RateLimit.Roles {
+		switch role {
+		case "admin", "operator", "viewer":
+		default:
+			return fmt.Errorf("invalid api.rateLimit.roles key %q: must be admin, operator or viewer", role)
+		}
+		if err := validateAPIRateLimit("api.rateLimit.roles."+role, limit, false); err != nil {
+			return err
+		}
+	}
+
+	if cfg.API.GRPC.Enabled && !filepath.IsAbs(cfg.API.GRPC.Socket) {
+		return fmt.Errorf("invalid api.grpc.socket %q: must be an absolute path", cfg.API.GRPC.Socket)
+	}
+	if cfg.API.Socket.Enabled {
+		if !filepath.IsAbs(cfg.API.Socket.Path) {
+			return fmt.Errorf("invalid api.socket.path %q: must be an absolute path", cfg.API.Socket.Path)
+		}
+		switch cfg.API.Socket.Role {
+		case "", "admin", "operator", "viewer":
+		default:
+			return fmt.Errorf("invalid api.socket.role %q: must be admin, operator, viewer or empty", cfg.API.Socket.Role)
+		}
+	}
+	if cfg.API.MTLS.Enabled {
+		if _, _, err := net.SplitHostPort(cfg.API.MTLS.Listen); err != nil {
+			return fmt.Errorf("invalid api.mtls.listen %q: %v", cfg.API.MTLS.Listen, err)
+		}
+		if cfg.API.MTLS.ClientCA == "" {
+			return fmt.Errorf("api.mtls enabled but no clientCA configured")
+		}
+		switch cfg.API.MTLS.Role {
+		case "", "admin", "operator", "viewer":
+		default:
+			return fmt.Errorf("invalid api.mtls.role %q: must be admin, operator, viewer or empty", cfg.API.MTLS.Role)
+		}
+	}
+
+	// Validate query mirroring
+	if cfg.Mirror.Enabled {
+		if cfg.Mirror.Target == "" {
+			return fmt.Errorf("mirror enabled but no target configured")
+		}
+		if cfg.Mirror.SampleRate <= 0 || cfg.Mirror.SampleRate > 1 {
+			return fmt.Errorf("invalid mirror.sampleRate: %v (must be greater than 0 and at most 1)", cfg.Mirror.SampleRate)
+		}
+		if cfg.Mirror.QueueSize <= 0 || cfg.Mirror.QueueSize > utils.MaxMirrorQueueSize {
+			return fmt.Errorf("invalid mirror.queueSize: %d (must be between 1 and %d)", cfg.Mirror.QueueSize, utils.MaxMirrorQueueSize)
+		}
+	}
+
+	// Validate trust store monitoring
+	if cfg.TrustStore.Enabled {
+		if cfg.TrustStore.Interval < time.Minute {
+			return fmt.Errorf("invalid trustStore.interval: %v (must be at least 1m)", cfg.TrustStore.Interval)
+		}
+		if cfg.TrustStore.WebhookURL != "" {
+			u, err := url.Parse(cfg.TrustStore.WebhookURL)
+			if err != nil {
+				return fmt.Errorf("invalid trustStore.webhookURL: %v", err)
+			}
+			if u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("trustStore.webhookURL must be an https URL")
+			}
+		}
+	}
+
+	// Validate transparent proxy mode
+	if cfg.TransparentProxy.Enabled {
+		if cfg.TransparentProxy.CorrelationTTL <= 0 {
+			return fmt.Errorf("invalid transparentProxy.correlationTTL: %v (must be positive)", cfg.TransparentProxy.CorrelationTTL)
+		}
+		if cfg.TransparentProxy.MaxTrackedIPs < 1 {
+			return fmt.Errorf("invalid transparentProxy.maxTrackedIPs: %d (must be at least 1)", cfg.TransparentProxy.MaxTrackedIPs)
+		}
+	}
+
+	// Validate managed policy
+	if cfg.ManagedPolicy.Enabled {
+		switch cfg.ManagedPolicy.Source {
+		case "file":
+			if cfg.ManagedPolicy.Path == "" {
+				return fmt.Errorf("managedPolicy.path is required for the file source")
+			}
+		case "s3":
+			if !cfg.S3.Configured() || cfg.ManagedPolicy.S3Key == "" {
+				return fmt.Errorf("managedPolicy.s3Key and s3.bucket (or s3.url) are required for the s3 source")
+			}
+		default:
+			return fmt.Errorf("invalid managedPolicy.source: %q (must be file or s3)", cfg.ManagedPolicy.Source)
+		}
+		if cfg.ManagedPolicy.RefreshInterval < time.Minute {
+			return fmt.Errorf("invalid managedPolicy.refreshInterval: %v (must be at least 1m)", cfg.ManagedPolicy.RefreshInterval)
+		}
+	}
+
+	// Validate query log
+	if cfg.QueryLog.Enabled {
+		if cfg.QueryLog.Path == "" {
+			return fmt.Errorf("queryLog.path is required")
+		}
+		if cfg.QueryLog.Retention < time.Hour {
+			return fmt.Errorf("invalid queryLog.retention: %v (must be at least 1h)", cfg.QueryLog.Retention)
+		}
+		if cfg.QueryLog.MaxSizeMB < 1 {
+			return fmt.Errorf("invalid queryLog.maxSizeMB: %d (must be at least 1)", cfg.QueryLog.MaxSizeMB)
+		}
+	}
+
+	// Validate incident ticketing
+	if cfg.Incident.Enabled {
+		switch cfg.Incident.Provider {
+		case "servicenow":
+		case "jira":
+			if cfg.Incident.Project == "" {
+				return fmt.Errorf("incident.project is required for Jira")
+			}
+		default:
+			return fmt.Errorf("invalid incident.provider: %q (must be servicenow or jira)", cfg.Incident.Provider)
+		}
+		u, err := url.Parse(cfg.Incident.URL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("incident.url must be an https URL")
+		}
+		if len(cfg.Incident.Categories) == 0 {
+			return fmt.Errorf("incident.categories must not be empty")
+		}
+		if cfg.Incident.Threshold < 1 {
+			return fmt.Errorf("invalid incident.threshold: %d (must be at least 1)", cfg.Incident.Threshold)
+		}
+		if cfg.Incident.Window <= 0 || cfg.Incident.DedupeWindow <= 0 {
+			return fmt.Errorf("incident.window and incident.dedupeWindow must be positive")
+		}
+		if cfg.Incident.MaxTicketsPerHour < 1 {
+			return fmt.Errorf("invalid incident.maxTicketsPerHour: %d (must be at least 1)", cfg.Incident.MaxTicketsPerHour)
+		}
+	}
+
+	// Validate access requests
+	if cfg.Unblock.Enabled {
+		if cfg.Unblock.WebhookURL == "" && cfg.Unblock.S3Prefix == "" {
+			return fmt.Errorf("unblock requires a webhookURL or s3Prefix")
+		}
+		if cfg.Unblock.WebhookURL != "" {
+			u, err := url.Parse(cfg.Unblock.WebhookURL)
+			if err != nil || u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("unblock.webhookURL must be an https URL")
+			}
+		}
+		if cfg.Unblock.ApprovalPublicKey != "" {
+			key, err := base64.StdEncoding.DecodeString(cfg.Unblock.ApprovalPublicKey)
+			if err != nil || len(key) != ed25519.PublicKeySize {
+				return fmt.Errorf("unblock.approvalPublicKey must be a base64 Ed25519 public key")
+			}
+		}
+		if cfg.Unblock.MaxAllowDuration <= 0 {
+			return fmt.Errorf("unblock.maxAllowDuration must be positive")
+		}
+		if cfg.Unblock.MaxRequestsPerHour < 1 {
+			return fmt.Errorf("invalid unblock.maxRequestsPerHour: %d (must be at least 1)", cfg.Unblock.MaxRequestsPerHour)
+		}
+	}
+
+	// Validate self-service bypass
+	if cfg.DomainBypass.Enabled {
+		if cfg.DomainBypass.MaxDuration <= 0 {
+			return fmt.Errorf("domainBypass.maxDuration must be positive")
+		}
+		if cfg.DomainBypass.MaxPerDay < 1 {
+			return fmt.Errorf("invalid domainBypass.maxPerDay: %d (must be at least 1)", cfg.DomainBypass.MaxPerDay)
+		}
+		if cfg.DomainBypass.TOTPSecret != "" {
+			if _, err := base32.StdEncoding.WithPadding(base32.NoPadding).DecodeString(strings.ToUpper(strings.TrimRight(cfg.DomainBypass.TOTPSecret, "="))); err != nil {
+				return fmt.Errorf("domainBypass.totpSecret must be base32")
+			}
+		}
+	}
+
+	// Validate local allow and block entries
+	if cfg.LocalOverrides.Enabled {
+		if cfg.LocalOverrides.MaxEntries < 1 {
+			return fmt.Errorf("invalid localOverrides.maxEntries: %d (must be at least 1)", cfg.LocalOverrides.MaxEntries)
+		}
+		for _, domain := range cfg.LocalOverrides.AllowedDomains {
+			if strings.TrimSpace(domain) == "" || strings.Contains(domain, "*") {
+				return fmt.Errorf("invalid localOverrides.allowedDomains entry: %q", domain)
+			}
+		}
+	}
+
+	// Validate maintenance windows; days and timezones are checked when
+	// the agent starts
+	names := make(map[string]bool)
+	for i := range cfg.MaintenanceWindows {
+		w := &cfg.MaintenanceWindows[i]
+		if w.Name == "" || names[w.Name] {
+			return fmt.Errorf("maintenanceWindows[%d]: name is required and must be unique", i)
+		}
+		names[w.Name] = true
+		if w.Mode == "" {
+			w.Mode = MaintenancePause
+		}
+		if w.Mode != MaintenancePause && w.Mode != MaintenanceMonitor {
+			return fmt.Errorf("maintenance window %s: invalid mode %q (must be pause or monitor)", w.Name, w.Mode)
+		}
+		start, err := time.Parse("15:04", w.Start)
+		if err != nil {
+			return fmt.Errorf("maintenance window %s: start %q is not HH:MM", w.Name, w.Start)
+		}
+		end, err := time.Parse("15:04", w.End)
+		if err != nil {
+			return fmt.Errorf("maintenance window %s: end %q is not HH:MM", w.Name, w.End)
+		}
+		if start.Equal(end) {
+			return fmt.Errorf("maintenance window %s: start and end must differ", w.Name)
+		}
+	}
+
+	// Validate identity provider users
+	if cfg.OIDC.Enabled {
+		if u, err := url.Parse(cfg.OIDC.Issuer); err != nil || u.Scheme != "https" || u.Host == "" {
+			return fmt.Errorf("oidc.issuer must be an https URL")
+		}
+		if cfg.OIDC.ClientID == "" {
+			return fmt.Errorf("oidc.clientId is required")
+		}
+		if cfg.OIDC.EmailClaim == "" || cfg.OIDC.TokenFile == "" {
+			return fmt.Errorf("oidc.emailClaim and oidc.tokenFile must not be empty")
+		}
+		for idpGroup, group := range cfg.OIDC.GroupMapping {
+			if group == "" || strings.ContainsAny(group, "/\\") || strings.Contains(group, "..") {
+				return fmt.Errorf("invalid oidc.groupMapping entry for %q: %q", idpGroup, group)
+			}
+		}
+	}
+
+	// Validate threat-intel feeds
+	if cfg.ThreatIntel.Enabled {
+		if len(cfg.ThreatIntel.Feeds) == 0 {
+			return fmt.Errorf("threatIntel.feeds must not be empty")
+		}
+		if cfg.ThreatIntel.UpdateInterval < time.Minute {
+			return fmt.Errorf("invalid threatIntel.updateInterval: %v (must be at least 1m)", cfg.ThreatIntel.UpdateInterval)
+		}
+		names := make(map[string]bool)
+		for _, feed := range cfg.ThreatIntel.Feeds {
+			if feed.Name == "" || strings.ContainsAny(feed.Name, " \t/") {
+				return fmt.Errorf("invalid threatIntel feed name: %q", feed.Name)
+			}
+			if names[feed.Name] {
+				return fmt.Errorf("duplicate threatIntel feed name: %q", feed.Name)
+			}
+			names[feed.Name] = true
+			switch feed.Type {
+			case "taxii":
+				if feed.Collection == "" {
+					return fmt.Errorf("threatIntel feed %s: collection is required for TAXII", feed.Name)
+				}
+			case "misp":
+			default:
+				return fmt.Errorf("threatIntel feed %s: invalid type %q (must be taxii or misp)", feed.Name, feed.Type)
+			}
+			u, err := url.Parse(feed.URL)
+			if err != nil || u.Scheme != "https" || u.Host == "" {
+				return fmt.Errorf("threatIntel feed %s: url must be an https URL", feed.Name)
+			}
+			if feed.MaxAge < 0 {
+				return fmt.Errorf("threatIntel feed %s: maxAge must not be negative", feed.Name)
+			}
+		}
+	}
+
+	// Validate newly registered domain blocking
+	if cfg.NRD.Enabled {
+		u, err := url.Parse(cfg.NRD.FeedURL)
+		if err != nil || (u.Scheme != "https" && u.Scheme != "http") || u.Host == "" {
+			return fmt.Errorf("nrd.feedURL must be an http or https URL")
+		}
+		if cfg.NRD.MaxAge < 24*time.Hour {
+			return fmt.Errorf("invalid nrd.maxAge: %v (must be at least 24h)", cfg.NRD.MaxAge)
+		}
+		if cfg.NRD.UpdateInterval < time.Hour {
+			return fmt.Errorf("invalid nrd.updateInterval: %v (must be at least 1h)", cfg.NRD.UpdateInterval)
+		}
+		if cfg.NRD.StatePath == "" {
+			return fmt.Errorf("nrd.statePath is required")
+		}
+		if cfg.NRD.MaxDomains < 1 || cfg.NRD.MaxDomains > utils.MaxConfigurableDomains {
+			return fmt.Errorf("invalid nrd.maxDomains: %d (must be between 1 and %d)", cfg.NRD.MaxDomains, utils.MaxConfigurableDomains)
+		}
+	}
+
+	// Validate DGA detection
+	if cfg.DGA.Enabled {
+		if cfg.DGA.Mode != DGAModeMonitor && cfg.DGA.Mode != DGAModeBlock {
+			return fmt.Errorf("invalid dga.mode: %q (must be monitor or block)", cfg.DGA.Mode)
+		}
+		if cfg.DGA.Threshold <= 0 || cfg.DGA.Threshold > 1 {
+			return fmt.Errorf("invalid dga.threshold: %v (must be between 0 and 1)", cfg.DGA.Threshold)
+		}
+		if cfg.DGA.MinLength < 1 {
+			return fmt.Errorf("invalid dga.minLength: %d (must be at least 1)", cfg.DGA.MinLength)
+		}
+	}
+
+	// Validate homograph detection
+	if cfg.Homograph.Enabled {
+		if cfg.Homograph.Mode != HomographModeWarn && cfg.Homograph.Mode != HomographModeBlock {
+			return fmt.Errorf("invalid homograph.mode: %q (must be warn or block)", cfg.Homograph.Mode)
+		}
+		if len(cfg.Homograph.Brands) == 0 {
+			return fmt.Errorf("homograph enabled but no brands configured")
+		}
+		for _, brand := range cfg.Homograph.Brands {
+			if !strings.Contains(brand, ".") || strings.HasPrefix(brand, ".") {
+				return fmt.Errorf("invalid homograph.brands entry %q (must be a domain such as example.com)", brand)
+			}
+		}
+	}
+
+	// Validate the pf DNS redirect
+	if cfg.Firewall.BlockDoT && !cfg.Firewall.RedirectDNS {
+		return fmt.Errorf("firewall.blockDoT requires firewall.redirectDNS")
+	}
+
+	// Validate block page certificates
+	switch cfg.Proxy.KeyAlgorithm {
+	case KeyAlgorithmECDSAP256, KeyAlgorithmRSA2048:
+	default:
+		return fmt.Errorf("invalid proxy.keyAlgorithm: %q (must be ecdsa-p256 or rsa-2048)", cfg.Proxy.KeyAlgorithm)
+	}
+	if cfg.Proxy.KeyPoolSize < 0 || cfg.Proxy.KeyPoolSize > 1000 {
+		return fmt.Errorf("invalid proxy.keyPoolSize: %d (must be between 0 and 1000)", cfg.Proxy.KeyPoolSize)
+	}
+
+	// Validate block responses
+	switch cfg.Blocking.BlockType {
+	case BlockTypeSinkhole, BlockTypeNXDomain, BlockTypeRefused, BlockTypeNullIP:
+	default:
+		return fmt.Errorf("invalid blocking.blockType: %q (must be sinkhole, nxdomain, refused or null_ip)", cfg.Blocking.BlockType)
+	}
+	if ip := net.ParseIP(cfg.Blocking.SinkholeIP); ip == nil || ip.To4() == nil {
+		return fmt.Errorf("invalid blocking.sinkholeIP: %q (must be an IPv4 address)", cfg.Blocking.SinkholeIP)
+	}
+	if cfg.Blocking.SinkholeIPv6 != "" {
+		if ip := net.ParseIP(cfg.Blocking.SinkholeIPv6); ip == nil || ip.To4() != nil {
+			return fmt.Errorf("invalid blocking.sinkholeIPv6: %q (must be an IPv6 address)", cfg.Blocking.SinkholeIPv6)
+		}
+	}
+	for qtype, blockType := range cfg.Blocking.RecordTypes {
+		switch blockType {
+		case BlockTypeSinkhole, BlockTypeNXDomain, BlockTypeRefused, BlockTypeNullIP, BlockTypeNoData:
+		default:
+			return fmt.Errorf("invalid blocking.recordTypes.%s: %q (must be sinkhole, nxdomain, refused, null_ip or nodata)", qtype, blockType)
+		}
+	}
+
+	// Forwarding sinkhole telemetry needs somewhere to send it
+	if cfg.Blocking.SinkholeTelemetry.ForwardToSIEM && !cfg.Logging.Splunk.Enabled {
+		return fmt.Errorf("blocking.sinkholeTelemetry.forwardToSIEM requires logging.splunk to be enabled")
+	}
+
 	// Validate Splunk endpoint if configured
 	if cfg.Logging.Splunk.Enabled && cfg.Logging.Splunk.Endpoint != "" {
 		u, err := url.Parse(cfg.Logging.Splunk.Endpoint)
@@ -117,5 +921,157 @@ func ValidateConfig(cfg *Config) error {
 		}
 	}
 
+	switch cfg.Logging.Privacy {
+	case "", "full", "hashed", "aggregate":
+	default:
+		return fmt.Errorf("invalid logging.privacy: %q (must be full, hashed or aggregate)", cfg.Logging.Privacy)
+	}
+
+	// Validate the remote logging fallback buffer
+	if local := cfg.Logging.Local; local.FallbackPath != "" && local.FallbackMaxSizeMB < 1 {
+		return fmt.Errorf("invalid logging.local.fallbackMaxSizeMB: %d (must be at least 1)", local.FallbackMaxSizeMB)
+	}
+	if retention := cfg.Logging.Local.Retention; retention < 0 || (retention > 0 && retention < time.Hour) {
+		return fmt.Errorf("invalid logging.local.retention: %v (must be 0 or at least 1h)", retention)
+	}
+
+	// Validate OpenTelemetry export
+	if otlp := cfg.Logging.OTLP; otlp.Enabled {
+		if otlp.Protocol != "grpc" && otlp.Protocol != "http" {
+			return fmt.Errorf("invalid logging.otlp.protocol: %q (must be grpc or http)", otlp.Protocol)
+		}
+		if otlp.Endpoint != "" {
+			u, err := url.Parse(otlp.Endpoint)
+			if err != nil || (u.Scheme != "http" && u.Scheme != "https") || u.Host == "" {
+				return fmt.Errorf("invalid logging.otlp.endpoint: %q (must be an http:// or https:// URL)", otlp.Endpoint)
+			}
+		}
+		if otlp.ServiceName == "" {
+			return fmt.Errorf("logging.otlp.serviceName must not be empty")
+		}
+		if otlp.Interval < time.Second {
+			return fmt.Errorf("invalid logging.otlp.interval: %v (must be at least 1s)", otlp.Interval)
+		}
+		if otlp.TraceSampleRate < 0 || otlp.TraceSampleRate > 1 {
+			return fmt.Errorf("invalid logging.otlp.traceSampleRate: %v (must be between 0 and 1)", otlp.TraceSampleRate)
+		}
+		if otlp.QueueSize <= 0 || otlp.QueueSize > utils.MaxTelemetryQueueSize {
+			return fmt.Errorf("invalid logging.otlp.queueSize: %d (must be between 1 and %d)", otlp.QueueSize, utils.MaxTelemetryQueueSize)
+		}
+	}
+
+	// Validate syslog output
+	if syslog := cfg.Logging.Syslog; syslog.Enabled {
+		scheme := ""
+		if syslog.Target != "" {
+			u, err := url.Parse(syslog.Target)
+			if err != nil || (u.Scheme != "udp" && u.Scheme != "tcp" && u.Scheme != "tls") || u.Port() == "" {
+				return fmt.Errorf("invalid logging.syslog.target: %q (must be empty or udp://, tcp:// or tls://host:port)", syslog.Target)
+			}
+			scheme = u.Scheme
+		}
+		switch syslog.Format {
+		case "text", "cef", "leef":
+		default:
+			return fmt.Errorf("invalid logging.syslog.format: %q (must be text, cef or leef)", syslog.Format)
+		}
+		if !validSyslogFacilities[strings.ToLower(syslog.Facility)] {
+			return fmt.Errorf("invalid logging.syslog.facility: %q", syslog.Facility)
+		}
+		if syslog.AppName == "" || strings.ContainsAny(syslog.AppName, " \t\n") {
+			return fmt.Errorf("invalid logging.syslog.appName: %q (must be non-empty without spaces)", syslog.AppName)
+		}
+		if syslog.CAFile != "" && scheme != "tls" {
+			return fmt.Errorf("logging.syslog.caFile requires a tls:// target")
+		}
+		if syslog.QueueSize <= 0 || syslog.QueueSize > utils.MaxSyslogQueueSize {
+			return fmt.Errorf("invalid logging.syslog.queueSize: %d (must be between 1 and %d)", syslog.QueueSize, utils.MaxSyslogQueueSize)
+		}
+	}
+
+	// Validate Kafka sink
+	if kafka := cfg.Logging.Kafka; kafka.Enabled {
+		if len(kafka.Brokers) == 0 {
+			return fmt.Errorf("logging.kafka.brokers must not be empty")
+		}
+		for _, broker := range kafka.Brokers {
+			if host, port, err := net.SplitHostPort(broker); err != nil || host == "" || port == "" {
+				return fmt.Errorf("invalid logging.kafka.brokers entry: %q (must be host:port)", broker)
+			}
+		}
+		if kafka.AuditTopic == "" && kafka.QueryTopic == "" {
+			return fmt.Errorf("logging.kafka needs an auditTopic or queryTopic")
+		}
+		for _, topic := range []string{kafka.AuditTopic, kafka.QueryTopic} {
+			if topic != "" && !validKafkaTopic(topic) {
+				return fmt.Errorf("invalid logging.kafka topic: %q", topic)
+			}
+		}
+		if kafka.TLS.CAFile != "" && !kafka.TLS.Enabled {
+			return fmt.Errorf("logging.kafka.tls.caFile requires tls.enabled")
+		}
+		switch kafka.SASL.Mechanism {
+		case "":
+		case "PLAIN", "SCRAM-SHA-256", "SCRAM-SHA-512":
+			if kafka.SASL.Username == "" {
+				return fmt.Errorf("logging.kafka.sasl.username is required with %s", kafka.SASL.Mechanism)
+			}
+		default:
+			return fmt.Errorf("invalid logging.kafka.sasl.mechanism: %q (must be PLAIN, SCRAM-SHA-256 or SCRAM-SHA-512)", kafka.SASL.Mechanism)
+		}
+		if kafka.Compression != "none" && kafka.Compression != "gzip" {
+			return fmt.Errorf("invalid logging.kafka.compression: %q (must be none or gzip)", kafka.Compression)
+		}
+		if kafka.RequiredAcks != 1 && kafka.RequiredAcks != -1 {
+			return fmt.Errorf("invalid logging.kafka.requiredAcks: %d (must be 1 or -1)", kafka.RequiredAcks)
+		}
+		if kafka.BatchSize <= 0 || kafka.BatchSize > utils.MaxKafkaBatchSize {
+			return fmt.Errorf("invalid logging.kafka.batchSize: %d (must be between 1 and %d)", kafka.BatchSize, utils.MaxKafkaBatchSize)
+		}
+		if kafka.BatchInterval < 10*time.Millisecond {
+			return fmt.Errorf("invalid logging.kafka.batchInterval: %v (must be at least 10ms)", kafka.BatchInterval)
+		}
+		if kafka.QueueSize <= 0 || kafka.QueueSize > utils.MaxKafkaQueueSize {
+			return fmt.Errorf("invalid logging.kafka.queueSize: %d (must be between 1 and %d)", kafka.QueueSize, utils.MaxKafkaQueueSize)
+		}
+	}
+
+	return nil
+}
+
+// validKafkaTopic reports whether name is a legal Kafka topic name
+func validKafkaTopic(name string) bool {
+	if len(name) > 249 || name == "." || name == ".." {
+		return false
+	}
+	for _, r := range name {
+		if !(r >= 'a' && r <= 'z' || r >= 'A' && r <= 'Z' || r >= '0' && r <= '9' || r == '.' || r == '_' || r == '-') {
+			return false
+		}
+	}
+	return true
+}
+
+// validSyslogFacilities are the facility names logging.syslog accepts
+var validSyslogFacilities = map[string]bool{
+	"kern": true, "user": true, "mail": true, "daemon": true, "auth": true,
+	"syslog": true, "lpr": true, "news": true, "uucp": true, "cron": true,
+	"authpriv": true, "ftp": true, "local0": true, "local1": true,
+	"local2": true, "local3": true, "local4": true, "local5": true,
+	"local6": true, "local7": true,
+}
+
+// validateAPIRateLimit checks a rate limit; overrides may leave fields zero
+// to inherit them from the default
+func validateAPIRateLimit(name string, limit APIRateLimit, required bool) error {
+	if limit.Requests < 0 || (required && limit.Requests == 0) {
+		return fmt.Errorf("invalid %s.requests: %d", name, limit.Requests)
+	}
+	if limit.Window < 0 || (required && limit.Window == 0) {
+		return fmt.Errorf("invalid %s.window: %v", name, limit.Window)
+	}
+	if limit.Burst < 0 {
+		return fmt.Errorf("invalid %s.burst: %d", name, limit.Burst)
+	}
 	return nil
 }
\ No newline at end of file
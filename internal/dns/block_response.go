@@ -0,0 +1,146 @@
+package dns
+
+import (
+	"net"
+	"strings"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// defaultBlockTTL is the TTL of sinkhole answers unless blocking.blockTTL
+// is set
+const defaultBlockTTL = 10
+
+// defaultBlockPagePort is the port the block page's HTTPS server listens
+// on unless SetBlockPagePort is called
+const defaultBlockPagePort = 443
+
+// SetBlockPagePort sets the port of the block page's HTTPS server, which
+// sinkholed HTTPS and SVCB answers point clients to
+func (h *Handler) SetBlockPagePort(port int) {
+	if port > 0 && port <= 65535 {
+		h.blockPagePort = port
+	}
+}
+
+// SetBlockResponse sets how blocked queries are answered from the blocking
+// config section. The sinkhole's IPv4 address is the one the handler was
+// created with. Without an IPv6 sinkhole, a loopback IPv4 sinkhole is
+// paired with ::1, where the block page also listens, so clients that
+// prefer IPv6 still reach it.
+func (h *Handler) SetBlockResponse(cfg *config.BlockingConfig) {
+	if cfg.BlockType != "" {
+		h.blockType = cfg.BlockType
+	}
+	if cfg.BlockTTL > 0 {
+		h.blockTTL = uint32(cfg.BlockTTL.Seconds())
+	}
+	h.blockIPv6 = nil
+	if ip := net.ParseIP(cfg.SinkholeIPv6); ip != nil && ip.To4() == nil {
+		h.blockIPv6 = ip
+	} else if cfg.SinkholeIPv6 == "" && h.blockIP.IsLoopback() {
+		h.blockIPv6 = net.IPv6loopback
+	}
+
+	h.blockTypes = make(map[uint16]string, len(cfg.RecordTypes))
+	for name, blockType := range cfg.RecordTypes {
+		qtype, ok := dns.StringToType[strings.ToUpper(name)]
+		if !ok {
+			logrus.WithField("type", name).Warn("Ignoring block type for unknown record type")
+			continue
+		}
+		h.blockTypes[qtype] = blockType
+	}
+}
+
+// blockTypeFor returns how blocked queries of qtype are answered
+func (h *Handler) blockTypeFor(qtype uint16) string {
+	if blockType, ok := h.blockTypes[qtype]; ok {
+		return blockType
+	}
+	return h.blockType
+}
+
+// writeBlockAnswer fills m with the answer to a blocked query. Query types
+// other than A, AAAA, HTTPS and SVCB get an empty answer unless their block
+// type is nxdomain or refused.
+func (h *Handler) writeBlockAnswer(m *dns.Msg, question dns.Question) {
+	var ip net.IP
+	switch h.blockTypeFor(question.Qtype) {
+	case config.BlockTypeNXDomain:
+		m.Rcode = dns.RcodeNameError
+		return
+	case config.BlockTypeRefused:
+		m.Rcode = dns.RcodeRefused
+		return
+	case config.BlockTypeNullIP:
+		switch question.Qtype {
+		case dns.TypeA:
+			ip = net.IPv4zero
+		case dns.TypeAAAA:
+			ip = net.IPv6zero
+		}
+	case config.BlockTypeSinkhole:
+		switch question.Qtype {
+		case dns.TypeA:
+			ip = h.blockIP
+		case dns.TypeAAAA:
+			ip = h.blockIPv6
+		case dns.TypeHTTPS, dns.TypeSVCB:
+			// Clients that ask for HTTPS records first would otherwise
+			// never connect to the block page
+			m.Answer = append(m.Answer, h.sinkholeServiceRecord(question))
+			return
+		}
+	}
+	if ip == nil {
+		// NODATA: the name exists but has no records of this type
+		m.Rcode = dns.RcodeSuccess
+		return
+	}
+
+	hdr := dns.RR_Header{
+		Name:   question.Name,
+		Rrtype: question.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    h.blockTTL,
+	}
+	if question.Qtype == dns.TypeA {
+		m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: ip.To4()})
+	} else {
+		m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+	}
+}
+
+// sinkholeServiceRecord returns an HTTPS or SVCB record for question that
+// sends clients to the block page: the name itself (target "."), with the
+// sinkhole addresses as hints and no ECH config, so the block page's
+// certificate is used
+func (h *Handler) sinkholeServiceRecord(question dns.Question) dns.RR {
+	svcb := dns.SVCB{
+		Hdr: dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: question.Qtype,
+			Class:  dns.ClassINET,
+			Ttl:    h.blockTTL,
+		},
+		Priority: 1,
+		Target:   ".",
+	}
+	// Keys must be in ascending order
+	svcb.Value = append(svcb.Value, &dns.SVCBAlpn{Alpn: []string{"h2", "http/1.1"}})
+	if h.blockPagePort != defaultBlockPagePort {
+		svcb.Value = append(svcb.Value, &dns.SVCBPort{Port: uint16(h.blockPagePort)})
+	}
+	svcb.Value = append(svcb.Value, &dns.SVCBIPv4Hint{Hint: []net.IP{h.blockIP.To4()}})
+	if h.blockIPv6 != nil {
+		svcb.Value = append(svcb.Value, &dns.SVCBIPv6Hint{Hint: []net.IP{h.blockIPv6}})
+	}
+
+	if question.Qtype == dns.TypeHTTPS {
+		return &dns.HTTPS{SVCB: svcb}
+	}
+	return &svcb
+}
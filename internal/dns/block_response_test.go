@@ -0,0 +1,124 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+func TestHandlerBlockResponses(t *testing.T) {
+	query := func(h *Handler, qtype uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("blocked.example.com.", qtype)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+		return w.msg
+	}
+	answerIP := func(m *dns.Msg) net.IP {
+		if len(m.Answer) != 1 {
+			return nil
+		}
+		switch rr := m.Answer[0].(type) {
+		case *dns.A:
+			return rr.A
+		case *dns.AAAA:
+			return rr.AAAA
+		}
+		return nil
+	}
+
+	tests := []struct {
+		name      string
+		cfg       config.BlockingConfig
+		qtype     uint16
+		wantRcode int
+		wantIP    string // Empty for no answer
+	}{
+		{"SinkholeA", config.BlockingConfig{BlockType: config.BlockTypeSinkhole}, dns.TypeA, dns.RcodeSuccess, "127.0.0.1"},
+		{"SinkholeAAAALoopback", config.BlockingConfig{BlockType: config.BlockTypeSinkhole}, dns.TypeAAAA, dns.RcodeSuccess, "::1"},
+		{"SinkholeAAAA", config.BlockingConfig{BlockType: config.BlockTypeSinkhole, SinkholeIPv6: "fd00::53"}, dns.TypeAAAA, dns.RcodeSuccess, "fd00::53"},
+		{"SinkholeAAAANoData", config.BlockingConfig{
+			BlockType:   config.BlockTypeSinkhole,
+			RecordTypes: map[string]string{"AAAA": config.BlockTypeNoData},
+		}, dns.TypeAAAA, dns.RcodeSuccess, ""},
+		{"SinkholeMX", config.BlockingConfig{BlockType: config.BlockTypeSinkhole}, dns.TypeMX, dns.RcodeSuccess, ""},
+		{"NXDomain", config.BlockingConfig{BlockType: config.BlockTypeNXDomain}, dns.TypeA, dns.RcodeNameError, ""},
+		{"Refused", config.BlockingConfig{BlockType: config.BlockTypeRefused}, dns.TypeAAAA, dns.RcodeRefused, ""},
+		{"NullIPA", config.BlockingConfig{BlockType: config.BlockTypeNullIP}, dns.TypeA, dns.RcodeSuccess, "0.0.0.0"},
+		{"NullIPAAAA", config.BlockingConfig{BlockType: config.BlockTypeNullIP}, dns.TypeAAAA, dns.RcodeSuccess, "::"},
+		{"RecordTypeOverride", config.BlockingConfig{
+			BlockType:   config.BlockTypeSinkhole,
+			RecordTypes: map[string]string{"https": config.BlockTypeNXDomain},
+		}, dns.TypeHTTPS, dns.RcodeNameError, ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := newTestHandler(t, "blocked.example.com")
+			tt.cfg.BlockTTL = 30 * time.Second
+			h.SetBlockResponse(&tt.cfg)
+
+			m := query(h, tt.qtype)
+			if m.Rcode != tt.wantRcode {
+				t.Errorf("rcode = %s, want %s", dns.RcodeToString[m.Rcode], dns.RcodeToString[tt.wantRcode])
+			}
+			ip := answerIP(m)
+			switch {
+			case tt.wantIP == "" && len(m.Answer) != 0:
+				t.Errorf("answer = %v, want none", m.Answer)
+			case tt.wantIP != "" && !ip.Equal(net.ParseIP(tt.wantIP)):
+				t.Errorf("answer = %v, want %s", m.Answer, tt.wantIP)
+			case tt.wantIP != "" && m.Answer[0].Header().Ttl != 30:
+				t.Errorf("TTL = %d, want 30", m.Answer[0].Header().Ttl)
+			}
+		})
+	}
+}
+
+func TestHandlerBlockedServiceRecords(t *testing.T) {
+	h := newTestHandler(t, "blocked.example.com")
+	h.SetBlockResponse(&config.BlockingConfig{BlockType: config.BlockTypeSinkhole, SinkholeIPv6: "::1"})
+	h.SetBlockPagePort(8443)
+
+	for _, qtype := range []uint16{dns.TypeHTTPS, dns.TypeSVCB} {
+		req := new(dns.Msg)
+		req.SetQuestion("www.blocked.example.com.", qtype)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+
+		if len(w.msg.Answer) != 1 {
+			t.Fatalf("%s answer = %v, want one record", dns.TypeToString[qtype], w.msg.Answer)
+		}
+		var svcb *dns.SVCB
+		switch rr := w.msg.Answer[0].(type) {
+		case *dns.HTTPS:
+			svcb = &rr.SVCB
+		case *dns.SVCB:
+			svcb = rr
+		}
+		if svcb == nil || svcb.Hdr.Rrtype != qtype || svcb.Priority != 1 || svcb.Target != "." {
+			t.Fatalf("%s answer = %v, want a service-mode record for the name itself", dns.TypeToString[qtype], w.msg.Answer[0])
+		}
+		// The record must survive packing, which checks the key order
+		if _, err := w.msg.Pack(); err != nil {
+			t.Fatalf("Pack: %v", err)
+		}
+		want := `1 . alpn="h2,http/1.1" port="8443" ipv4hint="127.0.0.1" ipv6hint="::1"`
+		if got := svcb.String()[len(svcb.Hdr.String()):]; got != want {
+			t.Errorf("%s record = %q, want %q", dns.TypeToString[qtype], got, want)
+		}
+	}
+
+	// Null answers can't point anywhere
+	h.SetBlockResponse(&config.BlockingConfig{BlockType: config.BlockTypeNullIP})
+	req := new(dns.Msg)
+	req.SetQuestion("blocked.example.com.", dns.TypeHTTPS)
+	w := &testResponseWriter{}
+	h.ServeDNS(w, req)
+	if w.msg.Rcode != dns.RcodeSuccess || len(w.msg.Answer) != 0 {
+		t.Errorf("null_ip HTTPS answer = %v, want NODATA", w.msg)
+	}
+}
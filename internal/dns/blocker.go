@@ -2,9 +2,12 @@ package dns
 
 import (
 	"fmt"
+	"net/url"
 	"strings"
 	"sync"
+	"sync/atomic"
 	
+	"dnshield/internal/config"
 	"dnshield/internal/security"
 	"dnshield/internal/utils"
 	"github.com/sirupsen/logrus"
@@ -12,10 +15,36 @@ import (
 
 // Blocker manages domain blocking
 type Blocker struct {
-	mu             sync.RWMutex
-	blockedDomains map[string]bool
-	allowlist      map[string]bool // Renamed from whitelist
-	allowOnlyMode  bool            // When true, block everything except allowlist
+	// The lists the rules replace as a whole are built off to the side and
+	// swapped in, so queries never wait for an update. updateMu only
+	// keeps updates of them in order.
+	updateMu        sync.Mutex
+	blockedDomains  atomic.Pointer[layeredTrie] // Rule -> source
+	securityDomains atomic.Pointer[compactTrie] // Security-critical (malware/C2) rule -> source
+	threatIntel     atomic.Pointer[compactTrie] // Threat-intel feed indicators, also security-critical
+	allowlist       atomic.Pointer[domainTrie]  // Renamed from whitelist
+
+	mu              sync.RWMutex
+	bypassDomains   *domainTrie     // DoH/DoT endpoints and canaries when bypass prevention is on
+	nrdDomains      *domainTrie     // Newly registered domains
+	nrdExempt       *domainTrie     // Never blocked as newly registered
+	localAllow      *domainTrie     // Allowed on this device through the API
+	localBlock      *domainTrie     // Blocked on this device through the API
+	localExcluded   map[string]bool // Categories local allows don't cover
+	regexRules      []*regexRule
+	categories      map[string]*blockCategory // Blocklist source -> registry category
+	schedules       []*Schedule
+	temporaryAllows map[string]TemporaryAllow // Keyed by domain
+
+	// Signals the Scheduler that schedules were replaced
+	schedulesChanged chan struct{}
+	allowOnlyMode   bool              // When true, block everything except allowlist
+	securityFirst   bool              // When true, security domains override the allowlist
+	safeSearch      bool              // When true, search engines resolve to their SafeSearch names
+	enforcement     string            // Mode set by the rules; empty uses defaultMode
+	defaultMode     string            // Device-wide enforcement mode
+	maintenance     bool              // A maintenance window relaxes blocking to monitor
+	maxDomains      int               // Maximum entries accepted per list update; guarded by updateMu
 
 	// Track metadata for logging
 	userEmail string
@@ -23,12 +52,22 @@ type Blocker struct {
 }
 
 // NewBlocker creates a new domain blocker instance.
-// The blocker maintains thread-safe maps of blocked domains and allowlist entries.
+// The blocker maintains thread-safe tries of blocked domains and allowlist entries.
 func NewBlocker() *Blocker {
 	b := &Blocker{
-		blockedDomains: make(map[string]bool),
-		allowlist:      make(map[string]bool),
+		bypassDomains: newDomainTrie(),
+		nrdDomains:    newDomainTrie(),
+		nrdExempt:     newDomainTrie(),
+		localAllow:    newDomainTrie(),
+		localBlock:    newDomainTrie(),
+		maxDomains:    utils.MaxDomainsPerRule,
+
+		schedulesChanged: make(chan struct{}, 1),
 	}
+	b.blockedDomains.Store(newLayeredTrie(newTrieBuilder(0).Build()))
+	b.securityDomains.Store(newTrieBuilder(0).Build())
+	b.threatIntel.Store(newTrieBuilder(0).Build())
+	b.allowlist.Store(newDomainTrie())
 	
 	// Load default blocking rules for common ad/tracking domains
 	// These provide basic protection even when S3 rules are unavailable
@@ -64,28 +103,40 @@ func (b *Blocker) LoadDefaultRules() {
 		"phishing-test.com",
 	}
 	
-	b.mu.Lock()
-	defer b.mu.Unlock()
-	
+	builder := newTrieBuilder(len(defaultBlockedDomains))
 	for _, domain := range defaultBlockedDomains {
-		b.blockedDomains[domain] = true
+		builder.Add(domain, SourceDefault)
 	}
+
+	b.updateMu.Lock()
+	defer b.updateMu.Unlock()
+	b.blockedDomains.Store(newLayeredTrie(builder.Build()))
 	
 	logrus.WithField("count", len(defaultBlockedDomains)).Info("Loaded default blocking rules")
 }
 
 // UpdateDomains updates the blocked domains list
 func (b *Blocker) UpdateDomains(domains []string) error {
-	b.mu.Lock()
-	defer b.mu.Unlock()
+	return b.UpdateDomainsWithSources(domains, nil)
+}
+
+// UpdateDomainsWithSources updates the blocked domains list, recording where
+// each domain came from so block events can name the originating list.
+// Domains missing from sources are attributed to SourceInline. Entries may
+// be plain domains, "*.example.com" (subdomains only) or "||example.com^".
+// Only the rules that changed are applied, so refreshing a large list that
+// barely changed is cheap, and queries go on matching the old list until
+// the new one is complete.
+func (b *Blocker) UpdateDomainsWithSources(domains []string, sources map[string]string) error {
+	b.updateMu.Lock()
+	defer b.updateMu.Unlock()
 
 	// Check domain count limit
-	if len(domains) > utils.MaxDomainsPerRule {
-		return fmt.Errorf("domain count %d exceeds maximum of %d", len(domains), utils.MaxDomainsPerRule)
+	if len(domains) > b.maxDomains {
+		return fmt.Errorf("domain count %d exceeds maximum of %d", len(domains), b.maxDomains)
 	}
 
-	// Clear and rebuild
-	b.blockedDomains = make(map[string]bool)
+	want := make(map[string]string, len(domains))
 	for _, domain := range domains {
 		domain = strings.ToLower(strings.TrimSpace(domain))
 		if domain != "" {
@@ -95,24 +146,118 @@ func (b *Blocker) UpdateDomains(domains []string) error {
 				logrus.WithError(err).WithField("domain", domain).Warn("Skipping invalid domain")
 				continue
 			}
-			b.blockedDomains[domain] = true
+			if _, _, err := parseDomainRule(domain); err != nil {
+				logrus.WithError(err).WithField("domain", domain).Warn("Skipping invalid domain")
+				continue
+			}
+			source := sources[domain]
+			if source == "" {
+				source = SourceInline
+			}
+			want[domain] = source
 		}
 	}
-	
+
+	blocked, added, removed := b.blockedDomains.Load().Apply(want)
+	b.blockedDomains.Store(blocked)
+
+	logrus.WithFields(logrus.Fields{
+		"added":   added,
+		"removed": removed,
+	}).Debug("Updated blocked domains")
 	return nil
 }
 
-// UpdateAllowlist updates the allowlist
-func (b *Blocker) UpdateAllowlist(domains []string) error {
+// UpdateSecurityDomainsWithSources replaces the security-critical blocklist
+// (malware, C2, phishing). These domains are always blocked like any other
+// rule, and also override the allowlist when the precedence is
+// PrecedenceSecurity.
+func (b *Blocker) UpdateSecurityDomainsWithSources(domains []string, sources map[string]string) error {
+	b.updateMu.Lock()
+	defer b.updateMu.Unlock()
+
+	if len(domains) > b.maxDomains {
+		return fmt.Errorf("security domain count %d exceeds maximum of %d", len(domains), b.maxDomains)
+	}
+
+	builder := newTrieBuilder(len(domains))
+	for _, domain := range domains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if err := utils.ValidateDomainLength(domain); err != nil {
+			logrus.WithError(err).WithField("domain", domain).Warn("Skipping invalid security domain")
+			continue
+		}
+		source := sources[domain]
+		if source == "" {
+			source = SourceInline
+		}
+		if err := builder.Add(domain, source); err != nil {
+			logrus.WithError(err).WithField("domain", domain).Warn("Skipping invalid security domain")
+		}
+	}
+	b.securityDomains.Store(builder.Build())
+
+	return nil
+}
+
+// UpdateRegexRules replaces the regex block rules. Patterns use Go (RE2)
+// syntax and are matched against the lowercase name without the trailing
+// dot; invalid patterns are skipped. Hit counters start again at zero.
+func (b *Blocker) UpdateRegexRules(patterns []string) error {
+	if len(patterns) > maxRegexRules {
+		return fmt.Errorf("regex rule count %d exceeds maximum of %d", len(patterns), maxRegexRules)
+	}
+
+	var compiled []*regexRule
+	seen := make(map[string]bool)
+	for _, pattern := range patterns {
+		pattern = strings.TrimSpace(pattern)
+		if pattern == "" || seen[pattern] {
+			continue
+		}
+		seen[pattern] = true
+
+		rule, err := compileRegexRule(pattern)
+		if err != nil {
+			logrus.WithError(err).WithField("regex", pattern).Warn("Skipping invalid regex rule")
+			continue
+		}
+		compiled = append(compiled, rule)
+	}
+
 	b.mu.Lock()
 	defer b.mu.Unlock()
+	b.regexRules = compiled
+	return nil
+}
+
+// RegexRuleHits returns each regex rule with the number of queries it
+// has blocked
+func (b *Blocker) RegexRuleHits() []RegexRuleHits {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	hits := make([]RegexRuleHits, 0, len(b.regexRules))
+	for _, rule := range b.regexRules {
+		hits = append(hits, RegexRuleHits{Pattern: rule.pattern, Hits: rule.hits.Load()})
+	}
+	return hits
+}
+
+// UpdateAllowlist updates the allowlist
+func (b *Blocker) UpdateAllowlist(domains []string) error {
+	b.updateMu.Lock()
+	defer b.updateMu.Unlock()
 
 	// Check domain count limit
-	if len(domains) > utils.MaxDomainsPerRule {
-		return fmt.Errorf("allowlist domain count %d exceeds maximum of %d", len(domains), utils.MaxDomainsPerRule)
+	if len(domains) > b.maxDomains {
+		return fmt.Errorf("allowlist domain count %d exceeds maximum of %d", len(domains), b.maxDomains)
 	}
 
-	b.allowlist = make(map[string]bool)
+	allowlist := newDomainTrie()
 	for _, domain := range domains {
 		domain = strings.ToLower(strings.TrimSpace(domain))
 		if domain != "" {
@@ -122,13 +267,28 @@ func (b *Blocker) UpdateAllowlist(domains []string) error {
 				logrus.WithError(err).WithField("domain", domain).Warn("Skipping invalid allowlist domain")
 				continue
 			}
-			b.allowlist[domain] = true
+			if err := allowlist.Add(domain, ""); err != nil {
+				logrus.WithError(err).WithField("domain", domain).Warn("Skipping invalid allowlist domain")
+			}
 		}
 	}
+	b.allowlist.Store(allowlist)
 	
 	return nil
 }
 
+// SetMaxDomains sets the maximum number of domains accepted by
+// UpdateDomains and UpdateAllowlist. Non-positive values are ignored.
+func (b *Blocker) SetMaxDomains(max int) {
+	if max <= 0 {
+		return
+	}
+
+	b.updateMu.Lock()
+	defer b.updateMu.Unlock()
+	b.maxDomains = max
+}
+
 // UpdateWhitelist is a backward compatibility alias for UpdateAllowlist
 func (b *Blocker) UpdateWhitelist(domains []string) error {
 	return b.UpdateAllowlist(domains)
@@ -149,6 +309,201 @@ func (b *Blocker) SetAllowOnlyMode(enabled bool) {
 	b.allowOnlyMode = enabled
 }
 
+// SetAllowlistPrecedence sets whether the allowlist or security-critical
+// rules win when both match. Unknown values fall back to PrecedenceAllowlist.
+func (b *Blocker) SetAllowlistPrecedence(precedence string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.securityFirst = precedence == PrecedenceSecurity
+}
+
+// AllowlistPrecedence returns the active precedence mode
+func (b *Blocker) AllowlistPrecedence() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	if b.securityFirst {
+		return PrecedenceSecurity
+	}
+	return PrecedenceAllowlist
+}
+
+// SetDefaultEnforcement sets the enforcement mode used when the rules
+// don't set one, a config.Enforcement value
+func (b *Blocker) SetDefaultEnforcement(mode string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.defaultMode = mode
+}
+
+// SetEnforcement sets the enforcement mode from the rules, a
+// config.Enforcement value; empty restores the default
+func (b *Blocker) SetEnforcement(mode string) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.enforcement = mode
+}
+
+// SetMaintenanceMonitoring switches to monitor enforcement while a
+// maintenance window is open, whatever the rules set
+func (b *Blocker) SetMaintenanceMonitoring(on bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.maintenance = on
+}
+
+// Enforcement returns the active enforcement mode
+func (b *Blocker) Enforcement() string {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	switch {
+	case b.maintenance:
+		return config.EnforcementMonitor
+	case b.enforcement != "":
+		return b.enforcement
+	case b.defaultMode != "":
+		return b.defaultMode
+	}
+	return config.EnforcementBlock
+}
+
+// Monitoring reports whether matches are only logged. Security-critical
+// rules and canaries are enforced regardless.
+func (b *Blocker) Monitoring() bool {
+	return b.Enforcement() == config.EnforcementMonitor
+}
+
+// Allowlist precedence modes
+const (
+	PrecedenceAllowlist = "allowlist" // Allowlist always wins (default)
+	PrecedenceSecurity  = "security"  // Security-critical rules override the allowlist
+)
+
+// Rule sources reported in BlockMatch for domains not loaded from a URL
+const (
+	SourceDefault   = "default"    // Built-in default rules
+	SourceInline    = "inline"     // Domains listed directly in rules or config
+	SourceAllowOnly = "allow-only" // Blocked because allow-only mode is enabled
+	SourceCanary    = "canary"     // Built-in canary used by 'dnshield verify'
+	SourceRegex     = "regex"      // Regex rules from the rules files
+)
+
+// sourceThreatIntelPrefix mirrors rules.SourceThreatIntelPrefix, which
+// prefixes the sources of threat-intel domains with their feed name
+const sourceThreatIntelPrefix = "threat-intel:"
+
+// CanaryDomain is always blocked so installations can be verified end to
+// end. It sits under the reserved .test TLD and never resolves upstream.
+const CanaryDomain = "dnshield-canary.test"
+
+// BlockMatch describes the outcome of a blocklist lookup
+type BlockMatch struct {
+	Blocked bool
+	Rule    string // Blocklist entry that matched (the domain or a parent)
+	Source  string // Where the matching rule came from
+
+	// Security is set when the rule came from the security-critical list
+	Security bool
+	// OverrodeAllowlist is set when the domain is allowlisted but a
+	// security-critical rule took precedence
+	OverrodeAllowlist bool
+	// CNAME is the alias target that matched when the query was blocked by
+	// CNAME uncloaking
+	CNAME string
+	// ListCategory is the registry category of the list the rule came
+	// from, e.g. "ads"
+	ListCategory string
+	// Decoded is the Unicode form of an internationalized name blocked as
+	// a lookalike of a protected brand
+	Decoded string
+}
+
+// Block categories reported by BlockMatch.Category. Lists from the
+// category registry report their own category instead of "blocklist".
+const (
+	CategoryBlocklist = "blocklist"  // Regular block rules and lists
+	CategorySecurity  = "security"   // Security-critical (malware/C2) rules
+	CategoryAllowOnly = "allow-only" // Not on the allowlist in allow-only mode
+	CategoryNRD       = "nrd"        // Newly registered domains
+	CategoryDGA       = "dga"        // Likely algorithmically generated domains
+	CategoryHomograph = "homograph"  // Lookalikes of protected brand domains
+)
+
+// Category returns the block category of the match, or "" if not blocked
+func (m BlockMatch) Category() string {
+	switch {
+	case !m.Blocked:
+		return ""
+	case m.Security:
+		return CategorySecurity
+	case m.Source == SourceAllowOnly:
+		return CategoryAllowOnly
+	case m.Source == SourceNRD:
+		return CategoryNRD
+	case m.Source == SourceDGA:
+		return CategoryDGA
+	case m.Source == SourceHomograph:
+		return CategoryHomograph
+	case m.ListCategory != "":
+		return m.ListCategory
+	}
+	return CategoryBlocklist
+}
+
+// Reason describes what blocked the domain for the block page, e.g.
+// "threat-intel feed abuse.ch" or "ads list lists.example.com". List URLs
+// are reduced to their host. It returns "" if not blocked.
+func (m BlockMatch) Reason() string {
+	if !m.Blocked {
+		return ""
+	}
+
+	var reason string
+	switch {
+	case m.Source == SourceDefault:
+		reason = "built-in rule " + m.Rule
+	case m.Source == SourceInline:
+		reason = "rule " + m.Rule
+	case m.Source == SourceRegex:
+		reason = "pattern " + m.Rule
+	case m.Source == SourceCanary:
+		reason = "the DNShield canary"
+	case m.Source == SourceLocal:
+		reason = "local rule " + m.Rule + " added on this device"
+	case m.Source == SourceAllowOnly && strings.HasPrefix(m.Rule, SourceSchedulePrefix):
+		reason = "allow-only schedule " + strings.TrimPrefix(m.Rule, SourceSchedulePrefix)
+	case m.Source == SourceAllowOnly:
+		reason = "allow-only mode"
+	case m.Source == SourceNRD:
+		reason = "newly registered domain filter"
+	case m.Source == SourceDGA:
+		reason = "generated domain detection"
+	case m.Source == SourceHomograph:
+		reason = "lookalike of " + m.Rule
+	case m.Source == SourceBypassPrevention:
+		reason = "DNS bypass prevention"
+	case strings.HasPrefix(m.Source, SourceSchedulePrefix):
+		reason = "schedule " + strings.TrimPrefix(m.Source, SourceSchedulePrefix)
+	case strings.HasPrefix(m.Source, sourceThreatIntelPrefix):
+		reason = "threat-intel feed " + strings.TrimPrefix(m.Source, sourceThreatIntelPrefix)
+	default:
+		list := m.Source
+		if u, err := url.Parse(m.Source); err == nil && u.Host != "" {
+			list = u.Host
+		}
+		switch category := m.Category(); category {
+		case CategoryBlocklist:
+			reason = "list " + list
+		default:
+			reason = category + " list " + list
+		}
+	}
+
+	if m.CNAME != "" {
+		reason += " (via alias " + m.CNAME + ")"
+	}
+	return reason
+}
+
 // IsBlocked checks if a domain should be blocked based on configured rules.
 // It supports two modes:
 // 1. Normal mode: Block domains in blocklist unless they're in allowlist
@@ -156,10 +511,16 @@ func (b *Blocker) SetAllowOnlyMode(enabled bool) {
 //
 // The lookup order is:
 //  1. Check if domain is a captive portal detection domain (never block)
-//  2. Check allowlist (if allowed, never block)
-//  3. In allow-only mode: block if not in allowlist
-//  4. In normal mode: check blocklist
-//  5. Check parent domains (e.g., sub.example.com checks example.com)
+//  2. With PrecedenceSecurity: check the security-critical blocklist
+//  3. Check allowlist (if allowed, never block)
+//  4. With bypass prevention on: check DoH/DoT endpoints and canaries
+//  5. In allow-only mode (always or during an active schedule): block if
+//     not in allowlist
+//  6. In normal mode: check blocklist, regex rules, active schedules, then
+//     the security-critical blocklist
+//
+// Every list lookup also checks parent domains (e.g., sub.example.com
+// checks example.com), and "*.example.com" rules match subdomains only.
 //
 // Example:
 //
@@ -167,64 +528,112 @@ func (b *Blocker) SetAllowOnlyMode(enabled bool) {
 //
 // Thread-Safety: This method is safe for concurrent use.
 func (b *Blocker) IsBlocked(domain string) bool {
+	return b.Check(domain).Blocked
+}
+
+// Check performs the same lookup as IsBlocked but also reports which rule
+// matched and its source.
+func (b *Blocker) Check(domain string) BlockMatch {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
+	return b.check(strings.ToLower(domain), true)
+}
 
-	domain = strings.ToLower(domain)
+// check is Check for a lowercase domain. Regex rule hits are only counted
+// when count is set. Callers must hold b.mu.
+func (b *Blocker) check(domain string, count bool) BlockMatch {
+	if domain == CanaryDomain {
+		return BlockMatch{Blocked: true, Rule: CanaryDomain, Source: SourceCanary}
+	}
 
 	// Never block captive portal detection domains
 	if security.IsCaptivePortalDomain(domain) {
-		return false
+		return BlockMatch{}
 	}
 
-	// Check allowlist first (allowlist always wins)
-	if b.allowlist[domain] {
-		return false
-	}
+	_, _, allowlisted := b.allowlist.Load().Match(domain)
+	allowlisted = allowlisted || b.temporarilyAllowed(domain)
 
-	// Also check parent domains in allowlist
-	parts := strings.Split(domain, ".")
-	for i := 1; i < len(parts); i++ {
-		parent := strings.Join(parts[i:], ".")
-		if b.allowlist[parent] {
-			return false
+	// Security-critical rules may take precedence over the allowlist
+	if b.securityFirst {
+		if rule, source, ok := b.matchSecurity(domain); ok {
+			return BlockMatch{Blocked: true, Rule: rule, Source: source, Security: true, OverrodeAllowlist: allowlisted}
 		}
 	}
 
+	// Otherwise the allowlist wins
+	if allowlisted {
+		return BlockMatch{}
+	}
+
+	// Then domains allowed or blocked on this device
+	if match, ok := b.checkLocal(domain, count); ok {
+		return match
+	}
+	return b.checkRules(domain, count)
+}
+
+// checkRules is the part of check after the allowlist and local rules.
+// Callers must hold b.mu.
+func (b *Blocker) checkRules(domain string, count bool) BlockMatch {
+	// Resolver bypass endpoints and canaries, in either mode
+	if rule, source, ok := b.bypassDomains.Match(domain); ok {
+		return BlockMatch{Blocked: true, Rule: rule, Source: source}
+	}
+
 	// In allow-only mode, block everything not explicitly allowed
 	if b.allowOnlyMode {
-		return true
+		return BlockMatch{Blocked: true, Rule: "*", Source: SourceAllowOnly}
+	}
+	if s := b.scheduledAllowOnly(); s != nil {
+		return BlockMatch{Blocked: true, Rule: SourceSchedulePrefix + s.Name, Source: SourceAllowOnly}
 	}
 
 	// Normal mode: check blocklist
-	// Check exact match
-	if b.blockedDomains[domain] {
-		return true
+	if rule, source, ok := b.blockedDomains.Load().Match(domain); ok {
+		return b.categorize(BlockMatch{Blocked: true, Rule: rule, Source: source}, count)
 	}
-
-	// Check parent domains in blocklist (e.g., subdomain.example.com → example.com)
-	for i := 1; i < len(parts); i++ {
-		parent := strings.Join(parts[i:], ".")
-		if b.blockedDomains[parent] {
-			return true
+	for _, rule := range b.regexRules {
+		if count && rule.match(domain) || !count && rule.matches(domain) {
+			return BlockMatch{Blocked: true, Rule: rule.pattern, Source: SourceRegex}
 		}
 	}
+	if match, ok := b.checkSchedules(domain); ok {
+		return match
+	}
+	if rule, source, ok := b.matchSecurity(domain); ok {
+		return BlockMatch{Blocked: true, Rule: rule, Source: source, Security: true}
+	}
+	if match, ok := b.checkNRD(domain); ok {
+		return match
+	}
 
-	return false
+	return BlockMatch{}
 }
 
-// GetBlockedCount returns the number of blocked domains
-func (b *Blocker) GetBlockedCount() int {
+// IsAllowlisted reports whether the domain or a parent is allowlisted,
+// permanently or temporarily
+func (b *Blocker) IsAllowlisted(domain string) bool {
 	b.mu.RLock()
 	defer b.mu.RUnlock()
-	return len(b.blockedDomains)
+	domain = strings.ToLower(domain)
+	_, _, ok := b.allowlist.Load().Match(domain)
+	return ok || b.temporarilyAllowed(domain)
+}
+
+// GetBlockedCount returns the number of blocked domains
+func (b *Blocker) GetBlockedCount() int {
+	return b.blockedDomains.Load().Len()
+}
+
+// GetSecurityBlockedCount returns the number of security-critical domains
+func (b *Blocker) GetSecurityBlockedCount() int {
+	return b.securityDomains.Load().Len()
 }
 
 // GetAllowlistCount returns the number of allowed domains
 func (b *Blocker) GetAllowlistCount() int {
-	b.mu.RLock()
-	defer b.mu.RUnlock()
-	return len(b.allowlist)
+	return b.allowlist.Load().Len()
 }
 
 // GetMetadata returns the current user and group for logging
@@ -0,0 +1,153 @@
+package dns
+
+import (
+	"fmt"
+	"testing"
+	"time"
+)
+
+func TestBlockerCheck(t *testing.T) {
+	blocker := NewBlocker()
+	err := blocker.UpdateDomainsWithSources(
+		[]string{"ads.example.com", "tracker.net"},
+		map[string]string{"ads.example.com": "https://lists.example.org/ads.txt"},
+	)
+	if err != nil {
+		t.Fatalf("UpdateDomainsWithSources failed: %v", err)
+	}
+	blocker.UpdateAllowlist([]string{"ok.tracker.net"})
+
+	tests := []struct {
+		domain  string
+		blocked bool
+		rule    string
+		source  string
+	}{
+		{"ads.example.com", true, "ads.example.com", "https://lists.example.org/ads.txt"},
+		{"cdn.ads.example.com", true, "ads.example.com", "https://lists.example.org/ads.txt"},
+		{"tracker.net", true, "tracker.net", SourceInline},
+		{"ok.tracker.net", false, "", ""},
+		{"example.com", false, "", ""},
+		{CanaryDomain, true, CanaryDomain, SourceCanary},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.domain, func(t *testing.T) {
+			match := blocker.Check(tt.domain)
+			if match.Blocked != tt.blocked || match.Rule != tt.rule || match.Source != tt.source {
+				t.Errorf("Check(%s) = %+v, want blocked=%v rule=%s source=%s",
+					tt.domain, match, tt.blocked, tt.rule, tt.source)
+			}
+			if blocker.IsBlocked(tt.domain) != tt.blocked {
+				t.Errorf("IsBlocked(%s) disagrees with Check", tt.domain)
+			}
+		})
+	}
+
+	t.Run("AllowOnlyMode", func(t *testing.T) {
+		blocker.SetAllowOnlyMode(true)
+		defer blocker.SetAllowOnlyMode(false)
+
+		match := blocker.Check("anything.example.org")
+		if !match.Blocked || match.Source != SourceAllowOnly {
+			t.Errorf("Expected allow-only block, got %+v", match)
+		}
+	})
+}
+
+func TestBlockerAllowlistPrecedence(t *testing.T) {
+	blocker := NewBlocker()
+	blocker.UpdateSecurityDomainsWithSources(
+		[]string{"c2.example.com"},
+		map[string]string{"c2.example.com": "https://lists.example.org/c2.txt"},
+	)
+	blocker.UpdateAllowlist([]string{"example.com"})
+
+	tests := []struct {
+		precedence string
+		allowOnly  bool
+		blocked    bool
+		overrode   bool
+	}{
+		{PrecedenceAllowlist, false, false, false},
+		{PrecedenceSecurity, false, true, true},
+		{PrecedenceSecurity, true, true, true},
+	}
+
+	for _, tt := range tests {
+		blocker.SetAllowlistPrecedence(tt.precedence)
+		blocker.SetAllowOnlyMode(tt.allowOnly)
+
+		match := blocker.Check("beacon.c2.example.com")
+		if match.Blocked != tt.blocked || match.OverrodeAllowlist != tt.overrode {
+			t.Errorf("precedence=%s allowOnly=%v: got %+v", tt.precedence, tt.allowOnly, match)
+		}
+		if tt.blocked && (!match.Security || match.Rule != "c2.example.com" || match.Source != "https://lists.example.org/c2.txt") {
+			t.Errorf("precedence=%s: unexpected match %+v", tt.precedence, match)
+		}
+	}
+
+	// Security domains are blocked like any other rule when not allowlisted
+	blocker.SetAllowlistPrecedence(PrecedenceAllowlist)
+	blocker.SetAllowOnlyMode(false)
+	blocker.UpdateAllowlist(nil)
+	if match := blocker.Check("c2.example.com"); !match.Blocked || !match.Security || match.OverrodeAllowlist {
+		t.Errorf("Expected plain security block, got %+v", match)
+	}
+}
+
+func TestBlockerUpdateDomainsDuringQueries(t *testing.T) {
+	blocker := NewBlocker()
+	lists := make([][]string, 2)
+	for i := 0; i < 5000; i++ {
+		lists[0] = append(lists[0], fmt.Sprintf("a%d.example.com", i))
+		lists[1] = append(lists[1], fmt.Sprintf("b%d.example.com", i))
+	}
+	for i := range lists {
+		lists[i] = append(lists[i], "shared.example.net")
+	}
+	blocker.UpdateDomains(lists[0])
+
+	done := make(chan struct{})
+	go func() {
+		defer close(done)
+		for i := 1; i <= 9; i++ {
+			blocker.UpdateDomains(lists[i%2])
+		}
+	}()
+
+	// A rule in both lists stays blocked while they are swapped
+	for queries := 0; ; queries++ {
+		select {
+		case <-done:
+			if !blocker.IsBlocked("b1.example.com") || blocker.IsBlocked("a1.example.com") {
+				t.Error("the last list isn't the one in use")
+			}
+			return
+		default:
+		}
+		if !blocker.IsBlocked("shared.example.net") {
+			t.Fatalf("query %d: shared.example.net unblocked during an update", queries)
+		}
+	}
+}
+
+func TestBlockerQueriesDontWaitForUpdates(t *testing.T) {
+	blocker := NewBlocker()
+	blocker.UpdateDomains([]string{"ads.example.com"})
+
+	// As if a large list were being built
+	blocker.updateMu.Lock()
+	defer blocker.updateMu.Unlock()
+
+	checked := make(chan bool)
+	go func() { checked <- blocker.IsBlocked("ads.example.com") }()
+	select {
+	case blocked := <-checked:
+		if !blocked {
+			t.Error("ads.example.com not blocked")
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("query waited for the update")
+	}
+}
@@ -0,0 +1,115 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"dnshield/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// SourceBypassPrevention is reported for blocks by bypass prevention
+const SourceBypassPrevention = "bypass-prevention"
+
+// DefaultBypassDomains are public DNS-over-HTTPS and DNS-over-TLS
+// endpoints that applications can use to resolve names without DNShield.
+// Rules files extend the list through bypass_prevention.
+var DefaultBypassDomains = []string{
+	"dns.google",
+	"dns.google.com",
+	"cloudflare-dns.com",
+	"one.one.one.one",
+	"1dot1dot1dot1.cloudflare-dns.com",
+	"dns.quad9.net",
+	"dns9.quad9.net",
+	"dns10.quad9.net",
+	"dns11.quad9.net",
+	"doh.opendns.com",
+	"doh.familyshield.opendns.com",
+	"dns.umbrella.com",
+	"doh.cleanbrowsing.org",
+	"dns.nextdns.io",
+	"dns.adguard.com",
+	"dns.adguard-dns.com",
+	"doh.mullvad.net",
+	"dns.mullvad.net",
+	"doh.dns.sb",
+	"dns.controld.com",
+	"freedns.controld.com",
+	"doh.libredns.gr",
+	"dns.alidns.com",
+	"doh.pub",
+	"dns.twnic.tw",
+	"doh.xfinity.com",
+	"chrome.cloudflare-dns.com",
+	"mozilla.cloudflare-dns.com",
+	"firefox.dns.nextdns.io",
+}
+
+// bypassCanaryDomains are checked by clients to decide whether they may
+// bypass the network's resolver; NXDOMAIN tells Firefox to keep DoH off.
+// iCloud Private Relay's mask.icloud.com is left alone as it is also used
+// for captive portal detection.
+var bypassCanaryDomains = map[string]bool{
+	"use-application-dns.net": true,
+}
+
+// isBypassCanary reports whether a bypass prevention rule is a canary
+// domain, which is answered with NXDOMAIN rather than the sinkhole
+func isBypassCanary(match BlockMatch) bool {
+	return match.Source == SourceBypassPrevention && bypassCanaryDomains[match.Rule]
+}
+
+// UpdateBypassPrevention turns bypass prevention on or off. When enabled,
+// the default DoH/DoT endpoints, the canary domains and extra are blocked
+// except where the allowlist permits them; exempt removes entries from the
+// default list.
+func (b *Blocker) UpdateBypassPrevention(enabled bool, extra, exempt []string) error {
+	if !enabled {
+		b.mu.Lock()
+		b.bypassDomains = newDomainTrie()
+		b.mu.Unlock()
+		return nil
+	}
+
+	b.updateMu.Lock()
+	maxDomains := b.maxDomains
+	b.updateMu.Unlock()
+	if len(extra) > maxDomains {
+		return fmt.Errorf("bypass domain count %d exceeds maximum of %d", len(extra), maxDomains)
+	}
+
+	skip := make(map[string]bool, len(exempt))
+	for _, domain := range exempt {
+		skip[strings.ToLower(strings.TrimSpace(domain))] = true
+	}
+
+	bypass := newDomainTrie()
+	add := func(domain string) {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" || skip[domain] {
+			return
+		}
+		if err := utils.ValidateDomainLength(domain); err != nil {
+			logrus.WithError(err).WithField("domain", domain).Warn("Skipping invalid bypass domain")
+			return
+		}
+		if err := bypass.Add(domain, SourceBypassPrevention); err != nil {
+			logrus.WithError(err).WithField("domain", domain).Warn("Skipping invalid bypass domain")
+		}
+	}
+	for domain := range bypassCanaryDomains {
+		add(domain)
+	}
+	for _, domain := range DefaultBypassDomains {
+		add(domain)
+	}
+	for _, domain := range extra {
+		add(domain)
+	}
+
+	b.mu.Lock()
+	b.bypassDomains = bypass
+	b.mu.Unlock()
+	return nil
+}
@@ -0,0 +1,65 @@
+package dns
+
+import (
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestBypassPrevention(t *testing.T) {
+	h := newTestHandler(t)
+	b := h.blocker
+	if err := b.UpdateAllowlist([]string{"dns.nextdns.io"}); err != nil {
+		t.Fatal(err)
+	}
+
+	if b.IsBlocked("dns.google") || b.IsBlocked("use-application-dns.net") {
+		t.Fatal("bypass endpoints blocked before bypass prevention was enabled")
+	}
+
+	if err := b.UpdateBypassPrevention(true, []string{"doh.example.net"}, []string{"dns.quad9.net"}); err != nil {
+		t.Fatal(err)
+	}
+	for domain, want := range map[string]bool{
+		"dns.google":                 true,
+		"mozilla.cloudflare-dns.com": true,
+		"doh.example.net":            true,
+		"mask.icloud.com":            false, // Captive portal detection
+		"dns.quad9.net":              false, // Exempt
+		"dns.nextdns.io":             false, // Allowlisted
+		"google.com":                 false,
+	} {
+		if match := b.Check(domain); match.Blocked != want || (want && match.Source != SourceBypassPrevention) {
+			t.Errorf("Check(%s) = %+v, want blocked %v", domain, match, want)
+		}
+	}
+
+	// Canaries get NXDOMAIN; endpoints the sinkhole
+	query := func(name string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+		return w.msg
+	}
+	if m := query("use-application-dns.net."); m.Rcode != dns.RcodeNameError {
+		t.Errorf("canary answered %s, want NXDOMAIN", dns.RcodeToString[m.Rcode])
+	}
+	if m := query("dns.google."); m.Rcode != dns.RcodeSuccess || len(m.Answer) != 1 {
+		t.Errorf("endpoint answered %v, want the sinkhole", m)
+	}
+
+	// Allow-only mode doesn't hide the canary behind a sinkhole answer
+	b.SetAllowOnlyMode(true)
+	if match := b.Check("use-application-dns.net"); !isBypassCanary(match) {
+		t.Errorf("Check(use-application-dns.net) in allow-only mode = %+v", match)
+	}
+
+	if err := b.UpdateBypassPrevention(false, nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	b.SetAllowOnlyMode(false)
+	if b.IsBlocked("dns.google") {
+		t.Error("dns.google blocked after bypass prevention was disabled")
+	}
+}
@@ -10,20 +10,30 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// staleAnswerTTL is the TTL of answers served from expired entries, as
+// RFC 8767 recommends
+const staleAnswerTTL = 30
+
 // CacheEntry represents a cached DNS response
 type CacheEntry struct {
+	Rcode      int
 	Answer     []dns.RR
+	Ns         []dns.RR // SOA of a negative answer
 	Expiration time.Time
 }
 
 // Cache is a simple DNS cache
 type Cache struct {
-	mu         sync.RWMutex
-	entries    map[string]*CacheEntry
-	maxSize    int
-	ttl        time.Duration
-	shutdownCh chan struct{}
-	wg         sync.WaitGroup
+	mu          sync.RWMutex
+	entries     map[string]*CacheEntry
+	maxSize     int
+	ttl         time.Duration
+	negativeTTL time.Duration // Cap on negative answers; 0 disables them
+	serveStale  time.Duration // How long entries outlive their expiration
+	// Saved to on Stop when snapshots are enabled
+	snapshotPath string
+	shutdownCh   chan struct{}
+	wg           sync.WaitGroup
 }
 
 // NewCache creates a new DNS cache
@@ -42,13 +52,43 @@ func NewCache(maxSize int, ttl time.Duration) *Cache {
 	return c
 }
 
+// SetNegativeTTL caps how long NXDOMAIN and NODATA answers are cached.
+// Zero disables negative caching.
+func (c *Cache) SetNegativeTTL(ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.negativeTTL = ttl
+}
+
+// SetServeStale keeps entries for d after they expire so GetStale can
+// answer while upstreams are unreachable. Zero disables serve-stale.
+func (c *Cache) SetServeStale(d time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.serveStale = d
+}
+
+// Resize changes the entry limit and TTL of the cache, evicting the oldest
+// entries if it holds more than maxSize. Cached entries keep their
+// expiration.
+func (c *Cache) Resize(maxSize int, ttl time.Duration) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+	c.maxSize = maxSize
+	c.ttl = ttl
+	if len(c.entries) > maxSize {
+		c.evictExpiredUnlocked()
+		c.evictOldestUnlocked(len(c.entries) - maxSize)
+	}
+}
+
 // makeKey creates a cache key from domain and query type
 func makeKey(domain string, qtype uint16) string {
 	return fmt.Sprintf("%s:%d", domain, qtype)
 }
 
-// Get retrieves a cached response
-func (c *Cache) Get(domain string, qtype uint16) []dns.RR {
+// Get retrieves a cached response, or nil if there is none or it expired
+func (c *Cache) Get(domain string, qtype uint16) *CacheEntry {
 	c.mu.RLock()
 	defer c.mu.RUnlock()
 
@@ -59,14 +99,68 @@ func (c *Cache) Get(domain string, qtype uint16) []dns.RR {
 	}
 
 	// Check if expired
-	if time.Now().After(entry.Expiration) {
+	now := time.Now()
+	if now.After(entry.Expiration) {
 		return nil
 	}
 
-	// Return a copy of the answer
-	answer := make([]dns.RR, len(entry.Answer))
-	copy(answer, entry.Answer)
-	return answer
+	// Return a copy; negative answers count their SOA's TTL down
+	cached := &CacheEntry{
+		Rcode:      entry.Rcode,
+		Answer:     make([]dns.RR, len(entry.Answer)),
+		Ns:         copyRRs(entry.Ns, uint32(entry.Expiration.Sub(now)/time.Second)),
+		Expiration: entry.Expiration,
+	}
+	copy(cached.Answer, entry.Answer)
+	return cached
+}
+
+// ExpiresIn returns how long the cached response for domain has left, and
+// false if there is none or it expired
+func (c *Cache) ExpiresIn(domain string, qtype uint16) (time.Duration, bool) {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, exists := c.entries[makeKey(domain, qtype)]
+	if !exists {
+		return 0, false
+	}
+	left := time.Until(entry.Expiration)
+	return left, left > 0
+}
+
+// GetStale retrieves an expired response still within the serve-stale
+// window, with its TTLs lowered for clients to retry soon. It returns nil
+// if serve-stale is disabled.
+func (c *Cache) GetStale(domain string, qtype uint16) *CacheEntry {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+
+	entry, exists := c.entries[makeKey(domain, qtype)]
+	if !exists || c.serveStale <= 0 || time.Now().After(entry.Expiration.Add(c.serveStale)) {
+		return nil
+	}
+	return &CacheEntry{
+		Rcode:      entry.Rcode,
+		Answer:     copyRRs(entry.Answer, staleAnswerTTL),
+		Ns:         copyRRs(entry.Ns, staleAnswerTTL),
+		Expiration: entry.Expiration,
+	}
+}
+
+// copyRRs deep-copies records, lowering TTLs above maxTTL to it
+func copyRRs(rrs []dns.RR, maxTTL uint32) []dns.RR {
+	if len(rrs) == 0 {
+		return nil
+	}
+	copied := make([]dns.RR, len(rrs))
+	for i, rr := range rrs {
+		copied[i] = dns.Copy(rr)
+		if copied[i].Header().Ttl > maxTTL {
+			copied[i].Header().Ttl = maxTTL
+		}
+	}
+	return copied
 }
 
 // Set stores a response in the cache
@@ -74,6 +168,63 @@ func (c *Cache) Set(domain string, qtype uint16, answer []dns.RR) {
 	c.mu.Lock()
 	defer c.mu.Unlock()
 
+	c.storeUnlocked(domain, qtype, &CacheEntry{
+		Rcode:      dns.RcodeSuccess,
+		Answer:     answer,
+		Expiration: time.Now().Add(c.ttl),
+	})
+}
+
+// SetNegative caches an NXDOMAIN or NODATA response for the negative TTL
+// of its SOA record (RFC 2308 section 5), capped by the configured maximum.
+// Responses without an SOA aren't cached. It reports whether resp was
+// cached.
+func (c *Cache) SetNegative(domain string, qtype uint16, resp *dns.Msg) bool {
+	isNegative := resp.Rcode == dns.RcodeNameError ||
+		(resp.Rcode == dns.RcodeSuccess && len(resp.Answer) == 0)
+	if !isNegative {
+		return false
+	}
+
+	var soa *dns.SOA
+	for _, rr := range resp.Ns {
+		if s, ok := rr.(*dns.SOA); ok {
+			soa = s
+			break
+		}
+	}
+	if soa == nil {
+		return false
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	ttl := time.Duration(soa.Hdr.Ttl) * time.Second
+	if minimum := time.Duration(soa.Minttl) * time.Second; minimum < ttl {
+		ttl = minimum
+	}
+	if ttl > c.negativeTTL {
+		ttl = c.negativeTTL
+	}
+	if ttl <= 0 {
+		return false
+	}
+
+	// The SOA is the negative answer's TTL as seen by downstream caches
+	ns := dns.Copy(soa)
+	ns.Header().Ttl = uint32(ttl / time.Second)
+	c.storeUnlocked(domain, qtype, &CacheEntry{
+		Rcode:      resp.Rcode,
+		Ns:         []dns.RR{ns},
+		Expiration: time.Now().Add(ttl),
+	})
+	return true
+}
+
+// storeUnlocked adds an entry, evicting others if the cache is full (must
+// be called with lock held)
+func (c *Cache) storeUnlocked(domain string, qtype uint16, entry *CacheEntry) {
 	// Evict expired entries first if at capacity
 	if len(c.entries) >= c.maxSize {
 		c.evictExpiredUnlocked()
@@ -84,18 +235,16 @@ func (c *Cache) Set(domain string, qtype uint16, answer []dns.RR) {
 		c.evictOldestUnlocked(c.maxSize / 10) // Remove 10%
 	}
 
-	key := makeKey(domain, qtype)
-	c.entries[key] = &CacheEntry{
-		Answer:     answer,
-		Expiration: time.Now().Add(c.ttl),
-	}
+	c.entries[makeKey(domain, qtype)] = entry
 }
 
-// Clear empties the cache
-func (c *Cache) Clear() {
+// Clear empties the cache and returns how many entries it held
+func (c *Cache) Clear() int {
 	c.mu.Lock()
 	defer c.mu.Unlock()
+	cleared := len(c.entries)
 	c.entries = make(map[string]*CacheEntry)
+	return cleared
 }
 
 // cleanupExpired runs periodically to remove expired entries
@@ -116,7 +265,8 @@ func (c *Cache) cleanupExpired() {
 	}
 }
 
-// removeExpired removes all expired entries from the cache
+// removeExpired removes entries that expired longer ago than the
+// serve-stale window
 func (c *Cache) removeExpired() {
 	c.mu.Lock()
 	defer c.mu.Unlock()
@@ -125,7 +275,7 @@ func (c *Cache) removeExpired() {
 	expiredCount := 0
 	
 	for key, entry := range c.entries {
-		if now.After(entry.Expiration) {
+		if now.After(entry.Expiration.Add(c.serveStale)) {
 			delete(c.entries, key)
 			expiredCount++
 		}
@@ -136,7 +286,8 @@ func (c *Cache) removeExpired() {
 	}
 }
 
-// evictExpiredUnlocked removes expired entries (must be called with lock held)
+// evictExpiredUnlocked removes expired entries, stale ones included (must be
+// called with lock held)
 func (c *Cache) evictExpiredUnlocked() int {
 	now := time.Now()
 	expiredCount := 0
@@ -191,8 +342,20 @@ func (c *Cache) evictOldestUnlocked(count int) {
 	}
 }
 
-// Stop gracefully shuts down the cache
+// Stop gracefully shuts down the cache, saving a snapshot if enabled
 func (c *Cache) Stop() {
 	close(c.shutdownCh)
 	c.wg.Wait()
+
+	c.mu.RLock()
+	path := c.snapshotPath
+	c.mu.RUnlock()
+	if path == "" {
+		return
+	}
+	if saved, err := c.SaveSnapshot(path); err != nil {
+		logrus.WithError(err).Warn("Failed to save DNS cache snapshot")
+	} else {
+		logrus.WithField("entries", saved).Info("Saved DNS cache snapshot")
+	}
 }
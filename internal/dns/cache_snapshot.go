@@ -0,0 +1,207 @@
+package dns
+
+import (
+	"bufio"
+	"encoding/gob"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// cacheSnapshotVersion is bumped when the snapshot format changes;
+	// snapshots of other versions are ignored
+	cacheSnapshotVersion = 1
+	// maxCacheSnapshotSize bounds the snapshot file read at startup
+	maxCacheSnapshotSize = 256 << 20
+)
+
+// cacheSnapshot is the on-disk form of the cache
+type cacheSnapshot struct {
+	Version int
+	Saved   time.Time
+	Entries []cacheSnapshotEntry
+}
+
+type cacheSnapshotEntry struct {
+	Domain     string
+	Qtype      uint16
+	Rcode      int
+	Expiration time.Time
+	Records    []byte // Answer and Ns sections in wire format
+}
+
+// EnableSnapshots restores the cache from the snapshot at path, then saves
+// it there every interval and when the cache is stopped, so a restart
+// doesn't start from an empty cache. A missing snapshot isn't an error.
+func (c *Cache) EnableSnapshots(path string, interval time.Duration) {
+	c.mu.Lock()
+	c.snapshotPath = path
+	c.mu.Unlock()
+
+	restored, err := c.LoadSnapshot(path)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).WithField("path", path).Warn("Ignoring unreadable DNS cache snapshot")
+	} else if restored > 0 {
+		logrus.WithField("entries", restored).Info("Restored DNS cache snapshot")
+	}
+
+	if interval > 0 {
+		c.wg.Add(1)
+		go func() {
+			defer c.wg.Done()
+			ticker := time.NewTicker(interval)
+			defer ticker.Stop()
+			for {
+				select {
+				case <-c.shutdownCh:
+					return
+				case <-ticker.C:
+					if _, err := c.SaveSnapshot(path); err != nil {
+						logrus.WithError(err).Warn("Failed to checkpoint DNS cache")
+					}
+				}
+			}
+		}()
+	}
+}
+
+// SaveSnapshot writes the unexpired and serve-stale entries to path and
+// returns how many were written
+func (c *Cache) SaveSnapshot(path string) (int, error) {
+	snapshot := cacheSnapshot{Version: cacheSnapshotVersion, Saved: time.Now()}
+
+	c.mu.RLock()
+	for key, entry := range c.entries {
+		if snapshot.Saved.After(entry.Expiration.Add(c.serveStale)) {
+			continue
+		}
+		domain, qtype, ok := parseKey(key)
+		if !ok {
+			continue
+		}
+		records, err := (&dns.Msg{Answer: entry.Answer, Ns: entry.Ns}).Pack()
+		if err != nil {
+			continue
+		}
+		snapshot.Entries = append(snapshot.Entries, cacheSnapshotEntry{
+			Domain:     domain,
+			Qtype:      qtype,
+			Rcode:      entry.Rcode,
+			Expiration: entry.Expiration,
+			Records:    records,
+		})
+	}
+	c.mu.RUnlock()
+
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return 0, err
+	}
+	tmp := path + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0600)
+	if err != nil {
+		return 0, err
+	}
+	w := bufio.NewWriter(f)
+	if err := gob.NewEncoder(w).Encode(&snapshot); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return 0, err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	return len(snapshot.Entries), os.Rename(tmp, path)
+}
+
+// LoadSnapshot adds the entries saved at path that haven't outlived the
+// serve-stale window, lowering their TTLs to the time they have left.
+// Entries already cached are kept. It returns how many were added.
+func (c *Cache) LoadSnapshot(path string) (int, error) {
+	f, err := os.Open(path)
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+
+	var snapshot cacheSnapshot
+	if err := gob.NewDecoder(io.LimitReader(bufio.NewReader(f), maxCacheSnapshotSize)).Decode(&snapshot); err != nil {
+		return 0, fmt.Errorf("decoding snapshot: %v", err)
+	}
+	if snapshot.Version != cacheSnapshotVersion {
+		return 0, fmt.Errorf("unsupported snapshot version %d", snapshot.Version)
+	}
+
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	now := time.Now()
+	restored := 0
+	for _, saved := range snapshot.Entries {
+		if len(c.entries) >= c.maxSize {
+			break
+		}
+		if now.After(saved.Expiration.Add(c.serveStale)) {
+			continue
+		}
+		key := makeKey(saved.Domain, saved.Qtype)
+		if _, exists := c.entries[key]; exists {
+			continue
+		}
+		msg := new(dns.Msg)
+		if err := msg.Unpack(saved.Records); err != nil {
+			continue
+		}
+
+		// Records can't outlive the entry; stale entries get their TTLs
+		// lowered when served
+		remaining := uint32(0)
+		if left := saved.Expiration.Sub(now); left > 0 {
+			remaining = uint32(left / time.Second)
+		}
+		c.entries[key] = &CacheEntry{
+			Rcode:      saved.Rcode,
+			Answer:     copyRRs(msg.Answer, remaining),
+			Ns:         copyRRs(msg.Ns, remaining),
+			Expiration: saved.Expiration,
+		}
+		restored++
+	}
+	return restored, nil
+}
+
+// parseKey splits a key made by makeKey
+func parseKey(key string) (domain string, qtype uint16, ok bool) {
+	i := strings.LastIndexByte(key, ':')
+	if i < 0 {
+		return "", 0, false
+	}
+	if _, err := fmt.Sscanf(key[i+1:], "%d", &qtype); err != nil {
+		return "", 0, false
+	}
+	return key[:i], qtype, true
+}
+
+// expandHome replaces a leading ~/ in path with the user's home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
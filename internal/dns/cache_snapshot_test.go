@@ -0,0 +1,66 @@
+package dns
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestCacheSnapshotRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.bin")
+
+	c := NewCache(100, 10*time.Minute)
+	c.SetNegativeTTL(5 * time.Minute)
+	a, _ := dns.NewRR("www.example.com. 3600 IN A 192.0.2.1")
+	c.Set("www.example.com", dns.TypeA, []dns.RR{a})
+	c.SetNegative("missing.example.com", dns.TypeA, negativeResponse(t, dns.RcodeNameError, "example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 7200 900 1209600 60"))
+	// Expired and past serve-stale: not saved
+	c.mu.Lock()
+	c.entries[makeKey("old.example.com", dns.TypeA)] = &CacheEntry{Answer: []dns.RR{a}, Expiration: time.Now().Add(-time.Minute)}
+	c.mu.Unlock()
+
+	if saved, err := c.SaveSnapshot(path); err != nil || saved != 2 {
+		t.Fatalf("SaveSnapshot() = %d, %v; want 2 entries", saved, err)
+	}
+	c.Stop()
+
+	restored := NewCache(100, 10*time.Minute)
+	defer restored.Stop()
+	if n, err := restored.LoadSnapshot(path); err != nil || n != 2 {
+		t.Fatalf("LoadSnapshot() = %d, %v; want 2 entries", n, err)
+	}
+
+	entry := restored.Get("www.example.com", dns.TypeA)
+	if entry == nil || len(entry.Answer) != 1 || !entry.Answer[0].(*dns.A).A.Equal(a.(*dns.A).A) {
+		t.Fatalf("restored entry = %+v", entry)
+	}
+	// The record's TTL is lowered to what's left of the entry's lifetime
+	if ttl := entry.Answer[0].Header().Ttl; ttl > 600 || ttl < 598 {
+		t.Errorf("restored TTL = %d, want about 600", ttl)
+	}
+	if neg := restored.Get("missing.example.com", dns.TypeA); neg == nil || neg.Rcode != dns.RcodeNameError {
+		t.Errorf("restored negative entry = %+v", neg)
+	}
+	if restored.Get("old.example.com", dns.TypeA) != nil {
+		t.Error("expired entry restored")
+	}
+}
+
+func TestCacheSnapshotSavedOnStop(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache.bin")
+
+	c := NewCache(100, time.Hour)
+	c.EnableSnapshots(path, 0)
+	a, _ := dns.NewRR("www.example.com. 60 IN A 192.0.2.1")
+	c.Set("www.example.com", dns.TypeA, []dns.RR{a})
+	c.Stop()
+
+	restarted := NewCache(100, time.Hour)
+	defer restarted.Stop()
+	restarted.EnableSnapshots(path, 0)
+	if restarted.Get("www.example.com", dns.TypeA) == nil {
+		t.Error("cache not restored after restart")
+	}
+}
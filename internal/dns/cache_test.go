@@ -0,0 +1,112 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func negativeResponse(t *testing.T, rcode int, soa string) *dns.Msg {
+	t.Helper()
+	m := new(dns.Msg)
+	m.SetQuestion("missing.example.com.", dns.TypeA)
+	m.Rcode = rcode
+	if soa != "" {
+		rr, err := dns.NewRR(soa)
+		if err != nil {
+			t.Fatal(err)
+		}
+		m.Ns = []dns.RR{rr}
+	}
+	return m
+}
+
+func TestCacheSetNegative(t *testing.T) {
+	tests := []struct {
+		name    string
+		rcode   int
+		soa     string
+		wantTTL uint32 // 0 when not cached
+	}{
+		{"nxdomain uses SOA minimum", dns.RcodeNameError, "example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 7200 900 1209600 60", 60},
+		{"nodata uses SOA TTL when lower", dns.RcodeSuccess, "example.com. 30 IN SOA ns.example.com. admin.example.com. 1 7200 900 1209600 600", 30},
+		{"capped by negativeCacheTTL", dns.RcodeNameError, "example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 7200 900 1209600 3600", 300},
+		{"no SOA", dns.RcodeNameError, "", 0},
+		{"servfail", dns.RcodeServerFailure, "example.com. 3600 IN SOA ns.example.com. admin.example.com. 1 7200 900 1209600 60", 0},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			c := NewCache(100, time.Hour)
+			defer c.Stop()
+			c.SetNegativeTTL(5 * time.Minute)
+
+			cached := c.SetNegative("missing.example.com", dns.TypeA, negativeResponse(t, tt.rcode, tt.soa))
+			entry := c.Get("missing.example.com", dns.TypeA)
+			if tt.wantTTL == 0 {
+				if cached || entry != nil {
+					t.Fatalf("SetNegative() cached %+v, want nothing", entry)
+				}
+				return
+			}
+			if !cached || entry == nil {
+				t.Fatal("SetNegative() did not cache the response")
+			}
+			if entry.Rcode != tt.rcode || len(entry.Answer) != 0 || len(entry.Ns) != 1 {
+				t.Fatalf("Get() = %+v", entry)
+			}
+			if ttl := entry.Ns[0].Header().Ttl; ttl > tt.wantTTL || ttl < tt.wantTTL-1 {
+				t.Errorf("SOA TTL = %d, want %d", ttl, tt.wantTTL)
+			}
+		})
+	}
+
+	// Disabled by default
+	c := NewCache(100, time.Hour)
+	defer c.Stop()
+	if c.SetNegative("missing.example.com", dns.TypeA, negativeResponse(t, dns.RcodeNameError, "example.com. 60 IN SOA ns.example.com. admin.example.com. 1 7200 900 1209600 60")) {
+		t.Error("SetNegative() cached with negative caching disabled")
+	}
+}
+
+func TestCacheGetStale(t *testing.T) {
+	a, _ := dns.NewRR("example.org. 3600 IN A 192.0.2.1")
+
+	c := NewCache(100, time.Hour)
+	defer c.Stop()
+	c.Set("example.org", dns.TypeA, []dns.RR{a})
+
+	// Expire the entry
+	c.mu.Lock()
+	c.entries[makeKey("example.org", dns.TypeA)].Expiration = time.Now().Add(-time.Minute)
+	c.mu.Unlock()
+
+	if c.Get("example.org", dns.TypeA) != nil {
+		t.Fatal("Get() returned an expired entry")
+	}
+	if c.GetStale("example.org", dns.TypeA) != nil {
+		t.Fatal("GetStale() answered with serve-stale disabled")
+	}
+
+	c.SetServeStale(time.Hour)
+	stale := c.GetStale("example.org", dns.TypeA)
+	if stale == nil || len(stale.Answer) != 1 {
+		t.Fatalf("GetStale() = %+v, want the expired answer", stale)
+	}
+	if ttl := stale.Answer[0].Header().Ttl; ttl != staleAnswerTTL {
+		t.Errorf("stale TTL = %d, want %d", ttl, staleAnswerTTL)
+	}
+	if a.Header().Ttl != 3600 {
+		t.Error("GetStale() modified the cached record")
+	}
+
+	// Past the serve-stale window the entry is dropped
+	c.SetServeStale(30 * time.Second)
+	if c.GetStale("example.org", dns.TypeA) != nil {
+		t.Error("GetStale() answered past the serve-stale window")
+	}
+	c.removeExpired()
+	if len(c.entries) != 0 {
+		t.Error("removeExpired() kept an entry past the serve-stale window")
+	}
+}
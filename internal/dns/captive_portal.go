@@ -1,6 +1,7 @@
 package dns
 
 import (
+	"errors"
 	"sync"
 	"time"
 	
@@ -17,13 +18,32 @@ type CaptivePortalDetector struct {
 	lastRequestTime   map[string]time.Time
 	bypassMode        bool
 	bypassUntil       time.Time
+	bypassManual      bool // Current bypass was requested by the user
 	enabled           bool
 	threshold         int
 	timeWindow        time.Duration
 	bypassDuration    time.Duration
 	additionalDomains []string
+
+	// Manual bypass policy
+	manualAllowed     bool
+	manualMaxDuration time.Duration
+	manualMaxPerHour  int
+	manualUses        []time.Time
 }
 
+// Errors returned by EnableManualBypass
+var (
+	ErrManualBypassNotAllowed  = errors.New("manual captive portal bypass is not allowed by policy")
+	ErrManualBypassRateLimited = errors.New("manual captive portal bypass limit reached, try again later")
+)
+
+// Manual bypass defaults used when the policy leaves them unset
+const (
+	defaultManualBypassMaxDuration = 5 * time.Minute
+	defaultManualBypassMaxPerHour  = 3
+)
+
 // NewCaptivePortalDetector creates a new captive portal detector
 func NewCaptivePortalDetector(cfg *config.CaptivePortalConfig) *CaptivePortalDetector {
 	// If no config provided, use defaults
@@ -36,26 +56,39 @@ func NewCaptivePortalDetector(cfg *config.CaptivePortalConfig) *CaptivePortalDet
 		}
 	}
 	
-	return &CaptivePortalDetector{
-		requestCounts:     make(map[string]int),
-		lastRequestTime:   make(map[string]time.Time),
-		enabled:           cfg.Enabled,
-		threshold:         cfg.DetectionThreshold,
-		timeWindow:        cfg.DetectionWindow,
-		bypassDuration:    cfg.BypassDuration,
-		additionalDomains: cfg.AdditionalDomains,
+	c := &CaptivePortalDetector{
+		requestCounts:   make(map[string]int),
+		lastRequestTime: make(map[string]time.Time),
 	}
+	c.UpdateConfig(cfg)
+	return c
+}
+
+// UpdateConfig applies new detection and manual bypass settings. A bypass
+// in progress runs until it was due to end.
+func (c *CaptivePortalDetector) UpdateConfig(cfg *config.CaptivePortalConfig) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	c.enabled = cfg.Enabled
+	c.threshold = cfg.DetectionThreshold
+	c.timeWindow = cfg.DetectionWindow
+	c.bypassDuration = cfg.BypassDuration
+	c.additionalDomains = cfg.AdditionalDomains
+	c.manualAllowed = cfg.AllowManualBypass
+	c.manualMaxDuration = cfg.ManualBypassMaxDuration
+	c.manualMaxPerHour = cfg.ManualBypassMaxPerHour
 }
 
 // RecordRequest records a DNS request and checks if captive portal bypass should be activated
 func (c *CaptivePortalDetector) RecordRequest(domain string) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
 	// Skip if detection is disabled
 	if !c.enabled {
 		return
 	}
-	
-	c.mu.Lock()
-	defer c.mu.Unlock()
 
 	// Check if this is a captive portal domain (including additional domains)
 	if !security.IsCaptivePortalDomainWithAdditional(domain, c.additionalDomains) {
@@ -90,6 +123,7 @@ func (c *CaptivePortalDetector) RecordRequest(domain string) {
 		// Set bypass mode here while we have the lock
 		c.bypassMode = true
 		c.bypassUntil = time.Now().Add(c.bypassDuration)
+		c.bypassManual = false
 		
 		// Clear counters
 		c.requestCounts = make(map[string]int)
@@ -110,6 +144,7 @@ func (c *CaptivePortalDetector) EnableBypass() {
 	
 	c.bypassMode = true
 	c.bypassUntil = time.Now().Add(c.bypassDuration)
+	c.bypassManual = false
 	
 	// Clear counters
 	c.requestCounts = make(map[string]int)
@@ -118,6 +153,63 @@ func (c *CaptivePortalDetector) EnableBypass() {
 	logrus.WithField("until", c.bypassUntil.Format(time.RFC3339)).Info("DNS filtering bypass enabled")
 }
 
+// EnableManualBypass starts a user-requested bypass, subject to policy.
+// The duration is capped at the configured maximum (zero requests the
+// maximum), and only a limited number of bypasses are allowed per hour.
+// Manual bypasses are scoped: security-critical blocks stay in force.
+func (c *CaptivePortalDetector) EnableManualBypass(duration time.Duration) (time.Time, error) {
+	c.mu.Lock()
+	defer c.mu.Unlock()
+
+	if !c.manualAllowed {
+		return time.Time{}, ErrManualBypassNotAllowed
+	}
+
+	maxDuration := c.manualMaxDuration
+	if maxDuration <= 0 {
+		maxDuration = defaultManualBypassMaxDuration
+	}
+	if duration <= 0 || duration > maxDuration {
+		duration = maxDuration
+	}
+
+	maxPerHour := c.manualMaxPerHour
+	if maxPerHour <= 0 {
+		maxPerHour = defaultManualBypassMaxPerHour
+	}
+
+	now := time.Now()
+	recent := c.manualUses[:0]
+	for _, used := range c.manualUses {
+		if now.Sub(used) < time.Hour {
+			recent = append(recent, used)
+		}
+	}
+	c.manualUses = recent
+	if len(c.manualUses) >= maxPerHour {
+		return time.Time{}, ErrManualBypassRateLimited
+	}
+	c.manualUses = append(c.manualUses, now)
+
+	c.bypassMode = true
+	c.bypassUntil = now.Add(duration)
+	c.bypassManual = true
+
+	logrus.WithFields(logrus.Fields{
+		"until":    c.bypassUntil.Format(time.RFC3339),
+		"duration": duration,
+	}).Warn("Manual captive portal bypass enabled")
+
+	return c.bypassUntil, nil
+}
+
+// IsManualBypass reports whether the active bypass was requested manually
+func (c *CaptivePortalDetector) IsManualBypass() bool {
+	c.mu.RLock()
+	defer c.mu.RUnlock()
+	return c.bypassMode && c.bypassManual && time.Now().Before(c.bypassUntil)
+}
+
 // DisableBypass manually disables bypass mode
 func (c *CaptivePortalDetector) DisableBypass() {
 	c.mu.Lock()
@@ -125,6 +217,7 @@ func (c *CaptivePortalDetector) DisableBypass() {
 	
 	c.bypassMode = false
 	c.bypassUntil = time.Time{}
+	c.bypassManual = false
 	
 	logrus.Info("DNS filtering bypass disabled")
 }
@@ -129,4 +129,48 @@ func TestCaptivePortalDetectorDisabled(t *testing.T) {
 	if detector.IsInBypassMode() {
 		t.Error("Bypass mode should not be enabled when detection is disabled")
 	}
-}
\ No newline at end of file
+}
+
+func TestCaptivePortalManualBypass(t *testing.T) {
+	t.Run("NotAllowed", func(t *testing.T) {
+		detector := NewCaptivePortalDetector(&config.CaptivePortalConfig{Enabled: true})
+		if _, err := detector.EnableManualBypass(time.Minute); err != ErrManualBypassNotAllowed {
+			t.Errorf("Expected ErrManualBypassNotAllowed, got %v", err)
+		}
+		if detector.IsInBypassMode() {
+			t.Error("Bypass must not start when policy forbids it")
+		}
+	})
+
+	t.Run("CappedAndRateLimited", func(t *testing.T) {
+		detector := NewCaptivePortalDetector(&config.CaptivePortalConfig{
+			Enabled:                 true,
+			AllowManualBypass:       true,
+			ManualBypassMaxDuration: 2 * time.Minute,
+			ManualBypassMaxPerHour:  2,
+		})
+
+		until, err := detector.EnableManualBypass(time.Hour)
+		if err != nil {
+			t.Fatalf("EnableManualBypass failed: %v", err)
+		}
+		if d := time.Until(until); d > 2*time.Minute || d < time.Minute {
+			t.Errorf("Expected duration capped to 2m, got %v", d)
+		}
+		if !detector.IsInBypassMode() || !detector.IsManualBypass() {
+			t.Error("Expected an active manual bypass")
+		}
+
+		if _, err := detector.EnableManualBypass(0); err != nil {
+			t.Fatalf("Second bypass should be allowed: %v", err)
+		}
+		if _, err := detector.EnableManualBypass(0); err != ErrManualBypassRateLimited {
+			t.Errorf("Expected ErrManualBypassRateLimited, got %v", err)
+		}
+
+		detector.DisableBypass()
+		if detector.IsManualBypass() {
+			t.Error("Manual bypass should end when disabled")
+		}
+	})
+}
@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"crypto/rand"
+	"fmt"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// randomizeCase flips the case of each letter of name at random (DNS 0x20,
+// draft-vixie-dnsext-dns0x20). Resolvers echo the question as sent, so an
+// off-path attacker forging an answer must also guess the case pattern:
+// one more bit of entropy per letter on top of the ID and source port.
+func randomizeCase(name string) string {
+	bits := make([]byte, len(name))
+	if _, err := rand.Read(bits); err != nil {
+		return name
+	}
+	b := []byte(name)
+	for i, c := range b {
+		if bits[i]&1 == 0 {
+			continue
+		}
+		switch {
+		case c >= 'a' && c <= 'z':
+			b[i] = c - 'a' + 'A'
+		case c >= 'A' && c <= 'Z':
+			b[i] = c - 'A' + 'a'
+		}
+	}
+	return string(b)
+}
+
+// checkQuestion verifies that resp answers r: same name, type and class.
+// The name is compared case-sensitively only if exactCase is set, when its
+// case was randomized, as plenty of resolvers answer in lowercase. Error
+// answers may omit the question, as some servers leave it out of them.
+func checkQuestion(r, resp *dns.Msg, exactCase bool) error {
+	if len(r.Question) == 0 {
+		return nil
+	}
+	if len(resp.Question) == 0 {
+		if resp.Rcode != dns.RcodeSuccess && resp.Rcode != dns.RcodeNameError && len(resp.Answer) == 0 {
+			return nil
+		}
+		return fmt.Errorf("answer has no question")
+	}
+	want, got := r.Question[0], resp.Question[0]
+	sameName := got.Name == want.Name || !exactCase && strings.EqualFold(got.Name, want.Name)
+	if !sameName || got.Qtype != want.Qtype || got.Qclass != want.Qclass {
+		return fmt.Errorf("answer is for %s %s, not %s %s", got.Name, dns.TypeToString[got.Qtype], want.Name, dns.TypeToString[want.Qtype])
+	}
+	return nil
+}
+
+// restoreCase puts the client's spelling of the name back into resp, which
+// answers the same name spelled as sent
+func restoreCase(resp *dns.Msg, sent, original string) {
+	if sent == original {
+		return
+	}
+	for i := range resp.Question {
+		if strings.EqualFold(resp.Question[i].Name, sent) {
+			resp.Question[i].Name = original
+		}
+	}
+	for _, section := range [][]dns.RR{resp.Answer, resp.Ns, resp.Extra} {
+		for _, rr := range section {
+			if strings.EqualFold(rr.Header().Name, sent) {
+				rr.Header().Name = original
+			}
+		}
+	}
+}
@@ -0,0 +1,162 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+func TestRandomizeCase(t *testing.T) {
+	name := "www.example-host123.com."
+	mixed := false
+	for i := 0; i < 20; i++ {
+		got := randomizeCase(name)
+		if !strings.EqualFold(got, name) {
+			t.Fatalf("randomizeCase(%q) = %q, want the same name", name, got)
+		}
+		if got != name {
+			mixed = true
+		}
+	}
+	if !mixed {
+		t.Error("randomizeCase never changed the case")
+	}
+}
+
+// startLowercasingUpstream starts an upstream that answers with the
+// question lowercased, like a resolver that doesn't preserve case or a
+// forged answer
+func startLowercasingUpstream(t *testing.T) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Question[0].Name = strings.ToLower(m.Question[0].Name)
+			a, _ := dns.NewRR(m.Question[0].Name + " 60 IN A 192.0.2.66")
+			m.Answer = []dns.RR{a}
+			w.WriteMsg(m)
+		}),
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestHandlerCaseRandomization(t *testing.T) {
+	var mu sync.Mutex
+	var seen []string
+	good := startTestUpstream(t, func(q dns.Question) []dns.RR {
+		mu.Lock()
+		seen = append(seen, q.Name)
+		mu.Unlock()
+		a, _ := dns.NewRR(q.Name + " 60 IN A 192.0.2.67")
+		return []dns.RR{a}
+	})
+	bad := startLowercasingUpstream(t)
+
+	h := NewHandler(NewBlocker(), &config.DNSConfig{
+		Upstreams:         []string{bad, good},
+		Strategy:          StrategySequential,
+		CacheSize:         100,
+		CacheTTL:          time.Minute,
+		CaseRandomization: true,
+	}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+
+	// Long enough that an all-lowercase randomization is vanishingly rare
+	const name = "randomized-case-check.example.com."
+	req := new(dns.Msg)
+	req.SetQuestion(name, dns.TypeA)
+	w := &testResponseWriter{}
+	h.ServeDNS(w, req)
+
+	if w.msg == nil || len(w.msg.Answer) != 1 {
+		t.Fatalf("got %v, want one answer", w.msg)
+	}
+	if a := w.msg.Answer[0].(*dns.A); !a.A.Equal(net.ParseIP("192.0.2.67")) {
+		t.Errorf("answer %v came from the upstream that didn't echo the case", a.A)
+	}
+	if w.msg.Question[0].Name != name || w.msg.Answer[0].Header().Name != name {
+		t.Errorf("client got %q / %q, want its own spelling %q", w.msg.Question[0].Name, w.msg.Answer[0].Header().Name, name)
+	}
+
+	mu.Lock()
+	defer mu.Unlock()
+	if len(seen) != 1 || seen[0] == name || !strings.EqualFold(seen[0], name) {
+		t.Errorf("upstream saw %q, want %q in mixed case", seen, name)
+	}
+}
+
+func TestHandlerAcceptsLowercasedAnswers(t *testing.T) {
+	h := NewHandler(NewBlocker(), &config.DNSConfig{
+		Upstreams: []string{startLowercasingUpstream(t)},
+		Strategy:  StrategySequential,
+		CacheSize: 100,
+		CacheTTL:  time.Minute,
+	}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+
+	const name = "Mixed-Case.Example.com."
+	req := new(dns.Msg)
+	req.SetQuestion(name, dns.TypeA)
+	w := &testResponseWriter{}
+	h.ServeDNS(w, req)
+
+	if w.msg == nil || len(w.msg.Answer) != 1 {
+		t.Fatalf("got %v, want the lowercased answer with randomization off", w.msg)
+	}
+	if a := w.msg.Answer[0].(*dns.A); !a.A.Equal(net.ParseIP("192.0.2.66")) {
+		t.Errorf("answer = %v, want 192.0.2.66", a.A)
+	}
+}
+
+func TestCheckQuestion(t *testing.T) {
+	query := new(dns.Msg)
+	query.SetQuestion("ExAmple.com.", dns.TypeA)
+
+	reply := new(dns.Msg)
+	reply.SetReply(query)
+	if err := checkQuestion(query, reply, true); err != nil {
+		t.Errorf("echoed question rejected: %v", err)
+	}
+
+	reply.Question[0].Name = "example.com."
+	if err := checkQuestion(query, reply, false); err != nil {
+		t.Errorf("lowercased question rejected without randomization: %v", err)
+	}
+	if err := checkQuestion(query, reply, true); err == nil {
+		t.Error("lowercased question accepted with randomization")
+	}
+
+	reply.Question[0].Name = "other.com."
+	if err := checkQuestion(query, reply, false); err == nil {
+		t.Error("answer for another name accepted")
+	}
+
+	reply.Question[0].Name = query.Question[0].Name
+	reply.Question[0].Qtype = dns.TypeAAAA
+	if err := checkQuestion(query, reply, false); err == nil {
+		t.Error("answer for another type accepted")
+	}
+
+	servfail := new(dns.Msg)
+	servfail.Id = query.Id
+	servfail.Rcode = dns.RcodeServerFailure
+	if err := checkQuestion(query, servfail, true); err != nil {
+		t.Errorf("SERVFAIL without a question rejected: %v", err)
+	}
+}
@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"fmt"
+	"regexp"
+	"sort"
+	"sync/atomic"
+)
+
+// validCategoryName matches category names from the category registry
+var validCategoryName = regexp.MustCompile(`^[a-z0-9][a-z0-9_-]*$`)
+
+// blockCategory counts the blocks by the lists of one registry category
+type blockCategory struct {
+	name string
+	hits atomic.Uint64
+}
+
+// CategoryHits reports how many queries a category's lists have blocked
+type CategoryHits struct {
+	Category string
+	Hits     uint64
+}
+
+// UpdateCategories records which category each blocklist source belongs
+// to, so blocks by those lists report the category. Hit counts restart.
+func (b *Blocker) UpdateCategories(sources map[string]string) error {
+	byName := make(map[string]*blockCategory)
+	categories := make(map[string]*blockCategory, len(sources))
+	for source, name := range sources {
+		if !validCategoryName.MatchString(name) {
+			return fmt.Errorf("invalid category name %q", name)
+		}
+		switch name {
+		case CategoryBlocklist, CategorySecurity, CategoryAllowOnly, CategoryNRD, CategoryDGA, CategoryHomograph:
+			return fmt.Errorf("category name %q is reserved", name)
+		}
+		if byName[name] == nil {
+			byName[name] = &blockCategory{name: name}
+		}
+		categories[source] = byName[name]
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.categories = categories
+	return nil
+}
+
+// CategoryHits returns each category with the number of queries its lists
+// have blocked
+func (b *Blocker) CategoryHits() []CategoryHits {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	seen := make(map[*blockCategory]bool)
+	var hits []CategoryHits
+	for _, category := range b.categories {
+		if !seen[category] {
+			seen[category] = true
+			hits = append(hits, CategoryHits{Category: category.name, Hits: category.hits.Load()})
+		}
+	}
+	sort.Slice(hits, func(i, j int) bool { return hits[i].Category < hits[j].Category })
+	return hits
+}
+
+// categorize sets the category of a blocklist match and, if count is set,
+// counts the hit. Callers must hold b.mu.
+func (b *Blocker) categorize(match BlockMatch, count bool) BlockMatch {
+	if category := b.categories[match.Source]; category != nil {
+		if count {
+			category.hits.Add(1)
+		}
+		match.ListCategory = category.name
+	}
+	return match
+}
@@ -0,0 +1,43 @@
+package dns
+
+import "testing"
+
+func TestBlockCategories(t *testing.T) {
+	b := NewBlocker()
+	if err := b.UpdateDomainsWithSources([]string{"ads.example.net", "casino.example.org", "other.example.com"}, map[string]string{
+		"ads.example.net":    "https://lists.example/ads.txt",
+		"casino.example.org": "https://lists.example/gambling.txt",
+		"other.example.com":  "https://lists.example/misc.txt",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.UpdateCategories(map[string]string{
+		"https://lists.example/ads.txt":      "ads",
+		"https://lists.example/gambling.txt": "gambling",
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, want := range map[string]string{
+		"ads.example.net":       "ads",
+		"cdn.ads.example.net":   "ads",
+		"casino.example.org":    "gambling",
+		"other.example.com":     CategoryBlocklist,
+		"unblocked.example.com": "",
+	} {
+		if got := b.Check(domain).Category(); got != want {
+			t.Errorf("Check(%s).Category() = %q, want %q", domain, got, want)
+		}
+	}
+
+	hits := b.CategoryHits()
+	if len(hits) != 2 || hits[0] != (CategoryHits{Category: "ads", Hits: 2}) || hits[1] != (CategoryHits{Category: "gambling", Hits: 1}) {
+		t.Errorf("CategoryHits() = %+v, want ads 2 and gambling 1", hits)
+	}
+
+	for _, name := range []string{"security", "Ads", "../ads", ""} {
+		if err := b.UpdateCategories(map[string]string{"https://lists.example/x.txt": name}); err == nil {
+			t.Errorf("UpdateCategories() accepted category %q", name)
+		}
+	}
+}
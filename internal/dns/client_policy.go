@@ -0,0 +1,33 @@
+package dns
+
+import (
+	"net"
+)
+
+// clientBlocker applies a separate blocker to queries from a client range
+type clientBlocker struct {
+	network *net.IPNet
+	blocker *Blocker
+}
+
+// SetClientBlocker makes queries from network use blocker instead of the
+// device's own rules, for shared resolvers serving VMs or other hosts.
+// When ranges overlap the most specific one wins. It must be called before
+// the server starts.
+func (h *Handler) SetClientBlocker(network *net.IPNet, blocker *Blocker) {
+	h.clientBlockers = append(h.clientBlockers, clientBlocker{network: network, blocker: blocker})
+}
+
+// blockerFor returns the blocker for queries from clientIP
+func (h *Handler) blockerFor(clientIP net.IP) *Blocker {
+	best, bestBits := h.blocker, -1
+	for _, cb := range h.clientBlockers {
+		if !cb.network.Contains(clientIP) {
+			continue
+		}
+		if bits, _ := cb.network.Mask.Size(); bits > bestBits {
+			best, bestBits = cb.blocker, bits
+		}
+	}
+	return best
+}
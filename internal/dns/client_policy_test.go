@@ -0,0 +1,52 @@
+package dns
+
+import (
+	"net"
+	"testing"
+
+	"github.com/miekg/dns"
+)
+
+func TestClientBlockers(t *testing.T) {
+	h := newTestHandler(t)
+	guests, lab := NewBlocker(), NewBlocker()
+	if err := guests.UpdateDomains([]string{"social.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	guests.UpdateMetadata("", "guests")
+
+	for cidr, blocker := range map[string]*Blocker{"127.0.0.0/8": guests, "127.0.0.128/25": lab} {
+		_, network, err := net.ParseCIDR(cidr)
+		if err != nil {
+			t.Fatal(err)
+		}
+		h.SetClientBlocker(network, blocker)
+	}
+
+	tests := []struct {
+		ip   string
+		want *Blocker
+	}{
+		{"127.0.0.1", guests},
+		{"127.0.0.200", lab},
+		{"::ffff:127.0.0.1", guests},
+		{"10.0.0.1", h.blocker},
+		{"fd00::1", h.blocker},
+	}
+	for _, tt := range tests {
+		if got := h.blockerFor(net.ParseIP(tt.ip)); got != tt.want {
+			t.Errorf("blockerFor(%s) picked the wrong blocker", tt.ip)
+		}
+	}
+
+	// Queries from 127.0.0.1 use the guest rules, including for block events
+	var events []BlockEvent
+	h.SetBlockedCallback(func(e BlockEvent) { events = append(events, e) })
+	req := new(dns.Msg)
+	req.SetQuestion("www.social.example.com.", dns.TypeA)
+	w := &testResponseWriter{}
+	h.ServeDNS(w, req)
+	if len(events) != 1 || events[0].Group != "guests" {
+		t.Errorf("block events = %+v, want one for group guests", events)
+	}
+}
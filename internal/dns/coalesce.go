@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// inflightQuery is an upstream exchange that identical queries arriving
+// meanwhile wait on instead of sending their own
+type inflightQuery struct {
+	done     chan struct{}
+	resp     *dns.Msg // Shared, read-only; nil if every upstream failed
+	upstream string
+	rtt      time.Duration
+}
+
+// inflightKey identifies queries that get the same upstream answer
+func inflightKey(r *dns.Msg) string {
+	q := r.Question[0]
+	key := strings.ToLower(q.Name) + "|" + strconv.Itoa(int(q.Qtype)) + "|" + strconv.Itoa(int(q.Qclass))
+	if opt := r.IsEdns0(); opt != nil && opt.Do() {
+		key += "|do"
+	}
+	if r.CheckingDisabled {
+		key += "|cd"
+	}
+	if ecs := clientSubnet(r); ecs != nil {
+		// Forwarded client subnets may get different answers
+		key += "|" + ecs.String()
+	}
+	return key
+}
+
+// forwardCoalesced forwards r like forwardToUpstream, except that a query
+// identical to one already waiting on an upstream (same name, type, class
+// and DNSSEC flags) shares its answer, so a burst of lookups for one name
+// costs a single upstream exchange
+func (h *Handler) forwardCoalesced(r *dns.Msg) (*dns.Msg, string, time.Duration) {
+	if len(r.Question) == 0 {
+		return h.forwardToUpstream(r)
+	}
+	key := inflightKey(r)
+
+	h.inflightMu.Lock()
+	if call, ok := h.inflight[key]; ok {
+		h.inflightMu.Unlock()
+		<-call.done
+		if call.resp == nil {
+			return nil, "", 0
+		}
+		// Each client gets its own copy with its ID and question casing
+		resp := call.resp.Copy()
+		resp.Id = r.Id
+		resp.Question = append([]dns.Question(nil), r.Question...)
+		return resp, call.upstream, call.rtt
+	}
+	if h.inflight == nil {
+		h.inflight = make(map[string]*inflightQuery)
+	}
+	call := &inflightQuery{done: make(chan struct{})}
+	h.inflight[key] = call
+	h.inflightMu.Unlock()
+
+	resp, upstream, rtt := h.forwardToUpstream(r)
+	if resp != nil {
+		call.resp = resp.Copy()
+	}
+	call.upstream, call.rtt = upstream, rtt
+
+	h.inflightMu.Lock()
+	delete(h.inflight, key)
+	h.inflightMu.Unlock()
+	close(call.done)
+
+	return resp, upstream, rtt
+}
@@ -0,0 +1,58 @@
+package dns
+
+import (
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+func TestHandlerCoalescesIdenticalQueries(t *testing.T) {
+	var exchanges atomic.Int32
+	upstream := startTestUpstream(t, func(q dns.Question) []dns.RR {
+		exchanges.Add(1)
+		time.Sleep(100 * time.Millisecond) // Keep the first query in flight
+		a, _ := dns.NewRR(q.Name + " 60 IN A 192.0.2.40")
+		return []dns.RR{a}
+	})
+	h := NewHandler(NewBlocker(), &config.DNSConfig{Upstreams: []string{upstream}, CacheSize: 100, CacheTTL: time.Minute}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+
+	const clients = 20
+	var wg sync.WaitGroup
+	replies := make([]*dns.Msg, clients)
+	ids := make([]uint16, clients)
+	for i := 0; i < clients; i++ {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			req := new(dns.Msg)
+			req.SetQuestion("Popular.Example.com.", dns.TypeA)
+			ids[i] = req.Id
+			w := &testResponseWriter{}
+			h.ServeDNS(w, req)
+			replies[i] = w.msg
+		}(i)
+	}
+	wg.Wait()
+
+	if n := exchanges.Load(); n != 1 {
+		t.Errorf("upstream saw %d queries, want 1", n)
+	}
+	for i, m := range replies {
+		if m == nil || len(m.Answer) != 1 || m.Id != ids[i] {
+			t.Fatalf("client %d got %v, want the answer with its own ID %d", i, m, ids[i])
+		}
+	}
+
+	// Other types are separate queries
+	req := new(dns.Msg)
+	req.SetQuestion("popular.example.com.", dns.TypeAAAA)
+	h.ServeDNS(&testResponseWriter{}, req)
+	if n := exchanges.Load(); n != 2 {
+		t.Errorf("upstream saw %d queries after an AAAA query, want 2", n)
+	}
+}
@@ -0,0 +1,492 @@
+package dns
+
+import (
+	"fmt"
+	"math"
+	"math/bits"
+	"sort"
+	"strings"
+)
+
+// compactTrie is a read-only domainTrie for the large blocklists, built
+// once by a trieBuilder and replaced as a whole when the rules change.
+//
+// Chains of labels without rules or branches are compressed into a single
+// edge (ads.tracker under com), the nodes are kept in one slice with the
+// text of every rule in one string, and children are found through one
+// hash table for the whole trie, so a million rules take a fraction of the
+// memory of a node and a map per label. A bloom filter over the names with
+// rules answers most lookups of names no rule covers without walking the
+// trie at all.
+type compactTrie struct {
+	// nodes[0] is the root; the children of a node are contiguous
+	nodes []compactNode
+	// children holds the index of every other node, placed by its parent
+	// and the hash of its first label, with zero for free slots
+	children []uint32
+	// text holds every rule back to back; rules and edges are substrings
+	text    string
+	sources []string
+	// Rules matching only subdomains, few enough to keep out of the nodes
+	wildcards []compactRule
+	filter    bloomFilter
+	size      int
+}
+
+type compactNode struct {
+	// hash of the first label of the edge, the one next to the parent
+	hash uint32
+	// Labels from the parent to this node, e.g. ads.tracker
+	edgeOff uint32
+	edgeLen uint16
+	// Rule matching this name and its subdomains
+	ruleLen uint16
+	ruleOff uint32
+	source  uint32
+	// Index in wildcards plus one of the rule matching only subdomains of
+	// this name
+	wildcard uint32
+	// Children are nodes[firstChild : firstChild+numChildren]
+	firstChild  uint32
+	numChildren uint32
+}
+
+// compactRule is a rule in compactTrie.text with its source
+type compactRule struct {
+	off    uint32
+	len    uint16
+	source uint32
+}
+
+// Match finds the rule covering domain, like domainTrie.Match
+func (t *compactTrie) Match(domain string) (rule, source string, ok bool) {
+	return t.match(domain, nil)
+}
+
+// match is Match ignoring the rules in skip
+func (t *compactTrie) match(domain string, skip map[string]bool) (rule, source string, ok bool) {
+	if !t.mayMatch(domain) {
+		return "", "", false
+	}
+
+	var node uint32
+	end := len(domain)
+	for end > 0 {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		child, found := t.child(node, domain[start:end])
+		if !found {
+			break
+		}
+		// The rest of the edge's labels must come next in domain
+		edge := t.edge(&t.nodes[child])
+		start = end - len(edge)
+		if start < 0 || domain[start:end] != edge || (start > 0 && domain[start-1] != '.') {
+			break
+		}
+		node = child
+
+		n := &t.nodes[node]
+		if n.ruleLen > 0 {
+			if r, s, _ := t.rule(compactRule{n.ruleOff, n.ruleLen, n.source}); !skip[r] {
+				rule, source, ok = r, s, true
+			}
+		}
+		// A wildcard matches only when labels remain below it
+		if start > 0 && n.wildcard > 0 {
+			if r, s, _ := t.rule(t.wildcards[n.wildcard-1]); !skip[r] {
+				rule, source, ok = r, s, true
+			}
+		}
+		end = start - 1
+	}
+	return rule, source, ok
+}
+
+// Lookup returns the source of rule if the trie holds it
+func (t *compactTrie) Lookup(rule string) (source string, ok bool) {
+	domain, subdomainsOnly, err := parseDomainRule(rule)
+	if err != nil {
+		return "", false
+	}
+
+	var node uint32
+	end := len(domain)
+	for end > 0 {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		child, found := t.child(node, domain[start:end])
+		if !found {
+			return "", false
+		}
+		edge := t.edge(&t.nodes[child])
+		start = end - len(edge)
+		if start < 0 || domain[start:end] != edge || (start > 0 && domain[start-1] != '.') {
+			return "", false
+		}
+		node, end = child, start-1
+	}
+
+	n := &t.nodes[node]
+	var r compactRule
+	switch {
+	case subdomainsOnly && n.wildcard > 0:
+		r = t.wildcards[n.wildcard-1]
+	case !subdomainsOnly && n.ruleLen > 0:
+		r = compactRule{n.ruleOff, n.ruleLen, n.source}
+	default:
+		return "", false
+	}
+	if found, source, _ := t.rule(r); found == rule {
+		return source, true
+	}
+	return "", false
+}
+
+// mayMatch reports whether domain or one of its parents may have a rule,
+// hashing them all in one pass from the end of the name
+func (t *compactTrie) mayMatch(domain string) bool {
+	h := uint64(fnvOffset64)
+	for i := len(domain) - 1; i >= 0; i-- {
+		h = (h ^ uint64(domain[i])) * fnvPrime64
+		if (i == 0 || domain[i-1] == '.') && t.filter.mayContain(h) {
+			return true
+		}
+	}
+	return false
+}
+
+// child finds the child of node whose edge starts with label
+func (t *compactTrie) child(node uint32, label string) (uint32, bool) {
+	parent := &t.nodes[node]
+	if parent.numChildren == 0 {
+		return 0, false
+	}
+	hash := labelHash(label)
+	mask := uint64(len(t.children) - 1)
+	for i := childSlot(node, hash, mask); ; i = (i + 1) & mask {
+		c := t.children[i]
+		switch {
+		case c == 0:
+			return 0, false
+		case c-parent.firstChild < parent.numChildren && t.nodes[c].hash == hash && t.firstLabel(&t.nodes[c]) == label:
+			return c, true
+		}
+	}
+}
+
+// childSlot returns where in children the search for the child of parent
+// with the label hash starts
+func childSlot(parent, hash uint32, mask uint64) uint64 {
+	return (uint64(parent)<<32 | uint64(hash)) * 0x9e3779b97f4a7c15 >> 32 & mask
+}
+
+func (t *compactTrie) edge(node *compactNode) string {
+	return t.text[node.edgeOff : node.edgeOff+uint32(node.edgeLen)]
+}
+
+// firstLabel returns the label of node's edge next to its parent
+func (t *compactTrie) firstLabel(node *compactNode) string {
+	edge := t.edge(node)
+	return edge[strings.LastIndexByte(edge, '.')+1:]
+}
+
+func (t *compactTrie) rule(r compactRule) (rule, source string, ok bool) {
+	return t.text[r.off : r.off+uint32(r.len)], t.sources[r.source], true
+}
+
+// Len returns the number of rules
+func (t *compactTrie) Len() int {
+	return t.size
+}
+
+// Rules calls fn for every rule with its source
+func (t *compactTrie) Rules(fn func(rule, source string)) {
+	for i := range t.nodes {
+		if node := &t.nodes[i]; node.ruleLen > 0 {
+			rule, source, _ := t.rule(compactRule{node.ruleOff, node.ruleLen, node.source})
+			fn(rule, source)
+		}
+	}
+	for _, r := range t.wildcards {
+		rule, source, _ := t.rule(r)
+		fn(rule, source)
+	}
+}
+
+// trieBuilder collects rules for a compactTrie. Adding the same rule again
+// replaces its source, and of rules for the same name in different
+// syntaxes (example.com and ||example.com^) the last one added is kept.
+type trieBuilder struct {
+	entries []builderEntry
+}
+
+type builderEntry struct {
+	rule   string
+	source string
+	// key is the domain with its labels reversed and separated by zero
+	// bytes (com\x00example\x00ads), so that sorting puts every name right
+	// before its subdomains
+	key            string
+	domainOff      int // Where the domain starts in rule
+	subdomainsOnly bool
+	order          int
+}
+
+func newTrieBuilder(capacity int) *trieBuilder {
+	return &trieBuilder{entries: make([]builderEntry, 0, capacity)}
+}
+
+// Add stores a rule, in the syntax domainTrie.Add accepts
+func (b *trieBuilder) Add(rule, source string) error {
+	domain, subdomainsOnly, err := parseDomainRule(rule)
+	if err != nil {
+		return err
+	}
+	if len(rule) > math.MaxUint16 {
+		return fmt.Errorf("rule too long: %d bytes", len(rule))
+	}
+
+	var key strings.Builder
+	key.Grow(len(domain))
+	end := len(domain)
+	for end >= 0 {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		if key.Len() > 0 {
+			key.WriteByte(0)
+		}
+		key.WriteString(domain[start:end])
+		end = start - 1
+	}
+
+	b.entries = append(b.entries, builderEntry{
+		rule:           rule,
+		source:         source,
+		key:            key.String(),
+		domainOff:      strings.Index(rule, domain),
+		subdomainsOnly: subdomainsOnly,
+		order:          len(b.entries),
+	})
+	return nil
+}
+
+// Build returns the trie of the rules added. The builder can't be used
+// afterwards.
+func (b *trieBuilder) Build() *compactTrie {
+	entries := b.entries
+	b.entries = nil
+	sort.Slice(entries, func(i, j int) bool {
+		if entries[i].key != entries[j].key {
+			return entries[i].key < entries[j].key
+		}
+		if entries[i].subdomainsOnly != entries[j].subdomainsOnly {
+			return !entries[i].subdomainsOnly
+		}
+		return entries[i].order < entries[j].order
+	})
+
+	// Keep the last rule added of each kind for each name
+	kept := entries[:0]
+	for i, e := range entries {
+		if i+1 < len(entries) && entries[i+1].key == e.key && entries[i+1].subdomainsOnly == e.subdomainsOnly {
+			continue
+		}
+		kept = append(kept, e)
+	}
+
+	t := &compactTrie{
+		nodes:  make([]compactNode, 1, len(kept)+len(kept)/16+1),
+		filter: newBloomFilter(len(kept)),
+		size:   len(kept),
+	}
+	textLen := 0
+	for _, e := range kept {
+		textLen += len(e.rule)
+	}
+	var text strings.Builder
+	text.Grow(textLen)
+	ruleOffs := make([]uint32, len(kept))
+	for i, e := range kept {
+		ruleOffs[i] = uint32(text.Len())
+		text.WriteString(e.rule)
+		t.filter.add(domainHash(e.rule[e.domainOff : e.domainOff+len(e.key)]))
+	}
+	t.text = text.String()
+
+	build := &trieBuild{trie: t, entries: kept, ruleOffs: ruleOffs, sourceIDs: make(map[string]uint32)}
+	build.node(0, 0, len(kept), 0)
+	if spare := cap(t.nodes) - len(t.nodes); spare > len(t.nodes)/16 {
+		t.nodes = append([]compactNode(nil), t.nodes...)
+	}
+
+	// At most 80% full, so a search soon reaches a free slot
+	size := 1 << bits.Len(uint(len(t.nodes)*5/4))
+	t.children = make([]uint32, size)
+	mask := uint64(size - 1)
+	for parent := range t.nodes {
+		node := &t.nodes[parent]
+		for c := node.firstChild; c < node.firstChild+node.numChildren; c++ {
+			i := childSlot(uint32(parent), t.nodes[c].hash, mask)
+			for t.children[i] != 0 {
+				i = (i + 1) & mask
+			}
+			t.children[i] = c
+		}
+	}
+	return t
+}
+
+// trieBuild lays out the nodes of a compactTrie from sorted entries
+type trieBuild struct {
+	trie      *compactTrie
+	entries   []builderEntry
+	ruleOffs  []uint32
+	sourceIDs map[string]uint32
+}
+
+// node fills in nodes[index], the name shared by the keys of
+// entries[lo:hi] up to byte p, and lays out its children
+func (b *trieBuild) node(index uint32, lo, hi, p int) {
+	// Entries for the name itself sort first
+	for ; lo < hi && len(b.entries[lo].key) == p; lo++ {
+		e := &b.entries[lo]
+		r := compactRule{b.ruleOffs[lo], uint16(len(e.rule)), b.source(e.source)}
+		n := &b.trie.nodes[index]
+		if e.subdomainsOnly {
+			b.trie.wildcards = append(b.trie.wildcards, r)
+			n.wildcard = uint32(len(b.trie.wildcards))
+		} else {
+			n.ruleOff, n.ruleLen, n.source = r.off, r.len, r.source
+		}
+	}
+
+	s := p
+	if p > 0 {
+		s++ // Past the separator
+	}
+	type childRange struct {
+		lo, hi int
+		p      int
+	}
+	var children []childRange
+	firstChild := uint32(len(b.trie.nodes))
+	for lo < hi {
+		first := b.entries[lo].key
+		labelEnd := labelEndAt(first, s)
+		label := first[s:labelEnd]
+
+		// The entries under this label
+		ghi := lo + 1
+		for ghi < hi && labelEndAt(b.entries[ghi].key, s) == labelEnd && b.entries[ghi].key[s:labelEnd] == label {
+			ghi++
+		}
+
+		// The edge runs on while no name on it has a rule and every entry
+		// continues with the same label
+		q := labelEnd
+		last := b.entries[ghi-1].key
+		for len(first) > q {
+			next := labelEndAt(first, q+1)
+			if len(last) < next || last[:next] != first[:next] || (len(last) > next && last[next] != 0) {
+				break
+			}
+			q = next
+		}
+
+		// Key bytes [s, q) are the domain's last q bytes but the last s
+		e := &b.entries[lo]
+		domainEnd := b.ruleOffs[lo] + uint32(e.domainOff+len(e.key))
+		b.trie.nodes = append(b.trie.nodes, compactNode{
+			hash:    labelHash(label),
+			edgeOff: domainEnd - uint32(q),
+			edgeLen: uint16(q - s),
+		})
+		children = append(children, childRange{lo: lo, hi: ghi, p: q})
+		lo = ghi
+	}
+
+	b.trie.nodes[index].firstChild, b.trie.nodes[index].numChildren = firstChild, uint32(len(children))
+	for i, c := range children {
+		b.node(firstChild+uint32(i), c.lo, c.hi, c.p)
+	}
+}
+
+// source returns the index of source in the trie's sources
+func (b *trieBuild) source(source string) uint32 {
+	id, ok := b.sourceIDs[source]
+	if !ok {
+		id = uint32(len(b.trie.sources))
+		b.trie.sources = append(b.trie.sources, source)
+		b.sourceIDs[source] = id
+	}
+	return id
+}
+
+// labelEndAt returns where the label of key starting at i ends
+func labelEndAt(key string, i int) int {
+	if end := strings.IndexByte(key[i:], 0); end >= 0 {
+		return i + end
+	}
+	return len(key)
+}
+
+const (
+	fnvOffset32 = 2166136261
+	fnvPrime32  = 16777619
+	fnvOffset64 = 14695981039346656037
+	fnvPrime64  = 1099511628211
+)
+
+// labelHash is the 32-bit FNV-1a hash of a label
+func labelHash(label string) uint32 {
+	h := uint32(fnvOffset32)
+	for i := 0; i < len(label); i++ {
+		h = (h ^ uint32(label[i])) * fnvPrime32
+	}
+	return h
+}
+
+// domainHash is the 64-bit FNV-1a hash of domain read from its end, so
+// the hashes of a name and all of its parents come out of one pass
+func domainHash(domain string) uint64 {
+	h := uint64(fnvOffset64)
+	for i := len(domain) - 1; i >= 0; i-- {
+		h = (h ^ uint64(domain[i])) * fnvPrime64
+	}
+	return h
+}
+
+// Bloom filter sizing, for about 2% false positives
+const (
+	bloomBitsPerKey = 10
+	bloomHashes     = 6
+)
+
+// bloomFilter is a blocked bloom filter: every key sets its bits in a
+// single word, so a lookup touches one cache line
+type bloomFilter struct {
+	words []uint64
+}
+
+func newBloomFilter(keys int) bloomFilter {
+	return bloomFilter{words: make([]uint64, keys*bloomBitsPerKey/64+1)}
+}
+
+func (f bloomFilter) add(h uint64) {
+	i, mask := f.locate(h)
+	f.words[i] |= mask
+}
+
+func (f bloomFilter) mayContain(h uint64) bool {
+	i, mask := f.locate(h)
+	return f.words[i]&mask == mask
+}
+
+// locate returns the word of hash h and the bits it sets there
+func (f bloomFilter) locate(h uint64) (int, uint64) {
+	i, _ := bits.Mul64(h, uint64(len(f.words)))
+	h *= 0x9e3779b97f4a7c15 // Mixed again so the bits don't follow the word
+	var mask uint64
+	for j := 0; j < bloomHashes; j++ {
+		mask |= 1 << (h >> (58 - 6*j) & 63)
+	}
+	return int(i), mask
+}
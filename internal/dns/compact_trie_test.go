@@ -0,0 +1,224 @@
+package dns
+
+import (
+	"fmt"
+	"math/rand"
+	"runtime"
+	"testing"
+)
+
+func TestCompactTrie(t *testing.T) {
+	builder := newTrieBuilder(0)
+	for _, rule := range [][2]string{
+		{"example.com", "list-a"},
+		{"*.wild.org", "list-b"},
+		{"||adblock.net^", "list-c"},
+		{"deep.sub.example.com", "list-d"},
+		{"a.b.c.chain.io", "list-e"},
+		{"x.b.c.chain.io", "list-e"},
+		{"example.com", "list-f"},
+	} {
+		if err := builder.Add(rule[0], rule[1]); err != nil {
+			t.Fatalf("Add(%q) error = %v", rule[0], err)
+		}
+	}
+	for _, rule := range []string{"*", "||", "ads.*.com", "||x.com^$third-party", "/ads/"} {
+		if err := builder.Add(rule, ""); err == nil {
+			t.Errorf("Add(%q) accepted unsupported syntax", rule)
+		}
+	}
+	trie := builder.Build()
+	if trie.Len() != 6 {
+		t.Errorf("Len() = %d, want 6", trie.Len())
+	}
+
+	tests := []struct {
+		domain string
+		rule   string
+		source string
+	}{
+		{"example.com", "example.com", "list-f"},
+		{"a.b.example.com", "example.com", "list-f"},
+		{"deep.sub.example.com", "deep.sub.example.com", "list-d"},
+		{"x.deep.sub.example.com", "deep.sub.example.com", "list-d"},
+		{"sub.example.com", "example.com", "list-f"},
+		{"notexample.com", "", ""},
+		{"wild.org", "", ""},
+		{"a.wild.org", "*.wild.org", "list-b"},
+		{"adblock.net", "||adblock.net^", "list-c"},
+		{"cdn.adblock.net", "||adblock.net^", "list-c"},
+		{"a.b.c.chain.io", "a.b.c.chain.io", "list-e"},
+		{"b.c.chain.io", "", ""},
+		{"c.chain.io", "", ""},
+		{"y.b.c.chain.io", "", ""},
+		{"com", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		rule, source, ok := trie.Match(tt.domain)
+		if ok != (tt.rule != "") || rule != tt.rule || source != tt.source {
+			t.Errorf("Match(%q) = %q, %q, %v; want %q, %q", tt.domain, rule, source, ok, tt.rule, tt.source)
+		}
+	}
+
+	rules := make(map[string]string)
+	trie.Rules(func(rule, source string) { rules[rule] = source })
+	if len(rules) != 6 || rules["example.com"] != "list-f" || rules["*.wild.org"] != "list-b" {
+		t.Errorf("Rules() = %v", rules)
+	}
+}
+
+// The compact trie must match exactly what a domainTrie of the same rules
+// matches
+func TestCompactTrieMatchesDomainTrie(t *testing.T) {
+	list := benchmarkBlocklist(50000)
+	trie, builder := newDomainTrie(), newTrieBuilder(len(list))
+	for i, rule := range list {
+		source := fmt.Sprintf("list-%d", i%3)
+		trie.Add(rule, source)
+		builder.Add(rule, source)
+	}
+	compact := builder.Build()
+	if compact.Len() != trie.Len() {
+		t.Fatalf("Len() = %d, want %d", compact.Len(), trie.Len())
+	}
+
+	r := rand.New(rand.NewSource(2))
+	for i := 0; i < 20000; i++ {
+		domain := list[r.Intn(len(list))]
+		switch i % 4 {
+		case 1:
+			domain = "x." + domain
+		case 2:
+			domain = domain[1:]
+		case 3:
+			domain = fmt.Sprintf("n%d.site%d.com", i, r.Intn(1000))
+		}
+		rule, source, ok := compact.Match(domain)
+		wantRule, wantSource, wantOK := trie.Match(domain)
+		if rule != wantRule || source != wantSource || ok != wantOK {
+			t.Fatalf("Match(%q) = %q, %q, %v; domainTrie has %q, %q, %v", domain, rule, source, ok, wantRule, wantSource, wantOK)
+		}
+	}
+}
+
+func TestBloomFilter(t *testing.T) {
+	filter := newBloomFilter(10000)
+	for i := 0; i < 10000; i++ {
+		filter.add(domainHash(fmt.Sprintf("in%d.example", i)))
+	}
+	falsePositives := 0
+	for i := 0; i < 10000; i++ {
+		if !filter.mayContain(domainHash(fmt.Sprintf("in%d.example", i))) {
+			t.Fatalf("in%d.example was added but isn't found", i)
+		}
+		if filter.mayContain(domainHash(fmt.Sprintf("out%d.example", i))) {
+			falsePositives++
+		}
+	}
+	if falsePositives > 500 {
+		t.Errorf("%d false positives in 10000, want under 5%%", falsePositives)
+	}
+}
+
+// benchmarkBlocklist returns n rules shaped like a large public blocklist:
+// registrable domains under common TLDs, with tracking subdomains of some
+func benchmarkBlocklist(n int) []string {
+	r := rand.New(rand.NewSource(1))
+	tlds := []string{"com", "com", "com", "net", "org", "io", "info", "xyz", "de", "ru", "top", "co.uk"}
+	word := func() string {
+		b := make([]byte, 5+r.Intn(10))
+		for i := range b {
+			b[i] = byte('a' + r.Intn(26))
+		}
+		return string(b)
+	}
+
+	var registered []string
+	rules := make([]string, 0, n)
+	for len(rules) < n {
+		switch x := r.Intn(100); {
+		case x < 45 || len(registered) < 100:
+			domain := word() + "." + tlds[r.Intn(len(tlds))]
+			registered = append(registered, domain)
+			rules = append(rules, domain)
+		case x < 85:
+			prefix := []string{"ads", "track", "metrics", "cdn", "pixel", word()}[r.Intn(6)]
+			rules = append(rules, fmt.Sprintf("%s%d.%s", prefix, r.Intn(50), registered[r.Intn(len(registered))]))
+		default:
+			rules = append(rules, word()+"."+word()+"."+registered[r.Intn(len(registered))])
+		}
+	}
+	return rules
+}
+
+// benchmarkQueries returns names to look up in the rules from
+// benchmarkBlocklist, half of them blocked
+func benchmarkQueries(rules []string) []string {
+	r := rand.New(rand.NewSource(3))
+	queries := make([]string, 4096)
+	for i := range queries {
+		if i%2 == 0 {
+			queries[i] = "www." + rules[r.Intn(len(rules))]
+		} else {
+			queries[i] = fmt.Sprintf("www.site%d.com", r.Int())
+		}
+	}
+	return queries
+}
+
+func BenchmarkCompactTrieMatch(b *testing.B) {
+	rules := benchmarkBlocklist(1000000)
+	builder := newTrieBuilder(len(rules))
+	for _, rule := range rules {
+		builder.Add(rule, "bench")
+	}
+	trie := builder.Build()
+	queries := benchmarkQueries(rules)
+
+	b.Run("blocked", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			trie.Match(queries[i%len(queries)&^1])
+		}
+	})
+	b.Run("allowed", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			trie.Match(queries[i%len(queries)|1])
+		}
+	})
+}
+
+// BenchmarkTrieMemory reports the heap a million rules take in each trie
+func BenchmarkTrieMemory(b *testing.B) {
+	rules := benchmarkBlocklist(1000000)
+	heap := func() uint64 {
+		runtime.GC()
+		var stats runtime.MemStats
+		runtime.ReadMemStats(&stats)
+		return stats.HeapAlloc
+	}
+
+	b.Run("domainTrie", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			before := heap()
+			trie := newDomainTrie()
+			for _, rule := range rules {
+				trie.Add(rule, "bench")
+			}
+			b.ReportMetric(float64(heap()-before)/float64(trie.Len()), "B/rule")
+			runtime.KeepAlive(trie)
+		}
+	})
+	b.Run("compactTrie", func(b *testing.B) {
+		for i := 0; i < b.N; i++ {
+			before := heap()
+			builder := newTrieBuilder(len(rules))
+			for _, rule := range rules {
+				builder.Add(rule, "bench")
+			}
+			trie := builder.Build()
+			b.ReportMetric(float64(heap()-before)/float64(trie.Len()), "B/rule")
+			runtime.KeepAlive(trie)
+		}
+	})
+}
@@ -0,0 +1,209 @@
+package dns
+
+import (
+	"math"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+// SourceDGA is reported for blocks of likely algorithmically generated
+// domains
+const SourceDGA = "dga"
+
+const (
+	// dgaReportInterval is how often the same flagged domain is reported
+	dgaReportInterval = time.Hour
+	// maxDGAReported bounds the domains remembered for deduplication
+	maxDGAReported = 10000
+)
+
+// commonBigrams are letter pairs frequent in English and in the words
+// domains are usually made of. Generated names use far fewer of them.
+var commonBigrams = func() map[string]bool {
+	const list = "th he in er an re on at en nd ti es or te of ed is it al ar st to nt ng " +
+		"se ha as ou io le ve co me de hi ri ro ic ne ea ra ce li ch ll be ma si om ur " +
+		"ca el ta la ns di fo ho pe ec pr no ct us ac ot il tr ly nc et ut ss so rs un " +
+		"lo wa ge ie wh ee wi em ad ol rt po we na ul ni ts mo ow pa im mi ai sh ir su " +
+		"id os iv ia am fi ci vi pl ig tu ev ld ry mp fe bl ab gh ty op wo sa ay ex ke " +
+		"fr oo av ag if ap gr od bo sp rd do uc bu ei ov by rm ep tt oc fa ef cu rn sc " +
+		"gi da yo cr cl du ga qu ue ff ba ey ls va um pp ua up lu go ht ru ug ds lt pi " +
+		"rc rr eg au ck ew mu br bi pt ak pu ui rg ib tl ny ki rk ys ob mm fu ph og ms " +
+		"ye ud mb ip ub oi rl gu dr hr cc tw ft wn nu af hu nn eo vo rv nf xp gn sm fl " +
+		"iz ok nl my gl aw ju oa sy sl ps jo lf nk kn gs dy hy ks xt bs ik dd cy sk"
+	set := make(map[string]bool)
+	for _, bigram := range strings.Fields(list) {
+		set[bigram] = true
+	}
+	return set
+}()
+
+// DGADetector scores domains for signs of being generated by malware
+// domain generation algorithms: high character entropy, letter pairs rare
+// in natural words and long consonant runs in a long label
+type DGADetector struct {
+	threshold float64
+	minLength int
+	enforce   bool
+
+	mu       sync.Mutex
+	reported map[string]time.Time // Domain -> when it was last reported
+}
+
+// DGAEvent describes a domain the detector flagged
+type DGAEvent struct {
+	Domain   string
+	Label    string // The label that was scored
+	Score    float64
+	ClientIP string
+	Blocked  bool // False in monitor mode
+}
+
+// NewDGADetector creates a detector from the dga config section
+func NewDGADetector(cfg *config.DGAConfig) *DGADetector {
+	return &DGADetector{
+		threshold: cfg.Threshold,
+		minLength: cfg.MinLength,
+		enforce:   cfg.Mode == config.DGAModeBlock,
+		reported:  make(map[string]time.Time),
+	}
+}
+
+// shouldReport reports whether a flagged domain hasn't been reported
+// within dgaReportInterval, and records that it now is
+func (d *DGADetector) shouldReport(domain string, now time.Time) bool {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	if last, ok := d.reported[domain]; ok && now.Sub(last) < dgaReportInterval {
+		return false
+	}
+	if len(d.reported) >= maxDGAReported {
+		d.reported = make(map[string]time.Time)
+	}
+	d.reported[domain] = now
+	return true
+}
+
+// Enforce reports whether flagged domains are blocked rather than only
+// reported
+func (d *DGADetector) Enforce() bool {
+	return d.enforce
+}
+
+// Check scores domain and reports whether it reaches the threshold
+func (d *DGADetector) Check(domain string) (score float64, label string, suspicious bool) {
+	label = registrableLabel(strings.ToLower(strings.TrimSuffix(domain, ".")))
+	if len(label) < d.minLength {
+		return 0, label, false
+	}
+	score = dgaScore(label)
+	return score, label, score >= d.threshold
+}
+
+// registrableLabel returns the label a domain was registered under, e.g.
+// "example" for www.example.co.uk. Only that label is scored: subdomains
+// of CDNs and cloud services are often random by design.
+func registrableLabel(domain string) string {
+	label, _, _ := strings.Cut(registrableDomain(domain), ".")
+	return label
+}
+
+// registrableDomain returns the domain a name was registered as, e.g.
+// example.co.uk for www.example.co.uk, or "" for a single label
+func registrableDomain(domain string) string {
+	labels := strings.Split(domain, ".")
+	if len(labels) < 2 {
+		return ""
+	}
+	i := len(labels) - 2
+	// Second-level public suffixes such as co.uk and com.au
+	if i > 0 && len(labels[len(labels)-1]) == 2 {
+		switch labels[i] {
+		case "co", "com", "net", "org", "ac", "gov", "edu", "ne", "or":
+			i--
+		}
+	}
+	return strings.Join(labels[i:], ".")
+}
+
+// dgaScore combines the label's features into a score from 0 to 1
+func dgaScore(label string) float64 {
+	chars := strings.ReplaceAll(label, "-", "")
+	if chars == "" {
+		return 0
+	}
+
+	// Shannon entropy, relative to the most a label this long can have
+	counts := make(map[rune]int)
+	for _, c := range chars {
+		counts[c]++
+	}
+	var entropy float64
+	for _, n := range counts {
+		p := float64(n) / float64(len(chars))
+		entropy -= p * math.Log2(p)
+	}
+	entropy /= math.Log2(math.Min(float64(len(chars)), 36))
+
+	// Share of adjacent pairs that are rare in words; pairs with digits count
+	rare, pairs := 0, 0
+	for i := 0; i+1 < len(chars); i++ {
+		pairs++
+		if !commonBigrams[chars[i:i+2]] {
+			rare++
+		}
+	}
+	rareRatio := float64(rare) / float64(pairs)
+
+	// Longest run of consonants and digits; words rarely exceed three
+	run, longest := 0, 0
+	for _, c := range chars {
+		if strings.ContainsRune("aeiouy", c) {
+			run = 0
+			continue
+		}
+		run++
+		if run > longest {
+			longest = run
+		}
+	}
+	consonants := math.Min(1, math.Max(0, float64(longest-2)/4))
+
+	return 0.45*rareRatio + 0.35*entropy + 0.2*consonants
+}
+
+// SetDGADetector makes the handler score queries with detector. cb is
+// called for flagged domains, blocked or not, at most hourly per domain.
+func (h *Handler) SetDGADetector(detector *DGADetector, cb func(event DGAEvent)) {
+	h.dga = detector
+	h.dgaCallback = cb
+}
+
+// checkDGA scores domain and reports flagged queries. It returns a block
+// match when the detector enforces. Allowlisted names are never flagged.
+func (h *Handler) checkDGA(blocker *Blocker, domain, clientIP string) (BlockMatch, bool) {
+	if h.dga == nil {
+		return BlockMatch{}, false
+	}
+	score, label, suspicious := h.dga.Check(domain)
+	if !suspicious || blocker.IsAllowlisted(domain) {
+		return BlockMatch{}, false
+	}
+
+	if h.dgaCallback != nil && h.dga.shouldReport(domain, time.Now()) {
+		h.dgaCallback(DGAEvent{
+			Domain:   domain,
+			Label:    label,
+			Score:    score,
+			ClientIP: clientIP,
+			Blocked:  h.dga.Enforce(),
+		})
+	}
+	if !h.dga.Enforce() {
+		return BlockMatch{}, false
+	}
+	return BlockMatch{Blocked: true, Rule: label, Source: SourceDGA}, true
+}
@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+func TestDGADetector(t *testing.T) {
+	d := NewDGADetector(&config.DGAConfig{Mode: config.DGAModeMonitor, Threshold: 0.75, MinLength: 10})
+
+	for domain, want := range map[string]bool{
+		"stackoverflow.com":           false,
+		"login.microsoftonline.com":   false,
+		"www.washingtonpost.com":      false,
+		"d3kfjq9zx8w7.cloudfront.net": false, // Random subdomains of services are normal
+		"news.bbc.co.uk":              false, // Short
+		"xjq7kd9fz2lp.com":            true,
+		"api.qwhtzrplkvbn.net":        true,
+		"kfjbvoqzuyxw.co.uk":          true,
+	} {
+		if _, _, got := d.Check(domain); got != want {
+			score, label, _ := d.Check(domain)
+			t.Errorf("Check(%s) = %v (label %s, score %.2f), want %v", domain, got, label, score, want)
+		}
+	}
+}
+
+func TestHandlerDGAModes(t *testing.T) {
+	query := func(h *Handler, name string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+		return w.msg
+	}
+
+	upstream := startTestUpstream(t, func(q dns.Question) []dns.RR {
+		a, _ := dns.NewRR(q.Name + " 60 IN A 192.0.2.30")
+		return []dns.RR{a}
+	})
+	h := NewHandler(NewBlocker(), &config.DNSConfig{Upstreams: []string{upstream}, CacheSize: 100, CacheTTL: time.Minute}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+	if err := h.blocker.UpdateAllowlist([]string{"mnbvcxzlkjhg.example"}); err != nil {
+		t.Fatal(err)
+	}
+	var events []DGAEvent
+	var blocks []BlockEvent
+	h.SetBlockedCallback(func(e BlockEvent) { blocks = append(blocks, e) })
+
+	// Monitor mode reports once and lets the query through
+	h.SetDGADetector(NewDGADetector(&config.DGAConfig{Mode: config.DGAModeMonitor, Threshold: 0.75, MinLength: 10}), func(e DGAEvent) { events = append(events, e) })
+	query(h, "xjq7kd9fz2lp.com.")
+	if m := query(h, "xjq7kd9fz2lp.com."); len(m.Answer) != 1 || !m.Answer[0].(*dns.A).A.Equal(net.IPv4(192, 0, 2, 30)) {
+		t.Fatalf("monitor mode answer = %v, want the upstream's", m)
+	}
+	if len(events) != 1 || events[0].Blocked || len(blocks) != 0 {
+		t.Fatalf("monitor mode: events %+v, blocks %+v; want one unblocked report", events, blocks)
+	}
+
+	// Block mode sinkholes it with the dga category
+	events = nil
+	h.SetDGADetector(NewDGADetector(&config.DGAConfig{Mode: config.DGAModeBlock, Threshold: 0.75, MinLength: 10}), func(e DGAEvent) { events = append(events, e) })
+	if m := query(h, "xjq7kd9fz2lp.com."); len(m.Answer) != 1 {
+		t.Fatalf("block mode answer = %v, want the sinkhole", m)
+	}
+	if len(events) != 1 || !events[0].Blocked || len(blocks) != 1 || blocks[0].Category != CategoryDGA {
+		t.Errorf("block mode: events %+v, blocks %+v", events, blocks)
+	}
+
+	// The allowlist wins
+	query(h, "mnbvcxzlkjhg.example.")
+	if len(blocks) != 1 {
+		t.Errorf("allowlisted name blocked: %+v", blocks)
+	}
+}
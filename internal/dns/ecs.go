@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"net"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// setECS sets how EDNS Client Subnet options in queries are handled on
+// the way upstream
+func (h *Handler) setECS(cfg config.ECSConfig) {
+	h.ecsMode = cfg.Mode
+	h.ecsSubnet = nil
+	if cfg.Mode != config.ECSModeInject {
+		return
+	}
+	_, subnet, err := net.ParseCIDR(cfg.Subnet)
+	if err != nil {
+		logrus.WithError(err).WithField("subnet", cfg.Subnet).Error("Invalid ECS subnet, stripping client subnets instead")
+		h.ecsMode = config.ECSModeStrip
+		return
+	}
+	h.ecsSubnet = subnet
+}
+
+// upstreamQuery returns the query to send upstream for r: r itself, or a
+// copy with its client subnet removed or replaced
+func (h *Handler) upstreamQuery(r *dns.Msg) *dns.Msg {
+	switch h.ecsMode {
+	case config.ECSModeStrip:
+		if clientSubnet(r) == nil {
+			return r
+		}
+		q := r.Copy()
+		stripECS(q)
+		return q
+	case config.ECSModeInject:
+		q := r.Copy()
+		stripECS(q)
+		opt := q.IsEdns0()
+		if opt == nil {
+			q.SetEdns0(dns.DefaultMsgSize, false)
+			opt = q.IsEdns0()
+		}
+		ones, bits := h.ecsSubnet.Mask.Size()
+		ecs := &dns.EDNS0_SUBNET{
+			Code:          dns.EDNS0SUBNET,
+			Family:        1,
+			SourceNetmask: uint8(ones),
+			Address:       h.ecsSubnet.IP,
+		}
+		if bits == 128 {
+			ecs.Family = 2
+		}
+		opt.Option = append(opt.Option, ecs)
+		return q
+	}
+	return r
+}
+
+// clientResponse removes the upstream's ECS option from resp when the
+// client's own subnet wasn't what was sent, since clients must not see a
+// subnet they didn't ask about (RFC 7871 section 7.2.1)
+func (h *Handler) clientResponse(resp *dns.Msg) {
+	if resp != nil && (h.ecsMode == config.ECSModeStrip || h.ecsMode == config.ECSModeInject) {
+		stripECS(resp)
+	}
+}
+
+// clientSubnet returns the ECS option of m, if any
+func clientSubnet(m *dns.Msg) *dns.EDNS0_SUBNET {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return nil
+	}
+	for _, o := range opt.Option {
+		if ecs, ok := o.(*dns.EDNS0_SUBNET); ok {
+			return ecs
+		}
+	}
+	return nil
+}
+
+// stripECS removes ECS options from m
+func stripECS(m *dns.Msg) {
+	opt := m.IsEdns0()
+	if opt == nil {
+		return
+	}
+	kept := opt.Option[:0]
+	for _, o := range opt.Option {
+		if _, ok := o.(*dns.EDNS0_SUBNET); !ok {
+			kept = append(kept, o)
+		}
+	}
+	opt.Option = kept
+}
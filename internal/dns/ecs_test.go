@@ -0,0 +1,120 @@
+package dns
+
+import (
+	"net"
+	"sync"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+// startECSUpstream starts an upstream that records the client subnet of
+// each query and echoes it in its answer
+func startECSUpstream(t *testing.T) (string, func() *dns.EDNS0_SUBNET) {
+	t.Helper()
+	var mu sync.Mutex
+	var last *dns.EDNS0_SUBNET
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			ecs := clientSubnet(r)
+			mu.Lock()
+			last = ecs
+			mu.Unlock()
+
+			m := new(dns.Msg)
+			m.SetReply(r)
+			a, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.50")
+			m.Answer = []dns.RR{a}
+			if ecs != nil {
+				m.SetEdns0(dns.DefaultMsgSize, false)
+				scoped := *ecs
+				scoped.SourceScope = ecs.SourceNetmask
+				m.IsEdns0().Option = []dns.EDNS0{&scoped}
+			}
+			w.WriteMsg(m)
+		}),
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+
+	return pc.LocalAddr().String(), func() *dns.EDNS0_SUBNET {
+		mu.Lock()
+		defer mu.Unlock()
+		return last
+	}
+}
+
+func TestHandlerECS(t *testing.T) {
+	upstream, received := startECSUpstream(t)
+
+	query := func(withSubnet bool) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("cdn.example.com.", dns.TypeA)
+		if withSubnet {
+			req.SetEdns0(dns.DefaultMsgSize, false)
+			req.IsEdns0().Option = []dns.EDNS0{&dns.EDNS0_SUBNET{
+				Code:          dns.EDNS0SUBNET,
+				Family:        1,
+				SourceNetmask: 24,
+				Address:       net.ParseIP("203.0.113.0").To4(),
+			}}
+		}
+		return req
+	}
+
+	tests := []struct {
+		name       string
+		ecs        config.ECSConfig
+		withSubnet bool
+		wantSent   string // Subnet the upstream saw, "" for none
+	}{
+		{"forward keeps the client subnet", config.ECSConfig{Mode: config.ECSModeForward}, true, "203.0.113.0/24"},
+		{"forward adds none", config.ECSConfig{Mode: config.ECSModeForward}, false, ""},
+		{"strip removes the client subnet", config.ECSConfig{Mode: config.ECSModeStrip}, true, ""},
+		{"inject replaces the client subnet", config.ECSConfig{Mode: config.ECSModeInject, Subnet: "198.51.100.0/24"}, true, "198.51.100.0/24"},
+		{"inject adds a subnet", config.ECSConfig{Mode: config.ECSModeInject, Subnet: "198.51.100.77/24"}, false, "198.51.100.0/24"},
+		{"inject IPv6", config.ECSConfig{Mode: config.ECSModeInject, Subnet: "2001:db8:1::/48"}, false, "2001:db8:1::/48"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			h := NewHandler(NewBlocker(), &config.DNSConfig{Upstreams: []string{upstream}, CacheSize: 100, CacheTTL: time.Minute, ECS: tt.ecs}, "127.0.0.1", &config.CaptivePortalConfig{})
+			t.Cleanup(h.Stop)
+
+			req := query(tt.withSubnet)
+			resp, _, _ := h.forwardToUpstream(req)
+			if resp == nil || len(resp.Answer) != 1 {
+				t.Fatalf("got %v, want an answer", resp)
+			}
+
+			sent := ""
+			if ecs := received(); ecs != nil {
+				bits := 128
+				if ecs.Family == 1 {
+					bits = 32
+				}
+				sent = (&net.IPNet{IP: ecs.Address, Mask: net.CIDRMask(int(ecs.SourceNetmask), bits)}).String()
+			}
+			if sent != tt.wantSent {
+				t.Errorf("upstream saw subnet %q, want %q", sent, tt.wantSent)
+			}
+
+			// Only a forwarded subnet's scope is returned to the client
+			if got := clientSubnet(resp) != nil; got != (tt.ecs.Mode == config.ECSModeForward && tt.withSubnet) {
+				t.Errorf("response has ECS = %v", got)
+			}
+			if tt.withSubnet && clientSubnet(req) == nil {
+				t.Error("the client's query was modified")
+			}
+		})
+	}
+}
@@ -0,0 +1,99 @@
+package dns
+
+import (
+	"strings"
+
+	"dnshield/internal/security"
+)
+
+// RuleHit is a rule that matches a domain, whether or not it decided the
+// outcome
+type RuleHit struct {
+	List   string // ExplainList*
+	Rule   string
+	Source string
+}
+
+// Lists a RuleHit can come from
+const (
+	ExplainListAllow      = "allowlist"
+	ExplainListBlock      = "blocklist"
+	ExplainListSecurity   = "security"
+	ExplainListRegex      = "regex"
+	ExplainListSchedule   = "schedule"
+	ExplainListBypass     = "bypass-prevention"
+	ExplainListNRD        = "nrd"
+	ExplainListTemporary  = "temporary-allow"
+	ExplainListLocalAllow = "local-allow"
+	ExplainListLocalBlock = "local-block"
+)
+
+// Explanation traces how Check decides on a domain: the outcome, every
+// rule that matches the domain, and the settings that choose between them
+type Explanation struct {
+	Domain string
+	Match  BlockMatch // What Check returns
+
+	Hits []RuleHit
+
+	// Captive portal checks are never blocked
+	CaptivePortal bool
+	// Allow-only mode is on, always or from an active schedule
+	AllowOnly         bool
+	AllowOnlySchedule string
+	Precedence        string
+	Enforcement       string
+}
+
+// Explain reports why domain is blocked or allowed. Unlike Check it
+// doesn't count hits for the statistics.
+func (b *Blocker) Explain(domain string) Explanation {
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	enforcement := b.Enforcement()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	e := Explanation{
+		Domain:        domain,
+		Match:         b.check(domain, false),
+		CaptivePortal: security.IsCaptivePortalDomain(domain),
+		AllowOnly:     b.allowOnlyMode,
+		Precedence:    PrecedenceAllowlist,
+		Enforcement:   enforcement,
+	}
+	if b.securityFirst {
+		e.Precedence = PrecedenceSecurity
+	}
+	if s := b.scheduledAllowOnly(); s != nil {
+		e.AllowOnly, e.AllowOnlySchedule = true, s.Name
+	}
+
+	hit := func(list string, trie ruleMatcher) {
+		if rule, source, ok := trie.Match(domain); ok {
+			e.Hits = append(e.Hits, RuleHit{List: list, Rule: rule, Source: source})
+		}
+	}
+	hit(ExplainListAllow, b.allowlist.Load())
+	if b.temporarilyAllowed(domain) {
+		e.Hits = append(e.Hits, RuleHit{List: ExplainListTemporary, Rule: domain})
+	}
+	hit(ExplainListLocalAllow, b.localAllow)
+	hit(ExplainListLocalBlock, b.localBlock)
+	hit(ExplainListSecurity, b.securityDomains.Load())
+	hit(ExplainListSecurity, b.threatIntel.Load())
+	hit(ExplainListBypass, b.bypassDomains)
+	hit(ExplainListBlock, b.blockedDomains.Load())
+	for _, rule := range b.regexRules {
+		if rule.matches(domain) {
+			e.Hits = append(e.Hits, RuleHit{List: ExplainListRegex, Rule: rule.pattern, Source: SourceRegex})
+		}
+	}
+	if match, ok := b.checkSchedules(domain); ok {
+		e.Hits = append(e.Hits, RuleHit{List: ExplainListSchedule, Rule: match.Rule, Source: match.Source})
+	}
+	if match, ok := b.checkNRD(domain); ok {
+		e.Hits = append(e.Hits, RuleHit{List: ExplainListNRD, Rule: match.Rule, Source: match.Source})
+	}
+	return e
+}
@@ -0,0 +1,50 @@
+package dns
+
+import (
+	"testing"
+)
+
+func TestExplain(t *testing.T) {
+	b := NewBlocker()
+	b.UpdateDomainsWithSources([]string{"ads.example.com", "corp.example"}, map[string]string{"ads.example.com": "https://lists.example.com/ads.txt"})
+	b.UpdateAllowlist([]string{"tools.corp.example"})
+	b.UpdateRegexRules([]string{`^track[0-9]+\.`})
+
+	e := b.Explain("Cdn.Ads.Example.com.")
+	if e.Domain != "cdn.ads.example.com" || !e.Match.Blocked || e.Match.Rule != "ads.example.com" {
+		t.Errorf("Explain(cdn.ads.example.com) = %+v", e)
+	}
+	if len(e.Hits) != 1 || e.Hits[0].List != ExplainListBlock || e.Hits[0].Source != "https://lists.example.com/ads.txt" {
+		t.Errorf("hits = %+v", e.Hits)
+	}
+
+	// The allowlist wins, but the block rule it overrides is still listed
+	e = b.Explain("tools.corp.example")
+	if e.Match.Blocked {
+		t.Error("allowlisted domain reported blocked")
+	}
+	if len(e.Hits) != 2 || e.Hits[0].List != ExplainListAllow || e.Hits[1].Rule != "corp.example" {
+		t.Errorf("hits = %+v", e.Hits)
+	}
+	if e.Precedence != PrecedenceAllowlist {
+		t.Errorf("precedence = %q", e.Precedence)
+	}
+
+	// Tracing doesn't count as a block
+	e = b.Explain("track1.example.net")
+	if !e.Match.Blocked || e.Match.Source != SourceRegex {
+		t.Errorf("Explain(track1.example.net) = %+v", e.Match)
+	}
+	if hits := b.RegexRuleHits(); len(hits) != 1 || hits[0].Hits != 0 {
+		t.Errorf("regex hits after Explain = %+v, want 0", hits)
+	}
+
+	if e = b.Explain("captive.apple.com"); !e.CaptivePortal || e.Match.Blocked {
+		t.Errorf("Explain(captive.apple.com) = %+v", e)
+	}
+
+	b.SetAllowOnlyMode(true)
+	if e = b.Explain("unknown.example.org"); !e.AllowOnly || e.Match.Source != SourceAllowOnly {
+		t.Errorf("Explain in allow-only mode = %+v", e)
+	}
+}
@@ -0,0 +1,223 @@
+package dns
+
+import (
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+// maxDomainsPerIP bounds the names remembered for one address; CDN
+// addresses can be shared by thousands of names
+const maxDomainsPerIP = 16
+
+// Flow match methods reported in FlowVerdict.MatchedBy
+const (
+	FlowMatchHostname = "hostname" // The flow named its destination host
+	FlowMatchIP       = "ip"       // The destination IP was resolved through DNShield
+)
+
+// ResolvedIPs remembers which names resolved to which addresses, so flows
+// that only carry a destination IP can be traced back to a domain
+type ResolvedIPs struct {
+	mu         sync.RWMutex
+	entries    map[string]*resolvedEntry // Keyed by IP string
+	minTTL     time.Duration
+	maxEntries int
+}
+
+type resolvedEntry struct {
+	domains []string // Oldest first
+	expires time.Time
+}
+
+// NewResolvedIPs creates a correlation table. Entries live for the record
+// TTL or minTTL, whichever is longer, since applications cache answers
+// well beyond their TTL.
+func NewResolvedIPs(minTTL time.Duration, maxEntries int) *ResolvedIPs {
+	return &ResolvedIPs{
+		entries:    make(map[string]*resolvedEntry),
+		minTTL:     minTTL,
+		maxEntries: maxEntries,
+	}
+}
+
+// Record links every A and AAAA address in answer to domain and to the
+// names along its CNAME chain
+func (r *ResolvedIPs) Record(domain string, answer []dns.RR) {
+	now := time.Now()
+	domain = strings.ToLower(domain)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	for _, rr := range answer {
+		var ip net.IP
+		switch v := rr.(type) {
+		case *dns.A:
+			ip = v.A
+		case *dns.AAAA:
+			ip = v.AAAA
+		default:
+			continue
+		}
+
+		ttl := time.Duration(rr.Header().Ttl) * time.Second
+		if ttl < r.minTTL {
+			ttl = r.minTTL
+		}
+
+		key := ip.String()
+		entry, ok := r.entries[key]
+		if !ok {
+			if len(r.entries) >= r.maxEntries && !r.evict(now) {
+				continue
+			}
+			entry = &resolvedEntry{}
+			r.entries[key] = entry
+		}
+		if expires := now.Add(ttl); expires.After(entry.expires) {
+			entry.expires = expires
+		}
+
+		entry.add(domain)
+		if owner := strings.ToLower(strings.TrimSuffix(rr.Header().Name, ".")); owner != domain {
+			entry.add(owner)
+		}
+	}
+}
+
+// Lookup returns the names that resolved to ip and haven't expired
+func (r *ResolvedIPs) Lookup(ip net.IP) []string {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.entries[ip.String()]
+	if !ok || time.Now().After(entry.expires) {
+		return nil
+	}
+	return append([]string(nil), entry.domains...)
+}
+
+// evict makes room for a new entry, preferring expired ones. Callers must
+// hold r.mu. It returns false if nothing could be removed.
+func (r *ResolvedIPs) evict(now time.Time) bool {
+	for key, entry := range r.entries {
+		if now.After(entry.expires) {
+			delete(r.entries, key)
+		}
+	}
+	if len(r.entries) < r.maxEntries {
+		return true
+	}
+	for key := range r.entries {
+		delete(r.entries, key)
+		return true
+	}
+	return false
+}
+
+func (e *resolvedEntry) add(domain string) {
+	for i, d := range e.domains {
+		if d == domain {
+			// Move to the end as the most recent
+			e.domains = append(append(e.domains[:i:i], e.domains[i+1:]...), domain)
+			return
+		}
+	}
+	e.domains = append(e.domains, domain)
+	if len(e.domains) > maxDomainsPerIP {
+		e.domains = e.domains[1:]
+	}
+}
+
+// FlowVerdict is the decision for a single TCP or UDP flow
+type FlowVerdict struct {
+	Blocked   bool
+	Domain    string // Name the decision was based on
+	MatchedBy string // FlowMatchHostname or FlowMatchIP; empty if unknown
+	Match     BlockMatch
+}
+
+// FlowFilter decides whether flows seen by the transparent proxy extension
+// may proceed, using the same rules as DNS queries. This catches apps that
+// resolve names themselves (e.g. hard-coded DNS-over-HTTPS).
+type FlowFilter struct {
+	blocker         *Blocker
+	resolved        *ResolvedIPs
+	captive         *CaptivePortalDetector
+	blockedCallback func(event BlockEvent)
+}
+
+// NewFlowFilter creates a flow filter. captive may be nil.
+func NewFlowFilter(blocker *Blocker, resolved *ResolvedIPs, captive *CaptivePortalDetector) *FlowFilter {
+	return &FlowFilter{
+		blocker:  blocker,
+		resolved: resolved,
+		captive:  captive,
+	}
+}
+
+// SetBlockedCallback sets the callback invoked for every blocked flow
+func (f *FlowFilter) SetBlockedCallback(cb func(event BlockEvent)) {
+	f.blockedCallback = cb
+}
+
+// Check decides a flow to hostname (may be empty) at ip. A named host is
+// checked directly. Otherwise the flow is blocked only if every name known
+// to have resolved to ip is blocked, so shared CDN addresses of allowed
+// sites keep working.
+func (f *FlowFilter) Check(hostname string, ip net.IP, protocol, clientIP string) FlowVerdict {
+	hostname = strings.ToLower(strings.TrimSuffix(hostname, "."))
+	if parsed := net.ParseIP(hostname); parsed != nil {
+		if ip == nil {
+			ip = parsed
+		}
+		hostname = ""
+	}
+
+	var verdict FlowVerdict
+	if hostname != "" {
+		verdict = FlowVerdict{Domain: hostname, MatchedBy: FlowMatchHostname, Match: f.check(hostname)}
+		verdict.Blocked = verdict.Match.Blocked
+	} else if ip != nil && f.resolved != nil {
+		for _, domain := range f.resolved.Lookup(ip) {
+			match := f.check(domain)
+			if !match.Blocked {
+				verdict = FlowVerdict{Domain: domain, MatchedBy: FlowMatchIP, Match: match}
+				break
+			}
+			if !verdict.Blocked {
+				verdict = FlowVerdict{Blocked: true, Domain: domain, MatchedBy: FlowMatchIP, Match: match}
+			}
+		}
+	}
+
+	if verdict.Blocked && f.blockedCallback != nil {
+		userEmail, groupName := f.blocker.GetMetadata()
+		f.blockedCallback(BlockEvent{
+			Timestamp: time.Now(),
+			Domain:    verdict.Domain,
+			QueryType: "FLOW/" + strings.ToUpper(protocol),
+			ClientIP:  clientIP,
+			Rule:      verdict.Match.Rule,
+			Source:    verdict.Match.Source,
+			User:      userEmail,
+			Group:     groupName,
+			Category:  verdict.Match.Category(),
+		})
+	}
+	return verdict
+}
+
+// check applies the blocker with the same captive portal bypass rules as
+// DNS queries
+func (f *FlowFilter) check(domain string) BlockMatch {
+	match := f.blocker.Check(domain)
+	if f.captive != nil && f.captive.IsInBypassMode() && !(f.captive.IsManualBypass() && match.Security) {
+		return BlockMatch{}
+	}
+	return match
+}
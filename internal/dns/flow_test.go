@@ -0,0 +1,79 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"github.com/miekg/dns"
+)
+
+func TestFlowFilter(t *testing.T) {
+	blocker := NewBlocker()
+	if err := blocker.UpdateDomains([]string{"c2.example.net", "ads.example.com"}); err != nil {
+		t.Fatalf("UpdateDomains failed: %v", err)
+	}
+
+	resolved := NewResolvedIPs(time.Minute, 10)
+	answer := func(name, target string, ip string) []dns.RR {
+		cname, _ := dns.NewRR(name + ". 30 IN CNAME " + target + ".")
+		a, _ := dns.NewRR(target + ". 30 IN A " + ip)
+		return []dns.RR{cname, a}
+	}
+	resolved.Record("C2.Example.net", answer("c2.example.net", "edge.c2.example.net", "203.0.113.5"))
+	resolved.Record("ads.example.com", answer("ads.example.com", "cdn.example.org", "198.51.100.7"))
+	resolved.Record("news.example.org", answer("news.example.org", "cdn.example.org", "198.51.100.7"))
+
+	var blocked []BlockEvent
+	filter := NewFlowFilter(blocker, resolved, nil)
+	filter.SetBlockedCallback(func(event BlockEvent) { blocked = append(blocked, event) })
+
+	tests := []struct {
+		name      string
+		hostname  string
+		ip        string
+		blocked   bool
+		domain    string
+		matchedBy string
+	}{
+		{"named host", "c2.example.net", "", true, "c2.example.net", FlowMatchHostname},
+		{"named allowed host", "example.com", "203.0.113.5", false, "example.com", FlowMatchHostname},
+		{"resolved IP", "", "203.0.113.5", true, "c2.example.net", FlowMatchIP},
+		{"IP literal as hostname", "203.0.113.5", "", true, "c2.example.net", FlowMatchIP},
+		{"shared CDN address", "", "198.51.100.7", false, "news.example.org", FlowMatchIP},
+		{"unknown IP", "", "192.0.2.1", false, "", ""},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			v := filter.Check(tt.hostname, net.ParseIP(tt.ip), "tcp", "127.0.0.1")
+			if v.Blocked != tt.blocked || v.Domain != tt.domain || v.MatchedBy != tt.matchedBy {
+				t.Errorf("Check(%q, %s) = %+v, want blocked=%v domain=%s matched_by=%s",
+					tt.hostname, tt.ip, v, tt.blocked, tt.domain, tt.matchedBy)
+			}
+		})
+	}
+
+	if len(blocked) != 3 || blocked[0].QueryType != "FLOW/TCP" || blocked[0].Category != CategoryBlocklist {
+		t.Errorf("blocked events = %+v, want 3 FLOW/TCP blocklist events", blocked)
+	}
+}
+
+func TestResolvedIPsLimits(t *testing.T) {
+	resolved := NewResolvedIPs(time.Minute, 2)
+	for _, ip := range []string{"192.0.2.1", "192.0.2.2", "192.0.2.3"} {
+		a, _ := dns.NewRR("host.example. 30 IN A " + ip)
+		resolved.Record("host.example", []dns.RR{a})
+	}
+	if n := len(resolved.entries); n != 2 {
+		t.Errorf("tracking %d addresses, want at most 2", n)
+	}
+
+	for i := 0; i < 2*maxDomainsPerIP; i++ {
+		a, _ := dns.NewRR("shared.example. 30 IN A 192.0.2.9")
+		resolved.Record(string(rune('a'+i))+".example", []dns.RR{a})
+	}
+	if n := len(resolved.Lookup(net.ParseIP("192.0.2.9"))); n != maxDomainsPerIP {
+		t.Errorf("remembered %d names for one address, want %d", n, maxDomainsPerIP)
+	}
+}
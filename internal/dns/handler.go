@@ -3,6 +3,7 @@ package dns
 import (
 	"net"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/miekg/dns"
@@ -13,17 +14,112 @@ import (
 
 // Handler handles DNS queries
 type Handler struct {
-	blocker          *Blocker
-	upstreams        []string
-	blockIP          net.IP
-	cache            *Cache
-	captiveDetector  *CaptivePortalDetector
-	rateLimiter      *RateLimiter
-	queryLimiter     *utils.ConcurrencyLimiter
-	statsCallback    func(query bool, blocked bool, cached bool)
-	blockedCallback  func(domain, rule, clientIP string)
+	blocker           *Blocker
+	upstreams         []string
+	blockIP           net.IP
+	blockIPv6         net.IP            // AAAA sinkhole; nil for empty answers
+	blockType         string            // config.BlockType* for blocked queries
+	blockTypes        map[uint16]string // Block type overrides by query type
+	blockTTL          uint32
+	blockPagePort     int    // HTTPS port of the block page
+	sinkholeReverse   string // in-addr.arpa / ip6.arpa name of blockIP
+	cache             *Cache
+	captiveDetector   *CaptivePortalDetector
+	hosts             *HostsFile
+	localRecords      *LocalRecords
+	identity          *serverIdentity
+	rateLimiter       *RateLimiter
+	queryLimiter      *utils.ConcurrencyLimiter
+	statsCallback     func(query bool, blocked bool, cached bool)
+	blockedCallback   func(event BlockEvent)
+	queryCallback     func(event QueryEvent)
+	networkResolvers  func() []string
+	resolvedIPs       *ResolvedIPs
+	recentBlocks      *RecentBlocks
+	cnameUncloaking   bool
+	dga               *DGADetector
+	dgaCallback       func(event DGAEvent)
+	homograph         *HomographDetector
+	homographCallback func(event HomographEvent)
+	tunnel            *TunnelDetector
+	tunnelCallback    func(event TunnelEvent)
+	clientBlockers    []clientBlocker // Rules for client ranges other than the device's
+	ecsMode           string          // config.ECSMode*
+	ecsSubnet         *net.IPNet      // Sent upstream in inject mode
+	caseRandomization bool            // DNS 0x20 on upstream queries
+
+	// Where queries are forwarded, replaced by UpdateConfig
+	upstreamMu     sync.RWMutex
+	strategy       string
+	forwarders     *domainTrie         // Conditional forwarding suffixes
+	forwarderLists map[string][]string // Upstreams by suffix
+
+	prefetch   *prefetcher // Nil unless dns.prefetch is set
+	inflightMu sync.Mutex
+	inflight   map[string]*inflightQuery // Upstream exchanges by inflightKey
 }
 
+// UpstreamDHCP can be listed in dns.upstreams to use the resolvers of the
+// current network (as captured by NetworkManager) in its place
+const UpstreamDHCP = "dhcp"
+
+// defaultFallbackUpstreams are used when upstreams is only "dhcp" and the
+// current network has no known resolvers
+var defaultFallbackUpstreams = []string{"1.1.1.1", "8.8.8.8"}
+
+// BlockEvent carries the context of a single blocked query
+type BlockEvent struct {
+	Timestamp  time.Time
+	Domain     string
+	QueryType  string // e.g. "A", "AAAA"
+	Rcode      string // Response code returned to the client
+	ClientIP   string
+	ClientPort int
+	Rule       string // Blocklist entry that matched
+	Source     string // Where the rule came from (list URL, "inline", "default", ...)
+	User       string
+	Group      string
+	Category   string // Block category (see BlockMatch.Category)
+	// Unicode form of Domain when it was blocked as a homograph
+	DecodedDomain string
+	// Matched in monitor enforcement and resolved normally
+	Monitored bool
+}
+
+// Query actions reported in QueryEvent.Action
+const (
+	QueryActionAllowed = "allowed" // Forwarded and answered by an upstream
+	QueryActionBlocked = "blocked"
+	QueryActionCached  = "cached"
+	QueryActionHosts   = "hosts" // Answered from the hosts file
+	QueryActionLocal   = "local" // Answered by DNShield about itself
+	QueryActionFailed  = "failed" // Every upstream failed
+	// Rewritten to the search engine's SafeSearch name
+	QueryActionSafeSearch = "safesearch"
+	// Matched a rule in monitor enforcement, resolved normally
+	QueryActionMonitored = "monitored"
+)
+
+// QueryEvent describes a single answered query
+type QueryEvent struct {
+	Timestamp   time.Time
+	Domain      string
+	QueryType   string
+	ClientIP    string
+	Action      string
+	Rcode       string
+	Rule        string        // Blocklist entry that matched, empty unless blocked or monitored
+	Upstream    string        // Upstream that answered, empty unless forwarded
+	UpstreamRTT time.Duration // Round trip to Upstream
+	Duration    time.Duration // Total time spent handling the query
+}
+
+// SinkholePTR is the name returned for reverse lookups of the block IP
+const SinkholePTR = "blocked.dnshield."
+
+// sinkholeTXT explains the block IP to anyone querying TXT on its reverse name
+const sinkholeTXT = "DNShield is active. Blocked domains resolve to this address; browse to it for details."
+
 // NewHandler creates a new DNS handler
 func NewHandler(blocker *Blocker, dnsCfg *config.DNSConfig, blockIP string, captivePortalCfg *config.CaptivePortalConfig) *Handler {
 	ip := net.ParseIP(blockIP)
@@ -42,28 +138,92 @@ func NewHandler(blocker *Blocker, dnsCfg *config.DNSConfig, blockIP string, capt
 		rateLimitWindow = time.Second // Default: 1 second window
 	}
 
-	// Validate and cap cache size
-	cacheSize := dnsCfg.CacheSize
-	if cacheSize <= 0 {
-		cacheSize = 10000 // Default
-	}
-	if cacheSize > utils.MaxCacheEntries {
-		logrus.WithFields(logrus.Fields{
-			"requested": cacheSize,
-			"maximum":   utils.MaxCacheEntries,
-		}).Warn("DNS cache size exceeds maximum, capping to limit")
-		cacheSize = utils.MaxCacheEntries
-	}
+	sinkholeReverse, _ := dns.ReverseAddr(ip.String())
 
-	return &Handler{
+	h := &Handler{
 		blocker:         blocker,
 		upstreams:       dnsCfg.Upstreams,
 		blockIP:         ip,
-		cache:           NewCache(cacheSize, dnsCfg.CacheTTL),
+		blockType:       config.BlockTypeSinkhole,
+		blockTTL:        defaultBlockTTL,
+		blockPagePort:   defaultBlockPagePort,
+		sinkholeReverse: sinkholeReverse,
+		cache:           NewCache(cacheSize(dnsCfg.CacheSize), dnsCfg.CacheTTL),
 		captiveDetector: NewCaptivePortalDetector(captivePortalCfg),
+		identity:        newServerIdentity(dnsCfg.ServerIdentity),
 		rateLimiter:     NewRateLimiter(rateLimitQueries, rateLimitWindow),
 		queryLimiter:    utils.NewConcurrencyLimiter(utils.MaxConcurrentDNSQueries),
 	}
+
+	h.strategy = dnsCfg.Strategy
+	h.setConditionalForwarders(dnsCfg.ConditionalForwarders)
+	h.setECS(dnsCfg.ECS)
+	h.caseRandomization = dnsCfg.CaseRandomization
+	h.cache.SetNegativeTTL(dnsCfg.NegativeCacheTTL)
+	h.cache.SetServeStale(dnsCfg.ServeStale)
+	if dnsCfg.CacheSnapshot.Enabled {
+		h.cache.EnableSnapshots(expandHome(dnsCfg.CacheSnapshot.Path), dnsCfg.CacheSnapshot.Interval)
+	}
+	if dnsCfg.Prefetch && dnsCfg.PrefetchTopN > 0 {
+		h.prefetch = newPrefetcher(h, dnsCfg.PrefetchTopN)
+		h.prefetch.start()
+	}
+
+	// Local records and names from the hosts file are answered before
+	// anything else
+	h.localRecords = NewLocalRecords()
+	if err := h.localRecords.Update(dnsCfg.LocalRecords); err != nil {
+		logrus.WithError(err).Error("Invalid local DNS records, none loaded")
+	}
+	if dnsCfg.HostsFile != "" {
+		h.hosts = NewHostsFile(dnsCfg.HostsFile)
+	}
+	if dnsCfg.Tunneling.Enabled {
+		h.tunnel = NewTunnelDetector(&dnsCfg.Tunneling)
+	}
+
+	return h
+}
+
+// cacheSize validates and caps the configured cache size
+func cacheSize(size int) int {
+	if size <= 0 {
+		return 10000 // Default
+	}
+	if size > utils.MaxCacheEntries {
+		logrus.WithFields(logrus.Fields{
+			"requested": size,
+			"maximum":   utils.MaxCacheEntries,
+		}).Warn("DNS cache size exceeds maximum, capping to limit")
+		return utils.MaxCacheEntries
+	}
+	return size
+}
+
+// UpdateConfig applies the upstreams, strategy, conditional forwarders and
+// cache limits of a reloaded config while queries are being served. Cached
+// answers are kept, and the other settings need a restart.
+func (h *Handler) UpdateConfig(dnsCfg *config.DNSConfig) {
+	h.setConditionalForwarders(dnsCfg.ConditionalForwarders)
+
+	h.upstreamMu.Lock()
+	h.upstreams = dnsCfg.Upstreams
+	h.strategy = dnsCfg.Strategy
+	h.upstreamMu.Unlock()
+
+	h.cache.Resize(cacheSize(dnsCfg.CacheSize), dnsCfg.CacheTTL)
+	h.cache.SetNegativeTTL(dnsCfg.NegativeCacheTTL)
+	h.cache.SetServeStale(dnsCfg.ServeStale)
+}
+
+// UpdateCaptivePortal applies reloaded captive portal settings
+func (h *Handler) UpdateCaptivePortal(cfg *config.CaptivePortalConfig) {
+	h.captiveDetector.UpdateConfig(cfg)
+}
+
+// ClearCache drops every cached answer and returns how many were cached
+func (h *Handler) ClearCache() int {
+	return h.cache.Clear()
 }
 
 // SetStatsCallback sets the callback for statistics updates
@@ -72,19 +232,96 @@ func (h *Handler) SetStatsCallback(cb func(query bool, blocked bool, cached bool
 }
 
 // SetBlockedCallback sets the callback for blocked domains
-func (h *Handler) SetBlockedCallback(cb func(domain, rule, clientIP string)) {
+func (h *Handler) SetBlockedCallback(cb func(event BlockEvent)) {
 	h.blockedCallback = cb
 }
 
+// SetQueryCallback sets the callback invoked once for every answered query
+func (h *Handler) SetQueryCallback(cb func(event QueryEvent)) {
+	h.queryCallback = cb
+}
+
+// SetCNAMEUncloaking makes the handler block forwarded queries whose answer
+// aliases (CNAMEs) a blocked domain
+func (h *Handler) SetCNAMEUncloaking(enabled bool) {
+	h.cnameUncloaking = enabled
+}
+
+// SetNetworkResolverSource sets the function that supplies the current
+// network's resolvers when "dhcp" is configured as an upstream
+func (h *Handler) SetNetworkResolverSource(fn func() []string) {
+	h.networkResolvers = fn
+}
+
+// SetFlowCorrelation records the addresses of answered queries in
+// resolved, so flows to those addresses can be matched to their domain
+func (h *Handler) SetFlowCorrelation(resolved *ResolvedIPs) {
+	h.resolvedIPs = resolved
+}
+
+// SetRecentBlocks records every block in recent, so the block page can say
+// what blocked a domain
+func (h *Handler) SetRecentBlocks(recent *RecentBlocks) {
+	h.recentBlocks = recent
+}
+
+// currentUpstreams expands the "dhcp" upstream into the current network's
+// resolvers, keeping the other configured upstreams as fallbacks
+func (h *Handler) currentUpstreams() []string {
+	h.upstreamMu.RLock()
+	defer h.upstreamMu.RUnlock()
+	return h.expandUpstreams()
+}
+
+// expandUpstreams is currentUpstreams for callers holding upstreamMu
+func (h *Handler) expandUpstreams() []string {
+	var upstreams, fallbacks []string
+	usesDHCP := false
+	for _, upstream := range h.upstreams {
+		if strings.EqualFold(upstream, UpstreamDHCP) {
+			usesDHCP = true
+			if h.networkResolvers != nil {
+				upstreams = append(upstreams, h.networkResolvers()...)
+			}
+			continue
+		}
+		fallbacks = append(fallbacks, upstream)
+	}
+
+	if !usesDHCP {
+		return h.upstreams
+	}
+
+	// "dhcp" on its own still needs somewhere to go off-network
+	if len(fallbacks) == 0 {
+		fallbacks = defaultFallbackUpstreams
+	}
+	return append(upstreams, fallbacks...)
+}
+
 // ServeDNS implements the dns.Handler interface
 func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
+	h.serve(w, r)
+}
+
+// serve answers r on w and returns what happened to it, which is also
+// reported to the query callback. Queries refused before they are looked
+// at have no Action.
+func (h *Handler) serve(w dns.ResponseWriter, r *dns.Msg) (event QueryEvent) {
+	start := time.Now()
 	m := new(dns.Msg)
 	m.SetReply(r)
 	m.Compress = true
 
+	// Responses too big for the client's UDP buffer are truncated
+	w = &sizeLimitedWriter{ResponseWriter: w, maxSize: maxResponseSize(w, r)}
+
 	// Get client IP for rate limiting
 	clientIP := net.IPv4(127, 0, 0, 1) // Default to localhost
-	if addr, ok := w.RemoteAddr().(*net.UDPAddr); ok {
+	switch addr := w.RemoteAddr().(type) {
+	case *net.UDPAddr:
+		clientIP = addr.IP
+	case *net.TCPAddr:
 		clientIP = addr.IP
 	}
 
@@ -139,107 +376,443 @@ func (h *Handler) ServeDNS(w dns.ResponseWriter, r *dns.Msg) {
 		}()
 	}
 
+	event = QueryEvent{
+		Timestamp: start,
+		Domain:    domain,
+		QueryType: dns.TypeToString[question.Qtype],
+	}
+	event.ClientIP, _ = remoteAddrParts(w.RemoteAddr())
+	var monitoredRule string // Set when monitor enforcement let a match through
+	defer func() {
+		if monitoredRule != "" && (event.Action == QueryActionAllowed || event.Action == QueryActionCached) {
+			event.Action = QueryActionMonitored
+			event.Rule = monitoredRule
+		}
+		event.Duration = time.Since(start)
+		if h.queryCallback != nil {
+			h.queryCallback(event)
+		}
+	}()
+
 	// Record request for captive portal detection
 	h.captiveDetector.RecordRequest(domain)
 
-	// Check cache first
-	if cached := h.cache.Get(domain, question.Qtype); cached != nil {
-		m.Answer = append(m.Answer, cached...)
-		w.WriteMsg(m)
-		if h.statsCallback != nil {
-			h.statsCallback(false, false, true) // Cached response
-		}
+	// Server identification queries are never forwarded
+	if h.identity.serveChaos(w, m, question) {
+		event.Action = QueryActionLocal
+		event.Rcode = dns.RcodeToString[m.Rcode]
 		return
 	}
+	stripNSID(r)
 
-	// Check if domain is blocked (unless in bypass mode)
-	if !h.captiveDetector.IsInBypassMode() && h.blocker.IsBlocked(domain) {
-		// Get user/group metadata for logging
-		userEmail, groupName := h.blocker.GetMetadata()
+	// Reverse lookups of the sinkhole explain what it is
+	if h.serveSinkholeReverse(w, m, question) {
+		event.Action = QueryActionLocal
+		event.Rcode = dns.RcodeToString[m.Rcode]
+		return
+	}
 
-		logFields := logrus.Fields{
-			"domain": domain,
+	// Local records and names in the hosts file are answered authoritatively
+	if answer, found := h.localRecords.Lookup(domain, question.Qtype); found {
+		h.serveLocalRecords(w, m, question, answer)
+		event.Action = QueryActionLocal
+		event.Rcode = dns.RcodeToString[m.Rcode]
+		return
+	}
+	if h.hosts != nil {
+		if ips, found := h.hosts.Lookup(domain, question.Qtype); found {
+			h.serveHosts(w, m, question, ips)
+			event.Action = QueryActionHosts
+			event.Rcode = dns.RcodeToString[m.Rcode]
+			return
 		}
+	}
+
+	blocker := h.blockerFor(clientIP)
+	bypass := h.captiveDetector.IsInBypassMode()
+
+	// Zones a client is tunneling through are refused for a cooldown
+	if !bypass && h.checkTunnel(blocker, domain, question.Qtype, event.ClientIP, start) {
+		h.serveTunnelRefused(w, r, m, domain)
+		event.Action = QueryActionBlocked
+		event.Rcode = dns.RcodeToString[m.Rcode]
+		event.Rule = registrableDomain(strings.ToLower(domain))
+		return
+	}
 
-		// Include user/group if they're set
-		if userEmail != "" {
-			logFields["user"] = userEmail
+	// Check if domain is blocked (unless in bypass mode). Manual bypasses
+	// still block security-critical domains. This comes before the cache,
+	// which is shared by clients with different rules.
+	if !bypass || h.captiveDetector.IsManualBypass() {
+		if match := blocker.Check(domain); match.Blocked && (!bypass || match.Security) {
+			if !h.monitorOnly(w, question, domain, match, blocker) {
+				h.serveBlocked(w, r, m, question, domain, match, blocker)
+				event.Action = QueryActionBlocked
+				event.Rcode = dns.RcodeToString[m.Rcode]
+				event.Rule = match.Rule
+				return
+			}
+			monitoredRule = match.Rule
 		}
-		if groupName != "" {
-			logFields["group"] = groupName
+	}
+
+	// Likely algorithmically generated names, unless only monitored
+	if !bypass {
+		if match, blocked := h.checkDGA(blocker, domain, event.ClientIP); blocked {
+			h.serveBlocked(w, r, m, question, domain, match, blocker)
+			event.Action = QueryActionBlocked
+			event.Rcode = dns.RcodeToString[m.Rcode]
+			event.Rule = match.Rule
+			return
 		}
+	}
 
-		logrus.WithFields(logFields).Info("Blocked domain")
+	// Internationalized lookalikes of protected brands, unless only warned
+	if !bypass {
+		if match, blocked := h.checkHomograph(blocker, domain, event.ClientIP); blocked {
+			h.serveBlocked(w, r, m, question, domain, match, blocker)
+			event.Action = QueryActionBlocked
+			event.Rcode = dns.RcodeToString[m.Rcode]
+			event.Rule = match.Rule
+			return
+		}
+	}
 
-		// Get client IP
-		clientIP := ""
-		if addr, ok := w.RemoteAddr().(*net.UDPAddr); ok {
-			clientIP = addr.IP.String()
+	// Search engines are pointed at their SafeSearch names
+	if !bypass {
+		if target, ok := blocker.SafeSearchTarget(domain); ok && h.serveSafeSearch(w, r, m, question, target) {
+			event.Action = QueryActionSafeSearch
+			event.Rcode = dns.RcodeToString[m.Rcode]
+			event.Rule = target
+			return
 		}
+	}
 
-		if h.statsCallback != nil {
-			h.statsCallback(false, true, false) // Blocked
+	// Then the cache
+	if h.prefetch != nil {
+		h.prefetch.record(domain, question.Qtype)
+	}
+	if cached := h.cache.Get(domain, question.Qtype); cached != nil {
+		m.Rcode = cached.Rcode
+		m.Answer = append(m.Answer, cached.Answer...)
+		m.Ns = append(m.Ns, cached.Ns...)
+		if h.resolvedIPs != nil {
+			h.resolvedIPs.Record(domain, cached.Answer)
 		}
-		if h.blockedCallback != nil {
-			h.blockedCallback(domain, "blocklist", clientIP)
+		w.WriteMsg(m)
+		if h.statsCallback != nil {
+			h.statsCallback(false, false, true) // Cached response
 		}
+		event.Action = QueryActionCached
+		event.Rcode = dns.RcodeToString[m.Rcode]
+		return
+	}
 
-		switch question.Qtype {
-		case dns.TypeA:
-			rr := &dns.A{
-				Hdr: dns.RR_Header{
-					Name:   question.Name,
-					Rrtype: dns.TypeA,
-					Class:  dns.ClassINET,
-					Ttl:    10,
-				},
-				A: h.blockIP,
+	// Forward to upstream
+	resp, upstream, rtt := h.forwardCoalesced(r)
+	if resp == nil {
+		// Names resolved before keep working while the network is flaky
+		if stale := h.cache.GetStale(domain, question.Qtype); stale != nil {
+			m.Rcode = stale.Rcode
+			m.Answer = append(m.Answer, stale.Answer...)
+			m.Ns = append(m.Ns, stale.Ns...)
+			setExtendedError(r, m, dns.ExtendedErrorCodeStaleAnswer, "All upstream resolvers failed")
+			w.WriteMsg(m)
+			if h.statsCallback != nil {
+				h.statsCallback(false, false, true)
 			}
-			m.Answer = append(m.Answer, rr)
-		case dns.TypeAAAA:
-			// Return empty response for IPv6
-			m.Rcode = dns.RcodeSuccess
-		default:
-			m.Rcode = dns.RcodeNotImplemented
+			logrus.WithField("domain", domain).Debug("Served stale answer")
+			event.Action = QueryActionCached
+			event.Rcode = dns.RcodeToString[m.Rcode]
+			return
 		}
-
+		m.Rcode = dns.RcodeServerFailure
+		setExtendedError(r, m, dns.ExtendedErrorCodeNoReachableAuthority, "All upstream resolvers failed")
 		w.WriteMsg(m)
+		event.Action = QueryActionFailed
+		event.Rcode = dns.RcodeToString[m.Rcode]
 		return
 	}
+	event.Upstream, event.UpstreamRTT = upstream, rtt
+
+	// Trackers hidden behind a first-party name are blocked by their alias
+	if h.cnameUncloaking && (!bypass || h.captiveDetector.IsManualBypass()) {
+		if match := h.uncloak(blocker, domain, resp); match.Blocked && (!bypass || match.Security) {
+			if !h.monitorOnly(w, question, domain, match, blocker) {
+				h.serveBlocked(w, r, m, question, domain, match, blocker)
+				event.Action = QueryActionBlocked
+				event.Rcode = dns.RcodeToString[m.Rcode]
+				event.Rule = match.Rule
+				return
+			}
+			monitoredRule = match.Rule
+		}
+	}
 
-	// Forward to upstream
-	h.forwardToUpstream(w, r, m, domain, question.Qtype)
+	h.cacheResponse(domain, question.Qtype, resp)
+	w.WriteMsg(resp)
+	event.Action = QueryActionAllowed
+	event.Rcode = dns.RcodeToString[resp.Rcode]
+	return
 }
 
-// forwardToUpstream forwards the query to upstream DNS servers
-func (h *Handler) forwardToUpstream(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg, domain string, qtype uint16) {
-	c := new(dns.Client)
-	c.Timeout = 5 * time.Second
-
-	for _, upstream := range h.upstreams {
-		// Add port if not specified
-		if !strings.Contains(upstream, ":") {
-			upstream += ":53"
+// cacheResponse caches successful responses, and NXDOMAIN/NODATA per
+// RFC 2308
+func (h *Handler) cacheResponse(domain string, qtype uint16, resp *dns.Msg) {
+	if resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0 {
+		h.cache.Set(domain, qtype, resp.Answer)
+		if h.resolvedIPs != nil {
+			h.resolvedIPs.Record(domain, resp.Answer)
 		}
+	} else {
+		h.cache.SetNegative(domain, qtype, resp)
+	}
+}
 
-		resp, _, err := c.Exchange(r, upstream)
-		if err != nil {
-			logrus.WithError(err).WithField("upstream", upstream).Warn("Failed to query upstream")
+// uncloak checks the CNAME targets in resp against the blocklist. Names
+// the allowlist permits are never uncloaked, and allow-only mode is
+// ignored since aliases of allowed names are rarely allowlisted themselves.
+func (h *Handler) uncloak(blocker *Blocker, domain string, resp *dns.Msg) BlockMatch {
+	if blocker.IsAllowlisted(domain) {
+		return BlockMatch{}
+	}
+	for _, rr := range resp.Answer {
+		cname, ok := rr.(*dns.CNAME)
+		if !ok {
 			continue
 		}
+		target := strings.TrimSuffix(cname.Target, ".")
+		if match := blocker.Check(target); match.Blocked && match.Source != SourceAllowOnly {
+			match.CNAME = target
+			return match
+		}
+	}
+	return BlockMatch{}
+}
+
+// serveBlocked answers a query blocker blocked and reports it to the
+// callbacks
+func (h *Handler) serveBlocked(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg, question dns.Question, domain string, match BlockMatch, blocker *Blocker) {
+	// Get user/group metadata for logging
+	userEmail, groupName := blocker.GetMetadata()
+
+	logFields := logrus.Fields{
+		"domain": domain,
+		"rule":   match.Rule,
+		"source": match.Source,
+	}
+
+	if match.OverrodeAllowlist {
+		logFields["overrode_allowlist"] = true
+	}
+	if match.CNAME != "" {
+		logFields["cname"] = match.CNAME
+	}
+
+	// Include user/group if they're set
+	if userEmail != "" {
+		logFields["user"] = userEmail
+	}
+	if groupName != "" {
+		logFields["group"] = groupName
+	}
+
+	logrus.WithFields(logFields).Info("Blocked domain")
+
+	switch {
+	case isBypassCanary(match):
+		// Clients only treat NXDOMAIN as "don't bypass this network"
+		m.Rcode = dns.RcodeNameError
+	default:
+		h.writeBlockAnswer(m, question)
+	}
+
+	// Tell EDNS-aware clients this was policy, not a resolution failure
+	setExtendedError(r, m, dns.ExtendedErrorCodeBlocked, "Blocked by DNShield policy: "+match.Rule)
+
+	if h.recentBlocks != nil {
+		h.recentBlocks.Record(domain, match)
+	}
+	if h.statsCallback != nil {
+		h.statsCallback(false, true, false) // Blocked
+	}
+	if h.blockedCallback != nil {
+		clientIP, clientPort := remoteAddrParts(w.RemoteAddr())
+		h.blockedCallback(BlockEvent{
+			Timestamp:     time.Now(),
+			Domain:        domain,
+			QueryType:     dns.TypeToString[question.Qtype],
+			Rcode:         dns.RcodeToString[m.Rcode],
+			ClientIP:      clientIP,
+			ClientPort:    clientPort,
+			Rule:          match.Rule,
+			Source:        match.Source,
+			User:          userEmail,
+			Group:         groupName,
+			Category:      match.Category(),
+			DecodedDomain: match.Decoded,
+		})
+	}
+
+	w.WriteMsg(m)
+}
+
+// monitorOnly reports a match that monitor enforcement lets through to the
+// blocked callback and returns true. It returns false when the match must
+// be blocked: outside monitor mode, and for security-critical rules and
+// canaries.
+func (h *Handler) monitorOnly(w dns.ResponseWriter, question dns.Question, domain string, match BlockMatch, blocker *Blocker) bool {
+	if !blocker.Monitoring() || match.Security || match.Source == SourceCanary || isBypassCanary(match) {
+		return false
+	}
+
+	userEmail, groupName := blocker.GetMetadata()
+	logrus.WithFields(logrus.Fields{
+		"domain": domain,
+		"rule":   match.Rule,
+		"source": match.Source,
+	}).Info("Monitored domain, not blocked")
+
+	if h.blockedCallback != nil {
+		clientIP, clientPort := remoteAddrParts(w.RemoteAddr())
+		h.blockedCallback(BlockEvent{
+			Timestamp:  time.Now(),
+			Domain:     domain,
+			QueryType:  dns.TypeToString[question.Qtype],
+			ClientIP:   clientIP,
+			ClientPort: clientPort,
+			Rule:       match.Rule,
+			Source:     match.Source,
+			User:       userEmail,
+			Group:      groupName,
+			Category:   match.Category(),
+			Monitored:  true,
+		})
+	}
+	return true
+}
+
+// serveSinkholeReverse answers PTR and TXT queries for the block IP's
+// reverse name. It returns false for any other query.
+func (h *Handler) serveSinkholeReverse(w dns.ResponseWriter, m *dns.Msg, question dns.Question) bool {
+	if h.sinkholeReverse == "" || !strings.EqualFold(question.Name, h.sinkholeReverse) {
+		return false
+	}
+
+	hdr := dns.RR_Header{
+		Name:   question.Name,
+		Rrtype: question.Qtype,
+		Class:  dns.ClassINET,
+		Ttl:    10,
+	}
+	switch question.Qtype {
+	case dns.TypePTR:
+		m.Answer = append(m.Answer, &dns.PTR{Hdr: hdr, Ptr: SinkholePTR})
+	case dns.TypeTXT:
+		m.Answer = append(m.Answer, &dns.TXT{Hdr: hdr, Txt: []string{sinkholeTXT}})
+	}
+	m.Authoritative = true
+	w.WriteMsg(m)
+	return true
+}
+
+// serveHosts answers a query from hosts file entries. Names listed only
+// for the other address family get an empty NOERROR answer.
+func (h *Handler) serveHosts(w dns.ResponseWriter, m *dns.Msg, question dns.Question, ips []net.IP) {
+	m.Authoritative = true
+	for _, ip := range ips {
+		hdr := dns.RR_Header{
+			Name:   question.Name,
+			Rrtype: question.Qtype,
+			Class:  dns.ClassINET,
+			Ttl:    hostsTTL,
+		}
+		if question.Qtype == dns.TypeA {
+			m.Answer = append(m.Answer, &dns.A{Hdr: hdr, A: ip})
+		} else {
+			m.Answer = append(m.Answer, &dns.AAAA{Hdr: hdr, AAAA: ip})
+		}
+	}
+	w.WriteMsg(m)
+}
 
-		// Cache successful responses
-		if resp.Rcode == dns.RcodeSuccess && len(resp.Answer) > 0 {
-			h.cache.Set(domain, qtype, resp.Answer)
+// serveLocalRecords answers authoritatively from dns.localRecords
+func (h *Handler) serveLocalRecords(w dns.ResponseWriter, m *dns.Msg, question dns.Question, answer []dns.RR) {
+	m.Authoritative = true
+	for _, rr := range answer {
+		// Echo the name as the client spelled it
+		if strings.EqualFold(rr.Header().Name, question.Name) {
+			rr.Header().Name = question.Name
 		}
+		m.Answer = append(m.Answer, rr)
+	}
+	w.WriteMsg(m)
+}
+
+// SetLocalRecords replaces the local records, keeping the current ones if
+// any record is invalid
+func (h *Handler) SetLocalRecords(records []config.LocalRecordConfig) error {
+	return h.localRecords.Update(records)
+}
 
-		w.WriteMsg(resp)
+// setExtendedError attaches an RFC 8914 Extended DNS Error to m. Options
+// are only added when the client signalled EDNS support in its request.
+func setExtendedError(r *dns.Msg, m *dns.Msg, code uint16, text string) {
+	opt := r.IsEdns0()
+	if opt == nil {
 		return
 	}
 
-	// All upstreams failed
-	m.Rcode = dns.RcodeServerFailure
-	w.WriteMsg(m)
+	udpSize := opt.UDPSize()
+	if udpSize < dns.MinMsgSize {
+		udpSize = dns.MinMsgSize
+	}
+	m.SetEdns0(udpSize, opt.Do())
+	reply := m.IsEdns0()
+	reply.Option = append(reply.Option, &dns.EDNS0_EDE{InfoCode: code, ExtraText: text})
+}
+
+// sizeLimitedWriter truncates responses to maxSize, setting the TC bit so
+// UDP clients retry over TCP
+type sizeLimitedWriter struct {
+	dns.ResponseWriter
+	maxSize int
+}
+
+func (w *sizeLimitedWriter) WriteMsg(m *dns.Msg) error {
+	m.Truncate(w.maxSize)
+	return w.ResponseWriter.WriteMsg(m)
+}
+
+// maxResponseSize returns the largest response the client accepts: 64 KiB
+// over TCP, otherwise its EDNS0 buffer size (RFC 6891), or 512 bytes
+// without EDNS0
+func maxResponseSize(w dns.ResponseWriter, r *dns.Msg) int {
+	if _, ok := w.RemoteAddr().(*net.TCPAddr); ok {
+		return dns.MaxMsgSize
+	}
+	size := dns.MinMsgSize
+	if opt := r.IsEdns0(); opt != nil {
+		size = int(opt.UDPSize())
+	}
+	if size < dns.MinMsgSize {
+		size = dns.MinMsgSize
+	}
+	if size > dns.DefaultMsgSize {
+		size = dns.DefaultMsgSize
+	}
+	return size
+}
+
+// remoteAddrParts extracts the client IP and port from a UDP or TCP address
+func remoteAddrParts(addr net.Addr) (string, int) {
+	switch a := addr.(type) {
+	case *net.UDPAddr:
+		return a.IP.String(), a.Port
+	case *net.TCPAddr:
+		return a.IP.String(), a.Port
+	}
+	return "", 0
 }
 
 // GetCaptivePortalDetector returns the captive portal detector
@@ -249,10 +822,16 @@ func (h *Handler) GetCaptivePortalDetector() *CaptivePortalDetector {
 
 // Stop gracefully shuts down the handler and its components
 func (h *Handler) Stop() {
+	if h.prefetch != nil {
+		h.prefetch.stop()
+	}
 	if h.rateLimiter != nil {
 		h.rateLimiter.Stop()
 	}
 	if h.cache != nil {
 		h.cache.Stop()
 	}
+	if h.hosts != nil {
+		h.hosts.Stop()
+	}
 }
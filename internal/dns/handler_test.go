@@ -0,0 +1,461 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// testResponseWriter captures the message written by the handler
+type testResponseWriter struct {
+	msg *dns.Msg
+}
+
+func (w *testResponseWriter) LocalAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 53}
+}
+func (w *testResponseWriter) RemoteAddr() net.Addr {
+	return &net.UDPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+func (w *testResponseWriter) WriteMsg(m *dns.Msg) error   { w.msg = m; return nil }
+func (w *testResponseWriter) Write(b []byte) (int, error) { return len(b), nil }
+func (w *testResponseWriter) Close() error                { return nil }
+func (w *testResponseWriter) TsigStatus() error           { return nil }
+func (w *testResponseWriter) TsigTimersOnly(bool)         {}
+func (w *testResponseWriter) Hijack()                     {}
+func (w *testResponseWriter) written() *dns.Msg           { return w.msg }
+
+func newTestHandler(t *testing.T, blocked ...string) *Handler {
+	blocker := NewBlocker()
+	if err := blocker.UpdateDomains(blocked); err != nil {
+		t.Fatalf("UpdateDomains failed: %v", err)
+	}
+	h := NewHandler(blocker, &config.DNSConfig{CacheSize: 100, CacheTTL: time.Minute}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+	return h
+}
+
+func TestHandlerCurrentUpstreams(t *testing.T) {
+	networkResolvers := []string{"10.0.0.53", "10.0.1.53"}
+
+	tests := []struct {
+		name      string
+		upstreams []string
+		resolvers []string
+		want      []string
+	}{
+		{
+			name:      "StaticOnly",
+			upstreams: []string{"1.1.1.1", "8.8.8.8"},
+			resolvers: networkResolvers,
+			want:      []string{"1.1.1.1", "8.8.8.8"},
+		},
+		{
+			name:      "DHCPWithFallback",
+			upstreams: []string{"dhcp", "9.9.9.9"},
+			resolvers: networkResolvers,
+			want:      []string{"10.0.0.53", "10.0.1.53", "9.9.9.9"},
+		},
+		{
+			name:      "DHCPOnlyUsesDefaultFallbacks",
+			upstreams: []string{"dhcp"},
+			resolvers: networkResolvers,
+			want:      []string{"10.0.0.53", "10.0.1.53", "1.1.1.1", "8.8.8.8"},
+		},
+		{
+			name:      "DHCPWithoutNetworkResolvers",
+			upstreams: []string{"DHCP"},
+			resolvers: nil,
+			want:      []string{"1.1.1.1", "8.8.8.8"},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resolvers := tt.resolvers
+			h := &Handler{upstreams: tt.upstreams}
+			h.SetNetworkResolverSource(func() []string { return resolvers })
+
+			got := h.currentUpstreams()
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("currentUpstreams() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestHandlerExtendedErrors(t *testing.T) {
+	h := newTestHandler(t, "blocked.example.com")
+
+	t.Run("BlockedWithEDNS", func(t *testing.T) {
+		req := new(dns.Msg)
+		req.SetQuestion("ads.blocked.example.com.", dns.TypeA)
+		req.SetEdns0(1232, false)
+
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+
+		opt := w.msg.IsEdns0()
+		if opt == nil {
+			t.Fatal("Expected OPT record in blocked response")
+		}
+		var ede *dns.EDNS0_EDE
+		for _, o := range opt.Option {
+			if e, ok := o.(*dns.EDNS0_EDE); ok {
+				ede = e
+			}
+		}
+		if ede == nil || ede.InfoCode != dns.ExtendedErrorCodeBlocked {
+			t.Fatalf("Expected EDE Blocked, got %v", opt.Option)
+		}
+		if ede.ExtraText != "Blocked by DNShield policy: blocked.example.com" {
+			t.Errorf("Unexpected EDE text %q", ede.ExtraText)
+		}
+	})
+
+	t.Run("BlockedWithoutEDNS", func(t *testing.T) {
+		req := new(dns.Msg)
+		req.SetQuestion("blocked.example.com.", dns.TypeA)
+
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+
+		if w.msg.IsEdns0() != nil {
+			t.Error("OPT record must not be added when the client did not use EDNS")
+		}
+		if len(w.msg.Answer) != 1 {
+			t.Errorf("Expected sinkhole answer, got %v", w.msg.Answer)
+		}
+	})
+}
+
+func TestHandlerSinkholeReverse(t *testing.T) {
+	h := newTestHandler(t)
+
+	req := new(dns.Msg)
+	req.SetQuestion("1.0.0.127.in-addr.arpa.", dns.TypePTR)
+	w := &testResponseWriter{}
+	h.ServeDNS(w, req)
+
+	if len(w.msg.Answer) != 1 {
+		t.Fatalf("Expected one PTR answer, got %v", w.msg.Answer)
+	}
+	if ptr, ok := w.msg.Answer[0].(*dns.PTR); !ok || ptr.Ptr != SinkholePTR {
+		t.Errorf("Unexpected answer %v", w.msg.Answer[0])
+	}
+}
+
+func TestHandlerServerIdentity(t *testing.T) {
+	chaos := func(name string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeTXT)
+		req.Question[0].Qclass = dns.ClassCHAOS
+		return req
+	}
+
+	t.Run("Refuse", func(t *testing.T) {
+		h := newTestHandler(t)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, chaos("version.bind."))
+
+		if w.msg.Rcode != dns.RcodeRefused || len(w.msg.Answer) != 0 {
+			t.Errorf("Expected REFUSED with no answer, got %s %v", dns.RcodeToString[w.msg.Rcode], w.msg.Answer)
+		}
+	})
+
+	t.Run("Answer", func(t *testing.T) {
+		h := NewHandler(NewBlocker(), &config.DNSConfig{
+			CacheSize: 100,
+			ServerIdentity: config.ServerIdentityConfig{
+				Mode:    ServerIdentityAnswer,
+				Version: "unknown",
+			},
+		}, "127.0.0.1", &config.CaptivePortalConfig{})
+		t.Cleanup(h.Stop)
+
+		w := &testResponseWriter{}
+		h.ServeDNS(w, chaos("VERSION.BIND."))
+		if len(w.msg.Answer) != 1 {
+			t.Fatalf("Expected one TXT answer, got %v", w.msg.Answer)
+		}
+		if txt, ok := w.msg.Answer[0].(*dns.TXT); !ok || txt.Txt[0] != "unknown" || txt.Hdr.Class != dns.ClassCHAOS {
+			t.Errorf("Unexpected answer %v", w.msg.Answer[0])
+		}
+
+		// No hostname configured, so it is still refused
+		w = &testResponseWriter{}
+		h.ServeDNS(w, chaos("hostname.bind."))
+		if w.msg.Rcode != dns.RcodeRefused {
+			t.Errorf("Expected REFUSED for unconfigured hostname.bind, got %s", dns.RcodeToString[w.msg.Rcode])
+		}
+	})
+}
+
+func TestStripNSID(t *testing.T) {
+	req := new(dns.Msg)
+	req.SetQuestion("example.com.", dns.TypeA)
+	req.SetEdns0(1232, false)
+	opt := req.IsEdns0()
+	opt.Option = append(opt.Option, &dns.EDNS0_NSID{Code: dns.EDNS0NSID}, &dns.EDNS0_COOKIE{Code: dns.EDNS0COOKIE, Cookie: "0123456789abcdef"})
+
+	stripNSID(req)
+
+	if len(opt.Option) != 1 || opt.Option[0].Option() != dns.EDNS0COOKIE {
+		t.Errorf("Expected only the cookie option to remain, got %v", opt.Option)
+	}
+}
+
+func TestHandlerManualBypassKeepsSecurityBlocks(t *testing.T) {
+	h := newTestHandler(t, "ads.example.com")
+	h.blocker.UpdateSecurityDomainsWithSources([]string{"c2.example.com"}, nil)
+	h.captiveDetector = NewCaptivePortalDetector(&config.CaptivePortalConfig{AllowManualBypass: true})
+	if _, err := h.captiveDetector.EnableManualBypass(time.Minute); err != nil {
+		t.Fatalf("EnableManualBypass failed: %v", err)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("c2.example.com.", dns.TypeA)
+	w := &testResponseWriter{}
+	h.ServeDNS(w, req)
+
+	if len(w.msg.Answer) != 1 {
+		t.Fatalf("Expected sinkhole answer during manual bypass, got %v", w.msg.Answer)
+	}
+	if a, ok := w.msg.Answer[0].(*dns.A); !ok || !a.A.Equal(h.blockIP) {
+		t.Errorf("Expected security domain to stay blocked, got %v", w.msg.Answer[0])
+	}
+}
+
+// startTestUpstream serves answers from a local UDP resolver and returns
+// its address
+func startTestUpstream(t *testing.T, answer func(q dns.Question) []dns.RR) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			m := new(dns.Msg)
+			m.SetReply(r)
+			m.Answer = answer(r.Question[0])
+			w.WriteMsg(m)
+		}),
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestHandlerCNAMEUncloaking(t *testing.T) {
+	upstream := startTestUpstream(t, func(q dns.Question) []dns.RR {
+		cname, _ := dns.NewRR(q.Name + " 60 IN CNAME edge.tracker.example.net.")
+		a, _ := dns.NewRR("edge.tracker.example.net. 60 IN A 192.0.2.10")
+		return []dns.RR{cname, a}
+	})
+
+	query := func(h *Handler, name string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+		return w.msg
+	}
+	sinkholed := func(m *dns.Msg) bool {
+		if len(m.Answer) != 1 {
+			return false
+		}
+		a, ok := m.Answer[0].(*dns.A)
+		return ok && a.A.Equal(net.IPv4(127, 0, 0, 1))
+	}
+
+	blocker := NewBlocker()
+	if err := blocker.UpdateDomains([]string{"tracker.example.net"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := blocker.UpdateAllowlist([]string{"allowed.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(blocker, &config.DNSConfig{Upstreams: []string{upstream}, CacheSize: 100, CacheTTL: time.Minute}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+
+	var events []BlockEvent
+	h.SetBlockedCallback(func(e BlockEvent) { events = append(events, e) })
+
+	// Disabled: the alias is returned as is
+	if m := query(h, "metrics.shop.example.com."); sinkholed(m) {
+		t.Fatal("query blocked with uncloaking disabled")
+	}
+	h.ClearCache()
+
+	h.SetCNAMEUncloaking(true)
+	if m := query(h, "metrics.shop.example.com."); !sinkholed(m) {
+		t.Errorf("cloaked tracker answered with %v", m.Answer)
+	}
+	if len(events) != 1 || events[0].Domain != "metrics.shop.example.com" || events[0].Rule != "tracker.example.net" {
+		t.Errorf("block events = %+v", events)
+	}
+
+	// The allowlist still wins
+	if m := query(h, "allowed.example.com."); sinkholed(m) {
+		t.Error("allowlisted name blocked by its alias")
+	}
+}
+
+func TestHandlerClearCache(t *testing.T) {
+	var queries atomic.Int32
+	upstream := startTestUpstream(t, func(q dns.Question) []dns.RR {
+		queries.Add(1)
+		a, _ := dns.NewRR(q.Name + " 60 IN A 192.0.2.30")
+		return []dns.RR{a}
+	})
+	h := NewHandler(NewBlocker(), &config.DNSConfig{Upstreams: []string{upstream}, CacheSize: 100, CacheTTL: time.Minute}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+
+	query := func() {
+		req := new(dns.Msg)
+		req.SetQuestion("cached.example.com.", dns.TypeA)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+		if w.msg == nil || len(w.msg.Answer) != 1 {
+			t.Fatalf("got %v, want one answer", w.msg)
+		}
+	}
+
+	query()
+	query()
+	if n := queries.Load(); n != 1 {
+		t.Fatalf("upstream queried %d times, want the second answer cached", n)
+	}
+	if cleared := h.ClearCache(); cleared != 1 {
+		t.Errorf("ClearCache() = %d, want 1", cleared)
+	}
+	query()
+	if n := queries.Load(); n != 2 {
+		t.Errorf("upstream queried %d times, want the cache cleared", n)
+	}
+}
+
+func TestHandlerMonitorEnforcement(t *testing.T) {
+	upstream := startTestUpstream(t, func(q dns.Question) []dns.RR {
+		a, _ := dns.NewRR(q.Name + " 60 IN A 192.0.2.20")
+		return []dns.RR{a}
+	})
+
+	blocker := NewBlocker()
+	if err := blocker.UpdateDomains([]string{"ads.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	blocker.UpdateSecurityDomainsWithSources([]string{"c2.example.com"}, nil)
+	blocker.SetDefaultEnforcement(config.EnforcementMonitor)
+	h := NewHandler(blocker, &config.DNSConfig{Upstreams: []string{upstream}, CacheSize: 100, CacheTTL: time.Minute}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+
+	var events []BlockEvent
+	var queries []QueryEvent
+	h.SetBlockedCallback(func(e BlockEvent) { events = append(events, e) })
+	h.SetQueryCallback(func(e QueryEvent) { queries = append(queries, e) })
+	query := func(name string) net.IP {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+		if len(w.msg.Answer) != 1 {
+			t.Fatalf("%s answered with %v", name, w.msg.Answer)
+		}
+		return w.msg.Answer[0].(*dns.A).A
+	}
+
+	if ip := query("ads.example.com."); !ip.Equal(net.ParseIP("192.0.2.20")) {
+		t.Errorf("monitored domain resolved to %v, want the upstream answer", ip)
+	}
+	if len(events) != 1 || !events[0].Monitored || events[0].Rule != "ads.example.com" {
+		t.Errorf("block events = %+v", events)
+	}
+	if len(queries) != 1 || queries[0].Action != QueryActionMonitored || queries[0].Rule != "ads.example.com" {
+		t.Errorf("query events = %+v", queries)
+	}
+
+	// Security-critical rules are enforced while monitoring
+	if ip := query("c2.example.com."); !ip.Equal(h.blockIP) {
+		t.Errorf("security domain resolved to %v while monitoring", ip)
+	}
+
+	// Rules that set block override the monitoring default
+	blocker.SetEnforcement(config.EnforcementBlock)
+	if ip := query("ads.example.com."); !ip.Equal(h.blockIP) {
+		t.Errorf("domain resolved to %v with block enforcement", ip)
+	}
+}
+
+// capturingWriter is a ResponseWriter that keeps the written message
+type capturingWriter interface {
+	dns.ResponseWriter
+	written() *dns.Msg
+}
+
+// tcpResponseWriter is a testResponseWriter for a client connected over TCP
+type tcpResponseWriter struct {
+	testResponseWriter
+}
+
+func (w *tcpResponseWriter) RemoteAddr() net.Addr {
+	return &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1), Port: 40000}
+}
+
+func TestHandlerTruncatesLargeResponses(t *testing.T) {
+	h := newTestHandler(t)
+	var records []config.LocalRecordConfig
+	for i := 1; i <= 60; i++ {
+		records = append(records, config.LocalRecordConfig{Name: "big.lan", Type: "A", Value: net.IPv4(10, 0, 0, byte(i)).String()})
+	}
+	if err := h.SetLocalRecords(records); err != nil {
+		t.Fatal(err)
+	}
+
+	query := func(w capturingWriter, edns uint16) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion("big.lan.", dns.TypeA)
+		if edns > 0 {
+			req.SetEdns0(edns, false)
+		}
+		h.ServeDNS(w, req)
+		return w.written()
+	}
+
+	tests := []struct {
+		name      string
+		w         capturingWriter
+		edns      uint16
+		truncated bool
+		maxSize   int
+	}{
+		{"UDP without EDNS0", &testResponseWriter{}, 0, true, dns.MinMsgSize},
+		{"UDP with large EDNS0 buffer", &testResponseWriter{}, 4096, false, 4096},
+		{"TCP", &tcpResponseWriter{}, 0, false, dns.MaxMsgSize},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			m := query(tt.w, tt.edns)
+			if m.Truncated != tt.truncated {
+				t.Errorf("Truncated = %v, want %v", m.Truncated, tt.truncated)
+			}
+			if !tt.truncated && len(m.Answer) != 60 {
+				t.Errorf("got %d answers, want 60", len(m.Answer))
+			}
+			if m.Len() > tt.maxSize {
+				t.Errorf("response is %d bytes, want at most %d", m.Len(), tt.maxSize)
+			}
+		})
+	}
+}
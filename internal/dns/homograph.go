@@ -0,0 +1,271 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"dnshield/internal/config"
+)
+
+// SourceHomograph is reported for blocks of lookalikes of protected brands
+const SourceHomograph = "homograph"
+
+// confusables maps letters that render like an ASCII letter to it. It
+// covers the Cyrillic and Greek lookalikes used in phishing and Latin
+// letters with diacritics.
+var confusables = func() map[rune]rune {
+	groups := map[rune]string{
+		'a': "аαàáâãäåāăąǎ",
+		'b': "ЬЪβ",
+		'c': "сϲçćĉċč",
+		'd': "ԁďđ",
+		'e': "еєεèéêëēĕėęěҽ",
+		'g': "ɡĝğġģ",
+		'h': "һнĥħ",
+		'i': "іιìíîïĩīĭįı",
+		'j': "јĵ",
+		'k': "кκķ",
+		'l': "ӏĺļľŀł",
+		'm': "м",
+		'n': "ոñńņňηп",
+		'o': "оοσòóôõöøōŏőօ",
+		'p': "рρ",
+		'q': "ԛ",
+		'r': "гŕŗř",
+		's': "ѕśŝşš",
+		't': "тτţťŧ",
+		'u': "υùúûüũūŭůűųս",
+		'v': "νѵ",
+		'w': "ԝωŵ",
+		'x': "хχ",
+		'y': "уүýÿŷ",
+		'z': "źżž",
+	}
+	m := make(map[rune]rune)
+	for ascii, lookalikes := range groups {
+		for _, r := range lookalikes {
+			m[r] = ascii
+		}
+	}
+	return m
+}()
+
+// skeleton reduces a label to the ASCII string it looks like, so that
+// lookalikes of the same name compare equal
+func skeleton(label string) string {
+	var b strings.Builder
+	for _, r := range label {
+		if ascii, ok := confusables[r]; ok {
+			r = ascii
+		}
+		switch r {
+		case '0':
+			r = 'o'
+		case '1':
+			r = 'l'
+		}
+		b.WriteRune(r)
+	}
+	s := strings.ReplaceAll(b.String(), "rn", "m")
+	return strings.ReplaceAll(s, "vv", "w")
+}
+
+// HomographDetector flags internationalized names whose registered label
+// looks like that of a protected brand domain once decoded, e.g.
+// xn--pple-43d.com (аpple.com with a Cyrillic а)
+type HomographDetector struct {
+	brands  map[string]string // Skeleton of the brand's label -> brand domain
+	enforce bool
+}
+
+// HomographEvent describes a lookalike the detector flagged
+type HomographEvent struct {
+	Domain   string // As queried, in punycode
+	Decoded  string // As displayed to the user
+	Brand    string // Protected domain it imitates
+	ClientIP string
+	Blocked  bool // False in warn mode
+}
+
+// NewHomographDetector creates a detector from the homograph config section
+func NewHomographDetector(cfg *config.HomographConfig) *HomographDetector {
+	d := &HomographDetector{
+		brands:  make(map[string]string),
+		enforce: cfg.Mode == config.HomographModeBlock,
+	}
+	for _, brand := range cfg.Brands {
+		brand = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(brand)), ".")
+		if label := registrableLabel(brand); label != "" {
+			d.brands[skeleton(label)] = registrableDomain(brand)
+		}
+	}
+	return d
+}
+
+// Enforce reports whether lookalikes are blocked rather than only reported
+func (d *HomographDetector) Enforce() bool {
+	return d.enforce
+}
+
+// Check decodes domain and reports the brand it imitates, if any. Only
+// names with punycode labels are considered; the brands themselves and
+// their subdomains never match.
+func (d *HomographDetector) Check(domain string) (decoded, brand string, lookalike bool) {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+	if !strings.Contains(domain, "xn--") {
+		return "", "", false
+	}
+	decoded, err := decodeIDN(domain)
+	if err != nil {
+		return "", "", false
+	}
+	brand, ok := d.brands[skeleton(registrableLabel(decoded))]
+	if !ok || registrableDomain(decoded) == brand {
+		return decoded, "", false
+	}
+	return decoded, brand, true
+}
+
+// SetHomographDetector makes the handler check internationalized names
+// with detector. cb is called for every lookalike, blocked or not.
+func (h *Handler) SetHomographDetector(detector *HomographDetector, cb func(event HomographEvent)) {
+	h.homograph = detector
+	h.homographCallback = cb
+}
+
+// checkHomograph reports lookalike queries and returns a block match when
+// the detector enforces. Allowlisted names are never flagged.
+func (h *Handler) checkHomograph(blocker *Blocker, domain, clientIP string) (BlockMatch, bool) {
+	if h.homograph == nil {
+		return BlockMatch{}, false
+	}
+	decoded, brand, lookalike := h.homograph.Check(domain)
+	if !lookalike || blocker.IsAllowlisted(domain) {
+		return BlockMatch{}, false
+	}
+
+	if h.homographCallback != nil {
+		h.homographCallback(HomographEvent{
+			Domain:   domain,
+			Decoded:  decoded,
+			Brand:    brand,
+			ClientIP: clientIP,
+			Blocked:  h.homograph.Enforce(),
+		})
+	}
+	if !h.homograph.Enforce() {
+		return BlockMatch{}, false
+	}
+	return BlockMatch{Blocked: true, Rule: brand, Source: SourceHomograph, Decoded: decoded}, true
+}
+
+// decodeIDN converts the punycode labels of domain to Unicode
+func decodeIDN(domain string) (string, error) {
+	labels := strings.Split(domain, ".")
+	for i, label := range labels {
+		if !strings.HasPrefix(label, "xn--") {
+			continue
+		}
+		decoded, err := decodePunycode(label[len("xn--"):])
+		if err != nil {
+			return "", fmt.Errorf("label %q: %v", label, err)
+		}
+		labels[i] = decoded
+	}
+	return strings.Join(labels, "."), nil
+}
+
+// Punycode parameters (RFC 3492)
+const (
+	punyBase        = 36
+	punyTMin        = 1
+	punyTMax        = 26
+	punySkew        = 38
+	punyDamp        = 700
+	punyInitialBias = 72
+	punyInitialN    = 128
+	punyMaxRune     = 0x10FFFF
+)
+
+// decodePunycode decodes a punycode label without its xn-- prefix
+func decodePunycode(s string) (string, error) {
+	var output []rune
+	if pos := strings.LastIndexByte(s, '-'); pos >= 0 {
+		for _, c := range s[:pos] {
+			if c >= 0x80 {
+				return "", fmt.Errorf("non-ASCII basic code point")
+			}
+			output = append(output, c)
+		}
+		s = s[pos+1:]
+	}
+
+	n, bias, i := punyInitialN, punyInitialBias, 0
+	for k := 0; k < len(s); {
+		oldi, w := i, 1
+		for t := punyBase; ; t += punyBase {
+			if k >= len(s) {
+				return "", fmt.Errorf("truncated input")
+			}
+			digit := punycodeDigit(s[k])
+			k++
+			if digit < 0 {
+				return "", fmt.Errorf("invalid digit %q", s[k-1])
+			}
+			if digit > (punyMaxRune*punyBase-i)/w {
+				return "", fmt.Errorf("overflow")
+			}
+			i += digit * w
+			threshold := t - bias
+			if threshold < punyTMin {
+				threshold = punyTMin
+			} else if threshold > punyTMax {
+				threshold = punyTMax
+			}
+			if digit < threshold {
+				break
+			}
+			w *= punyBase - threshold
+		}
+
+		points := len(output) + 1
+		bias = punycodeAdapt(i-oldi, points, oldi == 0)
+		n += i / points
+		i %= points
+		if n > punyMaxRune {
+			return "", fmt.Errorf("code point out of range")
+		}
+		output = append(output, 0)
+		copy(output[i+1:], output[i:])
+		output[i] = rune(n)
+		i++
+	}
+	return string(output), nil
+}
+
+func punycodeDigit(c byte) int {
+	switch {
+	case c >= 'a' && c <= 'z':
+		return int(c - 'a')
+	case c >= 'A' && c <= 'Z':
+		return int(c - 'A')
+	case c >= '0' && c <= '9':
+		return int(c-'0') + 26
+	}
+	return -1
+}
+
+func punycodeAdapt(delta, points int, first bool) int {
+	if first {
+		delta /= punyDamp
+	} else {
+		delta /= 2
+	}
+	delta += delta / points
+	k := 0
+	for delta > ((punyBase-punyTMin)*punyTMax)/2 {
+		delta /= punyBase - punyTMin
+		k += punyBase
+	}
+	return k + (punyBase-punyTMin+1)*delta/(delta+punySkew)
+}
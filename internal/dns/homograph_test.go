@@ -0,0 +1,64 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+func TestDecodePunycode(t *testing.T) {
+	for encoded, want := range map[string]string{
+		"pple-43d":   "аpple",
+		"80ak6aa92e": "аррӏе",
+		"bcher-kva":  "bücher",
+		"mnchen-3ya": "münchen",
+	} {
+		if got, err := decodePunycode(encoded); err != nil || got != want {
+			t.Errorf("decodePunycode(%s) = %q, %v; want %q", encoded, got, err, want)
+		}
+	}
+	if _, err := decodePunycode("99999999999"); err == nil {
+		t.Error("expected an error for an out of range code point")
+	}
+}
+
+func TestHomographDetector(t *testing.T) {
+	d := NewHomographDetector(&config.HomographConfig{Mode: config.HomographModeWarn, Brands: []string{"apple.com", "PayPal.com"}})
+
+	for domain, want := range map[string]string{
+		"xn--pple-43d.com":        "apple.com",  // Cyrillic а
+		"login.xn--80ak6aa92e.co": "apple.com",  // All Cyrillic, other TLD
+		"xn--pypal-4ve.com":       "paypal.com", // Cyrillic а
+		"apple.com":               "",           // Not internationalized
+		"xn--bcher-kva.com":       "",           // Unrelated
+	} {
+		if _, brand, _ := d.Check(domain); brand != want {
+			t.Errorf("Check(%s) brand = %q, want %q", domain, brand, want)
+		}
+	}
+	if decoded, _, _ := d.Check("xn--pple-43d.com."); decoded != "аpple.com" {
+		t.Errorf("decoded = %q", decoded)
+	}
+}
+
+func TestHandlerHomographBlock(t *testing.T) {
+	h := NewHandler(NewBlocker(), &config.DNSConfig{CacheSize: 100, CacheTTL: time.Minute}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+	var events []HomographEvent
+	var blocks []BlockEvent
+	h.SetBlockedCallback(func(e BlockEvent) { blocks = append(blocks, e) })
+	h.SetHomographDetector(NewHomographDetector(&config.HomographConfig{Mode: config.HomographModeBlock, Brands: []string{"apple.com"}}), func(e HomographEvent) { events = append(events, e) })
+
+	req := new(dns.Msg)
+	req.SetQuestion("xn--pple-43d.com.", dns.TypeA)
+	h.ServeDNS(&testResponseWriter{}, req)
+
+	if len(events) != 1 || !events[0].Blocked || events[0].Brand != "apple.com" {
+		t.Fatalf("events = %+v, want one blocked apple.com lookalike", events)
+	}
+	if len(blocks) != 1 || blocks[0].Domain != "xn--pple-43d.com" || blocks[0].DecodedDomain != "аpple.com" || blocks[0].Category != CategoryHomograph {
+		t.Errorf("blocks = %+v", blocks)
+	}
+}
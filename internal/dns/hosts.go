@@ -0,0 +1,186 @@
+package dns
+
+import (
+	"bufio"
+	"io"
+	"net"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/utils"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// hostsCheckInterval is how often the hosts file is checked for changes
+	hostsCheckInterval = 5 * time.Second
+	// hostsTTL is the TTL of answers served from the hosts file
+	hostsTTL = 10
+)
+
+// HostsFile answers queries from a hosts(5) file, reloading it when it
+// changes on disk
+type HostsFile struct {
+	mu         sync.RWMutex
+	path       string
+	v4         map[string][]net.IP
+	v6         map[string][]net.IP
+	modTime    time.Time
+	size       int64
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewHostsFile loads path and starts watching it for changes. A missing
+// file is not an error; it is picked up if it appears later.
+func NewHostsFile(path string) *HostsFile {
+	hf := &HostsFile{
+		path:       path,
+		v4:         make(map[string][]net.IP),
+		v6:         make(map[string][]net.IP),
+		shutdownCh: make(chan struct{}),
+	}
+	hf.reload()
+
+	hf.wg.Add(1)
+	go hf.watch()
+
+	return hf
+}
+
+// Lookup returns the addresses for name matching the IP family of qtype.
+// found reports whether name appears in the hosts file at all, so callers
+// can answer NODATA for names that only have the other family.
+func (hf *HostsFile) Lookup(name string, qtype uint16) (ips []net.IP, found bool) {
+	hf.mu.RLock()
+	defer hf.mu.RUnlock()
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	v4, ok4 := hf.v4[name]
+	v6, ok6 := hf.v6[name]
+
+	switch qtype {
+	case dns.TypeA:
+		return v4, ok4 || ok6
+	case dns.TypeAAAA:
+		return v6, ok4 || ok6
+	}
+	return nil, false
+}
+
+// Stop stops watching the hosts file
+func (hf *HostsFile) Stop() {
+	close(hf.shutdownCh)
+	hf.wg.Wait()
+}
+
+// watch polls the hosts file for modifications
+func (hf *HostsFile) watch() {
+	defer hf.wg.Done()
+	ticker := time.NewTicker(hostsCheckInterval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-hf.shutdownCh:
+			return
+		case <-ticker.C:
+			hf.reload()
+		}
+	}
+}
+
+// reload re-reads the hosts file if its size or modification time changed
+func (hf *HostsFile) reload() {
+	info, err := os.Stat(hf.path)
+	if err != nil {
+		hf.mu.Lock()
+		hadEntries := len(hf.v4)+len(hf.v6) > 0
+		hf.v4 = make(map[string][]net.IP)
+		hf.v6 = make(map[string][]net.IP)
+		hf.modTime, hf.size = time.Time{}, 0
+		hf.mu.Unlock()
+		if hadEntries {
+			logrus.WithField("path", hf.path).Info("Hosts file removed, cleared local entries")
+		}
+		return
+	}
+
+	hf.mu.RLock()
+	unchanged := info.ModTime().Equal(hf.modTime) && info.Size() == hf.size
+	hf.mu.RUnlock()
+	if unchanged {
+		return
+	}
+
+	if info.Size() > utils.MaxConfigFileSize {
+		logrus.WithFields(logrus.Fields{
+			"path":    hf.path,
+			"size":    info.Size(),
+			"maximum": utils.MaxConfigFileSize,
+		}).Warn("Hosts file exceeds maximum size, ignoring")
+		return
+	}
+
+	f, err := os.Open(hf.path)
+	if err != nil {
+		logrus.WithError(err).WithField("path", hf.path).Warn("Failed to read hosts file")
+		return
+	}
+	defer f.Close()
+
+	v4, v6 := parseHosts(f)
+
+	hf.mu.Lock()
+	hf.v4, hf.v6 = v4, v6
+	hf.modTime, hf.size = info.ModTime(), info.Size()
+	hf.mu.Unlock()
+
+	logrus.WithFields(logrus.Fields{
+		"path":  hf.path,
+		"names": len(v4) + len(v6),
+	}).Info("Loaded hosts file")
+}
+
+// parseHosts parses hosts(5) content into IPv4 and IPv6 address maps keyed
+// by lowercased hostname
+func parseHosts(r io.Reader) (v4, v6 map[string][]net.IP) {
+	v4 = make(map[string][]net.IP)
+	v6 = make(map[string][]net.IP)
+
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := scanner.Text()
+		if i := strings.IndexByte(line, '#'); i >= 0 {
+			line = line[:i]
+		}
+		fields := strings.Fields(line)
+		if len(fields) < 2 {
+			continue
+		}
+
+		// Zone suffixes (fe80::1%lo0) can't be returned in an AAAA record
+		addr := fields[0]
+		if i := strings.IndexByte(addr, '%'); i >= 0 {
+			addr = addr[:i]
+		}
+		ip := net.ParseIP(addr)
+		if ip == nil {
+			continue
+		}
+
+		for _, name := range fields[1:] {
+			name = strings.ToLower(strings.TrimSuffix(name, "."))
+			if ip4 := ip.To4(); ip4 != nil {
+				v4[name] = append(v4[name], ip4)
+			} else {
+				v6[name] = append(v6[name], ip)
+			}
+		}
+	}
+
+	return v4, v6
+}
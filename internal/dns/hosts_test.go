@@ -0,0 +1,83 @@
+package dns
+
+import (
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+func TestParseHosts(t *testing.T) {
+	content := `# Host Database
+127.0.0.1	localhost
+255.255.255.255	broadcasthost
+::1             localhost
+fe80::1%lo0	localhost
+10.0.0.5 api.dev.local  Web.Dev.Local # trailing comment
+not-an-ip bogus.local
+`
+	v4, v6 := parseHosts(strings.NewReader(content))
+
+	tests := []struct {
+		name string
+		m    map[string][]net.IP
+		want []string
+	}{
+		{"localhost", v4, []string{"127.0.0.1"}},
+		{"localhost", v6, []string{"::1", "fe80::1"}},
+		{"api.dev.local", v4, []string{"10.0.0.5"}},
+		{"web.dev.local", v4, []string{"10.0.0.5"}},
+		{"bogus.local", v4, nil},
+	}
+
+	for _, tt := range tests {
+		var got []string
+		for _, ip := range tt.m[tt.name] {
+			got = append(got, ip.String())
+		}
+		if strings.Join(got, ",") != strings.Join(tt.want, ",") {
+			t.Errorf("%s: got %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestHandlerServesHostsFile(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "hosts")
+	if err := os.WriteFile(path, []byte("10.0.0.5 app.dev.local\n"), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	h := NewHandler(NewBlocker(), &config.DNSConfig{CacheSize: 100, CacheTTL: time.Minute, HostsFile: path}, "127.0.0.1", &config.CaptivePortalConfig{})
+	defer h.Stop()
+
+	t.Run("A", func(t *testing.T) {
+		req := new(dns.Msg)
+		req.SetQuestion("App.Dev.Local.", dns.TypeA)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+
+		if !w.msg.Authoritative || len(w.msg.Answer) != 1 {
+			t.Fatalf("Expected one authoritative answer, got %v", w.msg)
+		}
+		if a, ok := w.msg.Answer[0].(*dns.A); !ok || !a.A.Equal(net.ParseIP("10.0.0.5")) {
+			t.Errorf("Unexpected answer %v", w.msg.Answer[0])
+		}
+	})
+
+	t.Run("AAAANoData", func(t *testing.T) {
+		req := new(dns.Msg)
+		req.SetQuestion("app.dev.local.", dns.TypeAAAA)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+
+		if w.msg.Rcode != dns.RcodeSuccess || len(w.msg.Answer) != 0 {
+			t.Errorf("Expected empty NOERROR answer, got %v", w.msg)
+		}
+	})
+}
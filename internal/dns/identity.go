@@ -0,0 +1,84 @@
+package dns
+
+import (
+	"strings"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+// Server identity modes for dns.serverIdentity.mode
+const (
+	ServerIdentityRefuse = "refuse" // Refuse all identification queries
+	ServerIdentityAnswer = "answer" // Answer with the configured values
+)
+
+// serverIdentity answers queries that would otherwise let anyone on the
+// network fingerprint the agent or its upstreams
+type serverIdentity struct {
+	answer   bool
+	version  string
+	hostname string
+}
+
+func newServerIdentity(cfg config.ServerIdentityConfig) *serverIdentity {
+	return &serverIdentity{
+		answer:   cfg.Mode == ServerIdentityAnswer,
+		version:  cfg.Version,
+		hostname: cfg.Hostname,
+	}
+}
+
+// serveChaos handles every CHAOS-class query (version.bind, hostname.bind,
+// id.server, ...) so none are forwarded upstream. Names without a
+// configured value, or all names in refuse mode, get REFUSED. It returns
+// false for queries of any other class.
+func (si *serverIdentity) serveChaos(w dns.ResponseWriter, m *dns.Msg, question dns.Question) bool {
+	if question.Qclass != dns.ClassCHAOS {
+		return false
+	}
+
+	value := ""
+	if si.answer && (question.Qtype == dns.TypeTXT || question.Qtype == dns.TypeANY) {
+		switch strings.ToLower(question.Name) {
+		case "version.bind.", "version.server.":
+			value = si.version
+		case "hostname.bind.", "id.server.":
+			value = si.hostname
+		}
+	}
+
+	if value == "" {
+		m.Rcode = dns.RcodeRefused
+	} else {
+		m.Authoritative = true
+		m.Answer = append(m.Answer, &dns.TXT{
+			Hdr: dns.RR_Header{
+				Name:   question.Name,
+				Rrtype: dns.TypeTXT,
+				Class:  dns.ClassCHAOS,
+				Ttl:    0,
+			},
+			Txt: []string{value},
+		})
+	}
+	w.WriteMsg(m)
+	return true
+}
+
+// stripNSID removes any EDNS NSID request from r before it is forwarded,
+// so upstreams don't reveal their identity through the agent
+func stripNSID(r *dns.Msg) {
+	opt := r.IsEdns0()
+	if opt == nil {
+		return
+	}
+
+	options := opt.Option[:0]
+	for _, o := range opt.Option {
+		if o.Option() != dns.EDNS0NSID {
+			options = append(options, o)
+		}
+	}
+	opt.Option = options
+}
@@ -0,0 +1,132 @@
+package dns
+
+// Overlay limits: changes go on top of the compact trie until they number
+// more than a tenth of its rules, and at least overlayMinRules
+const (
+	overlayMinRules = 10000
+	overlayFraction = 10
+)
+
+// layeredTrie is a compactTrie with the rules added and removed since it
+// was built, so a refresh that changes a few rules of a large list doesn't
+// rebuild it. Like the compactTrie it is never modified; Apply returns a
+// new one.
+type layeredTrie struct {
+	base *compactTrie
+	// Rules added since base was built, or given a new source
+	added *domainTrie
+	// Rules of base that are gone or were given a new source
+	removed map[string]bool
+	size    int
+}
+
+func newLayeredTrie(base *compactTrie) *layeredTrie {
+	return &layeredTrie{base: base, added: newDomainTrie(), size: base.Len()}
+}
+
+// Match finds the rule covering domain, like domainTrie.Match
+func (t *layeredTrie) Match(domain string) (rule, source string, ok bool) {
+	rule, source, ok = t.base.match(domain, t.removed)
+	if t.added.Len() == 0 {
+		return rule, source, ok
+	}
+	if r, s, found := t.added.Match(domain); found && (!ok || !closerRule(rule, r)) {
+		return r, s, true
+	}
+	return rule, source, ok
+}
+
+// closerRule reports whether rule a, matched on the same name as rule b,
+// is for a closer parent, or a wildcard for the same one
+func closerRule(a, b string) bool {
+	domainA, wildcardA, _ := parseDomainRule(a)
+	domainB, wildcardB, _ := parseDomainRule(b)
+	if len(domainA) != len(domainB) {
+		return len(domainA) > len(domainB)
+	}
+	return wildcardA && !wildcardB
+}
+
+// Lookup returns the source of rule if the trie holds it
+func (t *layeredTrie) Lookup(rule string) (source string, ok bool) {
+	if source, ok = t.added.Lookup(rule); ok || t.removed[rule] {
+		return source, ok
+	}
+	return t.base.Lookup(rule)
+}
+
+// Len returns the number of rules
+func (t *layeredTrie) Len() int {
+	return t.size
+}
+
+// Rules calls fn for every rule with its source
+func (t *layeredTrie) Rules(fn func(rule, source string)) {
+	t.base.Rules(func(rule, source string) {
+		if !t.removed[rule] {
+			fn(rule, source)
+		}
+	})
+	t.added.Rules(fn)
+}
+
+// Apply returns a trie of exactly the rules in want, mapped to their
+// sources, with how many rules were added and removed. Only the changes
+// are applied, on top of the same compact trie, until there are enough of
+// them that the compact trie is rebuilt. Every rule in want must be valid.
+func (t *layeredTrie) Apply(want map[string]string) (next *layeredTrie, added, removed int) {
+	var gone []string
+	t.Rules(func(rule, source string) {
+		if _, ok := want[rule]; !ok {
+			gone = append(gone, rule)
+		}
+	})
+	changed := make(map[string]string)
+	for rule, source := range want {
+		if current, ok := t.Lookup(rule); !ok || current != source {
+			changed[rule] = source
+			if !ok {
+				added++
+			}
+		}
+	}
+	removed = len(gone)
+	if len(gone) == 0 && len(changed) == 0 {
+		return t, 0, 0
+	}
+
+	next = &layeredTrie{base: t.base, added: newDomainTrie(), removed: make(map[string]bool, len(t.removed))}
+	for rule := range t.removed {
+		next.removed[rule] = true
+	}
+	for _, rule := range gone {
+		if _, ok := t.base.Lookup(rule); ok {
+			next.removed[rule] = true
+		}
+	}
+	for rule := range changed {
+		if _, ok := t.base.Lookup(rule); ok {
+			next.removed[rule] = true
+		}
+	}
+	t.added.Rules(func(rule, source string) {
+		if _, ok := want[rule]; ok {
+			if _, ok := changed[rule]; !ok {
+				next.added.Add(rule, source)
+			}
+		}
+	})
+	for rule, source := range changed {
+		next.added.Add(rule, source)
+	}
+	next.size = t.base.Len() - len(next.removed) + next.added.Len()
+
+	if limit := max(overlayMinRules, t.base.Len()/overlayFraction); next.added.Len()+len(next.removed) > limit {
+		builder := newTrieBuilder(len(want))
+		for rule, source := range want {
+			builder.Add(rule, source)
+		}
+		next = newLayeredTrie(builder.Build())
+	}
+	return next, added, removed
+}
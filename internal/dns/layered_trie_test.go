@@ -0,0 +1,82 @@
+package dns
+
+import (
+	"fmt"
+	"testing"
+)
+
+// matchesRules checks that trie matches names exactly as a domainTrie of
+// the rules in want does
+func matchesRules(t *testing.T, trie *layeredTrie, want map[string]string, names []string) {
+	t.Helper()
+	reference := newDomainTrie()
+	for rule, source := range want {
+		reference.Add(rule, source)
+	}
+	if trie.Len() != reference.Len() {
+		t.Errorf("Len() = %d, want %d", trie.Len(), reference.Len())
+	}
+	for _, name := range names {
+		rule, source, ok := trie.Match(name)
+		wantRule, wantSource, wantOK := reference.Match(name)
+		if rule != wantRule || source != wantSource || ok != wantOK {
+			t.Errorf("Match(%q) = %q, %q, %v; want %q, %q, %v", name, rule, source, ok, wantRule, wantSource, wantOK)
+		}
+	}
+}
+
+func TestLayeredTrieApply(t *testing.T) {
+	want := map[string]string{
+		"example.com":     "list-a",
+		"ads.example.com": "list-a",
+		"tracker.net":     "list-b",
+		"cdn.tracker.net": "list-b",
+		"gone.org":        "list-b",
+	}
+	for i := 0; i < 100; i++ {
+		want[fmt.Sprintf("host%d.example.io", i)] = "list-c"
+	}
+	trie, added, removed := newLayeredTrie(newTrieBuilder(0).Build()).Apply(want)
+	if added != len(want) || removed != 0 {
+		t.Errorf("first Apply added %d and removed %d, want %d and 0", added, removed, len(want))
+	}
+	base := trie.base
+
+	// A few changes go on top of the same compact trie
+	delete(want, "ads.example.com")
+	delete(want, "gone.org")
+	want["tracker.net"] = "list-d"
+	want["*.example.com"] = "list-e"
+	want["new.example.org"] = "list-f"
+	trie, added, removed = trie.Apply(want)
+	if trie.base != base {
+		t.Error("a small change rebuilt the compact trie")
+	}
+	if added != 2 || removed != 2 {
+		t.Errorf("Apply added %d and removed %d, want 2 and 2", added, removed)
+	}
+	names := []string{
+		"example.com", "ads.example.com", "x.ads.example.com", "tracker.net",
+		"cdn.tracker.net", "a.cdn.tracker.net", "gone.org", "new.example.org",
+		"host5.example.io", "host500.example.io",
+	}
+	matchesRules(t, trie, want, names)
+
+	// Rules removed and then restored come back
+	want["ads.example.com"] = "list-a"
+	trie, _, _ = trie.Apply(want)
+	matchesRules(t, trie, want, names)
+	if next, added, removed := trie.Apply(want); next != trie || added != 0 || removed != 0 {
+		t.Error("applying the same rules again made changes")
+	}
+
+	// Enough changes rebuild it
+	for i := 0; i <= overlayMinRules; i++ {
+		want[fmt.Sprintf("bulk%d.example.net", i)] = "list-g"
+	}
+	trie, _, _ = trie.Apply(want)
+	if trie.base == base || trie.added.Len() != 0 || len(trie.removed) != 0 {
+		t.Error("a large change wasn't merged into a new compact trie")
+	}
+	matchesRules(t, trie, want, append(names, "bulk7.example.net"))
+}
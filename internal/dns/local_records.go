@@ -0,0 +1,148 @@
+package dns
+
+import (
+	"fmt"
+	"net"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+const (
+	// defaultLocalRecordTTL is used for local records without a TTL
+	defaultLocalRecordTTL = time.Minute
+	// maxLocalCNAMEChain bounds how many local aliases are followed
+	maxLocalCNAMEChain = 8
+)
+
+// LocalRecords answers queries from static A, AAAA and CNAME records
+// defined in dns.localRecords
+type LocalRecords struct {
+	mu      sync.RWMutex
+	records map[string][]dns.RR // By lowercase name without the trailing dot
+}
+
+// NewLocalRecords creates an empty set of local records
+func NewLocalRecords() *LocalRecords {
+	return &LocalRecords{records: make(map[string][]dns.RR)}
+}
+
+// Update replaces the records. If any record is invalid the current ones
+// are kept and the error is returned.
+func (lr *LocalRecords) Update(configs []config.LocalRecordConfig) error {
+	records := make(map[string][]dns.RR, len(configs))
+	for _, rc := range configs {
+		rr, err := parseLocalRecord(rc)
+		if err != nil {
+			return err
+		}
+		name := strings.ToLower(strings.TrimSuffix(rc.Name, "."))
+		records[name] = append(records[name], rr)
+	}
+
+	// A CNAME can't share its name with other records (RFC 1034 section 3.6.2)
+	for name, rrs := range records {
+		if len(rrs) > 1 {
+			for _, rr := range rrs {
+				if rr.Header().Rrtype == dns.TypeCNAME {
+					return fmt.Errorf("local record %s: CNAME cannot be combined with other records", name)
+				}
+			}
+		}
+	}
+
+	lr.mu.Lock()
+	lr.records = records
+	lr.mu.Unlock()
+	return nil
+}
+
+// parseLocalRecord builds the resource record for one configured record
+func parseLocalRecord(rc config.LocalRecordConfig) (dns.RR, error) {
+	name := strings.TrimSuffix(strings.TrimSpace(rc.Name), ".")
+	if name == "" {
+		return nil, fmt.Errorf("local record name is required")
+	}
+	if _, ok := dns.IsDomainName(name); !ok {
+		return nil, fmt.Errorf("local record %s: invalid name", rc.Name)
+	}
+	ttl := rc.TTL
+	if ttl <= 0 {
+		ttl = defaultLocalRecordTTL
+	}
+	hdr := dns.RR_Header{
+		Name:  dns.Fqdn(strings.ToLower(name)),
+		Class: dns.ClassINET,
+		Ttl:   uint32(ttl / time.Second),
+	}
+
+	switch strings.ToUpper(rc.Type) {
+	case "A":
+		ip := net.ParseIP(rc.Value).To4()
+		if ip == nil {
+			return nil, fmt.Errorf("local record %s: %q is not an IPv4 address", rc.Name, rc.Value)
+		}
+		hdr.Rrtype = dns.TypeA
+		return &dns.A{Hdr: hdr, A: ip}, nil
+	case "AAAA":
+		ip := net.ParseIP(rc.Value)
+		if ip == nil || ip.To4() != nil {
+			return nil, fmt.Errorf("local record %s: %q is not an IPv6 address", rc.Name, rc.Value)
+		}
+		hdr.Rrtype = dns.TypeAAAA
+		return &dns.AAAA{Hdr: hdr, AAAA: ip}, nil
+	case "CNAME":
+		target := strings.TrimSpace(rc.Value)
+		if _, ok := dns.IsDomainName(target); !ok || target == "" {
+			return nil, fmt.Errorf("local record %s: invalid CNAME target %q", rc.Name, rc.Value)
+		}
+		hdr.Rrtype = dns.TypeCNAME
+		return &dns.CNAME{Hdr: hdr, Target: dns.Fqdn(strings.ToLower(target))}, nil
+	}
+	return nil, fmt.Errorf("local record %s: unsupported type %q (use A, AAAA or CNAME)", rc.Name, rc.Type)
+}
+
+// Lookup returns the answer for name and qtype. Local aliases are followed
+// to their targets' records. found reports whether name has local records
+// at all, so callers can answer NODATA for types it doesn't have.
+func (lr *LocalRecords) Lookup(name string, qtype uint16) (answer []dns.RR, found bool) {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+
+	name = strings.ToLower(strings.TrimSuffix(name, "."))
+	if _, found = lr.records[name]; !found {
+		return nil, false
+	}
+
+	for i := 0; i < maxLocalCNAMEChain; i++ {
+		var cname *dns.CNAME
+		for _, rr := range lr.records[name] {
+			switch {
+			case rr.Header().Rrtype == qtype:
+				answer = append(answer, dns.Copy(rr))
+			case rr.Header().Rrtype == dns.TypeCNAME:
+				cname = rr.(*dns.CNAME)
+			}
+		}
+		if cname == nil || qtype == dns.TypeCNAME {
+			return answer, true
+		}
+		answer = append(answer, dns.Copy(cname))
+		name = strings.TrimSuffix(cname.Target, ".")
+	}
+	return answer, true
+}
+
+// Len returns the number of records
+func (lr *LocalRecords) Len() int {
+	lr.mu.RLock()
+	defer lr.mu.RUnlock()
+	n := 0
+	for _, rrs := range lr.records {
+		n += len(rrs)
+	}
+	return n
+}
@@ -0,0 +1,85 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+func TestLocalRecords(t *testing.T) {
+	lr := NewLocalRecords()
+	err := lr.Update([]config.LocalRecordConfig{
+		{Name: "printer.lan", Type: "A", Value: "192.168.1.50", TTL: 5 * time.Minute},
+		{Name: "printer.lan", Type: "AAAA", Value: "fd00::50"},
+		{Name: "print.lan.", Type: "CNAME", Value: "printer.lan"},
+		{Name: "wiki.lan", Type: "cname", Value: "wiki.corp.example.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name   string
+		qtype  uint16
+		found  bool
+		answer []string
+	}{
+		{"Printer.LAN.", dns.TypeA, true, []string{"printer.lan.\t300\tIN\tA\t192.168.1.50"}},
+		{"printer.lan", dns.TypeAAAA, true, []string{"printer.lan.\t60\tIN\tAAAA\tfd00::50"}},
+		{"printer.lan", dns.TypeMX, true, nil},
+		{"print.lan", dns.TypeA, true, []string{"print.lan.\t60\tIN\tCNAME\tprinter.lan.", "printer.lan.\t300\tIN\tA\t192.168.1.50"}},
+		{"print.lan", dns.TypeCNAME, true, []string{"print.lan.\t60\tIN\tCNAME\tprinter.lan."}},
+		{"wiki.lan", dns.TypeA, true, []string{"wiki.lan.\t60\tIN\tCNAME\twiki.corp.example.com."}},
+		{"scanner.lan", dns.TypeA, false, nil},
+	}
+	for _, tt := range tests {
+		answer, found := lr.Lookup(tt.name, tt.qtype)
+		if found != tt.found || len(answer) != len(tt.answer) {
+			t.Errorf("Lookup(%s, %d) = %v, %v; want %v, %v", tt.name, tt.qtype, answer, found, tt.answer, tt.found)
+			continue
+		}
+		for i, rr := range answer {
+			if rr.String() != tt.answer[i] {
+				t.Errorf("Lookup(%s, %d)[%d] = %q, want %q", tt.name, tt.qtype, i, rr.String(), tt.answer[i])
+			}
+		}
+	}
+
+	// Invalid updates keep the current records
+	invalid := [][]config.LocalRecordConfig{
+		{{Name: "nas.lan", Type: "A", Value: "fd00::1"}},
+		{{Name: "nas.lan", Type: "AAAA", Value: "10.0.0.1"}},
+		{{Name: "nas.lan", Type: "TXT", Value: "hello"}},
+		{{Name: "", Type: "A", Value: "10.0.0.1"}},
+		{{Name: "nas.lan", Type: "CNAME", Value: "a.lan"}, {Name: "nas.lan", Type: "A", Value: "10.0.0.1"}},
+	}
+	for _, records := range invalid {
+		if err := lr.Update(records); err == nil {
+			t.Errorf("Update(%+v) succeeded, want error", records)
+		}
+	}
+	if lr.Len() != 4 {
+		t.Errorf("Len() = %d after invalid updates, want 4", lr.Len())
+	}
+}
+
+func TestHandlerLocalRecords(t *testing.T) {
+	h := newTestHandler(t, "printer.lan")
+	if err := h.SetLocalRecords([]config.LocalRecordConfig{{Name: "printer.lan", Type: "A", Value: "192.168.1.50"}}); err != nil {
+		t.Fatal(err)
+	}
+
+	req := new(dns.Msg)
+	req.SetQuestion("Printer.lan.", dns.TypeA)
+	w := &testResponseWriter{}
+	h.ServeDNS(w, req)
+
+	if !w.msg.Authoritative || len(w.msg.Answer) != 1 {
+		t.Fatalf("response = %v, want one authoritative answer", w.msg)
+	}
+	if a, ok := w.msg.Answer[0].(*dns.A); !ok || a.A.String() != "192.168.1.50" || a.Hdr.Name != "Printer.lan." {
+		t.Errorf("answer = %v, want Printer.lan. A 192.168.1.50", w.msg.Answer[0])
+	}
+}
@@ -0,0 +1,54 @@
+package dns
+
+import (
+	"strings"
+)
+
+// SourceLocal marks domains allowed or blocked on this device through the
+// API rather than by the enterprise rules
+const SourceLocal = "local"
+
+// SetLocalRules replaces the domains allowed and blocked on this device.
+// Each covers the domain and its subdomains. Local allows never override
+// security-critical rules or rules of the excluded categories, and the
+// enterprise allowlist wins over local blocks.
+func (b *Blocker) SetLocalRules(allow, block, excludedCategories []string) {
+	excluded := make(map[string]bool, len(excludedCategories))
+	for _, category := range excludedCategories {
+		excluded[category] = true
+	}
+	allowTrie, blockTrie := newDomainTrie(), newDomainTrie()
+	for _, domain := range allow {
+		allowTrie.Add(strings.ToLower(domain), SourceLocal)
+	}
+	for _, domain := range block {
+		blockTrie.Add(strings.ToLower(domain), SourceLocal)
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.localAllow = allowTrie
+	b.localBlock = blockTrie
+	b.localExcluded = excluded
+}
+
+// checkLocal decides on a domain the enterprise allowlist doesn't cover
+// from the local rules. Callers must hold b.mu.
+func (b *Blocker) checkLocal(domain string, count bool) (BlockMatch, bool) {
+	if _, _, ok := b.localAllow.Match(domain); ok {
+		if rule, source, ok := b.matchSecurity(domain); ok {
+			return BlockMatch{Blocked: true, Rule: rule, Source: source, Security: true, OverrodeAllowlist: true}, true
+		}
+		// The allow may be for a parent of a domain in an excluded category
+		if len(b.localExcluded) > 0 {
+			if match := b.checkRules(domain, count); b.localExcluded[match.Category()] {
+				return match, true
+			}
+		}
+		return BlockMatch{}, true
+	}
+	if rule, _, ok := b.localBlock.Match(domain); ok {
+		return BlockMatch{Blocked: true, Rule: rule, Source: SourceLocal}, true
+	}
+	return BlockMatch{}, false
+}
@@ -4,8 +4,8 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
+	"runtime"
 	"strings"
 	"sync"
 	"time"
@@ -13,6 +13,21 @@ import (
 	"github.com/sirupsen/logrus"
 )
 
+// LocalResolvers are the addresses system DNS is set to for DNShield to
+// filter: IPv4 and IPv6 loopback, so dual-stack machines have no IPv6
+// resolver (e.g. one advertised by the router) to fall back to
+var LocalResolvers = []string{"127.0.0.1", "::1"}
+
+// IsLocalResolver reports whether server is one of LocalResolvers
+func IsLocalResolver(server string) bool {
+	for _, local := range LocalResolvers {
+		if server == local {
+			return true
+		}
+	}
+	return false
+}
+
 // Manager handles DNS configuration for the system
 type Manager struct {
 	mu          sync.RWMutex
@@ -71,7 +86,7 @@ func (m *Manager) CaptureOriginalDNS() error {
 	return nil
 }
 
-// EnableDNSFiltering sets all interfaces to use DNShield (127.0.0.1)
+// EnableDNSFiltering sets all interfaces to use DNShield (127.0.0.1 and ::1)
 func (m *Manager) EnableDNSFiltering() error {
 	m.mu.Lock()
 	defer m.mu.Unlock()
@@ -82,11 +97,11 @@ func (m *Manager) EnableDNSFiltering() error {
 		if err != nil {
 			return err
 		}
-		// Only save if DNS is not already set to 127.0.0.1
+		// Only save if DNS is not already set to DNShield
 		needsSave := false
 		for _, iface := range config.Interfaces {
 			for _, dns := range iface.DNSServers {
-				if dns != "127.0.0.1" {
+				if !IsLocalResolver(dns) {
 					needsSave = true
 					break
 				}
@@ -103,16 +118,14 @@ func (m *Manager) EnableDNSFiltering() error {
 		}
 	}
 
-	// Set all interfaces to use 127.0.0.1
+	// Set all interfaces to use DNShield
 	for _, iface := range m.originalDNS.Interfaces {
 		if !iface.IsActive {
 			continue
 		}
 
-		cmd := exec.Command("networksetup", "-setdnsservers", iface.Name, "127.0.0.1")
-		if output, err := cmd.CombinedOutput(); err != nil {
-			logrus.WithError(err).WithField("output", string(output)).
-				Errorf("Failed to set DNS for interface %s", iface.Name)
+		if err := SetDNSServers(iface.Name, LocalResolvers...); err != nil {
+			logrus.WithError(err).Errorf("Failed to set DNS for interface %s", iface.Name)
 			continue
 		}
 
@@ -201,8 +214,7 @@ func (m *Manager) ResumeDNSFiltering() error {
 			continue
 		}
 
-		cmd := exec.Command("networksetup", "-setdnsservers", iface.Name, "127.0.0.1")
-		cmd.CombinedOutput()
+		SetDNSServers(iface.Name, LocalResolvers...)
 	}
 
 	m.isPaused = false
@@ -220,9 +232,7 @@ func (m *Manager) IsPaused() bool {
 // Private helper methods
 
 func (m *Manager) getCurrentDNSConfig() (*DNSConfiguration, error) {
-	// Get all network services
-	cmd := exec.Command("networksetup", "-listallnetworkservices")
-	output, err := cmd.Output()
+	services, err := ListDNSServices()
 	if err != nil {
 		return nil, err
 	}
@@ -233,44 +243,14 @@ func (m *Manager) getCurrentDNSConfig() (*DNSConfiguration, error) {
 		CapturedBy: "DNShield",
 		Interfaces: make(map[string]InterfaceConfig),
 		Metadata: map[string]string{
-			"os":       "darwin",
+			"os":       runtime.GOOS,
+			"backend":  DNSBackend(),
 			"hostname": getHostname(),
 		},
 	}
 
-	lines := strings.Split(string(output), "\n")
-	for i := 1; i < len(lines); i++ {
-		service := strings.TrimSpace(lines[i])
-		if service == "" || strings.HasPrefix(service, "*") {
-			continue
-		}
-
-		// Get interface type
-		typeCmd := exec.Command("networksetup", "-getnetworkserviceenabled", service)
-		typeOutput, _ := typeCmd.Output()
-		isActive := strings.TrimSpace(string(typeOutput)) != "Disabled"
-
-		// Get current DNS
-		dnsCmd := exec.Command("networksetup", "-getdnsservers", service)
-		dnsOutput, _ := dnsCmd.Output()
-		dnsStr := strings.TrimSpace(string(dnsOutput))
-
-		var dnsServers []string
-		isDHCP := false
-
-		if strings.Contains(dnsStr, "There aren't any DNS Servers") {
-			isDHCP = true
-		} else {
-			dnsServers = strings.Split(dnsStr, "\n")
-		}
-
-		config.Interfaces[service] = InterfaceConfig{
-			Name:       service,
-			Type:       detectInterfaceType(service),
-			DNSServers: dnsServers,
-			IsDHCP:     isDHCP,
-			IsActive:   isActive,
-		}
+	for _, service := range services {
+		config.Interfaces[service.Name] = service
 	}
 
 	return config, nil
@@ -324,19 +304,16 @@ func (m *Manager) restoreDNSConfig(config *DNSConfiguration) error {
 			continue
 		}
 
-		var cmd *exec.Cmd
-		if iface.IsDHCP {
-			cmd = exec.Command("networksetup", "-setdnsservers", iface.Name, "Empty")
-		} else if len(iface.DNSServers) > 0 {
-			args := append([]string{"-setdnsservers", iface.Name}, iface.DNSServers...)
-			cmd = exec.Command("networksetup", args...)
-		} else {
-			continue
+		var servers []string
+		if !iface.IsDHCP {
+			if len(iface.DNSServers) == 0 {
+				continue
+			}
+			servers = iface.DNSServers
 		}
 
-		if output, err := cmd.CombinedOutput(); err != nil {
-			logrus.WithError(err).WithField("output", string(output)).
-				Errorf("Failed to restore DNS for interface %s", iface.Name)
+		if err := SetDNSServers(iface.Name, servers...); err != nil {
+			logrus.WithError(err).Errorf("Failed to restore DNS for interface %s", iface.Name)
 			continue
 		}
 
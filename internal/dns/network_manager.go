@@ -5,12 +5,12 @@ import (
 	"encoding/json"
 	"fmt"
 	"os"
-	"os/exec"
 	"path/filepath"
 	"strings"
 	"sync"
 	"time"
 
+	"dnshield/internal/config"
 	"github.com/sirupsen/logrus"
 )
 
@@ -25,6 +25,10 @@ type NetworkManager struct {
 	pauseTimer        *time.Timer
 	changeDetector    *NetworkChangeDetector
 	captureInProgress bool
+	dhcpResolvers     []string // Resolvers offered by DHCP on the current network
+	profiles          []config.NetworkProfileConfig
+	profile           *config.NetworkProfileConfig // Applied to the current network
+	profileBypassed   bool                         // DNS handed back to the network by an "off" profile
 }
 
 // Ensure NetworkManager implements DNSManager interface
@@ -121,15 +125,17 @@ func (nm *NetworkManager) EnableDNSFiltering() error {
 		}
 	}
 	
-	// Set DNS to 127.0.0.1
-	if err := nm.setSystemDNS("127.0.0.1"); err != nil {
+	// Point DNS at DNShield
+	if err := nm.setSystemDNS(LocalResolvers...); err != nil {
 		return err
 	}
 	
 	nm.isActive = true
 	nm.isPaused = false
+	nm.profileBypassed = false
 	
 	logrus.WithField("network", nm.currentNetwork.SSID).Info("DNS filtering enabled")
+	nm.applyNetworkProfile()
 	return nil
 }
 
@@ -142,6 +148,10 @@ func (nm *NetworkManager) DisableDNSFiltering() error {
 		return fmt.Errorf("no current network detected")
 	}
 	
+	if nm.strictNetwork() {
+		return ErrStrictNetwork
+	}
+	
 	config, exists := nm.networkConfigs[nm.currentNetwork.ID]
 	if !exists {
 		return fmt.Errorf("no DNS configuration for current network")
@@ -152,6 +162,7 @@ func (nm *NetworkManager) DisableDNSFiltering() error {
 	}
 	
 	nm.isActive = false
+	nm.profileBypassed = false
 	logrus.WithField("network", nm.currentNetwork.SSID).Info("DNS filtering disabled")
 	return nil
 }
@@ -169,6 +180,10 @@ func (nm *NetworkManager) PauseDNSFiltering(duration time.Duration) error {
 		return fmt.Errorf("no current network detected")
 	}
 	
+	if nm.strictNetwork() {
+		return ErrStrictNetwork
+	}
+	
 	config, exists := nm.networkConfigs[nm.currentNetwork.ID]
 	if !exists {
 		// Try to capture current DNS first
@@ -195,9 +210,11 @@ func (nm *NetworkManager) PauseDNSFiltering(duration time.Duration) error {
 		defer nm.mu.Unlock()
 		
 		if nm.isPaused {
-			nm.setSystemDNS("127.0.0.1")
+			nm.setSystemDNS(LocalResolvers...)
 			nm.isPaused = false
+			nm.profileBypassed = false
 			logrus.Info("DNS filtering auto-resumed")
+			nm.applyNetworkProfile()
 		}
 	})
 	
@@ -223,12 +240,14 @@ func (nm *NetworkManager) ResumeDNSFiltering() error {
 		nm.pauseTimer = nil
 	}
 	
-	if err := nm.setSystemDNS("127.0.0.1"); err != nil {
+	if err := nm.setSystemDNS(LocalResolvers...); err != nil {
 		return err
 	}
 	
 	nm.isPaused = false
+	nm.profileBypassed = false
 	logrus.Info("DNS filtering resumed")
+	nm.applyNetworkProfile()
 	return nil
 }
 
@@ -259,7 +278,7 @@ func (nm *NetworkManager) OnNetworkChange() {
 				// Briefly restore DNS to capture original
 				nm.captureCurrentDNS()
 				// Re-enable filtering
-				nm.setSystemDNS("127.0.0.1")
+				nm.setSystemDNS(LocalResolvers...)
 			}
 		}
 		
@@ -277,6 +296,8 @@ func (nm *NetworkManager) OnNetworkChange() {
 				logrus.Warn("No DNS config for new network, resuming protection")
 			}
 		}
+		
+		nm.applyNetworkProfile()
 	}
 }
 
@@ -289,6 +310,13 @@ func (nm *NetworkManager) detectCurrentNetwork() error {
 	}
 	
 	nm.currentNetwork = identity
+
+	// Refresh DHCP-provided resolvers for the new network
+	resolvers, err := getDHCPResolvers(identity.Interface)
+	if err != nil {
+		logrus.WithError(err).WithField("interface", identity.Interface).Debug("No DHCP resolvers found")
+	}
+	nm.dhcpResolvers = resolvers
 	
 	// Update last seen
 	if config, exists := nm.networkConfigs[identity.ID]; exists {
@@ -313,8 +341,8 @@ func (nm *NetworkManager) captureCurrentDNS() error {
 	
 	// Skip if DNS is already set to DNShield
 	for _, dns := range currentDNS {
-		if dns == "127.0.0.1" {
-			logrus.Debug("Skipping DNS capture - already set to 127.0.0.1")
+		if IsLocalResolver(dns) {
+			logrus.Debug("Skipping DNS capture - already set to DNShield")
 			return nil
 		}
 	}
@@ -340,31 +368,26 @@ func (nm *NetworkManager) captureCurrentDNS() error {
 	return nil
 }
 
-func (nm *NetworkManager) setSystemDNS(dns string) error {
+func (nm *NetworkManager) setSystemDNS(servers ...string) error {
 	if nm.currentNetwork == nil {
 		return fmt.Errorf("no current network")
 	}
 	
-	cmd := exec.Command("networksetup", "-setdnsservers", nm.currentNetwork.Interface, dns)
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to set DNS: %s", output)
+	if err := SetDNSServers(nm.currentNetwork.Interface, servers...); err != nil {
+		return fmt.Errorf("failed to set DNS: %w", err)
 	}
 	
 	return nil
 }
 
 func (nm *NetworkManager) restoreNetworkDNS(config *NetworkDNSConfig) error {
-	var cmd *exec.Cmd
-	
-	if config.IsDHCP || len(config.DNSServers) == 0 {
-		cmd = exec.Command("networksetup", "-setdnsservers", config.NetworkIdentity.Interface, "Empty")
-	} else {
-		args := append([]string{"-setdnsservers", config.NetworkIdentity.Interface}, config.DNSServers...)
-		cmd = exec.Command("networksetup", args...)
+	var servers []string
+	if !config.IsDHCP {
+		servers = config.DNSServers
 	}
 	
-	if output, err := cmd.CombinedOutput(); err != nil {
-		return fmt.Errorf("failed to restore DNS: %s", output)
+	if err := SetDNSServers(config.NetworkIdentity.Interface, servers...); err != nil {
+		return fmt.Errorf("failed to restore DNS: %w", err)
 	}
 	
 	logrus.WithFields(logrus.Fields{
@@ -412,28 +435,9 @@ func (nm *NetworkManager) saveNetworkConfig(config *NetworkDNSConfig) {
 
 func getCurrentNetworkIdentity() (*NetworkIdentity, error) {
 	// Get active interface
-	cmd := exec.Command("route", "-n", "get", "default")
-	output, err := cmd.Output()
+	interfaceName, gateway, err := defaultRoute()
 	if err != nil {
-		return nil, fmt.Errorf("failed to get default route: %w", err)
-	}
-	
-	lines := strings.Split(string(output), "\n")
-	var interfaceName, gateway string
-	
-	for _, line := range lines {
-		if strings.Contains(line, "interface:") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				interfaceName = parts[1]
-			}
-		}
-		if strings.Contains(line, "gateway:") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				gateway = parts[1]
-			}
-		}
+		return nil, err
 	}
 	
 	if interfaceName == "" {
@@ -442,14 +446,14 @@ func getCurrentNetworkIdentity() (*NetworkIdentity, error) {
 	
 	identity := &NetworkIdentity{
 		Interface:     interfaceName,
-		InterfaceType: detectInterfaceType(interfaceName),
+		InterfaceType: interfaceType(interfaceName),
 		GatewayIP:     gateway,
 		LastSeen:      time.Now(),
 	}
 	
 	// Get SSID for WiFi
 	if identity.InterfaceType == "wifi" {
-		if ssid, err := getWiFiSSID(); err == nil {
+		if ssid, err := getWiFiSSID(interfaceName); err == nil {
 			identity.SSID = ssid
 		}
 	}
@@ -470,78 +474,31 @@ func getCurrentNetworkIdentity() (*NetworkIdentity, error) {
 	return identity, nil
 }
 
-func getWiFiSSID() (string, error) {
-	cmd := exec.Command("/System/Library/PrivateFrameworks/Apple80211.framework/Versions/Current/Resources/airport", "-I")
-	output, err := cmd.Output()
-	if err != nil {
-		return "", err
-	}
-	
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, " SSID:") {
-			parts := strings.Split(line, ":")
-			if len(parts) >= 2 {
-				return strings.TrimSpace(parts[1]), nil
-			}
+// parseDHCPResolvers extracts resolvers from `ipconfig getpacket` output, e.g.
+// "domain_name_server (ip_mult): {10.0.0.1, 10.0.0.2}"
+func parseDHCPResolvers(output string) []string {
+	for _, line := range strings.Split(output, "\n") {
+		line = strings.TrimSpace(line)
+		if !strings.HasPrefix(line, "domain_name_server") {
+			continue
 		}
-	}
-	
-	return "", fmt.Errorf("no SSID found")
-}
 
-func getGatewayMAC(ip string) (string, error) {
-	cmd := exec.Command("arp", "-n", ip)
-	output, err := cmd.Output()
-	if err != nil {
-		return "", err
-	}
-	
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.Contains(line, ip) {
-			fields := strings.Fields(line)
-			for _, field := range fields {
-				if strings.Count(field, ":") == 5 {
-					return field, nil
-				}
-			}
+		start := strings.Index(line, "{")
+		end := strings.LastIndex(line, "}")
+		if start == -1 || end <= start {
+			return nil
 		}
-	}
-	
-	return "", fmt.Errorf("MAC not found")
-}
 
-func getCurrentSystemDNS(interfaceName string) ([]string, error) {
-	cmd := exec.Command("networksetup", "-getdnsservers", interfaceName)
-	output, err := cmd.Output()
-	if err != nil {
-		return nil, err
-	}
-	
-	outputStr := strings.TrimSpace(string(output))
-	if strings.Contains(outputStr, "There aren't any DNS Servers") {
-		return []string{}, nil // DHCP
-	}
-	
-	return strings.Split(outputStr, "\n"), nil
-}
-
-func detectVPN() (bool, string) {
-	cmd := exec.Command("ifconfig")
-	output, _ := cmd.Output()
-	
-	lines := strings.Split(string(output), "\n")
-	for _, line := range lines {
-		if strings.HasPrefix(line, "utun") || strings.HasPrefix(line, "ppp") {
-			parts := strings.Split(line, ":")
-			if len(parts) > 0 {
-				return true, strings.TrimSpace(parts[0])
+		var resolvers []string
+		for _, field := range strings.Split(line[start+1:end], ",") {
+			if ip := strings.TrimSpace(field); ip != "" {
+				resolvers = append(resolvers, ip)
 			}
 		}
+		return resolvers
 	}
-	
-	return false, ""
+
+	return nil
 }
 
 func generateNetworkID(identity *NetworkIdentity) string {
@@ -637,4 +594,30 @@ func (nm *NetworkManager) GetNetworkDNS() *NetworkDNSConfig {
 	}
 	
 	return nm.networkConfigs[nm.currentNetwork.ID]
-}
\ No newline at end of file
+}
+
+// GetNetworkResolvers returns the original resolvers for the current network:
+// the statically configured servers captured for it, or the DHCP-provided
+// servers when the network uses DHCP. DNShield's own address is never returned.
+func (nm *NetworkManager) GetNetworkResolvers() []string {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	var candidates []string
+	if nm.currentNetwork != nil {
+		if config, exists := nm.networkConfigs[nm.currentNetwork.ID]; exists && !config.IsDHCP {
+			candidates = config.DNSServers
+		}
+	}
+	if len(candidates) == 0 {
+		candidates = nm.dhcpResolvers
+	}
+
+	var resolvers []string
+	for _, server := range candidates {
+		if server != "" && !IsLocalResolver(server) {
+			resolvers = append(resolvers, server)
+		}
+	}
+	return resolvers
+}
@@ -0,0 +1,97 @@
+package dns
+
+import (
+	"errors"
+	"reflect"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+func TestParseDHCPResolvers(t *testing.T) {
+	tests := []struct {
+		name   string
+		output string
+		want   []string
+	}{
+		{
+			name: "MultipleServers",
+			output: "op = BOOTREPLY\n" +
+				"router (ip_mult): {192.168.1.1}\n" +
+				"domain_name_server (ip_mult): {10.0.0.1, 10.0.0.2}\n" +
+				"domain_name (string): corp.example.com\n",
+			want: []string{"10.0.0.1", "10.0.0.2"},
+		},
+		{
+			name:   "SingleServer",
+			output: "domain_name_server (ip_mult): {192.168.1.1}\n",
+			want:   []string{"192.168.1.1"},
+		},
+		{
+			name:   "NoServers",
+			output: "router (ip_mult): {192.168.1.1}\n",
+			want:   nil,
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := parseDHCPResolvers(tt.output)
+			if !reflect.DeepEqual(got, tt.want) {
+				t.Errorf("parseDHCPResolvers() = %v, want %v", got, tt.want)
+			}
+		})
+	}
+}
+
+func TestMatchNetworkProfile(t *testing.T) {
+	profiles := []config.NetworkProfileConfig{
+		{Name: "corp-lan", GatewayMACs: []string{"A4:03:E7:12:34:56"}, Filtering: config.NetworkFilteringOff},
+		{Name: "home", SSIDs: []string{"HomeNet"}},
+		{Name: "unknown", Filtering: config.NetworkFilteringStrict},
+	}
+
+	tests := []struct {
+		name     string
+		identity *NetworkIdentity
+		want     string
+	}{
+		{"GatewayMAC", &NetworkIdentity{GatewayMAC: "a4:3:e7:12:34:56"}, "corp-lan"},
+		{"SSID", &NetworkIdentity{SSID: "HomeNet", GatewayMAC: "00:11:22:33:44:55"}, "home"},
+		{"SSIDIsCaseSensitive", &NetworkIdentity{SSID: "homenet"}, "unknown"},
+		{"Unknown", &NetworkIdentity{SSID: "Airport WiFi"}, "unknown"},
+		{"Undetected", nil, "unknown"},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			got := matchNetworkProfile(profiles, tt.identity)
+			if got == nil || got.Name != tt.want {
+				t.Errorf("matchNetworkProfile() = %v, want %s", got, tt.want)
+			}
+		})
+	}
+
+	if got := matchNetworkProfile(profiles[:2], &NetworkIdentity{SSID: "Airport WiFi"}); got != nil {
+		t.Errorf("matchNetworkProfile() = %v without a fallback, want nil", got)
+	}
+}
+
+func TestStrictNetworkRefusesPause(t *testing.T) {
+	nm := &NetworkManager{
+		currentNetwork: &NetworkIdentity{ID: "n1", SSID: "Airport WiFi"},
+		networkConfigs: make(map[string]*NetworkDNSConfig),
+		profiles:       []config.NetworkProfileConfig{{Name: "unknown", Filtering: config.NetworkFilteringStrict}},
+	}
+	nm.applyNetworkProfile()
+
+	if err := nm.PauseDNSFiltering(time.Minute); !errors.Is(err, ErrStrictNetwork) {
+		t.Errorf("PauseDNSFiltering() = %v, want ErrStrictNetwork", err)
+	}
+	if err := nm.DisableDNSFiltering(); !errors.Is(err, ErrStrictNetwork) {
+		t.Errorf("DisableDNSFiltering() = %v, want ErrStrictNetwork", err)
+	}
+	if got := nm.CurrentNetworkProfile(); got != "unknown" {
+		t.Errorf("CurrentNetworkProfile() = %q, want unknown", got)
+	}
+}
@@ -0,0 +1,167 @@
+package dns
+
+import (
+	"errors"
+	"strings"
+
+	"dnshield/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// ErrStrictNetwork is returned when pausing or disabling filtering is
+// refused because the current network's profile is strict
+var ErrStrictNetwork = errors.New("filtering can't be paused or disabled on this network")
+
+// SetNetworkProfiles replaces the per-network profiles and applies the one
+// matching the current network. Profiles with an unknown filtering mode
+// are skipped.
+func (nm *NetworkManager) SetNetworkProfiles(profiles []config.NetworkProfileConfig) {
+	nm.mu.Lock()
+	defer nm.mu.Unlock()
+
+	nm.profiles = nil
+	for _, p := range profiles {
+		switch p.Filtering {
+		case "", config.NetworkFilteringOn, config.NetworkFilteringOff, config.NetworkFilteringStrict:
+			nm.profiles = append(nm.profiles, p)
+		default:
+			logrus.WithFields(logrus.Fields{
+				"profile":   p.Name,
+				"filtering": p.Filtering,
+			}).Warn("Skipping network profile with invalid filtering mode")
+		}
+	}
+	nm.applyNetworkProfile()
+}
+
+// CurrentNetworkProfile returns the name of the profile applied to the
+// current network, or "" if none matches
+func (nm *NetworkManager) CurrentNetworkProfile() string {
+	nm.mu.RLock()
+	defer nm.mu.RUnlock()
+
+	if nm.profile == nil {
+		return ""
+	}
+	return nm.profile.Name
+}
+
+// strictNetwork reports whether the current network's profile forbids
+// pausing and disabling. Callers must hold nm.mu.
+func (nm *NetworkManager) strictNetwork() bool {
+	return nm.profile != nil && nm.profile.Filtering == config.NetworkFilteringStrict
+}
+
+// applyNetworkProfile brings the system DNS in line with the current
+// network's profile: handed back to the network on "off" networks, pointed
+// at DNShield elsewhere while filtering is enabled, and never left paused
+// on strict networks. Callers must hold nm.mu.
+func (nm *NetworkManager) applyNetworkProfile() {
+	profile := matchNetworkProfile(nm.profiles, nm.currentNetwork)
+	if profile != nm.profile {
+		fields := logrus.Fields{"network": getNetworkName(nm.currentNetwork)}
+		if profile != nil {
+			fields["profile"] = profile.Name
+			fields["filtering"] = profileFiltering(profile)
+		}
+		logrus.WithFields(fields).Info("Network profile applied")
+	}
+	nm.profile = profile
+
+	if nm.strictNetwork() && nm.isPaused {
+		if nm.pauseTimer != nil {
+			nm.pauseTimer.Stop()
+			nm.pauseTimer = nil
+		}
+		nm.isPaused = false
+		if err := nm.setSystemDNS(LocalResolvers...); err != nil {
+			logrus.WithError(err).Error("Failed to resume filtering on strict network")
+		} else {
+			logrus.Warn("Pause ended: the network's profile is strict")
+		}
+	}
+
+	off := profileFiltering(profile) == config.NetworkFilteringOff
+	switch {
+	case off && nm.isActive && !nm.isPaused && !nm.profileBypassed:
+		if nm.currentNetwork == nil {
+			return
+		}
+		if _, exists := nm.networkConfigs[nm.currentNetwork.ID]; !exists {
+			if err := nm.captureCurrentDNS(); err != nil {
+				logrus.WithError(err).Warn("Failed to capture network DNS, keeping filtering on")
+				return
+			}
+		}
+		config, exists := nm.networkConfigs[nm.currentNetwork.ID]
+		if !exists {
+			logrus.Warn("No DNS configuration for network, keeping filtering on")
+			return
+		}
+		if err := nm.restoreNetworkDNS(config); err != nil {
+			logrus.WithError(err).Error("Failed to hand DNS back to the network")
+			return
+		}
+		nm.profileBypassed = true
+		logrus.WithField("network", getNetworkName(nm.currentNetwork)).Info("Filtering off on this network by profile")
+
+	case !off && nm.profileBypassed:
+		nm.profileBypassed = false
+		if nm.isActive && !nm.isPaused {
+			if err := nm.setSystemDNS(LocalResolvers...); err != nil {
+				logrus.WithError(err).Error("Failed to resume filtering after leaving network")
+			}
+		}
+	}
+}
+
+// matchNetworkProfile returns the first profile listing the network's SSID
+// or gateway MAC, else the first profile listing neither
+func matchNetworkProfile(profiles []config.NetworkProfileConfig, identity *NetworkIdentity) *config.NetworkProfileConfig {
+	var fallback *config.NetworkProfileConfig
+	for i := range profiles {
+		p := &profiles[i]
+		if len(p.SSIDs) == 0 && len(p.GatewayMACs) == 0 {
+			if fallback == nil {
+				fallback = p
+			}
+			continue
+		}
+		if identity == nil {
+			continue
+		}
+		for _, ssid := range p.SSIDs {
+			if identity.SSID != "" && ssid == identity.SSID {
+				return p
+			}
+		}
+		for _, mac := range p.GatewayMACs {
+			if identity.GatewayMAC != "" && normalizeMAC(mac) == normalizeMAC(identity.GatewayMAC) {
+				return p
+			}
+		}
+	}
+	return fallback
+}
+
+// profileFiltering returns the filtering mode of profile, "on" by default
+func profileFiltering(profile *config.NetworkProfileConfig) string {
+	if profile == nil || profile.Filtering == "" {
+		return config.NetworkFilteringOn
+	}
+	return profile.Filtering
+}
+
+// normalizeMAC lowercases a MAC address and pads each octet to two digits,
+// since arp prints e.g. "a4:3:e7:..." for "a4:03:e7:..."
+func normalizeMAC(mac string) string {
+	octets := strings.FieldsFunc(strings.ToLower(strings.TrimSpace(mac)), func(r rune) bool {
+		return r == ':' || r == '-'
+	})
+	for i, octet := range octets {
+		if len(octet) == 1 {
+			octets[i] = "0" + octet
+		}
+	}
+	return strings.Join(octets, ":")
+}
@@ -0,0 +1,59 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SourceNRD is reported for blocks of newly registered domains
+const SourceNRD = "nrd"
+
+// UpdateNRD replaces the newly registered domains to block. Domains under
+// an exempt entry are never blocked as newly registered, though other
+// rules still apply to them.
+func (b *Blocker) UpdateNRD(domains, exempt []string) error {
+	nrd := newDomainTrie()
+	for _, domain := range domains {
+		if err := nrd.Add(strings.ToLower(domain), SourceNRD); err != nil {
+			logrus.WithError(err).WithField("domain", domain).Warn("Skipping invalid NRD domain")
+		}
+	}
+	nrdExempt := newDomainTrie()
+	for _, domain := range exempt {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if err := nrdExempt.Add(domain, SourceInline); err != nil {
+			return fmt.Errorf("invalid NRD exemption %q: %v", domain, err)
+		}
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.nrdDomains = nrd
+	b.nrdExempt = nrdExempt
+	return nil
+}
+
+// GetNRDCount returns the number of newly registered domains blocked
+func (b *Blocker) GetNRDCount() int {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.nrdDomains.Len()
+}
+
+// checkNRD matches domain against the newly registered domains. Callers
+// must hold b.mu.
+func (b *Blocker) checkNRD(domain string) (BlockMatch, bool) {
+	rule, _, ok := b.nrdDomains.Match(domain)
+	if !ok {
+		return BlockMatch{}, false
+	}
+	if _, _, exempt := b.nrdExempt.Match(domain); exempt {
+		return BlockMatch{}, false
+	}
+	return BlockMatch{Blocked: true, Rule: rule, Source: SourceNRD}, true
+}
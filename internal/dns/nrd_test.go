@@ -0,0 +1,25 @@
+package dns
+
+import "testing"
+
+func TestBlockerNRD(t *testing.T) {
+	b := NewBlocker()
+	if err := b.UpdateAllowlist([]string{"partner-new.example"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := b.UpdateNRD([]string{"phish-login.example", "partner-new.example", "vendor-new.example"}, []string{"vendor-new.example"}); err != nil {
+		t.Fatal(err)
+	}
+
+	for domain, want := range map[string]bool{
+		"phish-login.example":     true,
+		"www.phish-login.example": true,
+		"partner-new.example":     false, // Allowlisted
+		"cdn.vendor-new.example":  false, // Exempt from NRD blocking
+	} {
+		match := b.Check(domain)
+		if match.Blocked != want || (want && match.Category() != CategoryNRD) {
+			t.Errorf("Check(%s) = %+v, want blocked %v", domain, match, want)
+		}
+	}
+}
@@ -0,0 +1,164 @@
+package dns
+
+import (
+	"sort"
+	"sync"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// prefetchInterval is how often the most queried names are checked
+	prefetchInterval = 10 * time.Second
+	// prefetchLead is how long before expiring a cached answer is refreshed
+	prefetchLead = 30 * time.Second
+	// prefetchDecay is how often query counts are halved, so names that
+	// stop being popular drop out of the top
+	prefetchDecay = time.Hour
+	// maxPrefetchTracked bounds the names counted at once
+	maxPrefetchTracked = 10000
+)
+
+// prefetchKey is a name and type counted for prefetching
+type prefetchKey struct {
+	domain string
+	qtype  uint16
+}
+
+// prefetcher counts queries and refreshes the cached answers of the most
+// queried names shortly before they expire, so popular names are always
+// answered from the cache
+type prefetcher struct {
+	handler *Handler
+	topN    int
+
+	mu   sync.Mutex
+	hits map[prefetchKey]uint64
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+func newPrefetcher(h *Handler, topN int) *prefetcher {
+	return &prefetcher{
+		handler:    h,
+		topN:       topN,
+		hits:       make(map[prefetchKey]uint64),
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+// record counts a query answered from the cache or an upstream
+func (p *prefetcher) record(domain string, qtype uint16) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	key := prefetchKey{domain, qtype}
+	if _, tracked := p.hits[key]; !tracked && len(p.hits) >= maxPrefetchTracked {
+		return
+	}
+	p.hits[key]++
+}
+
+// top returns the most queried names, most queried first
+func (p *prefetcher) top() []prefetchKey {
+	p.mu.Lock()
+	keys := make([]prefetchKey, 0, len(p.hits))
+	for key := range p.hits {
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		if p.hits[keys[i]] != p.hits[keys[j]] {
+			return p.hits[keys[i]] > p.hits[keys[j]]
+		}
+		return keys[i].domain < keys[j].domain
+	})
+	p.mu.Unlock()
+
+	if len(keys) > p.topN {
+		keys = keys[:p.topN]
+	}
+	return keys
+}
+
+// decay halves every count and forgets names no longer queried
+func (p *prefetcher) decay() {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+
+	for key, hits := range p.hits {
+		if hits /= 2; hits == 0 {
+			delete(p.hits, key)
+		} else {
+			p.hits[key] = hits
+		}
+	}
+}
+
+// refreshDue refreshes the top names whose cached answers expire within
+// prefetchLead and returns how many were refreshed. A round stops at the
+// first failed refresh, as the upstreams are then likely unreachable.
+func (p *prefetcher) refreshDue() int {
+	refreshed := 0
+	for _, key := range p.top() {
+		left, cached := p.handler.cache.ExpiresIn(key.domain, key.qtype)
+		if !cached || left > prefetchLead {
+			continue
+		}
+		if !p.handler.refreshCached(key.domain, key.qtype) {
+			break
+		}
+		refreshed++
+	}
+	return refreshed
+}
+
+// start runs the prefetcher in the background
+func (p *prefetcher) start() {
+	p.wg.Add(1)
+	go func() {
+		defer p.wg.Done()
+		ticker := time.NewTicker(prefetchInterval)
+		defer ticker.Stop()
+		decay := time.NewTicker(prefetchDecay)
+		defer decay.Stop()
+
+		for {
+			select {
+			case <-p.shutdownCh:
+				return
+			case <-decay.C:
+				p.decay()
+			case <-ticker.C:
+				if n := p.refreshDue(); n > 0 {
+					logrus.WithField("count", n).Debug("Prefetched DNS cache entries")
+				}
+			}
+		}
+	}()
+}
+
+// stop stops the prefetcher
+func (p *prefetcher) stop() {
+	close(p.shutdownCh)
+	p.wg.Wait()
+}
+
+// refreshCached queries the upstreams for domain and caches the answer as
+// ServeDNS would. It reports whether an upstream answered.
+func (h *Handler) refreshCached(domain string, qtype uint16) bool {
+	req := new(dns.Msg)
+	req.SetQuestion(dns.Fqdn(domain), qtype)
+	resp, _, _ := h.forwardCoalesced(req)
+	if resp == nil {
+		return false
+	}
+	// Answers that alias a blocked domain were never cached
+	if h.cnameUncloaking && h.uncloak(h.blocker, domain, resp).Blocked {
+		return true
+	}
+	h.cacheResponse(domain, qtype, resp)
+	return true
+}
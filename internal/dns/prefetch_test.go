@@ -0,0 +1,56 @@
+package dns
+
+import (
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+func TestPrefetchRefreshesTopNames(t *testing.T) {
+	var exchanges atomic.Int32
+	upstream := startTestUpstream(t, func(q dns.Question) []dns.RR {
+		exchanges.Add(1)
+		a, _ := dns.NewRR(q.Name + " 60 IN A 192.0.2.50")
+		return []dns.RR{a}
+	})
+	// Every cached answer expires within the prefetch lead
+	h := NewHandler(NewBlocker(), &config.DNSConfig{
+		Upstreams: []string{upstream}, CacheSize: 100, CacheTTL: 20 * time.Second,
+		Prefetch: true, PrefetchTopN: 1,
+	}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+
+	query := func(name string) {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeA)
+		h.ServeDNS(&testResponseWriter{}, req)
+	}
+	for i := 0; i < 3; i++ {
+		query("popular.example.com.")
+	}
+	query("rare.example.com.")
+	if n := exchanges.Load(); n != 2 {
+		t.Fatalf("upstream saw %d queries, want 2", n)
+	}
+
+	// Only the most queried name is refreshed
+	if n := h.prefetch.refreshDue(); n != 1 {
+		t.Fatalf("refreshDue() = %d, want 1", n)
+	}
+	if n := exchanges.Load(); n != 3 {
+		t.Errorf("upstream saw %d queries after prefetching, want 3", n)
+	}
+	if left, ok := h.cache.ExpiresIn("popular.example.com", dns.TypeA); !ok || left < 19*time.Second {
+		t.Errorf("popular entry expires in %v, want a fresh entry", left)
+	}
+
+	// Counts decay until names are forgotten
+	h.prefetch.decay()
+	h.prefetch.decay()
+	if top := h.prefetch.top(); len(top) != 0 {
+		t.Errorf("top() after decay = %v, want none", top)
+	}
+}
@@ -0,0 +1,75 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+)
+
+// RecentBlocks remembers why domains were recently blocked, so the block
+// page can explain blocks the blocklist alone doesn't know about, such as
+// DGA and homograph detections or a client group's rules
+type RecentBlocks struct {
+	mu         sync.RWMutex
+	entries    map[string]recentBlock
+	ttl        time.Duration
+	maxEntries int
+}
+
+type recentBlock struct {
+	match   BlockMatch
+	expires time.Time
+}
+
+// NewRecentBlocks creates a table keeping each block for ttl
+func NewRecentBlocks(ttl time.Duration, maxEntries int) *RecentBlocks {
+	return &RecentBlocks{
+		entries:    make(map[string]recentBlock),
+		ttl:        ttl,
+		maxEntries: maxEntries,
+	}
+}
+
+// Record remembers that domain was blocked by match
+func (r *RecentBlocks) Record(domain string, match BlockMatch) {
+	now := time.Now()
+	domain = strings.ToLower(domain)
+
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	if _, ok := r.entries[domain]; !ok && len(r.entries) >= r.maxEntries && !r.evict(now) {
+		return
+	}
+	r.entries[domain] = recentBlock{match: match, expires: now.Add(r.ttl)}
+}
+
+// Lookup returns the most recent block of domain that hasn't expired
+func (r *RecentBlocks) Lookup(domain string) (BlockMatch, bool) {
+	r.mu.RLock()
+	defer r.mu.RUnlock()
+
+	entry, ok := r.entries[strings.ToLower(domain)]
+	if !ok || time.Now().After(entry.expires) {
+		return BlockMatch{}, false
+	}
+	return entry.match, true
+}
+
+// evict makes room for a new entry, preferring expired ones. Callers must
+// hold r.mu. It returns false if nothing could be removed.
+func (r *RecentBlocks) evict(now time.Time) bool {
+	for domain, entry := range r.entries {
+		if now.After(entry.expires) {
+			delete(r.entries, domain)
+		}
+	}
+	if len(r.entries) < r.maxEntries {
+		return true
+	}
+	for domain := range r.entries {
+		delete(r.entries, domain)
+		return true
+	}
+	return false
+}
@@ -0,0 +1,55 @@
+package dns
+
+import (
+	"testing"
+	"time"
+)
+
+func TestBlockMatchReason(t *testing.T) {
+	tests := []struct {
+		match BlockMatch
+		want  string
+	}{
+		{BlockMatch{}, ""},
+		{BlockMatch{Blocked: true, Rule: "ads.example.com", Source: SourceInline}, "rule ads.example.com"},
+		{BlockMatch{Blocked: true, Rule: "evil.example", Source: "threat-intel:abuse.ch", Security: true}, "threat-intel feed abuse.ch"},
+		{BlockMatch{Blocked: true, Rule: "evil.example", Source: "https://lists.example/malware.txt", Security: true}, "security list lists.example"},
+		{BlockMatch{Blocked: true, Rule: "ads.example", Source: "https://lists.example/ads.txt", ListCategory: "ads"}, "ads list lists.example"},
+		{BlockMatch{Blocked: true, Rule: "misc.example", Source: "https://lists.example/misc.txt"}, "list lists.example"},
+		{BlockMatch{Blocked: true, Rule: "paypal.com", Source: SourceHomograph}, "lookalike of paypal.com"},
+		{BlockMatch{Blocked: true, Rule: "*", Source: SourceAllowOnly}, "allow-only mode"},
+		{BlockMatch{Blocked: true, Rule: SourceSchedulePrefix + "exams", Source: SourceAllowOnly}, "allow-only schedule exams"},
+		{BlockMatch{Blocked: true, Rule: "game.example", Source: SourceSchedulePrefix + "school"}, "schedule school"},
+		{BlockMatch{Blocked: true, Rule: "tracker.example", Source: SourceInline, CNAME: "tracker.example"}, "rule tracker.example (via alias tracker.example)"},
+	}
+	for _, tt := range tests {
+		if got := tt.match.Reason(); got != tt.want {
+			t.Errorf("%+v.Reason() = %q, want %q", tt.match, got, tt.want)
+		}
+	}
+}
+
+func TestRecentBlocks(t *testing.T) {
+	recent := NewRecentBlocks(time.Hour, 2)
+	dga := BlockMatch{Blocked: true, Rule: "xkqjzvbw", Source: SourceDGA}
+	recent.Record("XKQJZVBW.example.com", dga)
+
+	if got, ok := recent.Lookup("xkqjzvbw.example.com"); !ok || got != dga {
+		t.Errorf("Lookup() = %+v, %v, want %+v", got, ok, dga)
+	}
+	if _, ok := recent.Lookup("other.example.com"); ok {
+		t.Error("Lookup() found a domain that was never blocked")
+	}
+
+	recent.Record("a.example.com", dga)
+	recent.Record("b.example.com", dga)
+	if len(recent.entries) != 2 {
+		t.Errorf("table holds %d entries, want at most 2", len(recent.entries))
+	}
+
+	expired := NewRecentBlocks(-time.Second, 10)
+	expired.Record("old.example.com", dga)
+	if _, ok := expired.Lookup("old.example.com"); ok {
+		t.Error("Lookup() returned an expired block")
+	}
+}
@@ -0,0 +1,95 @@
+package dns
+
+import (
+	"fmt"
+	"regexp"
+	"regexp/syntax"
+	"strings"
+	"sync/atomic"
+)
+
+const (
+	// maxRegexRules caps the regex rules loaded at once; each one is tried
+	// against every query that passes its pre-filter
+	maxRegexRules = 1000
+	// maxRegexLength caps the length of a single pattern
+	maxRegexLength = 1024
+)
+
+// regexRule is a compiled regex block rule
+type regexRule struct {
+	pattern string
+	re      *regexp.Regexp
+	// literal must appear in any name the pattern matches, so names
+	// without it skip the regex; empty when no such substring is known
+	literal string
+	hits    atomic.Uint64
+}
+
+// RegexRuleHits reports how many queries a regex rule has blocked
+type RegexRuleHits struct {
+	Pattern string
+	Hits    uint64
+}
+
+// compileRegexRule compiles a pattern matched against lowercase names
+// without the trailing dot
+func compileRegexRule(pattern string) (*regexRule, error) {
+	if len(pattern) > maxRegexLength {
+		return nil, fmt.Errorf("regex exceeds maximum length of %d characters", maxRegexLength)
+	}
+	re, err := regexp.Compile(pattern)
+	if err != nil {
+		return nil, err
+	}
+	parsed, err := syntax.Parse(pattern, syntax.Perl)
+	if err != nil {
+		return nil, err
+	}
+	return &regexRule{
+		pattern: pattern,
+		re:      re,
+		literal: strings.ToLower(requiredLiteral(parsed.Simplify())),
+	}, nil
+}
+
+// match reports whether the rule matches domain and counts the hit
+func (r *regexRule) match(domain string) bool {
+	if !r.matches(domain) {
+		return false
+	}
+	r.hits.Add(1)
+	return true
+}
+
+// matches is match without counting a hit
+func (r *regexRule) matches(domain string) bool {
+	if r.literal != "" && !strings.Contains(domain, r.literal) {
+		return false
+	}
+	return r.re.MatchString(domain)
+}
+
+// requiredLiteral returns the longest literal string every match of re must
+// contain, or "" if there is none
+func requiredLiteral(re *syntax.Regexp) string {
+	switch re.Op {
+	case syntax.OpLiteral:
+		return string(re.Rune)
+	case syntax.OpCapture, syntax.OpPlus:
+		return requiredLiteral(re.Sub[0])
+	case syntax.OpRepeat:
+		if re.Min >= 1 {
+			return requiredLiteral(re.Sub[0])
+		}
+	case syntax.OpConcat:
+		longest := ""
+		for _, sub := range re.Sub {
+			if lit := requiredLiteral(sub); len(lit) > len(longest) {
+				longest = lit
+			}
+		}
+		return longest
+	}
+	return ""
+}
@@ -0,0 +1,78 @@
+package dns
+
+import (
+	"regexp/syntax"
+	"testing"
+)
+
+func TestRequiredLiteral(t *testing.T) {
+	tests := []struct {
+		pattern string
+		want    string
+	}{
+		{`^ad[0-9]+\.example\.com$`, ".example.com"},
+		{`^track[0-9]+\.`, "track"},
+		{`(?i)^ADS\.`, "ads."},
+		{`(tracker)+`, "tracker"},
+		{`^(ads|track)\.`, "."},
+		{`^[a-z]+$`, ""},
+		{`(foo)?bar`, "bar"},
+	}
+	for _, tt := range tests {
+		rule, err := compileRegexRule(tt.pattern)
+		if err != nil {
+			t.Fatalf("compileRegexRule(%q) error = %v", tt.pattern, err)
+		}
+		if rule.literal != tt.want {
+			t.Errorf("literal for %q = %q, want %q", tt.pattern, rule.literal, tt.want)
+		}
+	}
+
+	// Optional parts never become the pre-filter
+	parsed, _ := syntax.Parse(`(metrics)?\.io`, syntax.Perl)
+	if lit := requiredLiteral(parsed.Simplify()); lit != ".io" {
+		t.Errorf("requiredLiteral() = %q, want .io", lit)
+	}
+}
+
+func TestBlockerRegexRules(t *testing.T) {
+	blocker := NewBlocker()
+	if err := blocker.UpdateDomains([]string{"ads.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := blocker.UpdateAllowlist([]string{"ad1.safe.org"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := blocker.UpdateRegexRules([]string{`^ad[0-9]+\.`, `[`, `^ad[0-9]+\.`}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		domain string
+		rule   string
+	}{
+		{"ad1.tracker.net", `^ad[0-9]+\.`},
+		{"ad42.cdn.io", `^ad[0-9]+\.`},
+		{"ads.example.com", "ads.example.com"}, // Domain rules first
+		{"bad1.tracker.net", ""},
+		{"ad1.safe.org", ""}, // Allowlist wins
+	}
+	for _, tt := range tests {
+		match := blocker.Check(tt.domain)
+		if match.Blocked != (tt.rule != "") || match.Rule != tt.rule {
+			t.Errorf("Check(%q) = %+v, want rule %q", tt.domain, match, tt.rule)
+		}
+		if tt.rule != "" && tt.rule != "ads.example.com" && match.Source != SourceRegex {
+			t.Errorf("Check(%q).Source = %q, want %q", tt.domain, match.Source, SourceRegex)
+		}
+	}
+
+	hits := blocker.RegexRuleHits()
+	if len(hits) != 1 || hits[0].Hits != 2 {
+		t.Errorf("RegexRuleHits() = %+v, want one rule with 2 hits", hits)
+	}
+
+	if err := blocker.UpdateRegexRules(make([]string, maxRegexRules+1)); err == nil {
+		t.Error("UpdateRegexRules() accepted too many rules")
+	}
+}
@@ -0,0 +1,45 @@
+package dns
+
+import (
+	"errors"
+	"net"
+
+	"github.com/miekg/dns"
+)
+
+// resolveClient is the client address queries from Resolve come from: the
+// device itself, over TCP so answers aren't truncated
+var resolveClient = &net.TCPAddr{IP: net.IPv4(127, 0, 0, 1)}
+
+// Resolve answers a query for name and qtype as ServeDNS would for one
+// from the device itself, and reports what happened to it. The query is
+// counted and reported to the callbacks like any other. The response is
+// nil if none was written.
+func (h *Handler) Resolve(name string, qtype uint16) (*dns.Msg, QueryEvent) {
+	r := new(dns.Msg)
+	r.SetQuestion(dns.Fqdn(name), qtype)
+	r.RecursionDesired = true
+
+	w := &recordingWriter{}
+	event := h.serve(w, r)
+	return w.msg, event
+}
+
+// recordingWriter keeps the response written to it
+type recordingWriter struct {
+	msg *dns.Msg
+}
+
+func (w *recordingWriter) LocalAddr() net.Addr  { return resolveClient }
+func (w *recordingWriter) RemoteAddr() net.Addr { return resolveClient }
+func (w *recordingWriter) WriteMsg(m *dns.Msg) error {
+	w.msg = m
+	return nil
+}
+func (w *recordingWriter) Write([]byte) (int, error) {
+	return 0, errors.New("raw writes are not supported")
+}
+func (w *recordingWriter) Close() error        { return nil }
+func (w *recordingWriter) TsigStatus() error   { return nil }
+func (w *recordingWriter) TsigTimersOnly(bool) {}
+func (w *recordingWriter) Hijack()             {}
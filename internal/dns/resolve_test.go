@@ -0,0 +1,52 @@
+package dns
+
+import (
+	"net"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+func TestHandlerResolve(t *testing.T) {
+	upstream := startTestUpstream(t, func(q dns.Question) []dns.RR {
+		a, _ := dns.NewRR(q.Name + " 60 IN A 192.0.2.30")
+		return []dns.RR{a}
+	})
+
+	blocker := NewBlocker()
+	if err := blocker.UpdateDomains([]string{"ads.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	h := NewHandler(blocker, &config.DNSConfig{Upstreams: []string{upstream}, CacheSize: 100, CacheTTL: time.Minute}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+
+	var reported []QueryEvent
+	h.SetQueryCallback(func(e QueryEvent) { reported = append(reported, e) })
+
+	resp, event := h.Resolve("www.example.com", dns.TypeA)
+	if event.Action != QueryActionAllowed || event.Upstream != upstream || event.ClientIP != "127.0.0.1" {
+		t.Errorf("first lookup = %+v", event)
+	}
+	if resp == nil || len(resp.Answer) != 1 || !resp.Answer[0].(*dns.A).A.Equal(net.ParseIP("192.0.2.30")) {
+		t.Fatalf("first lookup answered %v", resp)
+	}
+
+	if _, event := h.Resolve("www.example.com.", dns.TypeA); event.Action != QueryActionCached || event.Upstream != "" {
+		t.Errorf("second lookup = %+v, want it cached", event)
+	}
+
+	resp, event = h.Resolve("ads.example.com", dns.TypeA)
+	if event.Action != QueryActionBlocked || event.Rule != "ads.example.com" {
+		t.Errorf("blocked lookup = %+v", event)
+	}
+	if resp == nil || len(resp.Answer) != 1 || !resp.Answer[0].(*dns.A).A.Equal(h.blockIP) {
+		t.Errorf("blocked lookup answered %v", resp)
+	}
+
+	if len(reported) != 3 {
+		t.Errorf("query callback saw %d lookups, want 3", len(reported))
+	}
+}
@@ -0,0 +1,94 @@
+package dns
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/miekg/dns"
+)
+
+// safeSearchHosts maps search and video hosts to the names that serve
+// them with SafeSearch or YouTube Restricted Mode enforced
+var safeSearchHosts = map[string]string{
+	"www.bing.com":             "strict.bing.com",
+	"bing.com":                 "strict.bing.com",
+	"duckduckgo.com":           "safe.duckduckgo.com",
+	"www.duckduckgo.com":       "safe.duckduckgo.com",
+	"start.duckduckgo.com":     "safe.duckduckgo.com",
+	"www.youtube.com":          "restrict.youtube.com",
+	"m.youtube.com":            "restrict.youtube.com",
+	"youtube.com":              "restrict.youtube.com",
+	"youtubei.googleapis.com":  "restrict.youtube.com",
+	"youtube.googleapis.com":   "restrict.youtube.com",
+	"www.youtube-nocookie.com": "restrict.youtube.com",
+	"music.youtube.com":        "restrict.youtube.com",
+}
+
+// googleSearchHost matches Google search on any country domain
+var googleSearchHost = regexp.MustCompile(`^(www\.)?google\.(com|[a-z]{2}|co\.[a-z]{2}|com\.[a-z]{2})$`)
+
+const googleSafeSearchHost = "forcesafesearch.google.com"
+
+// SetSafeSearch turns SafeSearch enforcement on or off
+func (b *Blocker) SetSafeSearch(enabled bool) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	b.safeSearch = enabled
+}
+
+// SafeSearchEnabled reports whether SafeSearch is enforced
+func (b *Blocker) SafeSearchEnabled() bool {
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+	return b.safeSearch
+}
+
+// SafeSearchTarget returns the enforced-SafeSearch name domain should
+// resolve to, if SafeSearch is on and domain is a covered search host
+func (b *Blocker) SafeSearchTarget(domain string) (string, bool) {
+	if !b.SafeSearchEnabled() {
+		return "", false
+	}
+	domain = strings.ToLower(domain)
+	if target := safeSearchHosts[domain]; target != "" {
+		return target, true
+	}
+	if googleSearchHost.MatchString(domain) {
+		return googleSafeSearchHost, true
+	}
+	return "", false
+}
+
+// serveSafeSearch answers a query for a search host with a CNAME to its
+// SafeSearch name and that name's records. It returns false if the target
+// couldn't be resolved, leaving the query to be handled normally.
+func (h *Handler) serveSafeSearch(w dns.ResponseWriter, r *dns.Msg, m *dns.Msg, question dns.Question, target string) bool {
+	m.Answer = append(m.Answer, &dns.CNAME{
+		Hdr:    dns.RR_Header{Name: question.Name, Rrtype: dns.TypeCNAME, Class: dns.ClassINET, Ttl: 300},
+		Target: dns.Fqdn(target),
+	})
+	if question.Qtype == dns.TypeCNAME {
+		w.WriteMsg(m)
+		return true
+	}
+
+	if cached := h.cache.Get(target, question.Qtype); cached != nil {
+		m.Answer = append(m.Answer, cached.Answer...)
+		w.WriteMsg(m)
+		return true
+	}
+
+	query := r.Copy()
+	query.Question[0].Name = dns.Fqdn(target)
+	resp, _, _ := h.forwardToUpstream(query)
+	if resp == nil || resp.Rcode != dns.RcodeSuccess {
+		m.Answer = nil
+		return false
+	}
+	if len(resp.Answer) > 0 {
+		h.cache.Set(target, question.Qtype, resp.Answer)
+	}
+	m.Answer = append(m.Answer, resp.Answer...)
+	w.WriteMsg(m)
+	return true
+}
@@ -0,0 +1,80 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+func TestSafeSearchTarget(t *testing.T) {
+	b := NewBlocker()
+	if _, ok := b.SafeSearchTarget("www.google.com"); ok {
+		t.Fatal("SafeSearchTarget() rewrote a name before SafeSearch was enabled")
+	}
+
+	b.SetSafeSearch(true)
+	for domain, want := range map[string]string{
+		"www.google.com":     "forcesafesearch.google.com",
+		"google.co.uk":       "forcesafesearch.google.com",
+		"WWW.Google.DE":      "forcesafesearch.google.com",
+		"www.bing.com":       "strict.bing.com",
+		"duckduckgo.com":     "safe.duckduckgo.com",
+		"m.youtube.com":      "restrict.youtube.com",
+		"mail.google.com":    "",
+		"google.example.com": "",
+		"strict.bing.com":    "",
+	} {
+		if got, _ := b.SafeSearchTarget(domain); got != want {
+			t.Errorf("SafeSearchTarget(%s) = %q, want %q", domain, got, want)
+		}
+	}
+}
+
+func TestHandlerSafeSearch(t *testing.T) {
+	upstream := startTestUpstream(t, func(q dns.Question) []dns.RR {
+		a, _ := dns.NewRR(q.Name + " 60 IN A 192.0.2.20")
+		return []dns.RR{a}
+	})
+
+	blocker := NewBlocker()
+	blocker.SetSafeSearch(true)
+	h := NewHandler(blocker, &config.DNSConfig{Upstreams: []string{upstream}, CacheSize: 100, CacheTTL: time.Minute}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+
+	var events []QueryEvent
+	h.SetQueryCallback(func(e QueryEvent) { events = append(events, e) })
+
+	for i := 0; i < 2; i++ { // The second answer comes from the cache
+		req := new(dns.Msg)
+		req.SetQuestion("www.YouTube.com.", dns.TypeA)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+
+		m := w.msg
+		if m.Rcode != dns.RcodeSuccess || len(m.Answer) != 2 {
+			t.Fatalf("answer = %v, want a CNAME and an A record", m)
+		}
+		cname, ok := m.Answer[0].(*dns.CNAME)
+		if !ok || cname.Hdr.Name != "www.YouTube.com." || cname.Target != "restrict.youtube.com." {
+			t.Errorf("first record = %v, want a CNAME to restrict.youtube.com", m.Answer[0])
+		}
+		if a, ok := m.Answer[1].(*dns.A); !ok || a.Hdr.Name != "restrict.youtube.com." {
+			t.Errorf("second record = %v, want restrict.youtube.com's address", m.Answer[1])
+		}
+	}
+	if len(events) != 2 || events[0].Action != QueryActionSafeSearch {
+		t.Errorf("events = %+v, want two safesearch queries", events)
+	}
+
+	// Other names are forwarded untouched
+	blocker.SetSafeSearch(false)
+	req := new(dns.Msg)
+	req.SetQuestion("www.youtube.com.", dns.TypeA)
+	w := &testResponseWriter{}
+	h.ServeDNS(w, req)
+	if len(w.msg.Answer) != 1 || w.msg.Answer[0].Header().Rrtype != dns.TypeA {
+		t.Errorf("answer with SafeSearch off = %v, want the upstream's A record", w.msg)
+	}
+}
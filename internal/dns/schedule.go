@@ -0,0 +1,318 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// SourceSchedulePrefix prefixes BlockMatch.Source for blocks by a schedule
+const SourceSchedulePrefix = "schedule:"
+
+// maxScheduleWait bounds how long the scheduler sleeps, so clock changes
+// and sleep/wake are noticed
+const maxScheduleWait = 15 * time.Minute
+
+var weekdays = map[string]time.Weekday{
+	"sun": time.Sunday, "mon": time.Monday, "tue": time.Tuesday, "wed": time.Wednesday,
+	"thu": time.Thursday, "fri": time.Friday, "sat": time.Saturday,
+}
+
+// Window is a daily time window on some days of the week. A window whose
+// end is before its start runs overnight into the next day.
+type Window struct {
+	location *time.Location
+	days     [7]bool
+	start    time.Duration // Since midnight
+	end      time.Duration
+}
+
+// Schedule applies extra rules during a daily time window, e.g. blocking
+// social media 09:00–17:00 on weekdays
+type Schedule struct {
+	Window
+	Name      string
+	AllowOnly bool // Block everything except the allowlist while active
+
+	blocked *domainTrie
+
+	active bool // Guarded by the Blocker's mutex
+}
+
+// ScheduleStatus reports whether a schedule is active and when that changes
+type ScheduleStatus struct {
+	Name           string
+	Active         bool
+	AllowOnly      bool
+	NextTransition time.Time
+}
+
+// NewSchedule validates and compiles a schedule from the rules file
+func NewSchedule(cfg config.ScheduleConfig) (*Schedule, error) {
+	if cfg.Name == "" {
+		return nil, fmt.Errorf("schedule name is required")
+	}
+	window, err := NewWindow(cfg.Timezone, cfg.Days, cfg.Start, cfg.End)
+	if err != nil {
+		return nil, fmt.Errorf("schedule %s: %v", cfg.Name, err)
+	}
+	s := &Schedule{
+		Window:    window,
+		Name:      cfg.Name,
+		AllowOnly: cfg.AllowOnlyMode,
+		blocked:   newDomainTrie(),
+	}
+
+	for _, domain := range cfg.BlockDomains {
+		domain = strings.ToLower(strings.TrimSpace(domain))
+		if domain == "" {
+			continue
+		}
+		if err := s.blocked.Add(domain, SourceSchedulePrefix+cfg.Name); err != nil {
+			logrus.WithError(err).WithField("schedule", cfg.Name).Warn("Skipping invalid scheduled domain")
+		}
+	}
+	if s.blocked.Len() == 0 && !s.AllowOnly {
+		return nil, fmt.Errorf("schedule %s: needs block_domains or allow_only_mode", cfg.Name)
+	}
+
+	return s, nil
+}
+
+// NewWindow validates a window from "HH:MM" start and end times, days
+// (sun, mon, ... sat; every day if empty) and an IANA timezone (the
+// device's local time if empty)
+func NewWindow(timezone string, days []string, start, end string) (Window, error) {
+	w := Window{location: time.Local}
+	if timezone != "" {
+		loc, err := time.LoadLocation(timezone)
+		if err != nil {
+			return Window{}, fmt.Errorf("invalid timezone %q", timezone)
+		}
+		w.location = loc
+	}
+
+	if len(days) == 0 {
+		for i := range w.days {
+			w.days[i] = true
+		}
+	}
+	for _, day := range days {
+		d, ok := weekdays[strings.ToLower(day)]
+		if !ok {
+			return Window{}, fmt.Errorf("invalid day %q (use sun, mon, ... sat)", day)
+		}
+		w.days[d] = true
+	}
+
+	var err error
+	if w.start, err = parseClock(start); err != nil {
+		return Window{}, fmt.Errorf("invalid start: %v", err)
+	}
+	if w.end, err = parseClock(end); err != nil {
+		return Window{}, fmt.Errorf("invalid end: %v", err)
+	}
+	if w.start == w.end {
+		return Window{}, fmt.Errorf("start and end must differ")
+	}
+	return w, nil
+}
+
+// parseClock parses "HH:MM" into the time since midnight
+func parseClock(value string) (time.Duration, error) {
+	t, err := time.Parse("15:04", value)
+	if err != nil {
+		return 0, fmt.Errorf("%q is not HH:MM", value)
+	}
+	return time.Duration(t.Hour())*time.Hour + time.Duration(t.Minute())*time.Minute, nil
+}
+
+// Active reports whether the window includes t
+func (w Window) Active(t time.Time) bool {
+	local := t.In(w.location)
+	y, m, d := local.Date()
+	sinceMidnight := local.Sub(time.Date(y, m, d, 0, 0, 0, 0, w.location))
+
+	if w.start < w.end {
+		return w.days[local.Weekday()] && sinceMidnight >= w.start && sinceMidnight < w.end
+	}
+	// Overnight: started today, or started yesterday and not yet ended
+	yesterday := (local.Weekday() + 6) % 7
+	return (w.days[local.Weekday()] && sinceMidnight >= w.start) ||
+		(w.days[yesterday] && sinceMidnight < w.end)
+}
+
+// NextTransition returns the next time after t that the window opens or
+// closes
+func (w Window) NextTransition(t time.Time) time.Time {
+	current := w.Active(t)
+	local := t.In(w.location)
+	y, m, d := local.Date()
+
+	for i := 0; i <= 8; i++ {
+		// Check the day's boundaries in order
+		first, second := w.start, w.end
+		if w.end < w.start {
+			first, second = w.end, w.start
+		}
+		for _, offset := range []time.Duration{first, second} {
+			candidate := time.Date(y, m, d+i, int(offset/time.Hour), int(offset%time.Hour/time.Minute), 0, 0, w.location)
+			if candidate.After(t) && w.Active(candidate) != current {
+				return candidate
+			}
+		}
+	}
+	return time.Time{}
+}
+
+// UpdateSchedules replaces the scheduled rules. The Scheduler turns them
+// on and off from then on.
+func (b *Blocker) UpdateSchedules(schedules []*Schedule) {
+	now := time.Now()
+	for _, s := range schedules {
+		s.active = s.Active(now)
+	}
+
+	b.mu.Lock()
+	b.schedules = schedules
+	b.mu.Unlock()
+
+	select {
+	case b.schedulesChanged <- struct{}{}:
+	default:
+	}
+}
+
+// applySchedules activates the schedules whose window includes now and
+// returns their status and the earliest next transition
+func (b *Blocker) applySchedules(now time.Time) ([]ScheduleStatus, time.Time) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var next time.Time
+	statuses := make([]ScheduleStatus, 0, len(b.schedules))
+	for _, s := range b.schedules {
+		s.active = s.Active(now)
+		transition := s.NextTransition(now)
+		if !transition.IsZero() && (next.IsZero() || transition.Before(next)) {
+			next = transition
+		}
+		statuses = append(statuses, ScheduleStatus{
+			Name:           s.Name,
+			Active:         s.active,
+			AllowOnly:      s.AllowOnly,
+			NextTransition: transition,
+		})
+	}
+	return statuses, next
+}
+
+// checkSchedules matches domain against the active schedules. Callers must
+// hold b.mu.
+func (b *Blocker) checkSchedules(domain string) (BlockMatch, bool) {
+	for _, s := range b.schedules {
+		if !s.active {
+			continue
+		}
+		if rule, source, ok := s.blocked.Match(domain); ok {
+			return BlockMatch{Blocked: true, Rule: rule, Source: source}, true
+		}
+	}
+	return BlockMatch{}, false
+}
+
+// scheduledAllowOnly returns the active schedule enforcing allow-only mode,
+// if any. Callers must hold b.mu.
+func (b *Blocker) scheduledAllowOnly() *Schedule {
+	for _, s := range b.schedules {
+		if s.active && s.AllowOnly {
+			return s
+		}
+	}
+	return nil
+}
+
+// Scheduler turns the blocker's schedules on and off at their boundaries
+type Scheduler struct {
+	blocker  *Blocker
+	onChange func([]ScheduleStatus)
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewScheduler creates a scheduler for blocker. onChange is called with
+// the status of every schedule whenever one turns on or off or the
+// schedules are replaced.
+func NewScheduler(blocker *Blocker, onChange func([]ScheduleStatus)) *Scheduler {
+	return &Scheduler{
+		blocker:    blocker,
+		onChange:   onChange,
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+// Start runs the scheduler in the background
+func (s *Scheduler) Start() {
+	s.wg.Add(1)
+	go func() {
+		defer s.wg.Done()
+
+		var last []ScheduleStatus
+		for {
+			statuses, next := s.blocker.applySchedules(time.Now())
+			if !sameScheduleStatus(last, statuses) {
+				for _, status := range statuses {
+					logrus.WithFields(logrus.Fields{
+						"schedule": status.Name,
+						"active":   status.Active,
+						"next":     status.NextTransition,
+					}).Info("Schedule state")
+				}
+				if s.onChange != nil {
+					s.onChange(statuses)
+				}
+				last = statuses
+			}
+
+			wait := maxScheduleWait
+			if !next.IsZero() {
+				if until := time.Until(next); until < wait {
+					wait = until
+				}
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-s.shutdownCh:
+				timer.Stop()
+				return
+			case <-s.blocker.schedulesChanged:
+				timer.Stop()
+				last = nil
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the scheduler
+func (s *Scheduler) Stop() {
+	close(s.shutdownCh)
+	s.wg.Wait()
+}
+
+func sameScheduleStatus(a, b []ScheduleStatus) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for i := range a {
+		if a[i] != b[i] {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,141 @@
+package dns
+
+import (
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+func TestScheduleActive(t *testing.T) {
+	ny, err := time.LoadLocation("America/New_York")
+	if err != nil {
+		t.Skip("timezone database unavailable")
+	}
+
+	workday, err := NewSchedule(config.ScheduleConfig{
+		Name:         "work",
+		Timezone:     "America/New_York",
+		Days:         []string{"mon", "tue", "wed", "thu", "fri"},
+		Start:        "09:00",
+		End:          "17:00",
+		BlockDomains: []string{"facebook.com"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	bedtime, err := NewSchedule(config.ScheduleConfig{
+		Name:          "bedtime",
+		Timezone:      "America/New_York",
+		Days:          []string{"fri"},
+		Start:         "22:00",
+		End:           "06:00",
+		AllowOnlyMode: true,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// 2024-01-05 is a Friday
+	at := func(day, hour, minute int) time.Time {
+		return time.Date(2024, 1, day, hour, minute, 0, 0, ny)
+	}
+	tests := []struct {
+		name     string
+		schedule *Schedule
+		t        time.Time
+		active   bool
+		next     time.Time
+	}{
+		{"before window", workday, at(5, 8, 59), false, at(5, 9, 0)},
+		{"window start", workday, at(5, 9, 0), true, at(5, 17, 0)},
+		{"window end", workday, at(5, 17, 0), false, at(8, 9, 0)},
+		{"weekend", workday, at(6, 12, 0), false, at(8, 9, 0)},
+		{"other timezone", workday, at(5, 12, 0).In(time.UTC), true, at(5, 17, 0)},
+		{"overnight start", bedtime, at(5, 23, 0), true, at(6, 6, 0)},
+		{"overnight next morning", bedtime, at(6, 5, 59), true, at(6, 6, 0)},
+		{"overnight other days", bedtime, at(4, 23, 0), false, at(5, 22, 0)},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			if got := tt.schedule.Active(tt.t); got != tt.active {
+				t.Errorf("Active() = %v, want %v", got, tt.active)
+			}
+			if got := tt.schedule.NextTransition(tt.t); !got.Equal(tt.next) {
+				t.Errorf("NextTransition() = %v, want %v", got, tt.next)
+			}
+		})
+	}
+}
+
+func TestNewScheduleInvalid(t *testing.T) {
+	tests := []config.ScheduleConfig{
+		{Start: "09:00", End: "17:00", BlockDomains: []string{"a.com"}},
+		{Name: "s", Start: "9am", End: "17:00", BlockDomains: []string{"a.com"}},
+		{Name: "s", Start: "09:00", End: "09:00", BlockDomains: []string{"a.com"}},
+		{Name: "s", Days: []string{"funday"}, Start: "09:00", End: "17:00", BlockDomains: []string{"a.com"}},
+		{Name: "s", Timezone: "Mars/Olympus", Start: "09:00", End: "17:00", BlockDomains: []string{"a.com"}},
+		{Name: "s", Start: "09:00", End: "17:00"},
+	}
+	for _, cfg := range tests {
+		if _, err := NewSchedule(cfg); err == nil {
+			t.Errorf("NewSchedule(%+v) succeeded, want error", cfg)
+		}
+	}
+}
+
+func TestBlockerSchedules(t *testing.T) {
+	now := time.Now()
+	alwaysOn := func(name string) config.ScheduleConfig {
+		// A window from an hour ago to an hour from now, every day
+		return config.ScheduleConfig{
+			Name:  name,
+			Start: now.Add(-time.Hour).Format("15:04"),
+			End:   now.Add(time.Hour).Format("15:04"),
+		}
+	}
+
+	b := NewBlocker()
+	if err := b.UpdateAllowlist([]string{"docs.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+
+	social := alwaysOn("social")
+	social.BlockDomains = []string{"facebook.com"}
+	s, err := NewSchedule(social)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.UpdateSchedules([]*Schedule{s})
+
+	if match := b.Check("www.facebook.com"); !match.Blocked || match.Source != SourceSchedulePrefix+"social" {
+		t.Errorf("Check(www.facebook.com) = %+v, want blocked by schedule", match)
+	}
+	if b.IsBlocked("news.site") {
+		t.Error("news.site blocked outside allow-only schedule")
+	}
+
+	// Outside the window the schedule stops blocking
+	statuses, next := b.applySchedules(now.Add(3 * time.Hour))
+	if len(statuses) != 1 || statuses[0].Active || next.IsZero() {
+		t.Errorf("applySchedules() = %+v, %v; want one inactive schedule", statuses, next)
+	}
+	if b.IsBlocked("www.facebook.com") {
+		t.Error("www.facebook.com blocked outside its schedule")
+	}
+
+	focus := alwaysOn("focus")
+	focus.AllowOnlyMode = true
+	s, err = NewSchedule(focus)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.UpdateSchedules([]*Schedule{s})
+
+	if match := b.Check("news.site"); !match.Blocked || match.Source != SourceAllowOnly {
+		t.Errorf("Check(news.site) = %+v, want blocked by allow-only schedule", match)
+	}
+	if b.IsBlocked("docs.example.com") {
+		t.Error("allowlisted domain blocked by allow-only schedule")
+	}
+}
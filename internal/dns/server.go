@@ -38,24 +38,52 @@ func (s *Server) Start(port int) error {
 
 	addr := fmt.Sprintf(":%d", port)
 
-	// Create UDP server
-	udpServer := &dns.Server{
-		Addr:    addr,
-		Net:     "udp",
-		Handler: s.handler,
+	// IPv4 and IPv6 get listeners of their own, so ::1 (which
+	// configure-dns sets next to 127.0.0.1) is answered even where IPv6
+	// sockets don't accept IPv4. Hosts with IPv6 disabled serve IPv4 only.
+	ipv4 := newServers(addr, "4", s.handler)
+	if err := startServers(ipv4); err != nil {
+		return fmt.Errorf("failed to start DNS server: %v", err)
 	}
-
-	// Create TCP server
-	tcpServer := &dns.Server{
-		Addr:    addr,
-		Net:     "tcp",
-		Handler: s.handler,
+	s.servers = ipv4
+	ipv6 := newServers(addr, "6", s.handler)
+	if err := startServers(ipv6); err != nil {
+		logrus.WithError(err).Warn("IPv6 DNS listener unavailable, serving IPv4 only")
+	} else {
+		s.servers = append(s.servers, ipv6...)
 	}
 
-	s.servers = []*dns.Server{udpServer, tcpServer}
+	s.started = true
+	return nil
+}
 
-	// Start servers
-	for _, server := range s.servers {
+// newServers creates the UDP and TCP servers of an address family ("4" or
+// "6"). The UDP read buffer fits EDNS0 queries, which may be larger than
+// the classic 512 bytes.
+func newServers(addr, family string, handler dns.Handler) []*dns.Server {
+	return []*dns.Server{
+		{
+			Addr:    addr,
+			Net:     "udp" + family,
+			Handler: handler,
+			UDPSize: dns.DefaultMsgSize,
+		},
+		{
+			Addr:    addr,
+			Net:     "tcp" + family,
+			Handler: handler,
+		},
+	}
+}
+
+// startServers starts servers and waits until all listen, so a port
+// already taken on either protocol is reported instead of silently losing
+// it. If any fails, all are shut down.
+func startServers(servers []*dns.Server) error {
+	started := make(chan struct{}, len(servers))
+	errs := make(chan error, len(servers))
+	for _, server := range servers {
+		server.NotifyStartedFunc = func() { started <- struct{}{} }
 		go func(srv *dns.Server) {
 			logrus.WithFields(logrus.Fields{
 				"addr": srv.Addr,
@@ -64,11 +92,21 @@ func (s *Server) Start(port int) error {
 
 			if err := srv.ListenAndServe(); err != nil {
 				logrus.WithError(err).Error("DNS server error")
+				errs <- fmt.Errorf("%s %s: %v", srv.Net, srv.Addr, err)
 			}
 		}(server)
 	}
 
-	s.started = true
+	for range servers {
+		select {
+		case <-started:
+		case err := <-errs:
+			for _, server := range servers {
+				server.Shutdown()
+			}
+			return err
+		}
+	}
 	return nil
 }
 
@@ -0,0 +1,177 @@
+//go:build darwin
+// +build darwin
+
+package dns
+
+import (
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// DNSBackend names the mechanism used to change system DNS
+func DNSBackend() string {
+	return "networksetup"
+}
+
+// ListDNSServices returns the network services and their DNS servers. A
+// service with no DNS servers uses the ones from DHCP.
+func ListDNSServices() ([]InterfaceConfig, error) {
+	output, err := exec.Command("networksetup", "-listallnetworkservices").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list network services: %w", err)
+	}
+
+	var services []InterfaceConfig
+	lines := strings.Split(string(output), "\n")
+	// The first line is a notice about disabled services
+	for i := 1; i < len(lines); i++ {
+		service := strings.TrimSpace(lines[i])
+		if service == "" || strings.HasPrefix(service, "*") {
+			continue
+		}
+
+		enabled, _ := exec.Command("networksetup", "-getnetworkserviceenabled", service).Output()
+		servers, err := getCurrentSystemDNS(service)
+		if err != nil {
+			continue
+		}
+
+		services = append(services, InterfaceConfig{
+			Name:       service,
+			Type:       detectInterfaceType(service),
+			DNSServers: servers,
+			IsDHCP:     len(servers) == 0,
+			IsActive:   strings.TrimSpace(string(enabled)) != "Disabled",
+		})
+	}
+	return services, nil
+}
+
+// SetDNSServers points service at servers, or back at the DHCP-provided
+// servers when none are given
+func SetDNSServers(service string, servers ...string) error {
+	if len(servers) == 0 {
+		servers = []string{"Empty"}
+	}
+	args := append([]string{"-setdnsservers", service}, servers...)
+	if output, err := exec.Command("networksetup", args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("networksetup -setdnsservers %s: %v: %s", service, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
+
+// getCurrentSystemDNS returns the DNS servers set on service, or none when
+// it uses DHCP
+func getCurrentSystemDNS(service string) ([]string, error) {
+	output, err := exec.Command("networksetup", "-getdnsservers", service).Output()
+	if err != nil {
+		return nil, err
+	}
+
+	outputStr := strings.TrimSpace(string(output))
+	if outputStr == "" || strings.Contains(outputStr, "There aren't any DNS Servers") {
+		return []string{}, nil // DHCP
+	}
+	return strings.Split(outputStr, "\n"), nil
+}
+
+// defaultRoute returns the interface and gateway of the default route
+func defaultRoute() (string, string, error) {
+	output, err := exec.Command("route", "-n", "get", "default").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get default route: %w", err)
+	}
+
+	var interfaceName, gateway string
+	for _, line := range strings.Split(string(output), "\n") {
+		if strings.Contains(line, "interface:") {
+			parts := strings.Fields(line)
+			if len(parts) >= 2 {
+				interfaceName = parts[1]
+			}
+		}
+		if strings.Contains(line, "gateway:") {
+			parts := strings.Fields(line)
+			if len(parts) >= 2 {
+				gateway = parts[1]
+			}
+		}
+	}
+	return interfaceName, gateway, nil
+}
+
+// interfaceType classifies an interface for NetworkIdentity
+func interfaceType(name string) string {
+	return detectInterfaceType(name)
+}
+
+func getWiFiSSID(interfaceName string) (string, error) {
+	cmd := exec.Command("/System/Library/PrivateFrameworks/Apple80211.framework/Versions/Current/Resources/airport", "-I")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(string(output), "\n")
+	for _, line := range lines {
+		if strings.Contains(line, " SSID:") {
+			parts := strings.Split(line, ":")
+			if len(parts) >= 2 {
+				return strings.TrimSpace(parts[1]), nil
+			}
+		}
+	}
+
+	return "", fmt.Errorf("no SSID found")
+}
+
+func getGatewayMAC(ip string) (string, error) {
+	cmd := exec.Command("arp", "-n", ip)
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+
+	lines := strings.Split(string(output), "\n")
+	for _, line := range lines {
+		if strings.Contains(line, ip) {
+			fields := strings.Fields(line)
+			for _, field := range fields {
+				if strings.Count(field, ":") == 5 {
+					return field, nil
+				}
+			}
+		}
+	}
+
+	return "", fmt.Errorf("MAC not found")
+}
+
+// getDHCPResolvers returns the DNS servers offered in the interface's DHCP lease
+func getDHCPResolvers(interfaceName string) ([]string, error) {
+	cmd := exec.Command("ipconfig", "getpacket", interfaceName)
+	output, err := cmd.Output()
+	if err != nil {
+		return nil, err
+	}
+
+	return parseDHCPResolvers(string(output)), nil
+}
+
+func detectVPN() (bool, string) {
+	cmd := exec.Command("ifconfig")
+	output, _ := cmd.Output()
+
+	lines := strings.Split(string(output), "\n")
+	for _, line := range lines {
+		if strings.HasPrefix(line, "utun") || strings.HasPrefix(line, "ppp") {
+			parts := strings.Split(line, ":")
+			if len(parts) > 0 {
+				return true, strings.TrimSpace(parts[0])
+			}
+		}
+	}
+
+	return false, ""
+}
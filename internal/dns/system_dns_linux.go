@@ -0,0 +1,457 @@
+//go:build linux
+// +build linux
+
+package dns
+
+import (
+	"bufio"
+	"fmt"
+	"net"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strconv"
+	"strings"
+)
+
+// Mechanisms for changing system DNS on Linux, as reported by DNSBackend
+const (
+	BackendSystemdResolved = "systemd-resolved"
+	BackendNetworkManager  = "NetworkManager"
+	BackendResolvConf      = "resolv.conf"
+)
+
+// resolvConfService is the single service listed by the resolv.conf
+// backend; the file applies to every interface
+const resolvConfService = "resolv.conf"
+
+var (
+	resolvConfPath     = "/etc/resolv.conf"
+	networkdLeasesPath = "/run/systemd/netif/leases"
+)
+
+// DNSBackend names the mechanism used to change system DNS:
+// systemd-resolved when /etc/resolv.conf is managed by it, NetworkManager
+// when it is running, otherwise /etc/resolv.conf itself, as in most
+// containers
+func DNSBackend() string {
+	if target, err := filepath.EvalSymlinks(resolvConfPath); err == nil && strings.HasPrefix(target, "/run/systemd/resolve/") {
+		if _, err := exec.LookPath("resolvectl"); err == nil {
+			return BackendSystemdResolved
+		}
+	}
+	if _, err := exec.LookPath("nmcli"); err == nil {
+		if output, err := exec.Command("nmcli", "-t", "-f", "RUNNING", "general").Output(); err == nil && strings.TrimSpace(string(output)) == "running" {
+			return BackendNetworkManager
+		}
+	}
+	return BackendResolvConf
+}
+
+// ListDNSServices returns the interfaces and their DNS servers. A service
+// with no DNS servers uses the ones from DHCP. With the resolv.conf backend
+// the file is listed as a single service.
+func ListDNSServices() ([]InterfaceConfig, error) {
+	switch DNSBackend() {
+	case BackendSystemdResolved:
+		output, err := exec.Command("resolvectl", "dns").Output()
+		if err != nil {
+			return nil, fmt.Errorf("failed to list resolved links: %w", err)
+		}
+		var services []InterfaceConfig
+		for link, servers := range parseResolvectlDNS(string(output)) {
+			if link == "lo" {
+				continue
+			}
+			services = append(services, InterfaceConfig{
+				Name:       link,
+				Type:       interfaceType(link),
+				DNSServers: servers,
+				IsDHCP:     len(servers) == 0,
+				IsActive:   true,
+			})
+		}
+		return services, nil
+
+	case BackendNetworkManager:
+		devices, err := nmcliDevices()
+		if err != nil {
+			return nil, err
+		}
+		var services []InterfaceConfig
+		for _, device := range devices {
+			servers, err := nmcliConnectionDNS(device.connection)
+			if err != nil {
+				continue
+			}
+			services = append(services, InterfaceConfig{
+				Name:       device.name,
+				Type:       interfaceType(device.name),
+				DNSServers: servers,
+				IsDHCP:     len(servers) == 0,
+				IsActive:   true,
+			})
+		}
+		return services, nil
+
+	default:
+		servers, err := readResolvConf()
+		if err != nil {
+			return nil, err
+		}
+		return []InterfaceConfig{{
+			Name:       resolvConfService,
+			Type:       "other",
+			DNSServers: servers,
+			IsActive:   true,
+		}}, nil
+	}
+}
+
+// SetDNSServers points service at servers, or back at the DHCP-provided
+// servers when none are given. The resolv.conf backend rewrites the file
+// whatever the service and can't return to DHCP.
+func SetDNSServers(service string, servers ...string) error {
+	switch DNSBackend() {
+	case BackendSystemdResolved:
+		if len(servers) == 0 || !allLocalResolvers(servers) {
+			// Drop DNShield's settings, then restore any static servers
+			if err := runCommand("resolvectl", "revert", service); err != nil || len(servers) == 0 {
+				return err
+			}
+			return runCommand("resolvectl", append([]string{"dns", service}, servers...)...)
+		}
+		if err := runCommand("resolvectl", append([]string{"dns", service}, servers...)...); err != nil {
+			return err
+		}
+		// Route every domain to the link, not just its search domains
+		return runCommand("resolvectl", "domain", service, "~.")
+
+	case BackendNetworkManager:
+		connection, err := nmcliConnection(service)
+		if err != nil {
+			return err
+		}
+		var v4, v6 []string
+		for _, server := range servers {
+			if ip := net.ParseIP(server); ip != nil && ip.To4() == nil {
+				v6 = append(v6, server)
+			} else {
+				v4 = append(v4, server)
+			}
+		}
+		ignoreAuto := "no"
+		if len(servers) > 0 {
+			ignoreAuto = "yes"
+		}
+		if err := runCommand("nmcli", "connection", "modify", connection,
+			"ipv4.dns", strings.Join(v4, ","), "ipv4.ignore-auto-dns", ignoreAuto,
+			"ipv6.dns", strings.Join(v6, ","), "ipv6.ignore-auto-dns", ignoreAuto); err != nil {
+			return err
+		}
+		return runCommand("nmcli", "device", "reapply", service)
+
+	default:
+		if len(servers) == 0 {
+			return fmt.Errorf("%s has no DHCP setting to restore", resolvConfPath)
+		}
+		return writeResolvConf(servers)
+	}
+}
+
+// getCurrentSystemDNS returns the DNS servers set on the interface, or
+// none when it uses DHCP
+func getCurrentSystemDNS(interfaceName string) ([]string, error) {
+	services, err := ListDNSServices()
+	if err != nil {
+		return nil, err
+	}
+	for _, service := range services {
+		if service.Name == interfaceName || service.Name == resolvConfService {
+			return service.DNSServers, nil
+		}
+	}
+	return []string{}, nil
+}
+
+// defaultRoute returns the interface and gateway of the default route
+func defaultRoute() (string, string, error) {
+	output, err := exec.Command("ip", "route", "show", "default").Output()
+	if err != nil {
+		return "", "", fmt.Errorf("failed to get default route: %w", err)
+	}
+	interfaceName, gateway := parseDefaultRoute(string(output))
+	return interfaceName, gateway, nil
+}
+
+// parseDefaultRoute extracts the interface and gateway from `ip route show
+// default` output, e.g. "default via 192.168.1.1 dev wlp2s0 proto dhcp"
+func parseDefaultRoute(output string) (string, string) {
+	for _, line := range strings.Split(output, "\n") {
+		fields := strings.Fields(line)
+		if len(fields) == 0 || fields[0] != "default" {
+			continue
+		}
+		var interfaceName, gateway string
+		for i := 1; i+1 < len(fields); i++ {
+			switch fields[i] {
+			case "via":
+				gateway = fields[i+1]
+			case "dev":
+				interfaceName = fields[i+1]
+			}
+		}
+		if interfaceName != "" {
+			return interfaceName, gateway
+		}
+	}
+	return "", ""
+}
+
+// interfaceType classifies an interface for NetworkIdentity
+func interfaceType(name string) string {
+	if _, err := os.Stat(filepath.Join("/sys/class/net", name, "wireless")); err == nil {
+		return "wifi"
+	}
+	switch {
+	case strings.HasPrefix(name, "wl"):
+		return "wifi"
+	case strings.HasPrefix(name, "en"), strings.HasPrefix(name, "eth"):
+		return "ethernet"
+	default:
+		return "other"
+	}
+}
+
+func getWiFiSSID(interfaceName string) (string, error) {
+	output, err := exec.Command("iw", "dev", interfaceName, "link").Output()
+	if err != nil {
+		return "", err
+	}
+	for _, line := range strings.Split(string(output), "\n") {
+		if ssid, ok := strings.CutPrefix(strings.TrimSpace(line), "SSID:"); ok {
+			return strings.TrimSpace(ssid), nil
+		}
+	}
+	return "", fmt.Errorf("no SSID found")
+}
+
+func getGatewayMAC(ip string) (string, error) {
+	output, err := exec.Command("ip", "neigh", "show", ip).Output()
+	if err != nil {
+		return "", err
+	}
+	fields := strings.Fields(string(output))
+	for i := 0; i+1 < len(fields); i++ {
+		if fields[i] == "lladdr" {
+			return fields[i+1], nil
+		}
+	}
+	return "", fmt.Errorf("MAC not found")
+}
+
+// getDHCPResolvers returns the DNS servers offered in the interface's DHCP
+// lease, as recorded by NetworkManager or systemd-networkd
+func getDHCPResolvers(interfaceName string) ([]string, error) {
+	if output, err := exec.Command("nmcli", "-g", "DHCP4.OPTION", "device", "show", interfaceName).Output(); err == nil {
+		for _, option := range strings.Split(string(output), "|") {
+			name, value, ok := strings.Cut(strings.TrimSpace(option), "=")
+			if ok && strings.TrimSpace(name) == "domain_name_servers" {
+				return strings.Fields(value), nil
+			}
+		}
+	}
+
+	iface, err := net.InterfaceByName(interfaceName)
+	if err != nil {
+		return nil, err
+	}
+	f, err := os.Open(filepath.Join(networkdLeasesPath, strconv.Itoa(iface.Index)))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		if value, ok := strings.CutPrefix(scanner.Text(), "DNS="); ok {
+			return strings.Fields(value), nil
+		}
+	}
+	return nil, fmt.Errorf("no DNS servers in DHCP lease")
+}
+
+func detectVPN() (bool, string) {
+	ifaces, err := net.Interfaces()
+	if err != nil {
+		return false, ""
+	}
+	for _, iface := range ifaces {
+		if iface.Flags&net.FlagUp == 0 {
+			continue
+		}
+		for _, prefix := range []string{"tun", "tap", "wg", "ppp"} {
+			if strings.HasPrefix(iface.Name, prefix) {
+				return true, iface.Name
+			}
+		}
+	}
+	return false, ""
+}
+
+// parseResolvectlDNS maps link names to their servers from `resolvectl dns`
+// output, e.g. "Link 2 (eth0): 10.0.0.1 fe80::1%2"
+func parseResolvectlDNS(output string) map[string][]string {
+	links := make(map[string][]string)
+	for _, line := range strings.Split(output, "\n") {
+		if !strings.HasPrefix(line, "Link ") {
+			continue
+		}
+		start := strings.Index(line, "(")
+		end := strings.Index(line, "):")
+		if start == -1 || end <= start {
+			continue
+		}
+		servers := strings.Fields(line[end+2:])
+		if servers == nil {
+			servers = []string{}
+		}
+		links[line[start+1:end]] = servers
+	}
+	return links
+}
+
+// nmcliDevice is a device with an active NetworkManager connection
+type nmcliDevice struct {
+	name       string
+	connection string
+}
+
+func nmcliDevices() ([]nmcliDevice, error) {
+	output, err := exec.Command("nmcli", "-t", "-f", "DEVICE,STATE,CONNECTION", "device", "status").Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list NetworkManager devices: %w", err)
+	}
+	var devices []nmcliDevice
+	for _, line := range strings.Split(string(output), "\n") {
+		fields := splitTerse(line)
+		if len(fields) != 3 || fields[1] != "connected" || fields[0] == "lo" {
+			continue
+		}
+		devices = append(devices, nmcliDevice{name: fields[0], connection: fields[2]})
+	}
+	return devices, nil
+}
+
+// nmcliConnection returns the active connection of device
+func nmcliConnection(device string) (string, error) {
+	devices, err := nmcliDevices()
+	if err != nil {
+		return "", err
+	}
+	for _, d := range devices {
+		if d.name == device {
+			return d.connection, nil
+		}
+	}
+	return "", fmt.Errorf("no active NetworkManager connection on %s", device)
+}
+
+// nmcliConnectionDNS returns the DNS servers configured on connection
+// rather than learned from DHCP
+func nmcliConnectionDNS(connection string) ([]string, error) {
+	output, err := exec.Command("nmcli", "-g", "ipv4.dns,ipv6.dns", "connection", "show", connection).Output()
+	if err != nil {
+		return nil, err
+	}
+	servers := []string{}
+	for _, line := range strings.Split(string(output), "\n") {
+		for _, server := range strings.Split(line, ",") {
+			if server = strings.TrimSpace(server); server != "" {
+				servers = append(servers, server)
+			}
+		}
+	}
+	return servers, nil
+}
+
+// splitTerse splits a line of nmcli terse output on the colons that aren't
+// escaped
+func splitTerse(line string) []string {
+	if line == "" {
+		return nil
+	}
+	var fields []string
+	var field strings.Builder
+	for i := 0; i < len(line); i++ {
+		switch {
+		case line[i] == '\\' && i+1 < len(line):
+			i++
+			field.WriteByte(line[i])
+		case line[i] == ':':
+			fields = append(fields, field.String())
+			field.Reset()
+		default:
+			field.WriteByte(line[i])
+		}
+	}
+	return append(fields, field.String())
+}
+
+func readResolvConf() ([]string, error) {
+	data, err := os.ReadFile(resolvConfPath)
+	if err != nil {
+		return nil, err
+	}
+	servers := []string{}
+	for _, line := range strings.Split(string(data), "\n") {
+		fields := strings.Fields(line)
+		if len(fields) >= 2 && fields[0] == "nameserver" {
+			servers = append(servers, fields[1])
+		}
+	}
+	return servers, nil
+}
+
+// writeResolvConf replaces the nameserver lines of resolv.conf, keeping
+// search domains and options. The file is rewritten in place, as it is
+// often bind-mounted into containers and can't be replaced.
+func writeResolvConf(servers []string) error {
+	data, err := os.ReadFile(resolvConfPath)
+	if err != nil && !os.IsNotExist(err) {
+		return err
+	}
+	return os.WriteFile(resolvConfPath, []byte(rewriteResolvConf(string(data), servers)), 0644)
+}
+
+// rewriteResolvConf returns conf with its nameservers replaced by servers
+func rewriteResolvConf(conf string, servers []string) string {
+	var b strings.Builder
+	for _, server := range servers {
+		fmt.Fprintf(&b, "nameserver %s\n", server)
+	}
+	for _, line := range strings.Split(conf, "\n") {
+		fields := strings.Fields(line)
+		if line == "" || (len(fields) > 0 && fields[0] == "nameserver") {
+			continue
+		}
+		b.WriteString(line + "\n")
+	}
+	return b.String()
+}
+
+func allLocalResolvers(servers []string) bool {
+	for _, server := range servers {
+		if !IsLocalResolver(server) {
+			return false
+		}
+	}
+	return true
+}
+
+func runCommand(name string, args ...string) error {
+	if output, err := exec.Command(name, args...).CombinedOutput(); err != nil {
+		return fmt.Errorf("%s %s: %v: %s", name, strings.Join(args, " "), err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
@@ -0,0 +1,50 @@
+//go:build linux
+// +build linux
+
+package dns
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParseResolvectlDNS(t *testing.T) {
+	output := "Global:\nLink 1 (lo):\nLink 2 (eth0): 10.0.0.1 fe80::1%2\nLink 3 (wlp2s0): 127.0.0.1 ::1\n"
+	want := map[string][]string{
+		"lo":     {},
+		"eth0":   {"10.0.0.1", "fe80::1%2"},
+		"wlp2s0": {"127.0.0.1", "::1"},
+	}
+	if got := parseResolvectlDNS(output); !reflect.DeepEqual(got, want) {
+		t.Errorf("parseResolvectlDNS() = %v, want %v", got, want)
+	}
+}
+
+func TestParseDefaultRoute(t *testing.T) {
+	iface, gateway := parseDefaultRoute("default via 192.168.1.1 dev wlp2s0 proto dhcp src 192.168.1.20 metric 600\n")
+	if iface != "wlp2s0" || gateway != "192.168.1.1" {
+		t.Errorf("parseDefaultRoute() = %q, %q", iface, gateway)
+	}
+
+	// Containers and point-to-point links may have no gateway
+	iface, gateway = parseDefaultRoute("default dev tun0 scope link\n")
+	if iface != "tun0" || gateway != "" {
+		t.Errorf("parseDefaultRoute() = %q, %q", iface, gateway)
+	}
+}
+
+func TestSplitTerse(t *testing.T) {
+	got := splitTerse(`wlp2s0:connected:Cafe\: Guest`)
+	want := []string{"wlp2s0", "connected", "Cafe: Guest"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("splitTerse() = %q, want %q", got, want)
+	}
+}
+
+func TestRewriteResolvConf(t *testing.T) {
+	conf := "# Generated by Docker\nnameserver 8.8.8.8\nnameserver 8.8.4.4\nsearch corp.example\noptions ndots:0\n"
+	want := "nameserver 127.0.0.1\nnameserver ::1\n# Generated by Docker\nsearch corp.example\noptions ndots:0\n"
+	if got := rewriteResolvConf(conf, LocalResolvers); got != want {
+		t.Errorf("rewriteResolvConf() = %q, want %q", got, want)
+	}
+}
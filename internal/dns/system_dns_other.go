@@ -0,0 +1,51 @@
+//go:build !darwin && !linux
+// +build !darwin,!linux
+
+package dns
+
+import "fmt"
+
+var errSystemDNSUnsupported = fmt.Errorf("system DNS configuration is only supported on macOS and Linux")
+
+// DNSBackend names the mechanism used to change system DNS
+func DNSBackend() string {
+	return "unsupported"
+}
+
+// ListDNSServices is not supported on this platform
+func ListDNSServices() ([]InterfaceConfig, error) {
+	return nil, errSystemDNSUnsupported
+}
+
+// SetDNSServers is not supported on this platform
+func SetDNSServers(service string, servers ...string) error {
+	return errSystemDNSUnsupported
+}
+
+func getCurrentSystemDNS(interfaceName string) ([]string, error) {
+	return nil, errSystemDNSUnsupported
+}
+
+func defaultRoute() (string, string, error) {
+	return "", "", errSystemDNSUnsupported
+}
+
+func interfaceType(name string) string {
+	return detectInterfaceType(name)
+}
+
+func getWiFiSSID(interfaceName string) (string, error) {
+	return "", errSystemDNSUnsupported
+}
+
+func getGatewayMAC(ip string) (string, error) {
+	return "", errSystemDNSUnsupported
+}
+
+func getDHCPResolvers(interfaceName string) ([]string, error) {
+	return nil, errSystemDNSUnsupported
+}
+
+func detectVPN() (bool, string) {
+	return false, ""
+}
@@ -0,0 +1,86 @@
+package dns
+
+import (
+	"sort"
+	"strings"
+	"time"
+)
+
+// TemporaryAllow lets a domain, and optionally its subdomains, through
+// until a deadline as if it were on the allowlist
+type TemporaryAllow struct {
+	Domain     string    `json:"domain"`
+	Subdomains bool      `json:"subdomains"`
+	Until      time.Time `json:"until"`
+	Source     string    `json:"source"` // What granted it, e.g. "approval"
+}
+
+// AllowTemporarily adds allow, replacing any earlier one for its domain.
+// Security-critical rules still win with PrecedenceSecurity.
+func (b *Blocker) AllowTemporarily(allow TemporaryAllow) {
+	allow.Domain = strings.ToLower(strings.TrimSuffix(allow.Domain, "."))
+	now := time.Now()
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.temporaryAllows == nil {
+		b.temporaryAllows = make(map[string]TemporaryAllow)
+	}
+	for domain, existing := range b.temporaryAllows {
+		if now.After(existing.Until) {
+			delete(b.temporaryAllows, domain)
+		}
+	}
+	b.temporaryAllows[allow.Domain] = allow
+}
+
+// RevokeTemporaryAllow removes the temporary allow for domain and reports
+// whether there was one
+func (b *Blocker) RevokeTemporaryAllow(domain string) bool {
+	domain = strings.ToLower(strings.TrimSuffix(domain, "."))
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	_, ok := b.temporaryAllows[domain]
+	delete(b.temporaryAllows, domain)
+	return ok
+}
+
+// TemporaryAllows returns the temporary allows that haven't expired,
+// sorted by domain
+func (b *Blocker) TemporaryAllows() []TemporaryAllow {
+	now := time.Now()
+
+	b.mu.RLock()
+	defer b.mu.RUnlock()
+
+	allows := make([]TemporaryAllow, 0, len(b.temporaryAllows))
+	for _, allow := range b.temporaryAllows {
+		if now.Before(allow.Until) {
+			allows = append(allows, allow)
+		}
+	}
+	sort.Slice(allows, func(i, j int) bool { return allows[i].Domain < allows[j].Domain })
+	return allows
+}
+
+// temporarilyAllowed reports whether domain, or a parent allowed with its
+// subdomains, has an unexpired temporary allow. Callers must hold b.mu.
+func (b *Blocker) temporarilyAllowed(domain string) bool {
+	if len(b.temporaryAllows) == 0 {
+		return false
+	}
+	now := time.Now()
+	for name := domain; ; {
+		if allow, ok := b.temporaryAllows[name]; ok && now.Before(allow.Until) && (name == domain || allow.Subdomains) {
+			return true
+		}
+		i := strings.IndexByte(name, '.')
+		if i < 0 {
+			return false
+		}
+		name = name[i+1:]
+	}
+}
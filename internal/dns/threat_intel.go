@@ -0,0 +1,50 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// UpdateThreatIntel replaces the domains from threat-intel feeds, mapped to
+// their feed's source. They are blocked as security-critical alongside the
+// security rules, but refreshed on their own schedule.
+func (b *Blocker) UpdateThreatIntel(sources map[string]string) error {
+	b.updateMu.Lock()
+	defer b.updateMu.Unlock()
+
+	if len(sources) > b.maxDomains {
+		return fmt.Errorf("threat-intel domain count %d exceeds maximum of %d", len(sources), b.maxDomains)
+	}
+
+	builder := newTrieBuilder(len(sources))
+	for domain, source := range sources {
+		if err := builder.Add(strings.ToLower(domain), source); err != nil {
+			logrus.WithError(err).WithField("domain", domain).Warn("Skipping invalid threat-intel domain")
+		}
+	}
+	b.threatIntel.Store(builder.Build())
+	return nil
+}
+
+// GetThreatIntelCount returns the number of threat-intel domains
+func (b *Blocker) GetThreatIntelCount() int {
+	return b.threatIntel.Load().Len()
+}
+
+// IsSecurityBlocked reports whether domain matches a security-critical
+// rule or threat-intel indicator, whatever the allowlist says
+func (b *Blocker) IsSecurityBlocked(domain string) bool {
+	_, _, ok := b.matchSecurity(strings.ToLower(domain))
+	return ok
+}
+
+// matchSecurity matches domain against the security rules, then the
+// threat-intel domains
+func (b *Blocker) matchSecurity(domain string) (rule, source string, ok bool) {
+	if rule, source, ok = b.securityDomains.Load().Match(domain); ok {
+		return rule, source, ok
+	}
+	return b.threatIntel.Load().Match(domain)
+}
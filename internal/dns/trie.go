@@ -0,0 +1,169 @@
+package dns
+
+import (
+	"fmt"
+	"strings"
+)
+
+// domainTrie holds domain rules keyed by reversed labels (com → example →
+// ads), so a name and all of its parents are matched in a single walk over
+// its labels however many rules are loaded.
+//
+// Rules take three forms:
+//
+//	example.com      example.com and every subdomain
+//	||example.com^   the same, in adblock syntax
+//	*.example.com    subdomains of example.com only
+type domainTrie struct {
+	root trieNode
+	size int
+}
+
+type trieNode struct {
+	children map[string]*trieNode
+
+	// Rule matching this name and its subdomains
+	rule   string
+	source string
+	// Rule matching only subdomains of this name
+	wildcardRule   string
+	wildcardSource string
+}
+
+// ruleMatcher is a domainTrie or a compactTrie
+type ruleMatcher interface {
+	Match(domain string) (rule, source string, ok bool)
+}
+
+func newDomainTrie() *domainTrie {
+	return &domainTrie{}
+}
+
+// parseDomainRule returns the domain a rule applies to and whether it
+// covers only subdomains. rule must already be lowercase.
+func parseDomainRule(rule string) (domain string, subdomainsOnly bool, err error) {
+	domain = rule
+	if strings.HasPrefix(domain, "||") {
+		domain = strings.TrimPrefix(domain, "||")
+		// Only the modifier that doesn't change what's matched is accepted
+		domain = strings.TrimSuffix(domain, "$important")
+		domain = strings.TrimSuffix(domain, "^")
+	} else if strings.HasPrefix(domain, "*.") {
+		domain = strings.TrimPrefix(domain, "*.")
+		subdomainsOnly = true
+	}
+	domain = strings.TrimSuffix(domain, ".")
+
+	if domain == "" || strings.ContainsAny(domain, "*^|$/ ") {
+		return "", false, fmt.Errorf("unsupported rule syntax: %q", rule)
+	}
+	return domain, subdomainsOnly, nil
+}
+
+// Add stores a rule. Adding the same rule again replaces its source.
+func (t *domainTrie) Add(rule, source string) error {
+	domain, subdomainsOnly, err := parseDomainRule(rule)
+	if err != nil {
+		return err
+	}
+
+	node := &t.root
+	labels := strings.Split(domain, ".")
+	for i := len(labels) - 1; i >= 0; i-- {
+		child := node.children[labels[i]]
+		if child == nil {
+			if node.children == nil {
+				node.children = make(map[string]*trieNode, 1)
+			}
+			child = &trieNode{}
+			node.children[labels[i]] = child
+		}
+		node = child
+	}
+
+	if subdomainsOnly {
+		if node.wildcardRule == "" {
+			t.size++
+		}
+		node.wildcardRule, node.wildcardSource = rule, source
+	} else {
+		if node.rule == "" {
+			t.size++
+		}
+		node.rule, node.source = rule, source
+	}
+	return nil
+}
+
+// Match finds the rule covering domain. The rule for the closest parent
+// wins, so a more specific rule reports its own source.
+func (t *domainTrie) Match(domain string) (rule, source string, ok bool) {
+	node := &t.root
+	end := len(domain)
+	for end > 0 {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		child := node.children[domain[start:end]]
+		if child == nil {
+			break
+		}
+		node = child
+
+		if node.rule != "" {
+			rule, source, ok = node.rule, node.source, true
+		}
+		// A wildcard matches only when labels remain below it
+		if start > 0 && node.wildcardRule != "" {
+			rule, source, ok = node.wildcardRule, node.wildcardSource, true
+		}
+		end = start - 1
+	}
+	return rule, source, ok
+}
+
+// Lookup returns the source of rule if the trie holds it
+func (t *domainTrie) Lookup(rule string) (source string, ok bool) {
+	domain, subdomainsOnly, err := parseDomainRule(rule)
+	if err != nil {
+		return "", false
+	}
+
+	node := &t.root
+	end := len(domain)
+	for end >= 0 {
+		start := strings.LastIndexByte(domain[:end], '.') + 1
+		if node = node.children[domain[start:end]]; node == nil {
+			return "", false
+		}
+		end = start - 1
+	}
+
+	switch {
+	case subdomainsOnly && node.wildcardRule == rule:
+		return node.wildcardSource, true
+	case !subdomainsOnly && node.rule == rule:
+		return node.source, true
+	}
+	return "", false
+}
+
+// Len returns the number of rules
+func (t *domainTrie) Len() int {
+	return t.size
+}
+
+// Rules calls fn for every rule with its source
+func (t *domainTrie) Rules(fn func(rule, source string)) {
+	var walk func(n *trieNode)
+	walk = func(n *trieNode) {
+		if n.rule != "" {
+			fn(n.rule, n.source)
+		}
+		if n.wildcardRule != "" {
+			fn(n.wildcardRule, n.wildcardSource)
+		}
+		for _, child := range n.children {
+			walk(child)
+		}
+	}
+	walk(&t.root)
+}
@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"fmt"
+	"testing"
+)
+
+func TestDomainTrie(t *testing.T) {
+	trie := newDomainTrie()
+	for rule, source := range map[string]string{
+		"example.com":          "list-a",
+		"*.wild.org":           "list-b",
+		"||adblock.net^":       "list-c",
+		"deep.sub.example.com": "list-d",
+	} {
+		if err := trie.Add(rule, source); err != nil {
+			t.Fatalf("Add(%q) error = %v", rule, err)
+		}
+	}
+	if trie.Len() != 4 {
+		t.Errorf("Len() = %d, want 4", trie.Len())
+	}
+
+	tests := []struct {
+		domain string
+		rule   string
+		source string
+	}{
+		{"example.com", "example.com", "list-a"},
+		{"a.b.example.com", "example.com", "list-a"},
+		{"deep.sub.example.com", "deep.sub.example.com", "list-d"},
+		{"x.deep.sub.example.com", "deep.sub.example.com", "list-d"},
+		{"notexample.com", "", ""},
+		{"wild.org", "", ""},
+		{"a.wild.org", "*.wild.org", "list-b"},
+		{"adblock.net", "||adblock.net^", "list-c"},
+		{"cdn.adblock.net", "||adblock.net^", "list-c"},
+		{"com", "", ""},
+		{"", "", ""},
+	}
+	for _, tt := range tests {
+		rule, source, ok := trie.Match(tt.domain)
+		if ok != (tt.rule != "") || rule != tt.rule || source != tt.source {
+			t.Errorf("Match(%q) = %q, %q, %v; want %q, %q", tt.domain, rule, source, ok, tt.rule, tt.source)
+		}
+	}
+
+	for _, rule := range []string{"*", "||", "ads.*.com", "||x.com^$third-party", "/ads/"} {
+		if err := trie.Add(rule, ""); err == nil {
+			t.Errorf("Add(%q) accepted unsupported syntax", rule)
+		}
+	}
+}
+
+func BenchmarkDomainTrieMatch(b *testing.B) {
+	trie := newDomainTrie()
+	for i := 0; i < 1000000; i++ {
+		trie.Add(fmt.Sprintf("host%d.tracker%d.example", i, i%1000), "bench")
+	}
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		trie.Match("a.b.c.host123456.tracker456.example")
+	}
+}
+
+func TestDomainTrieLookup(t *testing.T) {
+	trie := newDomainTrie()
+	trie.Add("ads.example.com", "list-a")
+	trie.Add("*.example.com", "list-b")
+	trie.Add("||tracker.net^", "list-c")
+
+	tests := []struct {
+		rule   string
+		source string
+	}{
+		{"ads.example.com", "list-a"},
+		{"*.example.com", "list-b"},
+		{"||tracker.net^", "list-c"},
+		{"example.com", ""},
+		{"tracker.net", ""},
+		{"*.ads.example.com", ""},
+		{"cdn.ads.example.com", ""},
+	}
+	for _, tt := range tests {
+		if source, ok := trie.Lookup(tt.rule); ok != (tt.source != "") || source != tt.source {
+			t.Errorf("Lookup(%q) = %q, %v; want %q", tt.rule, source, ok, tt.source)
+		}
+	}
+}
@@ -0,0 +1,219 @@
+package dns
+
+import (
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Reasons reported in TunnelEvent.Reasons
+const (
+	TunnelReasonUniqueNames = "unique_names" // Nearly every query is for a new name
+	TunnelReasonTXTQueries  = "txt_queries"  // Many TXT or NULL queries
+)
+
+const (
+	// maxTunnelZones bounds the client and zone pairs tracked at once
+	maxTunnelZones = 10000
+	// maxTunnelNames bounds the distinct names remembered per pair; further
+	// names count as distinct, as tunnels rarely repeat one
+	maxTunnelNames = 1000
+)
+
+// TunnelDetector watches the queries each client sends to each zone for
+// the signs of DNS tunneling tools such as iodine and dnscat2: many
+// queries, nearly all for distinct names, often of type TXT or NULL
+type TunnelDetector struct {
+	window      time.Duration
+	minQueries  int
+	uniqueRatio float64
+	txtRatio    float64
+	refuse      bool
+	cooldown    time.Duration
+	exempt      *domainTrie
+
+	mu      sync.Mutex
+	stats   map[string]*tunnelStats // "client|zone" -> queries in the current window
+	refused map[string]time.Time    // Zone -> end of its cooldown
+}
+
+// tunnelStats counts one client's queries to one zone within a window
+type tunnelStats struct {
+	start   time.Time
+	queries int
+	txt     int
+	unique  int
+	names   map[string]struct{}
+	flagged bool // Already reported in this window
+}
+
+// TunnelEvent describes a zone flagged as a likely tunnel
+type TunnelEvent struct {
+	ClientIP    string
+	Zone        string
+	Queries     int
+	UniqueRatio float64
+	TXTRatio    float64
+	Reasons     []string
+	Refused     bool // The zone is refused for the cooldown
+}
+
+// NewTunnelDetector creates a detector from the dns.tunneling config
+func NewTunnelDetector(cfg *config.TunnelingConfig) *TunnelDetector {
+	d := &TunnelDetector{
+		window:      cfg.Window,
+		minQueries:  cfg.MinQueries,
+		uniqueRatio: cfg.UniqueRatio,
+		txtRatio:    cfg.TXTRatio,
+		refuse:      cfg.Refuse,
+		cooldown:    cfg.Cooldown,
+		exempt:      newDomainTrie(),
+		stats:       make(map[string]*tunnelStats),
+		refused:     make(map[string]time.Time),
+	}
+	for _, zone := range cfg.Exempt {
+		if err := d.exempt.Add(strings.ToLower(strings.TrimSpace(zone)), "exempt"); err != nil {
+			logrus.WithError(err).WithField("zone", zone).Warn("Skipping invalid tunneling exemption")
+		}
+	}
+	return d
+}
+
+// Refused reports whether domain belongs to a zone in its cooldown
+func (d *TunnelDetector) Refused(domain string, now time.Time) bool {
+	zone := registrableDomain(strings.ToLower(domain))
+	if zone == "" {
+		return false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	until, ok := d.refused[zone]
+	if !ok {
+		return false
+	}
+	if !now.Before(until) {
+		delete(d.refused, zone)
+		return false
+	}
+	return true
+}
+
+// Observe counts a query from clientIP and reports the zone the first time
+// in a window that it looks like a tunnel
+func (d *TunnelDetector) Observe(clientIP, domain string, qtype uint16, now time.Time) (TunnelEvent, bool) {
+	domain = strings.ToLower(domain)
+	zone := registrableDomain(domain)
+	if zone == "" {
+		return TunnelEvent{}, false
+	}
+	if _, _, exempt := d.exempt.Match(domain); exempt {
+		return TunnelEvent{}, false
+	}
+
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	key := clientIP + "|" + zone
+	s := d.stats[key]
+	if s == nil || now.Sub(s.start) >= d.window {
+		if s == nil && len(d.stats) >= maxTunnelZones {
+			d.pruneLocked(now)
+		}
+		s = &tunnelStats{start: now, names: make(map[string]struct{})}
+		d.stats[key] = s
+	}
+
+	s.queries++
+	if qtype == dns.TypeTXT || qtype == dns.TypeNULL {
+		s.txt++
+	}
+	if _, seen := s.names[domain]; !seen {
+		s.unique++
+		if len(s.names) < maxTunnelNames {
+			s.names[domain] = struct{}{}
+		}
+	}
+
+	if s.flagged || s.queries < d.minQueries {
+		return TunnelEvent{}, false
+	}
+	event := TunnelEvent{
+		ClientIP:    clientIP,
+		Zone:        zone,
+		Queries:     s.queries,
+		UniqueRatio: float64(s.unique) / float64(s.queries),
+		TXTRatio:    float64(s.txt) / float64(s.queries),
+	}
+	if event.UniqueRatio >= d.uniqueRatio {
+		event.Reasons = append(event.Reasons, TunnelReasonUniqueNames)
+	}
+	if event.TXTRatio >= d.txtRatio {
+		event.Reasons = append(event.Reasons, TunnelReasonTXTQueries)
+	}
+	if len(event.Reasons) == 0 {
+		return TunnelEvent{}, false
+	}
+
+	s.flagged = true
+	if d.refuse {
+		d.refused[zone] = now.Add(d.cooldown)
+		event.Refused = true
+	}
+	return event, true
+}
+
+// pruneLocked drops expired windows and cooldowns, or everything if the
+// table is still full. Callers must hold d.mu.
+func (d *TunnelDetector) pruneLocked(now time.Time) {
+	for key, s := range d.stats {
+		if now.Sub(s.start) >= d.window {
+			delete(d.stats, key)
+		}
+	}
+	if len(d.stats) >= maxTunnelZones {
+		d.stats = make(map[string]*tunnelStats)
+	}
+	for zone, until := range d.refused {
+		if !now.Before(until) {
+			delete(d.refused, zone)
+		}
+	}
+}
+
+// SetTunnelCallback sets the callback for zones flagged as likely tunnels
+func (h *Handler) SetTunnelCallback(cb func(event TunnelEvent)) {
+	h.tunnelCallback = cb
+}
+
+// checkTunnel counts the query and reports whether it must be refused
+// because its zone is, or has just become, a suspected tunnel.
+// Allowlisted names are never counted.
+func (h *Handler) checkTunnel(blocker *Blocker, domain string, qtype uint16, clientIP string, now time.Time) bool {
+	if h.tunnel == nil || blocker.IsAllowlisted(domain) {
+		return false
+	}
+	if h.tunnel.Refused(domain, now) {
+		return true
+	}
+	event, flagged := h.tunnel.Observe(clientIP, domain, qtype, now)
+	if !flagged {
+		return false
+	}
+	if h.tunnelCallback != nil {
+		h.tunnelCallback(event)
+	}
+	return event.Refused
+}
+
+// serveTunnelRefused answers a query to a suspected tunnel with REFUSED
+func (h *Handler) serveTunnelRefused(w dns.ResponseWriter, r, m *dns.Msg, domain string) {
+	m.Rcode = dns.RcodeRefused
+	setExtendedError(r, m, dns.ExtendedErrorCodeProhibited, "Suspected DNS tunnel: "+registrableDomain(strings.ToLower(domain)))
+	w.WriteMsg(m)
+}
@@ -0,0 +1,89 @@
+package dns
+
+import (
+	"fmt"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"github.com/miekg/dns"
+)
+
+func TestTunnelDetector(t *testing.T) {
+	d := NewTunnelDetector(&config.TunnelingConfig{
+		Window: time.Minute, MinQueries: 10, UniqueRatio: 0.8, TXTRatio: 0.5,
+		Refuse: true, Cooldown: 10 * time.Minute, Exempt: []string{"dnsbl.example"},
+	})
+	now := time.Now()
+
+	// Repeated lookups of the same names aren't a tunnel
+	for i := 0; i < 20; i++ {
+		if _, flagged := d.Observe("10.0.0.1", fmt.Sprintf("host%d.example.com", i%2), dns.TypeA, now); flagged {
+			t.Fatal("repeated names flagged")
+		}
+	}
+
+	// Exempt zones are never counted
+	for i := 0; i < 20; i++ {
+		if _, flagged := d.Observe("10.0.0.1", fmt.Sprintf("%d.2.0.192.dnsbl.example", i), dns.TypeA, now); flagged {
+			t.Fatal("exempt zone flagged")
+		}
+	}
+
+	// Distinct TXT names are, once, and the zone is refused until the
+	// cooldown ends
+	var events []TunnelEvent
+	for i := 0; i < 20; i++ {
+		if event, flagged := d.Observe("10.0.0.1", fmt.Sprintf("a%dx9q.t.tunnel.co.uk", i), dns.TypeTXT, now); flagged {
+			events = append(events, event)
+		}
+	}
+	if len(events) != 1 || events[0].Zone != "tunnel.co.uk" || len(events[0].Reasons) != 2 || !events[0].Refused {
+		t.Fatalf("events = %+v, want one refused tunnel.co.uk event with both reasons", events)
+	}
+	if !d.Refused("other.tunnel.co.uk", now.Add(time.Minute)) {
+		t.Error("flagged zone not refused")
+	}
+	if d.Refused("other.tunnel.co.uk", now.Add(11*time.Minute)) {
+		t.Error("zone still refused after the cooldown")
+	}
+}
+
+func TestHandlerRefusesTunnel(t *testing.T) {
+	upstream := startTestUpstream(t, func(q dns.Question) []dns.RR {
+		txt, _ := dns.NewRR(q.Name + ` 60 IN TXT "data"`)
+		return []dns.RR{txt}
+	})
+	h := NewHandler(NewBlocker(), &config.DNSConfig{
+		Upstreams: []string{upstream}, CacheSize: 100, CacheTTL: time.Minute,
+		Tunneling: config.TunnelingConfig{
+			Enabled: true, Window: time.Minute, MinQueries: 5, UniqueRatio: 0.8, TXTRatio: 0.5,
+			Refuse: true, Cooldown: time.Minute,
+		},
+	}, "127.0.0.1", &config.CaptivePortalConfig{})
+	t.Cleanup(h.Stop)
+	var events []TunnelEvent
+	h.SetTunnelCallback(func(e TunnelEvent) { events = append(events, e) })
+
+	query := func(name string) *dns.Msg {
+		req := new(dns.Msg)
+		req.SetQuestion(name, dns.TypeTXT)
+		w := &testResponseWriter{}
+		h.ServeDNS(w, req)
+		return w.msg
+	}
+	for i := 0; i < 4; i++ {
+		if m := query(fmt.Sprintf("q%d.tunnel.example.", i)); m.Rcode != dns.RcodeSuccess {
+			t.Fatalf("query %d refused before the threshold", i)
+		}
+	}
+	if m := query("q4.tunnel.example."); m.Rcode != dns.RcodeRefused {
+		t.Fatalf("rcode = %s, want REFUSED once flagged", dns.RcodeToString[m.Rcode])
+	}
+	if m := query("www.tunnel.example."); m.Rcode != dns.RcodeRefused {
+		t.Errorf("rcode = %s, want REFUSED during the cooldown", dns.RcodeToString[m.Rcode])
+	}
+	if len(events) != 1 || events[0].Zone != "tunnel.example" {
+		t.Errorf("events = %+v, want one for tunnel.example", events)
+	}
+}
@@ -0,0 +1,215 @@
+package dns
+
+import (
+	"context"
+	"math/rand"
+	"net"
+	"strings"
+	"time"
+
+	"github.com/miekg/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Upstream strategies (dns.strategy)
+const (
+	StrategySequential = "sequential" // In order, moving on when one fails
+	StrategyRace       = "race"       // All at once; the first good answer wins
+	StrategyRandom     = "random"     // In a random order
+)
+
+// upstreamTimeout bounds a single upstream exchange
+const upstreamTimeout = 5 * time.Second
+
+// forwardToUpstream sends the query to the upstream DNS servers according
+// to the configured strategy. It returns the response with the upstream
+// that answered and its round trip time, or nil if all upstreams failed.
+// Client subnets are stripped or replaced first if dns.ecs says so, and
+// the name's case is randomized if dns.caseRandomization is set.
+func (h *Handler) forwardToUpstream(r *dns.Msg) (*dns.Msg, string, time.Duration) {
+	query := h.upstreamQuery(r)
+	randomized := h.caseRandomization && len(query.Question) > 0
+	if randomized {
+		if query == r {
+			query = r.Copy()
+		}
+		query.Question[0].Name = randomizeCase(query.Question[0].Name)
+	}
+	resp, upstream, rtt := h.forwardQuery(query, randomized)
+	if resp != nil && len(r.Question) > 0 {
+		restoreCase(resp, query.Question[0].Name, r.Question[0].Name)
+	}
+	h.clientResponse(resp)
+	return resp, upstream, rtt
+}
+
+// forwardQuery sends r to the upstreams for its name as the strategy says.
+// Answers must echo the name's case exactly if exactCase is set.
+func (h *Handler) forwardQuery(r *dns.Msg, exactCase bool) (*dns.Msg, string, time.Duration) {
+	var upstreams []string
+	if len(r.Question) > 0 {
+		upstreams = h.upstreamsFor(strings.ToLower(strings.TrimSuffix(r.Question[0].Name, ".")))
+	} else {
+		upstreams = h.currentUpstreams()
+	}
+
+	h.upstreamMu.RLock()
+	strategy := h.strategy
+	h.upstreamMu.RUnlock()
+
+	switch strategy {
+	case StrategyRace:
+		if len(upstreams) > 1 {
+			return raceUpstreams(r, upstreams, exactCase)
+		}
+	case StrategyRandom:
+		shuffled := make([]string, len(upstreams))
+		copy(shuffled, upstreams)
+		rand.Shuffle(len(shuffled), func(i, j int) { shuffled[i], shuffled[j] = shuffled[j], shuffled[i] })
+		upstreams = shuffled
+	}
+
+	for _, upstream := range upstreams {
+		upstream = upstreamAddr(upstream)
+		resp, rtt, err := exchange(context.Background(), r, upstream, exactCase)
+		if err != nil {
+			logrus.WithError(err).WithField("upstream", upstream).Warn("Failed to query upstream")
+			continue
+		}
+		return resp, upstream, rtt
+	}
+	return nil, "", 0
+}
+
+// raceUpstreams sends the query to every upstream at once and returns the
+// first answer that isn't SERVFAIL or REFUSED, cancelling the others. If
+// no upstream gives one, the first error answer is returned instead.
+func raceUpstreams(r *dns.Msg, upstreams []string, exactCase bool) (*dns.Msg, string, time.Duration) {
+	ctx, cancel := context.WithTimeout(context.Background(), upstreamTimeout)
+	defer cancel()
+
+	type result struct {
+		resp     *dns.Msg
+		upstream string
+		rtt      time.Duration
+	}
+	// Buffered so the losers never block after the winner returns
+	results := make(chan result, len(upstreams))
+	for _, upstream := range upstreams {
+		go func(upstream string, query *dns.Msg) {
+			resp, rtt, err := exchange(ctx, query, upstream, exactCase)
+			if err != nil {
+				if ctx.Err() == nil {
+					logrus.WithError(err).WithField("upstream", upstream).Warn("Failed to query upstream")
+				}
+				resp = nil
+			}
+			results <- result{resp: resp, upstream: upstream, rtt: rtt}
+		}(upstreamAddr(upstream), r.Copy())
+	}
+
+	var fallback result
+	for range upstreams {
+		res := <-results
+		if res.resp == nil {
+			continue
+		}
+		if res.resp.Rcode != dns.RcodeServerFailure && res.resp.Rcode != dns.RcodeRefused {
+			return res.resp, res.upstream, res.rtt
+		}
+		if fallback.resp == nil {
+			fallback = res
+		}
+	}
+	return fallback.resp, fallback.upstream, fallback.rtt
+}
+
+// exchange queries upstream over UDP, retrying over TCP when the answer is
+// truncated so large responses (DNSSEC, long TXT records) arrive whole.
+// Answers that don't echo the question are rejected as spoofed, its case
+// included if exactCase is set.
+func exchange(ctx context.Context, r *dns.Msg, upstream string, exactCase bool) (*dns.Msg, time.Duration, error) {
+	c := &dns.Client{Timeout: upstreamTimeout}
+	resp, rtt, err := c.ExchangeContext(ctx, r, upstream)
+	if err == nil {
+		err = checkQuestion(r, resp, exactCase)
+	}
+	if err != nil || !resp.Truncated {
+		return resp, rtt, err
+	}
+
+	c.Net = "tcp"
+	tcpResp, tcpRTT, err := c.ExchangeContext(ctx, r, upstream)
+	if err == nil {
+		err = checkQuestion(r, tcpResp, exactCase)
+	}
+	if err != nil {
+		// The truncated answer is still better than none
+		logrus.WithError(err).WithField("upstream", upstream).Debug("TCP retry of truncated answer failed")
+		return resp, rtt, nil
+	}
+	return tcpResp, rtt + tcpRTT, nil
+}
+
+// setConditionalForwarders routes names under each suffix to its own
+// upstreams. Suffixes follow the blocklist syntax, so "corp.example.com"
+// covers the domain and its subdomains and "*.corp.example.com" only the
+// subdomains.
+func (h *Handler) setConditionalForwarders(forwarders map[string][]string) {
+	trie := newDomainTrie()
+	lists := make(map[string][]string, len(forwarders))
+	for suffix, upstreams := range forwarders {
+		key := strings.ToLower(strings.TrimSpace(suffix))
+		if len(upstreams) == 0 {
+			logrus.WithField("domain", suffix).Warn("Conditional forwarder has no upstreams, ignoring")
+			continue
+		}
+		if err := trie.Add(key, key); err != nil {
+			logrus.WithError(err).WithField("domain", suffix).Warn("Invalid conditional forwarder domain, ignoring")
+			continue
+		}
+		lists[key] = upstreams
+	}
+
+	h.upstreamMu.Lock()
+	defer h.upstreamMu.Unlock()
+	h.forwarders, h.forwarderLists = trie, lists
+}
+
+// upstreamsFor returns the upstreams for domain: the conditional forwarders
+// of its most specific matching suffix, or the default upstreams. A
+// forwarder list of only "dhcp" falls back to the defaults while the
+// current network (e.g. a VPN) supplies no resolvers.
+func (h *Handler) upstreamsFor(domain string) []string {
+	h.upstreamMu.RLock()
+	defer h.upstreamMu.RUnlock()
+
+	if h.forwarders == nil {
+		return h.expandUpstreams()
+	}
+	if _, key, ok := h.forwarders.Match(domain); ok {
+		var upstreams []string
+		for _, upstream := range h.forwarderLists[key] {
+			if strings.EqualFold(upstream, UpstreamDHCP) {
+				if h.networkResolvers != nil {
+					upstreams = append(upstreams, h.networkResolvers()...)
+				}
+				continue
+			}
+			upstreams = append(upstreams, upstream)
+		}
+		if len(upstreams) > 0 {
+			return upstreams
+		}
+	}
+	return h.expandUpstreams()
+}
+
+// upstreamAddr adds the default port to an upstream if it has none (bare
+// IPv6 addresses included)
+func upstreamAddr(upstream string) string {
+	if _, _, err := net.SplitHostPort(upstream); err != nil {
+		return net.JoinHostPort(upstream, "53")
+	}
+	return upstream
+}
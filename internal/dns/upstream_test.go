@@ -0,0 +1,156 @@
+package dns
+
+import (
+	"net"
+	"reflect"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+
+	"github.com/miekg/dns"
+)
+
+// startSlowUpstream runs a UDP resolver that answers every query with rcode
+// after delay
+func startSlowUpstream(t *testing.T, delay time.Duration, rcode int) string {
+	t.Helper()
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := &dns.Server{
+		PacketConn: pc,
+		Handler: dns.HandlerFunc(func(w dns.ResponseWriter, r *dns.Msg) {
+			time.Sleep(delay)
+			m := new(dns.Msg)
+			m.SetRcode(r, rcode)
+			if rcode == dns.RcodeSuccess {
+				a, _ := dns.NewRR(r.Question[0].Name + " 60 IN A 192.0.2.1")
+				m.Answer = []dns.RR{a}
+			}
+			w.WriteMsg(m)
+		}),
+	}
+	started := make(chan struct{})
+	server.NotifyStartedFunc = func() { close(started) }
+	go server.ActivateAndServe()
+	<-started
+	t.Cleanup(func() { server.Shutdown() })
+	return pc.LocalAddr().String()
+}
+
+func TestRaceUpstreams(t *testing.T) {
+	slow := startSlowUpstream(t, time.Second, dns.RcodeSuccess)
+	fast := startSlowUpstream(t, 0, dns.RcodeSuccess)
+	failing := startSlowUpstream(t, 0, dns.RcodeServerFailure)
+	unreachable := "127.0.0.1:1"
+
+	req := new(dns.Msg)
+	req.SetQuestion("example.org.", dns.TypeA)
+
+	tests := []struct {
+		name      string
+		upstreams []string
+		want      string
+		rcode     int
+	}{
+		{"fastest wins", []string{slow, fast}, fast, dns.RcodeSuccess},
+		{"errors lose to slower answers", []string{failing, unreachable, slow}, slow, dns.RcodeSuccess},
+		{"error answer when nothing better", []string{failing, unreachable}, failing, dns.RcodeServerFailure},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			resp, upstream, _ := raceUpstreams(req, tt.upstreams, false)
+			if resp == nil || upstream != tt.want || resp.Rcode != tt.rcode {
+				t.Fatalf("raceUpstreams() = %v from %s, want rcode %d from %s", resp, upstream, tt.rcode, tt.want)
+			}
+		})
+	}
+
+	if resp, _, _ := raceUpstreams(req, []string{unreachable}, false); resp != nil {
+		t.Errorf("raceUpstreams() = %v with no reachable upstream, want nil", resp)
+	}
+}
+
+func TestUpstreamsFor(t *testing.T) {
+	var resolvers []string
+	h := &Handler{upstreams: []string{"1.1.1.1"}}
+	h.SetNetworkResolverSource(func() []string { return resolvers })
+	h.setConditionalForwarders(map[string][]string{
+		"corp.example.com":     {"10.0.0.53", "10.0.1.53"},
+		"lab.corp.example.com": {"10.9.0.53"},
+		"*.vpn.example.com":    {"dhcp"},
+		"empty.example.com":    {},
+	})
+
+	tests := []struct {
+		domain    string
+		resolvers []string
+		want      []string
+	}{
+		{"corp.example.com", nil, []string{"10.0.0.53", "10.0.1.53"}},
+		{"git.corp.example.com", nil, []string{"10.0.0.53", "10.0.1.53"}},
+		{"host.lab.corp.example.com", nil, []string{"10.9.0.53"}},
+		{"notcorp.example.com", nil, []string{"1.1.1.1"}},
+		{"vpn.example.com", []string{"172.16.0.1"}, []string{"1.1.1.1"}},
+		{"intranet.vpn.example.com", []string{"172.16.0.1"}, []string{"172.16.0.1"}},
+		{"intranet.vpn.example.com", nil, []string{"1.1.1.1"}},
+		{"empty.example.com", nil, []string{"1.1.1.1"}},
+	}
+	for _, tt := range tests {
+		resolvers = tt.resolvers
+		if got := h.upstreamsFor(tt.domain); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("upstreamsFor(%s) with resolvers %v = %v, want %v", tt.domain, tt.resolvers, got, tt.want)
+		}
+	}
+}
+
+func TestHandlerUpdateConfig(t *testing.T) {
+	h := NewHandler(NewBlocker(), &config.DNSConfig{
+		Upstreams: []string{"1.1.1.1"},
+		CacheSize: 10,
+		CacheTTL:  time.Hour,
+	}, "127.0.0.1", &config.CaptivePortalConfig{Enabled: true, DetectionThreshold: 3})
+	defer h.Stop()
+
+	for _, name := range []string{"a.example.com", "b.example.com", "c.example.com", "d.example.com"} {
+		h.cache.Set(name, dns.TypeA, nil)
+	}
+
+	h.UpdateConfig(&config.DNSConfig{
+		Upstreams:             []string{"9.9.9.9", "149.112.112.112"},
+		Strategy:              StrategyRace,
+		ConditionalForwarders: map[string][]string{"corp.example.com": {"10.0.0.53"}},
+		CacheSize:             2,
+		CacheTTL:              time.Minute,
+	})
+	h.UpdateCaptivePortal(&config.CaptivePortalConfig{Enabled: false})
+
+	if got, want := h.upstreamsFor("example.org"), []string{"9.9.9.9", "149.112.112.112"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("upstreams = %v, want %v", got, want)
+	}
+	if got, want := h.upstreamsFor("git.corp.example.com"), []string{"10.0.0.53"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("conditional forwarder upstreams = %v, want %v", got, want)
+	}
+	if h.strategy != StrategyRace {
+		t.Errorf("strategy = %q, want %q", h.strategy, StrategyRace)
+	}
+	if n := len(h.cache.entries); n != 2 {
+		t.Errorf("cache holds %d entries after shrinking to 2", n)
+	}
+	if left, _ := h.cache.ExpiresIn("a.example.com", dns.TypeA); left > time.Hour {
+		t.Errorf("cached entry expires in %v", left)
+	}
+	h.cache.Set("e.example.com", dns.TypeA, nil)
+	if left, ok := h.cache.ExpiresIn("e.example.com", dns.TypeA); !ok || left > time.Minute {
+		t.Errorf("new entry expires in %v, want the reloaded TTL", left)
+	}
+
+	for _, domain := range []string{"captive.apple.com", "connectivitycheck.gstatic.com", "www.msftconnecttest.com"} {
+		h.captiveDetector.RecordRequest(domain)
+	}
+	if h.captiveDetector.IsInBypassMode() {
+		t.Error("captive portal detection still enabled after the reload")
+	}
+}
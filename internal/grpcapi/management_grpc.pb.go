@@ -0,0 +1,344 @@
+// Management API served on the agent's gRPC socket (api.grpc.socket).
+//
+// Every call needs an API key in the "authorization" metadata as
+// "Bearer <key>", with the same permission as the matching HTTP endpoint.
+// Errors carry the gRPC status matching the HTTP API's status code.
+
+// Code generated by protoc-gen-go-grpc. DO NOT EDIT.
+// versions:
+// - protoc-gen-go-grpc v1.3.0
+// - protoc             (unknown)
+// source: management.proto
+
+package grpcapi
+
+import (
+	context "context"
+	grpc "google.golang.org/grpc"
+	codes "google.golang.org/grpc/codes"
+	status "google.golang.org/grpc/status"
+)
+
+// This is a compile-time assertion to ensure that this generated file
+// is compatible with the grpc package it is being compiled against.
+// Requires gRPC-Go v1.32.0 or later.
+const _ = grpc.SupportPackageIsVersion7
+
+const (
+	Management_GetStatus_FullMethodName     = "/dnshield.v1.Management/GetStatus"
+	Management_GetStatistics_FullMethodName = "/dnshield.v1.Management/GetStatistics"
+	Management_Pause_FullMethodName         = "/dnshield.v1.Management/Pause"
+	Management_Resume_FullMethodName        = "/dnshield.v1.Management/Resume"
+	Management_RefreshRules_FullMethodName  = "/dnshield.v1.Management/RefreshRules"
+	Management_StreamQueries_FullMethodName = "/dnshield.v1.Management/StreamQueries"
+)
+
+// ManagementClient is the client API for Management service.
+//
+// For semantics around ctx use and closing/ending streaming RPCs, please refer to https://pkg.go.dev/google.golang.org/grpc/?tab=doc#ClientConn.NewStream.
+type ManagementClient interface {
+	// Protection and policy status (status:view, like GET /api/status)
+	GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error)
+	// Query counters (stats:view, like GET /api/statistics)
+	GetStatistics(ctx context.Context, in *GetStatisticsRequest, opts ...grpc.CallOption) (*Statistics, error)
+	// Pause protection (protection:pause, like POST /api/pause)
+	Pause(ctx context.Context, in *PauseRequest, opts ...grpc.CallOption) (*PauseResponse, error)
+	// Resume protection (protection:resume, like POST /api/resume)
+	Resume(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*ResumeResponse, error)
+	// Fetch and apply the enterprise rules now (rules:refresh, like
+	// POST /api/refresh-rules)
+	RefreshRules(ctx context.Context, in *RefreshRulesRequest, opts ...grpc.CallOption) (*RefreshRulesResponse, error)
+	// Every query as it is answered (queries:stream, like
+	// GET /api/queries/stream)
+	StreamQueries(ctx context.Context, in *StreamQueriesRequest, opts ...grpc.CallOption) (Management_StreamQueriesClient, error)
+}
+
+type managementClient struct {
+	cc grpc.ClientConnInterface
+}
+
+func NewManagementClient(cc grpc.ClientConnInterface) ManagementClient {
+	return &managementClient{cc}
+}
+
+func (c *managementClient) GetStatus(ctx context.Context, in *GetStatusRequest, opts ...grpc.CallOption) (*Status, error) {
+	out := new(Status)
+	err := c.cc.Invoke(ctx, Management_GetStatus_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementClient) GetStatistics(ctx context.Context, in *GetStatisticsRequest, opts ...grpc.CallOption) (*Statistics, error) {
+	out := new(Statistics)
+	err := c.cc.Invoke(ctx, Management_GetStatistics_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementClient) Pause(ctx context.Context, in *PauseRequest, opts ...grpc.CallOption) (*PauseResponse, error) {
+	out := new(PauseResponse)
+	err := c.cc.Invoke(ctx, Management_Pause_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementClient) Resume(ctx context.Context, in *ResumeRequest, opts ...grpc.CallOption) (*ResumeResponse, error) {
+	out := new(ResumeResponse)
+	err := c.cc.Invoke(ctx, Management_Resume_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementClient) RefreshRules(ctx context.Context, in *RefreshRulesRequest, opts ...grpc.CallOption) (*RefreshRulesResponse, error) {
+	out := new(RefreshRulesResponse)
+	err := c.cc.Invoke(ctx, Management_RefreshRules_FullMethodName, in, out, opts...)
+	if err != nil {
+		return nil, err
+	}
+	return out, nil
+}
+
+func (c *managementClient) StreamQueries(ctx context.Context, in *StreamQueriesRequest, opts ...grpc.CallOption) (Management_StreamQueriesClient, error) {
+	stream, err := c.cc.NewStream(ctx, &Management_ServiceDesc.Streams[0], Management_StreamQueries_FullMethodName, opts...)
+	if err != nil {
+		return nil, err
+	}
+	x := &managementStreamQueriesClient{stream}
+	if err := x.ClientStream.SendMsg(in); err != nil {
+		return nil, err
+	}
+	if err := x.ClientStream.CloseSend(); err != nil {
+		return nil, err
+	}
+	return x, nil
+}
+
+type Management_StreamQueriesClient interface {
+	Recv() (*QueryEvent, error)
+	grpc.ClientStream
+}
+
+type managementStreamQueriesClient struct {
+	grpc.ClientStream
+}
+
+func (x *managementStreamQueriesClient) Recv() (*QueryEvent, error) {
+	m := new(QueryEvent)
+	if err := x.ClientStream.RecvMsg(m); err != nil {
+		return nil, err
+	}
+	return m, nil
+}
+
+// ManagementServer is the server API for Management service.
+// All implementations must embed UnimplementedManagementServer
+// for forward compatibility
+type ManagementServer interface {
+	// Protection and policy status (status:view, like GET /api/status)
+	GetStatus(context.Context, *GetStatusRequest) (*Status, error)
+	// Query counters (stats:view, like GET /api/statistics)
+	GetStatistics(context.Context, *GetStatisticsRequest) (*Statistics, error)
+	// Pause protection (protection:pause, like POST /api/pause)
+	Pause(context.Context, *PauseRequest) (*PauseResponse, error)
+	// Resume protection (protection:resume, like POST /api/resume)
+	Resume(context.Context, *ResumeRequest) (*ResumeResponse, error)
+	// Fetch and apply the enterprise rules now (rules:refresh, like
+	// POST /api/refresh-rules)
+	RefreshRules(context.Context, *RefreshRulesRequest) (*RefreshRulesResponse, error)
+	// Every query as it is answered (queries:stream, like
+	// GET /api/queries/stream)
+	StreamQueries(*StreamQueriesRequest, Management_StreamQueriesServer) error
+	mustEmbedUnimplementedManagementServer()
+}
+
+// UnimplementedManagementServer must be embedded to have forward compatible implementations.
+type UnimplementedManagementServer struct {
+}
+
+func (UnimplementedManagementServer) GetStatus(context.Context, *GetStatusRequest) (*Status, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatus not implemented")
+}
+func (UnimplementedManagementServer) GetStatistics(context.Context, *GetStatisticsRequest) (*Statistics, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method GetStatistics not implemented")
+}
+func (UnimplementedManagementServer) Pause(context.Context, *PauseRequest) (*PauseResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Pause not implemented")
+}
+func (UnimplementedManagementServer) Resume(context.Context, *ResumeRequest) (*ResumeResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method Resume not implemented")
+}
+func (UnimplementedManagementServer) RefreshRules(context.Context, *RefreshRulesRequest) (*RefreshRulesResponse, error) {
+	return nil, status.Errorf(codes.Unimplemented, "method RefreshRules not implemented")
+}
+func (UnimplementedManagementServer) StreamQueries(*StreamQueriesRequest, Management_StreamQueriesServer) error {
+	return status.Errorf(codes.Unimplemented, "method StreamQueries not implemented")
+}
+func (UnimplementedManagementServer) mustEmbedUnimplementedManagementServer() {}
+
+// UnsafeManagementServer may be embedded to opt out of forward compatibility for this service.
+// Use of this interface is not recommended, as added methods to ManagementServer will
+// result in compilation errors.
+type UnsafeManagementServer interface {
+	mustEmbedUnimplementedManagementServer()
+}
+
+func RegisterManagementServer(s grpc.ServiceRegistrar, srv ManagementServer) {
+	s.RegisterService(&Management_ServiceDesc, srv)
+}
+
+func _Management_GetStatus_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatusRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServer).GetStatus(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Management_GetStatus_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServer).GetStatus(ctx, req.(*GetStatusRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Management_GetStatistics_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(GetStatisticsRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServer).GetStatistics(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Management_GetStatistics_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServer).GetStatistics(ctx, req.(*GetStatisticsRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Management_Pause_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(PauseRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServer).Pause(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Management_Pause_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServer).Pause(ctx, req.(*PauseRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Management_Resume_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(ResumeRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServer).Resume(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Management_Resume_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServer).Resume(ctx, req.(*ResumeRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Management_RefreshRules_Handler(srv interface{}, ctx context.Context, dec func(interface{}) error, interceptor grpc.UnaryServerInterceptor) (interface{}, error) {
+	in := new(RefreshRulesRequest)
+	if err := dec(in); err != nil {
+		return nil, err
+	}
+	if interceptor == nil {
+		return srv.(ManagementServer).RefreshRules(ctx, in)
+	}
+	info := &grpc.UnaryServerInfo{
+		Server:     srv,
+		FullMethod: Management_RefreshRules_FullMethodName,
+	}
+	handler := func(ctx context.Context, req interface{}) (interface{}, error) {
+		return srv.(ManagementServer).RefreshRules(ctx, req.(*RefreshRulesRequest))
+	}
+	return interceptor(ctx, in, info, handler)
+}
+
+func _Management_StreamQueries_Handler(srv interface{}, stream grpc.ServerStream) error {
+	m := new(StreamQueriesRequest)
+	if err := stream.RecvMsg(m); err != nil {
+		return err
+	}
+	return srv.(ManagementServer).StreamQueries(m, &managementStreamQueriesServer{stream})
+}
+
+type Management_StreamQueriesServer interface {
+	Send(*QueryEvent) error
+	grpc.ServerStream
+}
+
+type managementStreamQueriesServer struct {
+	grpc.ServerStream
+}
+
+func (x *managementStreamQueriesServer) Send(m *QueryEvent) error {
+	return x.ServerStream.SendMsg(m)
+}
+
+// Management_ServiceDesc is the grpc.ServiceDesc for Management service.
+// It's only intended for direct use with grpc.RegisterService,
+// and not to be introspected or modified (even as a copy)
+var Management_ServiceDesc = grpc.ServiceDesc{
+	ServiceName: "dnshield.v1.Management",
+	HandlerType: (*ManagementServer)(nil),
+	Methods: []grpc.MethodDesc{
+		{
+			MethodName: "GetStatus",
+			Handler:    _Management_GetStatus_Handler,
+		},
+		{
+			MethodName: "GetStatistics",
+			Handler:    _Management_GetStatistics_Handler,
+		},
+		{
+			MethodName: "Pause",
+			Handler:    _Management_Pause_Handler,
+		},
+		{
+			MethodName: "Resume",
+			Handler:    _Management_Resume_Handler,
+		},
+		{
+			MethodName: "RefreshRules",
+			Handler:    _Management_RefreshRules_Handler,
+		},
+	},
+	Streams: []grpc.StreamDesc{
+		{
+			StreamName:    "StreamQueries",
+			Handler:       _Management_StreamQueries_Handler,
+			ServerStreams: true,
+		},
+	},
+	Metadata: "management.proto",
+}
@@ -0,0 +1,66 @@
+package grpcapi
+
+import (
+	"dnshield/internal/api"
+)
+
+// Conversions from the API server's types to the messages of
+// management.proto
+
+func newStatus(s api.Status) *Status {
+	return &Status{
+		Running:        s.Running,
+		Protected:      s.Protected,
+		DnsConfigured:  s.DNSConfigured,
+		Mode:           s.Mode,
+		Enforcement:    s.Enforcement,
+		PolicyEnforced: s.PolicyEnforced,
+		PolicySource:   s.PolicySource,
+		PolicySerial:   s.PolicySerial,
+		Version:        s.Version,
+		CurrentNetwork: s.CurrentNetwork,
+		UpstreamDns:    s.UpstreamDNS,
+		RulesSource:    s.RulesSource,
+		RulesStale:     s.RulesStale,
+	}
+}
+
+func newStatistics(s api.Statistics) *Statistics {
+	return &Statistics{
+		QueriesTotal:     s.QueriesTotal,
+		QueriesBlocked:   s.QueriesBlocked,
+		QueriesMonitored: s.QueriesMonitored,
+		CacheHits:        s.CacheHits,
+		CacheMisses:      s.CacheMisses,
+		CacheHitRate:     s.CacheHitRate,
+		QueriesToday:     s.QueriesToday,
+		BlockedToday:     s.BlockedToday,
+		Uptime:           s.Uptime,
+	}
+}
+
+func newRefreshRulesResponse(r *api.RuleRefreshResult) *RefreshRulesResponse {
+	return &RefreshRulesResponse{
+		BlockedDomains:  int64(r.BlockedDomains),
+		SecurityDomains: int64(r.SecurityDomains),
+		AllowedDomains:  int64(r.AllowedDomains),
+		FailedSources:   r.FailedSources,
+	}
+}
+
+func newQueryEvent(e api.QueryStreamEvent) *QueryEvent {
+	m := &QueryEvent{
+		Domain:        e.Domain,
+		QueryType:     e.QueryType,
+		Action:        e.Action,
+		Rcode:         e.Rcode,
+		ClientIp:      e.ClientIP,
+		Upstream:      e.Upstream,
+		UpstreamRttMs: e.UpstreamRTTMs,
+		DurationMs:    e.DurationMs,
+	}
+	if !e.Timestamp.IsZero() {
+		m.TimestampUnixNano = e.Timestamp.UnixNano()
+	}
+	return m
+}
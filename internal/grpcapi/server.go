@@ -0,0 +1,232 @@
+// Package grpcapi serves the management API over gRPC on a Unix domain
+// socket, for fleet tooling that wants typed clients rather than the JSON
+// API. The service is defined in management.proto; calls are authorized
+// with the same API keys and permissions as the HTTP API.
+package grpcapi
+
+//go:generate protoc --go_out=. --go_opt=paths=source_relative --go-grpc_out=. --go-grpc_opt=paths=source_relative management.proto
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"os"
+	"strings"
+	"sync"
+
+	"dnshield/internal/api"
+	"github.com/sirupsen/logrus"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const (
+	// DefaultSocketPath is where the socket is created unless configured
+	DefaultSocketPath = "/var/run/dnshield/grpc.sock"
+
+	// maxMessageSize bounds request messages
+	maxMessageSize = 64 * 1024
+)
+
+// Server serves the Management service
+type Server struct {
+	UnimplementedManagementServer
+
+	api    *api.Server
+	socket api.SocketConfig
+
+	server   *grpc.Server
+	listener net.Listener
+	done     chan struct{} // Closed on Stop to end streams
+	stopOnce sync.Once
+}
+
+// NewServer creates a server for apiServer's state on the socket at
+// socket.Path, which admits the same uids as the API socket does with
+// socket. Callers also need an API key; socket.Role is not used.
+func NewServer(apiServer *api.Server, socket api.SocketConfig) *Server {
+	if socket.Path == "" {
+		socket.Path = DefaultSocketPath
+	}
+	return &Server{
+		api:    apiServer,
+		socket: socket,
+		done:   make(chan struct{}),
+	}
+}
+
+// Listen creates the socket, replacing one left behind by an earlier run
+func (s *Server) Listen() error {
+	listener, err := api.ListenSocket(s.socket)
+	if err != nil {
+		return fmt.Errorf("failed to listen on %s: %w", s.socket.Path, err)
+	}
+
+	s.listener = listener
+	s.server = grpc.NewServer(grpc.MaxRecvMsgSize(maxMessageSize))
+	RegisterManagementServer(s.server, s)
+	return nil
+}
+
+// Serve serves calls until Stop. Listen must have succeeded.
+func (s *Server) Serve() error {
+	logrus.Infof("Starting gRPC API on %s", s.socket.Path)
+	return s.server.Serve(s.listener)
+}
+
+// Stop ends streams, waits for calls in progress and removes the socket.
+// Calls still running when ctx is done are cancelled.
+func (s *Server) Stop(ctx context.Context) error {
+	if s.server == nil {
+		return nil
+	}
+	s.stopOnce.Do(func() { close(s.done) })
+
+	stopped := make(chan struct{})
+	go func() {
+		s.server.GracefulStop()
+		close(stopped)
+	}()
+	var err error
+	select {
+	case <-stopped:
+	case <-ctx.Done():
+		s.server.Stop()
+		err = ctx.Err()
+	}
+	os.Remove(s.socket.Path)
+	return err
+}
+
+// authorize checks the API key in the call's authorization metadata. path
+// is the equivalent HTTP endpoint, which the key's scopes must include.
+func (s *Server) authorize(ctx context.Context, path string, permission api.Permission) (api.Caller, error) {
+	md, _ := metadata.FromIncomingContext(ctx)
+	values := md.Get("authorization")
+	var parts []string
+	if len(values) == 1 {
+		parts = strings.Split(values[0], " ")
+	}
+	if len(parts) != 2 || parts[0] != "Bearer" {
+		return api.Caller{}, status.Error(codes.Unauthenticated, "Missing or invalid authorization metadata")
+	}
+	role, err := s.api.Authorize(parts[1], path, permission)
+	if err != nil {
+		return api.Caller{}, statusError(err)
+	}
+	return api.Caller{Role: role, ClientIP: "unix"}, nil
+}
+
+// GetStatus implements ManagementServer
+func (s *Server) GetStatus(ctx context.Context, req *GetStatusRequest) (*Status, error) {
+	if _, err := s.authorize(ctx, "/api/status", api.PermissionViewStatus); err != nil {
+		return nil, err
+	}
+	return newStatus(s.api.Status()), nil
+}
+
+// GetStatistics implements ManagementServer
+func (s *Server) GetStatistics(ctx context.Context, req *GetStatisticsRequest) (*Statistics, error) {
+	if _, err := s.authorize(ctx, "/api/statistics", api.PermissionViewStats); err != nil {
+		return nil, err
+	}
+	return newStatistics(s.api.Statistics()), nil
+}
+
+// Pause implements ManagementServer
+func (s *Server) Pause(ctx context.Context, req *PauseRequest) (*PauseResponse, error) {
+	caller, err := s.authorize(ctx, "/api/pause", api.PermissionPauseProtection)
+	if err != nil {
+		return nil, err
+	}
+	until, err := s.api.Pause(api.PauseRequest{Duration: req.Duration, Reason: req.Reason}, caller)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return &PauseResponse{UntilUnix: until.Unix()}, nil
+}
+
+// Resume implements ManagementServer
+func (s *Server) Resume(ctx context.Context, req *ResumeRequest) (*ResumeResponse, error) {
+	caller, err := s.authorize(ctx, "/api/resume", api.PermissionResumeProtection)
+	if err != nil {
+		return nil, err
+	}
+	if err := s.api.Resume(caller); err != nil {
+		return nil, statusError(err)
+	}
+	return &ResumeResponse{}, nil
+}
+
+// RefreshRules implements ManagementServer
+func (s *Server) RefreshRules(ctx context.Context, req *RefreshRulesRequest) (*RefreshRulesResponse, error) {
+	if _, err := s.authorize(ctx, "/api/refresh-rules", api.PermissionRefreshRules); err != nil {
+		return nil, err
+	}
+	result, err := s.api.RefreshRules(ctx)
+	if err != nil {
+		return nil, statusError(err)
+	}
+	return newRefreshRulesResponse(result), nil
+}
+
+// StreamQueries implements ManagementServer. It sends every answered query
+// until the client cancels the call or the server stops.
+func (s *Server) StreamQueries(req *StreamQueriesRequest, stream Management_StreamQueriesServer) error {
+	ctx := stream.Context()
+	if _, err := s.authorize(ctx, api.QueryStreamPath, api.PermissionStreamQueries); err != nil {
+		return err
+	}
+	events, cancel, err := s.api.SubscribeQueries()
+	if err != nil {
+		return statusError(err)
+	}
+	defer cancel()
+
+	// Clients see the stream open before the first query
+	if err := stream.SendHeader(nil); err != nil {
+		return err
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return ctx.Err()
+		case <-s.done:
+			return status.Error(codes.Unavailable, "Agent is shutting down")
+		case event := <-events:
+			if err := stream.Send(newQueryEvent(event)); err != nil {
+				return err
+			}
+		}
+	}
+}
+
+// statusError reports err with the gRPC code matching its HTTP status
+func statusError(err error) error {
+	var apiErr *api.Error
+	if !errors.As(err, &apiErr) {
+		return status.Error(codes.Internal, err.Error())
+	}
+	code := codes.Internal
+	switch apiErr.Status {
+	case http.StatusBadRequest:
+		code = codes.InvalidArgument
+	case http.StatusUnauthorized:
+		code = codes.Unauthenticated
+	case http.StatusForbidden:
+		code = codes.PermissionDenied
+	case http.StatusNotFound:
+		code = codes.NotFound
+	case http.StatusTooManyRequests:
+		code = codes.ResourceExhausted
+	case http.StatusNotImplemented:
+		code = codes.Unimplemented
+	case http.StatusServiceUnavailable:
+		code = codes.Unavailable
+	}
+	return status.Error(code, apiErr.Message)
+}
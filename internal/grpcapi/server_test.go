@@ -0,0 +1,115 @@
+package grpcapi
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dnshield/internal/api"
+	"dnshield/internal/dns"
+	"google.golang.org/grpc"
+	"google.golang.org/grpc/codes"
+	"google.golang.org/grpc/credentials/insecure"
+	"google.golang.org/grpc/metadata"
+	"google.golang.org/grpc/status"
+)
+
+const testKeys = `{"keys": {
+	"viewer": {"key": "viewer-key", "role": "viewer"},
+	"operator": {"key": "operator-key", "role": "operator"}
+}}`
+
+func TestServer(t *testing.T) {
+	home := t.TempDir()
+	t.Setenv("HOME", home)
+	if err := os.MkdirAll(filepath.Join(home, ".dnshield"), 0700); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(filepath.Join(home, ".dnshield", "api_keys.json"), []byte(testKeys), 0600); err != nil {
+		t.Fatal(err)
+	}
+	apiServer := api.NewServer(nil)
+	if err := apiServer.LoadAPIKeys(); err != nil {
+		t.Fatal(err)
+	}
+	apiServer.UpdateConfig(&api.Config{AllowPause: true, MaxPauseSeconds: 900})
+
+	// Socket paths are limited to about 100 bytes, which t.TempDir can exceed
+	dir, err := os.MkdirTemp("", "grpc")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	socketPath := filepath.Join(dir, "grpc.sock")
+
+	s := NewServer(apiServer, api.SocketConfig{Path: socketPath})
+	if err := s.Listen(); err != nil {
+		t.Fatal(err)
+	}
+	go s.Serve()
+	defer s.Stop(context.Background())
+
+	conn, err := grpc.Dial("unix:"+socketPath, grpc.WithTransportCredentials(insecure.NewCredentials()))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	client := NewManagementClient(conn)
+	withKey := func(key string) context.Context {
+		ctx := context.Background()
+		if key != "" {
+			ctx = metadata.AppendToOutgoingContext(ctx, "authorization", "Bearer "+key)
+		}
+		return ctx
+	}
+
+	st, err := client.GetStatus(withKey("viewer-key"), &GetStatusRequest{})
+	if err != nil || !st.Running {
+		t.Errorf("GetStatus: %v, %+v", err, st)
+	}
+	if _, err := client.GetStatus(withKey(""), &GetStatusRequest{}); status.Code(err) != codes.Unauthenticated {
+		t.Errorf("GetStatus without a key: %v, want Unauthenticated", err)
+	}
+	if _, err := client.Pause(withKey("viewer-key"), &PauseRequest{Duration: "5m", Reason: "Demo"}); status.Code(err) != codes.PermissionDenied {
+		t.Errorf("Pause as viewer: %v, want PermissionDenied", err)
+	}
+	if _, err := client.Pause(withKey("operator-key"), &PauseRequest{Duration: "5m"}); status.Code(err) != codes.InvalidArgument {
+		t.Errorf("Pause without a reason: %v, want InvalidArgument", err)
+	}
+	err = conn.Invoke(withKey("operator-key"), "/dnshield.v1.Management/Delete", &ResumeRequest{}, &ResumeResponse{})
+	if status.Code(err) != codes.Unimplemented {
+		t.Errorf("Unknown method: %v, want Unimplemented", err)
+	}
+
+	paused, err := client.Pause(withKey("operator-key"), &PauseRequest{Duration: "5m", Reason: "Vendor demo"})
+	if err != nil || paused.UntilUnix <= time.Now().Unix() {
+		t.Fatalf("Pause: %v, %+v", err, paused)
+	}
+	if _, err := client.Resume(withKey("operator-key"), &ResumeRequest{}); err != nil {
+		t.Errorf("Resume: %v", err)
+	}
+
+	ctx, cancel := context.WithTimeout(withKey("operator-key"), 5*time.Second)
+	defer cancel()
+	stream, err := client.StreamQueries(ctx, &StreamQueriesRequest{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	// The stream subscribes before its headers are sent
+	if _, err := stream.Header(); err != nil {
+		t.Fatal(err)
+	}
+	apiServer.RecordQuery(dns.QueryEvent{Timestamp: time.Now(), Domain: "example.com", QueryType: "A", Action: "allowed"})
+	event, err := stream.Recv()
+	if err != nil || event.Domain != "example.com" || event.QueryType != "A" || event.TimestampUnixNano == 0 {
+		t.Errorf("Unexpected stream event %+v (%v)", event, err)
+	}
+
+	// Stopping the server ends the stream
+	s.Stop(context.Background())
+	if _, err := stream.Recv(); status.Code(err) != codes.Unavailable {
+		t.Errorf("stream after Stop: %v, want Unavailable", err)
+	}
+}
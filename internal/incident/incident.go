@@ -0,0 +1,333 @@
+// Package incident opens tickets in the SOC's ticketing system when a
+// device repeatedly hits security-critical (malware/C2) blocks.
+package incident
+
+import (
+	"fmt"
+	"net"
+	"os"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// TokenEnv overrides incident.token from the configuration file
+	TokenEnv = "DNSHIELD_INCIDENT_TOKEN"
+
+	// queueSize bounds reports waiting for the ticketing system
+	queueSize = 100
+	// requestTimeout bounds each call to the ticketing system
+	requestTimeout = 30 * time.Second
+)
+
+// Ticketer creates and updates tickets in a ticketing system
+type Ticketer interface {
+	// Create opens a ticket and returns its identifier
+	Create(report *Report) (string, error)
+	// Update adds report to an existing ticket
+	Update(id string, report *Report) error
+}
+
+// DomainCount is a blocked domain and how often it was hit
+type DomainCount struct {
+	Domain string
+	Count  int
+}
+
+// Report describes the blocks that triggered or updated a ticket
+type Report struct {
+	Device    string
+	ClientIP  string
+	User      string
+	Group     string
+	Domains   []DomainCount // Most hit first
+	Count     int
+	FirstSeen time.Time
+	LastSeen  time.Time
+}
+
+// Summary returns a one-line ticket title
+func (r *Report) Summary() string {
+	return fmt.Sprintf("DNShield: %d malware/C2 blocks on %s", r.Count, r.Device)
+}
+
+// Description returns the ticket body
+func (r *Report) Description() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "Device: %s\n", r.Device)
+	if r.ClientIP != "" {
+		fmt.Fprintf(&b, "Client IP: %s\n", r.ClientIP)
+	}
+	if r.User != "" {
+		fmt.Fprintf(&b, "User: %s\n", r.User)
+	}
+	if r.Group != "" {
+		fmt.Fprintf(&b, "Group: %s\n", r.Group)
+	}
+	fmt.Fprintf(&b, "Blocks: %d between %s and %s\n\n", r.Count,
+		r.FirstSeen.UTC().Format(time.RFC3339), r.LastSeen.UTC().Format(time.RFC3339))
+	b.WriteString("Domains:\n")
+	for _, d := range r.Domains {
+		fmt.Fprintf(&b, "  %s (%d)\n", d.Domain, d.Count)
+	}
+	return b.String()
+}
+
+type hit struct {
+	at     time.Time
+	domain string
+	user   string
+	group  string
+}
+
+type ticket struct {
+	id      string
+	updated time.Time
+}
+
+type job struct {
+	key    string
+	report *Report
+}
+
+// Tracker counts security blocks per device and files tickets through a
+// Ticketer. Ticketing calls happen on a background worker so blocking
+// queries never wait on the ticketing system.
+type Tracker struct {
+	ticketer     Ticketer
+	categories   map[string]bool
+	threshold    int
+	window       time.Duration
+	dedupeWindow time.Duration
+	maxPerHour   int
+	hostname     string
+
+	mu      sync.Mutex
+	hits    map[string][]hit   // Keyed by client IP
+	tickets map[string]*ticket // Open tickets by client IP
+	pending map[string]bool    // Reports queued but not yet filed
+	calls   []time.Time        // Ticketing calls in the last hour
+
+	queue      chan job
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// New creates a tracker for cfg using the configured provider
+func New(cfg *config.IncidentConfig) (*Tracker, error) {
+	token := cfg.Token
+	if env := os.Getenv(TokenEnv); env != "" {
+		token = env
+	}
+
+	var ticketer Ticketer
+	switch cfg.Provider {
+	case "servicenow":
+		ticketer = NewServiceNow(cfg.URL, cfg.Username, token, cfg.AssignmentGroup)
+	case "jira":
+		ticketer = NewJira(cfg.URL, cfg.Username, token, cfg.Project, cfg.IssueType)
+	default:
+		return nil, fmt.Errorf("unsupported incident provider: %q", cfg.Provider)
+	}
+
+	return NewTracker(cfg, ticketer), nil
+}
+
+// NewTracker creates a tracker that files tickets through ticketer
+func NewTracker(cfg *config.IncidentConfig, ticketer Ticketer) *Tracker {
+	categories := make(map[string]bool)
+	for _, c := range cfg.Categories {
+		categories[c] = true
+	}
+	hostname, _ := os.Hostname()
+
+	t := &Tracker{
+		ticketer:     ticketer,
+		categories:   categories,
+		threshold:    cfg.Threshold,
+		window:       cfg.Window,
+		dedupeWindow: cfg.DedupeWindow,
+		maxPerHour:   cfg.MaxTicketsPerHour,
+		hostname:     hostname,
+		hits:         make(map[string][]hit),
+		tickets:      make(map[string]*ticket),
+		pending:      make(map[string]bool),
+		queue:        make(chan job, queueSize),
+		shutdownCh:   make(chan struct{}),
+	}
+
+	t.wg.Add(1)
+	go t.worker()
+
+	return t
+}
+
+// Record counts a block and queues a ticket once the device reaches the
+// threshold within the window
+func (t *Tracker) Record(event dns.BlockEvent) {
+	if !t.categories[event.Category] {
+		return
+	}
+
+	key := event.ClientIP
+	now := event.Timestamp
+	if now.IsZero() {
+		now = time.Now()
+	}
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	hits := t.hits[key]
+	cutoff := now.Add(-t.window)
+	for len(hits) > 0 && hits[0].at.Before(cutoff) {
+		hits = hits[1:]
+	}
+	hits = append(hits, hit{at: now, domain: event.Domain, user: event.User, group: event.Group})
+	t.hits[key] = hits
+
+	if len(hits) < t.threshold || t.pending[key] {
+		return
+	}
+	if !t.allowCall(now) {
+		logrus.WithField("client_ip", key).Warn("Incident ticket rate limit reached, deferring ticket")
+		return
+	}
+
+	report := t.report(key, hits)
+	t.hits[key] = nil
+	t.pending[key] = true
+
+	select {
+	case t.queue <- job{key: key, report: report}:
+	default:
+		delete(t.pending, key)
+		logrus.Warn("Incident queue full, dropping ticket")
+	}
+}
+
+// Stop stops the worker. Reports still queued are not filed.
+func (t *Tracker) Stop() {
+	close(t.shutdownCh)
+	t.wg.Wait()
+}
+
+// allowCall reports whether another ticketing call fits in the hourly
+// budget and, if so, reserves it. Callers must hold t.mu.
+func (t *Tracker) allowCall(now time.Time) bool {
+	cutoff := now.Add(-time.Hour)
+	calls := t.calls[:0]
+	for _, c := range t.calls {
+		if c.After(cutoff) {
+			calls = append(calls, c)
+		}
+	}
+	t.calls = calls
+
+	if len(t.calls) >= t.maxPerHour {
+		return false
+	}
+	t.calls = append(t.calls, now)
+	return true
+}
+
+// report summarizes hits for the device at key
+func (t *Tracker) report(key string, hits []hit) *Report {
+	r := &Report{
+		Device:    t.hostname,
+		Count:     len(hits),
+		FirstSeen: hits[0].at,
+		LastSeen:  hits[len(hits)-1].at,
+	}
+	if ip := net.ParseIP(key); ip != nil && !ip.IsLoopback() {
+		r.ClientIP = key
+		r.Device = fmt.Sprintf("%s (client %s)", t.hostname, key)
+	}
+
+	counts := make(map[string]int)
+	for _, h := range hits {
+		counts[h.domain]++
+		if h.user != "" {
+			r.User = h.user
+		}
+		if h.group != "" {
+			r.Group = h.group
+		}
+	}
+	for domain, count := range counts {
+		r.Domains = append(r.Domains, DomainCount{Domain: domain, Count: count})
+	}
+	sort.Slice(r.Domains, func(i, j int) bool {
+		if r.Domains[i].Count != r.Domains[j].Count {
+			return r.Domains[i].Count > r.Domains[j].Count
+		}
+		return r.Domains[i].Domain < r.Domains[j].Domain
+	})
+	return r
+}
+
+// worker files queued reports, updating the device's open ticket when it
+// is still within the dedupe window
+func (t *Tracker) worker() {
+	defer t.wg.Done()
+
+	for {
+		select {
+		case <-t.shutdownCh:
+			return
+		case j := <-t.queue:
+			t.file(j)
+		}
+	}
+}
+
+func (t *Tracker) file(j job) {
+	now := time.Now()
+
+	t.mu.Lock()
+	open := t.tickets[j.key]
+	if open != nil && now.Sub(open.updated) > t.dedupeWindow {
+		delete(t.tickets, j.key)
+		open = nil
+	}
+	t.mu.Unlock()
+
+	fields := logrus.Fields{
+		"device":  j.report.Device,
+		"blocks":  j.report.Count,
+		"domains": len(j.report.Domains),
+	}
+
+	var err error
+	id := ""
+	if open != nil {
+		id = open.id
+		err = t.ticketer.Update(id, j.report)
+	} else {
+		id, err = t.ticketer.Create(j.report)
+	}
+
+	t.mu.Lock()
+	delete(t.pending, j.key)
+	if err == nil {
+		t.tickets[j.key] = &ticket{id: id, updated: now}
+	}
+	t.mu.Unlock()
+
+	fields["ticket"] = id
+	if err != nil {
+		logrus.WithError(err).WithFields(fields).Error("Failed to file incident ticket")
+		return
+	}
+	if open != nil {
+		logrus.WithFields(fields).Info("Updated incident ticket")
+	} else {
+		logrus.WithFields(fields).Warn("Opened incident ticket")
+	}
+}
@@ -0,0 +1,113 @@
+package incident
+
+import (
+	"fmt"
+	"sync"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+)
+
+type fakeTicketer struct {
+	mu      sync.Mutex
+	created []*Report
+	updated map[string][]*Report
+	done    chan struct{}
+}
+
+func newFakeTicketer() *fakeTicketer {
+	return &fakeTicketer{updated: make(map[string][]*Report), done: make(chan struct{}, 10)}
+}
+
+func (f *fakeTicketer) Create(report *Report) (string, error) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.created = append(f.created, report)
+	f.done <- struct{}{}
+	return fmt.Sprintf("INC%d", len(f.created)), nil
+}
+
+func (f *fakeTicketer) Update(id string, report *Report) error {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.updated[id] = append(f.updated[id], report)
+	f.done <- struct{}{}
+	return nil
+}
+
+func (f *fakeTicketer) wait(t *testing.T) {
+	t.Helper()
+	select {
+	case <-f.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("timed out waiting for ticket")
+	}
+}
+
+func TestTrackerThresholdAndDedupe(t *testing.T) {
+	fake := newFakeTicketer()
+	tracker := NewTracker(&config.IncidentConfig{
+		Categories:        []string{dns.CategorySecurity},
+		Threshold:         3,
+		Window:            time.Minute,
+		DedupeWindow:      time.Hour,
+		MaxTicketsPerHour: 2,
+	}, fake)
+	defer tracker.Stop()
+
+	now := time.Now()
+	block := func(domain, category string, at time.Time) {
+		tracker.Record(dns.BlockEvent{
+			Timestamp: at,
+			Domain:    domain,
+			ClientIP:  "192.168.1.20",
+			User:      "alice@example.com",
+			Category:  category,
+		})
+	}
+
+	// Other categories and hits outside the window don't count
+	block("ads.example.com", dns.CategoryBlocklist, now)
+	block("c2.example.net", dns.CategorySecurity, now.Add(-5*time.Minute))
+	block("c2.example.net", dns.CategorySecurity, now)
+	block("c2.example.net", dns.CategorySecurity, now)
+	block("drop.example.org", dns.CategorySecurity, now)
+	fake.wait(t)
+
+	fake.mu.Lock()
+	if len(fake.created) != 1 {
+		t.Fatalf("created %d tickets, want 1", len(fake.created))
+	}
+	r := fake.created[0]
+	fake.mu.Unlock()
+	if r.Count != 3 || r.User != "alice@example.com" || r.ClientIP != "192.168.1.20" {
+		t.Errorf("report = %+v", r)
+	}
+	if len(r.Domains) != 2 || r.Domains[0] != (DomainCount{"c2.example.net", 2}) {
+		t.Errorf("domains = %+v, want c2.example.net first with 2 hits", r.Domains)
+	}
+
+	// The next burst updates the open ticket instead of opening another
+	for i := 0; i < 3; i++ {
+		block("c2.example.net", dns.CategorySecurity, now.Add(time.Second))
+	}
+	fake.wait(t)
+
+	fake.mu.Lock()
+	if len(fake.created) != 1 || len(fake.updated["INC1"]) != 1 {
+		t.Errorf("created %d, updated %d; want 1 and 1", len(fake.created), len(fake.updated["INC1"]))
+	}
+	fake.mu.Unlock()
+
+	// The hourly budget (2) is spent, so a third burst is deferred
+	for i := 0; i < 3; i++ {
+		block("c2.example.net", dns.CategorySecurity, now.Add(2*time.Second))
+	}
+	select {
+	case <-fake.done:
+		t.Error("ticket filed beyond the hourly limit")
+	case <-time.After(100 * time.Millisecond):
+	}
+}
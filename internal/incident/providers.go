@@ -0,0 +1,163 @@
+package incident
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"net/url"
+	"strings"
+)
+
+// ServiceNow files incidents through the Table API
+type ServiceNow struct {
+	baseURL         string
+	username        string
+	password        string
+	assignmentGroup string
+	client          *http.Client
+}
+
+// NewServiceNow creates a ServiceNow ticketer for the instance at baseURL
+func NewServiceNow(baseURL, username, password, assignmentGroup string) *ServiceNow {
+	return &ServiceNow{
+		baseURL:         strings.TrimSuffix(baseURL, "/"),
+		username:        username,
+		password:        password,
+		assignmentGroup: assignmentGroup,
+		client:          &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Create opens an incident and returns its sys_id
+func (s *ServiceNow) Create(report *Report) (string, error) {
+	body := map[string]string{
+		"short_description": report.Summary(),
+		"description":       report.Description(),
+		"category":          "security",
+		"impact":            "2",
+		"urgency":           "2",
+	}
+	if s.assignmentGroup != "" {
+		body["assignment_group"] = s.assignmentGroup
+	}
+
+	var resp struct {
+		Result struct {
+			SysID  string `json:"sys_id"`
+			Number string `json:"number"`
+		} `json:"result"`
+	}
+	if err := doJSON(s.client, http.MethodPost, s.baseURL+"/api/now/table/incident", s.username, s.password, body, &resp); err != nil {
+		return "", fmt.Errorf("failed to create ServiceNow incident: %v", err)
+	}
+	if resp.Result.SysID == "" {
+		return "", fmt.Errorf("ServiceNow response missing sys_id")
+	}
+	return resp.Result.SysID, nil
+}
+
+// Update adds the report to the incident's work notes
+func (s *ServiceNow) Update(id string, report *Report) error {
+	body := map[string]string{
+		"work_notes": report.Summary() + "\n\n" + report.Description(),
+	}
+	endpoint := s.baseURL + "/api/now/table/incident/" + url.PathEscape(id)
+	if err := doJSON(s.client, http.MethodPatch, endpoint, s.username, s.password, body, nil); err != nil {
+		return fmt.Errorf("failed to update ServiceNow incident %s: %v", id, err)
+	}
+	return nil
+}
+
+// Jira files issues through the REST API (v2)
+type Jira struct {
+	baseURL   string
+	username  string
+	token     string
+	project   string
+	issueType string
+	client    *http.Client
+}
+
+// NewJira creates a Jira ticketer for the site at baseURL
+func NewJira(baseURL, username, token, project, issueType string) *Jira {
+	if issueType == "" {
+		issueType = "Task"
+	}
+	return &Jira{
+		baseURL:   strings.TrimSuffix(baseURL, "/"),
+		username:  username,
+		token:     token,
+		project:   project,
+		issueType: issueType,
+		client:    &http.Client{Timeout: requestTimeout},
+	}
+}
+
+// Create opens an issue and returns its key
+func (j *Jira) Create(report *Report) (string, error) {
+	body := map[string]interface{}{
+		"fields": map[string]interface{}{
+			"project":     map[string]string{"key": j.project},
+			"issuetype":   map[string]string{"name": j.issueType},
+			"summary":     report.Summary(),
+			"description": report.Description(),
+			"labels":      []string{"dnshield", "malware"},
+		},
+	}
+
+	var resp struct {
+		Key string `json:"key"`
+	}
+	if err := doJSON(j.client, http.MethodPost, j.baseURL+"/rest/api/2/issue", j.username, j.token, body, &resp); err != nil {
+		return "", fmt.Errorf("failed to create Jira issue: %v", err)
+	}
+	if resp.Key == "" {
+		return "", fmt.Errorf("Jira response missing issue key")
+	}
+	return resp.Key, nil
+}
+
+// Update comments on the issue with the report
+func (j *Jira) Update(id string, report *Report) error {
+	body := map[string]string{
+		"body": report.Summary() + "\n\n" + report.Description(),
+	}
+	endpoint := j.baseURL + "/rest/api/2/issue/" + url.PathEscape(id) + "/comment"
+	if err := doJSON(j.client, http.MethodPost, endpoint, j.username, j.token, body, nil); err != nil {
+		return fmt.Errorf("failed to comment on Jira issue %s: %v", id, err)
+	}
+	return nil
+}
+
+// doJSON sends in as JSON with basic auth and decodes the response into out
+func doJSON(client *http.Client, method, endpoint, username, password string, in, out interface{}) error {
+	data, err := json.Marshal(in)
+	if err != nil {
+		return err
+	}
+
+	req, err := http.NewRequest(method, endpoint, bytes.NewReader(data))
+	if err != nil {
+		return err
+	}
+	req.SetBasicAuth(username, password)
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Accept", "application/json")
+
+	resp, err := client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode < 200 || resp.StatusCode >= 300 {
+		msg, _ := io.ReadAll(io.LimitReader(resp.Body, 512))
+		return fmt.Errorf("%s: %s", resp.Status, strings.TrimSpace(string(msg)))
+	}
+	if out == nil {
+		return nil
+	}
+	return json.NewDecoder(resp.Body).Decode(out)
+}
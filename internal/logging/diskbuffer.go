@@ -0,0 +1,446 @@
+package logging
+
+import (
+	"bufio"
+	"encoding/json"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+
+	"dnshield/internal/audit"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// diskSegmentSize is the size at which a new segment file is started
+	diskSegmentSize = 4 << 20
+	diskSegmentExt  = ".seg"
+	diskCursorFile  = "cursor"
+)
+
+// DiskBuffer is a write-ahead buffer of audit events on disk, kept as
+// numbered segment files of newline-delimited JSON. Events are read back
+// in order from a persisted cursor and a segment is deleted once it has
+// been read and committed. When the buffer grows past its cap the oldest
+// segments are deleted, so the newest events survive a long outage.
+type DiskBuffer struct {
+	dir         string
+	maxBytes    int64
+	segmentSize int64
+
+	mu       sync.Mutex
+	segments []uint64         // Oldest first
+	sizes    map[uint64]int64 // Bytes in each segment
+	total    int64
+	active   *os.File // Segment being appended to, opened on first append
+	activeID uint64
+	cursor   DiskPosition
+	dropped  int64 // Bytes of unread events deleted by the cap
+}
+
+// DiskPosition is a place in a DiskBuffer: a segment and a byte offset
+type DiskPosition struct {
+	Segment uint64
+	Offset  int64
+}
+
+// OpenDiskBuffer opens or creates the buffer in dir. Events left from a
+// previous run are kept, to be read from the persisted cursor.
+func OpenDiskBuffer(dir string, maxBytes int64) (*DiskBuffer, error) {
+	dir = expandHome(dir)
+	if err := os.MkdirAll(dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create buffer directory: %w", err)
+	}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return nil, err
+	}
+
+	b := &DiskBuffer{dir: dir, maxBytes: maxBytes, segmentSize: diskSegmentSize, sizes: make(map[uint64]int64)}
+	for _, entry := range entries {
+		name := entry.Name()
+		if !strings.HasSuffix(name, diskSegmentExt) {
+			continue
+		}
+		id, err := strconv.ParseUint(strings.TrimSuffix(name, diskSegmentExt), 10, 64)
+		if err != nil {
+			continue
+		}
+		info, err := entry.Info()
+		if err != nil {
+			continue
+		}
+		b.segments = append(b.segments, id)
+		b.sizes[id] = info.Size()
+		b.total += info.Size()
+	}
+	sort.Slice(b.segments, func(i, j int) bool { return b.segments[i] < b.segments[j] })
+	if n := len(b.segments); n > 0 {
+		// Appends start a fresh segment rather than follow a line a crash
+		// may have cut short
+		b.activeID = b.segments[n-1] + 1
+	} else {
+		b.activeID = 1
+	}
+
+	b.cursor = b.readCursor()
+	return b, nil
+}
+
+// Append writes events to the end of the buffer and syncs them to disk
+func (b *DiskBuffer) Append(events []audit.Event) error {
+	if len(events) == 0 {
+		return nil
+	}
+	var data []byte
+	for _, event := range events {
+		line, err := json.Marshal(event)
+		if err != nil {
+			continue
+		}
+		data = append(data, line...)
+		data = append(data, '\n')
+	}
+
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.active != nil && b.sizes[b.activeID]+int64(len(data)) > b.segmentSize && b.sizes[b.activeID] > 0 {
+		b.active.Close()
+		b.active = nil
+		b.activeID++
+	}
+	if b.active == nil {
+		f, err := os.OpenFile(b.segmentPath(b.activeID), os.O_CREATE|os.O_WRONLY|os.O_APPEND, 0600)
+		if err != nil {
+			return err
+		}
+		b.active = f
+		if _, ok := b.sizes[b.activeID]; !ok {
+			b.segments = append(b.segments, b.activeID)
+			b.sizes[b.activeID] = 0
+		}
+	}
+
+	n, err := b.active.Write(data)
+	b.sizes[b.activeID] += int64(n)
+	b.total += int64(n)
+	if err == nil {
+		err = b.active.Sync()
+	}
+	b.enforceCap()
+	return err
+}
+
+// enforceCap deletes the oldest segments until the buffer fits its cap.
+// The segment being appended to is kept.
+func (b *DiskBuffer) enforceCap() {
+	for b.total > b.maxBytes && len(b.segments) > 1 {
+		oldest := b.segments[0]
+		unread := b.sizes[oldest]
+		if oldest == b.cursor.Segment {
+			unread -= b.cursor.Offset
+		} else if oldest < b.cursor.Segment {
+			unread = 0
+		}
+		b.dropped += unread
+		logrus.WithFields(logrus.Fields{
+			"segment": oldest,
+			"bytes":   unread,
+		}).Warn("Audit fallback buffer full, dropping oldest events")
+		b.removeSegment(oldest)
+		if b.cursor.Segment <= oldest {
+			b.cursor = DiskPosition{Segment: b.segments[0]}
+		}
+	}
+}
+
+// Read returns up to max events from the cursor and the position after
+// them, without moving the cursor. Lines that don't parse, such as one cut
+// short by a crash, are skipped.
+func (b *DiskBuffer) Read(max int) ([]audit.Event, DiskPosition, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var events []audit.Event
+	pos := b.cursor
+	for _, id := range b.segments {
+		if id < pos.Segment {
+			continue
+		}
+		if id > pos.Segment {
+			pos = DiskPosition{Segment: id}
+		}
+		if pos.Offset >= b.sizes[id] {
+			continue
+		}
+
+		f, err := os.Open(b.segmentPath(id))
+		if err != nil {
+			return events, pos, err
+		}
+		if _, err := f.Seek(pos.Offset, io.SeekStart); err != nil {
+			f.Close()
+			return events, pos, err
+		}
+		r := bufio.NewReader(io.LimitReader(f, b.sizes[id]-pos.Offset))
+		for len(events) < max {
+			line, err := r.ReadBytes('\n')
+			if err != nil {
+				// A partial line at the end of a finished segment will
+				// never complete
+				if len(line) > 0 && id != b.activeID {
+					pos.Offset += int64(len(line))
+				}
+				break
+			}
+			pos.Offset += int64(len(line))
+			var event audit.Event
+			if json.Unmarshal(line, &event) == nil {
+				events = append(events, event)
+			}
+		}
+		f.Close()
+		if len(events) >= max {
+			break
+		}
+	}
+	return events, pos, nil
+}
+
+// Commit moves the cursor to pos after the events before it were
+// delivered, deleting segments that have been read completely
+func (b *DiskBuffer) Commit(pos DiskPosition) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if pos.Segment < b.cursor.Segment || (pos.Segment == b.cursor.Segment && pos.Offset < b.cursor.Offset) {
+		return nil // The cap moved the cursor past pos meanwhile
+	}
+	b.cursor = pos
+	for len(b.segments) > 0 {
+		oldest := b.segments[0]
+		done := oldest < pos.Segment || (oldest == pos.Segment && pos.Offset >= b.sizes[oldest])
+		if !done || (oldest == b.activeID && b.active != nil) {
+			break
+		}
+		b.removeSegment(oldest)
+	}
+	return b.writeCursor()
+}
+
+// Empty reports whether every buffered event has been committed
+func (b *DiskBuffer) Empty() bool {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	for _, id := range b.segments {
+		if id > b.cursor.Segment || (id == b.cursor.Segment && b.cursor.Offset < b.sizes[id]) {
+			return false
+		}
+	}
+	return true
+}
+
+// Stats returns the bytes on disk and the bytes of unread events dropped
+// because the buffer was full
+func (b *DiskBuffer) Stats() (size, dropped int64) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+	return b.total, b.dropped
+}
+
+// Purge deletes the unread events match selects and returns how many were
+// deleted. Segments left empty are removed.
+func (b *DiskBuffer) Purge(match func(audit.Event) bool) (int, error) {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	purged := 0
+	for _, id := range append([]uint64(nil), b.segments...) {
+		start := int64(0)
+		if id < b.cursor.Segment {
+			continue
+		} else if id == b.cursor.Segment {
+			start = b.cursor.Offset
+		}
+		if start >= b.sizes[id] {
+			continue
+		}
+		n, err := b.purgeSegment(id, start, match)
+		purged += n
+		if err != nil {
+			return purged, err
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+	return purged, b.writeCursor()
+}
+
+// purgeSegment rewrites segment id from start without the events match
+// selects. The bytes before start have been read, so they are dropped too.
+func (b *DiskBuffer) purgeSegment(id uint64, start int64, match func(audit.Event) bool) (int, error) {
+	f, err := os.Open(b.segmentPath(id))
+	if err != nil {
+		return 0, err
+	}
+	defer f.Close()
+	if _, err := f.Seek(start, io.SeekStart); err != nil {
+		return 0, err
+	}
+
+	var kept []byte
+	purged := 0
+	r := bufio.NewReader(io.LimitReader(f, b.sizes[id]-start))
+	for {
+		line, err := r.ReadBytes('\n')
+		if len(line) > 0 {
+			var event audit.Event
+			if json.Unmarshal(line, &event) == nil && match(event) {
+				purged++
+			} else {
+				kept = append(kept, line...)
+			}
+		}
+		if err == io.EOF {
+			break
+		}
+		if err != nil {
+			return 0, err
+		}
+	}
+	if purged == 0 {
+		return 0, nil
+	}
+
+	if id == b.activeID && b.active != nil {
+		// Appends continue in a new segment
+		b.active.Close()
+		b.active = nil
+		b.activeID++
+	}
+	if id == b.cursor.Segment {
+		b.cursor.Offset = 0
+	}
+	if len(kept) == 0 {
+		b.removeSegment(id)
+		return purged, nil
+	}
+	tmp := b.segmentPath(id) + ".tmp"
+	if err := os.WriteFile(tmp, kept, 0600); err != nil {
+		return 0, err
+	}
+	if err := os.Rename(tmp, b.segmentPath(id)); err != nil {
+		os.Remove(tmp)
+		return 0, err
+	}
+	b.total += int64(len(kept)) - b.sizes[id]
+	b.sizes[id] = int64(len(kept))
+	return purged, nil
+}
+
+// Close closes the segment being appended to
+func (b *DiskBuffer) Close() error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	if b.active == nil {
+		return nil
+	}
+	err := b.active.Close()
+	b.active = nil
+	return err
+}
+
+func (b *DiskBuffer) removeSegment(id uint64) {
+	if id == b.activeID && b.active != nil {
+		b.active.Close()
+		b.active = nil
+		b.activeID++
+	}
+	if err := os.Remove(b.segmentPath(id)); err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).Debug("Failed to remove audit buffer segment")
+	}
+	b.total -= b.sizes[id]
+	delete(b.sizes, id)
+	for i, segment := range b.segments {
+		if segment == id {
+			b.segments = append(b.segments[:i], b.segments[i+1:]...)
+			break
+		}
+	}
+}
+
+// PurgeDiskBuffer deletes the unread events match selects from the buffer
+// in dir, if there is one, for when no logger has it open
+func PurgeDiskBuffer(dir string, match func(audit.Event) bool) (int, error) {
+	if dir == "" {
+		return 0, nil
+	}
+	if _, err := os.Stat(expandHome(dir)); os.IsNotExist(err) {
+		return 0, nil
+	}
+	// The cap only applies to appends
+	b, err := OpenDiskBuffer(dir, 1<<62)
+	if err != nil {
+		return 0, err
+	}
+	defer b.Close()
+	return b.Purge(match)
+}
+
+func (b *DiskBuffer) segmentPath(id uint64) string {
+	return filepath.Join(b.dir, fmt.Sprintf("%020d%s", id, diskSegmentExt))
+}
+
+// readCursor loads the persisted cursor, falling back to the oldest
+// segment when it is missing or points at a deleted segment
+func (b *DiskBuffer) readCursor() DiskPosition {
+	start := DiskPosition{}
+	if len(b.segments) > 0 {
+		start.Segment = b.segments[0]
+	}
+	data, err := os.ReadFile(filepath.Join(b.dir, diskCursorFile))
+	if err != nil {
+		return start
+	}
+	var pos DiskPosition
+	if _, err := fmt.Sscanf(string(data), "%d %d", &pos.Segment, &pos.Offset); err != nil {
+		return start
+	}
+	if _, ok := b.sizes[pos.Segment]; !ok || pos.Segment < start.Segment {
+		return start
+	}
+	return pos
+}
+
+// writeCursor persists the cursor, replacing the file atomically
+func (b *DiskBuffer) writeCursor() error {
+	path := filepath.Join(b.dir, diskCursorFile)
+	tmp := path + ".tmp"
+	data := fmt.Sprintf("%d %d\n", b.cursor.Segment, b.cursor.Offset)
+	if err := os.WriteFile(tmp, []byte(data), 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// expandHome replaces a leading ~/ in path with the user's home directory
+func expandHome(path string) string {
+	if !strings.HasPrefix(path, "~/") {
+		return path
+	}
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return path
+	}
+	return filepath.Join(home, path[2:])
+}
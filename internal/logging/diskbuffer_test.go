@@ -0,0 +1,171 @@
+package logging
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+)
+
+func testEvents(messages ...string) []audit.Event {
+	var events []audit.Event
+	for _, m := range messages {
+		events = append(events, audit.Event{Type: audit.EventDomainBlocked, Message: m, Timestamp: time.Now()})
+	}
+	return events
+}
+
+func TestDiskBufferSurvivesRestart(t *testing.T) {
+	dir := t.TempDir()
+	b, err := OpenDiskBuffer(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := b.Append(testEvents("one", "two", "three")); err != nil {
+		t.Fatal(err)
+	}
+	events, pos, err := b.Read(2)
+	if err != nil || len(events) != 2 || events[0].Message != "one" {
+		t.Fatalf("Read = %v, %v", events, err)
+	}
+	if err := b.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	b.Close()
+
+	// The committed events aren't read again after a restart
+	b, err = OpenDiskBuffer(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	events, pos, err = b.Read(10)
+	if err != nil || len(events) != 1 || events[0].Message != "three" {
+		t.Fatalf("Read after reopening = %v, %v", events, err)
+	}
+	if err := b.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+	if !b.Empty() {
+		t.Error("Buffer not empty after committing every event")
+	}
+}
+
+func TestDiskBufferCapDropsOldest(t *testing.T) {
+	b, err := OpenDiskBuffer(t.TempDir(), 1000)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	b.segmentSize = 200
+	for i := 0; i < 20; i++ {
+		if err := b.Append(testEvents(strings.Repeat("x", 50))); err != nil {
+			t.Fatal(err)
+		}
+	}
+	b.Append(testEvents("newest"))
+
+	size, dropped := b.Stats()
+	if size > 1000+200 || dropped == 0 {
+		t.Errorf("Stats = %d bytes, %d dropped; want the cap enforced", size, dropped)
+	}
+	events, _, err := b.Read(100)
+	if err != nil || len(events) == 0 || events[len(events)-1].Message != "newest" {
+		t.Errorf("Newest event not kept: %v, %v", events, err)
+	}
+}
+
+func TestDiskBufferPurge(t *testing.T) {
+	b, err := OpenDiskBuffer(t.TempDir(), 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer b.Close()
+	if err := b.Append(testEvents("one", "jane", "two")); err != nil {
+		t.Fatal(err)
+	}
+	_, pos, _ := b.Read(1)
+	if err := b.Commit(pos); err != nil {
+		t.Fatal(err)
+	}
+
+	n, err := b.Purge(func(e audit.Event) bool { return e.Message == "jane" })
+	if err != nil || n != 1 {
+		t.Fatalf("Purge = %d, %v; want 1", n, err)
+	}
+	if err := b.Append(testEvents("three")); err != nil {
+		t.Fatal(err)
+	}
+	events, _, err := b.Read(10)
+	if err != nil || len(events) != 2 || events[0].Message != "two" || events[1].Message != "three" {
+		t.Fatalf("Read after purge = %v, %v", events, err)
+	}
+
+	// Purging everything left leaves nothing to deliver
+	if n, err := b.Purge(func(audit.Event) bool { return true }); err != nil || n != 2 {
+		t.Fatalf("Purge = %d, %v; want 2", n, err)
+	}
+	if !b.Empty() {
+		t.Error("Buffer not empty after purging every event")
+	}
+}
+
+func TestRemoteLoggerDrainsFallback(t *testing.T) {
+	var mu sync.Mutex
+	var received []string
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		dec := json.NewDecoder(r.Body)
+		for {
+			var event SplunkEvent
+			if dec.Decode(&event) != nil {
+				break
+			}
+			mu.Lock()
+			received = append(received, event.Event["message"].(string))
+			mu.Unlock()
+		}
+	}))
+	defer server.Close()
+
+	// Events left on disk by a previous run during an outage
+	dir := t.TempDir()
+	b, err := OpenDiskBuffer(dir, 1<<20)
+	if err != nil {
+		t.Fatal(err)
+	}
+	b.Append(testEvents("old-1", "old-2"))
+	b.Close()
+
+	rl, err := NewRemoteLogger(&config.LoggingConfig{
+		Splunk: config.SplunkConfig{Enabled: true, Endpoint: server.URL},
+		Local:  config.LocalConfig{BufferSize: 10, FallbackPath: dir, FallbackMaxSizeMB: 1},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	rl.Log(testEvents("new")[0])
+
+	deadline := time.Now().Add(5 * time.Second)
+	for time.Now().Before(deadline) {
+		mu.Lock()
+		n := len(received)
+		mu.Unlock()
+		if n >= 3 {
+			break
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+	rl.Shutdown()
+
+	mu.Lock()
+	defer mu.Unlock()
+	if strings.Join(received, ",") != "old-1,old-2,new" {
+		t.Errorf("Splunk received %v, want the buffered events first", received)
+	}
+}
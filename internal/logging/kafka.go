@@ -0,0 +1,480 @@
+package logging
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net"
+	"os"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"dnshield/internal/mirror"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	kafkaDialTimeout    = 10 * time.Second
+	kafkaRequestTimeout = 30 * time.Second
+	kafkaMinBackoff     = time.Second
+	kafkaMaxBackoff     = time.Minute
+)
+
+// kafkaMessage is a record waiting to be produced to topic
+type kafkaMessage struct {
+	topic string
+	value []byte
+}
+
+// kafkaPartition is a partition and the broker leading it
+type kafkaPartition struct {
+	id     int32
+	leader int32
+}
+
+// KafkaSink streams audit events and query records to Kafka topics as JSON,
+// batched and optionally compressed. It is best effort like the other
+// outputs: records are dropped when the queue is full or the brokers are
+// unreachable, and never delay DNS responses.
+type KafkaSink struct {
+	brokers         []string
+	auditTopic      string
+	queryTopic      string
+	clientID        string
+	tlsConfig       *tls.Config
+	saslMechanism   string
+	saslUsername    string
+	saslPassword    string
+	compression     int16
+	requiredAcks    int16
+	batchSize       int
+	batchInterval   time.Duration
+	includeClientIP bool
+	hostname        string
+
+	queue   chan kafkaMessage
+	sent    uint64
+	dropped uint64
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+
+	// Owned by the sender goroutine
+	conns      map[int32]*kafkaConn
+	addrs      map[int32]string
+	partitions map[string][]kafkaPartition
+	next       map[string]int
+	retryAt    time.Time
+	backoff    time.Duration
+	failing    bool
+}
+
+// kafkaAuditRecord is the JSON document produced for an audit event
+type kafkaAuditRecord struct {
+	audit.Event
+	Host string `json:"host"`
+}
+
+// NewKafkaSink creates a sink for cfg and starts its sender
+func NewKafkaSink(cfg *config.KafkaConfig) (*KafkaSink, error) {
+	s := &KafkaSink{
+		brokers:         cfg.Brokers,
+		auditTopic:      cfg.AuditTopic,
+		queryTopic:      cfg.QueryTopic,
+		clientID:        cfg.ClientID,
+		saslMechanism:   cfg.SASL.Mechanism,
+		saslUsername:    cfg.SASL.Username,
+		saslPassword:    os.ExpandEnv(cfg.SASL.Password),
+		requiredAcks:    int16(cfg.RequiredAcks),
+		batchSize:       cfg.BatchSize,
+		batchInterval:   cfg.BatchInterval,
+		includeClientIP: cfg.IncludeClientIP,
+		hostname:        getHostname(),
+		queue:           make(chan kafkaMessage, cfg.QueueSize),
+		shutdownCh:      make(chan struct{}),
+		conns:           make(map[int32]*kafkaConn),
+		addrs:           make(map[int32]string),
+		partitions:      make(map[string][]kafkaPartition),
+		next:            make(map[string]int),
+		backoff:         kafkaMinBackoff,
+	}
+	switch cfg.Compression {
+	case "", "none":
+		s.compression = kafkaCompressionNone
+	case "gzip":
+		s.compression = kafkaCompressionGzip
+	default:
+		return nil, fmt.Errorf("unsupported kafka compression %q", cfg.Compression)
+	}
+	if cfg.TLS.Enabled {
+		s.tlsConfig = &tls.Config{MinVersion: tls.VersionTLS12}
+		if cfg.TLS.CAFile != "" {
+			pem, err := os.ReadFile(cfg.TLS.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read kafka CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.TLS.CAFile)
+			}
+			s.tlsConfig.RootCAs = pool
+		}
+	}
+
+	s.wg.Add(1)
+	go s.sender()
+
+	return s, nil
+}
+
+// LogAudit queues event for the audit topic. It never blocks.
+func (s *KafkaSink) LogAudit(event audit.Event) {
+	if s.auditTopic == "" {
+		return
+	}
+	data, err := json.Marshal(kafkaAuditRecord{Event: event, Host: s.hostname})
+	if err != nil {
+		return
+	}
+	s.enqueue(s.auditTopic, data)
+}
+
+// RecordQuery queues event for the query topic, in the query mirror's
+// record format. It never blocks.
+func (s *KafkaSink) RecordQuery(event dns.QueryEvent) {
+	if s.queryTopic == "" {
+		return
+	}
+	record := mirror.Record{
+		Timestamp:     event.Timestamp,
+		Host:          s.hostname,
+		Domain:        event.Domain,
+		QueryType:     event.QueryType,
+		Action:        event.Action,
+		Rcode:         event.Rcode,
+		Upstream:      event.Upstream,
+		UpstreamRTTMs: float64(event.UpstreamRTT) / float64(time.Millisecond),
+		DurationMs:    float64(event.Duration) / float64(time.Millisecond),
+	}
+	if s.includeClientIP {
+		record.ClientIP = event.ClientIP
+	}
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+	s.enqueue(s.queryTopic, data)
+}
+
+func (s *KafkaSink) enqueue(topic string, data []byte) {
+	select {
+	case s.queue <- kafkaMessage{topic: topic, value: data}:
+	default:
+		atomic.AddUint64(&s.dropped, 1)
+	}
+}
+
+// Stats returns the number of records produced and dropped so far
+func (s *KafkaSink) Stats() (sent, dropped uint64) {
+	return atomic.LoadUint64(&s.sent), atomic.LoadUint64(&s.dropped)
+}
+
+// Stop produces the pending batch and stops the sender. Records still
+// queued are discarded.
+func (s *KafkaSink) Stop() {
+	close(s.shutdownCh)
+	s.wg.Wait()
+}
+
+// sender collects queued records into per-topic batches and produces them
+// when a batch is full or every batch interval
+func (s *KafkaSink) sender() {
+	defer s.wg.Done()
+	defer s.closeConns()
+
+	ticker := time.NewTicker(s.batchInterval)
+	defer ticker.Stop()
+
+	batches := make(map[string][][]byte)
+	pending := 0
+	flush := func() {
+		for topic, values := range batches {
+			s.flush(topic, values)
+			delete(batches, topic)
+		}
+		pending = 0
+	}
+
+	for {
+		select {
+		case <-s.shutdownCh:
+			flush()
+			return
+		case msg := <-s.queue:
+			batches[msg.topic] = append(batches[msg.topic], msg.value)
+			if pending++; pending >= s.batchSize {
+				flush()
+			}
+		case <-ticker.C:
+			flush()
+		}
+	}
+}
+
+// flush produces values to topic, retrying once on stale metadata.
+// Failures are logged when they start and when they end, and back off
+// further attempts.
+func (s *KafkaSink) flush(topic string, values [][]byte) {
+	if time.Now().Before(s.retryAt) {
+		atomic.AddUint64(&s.dropped, uint64(len(values)))
+		return
+	}
+
+	err := s.produce(topic, values)
+	var stale *kafkaStaleError
+	if errors.As(err, &stale) {
+		err = s.produce(topic, values)
+	}
+
+	switch {
+	case err != nil:
+		atomic.AddUint64(&s.dropped, uint64(len(values)))
+		s.closeConns()
+		s.partitions = make(map[string][]kafkaPartition)
+		s.retryAt = time.Now().Add(s.backoff)
+		if s.backoff *= 2; s.backoff > kafkaMaxBackoff {
+			s.backoff = kafkaMaxBackoff
+		}
+		if !s.failing {
+			logrus.WithError(err).WithField("topic", topic).Warn("Failed to produce to Kafka")
+		}
+		s.failing = true
+	default:
+		atomic.AddUint64(&s.sent, uint64(len(values)))
+		s.backoff = kafkaMinBackoff
+		if s.failing {
+			logrus.WithField("topic", topic).Info("Kafka producing recovered")
+		}
+		s.failing = false
+	}
+}
+
+// kafkaStaleError is a partition error that calls for fresh metadata
+type kafkaStaleError struct {
+	code int16
+}
+
+func (e *kafkaStaleError) Error() string {
+	return fmt.Sprintf("kafka partition error %d", e.code)
+}
+
+// produce sends values as one record batch to the next partition of topic
+func (s *KafkaSink) produce(topic string, values [][]byte) error {
+	partitions, err := s.topicPartitions(topic)
+	if err != nil {
+		return err
+	}
+	partition := partitions[s.next[topic]%len(partitions)]
+	s.next[topic]++
+
+	conn, err := s.broker(partition.leader)
+	if err != nil {
+		return err
+	}
+	batch, err := encodeRecordBatch(values, time.Now(), s.compression)
+	if err != nil {
+		return err
+	}
+
+	var e kafkaEncoder
+	e.nullString() // transactional ID
+	e.int16(s.requiredAcks)
+	e.int32(int32(kafkaRequestTimeout / time.Millisecond))
+	e.int32(1)
+	e.string(topic)
+	e.int32(1)
+	e.int32(partition.id)
+	e.bytes(batch)
+	resp, err := conn.roundTrip(kafkaAPIProduce, kafkaProduceVersion, e.buf, kafkaRequestTimeout)
+	if err != nil {
+		s.dropBroker(partition.leader)
+		return err
+	}
+
+	d := kafkaDecoder{buf: resp}
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.string()
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			d.int32()
+			code := d.int16()
+			d.int64() // base offset
+			d.int64() // log append time
+			if d.err == nil && code != 0 {
+				if kafkaStaleMetadataErrors[code] {
+					delete(s.partitions, topic)
+					return &kafkaStaleError{code: code}
+				}
+				return fmt.Errorf("kafka produce failed with error %d", code)
+			}
+		}
+	}
+	return d.err
+}
+
+// topicPartitions returns the partitions of topic that have a leader,
+// fetching metadata when none is cached
+func (s *KafkaSink) topicPartitions(topic string) ([]kafkaPartition, error) {
+	if partitions := s.partitions[topic]; len(partitions) > 0 {
+		return partitions, nil
+	}
+	if err := s.refreshMetadata(); err != nil {
+		return nil, err
+	}
+	if partitions := s.partitions[topic]; len(partitions) > 0 {
+		return partitions, nil
+	}
+	return nil, fmt.Errorf("kafka topic %s has no available partitions", topic)
+}
+
+// refreshMetadata asks the first reachable bootstrap broker for the
+// brokers and partition leaders of the configured topics
+func (s *KafkaSink) refreshMetadata() error {
+	var topics []string
+	for _, topic := range []string{s.auditTopic, s.queryTopic} {
+		if topic != "" && (len(topics) == 0 || topics[0] != topic) {
+			topics = append(topics, topic)
+		}
+	}
+	var e kafkaEncoder
+	e.int32(int32(len(topics)))
+	for _, topic := range topics {
+		e.string(topic)
+	}
+
+	var resp []byte
+	var lastErr error
+	for _, addr := range s.brokers {
+		conn, err := s.dial(addr)
+		if err != nil {
+			lastErr = err
+			continue
+		}
+		resp, err = conn.roundTrip(kafkaAPIMetadata, kafkaMetadataVersion, e.buf, kafkaRequestTimeout)
+		conn.Close()
+		if err == nil {
+			lastErr = nil
+			break
+		}
+		lastErr = err
+	}
+	if lastErr != nil {
+		return fmt.Errorf("failed to fetch kafka metadata: %w", lastErr)
+	}
+
+	d := kafkaDecoder{buf: resp}
+	addrs := make(map[int32]string)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		id := d.int32()
+		host := d.string()
+		port := d.int32()
+		d.string() // rack
+		addrs[id] = net.JoinHostPort(host, strconv.Itoa(int(port)))
+	}
+	d.int32() // controller
+	partitions := make(map[string][]kafkaPartition)
+	for i, n := 0, d.arrayLen(); i < n; i++ {
+		d.int16() // topic error, reflected in its partitions
+		topic := d.string()
+		d.int8() // internal
+		for j, m := 0, d.arrayLen(); j < m; j++ {
+			code := d.int16()
+			p := kafkaPartition{id: d.int32(), leader: d.int32()}
+			for k, r := 0, d.arrayLen(); k < r; k++ {
+				d.int32() // replicas
+			}
+			for k, r := 0, d.arrayLen(); k < r; k++ {
+				d.int32() // in-sync replicas
+			}
+			if code == 0 && p.leader >= 0 {
+				partitions[topic] = append(partitions[topic], p)
+			}
+		}
+	}
+	if d.err != nil {
+		return d.err
+	}
+
+	for id, addr := range s.addrs {
+		if addrs[id] != addr {
+			s.dropBroker(id)
+		}
+	}
+	s.addrs = addrs
+	s.partitions = partitions
+	return nil
+}
+
+// broker returns a connection to the broker with id
+func (s *KafkaSink) broker(id int32) (*kafkaConn, error) {
+	if conn, ok := s.conns[id]; ok {
+		return conn, nil
+	}
+	addr, ok := s.addrs[id]
+	if !ok {
+		return nil, fmt.Errorf("unknown kafka broker %d", id)
+	}
+	conn, err := s.dial(addr)
+	if err != nil {
+		return nil, err
+	}
+	s.conns[id] = conn
+	return conn, nil
+}
+
+// dial connects and authenticates to the broker at addr
+func (s *KafkaSink) dial(addr string) (*kafkaConn, error) {
+	d := &net.Dialer{Timeout: kafkaDialTimeout}
+	var nc net.Conn
+	var err error
+	if s.tlsConfig != nil {
+		host, _, _ := net.SplitHostPort(addr)
+		tlsConfig := s.tlsConfig.Clone()
+		tlsConfig.ServerName = host
+		nc, err = tls.DialWithDialer(d, "tcp", addr, tlsConfig)
+	} else {
+		nc, err = d.Dial("tcp", addr)
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	conn := &kafkaConn{conn: nc, clientID: s.clientID}
+	if s.saslMechanism != "" {
+		if err := conn.authenticate(s.saslMechanism, s.saslUsername, s.saslPassword, kafkaRequestTimeout); err != nil {
+			conn.Close()
+			return nil, fmt.Errorf("kafka broker %s: %w", addr, err)
+		}
+	}
+	return conn, nil
+}
+
+func (s *KafkaSink) dropBroker(id int32) {
+	if conn, ok := s.conns[id]; ok {
+		conn.Close()
+		delete(s.conns, id)
+	}
+}
+
+func (s *KafkaSink) closeConns() {
+	for id := range s.conns {
+		s.dropBroker(id)
+	}
+}
@@ -0,0 +1,414 @@
+package logging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"crypto/sha512"
+	"encoding/base64"
+	"encoding/binary"
+	"errors"
+	"fmt"
+	"hash"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"strings"
+	"time"
+
+	"golang.org/x/crypto/pbkdf2"
+)
+
+// Kafka API keys and the versions DNShield speaks. These are the oldest
+// versions with record batches (Kafka 0.11), which every supported broker
+// still accepts.
+const (
+	kafkaAPIProduce          = 0
+	kafkaAPIMetadata         = 3
+	kafkaAPISaslHandshake    = 17
+	kafkaAPISaslAuthenticate = 36
+
+	kafkaProduceVersion          = 3
+	kafkaMetadataVersion         = 1
+	kafkaSaslHandshakeVersion    = 1
+	kafkaSaslAuthenticateVersion = 0
+
+	// Record batch attributes
+	kafkaCompressionNone = 0
+	kafkaCompressionGzip = 1
+
+	// kafkaMaxResponseSize bounds responses read from a broker
+	kafkaMaxResponseSize = 16 << 20
+
+	// maxSCRAMIterations bounds the PBKDF2 work a broker can ask for.
+	// Brokers default to 4096.
+	maxSCRAMIterations = 100000
+)
+
+// Kafka error codes that mean cached metadata is stale
+var kafkaStaleMetadataErrors = map[int16]bool{
+	3:  true, // UNKNOWN_TOPIC_OR_PARTITION
+	5:  true, // LEADER_NOT_AVAILABLE
+	6:  true, // NOT_LEADER_OR_FOLLOWER
+	13: true, // NETWORK_EXCEPTION
+}
+
+var crc32c = crc32.MakeTable(crc32.Castagnoli)
+
+// kafkaEncoder appends values in the Kafka protocol's big-endian encoding
+type kafkaEncoder struct {
+	buf []byte
+}
+
+func (e *kafkaEncoder) int8(v int8)   { e.buf = append(e.buf, byte(v)) }
+func (e *kafkaEncoder) int16(v int16) { e.buf = binary.BigEndian.AppendUint16(e.buf, uint16(v)) }
+func (e *kafkaEncoder) int32(v int32) { e.buf = binary.BigEndian.AppendUint32(e.buf, uint32(v)) }
+func (e *kafkaEncoder) int64(v int64) { e.buf = binary.BigEndian.AppendUint64(e.buf, uint64(v)) }
+
+func (e *kafkaEncoder) string(s string) {
+	e.int16(int16(len(s)))
+	e.buf = append(e.buf, s...)
+}
+
+func (e *kafkaEncoder) nullString() { e.int16(-1) }
+
+func (e *kafkaEncoder) bytes(b []byte) {
+	e.int32(int32(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+// kafkaDecoder reads values from a response. The first error sticks, so
+// callers check err once after decoding.
+type kafkaDecoder struct {
+	buf []byte
+	err error
+}
+
+func (d *kafkaDecoder) take(n int) []byte {
+	if d.err != nil {
+		return nil
+	}
+	if n < 0 || n > len(d.buf) {
+		d.err = errors.New("truncated kafka response")
+		return nil
+	}
+	b := d.buf[:n]
+	d.buf = d.buf[n:]
+	return b
+}
+
+func (d *kafkaDecoder) int8() int8 {
+	if b := d.take(1); b != nil {
+		return int8(b[0])
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int16() int16 {
+	if b := d.take(2); b != nil {
+		return int16(binary.BigEndian.Uint16(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int32() int32 {
+	if b := d.take(4); b != nil {
+		return int32(binary.BigEndian.Uint32(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) int64() int64 {
+	if b := d.take(8); b != nil {
+		return int64(binary.BigEndian.Uint64(b))
+	}
+	return 0
+}
+
+func (d *kafkaDecoder) string() string {
+	n := d.int16()
+	if n < 0 {
+		return ""
+	}
+	return string(d.take(int(n)))
+}
+
+func (d *kafkaDecoder) bytes() []byte {
+	n := d.int32()
+	if n < 0 {
+		return nil
+	}
+	return d.take(int(n))
+}
+
+// arrayLen reads an array length, refusing ones the response can't hold
+func (d *kafkaDecoder) arrayLen() int {
+	n := d.int32()
+	if n < 0 {
+		return 0
+	}
+	if int(n) > len(d.buf) {
+		d.err = errors.New("invalid kafka array length")
+		return 0
+	}
+	return int(n)
+}
+
+// encodeRecordBatch builds a v2 record batch holding values
+func encodeRecordBatch(values [][]byte, timestamp time.Time, compression int16) ([]byte, error) {
+	var records []byte
+	for i, value := range values {
+		var r []byte
+		r = append(r, 0)                     // attributes
+		r = binary.AppendVarint(r, 0)        // timestamp delta
+		r = binary.AppendVarint(r, int64(i)) // offset delta
+		r = binary.AppendVarint(r, -1)       // null key
+		r = binary.AppendVarint(r, int64(len(value)))
+		r = append(r, value...)
+		r = binary.AppendVarint(r, 0) // headers
+		records = binary.AppendVarint(records, int64(len(r)))
+		records = append(records, r...)
+	}
+	if compression == kafkaCompressionGzip {
+		var buf bytes.Buffer
+		zw := gzip.NewWriter(&buf)
+		if _, err := zw.Write(records); err != nil {
+			return nil, err
+		}
+		if err := zw.Close(); err != nil {
+			return nil, err
+		}
+		records = buf.Bytes()
+	}
+
+	ms := timestamp.UnixMilli()
+	var tail kafkaEncoder // Everything the CRC covers
+	tail.int16(compression)
+	tail.int32(int32(len(values) - 1)) // last offset delta
+	tail.int64(ms)                     // first timestamp
+	tail.int64(ms)                     // max timestamp
+	tail.int64(-1)                     // producer ID
+	tail.int16(-1)                     // producer epoch
+	tail.int32(-1)                     // base sequence
+	tail.int32(int32(len(values)))
+	tail.buf = append(tail.buf, records...)
+
+	var e kafkaEncoder
+	e.int64(0)                                // base offset
+	e.int32(int32(4 + 1 + 4 + len(tail.buf))) // batch length
+	e.int32(-1)                               // partition leader epoch
+	e.int8(2)                                 // magic
+	e.buf = binary.BigEndian.AppendUint32(e.buf, crc32.Checksum(tail.buf, crc32c))
+	e.buf = append(e.buf, tail.buf...)
+	return e.buf, nil
+}
+
+// kafkaConn is an authenticated connection to one broker
+type kafkaConn struct {
+	conn          net.Conn
+	clientID      string
+	correlationID int32
+}
+
+// roundTrip sends a request and returns the response body after its
+// header
+func (c *kafkaConn) roundTrip(apiKey, version int16, body []byte, timeout time.Duration) ([]byte, error) {
+	c.correlationID++
+	var e kafkaEncoder
+	e.int32(0) // size, set below
+	e.int16(apiKey)
+	e.int16(version)
+	e.int32(c.correlationID)
+	e.string(c.clientID)
+	e.buf = append(e.buf, body...)
+	binary.BigEndian.PutUint32(e.buf, uint32(len(e.buf)-4))
+
+	c.conn.SetDeadline(time.Now().Add(timeout))
+	if _, err := c.conn.Write(e.buf); err != nil {
+		return nil, err
+	}
+
+	var header [8]byte
+	if _, err := io.ReadFull(c.conn, header[:]); err != nil {
+		return nil, err
+	}
+	size := int(binary.BigEndian.Uint32(header[:4]))
+	if size < 4 || size > kafkaMaxResponseSize {
+		return nil, fmt.Errorf("invalid kafka response size %d", size)
+	}
+	if id := int32(binary.BigEndian.Uint32(header[4:])); id != c.correlationID {
+		return nil, fmt.Errorf("kafka response for request %d, expected %d", id, c.correlationID)
+	}
+	resp := make([]byte, size-4)
+	if _, err := io.ReadFull(c.conn, resp); err != nil {
+		return nil, err
+	}
+	return resp, nil
+}
+
+func (c *kafkaConn) Close() error {
+	return c.conn.Close()
+}
+
+// authenticate performs a SASL handshake and exchange for mechanism
+func (c *kafkaConn) authenticate(mechanism, username, password string, timeout time.Duration) error {
+	var e kafkaEncoder
+	e.string(mechanism)
+	resp, err := c.roundTrip(kafkaAPISaslHandshake, kafkaSaslHandshakeVersion, e.buf, timeout)
+	if err != nil {
+		return err
+	}
+	d := kafkaDecoder{buf: resp}
+	if code := d.int16(); code != 0 {
+		return fmt.Errorf("broker refused SASL mechanism %s (error %d)", mechanism, code)
+	}
+
+	exchange := func(msg []byte) ([]byte, error) {
+		var e kafkaEncoder
+		e.bytes(msg)
+		resp, err := c.roundTrip(kafkaAPISaslAuthenticate, kafkaSaslAuthenticateVersion, e.buf, timeout)
+		if err != nil {
+			return nil, err
+		}
+		d := kafkaDecoder{buf: resp}
+		code := d.int16()
+		message := d.string()
+		reply := d.bytes()
+		if d.err != nil {
+			return nil, d.err
+		}
+		if code != 0 {
+			return nil, fmt.Errorf("SASL authentication failed (error %d): %s", code, message)
+		}
+		return reply, nil
+	}
+
+	if mechanism == "PLAIN" {
+		_, err := exchange([]byte("\x00" + username + "\x00" + password))
+		return err
+	}
+
+	scram, err := newSCRAMClient(mechanism, username, password, "")
+	if err != nil {
+		return err
+	}
+	serverFirst, err := exchange([]byte(scram.first()))
+	if err != nil {
+		return err
+	}
+	final, err := scram.final(string(serverFirst))
+	if err != nil {
+		return err
+	}
+	serverFinal, err := exchange([]byte(final))
+	if err != nil {
+		return err
+	}
+	return scram.verify(string(serverFinal))
+}
+
+// scramClient runs the client side of SCRAM (RFC 5802) without channel
+// binding
+type scramClient struct {
+	hash        func() hash.Hash
+	username    string
+	password    string
+	nonce       string
+	authMessage string
+	serverKey   []byte
+}
+
+func newSCRAMClient(mechanism, username, password, nonce string) (*scramClient, error) {
+	c := &scramClient{username: username, password: password, nonce: nonce}
+	switch mechanism {
+	case "SCRAM-SHA-256":
+		c.hash = sha256.New
+	case "SCRAM-SHA-512":
+		c.hash = sha512.New
+	default:
+		return nil, fmt.Errorf("unsupported SASL mechanism %q", mechanism)
+	}
+	if c.nonce == "" {
+		b := make([]byte, 18)
+		if _, err := rand.Read(b); err != nil {
+			return nil, err
+		}
+		c.nonce = base64.StdEncoding.EncodeToString(b)
+	}
+	return c, nil
+}
+
+func (c *scramClient) firstBare() string {
+	name := strings.NewReplacer("=", "=3D", ",", "=2C").Replace(c.username)
+	return "n=" + name + ",r=" + c.nonce
+}
+
+// first returns the client-first message
+func (c *scramClient) first() string {
+	return "n,," + c.firstBare()
+}
+
+// final returns the client-final message answering serverFirst
+func (c *scramClient) final(serverFirst string) (string, error) {
+	attrs := scramAttributes(serverFirst)
+	nonce, salt64, iter := attrs["r"], attrs["s"], attrs["i"]
+	if !strings.HasPrefix(nonce, c.nonce) || len(nonce) == len(c.nonce) {
+		return "", errors.New("invalid SCRAM server nonce")
+	}
+	salt, err := base64.StdEncoding.DecodeString(salt64)
+	if err != nil {
+		return "", fmt.Errorf("invalid SCRAM salt: %v", err)
+	}
+	iterations, err := strconv.Atoi(iter)
+	if err != nil || iterations < 1 || iterations > maxSCRAMIterations {
+		return "", fmt.Errorf("invalid SCRAM iteration count %q", iter)
+	}
+
+	salted := pbkdf2.Key([]byte(c.password), salt, iterations, c.hash().Size(), c.hash)
+	clientKey := c.hmac(salted, "Client Key")
+	h := c.hash()
+	h.Write(clientKey)
+	storedKey := h.Sum(nil)
+
+	withoutProof := "c=biws,r=" + nonce // biws is base64("n,,")
+	c.authMessage = c.firstBare() + "," + serverFirst + "," + withoutProof
+	proof := c.hmac(storedKey, c.authMessage)
+	for i := range proof {
+		proof[i] ^= clientKey[i]
+	}
+	c.serverKey = c.hmac(salted, "Server Key")
+	return withoutProof + ",p=" + base64.StdEncoding.EncodeToString(proof), nil
+}
+
+// verify checks the server's signature in serverFinal
+func (c *scramClient) verify(serverFinal string) error {
+	attrs := scramAttributes(serverFinal)
+	if msg, ok := attrs["e"]; ok {
+		return fmt.Errorf("SCRAM authentication failed: %s", msg)
+	}
+	want := base64.StdEncoding.EncodeToString(c.hmac(c.serverKey, c.authMessage))
+	if !hmac.Equal([]byte(attrs["v"]), []byte(want)) {
+		return errors.New("invalid SCRAM server signature")
+	}
+	return nil
+}
+
+func (c *scramClient) hmac(key []byte, msg string) []byte {
+	mac := hmac.New(c.hash, key)
+	mac.Write([]byte(msg))
+	return mac.Sum(nil)
+}
+
+// scramAttributes parses "k=v,k=v"
+func scramAttributes(msg string) map[string]string {
+	attrs := make(map[string]string)
+	for _, field := range strings.Split(msg, ",") {
+		if k, v, ok := strings.Cut(field, "="); ok {
+			attrs[k] = v
+		}
+	}
+	return attrs
+}
@@ -0,0 +1,267 @@
+package logging
+
+import (
+	"bytes"
+	"compress/gzip"
+	"encoding/binary"
+	"encoding/json"
+	"hash/crc32"
+	"io"
+	"net"
+	"strconv"
+	"sync"
+	"testing"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+)
+
+// fakeBroker is a single-node Kafka cluster that records produced values
+type fakeBroker struct {
+	t        *testing.T
+	ln       net.Listener
+	mu       sync.Mutex
+	values   map[string][]string
+	saslAuth []byte
+	done     chan struct{}
+}
+
+func newFakeBroker(t *testing.T) *fakeBroker {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	b := &fakeBroker{t: t, ln: ln, values: make(map[string][]string), done: make(chan struct{}, 100)}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go b.serve(conn)
+		}
+	}()
+	return b
+}
+
+func (b *fakeBroker) serve(conn net.Conn) {
+	defer conn.Close()
+	for {
+		var size [4]byte
+		if _, err := io.ReadFull(conn, size[:]); err != nil {
+			return
+		}
+		req := make([]byte, binary.BigEndian.Uint32(size[:]))
+		if _, err := io.ReadFull(conn, req); err != nil {
+			return
+		}
+		d := kafkaDecoder{buf: req}
+		apiKey := d.int16()
+		d.int16() // version
+		correlationID := d.int32()
+		d.string() // client ID
+
+		var e kafkaEncoder
+		switch apiKey {
+		case kafkaAPISaslHandshake:
+			e.int16(0)
+			e.int32(1)
+			e.string(d.string())
+		case kafkaAPISaslAuthenticate:
+			b.mu.Lock()
+			b.saslAuth = d.bytes()
+			b.mu.Unlock()
+			e.int16(0)
+			e.nullString()
+			e.bytes(nil)
+		case kafkaAPIMetadata:
+			host, port, _ := net.SplitHostPort(b.ln.Addr().String())
+			portNum, _ := strconv.Atoi(port)
+			e.int32(1)
+			e.int32(0)
+			e.string(host)
+			e.int32(int32(portNum))
+			e.nullString()
+			e.int32(0) // controller
+			n := d.arrayLen()
+			e.int32(int32(n))
+			for i := 0; i < n; i++ {
+				e.int16(0)
+				e.string(d.string())
+				e.int8(0)
+				e.int32(1) // one partition led by node 0
+				e.int16(0)
+				e.int32(0)
+				e.int32(0)
+				e.int32(1)
+				e.int32(0)
+				e.int32(1)
+				e.int32(0)
+			}
+		case kafkaAPIProduce:
+			d.string() // transactional ID
+			d.int16()  // acks
+			d.int32()  // timeout
+			d.arrayLen()
+			topic := d.string()
+			d.arrayLen()
+			partition := d.int32()
+			b.recordBatch(topic, d.bytes())
+			e.int32(1)
+			e.string(topic)
+			e.int32(1)
+			e.int32(partition)
+			e.int16(0)
+			e.int64(0)
+			e.int64(-1)
+			e.int32(0) // throttle time
+		}
+
+		var resp kafkaEncoder
+		resp.int32(int32(4 + len(e.buf)))
+		resp.int32(correlationID)
+		resp.buf = append(resp.buf, e.buf...)
+		if _, err := conn.Write(resp.buf); err != nil {
+			return
+		}
+		if apiKey == kafkaAPIProduce {
+			b.done <- struct{}{}
+		}
+	}
+}
+
+// recordBatch checks a v2 record batch and records its values
+func (b *fakeBroker) recordBatch(topic string, batch []byte) {
+	d := kafkaDecoder{buf: batch}
+	d.int64() // base offset
+	d.int32() // length
+	d.int32() // leader epoch
+	if magic := d.int8(); magic != 2 {
+		b.t.Errorf("Record batch magic = %d, want 2", magic)
+	}
+	crc := uint32(d.int32())
+	if got := crc32.Checksum(d.buf, crc32c); got != crc {
+		b.t.Errorf("Record batch CRC = %08x, computed %08x", crc, got)
+	}
+	attributes := d.int16()
+	d.take(4 + 8 + 8 + 8 + 2 + 4)
+	count := int(d.int32())
+	records := d.buf
+	if attributes&7 == kafkaCompressionGzip {
+		zr, err := gzip.NewReader(bytes.NewReader(records))
+		if err != nil {
+			b.t.Error(err)
+			return
+		}
+		records, _ = io.ReadAll(zr)
+	}
+
+	r := bytes.NewReader(records)
+	for i := 0; i < count; i++ {
+		binary.ReadVarint(r) // length
+		r.ReadByte()         // attributes
+		binary.ReadVarint(r) // timestamp delta
+		binary.ReadVarint(r) // offset delta
+		binary.ReadVarint(r) // key length (null)
+		n, _ := binary.ReadVarint(r)
+		value := make([]byte, n)
+		io.ReadFull(r, value)
+		binary.ReadVarint(r) // headers
+		b.mu.Lock()
+		b.values[topic] = append(b.values[topic], string(value))
+		b.mu.Unlock()
+	}
+}
+
+func TestKafkaSink(t *testing.T) {
+	broker := newFakeBroker(t)
+	defer broker.ln.Close()
+
+	t.Setenv("KAFKA_TEST_PASSWORD", "secret")
+	sink, err := NewKafkaSink(&config.KafkaConfig{
+		Brokers:       []string{broker.ln.Addr().String()},
+		AuditTopic:    "dnshield-audit",
+		QueryTopic:    "dnshield-queries",
+		ClientID:      "dnshield",
+		SASL:          config.KafkaSASLConfig{Mechanism: "PLAIN", Username: "agent", Password: "${KAFKA_TEST_PASSWORD}"},
+		Compression:   "gzip",
+		RequiredAcks:  1,
+		BatchSize:     2,
+		BatchInterval: time.Hour,
+		QueueSize:     10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	sink.LogAudit(audit.Event{Type: audit.EventDomainBlocked, Message: "Domain blocked", Timestamp: time.Now()})
+	sink.RecordQuery(dns.QueryEvent{Timestamp: time.Now(), Domain: "example.com", QueryType: "A", ClientIP: "10.0.0.5", Action: "allowed"})
+
+	// A full batch is produced without waiting for the interval
+	for i := 0; i < 2; i++ {
+		select {
+		case <-broker.done:
+		case <-time.After(5 * time.Second):
+			t.Fatal("Batch not produced")
+		}
+	}
+	sink.Stop()
+
+	broker.mu.Lock()
+	defer broker.mu.Unlock()
+	if string(broker.saslAuth) != "\x00agent\x00secret" {
+		t.Errorf("SASL PLAIN message = %q", broker.saslAuth)
+	}
+	var event struct {
+		Type string `json:"type"`
+		Host string `json:"host"`
+	}
+	if len(broker.values["dnshield-audit"]) != 1 || json.Unmarshal([]byte(broker.values["dnshield-audit"][0]), &event) != nil {
+		t.Fatalf("Audit topic got %q", broker.values["dnshield-audit"])
+	}
+	if event.Type != string(audit.EventDomainBlocked) || event.Host == "" {
+		t.Errorf("Unexpected audit record %+v", event)
+	}
+	var query map[string]interface{}
+	if len(broker.values["dnshield-queries"]) != 1 || json.Unmarshal([]byte(broker.values["dnshield-queries"][0]), &query) != nil {
+		t.Fatalf("Query topic got %q", broker.values["dnshield-queries"])
+	}
+	if query["domain"] != "example.com" {
+		t.Errorf("Query record domain = %v", query["domain"])
+	}
+	if _, ok := query["client_ip"]; ok {
+		t.Error("Query record includes the client IP")
+	}
+	if sent, dropped := sink.Stats(); sent != 2 || dropped != 0 {
+		t.Errorf("Stats = %d sent, %d dropped; want 2, 0", sent, dropped)
+	}
+}
+
+// TestSCRAMClient follows the SCRAM-SHA-256 example in RFC 7677
+func TestSCRAMClient(t *testing.T) {
+	c, err := newSCRAMClient("SCRAM-SHA-256", "user", "pencil", "rOprNGfwEbeRWgbNEkqO")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if got := c.first(); got != "n,,n=user,r=rOprNGfwEbeRWgbNEkqO" {
+		t.Errorf("Client first = %q", got)
+	}
+	final, err := c.final("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=4096")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := "c=biws,r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,p=dHzbZapWIk4jUhN+Ute9ytag9zjfMHgsqmmiz7AndVQ="; final != want {
+		t.Errorf("Client final = %q, want %q", final, want)
+	}
+	if err := c.verify("v=6rriTRBi23WpRR/wtup+mMhUZUn/dB5nLTJRsjl95G4="); err != nil {
+		t.Error(err)
+	}
+	if err := c.verify("v=AAAA"); err == nil {
+		t.Error("Wrong server signature accepted")
+	}
+
+	if _, err := c.final("r=rOprNGfwEbeRWgbNEkqO%hvYDpWUa2RaTCAfuxFIlj)hNlF$k0,s=W22ZaJ0SNY7soEsUEjb6gQ==,i=2000000000"); err == nil {
+		t.Error("Unbounded iteration count accepted")
+	}
+}
@@ -1,6 +1,6 @@
 // Package logging provides remote logging capabilities for DNShield audit events.
 // It supports sending logs to Splunk HEC and archiving to S3 with reliability features
-// like buffering, retries, and local fallback.
+// like buffering, retries, and local fallback, and forwarding them to syslog.
 package logging
 
 import (
@@ -28,6 +28,7 @@ type RemoteLogger struct {
 	s3Client      *s3.Client
 	s3Config      *config.S3Config
 	buffer        *RingBuffer
+	fallback      *DiskBuffer
 	mu            sync.RWMutex
 	shutdownCh    chan struct{}
 	wg            sync.WaitGroup
@@ -86,6 +87,16 @@ func NewRemoteLogger(cfg *config.LoggingConfig, s3Client *s3.Client) (*RemoteLog
 		}
 	}
 
+	// Keep undelivered events on disk when there is somewhere to send them
+	if cfg.Local.FallbackPath != "" && (rl.splunkClient != nil || s3Client != nil) {
+		fallback, err := OpenDiskBuffer(cfg.Local.FallbackPath, int64(cfg.Local.FallbackMaxSizeMB)<<20)
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to open audit fallback buffer, buffering in memory only")
+		} else {
+			rl.fallback = fallback
+		}
+	}
+
 	// Start background workers
 	rl.wg.Add(2)
 	go rl.splunkWorker()
@@ -119,6 +130,14 @@ func (rl *RemoteLogger) splunkWorker() {
 			return
 
 		case <-ticker.C:
+			// While older events wait on disk, new ones queue behind
+			// them so they arrive in order
+			if rl.splunkClient != nil && rl.fallback != nil && !rl.fallback.Empty() {
+				rl.spill(rl.popEvents(rl.buffer.size))
+				rl.drainFallback(rl.sendToSplunkOnce, 100)
+				continue
+			}
+
 			// Collect events from buffer
 			for i := 0; i < 100; i++ {
 				event, ok := rl.buffer.Pop()
@@ -130,18 +149,98 @@ func (rl *RemoteLogger) splunkWorker() {
 
 			// Send batch if we have events
 			if len(batch) > 0 {
-				rl.sendToSplunk(batch)
+				if err := rl.sendToSplunk(batch); err != nil {
+					rl.spill(batch)
+				}
 				batch = batch[:0] // Reset slice
 			}
 		}
 	}
 }
 
-// sendToSplunk sends a batch of events to Splunk HEC
-func (rl *RemoteLogger) sendToSplunk(events []audit.Event) {
-	if rl.splunkClient == nil {
+// popEvents removes up to max events from the memory buffer
+func (rl *RemoteLogger) popEvents(max int) []audit.Event {
+	var events []audit.Event
+	for len(events) < max {
+		event, ok := rl.buffer.Pop()
+		if !ok {
+			break
+		}
+		events = append(events, event)
+	}
+	return events
+}
+
+// spill writes events that couldn't be delivered to the fallback buffer,
+// to be sent when the destination is reachable again
+func (rl *RemoteLogger) spill(events []audit.Event) {
+	if rl.fallback == nil || len(events) == 0 {
 		return
 	}
+	if err := rl.fallback.Append(events); err != nil {
+		logrus.WithError(err).Error("Failed to write audit events to fallback buffer")
+	}
+}
+
+// drainFallback sends events from the fallback buffer, oldest first, in
+// batches of batchSize until it is empty or a send fails
+func (rl *RemoteLogger) drainFallback(send func([]audit.Event) error, batchSize int) {
+	if rl.fallback == nil {
+		return
+	}
+	sent := 0
+	for {
+		events, pos, err := rl.fallback.Read(batchSize)
+		if err != nil {
+			logrus.WithError(err).Error("Failed to read audit fallback buffer")
+			return
+		}
+		if len(events) > 0 {
+			if err := send(events); err != nil {
+				logrus.WithError(err).Debug("Destination still unavailable, keeping buffered audit events")
+				return
+			}
+			sent += len(events)
+		}
+		if err := rl.fallback.Commit(pos); err != nil {
+			logrus.WithError(err).Error("Failed to save audit fallback buffer position")
+		}
+		if len(events) == 0 {
+			break
+		}
+	}
+	if sent > 0 {
+		logrus.WithField("count", sent).Info("Delivered audit events from fallback buffer")
+	}
+}
+
+// sendToSplunk sends a batch of events to Splunk HEC, retrying on failure
+func (rl *RemoteLogger) sendToSplunk(events []audit.Event) error {
+	if rl.splunkClient == nil {
+		return nil
+	}
+
+	// Send to Splunk with retries
+	payload := rl.splunkPayload(events)
+	var err error
+	for attempt := 0; attempt < 3; attempt++ {
+		if err = rl.splunkClient.send(payload); err != nil {
+			logrus.WithError(err).Warnf("Failed to send to Splunk (attempt %d/3)", attempt+1)
+			time.Sleep(time.Duration(attempt+1) * 5 * time.Second)
+			continue
+		}
+		break
+	}
+	return err
+}
+
+// sendToSplunkOnce sends a batch of events to Splunk HEC without retrying
+func (rl *RemoteLogger) sendToSplunkOnce(events []audit.Event) error {
+	return rl.splunkClient.send(rl.splunkPayload(events))
+}
+
+// splunkPayload converts events to a HEC request body
+func (rl *RemoteLogger) splunkPayload(events []audit.Event) []byte {
 
 	hostname := getHostname()
 
@@ -173,16 +272,7 @@ func (rl *RemoteLogger) sendToSplunk(events []audit.Event) {
 		payload.Write(jsonData)
 		payload.WriteByte('\n')
 	}
-
-	// Send to Splunk with retries
-	for attempt := 0; attempt < 3; attempt++ {
-		if err := rl.splunkClient.send(payload.Bytes()); err != nil {
-			logrus.WithError(err).Warnf("Failed to send to Splunk (attempt %d/3)", attempt+1)
-			time.Sleep(time.Duration(attempt+1) * 5 * time.Second)
-			continue
-		}
-		break
-	}
+	return payload.Bytes()
 }
 
 // send performs the HTTP request to Splunk HEC
@@ -244,10 +334,30 @@ func (rl *RemoteLogger) uploadToS3() {
 		events = append(events, event)
 	}
 
-	if len(events) == 0 {
-		return
+	if len(events) > 0 {
+		if err := rl.putS3(events); err != nil {
+			logrus.WithError(err).Error("Failed to upload audit logs to S3")
+			if rl.fallback != nil {
+				rl.spill(events)
+			} else {
+				// Put events back in buffer
+				for _, event := range events {
+					rl.buffer.Push(event)
+				}
+			}
+			return
+		}
+		logrus.WithField("count", len(events)).Info("Uploaded audit logs to S3")
+	}
+
+	// Without Splunk, S3 delivers what was buffered on disk
+	if rl.splunkClient == nil {
+		rl.drainFallback(rl.putS3, 1000)
 	}
+}
 
+// putS3 uploads events to S3 as one compressed object
+func (rl *RemoteLogger) putS3(events []audit.Event) error {
 	// Prepare compressed JSON
 	var buf bytes.Buffer
 	gw := gzip.NewWriter(&buf)
@@ -260,8 +370,7 @@ func (rl *RemoteLogger) uploadToS3() {
 	}
 
 	if err := gw.Close(); err != nil {
-		logrus.WithError(err).Error("Failed to compress events for S3")
-		return
+		return fmt.Errorf("failed to compress events: %w", err)
 	}
 
 	// Upload to S3
@@ -271,7 +380,7 @@ func (rl *RemoteLogger) uploadToS3() {
 	key := fmt.Sprintf("%saudit-%s-%s.json.gz",
 		rl.s3Config.LogPrefix,
 		getHostname(),
-		time.Now().UTC().Format("20060102-150405"))
+		time.Now().UTC().Format("20060102-150405.000"))
 
 	_, err := rl.s3Client.PutObject(ctx, &s3.PutObjectInput{
 		Bucket:          aws.String(rl.s3Config.Bucket),
@@ -280,23 +389,30 @@ func (rl *RemoteLogger) uploadToS3() {
 		ContentType:     aws.String("application/gzip"),
 		ContentEncoding: aws.String("gzip"),
 	})
-
-	if err != nil {
-		logrus.WithError(err).Error("Failed to upload audit logs to S3")
-		// Put events back in buffer
-		for _, event := range events {
-			rl.buffer.Push(event)
-		}
-	} else {
-		logrus.WithField("count", len(events)).Info("Uploaded audit logs to S3")
-	}
+	return err
 }
 
-// Shutdown gracefully stops the remote logger
+// Shutdown gracefully stops the remote logger. Events not yet delivered
+// are kept in the fallback buffer for the next start.
 func (rl *RemoteLogger) Shutdown() error {
 	close(rl.shutdownCh)
 	rl.wg.Wait()
-	return nil
+	if rl.fallback == nil {
+		return nil
+	}
+	rl.spill(rl.popEvents(rl.buffer.size))
+	return rl.fallback.Close()
+}
+
+// Purge deletes the undelivered events match selects, in memory and in
+// the fallback buffer, and returns how many were deleted
+func (rl *RemoteLogger) Purge(match func(audit.Event) bool) (int, error) {
+	purged := rl.buffer.Remove(match)
+	if rl.fallback == nil {
+		return purged, nil
+	}
+	n, err := rl.fallback.Purge(match)
+	return purged + n, err
 }
 
 // NewRingBuffer creates a new ring buffer
@@ -343,6 +459,29 @@ func (rb *RingBuffer) Pop() (audit.Event, bool) {
 	return event, true
 }
 
+// Remove deletes the events match selects, keeping the rest in order, and
+// returns how many were deleted
+func (rb *RingBuffer) Remove(match func(audit.Event) bool) int {
+	rb.mu.Lock()
+	defer rb.mu.Unlock()
+
+	kept := 0
+	for i := 0; i < rb.count; i++ {
+		event := rb.events[(rb.tail+i)%rb.size]
+		if !match(event) {
+			rb.events[(rb.tail+kept)%rb.size] = event
+			kept++
+		}
+	}
+	removed := rb.count - kept
+	for i := kept; i < rb.count; i++ {
+		rb.events[(rb.tail+i)%rb.size] = audit.Event{}
+	}
+	rb.count = kept
+	rb.head = (rb.tail + kept) % rb.size
+	return removed
+}
+
 // getHostname returns the system hostname
 func getHostname() string {
 	hostname, err := os.Hostname()
@@ -0,0 +1,476 @@
+package logging
+
+import (
+	"crypto/tls"
+	"crypto/x509"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/url"
+	"os"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Syslog message formats
+const (
+	SyslogFormatText = "text"
+	SyslogFormatCEF  = "cef"
+	SyslogFormatLEEF = "leef"
+)
+
+const (
+	syslogWriteTimeout   = 5 * time.Second
+	syslogMinDialBackoff = time.Second
+	syslogMaxDialBackoff = 30 * time.Second
+
+	// sdID is the RFC 5424 structured data ID of event details. 32473 is
+	// the private enterprise number reserved for documentation (RFC 5612).
+	sdID = "dnshield@32473"
+
+	// Device fields of CEF and LEEF headers
+	deviceVendor  = "DNShield"
+	deviceProduct = "DNShield Agent"
+	deviceVersion = "1.0.0"
+)
+
+// localSyslogPaths are where syslog daemons listen, tried in order
+var localSyslogPaths = []string{"/dev/log", "/var/run/syslog", "/var/run/log"}
+
+// syslogFacilities maps facility names to their codes
+var syslogFacilities = map[string]int{
+	"kern": 0, "user": 1, "mail": 2, "daemon": 3, "auth": 4, "syslog": 5,
+	"lpr": 6, "news": 7, "uucp": 8, "cron": 9, "authpriv": 10, "ftp": 11,
+	"local0": 16, "local1": 17, "local2": 18, "local3": 19,
+	"local4": 20, "local5": 21, "local6": 22, "local7": 23,
+}
+
+// SyslogWriter sends audit events to a syslog daemon or collector. Like
+// the other outputs it is best effort: messages are queued and dropped
+// when the queue is full or the target is unreachable.
+type SyslogWriter struct {
+	network   string // "" for the local daemon, udp, tcp or tls
+	address   string
+	tlsConfig *tls.Config
+	format    string
+	facility  int
+	appName   string
+	hostname  string
+	types     map[audit.EventType]bool // Nil sends every type
+
+	queue   chan []byte
+	sent    uint64
+	dropped uint64
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// ParseSyslogTarget splits a target such as "tls://siem.example.com:6514"
+// into a network and address. An empty target is the local daemon.
+func ParseSyslogTarget(target string) (network, address string, err error) {
+	if target == "" {
+		return "", "", nil
+	}
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid syslog target: %v", err)
+	}
+	switch u.Scheme {
+	case "udp", "tcp", "tls":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return "", "", fmt.Errorf("syslog target %q must include host and port", target)
+		}
+		return u.Scheme, u.Host, nil
+	}
+	return "", "", fmt.Errorf("unsupported syslog target scheme %q (use udp, tcp or tls)", u.Scheme)
+}
+
+// SyslogFacility returns the code of a facility name
+func SyslogFacility(name string) (int, error) {
+	code, ok := syslogFacilities[strings.ToLower(name)]
+	if !ok {
+		return 0, fmt.Errorf("unknown syslog facility %q", name)
+	}
+	return code, nil
+}
+
+// NewSyslogWriter creates a writer for cfg and starts its sender
+func NewSyslogWriter(cfg *config.SyslogConfig) (*SyslogWriter, error) {
+	network, address, err := ParseSyslogTarget(cfg.Target)
+	if err != nil {
+		return nil, err
+	}
+	facility, err := SyslogFacility(cfg.Facility)
+	if err != nil {
+		return nil, err
+	}
+
+	w := &SyslogWriter{
+		network:    network,
+		address:    address,
+		format:     cfg.Format,
+		facility:   facility,
+		appName:    cfg.AppName,
+		hostname:   getHostname(),
+		queue:      make(chan []byte, cfg.QueueSize),
+		shutdownCh: make(chan struct{}),
+	}
+	if network == "tls" {
+		host, _, _ := net.SplitHostPort(address)
+		w.tlsConfig = &tls.Config{ServerName: host, MinVersion: tls.VersionTLS12}
+		if cfg.CAFile != "" {
+			pem, err := os.ReadFile(cfg.CAFile)
+			if err != nil {
+				return nil, fmt.Errorf("failed to read syslog CA file: %w", err)
+			}
+			pool := x509.NewCertPool()
+			if !pool.AppendCertsFromPEM(pem) {
+				return nil, fmt.Errorf("no certificates found in %s", cfg.CAFile)
+			}
+			w.tlsConfig.RootCAs = pool
+		}
+	}
+	if len(cfg.EventTypes) > 0 {
+		w.types = make(map[audit.EventType]bool)
+		for _, t := range cfg.EventTypes {
+			w.types[audit.EventType(t)] = true
+		}
+	}
+
+	w.wg.Add(1)
+	go w.sender()
+
+	return w, nil
+}
+
+// Log queues event, unless its type isn't sent. It never blocks.
+func (w *SyslogWriter) Log(event audit.Event) {
+	if w.types != nil && !w.types[event.Type] {
+		return
+	}
+	select {
+	case w.queue <- w.formatMessage(event):
+	default:
+		atomic.AddUint64(&w.dropped, 1)
+	}
+}
+
+// Stats returns the number of messages sent and dropped so far
+func (w *SyslogWriter) Stats() (sent, dropped uint64) {
+	return atomic.LoadUint64(&w.sent), atomic.LoadUint64(&w.dropped)
+}
+
+// Stop stops the sender. Queued messages are discarded.
+func (w *SyslogWriter) Stop() {
+	close(w.shutdownCh)
+	w.wg.Wait()
+}
+
+// formatMessage builds the syslog message for event: an RFC 5424 message
+// for remote collectors, or the traditional format local daemons expect
+func (w *SyslogWriter) formatMessage(event audit.Event) []byte {
+	pri := w.facility*8 + syslogSeverity(event.Severity)
+
+	var body, structured string
+	switch w.format {
+	case SyslogFormatCEF:
+		body = formatCEF(event)
+	case SyslogFormatLEEF:
+		body = formatLEEF(event)
+	default:
+		if w.network == "" {
+			body = string(event.Type) + ": " + event.Message
+			for _, k := range sortedKeys(event.Details) {
+				body += " " + k + "=" + detailString(event.Details[k])
+			}
+		} else {
+			body = event.Message
+			structured = structuredData(event)
+		}
+	}
+
+	if w.network == "" {
+		return []byte(fmt.Sprintf("<%d>%s %s[%d]: %s", pri, event.Timestamp.Format(time.Stamp), w.appName, os.Getpid(), body))
+	}
+	if structured == "" {
+		structured = "-"
+	}
+	msg := fmt.Sprintf("<%d>1 %s %s %s %d %s %s %s", pri,
+		event.Timestamp.Format("2006-01-02T15:04:05.000000Z07:00"),
+		w.hostname, w.appName, os.Getpid(), event.Type, structured, body)
+	if w.network == "udp" {
+		return []byte(msg)
+	}
+	// Octet-counted framing (RFC 6587), so messages may contain newlines
+	return []byte(strconv.Itoa(len(msg)) + " " + msg)
+}
+
+// syslogSeverity maps an audit severity to a syslog severity
+func syslogSeverity(severity string) int {
+	switch severity {
+	case "critical":
+		return 2
+	case "error":
+		return 3
+	case "warning", "warn":
+		return 4
+	case "notice":
+		return 5
+	case "debug":
+		return 7
+	}
+	return 6 // info
+}
+
+// structuredData renders event's details and user as an RFC 5424 SD
+// element
+func structuredData(event audit.Event) string {
+	params := make(map[string]string, len(event.Details)+1)
+	for k, v := range event.Details {
+		params[sdName(k)] = detailString(v)
+	}
+	if event.User != "" {
+		params["user"] = event.User
+	}
+	if len(params) == 0 {
+		return ""
+	}
+
+	var b strings.Builder
+	b.WriteString("[" + sdID)
+	for _, k := range sortedKeys(params) {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, `]`, `\]`).Replace(params[k])
+		b.WriteString(" " + k + `="` + v + `"`)
+	}
+	b.WriteString("]")
+	return b.String()
+}
+
+// sdName makes a detail name a valid SD-PARAM name
+func sdName(name string) string {
+	name = strings.Map(func(r rune) rune {
+		if r <= ' ' || r > '~' || r == '=' || r == ']' || r == '"' {
+			return '_'
+		}
+		return r
+	}, name)
+	if len(name) > 32 {
+		name = name[:32]
+	}
+	return name
+}
+
+// cefSeverity maps an audit severity to CEF's 0-10 scale
+func cefSeverity(severity string) int {
+	switch severity {
+	case "critical":
+		return 10
+	case "error":
+		return 8
+	case "warning", "warn":
+		return 6
+	}
+	return 3
+}
+
+// cefKeys maps detail names to CEF extension keys
+var cefKeys = map[string]string{
+	"client_ip":   "src",
+	"client_port": "spt",
+	"domain":      "dhost",
+	"user":        "suser",
+	"group":       "suid",
+}
+
+// cefLabeled maps detail names to labeled custom CEF strings
+var cefLabeled = map[string]string{
+	"rule":       "cs1",
+	"category":   "cs2",
+	"query_type": "cs3",
+	"reason":     "cs4",
+}
+
+// formatCEF renders event in ArcSight Common Event Format. Details
+// without a CEF key are sent as JSON in cs6.
+func formatCEF(event audit.Event) string {
+	header := strings.NewReplacer(`\`, `\\`, `|`, `\|`)
+	ext := strings.NewReplacer(`\`, `\\`, `=`, `\=`, "\n", `\n`, "\r", `\r`)
+
+	fields := []string{"rt=" + strconv.FormatInt(event.Timestamp.UnixMilli(), 10)}
+	rest := make(map[string]interface{})
+	for _, k := range sortedKeys(event.Details) {
+		v := detailString(event.Details[k])
+		if key, ok := cefKeys[k]; ok {
+			fields = append(fields, key+"="+ext.Replace(v))
+		} else if key, ok := cefLabeled[k]; ok {
+			fields = append(fields, key+"Label="+k, key+"="+ext.Replace(v))
+		} else {
+			rest[k] = event.Details[k]
+		}
+	}
+	if _, ok := event.Details["user"]; !ok && event.User != "" {
+		fields = append(fields, "suser="+ext.Replace(event.User))
+	}
+	fields = append(fields, "msg="+ext.Replace(event.Message))
+	if len(rest) > 0 {
+		if data, err := json.Marshal(rest); err == nil {
+			fields = append(fields, "cs6Label=details", "cs6="+ext.Replace(string(data)))
+		}
+	}
+
+	return fmt.Sprintf("CEF:0|%s|%s|%s|%s|%s|%d|%s",
+		header.Replace(deviceVendor), header.Replace(deviceProduct), header.Replace(deviceVersion),
+		header.Replace(string(event.Type)), header.Replace(event.Message),
+		cefSeverity(event.Severity), strings.Join(fields, " "))
+}
+
+// leefKeys maps detail names to LEEF attribute names
+var leefKeys = map[string]string{
+	"client_ip":   "src",
+	"client_port": "srcPort",
+	"domain":      "dstHost",
+	"user":        "usrName",
+	"group":       "groupName",
+}
+
+// formatLEEF renders event in IBM QRadar's Log Event Extended Format 1.0,
+// with tab-separated attributes
+func formatLEEF(event audit.Event) string {
+	header := strings.NewReplacer(`|`, `\|`)
+	value := strings.NewReplacer("\t", " ", "\n", " ", "\r", " ")
+
+	attrs := []string{
+		"devTime=" + event.Timestamp.Format("Jan 02 2006 15:04:05"),
+		"devTimeFormat=MMM dd yyyy HH:mm:ss",
+		"sev=" + strconv.Itoa(cefSeverity(event.Severity)),
+		"cat=" + string(event.Type),
+	}
+	for _, k := range sortedKeys(event.Details) {
+		key := k
+		if mapped, ok := leefKeys[k]; ok {
+			key = mapped
+		}
+		attrs = append(attrs, key+"="+value.Replace(detailString(event.Details[k])))
+	}
+	if _, ok := event.Details["user"]; !ok && event.User != "" {
+		attrs = append(attrs, "usrName="+value.Replace(event.User))
+	}
+	attrs = append(attrs, "msg="+value.Replace(event.Message))
+
+	return fmt.Sprintf("LEEF:1.0|%s|%s|%s|%s|%s",
+		header.Replace(deviceVendor), header.Replace(deviceProduct), header.Replace(deviceVersion),
+		header.Replace(string(event.Type)), strings.Join(attrs, "\t"))
+}
+
+// detailString renders a detail value: scalars as text, others as JSON
+func detailString(v interface{}) string {
+	switch v := v.(type) {
+	case string:
+		return v
+	case nil:
+		return ""
+	case bool, int, int64, uint32, uint64, float64, fmt.Stringer, error:
+		return fmt.Sprint(v)
+	}
+	data, err := json.Marshal(v)
+	if err != nil {
+		return fmt.Sprint(v)
+	}
+	return string(data)
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// sender drains the queue to the target, reconnecting with backoff.
+// Messages arriving while the target is unreachable are dropped.
+func (w *SyslogWriter) sender() {
+	defer w.wg.Done()
+
+	var conn net.Conn
+	var nextDial time.Time
+	backoff := syslogMinDialBackoff
+
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		var data []byte
+		select {
+		case <-w.shutdownCh:
+			return
+		case data = <-w.queue:
+		}
+
+		if conn == nil {
+			if time.Now().Before(nextDial) {
+				atomic.AddUint64(&w.dropped, 1)
+				continue
+			}
+			c, err := w.dial()
+			if err != nil {
+				logrus.WithError(err).WithField("target", w.network+"://"+w.address).
+					Warn("Syslog target unreachable")
+				nextDial = time.Now().Add(backoff)
+				if backoff *= 2; backoff > syslogMaxDialBackoff {
+					backoff = syslogMaxDialBackoff
+				}
+				atomic.AddUint64(&w.dropped, 1)
+				continue
+			}
+			conn = c
+			backoff = syslogMinDialBackoff
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(syslogWriteTimeout))
+		if _, err := conn.Write(data); err != nil {
+			logrus.WithError(err).Debug("Syslog write failed, reconnecting")
+			conn.Close()
+			conn = nil
+			atomic.AddUint64(&w.dropped, 1)
+			continue
+		}
+		atomic.AddUint64(&w.sent, 1)
+	}
+}
+
+// dial connects to the target, or to the first local daemon socket found
+func (w *SyslogWriter) dial() (net.Conn, error) {
+	switch w.network {
+	case "tls":
+		d := &net.Dialer{Timeout: syslogWriteTimeout}
+		return tls.DialWithDialer(d, "tcp", w.address, w.tlsConfig)
+	case "udp", "tcp":
+		return net.DialTimeout(w.network, w.address, syslogWriteTimeout)
+	}
+	var lastErr error
+	for _, path := range localSyslogPaths {
+		for _, network := range []string{"unixgram", "unix"} {
+			conn, err := net.DialTimeout(network, path, syslogWriteTimeout)
+			if err == nil {
+				return conn, nil
+			}
+			lastErr = err
+		}
+	}
+	return nil, fmt.Errorf("no local syslog daemon found: %w", lastErr)
+}
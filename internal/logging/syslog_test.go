@@ -0,0 +1,144 @@
+package logging
+
+import (
+	"bufio"
+	"net"
+	"strconv"
+	"strings"
+	"testing"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+)
+
+func blockedEvent() audit.Event {
+	return audit.Event{
+		Timestamp: time.Date(2026, 3, 1, 12, 0, 0, 0, time.UTC),
+		Type:      audit.EventDomainBlocked,
+		Severity:  "warning",
+		Message:   "Domain blocked",
+		Details: map[string]interface{}{
+			"domain":    "ads.example.com",
+			"client_ip": "10.0.0.5",
+			"rule":      `block "ads"`,
+		},
+	}
+}
+
+func TestSyslogWriterUDP(t *testing.T) {
+	pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer pc.Close()
+
+	w, err := NewSyslogWriter(&config.SyslogConfig{
+		Target:     "udp://" + pc.LocalAddr().String(),
+		Format:     SyslogFormatText,
+		Facility:   "local0",
+		AppName:    "dnshield",
+		EventTypes: []string{string(audit.EventDomainBlocked)},
+		QueueSize:  10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	w.Log(audit.Event{Type: audit.EventConfigChange, Timestamp: time.Now()})
+	w.Log(blockedEvent())
+
+	buf := make([]byte, 2048)
+	pc.SetReadDeadline(time.Now().Add(5 * time.Second))
+	n, _, err := pc.ReadFrom(buf)
+	if err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf[:n])
+	// local0 (16) * 8 + warning (4)
+	for _, want := range []string{
+		"<132>1 2026-03-01T12:00:00.000000Z ",
+		" dnshield ",
+		" DOMAIN_BLOCKED [dnshield@32473 ",
+		`client_ip="10.0.0.5"`,
+		`rule="block \"ads\""`,
+		"] Domain blocked",
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Message %q doesn't contain %q", msg, want)
+		}
+	}
+}
+
+func TestSyslogWriterTCPCEF(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	w, err := NewSyslogWriter(&config.SyslogConfig{
+		Target:    "tcp://" + ln.Addr().String(),
+		Format:    SyslogFormatCEF,
+		Facility:  "authpriv",
+		AppName:   "dnshield",
+		QueueSize: 10,
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer w.Stop()
+	w.Log(blockedEvent())
+
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+	r := bufio.NewReader(conn)
+	length, err := r.ReadString(' ')
+	if err != nil {
+		t.Fatal(err)
+	}
+	n, err := strconv.Atoi(strings.TrimSpace(length))
+	if err != nil {
+		t.Fatalf("Message isn't octet-counted: %q", length)
+	}
+	buf := make([]byte, n)
+	if _, err := r.Read(buf); err != nil {
+		t.Fatal(err)
+	}
+	msg := string(buf)
+	for _, want := range []string{
+		"<84>1 ",
+		"CEF:0|DNShield|DNShield Agent|",
+		"|DOMAIN_BLOCKED|Domain blocked|6|",
+		"src=10.0.0.5",
+		"dhost=ads.example.com",
+		`cs1Label=rule cs1=block "ads"`,
+	} {
+		if !strings.Contains(msg, want) {
+			t.Errorf("Message %q doesn't contain %q", msg, want)
+		}
+	}
+}
+
+func TestFormatLEEF(t *testing.T) {
+	event := blockedEvent()
+	event.Message = "Domain\tblocked"
+	msg := formatLEEF(event)
+	if !strings.HasPrefix(msg, "LEEF:1.0|DNShield|DNShield Agent|1.0.0|DOMAIN_BLOCKED|") {
+		t.Errorf("Unexpected LEEF header: %q", msg)
+	}
+	attrs := strings.Split(msg[strings.LastIndex(msg, "|")+1:], "\t")
+	for _, want := range []string{"src=10.0.0.5", "dstHost=ads.example.com", "sev=6", "msg=Domain blocked"} {
+		found := false
+		for _, attr := range attrs {
+			found = found || attr == want
+		}
+		if !found {
+			t.Errorf("Attributes %q don't include %q", attrs, want)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+// Package maintenance pauses or relaxes filtering during recurring
+// maintenance windows, e.g. while systems that need direct resolution are
+// patched
+package maintenance
+
+import (
+	"fmt"
+	"sync"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// maxWait bounds how long the runner sleeps, so clock changes and
+// sleep/wake are noticed
+const maxWait = 15 * time.Minute
+
+// Window is a compiled maintenance window
+type Window struct {
+	dns.Window
+	Name string
+	Mode string // config.MaintenancePause or config.MaintenanceMonitor
+}
+
+// NewWindows compiles the maintenance windows of the configuration
+func NewWindows(cfgs []config.MaintenanceWindowConfig) ([]*Window, error) {
+	windows := make([]*Window, 0, len(cfgs))
+	for _, cfg := range cfgs {
+		window, err := dns.NewWindow(cfg.Timezone, cfg.Days, cfg.Start, cfg.End)
+		if err != nil {
+			return nil, fmt.Errorf("maintenance window %s: %v", cfg.Name, err)
+		}
+		mode := cfg.Mode
+		if mode == "" {
+			mode = config.MaintenancePause
+		}
+		windows = append(windows, &Window{Window: window, Name: cfg.Name, Mode: mode})
+	}
+	return windows, nil
+}
+
+// Actions carry out the maintenance windows
+type Actions struct {
+	// Pause hands DNS back to the network's resolvers until the window
+	// closes
+	Pause func(name string, until time.Time) error
+	// Resume ends a pause started by Pause
+	Resume func(name string)
+	// Monitor turns monitor enforcement on or off
+	Monitor func(on bool)
+}
+
+// Runner opens and closes maintenance windows at their boundaries. Each
+// opening and closing is audited.
+type Runner struct {
+	windows []*Window
+	actions Actions
+	open    map[string]bool // Windows the runner has opened
+	monitor bool
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewRunner creates a runner for windows
+func NewRunner(windows []*Window, actions Actions) *Runner {
+	return &Runner{
+		windows:    windows,
+		actions:    actions,
+		open:       make(map[string]bool),
+		shutdownCh: make(chan struct{}),
+	}
+}
+
+// Start runs the windows in the background. A window that is already
+// open is opened straight away.
+func (r *Runner) Start() {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		for {
+			next := r.apply(time.Now())
+
+			wait := maxWait
+			if !next.IsZero() {
+				if until := time.Until(next); until < wait {
+					wait = until
+				}
+			}
+			timer := time.NewTimer(wait)
+			select {
+			case <-r.shutdownCh:
+				timer.Stop()
+				return
+			case <-timer.C:
+			}
+		}
+	}()
+}
+
+// Stop stops the runner. Open windows are left to end on their own.
+func (r *Runner) Stop() {
+	close(r.shutdownCh)
+	r.wg.Wait()
+}
+
+// apply opens the windows that include now and closes those that don't,
+// and returns the earliest next transition
+func (r *Runner) apply(now time.Time) time.Time {
+	var next time.Time
+	monitor := false
+	for _, w := range r.windows {
+		active := w.Active(now)
+		transition := w.NextTransition(now)
+		if !transition.IsZero() && (next.IsZero() || transition.Before(next)) {
+			next = transition
+		}
+
+		switch {
+		case active && !r.open[w.Name]:
+			r.open[w.Name] = true
+			r.start(w, transition)
+		case !active && r.open[w.Name]:
+			delete(r.open, w.Name)
+			r.end(w)
+		}
+		if active && w.Mode == config.MaintenanceMonitor {
+			monitor = true
+		}
+	}
+
+	if monitor != r.monitor {
+		r.monitor = monitor
+		if r.actions.Monitor != nil {
+			r.actions.Monitor(monitor)
+		}
+	}
+	return next
+}
+
+func (r *Runner) start(w *Window, until time.Time) {
+	details := map[string]interface{}{
+		"window": w.Name,
+		"mode":   w.Mode,
+		"until":  until.UTC().Format(time.RFC3339),
+	}
+	severity := "warning"
+	if w.Mode == config.MaintenancePause && r.actions.Pause != nil {
+		if err := r.actions.Pause(w.Name, until); err != nil {
+			logrus.WithError(err).WithField("window", w.Name).Error("Failed to pause filtering for maintenance")
+			details["error"] = err.Error()
+			severity = "error"
+		}
+	}
+
+	logrus.WithFields(logrus.Fields{"window": w.Name, "mode": w.Mode, "until": until}).Warn("Maintenance window started")
+	audit.Log(audit.EventMaintenanceStarted, severity, fmt.Sprintf("Maintenance window %s started", w.Name), details)
+}
+
+func (r *Runner) end(w *Window) {
+	if w.Mode == config.MaintenancePause && r.actions.Resume != nil {
+		r.actions.Resume(w.Name)
+	}
+
+	logrus.WithFields(logrus.Fields{"window": w.Name, "mode": w.Mode}).Info("Maintenance window ended")
+	audit.Log(audit.EventMaintenanceEnded, "info", fmt.Sprintf("Maintenance window %s ended", w.Name), map[string]interface{}{
+		"window": w.Name,
+		"mode":   w.Mode,
+	})
+}
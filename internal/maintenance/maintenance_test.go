@@ -0,0 +1,71 @@
+package maintenance
+
+import (
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+func TestRunner(t *testing.T) {
+	windows, err := NewWindows([]config.MaintenanceWindowConfig{
+		{Name: "patching", Timezone: "UTC", Days: []string{"sat"}, Start: "02:00", End: "04:00"},
+		{Name: "backups", Timezone: "UTC", Start: "03:00", End: "05:00", Mode: config.MaintenanceMonitor},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var paused, resumed []string
+	var pausedUntil time.Time
+	monitor := false
+	r := NewRunner(windows, Actions{
+		Pause: func(name string, until time.Time) error {
+			paused = append(paused, name)
+			pausedUntil = until
+			return nil
+		},
+		Resume:  func(name string) { resumed = append(resumed, name) },
+		Monitor: func(on bool) { monitor = on },
+	})
+
+	// 2024-01-06 is a Saturday
+	at := func(day, hour int) time.Time {
+		return time.Date(2024, 1, day, hour, 30, 0, 0, time.UTC)
+	}
+
+	if next := r.apply(at(6, 1)); !next.Equal(time.Date(2024, 1, 6, 2, 0, 0, 0, time.UTC)) {
+		t.Errorf("Next transition = %v, want 02:00", next)
+	}
+	if len(paused) != 0 || monitor {
+		t.Fatal("Windows opened before their start")
+	}
+
+	r.apply(at(6, 2))
+	if len(paused) != 1 || paused[0] != "patching" || !pausedUntil.Equal(time.Date(2024, 1, 6, 4, 0, 0, 0, time.UTC)) {
+		t.Fatalf("Unexpected pause: %v until %v", paused, pausedUntil)
+	}
+	r.apply(at(6, 3))
+	if len(paused) != 1 || !monitor {
+		t.Errorf("Expected the monitor window to open without pausing again: paused %v, monitor %v", paused, monitor)
+	}
+
+	r.apply(at(6, 4))
+	if len(resumed) != 1 || resumed[0] != "patching" || !monitor {
+		t.Errorf("Expected the pause to end and monitoring to go on: resumed %v, monitor %v", resumed, monitor)
+	}
+	r.apply(at(6, 5))
+	if monitor {
+		t.Error("Monitoring still on after the window closed")
+	}
+
+	// Sunday: only the daily window opens
+	r.apply(at(7, 2))
+	if len(paused) != 1 {
+		t.Errorf("Saturday window opened on Sunday: %v", paused)
+	}
+
+	if _, err := NewWindows([]config.MaintenanceWindowConfig{{Name: "bad", Days: []string{"someday"}, Start: "02:00", End: "04:00"}}); err == nil {
+		t.Error("NewWindows accepted an invalid day")
+	}
+}
@@ -0,0 +1,203 @@
+// Package mirror forwards a copy of DNS query metadata to an external
+// analysis endpoint. Mirroring is best effort and never blocks or delays
+// the DNS response path: records are queued and dropped when the queue is
+// full or the endpoint is unreachable.
+package mirror
+
+import (
+	"encoding/json"
+	"fmt"
+	"math/rand"
+	"net"
+	"net/url"
+	"os"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	writeTimeout   = time.Second
+	minDialBackoff = time.Second
+	maxDialBackoff = 30 * time.Second
+)
+
+// Record is the JSON document sent for each mirrored query
+type Record struct {
+	Timestamp     time.Time `json:"timestamp"`
+	Host          string    `json:"host"`
+	Domain        string    `json:"domain"`
+	QueryType     string    `json:"query_type"`
+	ClientIP      string    `json:"client_ip,omitempty"`
+	Action        string    `json:"action"`
+	Rcode         string    `json:"rcode"`
+	Upstream      string    `json:"upstream,omitempty"`
+	UpstreamRTTMs float64   `json:"upstream_rtt_ms,omitempty"`
+	DurationMs    float64   `json:"duration_ms"`
+}
+
+// Mirror sends sampled query records to a unix, unixgram, udp or tcp target
+type Mirror struct {
+	network         string
+	address         string
+	sampleRate      float64
+	includeClientIP bool
+	hostname        string
+
+	queue   chan []byte
+	sent    uint64
+	dropped uint64
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// ParseTarget splits a mirror target such as "udp://10.0.0.5:9000" or
+// "unix:///var/run/dns-mirror.sock" into a network and address
+func ParseTarget(target string) (network, address string, err error) {
+	u, err := url.Parse(target)
+	if err != nil {
+		return "", "", fmt.Errorf("invalid mirror target: %v", err)
+	}
+
+	switch u.Scheme {
+	case "unix", "unixgram":
+		if u.Path == "" {
+			return "", "", fmt.Errorf("mirror target %q has no socket path", target)
+		}
+		return u.Scheme, u.Path, nil
+	case "udp", "tcp":
+		if _, _, err := net.SplitHostPort(u.Host); err != nil {
+			return "", "", fmt.Errorf("mirror target %q must include host and port", target)
+		}
+		return u.Scheme, u.Host, nil
+	}
+	return "", "", fmt.Errorf("unsupported mirror target scheme %q (use unix, unixgram, udp or tcp)", u.Scheme)
+}
+
+// New creates a mirror for cfg and starts its sender
+func New(cfg *config.MirrorConfig) (*Mirror, error) {
+	network, address, err := ParseTarget(cfg.Target)
+	if err != nil {
+		return nil, err
+	}
+
+	hostname, _ := os.Hostname()
+	m := &Mirror{
+		network:         network,
+		address:         address,
+		sampleRate:      cfg.SampleRate,
+		includeClientIP: cfg.IncludeClientIP,
+		hostname:        hostname,
+		queue:           make(chan []byte, cfg.QueueSize),
+		shutdownCh:      make(chan struct{}),
+	}
+
+	m.wg.Add(1)
+	go m.sender()
+
+	return m, nil
+}
+
+// Mirror queues a sampled copy of event. It never blocks.
+func (m *Mirror) Mirror(event dns.QueryEvent) {
+	if m.sampleRate < 1 && rand.Float64() >= m.sampleRate {
+		return
+	}
+
+	record := Record{
+		Timestamp:     event.Timestamp,
+		Host:          m.hostname,
+		Domain:        event.Domain,
+		QueryType:     event.QueryType,
+		Action:        event.Action,
+		Rcode:         event.Rcode,
+		Upstream:      event.Upstream,
+		UpstreamRTTMs: float64(event.UpstreamRTT) / float64(time.Millisecond),
+		DurationMs:    float64(event.Duration) / float64(time.Millisecond),
+	}
+	if m.includeClientIP {
+		record.ClientIP = event.ClientIP
+	}
+
+	data, err := json.Marshal(record)
+	if err != nil {
+		return
+	}
+
+	select {
+	case m.queue <- append(data, '\n'):
+	default:
+		atomic.AddUint64(&m.dropped, 1)
+	}
+}
+
+// Stats returns the number of records sent and dropped so far
+func (m *Mirror) Stats() (sent, dropped uint64) {
+	return atomic.LoadUint64(&m.sent), atomic.LoadUint64(&m.dropped)
+}
+
+// Stop stops the sender. Queued records are discarded.
+func (m *Mirror) Stop() {
+	close(m.shutdownCh)
+	m.wg.Wait()
+}
+
+// sender drains the queue to the target, reconnecting with backoff.
+// Records arriving while the target is unreachable are dropped.
+func (m *Mirror) sender() {
+	defer m.wg.Done()
+
+	var conn net.Conn
+	var nextDial time.Time
+	backoff := minDialBackoff
+
+	defer func() {
+		if conn != nil {
+			conn.Close()
+		}
+	}()
+
+	for {
+		var data []byte
+		select {
+		case <-m.shutdownCh:
+			return
+		case data = <-m.queue:
+		}
+
+		if conn == nil {
+			if time.Now().Before(nextDial) {
+				atomic.AddUint64(&m.dropped, 1)
+				continue
+			}
+			c, err := net.DialTimeout(m.network, m.address, writeTimeout)
+			if err != nil {
+				logrus.WithError(err).WithField("target", m.network+"://"+m.address).
+					Warn("Query mirror target unreachable")
+				nextDial = time.Now().Add(backoff)
+				if backoff *= 2; backoff > maxDialBackoff {
+					backoff = maxDialBackoff
+				}
+				atomic.AddUint64(&m.dropped, 1)
+				continue
+			}
+			conn = c
+			backoff = minDialBackoff
+		}
+
+		conn.SetWriteDeadline(time.Now().Add(writeTimeout))
+		if _, err := conn.Write(data); err != nil {
+			logrus.WithError(err).Debug("Query mirror write failed, reconnecting")
+			conn.Close()
+			conn = nil
+			atomic.AddUint64(&m.dropped, 1)
+			continue
+		}
+		atomic.AddUint64(&m.sent, 1)
+	}
+}
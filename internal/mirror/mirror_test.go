@@ -0,0 +1,88 @@
+package mirror
+
+import (
+	"bufio"
+	"encoding/json"
+	"net"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+)
+
+func TestParseTarget(t *testing.T) {
+	tests := []struct {
+		target  string
+		network string
+		address string
+		wantErr bool
+	}{
+		{"udp://10.0.0.5:9000", "udp", "10.0.0.5:9000", false},
+		{"tcp://collector.example.com:5140", "tcp", "collector.example.com:5140", false},
+		{"unix:///var/run/dns-mirror.sock", "unix", "/var/run/dns-mirror.sock", false},
+		{"unixgram:///tmp/mirror.sock", "unixgram", "/tmp/mirror.sock", false},
+		{"udp://10.0.0.5", "", "", true},
+		{"unix://", "", "", true},
+		{"http://collector:80", "", "", true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.target, func(t *testing.T) {
+			network, address, err := ParseTarget(tt.target)
+			if (err != nil) != tt.wantErr {
+				t.Fatalf("ParseTarget(%q) error = %v, wantErr %v", tt.target, err, tt.wantErr)
+			}
+			if network != tt.network || address != tt.address {
+				t.Errorf("ParseTarget(%q) = %s, %s; want %s, %s", tt.target, network, address, tt.network, tt.address)
+			}
+		})
+	}
+}
+
+func TestMirrorSendsRecords(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer ln.Close()
+
+	m, err := New(&config.MirrorConfig{Target: "tcp://" + ln.Addr().String(), SampleRate: 1, QueueSize: 10})
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer m.Stop()
+
+	m.Mirror(dns.QueryEvent{
+		Timestamp: time.Now(),
+		Domain:    "example.com",
+		QueryType: "A",
+		ClientIP:  "192.168.1.20",
+		Action:    dns.QueryActionAllowed,
+		Rcode:     "NOERROR",
+		Upstream:  "1.1.1.1:53",
+	})
+
+	ln.(*net.TCPListener).SetDeadline(time.Now().Add(5 * time.Second))
+	conn, err := ln.Accept()
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer conn.Close()
+	conn.SetReadDeadline(time.Now().Add(5 * time.Second))
+
+	line, err := bufio.NewReader(conn).ReadBytes('\n')
+	if err != nil {
+		t.Fatal(err)
+	}
+	var record Record
+	if err := json.Unmarshal(line, &record); err != nil {
+		t.Fatalf("Invalid record %q: %v", line, err)
+	}
+	if record.Domain != "example.com" || record.Action != dns.QueryActionAllowed {
+		t.Errorf("Unexpected record %+v", record)
+	}
+	if record.ClientIP != "" {
+		t.Error("Client IP must be omitted unless includeClientIP is set")
+	}
+}
@@ -0,0 +1,232 @@
+// Package mobileconfig builds macOS configuration profiles that deploy
+// DNShield's CA trust and DNS settings through MDM (Jamf, Kandji, ...)
+package mobileconfig
+
+import (
+	"bytes"
+	"crypto/rand"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/xml"
+	"fmt"
+	"net"
+	"net/url"
+)
+
+// DNS protocols of the DNS Settings payload, which only supports
+// encrypted DNS
+const (
+	DNSProtocolHTTPS = "HTTPS"
+	DNSProtocolTLS   = "TLS"
+)
+
+// Options describes the profile to generate
+type Options struct {
+	// Identifier is the profile's reverse-DNS identifier; payloads are
+	// named under it. Profiles with the same identifier replace each other.
+	Identifier   string
+	DisplayName  string
+	Organization string
+
+	// CA is trusted as a root for TLS through a Certificate payload
+	CA *x509.Certificate
+
+	// DNS Settings payload, included when DNSProtocol is set: ServerURL
+	// for HTTPS, ServerName for TLS, and optionally ServerAddresses to
+	// reach the server without a lookup
+	DNSProtocol     string
+	ServerURL       string
+	ServerName      string
+	ServerAddresses []string
+}
+
+// Generate renders the profile as an XML property list
+func Generate(opts Options) ([]byte, error) {
+	if opts.Identifier == "" {
+		return nil, fmt.Errorf("profile identifier is required")
+	}
+	if opts.CA == nil {
+		return nil, fmt.Errorf("CA certificate is required")
+	}
+
+	var payloads []dict
+	caUUID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	payloads = append(payloads, dict{
+		{"PayloadType", "com.apple.security.root"},
+		{"PayloadVersion", 1},
+		{"PayloadIdentifier", opts.Identifier + ".ca"},
+		{"PayloadUUID", caUUID},
+		{"PayloadDisplayName", "DNShield CA"},
+		{"PayloadCertificateFileName", "dnshield-ca.cer"},
+		{"PayloadContent", opts.CA.Raw},
+	})
+
+	if opts.DNSProtocol != "" {
+		settings, err := dnsSettings(opts)
+		if err != nil {
+			return nil, err
+		}
+		dnsUUID, err := newUUID()
+		if err != nil {
+			return nil, err
+		}
+		payloads = append(payloads, dict{
+			{"PayloadType", "com.apple.dnsSettings.managed"},
+			{"PayloadVersion", 1},
+			{"PayloadIdentifier", opts.Identifier + ".dns"},
+			{"PayloadUUID", dnsUUID},
+			{"PayloadDisplayName", "DNShield DNS"},
+			{"DNSSettings", settings},
+		})
+	}
+
+	profileUUID, err := newUUID()
+	if err != nil {
+		return nil, err
+	}
+	displayName := opts.DisplayName
+	if displayName == "" {
+		displayName = "DNShield"
+	}
+	profile := dict{
+		{"PayloadType", "Configuration"},
+		{"PayloadVersion", 1},
+		{"PayloadIdentifier", opts.Identifier},
+		{"PayloadUUID", profileUUID},
+		{"PayloadDisplayName", displayName},
+		{"PayloadScope", "System"},
+		{"PayloadContent", payloads},
+	}
+	if opts.Organization != "" {
+		profile = append(profile, entry{"PayloadOrganization", opts.Organization})
+	}
+
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	buf.WriteString(`<!DOCTYPE plist PUBLIC "-//Apple//DTD PLIST 1.0//EN" "http://www.apple.com/DTDs/PropertyList-1.0.dtd">` + "\n")
+	buf.WriteString(`<plist version="1.0">` + "\n")
+	if err := writeValue(&buf, profile, 0); err != nil {
+		return nil, err
+	}
+	buf.WriteString("</plist>\n")
+	return buf.Bytes(), nil
+}
+
+// dnsSettings builds the DNSSettings dictionary of the DNS Settings payload
+func dnsSettings(opts Options) (dict, error) {
+	settings := dict{{"DNSProtocol", opts.DNSProtocol}}
+	switch opts.DNSProtocol {
+	case DNSProtocolHTTPS:
+		u, err := url.Parse(opts.ServerURL)
+		if err != nil || u.Scheme != "https" || u.Host == "" {
+			return nil, fmt.Errorf("DNS-over-HTTPS needs an https:// server URL, got %q", opts.ServerURL)
+		}
+		settings = append(settings, entry{"ServerURL", opts.ServerURL})
+	case DNSProtocolTLS:
+		if opts.ServerName == "" {
+			return nil, fmt.Errorf("DNS-over-TLS needs a server name")
+		}
+		settings = append(settings, entry{"ServerName", opts.ServerName})
+	default:
+		return nil, fmt.Errorf("unsupported DNS protocol %q (must be %s or %s)", opts.DNSProtocol, DNSProtocolHTTPS, DNSProtocolTLS)
+	}
+	if len(opts.ServerAddresses) > 0 {
+		for _, addr := range opts.ServerAddresses {
+			if net.ParseIP(addr) == nil {
+				return nil, fmt.Errorf("invalid DNS server address %q", addr)
+			}
+		}
+		settings = append(settings, entry{"ServerAddresses", opts.ServerAddresses})
+	}
+	return settings, nil
+}
+
+// entry is a key and value of a plist dictionary; dict keeps them in order
+// so generated profiles are stable and readable
+type entry struct {
+	key   string
+	value interface{}
+}
+
+type dict []entry
+
+// writeValue writes a plist value indented by depth tabs
+func writeValue(buf *bytes.Buffer, value interface{}, depth int) error {
+	indent := func(d int) {
+		for i := 0; i < d; i++ {
+			buf.WriteByte('\t')
+		}
+	}
+	escaped := func(s string) string {
+		var b bytes.Buffer
+		xml.EscapeText(&b, []byte(s))
+		return b.String()
+	}
+
+	indent(depth)
+	switch v := value.(type) {
+	case string:
+		fmt.Fprintf(buf, "<string>%s</string>\n", escaped(v))
+	case int:
+		fmt.Fprintf(buf, "<integer>%d</integer>\n", v)
+	case []byte:
+		buf.WriteString("<data>\n")
+		encoded := base64.StdEncoding.EncodeToString(v)
+		for len(encoded) > 0 {
+			n := 64
+			if len(encoded) < n {
+				n = len(encoded)
+			}
+			indent(depth)
+			buf.WriteString(encoded[:n] + "\n")
+			encoded = encoded[n:]
+		}
+		indent(depth)
+		buf.WriteString("</data>\n")
+	case []string:
+		buf.WriteString("<array>\n")
+		for _, s := range v {
+			writeValue(buf, s, depth+1)
+		}
+		indent(depth)
+		buf.WriteString("</array>\n")
+	case []dict:
+		buf.WriteString("<array>\n")
+		for _, d := range v {
+			if err := writeValue(buf, d, depth+1); err != nil {
+				return err
+			}
+		}
+		indent(depth)
+		buf.WriteString("</array>\n")
+	case dict:
+		buf.WriteString("<dict>\n")
+		for _, e := range v {
+			indent(depth + 1)
+			fmt.Fprintf(buf, "<key>%s</key>\n", escaped(e.key))
+			if err := writeValue(buf, e.value, depth+1); err != nil {
+				return err
+			}
+		}
+		indent(depth)
+		buf.WriteString("</dict>\n")
+	default:
+		return fmt.Errorf("unsupported plist value %T", value)
+	}
+	return nil
+}
+
+// newUUID returns a random (version 4) UUID in upper case, as profiles
+// conventionally use
+func newUUID() (string, error) {
+	var b [16]byte
+	if _, err := rand.Read(b[:]); err != nil {
+		return "", err
+	}
+	b[6] = b[6]&0x0f | 0x40
+	b[8] = b[8]&0x3f | 0x80
+	return fmt.Sprintf("%X-%X-%X-%X-%X", b[0:4], b[4:6], b[6:8], b[8:10], b[10:16]), nil
+}
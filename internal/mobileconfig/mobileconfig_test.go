@@ -0,0 +1,104 @@
+package mobileconfig
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/xml"
+	"io"
+	"math/big"
+	"strings"
+	"testing"
+	"time"
+)
+
+func testCA(t *testing.T) *x509.Certificate {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "DNShield Test CA"},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  true,
+		BasicConstraintsValid: true,
+		KeyUsage:              x509.KeyUsageCertSign,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert
+}
+
+func TestGenerate(t *testing.T) {
+	profile, err := Generate(Options{
+		Identifier:      "com.example.dnshield",
+		Organization:    "Example & Co",
+		CA:              testCA(t),
+		DNSProtocol:     DNSProtocolHTTPS,
+		ServerURL:       "https://dns.example.com/dns-query",
+		ServerAddresses: []string{"192.0.2.53", "2001:db8::53"},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Well-formed XML
+	dec := xml.NewDecoder(bytes.NewReader(profile))
+	for {
+		if _, err := dec.Token(); err == io.EOF {
+			break
+		} else if err != nil {
+			t.Fatalf("profile is not valid XML: %v\n%s", err, profile)
+		}
+	}
+
+	for _, want := range []string{
+		"<string>com.apple.security.root</string>",
+		"<string>com.apple.dnsSettings.managed</string>",
+		"<string>com.example.dnshield.ca</string>",
+		"<string>https://dns.example.com/dns-query</string>",
+		"<string>2001:db8::53</string>",
+		"<string>Example &amp; Co</string>",
+		"<data>",
+	} {
+		if !strings.Contains(string(profile), want) {
+			t.Errorf("profile lacks %s:\n%s", want, profile)
+		}
+	}
+
+	// Without DNS settings only the CA payload is included
+	profile, err = Generate(Options{Identifier: "com.example.dnshield", CA: testCA(t)})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if strings.Contains(string(profile), "dnsSettings") {
+		t.Error("profile has a DNS Settings payload without a DNS protocol")
+	}
+}
+
+func TestGenerateRejectsInvalidDNSSettings(t *testing.T) {
+	ca := testCA(t)
+	for _, opts := range []Options{
+		{Identifier: "x", CA: ca, DNSProtocol: DNSProtocolHTTPS, ServerURL: "http://dns.example.com/dns-query"},
+		{Identifier: "x", CA: ca, DNSProtocol: DNSProtocolTLS},
+		{Identifier: "x", CA: ca, DNSProtocol: "UDP"},
+		{Identifier: "x", CA: ca, DNSProtocol: DNSProtocolTLS, ServerName: "dns.example.com", ServerAddresses: []string{"not-an-ip"}},
+		{Identifier: "x"},
+	} {
+		if _, err := Generate(opts); err == nil {
+			t.Errorf("Generate(%+v) succeeded, want an error", opts)
+		}
+	}
+}
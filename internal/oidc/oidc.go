@@ -0,0 +1,241 @@
+// Package oidc resolves the user of a device from an OpenID Connect
+// identity provider such as Okta, with the device authorization grant
+package oidc
+
+import (
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"time"
+)
+
+// DefaultScopes are requested when oidc.scopes is not set
+var DefaultScopes = []string{"openid", "email", "profile", "groups", "offline_access"}
+
+const deviceCodeGrant = "urn:ietf:params:oauth:grant-type:device_code"
+
+// defaultPollInterval is used when the provider doesn't give an interval
+var defaultPollInterval = 5 * time.Second
+
+// Provider is an identity provider's discovery document
+type Provider struct {
+	Issuer                      string `json:"issuer"`
+	DeviceAuthorizationEndpoint string `json:"device_authorization_endpoint"`
+	TokenEndpoint               string `json:"token_endpoint"`
+	JWKSURI                     string `json:"jwks_uri"`
+}
+
+// Client talks to one identity provider as a public client
+type Client struct {
+	issuer   string
+	clientID string
+	scopes   []string
+	client   *http.Client
+
+	provider *Provider
+	keys     *keySet
+}
+
+// NewClient returns a client for issuer, such as https://example.okta.com
+func NewClient(issuer, clientID string, scopes []string) *Client {
+	if len(scopes) == 0 {
+		scopes = DefaultScopes
+	}
+	return &Client{
+		issuer:   strings.TrimSuffix(issuer, "/"),
+		clientID: clientID,
+		scopes:   scopes,
+		client:   &http.Client{Timeout: 30 * time.Second},
+	}
+}
+
+// Discover fetches the issuer's discovery document, once
+func (c *Client) Discover(ctx context.Context) (*Provider, error) {
+	if c.provider != nil {
+		return c.provider, nil
+	}
+	var provider Provider
+	if err := c.getJSON(ctx, c.issuer+"/.well-known/openid-configuration", &provider); err != nil {
+		return nil, fmt.Errorf("OIDC discovery failed: %v", err)
+	}
+	if strings.TrimSuffix(provider.Issuer, "/") != c.issuer {
+		return nil, fmt.Errorf("OIDC discovery returned issuer %q, want %q", provider.Issuer, c.issuer)
+	}
+	if provider.TokenEndpoint == "" || provider.JWKSURI == "" {
+		return nil, fmt.Errorf("OIDC discovery document has no token endpoint or JWKS")
+	}
+	c.provider = &provider
+	c.keys = &keySet{uri: provider.JWKSURI, client: c.client}
+	return c.provider, nil
+}
+
+// DeviceAuthorization is a pending device authorization
+type DeviceAuthorization struct {
+	DeviceCode              string `json:"device_code"`
+	UserCode                string `json:"user_code"`
+	VerificationURI         string `json:"verification_uri"`
+	VerificationURIComplete string `json:"verification_uri_complete"`
+	ExpiresIn               int    `json:"expires_in"`
+	Interval                int    `json:"interval"`
+}
+
+// StartDeviceAuthorization asks for a user code to approve this device with
+func (c *Client) StartDeviceAuthorization(ctx context.Context) (*DeviceAuthorization, error) {
+	provider, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	if provider.DeviceAuthorizationEndpoint == "" {
+		return nil, fmt.Errorf("%s does not support the device authorization grant", c.issuer)
+	}
+	var auth DeviceAuthorization
+	form := url.Values{"client_id": {c.clientID}, "scope": {strings.Join(c.scopes, " ")}}
+	if err := c.postForm(ctx, provider.DeviceAuthorizationEndpoint, form, &auth); err != nil {
+		return nil, fmt.Errorf("device authorization failed: %v", err)
+	}
+	return &auth, nil
+}
+
+// PollDeviceToken waits for the user to approve auth and returns the
+// tokens issued
+func (c *Client) PollDeviceToken(ctx context.Context, auth *DeviceAuthorization) (*Token, error) {
+	provider, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	interval := time.Duration(auth.Interval) * time.Second
+	if interval <= 0 {
+		interval = defaultPollInterval
+	}
+	deadline := time.Now().Add(time.Duration(auth.ExpiresIn) * time.Second)
+	form := url.Values{"client_id": {c.clientID}, "grant_type": {deviceCodeGrant}, "device_code": {auth.DeviceCode}}
+	for {
+		if auth.ExpiresIn > 0 && time.Now().After(deadline) {
+			return nil, fmt.Errorf("device authorization expired")
+		}
+		select {
+		case <-ctx.Done():
+			return nil, ctx.Err()
+		case <-time.After(interval):
+		}
+
+		token, err := c.requestToken(ctx, provider.TokenEndpoint, form)
+		var tokenErr *tokenError
+		switch {
+		case err == nil:
+			return token, nil
+		case asTokenError(err, &tokenErr) && tokenErr.Code == "authorization_pending":
+		case asTokenError(err, &tokenErr) && tokenErr.Code == "slow_down":
+			interval += 5 * time.Second
+		default:
+			return nil, err
+		}
+	}
+}
+
+// Refresh exchanges token's refresh token for new tokens
+func (c *Client) Refresh(ctx context.Context, token *Token) (*Token, error) {
+	if token.RefreshToken == "" {
+		return nil, fmt.Errorf("no refresh token; run dnshield login again")
+	}
+	provider, err := c.Discover(ctx)
+	if err != nil {
+		return nil, err
+	}
+	form := url.Values{
+		"client_id":     {c.clientID},
+		"grant_type":    {"refresh_token"},
+		"refresh_token": {token.RefreshToken},
+		"scope":         {strings.Join(c.scopes, " ")},
+	}
+	refreshed, err := c.requestToken(ctx, provider.TokenEndpoint, form)
+	if err != nil {
+		return nil, err
+	}
+	// Providers that don't rotate refresh tokens leave it out
+	if refreshed.RefreshToken == "" {
+		refreshed.RefreshToken = token.RefreshToken
+	}
+	return refreshed, nil
+}
+
+// tokenError is an OAuth error response from the token endpoint
+type tokenError struct {
+	Code        string `json:"error"`
+	Description string `json:"error_description"`
+}
+
+func (e *tokenError) Error() string {
+	if e.Description != "" {
+		return e.Code + ": " + e.Description
+	}
+	return e.Code
+}
+
+func asTokenError(err error, target **tokenError) bool {
+	e, ok := err.(*tokenError)
+	if ok {
+		*target = e
+	}
+	return ok
+}
+
+func (c *Client) requestToken(ctx context.Context, endpoint string, form url.Values) (*Token, error) {
+	var result struct {
+		IDToken      string `json:"id_token"`
+		RefreshToken string `json:"refresh_token"`
+		tokenError
+	}
+	err := c.postForm(ctx, endpoint, form, &result)
+	if result.Code != "" {
+		return nil, &result.tokenError
+	}
+	if err != nil {
+		return nil, fmt.Errorf("token request failed: %v", err)
+	}
+	if result.IDToken == "" {
+		return nil, fmt.Errorf("token response has no ID token; is the openid scope allowed?")
+	}
+	return &Token{IDToken: result.IDToken, RefreshToken: result.RefreshToken}, nil
+}
+
+// postForm posts form to endpoint and decodes the JSON response into v.
+// Error responses are decoded too, so OAuth error codes can be read.
+func (c *Client) postForm(ctx context.Context, endpoint string, form url.Values, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, endpoint, strings.NewReader(form.Encode()))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	decodeErr := json.NewDecoder(resp.Body).Decode(v)
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return decodeErr
+}
+
+func (c *Client) getJSON(ctx context.Context, endpoint string, v interface{}) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint, nil)
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Accept", "application/json")
+	resp, err := c.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s", resp.Status)
+	}
+	return json.NewDecoder(resp.Body).Decode(v)
+}
@@ -0,0 +1,163 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"math/big"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// testProvider is an identity provider issuing ID tokens signed with key
+type testProvider struct {
+	*httptest.Server
+	key       *rsa.PrivateKey
+	polls     int
+	expiry    time.Duration
+	refreshes int
+}
+
+func newTestProvider(t *testing.T) *testProvider {
+	key, err := rsa.GenerateKey(rand.Reader, 2048)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p := &testProvider{key: key, expiry: time.Hour}
+	mux := http.NewServeMux()
+	mux.HandleFunc("/.well-known/openid-configuration", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(Provider{
+			Issuer:                      p.URL,
+			DeviceAuthorizationEndpoint: p.URL + "/device",
+			TokenEndpoint:               p.URL + "/token",
+			JWKSURI:                     p.URL + "/keys",
+		})
+	})
+	mux.HandleFunc("/keys", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(map[string]interface{}{"keys": []map[string]string{{
+			"kid": "k1",
+			"kty": "RSA",
+			"n":   base64.RawURLEncoding.EncodeToString(key.N.Bytes()),
+			"e":   base64.RawURLEncoding.EncodeToString(big.NewInt(int64(key.E)).Bytes()),
+		}}})
+	})
+	mux.HandleFunc("/device", func(w http.ResponseWriter, r *http.Request) {
+		json.NewEncoder(w).Encode(DeviceAuthorization{
+			DeviceCode: "dc", UserCode: "ABCD-EFGH", VerificationURI: p.URL + "/activate", ExpiresIn: 60, Interval: 0,
+		})
+	})
+	mux.HandleFunc("/token", func(w http.ResponseWriter, r *http.Request) {
+		r.ParseForm()
+		switch r.Form.Get("grant_type") {
+		case deviceCodeGrant:
+			p.polls++
+			if p.polls == 1 {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "authorization_pending"})
+				return
+			}
+		case "refresh_token":
+			p.refreshes++
+			if r.Form.Get("refresh_token") != "rt" {
+				w.WriteHeader(http.StatusBadRequest)
+				json.NewEncoder(w).Encode(map[string]string{"error": "invalid_grant"})
+				return
+			}
+		}
+		json.NewEncoder(w).Encode(map[string]string{"id_token": p.sign(t, "client", p.expiry), "refresh_token": "rt"})
+	})
+	p.Server = httptest.NewServer(mux)
+	return p
+}
+
+func (p *testProvider) sign(t *testing.T, aud string, expiry time.Duration) string {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","kid":"k1"}`))
+	claims, _ := json.Marshal(map[string]interface{}{
+		"iss":    p.URL,
+		"aud":    aud,
+		"sub":    "00u1",
+		"email":  "alice@example.com",
+		"groups": []string{"Everyone", "Engineering"},
+		"exp":    time.Now().Add(expiry).Unix(),
+	})
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, p.key, crypto.SHA256, sum[:])
+	if err != nil {
+		t.Fatal(err)
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature)
+}
+
+func TestDeviceLogin(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	ctx := context.Background()
+	client := NewClient(p.URL, "client", nil)
+	defer func(interval time.Duration) { defaultPollInterval = interval }(defaultPollInterval)
+	defaultPollInterval = 10 * time.Millisecond
+
+	auth, err := client.StartDeviceAuthorization(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	token, err := client.PollDeviceToken(ctx, auth)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if p.polls != 2 {
+		t.Errorf("polled %d times, want 2 (pending, then approved)", p.polls)
+	}
+
+	identity, err := client.Verify(ctx, token.IDToken, "email", "groups")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Email != "alice@example.com" || len(identity.Groups) != 2 || identity.Groups[1] != "Engineering" {
+		t.Errorf("identity = %+v", identity)
+	}
+
+	if _, err := client.Verify(ctx, p.sign(t, "other-client", time.Hour), "email", "groups"); err == nil {
+		t.Error("accepted an ID token for another client")
+	}
+	tampered := token.IDToken[:len(token.IDToken)-4] + "AAAA"
+	if _, err := client.Verify(ctx, tampered, "email", "groups"); err == nil {
+		t.Error("accepted an ID token with an invalid signature")
+	}
+}
+
+func TestResolverRefresh(t *testing.T) {
+	p := newTestProvider(t)
+	defer p.Close()
+	ctx := context.Background()
+	path := filepath.Join(t.TempDir(), "oidc-token.json")
+	resolver := NewResolver(NewClient(p.URL, "client", nil), path, "email", "groups")
+
+	if _, err := resolver.Identity(ctx); err == nil {
+		t.Fatal("resolved a user without a login")
+	}
+
+	expired := &Token{IDToken: p.sign(t, "client", -time.Hour), RefreshToken: "rt"}
+	if err := expired.Save(path); err != nil {
+		t.Fatal(err)
+	}
+	identity, err := resolver.Identity(ctx)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if identity.Email != "alice@example.com" || p.refreshes != 1 {
+		t.Errorf("identity = %+v after %d refreshes", identity, p.refreshes)
+	}
+
+	// The refreshed token was saved, so it isn't refreshed again
+	if _, err := resolver.Identity(ctx); err != nil || p.refreshes != 1 {
+		t.Errorf("Identity = %v after %d refreshes, want the saved token", err, p.refreshes)
+	}
+}
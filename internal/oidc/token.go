@@ -0,0 +1,295 @@
+package oidc
+
+import (
+	"context"
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rsa"
+	"crypto/sha256"
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math/big"
+	"net/http"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+)
+
+// clockSkew is the leeway allowed on ID token expiry
+const clockSkew = 2 * time.Minute
+
+// Token holds the tokens issued to this device
+type Token struct {
+	IDToken      string `json:"id_token"`
+	RefreshToken string `json:"refresh_token,omitempty"`
+}
+
+// LoadToken reads the tokens saved at path
+func LoadToken(path string) (*Token, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	var token Token
+	if err := json.Unmarshal(data, &token); err != nil {
+		return nil, fmt.Errorf("invalid token file %s: %v", path, err)
+	}
+	return &token, nil
+}
+
+// Save writes the tokens to path, readable only by the owner
+func (t *Token) Save(path string) error {
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return err
+	}
+	data, err := json.Marshal(t)
+	if err != nil {
+		return err
+	}
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// Identity is the verified user of an ID token
+type Identity struct {
+	Subject string
+	Email   string
+	Groups  []string
+	Expiry  time.Time
+}
+
+// Verify checks the signature, issuer, audience and expiry of an ID token
+// and returns its user. emailClaim and groupsClaim name the claims holding
+// the email address and groups.
+func (c *Client) Verify(ctx context.Context, idToken, emailClaim, groupsClaim string) (*Identity, error) {
+	if _, err := c.Discover(ctx); err != nil {
+		return nil, err
+	}
+	parts := strings.Split(idToken, ".")
+	if len(parts) != 3 {
+		return nil, fmt.Errorf("malformed ID token")
+	}
+	var header struct {
+		Alg string `json:"alg"`
+		Kid string `json:"kid"`
+	}
+	if err := decodeSegment(parts[0], &header); err != nil {
+		return nil, fmt.Errorf("malformed ID token header")
+	}
+	signature, err := base64.RawURLEncoding.DecodeString(parts[2])
+	if err != nil {
+		return nil, fmt.Errorf("malformed ID token signature")
+	}
+	key, err := c.keys.get(ctx, header.Kid)
+	if err != nil {
+		return nil, err
+	}
+	if err := verifySignature(header.Alg, key, parts[0]+"."+parts[1], signature); err != nil {
+		return nil, err
+	}
+
+	var claims map[string]interface{}
+	if err := decodeSegment(parts[1], &claims); err != nil {
+		return nil, fmt.Errorf("malformed ID token claims")
+	}
+	if iss, _ := claims["iss"].(string); strings.TrimSuffix(iss, "/") != c.issuer {
+		return nil, fmt.Errorf("ID token issued by %q, want %q", iss, c.issuer)
+	}
+	if !hasAudience(claims["aud"], c.clientID) {
+		return nil, fmt.Errorf("ID token is not for client %s", c.clientID)
+	}
+	exp, _ := claims["exp"].(float64)
+	identity := &Identity{Expiry: time.Unix(int64(exp), 0)}
+	if time.Now().After(identity.Expiry.Add(clockSkew)) {
+		return nil, errExpired
+	}
+	identity.Subject, _ = claims["sub"].(string)
+	identity.Email, _ = claims[emailClaim].(string)
+	if identity.Email == "" {
+		return nil, fmt.Errorf("ID token has no %s claim", emailClaim)
+	}
+	switch groups := claims[groupsClaim].(type) {
+	case []interface{}:
+		for _, group := range groups {
+			if name, ok := group.(string); ok {
+				identity.Groups = append(identity.Groups, name)
+			}
+		}
+	case string:
+		identity.Groups = []string{groups}
+	}
+	return identity, nil
+}
+
+var errExpired = fmt.Errorf("ID token expired")
+
+func hasAudience(aud interface{}, clientID string) bool {
+	switch aud := aud.(type) {
+	case string:
+		return aud == clientID
+	case []interface{}:
+		for _, a := range aud {
+			if a == clientID {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func decodeSegment(segment string, v interface{}) error {
+	data, err := base64.RawURLEncoding.DecodeString(segment)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+func verifySignature(alg string, key crypto.PublicKey, signed string, signature []byte) error {
+	sum := sha256.Sum256([]byte(signed))
+	switch alg {
+	case "RS256":
+		if pub, ok := key.(*rsa.PublicKey); ok && rsa.VerifyPKCS1v15(pub, crypto.SHA256, sum[:], signature) == nil {
+			return nil
+		}
+	case "ES256":
+		pub, ok := key.(*ecdsa.PublicKey)
+		if ok && len(signature) == 64 {
+			r := new(big.Int).SetBytes(signature[:32])
+			s := new(big.Int).SetBytes(signature[32:])
+			if ecdsa.Verify(pub, sum[:], r, s) {
+				return nil
+			}
+		}
+	default:
+		return fmt.Errorf("unsupported ID token algorithm %q", alg)
+	}
+	return fmt.Errorf("invalid ID token signature")
+}
+
+// keySet caches the provider's signing keys, fetching them again when a
+// token names a key it doesn't have
+type keySet struct {
+	uri    string
+	client *http.Client
+
+	mu   sync.Mutex
+	keys map[string]crypto.PublicKey
+}
+
+func (k *keySet) get(ctx context.Context, kid string) (crypto.PublicKey, error) {
+	k.mu.Lock()
+	defer k.mu.Unlock()
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	if err := k.fetch(ctx); err != nil {
+		return nil, err
+	}
+	if key, ok := k.keys[kid]; ok {
+		return key, nil
+	}
+	return nil, fmt.Errorf("unknown ID token signing key %q", kid)
+}
+
+func (k *keySet) fetch(ctx context.Context) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, k.uri, nil)
+	if err != nil {
+		return err
+	}
+	resp, err := k.client.Do(req)
+	if err != nil {
+		return fmt.Errorf("failed to fetch JWKS: %v", err)
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("failed to fetch JWKS: %s", resp.Status)
+	}
+	var set struct {
+		Keys []struct {
+			Kid string `json:"kid"`
+			Kty string `json:"kty"`
+			N   string `json:"n"`
+			E   string `json:"e"`
+			Crv string `json:"crv"`
+			X   string `json:"x"`
+			Y   string `json:"y"`
+		} `json:"keys"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&set); err != nil {
+		return fmt.Errorf("invalid JWKS: %v", err)
+	}
+	k.keys = make(map[string]crypto.PublicKey)
+	for _, jwk := range set.Keys {
+		switch jwk.Kty {
+		case "RSA":
+			n, errN := base64.RawURLEncoding.DecodeString(jwk.N)
+			e, errE := base64.RawURLEncoding.DecodeString(jwk.E)
+			if errN != nil || errE != nil {
+				continue
+			}
+			k.keys[jwk.Kid] = &rsa.PublicKey{N: new(big.Int).SetBytes(n), E: int(new(big.Int).SetBytes(e).Int64())}
+		case "EC":
+			x, errX := base64.RawURLEncoding.DecodeString(jwk.X)
+			y, errY := base64.RawURLEncoding.DecodeString(jwk.Y)
+			if jwk.Crv != "P-256" || errX != nil || errY != nil {
+				continue
+			}
+			k.keys[jwk.Kid] = &ecdsa.PublicKey{Curve: elliptic.P256(), X: new(big.Int).SetBytes(x), Y: new(big.Int).SetBytes(y)}
+		}
+	}
+	return nil
+}
+
+// Resolver returns the user of the device from the tokens saved by
+// 'dnshield login', refreshing them once the ID token expires
+type Resolver struct {
+	client      *Client
+	tokenPath   string
+	emailClaim  string
+	groupsClaim string
+
+	mu sync.Mutex
+}
+
+// NewResolver returns a resolver for the tokens at tokenPath
+func NewResolver(client *Client, tokenPath, emailClaim, groupsClaim string) *Resolver {
+	return &Resolver{client: client, tokenPath: tokenPath, emailClaim: emailClaim, groupsClaim: groupsClaim}
+}
+
+// Identity returns the verified user of this device
+func (r *Resolver) Identity(ctx context.Context) (*Identity, error) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+
+	token, err := LoadToken(r.tokenPath)
+	if os.IsNotExist(err) {
+		return nil, fmt.Errorf("no OIDC login on this device; run dnshield login")
+	} else if err != nil {
+		return nil, err
+	}
+	identity, err := r.client.Verify(ctx, token.IDToken, r.emailClaim, r.groupsClaim)
+	if err != errExpired {
+		return identity, err
+	}
+
+	refreshed, err := r.client.Refresh(ctx, token)
+	if err != nil {
+		return nil, fmt.Errorf("failed to refresh OIDC login: %v", err)
+	}
+	identity, err = r.client.Verify(ctx, refreshed.IDToken, r.emailClaim, r.groupsClaim)
+	if err != nil {
+		return nil, err
+	}
+	if err := refreshed.Save(r.tokenPath); err != nil {
+		return nil, fmt.Errorf("failed to save refreshed OIDC login: %v", err)
+	}
+	return identity, nil
+}
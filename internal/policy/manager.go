@@ -0,0 +1,233 @@
+package policy
+
+import (
+	"crypto/ed25519"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultCachePath holds the last verified policy so an enrolled agent
+// stays locked down across restarts while the policy source is unreachable
+const DefaultCachePath = "/Library/Application Support/DNShield/managed-policy.cache.json"
+
+// Status describes the policy in effect, as reported by /api/status
+type Status struct {
+	Source   string // SourceLocal, SourceLockdown, or signed:<source>
+	Enforced bool
+	Serial   int64
+	Error    string // Why the latest load failed, if it did
+}
+
+// Manager loads, verifies and refreshes the managed policy
+type Manager struct {
+	key       ed25519.PublicKey
+	source    string
+	fetch     func() ([]byte, error)
+	cachePath string
+	interval  time.Duration
+
+	mu      sync.RWMutex
+	current *Policy
+	state   string
+	lastErr error
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewManager creates a manager that reads signed policies with fetch.
+// Until a policy verifies, the lockdown policy applies.
+func NewManager(cfg *config.ManagedPolicyConfig, fetch func() ([]byte, error)) *Manager {
+	m := &Manager{
+		source:     "signed:" + cfg.Source,
+		fetch:      fetch,
+		cachePath:  DefaultCachePath,
+		interval:   cfg.RefreshInterval,
+		current:    Lockdown(),
+		state:      SourceLockdown,
+		shutdownCh: make(chan struct{}),
+	}
+
+	key, err := PublicKey(cfg)
+	if err != nil {
+		m.lastErr = err
+	}
+	m.key = key
+	return m
+}
+
+// FileFetcher reads the signed policy delivered by MDM
+func FileFetcher(path string) func() ([]byte, error) {
+	return func() ([]byte, error) {
+		return os.ReadFile(path)
+	}
+}
+
+// Load fetches and verifies the policy. On failure the previous policy
+// stays in effect; if there is none, the cached policy or lockdown applies.
+// A key that the cached policy wasn't signed with is refused, so swapping
+// managedPolicy.publicKey can't replace the organization's policy.
+func (m *Manager) Load() error {
+	if m.key == nil {
+		return m.fail(m.lastErr)
+	}
+
+	m.mu.RLock()
+	loaded := m.state != SourceLockdown
+	m.mu.RUnlock()
+	if !loaded {
+		if err := m.loadCache(); err != nil {
+			audit.Log(audit.EventSecurityViolation, "critical", "Managed policy key changed", map[string]interface{}{
+				"source": m.source,
+				"error":  err.Error(),
+			})
+			return m.fail(err)
+		}
+	}
+
+	data, err := m.fetch()
+	if err != nil {
+		return m.fail(fmt.Errorf("failed to read policy: %v", err))
+	}
+	p, err := Verify(data, m.key)
+	if err != nil {
+		audit.Log(audit.EventSecurityViolation, "critical", "Managed policy rejected", map[string]interface{}{
+			"source": m.source,
+			"error":  err.Error(),
+		})
+		return m.fail(err)
+	}
+
+	m.mu.Lock()
+	previous := m.current
+	if m.state != SourceLockdown && p.Serial < previous.Serial {
+		m.mu.Unlock()
+		return m.fail(fmt.Errorf("policy serial %d is older than %d in effect", p.Serial, previous.Serial))
+	}
+	m.lastErr = nil
+	if m.state != SourceLockdown && p.Serial == previous.Serial {
+		m.mu.Unlock()
+		return nil
+	}
+	m.current = p
+	m.state = m.source
+	m.mu.Unlock()
+
+	audit.Log(audit.EventPolicyChange, "info", "Managed policy applied", map[string]interface{}{
+		"source":               m.source,
+		"serial":               p.Serial,
+		"allow_disable":        p.AllowDisable,
+		"max_pause":            p.MaxPause,
+		"allow_config_changes": p.AllowConfigChanges,
+		"allow_uninstall":      p.AllowUninstall,
+	})
+	m.saveCache(data)
+	return nil
+}
+
+// fail records err and returns it
+func (m *Manager) fail(err error) error {
+	m.mu.Lock()
+	m.lastErr = err
+	state := m.state
+	m.mu.Unlock()
+
+	logrus.WithError(err).WithField("policy_source", state).Error("Failed to load managed policy")
+	return err
+}
+
+// loadCache restores the last verified policy, if it is still valid. It
+// fails if the cached policy isn't signed with the manager's key.
+func (m *Manager) loadCache() error {
+	data, err := os.ReadFile(m.cachePath)
+	if err != nil {
+		return nil
+	}
+	p, err := Verify(data, m.key)
+	if errors.Is(err, errSignature) {
+		return fmt.Errorf("the cached policy isn't signed with the configured public key; refusing the key")
+	}
+	if err != nil {
+		logrus.WithError(err).Warn("Ignoring cached managed policy")
+		return nil
+	}
+
+	m.mu.Lock()
+	m.current = p
+	m.state = m.source
+	m.mu.Unlock()
+	return nil
+}
+
+func (m *Manager) saveCache(data []byte) {
+	if err := os.MkdirAll(filepath.Dir(m.cachePath), 0755); err != nil {
+		logrus.WithError(err).Warn("Failed to cache managed policy")
+		return
+	}
+	if err := os.WriteFile(m.cachePath, data, 0644); err != nil {
+		logrus.WithError(err).Warn("Failed to cache managed policy")
+	}
+}
+
+// Policy returns the policy in effect
+func (m *Manager) Policy() *Policy {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	return m.current
+}
+
+// Status returns what /api/status reports about the policy
+func (m *Manager) Status() Status {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+
+	status := Status{
+		Source:   m.state,
+		Enforced: true,
+		Serial:   m.current.Serial,
+	}
+	if m.lastErr != nil {
+		status.Error = m.lastErr.Error()
+	}
+	return status
+}
+
+// Start reloads the policy at every refresh interval and calls onChange
+// when a different policy takes effect
+func (m *Manager) Start(onChange func(*Policy)) {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.shutdownCh:
+				return
+			case <-ticker.C:
+				before := m.Policy()
+				if err := m.Load(); err != nil {
+					continue
+				}
+				if after := m.Policy(); after != before && onChange != nil {
+					onChange(after)
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops refreshing
+func (m *Manager) Stop() {
+	close(m.shutdownCh)
+	m.wg.Wait()
+}
@@ -0,0 +1,330 @@
+// Package policy loads and verifies the signed managed policy that locks
+// down an enrolled agent. A managed policy overrides local settings that
+// would let a local administrator weaken filtering: disabling or pausing
+// protection, changing configuration over the API, uninstalling, and
+// pointing the agent at different rules.
+package policy
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+const (
+	// SourceLocal means no managed policy applies; local settings are used
+	SourceLocal = "local"
+	// SourceLockdown means managed mode is on but no valid policy could be
+	// loaded, so the most restrictive settings apply
+	SourceLockdown = "lockdown"
+)
+
+// errSignature means a policy wasn't signed with the key it was checked
+// against
+var errSignature = errors.New("policy signature verification failed")
+
+// EmbeddedPublicKey, when set at build time with
+//
+//	-ldflags "-X dnshield/internal/policy.EmbeddedPublicKey=<base64 key>"
+//
+// is the only key policies are accepted from, and managedPolicy.publicKey
+// in the configuration file is ignored.
+var EmbeddedPublicKey string
+
+// Policy is the organization's settings for an enrolled agent
+type Policy struct {
+	// Serial increases with every published policy; older serials are
+	// rejected so a captured policy can't be replayed
+	Serial    int64     `json:"serial"`
+	IssuedAt  time.Time `json:"issued_at"`
+	ExpiresAt time.Time `json:"expires_at"` // Zero means no expiry
+
+	// Allow pausing and quitting protection
+	AllowDisable bool `json:"allow_disable"`
+	// Longest allowed pause, e.g. "30m"; empty means no limit
+	MaxPause string `json:"max_pause,omitempty"`
+	// Allow configuration changes through the API
+	AllowConfigChanges bool `json:"allow_config_changes"`
+	// Allow dnshield uninstall
+	AllowUninstall bool `json:"allow_uninstall"`
+	// Limits on domains allowed and blocked on the device through the
+	// API; nil turns them off
+	LocalOverrides *LocalOverrides `json:"local_overrides,omitempty"`
+	// Recurring windows during which filtering is paused or relaxed;
+	// replaces maintenanceWindows in the local configuration
+	MaintenanceWindows []MaintenanceWindow `json:"maintenance_windows,omitempty"`
+	// Where rules are fetched from; replaces the s3 section of the local
+	// configuration
+	RuleSources *RuleSources `json:"rule_sources,omitempty"`
+
+	maxPause time.Duration
+}
+
+// RuleSources is the location of enterprise rules: an S3 bucket, or a
+// store URL as in s3.url
+type RuleSources struct {
+	URL              string `json:"url,omitempty"`
+	Bucket           string `json:"bucket"`
+	Region           string `json:"region"`
+	Base             string `json:"base,omitempty"`
+	DeviceMapping    string `json:"device_mapping,omitempty"`
+	UserGroups       string `json:"user_groups,omitempty"`
+	GroupsDir        string `json:"groups_dir,omitempty"`
+	UserOverridesDir string `json:"user_overrides_dir,omitempty"`
+	// Keys rules files must be signed with; replaces s3.signingKeys
+	SigningKeys []string `json:"signing_keys,omitempty"`
+}
+
+// LocalOverrides limits the domains that can be allowed on the device
+type LocalOverrides struct {
+	// Replaces localOverrides.allowedDomains when set
+	AllowedDomains []string `json:"allowed_domains,omitempty"`
+	// Added to localOverrides.excludedCategories
+	ExcludedCategories []string `json:"excluded_categories,omitempty"`
+}
+
+// MaintenanceWindow is a recurring window as in maintenanceWindows
+type MaintenanceWindow struct {
+	Name     string   `json:"name"`
+	Timezone string   `json:"timezone,omitempty"`
+	Days     []string `json:"days,omitempty"`
+	Start    string   `json:"start"`
+	End      string   `json:"end"`
+	Mode     string   `json:"mode,omitempty"` // "pause" (default) or "monitor"
+}
+
+// Signed is the on-disk form of a policy. Payload is the base64 JSON
+// policy and Signature is the base64 Ed25519 signature of the decoded
+// payload bytes, so formatting the file never invalidates it.
+type Signed struct {
+	Payload   string `json:"payload"`
+	Signature string `json:"signature"`
+}
+
+// Lockdown returns the policy enforced when managed mode is on but no
+// valid policy is available
+func Lockdown() *Policy {
+	return &Policy{}
+}
+
+// MaxPauseDuration returns the longest allowed pause, or 0 for no limit
+func (p *Policy) MaxPauseDuration() time.Duration {
+	return p.maxPause
+}
+
+// Validate checks the policy's fields
+func (p *Policy) Validate() error {
+	if p.MaxPause != "" {
+		d, err := time.ParseDuration(p.MaxPause)
+		if err != nil || d <= 0 {
+			return fmt.Errorf("invalid max_pause: %q", p.MaxPause)
+		}
+		p.maxPause = d
+	}
+	for _, w := range p.MaintenanceWindows {
+		if w.Name == "" || w.Start == "" || w.End == "" {
+			return fmt.Errorf("maintenance_windows need a name, start and end")
+		}
+		if w.Mode != "" && w.Mode != config.MaintenancePause && w.Mode != config.MaintenanceMonitor {
+			return fmt.Errorf("invalid maintenance window mode: %q", w.Mode)
+		}
+	}
+	if p.RuleSources != nil && p.RuleSources.URL == "" && (p.RuleSources.Bucket == "" || p.RuleSources.Region == "") {
+		return fmt.Errorf("rule_sources requires a url, or bucket and region")
+	}
+	if p.RuleSources != nil {
+		for _, encoded := range p.RuleSources.SigningKeys {
+			if key, err := base64.StdEncoding.DecodeString(encoded); err != nil || len(key) != ed25519.PublicKeySize {
+				return fmt.Errorf("invalid rule_sources signing key: %q", encoded)
+			}
+		}
+	}
+	return nil
+}
+
+// Apply overrides the parts of cfg the policy controls and returns a
+// description of each local setting it overrode
+func (p *Policy) Apply(cfg *config.Config) []string {
+	var conflicts []string
+
+	if cfg.Agent.AllowDisable != p.AllowDisable {
+		conflicts = append(conflicts, fmt.Sprintf("agent.allowDisable=%v overridden to %v", cfg.Agent.AllowDisable, p.AllowDisable))
+		cfg.Agent.AllowDisable = p.AllowDisable
+	}
+
+	if lo := p.LocalOverrides; lo == nil {
+		if cfg.LocalOverrides.Enabled {
+			conflicts = append(conflicts, "localOverrides.enabled=true overridden to false")
+			cfg.LocalOverrides.Enabled = false
+		}
+	} else {
+		if len(lo.AllowedDomains) > 0 {
+			cfg.LocalOverrides.AllowedDomains = lo.AllowedDomains
+		}
+		for _, category := range lo.ExcludedCategories {
+			if !containsString(cfg.LocalOverrides.ExcludedCategories, category) {
+				cfg.LocalOverrides.ExcludedCategories = append(cfg.LocalOverrides.ExcludedCategories, category)
+			}
+		}
+	}
+
+	if len(cfg.MaintenanceWindows) > 0 && len(p.MaintenanceWindows) == 0 {
+		conflicts = append(conflicts, "maintenanceWindows overridden to none")
+	} else if len(cfg.MaintenanceWindows) > 0 {
+		conflicts = append(conflicts, "maintenanceWindows overridden by the policy's maintenance_windows")
+	}
+	cfg.MaintenanceWindows = nil
+	for _, w := range p.MaintenanceWindows {
+		mode := w.Mode
+		if mode == "" {
+			mode = config.MaintenancePause
+		}
+		cfg.MaintenanceWindows = append(cfg.MaintenanceWindows, config.MaintenanceWindowConfig{
+			Name:     w.Name,
+			Timezone: w.Timezone,
+			Days:     w.Days,
+			Start:    w.Start,
+			End:      w.End,
+			Mode:     mode,
+		})
+	}
+
+	if rs := p.RuleSources; rs != nil {
+		if cfg.S3.URL != rs.URL || cfg.S3.Bucket != rs.Bucket || cfg.S3.Region != rs.Region {
+			conflicts = append(conflicts, fmt.Sprintf("rules store %q overridden to %q", storeURL(cfg.S3.URL, cfg.S3.Bucket), storeURL(rs.URL, rs.Bucket)))
+		}
+		cfg.S3.URL = rs.URL
+		cfg.S3.Bucket = rs.Bucket
+		cfg.S3.Region = rs.Region
+		setPath(&cfg.S3.Paths.Base, rs.Base)
+		setPath(&cfg.S3.Paths.DeviceMapping, rs.DeviceMapping)
+		setPath(&cfg.S3.Paths.UserGroups, rs.UserGroups)
+		setPath(&cfg.S3.Paths.GroupsDir, rs.GroupsDir)
+		setPath(&cfg.S3.Paths.UserOverridesDir, rs.UserOverridesDir)
+		if len(rs.SigningKeys) > 0 {
+			cfg.S3.SigningKeys = rs.SigningKeys
+		}
+	}
+
+	return conflicts
+}
+
+func storeURL(url, bucket string) string {
+	if url != "" {
+		return url
+	}
+	return "s3://" + bucket
+}
+
+func setPath(dst *string, value string) {
+	if value != "" {
+		*dst = value
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// PublicKey returns the key policies must be signed with: the embedded key
+// if the binary has one, otherwise the configured key
+func PublicKey(cfg *config.ManagedPolicyConfig) (ed25519.PublicKey, error) {
+	encoded := cfg.PublicKey
+	if EmbeddedPublicKey != "" {
+		encoded = EmbeddedPublicKey
+	}
+	if encoded == "" {
+		return nil, fmt.Errorf("no policy public key configured")
+	}
+	return ParsePublicKey(encoded)
+}
+
+// ParsePublicKey decodes a base64 Ed25519 public key
+func ParsePublicKey(encoded string) (ed25519.PublicKey, error) {
+	key, err := base64.StdEncoding.DecodeString(encoded)
+	if err != nil || len(key) != ed25519.PublicKeySize {
+		return nil, fmt.Errorf("invalid policy public key")
+	}
+	return ed25519.PublicKey(key), nil
+}
+
+// Sign encodes and signs p
+func Sign(p *Policy, key ed25519.PrivateKey) ([]byte, error) {
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	payload, err := json.Marshal(p)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode policy: %v", err)
+	}
+	signed := Signed{
+		Payload:   base64.StdEncoding.EncodeToString(payload),
+		Signature: base64.StdEncoding.EncodeToString(ed25519.Sign(key, payload)),
+	}
+	return json.MarshalIndent(signed, "", "  ")
+}
+
+// Verify checks the signature on a signed policy and returns the policy if
+// it is valid and not expired
+func Verify(data []byte, key ed25519.PublicKey) (*Policy, error) {
+	var signed Signed
+	if err := json.Unmarshal(data, &signed); err != nil {
+		return nil, fmt.Errorf("malformed signed policy: %v", err)
+	}
+	payload, err := base64.StdEncoding.DecodeString(signed.Payload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed policy payload: %v", err)
+	}
+	signature, err := base64.StdEncoding.DecodeString(signed.Signature)
+	if err != nil {
+		return nil, fmt.Errorf("malformed policy signature: %v", err)
+	}
+	if !ed25519.Verify(key, payload, signature) {
+		return nil, errSignature
+	}
+
+	var p Policy
+	if err := json.Unmarshal(payload, &p); err != nil {
+		return nil, fmt.Errorf("malformed policy: %v", err)
+	}
+	if err := p.Validate(); err != nil {
+		return nil, err
+	}
+	if !p.ExpiresAt.IsZero() && time.Now().After(p.ExpiresAt) {
+		return nil, fmt.Errorf("policy %d expired at %s", p.Serial, p.ExpiresAt.Format(time.RFC3339))
+	}
+	return &p, nil
+}
+
+// Enrolled reports whether the agent is in managed mode: enabled in the
+// configuration, built with an embedded key, or a policy has been
+// delivered or cached. Enrollment is sticky: once a policy has been
+// verified and cached, editing the configuration doesn't leave managed mode.
+func Enrolled(cfg *config.ManagedPolicyConfig) bool {
+	return enrolled(cfg, DefaultCachePath)
+}
+
+func enrolled(cfg *config.ManagedPolicyConfig, cachePath string) bool {
+	if cfg.Enabled || EmbeddedPublicKey != "" {
+		return true
+	}
+	if _, err := os.Stat(cachePath); err == nil {
+		return true
+	}
+	if cfg.Path == "" {
+		return false
+	}
+	_, err := os.Stat(cfg.Path)
+	return err == nil
+}
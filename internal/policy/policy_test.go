@@ -0,0 +1,210 @@
+package policy
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+func newKey(t *testing.T) (ed25519.PublicKey, ed25519.PrivateKey) {
+	t.Helper()
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return pub, priv
+}
+
+func sign(t *testing.T, p *Policy, key ed25519.PrivateKey) []byte {
+	t.Helper()
+	data, err := Sign(p, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return data
+}
+
+func TestVerify(t *testing.T) {
+	pub, priv := newKey(t)
+	otherPub, _ := newKey(t)
+
+	data := sign(t, &Policy{Serial: 1, MaxPause: "15m"}, priv)
+	p, err := Verify(data, pub)
+	if err != nil {
+		t.Fatalf("Verify() error = %v", err)
+	}
+	if p.MaxPauseDuration() != 15*time.Minute {
+		t.Errorf("MaxPauseDuration() = %v, want 15m", p.MaxPauseDuration())
+	}
+
+	if _, err := Verify(data, otherPub); err == nil {
+		t.Error("policy verified with the wrong key")
+	}
+
+	expired := sign(t, &Policy{Serial: 2, ExpiresAt: time.Now().Add(-time.Hour)}, priv)
+	if _, err := Verify(expired, pub); err == nil {
+		t.Error("expired policy verified")
+	}
+
+	if _, err := Sign(&Policy{MaxPause: "forever"}, priv); err == nil {
+		t.Error("policy with invalid max_pause signed")
+	}
+}
+
+func TestApply(t *testing.T) {
+	cfg := &config.Config{}
+	cfg.Agent.AllowDisable = true
+	cfg.S3.Bucket = "local-bucket"
+	cfg.S3.Paths.Base = "base.yaml"
+
+	p := &Policy{RuleSources: &RuleSources{Bucket: "corp", Region: "us-east-1", GroupsDir: "teams/"}}
+	conflicts := p.Apply(cfg)
+
+	if len(conflicts) != 2 {
+		t.Errorf("Apply() reported %d conflicts, want 2: %v", len(conflicts), conflicts)
+	}
+	if cfg.Agent.AllowDisable || cfg.S3.Bucket != "corp" || cfg.S3.Paths.GroupsDir != "teams/" {
+		t.Errorf("Apply() left cfg = %+v", cfg)
+	}
+	if cfg.S3.Paths.Base != "base.yaml" {
+		t.Error("Apply() cleared a path the policy doesn't set")
+	}
+}
+
+func TestManagerLoad(t *testing.T) {
+	pub, priv := newKey(t)
+	cfg := &config.ManagedPolicyConfig{
+		Source:          "file",
+		PublicKey:       base64.StdEncoding.EncodeToString(pub),
+		RefreshInterval: time.Minute,
+	}
+
+	var data []byte
+	var fetchErr error
+	fetch := func() ([]byte, error) { return data, fetchErr }
+
+	m := NewManager(cfg, fetch)
+	m.cachePath = filepath.Join(t.TempDir(), "cache.json")
+
+	// Nothing to load: locked down
+	fetchErr = errors.New("missing")
+	if err := m.Load(); err == nil {
+		t.Fatal("Load() succeeded without a policy")
+	}
+	if s := m.Status(); s.Source != SourceLockdown || !s.Enforced || s.Error == "" {
+		t.Errorf("Status() = %+v, want lockdown with error", s)
+	}
+	if m.Policy().AllowDisable || m.Policy().AllowUninstall {
+		t.Error("lockdown policy allows disabling or uninstalling")
+	}
+
+	// A valid policy takes effect
+	fetchErr = nil
+	data = sign(t, &Policy{Serial: 5, AllowDisable: true}, priv)
+	if err := m.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if s := m.Status(); s.Source != "signed:file" || s.Serial != 5 || s.Error != "" {
+		t.Errorf("Status() = %+v", s)
+	}
+
+	// Older and tampered policies are rejected and the current one kept
+	data = sign(t, &Policy{Serial: 4, AllowDisable: true, AllowUninstall: true}, priv)
+	if err := m.Load(); err == nil {
+		t.Error("rolled back to an older policy")
+	}
+	data = []byte(`{"payload":"e30=","signature":"AAAA"}`)
+	if err := m.Load(); err == nil {
+		t.Error("tampered policy accepted")
+	}
+	if m.Policy().Serial != 5 {
+		t.Errorf("policy in effect = %d, want 5", m.Policy().Serial)
+	}
+
+	// A restarted agent uses the cached policy when the source is gone
+	restarted := NewManager(cfg, fetch)
+	restarted.cachePath = m.cachePath
+	fetchErr = errors.New("missing")
+	restarted.Load()
+	if s := restarted.Status(); s.Source != "signed:file" || s.Serial != 5 {
+		t.Errorf("restarted Status() = %+v, want cached policy 5", s)
+	}
+}
+
+func TestEnrolledIsSticky(t *testing.T) {
+	dir := t.TempDir()
+	cachePath := filepath.Join(dir, "cache.json")
+	cfg := &config.ManagedPolicyConfig{Enabled: true, Path: filepath.Join(dir, "managed-policy.json")}
+	if !enrolled(cfg, cachePath) {
+		t.Fatal("enabled agent not enrolled")
+	}
+
+	// Un-enrolling by editing the configuration works until a policy is cached
+	cfg.Enabled = false
+	if enrolled(cfg, cachePath) {
+		t.Error("agent enrolled with managed mode off and no policy")
+	}
+
+	_, priv := newKey(t)
+	if err := os.WriteFile(cachePath, sign(t, &Policy{Serial: 1}, priv), 0644); err != nil {
+		t.Fatal(err)
+	}
+	cfg.Path = ""
+	if !enrolled(cfg, cachePath) {
+		t.Error("agent with a cached policy un-enrolled by editing the configuration")
+	}
+
+	os.Remove(cachePath)
+	EmbeddedPublicKey = "embedded"
+	defer func() { EmbeddedPublicKey = "" }()
+	if !enrolled(cfg, cachePath) {
+		t.Error("agent with an embedded key not enrolled")
+	}
+}
+
+func TestManagerRefusesChangedKey(t *testing.T) {
+	pub, priv := newKey(t)
+	otherPub, otherPriv := newKey(t)
+	cachePath := filepath.Join(t.TempDir(), "cache.json")
+	if err := os.WriteFile(cachePath, sign(t, &Policy{Serial: 3}, priv), 0644); err != nil {
+		t.Fatal(err)
+	}
+
+	// The key is swapped for one the local administrator signed with
+	cfg := &config.ManagedPolicyConfig{
+		Source:          "file",
+		PublicKey:       base64.StdEncoding.EncodeToString(otherPub),
+		RefreshInterval: time.Minute,
+	}
+	data := sign(t, &Policy{Serial: 4, AllowDisable: true, AllowUninstall: true}, otherPriv)
+	m := NewManager(cfg, func() ([]byte, error) { return data, nil })
+	m.cachePath = cachePath
+	if err := m.Load(); err == nil {
+		t.Fatal("Load() accepted a policy signed with a swapped key")
+	}
+	if s := m.Status(); s.Source != SourceLockdown || s.Error == "" {
+		t.Errorf("Status() = %+v, want lockdown with error", s)
+	}
+	if m.Policy().AllowDisable || m.Policy().AllowUninstall {
+		t.Error("swapped key's policy took effect")
+	}
+
+	// The organization's key still works
+	cfg.PublicKey = base64.StdEncoding.EncodeToString(pub)
+	data = sign(t, &Policy{Serial: 4}, priv)
+	m = NewManager(cfg, func() ([]byte, error) { return data, nil })
+	m.cachePath = cachePath
+	if err := m.Load(); err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if s := m.Status(); s.Source != "signed:file" || s.Serial != 4 {
+		t.Errorf("Status() = %+v, want policy 4", s)
+	}
+}
@@ -0,0 +1,223 @@
+// Package privacy pseudonymizes or withholds the domains and client
+// addresses in query records, audit events and logs, as set by
+// logging.privacy, for deployments under works council or GDPR
+// constraints. Hashing uses a per-device HMAC key, so the same name gives
+// the same token on one device but tokens can't be reversed or matched
+// across devices without the key.
+package privacy
+
+import (
+	"crypto/hmac"
+	"crypto/rand"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/dns"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Privacy modes
+const (
+	// ModeFull keeps query data as it is
+	ModeFull = "full"
+	// ModeHashed replaces domains and client IPs with HMAC tokens
+	ModeHashed = "hashed"
+	// ModeAggregate keeps only counters: no per-query records are logged
+	// or exported, and identifying fields are withheld from events
+	ModeAggregate = "aggregate"
+)
+
+// Withheld replaces identifying values in aggregate mode
+const Withheld = "[withheld]"
+
+// identifyingFields are the audit detail and log field names that hold a
+// domain or client address
+var identifyingFields = []string{"domain", "decoded_domain", "client_ip"}
+
+const keySize = 32
+
+// Filter applies a privacy mode. A nil Filter is full mode.
+type Filter struct {
+	mode string
+	key  []byte
+}
+
+// KeyPath returns where the per-device hashing key is kept
+func KeyPath() string {
+	homeDir, _ := os.UserHomeDir()
+	return filepath.Join(homeDir, ".dnshield", "privacy.key")
+}
+
+// New returns a filter for mode, loading or creating the hashing key at
+// keyPath. Full mode returns nil.
+func New(mode, keyPath string) (*Filter, error) {
+	switch mode {
+	case "", ModeFull:
+		return nil, nil
+	case ModeHashed, ModeAggregate:
+	default:
+		return nil, fmt.Errorf("unknown privacy mode %q", mode)
+	}
+
+	key, err := loadKey(keyPath)
+	if err != nil {
+		return nil, err
+	}
+	return &Filter{mode: mode, key: key}, nil
+}
+
+// loadKey reads the hashing key, creating it on first use
+func loadKey(path string) ([]byte, error) {
+	data, err := os.ReadFile(path)
+	if err == nil {
+		key, err := hex.DecodeString(strings.TrimSpace(string(data)))
+		if err != nil || len(key) != keySize {
+			return nil, fmt.Errorf("invalid privacy key in %s", path)
+		}
+		return key, nil
+	}
+	if !os.IsNotExist(err) {
+		return nil, fmt.Errorf("failed to read privacy key: %w", err)
+	}
+
+	key := make([]byte, keySize)
+	if _, err := rand.Read(key); err != nil {
+		return nil, err
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0700); err != nil {
+		return nil, err
+	}
+	if err := os.WriteFile(path, []byte(hex.EncodeToString(key)+"\n"), 0600); err != nil {
+		return nil, fmt.Errorf("failed to save privacy key: %w", err)
+	}
+	return key, nil
+}
+
+// Mode returns the filter's mode
+func (f *Filter) Mode() string {
+	if f == nil {
+		return ModeFull
+	}
+	return f.mode
+}
+
+// Hash returns the token for value: the hex HMAC-SHA256 of its lower-case
+// form, truncated to 128 bits and prefixed with "h:"
+func (f *Filter) Hash(value string) string {
+	if f == nil || value == "" {
+		return value
+	}
+	mac := hmac.New(sha256.New, f.key)
+	mac.Write([]byte(strings.ToLower(value)))
+	return "h:" + hex.EncodeToString(mac.Sum(nil)[:16])
+}
+
+// replace returns what an identifying value becomes in this mode
+func (f *Filter) replace(value string) string {
+	if f.mode == ModeAggregate {
+		return Withheld
+	}
+	return f.Hash(value)
+}
+
+// Query returns event as it may be recorded, and whether a per-query
+// record may be kept at all. Aggregate mode only allows counting it.
+func (f *Filter) Query(event dns.QueryEvent) (dns.QueryEvent, bool) {
+	if f == nil {
+		return event, true
+	}
+	if f.mode == ModeAggregate {
+		return dns.QueryEvent{
+			Timestamp:   event.Timestamp,
+			QueryType:   event.QueryType,
+			Action:      event.Action,
+			Rcode:       event.Rcode,
+			Upstream:    event.Upstream,
+			UpstreamRTT: event.UpstreamRTT,
+			Duration:    event.Duration,
+		}, false
+	}
+	event.Domain = f.Hash(event.Domain)
+	event.ClientIP = f.Hash(event.ClientIP)
+	return event, true
+}
+
+// Block returns event with its domains and client address replaced
+func (f *Filter) Block(event dns.BlockEvent) dns.BlockEvent {
+	if f == nil {
+		return event
+	}
+	event.Domain = f.replace(event.Domain)
+	if event.DecodedDomain != "" {
+		event.DecodedDomain = f.replace(event.DecodedDomain)
+	}
+	if event.ClientIP != "" {
+		event.ClientIP = f.replace(event.ClientIP)
+	}
+	return event
+}
+
+// Event returns event with identifying details replaced, in its message
+// too. The details map is copied, not modified.
+func (f *Filter) Event(event audit.Event) audit.Event {
+	if f == nil || len(event.Details) == 0 {
+		return event
+	}
+	details := make(map[string]interface{}, len(event.Details))
+	for k, v := range event.Details {
+		details[k] = v
+	}
+	for _, name := range identifyingFields {
+		value, ok := details[name].(string)
+		if !ok || value == "" {
+			continue
+		}
+		replacement := f.replace(value)
+		details[name] = replacement
+		event.Message = strings.ReplaceAll(event.Message, value, replacement)
+	}
+	event.Details = details
+	return event
+}
+
+// Hook returns a logrus hook replacing identifying fields in log entries
+func (f *Filter) Hook() logrus.Hook {
+	return &logHook{filter: f}
+}
+
+type logHook struct {
+	filter *Filter
+}
+
+func (h *logHook) Levels() []logrus.Level {
+	return logrus.AllLevels
+}
+
+func (h *logHook) Fire(entry *logrus.Entry) error {
+	if h.filter == nil {
+		return nil
+	}
+	var data logrus.Fields
+	for _, name := range identifyingFields {
+		if value, ok := entry.Data[name].(string); ok && value != "" {
+			if data == nil {
+				// Copied, as the caller may still hold the fields
+				data = make(logrus.Fields, len(entry.Data))
+				for k, v := range entry.Data {
+					data[k] = v
+				}
+			}
+			data[name] = h.filter.replace(value)
+		}
+	}
+	if data != nil {
+		entry.Data = data
+	}
+	return nil
+}
@@ -0,0 +1,81 @@
+package privacy
+
+import (
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/dns"
+)
+
+func TestHashedMode(t *testing.T) {
+	keyPath := filepath.Join(t.TempDir(), "privacy.key")
+	f, err := New(ModeHashed, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	event, keep := f.Query(dns.QueryEvent{Domain: "Mail.Example.com", ClientIP: "10.0.0.5", Action: dns.QueryActionAllowed})
+	if !keep {
+		t.Fatal("Hashed mode withheld the query record")
+	}
+	if !strings.HasPrefix(event.Domain, "h:") || strings.Contains(event.Domain, "example") {
+		t.Errorf("Domain not hashed: %q", event.Domain)
+	}
+	if event.Domain != f.Hash("mail.example.com") {
+		t.Error("Hash depends on case")
+	}
+	if event.ClientIP == "10.0.0.5" {
+		t.Error("Client IP not hashed")
+	}
+
+	// The key is kept, so tokens stay stable across restarts
+	again, err := New(ModeHashed, keyPath)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again.Hash("mail.example.com") != event.Domain {
+		t.Error("Token changed after reloading the key")
+	}
+	other, err := New(ModeHashed, filepath.Join(t.TempDir(), "privacy.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if other.Hash("mail.example.com") == event.Domain {
+		t.Error("Devices with different keys produce the same token")
+	}
+
+	details := map[string]interface{}{"domain": "ads.example.com", "rule": "ads.example.com"}
+	filtered := f.Event(audit.NewEvent(audit.EventDomainBlocked, "info", "Blocked ads.example.com", details))
+	if strings.Contains(filtered.Message, "ads.example.com") || filtered.Details["domain"] != f.Hash("ads.example.com") {
+		t.Errorf("Event not pseudonymized: %q %v", filtered.Message, filtered.Details)
+	}
+	if details["domain"] != "ads.example.com" {
+		t.Error("Caller's details were modified")
+	}
+}
+
+func TestAggregateMode(t *testing.T) {
+	f, err := New(ModeAggregate, filepath.Join(t.TempDir(), "privacy.key"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	event, keep := f.Query(dns.QueryEvent{Domain: "example.com", ClientIP: "10.0.0.5", Action: dns.QueryActionBlocked, Rule: "example.com"})
+	if keep {
+		t.Error("Aggregate mode kept a query record")
+	}
+	if event.Domain != "" || event.ClientIP != "" || event.Rule != "" || event.Action != dns.QueryActionBlocked {
+		t.Errorf("Counted event = %+v, want only the action and timings", event)
+	}
+
+	blocked := f.Block(dns.BlockEvent{Domain: "example.com", ClientIP: "10.0.0.5"})
+	if blocked.Domain != Withheld || blocked.ClientIP != Withheld {
+		t.Errorf("Block event = %+v, want identifying fields withheld", blocked)
+	}
+
+	var full *Filter
+	if e, keep := full.Query(dns.QueryEvent{Domain: "example.com"}); !keep || e.Domain != "example.com" {
+		t.Error("Full mode changed the query")
+	}
+}
@@ -0,0 +1,96 @@
+package proxy
+
+import (
+	"crypto/x509"
+	"sync"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/ca"
+	"dnshield/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+// CASelector switches a certificate generator between the default CA and
+// group-specific CAs as the device's group assignment changes
+type CASelector struct {
+	mu        sync.Mutex
+	certGen   *CertGenerator
+	defaultCA ca.Manager
+	current   string // Active profile name, empty for the default CA
+}
+
+// NewCASelector creates a selector for certGen, which starts on defaultCA
+func NewCASelector(certGen *CertGenerator, defaultCA ca.Manager) *CASelector {
+	return &CASelector{certGen: certGen, defaultCA: defaultCA}
+}
+
+// SetDefaultCA replaces the default CA, switching to it unless a group CA
+// is active
+func (s *CASelector) SetDefaultCA(manager ca.Manager) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	s.defaultCA = manager
+	if s.current == "" {
+		s.certGen.SetCA(manager, nil)
+	}
+}
+
+// DefaultCertificate returns the default CA's certificate
+func (s *CASelector) DefaultCertificate() *x509.Certificate {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.defaultCA.Certificate()
+}
+
+// Current returns the name of the active profile, or "" for the default CA
+func (s *CASelector) Current() string {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	return s.current
+}
+
+// Apply switches to the CA of profileCfg, the profile from the device's
+// rules, loading or creating it, or to the default CA if profileCfg is nil.
+// If the profile's CA can't be loaded the current CA is kept.
+func (s *CASelector) Apply(profileCfg *config.CAProfileConfig) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	name := ""
+	if profileCfg != nil {
+		name = profileCfg.Name
+	}
+	if name == s.current {
+		return
+	}
+
+	if name == "" {
+		s.certGen.SetCA(s.defaultCA, nil)
+		s.current = ""
+		logrus.Info("Switched to default CA")
+		return
+	}
+
+	profile := ca.Profile{
+		Name:               profileCfg.Name,
+		Organization:       profileCfg.Organization,
+		OrganizationalUnit: profileCfg.OrganizationalUnit,
+		Country:            profileCfg.Country,
+	}
+	manager, err := ca.LoadOrCreateProfileCA(profile)
+	if err != nil {
+		logrus.WithError(err).WithField("profile", name).Error("Failed to load group CA, keeping current CA")
+		return
+	}
+
+	s.certGen.SetCA(manager, &profile)
+	s.current = name
+
+	audit.Log(audit.EventCAAccess, "info", "Switched to group CA", map[string]interface{}{
+		"profile": name,
+		"subject": manager.Certificate().Subject.String(),
+	})
+	logrus.WithFields(logrus.Fields{
+		"profile": name,
+		"path":    ca.ProfileCAPath(name),
+	}).Warnf("Switched to group CA; trust it with 'dnshield install-ca --profile %s'", name)
+}
@@ -0,0 +1,59 @@
+package proxy
+
+import (
+	"testing"
+
+	"dnshield/internal/config"
+)
+
+func TestCASelector(t *testing.T) {
+	t.Setenv("HOME", t.TempDir())
+
+	defaultCA := newTestCA(t)
+	gen := NewCertGenerator(defaultCA, nil)
+	defer gen.Stop()
+	s := NewCASelector(gen, defaultCA)
+
+	active := func() (string, string) {
+		gen.mu.RLock()
+		defer gen.mu.RUnlock()
+		profile := ""
+		if gen.profile != nil {
+			profile = gen.profile.Name
+		}
+		return gen.ca.Certificate().Subject.CommonName, profile
+	}
+
+	// A group's profile selects its own CA
+	s.Apply(&config.CAProfileConfig{Name: "contractors", Organization: "Contractor LLC"})
+	if subject, profile := active(); subject != "DNShield CA (contractors)" || profile != "contractors" || s.Current() != "contractors" {
+		t.Fatalf("after applying a profile, CA = %q with profile %q", subject, profile)
+	}
+
+	// A new default CA waits until the group CA is given up
+	rotated := newTestCA(t)
+	rotated.cert.Subject.CommonName = "Rotated CA"
+	s.SetDefaultCA(rotated)
+	if subject, _ := active(); subject != "DNShield CA (contractors)" {
+		t.Errorf("replacing the default CA switched away from the group CA to %q", subject)
+	}
+	if s.DefaultCertificate() != rotated.cert {
+		t.Error("DefaultCertificate() isn't the new default CA")
+	}
+
+	// A profile that can't be loaded keeps the current CA
+	s.Apply(&config.CAProfileConfig{Name: "../escape"})
+	if subject, profile := active(); subject != "DNShield CA (contractors)" || profile != "contractors" {
+		t.Errorf("invalid profile switched CA to %q with profile %q", subject, profile)
+	}
+
+	// Without a profile the default CA is used again
+	s.Apply(nil)
+	if subject, profile := active(); subject != "Rotated CA" || profile != "" || s.Current() != "" {
+		t.Errorf("without a profile, CA = %q with profile %q, want the default", subject, profile)
+	}
+	s.Apply(&config.CAProfileConfig{})
+	if subject, _ := active(); subject != "Rotated CA" {
+		t.Errorf("unnamed profile switched CA to %q", subject)
+	}
+}
@@ -1,18 +1,20 @@
 package proxy
 
 import (
-	"crypto/rand"
 	"crypto/rsa"
 	"crypto/tls"
 	"crypto/x509"
 	"crypto/x509/pkix"
 	"fmt"
 	"math/big"
+	"net"
+	"strings"
 	"sync"
 	"time"
 
 	"dnshield/internal/audit"
 	"dnshield/internal/ca"
+	"dnshield/internal/config"
 	"dnshield/internal/security"
 	"dnshield/internal/utils"
 	"github.com/sirupsen/logrus"
@@ -32,21 +34,26 @@ type DomainVerifier interface {
 // CertGenerator generates certificates dynamically
 type CertGenerator struct {
 	ca         ca.Manager
+	profile    *ca.Profile // Group CA profile, nil when using the default CA
 	verifier   DomainVerifier
 	cache      map[string]*cachedCert
 	mu         sync.RWMutex
 	genLimit   *utils.ConcurrencyLimiter
+	keys       *keyPool
 	shutdownCh chan struct{}
 	wg         sync.WaitGroup
 }
 
-// NewCertGenerator creates a new certificate generator
+// NewCertGenerator creates a new certificate generator. Leaf keys are
+// ECDSA P-256 and generated on demand until SetLeafKeys says otherwise.
 func NewCertGenerator(caManager ca.Manager, verifier DomainVerifier) *CertGenerator {
+	keys, _ := newKeyPool(config.KeyAlgorithmECDSAP256, 0)
 	gen := &CertGenerator{
 		ca:         caManager,
 		verifier:   verifier,
 		cache:      make(map[string]*cachedCert),
 		genLimit:   utils.NewConcurrencyLimiter(utils.MaxConcurrentCertGen),
+		keys:       keys,
 		shutdownCh: make(chan struct{}),
 	}
 
@@ -57,6 +64,33 @@ func NewCertGenerator(caManager ca.Manager, verifier DomainVerifier) *CertGenera
 	return gen
 }
 
+// SetCA switches the CA used to sign leaf certificates. A non-nil profile
+// stamps its organization details on issued certificates. Cached
+// certificates from the previous CA are discarded.
+func (g *CertGenerator) SetCA(manager ca.Manager, profile *ca.Profile) {
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	g.ca = manager
+	g.profile = profile
+	g.cache = make(map[string]*cachedCert)
+}
+
+// SetLeafKeys selects the leaf key algorithm, one of the
+// config.KeyAlgorithm values, and keeps poolSize keys pre-generated
+func (g *CertGenerator) SetLeafKeys(algorithm string, poolSize int) error {
+	keys, err := newKeyPool(algorithm, poolSize)
+	if err != nil {
+		return err
+	}
+
+	g.mu.Lock()
+	old := g.keys
+	g.keys = keys
+	g.mu.Unlock()
+	old.stop()
+	return nil
+}
+
 // GetCertificate generates or retrieves a cached TLS certificate for the
 // specified domain. It implements the tls.Config.GetCertificate interface
 // for dynamic certificate generation during TLS handshakes.
@@ -78,9 +112,16 @@ func NewCertGenerator(caManager ca.Manager, verifier DomainVerifier) *CertGenera
 //   - An error if certificate generation fails
 func (g *CertGenerator) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certificate, error) {
 	domain := hello.ServerName
+
+	// Browsing to the sinkhole directly sends no SNI (or "localhost"); those
+	// get a certificate for the loopback addresses and the diagnostics page
+	diagnostics := isDiagnosticsHost(domain)
+	if diagnostics {
+		domain = diagnosticsCertName
+	}
 	
 	// Security: Verify the domain is actually blocked before generating a certificate
-	if g.verifier != nil && !g.verifier.IsBlocked(domain) {
+	if !diagnostics && g.verifier != nil && !g.verifier.IsBlocked(domain) {
 		logrus.WithField("domain", domain).Warn("Certificate requested for non-blocked domain")
 		audit.Log(audit.EventSecurityViolation, "warning", "Certificate requested for non-blocked domain", map[string]interface{}{
 			"domain": domain,
@@ -119,27 +160,43 @@ func (g *CertGenerator) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certifi
 	// Generate new certificate
 	start := time.Now()
 
-	// Generate key pair
-	key, err := rsa.GenerateKey(rand.Reader, security.CertificateKeyBits)
+	// Snapshot the active CA so a concurrent SetCA doesn't mix issuers
+	g.mu.RLock()
+	caManager := g.ca
+	keys := g.keys
+	subject := pkix.Name{CommonName: domain}
+	if g.profile != nil {
+		subject = g.profile.LeafSubject(domain)
+	}
+	g.mu.RUnlock()
+
+	// Take a key pair, pre-generated when the pool has one
+	key, err := keys.get()
 	if err != nil {
 		return nil, err
 	}
+	keyUsage := x509.KeyUsageDigitalSignature
+	if _, ok := key.(*rsa.PrivateKey); ok {
+		keyUsage |= x509.KeyUsageKeyEncipherment
+	}
 
 	// Create certificate template
 	template := &x509.Certificate{
 		SerialNumber: big.NewInt(time.Now().Unix()),
-		Subject: pkix.Name{
-			CommonName: domain,
-		},
-		NotBefore:   time.Now().Add(-security.CertificateNotBeforeOffset),
-		NotAfter:    time.Now().Add(security.GetDomainCertificateValidity()), // 5 minutes
-		KeyUsage:    x509.KeyUsageKeyEncipherment | x509.KeyUsageDigitalSignature,
-		ExtKeyUsage: []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
-		DNSNames:    getDNSNames(domain),
+		Subject:      subject,
+		NotBefore:    time.Now().Add(-security.CertificateNotBeforeOffset),
+		NotAfter:     time.Now().Add(security.GetDomainCertificateValidity()), // 5 minutes
+		KeyUsage:     keyUsage,
+		ExtKeyUsage:  []x509.ExtKeyUsage{x509.ExtKeyUsageServerAuth},
+		DNSNames:     getDNSNames(domain),
+	}
+	if diagnostics {
+		template.DNSNames = []string{diagnosticsCertName}
+		template.IPAddresses = []net.IP{net.IPv4(127, 0, 0, 1), net.IPv6loopback}
 	}
 
 	// Sign certificate
-	certDER, err := g.ca.SignCertificate(template, g.ca.Certificate(), &key.PublicKey)
+	certDER, err := caManager.SignCertificate(template, caManager.Certificate(), key.Public())
 	if err != nil {
 		return nil, err
 	}
@@ -163,6 +220,11 @@ func (g *CertGenerator) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certifi
 	expiresAt := time.Now().Add(cacheTTL)
 
 	g.mu.Lock()
+	if g.ca != caManager {
+		// CA changed while generating; serve this certificate but don't cache it
+		g.mu.Unlock()
+		return tlsCert, nil
+	}
 	// Check cache size limit
 	if len(g.cache) >= utils.MaxCertCacheEntries {
 		// Remove ~10% of oldest entries
@@ -206,11 +268,14 @@ func (g *CertGenerator) GetCertificate(hello *tls.ClientHelloInfo) (*tls.Certifi
 	return tlsCert, nil
 }
 
-// ClearCache clears the certificate cache
-func (g *CertGenerator) ClearCache() {
+// ClearCache clears the certificate cache and returns how many
+// certificates it held
+func (g *CertGenerator) ClearCache() int {
 	g.mu.Lock()
 	defer g.mu.Unlock()
+	cleared := len(g.cache)
 	g.cache = make(map[string]*cachedCert)
+	return cleared
 }
 
 // cleanupExpiredCerts runs periodically to remove expired certificates from cache
@@ -255,6 +320,21 @@ func (g *CertGenerator) cleanupExpiredCerts() {
 func (g *CertGenerator) Stop() {
 	close(g.shutdownCh)
 	g.wg.Wait()
+
+	g.mu.Lock()
+	keys := g.keys
+	g.mu.Unlock()
+	keys.stop()
+}
+
+// diagnosticsCertName is the name on the certificate served for direct
+// visits to the sinkhole address
+const diagnosticsCertName = "localhost"
+
+// isDiagnosticsHost reports whether host addresses the sinkhole itself
+// rather than a blocked domain: no name at all, "localhost" or an IP
+func isDiagnosticsHost(host string) bool {
+	return host == "" || strings.EqualFold(host, diagnosticsCertName) || net.ParseIP(host) != nil
 }
 
 // getDNSNames returns the DNS names for a certificate based on security configuration
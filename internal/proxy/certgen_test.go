@@ -0,0 +1,128 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/tls"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"math/big"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+// testCA is an in-memory ca.Manager
+type testCA struct {
+	cert *x509.Certificate
+	key  *ecdsa.PrivateKey
+}
+
+func newTestCA(t *testing.T) *testCA {
+	t.Helper()
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(1),
+		Subject:               pkix.Name{CommonName: "Test CA"},
+		NotBefore:             time.Now().Add(-time.Hour),
+		NotAfter:              time.Now().Add(time.Hour),
+		KeyUsage:              x509.KeyUsageCertSign,
+		BasicConstraintsValid: true,
+		IsCA:                  true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return &testCA{cert: cert, key: key}
+}
+
+func (c *testCA) Certificate() *x509.Certificate { return c.cert }
+func (c *testCA) CertificatePEM() []byte         { return nil }
+func (c *testCA) InstallCA() error               { return nil }
+
+func (c *testCA) SignCertificate(template, parent *x509.Certificate, pub crypto.PublicKey) ([]byte, error) {
+	return x509.CreateCertificate(rand.Reader, template, parent, pub, c.key)
+}
+
+func TestLeafKeyAlgorithm(t *testing.T) {
+	tests := []struct {
+		algorithm string
+		poolSize  int
+		want      x509.PublicKeyAlgorithm
+	}{
+		{config.KeyAlgorithmECDSAP256, 0, x509.ECDSA},
+		{config.KeyAlgorithmECDSAP256, 4, x509.ECDSA},
+		{config.KeyAlgorithmRSA2048, 2, x509.RSA},
+	}
+	for _, tt := range tests {
+		authority := newTestCA(t)
+		gen := NewCertGenerator(authority, nil)
+		if err := gen.SetLeafKeys(tt.algorithm, tt.poolSize); err != nil {
+			t.Fatalf("SetLeafKeys(%s): %v", tt.algorithm, err)
+		}
+
+		first, err := gen.GetCertificate(&tls.ClientHelloInfo{ServerName: "ads.example.com"})
+		if err != nil {
+			t.Fatalf("%s: GetCertificate: %v", tt.algorithm, err)
+		}
+		second, err := gen.GetCertificate(&tls.ClientHelloInfo{ServerName: "tracker.example.com"})
+		if err != nil {
+			t.Fatalf("%s: GetCertificate: %v", tt.algorithm, err)
+		}
+		gen.Stop()
+
+		leaf := first.Leaf
+		if leaf.PublicKeyAlgorithm != tt.want {
+			t.Errorf("%s: leaf key is %v, want %v", tt.algorithm, leaf.PublicKeyAlgorithm, tt.want)
+		}
+		if err := leaf.CheckSignatureFrom(authority.cert); err != nil {
+			t.Errorf("%s: leaf not signed by CA: %v", tt.algorithm, err)
+		}
+		if _, isRSA := first.PrivateKey.(*rsa.PrivateKey); isRSA != (leaf.KeyUsage&x509.KeyUsageKeyEncipherment != 0) {
+			t.Errorf("%s: key usage %v doesn't match key type", tt.algorithm, leaf.KeyUsage)
+		}
+		if first.Leaf.PublicKey.(interface{ Equal(crypto.PublicKey) bool }).Equal(second.Leaf.PublicKey) {
+			t.Errorf("%s: two certificates share a key", tt.algorithm)
+		}
+	}
+}
+
+func TestSetLeafKeysUnknownAlgorithm(t *testing.T) {
+	gen := NewCertGenerator(newTestCA(t), nil)
+	defer gen.Stop()
+	if err := gen.SetLeafKeys("dsa", 0); err == nil {
+		t.Error("SetLeafKeys accepted an unknown algorithm")
+	}
+}
+
+func TestCertGeneratorClearCache(t *testing.T) {
+	gen := NewCertGenerator(newTestCA(t), nil)
+	defer gen.Stop()
+
+	first, err := gen.GetCertificate(&tls.ClientHelloInfo{ServerName: "ads.example.com"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if again, _ := gen.GetCertificate(&tls.ClientHelloInfo{ServerName: "ads.example.com"}); again != first {
+		t.Fatal("certificate not cached")
+	}
+
+	if cleared := gen.ClearCache(); cleared != 1 {
+		t.Errorf("ClearCache() = %d, want 1", cleared)
+	}
+	if again, _ := gen.GetCertificate(&tls.ClientHelloInfo{ServerName: "ads.example.com"}); again == first {
+		t.Error("cached certificate served after ClearCache")
+	}
+}
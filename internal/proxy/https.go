@@ -10,10 +10,14 @@ import (
 	"fmt"
 	"html"
 	"html/template"
+	"mime"
 	"net"
 	"net/http"
 	"net/url"
+	"path"
+	"strconv"
 	"strings"
+	"sync"
 	"time"
 
 	"github.com/sirupsen/logrus"
@@ -75,6 +79,42 @@ var blockPageHTML = `<!DOCTYPE html>
             opacity: 0.8;
             margin-top: 2rem;
         }
+        .category-security { background: linear-gradient(135deg, #cb2d3e 0%, #6a0f1c 100%); }
+        .category-nrd, .category-dga, .category-homograph {
+            background: linear-gradient(135deg, #f2994a 0%, #b24a0c 100%);
+        }
+        .category-allow-only { background: linear-gradient(135deg, #4b6cb7 0%, #182848 100%); }
+        .contact {
+            font-size: 0.95rem;
+            margin-top: 1.5rem;
+        }
+        .contact a { color: white; }
+        .notice {
+            background: rgba(255, 255, 255, 0.2);
+            border-radius: 10px;
+            padding: 0.75rem 1rem;
+            margin-top: 1.5rem;
+        }
+        .request { margin-top: 1.5rem; text-align: left; font-size: 0.95rem; }
+        .request label { display: block; margin-bottom: 0.5rem; }
+        .request textarea, .request input {
+            width: 100%;
+            padding: 0.5rem;
+            border: none;
+            border-radius: 8px;
+            font: inherit;
+        }
+        .request textarea { min-height: 4.5rem; resize: vertical; }
+        .request button {
+            margin-top: 0.5rem;
+            padding: 0.5rem 1.25rem;
+            border: 1px solid white;
+            border-radius: 8px;
+            background: transparent;
+            color: white;
+            font: inherit;
+            cursor: pointer;
+        }
         .timestamp {
             font-size: 0.8rem;
             opacity: 0.6;
@@ -92,35 +132,178 @@ var blockPageHTML = `<!DOCTYPE html>
         }
     </style>
 </head>
-<body>
+<body{{if .Category}} class="category-{{.Category}}"{{end}}>
     <div class="container">
         <h1><span class="icon">🚫</span> Access Blocked</h1>
         <p>The website you're trying to visit has been blocked by your enterprise DNS filter.</p>
         <div class="domain">{{.Domain}}</div>
-        <p>This domain was blocked for your protection.</p>
+        <p>{{if .Message}}{{.Message}}{{else}}This domain was blocked for your protection.{{end}}</p>
         <p class="reason">{{.Reason}}</p>
+        {{if or .ContactURL .PolicyURL}}<p class="contact">
+            {{if .ContactURL}}Need access? <a href="{{.ContactURL}}">{{if .ContactEmail}}Contact {{.ContactEmail}}{{else}}Request access{{end}}</a>{{end}}
+            {{if .PolicyURL}}{{if .ContactURL}}<br>{{end}}<a href="{{.PolicyURL}}">Read the acceptable use policy</a>{{end}}
+        </p>{{end}}
+        {{if .Notice}}<p class="notice">{{.Notice}}</p>{{end}}
+        {{if .RequestAccessPath}}<form class="request" method="post" action="{{.RequestAccessPath}}">
+            <label for="justification">Need this site for work? Tell the security team why:</label>
+            <textarea id="justification" name="justification" maxlength="1000" required></textarea>
+            <button type="submit">Request access</button>
+        </form>{{end}}
+        {{if .ApprovalPath}}<form class="request" method="post" action="{{.ApprovalPath}}">
+            <label for="token">Have an approval code?</label>
+            <input id="token" name="token" autocomplete="off" required>
+            <button type="submit">Apply</button>
+        </form>{{end}}
         <p class="timestamp">{{.Timestamp}}</p>
         <p class="agent-info">DNShield v{{.Version}}</p>
     </div>
 </body>
 </html>`
 
+var diagnosticsPageHTML = `<!DOCTYPE html>
+<html lang="en">
+<head>
+    <meta charset="UTF-8">
+    <meta name="viewport" content="width=device-width, initial-scale=1.0">
+    <title>DNShield is active</title>
+    <style>
+        * { margin: 0; padding: 0; box-sizing: border-box; }
+        body {
+            font-family: -apple-system, BlinkMacSystemFont, 'Segoe UI', Roboto, sans-serif;
+            background: linear-gradient(135deg, #11998e 0%, #38ef7d 100%);
+            color: white;
+            min-height: 100vh;
+            display: flex;
+            align-items: center;
+            justify-content: center;
+        }
+        .container {
+            background: rgba(255, 255, 255, 0.1);
+            border-radius: 20px;
+            padding: 3rem;
+            max-width: 560px;
+            box-shadow: 0 8px 32px rgba(0, 0, 0, 0.1);
+            border: 1px solid rgba(255, 255, 255, 0.2);
+        }
+        h1 { font-size: 2rem; margin-bottom: 1rem; }
+        p { font-size: 1.05rem; line-height: 1.6; margin-bottom: 1rem; opacity: 0.9; }
+        table { width: 100%; margin: 1rem 0; border-collapse: collapse; }
+        td { padding: 0.4rem 0; border-bottom: 1px solid rgba(255, 255, 255, 0.2); }
+        td:last-child { text-align: right; font-family: 'SF Mono', Monaco, monospace; }
+        a { color: white; }
+        .agent-info { font-size: 0.7rem; opacity: 0.5; margin-top: 2rem; }
+    </style>
+</head>
+<body>
+    <div class="container">
+        <h1>🛡️ DNShield is active</h1>
+        <p>You've reached the address DNShield uses for blocked domains. Blocked
+        sites resolve here so a block page can be shown instead of the site.
+        Nothing is wrong with this device.</p>
+        <table>
+            <tr><td>Protection</td><td>{{if .Protected}}enabled{{else}}paused{{end}}</td></tr>
+            <tr><td>Blocked domains loaded</td><td>{{.BlockedDomains}}</td></tr>
+        </table>
+        {{if .StatusURL}}<p>Agent health: <a href="{{.StatusURL}}">{{.StatusURL}}</a></p>{{end}}
+        <p>Run <code>dnshield status</code> or <code>dnshield verify</code> for details.</p>
+        <p class="agent-info">DNShield v{{.Version}}</p>
+    </div>
+</body>
+</html>`
+
+// DiagnosticsData is shown when the sinkhole address is visited directly
+type DiagnosticsData struct {
+	Protected      bool
+	BlockedDomains int
+	StatusURL      string
+	Version        string
+}
+
 // HTTPSProxy handles HTTPS requests with dynamic certificates
 type HTTPSProxy struct {
 	certGen     *CertGenerator
 	httpServer  *http.Server
 	httpsServer *http.Server
 	blockPage   *template.Template
+	diagnostics *template.Template
+	httpsPort   int
+
+	blockPageCallback   func(domain, clientIP string)
+	diagnosticsCallback func() DiagnosticsData
+	detailsCallback     func(domain string) BlockDetails
+	requestCallback     func(SinkholeRequest)
+	unblockCallback     func(UnblockRequest) (string, error)
+	approveCallback     func(token string) (string, time.Time, error)
+
+	mu              sync.RWMutex
+	messaging       BlockPageMessaging
+	customPage      *template.Template // Block page from the rules bucket, nil for the built-in one
+	customAssets    map[string][]byte
+	telemetryWindow time.Time
+	telemetryCount  int
 }
 
-// BlockPageData contains data for the block page template
+// BlockPageMessaging customizes the guidance on the block page, typically
+// per device group
+type BlockPageMessaging struct {
+	ContactEmail string
+	ContactURL   string // Where to ask for access, e.g. a ticket form
+	PolicyURL    string
+	Message      string
+	Categories   map[string]string // Message per block category, overriding Message
+	User         string            // Device user from the rules, if known
+}
+
+// BlockDetails describes why a domain is blocked
+type BlockDetails struct {
+	Rule     string // Rule that matched
+	Category string // Block category, e.g. "security"
+	Reason   string // What blocked it, e.g. "threat-intel feed abuse.ch"
+}
+
+// BlockPageData contains data for the block page template. Custom
+// templates from the rules bucket receive the same values.
 type BlockPageData struct {
-	Domain    string
-	Reason    string
-	Timestamp string
-	Version   string
+	Domain       string
+	Reason       string
+	Rule         string
+	Category     string
+	User         string
+	Message      string
+	ContactEmail string
+	ContactURL   string // ContactURL from the rules, or a mailto: link for ContactEmail
+	PolicyURL    string
+	AssetPath    string // URL path custom block page assets are served under
+	// Form actions for access requests and approval codes, empty when
+	// they aren't enabled
+	RequestAccessPath string
+	ApprovalPath      string
+	Notice            string // Outcome of a submitted form
+	Timestamp    string
+	Version      string
 }
 
+// defaultBlockReason is shown when the block details don't say what
+// blocked the domain
+const defaultBlockReason = "This domain is blocked by your organization's security policy"
+
+// UnblockRequest is an access request submitted on the block page
+type UnblockRequest struct {
+	Domain        string
+	Justification string
+	ClientIP      string
+}
+
+// Block page form actions, handled on every blocked domain
+const (
+	unblockRequestPath = "/.dnshield/request-access"
+	approvalPath       = "/.dnshield/approve"
+)
+
+// blockPageAssetPath is where the assets of a custom block page are served
+// on every blocked domain
+const blockPageAssetPath = "/.dnshield/assets/"
+
 // sanitizeDomain validates and sanitizes a domain name to prevent XSS
 func sanitizeDomain(domain string) string {
 	// Remove any potential HTML/JavaScript
@@ -165,9 +348,16 @@ func NewHTTPSProxy(certGen *CertGenerator) (*HTTPSProxy, error) {
 		return nil, fmt.Errorf("failed to parse block page template: %v", err)
 	}
 
+	diagTmpl, err := template.New("diagnostics").Parse(diagnosticsPageHTML)
+	if err != nil {
+		return nil, fmt.Errorf("failed to parse diagnostics page template: %v", err)
+	}
+
 	proxy := &HTTPSProxy{
-		certGen:   certGen,
-		blockPage: tmpl,
+		certGen:     certGen,
+		blockPage:   tmpl,
+		diagnostics: diagTmpl,
+		httpsPort:   443,
 	}
 
 	// Create HTTP server (redirect to HTTPS)
@@ -192,11 +382,90 @@ func NewHTTPSProxy(certGen *CertGenerator) (*HTTPSProxy, error) {
 	return proxy, nil
 }
 
+// SetListenPorts changes the ports the HTTP and HTTPS servers listen on
+// from 80 and 443. It must be called before Start.
+func (p *HTTPSProxy) SetListenPorts(httpPort, httpsPort int) {
+	p.httpServer.Addr = fmt.Sprintf(":%d", httpPort)
+	p.httpsServer.Addr = fmt.Sprintf(":%d", httpsPort)
+	p.httpsPort = httpsPort
+}
+
+// SetBlockPageCallback sets the callback invoked whenever the block page is served
+func (p *HTTPSProxy) SetBlockPageCallback(cb func(domain, clientIP string)) {
+	p.blockPageCallback = cb
+}
+
+// SetDiagnosticsCallback sets the callback supplying the live values shown
+// on the diagnostics page
+func (p *HTTPSProxy) SetDiagnosticsCallback(cb func() DiagnosticsData) {
+	p.diagnosticsCallback = cb
+}
+
+// SetBlockDetailsCallback sets the function that reports why a domain is
+// blocked, shown on the block page and used to pick a per-category message
+func (p *HTTPSProxy) SetBlockDetailsCallback(cb func(domain string) BlockDetails) {
+	p.detailsCallback = cb
+}
+
+// SetUnblockCallbacks adds an access request form to the block page.
+// request forwards a request and returns its reference. approve applies an
+// approval code and returns the allowed domain and when the allow ends; a
+// nil approve hides the approval form.
+func (p *HTTPSProxy) SetUnblockCallbacks(request func(UnblockRequest) (string, error), approve func(token string) (string, time.Time, error)) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.unblockCallback = request
+	p.approveCallback = approve
+}
+
+// SetBlockPageMessaging replaces the block page guidance. Policy and
+// contact URLs that aren't http(s) are dropped.
+func (p *HTTPSProxy) SetBlockPageMessaging(messaging BlockPageMessaging) {
+	if messaging.PolicyURL != "" && !isWebURL(messaging.PolicyURL) {
+		logrus.WithField("policy_url", messaging.PolicyURL).Warn("Ignoring invalid block page policy URL")
+		messaging.PolicyURL = ""
+	}
+	if messaging.ContactURL != "" && !isWebURL(messaging.ContactURL) {
+		logrus.WithField("contact_url", messaging.ContactURL).Warn("Ignoring invalid block page contact URL")
+		messaging.ContactURL = ""
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.messaging = messaging
+}
+
+// SetCustomBlockPage replaces the built-in block page with an html/template
+// page and the assets it links to under /.dnshield/assets/. A nil template
+// restores the built-in page. If the template doesn't parse, the current
+// page is kept.
+func (p *HTTPSProxy) SetCustomBlockPage(templateHTML []byte, assets map[string][]byte) error {
+	var tmpl *template.Template
+	if templateHTML != nil {
+		var err error
+		tmpl, err = template.New("custom-blockpage").Parse(string(templateHTML))
+		if err != nil {
+			return fmt.Errorf("failed to parse custom block page: %v", err)
+		}
+	}
+
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	p.customPage = tmpl
+	p.customAssets = assets
+	return nil
+}
+
+func isWebURL(raw string) bool {
+	u, err := url.Parse(raw)
+	return err == nil && (u.Scheme == "https" || u.Scheme == "http")
+}
+
 // Start starts both HTTP and HTTPS servers
 func (p *HTTPSProxy) Start() error {
 	// Start HTTP server
 	go func() {
-		logrus.Info("Starting HTTP server on :80")
+		logrus.Infof("Starting HTTP server on %s", p.httpServer.Addr)
 		if err := p.httpServer.ListenAndServe(); err != nil && err != http.ErrServerClosed {
 			logrus.WithError(err).Error("HTTP server error")
 		}
@@ -204,7 +473,7 @@ func (p *HTTPSProxy) Start() error {
 
 	// Start HTTPS server
 	go func() {
-		logrus.Info("Starting HTTPS server on :443")
+		logrus.Infof("Starting HTTPS server on %s", p.httpsServer.Addr)
 		if err := p.httpsServer.ListenAndServeTLS("", ""); err != nil && err != http.ErrServerClosed {
 			logrus.WithError(err).Error("HTTPS server error")
 		}
@@ -239,7 +508,18 @@ func (p *HTTPSProxy) Stop() error {
 
 // handleHTTPRedirect redirects HTTP to HTTPS
 func (p *HTTPSProxy) handleHTTPRedirect(w http.ResponseWriter, r *http.Request) {
+	domain := r.Host
+	if host, _, err := net.SplitHostPort(domain); err == nil {
+		domain = host
+	}
+	if !isDiagnosticsHost(strings.Trim(domain, "[]")) {
+		p.recordRequest(r, "http", domain)
+	}
+
 	target := "https://" + r.Host + r.RequestURI
+	if p.httpsPort != 443 {
+		target = "https://" + net.JoinHostPort(strings.Trim(domain, "[]"), strconv.Itoa(p.httpsPort)) + r.RequestURI
+	}
 	http.Redirect(w, r, target, http.StatusMovedPermanently)
 }
 
@@ -249,7 +529,27 @@ func (p *HTTPSProxy) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 	if host, _, err := net.SplitHostPort(domain); err == nil {
 		domain = host
 	}
+
+	// Someone browsed to the sinkhole itself rather than a blocked domain
+	if isDiagnosticsHost(strings.Trim(domain, "[]")) {
+		p.serveDiagnostics(w)
+		return
+	}
+
+	// Images and stylesheets of a custom block page
+	if strings.HasPrefix(r.URL.Path, blockPageAssetPath) {
+		p.serveBlockPageAsset(w, strings.TrimPrefix(r.URL.Path, blockPageAssetPath))
+		return
+	}
+
+	// Forms on the block page
+	if r.Method == http.MethodPost && (r.URL.Path == unblockRequestPath || r.URL.Path == approvalPath) {
+		p.serveBlockPage(w, domain, p.handleUnblockForm(w, r, strings.ToLower(domain)))
+		return
+	}
 	
+	p.recordRequest(r, "https", domain)
+
 	// Sanitize the domain to prevent XSS
 	safeDomain := sanitizeDomain(domain)
 
@@ -258,18 +558,84 @@ func (p *HTTPSProxy) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 		"safeDomain": safeDomain,
 	}).Info("Serving block page")
 
+	if p.blockPageCallback != nil {
+		clientIP := r.RemoteAddr
+		if host, _, err := net.SplitHostPort(clientIP); err == nil {
+			clientIP = host
+		}
+		p.blockPageCallback(strings.ToLower(domain), clientIP)
+	}
+
+	p.serveBlockPage(w, domain, "")
+}
+
+// serveBlockPage renders the block page for domain, with notice above the
+// forms
+func (p *HTTPSProxy) serveBlockPage(w http.ResponseWriter, domain, notice string) {
+	safeDomain := sanitizeDomain(domain)
+
+	p.mu.RLock()
+	messaging := p.messaging
+	customPage := p.customPage
+	requestAccess := p.unblockCallback != nil
+	approvals := p.approveCallback != nil
+	p.mu.RUnlock()
+
+	var details BlockDetails
+	if p.detailsCallback != nil {
+		details = p.detailsCallback(strings.ToLower(domain))
+	}
+	message := messaging.Message
+	if categoryMessage, ok := messaging.Categories[details.Category]; ok {
+		message = categoryMessage
+	}
+	contactURL := messaging.ContactURL
+	if contactURL == "" && messaging.ContactEmail != "" {
+		contactURL = "mailto:" + messaging.ContactEmail
+	}
+	reason := defaultBlockReason
+	if details.Reason != "" {
+		reason = "Blocked by " + details.Reason
+	}
+
 	data := BlockPageData{
-		Domain:    safeDomain, // Use sanitized domain in template
-		Reason:    "This domain is blocked by your organization's security policy",
-		Timestamp: time.Now().Format("2006-01-02 15:04:05"),
-		Version:   "1.0.0",
+		Domain:       safeDomain, // Use sanitized domain in template
+		Reason:       reason,
+		Rule:         details.Rule,
+		Category:     details.Category,
+		User:         messaging.User,
+		Message:      message,
+		ContactEmail: messaging.ContactEmail,
+		ContactURL:   contactURL,
+		PolicyURL:    messaging.PolicyURL,
+		AssetPath:    blockPageAssetPath,
+		Notice:       notice,
+		Timestamp:    time.Now().Format("2006-01-02 15:04:05"),
+		Version:      "1.0.0",
+	}
+	if requestAccess {
+		data.RequestAccessPath = unblockRequestPath
+	}
+	if approvals {
+		data.ApprovalPath = approvalPath
 	}
 
 	var buf bytes.Buffer
-	if err := p.blockPage.Execute(&buf, data); err != nil {
-		logrus.WithError(err).Error("Failed to render block page")
-		http.Error(w, "Blocked", http.StatusForbidden)
-		return
+	rendered := false
+	if customPage != nil {
+		if err := customPage.Execute(&buf, data); err != nil {
+			logrus.WithError(err).Warn("Failed to render custom block page, using built-in page")
+			buf.Reset()
+		} else {
+			rendered = true
+		}
+	}
+	if !rendered {
+		if err := p.blockPage.Execute(&buf, data); err != nil {
+			logrus.WithError(err).Error("Failed to render block page")
+			http.Error(w, "Blocked", http.StatusForbidden)
+			return
+		}
 	}
 
 	w.Header().Set("Content-Type", "text/html; charset=utf-8")
@@ -277,7 +643,7 @@ func (p *HTTPSProxy) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 	w.Header().Set("X-Content-Type-Options", "nosniff")
 	w.Header().Set("X-Frame-Options", "DENY")
 	w.Header().Set("X-XSS-Protection", "1; mode=block")
-	w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; script-src 'none'; style-src 'self' 'unsafe-inline'")
 	
 	// Sanitize domain for header to prevent header injection
 	w.Header().Set("X-Blocked-Domain", sanitizeHeader(safeDomain))
@@ -285,3 +651,102 @@ func (p *HTTPSProxy) handleHTTPS(w http.ResponseWriter, r *http.Request) {
 	w.WriteHeader(http.StatusOK)
 	w.Write(buf.Bytes())
 }
+
+// handleUnblockForm submits the access request or approval form posted
+// for domain and returns the notice describing the outcome
+func (p *HTTPSProxy) handleUnblockForm(w http.ResponseWriter, r *http.Request, domain string) string {
+	p.mu.RLock()
+	request := p.unblockCallback
+	approve := p.approveCallback
+	p.mu.RUnlock()
+
+	// Other sites could post the form into a blocked domain's page
+	if !sameOrigin(r) {
+		logrus.WithField("domain", domain).Warn("Ignored block page form posted from another site")
+		return "The form was submitted from another site and was ignored."
+	}
+	r.Body = http.MaxBytesReader(w, r.Body, maxFormSize)
+	if err := r.ParseForm(); err != nil {
+		return "The form could not be read."
+	}
+	clientIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+
+	switch {
+	case r.URL.Path == unblockRequestPath && request != nil:
+		id, err := request(UnblockRequest{Domain: domain, Justification: r.PostFormValue("justification"), ClientIP: clientIP})
+		if err != nil {
+			return "Your request could not be sent: " + err.Error()
+		}
+		return fmt.Sprintf("Your request was sent to the security team (reference %s).", id)
+	case r.URL.Path == approvalPath && approve != nil:
+		allowed, until, err := approve(r.PostFormValue("token"))
+		if err != nil {
+			return "The approval code was not accepted: " + err.Error()
+		}
+		return fmt.Sprintf("%s is allowed until %s. Reload the page in a minute.", allowed, until.Format("2006-01-02 15:04"))
+	}
+	return "Access requests are not enabled."
+}
+
+// maxFormSize bounds block page form submissions
+const maxFormSize = 16 * 1024
+
+// sameOrigin reports whether a form post came from the page it was posted
+// to. Browsers send Origin (or at least Referer) with form posts.
+func sameOrigin(r *http.Request) bool {
+	origin := r.Header.Get("Origin")
+	if origin == "" {
+		origin = r.Header.Get("Referer")
+	}
+	u, err := url.Parse(origin)
+	return err == nil && u.Host != "" && strings.EqualFold(u.Host, r.Host)
+}
+
+// serveBlockPageAsset serves a file of the custom block page
+func (p *HTTPSProxy) serveBlockPageAsset(w http.ResponseWriter, name string) {
+	p.mu.RLock()
+	content, ok := p.customAssets[name]
+	p.mu.RUnlock()
+	if !ok {
+		http.Error(w, "Not found", http.StatusNotFound)
+		return
+	}
+
+	contentType := mime.TypeByExtension(path.Ext(name))
+	if contentType == "" {
+		contentType = http.DetectContentType(content)
+	}
+	w.Header().Set("Content-Type", contentType)
+	w.Header().Set("Cache-Control", "private, max-age=300")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("Content-Security-Policy", "default-src 'none'; style-src 'self' 'unsafe-inline'; img-src 'self'; font-src 'self'")
+	w.WriteHeader(http.StatusOK)
+	w.Write(content)
+}
+
+// serveDiagnostics explains that DNShield is active when the sinkhole
+// address is visited directly
+func (p *HTTPSProxy) serveDiagnostics(w http.ResponseWriter) {
+	data := DiagnosticsData{Protected: true, Version: "1.0.0"}
+	if p.diagnosticsCallback != nil {
+		data = p.diagnosticsCallback()
+	}
+
+	var buf bytes.Buffer
+	if err := p.diagnostics.Execute(&buf, data); err != nil {
+		logrus.WithError(err).Error("Failed to render diagnostics page")
+		http.Error(w, "DNShield is active", http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "text/html; charset=utf-8")
+	w.Header().Set("Cache-Control", "no-cache, no-store, must-revalidate")
+	w.Header().Set("X-Content-Type-Options", "nosniff")
+	w.Header().Set("X-Frame-Options", "DENY")
+	w.Header().Set("Content-Security-Policy", "default-src 'self'; style-src 'unsafe-inline'")
+	w.WriteHeader(http.StatusOK)
+	w.Write(buf.Bytes())
+}
@@ -0,0 +1,133 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestHTTPRedirectPort(t *testing.T) {
+	p, err := NewHTTPSProxy(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		httpPort, httpsPort int
+		url, want           string
+	}{
+		{80, 443, "http://ads.example.com/banner?id=1", "https://ads.example.com/banner?id=1"},
+		{8080, 8443, "http://ads.example.com:8080/banner?id=1", "https://ads.example.com:8443/banner?id=1"},
+		{8080, 8443, "http://[2001:db8::1]:8080/", "https://[2001:db8::1]:8443/"},
+	}
+	for _, tt := range tests {
+		p.SetListenPorts(tt.httpPort, tt.httpsPort)
+		w := httptest.NewRecorder()
+		r := httptest.NewRequest("GET", tt.url, nil)
+		r.RequestURI = r.URL.RequestURI() // As received by a server
+		p.handleHTTPRedirect(w, r)
+		if got := w.Header().Get("Location"); got != tt.want {
+			t.Errorf("redirect of %s with HTTPS on %d = %q, want %q", tt.url, tt.httpsPort, got, tt.want)
+		}
+	}
+}
+
+func TestCustomBlockPage(t *testing.T) {
+	p, err := NewHTTPSProxy(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	p.SetBlockDetailsCallback(func(domain string) BlockDetails {
+		return BlockDetails{Rule: "example.com", Category: "security", Reason: "threat-intel feed abuse.ch"}
+	})
+	p.SetBlockPageMessaging(BlockPageMessaging{ContactURL: "https://help.example.com/unblock", User: "jane@example.com"})
+
+	render := func() string {
+		w := httptest.NewRecorder()
+		p.handleHTTPS(w, httptest.NewRequest("GET", "https://ads.example.com/", nil))
+		return w.Body.String()
+	}
+
+	page := `<h1>{{.Domain}}</h1><p>{{.Rule}} {{.Category}} {{.User}}</p><a href="{{.ContactURL}}">help</a><img src="{{.AssetPath}}logo.png">`
+	if err := p.SetCustomBlockPage([]byte(page), map[string][]byte{"logo.png": []byte("\x89PNG")}); err != nil {
+		t.Fatalf("SetCustomBlockPage: %v", err)
+	}
+	want := `<h1>ads.example.com</h1><p>example.com security jane@example.com</p><a href="https://help.example.com/unblock">help</a><img src="/.dnshield/assets/logo.png">`
+	if got := render(); got != want {
+		t.Errorf("custom page = %s, want %s", got, want)
+	}
+
+	w := httptest.NewRecorder()
+	p.handleHTTPS(w, httptest.NewRequest("GET", "https://ads.example.com/.dnshield/assets/logo.png", nil))
+	if w.Code != 200 || w.Body.String() != "\x89PNG" || w.Header().Get("Content-Type") != "image/png" {
+		t.Errorf("asset = %d %q (%s)", w.Code, w.Body.String(), w.Header().Get("Content-Type"))
+	}
+	w = httptest.NewRecorder()
+	p.handleHTTPS(w, httptest.NewRequest("GET", "https://ads.example.com/.dnshield/assets/missing.css", nil))
+	if w.Code != 404 {
+		t.Errorf("missing asset status = %d, want 404", w.Code)
+	}
+
+	// Invalid templates are rejected and the current page kept
+	if err := p.SetCustomBlockPage([]byte("{{.Domain"), nil); err == nil {
+		t.Error("SetCustomBlockPage accepted an invalid template")
+	}
+	if got := render(); got != want {
+		t.Errorf("page after invalid template = %s", got)
+	}
+
+	// Templates that fail to render fall back to the built-in page
+	if err := p.SetCustomBlockPage([]byte("{{.NoSuchField}}"), nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := render(); !strings.Contains(got, "Access Blocked") {
+		t.Errorf("broken custom page did not fall back to the built-in page: %s", got)
+	}
+
+	if err := p.SetCustomBlockPage(nil, nil); err != nil {
+		t.Fatal(err)
+	}
+	if got := render(); !strings.Contains(got, "Access Blocked") || !strings.Contains(got, "https://help.example.com/unblock") {
+		t.Errorf("built-in page = %s", got)
+	}
+	if got := render(); !strings.Contains(got, `class="category-security"`) || !strings.Contains(got, "Blocked by threat-intel feed abuse.ch") {
+		t.Errorf("built-in page doesn't show the block reason: %s", got)
+	}
+}
+
+func TestBlockPageAccessRequest(t *testing.T) {
+	p, err := NewHTTPSProxy(nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var got UnblockRequest
+	p.SetUnblockCallbacks(func(req UnblockRequest) (string, error) {
+		got = req
+		return "abc123", nil
+	}, nil)
+
+	page := httptest.NewRecorder()
+	p.handleHTTPS(page, httptest.NewRequest("GET", "https://ads.example.com/", nil))
+	if body := page.Body.String(); !strings.Contains(body, `action="/.dnshield/request-access"`) || strings.Contains(body, `action="/.dnshield/approve"`) {
+		t.Errorf("block page forms: %s", body)
+	}
+
+	post := func(origin string) string {
+		r := httptest.NewRequest("POST", "https://ads.example.com/.dnshield/request-access", strings.NewReader("justification=Needed+for+the+launch"))
+		r.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+		r.Header.Set("Origin", origin)
+		w := httptest.NewRecorder()
+		p.handleHTTPS(w, r)
+		return w.Body.String()
+	}
+
+	if body := post("https://evil.example.net"); got.Domain != "" || !strings.Contains(body, "another site") {
+		t.Errorf("cross-site post was submitted: %+v", got)
+	}
+	if body := post("https://ads.example.com"); !strings.Contains(body, "reference abc123") {
+		t.Errorf("request notice missing: %s", body)
+	}
+	if got.Domain != "ads.example.com" || got.Justification != "Needed for the launch" {
+		t.Errorf("submitted %+v", got)
+	}
+}
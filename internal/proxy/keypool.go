@@ -0,0 +1,93 @@
+package proxy
+
+import (
+	"crypto"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/rsa"
+	"fmt"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/security"
+	"github.com/sirupsen/logrus"
+)
+
+// keyPool hands out freshly generated leaf keys. With a non-zero size a
+// background goroutine keeps that many keys ready, so a handshake doesn't
+// wait for key generation. Every key is used for a single certificate.
+type keyPool struct {
+	algorithm string
+	keys      chan crypto.Signer // Nil when pooling is off
+	stopCh    chan struct{}
+	wg        sync.WaitGroup
+}
+
+// newKeyPool creates a pool of keys for algorithm, one of the
+// config.KeyAlgorithm values, holding up to size keys
+func newKeyPool(algorithm string, size int) (*keyPool, error) {
+	switch algorithm {
+	case config.KeyAlgorithmECDSAP256, config.KeyAlgorithmRSA2048:
+	default:
+		return nil, fmt.Errorf("unsupported leaf key algorithm %q", algorithm)
+	}
+
+	p := &keyPool{
+		algorithm: algorithm,
+		stopCh:    make(chan struct{}),
+	}
+	if size > 0 {
+		p.keys = make(chan crypto.Signer, size)
+		p.wg.Add(1)
+		go p.fill()
+	}
+	return p, nil
+}
+
+// get returns a pooled key, or generates one if the pool is empty
+func (p *keyPool) get() (crypto.Signer, error) {
+	select {
+	case key := <-p.keys:
+		return key, nil
+	default:
+		return p.generate()
+	}
+}
+
+func (p *keyPool) generate() (crypto.Signer, error) {
+	if p.algorithm == config.KeyAlgorithmRSA2048 {
+		return rsa.GenerateKey(rand.Reader, security.CertificateKeyBits)
+	}
+	return ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+}
+
+// fill generates keys until the pool is stopped, blocking while it is full
+func (p *keyPool) fill() {
+	defer p.wg.Done()
+	for {
+		key, err := p.generate()
+		if err != nil {
+			logrus.WithError(err).Warn("Failed to pre-generate leaf key")
+			select {
+			case <-p.stopCh:
+				return
+			case <-time.After(time.Second):
+			}
+			continue
+		}
+
+		select {
+		case p.keys <- key:
+		case <-p.stopCh:
+			return
+		}
+	}
+}
+
+// stop ends background generation
+func (p *keyPool) stop() {
+	close(p.stopCh)
+	p.wg.Wait()
+}
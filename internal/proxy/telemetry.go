@@ -0,0 +1,89 @@
+package proxy
+
+import (
+	"net"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// maxTelemetryURI and maxTelemetryUserAgent bound what a client can
+	// push into the logs
+	maxTelemetryURI       = 2048
+	maxTelemetryUserAgent = 512
+	// maxTelemetryPerMinute caps records so a beaconing client can't flood
+	// the audit log
+	maxTelemetryPerMinute = 600
+)
+
+// SinkholeRequest describes an HTTP(S) request that reached the sinkhole
+// for a blocked domain, showing what the client was trying to fetch
+type SinkholeRequest struct {
+	Timestamp time.Time
+	Scheme    string // "http" or "https"
+	Domain    string
+	Method    string
+	URI       string // Path and query string
+	UserAgent string
+	ClientIP  string
+}
+
+// Redacted returns a copy without the query string or client IP, for
+// privacy mode
+func (s SinkholeRequest) Redacted() SinkholeRequest {
+	if i := strings.IndexByte(s.URI, '?'); i >= 0 {
+		s.URI = s.URI[:i]
+	}
+	s.ClientIP = ""
+	return s
+}
+
+// SetSinkholeRequestCallback sets the callback invoked for every request
+// to a blocked domain, over HTTP or HTTPS
+func (p *HTTPSProxy) SetSinkholeRequestCallback(cb func(SinkholeRequest)) {
+	p.requestCallback = cb
+}
+
+// recordRequest reports r to the sinkhole request callback, subject to the
+// per-minute cap
+func (p *HTTPSProxy) recordRequest(r *http.Request, scheme, domain string) {
+	if p.requestCallback == nil {
+		return
+	}
+
+	now := time.Now()
+	p.mu.Lock()
+	if now.Sub(p.telemetryWindow) >= time.Minute {
+		p.telemetryWindow = now
+		p.telemetryCount = 0
+	}
+	p.telemetryCount++
+	over := p.telemetryCount > maxTelemetryPerMinute
+	p.mu.Unlock()
+	if over {
+		return
+	}
+
+	clientIP := r.RemoteAddr
+	if host, _, err := net.SplitHostPort(clientIP); err == nil {
+		clientIP = host
+	}
+
+	p.requestCallback(SinkholeRequest{
+		Timestamp: now,
+		Scheme:    scheme,
+		Domain:    strings.ToLower(domain),
+		Method:    truncate(r.Method, 16),
+		URI:       truncate(r.URL.RequestURI(), maxTelemetryURI),
+		UserAgent: truncate(r.UserAgent(), maxTelemetryUserAgent),
+		ClientIP:  clientIP,
+	})
+}
+
+func truncate(s string, max int) string {
+	if len(s) > max {
+		return s[:max]
+	}
+	return s
+}
@@ -0,0 +1,50 @@
+package proxy
+
+import (
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestSinkholeRequestTelemetry(t *testing.T) {
+	p := &HTTPSProxy{}
+	var got []SinkholeRequest
+	p.SetSinkholeRequestCallback(func(req SinkholeRequest) {
+		got = append(got, req)
+	})
+
+	r := httptest.NewRequest("POST", "http://C2.Example.net/gate.php?id=42&os=mac", nil)
+	r.Header.Set("User-Agent", "curl/8.4.0")
+	r.RemoteAddr = "192.168.1.20:51515"
+	p.recordRequest(r, "http", "C2.Example.net")
+
+	if len(got) != 1 {
+		t.Fatalf("recorded %d requests, want 1", len(got))
+	}
+	req := got[0]
+	if req.Domain != "c2.example.net" || req.Method != "POST" || req.URI != "/gate.php?id=42&os=mac" ||
+		req.UserAgent != "curl/8.4.0" || req.ClientIP != "192.168.1.20" || req.Scheme != "http" {
+		t.Errorf("recorded %+v", req)
+	}
+
+	redacted := req.Redacted()
+	if redacted.URI != "/gate.php" || redacted.ClientIP != "" || redacted.UserAgent != "curl/8.4.0" {
+		t.Errorf("Redacted() = %+v", redacted)
+	}
+
+	// Oversized fields are truncated
+	r = httptest.NewRequest("GET", "https://c2.example.net/"+strings.Repeat("a", 3*maxTelemetryURI), nil)
+	r.Header.Set("User-Agent", strings.Repeat("b", 2*maxTelemetryUserAgent))
+	p.recordRequest(r, "https", "c2.example.net")
+	if len(got[1].URI) != maxTelemetryURI || len(got[1].UserAgent) != maxTelemetryUserAgent {
+		t.Errorf("URI/User-Agent not truncated: %d, %d", len(got[1].URI), len(got[1].UserAgent))
+	}
+
+	// A flood is capped per minute
+	for i := 0; i < 2*maxTelemetryPerMinute; i++ {
+		p.recordRequest(r, "https", "c2.example.net")
+	}
+	if len(got) != maxTelemetryPerMinute {
+		t.Errorf("recorded %d requests in a minute, want %d", len(got), maxTelemetryPerMinute)
+	}
+}
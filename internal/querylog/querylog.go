@@ -0,0 +1,379 @@
+// Package querylog records every answered DNS query in a local SQLite
+// database so queries can be searched after the fact. Writes are batched on
+// a background worker so the resolver never waits on the disk.
+package querylog
+
+import (
+	"database/sql"
+	"fmt"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"github.com/sirupsen/logrus"
+	_ "modernc.org/sqlite"
+)
+
+const (
+	// queueSize bounds queries waiting to be written
+	queueSize = 10000
+	// batchSize is the most queries written in one transaction
+	batchSize = 500
+	// flushInterval is how often queued queries are written
+	flushInterval = time.Second
+	// pruneInterval is how often retention and size limits are enforced
+	pruneInterval = 10 * time.Minute
+
+	// DefaultPageSize is the number of entries returned when a filter
+	// doesn't set a limit
+	DefaultPageSize = 100
+	// MaxPageSize caps the entries returned by one search
+	MaxPageSize = 1000
+)
+
+const schema = `
+CREATE TABLE IF NOT EXISTS queries (
+	id          INTEGER PRIMARY KEY,
+	ts          INTEGER NOT NULL,
+	domain      TEXT NOT NULL,
+	query_type  TEXT NOT NULL,
+	client_ip   TEXT NOT NULL,
+	action      TEXT NOT NULL,
+	rcode       TEXT NOT NULL,
+	rule        TEXT NOT NULL,
+	upstream    TEXT NOT NULL,
+	duration_us INTEGER NOT NULL
+);
+CREATE INDEX IF NOT EXISTS queries_ts ON queries (ts);
+CREATE INDEX IF NOT EXISTS queries_domain ON queries (domain);
+CREATE INDEX IF NOT EXISTS queries_client_ip ON queries (client_ip);
+`
+
+// Entry is a logged query
+type Entry struct {
+	Timestamp  time.Time `json:"timestamp"`
+	Domain     string    `json:"domain"`
+	QueryType  string    `json:"query_type"`
+	ClientIP   string    `json:"client_ip"`
+	Action     string    `json:"action"`
+	Rcode      string    `json:"rcode"`
+	Rule       string    `json:"rule,omitempty"`
+	Upstream   string    `json:"upstream,omitempty"`
+	DurationMs float64   `json:"duration_ms"`
+}
+
+// Filter selects logged queries. Empty fields match everything.
+type Filter struct {
+	Domain string // Matches the domain and its subdomains
+	Client string
+	Action string // One of the dns.QueryAction* values
+	Since  time.Time
+	Until  time.Time
+	Limit  int
+	Offset int
+}
+
+// Page is one page of search results, newest first
+type Page struct {
+	Entries []Entry `json:"entries"`
+	Total   int     `json:"total"` // Entries matching the filter across all pages
+	Limit   int     `json:"limit"`
+	Offset  int     `json:"offset"`
+}
+
+// Log is an open query log
+type Log struct {
+	db        *sql.DB
+	retention time.Duration
+	maxSize   int64
+
+	queue   chan dns.QueryEvent
+	dropped atomic.Uint64
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// Open opens or creates the query log database and starts the writer
+func Open(cfg *config.QueryLogConfig) (*Log, error) {
+	// Queries reveal browsing history, so only root can read the log
+	if err := os.MkdirAll(filepath.Dir(cfg.Path), 0700); err != nil {
+		return nil, fmt.Errorf("failed to create query log directory: %v", err)
+	}
+
+	db, err := sql.Open("sqlite", cfg.Path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to open query log: %v", err)
+	}
+	// One connection keeps the pragmas in effect and serializes writers
+	db.SetMaxOpenConns(1)
+
+	for _, stmt := range []string{
+		"PRAGMA auto_vacuum = INCREMENTAL", // Only takes effect on a new database
+		"PRAGMA journal_mode = WAL",
+		"PRAGMA synchronous = NORMAL",
+		"PRAGMA busy_timeout = 5000",
+		schema,
+	} {
+		if _, err := db.Exec(stmt); err != nil {
+			db.Close()
+			return nil, fmt.Errorf("failed to initialize query log: %v", err)
+		}
+	}
+	if err := os.Chmod(cfg.Path, 0600); err != nil {
+		logrus.WithError(err).Warn("Failed to restrict query log permissions")
+	}
+
+	l := &Log{
+		db:         db,
+		retention:  cfg.Retention,
+		maxSize:    int64(cfg.MaxSizeMB) * 1024 * 1024,
+		queue:      make(chan dns.QueryEvent, queueSize),
+		shutdownCh: make(chan struct{}),
+	}
+
+	l.wg.Add(1)
+	go l.worker()
+
+	return l, nil
+}
+
+// Record queues a query to be written. It never blocks; queries are
+// dropped if the writer falls behind.
+func (l *Log) Record(event dns.QueryEvent) {
+	select {
+	case l.queue <- event:
+	default:
+		l.dropped.Add(1)
+	}
+}
+
+// Close writes queued queries and closes the database
+func (l *Log) Close() error {
+	close(l.shutdownCh)
+	l.wg.Wait()
+	return l.db.Close()
+}
+
+// worker writes queued queries in batches and periodically prunes the log
+func (l *Log) worker() {
+	defer l.wg.Done()
+
+	flush := time.NewTicker(flushInterval)
+	defer flush.Stop()
+	prune := time.NewTicker(pruneInterval)
+	defer prune.Stop()
+
+	l.prune()
+
+	batch := make([]dns.QueryEvent, 0, batchSize)
+	write := func() {
+		if len(batch) == 0 {
+			return
+		}
+		if err := l.insert(batch); err != nil {
+			logrus.WithError(err).WithField("queries", len(batch)).Error("Failed to write query log")
+		}
+		batch = batch[:0]
+	}
+
+	for {
+		select {
+		case <-l.shutdownCh:
+			for {
+				select {
+				case event := <-l.queue:
+					batch = append(batch, event)
+					if len(batch) == batchSize {
+						write()
+					}
+				default:
+					write()
+					return
+				}
+			}
+		case event := <-l.queue:
+			batch = append(batch, event)
+			if len(batch) == batchSize {
+				write()
+			}
+		case <-flush.C:
+			write()
+			if dropped := l.dropped.Swap(0); dropped > 0 {
+				logrus.WithField("dropped", dropped).Warn("Query log queue full, dropped queries")
+			}
+		case <-prune.C:
+			l.prune()
+		}
+	}
+}
+
+// insert writes events in a single transaction
+func (l *Log) insert(events []dns.QueryEvent) error {
+	tx, err := l.db.Begin()
+	if err != nil {
+		return err
+	}
+	defer tx.Rollback()
+
+	stmt, err := tx.Prepare(`INSERT INTO queries
+		(ts, domain, query_type, client_ip, action, rcode, rule, upstream, duration_us)
+		VALUES (?, ?, ?, ?, ?, ?, ?, ?, ?)`)
+	if err != nil {
+		return err
+	}
+	defer stmt.Close()
+
+	for _, e := range events {
+		if _, err := stmt.Exec(e.Timestamp.UnixNano(), strings.ToLower(e.Domain), e.QueryType, e.ClientIP,
+			e.Action, e.Rcode, e.Rule, e.Upstream, e.Duration.Microseconds()); err != nil {
+			return err
+		}
+	}
+	return tx.Commit()
+}
+
+// prune deletes queries past the retention period, then the oldest queries
+// until the database fits in its size limit
+func (l *Log) prune() {
+	cutoff := time.Now().Add(-l.retention).UnixNano()
+	if _, err := l.db.Exec("DELETE FROM queries WHERE ts < ?", cutoff); err != nil {
+		logrus.WithError(err).Error("Failed to prune query log")
+		return
+	}
+
+	for {
+		size, err := l.size()
+		if err != nil {
+			logrus.WithError(err).Error("Failed to read query log size")
+			return
+		}
+		if size <= l.maxSize {
+			break
+		}
+
+		// Delete the oldest tenth, at least 1000 rows, and check again
+		var count int
+		if err := l.db.QueryRow("SELECT COUNT(*) FROM queries").Scan(&count); err != nil || count == 0 {
+			break
+		}
+		n := count / 10
+		if n < 1000 {
+			n = 1000
+		}
+		if _, err := l.db.Exec("DELETE FROM queries WHERE id IN (SELECT id FROM queries ORDER BY ts LIMIT ?)", n); err != nil {
+			logrus.WithError(err).Error("Failed to prune query log")
+			return
+		}
+		if _, err := l.db.Exec("PRAGMA incremental_vacuum"); err != nil {
+			logrus.WithError(err).Warn("Failed to reclaim query log space")
+			return
+		}
+	}
+}
+
+// Purge deletes the queries logged before before and returns how many were
+// deleted
+func (l *Log) Purge(before time.Time) (int64, error) {
+	res, err := l.db.Exec("DELETE FROM queries WHERE ts < ?", before.UnixNano())
+	if err != nil {
+		return 0, fmt.Errorf("failed to purge query log: %v", err)
+	}
+	n, _ := res.RowsAffected()
+	if _, err := l.db.Exec("PRAGMA incremental_vacuum"); err != nil {
+		logrus.WithError(err).Warn("Failed to reclaim query log space")
+	}
+	return n, nil
+}
+
+// size returns the bytes used by the database, excluding free pages
+func (l *Log) size() (int64, error) {
+	var pages, free, pageSize int64
+	if err := l.db.QueryRow("PRAGMA page_count").Scan(&pages); err != nil {
+		return 0, err
+	}
+	if err := l.db.QueryRow("PRAGMA freelist_count").Scan(&free); err != nil {
+		return 0, err
+	}
+	if err := l.db.QueryRow("PRAGMA page_size").Scan(&pageSize); err != nil {
+		return 0, err
+	}
+	return (pages - free) * pageSize, nil
+}
+
+// Search returns the queries matching f, newest first
+func (l *Log) Search(f Filter) (*Page, error) {
+	if f.Limit <= 0 {
+		f.Limit = DefaultPageSize
+	}
+	if f.Limit > MaxPageSize {
+		f.Limit = MaxPageSize
+	}
+	if f.Offset < 0 {
+		f.Offset = 0
+	}
+
+	var where []string
+	var args []interface{}
+	if f.Domain != "" {
+		domain := strings.ToLower(strings.TrimSuffix(f.Domain, "."))
+		where = append(where, `(domain = ? OR domain LIKE ? ESCAPE '\')`)
+		args = append(args, domain, "%."+escapeLike(domain))
+	}
+	if f.Client != "" {
+		where = append(where, "client_ip = ?")
+		args = append(args, f.Client)
+	}
+	if f.Action != "" {
+		where = append(where, "action = ?")
+		args = append(args, f.Action)
+	}
+	if !f.Since.IsZero() {
+		where = append(where, "ts >= ?")
+		args = append(args, f.Since.UnixNano())
+	}
+	if !f.Until.IsZero() {
+		where = append(where, "ts < ?")
+		args = append(args, f.Until.UnixNano())
+	}
+	clause := ""
+	if len(where) > 0 {
+		clause = " WHERE " + strings.Join(where, " AND ")
+	}
+
+	page := &Page{Entries: []Entry{}, Limit: f.Limit, Offset: f.Offset}
+	if err := l.db.QueryRow("SELECT COUNT(*) FROM queries"+clause, args...).Scan(&page.Total); err != nil {
+		return nil, fmt.Errorf("failed to count queries: %v", err)
+	}
+
+	rows, err := l.db.Query(`SELECT ts, domain, query_type, client_ip, action, rcode, rule, upstream, duration_us
+		FROM queries`+clause+" ORDER BY ts DESC, id DESC LIMIT ? OFFSET ?",
+		append(args, f.Limit, f.Offset)...)
+	if err != nil {
+		return nil, fmt.Errorf("failed to search queries: %v", err)
+	}
+	defer rows.Close()
+
+	for rows.Next() {
+		var e Entry
+		var ts, durationUs int64
+		if err := rows.Scan(&ts, &e.Domain, &e.QueryType, &e.ClientIP, &e.Action, &e.Rcode,
+			&e.Rule, &e.Upstream, &durationUs); err != nil {
+			return nil, fmt.Errorf("failed to read query: %v", err)
+		}
+		e.Timestamp = time.Unix(0, ts)
+		e.DurationMs = float64(durationUs) / 1000
+		page.Entries = append(page.Entries, e)
+	}
+	return page, rows.Err()
+}
+
+// escapeLike escapes the LIKE wildcards in s
+func escapeLike(s string) string {
+	return strings.NewReplacer(`\`, `\\`, "%", `\%`, "_", `\_`).Replace(s)
+}
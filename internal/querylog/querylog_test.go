@@ -0,0 +1,126 @@
+package querylog
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+)
+
+func openTestLog(t *testing.T) *Log {
+	t.Helper()
+	l, err := Open(&config.QueryLogConfig{
+		Path:      filepath.Join(t.TempDir(), "querylog.db"),
+		Retention: time.Hour,
+		MaxSizeMB: 100,
+	})
+	if err != nil {
+		t.Fatalf("Open() error = %v", err)
+	}
+	t.Cleanup(func() { l.Close() })
+	return l
+}
+
+func TestSearch(t *testing.T) {
+	l := openTestLog(t)
+
+	now := time.Now()
+	events := []dns.QueryEvent{
+		{Timestamp: now.Add(-3 * time.Second), Domain: "example.com", QueryType: "A", ClientIP: "10.0.0.1", Action: dns.QueryActionAllowed},
+		{Timestamp: now.Add(-2 * time.Second), Domain: "Ads.Example.com", QueryType: "A", ClientIP: "10.0.0.2", Action: dns.QueryActionBlocked, Rule: "ads.example.com"},
+		{Timestamp: now.Add(-time.Second), Domain: "notexample.com", QueryType: "AAAA", ClientIP: "10.0.0.1", Action: dns.QueryActionCached},
+		{Timestamp: now, Domain: "tracker.io", QueryType: "A", ClientIP: "10.0.0.2", Action: dns.QueryActionBlocked, Duration: 1500 * time.Microsecond},
+	}
+	if err := l.insert(events); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name    string
+		filter  Filter
+		domains []string
+	}{
+		{"all newest first", Filter{}, []string{"tracker.io", "notexample.com", "ads.example.com", "example.com"}},
+		{"domain and subdomains", Filter{Domain: "example.com"}, []string{"ads.example.com", "example.com"}},
+		{"client", Filter{Client: "10.0.0.1"}, []string{"notexample.com", "example.com"}},
+		{"verdict", Filter{Action: dns.QueryActionBlocked}, []string{"tracker.io", "ads.example.com"}},
+		{"since", Filter{Since: now.Add(-1500 * time.Millisecond)}, []string{"tracker.io", "notexample.com"}},
+		{"page", Filter{Limit: 2, Offset: 1}, []string{"notexample.com", "ads.example.com"}},
+	}
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			page, err := l.Search(tt.filter)
+			if err != nil {
+				t.Fatalf("Search() error = %v", err)
+			}
+			var got []string
+			for _, e := range page.Entries {
+				got = append(got, e.Domain)
+			}
+			if len(got) != len(tt.domains) {
+				t.Fatalf("Search() = %v, want %v", got, tt.domains)
+			}
+			for i := range got {
+				if got[i] != tt.domains[i] {
+					t.Fatalf("Search() = %v, want %v", got, tt.domains)
+				}
+			}
+		})
+	}
+
+	page, _ := l.Search(Filter{Limit: 1})
+	if page.Total != 4 || page.Entries[0].DurationMs != 1.5 {
+		t.Errorf("Search() = %+v, want total 4 and 1.5ms", page)
+	}
+}
+
+func TestPruneRetention(t *testing.T) {
+	l := openTestLog(t)
+
+	now := time.Now()
+	if err := l.insert([]dns.QueryEvent{
+		{Timestamp: now.Add(-2 * time.Hour), Domain: "old.example.com", Action: dns.QueryActionAllowed},
+		{Timestamp: now, Domain: "new.example.com", Action: dns.QueryActionAllowed},
+	}); err != nil {
+		t.Fatal(err)
+	}
+
+	l.prune()
+
+	page, err := l.Search(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 || page.Entries[0].Domain != "new.example.com" {
+		t.Errorf("after prune Search() = %+v, want only new.example.com", page.Entries)
+	}
+}
+
+func TestRecordFlushesOnClose(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "querylog.db")
+	cfg := &config.QueryLogConfig{Path: path, Retention: time.Hour, MaxSizeMB: 100}
+
+	l, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	l.Record(dns.QueryEvent{Timestamp: time.Now(), Domain: "example.com", Action: dns.QueryActionAllowed})
+	if err := l.Close(); err != nil {
+		t.Fatal(err)
+	}
+
+	reopened, err := Open(cfg)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer reopened.Close()
+	page, err := reopened.Search(Filter{})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if page.Total != 1 {
+		t.Errorf("Total = %d after reopening, want 1", page.Total)
+	}
+}
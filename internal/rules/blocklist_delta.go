@@ -0,0 +1,408 @@
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+
+	"dnshield/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+// DefaultBlocklistCacheDir keeps the last copy of each external blocklist,
+// so refreshes only download what changed
+const DefaultBlocklistCacheDir = "/Library/Application Support/DNShield/blocklists"
+
+// DeltaPath is appended to a blocklist URL, followed by the SHA-256 of an
+// earlier version of the list, to find the delta from that version to the
+// current one
+const DeltaPath = ".delta/"
+
+// BlocklistDelta lists the domains added to and removed from a blocklist
+// between two versions, identified by the SHA-256 of their content
+type BlocklistDelta struct {
+	Base    string
+	Target  string
+	Added   []string
+	Removed []string
+}
+
+// DiffBlocklists compares two versions of a blocklist
+func (p *Parser) DiffBlocklists(oldContent, newContent []byte) (*BlocklistDelta, error) {
+	oldDomains := make(map[string]bool)
+	if err := p.ParseReader(bytes.NewReader(oldContent), func(domain string) error {
+		oldDomains[domain] = true
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+
+	delta := &BlocklistDelta{Base: contentHash(oldContent), Target: contentHash(newContent)}
+	newDomains := make(map[string]bool)
+	if err := p.ParseReader(bytes.NewReader(newContent), func(domain string) error {
+		if !newDomains[domain] && !oldDomains[domain] {
+			delta.Added = append(delta.Added, domain)
+		}
+		newDomains[domain] = true
+		return nil
+	}); err != nil {
+		return nil, err
+	}
+	for domain := range oldDomains {
+		if !newDomains[domain] {
+			delta.Removed = append(delta.Removed, domain)
+		}
+	}
+	sort.Strings(delta.Added)
+	sort.Strings(delta.Removed)
+	return delta, nil
+}
+
+// Marshal encodes the delta as published: two header comments naming the
+// versions, then one "+domain" or "-domain" line per change
+func (d *BlocklistDelta) Marshal() []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "# base %s\n# target %s\n", d.Base, d.Target)
+	for _, domain := range d.Removed {
+		fmt.Fprintf(&buf, "-%s\n", domain)
+	}
+	for _, domain := range d.Added {
+		fmt.Fprintf(&buf, "+%s\n", domain)
+	}
+	return buf.Bytes()
+}
+
+// ParseBlocklistDelta decodes a delta written by Marshal
+func ParseBlocklistDelta(r io.Reader) (*BlocklistDelta, error) {
+	delta := &BlocklistDelta{}
+	scanner := bufio.NewScanner(r)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		switch {
+		case line == "":
+		case strings.HasPrefix(line, "# base "):
+			delta.Base = strings.TrimPrefix(line, "# base ")
+		case strings.HasPrefix(line, "# target "):
+			delta.Target = strings.TrimPrefix(line, "# target ")
+		case strings.HasPrefix(line, "#"):
+		case line[0] == '+' || line[0] == '-':
+			domain, err := parseDeltaDomain(line[1:])
+			if err != nil {
+				return nil, fmt.Errorf("invalid blocklist delta line %q: %v", line, err)
+			}
+			if line[0] == '+' {
+				delta.Added = append(delta.Added, domain)
+			} else {
+				delta.Removed = append(delta.Removed, domain)
+			}
+		default:
+			return nil, fmt.Errorf("invalid blocklist delta line: %q", line)
+		}
+	}
+	if err := scanner.Err(); err != nil {
+		return nil, err
+	}
+	if delta.Base == "" || delta.Target == "" {
+		return nil, fmt.Errorf("blocklist delta is missing its base or target")
+	}
+	return delta, nil
+}
+
+// parseDeltaDomain checks a delta entry the way ParseReader checks a
+// blocklist line; each entry must be a single domain
+func parseDeltaDomain(entry string) (string, error) {
+	entry = strings.TrimSpace(entry)
+	domain := parseBlocklistLine(entry)
+	if domain == "" || domain != entry {
+		return "", fmt.Errorf("not a domain")
+	}
+	if err := utils.ValidateDomainLength(domain); err != nil {
+		return "", err
+	}
+	return strings.ToLower(domain), nil
+}
+
+// Apply returns domains with the delta applied
+func (d *BlocklistDelta) Apply(domains []string) []string {
+	removed := make(map[string]bool, len(d.Removed))
+	for _, domain := range d.Removed {
+		removed[domain] = true
+	}
+	present := make(map[string]bool, len(domains))
+	result := make([]string, 0, len(domains)+len(d.Added))
+	for _, domain := range domains {
+		if !removed[domain] {
+			result = append(result, domain)
+			present[domain] = true
+		}
+	}
+	for _, domain := range d.Added {
+		if !present[domain] {
+			result = append(result, domain)
+			present[domain] = true
+		}
+	}
+	return result
+}
+
+func contentHash(content []byte) string {
+	sum := sha256.Sum256(content)
+	return hex.EncodeToString(sum[:])
+}
+
+// BlocklistCache stores the domains of each external blocklist with the
+// hash and validators of the version they came from
+type BlocklistCache struct {
+	dir string
+}
+
+// NewBlocklistCache keeps blocklists in dir
+func NewBlocklistCache(dir string) *BlocklistCache {
+	return &BlocklistCache{dir: dir}
+}
+
+// cachedBlocklist is one cache file: this header as a JSON line, then a
+// domain per line. Hash is computed here for lists downloaded in full, but
+// taken from the delta's target for lists rebuilt from one, so FromDelta
+// lists can't be checked against a pinned checksum.
+type cachedBlocklist struct {
+	URL          string `json:"url"`
+	Hash         string `json:"sha256"`
+	FromDelta    bool   `json:"from_delta,omitempty"`
+	ETag         string `json:"etag,omitempty"`
+	LastModified string `json:"last_modified,omitempty"`
+
+	Domains []string `json:"-"`
+}
+
+func (c *BlocklistCache) path(url string) string {
+	return filepath.Join(c.dir, contentHash([]byte(url))+".txt")
+}
+
+func (c *BlocklistCache) load(url string) (*cachedBlocklist, error) {
+	f, err := os.Open(c.path(url))
+	if err != nil {
+		return nil, err
+	}
+	defer f.Close()
+
+	scanner := bufio.NewScanner(f)
+	if !scanner.Scan() {
+		return nil, fmt.Errorf("empty blocklist cache for %s", url)
+	}
+	var list cachedBlocklist
+	if err := json.Unmarshal(scanner.Bytes(), &list); err != nil || list.URL != url {
+		return nil, fmt.Errorf("invalid blocklist cache for %s", url)
+	}
+	for scanner.Scan() {
+		list.Domains = append(list.Domains, scanner.Text())
+	}
+	return &list, scanner.Err()
+}
+
+func (c *BlocklistCache) save(list *cachedBlocklist) error {
+	if err := os.MkdirAll(c.dir, 0755); err != nil {
+		return err
+	}
+	header, err := json.Marshal(list)
+	if err != nil {
+		return err
+	}
+
+	path := c.path(list.URL)
+	tmp := path + ".tmp"
+	f, err := os.Create(tmp)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	w.Write(header)
+	w.WriteByte('\n')
+	for _, domain := range list.Domains {
+		w.WriteString(domain)
+		w.WriteByte('\n')
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		os.Remove(tmp)
+		return err
+	}
+	if err := f.Close(); err != nil {
+		os.Remove(tmp)
+		return err
+	}
+	return os.Rename(tmp, path)
+}
+
+// fetchCached fetches a blocklist through the cache. A HEAD request tells
+// whether the cached version is current; if not, the delta from it is
+// applied when the list publishes one, and the whole list downloaded
+// otherwise. Lists with a pinned checksum skip deltas: a delta names its
+// own target hash, so only a full download can be checked against the pin.
+func (p *Parser) fetchCached(urlStr, expectedSHA256 string, fn func(domain string) error) error {
+	cached, err := p.cache.load(urlStr)
+	if err != nil && !os.IsNotExist(err) {
+		logrus.WithError(err).WithField("url", urlStr).Debug("Ignoring blocklist cache")
+	}
+	if cached != nil && cached.FromDelta && expectedSHA256 != "" {
+		cached = nil
+	}
+
+	var list *cachedBlocklist
+	if cached != nil {
+		// Validators are read before the delta, so a list changing in
+		// between is downloaded again next time rather than missed
+		etag, lastModified, ok := p.headBlocklist(urlStr)
+		switch {
+		case ok && cached.current(etag, lastModified):
+			logrus.WithField("url", urlStr).Debug("Blocklist unchanged, using cached copy")
+			list = cached
+		case ok && expectedSHA256 == "":
+			if delta := p.fetchDelta(urlStr, cached.Hash); delta != nil {
+				list = &cachedBlocklist{
+					URL:          urlStr,
+					Hash:         delta.Target,
+					FromDelta:    true,
+					ETag:         etag,
+					LastModified: lastModified,
+					Domains:      delta.Apply(cached.Domains),
+				}
+				logrus.WithFields(logrus.Fields{
+					"url":     urlStr,
+					"added":   len(delta.Added),
+					"removed": len(delta.Removed),
+				}).Info("Applied blocklist delta")
+			}
+		}
+	}
+
+	if list == nil {
+		req, err := http.NewRequest(http.MethodGet, urlStr, nil)
+		if err != nil {
+			return err
+		}
+		if cached != nil {
+			if cached.ETag != "" {
+				req.Header.Set("If-None-Match", cached.ETag)
+			}
+			if cached.LastModified != "" {
+				req.Header.Set("If-Modified-Since", cached.LastModified)
+			}
+		}
+		resp, err := p.httpClient.Do(req)
+		if err != nil {
+			return err
+		}
+		defer resp.Body.Close()
+
+		switch {
+		case resp.StatusCode == http.StatusNotModified && cached != nil:
+			logrus.WithField("url", urlStr).Debug("Blocklist unchanged, using cached copy")
+			list = cached
+		case resp.StatusCode == http.StatusOK:
+			hasher := sha256.New()
+			list = &cachedBlocklist{
+				URL:          urlStr,
+				ETag:         resp.Header.Get("ETag"),
+				LastModified: resp.Header.Get("Last-Modified"),
+			}
+			if err := p.ParseReader(io.TeeReader(resp.Body, hasher), func(domain string) error {
+				list.Domains = append(list.Domains, domain)
+				return fn(domain)
+			}); err != nil {
+				return err
+			}
+			list.Hash = hex.EncodeToString(hasher.Sum(nil))
+			if err := p.verifyChecksum(urlStr, list.Hash, expectedSHA256); err != nil {
+				return err
+			}
+			p.saveCached(list)
+			logrus.WithFields(logrus.Fields{"url": urlStr, "domains": len(list.Domains)}).Info("Parsed blocklist")
+			return nil
+		default:
+			return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		}
+	}
+
+	if err := p.verifyChecksum(urlStr, list.Hash, expectedSHA256); err != nil {
+		return err
+	}
+	for _, domain := range list.Domains {
+		if err := fn(domain); err != nil {
+			return err
+		}
+	}
+	if list != cached {
+		p.saveCached(list)
+	}
+	return nil
+}
+
+// current reports whether validators from the server match the cached
+// version
+func (l *cachedBlocklist) current(etag, lastModified string) bool {
+	if etag != "" {
+		return etag == l.ETag
+	}
+	return lastModified != "" && lastModified == l.LastModified
+}
+
+// headBlocklist returns the validators of the current version of a
+// blocklist. ok is false when the server doesn't answer HEAD requests.
+func (p *Parser) headBlocklist(urlStr string) (etag, lastModified string, ok bool) {
+	resp, err := p.httpClient.Head(urlStr)
+	if err != nil {
+		return "", "", false
+	}
+	resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return "", "", false
+	}
+	return resp.Header.Get("ETag"), resp.Header.Get("Last-Modified"), true
+}
+
+// fetchDelta downloads the delta from the version of a blocklist with hash
+// base, returning nil when there is none
+func (p *Parser) fetchDelta(urlStr, base string) *BlocklistDelta {
+	deltaURL := urlStr + DeltaPath + base
+	resp, err := p.httpClient.Get(deltaURL)
+	if err != nil {
+		return nil
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil
+	}
+
+	// A truncated delta still has its headers, so reading past the limit
+	// rejects it rather than caching a partial list under the new hash
+	counter := &countingReader{r: io.LimitReader(resp.Body, p.maxFileSize+1)}
+	delta, err := ParseBlocklistDelta(counter)
+	switch {
+	case err != nil:
+	case counter.n > p.maxFileSize:
+		err = fmt.Errorf("delta exceeds maximum size of %d bytes", p.maxFileSize)
+	case delta.Base != base:
+		err = fmt.Errorf("delta is from %s", delta.Base)
+	}
+	if err != nil {
+		logrus.WithError(err).WithField("url", deltaURL).Warn("Ignoring invalid blocklist delta")
+		return nil
+	}
+	return delta
+}
+
+func (p *Parser) saveCached(list *cachedBlocklist) {
+	if err := p.cache.save(list); err != nil {
+		logrus.WithError(err).WithField("url", list.URL).Warn("Failed to cache blocklist")
+	}
+}
@@ -0,0 +1,197 @@
+package rules
+
+import (
+	"bytes"
+	"fmt"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"testing"
+)
+
+func TestBlocklistDelta(t *testing.T) {
+	p := NewParser()
+	oldList := []byte("0.0.0.0 ads.example.com\n0.0.0.0 tracker.example.com\n")
+	newList := []byte("0.0.0.0 ads.example.com\n0.0.0.0 metrics.example.com\n")
+
+	delta, err := p.DiffBlocklists(oldList, newList)
+	if err != nil {
+		t.Fatalf("DiffBlocklists: %v", err)
+	}
+	parsed, err := ParseBlocklistDelta(bytes.NewReader(delta.Marshal()))
+	if err != nil {
+		t.Fatalf("ParseBlocklistDelta: %v", err)
+	}
+	if !reflect.DeepEqual(parsed, delta) {
+		t.Errorf("round trip = %+v, want %+v", parsed, delta)
+	}
+	if parsed.Base != contentHash(oldList) || parsed.Target != contentHash(newList) {
+		t.Errorf("delta versions = %s → %s", parsed.Base, parsed.Target)
+	}
+
+	got := parsed.Apply([]string{"ads.example.com", "tracker.example.com"})
+	if want := []string{"ads.example.com", "metrics.example.com"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Apply = %v, want %v", got, want)
+	}
+
+	if _, err := ParseBlocklistDelta(bytes.NewReader([]byte("+ads.example.com\n"))); err == nil {
+		t.Error("delta without versions accepted")
+	}
+	for _, line := range []string{"+0.0.0.0 ads.example.com", "+", "-" + strings.Repeat("a", 64) + ".com"} {
+		if _, err := ParseBlocklistDelta(strings.NewReader("# base a\n# target b\n" + line + "\n")); err == nil {
+			t.Errorf("delta line %q accepted", line)
+		}
+	}
+}
+
+func TestFetchCached(t *testing.T) {
+	oldList := []byte("ads.example.com\ntracker.example.com\n")
+	newList := []byte("ads.example.com\nmetrics.example.com\n")
+	delta, err := NewParser().DiffBlocklists(oldList, newList)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	var mu sync.Mutex
+	var fullDownloads int
+	list, etag := oldList, `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/list.txt":
+			w.Header().Set("ETag", etag)
+			if r.Header.Get("If-None-Match") == etag {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			if r.Method == http.MethodGet {
+				fullDownloads++
+			}
+			w.Write(list)
+		case "/list.txt" + DeltaPath + delta.Base:
+			w.Write(delta.Marshal())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := NewParser()
+	p.SetCache(NewBlocklistCache(t.TempDir()))
+	fetch := func() []string {
+		t.Helper()
+		var domains []string
+		if err := p.fetchCached(srv.URL+"/list.txt", "", func(domain string) error {
+			domains = append(domains, domain)
+			return nil
+		}); err != nil {
+			t.Fatalf("fetchCached: %v", err)
+		}
+		sort.Strings(domains)
+		return domains
+	}
+
+	want := []string{"ads.example.com", "tracker.example.com"}
+	if got := fetch(); !reflect.DeepEqual(got, want) {
+		t.Errorf("first fetch = %v, want %v", got, want)
+	}
+	if got := fetch(); !reflect.DeepEqual(got, want) {
+		t.Errorf("unchanged fetch = %v, want %v", got, want)
+	}
+	if fullDownloads != 1 {
+		t.Errorf("list downloaded %d times, want 1", fullDownloads)
+	}
+
+	mu.Lock()
+	list, etag = newList, `"v2"`
+	mu.Unlock()
+	want = []string{"ads.example.com", "metrics.example.com"}
+	if got := fetch(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fetch with delta = %v, want %v", got, want)
+	}
+	if fullDownloads != 1 {
+		t.Errorf("list downloaded %d times after delta, want 1", fullDownloads)
+	}
+
+	if got := fetch(); !reflect.DeepEqual(got, want) {
+		t.Errorf("fetch after delta = %v, want %v", got, want)
+	}
+	if fullDownloads != 1 {
+		t.Errorf("list downloaded %d times after delta, want 1", fullDownloads)
+	}
+}
+
+func TestFetchCachedRejectsUntrustedDeltas(t *testing.T) {
+	list := []byte("ads.example.com\ntracker.example.com\n")
+	pinned := contentHash(list)
+	forged := &BlocklistDelta{Base: pinned, Target: pinned, Added: []string{"evil.example.com"}}
+	oversized := &BlocklistDelta{Base: pinned, Target: "next"}
+	for i := 0; i < 100; i++ {
+		oversized.Added = append(oversized.Added, fmt.Sprintf("host%d.example.com", i))
+	}
+
+	var mu sync.Mutex
+	var fullDownloads int
+	delta, etag := forged, `"v1"`
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		mu.Lock()
+		defer mu.Unlock()
+		switch r.URL.Path {
+		case "/list.txt":
+			w.Header().Set("ETag", etag)
+			if r.Method == http.MethodGet {
+				fullDownloads++
+			}
+			w.Write(list)
+		case "/list.txt" + DeltaPath + pinned:
+			w.Write(delta.Marshal())
+		default:
+			http.NotFound(w, r)
+		}
+	}))
+	defer srv.Close()
+
+	p := NewParser()
+	p.SetLimits(512, 0)
+	p.SetCache(NewBlocklistCache(t.TempDir()))
+	fetch := func(expectedSHA256 string) []string {
+		t.Helper()
+		var domains []string
+		if err := p.fetchCached(srv.URL+"/list.txt", expectedSHA256, func(domain string) error {
+			domains = append(domains, domain)
+			return nil
+		}); err != nil {
+			t.Fatalf("fetchCached: %v", err)
+		}
+		sort.Strings(domains)
+		return domains
+	}
+	want := []string{"ads.example.com", "tracker.example.com"}
+
+	// A delta claiming the pinned hash as its target isn't trusted
+	fetch(pinned)
+	mu.Lock()
+	etag = `"v2"`
+	mu.Unlock()
+	if got := fetch(pinned); !reflect.DeepEqual(got, want) {
+		t.Errorf("pinned fetch = %v, want %v", got, want)
+	}
+	if fullDownloads != 2 {
+		t.Errorf("pinned list downloaded %d times, want 2", fullDownloads)
+	}
+
+	// A delta over the size limit is refused rather than cut short
+	mu.Lock()
+	delta, etag = oversized, `"v3"`
+	mu.Unlock()
+	if got := fetch(""); !reflect.DeepEqual(got, want) {
+		t.Errorf("fetch with oversized delta = %v, want %v", got, want)
+	}
+	if fullDownloads != 3 {
+		t.Errorf("list downloaded %d times with oversized delta, want 3", fullDownloads)
+	}
+}
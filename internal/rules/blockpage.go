@@ -0,0 +1,133 @@
+package rules
+
+import (
+	"context"
+	"fmt"
+	"path"
+	"sort"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// BlockPageTemplateFile is the template of a custom block page, relative
+	// to the block page prefix
+	BlockPageTemplateFile = "index.html"
+
+	maxBlockPageFiles = 50
+	maxBlockPageSize  = 10 * 1024 * 1024 // Template and assets combined
+)
+
+// BlockPageBundle is a custom block page from the rules bucket: an HTML
+// template and the files it references
+type BlockPageBundle struct {
+	Template []byte
+	Assets   map[string][]byte // Keyed by path relative to the prefix
+}
+
+// FetchBlockPage downloads the custom block page under the block page
+// prefix. It returns nil if the prefix has no template. Objects are only
+// downloaded again when their ETags change.
+func (f *EnterpriseFetcher) FetchBlockPage(ctx context.Context) (*BlockPageBundle, error) {
+	prefix := f.paths.BlockPageDir
+	if prefix == "" {
+		return nil, nil
+	}
+	if !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+
+	etags := make(map[string]string)
+	var total int64
+	objects, err := f.store.List(ctx, prefix)
+	if err == ErrListNotSupported {
+		logrus.Debug("Rules store can't list objects, using the built-in block page")
+		f.setBlockPage(nil, nil)
+		return nil, nil
+	}
+	if err != nil {
+		return nil, fmt.Errorf("failed to list %s: %v", prefix, err)
+	}
+	for _, obj := range objects {
+		name := strings.TrimPrefix(obj.Key, prefix)
+		if !validAssetName(name) {
+			continue
+		}
+		etags[name] = obj.ETag
+		total += obj.Size
+	}
+
+	if _, ok := etags[BlockPageTemplateFile]; !ok {
+		f.setBlockPage(nil, nil)
+		return nil, nil
+	}
+	if len(etags) > maxBlockPageFiles {
+		return nil, fmt.Errorf("%s has %d files (at most %d)", prefix, len(etags), maxBlockPageFiles)
+	}
+	if total > maxBlockPageSize {
+		return nil, fmt.Errorf("%s is %d bytes (at most %d)", prefix, total, maxBlockPageSize)
+	}
+
+	f.mu.RLock()
+	cached, cachedETags := f.blockPage, f.blockPageETags
+	f.mu.RUnlock()
+	if cached != nil && sameETags(etags, cachedETags) {
+		return cached, nil
+	}
+
+	names := make([]string, 0, len(etags))
+	for name := range etags {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+
+	bundle := &BlockPageBundle{Assets: make(map[string][]byte)}
+	for _, name := range names {
+		content, err := f.FetchObject(ctx, prefix+name)
+		if err != nil {
+			return nil, fmt.Errorf("failed to fetch %s%s: %v", prefix, name, err)
+		}
+		if name == BlockPageTemplateFile {
+			bundle.Template = content
+		} else {
+			bundle.Assets[name] = content
+		}
+	}
+
+	f.setBlockPage(bundle, etags)
+	return bundle, nil
+}
+
+func (f *EnterpriseFetcher) setBlockPage(bundle *BlockPageBundle, etags map[string]string) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	f.blockPage = bundle
+	f.blockPageETags = etags
+}
+
+// validAssetName accepts relative paths without empty, "." or ".."
+// elements, so names can be used in URLs as they are
+func validAssetName(name string) bool {
+	if name == "" || strings.HasPrefix(name, "/") || strings.HasSuffix(name, "/") || path.Clean(name) != name {
+		return false
+	}
+	for _, elem := range strings.Split(name, "/") {
+		if elem == "." || elem == ".." {
+			return false
+		}
+	}
+	return true
+}
+
+func sameETags(a, b map[string]string) bool {
+	if len(a) != len(b) {
+		return false
+	}
+	for name, etag := range a {
+		if b[name] != etag {
+			return false
+		}
+	}
+	return true
+}
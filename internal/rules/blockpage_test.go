@@ -0,0 +1,24 @@
+package rules
+
+import "testing"
+
+func TestValidAssetName(t *testing.T) {
+	tests := map[string]bool{
+		"index.html":     true,
+		"logo.png":       true,
+		"css/site.css":   true,
+		"":               false,
+		"css/":           false,
+		"/logo.png":      false,
+		"../secret":      false,
+		"css/../x.css":   false,
+		"./logo.png":     false,
+		"css//site.css":  false,
+		"img/./logo.png": false,
+	}
+	for name, want := range tests {
+		if got := validAssetName(name); got != want {
+			t.Errorf("validAssetName(%q) = %v, want %v", name, got, want)
+		}
+	}
+}
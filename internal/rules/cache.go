@@ -0,0 +1,66 @@
+package rules
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// DefaultCachePath holds the last enterprise rules fetched, so the agent
+// can start with them when S3 is unreachable. dnshield update-rules also
+// writes it when the agent isn't running.
+const DefaultCachePath = "/Library/Application Support/DNShield/rules-cache.yaml"
+
+// Stale reports whether rules fetched at fetchedAt are older than
+// staleAfter (s3.staleAfter) at now. Rules never fetched are stale.
+func Stale(fetchedAt time.Time, staleAfter time.Duration, now time.Time) bool {
+	return fetchedAt.IsZero() || now.Sub(fetchedAt) > staleAfter
+}
+
+// SaveCache writes rules to path, replacing any previous cache atomically
+func SaveCache(path string, rules *EnterpriseRules) error {
+	data, err := yaml.Marshal(rules)
+	if err != nil {
+		return fmt.Errorf("failed to encode rules cache: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(path), 0755); err != nil {
+		return fmt.Errorf("failed to create rules cache directory: %v", err)
+	}
+
+	tmp := path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return fmt.Errorf("failed to write rules cache: %v", err)
+	}
+	if err := os.Rename(tmp, path); err != nil {
+		os.Remove(tmp)
+		return fmt.Errorf("failed to write rules cache: %v", err)
+	}
+	return nil
+}
+
+// LoadCache reads rules saved by SaveCache
+func LoadCache(path string) (*EnterpriseRules, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+
+	if err := utils.SafeYAMLUnmarshal(data, nil, utils.MaxRulesFileSize); err != nil {
+		return nil, fmt.Errorf("invalid rules cache: %v", err)
+	}
+	var rules EnterpriseRules
+	if err := yaml.Unmarshal(data, &rules); err != nil {
+		return nil, fmt.Errorf("invalid rules cache: %v", err)
+	}
+	for _, r := range []*config.Rules{rules.BaseRules, rules.GroupRules, rules.UserRules} {
+		if r != nil {
+			r.Normalize()
+		}
+	}
+	return &rules, nil
+}
@@ -0,0 +1,59 @@
+package rules
+
+import (
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+func TestCacheRoundTrip(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "cache", "rules-cache.yaml")
+	saved := &EnterpriseRules{
+		DeviceName: "laptop-1",
+		UserEmail:  "user@example.com",
+		GroupName:  "engineering",
+		BaseRules:  &config.Rules{BlockDomains: []string{"ads.example.com"}},
+		GroupRules: &config.Rules{AllowDomains: []string{"tools.example.com"}},
+		FetchTime:  time.Now().Truncate(time.Second),
+	}
+
+	if err := SaveCache(path, saved); err != nil {
+		t.Fatalf("SaveCache() error = %v", err)
+	}
+	loaded, err := LoadCache(path)
+	if err != nil {
+		t.Fatalf("LoadCache() error = %v", err)
+	}
+
+	if loaded.GroupName != "engineering" || !loaded.FetchTime.Equal(saved.FetchTime) {
+		t.Errorf("LoadCache() = %+v", loaded)
+	}
+	block, allow, _ := loaded.MergeRules()
+	if len(block) != 1 || len(allow) != 1 {
+		t.Errorf("MergeRules() after reload = %v, %v", block, allow)
+	}
+
+	if _, err := LoadCache(filepath.Join(t.TempDir(), "missing.yaml")); err == nil {
+		t.Error("LoadCache() of a missing file succeeded")
+	}
+}
+
+func TestStale(t *testing.T) {
+	fetched := time.Date(2024, 3, 1, 12, 0, 0, 0, time.UTC)
+	staleAfter := 24 * time.Hour
+
+	if Stale(fetched, staleAfter, fetched.Add(time.Hour)) {
+		t.Error("rules fetched an hour ago are stale")
+	}
+	if Stale(fetched, staleAfter, fetched.Add(staleAfter)) {
+		t.Error("rules exactly s3.staleAfter old are stale")
+	}
+	if !Stale(fetched, staleAfter, fetched.Add(staleAfter+time.Second)) {
+		t.Error("rules older than s3.staleAfter aren't stale")
+	}
+	if !Stale(time.Time{}, staleAfter, fetched) {
+		t.Error("rules never fetched aren't stale")
+	}
+}
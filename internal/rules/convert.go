@@ -0,0 +1,449 @@
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/utils"
+)
+
+// Formats of the lists rules are imported from and exported to
+const (
+	// Pi-hole adlists: hosts lines, plain domains, ||example.com^ and
+	// regexes as in its regex list
+	ListFormatPihole = "pihole"
+	// AdGuard Home filters: adblock syntax with @@ exceptions, /regex/
+	// rules and hosts lines
+	ListFormatAdGuard = "adguard"
+	// Hosts files: an address followed by names
+	ListFormatHosts = "hosts"
+)
+
+// ListFormats are the formats ImportList and ExportList support
+var ListFormats = []string{ListFormatPihole, ListFormatAdGuard, ListFormatHosts}
+
+// hostsLocalNames are hosts file entries for the machine itself, never
+// imported as rules
+var hostsLocalNames = map[string]bool{
+	"localhost":             true,
+	"localhost.localdomain": true,
+	"local":                 true,
+	"broadcasthost":         true,
+	"ip6-localhost":         true,
+	"ip6-loopback":          true,
+	"ip6-localnet":          true,
+	"ip6-mcastprefix":       true,
+	"ip6-allnodes":          true,
+	"ip6-allrouters":        true,
+	"ip6-allhosts":          true,
+	"0.0.0.0":               true,
+}
+
+// listDomainPattern matches the names, with * wildcards, adblock and
+// hosts rules can be converted from
+var listDomainPattern = regexp.MustCompile(`^[a-z0-9_*-]+(\.[a-z0-9_*-]+)*$`)
+
+// ImportedList is a list converted to DNShield rules
+type ImportedList struct {
+	BlockDomains []string
+	AllowDomains []string
+	BlockRegex   []string
+	// Lines with rules DNShield can't express, such as cosmetic rules,
+	// modifiers other than $important and exceptions by regex
+	Skipped []string
+}
+
+// Rules returns the list as a rules file
+func (l *ImportedList) Rules(description string) *config.Rules {
+	now := time.Now()
+	return &config.Rules{
+		Version:      now.Format("2006.01.02"),
+		Description:  description,
+		Updated:      now.UTC().Truncate(time.Second),
+		BlockDomains: l.BlockDomains,
+		AllowDomains: l.AllowDomains,
+		BlockRegex:   l.BlockRegex,
+	}
+}
+
+// listRule is one rule converted from a list line
+type listRule struct {
+	rule  string
+	allow bool
+	regex bool // rule is an RE2 pattern rather than a domain rule
+}
+
+// ImportList converts a list in format, read from r, to DNShield rules.
+// Adblock wildcards become "*.example.com" rules where the domain engine
+// can match them and regexes otherwise. With allow, the list's rules are
+// imported as allow rules, as for a Pi-hole allowlist. The parser's size
+// and domain limits apply.
+func (p *Parser) ImportList(r io.Reader, format string, allow bool) (*ImportedList, error) {
+	var parse func(line string) ([]listRule, bool)
+	switch format {
+	case ListFormatPihole:
+		parse = parsePiholeLine
+	case ListFormatAdGuard:
+		parse = parseAdGuardLine
+	case ListFormatHosts:
+		parse = parseHostsLine
+	default:
+		return nil, fmt.Errorf("unknown list format %q, want %s", format, strings.Join(ListFormats, ", "))
+	}
+
+	counter := &countingReader{r: io.LimitReader(r, p.maxFileSize+1)}
+	scanner := bufio.NewScanner(counter)
+	list := &ImportedList{}
+	seen := make(map[listRule]bool)
+	count := 0
+
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		rules, ok := parse(line)
+		if ok && allow {
+			for i := range rules {
+				// Allow rules can't be regexes
+				ok = ok && !rules[i].regex
+				rules[i].allow = true
+			}
+		}
+		if !ok {
+			list.Skipped = append(list.Skipped, line)
+			continue
+		}
+
+		for _, rule := range rules {
+			if seen[rule] {
+				continue
+			}
+			seen[rule] = true
+			if count++; count > p.maxDomains {
+				return nil, fmt.Errorf("list has more than %d rules", p.maxDomains)
+			}
+			switch {
+			case rule.regex:
+				list.BlockRegex = append(list.BlockRegex, rule.rule)
+			case rule.allow:
+				list.AllowDomains = append(list.AllowDomains, rule.rule)
+			default:
+				list.BlockDomains = append(list.BlockDomains, rule.rule)
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return nil, fmt.Errorf("error reading list: %v", err)
+	}
+	if counter.n > p.maxFileSize {
+		return nil, fmt.Errorf("list exceeds maximum size of %d bytes", p.maxFileSize)
+	}
+	return list, nil
+}
+
+// ImportListURL downloads a list and converts it as ImportList does.
+// Unlike block sources, the list may be on the local network, such as
+// one served by the Pi-hole being migrated from.
+func (p *Parser) ImportListURL(urlStr, format string, allow bool) (*ImportedList, error) {
+	if !strings.HasPrefix(urlStr, "http://") && !strings.HasPrefix(urlStr, "https://") {
+		return nil, fmt.Errorf("only http and https URLs are allowed")
+	}
+	resp, err := p.httpClient.Get(urlStr)
+	if err != nil {
+		return nil, err
+	}
+	defer resp.Body.Close()
+	if resp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+	}
+	return p.ImportList(resp.Body, format, allow)
+}
+
+// parseHostsLine converts a hosts file line, an address followed by names,
+// or a lone domain. Comments and blank lines convert to no rules.
+func parseHostsLine(line string) ([]listRule, bool) {
+	if i := strings.IndexByte(line, '#'); i >= 0 {
+		line = line[:i]
+	}
+	fields := strings.Fields(strings.ToLower(line))
+	if len(fields) == 0 {
+		return nil, true
+	}
+
+	names := fields
+	if net.ParseIP(fields[0]) != nil {
+		names = fields[1:]
+	} else if len(fields) > 1 {
+		return nil, false
+	}
+
+	var rules []listRule
+	for _, name := range names {
+		name = strings.TrimSuffix(name, ".")
+		if hostsLocalNames[name] {
+			continue
+		}
+		if !validListDomain(name) || strings.Contains(name, "*") {
+			return nil, false
+		}
+		rules = append(rules, listRule{rule: name})
+	}
+	return rules, true
+}
+
+// parsePiholeLine converts a line of a Pi-hole adlist or regex list
+func parsePiholeLine(line string) ([]listRule, bool) {
+	if line == "" || strings.HasPrefix(line, "#") {
+		return nil, true
+	}
+	if strings.HasPrefix(line, "||") || strings.HasPrefix(line, "@@") {
+		return parseAdblockRule(line)
+	}
+	if rules, ok := parseHostsLine(line); ok {
+		return rules, true
+	}
+
+	// Regex list entries; ;querytype= and other options have no equivalent
+	if strings.Contains(line, ";") {
+		return nil, false
+	}
+	if _, err := regexp.Compile(line); err != nil {
+		return nil, false
+	}
+	return []listRule{{rule: line, regex: true}}, true
+}
+
+// parseAdGuardLine converts a line of an AdGuard Home filter
+func parseAdGuardLine(line string) ([]listRule, bool) {
+	if line == "" || strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") {
+		return nil, true
+	}
+	// Cosmetic rules only apply in browsers
+	for _, marker := range []string{"##", "#@#", "#$#", "#?#", "#%#"} {
+		if strings.Contains(line, marker) {
+			return nil, false
+		}
+	}
+	if strings.HasPrefix(line, "#") {
+		return nil, true
+	}
+	if fields := strings.Fields(line); len(fields) > 1 && net.ParseIP(fields[0]) != nil {
+		return parseHostsLine(line)
+	}
+	return parseAdblockRule(line)
+}
+
+// parseAdblockRule converts an adblock-style rule: ||example.com^ for a
+// domain and its subdomains, |example.com^ for the name alone, /regex/
+// or a plain domain, with @@ for exceptions and $important as the only
+// modifier
+func parseAdblockRule(line string) ([]listRule, bool) {
+	rule := listRule{}
+	if strings.HasPrefix(line, "@@") {
+		rule.allow = true
+		line = line[2:]
+	}
+
+	if strings.HasPrefix(line, "/") {
+		end := strings.LastIndexByte(line, '/')
+		if end < 2 || (line[end+1:] != "" && line[end+1:] != "$important") || rule.allow {
+			return nil, false
+		}
+		rule.rule, rule.regex = line[1:end], true
+		if _, err := regexp.Compile(rule.rule); err != nil {
+			return nil, false
+		}
+		return []listRule{rule}, true
+	}
+
+	if i := strings.IndexByte(line, '$'); i >= 0 {
+		if line[i+1:] != "important" {
+			return nil, false
+		}
+		line = line[:i]
+	}
+	line = strings.ToLower(line)
+
+	var pattern string
+	anchored := false // |example.com^: the name alone
+	switch {
+	case strings.HasPrefix(line, "||"):
+		pattern = strings.TrimSuffix(strings.TrimSuffix(line[2:], "|"), "^")
+	case strings.HasPrefix(line, "|"):
+		pattern = strings.TrimSuffix(strings.TrimSuffix(line[1:], "|"), "^")
+		anchored = true
+	default:
+		pattern = line
+	}
+	pattern = strings.TrimSuffix(pattern, ".")
+	if !validListDomain(pattern) {
+		return nil, false
+	}
+
+	wildcard := strings.Contains(pattern, "*")
+	switch {
+	case !anchored && !wildcard:
+		rule.rule = pattern
+	case !anchored && strings.HasPrefix(pattern, "*.") && !strings.Contains(pattern[2:], "*"):
+		// Subdomains only, which the domain engine matches directly
+		rule.rule = pattern
+	case rule.allow:
+		// Exceptions can't be regexes
+		return nil, false
+	case anchored:
+		rule.rule, rule.regex = "^"+globRegex(pattern)+"$", true
+	default:
+		rule.rule, rule.regex = `^(.*\.)?`+globRegex(pattern)+"$", true
+	}
+	return []listRule{rule}, true
+}
+
+// globRegex converts a name with * wildcards to an unanchored regex
+func globRegex(pattern string) string {
+	parts := strings.Split(pattern, "*")
+	for i := range parts {
+		parts[i] = regexp.QuoteMeta(parts[i])
+	}
+	return strings.Join(parts, ".*")
+}
+
+// validListDomain reports whether name, which may have * wildcards, is a
+// name rules can be converted from
+func validListDomain(name string) bool {
+	if !listDomainPattern.MatchString(name) {
+		return false
+	}
+	return utils.ValidateDomainLength(name) == nil
+}
+
+// splitDomainRule returns the domain a DNShield domain rule applies to and
+// whether it covers only subdomains, or false if the rule isn't valid.
+// rule must already be lowercase.
+func splitDomainRule(rule string) (domain string, subdomainsOnly, ok bool) {
+	domain = rule
+	if strings.HasPrefix(domain, "||") {
+		domain = strings.TrimSuffix(strings.TrimSuffix(strings.TrimPrefix(domain, "||"), "$important"), "^")
+	} else if strings.HasPrefix(domain, "*.") {
+		domain, subdomainsOnly = strings.TrimPrefix(domain, "*."), true
+	}
+	domain = strings.TrimSuffix(domain, ".")
+	if domain == "" || strings.ContainsAny(domain, "*^|$/ ") {
+		return "", false, false
+	}
+	return domain, subdomainsOnly, true
+}
+
+// ExportList writes the rules of a rules file as a list in format, for
+// migrating to Pi-hole, AdGuard Home or a hosts-based blocker. Block
+// rules, security ones included, are written unless allow is set, when
+// only the allow rules are, as @@ exceptions in a list of their own.
+// AdGuard filters also carry the allow rules and the regexes. Hosts
+// entries only block the names listed, not their subdomains. It returns what
+// couldn't be written, such as external sources or rules the format has
+// no syntax for.
+func ExportList(w io.Writer, r *config.Rules, format string, allow bool) ([]string, error) {
+	switch format {
+	case ListFormatPihole, ListFormatAdGuard:
+	case ListFormatHosts:
+		if allow {
+			return nil, fmt.Errorf("hosts files can't hold allow rules")
+		}
+	default:
+		return nil, fmt.Errorf("unknown list format %q, want %s", format, strings.Join(ListFormats, ", "))
+	}
+
+	var skipped []string
+	skip := func(format string, args ...interface{}) {
+		skipped = append(skipped, fmt.Sprintf(format, args...))
+	}
+	if !allow {
+		for _, source := range append(r.BlockSources, r.SecurityBlockSources...) {
+			skip("block source %s: import the list itself", source)
+		}
+		for _, category := range r.BlockCategories {
+			skip("block category %s", category)
+		}
+		if len(r.Schedules) > 0 {
+			skip("%d schedules", len(r.Schedules))
+		}
+	}
+
+	bw := bufio.NewWriter(w)
+	comment := "#"
+	if format == ListFormatAdGuard {
+		comment = "!"
+	}
+	fmt.Fprintf(bw, "%s Exported from DNShield rules", comment)
+	if r.Version != "" {
+		fmt.Fprintf(bw, " version %s", r.Version)
+	}
+	fmt.Fprintln(bw)
+	if r.Description != "" {
+		fmt.Fprintf(bw, "%s %s\n", comment, r.Description)
+	}
+
+	seen := make(map[string]bool)
+	write := func(rules []string, exception bool) {
+		for _, entry := range rules {
+			rule := strings.ToLower(strings.TrimSpace(entry))
+			domain, subdomainsOnly, ok := splitDomainRule(rule)
+			if !ok {
+				skip("unsupported rule %s", entry)
+				continue
+			}
+
+			var line string
+			switch {
+			case format == ListFormatHosts && !subdomainsOnly:
+				line = "0.0.0.0 " + domain
+			case format == ListFormatAdGuard && subdomainsOnly:
+				line = "||*." + domain + "^"
+			case subdomainsOnly:
+				skip("subdomains-only rule %s", entry)
+				continue
+			default:
+				line = "||" + domain + "^"
+			}
+			if exception {
+				line = "@@" + line
+			}
+			if !seen[line] {
+				seen[line] = true
+				fmt.Fprintln(bw, line)
+			}
+		}
+	}
+
+	switch {
+	case allow:
+		write(r.AllowDomains, true)
+	case format == ListFormatAdGuard:
+		write(r.BlockDomains, false)
+		write(r.SecurityBlockDomains, false)
+		write(r.AllowDomains, true)
+		for _, re := range r.BlockRegex {
+			if strings.Contains(re, "/") {
+				skip("regex %s", re)
+				continue
+			}
+			fmt.Fprintf(bw, "/%s/\n", re)
+		}
+	default:
+		write(r.BlockDomains, false)
+		write(r.SecurityBlockDomains, false)
+		if len(r.AllowDomains) > 0 {
+			skip("%d allow rules, which this format keeps in a list of their own", len(r.AllowDomains))
+		}
+		for _, re := range r.BlockRegex {
+			skip("regex %s", re)
+		}
+	}
+
+	return skipped, bw.Flush()
+}
@@ -0,0 +1,165 @@
+package rules
+
+import (
+	"bytes"
+	"reflect"
+	"strings"
+	"testing"
+
+	"dnshield/internal/config"
+)
+
+func TestImportList(t *testing.T) {
+	tests := []struct {
+		format  string
+		list    string
+		block   []string
+		allow   []string
+		regex   []string
+		skipped []string
+	}{
+		{
+			format: ListFormatHosts,
+			list: `# Blocklist
+127.0.0.1 localhost
+0.0.0.0 ads.example.com tracker.example.com # trailing comment
+:: Ads.Example.NET.
+plain.example.org
+not a hosts line`,
+			block:   []string{"ads.example.com", "tracker.example.com", "ads.example.net", "plain.example.org"},
+			skipped: []string{"not a hosts line"},
+		},
+		{
+			format: ListFormatPihole,
+			list: `# Pi-hole adlist
+0.0.0.0 ads.example.com
+||tracker.example.com^
+@@||cdn.example.com^
+(^|\.)doubleclick\.net$
+^ads[0-9]+\.example\.org$;querytype=A`,
+			block:   []string{"ads.example.com", "tracker.example.com"},
+			allow:   []string{"cdn.example.com"},
+			regex:   []string{`(^|\.)doubleclick\.net$`},
+			skipped: []string{`^ads[0-9]+\.example\.org$;querytype=A`},
+		},
+		{
+			format: ListFormatAdGuard,
+			list: `[Adblock Plus 2.0]
+! AdGuard filter
+||ads.example.com^
+||*.tracker.example.com^$important
+||ads*.example.net^
+|exact.example.org^
+@@||good.example.com^
+/^metrics[0-9]*\./
+example.com##.banner
+||video.example.com^$client=192.168.1.2
+@@/allowed/
+0.0.0.0 hosts.example.com`,
+			block: []string{"ads.example.com", "*.tracker.example.com", "hosts.example.com"},
+			allow: []string{"good.example.com"},
+			regex: []string{`^(.*\.)?ads.*\.example\.net$`, `^exact\.example\.org$`, `^metrics[0-9]*\.`},
+			skipped: []string{
+				"example.com##.banner",
+				"||video.example.com^$client=192.168.1.2",
+				"@@/allowed/",
+			},
+		},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.format, func(t *testing.T) {
+			list, err := NewParser().ImportList(strings.NewReader(tt.list), tt.format, false)
+			if err != nil {
+				t.Fatalf("ImportList failed: %v", err)
+			}
+			if !reflect.DeepEqual(list.BlockDomains, tt.block) {
+				t.Errorf("block domains = %q, want %q", list.BlockDomains, tt.block)
+			}
+			if !reflect.DeepEqual(list.AllowDomains, tt.allow) {
+				t.Errorf("allow domains = %q, want %q", list.AllowDomains, tt.allow)
+			}
+			if !reflect.DeepEqual(list.BlockRegex, tt.regex) {
+				t.Errorf("regexes = %q, want %q", list.BlockRegex, tt.regex)
+			}
+			if !reflect.DeepEqual(list.Skipped, tt.skipped) {
+				t.Errorf("skipped = %q, want %q", list.Skipped, tt.skipped)
+			}
+		})
+	}
+}
+
+func TestImportListAllow(t *testing.T) {
+	list, err := NewParser().ImportList(strings.NewReader("cdn.example.com\n(^|\\.)example\\.net$\n"), ListFormatPihole, true)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(list.AllowDomains, []string{"cdn.example.com"}) || len(list.BlockDomains) != 0 {
+		t.Errorf("allow domains = %q, block domains = %q", list.AllowDomains, list.BlockDomains)
+	}
+	if len(list.Skipped) != 1 {
+		t.Errorf("expected the regex to be skipped, skipped %q", list.Skipped)
+	}
+
+	if _, err := NewParser().ImportList(strings.NewReader(""), "dnsmasq", false); err == nil {
+		t.Error("expected an unknown format to be rejected")
+	}
+}
+
+func TestExportList(t *testing.T) {
+	r := &config.Rules{
+		Version:              "2024.01.15",
+		BlockSources:         []string{"https://lists.example.com/ads.txt"},
+		BlockDomains:         []string{"ads.example.com", "||tracker.example.com^", "*.metrics.example.com"},
+		SecurityBlockDomains: []string{"c2.example.net"},
+		AllowDomains:         []string{"cdn.example.com"},
+		BlockRegex:           []string{`^ads[0-9]+\.`},
+	}
+
+	tests := []struct {
+		format  string
+		allow   bool
+		want    string
+		skipped int
+	}{
+		{ListFormatHosts, false, `# Exported from DNShield rules version 2024.01.15
+0.0.0.0 ads.example.com
+0.0.0.0 tracker.example.com
+0.0.0.0 c2.example.net
+`, 4},
+		{ListFormatPihole, false, `# Exported from DNShield rules version 2024.01.15
+||ads.example.com^
+||tracker.example.com^
+||c2.example.net^
+`, 4},
+		{ListFormatPihole, true, `# Exported from DNShield rules version 2024.01.15
+@@||cdn.example.com^
+`, 0},
+		{ListFormatAdGuard, false, `! Exported from DNShield rules version 2024.01.15
+||ads.example.com^
+||tracker.example.com^
+||*.metrics.example.com^
+||c2.example.net^
+@@||cdn.example.com^
+/^ads[0-9]+\./
+`, 1},
+	}
+
+	for _, tt := range tests {
+		var buf bytes.Buffer
+		skipped, err := ExportList(&buf, r, tt.format, tt.allow)
+		if err != nil {
+			t.Fatalf("%s: ExportList failed: %v", tt.format, err)
+		}
+		if buf.String() != tt.want {
+			t.Errorf("%s (allow %v) wrote:\n%s\nwant:\n%s", tt.format, tt.allow, buf.String(), tt.want)
+		}
+		if len(skipped) != tt.skipped {
+			t.Errorf("%s (allow %v) skipped %q, want %d entries", tt.format, tt.allow, skipped, tt.skipped)
+		}
+	}
+
+	if _, err := ExportList(&bytes.Buffer{}, r, ListFormatHosts, true); err == nil {
+		t.Error("expected hosts allowlists to be rejected")
+	}
+}
@@ -0,0 +1,105 @@
+package rules
+
+import (
+	"os"
+	"strings"
+
+	"dnshield/internal/config"
+
+	"github.com/sirupsen/logrus"
+)
+
+// Device identifiers, in the order given by s3.deviceIdentity
+const (
+	IdentitySerial      = "serial"      // Hardware serial number
+	IdentityMDM         = "mdm"         // Device ID delivered by MDM in a managed preference
+	IdentityConsoleUser = "consoleUser" // User logged in at the console
+	IdentityHostname    = "hostname"    // Hostname, which users can change
+)
+
+// DefaultDeviceIdentity is the identifier order used when
+// s3.deviceIdentity is not set
+var DefaultDeviceIdentity = []string{IdentitySerial, IdentityMDM, IdentityHostname}
+
+// MDMPreferencesDomain is the managed preferences domain an MDM profile
+// sets MDMDeviceIDKey in, for example to $UDID or $SERIALNUMBER
+const (
+	MDMPreferencesDomain = "/Library/Managed Preferences/com.dnshield.agent"
+	MDMDeviceIDKey       = "DeviceID"
+)
+
+// identitySources read each kind of identifier; tests replace them
+var identitySources = map[string]func() (string, error){
+	IdentitySerial:      hardwareSerial,
+	IdentityMDM:         mdmDeviceID,
+	IdentityConsoleUser: consoleUser,
+	IdentityHostname:    os.Hostname,
+}
+
+// DeviceID is one identifier of this device
+type DeviceID struct {
+	Kind  string
+	Value string
+}
+
+func (id DeviceID) String() string {
+	return id.Kind + " " + id.Value
+}
+
+// ResolveDeviceIdentity returns the identifiers of this device available
+// on this platform, in order. Identifiers that can't be read are skipped.
+func ResolveDeviceIdentity(order []string) []DeviceID {
+	if len(order) == 0 {
+		order = DefaultDeviceIdentity
+	}
+	var ids []DeviceID
+	for _, kind := range order {
+		source, ok := identitySources[kind]
+		if !ok {
+			continue
+		}
+		value, err := source()
+		value = strings.TrimSpace(value)
+		if err != nil || value == "" {
+			logrus.WithError(err).WithField("identity", kind).Debug("Device identifier unavailable")
+			continue
+		}
+		ids = append(ids, DeviceID{Kind: kind, Value: value})
+	}
+	return ids
+}
+
+// MatchDevice returns the user the first of ids is mapped to, and that
+// identifier. Serial numbers, MDM IDs and hostnames are looked up in each
+// user's devices; a console user matches the user with that name or the
+// local part of that email address.
+func MatchDevice(mapping *config.DeviceMapping, ids []DeviceID) (string, DeviceID, bool) {
+	for _, id := range ids {
+		for user, devices := range mapping.Users {
+			if id.Kind == IdentityConsoleUser {
+				local, _, _ := strings.Cut(user, "@")
+				if strings.EqualFold(user, id.Value) || strings.EqualFold(local, id.Value) {
+					return user, id, true
+				}
+				continue
+			}
+			for _, device := range devices.Devices {
+				if strings.EqualFold(device, id.Value) {
+					return user, id, true
+				}
+			}
+		}
+	}
+	return "", DeviceID{}, false
+}
+
+// stableDeviceID returns the first identifier of the device itself rather
+// than of its user, or the hostname
+func stableDeviceID(ids []DeviceID) string {
+	for _, id := range ids {
+		if id.Kind != IdentityConsoleUser {
+			return id.Value
+		}
+	}
+	return GetDeviceName()
+}
@@ -0,0 +1,62 @@
+//go:build darwin
+// +build darwin
+
+package rules
+
+import (
+	"bufio"
+	"bytes"
+	"fmt"
+	"os/exec"
+	"strings"
+)
+
+// hardwareSerial reads IOPlatformSerialNumber from the IOKit registry
+func hardwareSerial() (string, error) {
+	output, err := exec.Command("ioreg", "-rd1", "-c", "IOPlatformExpertDevice").Output()
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), "=")
+		if ok && strings.Trim(strings.TrimSpace(key), `"`) == "IOPlatformSerialNumber" {
+			return strings.Trim(strings.TrimSpace(value), `"`), nil
+		}
+	}
+	return "", fmt.Errorf("IOPlatformSerialNumber not found")
+}
+
+// mdmDeviceID reads the device ID an MDM profile set in the managed
+// preferences
+func mdmDeviceID() (string, error) {
+	output, err := exec.Command("defaults", "read", MDMPreferencesDomain, MDMDeviceIDKey).Output()
+	if err != nil {
+		return "", err
+	}
+	return string(output), nil
+}
+
+// consoleUser reads the user logged in at the console from the
+// State:/Users/ConsoleUser key of the SCDynamicStore. The login window
+// reports itself as loginwindow when nobody is logged in.
+func consoleUser() (string, error) {
+	cmd := exec.Command("scutil")
+	cmd.Stdin = strings.NewReader("show State:/Users/ConsoleUser\n")
+	output, err := cmd.Output()
+	if err != nil {
+		return "", err
+	}
+	scanner := bufio.NewScanner(bytes.NewReader(output))
+	for scanner.Scan() {
+		key, value, ok := strings.Cut(scanner.Text(), ":")
+		if !ok || strings.TrimSpace(key) != "Name" {
+			continue
+		}
+		if name := strings.TrimSpace(value); name != "loginwindow" {
+			return name, nil
+		}
+		break
+	}
+	return "", nil
+}
@@ -0,0 +1,20 @@
+//go:build !darwin
+// +build !darwin
+
+package rules
+
+import "fmt"
+
+var errIdentityUnsupported = fmt.Errorf("device identifier is only available on macOS")
+
+func hardwareSerial() (string, error) {
+	return "", errIdentityUnsupported
+}
+
+func mdmDeviceID() (string, error) {
+	return "", errIdentityUnsupported
+}
+
+func consoleUser() (string, error) {
+	return "", errIdentityUnsupported
+}
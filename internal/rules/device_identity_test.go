@@ -0,0 +1,50 @@
+package rules
+
+import (
+	"errors"
+	"testing"
+
+	"dnshield/internal/config"
+)
+
+func TestDeviceIdentity(t *testing.T) {
+	saved := identitySources
+	defer func() { identitySources = saved }()
+	identitySources = map[string]func() (string, error){
+		IdentitySerial:      func() (string, error) { return "C02XK1ABJG5J\n", nil },
+		IdentityMDM:         func() (string, error) { return "", errors.New("no managed preferences") },
+		IdentityConsoleUser: func() (string, error) { return "jdoe", nil },
+		IdentityHostname:    func() (string, error) { return "renamed-mac", nil },
+	}
+
+	ids := ResolveDeviceIdentity(nil)
+	want := []DeviceID{{IdentitySerial, "C02XK1ABJG5J"}, {IdentityHostname, "renamed-mac"}}
+	if len(ids) != len(want) || ids[0] != want[0] || ids[1] != want[1] {
+		t.Fatalf("ResolveDeviceIdentity(nil) = %v, want %v", ids, want)
+	}
+	if got := stableDeviceID(ids); got != "C02XK1ABJG5J" {
+		t.Errorf("stableDeviceID = %q, want the serial", got)
+	}
+
+	mapping := &config.DeviceMapping{Users: map[string]config.UserDevices{
+		"alice@example.com": {Devices: []string{"alice-mbp", "c02xk1abjg5j"}},
+		"jdoe@example.com":  {Devices: []string{"renamed-mac"}},
+	}}
+	user, id, ok := MatchDevice(mapping, ids)
+	if !ok || user != "alice@example.com" || id.Kind != IdentitySerial {
+		t.Errorf("MatchDevice = %q, %v, %v; want alice by serial", user, id, ok)
+	}
+
+	ids = ResolveDeviceIdentity([]string{IdentityConsoleUser, IdentitySerial})
+	user, id, ok = MatchDevice(mapping, ids)
+	if !ok || user != "jdoe@example.com" || id.Kind != IdentityConsoleUser {
+		t.Errorf("MatchDevice = %q, %v, %v; want jdoe by console user", user, id, ok)
+	}
+	if got := stableDeviceID(ids); got != "C02XK1ABJG5J" {
+		t.Errorf("stableDeviceID = %q, want the serial rather than the user", got)
+	}
+
+	if _, _, ok := MatchDevice(mapping, []DeviceID{{IdentityHostname, "unknown"}}); ok {
+		t.Error("MatchDevice matched an unmapped hostname")
+	}
+}
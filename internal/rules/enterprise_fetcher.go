@@ -2,6 +2,9 @@ package rules
 
 import (
 	"context"
+	"crypto/ed25519"
+	"crypto/sha256"
+	"encoding/binary"
 	"fmt"
 	"os"
 	"path"
@@ -9,72 +12,91 @@ import (
 	"sync"
 	"time"
 
+	"dnshield/internal/audit"
 	"dnshield/internal/config"
 	"dnshield/internal/utils"
 
-	"github.com/aws/aws-sdk-go-v2/aws"
-	awsconfig "github.com/aws/aws-sdk-go-v2/config"
-	"github.com/aws/aws-sdk-go-v2/credentials"
-	"github.com/aws/aws-sdk-go-v2/service/s3"
 	"github.com/sirupsen/logrus"
 	"gopkg.in/yaml.v3"
 )
 
-// EnterpriseFetcher fetches rules from S3 with multi-file support and ETag caching
+// EnterpriseFetcher fetches rules from a rules store with multi-file
+// support and ETag caching
 type EnterpriseFetcher struct {
-	s3Client  *s3.Client
-	bucket    string
+	store     RuleStore
 	paths     config.S3Paths
 	etagCache map[string]string // Track ETags to avoid unnecessary downloads
 	mu        sync.RWMutex
+
+	// Keys files from the store must be signed with; nil disables
+	// verification. serials holds the highest serial accepted per key, so
+	// an older signed version can't be replayed.
+	signingKeys []ed25519.PublicKey
+	serials     map[string]uint64
+
+	// Rules files by key: the last version parsed, reused while the ETag
+	// is unchanged, and the last version this device applied, kept while a
+	// newer one is staged to other devices
+	parsed  map[string]*config.Rules
+	applied map[string]*config.Rules
+
+	// Device mapping and user groups content by key, reused while the
+	// ETag is unchanged
+	verified map[string][]byte
+
+	// Custom block page and the ETags of its files
+	blockPage      *BlockPageBundle
+	blockPageETags map[string]string
+
+	// Order of the identifiers the device is looked up by, and the stable
+	// one staged rollouts hash, resolved on each fetch
+	identity []string
+	deviceID string
+
+	// Resolves the user from an identity provider instead of the device
+	// mapping when set
+	userResolver UserResolver
 }
 
-// NewEnterpriseFetcher creates a new enterprise rule fetcher
-func NewEnterpriseFetcher(cfg *config.S3Config) (*EnterpriseFetcher, error) {
-	// Configure AWS SDK with timeout for faster failure on non-EC2 systems
-	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
-	defer cancel()
+// UserResolver returns the verified user of this device and the group
+// their identity provider assigns them, or "" to look the group up in
+// the user groups file
+type UserResolver func(ctx context.Context) (user, group string, err error)
+
+// Where EnterpriseRules.UserEmail came from
+const (
+	UserSourceDeviceMapping = "device_mapping"
+	UserSourceOIDC          = "oidc"
+)
 
-	// Get credentials securely
-	creds, err := config.GetAWSCredentials(cfg)
+// SetUserResolver resolves the user with resolver before falling back to
+// the device mapping
+func (f *EnterpriseFetcher) SetUserResolver(resolver UserResolver) {
+	f.userResolver = resolver
+}
+
+// NewEnterpriseFetcher creates a new enterprise rule fetcher for the rules
+// store cfg points at
+func NewEnterpriseFetcher(cfg *config.S3Config) (*EnterpriseFetcher, error) {
+	signingKeys, err := ParseSigningKeys(cfg.SigningKeys)
 	if err != nil {
-		return nil, fmt.Errorf("failed to get AWS credentials: %v", err)
-	}
-
-	var awsCfg aws.Config
-
-	// Configure based on credential source
-	switch creds.Source {
-	case config.CredentialSourceEnvironment, config.CredentialSourceConfig:
-		// Use explicit credentials (from env or config)
-		awsCfg, err = awsconfig.LoadDefaultConfig(ctx,
-			awsconfig.WithRegion(cfg.Region),
-			awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
-				creds.AccessKeyID,
-				creds.SecretAccessKey,
-				"",
-			)),
-		)
-	default:
-		// Use default credential chain (IAM role, etc.)
-		// Use context timeout to avoid long waits on non-EC2 systems
-		awsCfg, err = awsconfig.LoadDefaultConfig(ctx,
-			awsconfig.WithRegion(cfg.Region),
-		)
+		return nil, err
 	}
-
+	store, err := NewRuleStore(cfg)
 	if err != nil {
-		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+		return nil, err
 	}
 
-	// Log credential source for transparency
-	logrus.Infof("Using AWS credentials from: %s", creds.Source)
-
 	return &EnterpriseFetcher{
-		s3Client:  s3.NewFromConfig(awsCfg),
-		bucket:    cfg.Bucket,
-		paths:     cfg.Paths,
-		etagCache: make(map[string]string),
+		store:       store,
+		paths:       cfg.Paths,
+		etagCache:   make(map[string]string),
+		signingKeys: signingKeys,
+		serials:     make(map[string]uint64),
+		parsed:      make(map[string]*config.Rules),
+		applied:     make(map[string]*config.Rules),
+		verified:    make(map[string][]byte),
+		identity:    cfg.DeviceIdentity,
 	}, nil
 }
 
@@ -86,65 +108,46 @@ type FetchResult struct {
 	Error   error
 }
 
-// fetchFile fetches a single file from S3, checking ETag for changes
+// fetchFile fetches a single file from the store, checking ETag for changes
 func (f *EnterpriseFetcher) fetchFile(ctx context.Context, key string) FetchResult {
 	// Check if we have a cached ETag
 	f.mu.RLock()
 	cachedETag := f.etagCache[key]
 	f.mu.RUnlock()
 
-	// First, do a HEAD request to check ETag
-	headResp, err := f.s3Client.HeadObject(ctx, &s3.HeadObjectInput{
-		Bucket: aws.String(f.bucket),
-		Key:    aws.String(key),
-	})
-
-	if err != nil {
-		// File might not exist, which is OK for optional files
-		return FetchResult{Key: key, Error: err}
-	}
-
-	// If ETag matches cached version, skip download
-	currentETag := aws.ToString(headResp.ETag)
-	if cachedETag != "" && cachedETag == currentETag {
+	content, etag, err := f.store.Fetch(ctx, key, cachedETag)
+	if err == ErrNotModified {
 		logrus.WithField("key", key).Debug("File unchanged (ETag match), skipping download")
-		return FetchResult{Key: key, ETag: currentETag, Content: nil}
+		return FetchResult{Key: key, ETag: etag, Content: nil}
 	}
-
-	// Download the file
-	resp, err := f.s3Client.GetObject(ctx, &s3.GetObjectInput{
-		Bucket: aws.String(f.bucket),
-		Key:    aws.String(key),
-	})
-	if err != nil {
-		return FetchResult{Key: key, Error: err}
-	}
-	defer resp.Body.Close()
-
-	// Check content length
-	contentLength := aws.ToInt64(resp.ContentLength)
-	if contentLength > utils.MaxS3ObjectSize {
-		return FetchResult{Key: key, Error: fmt.Errorf("S3 object exceeds maximum size of %d bytes", utils.MaxS3ObjectSize)}
-	}
-	
-	// Read content with size limit
-	content, err := utils.ReadAllLimited(resp.Body, utils.MaxS3ObjectSize)
 	if err != nil {
+		// File might not exist, which is OK for optional files
 		return FetchResult{Key: key, Error: err}
 	}
 
 	// Update ETag cache
 	f.mu.Lock()
-	f.etagCache[key] = currentETag
+	f.etagCache[key] = etag
 	f.mu.Unlock()
 
 	return FetchResult{
 		Key:     key,
 		Content: content,
-		ETag:    currentETag,
+		ETag:    etag,
 	}
 }
 
+// FetchObject downloads key from the rules store, ignoring the ETag cache
+func (f *EnterpriseFetcher) FetchObject(ctx context.Context, key string) ([]byte, error) {
+	content, _, err := f.store.Fetch(ctx, key, "")
+	return content, err
+}
+
+// PutObject writes content to key in the rules store
+func (f *EnterpriseFetcher) PutObject(ctx context.Context, key string, content []byte, contentType string) error {
+	return f.store.Put(ctx, key, content, contentType)
+}
+
 // GetDeviceName returns the device name for this machine
 func GetDeviceName() string {
 	// Try to get the ComputerName (user-friendly name)
@@ -159,61 +162,103 @@ func GetDeviceName() string {
 	return name
 }
 
-// FetchEnterpriseRules fetches all rules for the current device
-func (f *EnterpriseFetcher) FetchEnterpriseRules() (*EnterpriseRules, error) {
-	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
-	defer cancel()
-
-	result := &EnterpriseRules{
-		DeviceName: GetDeviceName(),
-		FetchTime:  time.Now(),
-	}
+// resolveIdentity reads the identifiers of this device and keeps the
+// stable one for staged rollouts
+func (f *EnterpriseFetcher) resolveIdentity() []DeviceID {
+	ids := ResolveDeviceIdentity(f.identity)
+	f.mu.Lock()
+	f.deviceID = stableDeviceID(ids)
+	f.mu.Unlock()
+	return ids
+}
 
-	// Step 1: Fetch device mapping
+// mapDevice sets the user of result from the device mapping entry of the
+// first of ids that is mapped
+func (f *EnterpriseFetcher) mapDevice(ctx context.Context, result *EnterpriseRules, ids []DeviceID) error {
 	deviceMappingResult := f.fetchFile(ctx, f.paths.DeviceMapping)
 	if deviceMappingResult.Error != nil {
-		return nil, fmt.Errorf("failed to fetch device mapping: %v", deviceMappingResult.Error)
+		return fmt.Errorf("failed to fetch device mapping: %v", deviceMappingResult.Error)
 	}
 
-	if deviceMappingResult.Content != nil {
+	content, err := f.verifiedContent(ctx, deviceMappingResult, "Device mapping")
+	if err != nil {
+		return err
+	}
+
+	if content != nil {
 		// Validate YAML before parsing
-		if err := utils.SafeYAMLUnmarshal(deviceMappingResult.Content, nil, utils.MaxRulesFileSize); err != nil {
-			return nil, fmt.Errorf("device mapping YAML validation failed: %v", err)
+		if err := utils.SafeYAMLUnmarshal(content, nil, utils.MaxRulesFileSize); err != nil {
+			return fmt.Errorf("device mapping YAML validation failed: %v", err)
 		}
 		
 		var deviceMapping config.DeviceMapping
-		if err := yaml.Unmarshal(deviceMappingResult.Content, &deviceMapping); err != nil {
-			return nil, fmt.Errorf("failed to parse device mapping: %v", err)
+		if err := yaml.Unmarshal(content, &deviceMapping); err != nil {
+			return fmt.Errorf("failed to parse device mapping: %v", err)
 		}
 
-		// Find user for this device
-		for user, devices := range deviceMapping.Users {
-			for _, device := range devices.Devices {
-				if device == result.DeviceName {
-					result.UserEmail = user
-					break
-				}
-			}
-			if result.UserEmail != "" {
-				break
-			}
+		// Find user for this device by the first identifier mapped
+		if user, id, ok := MatchDevice(&deviceMapping, ids); ok {
+			result.UserEmail = user
+			result.DeviceID = id.String()
+			result.UserSource = UserSourceDeviceMapping
+		}
+	}
+	return nil
+}
+
+// FetchEnterpriseRules fetches all rules for the current device
+func (f *EnterpriseFetcher) FetchEnterpriseRules() (*EnterpriseRules, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	ids := f.resolveIdentity()
+	result := &EnterpriseRules{
+		DeviceName: GetDeviceName(),
+		FetchTime:  time.Now(),
+	}
+
+	// Step 1: Resolve the user from the identity provider, or else find
+	// this device in the device mapping
+	if f.userResolver != nil {
+		if user, group, err := f.userResolver(ctx); err != nil {
+			logrus.WithError(err).Warn("Failed to resolve user from identity provider, using device mapping")
+		} else {
+			result.UserEmail = user
+			result.GroupName = group
+			result.UserSource = UserSourceOIDC
+		}
+	}
+	if result.UserEmail == "" {
+		if err := f.mapDevice(ctx, result, ids); err != nil {
+			return nil, err
 		}
 	}
 
 	if result.UserEmail == "" {
-		logrus.WithField("device", result.DeviceName).Warn("Device not found in mapping, applying base rules only")
+		logrus.WithFields(logrus.Fields{
+			"device":      result.DeviceName,
+			"identifiers": ids,
+		}).Warn("Device not found in mapping, applying base rules only")
 	}
 
-	// Step 2: Fetch user groups (if we have a user)
-	if result.UserEmail != "" {
+	// Step 2: Fetch user groups (if we have a user the identity provider
+	// didn't assign a group)
+	if result.UserEmail != "" && result.GroupName == "" {
+		var content []byte
 		userGroupsResult := f.fetchFile(ctx, f.paths.UserGroups)
-		if userGroupsResult.Error == nil && userGroupsResult.Content != nil {
+		if userGroupsResult.Error == nil {
+			var err error
+			if content, err = f.verifiedContent(ctx, userGroupsResult, "User groups"); err != nil {
+				return nil, err
+			}
+		}
+		if content != nil {
 			// Validate YAML before parsing
-			if err := utils.SafeYAMLUnmarshal(userGroupsResult.Content, nil, utils.MaxRulesFileSize); err != nil {
+			if err := utils.SafeYAMLUnmarshal(content, nil, utils.MaxRulesFileSize); err != nil {
 				logrus.WithError(err).Warn("User groups YAML validation failed")
 			} else {
 				var userGroups config.UserGroups
-				if err := yaml.Unmarshal(userGroupsResult.Content, &userGroups); err == nil {
+				if err := yaml.Unmarshal(content, &userGroups); err == nil {
 				// Check direct override first
 				if group, ok := userGroups.UserOverrides[result.UserEmail]; ok {
 					result.GroupName = group
@@ -238,65 +283,313 @@ func (f *EnterpriseFetcher) FetchEnterpriseRules() (*EnterpriseRules, error) {
 	}
 
 	logrus.WithFields(logrus.Fields{
-		"device": result.DeviceName,
-		"user":   result.UserEmail,
+		"device":    result.DeviceName,
+		"device_id": result.DeviceID,
+		"user":      result.UserEmail,
+		"source":    result.UserSource,
 		"group":  result.GroupName,
 	}).Info("Resolved device identity")
 
 	// Step 3: Fetch base rules (everyone gets these)
-	baseResult := f.fetchFile(ctx, f.paths.Base)
-	if baseResult.Error == nil && baseResult.Content != nil {
-		// Validate YAML before parsing
-		if err := utils.SafeYAMLUnmarshal(baseResult.Content, nil, utils.MaxRulesFileSize); err != nil {
-			logrus.WithError(err).Warn("Base rules YAML validation failed")
-		} else {
-			var baseRules config.Rules
-			if err := yaml.Unmarshal(baseResult.Content, &baseRules); err == nil {
-			baseRules.Normalize()
-				result.BaseRules = &baseRules
-			}
-		}
-	}
+	result.BaseRules = f.fetchRules(ctx, f.paths.Base, "Base")
 
 	// Step 4: Fetch group rules (if applicable)
 	if result.GroupName != "" {
-		groupKey := path.Join(f.paths.GroupsDir, result.GroupName+".yaml")
-		groupResult := f.fetchFile(ctx, groupKey)
-		if groupResult.Error == nil && groupResult.Content != nil {
-			// Validate YAML before parsing
-			if err := utils.SafeYAMLUnmarshal(groupResult.Content, nil, utils.MaxRulesFileSize); err != nil {
-				logrus.WithError(err).Warn("Group rules YAML validation failed")
-			} else {
-				var groupRules config.Rules
-				if err := yaml.Unmarshal(groupResult.Content, &groupRules); err == nil {
-				groupRules.Normalize()
-					result.GroupRules = &groupRules
-				}
-			}
-		}
+		result.GroupRules = f.fetchRules(ctx, f.groupKey(result.GroupName), "Group")
 	}
 
 	// Step 5: Fetch user overrides (if applicable)
 	if result.UserEmail != "" {
-		overrideKey := path.Join(f.paths.UserOverridesDir, result.UserEmail+".yaml")
-		overrideResult := f.fetchFile(ctx, overrideKey)
-		if overrideResult.Error == nil && overrideResult.Content != nil {
-			// Validate YAML before parsing
-			if err := utils.SafeYAMLUnmarshal(overrideResult.Content, nil, utils.MaxRulesFileSize); err != nil {
-				logrus.WithError(err).Warn("User override rules YAML validation failed")
-			} else {
-				var userRules config.Rules
-				if err := yaml.Unmarshal(overrideResult.Content, &userRules); err == nil {
-				userRules.Normalize()
-					result.UserRules = &userRules
-				}
-			}
+		result.UserRules = f.fetchRules(ctx, f.userKey(result.UserEmail), "User override")
+	}
+
+	// Step 6: Fetch the category registry (if any rules use categories)
+	if len(result.blockCategories()) > 0 {
+		categories, err := f.fetchCategories(ctx)
+		if err != nil {
+			return nil, err
 		}
+		result.Categories = categories
+	}
+
+	result.Serials = f.acceptedSerials()
+	return result, nil
+}
+
+// FetchGroupRules fetches the base rules and the rules of group, for
+// clients that are assigned a group by address rather than by user
+func (f *EnterpriseFetcher) FetchGroupRules(group string) (*EnterpriseRules, error) {
+	ctx, cancel := context.WithTimeout(context.Background(), 60*time.Second)
+	defer cancel()
+
+	f.resolveIdentity()
+	groupRules := f.fetchRules(ctx, f.groupKey(group), "Group")
+	if groupRules == nil {
+		return nil, fmt.Errorf("failed to fetch rules for group %s", group)
 	}
 
+	result := &EnterpriseRules{
+		DeviceName: GetDeviceName(),
+		GroupName:  group,
+		BaseRules:  f.fetchRules(ctx, f.paths.Base, "Base"),
+		GroupRules: groupRules,
+		FetchTime:  time.Now(),
+	}
+	if len(result.blockCategories()) > 0 {
+		categories, err := f.fetchCategories(ctx)
+		if err != nil {
+			return nil, err
+		}
+		result.Categories = categories
+	}
+	result.Serials = f.acceptedSerials()
 	return result, nil
 }
 
+// fetchCategories fetches the category registry, returning nil if it is
+// missing or invalid, and an error if its signature is rejected. It
+// bypasses the ETag cache since the registry is only fetched alongside
+// rules that need it.
+func (f *EnterpriseFetcher) fetchCategories(ctx context.Context) (map[string]config.CategoryConfig, error) {
+	content, err := f.FetchObject(ctx, f.paths.Categories)
+	if err != nil {
+		logrus.WithError(err).WithField("key", f.paths.Categories).Warn("Failed to fetch category registry")
+		return nil, nil
+	}
+	if err := f.verifyRules(ctx, f.paths.Categories, content); err != nil {
+		f.reject(f.paths.Categories, "Category registry", "", err)
+		return nil, fmt.Errorf("category registry rejected: %v", err)
+	}
+
+	// Validate YAML before parsing
+	if err := utils.SafeYAMLUnmarshal(content, nil, utils.MaxRulesFileSize); err != nil {
+		logrus.WithError(err).Warn("Category registry YAML validation failed")
+		return nil, nil
+	}
+	var registry config.CategoryRegistry
+	if err := yaml.Unmarshal(content, &registry); err != nil {
+		logrus.WithError(err).Warn("Failed to parse category registry")
+		return nil, nil
+	}
+
+	categories := make(map[string]config.CategoryConfig, len(registry.Categories))
+	for name, entry := range registry.Categories {
+		categories[strings.ToLower(strings.TrimSpace(name))] = entry
+	}
+	return categories, nil
+}
+
+// RememberApplied records the rules files of er as the versions this
+// device applied, so a staged rollout that excludes the device keeps them.
+// It is used to carry them over from the rules cache after a restart.
+func (f *EnterpriseFetcher) RememberApplied(er *EnterpriseRules) {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if er.BaseRules != nil {
+		f.applied[f.paths.Base] = er.BaseRules
+	}
+	if er.GroupRules != nil && er.GroupName != "" {
+		f.applied[f.groupKey(er.GroupName)] = er.GroupRules
+	}
+	if er.UserRules != nil && er.UserEmail != "" {
+		f.applied[f.userKey(er.UserEmail)] = er.UserRules
+	}
+	for key, serial := range er.Serials {
+		if serial > f.serials[key] {
+			f.serials[key] = serial
+		}
+	}
+}
+
+// acceptedSerials returns a copy of the highest serial accepted per key
+func (f *EnterpriseFetcher) acceptedSerials() map[string]uint64 {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	if len(f.serials) == 0 {
+		return nil
+	}
+	serials := make(map[string]uint64, len(f.serials))
+	for key, serial := range f.serials {
+		serials[key] = serial
+	}
+	return serials
+}
+
+// ETags returns the ETags of the rules files er was fetched from
+func (f *EnterpriseFetcher) ETags(er *EnterpriseRules) map[string]string {
+	f.mu.RLock()
+	defer f.mu.RUnlock()
+
+	keys := []string{f.paths.Base}
+	if er.GroupName != "" {
+		keys = append(keys, f.groupKey(er.GroupName))
+	}
+	if er.UserEmail != "" {
+		keys = append(keys, f.userKey(er.UserEmail))
+	}
+	etags := make(map[string]string)
+	for _, key := range keys {
+		if etag := f.etagCache[key]; etag != "" {
+			etags[key] = etag
+		}
+	}
+	return etags
+}
+
+// fetchRules fetches and parses one rules file, returning nil if it is
+// missing or invalid. A version staged to other devices is replaced by
+// the one this device applied before, as is one without a valid
+// signature. kind names the file in warnings.
+func (f *EnterpriseFetcher) fetchRules(ctx context.Context, key, kind string) *config.Rules {
+	fileResult := f.fetchFile(ctx, key)
+	if fileResult.Error != nil {
+		return nil
+	}
+
+	var rules *config.Rules
+	if fileResult.Content == nil {
+		// Unchanged since the last fetch
+		f.mu.RLock()
+		rules = f.parsed[key]
+		f.mu.RUnlock()
+		if rules == nil {
+			return nil
+		}
+	} else {
+		if err := f.verifyRules(ctx, key, fileResult.Content); err != nil {
+			f.reject(key, kind+" rules", fileResult.ETag, err)
+			f.mu.RLock()
+			previous := f.applied[key]
+			f.mu.RUnlock()
+			return previous
+		}
+
+		// Validate YAML before parsing
+		if err := utils.SafeYAMLUnmarshal(fileResult.Content, nil, utils.MaxRulesFileSize); err != nil {
+			logrus.WithError(err).Warnf("%s rules YAML validation failed", kind)
+			return nil
+		}
+		rules = &config.Rules{}
+		if err := yaml.Unmarshal(fileResult.Content, rules); err != nil {
+			return nil
+		}
+		rules.Normalize()
+		f.mu.Lock()
+		f.parsed[key] = rules
+		f.mu.Unlock()
+	}
+
+	return f.rollOut(key, kind, rules)
+}
+
+// verifyRules checks the detached signature of a file from the store when
+// signing keys are configured, and that it isn't older than the version of
+// key accepted before
+func (f *EnterpriseFetcher) verifyRules(ctx context.Context, key string, content []byte) error {
+	if len(f.signingKeys) == 0 {
+		return nil
+	}
+	signature, err := f.FetchObject(ctx, key+SignatureSuffix)
+	if err != nil {
+		return fmt.Errorf("no signature: %v", err)
+	}
+	serial, err := VerifyRules(key, content, signature, f.signingKeys)
+	if err != nil {
+		return err
+	}
+
+	f.mu.Lock()
+	defer f.mu.Unlock()
+	if serial < f.serials[key] {
+		return fmt.Errorf("serial %d is older than serial %d accepted before", serial, f.serials[key])
+	}
+	f.serials[key] = serial
+	return nil
+}
+
+// verifiedContent returns the content of a fetched device mapping or user
+// groups file once its signature is checked, or the content verified
+// before while it is unchanged. kind names the file in errors.
+func (f *EnterpriseFetcher) verifiedContent(ctx context.Context, result FetchResult, kind string) ([]byte, error) {
+	if result.Content == nil {
+		f.mu.RLock()
+		defer f.mu.RUnlock()
+		return f.verified[result.Key], nil
+	}
+	if err := f.verifyRules(ctx, result.Key, result.Content); err != nil {
+		f.reject(result.Key, kind, result.ETag, err)
+		return nil, fmt.Errorf("%s rejected: %v", strings.ToLower(kind), err)
+	}
+	f.mu.Lock()
+	f.verified[result.Key] = result.Content
+	f.mu.Unlock()
+	return result.Content, nil
+}
+
+// reject logs and audits a file that failed verification, and forgets its
+// ETag so it is checked again once a signature is uploaded. kind names the
+// file in the log.
+func (f *EnterpriseFetcher) reject(key, kind, etag string, err error) {
+	logrus.WithError(err).WithField("key", key).Errorf("%s rejected, keeping the previous version", kind)
+	audit.Log(audit.EventSecurityViolation, "critical", fmt.Sprintf("%s rejected: %v", kind, err), map[string]interface{}{
+		"key":  key,
+		"etag": etag,
+	})
+	f.mu.Lock()
+	delete(f.etagCache, key)
+	f.mu.Unlock()
+}
+
+// rollOut returns rules if this device is included in their rollout,
+// otherwise the version of key it applied before. Devices with no earlier
+// version take rules as they are.
+func (f *EnterpriseFetcher) rollOut(key, kind string, rules *config.Rules) *config.Rules {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	if rules.Rollout != nil {
+		fields := logrus.Fields{"key": key, "percent": rules.Rollout.Percent}
+		deviceID := f.deviceID
+		if deviceID == "" {
+			deviceID = GetDeviceName()
+		}
+		included := InRollout(rules.Rollout, deviceID)
+		if previous := f.applied[key]; !included && previous != nil {
+			if previous != rules {
+				logrus.WithFields(fields).Infof("%s rules staged to other devices, keeping the previous version", kind)
+			}
+			return previous
+		}
+		logrus.WithFields(fields).WithField("included", included).Infof("%s rules staged, applying this version", kind)
+	}
+	f.applied[key] = rules
+	return rules
+}
+
+// InRollout reports whether device is among the share of devices rollout
+// includes. The choice is stable for a given salt, and devices included
+// at a lower percentage stay included as it grows.
+func InRollout(rollout *config.RolloutConfig, device string) bool {
+	if rollout == nil || rollout.Percent >= 100 {
+		return true
+	}
+	if rollout.Percent <= 0 {
+		return false
+	}
+	sum := sha256.Sum256([]byte(rollout.Salt + ":" + device))
+	return binary.BigEndian.Uint64(sum[:8])%100 < uint64(rollout.Percent)
+}
+
+func (f *EnterpriseFetcher) groupKey(group string) string {
+	return path.Join(f.paths.GroupsDir, group+".yaml")
+}
+
+func (f *EnterpriseFetcher) userKey(user string) string {
+	return path.Join(f.paths.UserOverridesDir, user+".yaml")
+}
+
 // matchesWildcard checks if an email matches a wildcard pattern
 func matchesWildcard(email, pattern string) bool {
 	// Simple wildcard matching for patterns like *@domain.com
@@ -309,13 +602,22 @@ func matchesWildcard(email, pattern string) bool {
 
 // EnterpriseRules contains all rules applicable to a device
 type EnterpriseRules struct {
-	DeviceName string
-	UserEmail  string
-	GroupName  string
-	BaseRules  *config.Rules
-	GroupRules *config.Rules
-	UserRules  *config.Rules
-	FetchTime  time.Time
+	DeviceName string        `yaml:"device_name"`
+	DeviceID   string        `yaml:"device_id,omitempty"` // Identifier matched in the device mapping
+	UserEmail  string        `yaml:"user_email,omitempty"`
+	UserSource string        `yaml:"user_source,omitempty"` // device_mapping or oidc
+	GroupName  string        `yaml:"group_name,omitempty"`
+	BaseRules  *config.Rules `yaml:"base_rules,omitempty"`
+	GroupRules *config.Rules `yaml:"group_rules,omitempty"`
+	UserRules  *config.Rules `yaml:"user_rules,omitempty"`
+	FetchTime  time.Time     `yaml:"fetch_time"`
+
+	// Registry entries, fetched when the rules use block_categories
+	Categories map[string]config.CategoryConfig `yaml:"categories,omitempty"`
+
+	// Highest signature serial accepted per file, carried over restarts so
+	// older signed versions stay rejected
+	Serials map[string]uint64 `yaml:"serials,omitempty"`
 }
 
 // IsAllowOnlyMode checks if allow-only mode is enabled for this device
@@ -417,3 +719,292 @@ func (er *EnterpriseRules) GetBlockSources() []string {
 
 	return sources
 }
+
+// blockCategories returns the categories blocked at any rule level
+func (er *EnterpriseRules) blockCategories() []string {
+	seen := make(map[string]bool)
+	var categories []string
+	for _, r := range []*config.Rules{er.BaseRules, er.GroupRules, er.UserRules} {
+		if r == nil {
+			continue
+		}
+		for _, category := range r.BlockCategories {
+			category = strings.ToLower(strings.TrimSpace(category))
+			if category != "" && !seen[category] {
+				seen[category] = true
+				categories = append(categories, category)
+			}
+		}
+	}
+	return categories
+}
+
+// GetCategorySources returns the blocklist URLs of the categories blocked
+// at any rule level, mapped to their category. Categories missing from
+// the registry are logged and skipped.
+func (er *EnterpriseRules) GetCategorySources() map[string]string {
+	sources := make(map[string]string)
+	for _, category := range er.blockCategories() {
+		entry, ok := er.Categories[category]
+		if !ok {
+			logrus.WithField("category", category).Warn("Unknown block category, not in the category registry")
+			continue
+		}
+		for _, source := range entry.Sources {
+			if _, dup := sources[source]; !dup {
+				sources[source] = category
+			}
+		}
+	}
+	return sources
+}
+
+// RuleFiles returns the rules files that list rule, a domain rule or a
+// source URL, as "base", "group <name>" or "user <email>". Sources from
+// the category registry are reported as "category <name>".
+func (er *EnterpriseRules) RuleFiles(rule string) []string {
+	rule = strings.ToLower(strings.TrimSpace(rule))
+	var files []string
+	for _, level := range []struct {
+		name  string
+		rules *config.Rules
+	}{
+		{"base", er.BaseRules},
+		{"group " + er.GroupName, er.GroupRules},
+		{"user " + er.UserEmail, er.UserRules},
+	} {
+		if level.rules == nil {
+			continue
+		}
+		for _, list := range [][]string{
+			level.rules.BlockDomains,
+			level.rules.AllowDomains,
+			level.rules.SecurityBlockDomains,
+			level.rules.BlockSources,
+			level.rules.SecurityBlockSources,
+		} {
+			if containsFold(list, rule) {
+				files = append(files, level.name)
+				break
+			}
+		}
+	}
+	for _, category := range er.blockCategories() {
+		if containsFold(er.Categories[category].Sources, rule) {
+			files = append(files, "category "+category)
+		}
+	}
+	return files
+}
+
+func containsFold(list []string, s string) bool {
+	for _, item := range list {
+		if strings.EqualFold(strings.TrimSpace(item), s) {
+			return true
+		}
+	}
+	return false
+}
+
+// MergeSchedules returns the schedules from all rule levels. A schedule
+// with the same name at a more specific level replaces the broader one.
+func (er *EnterpriseRules) MergeSchedules() []config.ScheduleConfig {
+	var schedules []config.ScheduleConfig
+	index := make(map[string]int)
+
+	for _, r := range []*config.Rules{er.BaseRules, er.GroupRules, er.UserRules} {
+		if r == nil {
+			continue
+		}
+		for _, schedule := range r.Schedules {
+			if i, ok := index[schedule.Name]; ok {
+				schedules[i] = schedule
+				continue
+			}
+			index[schedule.Name] = len(schedules)
+			schedules = append(schedules, schedule)
+		}
+	}
+
+	return schedules
+}
+
+// MergeRegexRules returns the regex block rules from all rule levels
+func (er *EnterpriseRules) MergeRegexRules() []string {
+	seen := make(map[string]bool)
+	var patterns []string
+
+	for _, r := range []*config.Rules{er.BaseRules, er.GroupRules, er.UserRules} {
+		if r == nil {
+			continue
+		}
+		for _, pattern := range r.BlockRegex {
+			if !seen[pattern] {
+				seen[pattern] = true
+				patterns = append(patterns, pattern)
+			}
+		}
+	}
+
+	return patterns
+}
+
+// MergeSecurityRules returns the security-critical block domains and
+// external lists from all rule levels
+func (er *EnterpriseRules) MergeSecurityRules() (domains []string, sources []string) {
+	domainMap := make(map[string]bool)
+	sourceMap := make(map[string]bool)
+
+	for _, r := range []*config.Rules{er.BaseRules, er.GroupRules, er.UserRules} {
+		if r == nil {
+			continue
+		}
+		for _, domain := range r.SecurityBlockDomains {
+			domainMap[strings.ToLower(domain)] = true
+		}
+		for _, source := range r.SecurityBlockSources {
+			sourceMap[source] = true
+		}
+	}
+
+	for domain := range domainMap {
+		domains = append(domains, domain)
+	}
+	for source := range sourceMap {
+		sources = append(sources, source)
+	}
+
+	return domains, sources
+}
+
+// GetAllowlistPrecedence returns the allowlist precedence for this device.
+// Group rules take precedence over base rules; user overrides are ignored so
+// a user can't allowlist their way past the group's security policy.
+func (er *EnterpriseRules) GetAllowlistPrecedence() string {
+	if er.GroupRules != nil && er.GroupRules.AllowlistPrecedence != "" {
+		return er.GroupRules.AllowlistPrecedence
+	}
+
+	if er.BaseRules != nil && er.BaseRules.AllowlistPrecedence != "" {
+		return er.BaseRules.AllowlistPrecedence
+	}
+
+	return ""
+}
+
+// GetBypassPrevention returns the merged bypass prevention settings, or nil
+// if neither the base nor group rules configure them. User overrides are
+// ignored so users can't turn it off for themselves.
+func (er *EnterpriseRules) GetBypassPrevention() *config.BypassPreventionConfig {
+	var merged *config.BypassPreventionConfig
+
+	for _, r := range []*config.Rules{er.BaseRules, er.GroupRules} {
+		if r == nil || r.BypassPrevention == nil {
+			continue
+		}
+		if merged == nil {
+			merged = &config.BypassPreventionConfig{}
+		}
+		if r.BypassPrevention.Enabled != nil {
+			merged.Enabled = r.BypassPrevention.Enabled
+		}
+		merged.Domains = append(merged.Domains, r.BypassPrevention.Domains...)
+		merged.Sources = append(merged.Sources, r.BypassPrevention.Sources...)
+		merged.Exempt = append(merged.Exempt, r.BypassPrevention.Exempt...)
+	}
+
+	return merged
+}
+
+// GetEnforceSafeSearch reports whether SafeSearch is enforced. The group
+// setting overrides the base; user overrides are ignored.
+func (er *EnterpriseRules) GetEnforceSafeSearch() bool {
+	enforce := false
+	for _, r := range []*config.Rules{er.BaseRules, er.GroupRules} {
+		if r != nil && r.EnforceSafeSearch != nil {
+			enforce = *r.EnforceSafeSearch
+		}
+	}
+	return enforce
+}
+
+// GetEnforcement returns the enforcement mode of the rules, or "" to use
+// the device default. The group setting overrides the base; user overrides
+// are ignored so users can't switch themselves to monitoring.
+func (er *EnterpriseRules) GetEnforcement() string {
+	if er.GroupRules != nil && er.GroupRules.Enforcement != "" {
+		return er.GroupRules.Enforcement
+	}
+	if er.BaseRules != nil && er.BaseRules.Enforcement != "" {
+		return er.BaseRules.Enforcement
+	}
+	return ""
+}
+
+// GetBlockPage returns the block page messaging for this device, with group
+// values overriding base values field by field. User overrides are ignored
+// so guidance stays consistent across a group.
+func (er *EnterpriseRules) GetBlockPage() *config.BlockPageConfig {
+	merged := &config.BlockPageConfig{}
+	found := false
+
+	for _, r := range []*config.Rules{er.BaseRules, er.GroupRules} {
+		if r == nil || r.BlockPage == nil {
+			continue
+		}
+		found = true
+		if r.BlockPage.ContactEmail != "" {
+			merged.ContactEmail = r.BlockPage.ContactEmail
+		}
+		if r.BlockPage.ContactURL != "" {
+			merged.ContactURL = r.BlockPage.ContactURL
+		}
+		if r.BlockPage.PolicyURL != "" {
+			merged.PolicyURL = r.BlockPage.PolicyURL
+		}
+		if r.BlockPage.Message != "" {
+			merged.Message = r.BlockPage.Message
+		}
+		for category, message := range r.BlockPage.Categories {
+			if merged.Categories == nil {
+				merged.Categories = make(map[string]string)
+			}
+			merged.Categories[category] = message
+		}
+	}
+
+	if !found {
+		return nil
+	}
+	return merged
+}
+
+// GetNetworkProfiles returns the per-network profiles for this device.
+// Group rules replace the base list when they have one; user overrides are
+// ignored so users can't turn filtering off on networks of their choosing.
+func (er *EnterpriseRules) GetNetworkProfiles() []config.NetworkProfileConfig {
+	if er.GroupRules != nil && len(er.GroupRules.Networks) > 0 {
+		return er.GroupRules.Networks
+	}
+
+	if er.BaseRules != nil {
+		return er.BaseRules.Networks
+	}
+
+	return nil
+}
+
+// GetCAProfile returns the CA profile selected for this device, if any.
+// Group rules take precedence over base rules; user overrides cannot change
+// the CA since trust is managed per legal entity, not per user.
+func (er *EnterpriseRules) GetCAProfile() *config.CAProfileConfig {
+	if er.GroupRules != nil && er.GroupRules.CA != nil && er.GroupRules.CA.Name != "" {
+		return er.GroupRules.CA
+	}
+
+	if er.BaseRules != nil && er.BaseRules.CA != nil && er.BaseRules.CA.Name != "" {
+		return er.BaseRules.CA
+	}
+
+	return nil
+}
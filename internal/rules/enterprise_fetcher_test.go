@@ -0,0 +1,287 @@
+package rules
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"fmt"
+	"os"
+	"reflect"
+	"testing"
+
+	"dnshield/internal/config"
+)
+
+func TestGetBlockPage(t *testing.T) {
+	er := &EnterpriseRules{
+		BaseRules: &config.Rules{BlockPage: &config.BlockPageConfig{
+			ContactEmail: "it@company.com",
+			PolicyURL:    "https://intranet.company.com/aup",
+			Categories:   map[string]string{"security": "Report this to security@company.com."},
+		}},
+		GroupRules: &config.Rules{BlockPage: &config.BlockPageConfig{
+			ContactEmail: "eng-help@company.com",
+			Categories:   map[string]string{"blocklist": "Ask in #eng-help if you need this site."},
+		}},
+		UserRules: &config.Rules{BlockPage: &config.BlockPageConfig{
+			Message: "ignored",
+		}},
+	}
+
+	got := er.GetBlockPage()
+	want := &config.BlockPageConfig{
+		ContactEmail: "eng-help@company.com",
+		PolicyURL:    "https://intranet.company.com/aup",
+		Categories: map[string]string{
+			"security":  "Report this to security@company.com.",
+			"blocklist": "Ask in #eng-help if you need this site.",
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetBlockPage() = %+v, want %+v", got, want)
+	}
+
+	if (&EnterpriseRules{BaseRules: &config.Rules{}}).GetBlockPage() != nil {
+		t.Error("Expected nil when no rules configure the block page")
+	}
+}
+
+func TestGetBypassPrevention(t *testing.T) {
+	enabled, disabled := true, false
+	er := &EnterpriseRules{
+		BaseRules: &config.Rules{BypassPrevention: &config.BypassPreventionConfig{
+			Enabled: &enabled,
+			Domains: []string{"doh.example.net"},
+		}},
+		GroupRules: &config.Rules{BypassPrevention: &config.BypassPreventionConfig{
+			Exempt: []string{"dns.quad9.net"},
+		}},
+		UserRules: &config.Rules{BypassPrevention: &config.BypassPreventionConfig{
+			Enabled: &disabled,
+		}},
+	}
+
+	got := er.GetBypassPrevention()
+	want := &config.BypassPreventionConfig{
+		Enabled: &enabled,
+		Domains: []string{"doh.example.net"},
+		Exempt:  []string{"dns.quad9.net"},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetBypassPrevention() = %+v, want %+v", got, want)
+	}
+
+	er.GroupRules.BypassPrevention.Enabled = &disabled
+	if got := er.GetBypassPrevention(); *got.Enabled {
+		t.Error("Expected the group to disable bypass prevention")
+	}
+}
+
+func TestGetEnforcement(t *testing.T) {
+	er := &EnterpriseRules{
+		BaseRules:  &config.Rules{Enforcement: config.EnforcementMonitor},
+		GroupRules: &config.Rules{},
+		UserRules:  &config.Rules{Enforcement: config.EnforcementBlock},
+	}
+	if got := er.GetEnforcement(); got != config.EnforcementMonitor {
+		t.Errorf("GetEnforcement() = %q, want the base mode", got)
+	}
+
+	er.GroupRules.Enforcement = config.EnforcementBlock
+	er.UserRules.Enforcement = config.EnforcementMonitor
+	if got := er.GetEnforcement(); got != config.EnforcementBlock {
+		t.Errorf("GetEnforcement() = %q, want the group mode", got)
+	}
+
+	if got := (&EnterpriseRules{}).GetEnforcement(); got != "" {
+		t.Errorf("GetEnforcement() = %q without rules, want the device default", got)
+	}
+}
+
+func TestGetCAProfile(t *testing.T) {
+	er := &EnterpriseRules{
+		BaseRules:  &config.Rules{CA: &config.CAProfileConfig{Name: "corp"}},
+		GroupRules: &config.Rules{CA: &config.CAProfileConfig{Name: "contractors", Organization: "Contractor LLC"}},
+	}
+	if got := er.GetCAProfile(); got == nil || got.Name != "contractors" {
+		t.Errorf("GetCAProfile() = %+v, want the group's profile", got)
+	}
+
+	er.GroupRules.CA = &config.CAProfileConfig{}
+	if got := er.GetCAProfile(); got == nil || got.Name != "corp" {
+		t.Errorf("GetCAProfile() = %+v, want the base profile when the group's has no name", got)
+	}
+
+	if got := (&EnterpriseRules{GroupRules: &config.Rules{}}).GetCAProfile(); got != nil {
+		t.Errorf("GetCAProfile() = %+v without a profile, want nil for the default CA", got)
+	}
+}
+
+func TestGetCategorySources(t *testing.T) {
+	er := &EnterpriseRules{
+		BaseRules:  &config.Rules{BlockCategories: []string{"ads", "Malware"}},
+		GroupRules: &config.Rules{BlockCategories: []string{"gambling", "unknown"}},
+		UserRules:  &config.Rules{BlockCategories: []string{"ads"}},
+		Categories: map[string]config.CategoryConfig{
+			"ads":      {Sources: []string{"https://lists.example/ads.txt"}},
+			"malware":  {Sources: []string{"https://lists.example/malware.txt", "https://lists.example/ads.txt"}},
+			"gambling": {Sources: []string{"https://lists.example/gambling.txt"}},
+			"social":   {Sources: []string{"https://lists.example/social.txt"}},
+		},
+	}
+
+	got := er.GetCategorySources()
+	want := map[string]string{
+		"https://lists.example/ads.txt":      "ads",
+		"https://lists.example/malware.txt":  "malware",
+		"https://lists.example/gambling.txt": "gambling",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("GetCategorySources() = %v, want %v", got, want)
+	}
+}
+
+func TestInRollout(t *testing.T) {
+	rollout := &config.RolloutConfig{Percent: 25, Salt: "blocklist-v2"}
+	included := 0
+	for i := 0; i < 1000; i++ {
+		device := fmt.Sprintf("laptop-%d", i)
+		in := InRollout(rollout, device)
+		if in != InRollout(rollout, device) {
+			t.Fatalf("InRollout(%s) is not deterministic", device)
+		}
+		if in {
+			included++
+			if !InRollout(&config.RolloutConfig{Percent: 50, Salt: rollout.Salt}, device) {
+				t.Errorf("%s left the rollout when it grew", device)
+			}
+		}
+	}
+	if included < 200 || included > 300 {
+		t.Errorf("%d of 1000 devices included at 25%%", included)
+	}
+
+	if !InRollout(nil, "laptop") || !InRollout(&config.RolloutConfig{Percent: 100}, "laptop") {
+		t.Error("full rollout excluded a device")
+	}
+	if InRollout(&config.RolloutConfig{Percent: 0}, "laptop") {
+		t.Error("empty rollout included a device")
+	}
+}
+
+func TestRollOut(t *testing.T) {
+	f := &EnterpriseFetcher{
+		parsed:  make(map[string]*config.Rules),
+		applied: make(map[string]*config.Rules),
+	}
+	v1 := &config.Rules{BlockDomains: []string{"old.example.com"}}
+	staged := &config.Rules{
+		BlockDomains: []string{"new.example.com"},
+		Rollout:      &config.RolloutConfig{Percent: 0},
+	}
+
+	if got := f.rollOut("base.yaml", "Base", staged); got != staged {
+		t.Error("device with no earlier version didn't take the staged rules")
+	}
+
+	f.applied["base.yaml"] = v1
+	if got := f.rollOut("base.yaml", "Base", staged); got != v1 {
+		t.Error("excluded device didn't keep the previous version")
+	}
+
+	staged.Rollout.Percent = 100
+	if got := f.rollOut("base.yaml", "Base", staged); got != staged {
+		t.Error("included device kept the previous version")
+	}
+	if f.applied["base.yaml"] != staged {
+		t.Error("applied version wasn't recorded")
+	}
+}
+
+func TestFetchEnterpriseRulesSigned(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	hostname, err := os.Hostname()
+	if err != nil {
+		t.Skip("no hostname")
+	}
+	f, err := NewEnterpriseFetcher(&config.S3Config{
+		URL: config.LocalRulesURL(t.TempDir()),
+		Paths: config.S3Paths{
+			Base:             "base.yaml",
+			DeviceMapping:    "users/device-mapping.yaml",
+			UserGroups:       "users/user-groups.yaml",
+			GroupsDir:        "groups/",
+			UserOverridesDir: "users/overrides/",
+			Categories:       "categories.yaml",
+		},
+		SigningKeys:    []string{base64.StdEncoding.EncodeToString(pub)},
+		DeviceIdentity: []string{IdentityHostname},
+	})
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	put := func(key string, serial uint64, content string, signed bool) {
+		t.Helper()
+		if signed {
+			if err := f.PutObject(ctx, key+SignatureSuffix, SignRules(key, serial, []byte(content), priv), "text/plain"); err != nil {
+				t.Fatal(err)
+			}
+		}
+		if err := f.PutObject(ctx, key, []byte(content), "application/x-yaml"); err != nil {
+			t.Fatal(err)
+		}
+	}
+	strict := "block_domains: [strict.example.com]\nblock_categories: [ads]\n"
+	open := "allow_domains: [strict.example.com]\n"
+	put("base.yaml", 1, "block_domains: [base.example.com]\n", true)
+	put("groups/strict.yaml", 10, strict, true)
+	put("groups/open.yaml", 10, open, true)
+	put("users/device-mapping.yaml", 1, fmt.Sprintf("users:\n  alice@example.com:\n    devices: [%q]\n", hostname), true)
+	put("users/user-groups.yaml", 1, "group_assignments:\n  strict: [alice@example.com]\n", true)
+	put("categories.yaml", 1, "categories:\n  ads:\n    sources: [\"https://lists.example.com/ads.txt\"]\n", true)
+
+	er, err := f.FetchEnterpriseRules()
+	if err != nil {
+		t.Fatalf("FetchEnterpriseRules: %v", err)
+	}
+	if er.UserEmail != "alice@example.com" || er.GroupName != "strict" || len(er.Categories["ads"].Sources) != 1 {
+		t.Fatalf("signed rules = user %q, group %q, categories %v", er.UserEmail, er.GroupName, er.Categories)
+	}
+	if er.Serials["groups/strict.yaml"] != 10 {
+		t.Errorf("serials = %v", er.Serials)
+	}
+
+	// A signed group file copied over another group's key is rejected
+	openSig, _ := f.FetchObject(ctx, "groups/open.yaml"+SignatureSuffix)
+	f.PutObject(ctx, "groups/strict.yaml"+SignatureSuffix, openSig, "text/plain")
+	f.PutObject(ctx, "groups/strict.yaml", []byte(open), "application/x-yaml")
+	if er, err := f.FetchEnterpriseRules(); err != nil || len(er.GroupRules.BlockDomains) != 1 {
+		t.Errorf("copied group file applied: %+v, %v", er.GroupRules, err)
+	}
+
+	// An older signed version is rejected once a newer one was applied
+	put("groups/strict.yaml", 20, strict+"block_regex: [\"^ads\\\\.\"]\n", true)
+	if er, err := f.FetchEnterpriseRules(); err != nil || len(er.GroupRules.BlockRegex) != 1 {
+		t.Fatalf("newer group file not applied: %+v, %v", er.GroupRules, err)
+	}
+	put("groups/strict.yaml", 10, strict, true)
+	if er, err := f.FetchEnterpriseRules(); err != nil || len(er.GroupRules.BlockRegex) != 1 {
+		t.Errorf("replayed group file applied: %+v, %v", er.GroupRules, err)
+	}
+
+	// Unsigned device mappings, user groups and category registries fail
+	// the fetch, so the rules applied before are kept
+	for _, key := range []string{"categories.yaml", "users/user-groups.yaml", "users/device-mapping.yaml"} {
+		original, _ := f.FetchObject(ctx, key)
+		put(key, 0, string(original)+"# changed\n", false)
+		if _, err := f.FetchEnterpriseRules(); err == nil {
+			t.Errorf("unsigned %s accepted", key)
+		}
+		put(key, 2, string(original), true)
+	}
+}
@@ -0,0 +1,215 @@
+package rules
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/utils"
+	"gopkg.in/yaml.v3"
+)
+
+// heldFile names the digest of rules held back after a rollback
+const heldFile = "held"
+
+// HistoryEntry describes a ruleset the agent applied. The version is the
+// start of the ruleset's digest, so applying the same rules again moves
+// their entry to the top rather than adding one.
+type HistoryEntry struct {
+	Version   string            `yaml:"version" json:"version"`
+	AppliedAt time.Time         `yaml:"applied_at" json:"applied_at"`
+	ETags     map[string]string `yaml:"etags,omitempty" json:"etags,omitempty"`
+	Digest    string            `yaml:"digest" json:"-"`
+}
+
+type historyRecord struct {
+	HistoryEntry `yaml:",inline"`
+	Rules        *EnterpriseRules `yaml:"rules"`
+}
+
+// History keeps the last applied rulesets on disk for rolling back
+type History struct {
+	dir  string
+	size int
+	mu   sync.Mutex
+}
+
+// DefaultHistoryDir returns ~/.dnshield/rules-history
+func DefaultHistoryDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".dnshield", "rules-history"), nil
+}
+
+// NewHistory keeps up to size rulesets in dir
+func NewHistory(dir string, size int) *History {
+	return &History{dir: dir, size: size}
+}
+
+// Digest identifies the rules of er, ignoring when they were fetched
+func Digest(er *EnterpriseRules) string {
+	rules := *er
+	rules.FetchTime = time.Time{}
+	data, _ := yaml.Marshal(&rules)
+	sum := sha256.Sum256(data)
+	return hex.EncodeToString(sum[:])
+}
+
+// Record adds er to the history, dropping the oldest entries beyond the
+// history size. Nothing is written if er is the newest entry already.
+func (h *History) Record(er *EnterpriseRules, etags map[string]string) (*HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	digest := Digest(er)
+	if entries, err := h.list(); err == nil && len(entries) > 0 && entries[0].Digest == digest {
+		return &entries[0], nil
+	}
+	record := historyRecord{
+		HistoryEntry: HistoryEntry{
+			Version:   digest[:12],
+			AppliedAt: time.Now().UTC().Truncate(time.Second),
+			ETags:     etags,
+			Digest:    digest,
+		},
+		Rules: er,
+	}
+	data, err := yaml.Marshal(&record)
+	if err != nil {
+		return nil, fmt.Errorf("failed to encode rules history: %v", err)
+	}
+	if err := os.MkdirAll(h.dir, 0700); err != nil {
+		return nil, fmt.Errorf("failed to create rules history directory: %v", err)
+	}
+	path := h.path(record.Version)
+	if err := os.WriteFile(path+".tmp", data, 0600); err != nil {
+		return nil, fmt.Errorf("failed to write rules history: %v", err)
+	}
+	if err := os.Rename(path+".tmp", path); err != nil {
+		os.Remove(path + ".tmp")
+		return nil, fmt.Errorf("failed to write rules history: %v", err)
+	}
+
+	entries, err := h.list()
+	if err != nil {
+		return nil, err
+	}
+	for _, old := range entries[min(len(entries), h.size):] {
+		os.Remove(h.path(old.Version))
+	}
+	return &record.HistoryEntry, nil
+}
+
+// List returns the recorded rulesets, newest first
+func (h *History) List() ([]HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.list()
+}
+
+func (h *History) list() ([]HistoryEntry, error) {
+	files, err := filepath.Glob(filepath.Join(h.dir, "*.yaml"))
+	if err != nil {
+		return nil, err
+	}
+	var entries []HistoryEntry
+	for _, file := range files {
+		record, err := readHistoryRecord(file)
+		if err != nil {
+			continue
+		}
+		entries = append(entries, record.HistoryEntry)
+	}
+	sort.Slice(entries, func(i, j int) bool {
+		return entries[i].AppliedAt.After(entries[j].AppliedAt)
+	})
+	return entries, nil
+}
+
+// Load returns the rules recorded as version, which may be abbreviated
+func (h *History) Load(version string) (*EnterpriseRules, *HistoryEntry, error) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	version = strings.ToLower(strings.TrimSpace(version))
+	if version == "" || strings.Trim(version, "0123456789abcdef") != "" {
+		return nil, nil, fmt.Errorf("invalid rules version %q", version)
+	}
+	matches, _ := filepath.Glob(filepath.Join(h.dir, version+"*.yaml"))
+	switch len(matches) {
+	case 0:
+		return nil, nil, fmt.Errorf("rules version %s not found", version)
+	case 1:
+	default:
+		return nil, nil, fmt.Errorf("rules version %s is ambiguous", version)
+	}
+	record, err := readHistoryRecord(matches[0])
+	if err != nil {
+		return nil, nil, err
+	}
+	return record.Rules, &record.HistoryEntry, nil
+}
+
+// Hold records the digest of rules rolled back from, so they aren't
+// applied again until they change. An empty digest clears it.
+func (h *History) Hold(digest string) error {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	path := filepath.Join(h.dir, heldFile)
+	if digest == "" {
+		if err := os.Remove(path); err != nil && !os.IsNotExist(err) {
+			return err
+		}
+		return nil
+	}
+	if err := os.MkdirAll(h.dir, 0700); err != nil {
+		return err
+	}
+	return os.WriteFile(path, []byte(digest+"\n"), 0600)
+}
+
+// Held returns the digest of the rules held back, if any
+func (h *History) Held() string {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+
+	data, err := os.ReadFile(filepath.Join(h.dir, heldFile))
+	if err != nil {
+		return ""
+	}
+	return strings.TrimSpace(string(data))
+}
+
+func (h *History) path(version string) string {
+	return filepath.Join(h.dir, version+".yaml")
+}
+
+func readHistoryRecord(path string) (*historyRecord, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, err
+	}
+	if err := utils.SafeYAMLUnmarshal(data, nil, utils.MaxRulesFileSize); err != nil {
+		return nil, fmt.Errorf("invalid rules history: %v", err)
+	}
+	var record historyRecord
+	if err := yaml.Unmarshal(data, &record); err != nil || record.Rules == nil {
+		return nil, fmt.Errorf("invalid rules history %s", filepath.Base(path))
+	}
+	for _, r := range []*config.Rules{record.Rules.BaseRules, record.Rules.GroupRules, record.Rules.UserRules} {
+		if r != nil {
+			r.Normalize()
+		}
+	}
+	return &record, nil
+}
@@ -0,0 +1,68 @@
+package rules
+
+import (
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+func TestHistory(t *testing.T) {
+	h := NewHistory(t.TempDir(), 2)
+	ruleset := func(domain string) *EnterpriseRules {
+		return &EnterpriseRules{
+			DeviceName: "laptop-1",
+			BaseRules:  &config.Rules{BlockDomains: []string{domain}},
+			FetchTime:  time.Now(),
+		}
+	}
+
+	first, err := h.Record(ruleset("one.example.com"), map[string]string{"base.yaml": `"etag-1"`})
+	if err != nil {
+		t.Fatalf("Record() error = %v", err)
+	}
+	again, _ := h.Record(ruleset("one.example.com"), nil)
+	if again.Version != first.Version || !again.AppliedAt.Equal(first.AppliedAt) {
+		t.Error("recording the newest rules again changed the history")
+	}
+
+	// Later entries sort first; AppliedAt has one-second resolution
+	time.Sleep(1100 * time.Millisecond)
+	second, _ := h.Record(ruleset("two.example.com"), nil)
+	time.Sleep(1100 * time.Millisecond)
+	third, _ := h.Record(ruleset("three.example.com"), nil)
+
+	entries, err := h.List()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(entries) != 2 || entries[0].Version != third.Version || entries[1].Version != second.Version {
+		t.Fatalf("List() = %+v, want the two newest rulesets", entries)
+	}
+
+	rules, entry, err := h.Load(second.Version[:6])
+	if err != nil {
+		t.Fatalf("Load() error = %v", err)
+	}
+	if entry.Version != second.Version || rules.BaseRules.BlockDomains[0] != "two.example.com" {
+		t.Errorf("Load() = %+v, %+v", rules.BaseRules, entry)
+	}
+	if _, _, err := h.Load(first.Version); err == nil {
+		t.Error("Load() returned a pruned version")
+	}
+	if _, _, err := h.Load("../rules-cache"); err == nil {
+		t.Error("Load() accepted a path")
+	}
+
+	if h.Held() != "" {
+		t.Error("Held() before Hold")
+	}
+	h.Hold(third.Digest)
+	if h.Held() != Digest(ruleset("three.example.com")) {
+		t.Error("Held() doesn't match the held rules")
+	}
+	h.Hold("")
+	if h.Held() != "" {
+		t.Error("Hold(\"\") didn't clear the held rules")
+	}
+}
@@ -0,0 +1,339 @@
+package rules
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"path"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+
+	"dnshield/internal/config"
+	"dnshield/internal/utils"
+
+	"gopkg.in/yaml.v3"
+)
+
+// Severities of lint issues
+const (
+	LintError   = "error"
+	LintWarning = "warning"
+)
+
+// LintIssue is a problem found in a rules layout
+type LintIssue struct {
+	File     string
+	Severity string
+	Message  string
+}
+
+func (i LintIssue) String() string {
+	return fmt.Sprintf("%s: %s", i.File, i.Message)
+}
+
+// Linter checks a rules layout on disk before it is published: that every
+// file parses with only known keys, that device and group assignments are
+// unambiguous and point at existing files, and that rules don't contradict
+// each other
+type Linter struct {
+	dir   string
+	paths config.S3Paths
+
+	// CheckSources downloads every external source to check it is
+	// reachable and parses
+	CheckSources bool
+	parser       *Parser
+
+	issues  []LintIssue
+	sources map[string][]string // Source URL -> files using it
+}
+
+// NewLinter lints the layout in dir, with files at paths
+func NewLinter(dir string, paths config.S3Paths, parser *Parser) *Linter {
+	return &Linter{dir: dir, paths: paths, parser: parser, sources: make(map[string][]string)}
+}
+
+// Lint returns the issues found, errors first
+func (l *Linter) Lint() []LintIssue {
+	l.issues = nil
+
+	if l.read(l.paths.Base) == nil {
+		l.errorf(l.paths.Base, "base rules are missing")
+	} else {
+		l.lintRules(l.paths.Base)
+	}
+
+	groups := l.lintDir(l.paths.GroupsDir)
+	l.lintDir(l.paths.UserOverridesDir)
+	l.lintDeviceMapping()
+	l.lintUserGroups(groups)
+	if l.CheckSources {
+		l.lintSources()
+	}
+
+	sort.SliceStable(l.issues, func(i, j int) bool {
+		return l.issues[i].Severity == LintError && l.issues[j].Severity != LintError
+	})
+	return l.issues
+}
+
+func (l *Linter) errorf(file, format string, args ...interface{}) {
+	l.issues = append(l.issues, LintIssue{File: file, Severity: LintError, Message: fmt.Sprintf(format, args...)})
+}
+
+func (l *Linter) warnf(file, format string, args ...interface{}) {
+	l.issues = append(l.issues, LintIssue{File: file, Severity: LintWarning, Message: fmt.Sprintf(format, args...)})
+}
+
+// read returns the content of key, or nil if it doesn't exist
+func (l *Linter) read(key string) []byte {
+	content, err := os.ReadFile(filepath.Join(l.dir, filepath.FromSlash(key)))
+	if err != nil {
+		if !os.IsNotExist(err) {
+			l.errorf(key, "%v", err)
+		}
+		return nil
+	}
+	return content
+}
+
+// decode parses key strictly into v, reporting unknown keys
+func (l *Linter) decode(key string, v interface{}) bool {
+	content := l.read(key)
+	if content == nil {
+		return false
+	}
+	if err := utils.SafeYAMLUnmarshal(content, nil, utils.MaxRulesFileSize); err != nil {
+		l.errorf(key, "%v", err)
+		return false
+	}
+	dec := yaml.NewDecoder(bytes.NewReader(content))
+	dec.KnownFields(true)
+	if err := dec.Decode(v); err != nil && !errors.Is(err, io.EOF) {
+		l.errorf(key, "%v", err)
+		return false
+	}
+	return true
+}
+
+// lintDir lints the rules files in dir and returns their names without
+// the .yaml extension
+func (l *Linter) lintDir(dir string) map[string]bool {
+	names := make(map[string]bool)
+	entries, err := os.ReadDir(filepath.Join(l.dir, filepath.FromSlash(dir)))
+	if err != nil {
+		return names
+	}
+	for _, entry := range entries {
+		name := entry.Name()
+		if entry.IsDir() || !strings.HasSuffix(name, ".yaml") {
+			continue
+		}
+		names[strings.TrimSuffix(name, ".yaml")] = true
+		l.lintRules(path.Join(dir, name))
+	}
+	return names
+}
+
+func (l *Linter) lintRules(key string) {
+	var rules config.Rules
+	if !l.decode(key, &rules) {
+		return
+	}
+	rules.Normalize()
+
+	blocked := l.lintDomains(key, "block_domains", rules.BlockDomains)
+	allowed := l.lintDomains(key, "allow_domains", rules.AllowDomains)
+	l.lintDomains(key, "security_block_domains", rules.SecurityBlockDomains)
+
+	// The allowlist wins over block rules, so a block rule an allow rule
+	// covers never applies
+	for _, block := range blocked {
+		for _, allow := range allowed {
+			if allow.covers(block) {
+				l.warnf(key, "block rule %q never applies: allow rule %q covers it", block.rule, allow.rule)
+			}
+		}
+	}
+
+	for _, list := range []struct {
+		field   string
+		sources []string
+	}{
+		{"block_sources", rules.BlockSources},
+		{"security_block_sources", rules.SecurityBlockSources},
+	} {
+		seen := make(map[string]bool)
+		for _, source := range list.sources {
+			if seen[source] {
+				l.warnf(key, "%s lists %s more than once", list.field, source)
+			}
+			seen[source] = true
+			l.sources[source] = append(l.sources[source], key)
+		}
+	}
+	for source := range rules.Checksums {
+		if !contains(rules.BlockSources, source) {
+			l.warnf(key, "checksum for %s, which isn't in block_sources", source)
+		}
+	}
+
+	for _, pattern := range rules.BlockRegex {
+		if _, err := regexp.Compile(pattern); err != nil {
+			l.errorf(key, "invalid block_regex %q: %v", pattern, err)
+		}
+	}
+	switch rules.AllowlistPrecedence {
+	case "", "allowlist", "security":
+	default:
+		l.errorf(key, "invalid allowlist_precedence %q (must be allowlist or security)", rules.AllowlistPrecedence)
+	}
+	switch rules.Enforcement {
+	case "", config.EnforcementBlock, config.EnforcementMonitor:
+	default:
+		l.errorf(key, "invalid enforcement %q (must be block or monitor)", rules.Enforcement)
+	}
+	if rules.Rollout != nil && (rules.Rollout.Percent < 0 || rules.Rollout.Percent > 100) {
+		l.errorf(key, "rollout percent %d must be between 0 and 100", rules.Rollout.Percent)
+	}
+	if rules.AllowOnlyMode && len(rules.AllowDomains) == 0 {
+		l.warnf(key, "allow_only_mode with no allow_domains blocks everything")
+	}
+}
+
+// lintRule is a domain rule and the names it covers
+type lintRule struct {
+	rule           string
+	domain         string
+	subdomainsOnly bool
+}
+
+func (r lintRule) covers(other lintRule) bool {
+	if other.domain == r.domain {
+		return !r.subdomainsOnly || other.subdomainsOnly
+	}
+	return strings.HasSuffix(other.domain, "."+r.domain)
+}
+
+// lintDomains checks the syntax of a list of domain rules and reports
+// duplicates, returning the valid rules
+func (l *Linter) lintDomains(key, field string, entries []string) []lintRule {
+	var rules []lintRule
+	seen := make(map[string]bool)
+	for _, entry := range entries {
+		rule := strings.ToLower(strings.TrimSpace(entry))
+		if seen[rule] {
+			l.warnf(key, "%s lists %q more than once", field, entry)
+			continue
+		}
+		seen[rule] = true
+
+		domain, subdomainsOnly, ok := splitDomainRule(rule)
+		if !ok {
+			l.errorf(key, "unsupported %s rule %q", field, entry)
+			continue
+		}
+		if err := utils.ValidateDomainLength(domain); err != nil {
+			l.errorf(key, "invalid %s rule %q: %v", field, entry, err)
+			continue
+		}
+		rules = append(rules, lintRule{rule: rule, domain: domain, subdomainsOnly: subdomainsOnly})
+	}
+	return rules
+}
+
+func (l *Linter) lintDeviceMapping() {
+	var mapping config.DeviceMapping
+	if !l.decode(l.paths.DeviceMapping, &mapping) {
+		return
+	}
+
+	owners := make(map[string]string)
+	for _, user := range sortedKeys(mapping.Users) {
+		for _, device := range mapping.Users[user].Devices {
+			if strings.TrimSpace(device) == "" {
+				l.errorf(l.paths.DeviceMapping, "empty device name for %s", user)
+				continue
+			}
+			if owner, ok := owners[device]; ok && owner != user {
+				l.errorf(l.paths.DeviceMapping, "device %s is mapped to both %s and %s", device, owner, user)
+				continue
+			}
+			owners[device] = user
+		}
+	}
+}
+
+func (l *Linter) lintUserGroups(groups map[string]bool) {
+	var userGroups config.UserGroups
+	if !l.decode(l.paths.UserGroups, &userGroups) {
+		return
+	}
+
+	assigned := make(map[string]string)
+	for _, group := range sortedKeys(userGroups.GroupAssignments) {
+		if !groups[group] {
+			l.errorf(l.paths.UserGroups, "group %s has no %s file", group, path.Join(l.paths.GroupsDir, group+".yaml"))
+		}
+		for _, user := range userGroups.GroupAssignments[group] {
+			if other, ok := assigned[user]; ok && other != group {
+				// Assignments are a map, so which one applies is undefined
+				l.errorf(l.paths.UserGroups, "%s is assigned to both %s and %s", user, other, group)
+				continue
+			}
+			assigned[user] = group
+		}
+	}
+	for _, user := range sortedKeys(userGroups.UserOverrides) {
+		if group := userGroups.UserOverrides[user]; !groups[group] {
+			l.errorf(l.paths.UserGroups, "user_overrides sends %s to group %s, which has no %s file", user, group, path.Join(l.paths.GroupsDir, group+".yaml"))
+		}
+	}
+}
+
+// lintSources downloads each external source once
+func (l *Linter) lintSources() {
+	sources := make([]string, 0, len(l.sources))
+	for source := range l.sources {
+		sources = append(sources, source)
+	}
+	sort.Strings(sources)
+
+	for _, source := range sources {
+		domains := 0
+		err := l.parser.FetchAndStreamURL(source, "", func(string) error {
+			domains++
+			return nil
+		})
+		if err == nil && domains == 0 {
+			err = fmt.Errorf("no domains found")
+		}
+		if err != nil {
+			for _, file := range l.sources[source] {
+				l.errorf(file, "source %s: %v", source, err)
+			}
+		}
+	}
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func sortedKeys[V any](m map[string]V) []string {
+	keys := make([]string, 0, len(m))
+	for k := range m {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
@@ -0,0 +1,92 @@
+package rules
+
+import (
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"dnshield/internal/config"
+)
+
+func TestLinter(t *testing.T) {
+	dir := t.TempDir()
+	files := map[string]string{
+		"base.yaml": `version: "1.0"
+block_domains:
+  - ads.example.com
+  - ads.example.com
+  - tracker.corp.com
+  - "ads.*.com"
+allow_domains:
+  - "*.corp.com"
+block_regex:
+  - "(["
+`,
+		"groups/engineering.yaml": `block_domain:
+  - typo.example.com
+`,
+		"users/device-mapping.yaml": `users:
+  alice@example.com:
+    devices: [mac-1]
+  bob@example.com:
+    devices: [mac-1]
+`,
+		"users/user-groups.yaml": `group_assignments:
+  engineering: [alice@example.com]
+  sales: [alice@example.com]
+user_overrides:
+  bob@example.com: marketing
+`,
+	}
+	for name, content := range files {
+		file := filepath.Join(dir, filepath.FromSlash(name))
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	paths := config.S3Paths{
+		Base:             "base.yaml",
+		DeviceMapping:    "users/device-mapping.yaml",
+		UserGroups:       "users/user-groups.yaml",
+		GroupsDir:        "groups/",
+		UserOverridesDir: "users/overrides/",
+	}
+	issues := NewLinter(dir, paths, NewParser()).Lint()
+
+	want := []struct {
+		file, severity, message string
+	}{
+		{"base.yaml", LintWarning, `block_domains lists "ads.example.com" more than once`},
+		{"base.yaml", LintError, `unsupported block_domains rule "ads.*.com"`},
+		{"base.yaml", LintWarning, `block rule "tracker.corp.com" never applies: allow rule "*.corp.com" covers it`},
+		{"base.yaml", LintError, `invalid block_regex "(["`},
+		{"groups/engineering.yaml", LintError, "field block_domain not found"},
+		{"users/device-mapping.yaml", LintError, "device mac-1 is mapped to both alice@example.com and bob@example.com"},
+		{"users/user-groups.yaml", LintError, "group sales has no groups/sales.yaml file"},
+		{"users/user-groups.yaml", LintError, "alice@example.com is assigned to both engineering and sales"},
+		{"users/user-groups.yaml", LintError, "sends bob@example.com to group marketing"},
+	}
+	for _, w := range want {
+		found := false
+		for _, issue := range issues {
+			if issue.File == w.file && issue.Severity == w.severity && strings.Contains(issue.Message, w.message) {
+				found = true
+			}
+		}
+		if !found {
+			t.Errorf("missing %s in %s: %s", w.severity, w.file, w.message)
+		}
+	}
+	if len(issues) != len(want) {
+		t.Errorf("got %d issues, want %d: %v", len(issues), len(want), issues)
+	}
+	for i := 1; i < len(issues); i++ {
+		if issues[i-1].Severity == LintWarning && issues[i].Severity == LintError {
+			t.Error("errors not listed before warnings")
+			break
+		}
+	}
+}
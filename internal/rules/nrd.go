@@ -0,0 +1,198 @@
+package rules
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+const nrdDateLayout = "2006-01-02"
+
+// NRDTracker remembers when newly registered domains first appeared in the
+// NRD feed, so that domains can be blocked for a number of days after
+// registration even though the feed only lists each domain once
+type NRDTracker struct {
+	cfg *config.NRDConfig
+
+	mu         sync.Mutex
+	registered map[string]time.Time // Domain -> registration day
+}
+
+// NewNRDTracker creates a tracker with no known domains
+func NewNRDTracker(cfg *config.NRDConfig) *NRDTracker {
+	return &NRDTracker{
+		cfg:        cfg,
+		registered: make(map[string]time.Time),
+	}
+}
+
+// Update fetches the feed with parser, records its domains as registered
+// today unless the feed gives a date, and forgets domains past the
+// configured age
+func (t *NRDTracker) Update(parser *Parser, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	added := 0
+	err := parser.FetchAndStreamURL(t.cfg.FeedURL, "", func(line string) error {
+		ok, err := t.addLocked(line, now)
+		if ok {
+			added++
+		}
+		return err
+	})
+	t.pruneLocked(now)
+	logrus.WithFields(logrus.Fields{
+		"added":   added,
+		"tracked": len(t.registered),
+	}).Debug("Merged NRD feed")
+	return err
+}
+
+// Merge reads a feed from r like Update
+func (t *NRDTracker) Merge(parser *Parser, r io.Reader, now time.Time) error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	err := parser.ParseReader(r, func(line string) error {
+		_, err := t.addLocked(line, now)
+		return err
+	})
+	t.pruneLocked(now)
+	return err
+}
+
+// addLocked records one feed entry: a domain, or "domain,YYYY-MM-DD". It
+// reports whether a new domain was recorded. Callers must hold t.mu.
+func (t *NRDTracker) addLocked(line string, now time.Time) (bool, error) {
+	domain, date, hasDate := strings.Cut(line, ",")
+	domain = strings.TrimSuffix(strings.ToLower(strings.TrimSpace(domain)), ".")
+	if domain == "" || !strings.Contains(domain, ".") || utils.ValidateDomainLength(domain) != nil {
+		return false, nil
+	}
+
+	day := truncateDay(now)
+	if hasDate {
+		parsed, err := time.ParseInLocation(nrdDateLayout, strings.TrimSpace(date), time.UTC)
+		if err != nil {
+			return false, nil
+		}
+		day = parsed
+	}
+	if day.Before(truncateDay(now).Add(-t.cfg.MaxAge)) {
+		return false, nil
+	}
+
+	// The earliest date seen wins; feeds may repeat a domain on later days
+	if known, ok := t.registered[domain]; ok {
+		if day.Before(known) {
+			t.registered[domain] = day
+		}
+		return false, nil
+	}
+	if len(t.registered) >= t.cfg.MaxDomains {
+		return false, fmt.Errorf("NRD domain count exceeds maximum of %d", t.cfg.MaxDomains)
+	}
+	t.registered[domain] = day
+	return true, nil
+}
+
+// pruneLocked forgets domains registered longer ago than the configured
+// age. Callers must hold t.mu.
+func (t *NRDTracker) pruneLocked(now time.Time) {
+	cutoff := truncateDay(now).Add(-t.cfg.MaxAge)
+	for domain, day := range t.registered {
+		if day.Before(cutoff) {
+			delete(t.registered, domain)
+		}
+	}
+}
+
+// Domains returns the domains registered within the configured age
+func (t *NRDTracker) Domains(now time.Time) []string {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	t.pruneLocked(now)
+	domains := make([]string, 0, len(t.registered))
+	for domain := range t.registered {
+		domains = append(domains, domain)
+	}
+	sort.Strings(domains)
+	return domains
+}
+
+// Load restores the domains saved by Save. A missing state file is not an
+// error.
+func (t *NRDTracker) Load() error {
+	f, err := os.Open(t.cfg.StatePath)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	scanner := bufio.NewScanner(f)
+	for scanner.Scan() {
+		domain, date, ok := strings.Cut(scanner.Text(), " ")
+		if !ok {
+			continue
+		}
+		day, err := time.ParseInLocation(nrdDateLayout, date, time.UTC)
+		if err != nil || len(t.registered) >= t.cfg.MaxDomains {
+			continue
+		}
+		t.registered[domain] = day
+	}
+	return scanner.Err()
+}
+
+// Save writes the tracked domains and their registration days to the
+// state file
+func (t *NRDTracker) Save() error {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+
+	if err := os.MkdirAll(filepath.Dir(t.cfg.StatePath), 0755); err != nil {
+		return err
+	}
+	tmp := t.cfg.StatePath + ".tmp"
+	f, err := os.OpenFile(tmp, os.O_WRONLY|os.O_CREATE|os.O_TRUNC, 0644)
+	if err != nil {
+		return err
+	}
+	w := bufio.NewWriter(f)
+	for domain, day := range t.registered {
+		fmt.Fprintf(w, "%s %s\n", domain, day.Format(nrdDateLayout))
+	}
+	if err := w.Flush(); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(tmp, t.cfg.StatePath)
+}
+
+// truncateDay returns the start of t's day in UTC, the timezone NRD feeds
+// date registrations in
+func truncateDay(t time.Time) time.Time {
+	return t.UTC().Truncate(24 * time.Hour)
+}
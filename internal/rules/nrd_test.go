@@ -0,0 +1,54 @@
+package rules
+
+import (
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+func TestNRDTracker(t *testing.T) {
+	cfg := &config.NRDConfig{
+		MaxAge:     7 * 24 * time.Hour,
+		MaxDomains: 100,
+		StatePath:  filepath.Join(t.TempDir(), "nrd-state.txt"),
+	}
+	tracker := NewNRDTracker(cfg)
+	parser := NewParser()
+	day1 := time.Date(2024, 1, 10, 8, 0, 0, 0, time.UTC)
+
+	feed := strings.Join([]string{
+		"fresh-login.example",
+		"Dated.Example,2024-01-08",
+		"old.example,2023-12-01", // Registered too long ago
+		"bad-date.example,yesterday",
+		"localhost",
+	}, "\n")
+	if err := tracker.Merge(parser, strings.NewReader(feed), day1); err != nil {
+		t.Fatal(err)
+	}
+	if got, want := tracker.Domains(day1), []string{"dated.example", "fresh-login.example"}; !reflect.DeepEqual(got, want) {
+		t.Fatalf("Domains() = %v, want %v", got, want)
+	}
+
+	// A domain listed again later keeps its first date
+	day5 := day1.Add(4 * 24 * time.Hour)
+	if err := tracker.Merge(parser, strings.NewReader("fresh-login.example\nnext.example\n"), day5); err != nil {
+		t.Fatal(err)
+	}
+	if err := tracker.Save(); err != nil {
+		t.Fatal(err)
+	}
+
+	restored := NewNRDTracker(cfg)
+	if err := restored.Load(); err != nil {
+		t.Fatal(err)
+	}
+	day9 := day1.Add(8 * 24 * time.Hour)
+	if got, want := restored.Domains(day9), []string{"next.example"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("Domains() after a week = %v, want %v", got, want)
+	}
+}
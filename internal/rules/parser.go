@@ -18,7 +18,10 @@ import (
 
 // Parser parses blocklist files
 type Parser struct {
-	httpClient *http.Client
+	httpClient  *http.Client
+	maxFileSize int64
+	maxDomains  int
+	cache       *BlocklistCache // nil downloads every list in full
 }
 
 // NewParser creates a new rule parser
@@ -27,9 +30,34 @@ func NewParser() *Parser {
 		httpClient: &http.Client{
 			Timeout: 30 * time.Second,
 		},
+		maxFileSize: utils.MaxRulesFileSize,
+		maxDomains:  utils.MaxDomainsPerRule,
 	}
 }
 
+// SetLimits overrides the maximum blocklist size in bytes and the maximum
+// number of domains accepted from a single list. Non-positive values keep
+// the current limit.
+func (p *Parser) SetLimits(maxFileSize int64, maxDomains int) {
+	if maxFileSize > 0 {
+		p.maxFileSize = maxFileSize
+	}
+	if maxDomains > 0 {
+		p.maxDomains = maxDomains
+	}
+}
+
+// SetCache keeps a copy of each blocklist in cache, so lists are only
+// downloaded again when they change, and as a delta when one is published
+func (p *Parser) SetCache(cache *BlocklistCache) {
+	p.cache = cache
+}
+
+// MaxDomains returns the maximum number of domains accepted from a single list
+func (p *Parser) MaxDomains() int {
+	return p.maxDomains
+}
+
 // ParseHostsFile parses a hosts file format blocklist
 func (p *Parser) ParseHostsFile(content string) []string {
 	var domains []string
@@ -57,6 +85,33 @@ func (p *Parser) ParseHostsFile(content string) []string {
 	return domains
 }
 
+// ParseReader streams a blocklist in hosts or plain domain format, calling fn
+// for every domain found. Only one line is held in memory at a time. Reading
+// stops with an error if the input exceeds the parser's size limit or if fn
+// returns an error.
+func (p *Parser) ParseReader(r io.Reader, fn func(domain string) error) error {
+	counter := &countingReader{r: io.LimitReader(r, p.maxFileSize+1)}
+	scanner := bufio.NewScanner(counter)
+
+	for scanner.Scan() {
+		if domain := parseBlocklistLine(scanner.Text()); domain != "" {
+			if err := fn(domain); err != nil {
+				return err
+			}
+		}
+	}
+
+	if err := scanner.Err(); err != nil {
+		return fmt.Errorf("error reading blocklist: %v", err)
+	}
+
+	if counter.n > p.maxFileSize {
+		return fmt.Errorf("blocklist exceeds maximum size of %d bytes", p.maxFileSize)
+	}
+
+	return nil
+}
+
 // FetchAndParseURL fetches and parses a blocklist from URL
 func (p *Parser) FetchAndParseURL(urlStr string) ([]string, error) {
 	return p.FetchAndParseURLWithChecksum(urlStr, "")
@@ -64,9 +119,29 @@ func (p *Parser) FetchAndParseURL(urlStr string) ([]string, error) {
 
 // FetchAndParseURLWithChecksum fetches and parses a blocklist from URL with optional SHA256 checksum verification
 func (p *Parser) FetchAndParseURLWithChecksum(urlStr, expectedSHA256 string) ([]string, error) {
+	var domains []string
+	err := p.FetchAndStreamURL(urlStr, expectedSHA256, func(domain string) error {
+		if len(domains) >= p.maxDomains {
+			return fmt.Errorf("blocklist domain count exceeds maximum of %d", p.maxDomains)
+		}
+		domains = append(domains, domain)
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	return domains, nil
+}
+
+// FetchAndStreamURL fetches a blocklist from URL and streams each domain to fn
+// without buffering the whole response. When expectedSHA256 is set the
+// checksum can only be verified once the body has been read, so callers must
+// discard anything fn received if an error is returned.
+func (p *Parser) FetchAndStreamURL(urlStr, expectedSHA256 string, fn func(domain string) error) error {
 	// Validate URL to prevent SSRF attacks
 	if err := validateBlocklistURL(urlStr); err != nil {
-		return nil, err
+		return err
 	}
 	
 	logFields := logrus.Fields{"url": urlStr}
@@ -75,79 +150,209 @@ func (p *Parser) FetchAndParseURLWithChecksum(urlStr, expectedSHA256 string) ([]
 	}
 	logrus.WithFields(logFields).Debug("Fetching blocklist")
 
+	if p.cache != nil {
+		return p.fetchCached(urlStr, expectedSHA256, fn)
+	}
+
 	resp, err := p.httpClient.Get(urlStr)
 	if err != nil {
-		return nil, err
+		return err
 	}
 	defer resp.Body.Close()
 
 	if resp.StatusCode != http.StatusOK {
-		return nil, fmt.Errorf("unexpected status code: %d", resp.StatusCode)
+		return fmt.Errorf("unexpected status code: %d", resp.StatusCode)
 	}
 
-	// Limit response body size to prevent DoS
-	limitedReader := utils.LimitedReader(resp.Body, int64(utils.MaxRulesFileSize))
-	
 	// If checksum verification is requested, wrap with a hashing reader
-	var reader io.Reader = limitedReader
+	var reader io.Reader = resp.Body
 	hasher := sha256.New()
 	if expectedSHA256 != "" {
-		reader = io.TeeReader(limitedReader, hasher)
-	}
-	
-	scanner := bufio.NewScanner(reader)
-	var domains []string
-
-	for scanner.Scan() {
-		line := strings.TrimSpace(scanner.Text())
-
-		// Skip comments and empty lines
-		if line == "" || strings.HasPrefix(line, "#") {
-			continue
-		}
-
-		// Try to parse as hosts file format
-		if strings.Contains(line, " ") || strings.Contains(line, "\t") {
-			parts := strings.Fields(line)
-			if len(parts) >= 2 {
-				domain := parts[1]
-				if domain != "localhost" && domain != "localhost.localdomain" {
-					domains = append(domains, domain)
-				}
-			}
-		} else {
-			// Plain domain format
-			domains = append(domains, line)
-		}
+		reader = io.TeeReader(resp.Body, hasher)
 	}
 
-	if err := scanner.Err(); err != nil {
-		return nil, fmt.Errorf("error reading blocklist: %v", err)
+	count := 0
+	err = p.ParseReader(reader, func(domain string) error {
+		count++
+		return fn(domain)
+	})
+	if err != nil {
+		return err
 	}
 	
 	// Verify checksum if provided
 	if expectedSHA256 != "" {
-		actualChecksum := hex.EncodeToString(hasher.Sum(nil))
-		if actualChecksum != expectedSHA256 {
-			logrus.WithFields(logrus.Fields{
-				"url":      urlStr,
-				"expected": expectedSHA256,
-				"actual":   actualChecksum,
-			}).Error("Blocklist checksum mismatch")
-			return nil, fmt.Errorf("blocklist checksum mismatch: expected %s, got %s", expectedSHA256, actualChecksum)
+		if err := p.verifyChecksum(urlStr, hex.EncodeToString(hasher.Sum(nil)), expectedSHA256); err != nil {
+			return err
 		}
-		logrus.WithFields(logrus.Fields{
-			"url":      urlStr,
-			"checksum": actualChecksum,
-		}).Debug("Blocklist checksum verified")
 	}
 
 	logrus.WithFields(logrus.Fields{
 		"url":     urlStr,
-		"domains": len(domains),
+		"domains": count,
 	}).Info("Parsed blocklist")
 
-	return domains, nil
+	return nil
+}
+
+// verifyChecksum compares the SHA-256 of a blocklist with the expected one,
+// if any
+func (p *Parser) verifyChecksum(urlStr, actualChecksum, expectedSHA256 string) error {
+	if expectedSHA256 == "" {
+		return nil
+	}
+	if actualChecksum != expectedSHA256 {
+		logrus.WithFields(logrus.Fields{
+			"url":      urlStr,
+			"expected": expectedSHA256,
+			"actual":   actualChecksum,
+		}).Error("Blocklist checksum mismatch")
+		return fmt.Errorf("blocklist checksum mismatch: expected %s, got %s", expectedSHA256, actualChecksum)
+	}
+	logrus.WithFields(logrus.Fields{
+		"url":      urlStr,
+		"checksum": actualChecksum,
+	}).Debug("Blocklist checksum verified")
+	return nil
+}
+
+// parseBlocklistLine extracts the domain from a single blocklist line,
+// returning an empty string for comments, blank lines and localhost entries
+func parseBlocklistLine(line string) string {
+	line = strings.TrimSpace(line)
+
+	// Skip comments and empty lines
+	if line == "" || strings.HasPrefix(line, "#") {
+		return ""
+	}
+
+	// Adblock-style lists: skip comments ("!"), headers ("[Adblock Plus]")
+	// and exceptions ("@@"); "||example.com^" rules are kept as is
+	if strings.HasPrefix(line, "!") || strings.HasPrefix(line, "[") || strings.HasPrefix(line, "@@") {
+		return ""
+	}
+
+	// Plain domain format
+	if !strings.Contains(line, " ") && !strings.Contains(line, "\t") {
+		return line
+	}
+
+	// Hosts file format (e.g., "0.0.0.0 example.com")
+	parts := strings.Fields(line)
+	if len(parts) < 2 {
+		return ""
+	}
+	domain := parts[1]
+	if domain == "localhost" || domain == "localhost.localdomain" {
+		return ""
+	}
+	return domain
+}
+
+// countingReader tracks the number of bytes read through it
+type countingReader struct {
+	r io.Reader
+	n int64
+}
+
+func (c *countingReader) Read(p []byte) (int, error) {
+	n, err := c.r.Read(p)
+	c.n += int64(n)
+	return n, err
+}
+
+// DomainCollector accumulates streamed domains with deduplication and a hard
+// cap on the number of unique entries. The first source to supply a domain
+// is remembered for it.
+type DomainCollector struct {
+	max     int
+	sources map[string]string
+	domains []string
+}
+
+// NewDomainCollector creates a collector that accepts at most max unique domains
+func NewDomainCollector(max int) *DomainCollector {
+	return &DomainCollector{
+		max:     max,
+		sources: make(map[string]string),
+	}
+}
+
+// Add normalizes and records a domain, ignoring duplicates. It returns an
+// error once the unique domain count would exceed the collector's limit.
+func (c *DomainCollector) Add(domain string) error {
+	return c.add(domain, "")
+}
+
+// AddFrom returns an Add function that attributes domains to source, suitable
+// for passing to Parser.FetchAndStreamURL
+func (c *DomainCollector) AddFrom(source string) func(domain string) error {
+	return func(domain string) error {
+		return c.add(domain, source)
+	}
+}
+
+func (c *DomainCollector) add(domain, source string) error {
+	domain = strings.ToLower(strings.TrimSpace(domain))
+	if domain == "" {
+		return nil
+	}
+	if _, seen := c.sources[domain]; seen {
+		return nil
+	}
+	if len(c.domains) >= c.max {
+		return fmt.Errorf("domain count exceeds maximum of %d", c.max)
+	}
+	c.sources[domain] = source
+	c.domains = append(c.domains, domain)
+	return nil
+}
+
+// AddAll adds every domain in the list
+func (c *DomainCollector) AddAll(domains []string) error {
+	for _, domain := range domains {
+		if err := c.Add(domain); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// Merge adds the domains of other with their sources. Either all of them
+// are added or, if that would pass the collector's limit, none are.
+func (c *DomainCollector) Merge(other *DomainCollector) error {
+	added := 0
+	for _, domain := range other.domains {
+		if _, seen := c.sources[domain]; !seen {
+			added++
+		}
+	}
+	if len(c.domains)+added > c.max {
+		return fmt.Errorf("domain count exceeds maximum of %d", c.max)
+	}
+	for _, domain := range other.domains {
+		if _, seen := c.sources[domain]; !seen {
+			c.sources[domain] = other.sources[domain]
+			c.domains = append(c.domains, domain)
+		}
+	}
+	return nil
+}
+
+// Domains returns the collected unique domains in insertion order
+func (c *DomainCollector) Domains() []string {
+	return c.domains
+}
+
+// Sources returns the source each collected domain was first seen in.
+// Domains added without a source map to an empty string.
+func (c *DomainCollector) Sources() map[string]string {
+	return c.sources
+}
+
+// Len returns the number of unique domains collected
+func (c *DomainCollector) Len() int {
+	return len(c.domains)
 }
 
 // MergeDomains merges multiple domain lists and removes duplicates
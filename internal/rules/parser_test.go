@@ -0,0 +1,106 @@
+package rules
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestParseReader(t *testing.T) {
+	input := strings.Join([]string{
+		"# comment",
+		"",
+		"0.0.0.0 ads.example.com",
+		"127.0.0.1\ttracker.example.com",
+		"127.0.0.1 localhost",
+		"plain.example.com",
+	}, "\n")
+
+	p := NewParser()
+	var got []string
+	err := p.ParseReader(strings.NewReader(input), func(domain string) error {
+		got = append(got, domain)
+		return nil
+	})
+	if err != nil {
+		t.Fatalf("ParseReader returned error: %v", err)
+	}
+
+	want := []string{"ads.example.com", "tracker.example.com", "plain.example.com"}
+	if len(got) != len(want) {
+		t.Fatalf("Expected %d domains, got %d: %v", len(want), len(got), got)
+	}
+	for i := range want {
+		if got[i] != want[i] {
+			t.Errorf("Domain %d: expected %s, got %s", i, want[i], got[i])
+		}
+	}
+}
+
+func TestParseReaderSizeLimit(t *testing.T) {
+	input := strings.Repeat("blocked.example.com\n", 100)
+
+	tests := []struct {
+		name    string
+		maxSize int64
+		wantErr bool
+	}{
+		{"WithinLimit", int64(len(input)), false},
+		{"ExceedsLimit", int64(len(input)) - 1, true},
+	}
+
+	for _, tt := range tests {
+		t.Run(tt.name, func(t *testing.T) {
+			p := NewParser()
+			p.SetLimits(tt.maxSize, 0)
+			err := p.ParseReader(strings.NewReader(input), func(string) error { return nil })
+			if (err != nil) != tt.wantErr {
+				t.Errorf("ParseReader() error = %v, wantErr %v", err, tt.wantErr)
+			}
+		})
+	}
+}
+
+func TestDomainCollector(t *testing.T) {
+	t.Run("Deduplicates", func(t *testing.T) {
+		c := NewDomainCollector(10)
+		for _, d := range []string{"a.com", "A.com", " a.com ", "b.com", ""} {
+			if err := c.Add(d); err != nil {
+				t.Fatalf("Add(%q) returned error: %v", d, err)
+			}
+		}
+		if c.Len() != 2 {
+			t.Errorf("Expected 2 unique domains, got %d: %v", c.Len(), c.Domains())
+		}
+	})
+
+	t.Run("EnforcesLimit", func(t *testing.T) {
+		c := NewDomainCollector(2)
+		if err := c.AddAll([]string{"a.com", "b.com", "a.com"}); err != nil {
+			t.Fatalf("Duplicates should not count toward the limit: %v", err)
+		}
+		if err := c.Add("c.com"); err == nil {
+			t.Error("Expected error when exceeding domain limit")
+		}
+	})
+
+	t.Run("MergesWholeListsOnly", func(t *testing.T) {
+		c := NewDomainCollector(3)
+		c.Add("a.com")
+		list := NewDomainCollector(10)
+		list.AddAll([]string{"a.com", "b.com"})
+		if err := c.Merge(list); err != nil || c.Len() != 2 || c.Sources()["b.com"] != "" {
+			t.Fatalf("Merge = %v, domains %v", err, c.Domains())
+		}
+
+		tooMany := NewDomainCollector(10)
+		add := tooMany.AddFrom("https://lists.example.com/ads.txt")
+		add("c.com")
+		add("d.com")
+		if err := c.Merge(tooMany); err == nil {
+			t.Error("Expected error when a merge exceeds the domain limit")
+		}
+		if c.Len() != 2 {
+			t.Errorf("Failed merge added domains: %v", c.Domains())
+		}
+	})
+}
@@ -0,0 +1,70 @@
+package rules
+
+import (
+	"bytes"
+	"crypto/ed25519"
+	"encoding/base64"
+	"fmt"
+	"strconv"
+	"strings"
+)
+
+// SignatureSuffix is appended to the key of a rules file to find its
+// detached signature, e.g. groups/engineering.yaml.sig
+const SignatureSuffix = ".sig"
+
+// signaturePayload is what a detached signature covers: the store key and
+// serial of a file as well as its exact bytes, so a signed file can't be
+// copied over another key or replaced by an older version
+func signaturePayload(objectKey string, serial uint64, content []byte) []byte {
+	var buf bytes.Buffer
+	fmt.Fprintf(&buf, "dnshield-rules\nkey %s\nserial %d\n", objectKey, serial)
+	buf.Write(content)
+	return buf.Bytes()
+}
+
+// SignRules returns the detached signature of the file stored at
+// objectKey: a "serial" line, then the base64 Ed25519 signature of the
+// key, serial and content. Serials must grow with each version of a file.
+func SignRules(objectKey string, serial uint64, content []byte, key ed25519.PrivateKey) []byte {
+	signature := base64.StdEncoding.EncodeToString(ed25519.Sign(key, signaturePayload(objectKey, serial, content)))
+	return []byte(fmt.Sprintf("serial %d\n%s\n", serial, signature))
+}
+
+// VerifyRules checks a detached signature of the file stored at objectKey
+// against each of keys. It returns the serial the file was signed with if
+// any of them signed it.
+func VerifyRules(objectKey string, content, signature []byte, keys []ed25519.PublicKey) (uint64, error) {
+	lines := strings.Fields(string(signature))
+	if len(lines) != 3 || lines[0] != "serial" {
+		return 0, fmt.Errorf("malformed rules signature")
+	}
+	serial, err := strconv.ParseUint(lines[1], 10, 64)
+	if err != nil {
+		return 0, fmt.Errorf("malformed rules signature serial")
+	}
+	decoded, err := base64.StdEncoding.DecodeString(lines[2])
+	if err != nil || len(decoded) != ed25519.SignatureSize {
+		return 0, fmt.Errorf("malformed rules signature")
+	}
+	payload := signaturePayload(objectKey, serial, content)
+	for _, key := range keys {
+		if ed25519.Verify(key, payload, decoded) {
+			return serial, nil
+		}
+	}
+	return 0, fmt.Errorf("rules signature verification failed")
+}
+
+// ParseSigningKeys decodes base64 Ed25519 public keys
+func ParseSigningKeys(encoded []string) ([]ed25519.PublicKey, error) {
+	keys := make([]ed25519.PublicKey, 0, len(encoded))
+	for _, e := range encoded {
+		key, err := base64.StdEncoding.DecodeString(e)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid rules signing key %q", e)
+		}
+		keys = append(keys, ed25519.PublicKey(key))
+	}
+	return keys, nil
+}
@@ -0,0 +1,48 @@
+package rules
+
+import (
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"testing"
+)
+
+func TestVerifyRules(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	otherPub, _, _ := ed25519.GenerateKey(rand.Reader)
+
+	content := []byte("whitelist:\n  - \"company-analytics.com\"\n")
+	signature := SignRules("groups/engineering.yaml", 42, content, priv)
+
+	if serial, err := VerifyRules("groups/engineering.yaml", content, signature, []ed25519.PublicKey{otherPub, pub}); err != nil || serial != 42 {
+		t.Errorf("VerifyRules() = %d, %v, want 42", serial, err)
+	}
+	if _, err := VerifyRules("groups/engineering.yaml", content, signature, []ed25519.PublicKey{otherPub}); err == nil {
+		t.Error("rules verified with the wrong key")
+	}
+	tampered := append([]byte("whitelist:\n  - \"evil.example\"\n"), content[10:]...)
+	if _, err := VerifyRules("groups/engineering.yaml", tampered, signature, []ed25519.PublicKey{pub}); err == nil {
+		t.Error("modified rules verified")
+	}
+	if _, err := VerifyRules("groups/sales.yaml", content, signature, []ed25519.PublicKey{pub}); err == nil {
+		t.Error("rules verified under another key")
+	}
+	reserialed := append([]byte("serial 43\n"), signature[len("serial 42\n"):]...)
+	if _, err := VerifyRules("groups/engineering.yaml", content, reserialed, []ed25519.PublicKey{pub}); err == nil {
+		t.Error("rules verified with a changed serial")
+	}
+	if _, err := VerifyRules("groups/engineering.yaml", content, []byte("not a signature"), []ed25519.PublicKey{pub}); err == nil {
+		t.Error("malformed signature verified")
+	}
+
+	keys, err := ParseSigningKeys([]string{base64.StdEncoding.EncodeToString(pub)})
+	if err != nil || len(keys) != 1 || !keys[0].Equal(pub) {
+		t.Errorf("ParseSigningKeys() = %v, %v", keys, err)
+	}
+	if _, err := ParseSigningKeys([]string{"c2hvcnQ="}); err == nil {
+		t.Error("ParseSigningKeys accepted a short key")
+	}
+}
@@ -0,0 +1,108 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net/url"
+	"path/filepath"
+	"strings"
+
+	"dnshield/internal/config"
+)
+
+var (
+	// ErrNotModified is returned by RuleStore.Fetch when the object still
+	// has the ETag passed in
+	ErrNotModified = errors.New("not modified")
+	// ErrListNotSupported is returned by stores that can't list objects
+	ErrListNotSupported = errors.New("listing objects is not supported")
+)
+
+// ObjectInfo describes an object returned by RuleStore.List
+type ObjectInfo struct {
+	Key  string
+	ETag string
+	Size int64
+}
+
+// RuleStore is where the enterprise rules layout (base, groups, users,
+// block page) is kept
+type RuleStore interface {
+	// Fetch downloads key and returns it with its ETag. If etag is not
+	// empty and still current, it returns ErrNotModified instead.
+	Fetch(ctx context.Context, key, etag string) ([]byte, string, error)
+	// Put writes content to key
+	Put(ctx context.Context, key string, content []byte, contentType string) error
+	// List returns the objects under prefix
+	List(ctx context.Context, prefix string) ([]ObjectInfo, error)
+}
+
+// NewRuleStore opens the store cfg points at: s3.url when set, otherwise
+// s3.bucket in S3
+func NewRuleStore(cfg *config.S3Config) (RuleStore, error) {
+	if cfg.URL == "" {
+		return newS3Store(cfg, cfg.Bucket)
+	}
+
+	u, err := url.Parse(cfg.URL)
+	if err != nil {
+		return nil, fmt.Errorf("invalid s3.url: %v", err)
+	}
+	switch u.Scheme {
+	case "s3":
+		return newS3Store(cfg, u.Host)
+	case "gs":
+		return newGCSStore(u.Host)
+	case "azblob":
+		return newAzureStore(u.Host, strings.Trim(u.Path, "/"))
+	case "https":
+		return newHTTPSStore(u), nil
+	case "git+https", "git+ssh":
+		return newGitStore(u)
+	case "file":
+		// rules.localDir
+		return dirStore{filepath.FromSlash(u.Path)}, nil
+	default:
+		return nil, fmt.Errorf("unsupported rules store %q (use s3, gs, azblob, https, git+https or git+ssh)", u.Scheme)
+	}
+}
+
+// StoreURL describes the store cfg points at, for messages
+func StoreURL(cfg *config.S3Config) string {
+	if cfg.URL != "" {
+		return cfg.URL
+	}
+	return "s3://" + cfg.Bucket
+}
+
+// StoreHosts returns the hosts the agent contacts to reach the store cfg
+// points at
+func StoreHosts(cfg *config.S3Config) []string {
+	bucket := cfg.Bucket
+	if cfg.URL != "" {
+		u, err := url.Parse(cfg.URL)
+		if err != nil {
+			return nil
+		}
+		switch u.Scheme {
+		case "s3":
+			bucket = u.Host
+		case "gs":
+			return []string{gcsHost, "oauth2.googleapis.com"}
+		case "azblob":
+			return []string{u.Host + azureHostSuffix}
+		case "file":
+			return nil
+		default:
+			return []string{u.Hostname()}
+		}
+	}
+	if bucket == "" {
+		return nil
+	}
+	return []string{
+		fmt.Sprintf("%s.s3.%s.amazonaws.com", bucket, cfg.Region),
+		fmt.Sprintf("s3.%s.amazonaws.com", cfg.Region),
+	}
+}
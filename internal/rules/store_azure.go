@@ -0,0 +1,101 @@
+package rules
+
+import (
+	"context"
+	"encoding/xml"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+)
+
+const (
+	azureHostSuffix = ".blob.core.windows.net"
+	// AzureSASTokenEnv is the shared access signature for azblob:// rules
+	// stores, e.g. "sv=2022-11-02&ss=b&srt=co&sp=rl&sig=..."
+	AzureSASTokenEnv = "AZURE_STORAGE_SAS_TOKEN"
+	azureAPIVersion  = "2021-08-06"
+)
+
+// newAzureStore keeps rules in an Azure Blob Storage container. Requests
+// carry the SAS token from AZURE_STORAGE_SAS_TOKEN, if set.
+func newAzureStore(account, container string) (*httpStore, error) {
+	if account == "" || container == "" || strings.Contains(container, "/") {
+		return nil, fmt.Errorf("azblob:// rules store must be azblob://<account>/<container>")
+	}
+	base := "https://" + account + azureHostSuffix + "/" + container
+	sas := strings.TrimPrefix(os.Getenv(AzureSASTokenEnv), "?")
+
+	s := &httpStore{
+		client: newHTTPClient(),
+		objectURL: func(key string) string {
+			return withQuery(base+"/"+escapeKey(key), sas)
+		},
+		authorize: func(req *http.Request) error {
+			req.Header.Set("x-ms-version", azureAPIVersion)
+			return nil
+		},
+		putHeader: http.Header{"X-Ms-Blob-Type": {"BlockBlob"}},
+	}
+	s.list = func(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+		return listAzure(ctx, s, base, sas, prefix)
+	}
+	return s, nil
+}
+
+// listAzure lists blobs with the List Blobs operation
+func listAzure(ctx context.Context, s *httpStore, base, sas, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	marker := ""
+	for {
+		q := url.Values{"restype": {"container"}, "comp": {"list"}, "prefix": {prefix}}
+		if marker != "" {
+			q.Set("marker", marker)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, withQuery(base+"?"+q.Encode(), sas), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Blobs []struct {
+				Name       string `xml:"Name"`
+				Properties struct {
+					ETag string `xml:"Etag"`
+					Size int64  `xml:"Content-Length"`
+				} `xml:"Properties"`
+			} `xml:"Blobs>Blob"`
+			NextMarker string `xml:"NextMarker"`
+		}
+		err = xml.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("list %s: %s", prefix, resp.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, blob := range page.Blobs {
+			objects = append(objects, ObjectInfo{Key: blob.Name, ETag: blob.Properties.ETag, Size: blob.Properties.Size})
+		}
+		if page.NextMarker == "" {
+			return objects, nil
+		}
+		marker = page.NextMarker
+	}
+}
+
+// withQuery appends query parameters to rawURL
+func withQuery(rawURL, query string) string {
+	if query == "" {
+		return rawURL
+	}
+	if strings.Contains(rawURL, "?") {
+		return rawURL + "&" + query
+	}
+	return rawURL + "?" + query
+}
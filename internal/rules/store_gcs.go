@@ -0,0 +1,210 @@
+package rules
+
+import (
+	"context"
+	"crypto"
+	"crypto/rand"
+	"crypto/rsa"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/base64"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+)
+
+const (
+	gcsHost = "storage.googleapis.com"
+	// gcsCredentialsEnv names a service account key file, as for Google's
+	// own tools
+	gcsCredentialsEnv = "GOOGLE_APPLICATION_CREDENTIALS"
+	gcsScope          = "https://www.googleapis.com/auth/devstorage.read_write"
+)
+
+// newGCSStore keeps rules in a Google Cloud Storage bucket. Requests are
+// authorized with the service account key in GOOGLE_APPLICATION_CREDENTIALS
+// if set, and are anonymous otherwise.
+func newGCSStore(bucket string) (*httpStore, error) {
+	if bucket == "" {
+		return nil, fmt.Errorf("gs:// rules store needs a bucket")
+	}
+	s := &httpStore{
+		client: newHTTPClient(),
+		objectURL: func(key string) string {
+			return "https://" + gcsHost + "/" + bucket + "/" + escapeKey(key)
+		},
+	}
+	s.list = func(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+		return listGCS(ctx, s, bucket, prefix)
+	}
+
+	if path := os.Getenv(gcsCredentialsEnv); path != "" {
+		tokens, err := newGCSTokenSource(path, s.client)
+		if err != nil {
+			return nil, err
+		}
+		s.authorize = func(req *http.Request) error {
+			token, err := tokens.token(req.Context())
+			if err != nil {
+				return err
+			}
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}
+	}
+	return s, nil
+}
+
+// listGCS lists objects with the JSON API
+func listGCS(ctx context.Context, s *httpStore, bucket, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	pageToken := ""
+	for {
+		q := url.Values{"prefix": {prefix}}
+		if pageToken != "" {
+			q.Set("pageToken", pageToken)
+		}
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet,
+			"https://"+gcsHost+"/storage/v1/b/"+url.PathEscape(bucket)+"/o?"+q.Encode(), nil)
+		if err != nil {
+			return nil, err
+		}
+		resp, err := s.do(req)
+		if err != nil {
+			return nil, err
+		}
+		var page struct {
+			Items []struct {
+				Name string `json:"name"`
+				ETag string `json:"etag"`
+				Size string `json:"size"`
+			} `json:"items"`
+			NextPageToken string `json:"nextPageToken"`
+		}
+		err = json.NewDecoder(resp.Body).Decode(&page)
+		resp.Body.Close()
+		if resp.StatusCode != http.StatusOK {
+			return nil, fmt.Errorf("list %s: %s", prefix, resp.Status)
+		}
+		if err != nil {
+			return nil, err
+		}
+		for _, item := range page.Items {
+			size, _ := strconv.ParseInt(item.Size, 10, 64)
+			objects = append(objects, ObjectInfo{Key: item.Name, ETag: item.ETag, Size: size})
+		}
+		if page.NextPageToken == "" {
+			return objects, nil
+		}
+		pageToken = page.NextPageToken
+	}
+}
+
+// gcsTokenSource exchanges a service account key for access tokens with
+// the OAuth 2.0 JWT bearer flow, reusing each token until shortly before
+// it expires
+type gcsTokenSource struct {
+	email    string
+	key      *rsa.PrivateKey
+	tokenURI string
+	client   *http.Client
+
+	mu      sync.Mutex
+	current string
+	expires time.Time
+}
+
+func newGCSTokenSource(path string, client *http.Client) (*gcsTokenSource, error) {
+	data, err := os.ReadFile(path)
+	if err != nil {
+		return nil, fmt.Errorf("failed to read GCS credentials: %v", err)
+	}
+	var sa struct {
+		ClientEmail string `json:"client_email"`
+		PrivateKey  string `json:"private_key"`
+		TokenURI    string `json:"token_uri"`
+	}
+	if err := json.Unmarshal(data, &sa); err != nil || sa.ClientEmail == "" {
+		return nil, fmt.Errorf("invalid GCS service account key %s", path)
+	}
+	block, _ := pem.Decode([]byte(sa.PrivateKey))
+	if block == nil {
+		return nil, fmt.Errorf("invalid private key in %s", path)
+	}
+	parsed, err := x509.ParsePKCS8PrivateKey(block.Bytes)
+	key, ok := parsed.(*rsa.PrivateKey)
+	if err != nil || !ok {
+		return nil, fmt.Errorf("invalid private key in %s", path)
+	}
+	if sa.TokenURI == "" {
+		sa.TokenURI = "https://oauth2.googleapis.com/token"
+	}
+	return &gcsTokenSource{email: sa.ClientEmail, key: key, tokenURI: sa.TokenURI, client: client}, nil
+}
+
+func (t *gcsTokenSource) token(ctx context.Context) (string, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.current != "" && time.Now().Before(t.expires) {
+		return t.current, nil
+	}
+
+	assertion, err := t.assertion(time.Now())
+	if err != nil {
+		return "", err
+	}
+	form := url.Values{
+		"grant_type": {"urn:ietf:params:oauth:grant-type:jwt-bearer"},
+		"assertion":  {assertion},
+	}
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, t.tokenURI, strings.NewReader(form.Encode()))
+	if err != nil {
+		return "", err
+	}
+	req.Header.Set("Content-Type", "application/x-www-form-urlencoded")
+	resp, err := t.client.Do(req)
+	if err != nil {
+		return "", fmt.Errorf("GCS token request failed: %v", err)
+	}
+	defer resp.Body.Close()
+
+	var result struct {
+		AccessToken string `json:"access_token"`
+		ExpiresIn   int    `json:"expires_in"`
+	}
+	if err := json.NewDecoder(resp.Body).Decode(&result); err != nil || resp.StatusCode != http.StatusOK || result.AccessToken == "" {
+		return "", fmt.Errorf("GCS token request failed: %s", resp.Status)
+	}
+	t.current = result.AccessToken
+	t.expires = time.Now().Add(time.Duration(result.ExpiresIn)*time.Second - time.Minute)
+	return t.current, nil
+}
+
+// assertion returns a signed JWT asking for a storage token
+func (t *gcsTokenSource) assertion(now time.Time) (string, error) {
+	header := base64.RawURLEncoding.EncodeToString([]byte(`{"alg":"RS256","typ":"JWT"}`))
+	claims, err := json.Marshal(map[string]interface{}{
+		"iss":   t.email,
+		"scope": gcsScope,
+		"aud":   t.tokenURI,
+		"iat":   now.Unix(),
+		"exp":   now.Add(time.Hour).Unix(),
+	})
+	if err != nil {
+		return "", err
+	}
+	unsigned := header + "." + base64.RawURLEncoding.EncodeToString(claims)
+	sum := sha256.Sum256([]byte(unsigned))
+	signature, err := rsa.SignPKCS1v15(rand.Reader, t.key, crypto.SHA256, sum[:])
+	if err != nil {
+		return "", err
+	}
+	return unsigned + "." + base64.RawURLEncoding.EncodeToString(signature), nil
+}
@@ -0,0 +1,198 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/base64"
+	"fmt"
+	"net/url"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// GitSSHKeyEnv is the deploy key used for git+ssh:// rules stores
+	GitSSHKeyEnv = "DNSHIELD_GIT_SSH_KEY"
+
+	// gitPullInterval stops the several files of one rules update from
+	// each pulling the repository
+	gitPullInterval = 30 * time.Second
+)
+
+// gitStore keeps rules in a Git repository, giving them review and
+// history. The branch is cloned shallowly and pulled before files are
+// read from the checkout; if a pull fails the existing checkout is used.
+type gitStore struct {
+	remote string // URL as git understands it
+	branch string // Empty for the remote's default branch
+	dir    string
+	env    []string
+
+	mu       sync.Mutex
+	pulledAt time.Time
+	commit   string
+}
+
+// newGitStore parses a git+https:// or git+ssh:// URL. A fragment selects
+// the branch, e.g. git+ssh://git@github.com/corp/dns-rules.git#main.
+func newGitStore(u *url.URL) (*gitStore, error) {
+	remote := *u
+	remote.Scheme = strings.TrimPrefix(u.Scheme, "git+")
+	remote.Fragment = ""
+	if remote.Host == "" || remote.User.String() != "" && remote.Scheme == "https" {
+		return nil, fmt.Errorf("invalid git rules store %q (put tokens in %s, not the URL)", u.Redacted(), RulesTokenEnv)
+	}
+
+	dir, err := defaultGitDir()
+	if err != nil {
+		return nil, err
+	}
+	s := &gitStore{remote: remote.String(), branch: u.Fragment, dir: dir}
+
+	if key := os.Getenv(GitSSHKeyEnv); key != "" && remote.Scheme == "ssh" {
+		s.env = append(s.env, "GIT_SSH_COMMAND=ssh -i "+shellQuote(key)+" -o IdentitiesOnly=yes -o StrictHostKeyChecking=accept-new")
+	}
+	if token := os.Getenv(RulesTokenEnv); token != "" && remote.Scheme == "https" {
+		// Passed in the environment so it doesn't show up in ps
+		basic := base64.StdEncoding.EncodeToString([]byte("x-access-token:" + token))
+		s.env = append(s.env,
+			"GIT_CONFIG_COUNT=1",
+			"GIT_CONFIG_KEY_0=http.extraHeader",
+			"GIT_CONFIG_VALUE_0=Authorization: Basic "+basic)
+	}
+	return s, nil
+}
+
+// defaultGitDir returns ~/.dnshield/rules-git
+func defaultGitDir() (string, error) {
+	home, err := os.UserHomeDir()
+	if err != nil {
+		return "", err
+	}
+	return filepath.Join(home, ".dnshield", "rules-git"), nil
+}
+
+func (s *gitStore) Fetch(ctx context.Context, key, etag string) ([]byte, string, error) {
+	if err := s.sync(ctx); err != nil {
+		return nil, "", err
+	}
+	return dirStore{s.dir}.Fetch(ctx, key, etag)
+}
+
+func (s *gitStore) Put(ctx context.Context, key string, content []byte, contentType string) error {
+	return fmt.Errorf("git rules stores are read-only")
+}
+
+func (s *gitStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	if err := s.sync(ctx); err != nil {
+		return nil, err
+	}
+	return dirStore{s.dir}.List(ctx, prefix)
+}
+
+// sync clones the repository, or pulls it if the last pull is older than
+// gitPullInterval
+func (s *gitStore) sync(ctx context.Context) error {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if time.Since(s.pulledAt) < gitPullInterval {
+		return nil
+	}
+
+	var err error
+	if !s.checkedOut() || s.pulledAt.IsZero() && !s.sameRemote(ctx) {
+		err = s.clone(ctx)
+	} else {
+		err = s.pull(ctx)
+	}
+	if err != nil {
+		if s.checkedOut() {
+			logrus.WithError(err).Warn("Failed to update rules repository, using the existing checkout")
+			return nil
+		}
+		return err
+	}
+	s.pulledAt = time.Now()
+
+	commit, err := s.git(ctx, s.dir, "rev-parse", "HEAD")
+	if err == nil && commit != s.commit {
+		s.commit = commit
+		logrus.WithField("commit", commit).Info("Rules repository updated")
+	}
+	return nil
+}
+
+func (s *gitStore) clone(ctx context.Context) error {
+	if err := os.MkdirAll(filepath.Dir(s.dir), 0700); err != nil {
+		return err
+	}
+	// Clone beside the checkout so a failed clone leaves the old one usable
+	tmp := s.dir + ".new"
+	os.RemoveAll(tmp)
+	args := []string{"clone", "--depth", "1", "--single-branch"}
+	if s.branch != "" {
+		args = append(args, "--branch", s.branch)
+	}
+	if _, err := s.git(ctx, "", append(args, "--", s.remote, tmp)...); err != nil {
+		os.RemoveAll(tmp)
+		return err
+	}
+	os.RemoveAll(s.dir)
+	return os.Rename(tmp, s.dir)
+}
+
+func (s *gitStore) pull(ctx context.Context) error {
+	ref := "HEAD"
+	if s.branch != "" {
+		ref = s.branch
+	}
+	if _, err := s.git(ctx, s.dir, "fetch", "--depth", "1", "origin", ref); err != nil {
+		return err
+	}
+	if _, err := s.git(ctx, s.dir, "reset", "--hard", "FETCH_HEAD"); err != nil {
+		return err
+	}
+	_, err := s.git(ctx, s.dir, "clean", "-fdx")
+	return err
+}
+
+// sameRemote reports whether the checkout left by a previous run is of
+// the configured repository
+func (s *gitStore) sameRemote(ctx context.Context) bool {
+	origin, err := s.git(ctx, s.dir, "remote", "get-url", "origin")
+	return err == nil && origin == s.remote
+}
+
+func (s *gitStore) checkedOut() bool {
+	_, err := os.Stat(filepath.Join(s.dir, ".git"))
+	return err == nil
+}
+
+// git runs a git command in dir and returns its trimmed output
+func (s *gitStore) git(ctx context.Context, dir string, args ...string) (string, error) {
+	ctx, cancel := context.WithTimeout(ctx, 2*time.Minute)
+	defer cancel()
+
+	cmd := exec.CommandContext(ctx, "git", args...)
+	cmd.Dir = dir
+	cmd.Env = append(os.Environ(), "GIT_TERMINAL_PROMPT=0")
+	cmd.Env = append(cmd.Env, s.env...)
+	var stderr bytes.Buffer
+	cmd.Stderr = &stderr
+	out, err := cmd.Output()
+	if err != nil {
+		return "", fmt.Errorf("git %s: %v: %s", args[0], err, strings.TrimSpace(stderr.String()))
+	}
+	return strings.TrimSpace(string(out)), nil
+}
+
+// shellQuote quotes s for GIT_SSH_COMMAND, which git runs with a shell
+func shellQuote(s string) string {
+	return "'" + strings.ReplaceAll(s, "'", `'\''`) + "'"
+}
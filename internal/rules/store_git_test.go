@@ -0,0 +1,95 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+func TestGitStore(t *testing.T) {
+	if _, err := exec.LookPath("git"); err != nil {
+		t.Skip("git not installed")
+	}
+
+	origin := t.TempDir()
+	run := func(args ...string) {
+		t.Helper()
+		cmd := exec.Command("git", args...)
+		cmd.Dir = origin
+		cmd.Env = append(os.Environ(),
+			"GIT_AUTHOR_NAME=test", "GIT_AUTHOR_EMAIL=test@example.com",
+			"GIT_COMMITTER_NAME=test", "GIT_COMMITTER_EMAIL=test@example.com")
+		if out, err := cmd.CombinedOutput(); err != nil {
+			t.Fatalf("git %v: %v: %s", args, err, out)
+		}
+	}
+	write := func(name, content string) {
+		t.Helper()
+		file := filepath.Join(origin, name)
+		os.MkdirAll(filepath.Dir(file), 0755)
+		if err := os.WriteFile(file, []byte(content), 0644); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	run("init", "-b", "main")
+	write("base.yaml", "domains:\n  - ads.example.com\n")
+	write("groups/engineering.yaml", "domains:\n  - games.example.com\n")
+	run("add", "-A")
+	run("commit", "-m", "initial rules")
+
+	s := &gitStore{remote: origin, branch: "main", dir: filepath.Join(t.TempDir(), "checkout")}
+	ctx := context.Background()
+
+	content, etag, err := s.Fetch(ctx, "base.yaml", "")
+	if err != nil {
+		t.Fatalf("Fetch: %v", err)
+	}
+	if string(content) != "domains:\n  - ads.example.com\n" {
+		t.Errorf("content = %q", content)
+	}
+	if _, _, err := s.Fetch(ctx, "base.yaml", etag); !errors.Is(err, ErrNotModified) {
+		t.Errorf("Fetch with current ETag: err = %v, want ErrNotModified", err)
+	}
+
+	objects, err := s.List(ctx, "groups/")
+	if err != nil {
+		t.Fatalf("List: %v", err)
+	}
+	if len(objects) != 1 || objects[0].Key != "groups/engineering.yaml" {
+		t.Errorf("List = %+v", objects)
+	}
+
+	if _, _, err := s.Fetch(ctx, "../../etc/passwd", ""); err == nil {
+		t.Error("Fetch outside the checkout succeeded")
+	}
+	if _, _, err := s.Fetch(ctx, ".git/config", ""); err == nil {
+		t.Error("Fetch of .git/config succeeded")
+	}
+	if err := s.Put(ctx, "base.yaml", nil, "text/yaml"); err == nil {
+		t.Error("Put succeeded on a read-only store")
+	}
+
+	// A new commit is picked up on the next pull
+	write("base.yaml", "domains:\n  - tracker.example.com\n")
+	run("commit", "-am", "block tracker")
+	s.pulledAt = time.Time{}
+	content, _, err = s.Fetch(ctx, "base.yaml", etag)
+	if err != nil {
+		t.Fatalf("Fetch after commit: %v", err)
+	}
+	if string(content) != "domains:\n  - tracker.example.com\n" {
+		t.Errorf("content after commit = %q", content)
+	}
+
+	// An unreachable remote keeps serving the checkout
+	s.remote = filepath.Join(t.TempDir(), "missing")
+	s.pulledAt = time.Time{}
+	if _, _, err := s.Fetch(ctx, "base.yaml", ""); err != nil {
+		t.Errorf("Fetch with unreachable remote: %v", err)
+	}
+}
@@ -0,0 +1,126 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"net/http"
+	"net/url"
+	"os"
+	"strings"
+	"time"
+
+	"dnshield/internal/utils"
+)
+
+// RulesTokenEnv is a bearer token sent to https:// rules stores
+const RulesTokenEnv = "DNSHIELD_RULES_TOKEN"
+
+// httpStore reads and writes objects over HTTP(S) with conditional GETs.
+// The GCS, Azure and plain HTTPS stores differ only in how object URLs are
+// built, how requests are authorized, and how objects are listed.
+type httpStore struct {
+	client    *http.Client
+	objectURL func(key string) string
+	authorize func(req *http.Request) error // Nil for anonymous access
+	list      func(ctx context.Context, prefix string) ([]ObjectInfo, error)
+	putHeader http.Header // Extra headers on uploads
+}
+
+func newHTTPClient() *http.Client {
+	return &http.Client{Timeout: 60 * time.Second}
+}
+
+// newHTTPSStore serves objects relative to base, e.g. base.yaml from
+// https://rules.example.com/dnshield/base.yaml
+func newHTTPSStore(base *url.URL) *httpStore {
+	prefix := strings.TrimSuffix(base.String(), "/") + "/"
+	s := &httpStore{
+		client:    newHTTPClient(),
+		objectURL: func(key string) string { return prefix + escapeKey(key) },
+	}
+	if token := os.Getenv(RulesTokenEnv); token != "" {
+		s.authorize = func(req *http.Request) error {
+			req.Header.Set("Authorization", "Bearer "+token)
+			return nil
+		}
+	}
+	return s
+}
+
+func (s *httpStore) Fetch(ctx context.Context, key, etag string) ([]byte, string, error) {
+	req, err := http.NewRequestWithContext(ctx, http.MethodGet, s.objectURL(key), nil)
+	if err != nil {
+		return nil, "", err
+	}
+	if etag != "" {
+		req.Header.Set("If-None-Match", etag)
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	switch resp.StatusCode {
+	case http.StatusOK:
+	case http.StatusNotModified:
+		return nil, etag, ErrNotModified
+	default:
+		return nil, "", fmt.Errorf("GET %s: %s", key, resp.Status)
+	}
+	if resp.ContentLength > utils.MaxS3ObjectSize {
+		return nil, "", fmt.Errorf("object exceeds maximum size of %d bytes", utils.MaxS3ObjectSize)
+	}
+	content, err := utils.ReadAllLimited(resp.Body, utils.MaxS3ObjectSize)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, resp.Header.Get("ETag"), nil
+}
+
+func (s *httpStore) Put(ctx context.Context, key string, content []byte, contentType string) error {
+	req, err := http.NewRequestWithContext(ctx, http.MethodPut, s.objectURL(key), bytes.NewReader(content))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	for name, values := range s.putHeader {
+		req.Header[name] = values
+	}
+	resp, err := s.do(req)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("PUT %s: %s", key, resp.Status)
+	}
+	return nil
+}
+
+func (s *httpStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	if s.list == nil {
+		return nil, ErrListNotSupported
+	}
+	return s.list(ctx, prefix)
+}
+
+// do authorizes and sends req
+func (s *httpStore) do(req *http.Request) (*http.Response, error) {
+	if s.authorize != nil {
+		if err := s.authorize(req); err != nil {
+			return nil, err
+		}
+	}
+	return s.client.Do(req)
+}
+
+// escapeKey escapes each element of an object key for use in a URL path
+func escapeKey(key string) string {
+	elems := strings.Split(key, "/")
+	for i, elem := range elems {
+		elems[i] = url.PathEscape(elem)
+	}
+	return strings.Join(elems, "/")
+}
@@ -0,0 +1,212 @@
+package rules
+
+import (
+	"context"
+	"crypto/sha256"
+	"encoding/hex"
+	"fmt"
+	"io/fs"
+	"os"
+	"path"
+	"path/filepath"
+	"strings"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	"github.com/sirupsen/logrus"
+)
+
+// watchSettle is how long file notifications must be quiet before a change
+// is applied, as editors write a file in several steps
+const watchSettle = 100 * time.Millisecond
+
+// dirStore keeps rules as files in a local directory (rules.localDir),
+// and serves the checkout of a Git store
+type dirStore struct {
+	dir string
+}
+
+func (s dirStore) Fetch(ctx context.Context, key, etag string) ([]byte, string, error) {
+	file, err := s.path(key)
+	if err != nil {
+		return nil, "", err
+	}
+	content, err := os.ReadFile(file)
+	if err != nil {
+		return nil, "", err
+	}
+	current := contentETag(content)
+	if etag == current {
+		return nil, etag, ErrNotModified
+	}
+	return content, current, nil
+}
+
+func (s dirStore) Put(ctx context.Context, key string, content []byte, contentType string) error {
+	file, err := s.path(key)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(file), 0755); err != nil {
+		return err
+	}
+	return os.WriteFile(file, content, 0644)
+}
+
+func (s dirStore) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	root, err := s.path(prefix)
+	if err != nil {
+		return nil, err
+	}
+
+	var objects []ObjectInfo
+	err = filepath.WalkDir(root, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			if os.IsNotExist(err) {
+				return nil
+			}
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		content, err := os.ReadFile(file)
+		if err != nil {
+			return err
+		}
+		rel, _ := filepath.Rel(s.dir, file)
+		objects = append(objects, ObjectInfo{
+			Key:  filepath.ToSlash(rel),
+			ETag: contentETag(content),
+			Size: int64(len(content)),
+		})
+		return nil
+	})
+	return objects, err
+}
+
+// path maps a key to a file in the directory, refusing keys outside it
+func (s dirStore) path(key string) (string, error) {
+	clean := path.Clean("/" + key)
+	if clean == "/.git" || strings.HasPrefix(clean, "/.git/") {
+		return "", fmt.Errorf("invalid rules key %q", key)
+	}
+	return filepath.Join(s.dir, filepath.FromSlash(clean)), nil
+}
+
+// contentETag stands in for an ETag on stores that don't have one
+func contentETag(content []byte) string {
+	sum := sha256.Sum256(content)
+	return `"` + hex.EncodeToString(sum[:16]) + `"`
+}
+
+// WatchDir calls onChange whenever a file under dir is added, removed or
+// modified, until ctx is done. Changes are noticed through file system
+// notifications, and the directory is also polled every interval for
+// changes notifications miss, such as on network mounts.
+func WatchDir(ctx context.Context, dir string, interval time.Duration, onChange func()) {
+	last, err := dirFingerprint(dir)
+	if err != nil {
+		logrus.WithError(err).WithField("dir", dir).Warn("Failed to read local rules directory")
+	}
+
+	var events <-chan fsnotify.Event
+	var errs <-chan error
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		logrus.WithError(err).WithField("dir", dir).Warn("File notifications unavailable, polling local rules directory")
+	} else {
+		defer watcher.Close()
+		watchTree(watcher, dir)
+		events, errs = watcher.Events, watcher.Errors
+	}
+
+	check := func() {
+		current, err := dirFingerprint(dir)
+		if err != nil || current == last {
+			return
+		}
+		last = current
+		logrus.WithField("dir", dir).Info("Local rules changed")
+		onChange()
+	}
+
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	var settle <-chan time.Time
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case event, ok := <-events:
+			if !ok {
+				events = nil
+				continue
+			}
+			// fsnotify doesn't watch subdirectories by itself
+			if event.Has(fsnotify.Create) {
+				if info, err := os.Stat(event.Name); err == nil && info.IsDir() {
+					watchTree(watcher, event.Name)
+				}
+			}
+			settle = time.After(watchSettle)
+		case err, ok := <-errs:
+			if !ok {
+				errs = nil
+				continue
+			}
+			logrus.WithError(err).WithField("dir", dir).Debug("Local rules directory notification error")
+		case <-settle:
+			settle = nil
+			check()
+		case <-ticker.C:
+			check()
+		}
+	}
+}
+
+// watchTree adds dir and every directory under it to watcher
+func watchTree(watcher *fsnotify.Watcher, dir string) {
+	filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil || !d.IsDir() {
+			return nil
+		}
+		if d.Name() == ".git" {
+			return filepath.SkipDir
+		}
+		if err := watcher.Add(file); err != nil {
+			logrus.WithError(err).WithField("dir", file).Warn("Failed to watch local rules directory")
+		}
+		return nil
+	})
+}
+
+// dirFingerprint hashes the names, sizes and modification times of the
+// files under dir
+func dirFingerprint(dir string) (string, error) {
+	h := sha256.New()
+	err := filepath.WalkDir(dir, func(file string, d fs.DirEntry, err error) error {
+		if err != nil {
+			return err
+		}
+		if d.IsDir() {
+			if d.Name() == ".git" {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		info, err := d.Info()
+		if err != nil {
+			return err
+		}
+		fmt.Fprintf(h, "%s\x00%d\x00%d\n", file, info.Size(), info.ModTime().UnixNano())
+		return nil
+	})
+	if err != nil {
+		return "", err
+	}
+	return hex.EncodeToString(h.Sum(nil)), nil
+}
@@ -0,0 +1,104 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+func TestDirStore(t *testing.T) {
+	dir := t.TempDir()
+	store, err := NewRuleStore(&config.S3Config{URL: config.LocalRulesURL(dir)})
+	if err != nil {
+		t.Fatalf("NewRuleStore: %v", err)
+	}
+	ctx := context.Background()
+
+	if err := store.Put(ctx, "groups/engineering.yaml", []byte("domains: []\n"), "text/yaml"); err != nil {
+		t.Fatalf("Put: %v", err)
+	}
+	content, etag, err := store.Fetch(ctx, "groups/engineering.yaml", "")
+	if err != nil || string(content) != "domains: []\n" {
+		t.Fatalf("Fetch = %q, %v", content, err)
+	}
+	if _, _, err := store.Fetch(ctx, "groups/engineering.yaml", etag); !errors.Is(err, ErrNotModified) {
+		t.Errorf("Fetch with current ETag: err = %v, want ErrNotModified", err)
+	}
+	if _, _, err := store.Fetch(ctx, "base.yaml", ""); err == nil {
+		t.Error("Fetch of a missing file succeeded")
+	}
+	if _, _, err := store.Fetch(ctx, "../outside.yaml", ""); err == nil {
+		t.Error("Fetch outside the directory succeeded")
+	}
+
+	objects, err := store.List(ctx, "groups/")
+	if err != nil || len(objects) != 1 || objects[0].Key != "groups/engineering.yaml" {
+		t.Errorf("List = %+v, %v", objects, err)
+	}
+}
+
+func TestWatchDir(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "base.yaml"), []byte("domains: []\n"), 0644)
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 4)
+	go WatchDir(ctx, dir, 10*time.Millisecond, func() { changed <- struct{}{} })
+
+	select {
+	case <-changed:
+		t.Fatal("change reported before any file changed")
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	os.MkdirAll(filepath.Join(dir, "groups"), 0755)
+	os.WriteFile(filepath.Join(dir, "groups", "sales.yaml"), []byte("domains:\n  - games.example.com\n"), 0644)
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("new file not reported")
+	}
+}
+
+func TestWatchDirNotifications(t *testing.T) {
+	dir := t.TempDir()
+	os.WriteFile(filepath.Join(dir, "base.yaml"), []byte("domains: []\n"), 0644)
+
+	// Polling is too slow to notice anything during the test
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	changed := make(chan struct{}, 4)
+	go WatchDir(ctx, dir, time.Hour, func() { changed <- struct{}{} })
+	time.Sleep(50 * time.Millisecond)
+
+	os.WriteFile(filepath.Join(dir, "base.yaml"), []byte("domains:\n  - ads.example.com\n"), 0644)
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("edited file not reported")
+	}
+
+	// Directories created later are watched too
+	os.MkdirAll(filepath.Join(dir, "groups"), 0755)
+	time.Sleep(50 * time.Millisecond)
+	drain := time.After(2 * watchSettle)
+	for draining := true; draining; {
+		select {
+		case <-changed:
+		case <-drain:
+			draining = false
+		}
+	}
+	os.WriteFile(filepath.Join(dir, "groups", "sales.yaml"), []byte("domains:\n  - games.example.com\n"), 0644)
+	select {
+	case <-changed:
+	case <-time.After(2 * time.Second):
+		t.Fatal("file in a new directory not reported")
+	}
+}
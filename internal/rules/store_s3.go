@@ -0,0 +1,134 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/utils"
+
+	"github.com/aws/aws-sdk-go-v2/aws"
+	awsconfig "github.com/aws/aws-sdk-go-v2/config"
+	"github.com/aws/aws-sdk-go-v2/credentials"
+	"github.com/aws/aws-sdk-go-v2/service/s3"
+	"github.com/sirupsen/logrus"
+)
+
+// s3Store keeps rules in an S3 bucket
+type s3Store struct {
+	client *s3.Client
+	bucket string
+}
+
+func newS3Store(cfg *config.S3Config, bucket string) (*s3Store, error) {
+	// Configure AWS SDK with timeout for faster failure on non-EC2 systems
+	ctx, cancel := context.WithTimeout(context.Background(), 5*time.Second)
+	defer cancel()
+
+	// Get credentials securely
+	creds, err := config.GetAWSCredentials(cfg)
+	if err != nil {
+		return nil, fmt.Errorf("failed to get AWS credentials: %v", err)
+	}
+
+	var awsCfg aws.Config
+
+	// Configure based on credential source
+	switch creds.Source {
+	case config.CredentialSourceEnvironment, config.CredentialSourceConfig:
+		// Use explicit credentials (from env or config)
+		awsCfg, err = awsconfig.LoadDefaultConfig(ctx,
+			awsconfig.WithRegion(cfg.Region),
+			awsconfig.WithCredentialsProvider(credentials.NewStaticCredentialsProvider(
+				creds.AccessKeyID,
+				creds.SecretAccessKey,
+				"",
+			)),
+		)
+	default:
+		// Use default credential chain (IAM role, etc.)
+		// Use context timeout to avoid long waits on non-EC2 systems
+		awsCfg, err = awsconfig.LoadDefaultConfig(ctx,
+			awsconfig.WithRegion(cfg.Region),
+		)
+	}
+
+	if err != nil {
+		return nil, fmt.Errorf("failed to load AWS config: %v", err)
+	}
+
+	// Log credential source for transparency
+	logrus.Infof("Using AWS credentials from: %s", creds.Source)
+
+	return &s3Store{client: s3.NewFromConfig(awsCfg), bucket: bucket}, nil
+}
+
+func (s *s3Store) Fetch(ctx context.Context, key, etag string) ([]byte, string, error) {
+	// First, do a HEAD request to check ETag
+	headResp, err := s.client.HeadObject(ctx, &s3.HeadObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+
+	// If ETag matches cached version, skip download
+	currentETag := aws.ToString(headResp.ETag)
+	if etag != "" && etag == currentETag {
+		return nil, etag, ErrNotModified
+	}
+
+	resp, err := s.client.GetObject(ctx, &s3.GetObjectInput{
+		Bucket: aws.String(s.bucket),
+		Key:    aws.String(key),
+	})
+	if err != nil {
+		return nil, "", err
+	}
+	defer resp.Body.Close()
+
+	// Check content length
+	if aws.ToInt64(resp.ContentLength) > utils.MaxS3ObjectSize {
+		return nil, "", fmt.Errorf("S3 object exceeds maximum size of %d bytes", utils.MaxS3ObjectSize)
+	}
+	content, err := utils.ReadAllLimited(resp.Body, utils.MaxS3ObjectSize)
+	if err != nil {
+		return nil, "", err
+	}
+	return content, aws.ToString(resp.ETag), nil
+}
+
+func (s *s3Store) Put(ctx context.Context, key string, content []byte, contentType string) error {
+	_, err := s.client.PutObject(ctx, &s3.PutObjectInput{
+		Bucket:      aws.String(s.bucket),
+		Key:         aws.String(key),
+		Body:        bytes.NewReader(content),
+		ContentType: aws.String(contentType),
+	})
+	return err
+}
+
+func (s *s3Store) List(ctx context.Context, prefix string) ([]ObjectInfo, error) {
+	var objects []ObjectInfo
+	paginator := s3.NewListObjectsV2Paginator(s.client, &s3.ListObjectsV2Input{
+		Bucket: aws.String(s.bucket),
+		Prefix: aws.String(prefix),
+	})
+	for paginator.HasMorePages() {
+		page, err := paginator.NextPage(ctx)
+		if err != nil {
+			return nil, err
+		}
+		for _, obj := range page.Contents {
+			objects = append(objects, ObjectInfo{
+				Key:  aws.ToString(obj.Key),
+				ETag: aws.ToString(obj.ETag),
+				Size: aws.ToInt64(obj.Size),
+			})
+		}
+	}
+	return objects, nil
+}
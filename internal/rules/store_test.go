@@ -0,0 +1,111 @@
+package rules
+
+import (
+	"context"
+	"errors"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"reflect"
+	"testing"
+
+	"dnshield/internal/config"
+)
+
+func TestHTTPSStore(t *testing.T) {
+	objects := map[string][]byte{"/rules/groups/eng%20team.yaml": []byte("domains: []\n")}
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		switch r.Method {
+		case http.MethodGet:
+			content, ok := objects[r.URL.EscapedPath()]
+			if !ok {
+				http.NotFound(w, r)
+				return
+			}
+			if r.Header.Get("If-None-Match") == `"v1"` {
+				w.WriteHeader(http.StatusNotModified)
+				return
+			}
+			w.Header().Set("ETag", `"v1"`)
+			w.Write(content)
+		case http.MethodPut:
+			objects[r.URL.EscapedPath()], _ = io.ReadAll(r.Body)
+			w.WriteHeader(http.StatusCreated)
+		}
+	}))
+	defer srv.Close()
+
+	base, _ := url.Parse(srv.URL + "/rules/")
+	store := newHTTPSStore(base)
+	store.client = srv.Client()
+	ctx := context.Background()
+
+	content, etag, err := store.Fetch(ctx, "groups/eng team.yaml", "")
+	if err != nil || string(content) != "domains: []\n" || etag != `"v1"` {
+		t.Fatalf("Fetch() = %q, %q, %v", content, etag, err)
+	}
+	if _, _, err := store.Fetch(ctx, "groups/eng team.yaml", etag); !errors.Is(err, ErrNotModified) {
+		t.Errorf("Fetch() with current ETag error = %v, want ErrNotModified", err)
+	}
+	if _, _, err := store.Fetch(ctx, "missing.yaml", ""); err == nil {
+		t.Error("Fetch() of a missing object succeeded")
+	}
+
+	if err := store.Put(ctx, "requests/1.json", []byte("{}"), "application/json"); err != nil {
+		t.Fatalf("Put() error = %v", err)
+	}
+	if string(objects["/rules/requests/1.json"]) != "{}" {
+		t.Error("Put() didn't upload the object")
+	}
+	if _, err := store.List(ctx, "blockpage/"); !errors.Is(err, ErrListNotSupported) {
+		t.Errorf("List() error = %v, want ErrListNotSupported", err)
+	}
+}
+
+func TestListAzure(t *testing.T) {
+	srv := httptest.NewTLSServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		q := r.URL.Query()
+		if q.Get("comp") != "list" || q.Get("prefix") != "blockpage/" || q.Get("sig") != "secret" {
+			http.Error(w, "bad request", http.StatusBadRequest)
+			return
+		}
+		if q.Get("marker") == "" {
+			io.WriteString(w, `<?xml version="1.0" encoding="utf-8"?>
+<EnumerationResults><Blobs><Blob><Name>blockpage/index.html</Name><Properties><Etag>0x1</Etag><Content-Length>120</Content-Length></Properties></Blob></Blobs><NextMarker>page2</NextMarker></EnumerationResults>`)
+			return
+		}
+		io.WriteString(w, `<EnumerationResults><Blobs><Blob><Name>blockpage/logo.png</Name><Properties><Etag>0x2</Etag><Content-Length>3</Content-Length></Properties></Blob></Blobs><NextMarker/></EnumerationResults>`)
+	}))
+	defer srv.Close()
+
+	store := &httpStore{client: srv.Client()}
+	objects, err := listAzure(context.Background(), store, srv.URL+"/rules", "sig=secret", "blockpage/")
+	if err != nil {
+		t.Fatalf("listAzure() error = %v", err)
+	}
+	want := []ObjectInfo{
+		{Key: "blockpage/index.html", ETag: "0x1", Size: 120},
+		{Key: "blockpage/logo.png", ETag: "0x2", Size: 3},
+	}
+	if !reflect.DeepEqual(objects, want) {
+		t.Errorf("listAzure() = %+v, want %+v", objects, want)
+	}
+}
+
+func TestNewRuleStore(t *testing.T) {
+	for _, rawURL := range []string{"ftp://rules.example.com/", "azblob://account", "gs://"} {
+		if _, err := NewRuleStore(&config.S3Config{URL: rawURL}); err == nil {
+			t.Errorf("NewRuleStore(%q) succeeded", rawURL)
+		}
+	}
+
+	hosts := StoreHosts(&config.S3Config{URL: "azblob://corpstorage/dnshield"})
+	if !reflect.DeepEqual(hosts, []string{"corpstorage.blob.core.windows.net"}) {
+		t.Errorf("StoreHosts(azblob) = %v", hosts)
+	}
+	hosts = StoreHosts(&config.S3Config{Bucket: "corp-rules", Region: "us-east-1"})
+	if len(hosts) != 2 || hosts[0] != "corp-rules.s3.us-east-1.amazonaws.com" {
+		t.Errorf("StoreHosts(s3) = %v", hosts)
+	}
+}
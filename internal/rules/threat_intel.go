@@ -0,0 +1,278 @@
+package rules
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"regexp"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/utils"
+
+	"github.com/sirupsen/logrus"
+)
+
+// SourceThreatIntelPrefix prefixes the block source of threat-intel
+// indicators, followed by the feed name
+const SourceThreatIntelPrefix = "threat-intel:"
+
+const (
+	// maxTAXIIPages bounds the pages fetched from a TAXII collection per poll
+	maxTAXIIPages  = 100
+	taxiiMediaType = "application/taxii+json;version=2.1"
+)
+
+// stixDomainPattern extracts domains from STIX patterns such as
+// [domain-name:value = 'evil.example'] OR [domain-name:value = 'c2.example']
+var stixDomainPattern = regexp.MustCompile(`domain-name:value\s*=\s*'((?:[^'\\]|\\.)*)'`)
+
+// ThreatIntelFetcher polls STIX/TAXII 2.1 collections and MISP instances
+// for domain indicators
+type ThreatIntelFetcher struct {
+	feeds      []config.ThreatIntelFeedConfig
+	httpClient *http.Client
+	maxDomains int
+
+	mu   sync.Mutex
+	last map[string][]string // Feed name -> domains from its last successful poll
+}
+
+// NewThreatIntelFetcher creates a fetcher for the configured feeds,
+// accepting at most maxDomains indicators from each
+func NewThreatIntelFetcher(cfg *config.ThreatIntelConfig, maxDomains int) *ThreatIntelFetcher {
+	return &ThreatIntelFetcher{
+		feeds: cfg.Feeds,
+		httpClient: &http.Client{
+			Timeout: 60 * time.Second,
+		},
+		maxDomains: maxDomains,
+		last:       make(map[string][]string),
+	}
+}
+
+// Fetch polls every feed and returns the indicator domains of all of them,
+// mapped to their block source. A feed that can't be polled keeps the
+// domains from its last successful poll.
+func (f *ThreatIntelFetcher) Fetch(ctx context.Context) map[string]string {
+	f.mu.Lock()
+	defer f.mu.Unlock()
+
+	for _, feed := range f.feeds {
+		var domains []string
+		var err error
+		switch feed.Type {
+		case "taxii":
+			domains, err = f.fetchTAXII(ctx, feed)
+		case "misp":
+			domains, err = f.fetchMISP(ctx, feed)
+		default:
+			err = fmt.Errorf("unsupported feed type %q", feed.Type)
+		}
+		if err != nil {
+			logrus.WithError(err).WithFields(logrus.Fields{
+				"feed":   feed.Name,
+				"cached": len(f.last[feed.Name]),
+			}).Warn("Failed to poll threat-intel feed")
+			continue
+		}
+		f.last[feed.Name] = domains
+		logrus.WithFields(logrus.Fields{
+			"feed":    feed.Name,
+			"domains": len(domains),
+		}).Debug("Polled threat-intel feed")
+	}
+
+	sources := make(map[string]string)
+	for _, feed := range f.feeds {
+		for _, domain := range f.last[feed.Name] {
+			if _, dup := sources[domain]; !dup {
+				sources[domain] = SourceThreatIntelPrefix + feed.Name
+			}
+		}
+	}
+	return sources
+}
+
+// taxiiEnvelope is a page of objects from a TAXII 2.1 collection
+type taxiiEnvelope struct {
+	More    bool   `json:"more"`
+	Next    string `json:"next"`
+	Objects []struct {
+		Type        string `json:"type"`
+		Pattern     string `json:"pattern"`
+		PatternType string `json:"pattern_type"`
+		Revoked     bool   `json:"revoked"`
+		ValidUntil  string `json:"valid_until"`
+	} `json:"objects"`
+}
+
+// fetchTAXII pages through the indicators of a TAXII 2.1 collection
+func (f *ThreatIntelFetcher) fetchTAXII(ctx context.Context, feed config.ThreatIntelFeedConfig) ([]string, error) {
+	endpoint, err := url.Parse(strings.TrimSuffix(feed.URL, "/") + "/collections/" + url.PathEscape(feed.Collection) + "/objects/")
+	if err != nil {
+		return nil, err
+	}
+	query := endpoint.Query()
+	query.Set("match[type]", "indicator")
+	if feed.MaxAge > 0 {
+		query.Set("added_after", time.Now().Add(-feed.MaxAge).UTC().Format(time.RFC3339))
+	}
+
+	collector := newIndicatorCollector(f.maxDomains)
+	now := time.Now()
+	for page := 0; page < maxTAXIIPages; page++ {
+		endpoint.RawQuery = query.Encode()
+		req, err := http.NewRequestWithContext(ctx, http.MethodGet, endpoint.String(), nil)
+		if err != nil {
+			return nil, err
+		}
+		req.Header.Set("Accept", taxiiMediaType)
+		if feed.Username != "" {
+			req.SetBasicAuth(feed.Username, feedToken(feed))
+		}
+
+		var envelope taxiiEnvelope
+		if err := f.doJSON(req, &envelope); err != nil {
+			return nil, err
+		}
+		for _, obj := range envelope.Objects {
+			if obj.Type != "indicator" || obj.Revoked || (obj.PatternType != "" && obj.PatternType != "stix") {
+				continue
+			}
+			if until, err := time.Parse(time.RFC3339, obj.ValidUntil); err == nil && until.Before(now) {
+				continue
+			}
+			for _, m := range stixDomainPattern.FindAllStringSubmatch(obj.Pattern, -1) {
+				if err := collector.add(strings.ReplaceAll(m[1], `\'`, `'`)); err != nil {
+					return nil, err
+				}
+			}
+		}
+
+		if !envelope.More || envelope.Next == "" {
+			return collector.domains, nil
+		}
+		query.Set("next", envelope.Next)
+	}
+	return nil, fmt.Errorf("collection has more than %d pages", maxTAXIIPages)
+}
+
+// mispResponse is the result of a MISP attribute search
+type mispResponse struct {
+	Response struct {
+		Attribute []struct {
+			Type  string `json:"type"`
+			Value string `json:"value"`
+		} `json:"Attribute"`
+	} `json:"response"`
+}
+
+// fetchMISP searches a MISP instance for domain attributes flagged for
+// detection
+func (f *ThreatIntelFetcher) fetchMISP(ctx context.Context, feed config.ThreatIntelFeedConfig) ([]string, error) {
+	search := map[string]interface{}{
+		"returnFormat": "json",
+		"type":         []string{"domain", "hostname", "domain|ip"},
+		"to_ids":       1,
+		"deleted":      0,
+	}
+	if feed.MaxAge > 0 {
+		search["timestamp"] = fmt.Sprintf("%dm", int(feed.MaxAge.Minutes()))
+	}
+	body, err := json.Marshal(search)
+	if err != nil {
+		return nil, err
+	}
+
+	req, err := http.NewRequestWithContext(ctx, http.MethodPost, strings.TrimSuffix(feed.URL, "/")+"/attributes/restSearch", bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	req.Header.Set("Accept", "application/json")
+	req.Header.Set("Content-Type", "application/json")
+	req.Header.Set("Authorization", feedToken(feed))
+
+	var resp mispResponse
+	if err := f.doJSON(req, &resp); err != nil {
+		return nil, err
+	}
+
+	collector := newIndicatorCollector(f.maxDomains)
+	for _, attr := range resp.Response.Attribute {
+		value := attr.Value
+		if attr.Type == "domain|ip" {
+			value, _, _ = strings.Cut(value, "|")
+		}
+		if err := collector.add(value); err != nil {
+			return nil, err
+		}
+	}
+	return collector.domains, nil
+}
+
+// doJSON sends req and decodes the JSON response into v
+func (f *ThreatIntelFetcher) doJSON(req *http.Request, v interface{}) error {
+	resp, err := f.httpClient.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("%s returned HTTP %d", req.URL.Host, resp.StatusCode)
+	}
+	data, err := utils.ReadAllLimited(resp.Body, utils.MaxRulesFileSize)
+	if err != nil {
+		return err
+	}
+	return json.Unmarshal(data, v)
+}
+
+// feedToken returns the feed's credential, preferring its environment
+// variable
+func feedToken(feed config.ThreatIntelFeedConfig) string {
+	if feed.TokenEnv != "" {
+		if token := os.Getenv(feed.TokenEnv); token != "" {
+			return token
+		}
+	}
+	return feed.Token
+}
+
+// indicatorCollector deduplicates indicator domains up to a limit
+type indicatorCollector struct {
+	seen    map[string]bool
+	domains []string
+	max     int
+}
+
+func newIndicatorCollector(max int) *indicatorCollector {
+	return &indicatorCollector{seen: make(map[string]bool), max: max}
+}
+
+// add normalizes and stores a domain indicator. Values that aren't domain
+// names, such as IP addresses, are skipped.
+func (c *indicatorCollector) add(value string) error {
+	domain := strings.TrimSuffix(strings.ToLower(strings.TrimSpace(value)), ".")
+	if !strings.Contains(domain, ".") || strings.ContainsAny(domain, " \t/:*^|$@") ||
+		net.ParseIP(domain) != nil || utils.ValidateDomainLength(domain) != nil {
+		return nil
+	}
+	if c.seen[domain] {
+		return nil
+	}
+	if len(c.domains) >= c.max {
+		return fmt.Errorf("feed domain count exceeds maximum of %d", c.max)
+	}
+	c.seen[domain] = true
+	c.domains = append(c.domains, domain)
+	return nil
+}
@@ -0,0 +1,82 @@
+package rules
+
+import (
+	"context"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"sync/atomic"
+	"testing"
+
+	"dnshield/internal/config"
+)
+
+func TestThreatIntelFetch(t *testing.T) {
+	var mispDown atomic.Bool
+	mux := http.NewServeMux()
+	mux.HandleFunc("/taxii/collections/c1/objects/", func(w http.ResponseWriter, r *http.Request) {
+		if r.URL.Query().Get("match[type]") != "indicator" {
+			t.Errorf("TAXII query = %s, want indicators only", r.URL.RawQuery)
+		}
+		if user, pass, _ := r.BasicAuth(); user != "dnshield" || pass != "secret" {
+			t.Errorf("TAXII auth = %s/%s", user, pass)
+		}
+		w.Header().Set("Content-Type", taxiiMediaType)
+		if r.URL.Query().Get("next") == "" {
+			json.NewEncoder(w).Encode(map[string]interface{}{
+				"more": true,
+				"next": "page2",
+				"objects": []map[string]interface{}{
+					{"type": "indicator", "pattern_type": "stix", "pattern": "[domain-name:value = 'C2.Example.net'] OR [domain-name:value = 'drop.example.org']"},
+					{"type": "indicator", "pattern": "[domain-name:value = 'revoked.example.com']", "revoked": true},
+					{"type": "indicator", "pattern": "[domain-name:value = 'expired.example.com']", "valid_until": "2000-01-01T00:00:00Z"},
+				},
+			})
+			return
+		}
+		json.NewEncoder(w).Encode(map[string]interface{}{
+			"objects": []map[string]interface{}{
+				{"type": "indicator", "pattern": "[ipv4-addr:value = '192.0.2.1'] OR [domain-name:value = 'page2.example.com']"},
+			},
+		})
+	})
+	mux.HandleFunc("/misp/attributes/restSearch", func(w http.ResponseWriter, r *http.Request) {
+		if mispDown.Load() {
+			http.Error(w, "unavailable", http.StatusServiceUnavailable)
+			return
+		}
+		if r.Header.Get("Authorization") != "misp-key" {
+			t.Errorf("MISP Authorization = %q", r.Header.Get("Authorization"))
+		}
+		w.Write([]byte(`{"response": {"Attribute": [
+			{"type": "domain", "value": "phish.example.com"},
+			{"type": "domain|ip", "value": "c2.example.net|192.0.2.2"},
+			{"type": "hostname", "value": "192.0.2.3"}
+		]}}`))
+	})
+	server := httptest.NewServer(mux)
+	defer server.Close()
+
+	t.Setenv("TEST_TAXII_PASSWORD", "secret")
+	fetcher := NewThreatIntelFetcher(&config.ThreatIntelConfig{Feeds: []config.ThreatIntelFeedConfig{
+		{Name: "isac", Type: "taxii", URL: server.URL + "/taxii/", Collection: "c1", Username: "dnshield", TokenEnv: "TEST_TAXII_PASSWORD"},
+		{Name: "misp", Type: "misp", URL: server.URL + "/misp", Token: "misp-key"},
+	}}, 100)
+
+	want := map[string]string{
+		"c2.example.net":    "threat-intel:isac",
+		"drop.example.org":  "threat-intel:isac",
+		"page2.example.com": "threat-intel:isac",
+		"phish.example.com": "threat-intel:misp",
+	}
+	if got := fetcher.Fetch(context.Background()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Fetch() = %v, want %v", got, want)
+	}
+
+	// A feed that fails keeps its last domains
+	mispDown.Store(true)
+	if got := fetcher.Fetch(context.Background()); !reflect.DeepEqual(got, want) {
+		t.Errorf("Fetch() with MISP down = %v, want %v", got, want)
+	}
+}
@@ -0,0 +1,242 @@
+// Package scim receives users and groups from an identity provider over
+// SCIM 2.0 and renders them as the user groups and device mapping files
+package scim
+
+import (
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"fmt"
+	"os"
+	"path/filepath"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+// Schema URNs
+const (
+	UserSchema          = "urn:ietf:params:scim:schemas:core:2.0:User"
+	GroupSchema         = "urn:ietf:params:scim:schemas:core:2.0:Group"
+	DeviceSchema        = "urn:ietf:params:scim:schemas:extension:dnshield:2.0:User"
+	ListResponseSchema  = "urn:ietf:params:scim:api:messages:2.0:ListResponse"
+	PatchOpSchema       = "urn:ietf:params:scim:api:messages:2.0:PatchOp"
+	ErrorSchema         = "urn:ietf:params:scim:api:messages:2.0:Error"
+	ServiceConfigSchema = "urn:ietf:params:scim:schemas:core:2.0:ServiceProviderConfig"
+)
+
+// User is a SCIM user. Devices come from the DNShield extension, which
+// the identity provider maps from a profile attribute such as the serial
+// numbers of the user's Macs.
+type User struct {
+	Schemas     []string               `json:"schemas"`
+	ID          string                 `json:"id"`
+	ExternalID  string                 `json:"externalId,omitempty"`
+	UserName    string                 `json:"userName"`
+	DisplayName string                 `json:"displayName,omitempty"`
+	Name        map[string]interface{} `json:"name,omitempty"`
+	Active      bool                   `json:"active"`
+	Emails      []Email                `json:"emails,omitempty"`
+	Devices     *DeviceExtension       `json:"urn:ietf:params:scim:schemas:extension:dnshield:2.0:User,omitempty"`
+	Meta        Meta                   `json:"meta"`
+}
+
+// Email is one of a user's email addresses
+type Email struct {
+	Value   string `json:"value"`
+	Type    string `json:"type,omitempty"`
+	Primary bool   `json:"primary,omitempty"`
+}
+
+// DeviceExtension lists the devices of a user
+type DeviceExtension struct {
+	Devices []string `json:"devices,omitempty"`
+}
+
+// Group is a SCIM group
+type Group struct {
+	Schemas     []string `json:"schemas"`
+	ID          string   `json:"id"`
+	ExternalID  string   `json:"externalId,omitempty"`
+	DisplayName string   `json:"displayName"`
+	Members     []Member `json:"members"`
+	Meta        Meta     `json:"meta"`
+}
+
+// Member is a user in a group
+type Member struct {
+	Value   string `json:"value"`
+	Display string `json:"display,omitempty"`
+}
+
+// Meta is the metadata of a resource
+type Meta struct {
+	ResourceType string    `json:"resourceType"`
+	Created      time.Time `json:"created"`
+	LastModified time.Time `json:"lastModified"`
+	Location     string    `json:"location,omitempty"`
+}
+
+// Email returns the address the user is assigned rules by: the primary
+// email, the first email, or the user name
+func (u *User) Email() string {
+	for _, e := range u.Emails {
+		if e.Primary {
+			return strings.ToLower(e.Value)
+		}
+	}
+	if len(u.Emails) > 0 {
+		return strings.ToLower(u.Emails[0].Value)
+	}
+	return strings.ToLower(u.UserName)
+}
+
+var unsafeGroupChars = regexp.MustCompile(`[^a-z0-9_-]+`)
+
+// GroupName returns the DNShield group name of a SCIM group: its display
+// name in lower case with other characters than letters, digits, - and _
+// replaced by -
+func GroupName(displayName string) string {
+	return strings.Trim(unsafeGroupChars.ReplaceAllString(strings.ToLower(displayName), "-"), "-")
+}
+
+// Directory holds the users and groups pushed by the identity provider,
+// saved to a state file after each change
+type Directory struct {
+	path string
+
+	mu       sync.Mutex
+	users    map[string]*User
+	groups   map[string]*Group
+	onChange func()
+}
+
+type directoryState struct {
+	Users  map[string]*User  `json:"users"`
+	Groups map[string]*Group `json:"groups"`
+}
+
+// OpenDirectory loads the directory saved at path, or an empty one
+func OpenDirectory(path string) (*Directory, error) {
+	d := &Directory{path: path, users: make(map[string]*User), groups: make(map[string]*Group)}
+	data, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return d, nil
+	} else if err != nil {
+		return nil, err
+	}
+	var state directoryState
+	if err := json.Unmarshal(data, &state); err != nil {
+		return nil, fmt.Errorf("invalid SCIM state %s: %v", path, err)
+	}
+	if state.Users != nil {
+		d.users = state.Users
+	}
+	if state.Groups != nil {
+		d.groups = state.Groups
+	}
+	return d, nil
+}
+
+// OnChange calls fn after each change to the directory
+func (d *Directory) OnChange(fn func()) {
+	d.mu.Lock()
+	d.onChange = fn
+	d.mu.Unlock()
+}
+
+// changed saves the directory and notifies the change callback. It is
+// called with d.mu held.
+func (d *Directory) changed() error {
+	data, err := json.Marshal(directoryState{Users: d.users, Groups: d.groups})
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(d.path), 0700); err != nil {
+		return err
+	}
+	tmp := d.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0600); err != nil {
+		return err
+	}
+	if err := os.Rename(tmp, d.path); err != nil {
+		return err
+	}
+	if d.onChange != nil {
+		go d.onChange()
+	}
+	return nil
+}
+
+// Render returns the user groups and device mapping files of the active
+// users. A user in several groups is assigned the first of them in order,
+// then the rest by name; groups missing from a non-empty order are left out.
+func (d *Directory) Render(order []string) (*config.UserGroups, *config.DeviceMapping) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+
+	rank := make(map[string]int)
+	for i, name := range order {
+		rank[GroupName(name)] = i
+	}
+	groups := make([]*Group, 0, len(d.groups))
+	for _, g := range d.groups {
+		if _, ok := rank[GroupName(g.DisplayName)]; ok || len(order) == 0 {
+			groups = append(groups, g)
+		}
+	}
+	sort.Slice(groups, func(i, j int) bool {
+		a, b := GroupName(groups[i].DisplayName), GroupName(groups[j].DisplayName)
+		if rank[a] != rank[b] {
+			return rank[a] < rank[b]
+		}
+		return a < b
+	})
+
+	userGroups := &config.UserGroups{
+		Version:          "1.0",
+		Description:      "Generated by dnshield scim from the identity provider; do not edit",
+		GroupAssignments: make(map[string][]string),
+		UserOverrides:    make(map[string]string),
+	}
+	assigned := make(map[string]bool)
+	for _, g := range groups {
+		name := GroupName(g.DisplayName)
+		if name == "" {
+			continue
+		}
+		for _, m := range g.Members {
+			u, ok := d.users[m.Value]
+			if !ok || !u.Active || assigned[u.ID] {
+				continue
+			}
+			assigned[u.ID] = true
+			userGroups.GroupAssignments[name] = append(userGroups.GroupAssignments[name], u.Email())
+		}
+		sort.Strings(userGroups.GroupAssignments[name])
+	}
+
+	mapping := &config.DeviceMapping{
+		Version:     "1.0",
+		Description: userGroups.Description,
+		Users:       make(map[string]config.UserDevices),
+	}
+	for _, u := range d.users {
+		if u.Active && u.Devices != nil && len(u.Devices.Devices) > 0 {
+			devices := append([]string(nil), u.Devices.Devices...)
+			sort.Strings(devices)
+			mapping.Users[u.Email()] = config.UserDevices{Devices: devices}
+		}
+	}
+	return userGroups, mapping
+}
+
+func newID() string {
+	b := make([]byte, 16)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
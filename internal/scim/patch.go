@@ -0,0 +1,223 @@
+package scim
+
+import (
+	"encoding/json"
+	"net/http"
+	"regexp"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// PatchOp is one operation of a SCIM PATCH request
+type PatchOp struct {
+	Op    string          `json:"op"`
+	Path  string          `json:"path,omitempty"`
+	Value json.RawMessage `json:"value,omitempty"`
+}
+
+// memberFilter matches a path selecting one group member, as Okta sends
+// when removing a user from a group
+var memberFilter = regexp.MustCompile(`^members\[value eq "([^"]+)"\]$`)
+
+// PatchUser applies ops to the user with id. Paths other than active,
+// userName, displayName, emails and the devices extension are ignored.
+func (d *Directory) PatchUser(id string, ops []PatchOp) (*User, *Error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	existing, ok := d.users[id]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "user %s not found", id)
+	}
+	u := *existing
+	if existing.Devices != nil {
+		devices := *existing.Devices
+		u.Devices = &devices
+	}
+	for _, op := range ops {
+		if err := patchUser(&u, op); err != nil {
+			return nil, err
+		}
+	}
+	u.Meta.LastModified = time.Now().UTC()
+	d.users[id] = &u
+	return &u, d.save()
+}
+
+func patchUser(u *User, op PatchOp) *Error {
+	kind := strings.ToLower(op.Op)
+	if kind != "add" && kind != "replace" && kind != "remove" {
+		return errorf(http.StatusBadRequest, "unsupported patch op %q", op.Op)
+	}
+	path := strings.ToLower(op.Path)
+	if path == "" {
+		// The value holds the attributes to set, such as {"active": false}
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attrs); err != nil {
+			return errorf(http.StatusBadRequest, "invalid patch value: %v", err)
+		}
+		for attr, value := range attrs {
+			if err := patchUser(u, PatchOp{Op: op.Op, Path: attr, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	switch {
+	case path == "active":
+		active, err := parseBool(op.Value)
+		if err != nil {
+			return err
+		}
+		u.Active = active
+	case path == "username":
+		return unmarshalValue(op.Value, &u.UserName)
+	case path == "displayname":
+		return unmarshalValue(op.Value, &u.DisplayName)
+	case path == "emails":
+		if kind == "remove" {
+			u.Emails = nil
+			return nil
+		}
+		return unmarshalValue(op.Value, &u.Emails)
+	case strings.HasPrefix(path, "emails[") && strings.HasSuffix(path, "].value"):
+		// Azure AD sends emails[type eq "work"].value
+		var value string
+		if err := unmarshalValue(op.Value, &value); err != nil {
+			return err
+		}
+		u.Emails = []Email{{Value: value, Type: "work", Primary: true}}
+	case path == strings.ToLower(DeviceSchema) || path == strings.ToLower(DeviceSchema)+":devices":
+		if u.Devices == nil {
+			u.Devices = &DeviceExtension{}
+		}
+		if kind == "remove" {
+			u.Devices.Devices = nil
+			return nil
+		}
+		if path == strings.ToLower(DeviceSchema) {
+			return unmarshalValue(op.Value, u.Devices)
+		}
+		return unmarshalValue(op.Value, &u.Devices.Devices)
+	}
+	return nil
+}
+
+// PatchGroup applies ops to the group with id. Members can be added,
+// replaced and removed, and the group renamed.
+func (d *Directory) PatchGroup(id string, ops []PatchOp) (*Group, *Error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	existing, ok := d.groups[id]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "group %s not found", id)
+	}
+	g := *existing
+	g.Members = append([]Member(nil), existing.Members...)
+	for _, op := range ops {
+		if err := patchGroup(&g, op); err != nil {
+			return nil, err
+		}
+	}
+	g.Meta.LastModified = time.Now().UTC()
+	d.groups[id] = &g
+	return &g, d.save()
+}
+
+func patchGroup(g *Group, op PatchOp) *Error {
+	kind := strings.ToLower(op.Op)
+	path := strings.ToLower(op.Path)
+	if path == "" {
+		var attrs map[string]json.RawMessage
+		if err := json.Unmarshal(op.Value, &attrs); err != nil {
+			return errorf(http.StatusBadRequest, "invalid patch value: %v", err)
+		}
+		for attr, value := range attrs {
+			if attr == "id" {
+				continue
+			}
+			if err := patchGroup(g, PatchOp{Op: op.Op, Path: attr, Value: value}); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+
+	if m := memberFilter.FindStringSubmatch(op.Path); m != nil && kind == "remove" {
+		g.Members = removeMembers(g.Members, map[string]bool{m[1]: true})
+		return nil
+	}
+	switch path {
+	case "displayname":
+		return unmarshalValue(op.Value, &g.DisplayName)
+	case "members":
+		var members []Member
+		if len(op.Value) > 0 {
+			if err := unmarshalValue(op.Value, &members); err != nil {
+				return err
+			}
+		}
+		switch kind {
+		case "add":
+			present := make(map[string]bool)
+			for _, m := range g.Members {
+				present[m.Value] = true
+			}
+			for _, m := range members {
+				if !present[m.Value] {
+					g.Members = append(g.Members, m)
+					present[m.Value] = true
+				}
+			}
+		case "replace":
+			g.Members = members
+		case "remove":
+			if len(members) == 0 {
+				g.Members = []Member{}
+				return nil
+			}
+			remove := make(map[string]bool)
+			for _, m := range members {
+				remove[m.Value] = true
+			}
+			g.Members = removeMembers(g.Members, remove)
+		default:
+			return errorf(http.StatusBadRequest, "unsupported patch op %q", op.Op)
+		}
+	}
+	return nil
+}
+
+func removeMembers(members []Member, remove map[string]bool) []Member {
+	kept := []Member{}
+	for _, m := range members {
+		if !remove[m.Value] {
+			kept = append(kept, m)
+		}
+	}
+	return kept
+}
+
+func unmarshalValue(value json.RawMessage, v interface{}) *Error {
+	if err := json.Unmarshal(value, v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid patch value: %v", err)
+	}
+	return nil
+}
+
+// parseBool reads a boolean sent as true or as the string "True", as
+// Azure AD does
+func parseBool(value json.RawMessage) (bool, *Error) {
+	var b bool
+	if json.Unmarshal(value, &b) == nil {
+		return b, nil
+	}
+	var s string
+	if json.Unmarshal(value, &s) == nil {
+		if parsed, err := strconv.ParseBool(s); err == nil {
+			return parsed, nil
+		}
+	}
+	return false, errorf(http.StatusBadRequest, "invalid boolean %s", value)
+}
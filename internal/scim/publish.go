@@ -0,0 +1,132 @@
+package scim
+
+import (
+	"bytes"
+	"context"
+	"crypto/ed25519"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/rules"
+
+	"github.com/sirupsen/logrus"
+	"gopkg.in/yaml.v3"
+)
+
+// PutFunc writes content to key in the rules store
+type PutFunc func(ctx context.Context, key string, content []byte, contentType string) error
+
+// Publisher writes the user groups and device mapping files to the rules
+// store whenever the directory changes, once changes stop for a while so
+// a full import is written once
+type Publisher struct {
+	dir   *Directory
+	put   PutFunc
+	paths config.S3Paths
+	order []string
+	delay time.Duration
+
+	changes   chan struct{}
+	published map[string][]byte
+
+	// Signs each file when set; serial is the last serial signed with
+	signingKey ed25519.PrivateKey
+	serial     uint64
+}
+
+// NewPublisher returns a publisher of dir's files to put. order lists the
+// groups to publish, in precedence order, or is empty for all groups.
+func NewPublisher(dir *Directory, put PutFunc, paths config.S3Paths, order []string, delay time.Duration) *Publisher {
+	p := &Publisher{
+		dir:       dir,
+		put:       put,
+		paths:     paths,
+		order:     order,
+		delay:     delay,
+		changes:   make(chan struct{}, 1),
+		published: make(map[string][]byte),
+	}
+	dir.OnChange(func() {
+		select {
+		case p.changes <- struct{}{}:
+		default:
+		}
+	})
+	return p
+}
+
+// SetSigningKey writes a detached signature with key before each file, for
+// devices that require signed files
+func (p *Publisher) SetSigningKey(key ed25519.PrivateKey) {
+	p.signingKey = key
+}
+
+// Run publishes the files now and after each burst of changes until ctx
+// is done
+func (p *Publisher) Run(ctx context.Context) {
+	if err := p.Publish(ctx); err != nil {
+		logrus.WithError(err).Error("Failed to publish SCIM directory")
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-p.changes:
+		}
+		// Wait for the changes to settle
+		timer := time.NewTimer(p.delay)
+	settle:
+		for {
+			select {
+			case <-ctx.Done():
+				timer.Stop()
+				return
+			case <-p.changes:
+				timer.Reset(p.delay)
+			case <-timer.C:
+				break settle
+			}
+		}
+		if err := p.Publish(ctx); err != nil {
+			logrus.WithError(err).Error("Failed to publish SCIM directory")
+		}
+	}
+}
+
+// Publish renders the directory and writes the files that changed since
+// they were last written
+func (p *Publisher) Publish(ctx context.Context) error {
+	userGroups, mapping := p.dir.Render(p.order)
+	files := []struct {
+		key string
+		v   interface{}
+	}{
+		{p.paths.UserGroups, userGroups},
+		{p.paths.DeviceMapping, mapping},
+	}
+	for _, f := range files {
+		content, err := yaml.Marshal(f.v)
+		if err != nil {
+			return err
+		}
+		if bytes.Equal(p.published[f.key], content) {
+			continue
+		}
+		if p.signingKey != nil {
+			p.serial++
+			if now := uint64(time.Now().Unix()); now > p.serial {
+				p.serial = now
+			}
+			signature := rules.SignRules(f.key, p.serial, content, p.signingKey)
+			if err := p.put(ctx, f.key+rules.SignatureSuffix, signature, "text/plain"); err != nil {
+				return err
+			}
+		}
+		if err := p.put(ctx, f.key, content, "application/x-yaml"); err != nil {
+			return err
+		}
+		p.published[f.key] = content
+		logrus.WithField("key", f.key).Info("Published SCIM directory")
+	}
+	return nil
+}
@@ -0,0 +1,185 @@
+package scim
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"path/filepath"
+	"strings"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/rules"
+
+	"gopkg.in/yaml.v3"
+)
+
+func TestSCIMDirectory(t *testing.T) {
+	state := filepath.Join(t.TempDir(), "scim-state.json")
+	dir, err := OpenDirectory(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	server := httptest.NewServer(NewServer(dir, "secret"))
+	defer server.Close()
+
+	do := func(method, path, body string, want int) map[string]interface{} {
+		t.Helper()
+		req, _ := http.NewRequest(method, server.URL+BasePath+path, strings.NewReader(body))
+		req.Header.Set("Authorization", "Bearer secret")
+		resp, err := http.DefaultClient.Do(req)
+		if err != nil {
+			t.Fatal(err)
+		}
+		defer resp.Body.Close()
+		if resp.StatusCode != want {
+			t.Fatalf("%s %s = %d, want %d", method, path, resp.StatusCode, want)
+		}
+		var result map[string]interface{}
+		json.NewDecoder(resp.Body).Decode(&result)
+		return result
+	}
+
+	req, _ := http.NewRequest(http.MethodGet, server.URL+BasePath+"/Users", nil)
+	req.Header.Set("Authorization", "Bearer wrong")
+	if resp, err := http.DefaultClient.Do(req); err != nil || resp.StatusCode != http.StatusUnauthorized {
+		t.Fatalf("wrong token: %v %v", resp.StatusCode, err)
+	}
+
+	alice := do("POST", "/Users", `{"userName":"alice@example.com","active":true,
+		"urn:ietf:params:scim:schemas:extension:dnshield:2.0:User":{"devices":["C02XK1ABJG5J"]}}`, http.StatusCreated)["id"].(string)
+	bob := do("POST", "/Users", `{"userName":"bob","active":true,"emails":[{"value":"Bob@Example.com","primary":true}]}`, http.StatusCreated)["id"].(string)
+	do("POST", "/Users", `{"userName":"ALICE@example.com"}`, http.StatusConflict)
+
+	found := do("GET", `/Users?filter=userName+eq+"alice@example.com"`, "", http.StatusOK)
+	if found["totalResults"].(float64) != 1 {
+		t.Errorf("filter found %v users, want 1", found["totalResults"])
+	}
+
+	eng := do("POST", "/Groups", `{"displayName":"Engineering Team","members":[{"value":"`+alice+`"}]}`, http.StatusCreated)["id"].(string)
+	sales := do("POST", "/Groups", `{"displayName":"Sales"}`, http.StatusCreated)["id"].(string)
+	do("PATCH", "/Groups/"+sales, `{"Operations":[{"op":"add","path":"members","value":[{"value":"`+alice+`"},{"value":"`+bob+`"}]}]}`, http.StatusOK)
+
+	// Engineering comes first, so alice is assigned to it
+	userGroups, mapping := dir.Render([]string{"Engineering Team", "Sales"})
+	if got := userGroups.GroupAssignments["engineering-team"]; len(got) != 1 || got[0] != "alice@example.com" {
+		t.Errorf("engineering-team = %v", got)
+	}
+	if got := userGroups.GroupAssignments["sales"]; len(got) != 1 || got[0] != "bob@example.com" {
+		t.Errorf("sales = %v", got)
+	}
+	if got := mapping.Users["alice@example.com"].Devices; len(got) != 1 || got[0] != "C02XK1ABJG5J" {
+		t.Errorf("alice's devices = %v", got)
+	}
+
+	// Okta removes a member by filter; Azure AD deactivates with a string
+	do("PATCH", "/Groups/"+eng, `{"Operations":[{"op":"remove","path":"members[value eq \"`+alice+`\"]"}]}`, http.StatusOK)
+	do("PATCH", "/Users/"+bob, `{"Operations":[{"op":"Replace","path":"active","value":"False"}]}`, http.StatusOK)
+	userGroups, _ = dir.Render(nil)
+	if got := userGroups.GroupAssignments["sales"]; len(got) != 1 || got[0] != "alice@example.com" {
+		t.Errorf("sales after changes = %v", got)
+	}
+
+	do("DELETE", "/Users/"+alice, "", http.StatusNoContent)
+	do("GET", "/Users/"+alice, "", http.StatusNotFound)
+
+	// The directory survives a restart
+	reopened, err := OpenDirectory(state)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if len(reopened.users) != 1 || len(reopened.groups) != 2 {
+		t.Errorf("reopened directory has %d users and %d groups", len(reopened.users), len(reopened.groups))
+	}
+}
+
+func TestPublisher(t *testing.T) {
+	dir, err := OpenDirectory(filepath.Join(t.TempDir(), "scim-state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	written := make(chan string, 10)
+	files := make(map[string][]byte)
+	put := func(ctx context.Context, key string, content []byte, contentType string) error {
+		files[key] = content
+		written <- key
+		return nil
+	}
+	paths := config.S3Paths{UserGroups: "users/user-groups.yaml", DeviceMapping: "users/device-mapping.yaml"}
+	publisher := NewPublisher(dir, put, paths, nil, 10*time.Millisecond)
+	if err := publisher.Publish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+	if len(written) != 2 {
+		t.Fatalf("first publish wrote %d files, want 2", len(written))
+	}
+	<-written
+	<-written
+
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go publisher.Run(ctx)
+	u, _ := dir.CreateUser(&User{UserName: "carol@example.com", Active: true, Devices: &DeviceExtension{Devices: []string{"carol-mbp"}}})
+	dir.CreateGroup(&Group{DisplayName: "support", Members: []Member{{Value: u.ID}}})
+
+	// Only the changed files are written again
+	seen := make(map[string]int)
+	timeout := time.After(2 * time.Second)
+	for len(seen) < 2 {
+		select {
+		case key := <-written:
+			seen[key]++
+		case <-timeout:
+			t.Fatalf("published %v", seen)
+		}
+	}
+	var userGroups config.UserGroups
+	if err := yaml.Unmarshal(files[paths.UserGroups], &userGroups); err != nil {
+		t.Fatal(err)
+	}
+	if got := userGroups.GroupAssignments["support"]; len(got) != 1 || got[0] != "carol@example.com" {
+		t.Errorf("published support = %v", got)
+	}
+}
+
+func TestPublisherSigns(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	dir, err := OpenDirectory(filepath.Join(t.TempDir(), "scim-state.json"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var order []string
+	files := make(map[string][]byte)
+	put := func(ctx context.Context, key string, content []byte, contentType string) error {
+		order = append(order, key)
+		files[key] = content
+		return nil
+	}
+	paths := config.S3Paths{UserGroups: "users/user-groups.yaml", DeviceMapping: "users/device-mapping.yaml"}
+	publisher := NewPublisher(dir, put, paths, nil, time.Millisecond)
+	publisher.SetSigningKey(priv)
+	if err := publisher.Publish(context.Background()); err != nil {
+		t.Fatal(err)
+	}
+
+	// Each signature is written before the file it signs
+	want := []string{
+		paths.UserGroups + rules.SignatureSuffix, paths.UserGroups,
+		paths.DeviceMapping + rules.SignatureSuffix, paths.DeviceMapping,
+	}
+	if strings.Join(order, " ") != strings.Join(want, " ") {
+		t.Errorf("published %v, want %v", order, want)
+	}
+	for _, key := range []string{paths.UserGroups, paths.DeviceMapping} {
+		if _, err := rules.VerifyRules(key, files[key], files[key+rules.SignatureSuffix], []ed25519.PublicKey{pub}); err != nil {
+			t.Errorf("%s: %v", key, err)
+		}
+	}
+}
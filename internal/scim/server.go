@@ -0,0 +1,344 @@
+package scim
+
+import (
+	"crypto/subtle"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// BasePath is where the SCIM endpoints are served
+const BasePath = "/scim/v2"
+
+// Server serves the SCIM Users and Groups endpoints for a directory,
+// authenticated with a bearer token
+type Server struct {
+	dir   *Directory
+	token string
+}
+
+// NewServer returns a SCIM server for dir
+func NewServer(dir *Directory, token string) *Server {
+	return &Server{dir: dir, token: token}
+}
+
+// Error is a SCIM error response
+type Error struct {
+	Status int
+	Detail string
+}
+
+func (e *Error) Error() string {
+	return e.Detail
+}
+
+func errorf(status int, format string, args ...interface{}) *Error {
+	return &Error{Status: status, Detail: fmt.Sprintf(format, args...)}
+}
+
+func (s *Server) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	auth := r.Header.Get("Authorization")
+	if !strings.HasPrefix(auth, "Bearer ") || subtle.ConstantTimeCompare([]byte(strings.TrimPrefix(auth, "Bearer ")), []byte(s.token)) != 1 {
+		writeError(w, errorf(http.StatusUnauthorized, "invalid bearer token"))
+		return
+	}
+
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, BasePath), "/")
+	resource, id, _ := strings.Cut(path, "/")
+	var result interface{}
+	var err *Error
+	status := http.StatusOK
+	switch {
+	case resource == "ServiceProviderConfig" && r.Method == http.MethodGet:
+		result = serviceProviderConfig()
+	case resource == "Users" || resource == "Groups":
+		result, status, err = s.handleResource(r, resource, id)
+	default:
+		err = errorf(http.StatusNotFound, "unknown endpoint %s", r.URL.Path)
+	}
+	if err != nil {
+		writeError(w, err)
+		return
+	}
+	if result == nil {
+		w.WriteHeader(status)
+		return
+	}
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(status)
+	json.NewEncoder(w).Encode(result)
+}
+
+func (s *Server) handleResource(r *http.Request, resource, id string) (interface{}, int, *Error) {
+	users := resource == "Users"
+	switch {
+	case r.Method == http.MethodGet && id == "":
+		return s.list(r, users)
+	case r.Method == http.MethodGet:
+		result, err := s.get(users, id)
+		return result, http.StatusOK, err
+	case r.Method == http.MethodPost && id == "":
+		if users {
+			var u User
+			if err := decode(r, &u); err != nil {
+				return nil, 0, err
+			}
+			result, err := s.dir.CreateUser(&u)
+			return result, http.StatusCreated, err
+		}
+		var g Group
+		if err := decode(r, &g); err != nil {
+			return nil, 0, err
+		}
+		result, err := s.dir.CreateGroup(&g)
+		return result, http.StatusCreated, err
+	case r.Method == http.MethodPut && id != "":
+		if users {
+			var u User
+			if err := decode(r, &u); err != nil {
+				return nil, 0, err
+			}
+			result, err := s.dir.ReplaceUser(id, &u)
+			return result, http.StatusOK, err
+		}
+		var g Group
+		if err := decode(r, &g); err != nil {
+			return nil, 0, err
+		}
+		result, err := s.dir.ReplaceGroup(id, &g)
+		return result, http.StatusOK, err
+	case r.Method == http.MethodPatch && id != "":
+		var patch struct {
+			Operations []PatchOp `json:"Operations"`
+		}
+		if err := decode(r, &patch); err != nil {
+			return nil, 0, err
+		}
+		if users {
+			result, err := s.dir.PatchUser(id, patch.Operations)
+			return result, http.StatusOK, err
+		}
+		result, err := s.dir.PatchGroup(id, patch.Operations)
+		return result, http.StatusOK, err
+	case r.Method == http.MethodDelete && id != "":
+		return nil, http.StatusNoContent, s.dir.Delete(users, id)
+	}
+	return nil, 0, errorf(http.StatusMethodNotAllowed, "%s not supported on %s", r.Method, r.URL.Path)
+}
+
+func (s *Server) get(users bool, id string) (interface{}, *Error) {
+	s.dir.mu.Lock()
+	defer s.dir.mu.Unlock()
+	if users {
+		if u, ok := s.dir.users[id]; ok {
+			return u, nil
+		}
+	} else if g, ok := s.dir.groups[id]; ok {
+		return g, nil
+	}
+	return nil, errorf(http.StatusNotFound, "resource %s not found", id)
+}
+
+// filterPattern matches the equality filters identity providers use to
+// look up a resource before creating it
+var filterPattern = regexp.MustCompile(`^(\w+)\s+eq\s+"((?:[^"\\]|\\.)*)"$`)
+
+func (s *Server) list(r *http.Request, users bool) (interface{}, int, *Error) {
+	var attr, value string
+	if filter := strings.TrimSpace(r.URL.Query().Get("filter")); filter != "" {
+		m := filterPattern.FindStringSubmatch(filter)
+		if m == nil {
+			return nil, 0, errorf(http.StatusBadRequest, "unsupported filter %q", filter)
+		}
+		attr, value = strings.ToLower(m[1]), strings.ReplaceAll(m[2], `\"`, `"`)
+	}
+
+	s.dir.mu.Lock()
+	var resources []interface{}
+	var ids []string
+	byID := make(map[string]interface{})
+	if users {
+		for id, u := range s.dir.users {
+			if matchesFilter(attr, value, map[string]string{"username": u.UserName, "externalid": u.ExternalID, "id": u.ID}) {
+				ids = append(ids, id)
+				byID[id] = u
+			}
+		}
+	} else {
+		for id, g := range s.dir.groups {
+			if matchesFilter(attr, value, map[string]string{"displayname": g.DisplayName, "externalid": g.ExternalID, "id": g.ID}) {
+				ids = append(ids, id)
+				byID[id] = g
+			}
+		}
+	}
+	s.dir.mu.Unlock()
+
+	sort.Strings(ids)
+	start, _ := strconv.Atoi(r.URL.Query().Get("startIndex"))
+	if start < 1 {
+		start = 1
+	}
+	count, err := strconv.Atoi(r.URL.Query().Get("count"))
+	if err != nil || count < 0 {
+		count = len(ids)
+	}
+	for i := start - 1; i < len(ids) && len(resources) < count; i++ {
+		resources = append(resources, byID[ids[i]])
+	}
+	if resources == nil {
+		resources = []interface{}{}
+	}
+	return map[string]interface{}{
+		"schemas":      []string{ListResponseSchema},
+		"totalResults": len(ids),
+		"startIndex":   start,
+		"itemsPerPage": len(resources),
+		"Resources":    resources,
+	}, http.StatusOK, nil
+}
+
+func matchesFilter(attr, value string, fields map[string]string) bool {
+	if attr == "" {
+		return true
+	}
+	return strings.EqualFold(fields[attr], value)
+}
+
+func decode(r *http.Request, v interface{}) *Error {
+	if err := json.NewDecoder(http.MaxBytesReader(nil, r.Body, 1<<20)).Decode(v); err != nil {
+		return errorf(http.StatusBadRequest, "invalid request body: %v", err)
+	}
+	return nil
+}
+
+func writeError(w http.ResponseWriter, err *Error) {
+	w.Header().Set("Content-Type", "application/scim+json")
+	w.WriteHeader(err.Status)
+	json.NewEncoder(w).Encode(map[string]interface{}{
+		"schemas": []string{ErrorSchema},
+		"status":  strconv.Itoa(err.Status),
+		"detail":  err.Detail,
+	})
+}
+
+func serviceProviderConfig() interface{} {
+	supported := func(ok bool) map[string]bool { return map[string]bool{"supported": ok} }
+	return map[string]interface{}{
+		"schemas":               []string{ServiceConfigSchema},
+		"patch":                 supported(true),
+		"bulk":                  map[string]interface{}{"supported": false, "maxOperations": 0, "maxPayloadSize": 0},
+		"filter":                map[string]interface{}{"supported": true, "maxResults": 1000},
+		"changePassword":        supported(false),
+		"sort":                  supported(false),
+		"etag":                  supported(false),
+		"authenticationSchemes": []map[string]string{{"type": "oauthbearertoken", "name": "Bearer token"}},
+	}
+}
+
+// CreateUser adds u, rejecting a user name that is taken
+func (d *Directory) CreateUser(u *User) (*User, *Error) {
+	if u.UserName == "" {
+		return nil, errorf(http.StatusBadRequest, "userName is required")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, existing := range d.users {
+		if strings.EqualFold(existing.UserName, u.UserName) {
+			return nil, errorf(http.StatusConflict, "user %s already exists", u.UserName)
+		}
+	}
+	now := time.Now().UTC()
+	u.ID = newID()
+	u.Schemas = []string{UserSchema, DeviceSchema}
+	u.Meta = Meta{ResourceType: "User", Created: now, LastModified: now, Location: BasePath + "/Users/" + u.ID}
+	d.users[u.ID] = u
+	return u, d.save()
+}
+
+// ReplaceUser replaces the user with id by u
+func (d *Directory) ReplaceUser(id string, u *User) (*User, *Error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	existing, ok := d.users[id]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "user %s not found", id)
+	}
+	u.ID, u.Schemas, u.Meta = id, existing.Schemas, existing.Meta
+	u.Meta.LastModified = time.Now().UTC()
+	d.users[id] = u
+	return u, d.save()
+}
+
+// CreateGroup adds g, rejecting a display name that is taken
+func (d *Directory) CreateGroup(g *Group) (*Group, *Error) {
+	if g.DisplayName == "" {
+		return nil, errorf(http.StatusBadRequest, "displayName is required")
+	}
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	for _, existing := range d.groups {
+		if strings.EqualFold(existing.DisplayName, g.DisplayName) {
+			return nil, errorf(http.StatusConflict, "group %s already exists", g.DisplayName)
+		}
+	}
+	now := time.Now().UTC()
+	g.ID = newID()
+	g.Schemas = []string{GroupSchema}
+	g.Meta = Meta{ResourceType: "Group", Created: now, LastModified: now, Location: BasePath + "/Groups/" + g.ID}
+	if g.Members == nil {
+		g.Members = []Member{}
+	}
+	d.groups[g.ID] = g
+	return g, d.save()
+}
+
+// ReplaceGroup replaces the group with id by g
+func (d *Directory) ReplaceGroup(id string, g *Group) (*Group, *Error) {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	existing, ok := d.groups[id]
+	if !ok {
+		return nil, errorf(http.StatusNotFound, "group %s not found", id)
+	}
+	g.ID, g.Schemas, g.Meta = id, existing.Schemas, existing.Meta
+	g.Meta.LastModified = time.Now().UTC()
+	if g.Members == nil {
+		g.Members = []Member{}
+	}
+	d.groups[id] = g
+	return g, d.save()
+}
+
+// Delete removes a user, and their group memberships, or a group
+func (d *Directory) Delete(users bool, id string) *Error {
+	d.mu.Lock()
+	defer d.mu.Unlock()
+	if users {
+		if _, ok := d.users[id]; !ok {
+			return errorf(http.StatusNotFound, "user %s not found", id)
+		}
+		delete(d.users, id)
+		for _, g := range d.groups {
+			g.Members = removeMembers(g.Members, map[string]bool{id: true})
+		}
+	} else {
+		if _, ok := d.groups[id]; !ok {
+			return errorf(http.StatusNotFound, "group %s not found", id)
+		}
+		delete(d.groups, id)
+	}
+	return d.save()
+}
+
+func (d *Directory) save() *Error {
+	if err := d.changed(); err != nil {
+		return errorf(http.StatusInternalServerError, "failed to save directory: %v", err)
+	}
+	return nil
+}
@@ -0,0 +1,130 @@
+package security
+
+import (
+	"bytes"
+	"fmt"
+	"os"
+	"os/exec"
+	"regexp"
+	"runtime"
+	"strings"
+
+	"github.com/sirupsen/logrus"
+)
+
+// PFAnchor is the pf anchor the DNS redirect rules are loaded into. The
+// default /etc/pf.conf on macOS evaluates the com.apple/* anchors, so it
+// needs no edits.
+const PFAnchor = "com.apple/dnshield"
+
+// pfTokenPattern extracts the reference token printed by pfctl -E
+var pfTokenPattern = regexp.MustCompile(`Token\s*:\s*(\d+)`)
+
+// DNSRedirect installs pf rules that send all outbound DNS to the agent, so
+// applications with a hard-coded resolver such as 8.8.8.8 are filtered too
+type DNSRedirect struct {
+	port     int  // Agent DNS port
+	blockDoT bool // Also reject DNS over TLS (port 853)
+	uid      int  // Owner of the agent's upstream sockets, exempt from the redirect
+	token    string
+}
+
+// NewDNSRedirect creates a redirect to the agent listening on port. The
+// current user is exempt so that the agent can still reach its upstreams.
+func NewDNSRedirect(port int, blockDoT bool) *DNSRedirect {
+	return &DNSRedirect{
+		port:     port,
+		blockDoT: blockDoT,
+		uid:      os.Getuid(),
+	}
+}
+
+// Rules returns the pf ruleset for the anchor. pf only translates inbound
+// packets, so outbound queries are first routed through lo0, where the rdr
+// rules point them at the agent.
+func (r *DNSRedirect) Rules() string {
+	var b strings.Builder
+	fmt.Fprintf(&b, "rdr pass on lo0 inet proto { udp tcp } from any to ! 127.0.0.1 port 53 -> 127.0.0.1 port %d\n", r.port)
+	fmt.Fprintf(&b, "rdr pass on lo0 inet6 proto { udp tcp } from any to ! ::1 port 53 -> ::1 port %d\n", r.port)
+	fmt.Fprintf(&b, "pass out quick on ! lo0 route-to (lo0 127.0.0.1) inet proto { udp tcp } from any to any port 53 user != %d\n", r.uid)
+	fmt.Fprintf(&b, "pass out quick on ! lo0 route-to (lo0 ::1) inet6 proto { udp tcp } from any to any port 53 user != %d\n", r.uid)
+	if r.blockDoT {
+		// DNShield has no DNS over TLS listener; rejecting the connection
+		// makes clients fall back to plain DNS, which is redirected
+		fmt.Fprintf(&b, "block return out quick on ! lo0 proto { tcp udp } from any to any port 853 user != %d\n", r.uid)
+	}
+	return b.String()
+}
+
+// Install loads the rules into PFAnchor and enables pf. Enabling takes a
+// reference, so pf stays on for other users of it after Remove.
+func (r *DNSRedirect) Install() error {
+	if runtime.GOOS != "darwin" {
+		return fmt.Errorf("pf DNS redirect is only supported on macOS")
+	}
+
+	cmd := exec.Command("pfctl", "-a", PFAnchor, "-f", "-")
+	cmd.Stdin = strings.NewReader(r.Rules())
+	if output, err := cmd.CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to load pf rules: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+
+	output, err := exec.Command("pfctl", "-E").CombinedOutput()
+	if err != nil {
+		flushPFAnchor()
+		return fmt.Errorf("failed to enable pf: %v: %s", err, strings.TrimSpace(string(output)))
+	}
+	if m := pfTokenPattern.FindSubmatch(output); m != nil {
+		r.token = string(m[1])
+	}
+
+	logrus.WithFields(logrus.Fields{
+		"anchor":    PFAnchor,
+		"port":      r.port,
+		"block_dot": r.blockDoT,
+	}).Info("Redirecting outbound DNS to DNShield with pf")
+	return nil
+}
+
+// Remove flushes the rules and releases the reference taken by Install
+func (r *DNSRedirect) Remove() error {
+	if runtime.GOOS != "darwin" {
+		return nil
+	}
+
+	err := flushPFAnchor()
+	if r.token != "" {
+		if output, releaseErr := exec.Command("pfctl", "-X", r.token).CombinedOutput(); releaseErr != nil {
+			logrus.WithError(releaseErr).WithField("output", strings.TrimSpace(string(output))).Warn("Failed to release pf reference")
+		}
+		r.token = ""
+	}
+	if err == nil {
+		logrus.Info("Removed pf DNS redirect")
+	}
+	return err
+}
+
+// RemoveDNSRedirect flushes rules left in PFAnchor by an agent that didn't
+// stop cleanly. It runs pfctl with sudo when not root.
+func RemoveDNSRedirect() error {
+	args := []string{"pfctl", "-a", PFAnchor, "-F", "all"}
+	if os.Getuid() != 0 {
+		args = append([]string{"sudo", "-p", "Touch ID or enter password: "}, args...)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
+	var stderr bytes.Buffer
+	cmd.Stdin = os.Stdin
+	cmd.Stderr = &stderr
+	if err := cmd.Run(); err != nil {
+		return fmt.Errorf("failed to flush pf anchor %s: %v: %s", PFAnchor, err, strings.TrimSpace(stderr.String()))
+	}
+	return nil
+}
+
+func flushPFAnchor() error {
+	if output, err := exec.Command("pfctl", "-a", PFAnchor, "-F", "all").CombinedOutput(); err != nil {
+		return fmt.Errorf("failed to flush pf anchor %s: %v: %s", PFAnchor, err, strings.TrimSpace(string(output)))
+	}
+	return nil
+}
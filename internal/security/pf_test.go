@@ -0,0 +1,43 @@
+package security
+
+import (
+	"strings"
+	"testing"
+)
+
+func TestDNSRedirectRules(t *testing.T) {
+	r := &DNSRedirect{port: 5353, uid: 501}
+	rules := r.Rules()
+
+	for _, want := range []string{
+		"rdr pass on lo0 inet proto { udp tcp } from any to ! 127.0.0.1 port 53 -> 127.0.0.1 port 5353",
+		"rdr pass on lo0 inet6 proto { udp tcp } from any to ! ::1 port 53 -> ::1 port 5353",
+		"route-to (lo0 127.0.0.1) inet proto { udp tcp } from any to any port 53 user != 501",
+		"route-to (lo0 ::1) inet6 proto { udp tcp } from any to any port 53 user != 501",
+	} {
+		if !strings.Contains(rules, want) {
+			t.Errorf("Rules missing %q:\n%s", want, rules)
+		}
+	}
+	if strings.Contains(rules, "853") {
+		t.Errorf("Expected no DoT rule when blockDoT is off:\n%s", rules)
+	}
+
+	// Translation rules must precede filter rules in a pf ruleset
+	if strings.Index(rules, "rdr") > strings.Index(rules, "pass out") {
+		t.Errorf("Expected rdr rules first:\n%s", rules)
+	}
+
+	r.blockDoT = true
+	if rules := r.Rules(); !strings.Contains(rules, "block return out quick on ! lo0 proto { tcp udp } from any to any port 853 user != 501") {
+		t.Errorf("Expected DoT block rule:\n%s", rules)
+	}
+}
+
+func TestPFToken(t *testing.T) {
+	output := []byte("No ALTQ support in kernel\nALTQ related functions disabled\npf enabled\nToken : 17318806347373219015\n")
+	m := pfTokenPattern.FindSubmatch(output)
+	if m == nil || string(m[1]) != "17318806347373219015" {
+		t.Errorf("Expected token to be parsed from pfctl output, got %q", m)
+	}
+}
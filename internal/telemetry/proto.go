@@ -0,0 +1,268 @@
+package telemetry
+
+import (
+	"encoding/binary"
+	"fmt"
+	"math"
+	"sort"
+	"time"
+)
+
+// Protobuf wire types used by the OTLP messages
+const (
+	wireVarint  = 0
+	wireFixed64 = 1
+	wireBytes   = 2
+)
+
+// Field numbers and enum values from opentelemetry-proto v1. Only what
+// DNShield sends is listed.
+const (
+	// Export{Trace,Metrics}ServiceRequest
+	fieldRequestResource = 1
+
+	// ResourceSpans and ResourceMetrics
+	fieldResource = 1
+	fieldScoped   = 2
+
+	// Resource
+	fieldResourceAttributes = 1
+
+	// ScopeSpans and ScopeMetrics
+	fieldScope      = 1
+	fieldScopeItems = 2
+
+	// InstrumentationScope
+	fieldScopeName = 1
+
+	// Span
+	fieldTraceID    = 1
+	fieldSpanID     = 2
+	fieldSpanName   = 5
+	fieldSpanKind   = 6
+	fieldSpanStart  = 7
+	fieldSpanEnd    = 8
+	fieldSpanAttrs  = 9
+	fieldSpanStatus = 15
+
+	// Status
+	fieldStatusMessage = 2
+	fieldStatusCode    = 3
+
+	// KeyValue and AnyValue
+	fieldKey         = 1
+	fieldValue       = 2
+	fieldValueString = 1
+	fieldValueBool   = 2
+	fieldValueInt    = 3
+	fieldValueDouble = 4
+
+	// Metric, Gauge and Sum
+	fieldMetricName        = 1
+	fieldMetricDescription = 2
+	fieldMetricUnit        = 3
+	fieldMetricGauge       = 5
+	fieldMetricSum         = 7
+	fieldDataPoints        = 1
+	fieldTemporality       = 2
+	fieldMonotonic         = 3
+
+	// NumberDataPoint
+	fieldPointStart  = 2
+	fieldPointTime   = 3
+	fieldPointDouble = 4
+	fieldPointAttrs  = 7
+
+	spanKindInternal      = 1
+	spanKindServer        = 2
+	statusOK              = 1
+	statusError           = 2
+	temporalityCumulative = 2
+)
+
+// encoder appends fields in the protobuf wire format
+type encoder struct {
+	buf []byte
+}
+
+func (e *encoder) tag(field, wireType int) {
+	e.buf = binary.AppendUvarint(e.buf, uint64(field)<<3|uint64(wireType))
+}
+
+func (e *encoder) varint(field int, v uint64) {
+	e.tag(field, wireVarint)
+	e.buf = binary.AppendUvarint(e.buf, v)
+}
+
+func (e *encoder) fixed64(field int, v uint64) {
+	e.tag(field, wireFixed64)
+	e.buf = binary.LittleEndian.AppendUint64(e.buf, v)
+}
+
+func (e *encoder) double(field int, v float64) {
+	e.fixed64(field, math.Float64bits(v))
+}
+
+func (e *encoder) time(field int, t time.Time) {
+	e.fixed64(field, uint64(t.UnixNano()))
+}
+
+func (e *encoder) bytes(field int, b []byte) {
+	e.tag(field, wireBytes)
+	e.buf = binary.AppendUvarint(e.buf, uint64(len(b)))
+	e.buf = append(e.buf, b...)
+}
+
+func (e *encoder) string(field int, s string) {
+	if s != "" {
+		e.bytes(field, []byte(s))
+	}
+}
+
+// message appends a nested message written by fn
+func (e *encoder) message(field int, fn func(e *encoder)) {
+	var nested encoder
+	fn(&nested)
+	e.bytes(field, nested.buf)
+}
+
+// attributes appends KeyValues in key order
+func (e *encoder) attributes(field int, attrs map[string]interface{}) {
+	keys := make([]string, 0, len(attrs))
+	for k := range attrs {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	for _, k := range keys {
+		v := attrs[k]
+		e.message(field, func(kv *encoder) {
+			kv.string(fieldKey, k)
+			kv.message(fieldValue, func(av *encoder) {
+				switch v := v.(type) {
+				case bool:
+					var b uint64
+					if v {
+						b = 1
+					}
+					av.varint(fieldValueBool, b)
+				case int:
+					av.varint(fieldValueInt, uint64(v))
+				case int64:
+					av.varint(fieldValueInt, uint64(v))
+				case float64:
+					av.double(fieldValueDouble, v)
+				case string:
+					av.bytes(fieldValueString, []byte(v))
+				default:
+					av.bytes(fieldValueString, []byte(fmt.Sprint(v)))
+				}
+			})
+		})
+	}
+}
+
+// scope appends the InstrumentationScope identifying DNShield
+func (e *encoder) scope() {
+	e.message(fieldScope, func(s *encoder) {
+		s.string(fieldScopeName, scopeName)
+	})
+}
+
+// encodeTraces builds an ExportTraceServiceRequest
+func encodeTraces(resource map[string]interface{}, spans []Span) []byte {
+	var e encoder
+	e.message(fieldRequestResource, func(rs *encoder) {
+		rs.message(fieldResource, func(r *encoder) {
+			r.attributes(fieldResourceAttributes, resource)
+		})
+		rs.message(fieldScoped, func(ss *encoder) {
+			ss.scope()
+			for _, span := range spans {
+				ss.message(fieldScopeItems, span.encode)
+			}
+		})
+	})
+	return e.buf
+}
+
+func (s Span) encode(e *encoder) {
+	e.bytes(fieldTraceID, s.traceID[:])
+	e.bytes(fieldSpanID, s.spanID[:])
+	e.string(fieldSpanName, s.Name)
+	kind := spanKindInternal
+	if s.Server {
+		kind = spanKindServer
+	}
+	e.varint(fieldSpanKind, uint64(kind))
+	e.time(fieldSpanStart, s.Start)
+	e.time(fieldSpanEnd, s.End)
+	e.attributes(fieldSpanAttrs, s.Attributes)
+	e.message(fieldSpanStatus, func(st *encoder) {
+		if s.Err != "" {
+			st.string(fieldStatusMessage, s.Err)
+			st.varint(fieldStatusCode, statusError)
+		} else {
+			st.varint(fieldStatusCode, statusOK)
+		}
+	})
+}
+
+// encodeMetrics builds an ExportMetricsServiceRequest. Metrics with the
+// same name become data points of one metric.
+func encodeMetrics(resource map[string]interface{}, start, now time.Time, metrics []Metric) []byte {
+	var names []string
+	byName := make(map[string][]Metric)
+	for _, m := range metrics {
+		if _, ok := byName[m.Name]; !ok {
+			names = append(names, m.Name)
+		}
+		byName[m.Name] = append(byName[m.Name], m)
+	}
+
+	var e encoder
+	e.message(fieldRequestResource, func(rm *encoder) {
+		rm.message(fieldResource, func(r *encoder) {
+			r.attributes(fieldResourceAttributes, resource)
+		})
+		rm.message(fieldScoped, func(sm *encoder) {
+			sm.scope()
+			for _, name := range names {
+				points := byName[name]
+				sm.message(fieldScopeItems, func(me *encoder) {
+					encodeMetric(me, points, start, now)
+				})
+			}
+		})
+	})
+	return e.buf
+}
+
+// encodeMetric appends a Metric with a data point for each of points,
+// which share a name
+func encodeMetric(e *encoder, points []Metric, start, now time.Time) {
+	m := points[0]
+	e.string(fieldMetricName, m.Name)
+	e.string(fieldMetricDescription, m.Description)
+	e.string(fieldMetricUnit, m.Unit)
+	dataPoints := func(d *encoder) {
+		for _, p := range points {
+			d.message(fieldDataPoints, func(dp *encoder) {
+				if m.Counter {
+					dp.time(fieldPointStart, start)
+				}
+				dp.time(fieldPointTime, now)
+				dp.double(fieldPointDouble, p.Value)
+				dp.attributes(fieldPointAttrs, p.Attributes)
+			})
+		}
+	}
+	if m.Counter {
+		e.message(fieldMetricSum, func(sum *encoder) {
+			dataPoints(sum)
+			sum.varint(fieldTemporality, temporalityCumulative)
+			sum.varint(fieldMonotonic, 1)
+		})
+		return
+	}
+	e.message(fieldMetricGauge, dataPoints)
+}
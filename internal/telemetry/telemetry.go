@@ -0,0 +1,458 @@
+// Package telemetry exports traces and metrics to an OpenTelemetry
+// collector over OTLP, with gRPC or protobuf over HTTP. Export is best
+// effort and never delays DNS responses: spans are queued, sent in batches
+// and dropped when the queue is full or the collector is unreachable.
+package telemetry
+
+import (
+	"bytes"
+	"context"
+	"crypto/rand"
+	"crypto/tls"
+	"encoding/binary"
+	"fmt"
+	"io"
+	mathrand "math/rand"
+	"net"
+	"net/http"
+	"net/url"
+	"os"
+	"runtime"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"github.com/sirupsen/logrus"
+	"golang.org/x/net/http2"
+)
+
+const (
+	// scopeName is the instrumentation scope of everything DNShield sends
+	scopeName = "dnshield"
+
+	// Protocols
+	ProtocolGRPC = "grpc"
+	ProtocolHTTP = "http"
+
+	// maxBatch is the most spans sent in one request
+	maxBatch = 512
+
+	exportTimeout = 10 * time.Second
+)
+
+// Default collector endpoints, on the local host
+const (
+	DefaultGRPCEndpoint = "http://localhost:4317"
+	DefaultHTTPEndpoint = "http://localhost:4318"
+)
+
+// OTLP paths of the trace and metrics services
+var (
+	tracePaths  = map[string]string{ProtocolGRPC: "/opentelemetry.proto.collector.trace.v1.TraceService/Export", ProtocolHTTP: "/v1/traces"}
+	metricPaths = map[string]string{ProtocolGRPC: "/opentelemetry.proto.collector.metrics.v1.MetricsService/Export", ProtocolHTTP: "/v1/metrics"}
+)
+
+// Span is a finished operation
+type Span struct {
+	Name       string
+	Start, End time.Time
+	Server     bool   // Handled a request from a client, e.g. a DNS query
+	Err        string // Empty if it succeeded
+	Attributes map[string]interface{}
+
+	traceID [16]byte
+	spanID  [8]byte
+}
+
+// Metric is a value sent at every export
+type Metric struct {
+	Name        string
+	Description string
+	Unit        string
+	Value       float64
+	// A cumulative count since the agent started, rather than a gauge
+	Counter    bool
+	Attributes map[string]interface{}
+}
+
+// Exporter sends spans and metrics to a collector
+type Exporter struct {
+	protocol   string
+	endpoint   string
+	headers    map[string]string
+	client     *http.Client
+	sampleRate float64
+	interval   time.Duration
+	resource   map[string]interface{}
+	start      time.Time
+
+	spans   chan Span
+	dropped uint64
+
+	mu       sync.Mutex
+	counters map[string]*Metric // By name and attributes
+	metrics  func() []Metric
+	failing  bool // The last export failed
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// ParseEndpoint checks a collector endpoint, which must be an http or
+// https URL, and returns it without a trailing slash
+func ParseEndpoint(endpoint string) (string, error) {
+	u, err := url.Parse(endpoint)
+	if err != nil {
+		return "", fmt.Errorf("invalid OTLP endpoint: %v", err)
+	}
+	if u.Scheme != "http" && u.Scheme != "https" {
+		return "", fmt.Errorf("OTLP endpoint %q must be an http:// or https:// URL", endpoint)
+	}
+	if u.Host == "" {
+		return "", fmt.Errorf("OTLP endpoint %q has no host", endpoint)
+	}
+	return strings.TrimSuffix(endpoint, "/"), nil
+}
+
+// New creates an exporter for cfg and starts its sender
+func New(cfg *config.OTLPConfig) (*Exporter, error) {
+	endpoint := cfg.Endpoint
+	if endpoint == "" {
+		endpoint = DefaultHTTPEndpoint
+		if cfg.Protocol == ProtocolGRPC {
+			endpoint = DefaultGRPCEndpoint
+		}
+	}
+	endpoint, err := ParseEndpoint(endpoint)
+	if err != nil {
+		return nil, err
+	}
+
+	// Header values may name environment variables, to keep tokens out of
+	// the config file
+	headers := make(map[string]string, len(cfg.Headers))
+	for name, value := range cfg.Headers {
+		headers[name] = os.ExpandEnv(value)
+	}
+
+	hostname, _ := os.Hostname()
+	e := &Exporter{
+		protocol:   cfg.Protocol,
+		endpoint:   endpoint,
+		headers:    headers,
+		client:     &http.Client{Timeout: exportTimeout},
+		sampleRate: cfg.TraceSampleRate,
+		interval:   cfg.Interval,
+		resource: map[string]interface{}{
+			"service.name": cfg.ServiceName,
+			"host.name":    hostname,
+			"os.type":      runtime.GOOS,
+		},
+		start:      time.Now(),
+		spans:      make(chan Span, cfg.QueueSize),
+		counters:   make(map[string]*Metric),
+		shutdownCh: make(chan struct{}),
+	}
+	if e.protocol == ProtocolGRPC {
+		e.client.Transport = grpcTransport(strings.HasPrefix(endpoint, "http://"))
+	}
+
+	e.wg.Add(1)
+	go e.sender()
+
+	return e, nil
+}
+
+// grpcTransport speaks HTTP/2, which gRPC needs, over TLS or, for http://
+// endpoints, in plaintext
+func grpcTransport(plaintext bool) http.RoundTripper {
+	t := &http2.Transport{}
+	if plaintext {
+		t.AllowHTTP = true
+		t.DialTLSContext = func(ctx context.Context, network, addr string, _ *tls.Config) (net.Conn, error) {
+			var d net.Dialer
+			return d.DialContext(ctx, network, addr)
+		}
+	}
+	return t
+}
+
+// SetMetricsCallback sets the function read at every export for metrics
+// kept elsewhere, such as cache statistics
+func (e *Exporter) SetMetricsCallback(fn func() []Metric) {
+	e.mu.Lock()
+	e.metrics = fn
+	e.mu.Unlock()
+}
+
+// RecordQuery counts an answered query and traces a sampled fraction of
+// queries, and every failed one. It never blocks.
+func (e *Exporter) RecordQuery(event dns.QueryEvent) {
+	e.CountQuery(event)
+	failed := event.Action == dns.QueryActionFailed
+	if !failed && e.sampleRate < 1 && mathrand.Float64() >= e.sampleRate {
+		return
+	}
+
+	span := Span{
+		Name:   "dns.query",
+		Start:  event.Timestamp,
+		End:    event.Timestamp.Add(event.Duration),
+		Server: true,
+		Attributes: map[string]interface{}{
+			"dns.question.name": event.Domain,
+			"dns.question.type": event.QueryType,
+			"dns.action":        event.Action,
+			"dns.rcode":         event.Rcode,
+		},
+	}
+	if event.Rule != "" {
+		span.Attributes["dns.rule"] = event.Rule
+	}
+	if event.Upstream != "" {
+		span.Attributes["dns.upstream"] = event.Upstream
+		span.Attributes["dns.upstream.rtt_ms"] = float64(event.UpstreamRTT) / float64(time.Millisecond)
+	}
+	if failed {
+		span.Err = "every upstream failed"
+	}
+	e.RecordSpan(span)
+}
+
+// CountQuery counts event in the query and error counters without
+// tracing it, for when per-query data may not leave the device
+func (e *Exporter) CountQuery(event dns.QueryEvent) {
+	e.count("dnshield.dns.queries", "DNS queries answered", map[string]interface{}{"action": event.Action})
+	if event.Action == dns.QueryActionFailed {
+		e.CountError("upstream")
+	}
+}
+
+// CountError counts an error of the given kind, e.g. "upstream" or
+// "rules_update". It is safe to call on a nil Exporter.
+func (e *Exporter) CountError(kind string) {
+	if e == nil {
+		return
+	}
+	e.count("dnshield.errors", "Errors by kind", map[string]interface{}{"kind": kind})
+}
+
+func (e *Exporter) count(name, description string, attrs map[string]interface{}) {
+	names := make([]string, 0, len(attrs))
+	for k := range attrs {
+		names = append(names, k)
+	}
+	sort.Strings(names)
+	key := name
+	for _, k := range names {
+		key += fmt.Sprintf("|%s=%v", k, attrs[k])
+	}
+	e.mu.Lock()
+	defer e.mu.Unlock()
+	m, ok := e.counters[key]
+	if !ok {
+		m = &Metric{Name: name, Description: description, Unit: "1", Counter: true, Attributes: attrs}
+		e.counters[key] = m
+	}
+	m.Value++
+}
+
+// RecordSpan queues a finished span. It never blocks.
+func (e *Exporter) RecordSpan(span Span) {
+	rand.Read(span.traceID[:])
+	rand.Read(span.spanID[:])
+	select {
+	case e.spans <- span:
+	default:
+		atomic.AddUint64(&e.dropped, 1)
+	}
+}
+
+// ActiveSpan is a span in progress
+type ActiveSpan struct {
+	e    *Exporter
+	span Span
+}
+
+// StartSpan begins an internal span, such as a rule update. It is safe to
+// call on a nil Exporter, as are the span's methods.
+func (e *Exporter) StartSpan(name string, attrs map[string]interface{}) *ActiveSpan {
+	if e == nil {
+		return nil
+	}
+	if attrs == nil {
+		attrs = make(map[string]interface{})
+	}
+	return &ActiveSpan{e: e, span: Span{Name: name, Start: time.Now(), Attributes: attrs}}
+}
+
+// SetAttribute adds an attribute to the span
+func (s *ActiveSpan) SetAttribute(key string, value interface{}) {
+	if s != nil {
+		s.span.Attributes[key] = value
+	}
+}
+
+// End records the span; a non-nil err marks it failed
+func (s *ActiveSpan) End(err error) {
+	if s == nil {
+		return
+	}
+	s.span.End = time.Now()
+	if err != nil {
+		s.span.Err = err.Error()
+	}
+	s.e.RecordSpan(s.span)
+}
+
+// Dropped returns the number of spans dropped because the queue was full
+func (e *Exporter) Dropped() uint64 {
+	return atomic.LoadUint64(&e.dropped)
+}
+
+// Stop sends what is queued and stops the sender
+func (e *Exporter) Stop() {
+	close(e.shutdownCh)
+	e.wg.Wait()
+}
+
+func (e *Exporter) sender() {
+	defer e.wg.Done()
+
+	ticker := time.NewTicker(e.interval)
+	defer ticker.Stop()
+
+	for {
+		select {
+		case <-e.shutdownCh:
+			e.flush()
+			return
+		case <-ticker.C:
+			e.flush()
+		}
+	}
+}
+
+// flush sends the queued spans and the current metrics
+func (e *Exporter) flush() {
+	for {
+		batch := e.nextBatch()
+		if len(batch) == 0 {
+			break
+		}
+		e.export(tracePaths[e.protocol], encodeTraces(e.resource, batch))
+		if len(batch) < maxBatch {
+			break
+		}
+	}
+
+	metrics := e.snapshot()
+	metrics = append(metrics, Metric{
+		Name:        "dnshield.telemetry.spans_dropped",
+		Description: "Spans dropped because the export queue was full",
+		Unit:        "1",
+		Value:       float64(e.Dropped()),
+		Counter:     true,
+	})
+	e.export(metricPaths[e.protocol], encodeMetrics(e.resource, e.start, time.Now(), metrics))
+}
+
+// nextBatch takes up to maxBatch queued spans
+func (e *Exporter) nextBatch() []Span {
+	var batch []Span
+	for len(batch) < maxBatch {
+		select {
+		case span := <-e.spans:
+			batch = append(batch, span)
+		default:
+			return batch
+		}
+	}
+	return batch
+}
+
+// snapshot returns the counters and the callback's metrics
+func (e *Exporter) snapshot() []Metric {
+	e.mu.Lock()
+	metrics := make([]Metric, 0, len(e.counters))
+	for _, m := range e.counters {
+		metrics = append(metrics, *m)
+	}
+	fn := e.metrics
+	e.mu.Unlock()
+
+	if fn != nil {
+		metrics = append(metrics, fn()...)
+	}
+	return metrics
+}
+
+// export sends one request. Failures are logged when they start and when
+// they end, not on every attempt.
+func (e *Exporter) export(path string, body []byte) {
+	err := e.send(path, body)
+
+	e.mu.Lock()
+	wasFailing := e.failing
+	e.failing = err != nil
+	e.mu.Unlock()
+
+	switch {
+	case err != nil && !wasFailing:
+		logrus.WithError(err).WithField("endpoint", e.endpoint).Warn("Failed to export telemetry")
+	case err == nil && wasFailing:
+		logrus.WithField("endpoint", e.endpoint).Info("Telemetry export recovered")
+	}
+}
+
+func (e *Exporter) send(path string, body []byte) error {
+	contentType := "application/x-protobuf"
+	if e.protocol == ProtocolGRPC {
+		// Length-prefixed message, uncompressed
+		framed := make([]byte, 5, 5+len(body))
+		binary.BigEndian.PutUint32(framed[1:], uint32(len(body)))
+		body = append(framed, body...)
+		contentType = "application/grpc"
+	}
+
+	req, err := http.NewRequest(http.MethodPost, e.endpoint+path, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	req.Header.Set("Content-Type", contentType)
+	if e.protocol == ProtocolGRPC {
+		req.Header.Set("TE", "trailers")
+	}
+	for name, value := range e.headers {
+		req.Header.Set(name, value)
+	}
+
+	resp, err := e.client.Do(req)
+	if err != nil {
+		return err
+	}
+	defer resp.Body.Close()
+	io.Copy(io.Discard, io.LimitReader(resp.Body, 64*1024))
+
+	if resp.StatusCode != http.StatusOK {
+		return fmt.Errorf("collector returned %s", resp.Status)
+	}
+	if e.protocol == ProtocolGRPC {
+		// Errors come in the trailers, or in the headers of a response
+		// without a body
+		status := resp.Trailer.Get("Grpc-Status")
+		message := resp.Trailer.Get("Grpc-Message")
+		if status == "" {
+			status = resp.Header.Get("Grpc-Status")
+			message = resp.Header.Get("Grpc-Message")
+		}
+		if status != "" && status != "0" {
+			return fmt.Errorf("collector returned gRPC status %s: %s", status, message)
+		}
+	}
+	return nil
+}
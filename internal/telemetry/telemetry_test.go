@@ -0,0 +1,102 @@
+package telemetry
+
+import (
+	"bytes"
+	"encoding/binary"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"sync"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"golang.org/x/net/http2"
+	"golang.org/x/net/http2/h2c"
+)
+
+// collector records the requests an exporter sends
+type collector struct {
+	mu       sync.Mutex
+	bodies   map[string][]byte
+	headers  map[string]http.Header
+	protocol string
+}
+
+func (c *collector) ServeHTTP(w http.ResponseWriter, r *http.Request) {
+	body, _ := io.ReadAll(r.Body)
+	if c.protocol == ProtocolGRPC {
+		if len(body) < 5 || int(binary.BigEndian.Uint32(body[1:5])) != len(body)-5 {
+			http.Error(w, "bad frame", http.StatusBadRequest)
+			return
+		}
+		body = body[5:]
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("Trailer", "Grpc-Status")
+		defer w.Header().Set("Grpc-Status", "0")
+	}
+	c.mu.Lock()
+	c.bodies[r.URL.Path] = append(c.bodies[r.URL.Path], body...)
+	c.headers[r.URL.Path] = r.Header.Clone()
+	c.mu.Unlock()
+}
+
+func TestExporter(t *testing.T) {
+	for _, protocol := range []string{ProtocolHTTP, ProtocolGRPC} {
+		t.Run(protocol, func(t *testing.T) {
+			c := &collector{bodies: make(map[string][]byte), headers: make(map[string]http.Header), protocol: protocol}
+			server := httptest.NewServer(h2c.NewHandler(c, &http2.Server{}))
+			defer server.Close()
+
+			t.Setenv("OTLP_TEST_TOKEN", "secret")
+			e, err := New(&config.OTLPConfig{
+				Protocol:        protocol,
+				Endpoint:        server.URL,
+				Headers:         map[string]string{"Authorization": "Bearer ${OTLP_TEST_TOKEN}"},
+				ServiceName:     "dnshield",
+				Interval:        time.Hour,
+				TraceSampleRate: 1,
+				QueueSize:       10,
+			})
+			if err != nil {
+				t.Fatal(err)
+			}
+			e.SetMetricsCallback(func() []Metric {
+				return []Metric{{Name: "dnshield.cache.hits", Value: 7, Counter: true}}
+			})
+			e.RecordQuery(dns.QueryEvent{
+				Timestamp: time.Now(),
+				Domain:    "example.com",
+				QueryType: "A",
+				Action:    dns.QueryActionAllowed,
+				Rcode:     "NOERROR",
+				Duration:  5 * time.Millisecond,
+			})
+			span := e.StartSpan("rules.update", nil)
+			span.End(nil)
+			e.Stop()
+
+			traces := c.bodies[tracePaths[protocol]]
+			for _, want := range []string{"dns.query", "example.com", "rules.update", "service.name"} {
+				if !bytes.Contains(traces, []byte(want)) {
+					t.Errorf("Traces don't contain %q", want)
+				}
+			}
+			metrics := c.bodies[metricPaths[protocol]]
+			for _, want := range []string{"dnshield.dns.queries", "dnshield.cache.hits", "allowed"} {
+				if !bytes.Contains(metrics, []byte(want)) {
+					t.Errorf("Metrics don't contain %q", want)
+				}
+			}
+			if got := c.headers[tracePaths[protocol]].Get("Authorization"); got != "Bearer secret" {
+				t.Errorf("Authorization header = %q, want the expanded token", got)
+			}
+		})
+	}
+
+	// A nil exporter, as when export is off, ignores spans
+	var e *Exporter
+	e.StartSpan("rules.update", nil).End(nil)
+	e.CountError("rules_update")
+}
@@ -0,0 +1,23 @@
+//go:build darwin
+// +build darwin
+
+package truststore
+
+import (
+	"fmt"
+	"os/exec"
+)
+
+// systemKeychain holds admin-trusted roots, where 'security add-trusted-cert
+// -d' (and most interception tools) install them
+const systemKeychain = "/Library/Keychains/System.keychain"
+
+// listSystemCertificates returns every certificate in the System keychain
+// as PEM
+func listSystemCertificates() ([]byte, error) {
+	out, err := exec.Command("security", "find-certificate", "-a", "-p", systemKeychain).Output()
+	if err != nil {
+		return nil, fmt.Errorf("failed to list System keychain certificates: %v", err)
+	}
+	return out, nil
+}
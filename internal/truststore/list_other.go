@@ -0,0 +1,11 @@
+//go:build !darwin
+// +build !darwin
+
+package truststore
+
+import "fmt"
+
+// listSystemCertificates is not supported on non-Darwin platforms
+func listSystemCertificates() ([]byte, error) {
+	return nil, fmt.Errorf("trust store monitoring is only supported on macOS")
+}
@@ -0,0 +1,304 @@
+// Package truststore watches the system trust store for root certificates
+// that appear without DNShield's involvement. Newly trusted CAs are a
+// common sign of interception malware or unmanaged proxies, so each one is
+// compared against a baseline and reported as a security audit event.
+package truststore
+
+import (
+	"bytes"
+	"crypto/sha256"
+	"crypto/x509"
+	"encoding/hex"
+	"encoding/json"
+	"encoding/pem"
+	"fmt"
+	"net/http"
+	"os"
+	"path/filepath"
+	"sort"
+	"sync"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	defaultInterval = 10 * time.Minute
+	webhookTimeout  = 10 * time.Second
+	baselineFile    = "truststore-baseline.json"
+)
+
+// CertInfo identifies a root certificate in the trust store
+type CertInfo struct {
+	Fingerprint string    `json:"fingerprint"` // SHA-256 of the DER encoding
+	Subject     string    `json:"subject"`
+	Issuer      string    `json:"issuer"`
+	NotBefore   time.Time `json:"not_before"`
+	NotAfter    time.Time `json:"not_after"`
+	FirstSeen   time.Time `json:"first_seen"`
+}
+
+// Baseline is the set of roots already accepted on this machine
+type Baseline struct {
+	CreatedAt time.Time           `json:"created_at"`
+	Roots     map[string]CertInfo `json:"roots"` // Keyed by fingerprint
+}
+
+// Alert is sent to the webhook when the trust store changes
+type Alert struct {
+	Timestamp time.Time  `json:"timestamp"`
+	Hostname  string     `json:"hostname"`
+	Added     []CertInfo `json:"added,omitempty"`
+	Removed   []CertInfo `json:"removed,omitempty"`
+}
+
+// Monitor periodically compares the trust store against a baseline
+type Monitor struct {
+	interval     time.Duration
+	webhookURL   string
+	baselinePath string
+	knownCAs     func() []*x509.Certificate
+	list         func() ([]byte, error)
+	httpClient   *http.Client
+
+	mu       sync.Mutex
+	baseline *Baseline
+
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// NewMonitor creates a trust store monitor. knownCAs returns DNShield's own
+// CAs, which are never reported.
+func NewMonitor(cfg *config.TrustStoreConfig, knownCAs func() []*x509.Certificate) *Monitor {
+	interval := cfg.Interval
+	if interval <= 0 {
+		interval = defaultInterval
+	}
+
+	home, err := os.UserHomeDir()
+	if err != nil {
+		home = "/tmp"
+	}
+
+	return &Monitor{
+		interval:     interval,
+		webhookURL:   cfg.WebhookURL,
+		baselinePath: filepath.Join(home, ".dnshield", baselineFile),
+		knownCAs:     knownCAs,
+		list:         listSystemCertificates,
+		httpClient:   &http.Client{Timeout: webhookTimeout},
+		shutdownCh:   make(chan struct{}),
+	}
+}
+
+// Start scans immediately and then at every interval
+func (m *Monitor) Start() {
+	m.wg.Add(1)
+	go func() {
+		defer m.wg.Done()
+
+		if err := m.Scan(); err != nil {
+			logrus.WithError(err).Warn("Trust store monitoring unavailable")
+			return
+		}
+
+		ticker := time.NewTicker(m.interval)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-m.shutdownCh:
+				return
+			case <-ticker.C:
+				if err := m.Scan(); err != nil {
+					logrus.WithError(err).Warn("Trust store scan failed")
+				}
+			}
+		}
+	}()
+}
+
+// Stop stops the monitor
+func (m *Monitor) Stop() {
+	close(m.shutdownCh)
+	m.wg.Wait()
+}
+
+// Scan compares the current trust store with the baseline. The first scan
+// records the baseline; later scans audit additions and removals and add
+// new roots to the baseline so each is reported once.
+func (m *Monitor) Scan() error {
+	data, err := m.list()
+	if err != nil {
+		return err
+	}
+
+	now := time.Now()
+	current := make(map[string]CertInfo)
+	for _, cert := range rootCertificates(data) {
+		info := certInfo(cert)
+		info.FirstSeen = now
+		current[info.Fingerprint] = info
+	}
+
+	m.mu.Lock()
+	defer m.mu.Unlock()
+
+	if m.baseline == nil {
+		m.baseline = m.loadBaseline()
+	}
+	if m.baseline == nil {
+		m.baseline = &Baseline{CreatedAt: now, Roots: current}
+		logrus.WithField("roots", len(current)).Info("Recorded trust store baseline")
+		return m.saveBaseline()
+	}
+
+	known := make(map[string]bool)
+	if m.knownCAs != nil {
+		for _, cert := range m.knownCAs() {
+			if cert != nil {
+				known[fingerprint(cert)] = true
+			}
+		}
+	}
+
+	var added, removed []CertInfo
+	for fp, info := range current {
+		if _, ok := m.baseline.Roots[fp]; ok {
+			continue
+		}
+		m.baseline.Roots[fp] = info
+		if !known[fp] {
+			added = append(added, info)
+		}
+	}
+	for fp, info := range m.baseline.Roots {
+		if _, ok := current[fp]; !ok {
+			delete(m.baseline.Roots, fp)
+			removed = append(removed, info)
+		}
+	}
+
+	if len(added) == 0 && len(removed) == 0 {
+		return nil
+	}
+
+	sortCerts(added)
+	sortCerts(removed)
+	for _, info := range added {
+		audit.Log(audit.EventTrustStoreChange, "critical", "Unknown root CA added to the system trust store", map[string]interface{}{
+			"fingerprint": info.Fingerprint,
+			"subject":     info.Subject,
+			"issuer":      info.Issuer,
+			"not_before":  info.NotBefore,
+			"not_after":   info.NotAfter,
+		})
+		logrus.WithFields(logrus.Fields{
+			"fingerprint": info.Fingerprint,
+			"subject":     info.Subject,
+		}).Error("Unknown root CA added to the system trust store")
+	}
+	for _, info := range removed {
+		audit.Log(audit.EventTrustStoreChange, "info", "Root CA removed from the system trust store", map[string]interface{}{
+			"fingerprint": info.Fingerprint,
+			"subject":     info.Subject,
+		})
+	}
+
+	if len(added) > 0 && m.webhookURL != "" {
+		hostname, _ := os.Hostname()
+		go m.notify(Alert{Timestamp: now, Hostname: hostname, Added: added, Removed: removed})
+	}
+
+	return m.saveBaseline()
+}
+
+// notify posts alert to the configured webhook
+func (m *Monitor) notify(alert Alert) {
+	body, err := json.Marshal(alert)
+	if err != nil {
+		return
+	}
+
+	resp, err := m.httpClient.Post(m.webhookURL, "application/json", bytes.NewReader(body))
+	if err != nil {
+		logrus.WithError(err).Warn("Failed to send trust store alert")
+		return
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		logrus.WithField("status", resp.Status).Warn("Trust store alert webhook rejected the alert")
+	}
+}
+
+func (m *Monitor) loadBaseline() *Baseline {
+	data, err := os.ReadFile(m.baselinePath)
+	if err != nil {
+		return nil
+	}
+
+	var baseline Baseline
+	if err := json.Unmarshal(data, &baseline); err != nil || baseline.Roots == nil {
+		logrus.WithError(err).Warn("Ignoring unreadable trust store baseline")
+		return nil
+	}
+	return &baseline
+}
+
+func (m *Monitor) saveBaseline() error {
+	data, err := json.MarshalIndent(m.baseline, "", "  ")
+	if err != nil {
+		return fmt.Errorf("failed to encode trust store baseline: %v", err)
+	}
+	if err := os.MkdirAll(filepath.Dir(m.baselinePath), 0700); err != nil {
+		return fmt.Errorf("failed to create baseline directory: %v", err)
+	}
+	if err := os.WriteFile(m.baselinePath, data, 0600); err != nil {
+		return fmt.Errorf("failed to write trust store baseline: %v", err)
+	}
+	return nil
+}
+
+// rootCertificates parses PEM data and returns the self-signed CA
+// certificates in it
+func rootCertificates(data []byte) []*x509.Certificate {
+	var roots []*x509.Certificate
+	for {
+		var block *pem.Block
+		block, data = pem.Decode(data)
+		if block == nil {
+			return roots
+		}
+		if block.Type != "CERTIFICATE" {
+			continue
+		}
+		cert, err := x509.ParseCertificate(block.Bytes)
+		if err != nil {
+			continue
+		}
+		if cert.IsCA && bytes.Equal(cert.RawSubject, cert.RawIssuer) {
+			roots = append(roots, cert)
+		}
+	}
+}
+
+func certInfo(cert *x509.Certificate) CertInfo {
+	return CertInfo{
+		Fingerprint: fingerprint(cert),
+		Subject:     cert.Subject.String(),
+		Issuer:      cert.Issuer.String(),
+		NotBefore:   cert.NotBefore,
+		NotAfter:    cert.NotAfter,
+	}
+}
+
+func fingerprint(cert *x509.Certificate) string {
+	sum := sha256.Sum256(cert.Raw)
+	return hex.EncodeToString(sum[:])
+}
+
+func sortCerts(certs []CertInfo) {
+	sort.Slice(certs, func(i, j int) bool { return certs[i].Subject < certs[j].Subject })
+}
@@ -0,0 +1,109 @@
+package truststore
+
+import (
+	"bytes"
+	"crypto/ecdsa"
+	"crypto/elliptic"
+	"crypto/rand"
+	"crypto/x509"
+	"crypto/x509/pkix"
+	"encoding/pem"
+	"math/big"
+	"path/filepath"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+)
+
+// newRoot creates a self-signed certificate; isCA false gives a leaf
+func newRoot(t *testing.T, name string, isCA bool) (*x509.Certificate, []byte) {
+	t.Helper()
+
+	key, err := ecdsa.GenerateKey(elliptic.P256(), rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	template := &x509.Certificate{
+		SerialNumber:          big.NewInt(time.Now().UnixNano()),
+		Subject:               pkix.Name{CommonName: name},
+		NotBefore:             time.Now(),
+		NotAfter:              time.Now().Add(time.Hour),
+		IsCA:                  isCA,
+		BasicConstraintsValid: true,
+	}
+	der, err := x509.CreateCertificate(rand.Reader, template, template, &key.PublicKey, key)
+	if err != nil {
+		t.Fatal(err)
+	}
+	cert, err := x509.ParseCertificate(der)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return cert, pem.EncodeToMemory(&pem.Block{Type: "CERTIFICATE", Bytes: der})
+}
+
+func TestRootCertificates(t *testing.T) {
+	_, root := newRoot(t, "Root", true)
+	_, leaf := newRoot(t, "Leaf", false)
+
+	var data bytes.Buffer
+	data.Write(root)
+	data.WriteString("garbage between blocks\n")
+	data.Write(pem.EncodeToMemory(&pem.Block{Type: "PRIVATE KEY", Bytes: []byte("x")}))
+	data.Write(leaf)
+
+	roots := rootCertificates(data.Bytes())
+	if len(roots) != 1 || roots[0].Subject.CommonName != "Root" {
+		t.Fatalf("rootCertificates() = %d certs, want only Root", len(roots))
+	}
+}
+
+func TestMonitorScan(t *testing.T) {
+	_, existing := newRoot(t, "Existing Root", true)
+	rogue, roguePEM := newRoot(t, "Rogue Root", true)
+	own, ownPEM := newRoot(t, "DNShield CA", true)
+
+	store := existing
+	m := NewMonitor(&config.TrustStoreConfig{Enabled: true}, func() []*x509.Certificate {
+		return []*x509.Certificate{own}
+	})
+	m.baselinePath = filepath.Join(t.TempDir(), baselineFile)
+	m.list = func() ([]byte, error) { return store, nil }
+
+	// First scan only records the baseline
+	if err := m.Scan(); err != nil {
+		t.Fatalf("initial scan: %v", err)
+	}
+	if len(m.baseline.Roots) != 1 {
+		t.Fatalf("baseline has %d roots, want 1", len(m.baseline.Roots))
+	}
+
+	// A new root and DNShield's own CA appear
+	store = append(append(append([]byte{}, existing...), roguePEM...), ownPEM...)
+	if err := m.Scan(); err != nil {
+		t.Fatalf("second scan: %v", err)
+	}
+	if len(m.baseline.Roots) != 3 {
+		t.Errorf("baseline has %d roots after update, want 3", len(m.baseline.Roots))
+	}
+	if _, ok := m.baseline.Roots[fingerprint(rogue)]; !ok {
+		t.Error("new root not added to baseline")
+	}
+
+	// The baseline survives a restart
+	reloaded := NewMonitor(&config.TrustStoreConfig{Enabled: true}, nil)
+	reloaded.baselinePath = m.baselinePath
+	if b := reloaded.loadBaseline(); b == nil || len(b.Roots) != 3 {
+		t.Fatalf("reloaded baseline = %+v, want 3 roots", b)
+	}
+
+	// Removals drop out of the baseline
+	store = existing
+	if err := m.Scan(); err != nil {
+		t.Fatalf("third scan: %v", err)
+	}
+	if len(m.baseline.Roots) != 1 {
+		t.Errorf("baseline has %d roots after removal, want 1", len(m.baseline.Roots))
+	}
+}
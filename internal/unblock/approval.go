@@ -0,0 +1,73 @@
+package unblock
+
+import (
+	"crypto/ed25519"
+	"encoding/base64"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// Approval is signed by the security team to let a device reach a blocked
+// domain until ExpiresAt
+type Approval struct {
+	Domain    string    `json:"domain"`
+	Device    string    `json:"device,omitempty"` // Hostname it's valid on; empty for any device
+	ExpiresAt time.Time `json:"expires_at"`
+	Approver  string    `json:"approver,omitempty"`
+	RequestID string    `json:"request_id,omitempty"` // Request being answered, if any
+}
+
+// ErrApprovalExpired is returned for approvals past their expiry
+var ErrApprovalExpired = errors.New("approval has expired")
+
+// SignApproval returns a token carrying a and its signature
+func SignApproval(a *Approval, key ed25519.PrivateKey) (string, error) {
+	a.Domain = normalizeDomain(a.Domain)
+	if !validDomain(a.Domain) {
+		return "", fmt.Errorf("invalid domain %q", a.Domain)
+	}
+	if a.ExpiresAt.IsZero() {
+		return "", fmt.Errorf("approval has no expiry")
+	}
+	payload, err := json.Marshal(a)
+	if err != nil {
+		return "", err
+	}
+	sig := ed25519.Sign(key, payload)
+	return base64.RawURLEncoding.EncodeToString(payload) + "." + base64.RawURLEncoding.EncodeToString(sig), nil
+}
+
+// VerifyApproval checks the signature of token against key and returns
+// the approval if it hasn't expired
+func VerifyApproval(token string, key ed25519.PublicKey) (*Approval, error) {
+	encodedPayload, encodedSig, ok := strings.Cut(strings.TrimSpace(token), ".")
+	if !ok {
+		return nil, fmt.Errorf("malformed approval")
+	}
+	payload, err := base64.RawURLEncoding.DecodeString(encodedPayload)
+	if err != nil {
+		return nil, fmt.Errorf("malformed approval")
+	}
+	sig, err := base64.RawURLEncoding.DecodeString(encodedSig)
+	if err != nil {
+		return nil, fmt.Errorf("malformed approval")
+	}
+	if !ed25519.Verify(key, payload, sig) {
+		return nil, fmt.Errorf("approval signature is invalid")
+	}
+
+	var a Approval
+	if err := json.Unmarshal(payload, &a); err != nil {
+		return nil, fmt.Errorf("malformed approval: %v", err)
+	}
+	if !validDomain(a.Domain) {
+		return nil, fmt.Errorf("approval has an invalid domain")
+	}
+	if !time.Now().Before(a.ExpiresAt) {
+		return nil, ErrApprovalExpired
+	}
+	return &a, nil
+}
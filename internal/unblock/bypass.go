@@ -0,0 +1,259 @@
+package unblock
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SourceBypass marks temporary allows started by a user bypass
+	SourceBypass = "bypass"
+
+	// TOTPSecretEnv overrides domainBypass.totpSecret from the configuration file
+	TOTPSecretEnv = "DNSHIELD_BYPASS_TOTP_SECRET"
+
+	// maxCodeFailures invalid admin codes in a row refuse codes for
+	// codeLockout, whatever the API's rate limits, so a 6-digit code
+	// can't be guessed
+	maxCodeFailures = 5
+	codeLockout     = 15 * time.Minute
+)
+
+var (
+	// ErrCodeRequired is returned when a bypass needs an admin code and none was given
+	ErrCodeRequired = errors.New("an admin code is required")
+	// ErrInvalidCode is returned for wrong, expired or reused admin codes
+	ErrInvalidCode = errors.New("invalid admin code")
+	// ErrCodeLockout is returned for any code while codes are refused
+	// after too many invalid ones
+	ErrCodeLockout = errors.New("too many invalid admin codes, try again later")
+	// ErrBypassLimit is returned once the daily bypass budget is spent
+	ErrBypassLimit = errors.New("too many bypasses today")
+	// ErrNotBypassable is returned for domains blocked by an excluded category
+	ErrNotBypassable = errors.New("this domain can't be bypassed")
+)
+
+// Bypasser lets a user through to a single blocked domain for a few
+// minutes. Unlike pausing protection, everything else stays filtered, and
+// unlike an approval the bypass doesn't cover subdomains.
+type Bypasser struct {
+	blocker     *dns.Blocker
+	lookup      func(domain string) dns.BlockMatch
+	maxDuration time.Duration
+	maxPerDay   int
+	requireCode bool
+	totpKey     []byte // Nil when no secret is configured
+	excluded    map[string]bool
+
+	mu           sync.Mutex
+	bypasses     []time.Time // Bypasses started in the last 24 hours
+	lastCounter  uint64      // Time step of the last accepted code, against replays
+	codeFailures int         // Invalid codes since the last valid one or lockout
+	lockedUntil  time.Time   // Codes are refused until then
+}
+
+// NewBypasser creates a bypasser for cfg. Bypasses allow domains on
+// blocker; lookup explains why a domain is blocked.
+func NewBypasser(cfg *config.DomainBypassConfig, blocker *dns.Blocker, lookup func(string) dns.BlockMatch) (*Bypasser, error) {
+	secret := cfg.TOTPSecret
+	if env := os.Getenv(TOTPSecretEnv); env != "" {
+		secret = env
+	}
+
+	var key []byte
+	if secret != "" {
+		var err error
+		if key, err = decodeTOTPSecret(secret); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.RequireCode && key == nil {
+		return nil, fmt.Errorf("domainBypass.requireCode needs a TOTP secret (set %s)", TOTPSecretEnv)
+	}
+
+	excluded := make(map[string]bool)
+	for _, category := range cfg.ExcludedCategories {
+		excluded[category] = true
+	}
+
+	return &Bypasser{
+		blocker:     blocker,
+		lookup:      lookup,
+		maxDuration: cfg.MaxDuration,
+		maxPerDay:   cfg.MaxPerDay,
+		requireCode: cfg.RequireCode,
+		totpKey:     key,
+		excluded:    excluded,
+	}, nil
+}
+
+// RequiresCode reports whether bypasses need an admin code
+func (b *Bypasser) RequiresCode() bool {
+	return b.requireCode
+}
+
+// MaxDuration is the longest bypass a user can start
+func (b *Bypasser) MaxDuration() time.Duration {
+	return b.maxDuration
+}
+
+// Bypass allows exactly domain, not its subdomains, for duration, capped
+// at the configured maximum. A code is checked whenever one is given or
+// required.
+func (b *Bypasser) Bypass(domain, justification string, duration time.Duration, code, clientIP string) (*dns.TemporaryAllow, error) {
+	domain = normalizeDomain(domain)
+	justification = strings.TrimSpace(justification)
+	code = strings.TrimSpace(code)
+	if !validDomain(domain) {
+		return nil, fmt.Errorf("invalid domain")
+	}
+	if justification == "" {
+		return nil, fmt.Errorf("a justification is required")
+	}
+	if len(justification) > MaxJustificationLength {
+		return nil, fmt.Errorf("justification is longer than %d characters", MaxJustificationLength)
+	}
+	if duration <= 0 {
+		return nil, fmt.Errorf("duration must be positive")
+	}
+	if duration > b.maxDuration {
+		duration = b.maxDuration
+	}
+	if code == "" && b.requireCode {
+		return nil, ErrCodeRequired
+	}
+
+	match := b.lookup(domain)
+	if !match.Blocked {
+		return nil, ErrNotBlocked
+	}
+	category := match.Category()
+	if b.excluded[category] || (b.excluded[dns.CategorySecurity] && b.blocker.IsSecurityBlocked(domain)) {
+		return nil, ErrNotBypassable
+	}
+
+	now := time.Now()
+	if err := b.reserve(code, now); err != nil {
+		switch err {
+		case ErrInvalidCode:
+			audit.Log(audit.EventSecurityViolation, "warning", "Invalid bypass code", map[string]interface{}{
+				"domain":    domain,
+				"client_ip": clientIP,
+			})
+		case ErrCodeLockout:
+			audit.Log(audit.EventSecurityViolation, "critical", "Bypass code refused during lockout", map[string]interface{}{
+				"domain":    domain,
+				"client_ip": clientIP,
+			})
+		}
+		return nil, err
+	}
+
+	allow := dns.TemporaryAllow{Domain: domain, Until: now.Add(duration), Source: SourceBypass}
+	b.blocker.AllowTemporarily(allow)
+
+	user, group := b.blocker.GetMetadata()
+	logrus.WithFields(logrus.Fields{
+		"domain":   domain,
+		"until":    allow.Until.Format(time.RFC3339),
+		"category": category,
+	}).Warn("Domain bypassed by user")
+	audit.Log(audit.EventDomainBypass, "warning", fmt.Sprintf("Bypassed %s for %s", domain, duration), map[string]interface{}{
+		"domain":        domain,
+		"until":         allow.Until.Format(time.RFC3339),
+		"justification": justification,
+		"with_code":     code != "",
+		"rule":          match.Rule,
+		"category":      category,
+		"user":          user,
+		"group":         group,
+		"client_ip":     clientIP,
+	})
+	return &allow, nil
+}
+
+// End stops a bypass early and reports whether one was active. Allows
+// granted by approvals are left alone.
+func (b *Bypasser) End(domain string) bool {
+	domain = normalizeDomain(domain)
+	for _, allow := range b.Active() {
+		if allow.Domain != domain {
+			continue
+		}
+		if !b.blocker.RevokeTemporaryAllow(domain) {
+			return false
+		}
+		audit.Log(audit.EventDomainBypassEnd, "info", fmt.Sprintf("Ended bypass of %s", domain), map[string]interface{}{
+			"domain": domain,
+		})
+		return true
+	}
+	return false
+}
+
+// Active returns the bypasses that haven't expired, sorted by domain
+func (b *Bypasser) Active() []dns.TemporaryAllow {
+	var active []dns.TemporaryAllow
+	for _, allow := range b.blocker.TemporaryAllows() {
+		if allow.Source == SourceBypass {
+			active = append(active, allow)
+		}
+	}
+	return active
+}
+
+// reserve checks code, if any, and takes a bypass from the daily budget.
+// After maxCodeFailures invalid codes in a row, codes are refused for
+// codeLockout.
+func (b *Bypasser) reserve(code string, now time.Time) error {
+	b.mu.Lock()
+	defer b.mu.Unlock()
+
+	var counter uint64
+	if code != "" {
+		if now.Before(b.lockedUntil) {
+			return ErrCodeLockout
+		}
+		var ok bool
+		if b.totpKey != nil {
+			counter, ok = matchTOTP(b.totpKey, code, now)
+		}
+		if !ok || counter <= b.lastCounter {
+			b.codeFailures++
+			if b.codeFailures >= maxCodeFailures {
+				b.codeFailures = 0
+				b.lockedUntil = now.Add(codeLockout)
+				logrus.WithField("until", b.lockedUntil.Format(time.RFC3339)).Warn("Too many invalid bypass codes, refusing codes")
+			}
+			return ErrInvalidCode
+		}
+		b.codeFailures = 0
+	}
+
+	cutoff := now.Add(-24 * time.Hour)
+	bypasses := b.bypasses[:0]
+	for _, t := range b.bypasses {
+		if t.After(cutoff) {
+			bypasses = append(bypasses, t)
+		}
+	}
+	b.bypasses = bypasses
+	if len(b.bypasses) >= b.maxPerDay {
+		return ErrBypassLimit
+	}
+
+	b.bypasses = append(b.bypasses, now)
+	if code != "" {
+		b.lastCounter = counter
+	}
+	return nil
+}
@@ -0,0 +1,160 @@
+package unblock
+
+import (
+	"errors"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+)
+
+func newTestBypasser(t *testing.T, cfg config.DomainBypassConfig) (*Bypasser, *dns.Blocker) {
+	t.Helper()
+	blocker := dns.NewBlocker()
+	if err := blocker.UpdateDomains([]string{"blocked.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := blocker.UpdateSecurityDomainsWithSources([]string{"c2.example.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if cfg.MaxDuration == 0 {
+		cfg.MaxDuration = 30 * time.Minute
+	}
+	if cfg.MaxPerDay == 0 {
+		cfg.MaxPerDay = 5
+	}
+	cfg.ExcludedCategories = []string{dns.CategorySecurity}
+	bypasser, err := NewBypasser(&cfg, blocker, blocker.Check)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return bypasser, blocker
+}
+
+func TestTOTP(t *testing.T) {
+	// RFC 6238 appendix B, SHA-1, truncated to six digits
+	key := []byte("12345678901234567890")
+	tests := []struct {
+		unix int64
+		want string
+	}{
+		{59, "287082"},
+		{1111111109, "081804"},
+		{1234567890, "005924"},
+		{2000000000, "279037"},
+	}
+	for _, tt := range tests {
+		if got := totpCode(key, uint64(tt.unix)/30); got != tt.want {
+			t.Errorf("code at %d = %s, want %s", tt.unix, got, tt.want)
+		}
+	}
+
+	now := time.Unix(1111111109, 0)
+	if _, ok := matchTOTP(key, "081804", now.Add(totpStep)); !ok {
+		t.Error("code from the previous step was rejected")
+	}
+	if _, ok := matchTOTP(key, "081804", now.Add(3*totpStep)); ok {
+		t.Error("stale code was accepted")
+	}
+}
+
+func TestBypass(t *testing.T) {
+	bypasser, blocker := newTestBypasser(t, config.DomainBypassConfig{MaxPerDay: 2})
+
+	if _, err := bypasser.Bypass("blocked.example.com", " ", 10*time.Minute, "", ""); err == nil {
+		t.Error("Bypass accepted an empty justification")
+	}
+	if _, err := bypasser.Bypass("open.example.com", "testing", 10*time.Minute, "", ""); !errors.Is(err, ErrNotBlocked) {
+		t.Errorf("Bypass(unblocked domain) error = %v, want ErrNotBlocked", err)
+	}
+	if _, err := bypasser.Bypass("c2.example.com", "testing", 10*time.Minute, "", ""); !errors.Is(err, ErrNotBypassable) {
+		t.Errorf("Bypass(security domain) error = %v, want ErrNotBypassable", err)
+	}
+
+	allow, err := bypasser.Bypass("blocked.example.com", "Vendor demo", 2*time.Hour, "", "")
+	if err != nil {
+		t.Fatalf("Bypass: %v", err)
+	}
+	if allow.Until.After(time.Now().Add(30 * time.Minute)) {
+		t.Errorf("bypass lasts until %v, beyond maxDuration", allow.Until)
+	}
+	if blocker.IsBlocked("blocked.example.com") {
+		t.Error("bypassed domain is still blocked")
+	}
+	if !blocker.IsBlocked("cdn.blocked.example.com") {
+		t.Error("bypass covered a subdomain")
+	}
+	if active := bypasser.Active(); len(active) != 1 || active[0].Domain != "blocked.example.com" {
+		t.Errorf("Active() = %+v", active)
+	}
+
+	if !bypasser.End("blocked.example.com") {
+		t.Error("End reported no active bypass")
+	}
+	if !blocker.IsBlocked("blocked.example.com") {
+		t.Error("domain still allowed after ending the bypass")
+	}
+
+	bypasser.Bypass("blocked.example.com", "again", time.Minute, "", "")
+	bypasser.End("blocked.example.com")
+	if _, err := bypasser.Bypass("blocked.example.com", "and again", time.Minute, "", ""); !errors.Is(err, ErrBypassLimit) {
+		t.Errorf("third bypass error = %v, want ErrBypassLimit", err)
+	}
+}
+
+func TestBypassCode(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	if _, err := NewBypasser(&config.DomainBypassConfig{RequireCode: true}, dns.NewBlocker(), nil); err == nil {
+		t.Error("NewBypasser accepted requireCode without a secret")
+	}
+	bypasser, _ := newTestBypasser(t, config.DomainBypassConfig{RequireCode: true, TOTPSecret: secret})
+
+	if _, err := bypasser.Bypass("blocked.example.com", "testing", time.Minute, "", ""); !errors.Is(err, ErrCodeRequired) {
+		t.Errorf("Bypass without code error = %v, want ErrCodeRequired", err)
+	}
+	if _, err := bypasser.Bypass("blocked.example.com", "testing", time.Minute, "000000x", ""); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("Bypass with a bad code error = %v, want ErrInvalidCode", err)
+	}
+
+	key, _ := decodeTOTPSecret(secret)
+	code := totpCode(key, uint64(time.Now().Unix())/30)
+	if _, err := bypasser.Bypass("blocked.example.com", "testing", time.Minute, code, ""); err != nil {
+		t.Fatalf("Bypass with a valid code: %v", err)
+	}
+	bypasser.End("blocked.example.com")
+	if _, err := bypasser.Bypass("blocked.example.com", "testing", time.Minute, code, ""); !errors.Is(err, ErrInvalidCode) {
+		t.Errorf("reused code error = %v, want ErrInvalidCode", err)
+	}
+}
+
+func TestBypassCodeLockout(t *testing.T) {
+	secret, err := NewTOTPSecret()
+	if err != nil {
+		t.Fatal(err)
+	}
+	key, _ := decodeTOTPSecret(secret)
+	bypasser, _ := newTestBypasser(t, config.DomainBypassConfig{RequireCode: true, TOTPSecret: secret})
+	codeAt := func(at time.Time) string { return totpCode(key, uint64(at.Unix())/30) }
+
+	now := time.Now()
+	for i := 0; i < maxCodeFailures; i++ {
+		if err := bypasser.reserve("000000x", now); !errors.Is(err, ErrInvalidCode) {
+			t.Fatalf("invalid code %d error = %v, want ErrInvalidCode", i+1, err)
+		}
+	}
+	if err := bypasser.reserve(codeAt(now), now); !errors.Is(err, ErrCodeLockout) {
+		t.Errorf("valid code during lockout error = %v, want ErrCodeLockout", err)
+	}
+	if _, err := bypasser.Bypass("blocked.example.com", "testing", time.Minute, codeAt(time.Now()), ""); !errors.Is(err, ErrCodeLockout) {
+		t.Errorf("Bypass during lockout error = %v, want ErrCodeLockout", err)
+	}
+
+	later := now.Add(codeLockout + time.Minute)
+	if err := bypasser.reserve(codeAt(later), later); err != nil {
+		t.Errorf("valid code after lockout: %v", err)
+	}
+}
@@ -0,0 +1,235 @@
+package unblock
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"github.com/sirupsen/logrus"
+)
+
+// Local rule lists
+const (
+	ListAllow = "allow"
+	ListBlock = "block"
+)
+
+// DefaultOverridesPath is where local allow and block entries are kept
+const DefaultOverridesPath = "/Library/Application Support/DNShield/local-rules.json"
+
+var (
+	// ErrNotOverridable is returned for domains that can't be allowed on this device
+	ErrNotOverridable = errors.New("this domain can't be allowed on this device")
+	// ErrOverrideLimit is returned once the lists hold localOverrides.maxEntries domains
+	ErrOverrideLimit = errors.New("too many local rules")
+)
+
+// Overrides are domains allowed or blocked on this device through the API,
+// for example from the menu bar app. They survive restarts and rule
+// updates; allows are limited to the allowed domains, and never cover an
+// excluded category or a security-critical rule.
+type Overrides struct {
+	blocker    *dns.Blocker
+	lookup     func(domain string) dns.BlockMatch
+	path       string
+	allowed    []string
+	excluded   []string
+	maxEntries int
+
+	mu    sync.Mutex
+	lists map[string][]string
+}
+
+// NewOverrides loads the entries saved at path and applies them to blocker.
+// lookup explains why a domain is blocked.
+func NewOverrides(cfg *config.LocalOverridesConfig, path string, blocker *dns.Blocker, lookup func(string) dns.BlockMatch) (*Overrides, error) {
+	o := &Overrides{
+		blocker:    blocker,
+		lookup:     lookup,
+		path:       path,
+		maxEntries: cfg.MaxEntries,
+		lists:      map[string][]string{ListAllow: {}, ListBlock: {}},
+	}
+	for _, domain := range cfg.AllowedDomains {
+		o.allowed = append(o.allowed, normalizeDomain(domain))
+	}
+	o.excluded = cfg.ExcludedCategories
+
+	data, err := os.ReadFile(path)
+	if err != nil && !os.IsNotExist(err) {
+		return nil, err
+	}
+	if err == nil {
+		var saved map[string][]string
+		if err := json.Unmarshal(data, &saved); err != nil {
+			return nil, fmt.Errorf("invalid local rules %s: %v", path, err)
+		}
+		for _, list := range []string{ListAllow, ListBlock} {
+			for _, domain := range saved[list] {
+				// Allows outside domains a new policy permits are dropped
+				if !validDomain(domain) || list == ListAllow && !o.allowable(domain) {
+					logrus.WithFields(logrus.Fields{"domain": domain, "list": list}).Warn("Dropping local rule that is no longer permitted")
+					continue
+				}
+				o.lists[list] = append(o.lists[list], domain)
+			}
+		}
+	}
+	o.apply()
+	return o, nil
+}
+
+// Lists returns the allowed and blocked domains, sorted
+func (o *Overrides) Lists() (allow, block []string) {
+	o.mu.Lock()
+	defer o.mu.Unlock()
+	return append([]string{}, o.lists[ListAllow]...), append([]string{}, o.lists[ListBlock]...)
+}
+
+// Add adds domain, and its subdomains, to list, replacing an entry for it
+// in the other list
+func (o *Overrides) Add(list, domain, clientIP string) error {
+	domain = normalizeDomain(domain)
+	if list != ListAllow && list != ListBlock {
+		return fmt.Errorf("unknown list %q", list)
+	}
+	if !validDomain(domain) {
+		return fmt.Errorf("invalid domain")
+	}
+
+	var match dns.BlockMatch
+	if list == ListAllow {
+		if !o.allowable(domain) {
+			return ErrNotOverridable
+		}
+		// Security-critical rules can't be allowed locally in any case.
+		// The blocker also keeps subdomains in excluded categories blocked.
+		match = o.lookup(domain)
+		if match.Blocked && (contains(o.excluded, match.Category()) || match.Security) || o.blocker.IsSecurityBlocked(domain) {
+			return ErrNotOverridable
+		}
+	}
+
+	o.mu.Lock()
+	other := ListBlock
+	if list == ListBlock {
+		other = ListAllow
+	}
+	o.lists[other] = remove(o.lists[other], domain)
+	if !contains(o.lists[list], domain) {
+		if len(o.lists[ListAllow])+len(o.lists[ListBlock]) >= o.maxEntries {
+			o.mu.Unlock()
+			return ErrOverrideLimit
+		}
+		o.lists[list] = append(o.lists[list], domain)
+		sort.Strings(o.lists[list])
+	}
+	err := o.save()
+	o.mu.Unlock()
+	if err != nil {
+		return err
+	}
+	o.apply()
+
+	user, group := o.blocker.GetMetadata()
+	logrus.WithFields(logrus.Fields{"domain": domain, "list": list}).Warn("Local rule added")
+	audit.Log(audit.EventLocalRuleAdded, "warning", fmt.Sprintf("Added %s to the local %s list", domain, list), map[string]interface{}{
+		"domain":    domain,
+		"list":      list,
+		"rule":      match.Rule,
+		"category":  match.Category(),
+		"user":      user,
+		"group":     group,
+		"client_ip": clientIP,
+	})
+	return nil
+}
+
+// Remove removes domain from list and reports whether it was there
+func (o *Overrides) Remove(list, domain, clientIP string) (bool, error) {
+	domain = normalizeDomain(domain)
+
+	o.mu.Lock()
+	if !contains(o.lists[list], domain) {
+		o.mu.Unlock()
+		return false, nil
+	}
+	o.lists[list] = remove(o.lists[list], domain)
+	err := o.save()
+	o.mu.Unlock()
+	if err != nil {
+		return false, err
+	}
+	o.apply()
+
+	user, group := o.blocker.GetMetadata()
+	audit.Log(audit.EventLocalRuleRemoved, "info", fmt.Sprintf("Removed %s from the local %s list", domain, list), map[string]interface{}{
+		"domain":    domain,
+		"list":      list,
+		"user":      user,
+		"group":     group,
+		"client_ip": clientIP,
+	})
+	return true, nil
+}
+
+// allowable reports whether domain is one of the allowed domains or under one
+func (o *Overrides) allowable(domain string) bool {
+	if len(o.allowed) == 0 {
+		return true
+	}
+	for _, allowed := range o.allowed {
+		if domain == allowed || strings.HasSuffix(domain, "."+allowed) {
+			return true
+		}
+	}
+	return false
+}
+
+func (o *Overrides) apply() {
+	allow, block := o.Lists()
+	o.blocker.SetLocalRules(allow, block, o.excluded)
+}
+
+// save writes the lists to o.path. Callers must hold o.mu.
+func (o *Overrides) save() error {
+	data, err := json.MarshalIndent(o.lists, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(o.path), 0755); err != nil {
+		return err
+	}
+	tmp := o.path + ".tmp"
+	if err := os.WriteFile(tmp, data, 0644); err != nil {
+		return err
+	}
+	return os.Rename(tmp, o.path)
+}
+
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+func remove(list []string, s string) []string {
+	kept := []string{}
+	for _, item := range list {
+		if item != s {
+			kept = append(kept, item)
+		}
+	}
+	return kept
+}
@@ -0,0 +1,113 @@
+package unblock
+
+import (
+	"errors"
+	"path/filepath"
+	"testing"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+)
+
+func TestOverrides(t *testing.T) {
+	blocker := dns.NewBlocker()
+	if err := blocker.UpdateDomains([]string{"blocked.example.com", "ads.partner.com"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := blocker.UpdateSecurityDomainsWithSources([]string{"c2.example.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	path := filepath.Join(t.TempDir(), "local-rules.json")
+	cfg := config.LocalOverridesConfig{
+		AllowedDomains:     []string{"example.com"},
+		ExcludedCategories: []string{dns.CategorySecurity},
+		MaxEntries:         3,
+	}
+	overrides, err := NewOverrides(&cfg, path, blocker, blocker.Check)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := overrides.Add(ListAllow, "blocked.example.com", "127.0.0.1"); err != nil {
+		t.Fatal(err)
+	}
+	if blocker.IsBlocked("www.blocked.example.com") {
+		t.Error("local allow didn't cover a subdomain")
+	}
+	if err := overrides.Add(ListAllow, "c2.example.com", ""); !errors.Is(err, ErrNotOverridable) {
+		t.Errorf("allowing a security domain: %v, want ErrNotOverridable", err)
+	}
+	if err := overrides.Add(ListAllow, "ads.partner.com", ""); !errors.Is(err, ErrNotOverridable) {
+		t.Errorf("allowing outside the allowed domains: %v, want ErrNotOverridable", err)
+	}
+
+	// Blocks aren't limited to the allowed domains
+	if err := overrides.Add(ListBlock, "news.site.org", ""); err != nil {
+		t.Fatal(err)
+	}
+	if match := blocker.Check("news.site.org"); !match.Blocked || match.Source != dns.SourceLocal {
+		t.Errorf("Check(news.site.org) = %+v, want a local block", match)
+	}
+	if err := overrides.Add(ListBlock, "video.site.org", ""); err != nil {
+		t.Fatal(err)
+	}
+	if err := overrides.Add(ListBlock, "games.site.org", ""); !errors.Is(err, ErrOverrideLimit) {
+		t.Errorf("adding past maxEntries: %v, want ErrOverrideLimit", err)
+	}
+
+	// A security rule added later still wins over a local allow
+	if err := blocker.UpdateSecurityDomainsWithSources([]string{"blocked.example.com"}, nil); err != nil {
+		t.Fatal(err)
+	}
+	if match := blocker.Check("blocked.example.com"); !match.Blocked || !match.Security {
+		t.Errorf("Check(blocked.example.com) = %+v, want the security rule", match)
+	}
+
+	// The lists survive a restart, without allows a new policy forbids
+	if removed, err := overrides.Remove(ListBlock, "video.site.org", ""); !removed || err != nil {
+		t.Fatalf("Remove = %v, %v", removed, err)
+	}
+	cfg.AllowedDomains = []string{"partner.com"}
+	reloaded, err := NewOverrides(&cfg, path, dns.NewBlocker(), blocker.Check)
+	if err != nil {
+		t.Fatal(err)
+	}
+	allow, block := reloaded.Lists()
+	if len(allow) != 0 || len(block) != 1 || block[0] != "news.site.org" {
+		t.Errorf("reloaded lists = %v, %v", allow, block)
+	}
+}
+
+func TestOverridesExcludedSubdomains(t *testing.T) {
+	blocker := dns.NewBlocker()
+	if err := blocker.UpdateDomainsWithSources([]string{"casino.example.com", "ads.example.com"}, map[string]string{
+		"casino.example.com": "https://lists.example/gambling.txt",
+		"ads.example.com":    "https://lists.example/ads.txt",
+	}); err != nil {
+		t.Fatal(err)
+	}
+	if err := blocker.UpdateCategories(map[string]string{"https://lists.example/gambling.txt": "gambling"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := config.LocalOverridesConfig{
+		AllowedDomains:     []string{"example.com"},
+		ExcludedCategories: []string{"gambling"},
+		MaxEntries:         10,
+	}
+	overrides, err := NewOverrides(&cfg, filepath.Join(t.TempDir(), "local-rules.json"), blocker, blocker.Check)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	// Allowing the parent passes the check at add time, but doesn't cover
+	// subdomains in an excluded category
+	if err := overrides.Add(ListAllow, "example.com", ""); err != nil {
+		t.Fatal(err)
+	}
+	if match := blocker.Check("www.casino.example.com"); !match.Blocked || match.Category() != "gambling" {
+		t.Errorf("Check(www.casino.example.com) = %+v, want the gambling rule", match)
+	}
+	if blocker.IsBlocked("ads.example.com") {
+		t.Error("local allow didn't cover a subdomain outside the excluded categories")
+	}
+}
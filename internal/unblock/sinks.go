@@ -0,0 +1,96 @@
+package unblock
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/rules"
+)
+
+// webhookTimeout bounds each webhook call
+const webhookTimeout = 10 * time.Second
+
+// Sink delivers requests to the security team
+type Sink interface {
+	Send(ctx context.Context, req *Request) error
+}
+
+// WebhookSink posts each request as JSON
+type WebhookSink struct {
+	url    string
+	client *http.Client
+}
+
+// NewWebhookSink creates a sink posting to url
+func NewWebhookSink(url string) *WebhookSink {
+	return &WebhookSink{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+// Send posts req to the webhook
+func (s *WebhookSink) Send(ctx context.Context, req *Request) error {
+	body, err := json.Marshal(req)
+	if err != nil {
+		return err
+	}
+	httpReq, err := http.NewRequestWithContext(ctx, http.MethodPost, s.url, bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	httpReq.Header.Set("Content-Type", "application/json")
+	resp, err := s.client.Do(httpReq)
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode < 200 || resp.StatusCode > 299 {
+		return fmt.Errorf("webhook returned %s", resp.Status)
+	}
+	return nil
+}
+
+// S3Sink writes each request to <prefix><device>/<time>-<id>.json in the
+// rules bucket. The S3 client is created on first use.
+type S3Sink struct {
+	cfg    *config.S3Config
+	prefix string
+
+	mu       sync.Mutex
+	uploader *rules.EnterpriseFetcher
+}
+
+// NewS3Sink creates a sink writing under prefix of cfg.Bucket
+func NewS3Sink(cfg *config.S3Config, prefix string) *S3Sink {
+	if prefix != "" && !strings.HasSuffix(prefix, "/") {
+		prefix += "/"
+	}
+	return &S3Sink{cfg: cfg, prefix: prefix}
+}
+
+// Send uploads req
+func (s *S3Sink) Send(ctx context.Context, req *Request) error {
+	s.mu.Lock()
+	if s.uploader == nil {
+		uploader, err := rules.NewEnterpriseFetcher(s.cfg)
+		if err != nil {
+			s.mu.Unlock()
+			return err
+		}
+		s.uploader = uploader
+	}
+	uploader := s.uploader
+	s.mu.Unlock()
+
+	body, err := json.MarshalIndent(req, "", "  ")
+	if err != nil {
+		return err
+	}
+	key := fmt.Sprintf("%s%s/%s-%s.json", s.prefix, req.Device, req.Time.UTC().Format("20060102-150405"), req.ID)
+	return uploader.PutObject(ctx, key, body, "application/json")
+}
@@ -0,0 +1,308 @@
+// Package unblock handles access requests from the block page: it forwards
+// a user's justification to the security team and applies the signed
+// approvals they send back as temporary allows. It also handles
+// self-service bypasses of a single blocked domain.
+package unblock
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"encoding/hex"
+	"errors"
+	"fmt"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"dnshield/internal/audit"
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+	"dnshield/internal/utils"
+	"github.com/sirupsen/logrus"
+)
+
+const (
+	// SourceApproval marks temporary allows granted by an approval
+	SourceApproval = "approval"
+
+	// MaxJustificationLength bounds the justification of a request
+	MaxJustificationLength = 1000
+
+	// queueSize bounds requests waiting to be delivered
+	queueSize = 50
+	// sendTimeout bounds delivery of a request to each sink
+	sendTimeout = 30 * time.Second
+)
+
+var (
+	// ErrNotBlocked is returned for requests to reach a domain that isn't blocked
+	ErrNotBlocked = errors.New("domain is not blocked")
+	// ErrRateLimited is returned once the hourly request budget is spent
+	ErrRateLimited = errors.New("too many access requests, try again later")
+	// ErrApprovalsDisabled is returned when no approval key is configured
+	ErrApprovalsDisabled = errors.New("approvals are not enabled")
+)
+
+// Request asks the security team for access to a blocked domain
+type Request struct {
+	ID            string    `json:"id"`
+	Time          time.Time `json:"time"`
+	Domain        string    `json:"domain"`
+	Justification string    `json:"justification"`
+	Rule          string    `json:"rule,omitempty"`
+	Category      string    `json:"category,omitempty"`
+	Reason        string    `json:"reason,omitempty"` // What blocked the domain
+	Device        string    `json:"device"`
+	User          string    `json:"user,omitempty"`
+	Group         string    `json:"group,omitempty"`
+	ClientIP      string    `json:"client_ip,omitempty"`
+}
+
+// Service accepts access requests and approvals. Requests are delivered on
+// a background worker so the block page never waits on the sinks.
+type Service struct {
+	blocker     *dns.Blocker
+	lookup      func(domain string) dns.BlockMatch
+	sinks       []Sink
+	approvalKey ed25519.PublicKey // Nil when approvals are disabled
+	maxAllow    time.Duration
+	maxPerHour  int
+	hostname    string
+
+	mu       sync.Mutex
+	requests []time.Time // Requests accepted in the last hour
+
+	queue      chan *Request
+	shutdownCh chan struct{}
+	wg         sync.WaitGroup
+}
+
+// New creates a service delivering requests to the webhook and S3 prefix
+// in cfg. Approvals allow domains on blocker; lookup explains why a domain
+// is blocked.
+func New(cfg *config.UnblockConfig, s3 *config.S3Config, blocker *dns.Blocker, lookup func(string) dns.BlockMatch) (*Service, error) {
+	var sinks []Sink
+	if cfg.WebhookURL != "" {
+		sinks = append(sinks, NewWebhookSink(cfg.WebhookURL))
+	}
+	if cfg.S3Prefix != "" && s3.Bucket != "" {
+		sinks = append(sinks, NewS3Sink(s3, cfg.S3Prefix))
+	}
+	return NewService(cfg, blocker, lookup, sinks)
+}
+
+// NewService creates a service delivering requests to sinks
+func NewService(cfg *config.UnblockConfig, blocker *dns.Blocker, lookup func(string) dns.BlockMatch, sinks []Sink) (*Service, error) {
+	var approvalKey ed25519.PublicKey
+	if cfg.ApprovalPublicKey != "" {
+		key, err := base64.StdEncoding.DecodeString(cfg.ApprovalPublicKey)
+		if err != nil || len(key) != ed25519.PublicKeySize {
+			return nil, fmt.Errorf("invalid approval public key")
+		}
+		approvalKey = key
+	}
+	hostname, _ := os.Hostname()
+
+	s := &Service{
+		blocker:     blocker,
+		lookup:      lookup,
+		sinks:       sinks,
+		approvalKey: approvalKey,
+		maxAllow:    cfg.MaxAllowDuration,
+		maxPerHour:  cfg.MaxRequestsPerHour,
+		hostname:    hostname,
+		queue:       make(chan *Request, queueSize),
+		shutdownCh:  make(chan struct{}),
+	}
+
+	s.wg.Add(1)
+	go s.worker()
+
+	return s, nil
+}
+
+// ApprovalsEnabled reports whether signed approvals are accepted
+func (s *Service) ApprovalsEnabled() bool {
+	return s.approvalKey != nil
+}
+
+// Submit records a request for access to domain and queues it for the
+// security team
+func (s *Service) Submit(domain, justification, clientIP string) (*Request, error) {
+	domain = normalizeDomain(domain)
+	justification = strings.TrimSpace(justification)
+	if !validDomain(domain) {
+		return nil, fmt.Errorf("invalid domain")
+	}
+	if justification == "" {
+		return nil, fmt.Errorf("a justification is required")
+	}
+	if len(justification) > MaxJustificationLength {
+		return nil, fmt.Errorf("justification is longer than %d characters", MaxJustificationLength)
+	}
+
+	match := s.lookup(domain)
+	if !match.Blocked {
+		return nil, ErrNotBlocked
+	}
+
+	now := time.Now()
+	if !s.allowRequest(now) {
+		return nil, ErrRateLimited
+	}
+
+	user, group := s.blocker.GetMetadata()
+	req := &Request{
+		ID:            newRequestID(),
+		Time:          now,
+		Domain:        domain,
+		Justification: justification,
+		Rule:          match.Rule,
+		Category:      match.Category(),
+		Reason:        match.Reason(),
+		Device:        s.hostname,
+		User:          user,
+		Group:         group,
+		ClientIP:      clientIP,
+	}
+
+	select {
+	case s.queue <- req:
+	default:
+		return nil, ErrRateLimited
+	}
+
+	audit.Log(audit.EventUnblockRequested, "info", fmt.Sprintf("Access requested to %s", domain), map[string]interface{}{
+		"request_id":    req.ID,
+		"domain":        domain,
+		"justification": justification,
+		"rule":          req.Rule,
+		"category":      req.Category,
+		"user":          user,
+		"group":         group,
+		"client_ip":     clientIP,
+	})
+	return req, nil
+}
+
+// Approve verifies an approval token and allows its domain until the
+// approval expires, capped at the configured maximum
+func (s *Service) Approve(token string) (*dns.TemporaryAllow, error) {
+	if s.approvalKey == nil {
+		return nil, ErrApprovalsDisabled
+	}
+	approval, err := VerifyApproval(token, s.approvalKey)
+	if err != nil {
+		return nil, err
+	}
+	if approval.Device != "" && !strings.EqualFold(approval.Device, s.hostname) {
+		return nil, fmt.Errorf("approval is for another device")
+	}
+
+	until := approval.ExpiresAt
+	if limit := time.Now().Add(s.maxAllow); until.After(limit) {
+		until = limit
+	}
+	allow := dns.TemporaryAllow{Domain: approval.Domain, Subdomains: true, Until: until, Source: SourceApproval}
+	s.blocker.AllowTemporarily(allow)
+
+	logrus.WithFields(logrus.Fields{
+		"domain":   allow.Domain,
+		"until":    until.Format(time.RFC3339),
+		"approver": approval.Approver,
+	}).Warn("Temporarily allowed domain by approval")
+	audit.Log(audit.EventUnblockApproved, "warning", fmt.Sprintf("Temporarily allowed %s by approval", allow.Domain), map[string]interface{}{
+		"domain":     allow.Domain,
+		"until":      until.Format(time.RFC3339),
+		"approver":   approval.Approver,
+		"request_id": approval.RequestID,
+	})
+	return &allow, nil
+}
+
+// Stop stops the worker. Requests still queued are not delivered.
+func (s *Service) Stop() {
+	close(s.shutdownCh)
+	s.wg.Wait()
+}
+
+// allowRequest reports whether another request fits in the hourly budget
+// and, if so, reserves it
+func (s *Service) allowRequest(now time.Time) bool {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+
+	cutoff := now.Add(-time.Hour)
+	requests := s.requests[:0]
+	for _, t := range s.requests {
+		if t.After(cutoff) {
+			requests = append(requests, t)
+		}
+	}
+	s.requests = requests
+
+	if len(s.requests) >= s.maxPerHour {
+		return false
+	}
+	s.requests = append(s.requests, now)
+	return true
+}
+
+func (s *Service) worker() {
+	defer s.wg.Done()
+
+	for {
+		select {
+		case <-s.shutdownCh:
+			return
+		case req := <-s.queue:
+			s.deliver(req)
+		}
+	}
+}
+
+func (s *Service) deliver(req *Request) {
+	for _, sink := range s.sinks {
+		ctx, cancel := context.WithTimeout(context.Background(), sendTimeout)
+		err := sink.Send(ctx, req)
+		cancel()
+
+		fields := logrus.Fields{"request_id": req.ID, "domain": req.Domain}
+		if err != nil {
+			logrus.WithError(err).WithFields(fields).Error("Failed to deliver access request")
+			continue
+		}
+		logrus.WithFields(fields).Info("Delivered access request")
+	}
+}
+
+func newRequestID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+func normalizeDomain(domain string) string {
+	return strings.ToLower(strings.TrimSuffix(strings.TrimSpace(domain), "."))
+}
+
+// validDomain accepts hostnames made of letters, digits and hyphens
+func validDomain(domain string) bool {
+	if domain == "" || utils.ValidateDomainLength(domain) != nil {
+		return false
+	}
+	for _, label := range strings.Split(domain, ".") {
+		if label == "" || strings.HasPrefix(label, "-") || strings.HasSuffix(label, "-") {
+			return false
+		}
+		for _, ch := range label {
+			if !(ch >= 'a' && ch <= 'z') && !(ch >= '0' && ch <= '9') && ch != '-' && ch != '_' {
+				return false
+			}
+		}
+	}
+	return true
+}
@@ -0,0 +1,134 @@
+package unblock
+
+import (
+	"context"
+	"crypto/ed25519"
+	"crypto/rand"
+	"encoding/base64"
+	"errors"
+	"os"
+	"strings"
+	"testing"
+	"time"
+
+	"dnshield/internal/config"
+	"dnshield/internal/dns"
+)
+
+// recordingSink collects delivered requests
+type recordingSink struct {
+	requests chan *Request
+}
+
+func (s *recordingSink) Send(ctx context.Context, req *Request) error {
+	s.requests <- req
+	return nil
+}
+
+func newTestService(t *testing.T, pub ed25519.PublicKey) (*Service, *dns.Blocker, *recordingSink) {
+	t.Helper()
+	blocker := dns.NewBlocker()
+	if err := blocker.UpdateDomains([]string{"blocked.example.com"}); err != nil {
+		t.Fatal(err)
+	}
+	cfg := &config.UnblockConfig{MaxAllowDuration: time.Hour, MaxRequestsPerHour: 2}
+	if pub != nil {
+		cfg.ApprovalPublicKey = base64.StdEncoding.EncodeToString(pub)
+	}
+	sink := &recordingSink{requests: make(chan *Request, 10)}
+	svc, err := NewService(cfg, blocker, blocker.Check, []Sink{sink})
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(svc.Stop)
+	return svc, blocker, sink
+}
+
+func TestSubmit(t *testing.T) {
+	svc, _, sink := newTestService(t, nil)
+
+	if _, err := svc.Submit("open.example.com", "needed for work", ""); !errors.Is(err, ErrNotBlocked) {
+		t.Errorf("Submit(unblocked domain) error = %v, want ErrNotBlocked", err)
+	}
+	if _, err := svc.Submit("blocked.example.com", "  ", ""); err == nil {
+		t.Error("Submit accepted an empty justification")
+	}
+	if _, err := svc.Submit("blocked.example.com", strings.Repeat("x", MaxJustificationLength+1), ""); err == nil {
+		t.Error("Submit accepted an overlong justification")
+	}
+
+	req, err := svc.Submit("Blocked.Example.com.", "Vendor portal for the Q3 audit", "127.0.0.1")
+	if err != nil {
+		t.Fatalf("Submit: %v", err)
+	}
+	select {
+	case delivered := <-sink.requests:
+		if delivered.ID != req.ID || delivered.Domain != "blocked.example.com" || delivered.Rule != "blocked.example.com" || delivered.Reason == "" {
+			t.Errorf("delivered %+v", delivered)
+		}
+	case <-time.After(5 * time.Second):
+		t.Fatal("request was not delivered")
+	}
+
+	svc.Submit("blocked.example.com", "again", "")
+	if _, err := svc.Submit("blocked.example.com", "and again", ""); !errors.Is(err, ErrRateLimited) {
+		t.Errorf("third request error = %v, want ErrRateLimited", err)
+	}
+}
+
+func TestApprove(t *testing.T) {
+	pub, priv, err := ed25519.GenerateKey(rand.Reader)
+	if err != nil {
+		t.Fatal(err)
+	}
+	svc, blocker, _ := newTestService(t, pub)
+	hostname, _ := os.Hostname()
+
+	sign := func(a Approval) string {
+		t.Helper()
+		token, err := SignApproval(&a, priv)
+		if err != nil {
+			t.Fatal(err)
+		}
+		return token
+	}
+
+	_, otherKey, _ := ed25519.GenerateKey(rand.Reader)
+	forged, _ := SignApproval(&Approval{Domain: "blocked.example.com", ExpiresAt: time.Now().Add(time.Hour)}, otherKey)
+	for name, token := range map[string]string{
+		"forged":       forged,
+		"expired":      sign(Approval{Domain: "blocked.example.com", ExpiresAt: time.Now().Add(-time.Minute)}),
+		"other device": sign(Approval{Domain: "blocked.example.com", Device: "someone-else", ExpiresAt: time.Now().Add(time.Hour)}),
+		"malformed":    "not-a-token",
+	} {
+		if _, err := svc.Approve(token); err == nil {
+			t.Errorf("Approve accepted a %s approval", name)
+		}
+	}
+	if !blocker.IsBlocked("blocked.example.com") {
+		t.Fatal("rejected approvals allowed the domain")
+	}
+
+	allow, err := svc.Approve(sign(Approval{Domain: "blocked.example.com", Device: hostname, ExpiresAt: time.Now().Add(48 * time.Hour)}))
+	if err != nil {
+		t.Fatalf("Approve: %v", err)
+	}
+	if allow.Until.After(time.Now().Add(time.Hour)) {
+		t.Errorf("allow lasts until %v, beyond maxAllowDuration", allow.Until)
+	}
+	if blocker.IsBlocked("blocked.example.com") || blocker.IsBlocked("cdn.blocked.example.com") {
+		t.Error("approved domain is still blocked")
+	}
+
+	blocker.RevokeTemporaryAllow("blocked.example.com")
+	if !blocker.IsBlocked("blocked.example.com") {
+		t.Error("domain still allowed after revoking the approval")
+	}
+}
+
+func TestApprovalsDisabled(t *testing.T) {
+	svc, _, _ := newTestService(t, nil)
+	if _, err := svc.Approve("anything"); !errors.Is(err, ErrApprovalsDisabled) {
+		t.Errorf("Approve error = %v, want ErrApprovalsDisabled", err)
+	}
+}
@@ -40,6 +40,31 @@ const (
 
 	// MaxHTTPBodySize is the maximum size for HTTP request bodies (10MB)
 	MaxHTTPBodySize = 10 * 1024 * 1024
+
+	// MaxConfigurableDomains is the hard ceiling for the configurable domain
+	// limit (rules.maxDomains). Lists beyond this need the trie-based blocker.
+	MaxConfigurableDomains = 5000000
+
+	// MaxConfigurableRulesFileSize is the hard ceiling for the configurable
+	// blocklist size limit (rules.maxFileSize) (1GB)
+	MaxConfigurableRulesFileSize = 1024 * 1024 * 1024
+
+	// MaxMirrorQueueSize is the maximum number of buffered query mirror records
+	MaxMirrorQueueSize = 1000000
+
+	// MaxTelemetryQueueSize is the maximum number of spans buffered for
+	// OpenTelemetry export
+	MaxTelemetryQueueSize = 1000000
+
+	// MaxSyslogQueueSize is the maximum number of buffered syslog messages
+	MaxSyslogQueueSize = 1000000
+
+	// MaxKafkaQueueSize is the maximum number of buffered Kafka records
+	MaxKafkaQueueSize = 1000000
+
+	// MaxKafkaBatchSize is the maximum number of records per Kafka produce
+	// request
+	MaxKafkaBatchSize = 10000
 )
 
 // LimitedReader returns a reader that limits the amount of data read
@@ -36,6 +36,22 @@ block pages without certificate warnings.`,
 		newConfigureDNSCmd(),
 		newBypassCmd(),
 		newAPIKeyCmd(),
+		newTopCmd(),
+		newVerifyCmd(),
+		newDebugCmd(),
+		newTailQueriesCmd(),
+		newTailCmd(),
+		newPolicyCmd(),
+		newProfileCmd(),
+		newCACmd(),
+		newUnblockCmd(),
+		newRulesCmd(),
+		newWhyCmd(),
+		newQueryCmd(),
+		newLoginCmd(),
+		newSCIMCmd(),
+		newDataCmd(),
+		newConfigCmd(),
 	)
 
 	if err := rootCmd.Execute(); err != nil {
@@ -61,26 +77,7 @@ func newStatusCmd() *cobra.Command {
 }
 
 func newUpdateRulesCmd() *cobra.Command {
-	return &cobra.Command{
-		Use:   "update-rules",
-		Short: "Force update blocking rules from S3",
-		Long:  `Manually trigger an update of blocking rules from the configured S3 bucket.`,
-		RunE: func(cmd *cobra.Command, args []string) error {
-			// We need to create a separate implementation for this
-			// For now, return a helpful message
-			fmt.Println("📥 Updating rules from S3...")
-			fmt.Println()
-			fmt.Println("Note: In the current version, rules are updated automatically")
-			fmt.Println("when the service starts and at the configured interval.")
-			fmt.Println()
-			fmt.Println("To force an update:")
-			fmt.Println("1. Restart the service: sudo ./dnshield run")
-			fmt.Println("2. Or wait for the next automatic update")
-			fmt.Println()
-			fmt.Println("Manual rule updates will be implemented in v1.1.0")
-			return nil
-		},
-	}
+	return cmd.NewUpdateRulesCmd()
 }
 
 func newVersionCmd() *cobra.Command {
@@ -104,3 +101,67 @@ func newBypassCmd() *cobra.Command {
 func newAPIKeyCmd() *cobra.Command {
 	return cmd.NewAPIKeyCmd()
 }
+
+func newTopCmd() *cobra.Command {
+	return cmd.NewTopCmd()
+}
+
+func newVerifyCmd() *cobra.Command {
+	return cmd.NewVerifyCmd()
+}
+
+func newDebugCmd() *cobra.Command {
+	return cmd.NewDebugCmd()
+}
+
+func newTailQueriesCmd() *cobra.Command {
+	return cmd.NewTailQueriesCmd()
+}
+
+func newTailCmd() *cobra.Command {
+	return cmd.NewTailCmd()
+}
+
+func newPolicyCmd() *cobra.Command {
+	return cmd.NewPolicyCmd()
+}
+
+func newProfileCmd() *cobra.Command {
+	return cmd.NewProfileCmd()
+}
+
+func newCACmd() *cobra.Command {
+	return cmd.NewCACmd()
+}
+
+func newUnblockCmd() *cobra.Command {
+	return cmd.NewUnblockCmd()
+}
+
+func newRulesCmd() *cobra.Command {
+	return cmd.NewRulesCmd()
+}
+
+func newWhyCmd() *cobra.Command {
+	return cmd.NewWhyCmd()
+}
+
+func newQueryCmd() *cobra.Command {
+	return cmd.NewQueryCmd()
+}
+
+func newLoginCmd() *cobra.Command {
+	return cmd.NewLoginCmd()
+}
+
+func newSCIMCmd() *cobra.Command {
+	return cmd.NewSCIMCmd()
+}
+
+func newDataCmd() *cobra.Command {
+	return cmd.NewDataCmd()
+}
+
+func newConfigCmd() *cobra.Command {
+	return cmd.NewConfigCmd()
+}
@@ -8,7 +8,7 @@ echo ""
 
 # Check if DNShield is running
 echo "1. Checking DNShield status..."
-STATUS=$(curl -s http://127.0.0.1:5353/api/status)
+STATUS=$(curl -s --unix-socket /var/run/dnshield/api.sock http://dnshield/api/status)
 
 if [ -z "$STATUS" ]; then
     echo "   ❌ DNShield is not running"
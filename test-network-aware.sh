@@ -8,7 +8,7 @@ echo ""
 
 # Check if API is running
 echo "1. Checking DNShield status..."
-STATUS=$(curl -s http://127.0.0.1:5353/api/status)
+STATUS=$(curl -s --unix-socket /var/run/dnshield/api.sock http://dnshield/api/status)
 
 if [ -z "$STATUS" ]; then
     echo "   ❌ DNShield is not running"
@@ -65,16 +65,16 @@ echo "   Current network: $CURRENT_NET"
 
 # Pause for 10 seconds
 echo "   Pausing protection for 10 seconds..."
-curl -X POST http://127.0.0.1:5353/api/pause \
+curl -X POST --unix-socket /var/run/dnshield/api.sock http://dnshield/api/pause \
     -H "Content-Type: application/json" \
-    -d '{"duration": "10s"}' \
+    -d '{"duration": "10s", "reason": "Testing pause"}' \
     -s | jq
 
 # Check status during pause
 sleep 2
 echo ""
 echo "   Status during pause:"
-curl -s http://127.0.0.1:5353/api/status | jq '{
+curl -s --unix-socket /var/run/dnshield/api.sock http://dnshield/api/status | jq '{
     protected,
     current_network,
     original_dns
@@ -88,7 +88,7 @@ sleep 10
 # Check status after resume
 echo ""
 echo "   Status after auto-resume:"
-curl -s http://127.0.0.1:5353/api/status | jq '{
+curl -s --unix-socket /var/run/dnshield/api.sock http://dnshield/api/status | jq '{
     protected,
     current_network
 }'
@@ -8,7 +8,7 @@ echo ""
 
 # Check if API is running
 echo "1. Checking if DNShield API is running..."
-if curl -s http://127.0.0.1:5353/api/health | grep -q "healthy"; then
+if curl -s --unix-socket /var/run/dnshield/api.sock http://dnshield/api/health | grep -q "healthy"; then
     echo "   ✅ API is running"
 else
     echo "   ❌ API is not running. Please start DNShield first:"
@@ -19,7 +19,7 @@ fi
 # Get current status
 echo ""
 echo "2. Current status:"
-curl -s http://127.0.0.1:5353/api/status | jq '{running, protected, dns_configured}'
+curl -s --unix-socket /var/run/dnshield/api.sock http://dnshield/api/status | jq '{running, protected, dns_configured}'
 
 # Test DNS resolution before pause
 echo ""
@@ -30,15 +30,15 @@ nslookup doubleclick.net 127.0.0.1 2>&1 | grep -A2 "Address"
 # Pause for 30 seconds
 echo ""
 echo "4. Pausing protection for 30 seconds..."
-curl -X POST http://127.0.0.1:5353/api/pause \
+curl -X POST --unix-socket /var/run/dnshield/api.sock http://dnshield/api/pause \
     -H "Content-Type: application/json" \
-    -d '{"duration": "30s"}' \
+    -d '{"duration": "30s", "reason": "Testing pause"}' \
     -s | jq
 
 # Check status again
 echo ""
 echo "5. Status after pause:"
-curl -s http://127.0.0.1:5353/api/status | jq '{running, protected, dns_configured}'
+curl -s --unix-socket /var/run/dnshield/api.sock http://dnshield/api/status | jq '{running, protected, dns_configured}'
 
 # Test DNS resolution during pause
 echo ""
@@ -54,7 +54,7 @@ sleep 35
 # Check final status
 echo ""
 echo "8. Status after auto-resume:"
-curl -s http://127.0.0.1:5353/api/status | jq '{running, protected, dns_configured}'
+curl -s --unix-socket /var/run/dnshield/api.sock http://dnshield/api/status | jq '{running, protected, dns_configured}'
 
 # Test DNS resolution after resume
 echo ""